@@ -18,6 +18,8 @@ type Account struct {
 	Orders  string           `json:"orders"`
 	ID      string           `json:"-"`
 	Key     *jose.JSONWebKey `json:"-"`
+	// ExternalAccount is the external account bound to the account, if any.
+	ExternalAccount *provisioner.ACMEExternalAccount `json:"-"`
 }
 
 // ToLog enables response logging.
@@ -48,6 +50,10 @@ func (a *Account) IsValid() bool {
 type AccountOptions struct {
 	Key     *jose.JSONWebKey
 	Contact []string
+	// ExternalAccountBinding is the JWS, in JSON serialization, used to bind
+	// the new account to an external account.
+	ExternalAccountBinding []byte
+	externalAccount        *provisioner.ACMEExternalAccount
 }
 
 // account represents an ACME account.
@@ -58,6 +64,8 @@ type account struct {
 	Key         *jose.JSONWebKey `json:"key"`
 	Contact     []string         `json:"contact,omitempty"`
 	Status      string           `json:"status"`
+	// ExternalAccount is the external account bound using the EAB.
+	ExternalAccount *provisioner.ACMEExternalAccount `json:"externalAccount,omitempty"`
 }
 
 // newAccount returns a new acme account type.
@@ -68,11 +76,12 @@ func newAccount(db nosql.DB, ops AccountOptions) (*account, error) {
 	}
 
 	a := &account{
-		ID:      id,
-		Key:     ops.Key,
-		Contact: ops.Contact,
-		Status:  "valid",
-		Created: clock.Now(),
+		ID:              id,
+		Key:             ops.Key,
+		Contact:         ops.Contact,
+		Status:          "valid",
+		Created:         clock.Now(),
+		ExternalAccount: ops.externalAccount,
 	}
 	return a, a.saveNew(db)
 }
@@ -81,11 +90,12 @@ func newAccount(db nosql.DB, ops AccountOptions) (*account, error) {
 // type for presentation in the ACME protocol.
 func (a *account) toACME(db nosql.DB, dir *directory, p provisioner.Interface) (*Account, error) {
 	return &Account{
-		Status:  a.Status,
-		Contact: a.Contact,
-		Orders:  dir.getLink(OrdersByAccountLink, URLSafeProvisionerName(p), true, a.ID),
-		Key:     a.Key,
-		ID:      a.ID,
+		Status:          a.Status,
+		Contact:         a.Contact,
+		Orders:          dir.getLink(OrdersByAccountLink, URLSafeProvisionerName(p), true, a.ID),
+		Key:             a.Key,
+		ID:              a.ID,
+		ExternalAccount: a.ExternalAccount,
 	}, nil
 }
 
@@ -167,6 +177,22 @@ func (a *account) deactivate(db nosql.DB) (*account, error) {
 	return &b, nil
 }
 
+// remove deletes the acme account and its key-id to account-id index. It's
+// used to roll back an account that could not be completely created.
+func (a *account) remove(db nosql.DB) error {
+	kid, err := keyToID(a.Key)
+	if err != nil {
+		return err
+	}
+	if err := db.Del(accountByKeyIDTable, []byte(kid)); err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error deleting key-id to account-id index"))
+	}
+	if err := db.Del(accountTable, []byte(a.ID)); err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error deleting account"))
+	}
+	return nil
+}
+
 // getAccountByID retrieves the account with the given ID.
 func getAccountByID(db nosql.DB, id string) (*account, error) {
 	ab, err := db.Get(accountTable, []byte(id))
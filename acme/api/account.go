@@ -13,9 +13,10 @@ import (
 
 // NewAccountRequest represents the payload for a new account request.
 type NewAccountRequest struct {
-	Contact              []string `json:"contact"`
-	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
-	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	Contact                []string        `json:"contact"`
+	OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+	TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
 }
 
 func validateContacts(cs []string) error {
@@ -114,8 +115,9 @@ func (h *Handler) NewAccount(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if acc, err = h.Auth.NewAccount(prov, acme.AccountOptions{
-			Key:     jwk,
-			Contact: nar.Contact,
+			Key:                    jwk,
+			Contact:                nar.Contact,
+			ExternalAccountBinding: nar.ExternalAccountBinding,
 		}); err != nil {
 			api.WriteError(w, err)
 			return
@@ -468,6 +468,37 @@ func TestHandlerNewAccount(t *testing.T) {
 				statusCode: 201,
 			}
 		},
+		"ok/new-account-with-eab": func(t *testing.T) test {
+			eab := json.RawMessage(`{"protected":"e30","payload":"e30","signature":"e30"}`)
+			nar := &NewAccountRequest{
+				Contact:                []string{"foo", "bar"},
+				ExternalAccountBinding: eab,
+			}
+			b, err := json.Marshal(nar)
+			assert.FatalError(t, err)
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			ctx = context.WithValue(ctx, jwkContextKey, jwk)
+			return test{
+				auth: &mockAcmeAuthority{
+					newAccount: func(p provisioner.Interface, ops acme.AccountOptions) (*acme.Account, error) {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, ops.Contact, nar.Contact)
+						assert.Equals(t, ops.Key, jwk)
+						assert.Equals(t, ops.ExternalAccountBinding, []byte(eab))
+						return &acc, nil
+					},
+					getLink: func(typ acme.Link, provID string, abs bool, in ...string) string {
+						return fmt.Sprintf("https://ca.smallstep.com/acme/%s/account/%s",
+							acme.URLSafeProvisionerName(prov), accID)
+					},
+				},
+				ctx:        ctx,
+				statusCode: 201,
+			}
+		},
 		"ok/return-existing": func(t *testing.T) test {
 			nar := &NewAccountRequest{
 				OnlyReturnExisting: true,
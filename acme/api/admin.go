@@ -0,0 +1,162 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/logging"
+)
+
+// NewExternalAccountKeyRequest is the type used in the admin API to create a
+// new external account key.
+type NewExternalAccountKeyRequest struct {
+	Reference string `json:"reference"`
+}
+
+// EarlyRenewalRequest is the type used in the admin API to signal the early
+// renewal of certificates. Either the identifiers of the certificates, as used
+// in the renewalInfo resource, or all must be set.
+type EarlyRenewalRequest struct {
+	IDs            []string `json:"ids,omitempty"`
+	All            bool     `json:"all,omitempty"`
+	ExplanationURL string   `json:"explanationURL,omitempty"`
+}
+
+// Validate validates an early renewal request body.
+func (r *EarlyRenewalRequest) Validate() error {
+	switch {
+	case len(r.IDs) == 0 && !r.All:
+		return acme.MalformedErr(errors.New("ids or all must be set"))
+	case len(r.IDs) > 0 && r.All:
+		return acme.MalformedErr(errors.New("ids and all cannot be set at the same time"))
+	}
+	for _, id := range r.IDs {
+		if id == "" {
+			return acme.MalformedErr(errors.New("ids cannot contain an empty value"))
+		}
+	}
+	return nil
+}
+
+// EarlyRenewalResponse is the response of the early renewal admin API, with
+// the identifiers of the certificates signaled.
+type EarlyRenewalResponse struct {
+	IDs []string `json:"ids"`
+}
+
+// requireAdmin is a middleware that only allows the request if the client
+// certificate used in the TLS connection or the OIDC token belongs to an
+// administrator of the provisioner. The requests of the administrators are
+// recorded in the audit log.
+func (h *Handler) requireAdmin(next nextHTTP) nextHTTP {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prov, err := provisionerFromContext(r)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		crt, token, err := api.AdminCredentials(r)
+		if err != nil {
+			api.WriteError(w, acme.UnauthorizedErr(err))
+			return
+		}
+		subject, err := h.Auth.AuthorizeAdmin(r.Context(), crt, token, prov)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		rw := logging.NewResponseLogger(w)
+		next(rw, r)
+		h.Auth.AuditAdminRequest(subject, r.Method, r.URL.Path, rw.StatusCode())
+	}
+}
+
+// NewExternalAccountKey is the admin API resource used to create a new
+// external account key. The response is the only one that includes the HMAC
+// key.
+func (h *Handler) NewExternalAccountKey(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	var body NewExternalAccountKeyRequest
+	if r.ContentLength != 0 {
+		if err := api.ReadJSON(r.Body, &body); err != nil {
+			api.WriteError(w, acme.MalformedErr(errors.Wrap(err,
+				"failed to unmarshal new external account key request")))
+			return
+		}
+	}
+	eak, err := h.Auth.NewExternalAccountKey(prov, body.Reference)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSONStatus(w, eak, http.StatusCreated)
+}
+
+// GetExternalAccountKeys is the admin API resource used to list the external
+// account keys of a provisioner.
+func (h *Handler) GetExternalAccountKeys(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	keys, err := h.Auth.GetExternalAccountKeys(prov)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, keys)
+}
+
+// DeleteExternalAccountKey is the admin API resource used to delete an
+// external account key.
+func (h *Handler) DeleteExternalAccountKey(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if err := h.Auth.DeleteExternalAccountKey(prov, chi.URLParam(r, "keyID")); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TriggerEarlyRenewal is the admin API resource used to signal the early
+// renewal of certificates, e.g. before a mass revocation. The renewalInfo
+// resource of these certificates will suggest an immediate renewal.
+func (h *Handler) TriggerEarlyRenewal(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	var body EarlyRenewalRequest
+	if err := api.ReadJSON(r.Body, &body); err != nil {
+		api.WriteError(w, acme.MalformedErr(errors.Wrap(err,
+			"failed to unmarshal early renewal request")))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	ids, err := h.Auth.TriggerEarlyRenewal(prov, acme.EarlyRenewalOptions{
+		IDs:            body.IDs,
+		All:            body.All,
+		ExplanationURL: body.ExplanationURL,
+	})
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	api.JSON(w, &EarlyRenewalResponse{IDs: ids})
+}
@@ -0,0 +1,518 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+func assertProblem(t *testing.T, body []byte, res *http.Response, problem *acme.Error) {
+	var ae acme.AError
+	assert.FatalError(t, json.Unmarshal(bytes.TrimSpace(body), &ae))
+	prob := problem.ToACME()
+	assert.Equals(t, ae.Type, prob.Type)
+	assert.Equals(t, ae.Detail, prob.Detail)
+	assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+}
+
+func TestHandlerRequireAdmin(t *testing.T) {
+	prov := newProv()
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/eab-keys", acme.URLSafeProvisionerName(prov))
+	provCtx := context.WithValue(context.Background(), provisionerContextKey, prov)
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		tls        *tls.ConnectionState
+		header     string
+		statusCode int
+		problem    *acme.Error
+		audited    bool
+	}
+	var audited bool
+	auditAdmin := func(requester, method, path string, status int) {
+		assert.Equals(t, "admin", requester)
+		assert.Equals(t, "GET", method)
+		assert.Equals(t, 200, status)
+		audited = true
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/no-tls": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        provCtx,
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("missing client certificate or token")),
+			}
+		},
+		"fail/no-certificate": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        provCtx,
+				tls:        &tls.ConnectionState{},
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("missing client certificate or token")),
+			}
+		},
+		"fail/not-admin": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					authorizeAdmin: func(ctx context.Context, c *x509.Certificate, token string, p provisioner.Interface) (string, error) {
+						assert.Equals(t, c, cert)
+						assert.Equals(t, p, prov)
+						return "", acme.UnauthorizedErr(errors.New("force"))
+					},
+				},
+				ctx:        provCtx,
+				tls:        &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+				statusCode: 401,
+				problem:    acme.UnauthorizedErr(errors.New("force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					authorizeAdmin: func(ctx context.Context, c *x509.Certificate, token string, p provisioner.Interface) (string, error) {
+						assert.Equals(t, c, cert)
+						assert.Equals(t, "", token)
+						return "admin", nil
+					},
+					auditAdminRequest: auditAdmin,
+				},
+				ctx:        provCtx,
+				tls:        &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+				statusCode: 200,
+				audited:    true,
+			}
+		},
+		"ok/token": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					authorizeAdmin: func(ctx context.Context, c *x509.Certificate, token string, p provisioner.Interface) (string, error) {
+						assert.Nil(t, c)
+						assert.Equals(t, "the-token", token)
+						return "admin", nil
+					},
+					auditAdminRequest: auditAdmin,
+				},
+				ctx:        provCtx,
+				header:     "Bearer the-token",
+				statusCode: 200,
+				audited:    true,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			audited = false
+			h := &Handler{Auth: tc.auth}
+			req := httptest.NewRequest("GET", url, nil)
+			req = req.WithContext(tc.ctx)
+			req.TLS = tc.tls
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			h.requireAdmin(testNext)(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+			assert.Equals(t, tc.audited, audited)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				assertProblem(t, body, res, tc.problem)
+			} else {
+				assert.Equals(t, bytes.TrimSpace(body), testBody)
+			}
+		})
+	}
+}
+
+func TestHandlerNewExternalAccountKey(t *testing.T) {
+	prov := newProv()
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/eab-keys", acme.URLSafeProvisionerName(prov))
+	eak := &acme.ExternalAccountKey{
+		ID:          "eakID",
+		Provisioner: prov.GetID(),
+		Reference:   "ref",
+		HmacKey:     "aG1hYy1rZXk",
+		CreatedAt:   time.Now().UTC().Round(time.Second),
+	}
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		body       string
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/bad-json": func(t *testing.T) test {
+			return test{
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       "{",
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("failed to unmarshal new external account key request: error decoding json: unexpected EOF")),
+			}
+		},
+		"fail/authority-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.ServerInternalErr(errors.New("force")),
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       `{"reference":"ref"}`,
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					newExternalAccountKey: func(p provisioner.Interface, ref string) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, ref, "ref")
+						return eak, nil
+					},
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       `{"reference":"ref"}`,
+				statusCode: 201,
+			}
+		},
+		"ok/empty-body": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					newExternalAccountKey: func(p provisioner.Interface, ref string) (*acme.ExternalAccountKey, error) {
+						assert.Equals(t, ref, "")
+						return eak, nil
+					},
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 201,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Auth: tc.auth}
+			req := httptest.NewRequest("POST", url, strings.NewReader(tc.body))
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.NewExternalAccountKey(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				assertProblem(t, body, res, tc.problem)
+			} else {
+				expB, err := json.Marshal(eak)
+				assert.FatalError(t, err)
+				assert.Equals(t, bytes.TrimSpace(body), expB)
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+			}
+		})
+	}
+}
+
+func TestHandlerGetExternalAccountKeys(t *testing.T) {
+	prov := newProv()
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/eab-keys", acme.URLSafeProvisionerName(prov))
+	keys := []*acme.ExternalAccountKey{
+		{ID: "eakID", Provisioner: prov.GetID(), Reference: "ref", AccountID: "accID"},
+		{ID: "otherID", Provisioner: prov.GetID()},
+	}
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/authority-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.ServerInternalErr(errors.New("force")),
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					getExternalAccountKeys: func(p provisioner.Interface) ([]*acme.ExternalAccountKey, error) {
+						assert.Equals(t, p, prov)
+						return keys, nil
+					},
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Auth: tc.auth}
+			req := httptest.NewRequest("GET", url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.GetExternalAccountKeys(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				assertProblem(t, body, res, tc.problem)
+			} else {
+				expB, err := json.Marshal(keys)
+				assert.FatalError(t, err)
+				assert.Equals(t, bytes.TrimSpace(body), expB)
+			}
+		})
+	}
+}
+
+func TestHandlerDeleteExternalAccountKey(t *testing.T) {
+	prov := newProv()
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/eab-keys/eakID", acme.URLSafeProvisionerName(prov))
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/authority-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.MalformedErr(errors.New("external account key eakID not found")),
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("external account key eakID not found")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					deleteExternalAccountKey: func(p provisioner.Interface, id string) error {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, id, "eakID")
+						return nil
+					},
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				statusCode: 204,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Auth: tc.auth}
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("keyID", "eakID")
+			req := httptest.NewRequest("DELETE", url, nil)
+			req = req.WithContext(context.WithValue(tc.ctx, chi.RouteCtxKey, chiCtx))
+			w := httptest.NewRecorder()
+			h.DeleteExternalAccountKey(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				assertProblem(t, body, res, tc.problem)
+			} else {
+				assert.Equals(t, len(body), 0)
+			}
+		})
+	}
+}
+
+func TestEarlyRenewalRequestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *EarlyRenewalRequest
+		err  *acme.Error
+	}{
+		{"fail/empty", &EarlyRenewalRequest{}, acme.MalformedErr(errors.New("ids or all must be set"))},
+		{"fail/ids-and-all", &EarlyRenewalRequest{IDs: []string{"foo"}, All: true}, acme.MalformedErr(errors.New("ids and all cannot be set at the same time"))},
+		{"fail/empty-id", &EarlyRenewalRequest{IDs: []string{"foo", ""}}, acme.MalformedErr(errors.New("ids cannot contain an empty value"))},
+		{"ok/ids", &EarlyRenewalRequest{IDs: []string{"foo", "bar"}}, nil},
+		{"ok/all", &EarlyRenewalRequest{All: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); err != nil {
+				if assert.NotNil(t, tt.err) {
+					ae, ok := err.(*acme.Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tt.err.Error())
+					assert.Equals(t, ae.StatusCode(), tt.err.StatusCode())
+					assert.Equals(t, ae.Type, tt.err.Type)
+				}
+			} else {
+				assert.Nil(t, tt.err)
+			}
+		})
+	}
+}
+
+func TestHandlerTriggerEarlyRenewal(t *testing.T) {
+	prov := newProv()
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/renewal-info", acme.URLSafeProvisionerName(prov))
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		body       string
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/bad-json": func(t *testing.T) test {
+			return test{
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       "{",
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("failed to unmarshal early renewal request: error decoding json: unexpected EOF")),
+			}
+		},
+		"fail/validate": func(t *testing.T) test {
+			return test{
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       "{}",
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("ids or all must be set")),
+			}
+		},
+		"fail/authority-error": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.MalformedErr(errors.New("renewal info for foo not found")),
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       `{"ids":["foo"]}`,
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.New("renewal info for foo not found")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				auth: &mockAcmeAuthority{
+					triggerEarlyRenewal: func(p provisioner.Interface, opts acme.EarlyRenewalOptions) ([]string, error) {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, opts, acme.EarlyRenewalOptions{
+							All:            true,
+							ExplanationURL: "https://status.smallstep.com/incident",
+						})
+						return []string{"foo", "bar"}, nil
+					},
+				},
+				ctx:        context.WithValue(context.Background(), provisionerContextKey, prov),
+				body:       `{"all":true,"explanationURL":"https://status.smallstep.com/incident"}`,
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Auth: tc.auth}
+			req := httptest.NewRequest("POST", url, strings.NewReader(tc.body))
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.TriggerEarlyRenewal(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				assertProblem(t, body, res, tc.problem)
+			} else {
+				assert.Equals(t, bytes.TrimSpace(body), []byte(`{"ids":["foo","bar"]}`))
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
@@ -12,6 +14,10 @@ import (
 	"github.com/smallstep/cli/jose"
 )
 
+// renewalInfoRetryAfter is the time that ACME clients should wait before
+// polling the renewalInfo resource again.
+const renewalInfoRetryAfter = 6 * time.Hour
+
 func link(url, typ string) string {
 	return fmt.Sprintf("<%s>;rel=\"%s\"", url, typ)
 }
@@ -103,6 +109,15 @@ func (h *Handler) Route(r api.Router) {
 	r.MethodFunc("POST", getLink(acme.AuthzLink, "{provisionerID}", false, "{authzID}"), extractPayloadByKid(h.isPostAsGet(h.GetAuthz)))
 	r.MethodFunc("POST", getLink(acme.ChallengeLink, "{provisionerID}", false, "{chID}"), extractPayloadByKid(h.GetChallenge))
 	r.MethodFunc("POST", getLink(acme.CertificateLink, "{provisionerID}", false, "{certID}"), extractPayloadByKid(h.isPostAsGet(h.GetCertificate)))
+
+	// ACME Renewal Information (ARI)
+	r.MethodFunc("GET", getLink(acme.RenewalInfoLink, "{provisionerID}", false, "{certID}"), h.lookupProvisioner(h.GetRenewalInfo))
+
+	// Admin API
+	r.MethodFunc("POST", getLink(acme.ExternalAccountKeysLink, "{provisionerID}", false), h.lookupProvisioner(h.requireAdmin(h.NewExternalAccountKey)))
+	r.MethodFunc("GET", getLink(acme.ExternalAccountKeysLink, "{provisionerID}", false), h.lookupProvisioner(h.requireAdmin(h.GetExternalAccountKeys)))
+	r.MethodFunc("DELETE", getLink(acme.ExternalAccountKeyLink, "{provisionerID}", false, "{keyID}"), h.lookupProvisioner(h.requireAdmin(h.DeleteExternalAccountKey)))
+	r.MethodFunc("POST", getLink(acme.EarlyRenewalLink, "{provisionerID}", false), h.lookupProvisioner(h.requireAdmin(h.TriggerEarlyRenewal)))
 }
 
 // GetNonce just sets the right header since a Nonce is added to each response
@@ -163,7 +178,7 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	}
 	// Just verify that the payload was set, since we're not strictly adhering
 	// to ACME V2 spec for reasons specified below.
-	_, err = payloadFromContext(r)
+	payload, err := payloadFromContext(r)
 	if err != nil {
 		api.WriteError(w, err)
 		return
@@ -173,12 +188,13 @@ func (h *Handler) GetChallenge(w http.ResponseWriter, r *http.Request) {
 	// that the payload is an empty JSON block ({}). However, older ACME clients
 	// still send a vestigial body (rather than an empty JSON block) and
 	// strict enforcement would render these clients broken. For the time being
-	// we'll just ignore the body.
+	// we'll just ignore the body, except for the challenges that require data
+	// from the client, like device-attest-01.
 	var (
 		ch   *acme.Challenge
 		chID = chi.URLParam(r, "chID")
 	)
-	ch, err = h.Auth.ValidateChallenge(prov, acc.GetID(), chID, acc.GetKey())
+	ch, err = h.Auth.ValidateChallenge(prov, acc.GetID(), chID, acc.GetKey(), payload.value)
 	if err != nil {
 		api.WriteError(w, err)
 		return
@@ -207,3 +223,21 @@ func (h *Handler) GetCertificate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/pem-certificate-chain; charset=utf-8")
 	w.Write(certBytes)
 }
+
+// GetRenewalInfo ACME api for retrieving the suggested renewal window of a
+// certificate. Unlike the other resources, it does not require a JWS.
+func (h *Handler) GetRenewalInfo(w http.ResponseWriter, r *http.Request) {
+	prov, err := provisionerFromContext(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	ri, err := h.Auth.GetRenewalInfo(prov, chi.URLParam(r, "certID"))
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(renewalInfoRetryAfter.Seconds())))
+	api.JSON(w, ri)
+}
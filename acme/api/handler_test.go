@@ -23,26 +23,53 @@ import (
 )
 
 type mockAcmeAuthority struct {
-	deactivateAccount   func(provisioner.Interface, string) (*acme.Account, error)
-	finalizeOrder       func(p provisioner.Interface, accID string, id string, csr *x509.CertificateRequest) (*acme.Order, error)
-	getAccount          func(p provisioner.Interface, id string) (*acme.Account, error)
-	getAccountByKey     func(provisioner.Interface, *jose.JSONWebKey) (*acme.Account, error)
-	getAuthz            func(p provisioner.Interface, accID string, id string) (*acme.Authz, error)
-	getCertificate      func(accID string, id string) ([]byte, error)
-	getChallenge        func(p provisioner.Interface, accID string, id string) (*acme.Challenge, error)
-	getDirectory        func(provisioner.Interface) *acme.Directory
-	getLink             func(acme.Link, string, bool, ...string) string
-	getOrder            func(p provisioner.Interface, accID string, id string) (*acme.Order, error)
-	getOrdersByAccount  func(p provisioner.Interface, id string) ([]string, error)
-	loadProvisionerByID func(string) (provisioner.Interface, error)
-	newAccount          func(provisioner.Interface, acme.AccountOptions) (*acme.Account, error)
-	newNonce            func() (string, error)
-	newOrder            func(provisioner.Interface, acme.OrderOptions) (*acme.Order, error)
-	updateAccount       func(provisioner.Interface, string, []string) (*acme.Account, error)
-	useNonce            func(string) error
-	validateChallenge   func(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error)
-	ret1                interface{}
-	err                 error
+	authorizeAdmin           func(context.Context, *x509.Certificate, string, provisioner.Interface) (string, error)
+	auditAdminRequest        func(requester, method, path string, status int)
+	deactivateAccount        func(provisioner.Interface, string) (*acme.Account, error)
+	deleteExternalAccountKey func(provisioner.Interface, string) error
+	finalizeOrder            func(p provisioner.Interface, accID string, id string, csr *x509.CertificateRequest) (*acme.Order, error)
+	getAccount               func(p provisioner.Interface, id string) (*acme.Account, error)
+	getAccountByKey          func(provisioner.Interface, *jose.JSONWebKey) (*acme.Account, error)
+	getAuthz                 func(p provisioner.Interface, accID string, id string) (*acme.Authz, error)
+	getCertificate           func(accID string, id string) ([]byte, error)
+	getChallenge             func(p provisioner.Interface, accID string, id string) (*acme.Challenge, error)
+	getDirectory             func(provisioner.Interface) *acme.Directory
+	getExternalAccountKeys   func(provisioner.Interface) ([]*acme.ExternalAccountKey, error)
+	getLink                  func(acme.Link, string, bool, ...string) string
+	getOrder                 func(p provisioner.Interface, accID string, id string) (*acme.Order, error)
+	getOrdersByAccount       func(p provisioner.Interface, id string) ([]string, error)
+	getRenewalInfo           func(provisioner.Interface, string) (*acme.RenewalInfo, error)
+	loadProvisionerByID      func(string) (provisioner.Interface, error)
+	newAccount               func(provisioner.Interface, acme.AccountOptions) (*acme.Account, error)
+	newExternalAccountKey    func(provisioner.Interface, string) (*acme.ExternalAccountKey, error)
+	newNonce                 func() (string, error)
+	newOrder                 func(provisioner.Interface, acme.OrderOptions) (*acme.Order, error)
+	triggerEarlyRenewal      func(provisioner.Interface, acme.EarlyRenewalOptions) ([]string, error)
+	updateAccount            func(provisioner.Interface, string, []string) (*acme.Account, error)
+	useNonce                 func(string) error
+	validateChallenge        func(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error)
+	ret1                     interface{}
+	err                      error
+}
+
+func (m *mockAcmeAuthority) AuthorizeAdmin(ctx context.Context, cert *x509.Certificate, token string, p provisioner.Interface) (string, error) {
+	if m.authorizeAdmin != nil {
+		return m.authorizeAdmin(ctx, cert, token, p)
+	}
+	return "", m.err
+}
+
+func (m *mockAcmeAuthority) AuditAdminRequest(requester, method, path string, status int) {
+	if m.auditAdminRequest != nil {
+		m.auditAdminRequest(requester, method, path, status)
+	}
+}
+
+func (m *mockAcmeAuthority) DeleteExternalAccountKey(p provisioner.Interface, id string) error {
+	if m.deleteExternalAccountKey != nil {
+		return m.deleteExternalAccountKey(p, id)
+	}
+	return m.err
 }
 
 func (m *mockAcmeAuthority) DeactivateAccount(p provisioner.Interface, id string) (*acme.Account, error) {
@@ -115,6 +142,15 @@ func (m *mockAcmeAuthority) GetDirectory(p provisioner.Interface) *acme.Director
 	return m.ret1.(*acme.Directory)
 }
 
+func (m *mockAcmeAuthority) GetExternalAccountKeys(p provisioner.Interface) ([]*acme.ExternalAccountKey, error) {
+	if m.getExternalAccountKeys != nil {
+		return m.getExternalAccountKeys(p)
+	} else if m.err != nil {
+		return nil, m.err
+	}
+	return m.ret1.([]*acme.ExternalAccountKey), m.err
+}
+
 func (m *mockAcmeAuthority) GetLink(typ acme.Link, provID string, abs bool, in ...string) string {
 	if m.getLink != nil {
 		return m.getLink(typ, provID, abs, in...)
@@ -140,6 +176,15 @@ func (m *mockAcmeAuthority) GetOrdersByAccount(p provisioner.Interface, id strin
 	return m.ret1.([]string), m.err
 }
 
+func (m *mockAcmeAuthority) GetRenewalInfo(p provisioner.Interface, id string) (*acme.RenewalInfo, error) {
+	if m.getRenewalInfo != nil {
+		return m.getRenewalInfo(p, id)
+	} else if m.err != nil {
+		return nil, m.err
+	}
+	return m.ret1.(*acme.RenewalInfo), m.err
+}
+
 func (m *mockAcmeAuthority) LoadProvisionerByID(provID string) (provisioner.Interface, error) {
 	if m.loadProvisionerByID != nil {
 		return m.loadProvisionerByID(provID)
@@ -158,6 +203,15 @@ func (m *mockAcmeAuthority) NewAccount(p provisioner.Interface, ops acme.Account
 	return m.ret1.(*acme.Account), m.err
 }
 
+func (m *mockAcmeAuthority) NewExternalAccountKey(p provisioner.Interface, ref string) (*acme.ExternalAccountKey, error) {
+	if m.newExternalAccountKey != nil {
+		return m.newExternalAccountKey(p, ref)
+	} else if m.err != nil {
+		return nil, m.err
+	}
+	return m.ret1.(*acme.ExternalAccountKey), m.err
+}
+
 func (m *mockAcmeAuthority) NewNonce() (string, error) {
 	if m.newNonce != nil {
 		return m.newNonce()
@@ -176,6 +230,15 @@ func (m *mockAcmeAuthority) NewOrder(p provisioner.Interface, ops acme.OrderOpti
 	return m.ret1.(*acme.Order), m.err
 }
 
+func (m *mockAcmeAuthority) TriggerEarlyRenewal(p provisioner.Interface, opts acme.EarlyRenewalOptions) ([]string, error) {
+	if m.triggerEarlyRenewal != nil {
+		return m.triggerEarlyRenewal(p, opts)
+	} else if m.err != nil {
+		return nil, m.err
+	}
+	return m.ret1.([]string), m.err
+}
+
 func (m *mockAcmeAuthority) UpdateAccount(p provisioner.Interface, id string, contact []string) (*acme.Account, error) {
 	if m.updateAccount != nil {
 		return m.updateAccount(p, id, contact)
@@ -192,10 +255,10 @@ func (m *mockAcmeAuthority) UseNonce(nonce string) error {
 	return m.err
 }
 
-func (m *mockAcmeAuthority) ValidateChallenge(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error) {
+func (m *mockAcmeAuthority) ValidateChallenge(p provisioner.Interface, accID string, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error) {
 	switch {
 	case m.validateChallenge != nil:
-		return m.validateChallenge(p, accID, id, jwk)
+		return m.validateChallenge(p, accID, id, jwk, payload)
 	case m.err != nil:
 		return nil, m.err
 	default:
@@ -237,11 +300,12 @@ func TestHandlerGetDirectory(t *testing.T) {
 	url := fmt.Sprintf("http://ca.smallstep.com/acme/%s/directory", acme.URLSafeProvisionerName(prov))
 
 	expDir := acme.Directory{
-		NewNonce:   fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-nonce", acme.URLSafeProvisionerName(prov)),
-		NewAccount: fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-account", acme.URLSafeProvisionerName(prov)),
-		NewOrder:   fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-order", acme.URLSafeProvisionerName(prov)),
-		RevokeCert: fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", acme.URLSafeProvisionerName(prov)),
-		KeyChange:  fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", acme.URLSafeProvisionerName(prov)),
+		NewNonce:    fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-nonce", acme.URLSafeProvisionerName(prov)),
+		NewAccount:  fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-account", acme.URLSafeProvisionerName(prov)),
+		NewOrder:    fmt.Sprintf("https://ca.smallstep.com/acme/%s/new-order", acme.URLSafeProvisionerName(prov)),
+		RevokeCert:  fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", acme.URLSafeProvisionerName(prov)),
+		KeyChange:   fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", acme.URLSafeProvisionerName(prov)),
+		RenewalInfo: fmt.Sprintf("https://ca.smallstep.com/acme/%s/renewal-info", acme.URLSafeProvisionerName(prov)),
 	}
 
 	type test struct {
@@ -581,6 +645,88 @@ func TestHandlerGetCertificate(t *testing.T) {
 	}
 }
 
+func TestHandlerGetRenewalInfo(t *testing.T) {
+	prov := newProv()
+	id := "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("certID", id)
+	url := fmt.Sprintf("https://ca.smallstep.com/acme/%s/renewal-info/%s",
+		acme.URLSafeProvisionerName(prov), id)
+	now := time.Now().UTC().Round(time.Second)
+	ri := &acme.RenewalInfo{
+		SuggestedWindow: acme.RenewalWindow{Start: now, End: now.Add(time.Hour)},
+	}
+
+	type test struct {
+		auth       acme.Interface
+		ctx        context.Context
+		statusCode int
+		problem    *acme.Error
+	}
+	var tests = map[string]func(t *testing.T) test{
+		"fail/no-provisioner": func(t *testing.T) test {
+			return test{
+				auth:       &mockAcmeAuthority{},
+				ctx:        context.Background(),
+				statusCode: 500,
+				problem:    acme.ServerInternalErr(errors.New("provisioner expected in request context")),
+			}
+		},
+		"fail/getRenewalInfo-error": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			return test{
+				auth: &mockAcmeAuthority{
+					err: acme.MalformedErr(errors.Errorf("renewal info for %s not found", id)),
+				},
+				ctx:        context.WithValue(ctx, chi.RouteCtxKey, chiCtx),
+				statusCode: 400,
+				problem:    acme.MalformedErr(errors.Errorf("renewal info for %s not found", id)),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			return test{
+				auth: &mockAcmeAuthority{
+					getRenewalInfo: func(p provisioner.Interface, certID string) (*acme.RenewalInfo, error) {
+						assert.Equals(t, p, prov)
+						assert.Equals(t, certID, id)
+						return ri, nil
+					},
+				},
+				ctx:        context.WithValue(ctx, chi.RouteCtxKey, chiCtx),
+				statusCode: 200,
+			}
+		},
+	}
+	for name, run := range tests {
+		tc := run(t)
+		t.Run(name, func(t *testing.T) {
+			h := New(tc.auth).(*Handler)
+			req := httptest.NewRequest("GET", url, nil)
+			req = req.WithContext(tc.ctx)
+			w := httptest.NewRecorder()
+			h.GetRenewalInfo(w, req)
+			res := w.Result()
+
+			assert.Equals(t, res.StatusCode, tc.statusCode)
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			if res.StatusCode >= 400 && assert.NotNil(t, tc.problem) {
+				assertProblem(t, body, res, tc.problem)
+			} else {
+				expB, err := json.Marshal(ri)
+				assert.FatalError(t, err)
+				assert.Equals(t, bytes.TrimSpace(body), expB)
+				assert.Equals(t, res.Header["Retry-After"], []string{"21600"})
+				assert.Equals(t, res.Header["Content-Type"], []string{"application/json"})
+			}
+		})
+	}
+}
+
 func ch() acme.Challenge {
 	return acme.Challenge{
 		Type:    "http-01",
@@ -701,7 +847,7 @@ func TestHandlerGetChallenge(t *testing.T) {
 			count := 0
 			return test{
 				auth: &mockAcmeAuthority{
-					validateChallenge: func(p provisioner.Interface, accID, id string, jwk *jose.JSONWebKey) (*acme.Challenge, error) {
+					validateChallenge: func(p provisioner.Interface, accID, id string, jwk *jose.JSONWebKey, payload []byte) (*acme.Challenge, error) {
 						assert.Equals(t, p, prov)
 						assert.Equals(t, accID, acc.ID)
 						assert.Equals(t, id, ch.ID)
@@ -26,7 +26,7 @@ func (n *NewOrderRequest) Validate() error {
 		return acme.MalformedErr(errors.Errorf("identifiers list cannot be empty"))
 	}
 	for _, id := range n.Identifiers {
-		if id.Type != "dns" {
+		if id.Type != "dns" && id.Type != "ip" && id.Type != "permanent-identifier" {
 			return acme.MalformedErr(errors.Errorf("identifier type unsupported: %s", id.Type))
 		}
 	}
@@ -85,10 +85,11 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	o, err := h.Auth.NewOrder(prov, acme.OrderOptions{
-		AccountID:   acc.GetID(),
-		Identifiers: nor.Identifiers,
-		NotBefore:   nor.NotBefore,
-		NotAfter:    nor.NotAfter,
+		AccountID:       acc.GetID(),
+		Identifiers:     nor.Identifiers,
+		NotBefore:       nor.NotBefore,
+		NotAfter:        nor.NotAfter,
+		ExternalAccount: acc.ExternalAccount,
 	})
 	if err != nil {
 		api.WriteError(w, err)
@@ -52,6 +52,8 @@ func TestNewOrderRequestValidate(t *testing.T) {
 					Identifiers: []acme.Identifier{
 						{Type: "dns", Value: "example.com"},
 						{Type: "dns", Value: "bar.com"},
+						{Type: "permanent-identifier", Value: "SERIAL"},
+						{Type: "ip", Value: "10.0.0.1"},
 					},
 					NotAfter:  naf,
 					NotBefore: nbf,
@@ -322,6 +324,7 @@ func TestHandlerNewOrder(t *testing.T) {
 		ctx        context.Context
 		statusCode int
 		problem    *acme.Error
+		retryAfter string
 	}
 	var tests = map[string]func(t *testing.T) test{
 		"fail/no-provisioner": func(t *testing.T) test {
@@ -429,6 +432,32 @@ func TestHandlerNewOrder(t *testing.T) {
 				problem:    acme.MalformedErr(errors.New("force")),
 			}
 		},
+		"fail/rate-limited": func(t *testing.T) test {
+			acc := &acme.Account{ID: "accID"}
+			nor := &NewOrderRequest{
+				Identifiers: []acme.Identifier{
+					{Type: "dns", Value: "example.com"},
+				},
+			}
+			b, err := json.Marshal(nor)
+			assert.FatalError(t, err)
+			ctx := context.WithValue(context.Background(), provisionerContextKey, prov)
+			ctx = context.WithValue(ctx, accContextKey, acc)
+			ctx = context.WithValue(ctx, payloadContextKey, &payloadInfo{value: b})
+			return test{
+				auth: &mockAcmeAuthority{
+					newOrder: func(p provisioner.Interface, ops acme.OrderOptions) (*acme.Order, error) {
+						e := acme.RateLimitedErr(errors.New("force"))
+						e.RetryAfter = 90500 * time.Millisecond
+						return nil, e
+					},
+				},
+				ctx:        ctx,
+				statusCode: 429,
+				problem:    acme.RateLimitedErr(errors.New("force")),
+				retryAfter: "91",
+			}
+		},
 		"ok": func(t *testing.T) test {
 			acc := &acme.Account{ID: "accID"}
 			nor := &NewOrderRequest{
@@ -529,6 +558,7 @@ func TestHandlerNewOrder(t *testing.T) {
 				assert.Equals(t, ae.Identifier, prob.Identifier)
 				assert.Equals(t, ae.Subproblems, prob.Subproblems)
 				assert.Equals(t, res.Header["Content-Type"], []string{"application/problem+json"})
+				assert.Equals(t, res.Header.Get("Retry-After"), tc.retryAfter)
 			} else {
 				expB, err := json.Marshal(o)
 				assert.FatalError(t, err)
@@ -0,0 +1,480 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+var (
+	oidSubjectAltName              = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidPermanentIdentifier         = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 3}
+	oidTCGKpAIKCertificate         = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
+	oidAppleSerialNumber           = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 9, 1}
+	oidAppleUniqueDeviceIdentifier = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 9, 2}
+	oidAppleNonce                  = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 11, 1}
+)
+
+// COSE algorithm identifiers supported in TPM attestation statements.
+const (
+	coseAlgES256 int64 = -7
+	coseAlgRS256 int64 = -257
+)
+
+// TPM 2.0 constants used to parse the TPMS_ATTEST structure.
+const (
+	tpmGeneratedValue      uint32 = 0xff544347
+	tpmSTAttestCertify     uint16 = 0x8017
+	tpmAlgSHA1             uint16 = 0x0004
+	tpmAlgSHA256           uint16 = 0x000B
+	tpmAlgSHA384           uint16 = 0x000C
+	tpmAlgSHA512           uint16 = 0x000D
+	tpmClockInfoSize              = 17
+	tpmFirmwareVersionSize        = 8
+)
+
+// attestationProvisioner is the interface implemented by the provisioners that
+// support the device-attest-01 challenge.
+type attestationProvisioner interface {
+	IsAttestationFormatEnabled(provisioner.ACMEAttestationFormat) bool
+	GetAttestationRoots() *x509.CertPool
+}
+
+// getAttestationProvisioner returns the given provisioner as an
+// attestationProvisioner if it has the device attestation enabled.
+func getAttestationProvisioner(p provisioner.Interface) (attestationProvisioner, bool) {
+	ap, ok := p.(attestationProvisioner)
+	if !ok || ap.GetAttestationRoots() == nil {
+		return nil, false
+	}
+	return ap, true
+}
+
+// attestationObject is the WebAuthn like attestation object sent by the
+// clients in the device-attest-01 challenge.
+type attestationObject struct {
+	Format       string
+	AttStatement map[string]interface{}
+	AuthData     []byte
+}
+
+// parseAttestationObject decodes a CBOR encoded attestation object.
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	v, err := cborUnmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	obj := new(attestationObject)
+	if obj.Format, ok = m["fmt"].(string); !ok || obj.Format == "" {
+		return nil, errors.New("attestation object fmt is missing or invalid")
+	}
+	stmt, ok := m["attStmt"].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object attStmt is missing or invalid")
+	}
+	obj.AttStatement = make(map[string]interface{}, len(stmt))
+	for k, v := range stmt {
+		s, ok := k.(string)
+		if !ok {
+			return nil, errors.New("attestation object attStmt contains a non-string key")
+		}
+		obj.AttStatement[s] = v
+	}
+	if v, ok := m["authData"]; ok {
+		if obj.AuthData, ok = v.([]byte); !ok {
+			return nil, errors.New("attestation object authData is invalid")
+		}
+	}
+	return obj, nil
+}
+
+// deviceAttestation contains the data extracted from a verified attestation
+// statement.
+type deviceAttestation struct {
+	Format               provisioner.ACMEAttestationFormat
+	PermanentIdentifiers []string
+	Certificate          *x509.Certificate
+}
+
+// hasPermanentIdentifier returns true if the given identifier has been attested.
+func (da *deviceAttestation) hasPermanentIdentifier(id string) bool {
+	for _, s := range da.PermanentIdentifiers {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAttestation verifies the attestation statement in the attestation
+// object. The statement must be bound to the given key authorization and its
+// certificate chain must be signed by one of the given roots.
+func verifyAttestation(obj *attestationObject, keyAuth string, roots *x509.CertPool) (*deviceAttestation, error) {
+	switch provisioner.ACMEAttestationFormat(obj.Format) {
+	case provisioner.AppleAttestationFormat:
+		return verifyAppleAttestation(obj.AttStatement, keyAuth, roots)
+	case provisioner.TPMAttestationFormat:
+		return verifyTPMAttestation(obj.AttStatement, keyAuth, roots)
+	default:
+		return nil, errors.Errorf("unsupported attestation format %s", obj.Format)
+	}
+}
+
+// verifyAppleAttestation verifies an attestation generated by Apple devices
+// using the Managed Device Attestation. The nonce in the leaf certificate must
+// be the SHA-256 of the key authorization and the permanent identifiers are
+// the serial number and the UDID of the device.
+func verifyAppleAttestation(stmt map[string]interface{}, keyAuth string, roots *x509.CertPool) (*deviceAttestation, error) {
+	certs, err := parseX5C(stmt)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyX5C(certs, roots); err != nil {
+		return nil, err
+	}
+
+	var nonce []byte
+	att := &deviceAttestation{
+		Format:      provisioner.AppleAttestationFormat,
+		Certificate: certs[0],
+	}
+	for _, ext := range certs[0].Extensions {
+		switch {
+		case ext.Id.Equal(oidAppleSerialNumber), ext.Id.Equal(oidAppleUniqueDeviceIdentifier):
+			if len(ext.Value) > 0 {
+				att.PermanentIdentifiers = append(att.PermanentIdentifiers, string(ext.Value))
+			}
+		case ext.Id.Equal(oidAppleNonce):
+			nonce = ext.Value
+		}
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	if len(nonce) == 0 {
+		return nil, errors.New("apple attestation certificate is missing the nonce")
+	}
+	if subtle.ConstantTimeCompare(sum[:], nonce) != 1 {
+		return nil, errors.New("apple attestation nonce does not match the key authorization")
+	}
+	if len(att.PermanentIdentifiers) == 0 {
+		return nil, errors.New("apple attestation certificate does not contain a device identifier")
+	}
+	return att, nil
+}
+
+// verifyTPMAttestation verifies an attestation generated by a TPM 2.0 device.
+// The certInfo must be signed by the attestation key (AIK) in the x5c chain,
+// certify the key in pubArea and include the SHA-256 of the key authorization
+// as extraData. The permanent identifiers are the ones in the subject
+// alternative name of the AIK certificate.
+func verifyTPMAttestation(stmt map[string]interface{}, keyAuth string, roots *x509.CertPool) (*deviceAttestation, error) {
+	if v, _ := stmt["ver"].(string); v != "2.0" {
+		return nil, errors.Errorf("unsupported tpm attestation version %v", stmt["ver"])
+	}
+	alg, ok := stmt["alg"].(int64)
+	if !ok {
+		return nil, errors.New("tpm attestation alg is missing or invalid")
+	}
+	sig, ok := stmt["sig"].([]byte)
+	if !ok || len(sig) == 0 {
+		return nil, errors.New("tpm attestation sig is missing or invalid")
+	}
+	certInfo, ok := stmt["certInfo"].([]byte)
+	if !ok || len(certInfo) == 0 {
+		return nil, errors.New("tpm attestation certInfo is missing or invalid")
+	}
+	pubArea, ok := stmt["pubArea"].([]byte)
+	if !ok || len(pubArea) == 0 {
+		return nil, errors.New("tpm attestation pubArea is missing or invalid")
+	}
+
+	certs, err := parseX5C(stmt)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyX5C(certs, roots); err != nil {
+		return nil, err
+	}
+	aik := certs[0]
+	var isAIK bool
+	for _, eku := range aik.UnknownExtKeyUsage {
+		if eku.Equal(oidTCGKpAIKCertificate) {
+			isAIK = true
+			break
+		}
+	}
+	if !isAIK {
+		return nil, errors.New("tpm attestation certificate is not an AIK certificate")
+	}
+
+	if err := verifyCOSESignature(aik.PublicKey, alg, certInfo, sig); err != nil {
+		return nil, err
+	}
+
+	info, err := parseTPMSAttest(certInfo)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	if subtle.ConstantTimeCompare(sum[:], info.ExtraData) != 1 {
+		return nil, errors.New("tpm attestation extraData does not match the key authorization")
+	}
+	name, err := tpmName(info.Name, pubArea)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(name, info.Name) {
+		return nil, errors.New("tpm attestation certInfo does not certify the key in pubArea")
+	}
+
+	ids, err := parsePermanentIdentifiers(aik)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("tpm attestation certificate does not contain a permanent identifier")
+	}
+	return &deviceAttestation{
+		Format:               provisioner.TPMAttestationFormat,
+		PermanentIdentifiers: ids,
+		Certificate:          aik,
+	}, nil
+}
+
+// parseX5C parses the certificate chain in the x5c attribute of an
+// attestation statement.
+func parseX5C(stmt map[string]interface{}) ([]*x509.Certificate, error) {
+	x5c, ok := stmt["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return nil, errors.New("attestation x5c is missing or invalid")
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, v := range x5c {
+		der, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("attestation x5c is missing or invalid")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing attestation x5c certificate")
+		}
+		certs[i] = cert
+	}
+	return certs, nil
+}
+
+// verifyX5C verifies the certificate chain using the given roots.
+func verifyX5C(certs []*x509.Certificate, roots *x509.CertPool) error {
+	if roots == nil {
+		return errors.New("attestation roots are not configured")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   clock.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errors.Wrap(err, "error verifying attestation x5c chain")
+	}
+	return nil
+}
+
+// verifyCOSESignature verifies the signature of data using the given COSE
+// algorithm.
+func verifyCOSESignature(pub crypto.PublicKey, alg int64, data, sig []byte) error {
+	sum := sha256.Sum256(data)
+	switch alg {
+	case coseAlgRS256:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("attestation certificate key type does not match alg RS256")
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return errors.New("error verifying attestation signature")
+		}
+		return nil
+	case coseAlgES256:
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("attestation certificate key type does not match alg ES256")
+		}
+		var es struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &es); err != nil || len(rest) > 0 {
+			return errors.New("error verifying attestation signature")
+		}
+		if !ecdsa.Verify(key, sum[:], es.R, es.S) {
+			return errors.New("error verifying attestation signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported attestation alg %d", alg)
+	}
+}
+
+// tpmsAttest contains the fields of a TPMS_ATTEST structure used to validate
+// a TPM key certification.
+type tpmsAttest struct {
+	ExtraData []byte
+	Name      []byte
+}
+
+// parseTPMSAttest parses a TPMS_ATTEST structure of type TPM_ST_ATTEST_CERTIFY.
+func parseTPMSAttest(data []byte) (*tpmsAttest, error) {
+	r := bytes.NewReader(data)
+	var hdr struct {
+		Magic uint32
+		Type  uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, errors.New("error parsing tpm attestation certInfo")
+	}
+	if hdr.Magic != tpmGeneratedValue {
+		return nil, errors.New("tpm attestation certInfo has an invalid magic value")
+	}
+	if hdr.Type != tpmSTAttestCertify {
+		return nil, errors.New("tpm attestation certInfo is not a key certification")
+	}
+	// qualifiedSigner
+	if _, err := readTPM2B(r); err != nil {
+		return nil, err
+	}
+	extraData, err := readTPM2B(r)
+	if err != nil {
+		return nil, err
+	}
+	// clockInfo and firmwareVersion
+	if _, err := r.Seek(tpmClockInfoSize+tpmFirmwareVersionSize, 1); err != nil || r.Len() == 0 {
+		return nil, errors.New("error parsing tpm attestation certInfo")
+	}
+	name, err := readTPM2B(r)
+	if err != nil {
+		return nil, err
+	}
+	// qualifiedName
+	if _, err := readTPM2B(r); err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("error parsing tpm attestation certInfo: unexpected trailing data")
+	}
+	return &tpmsAttest{
+		ExtraData: extraData,
+		Name:      name,
+	}, nil
+}
+
+// readTPM2B reads a TPM2B structure, a 2 bytes size followed by the data.
+func readTPM2B(r *bytes.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, errors.New("error parsing tpm attestation certInfo")
+	}
+	if int(size) > r.Len() {
+		return nil, errors.New("error parsing tpm attestation certInfo")
+	}
+	b := make([]byte, size)
+	if _, err := r.Read(b); err != nil && size > 0 {
+		return nil, errors.New("error parsing tpm attestation certInfo")
+	}
+	return b, nil
+}
+
+// tpmName returns the TPM name of pubArea using the same name algorithm than
+// the given name.
+func tpmName(name, pubArea []byte) ([]byte, error) {
+	if len(name) < 2 {
+		return nil, errors.New("tpm attestation certInfo has an invalid name")
+	}
+	var h crypto.Hash
+	alg := binary.BigEndian.Uint16(name)
+	switch alg {
+	case tpmAlgSHA1:
+		h = crypto.SHA1
+	case tpmAlgSHA256:
+		h = crypto.SHA256
+	case tpmAlgSHA384:
+		h = crypto.SHA384
+	case tpmAlgSHA512:
+		h = crypto.SHA512
+	default:
+		return nil, errors.Errorf("tpm attestation certInfo has an unsupported name algorithm %#04x", alg)
+	}
+	if !h.Available() {
+		return nil, errors.Errorf("tpm attestation certInfo has an unsupported name algorithm %#04x", alg)
+	}
+	hh := h.New()
+	hh.Write(pubArea)
+	return hh.Sum(append([]byte(nil), name[:2]...)), nil
+}
+
+// permanentIdentifier is defined in RFC 4043 as an otherName in the subject
+// alternative name extension.
+type permanentIdentifier struct {
+	IdentifierValue string                `asn1:"utf8,optional"`
+	Assigner        asn1.ObjectIdentifier `asn1:"optional"`
+}
+
+// parsePermanentIdentifiers returns the permanent identifiers in the subject
+// alternative name of the given certificate.
+func parsePermanentIdentifiers(cert *x509.Certificate) ([]string, error) {
+	var ids []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &seq); err != nil || len(rest) > 0 {
+			return nil, errors.New("error parsing subject alternative name extension")
+		} else if !seq.IsCompound || seq.Tag != asn1.TagSequence || seq.Class != asn1.ClassUniversal {
+			return nil, errors.New("error parsing subject alternative name extension")
+		}
+		rest := seq.Bytes
+		for len(rest) > 0 {
+			var gn asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &gn); err != nil {
+				return nil, errors.New("error parsing subject alternative name extension")
+			}
+			// otherName [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+			if gn.Class != asn1.ClassContextSpecific || gn.Tag != 0 {
+				continue
+			}
+			var oid asn1.ObjectIdentifier
+			value, err := asn1.Unmarshal(gn.Bytes, &oid)
+			if err != nil {
+				return nil, errors.New("error parsing subject alternative name extension")
+			}
+			if !oid.Equal(oidPermanentIdentifier) {
+				continue
+			}
+			var pi permanentIdentifier
+			if _, err := asn1.UnmarshalWithParams(value, &pi, "explicit,tag:0"); err != nil {
+				return nil, errors.New("error parsing permanent identifier")
+			}
+			if pi.IdentifierValue != "" {
+				ids = append(ids, pi.IdentifierValue)
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,349 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+type attestationCA struct {
+	Root   *x509.Certificate
+	Signer crypto.Signer
+	Pool   *x509.CertPool
+}
+
+func newAttestationCA(t *testing.T) *attestationCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Attestation Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	assert.FatalError(t, err)
+	root, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	return &attestationCA{Root: root, Signer: key, Pool: pool}
+}
+
+func (ca *attestationCA) sign(t *testing.T, tmpl *x509.Certificate, pub crypto.PublicKey) []byte {
+	t.Helper()
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Root, pub, ca.Signer)
+	assert.FatalError(t, err)
+	return der
+}
+
+// newAppleAttestation returns a CBOR encoded apple attestation object.
+func newAppleAttestation(t *testing.T, ca *attestationCA, nonce []byte, serial, udid string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{Subject: pkix.Name{CommonName: "device"}}
+	if serial != "" {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{Id: oidAppleSerialNumber, Value: []byte(serial)})
+	}
+	if udid != "" {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{Id: oidAppleUniqueDeviceIdentifier, Value: []byte(udid)})
+	}
+	if nonce != nil {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{Id: oidAppleNonce, Value: nonce})
+	}
+	der := ca.sign(t, tmpl, key.Public())
+	return cborMarshal(map[string]interface{}{
+		"fmt": "apple",
+		"attStmt": map[string]interface{}{
+			"x5c": []interface{}{der},
+		},
+		"authData": []byte{},
+	})
+}
+
+// marshalPermanentIdentifierSAN returns a subject alternative name extension
+// with the given permanent identifier.
+func marshalPermanentIdentifierSAN(t *testing.T, id string) pkix.Extension {
+	t.Helper()
+	pi, err := asn1.Marshal(permanentIdentifier{IdentifierValue: id})
+	assert.FatalError(t, err)
+	value, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: pi})
+	assert.FatalError(t, err)
+	oid, err := asn1.Marshal(oidPermanentIdentifier)
+	assert.FatalError(t, err)
+	otherName, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(oid, value...)})
+	assert.FatalError(t, err)
+	dnsName, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("tpm.internal")})
+	assert.FatalError(t, err)
+	san, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(dnsName, otherName...)})
+	assert.FatalError(t, err)
+	return pkix.Extension{Id: oidSubjectAltName, Value: san}
+}
+
+type tpmAttestationOptions struct {
+	Version       string
+	Alg           int64
+	ExtraData     []byte
+	Magic         uint32
+	PubArea       []byte
+	CertifiedArea []byte
+	ID            string
+	IsAIK         bool
+	RSA           bool
+}
+
+// newTPMAttestation returns a CBOR encoded tpm attestation object.
+func newTPMAttestation(t *testing.T, ca *attestationCA, opts tpmAttestationOptions) []byte {
+	t.Helper()
+	var signer crypto.Signer
+	var err error
+	if opts.RSA {
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	assert.FatalError(t, err)
+
+	tmpl := &x509.Certificate{}
+	if opts.IsAIK {
+		tmpl.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidTCGKpAIKCertificate}
+	}
+	if opts.ID != "" {
+		tmpl.ExtraExtensions = []pkix.Extension{marshalPermanentIdentifierSAN(t, opts.ID)}
+	}
+	der := ca.sign(t, tmpl, signer.Public())
+
+	tpm2b := func(b []byte) []byte {
+		return append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+	sum := sha256.Sum256(opts.CertifiedArea)
+	name := append([]byte{0x00, 0x0B}, sum[:]...)
+	var certInfo bytes.Buffer
+	binary.Write(&certInfo, binary.BigEndian, opts.Magic)
+	binary.Write(&certInfo, binary.BigEndian, tpmSTAttestCertify)
+	certInfo.Write(tpm2b([]byte{0x00, 0x0B, 0x01, 0x02}))
+	certInfo.Write(tpm2b(opts.ExtraData))
+	certInfo.Write(make([]byte, tpmClockInfoSize+tpmFirmwareVersionSize))
+	certInfo.Write(tpm2b(name))
+	certInfo.Write(tpm2b(name))
+
+	digest := sha256.Sum256(certInfo.Bytes())
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.FatalError(t, err)
+
+	return cborMarshal(map[string]interface{}{
+		"fmt": "tpm",
+		"attStmt": map[string]interface{}{
+			"ver":      opts.Version,
+			"alg":      opts.Alg,
+			"x5c":      []interface{}{der},
+			"sig":      sig,
+			"certInfo": certInfo.Bytes(),
+			"pubArea":  opts.PubArea,
+		},
+		"authData": []byte{},
+	})
+}
+
+func TestParseAttestationObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *attestationObject
+		wantErr bool
+	}{
+		{"ok", cborMarshal(map[string]interface{}{"fmt": "apple", "attStmt": map[string]interface{}{"x5c": []interface{}{}}, "authData": []byte{1}}),
+			&attestationObject{Format: "apple", AttStatement: map[string]interface{}{"x5c": []interface{}{}}, AuthData: []byte{1}}, false},
+		{"ok no authData", cborMarshal(map[string]interface{}{"fmt": "tpm", "attStmt": map[string]interface{}{}}),
+			&attestationObject{Format: "tpm", AttStatement: map[string]interface{}{}}, false},
+		{"fail cbor", []byte{0xff}, nil, true},
+		{"fail not map", cborMarshal("apple"), nil, true},
+		{"fail fmt", cborMarshal(map[string]interface{}{"fmt": 1, "attStmt": map[string]interface{}{}}), nil, true},
+		{"fail attStmt", cborMarshal(map[string]interface{}{"fmt": "apple", "attStmt": "foo"}), nil, true},
+		{"fail attStmt key", []byte{0xa2, 0x63, 'f', 'm', 't', 0x61, 'a', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa1, 0x01, 0x01}, nil, true},
+		{"fail authData", cborMarshal(map[string]interface{}{"fmt": "apple", "attStmt": map[string]interface{}{}, "authData": "foo"}), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAttestationObject(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseAttestationObject() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func TestVerifyAttestation(t *testing.T) {
+	ca := newAttestationCA(t)
+	otherCA := newAttestationCA(t)
+	keyAuth := "token.thumbprint"
+	sum := sha256.Sum256([]byte(keyAuth))
+	pubArea := []byte("pubArea")
+	tpmOpts := func(fn func(o *tpmAttestationOptions)) tpmAttestationOptions {
+		o := tpmAttestationOptions{
+			Version:       "2.0",
+			Alg:           coseAlgES256,
+			ExtraData:     sum[:],
+			Magic:         tpmGeneratedValue,
+			PubArea:       pubArea,
+			CertifiedArea: pubArea,
+			ID:            "EK-1234",
+			IsAIK:         true,
+		}
+		if fn != nil {
+			fn(&o)
+		}
+		return o
+	}
+	parse := func(b []byte) *attestationObject {
+		obj, err := parseAttestationObject(b)
+		assert.FatalError(t, err)
+		return obj
+	}
+
+	tests := []struct {
+		name    string
+		obj     *attestationObject
+		roots   *x509.CertPool
+		want    *deviceAttestation
+		wantErr string
+	}{
+		{"ok apple", parse(newAppleAttestation(t, ca, sum[:], "SERIAL", "UDID")), ca.Pool,
+			&deviceAttestation{Format: provisioner.AppleAttestationFormat, PermanentIdentifiers: []string{"SERIAL", "UDID"}}, ""},
+		{"ok apple serial", parse(newAppleAttestation(t, ca, sum[:], "SERIAL", "")), ca.Pool,
+			&deviceAttestation{Format: provisioner.AppleAttestationFormat, PermanentIdentifiers: []string{"SERIAL"}}, ""},
+		{"ok tpm", parse(newTPMAttestation(t, ca, tpmOpts(nil))), ca.Pool,
+			&deviceAttestation{Format: provisioner.TPMAttestationFormat, PermanentIdentifiers: []string{"EK-1234"}}, ""},
+		{"ok tpm rsa", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.RSA = true; o.Alg = coseAlgRS256 }))), ca.Pool,
+			&deviceAttestation{Format: provisioner.TPMAttestationFormat, PermanentIdentifiers: []string{"EK-1234"}}, ""},
+		{"fail format", &attestationObject{Format: "packed"}, ca.Pool, nil, "unsupported attestation format packed"},
+		{"fail apple roots", parse(newAppleAttestation(t, ca, sum[:], "SERIAL", "UDID")), otherCA.Pool, nil, "error verifying attestation x5c chain"},
+		{"fail apple no roots", parse(newAppleAttestation(t, ca, sum[:], "SERIAL", "UDID")), nil, nil, "attestation roots are not configured"},
+		{"fail apple x5c", &attestationObject{Format: "apple", AttStatement: map[string]interface{}{}}, ca.Pool, nil, "attestation x5c is missing or invalid"},
+		{"fail apple x5c type", &attestationObject{Format: "apple", AttStatement: map[string]interface{}{"x5c": []interface{}{"foo"}}}, ca.Pool, nil, "attestation x5c is missing or invalid"},
+		{"fail apple x5c parse", &attestationObject{Format: "apple", AttStatement: map[string]interface{}{"x5c": []interface{}{[]byte("foo")}}}, ca.Pool, nil, "error parsing attestation x5c certificate"},
+		{"fail apple no nonce", parse(newAppleAttestation(t, ca, nil, "SERIAL", "UDID")), ca.Pool, nil, "apple attestation certificate is missing the nonce"},
+		{"fail apple nonce", parse(newAppleAttestation(t, ca, []byte("foo"), "SERIAL", "UDID")), ca.Pool, nil, "apple attestation nonce does not match the key authorization"},
+		{"fail apple identifier", parse(newAppleAttestation(t, ca, sum[:], "", "")), ca.Pool, nil, "apple attestation certificate does not contain a device identifier"},
+		{"fail tpm version", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.Version = "1.2" }))), ca.Pool, nil, "unsupported tpm attestation version 1.2"},
+		{"fail tpm alg", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.Alg = -8 }))), ca.Pool, nil, "unsupported attestation alg -8"},
+		{"fail tpm alg key", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.Alg = coseAlgRS256 }))), ca.Pool, nil, "attestation certificate key type does not match alg RS256"},
+		{"fail tpm pubArea", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.PubArea = nil }))), ca.Pool, nil, "tpm attestation pubArea is missing or invalid"},
+		{"fail tpm roots", parse(newTPMAttestation(t, ca, tpmOpts(nil))), otherCA.Pool, nil, "error verifying attestation x5c chain"},
+		{"fail tpm aik", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.IsAIK = false }))), ca.Pool, nil, "tpm attestation certificate is not an AIK certificate"},
+		{"fail tpm magic", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.Magic = 0 }))), ca.Pool, nil, "tpm attestation certInfo has an invalid magic value"},
+		{"fail tpm extraData", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.ExtraData = []byte("foo") }))), ca.Pool, nil, "tpm attestation extraData does not match the key authorization"},
+		{"fail tpm name", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.CertifiedArea = []byte("foo") }))), ca.Pool, nil, "tpm attestation certInfo does not certify the key in pubArea"},
+		{"fail tpm identifier", parse(newTPMAttestation(t, ca, tpmOpts(func(o *tpmAttestationOptions) { o.ID = "" }))), ca.Pool, nil, "tpm attestation certificate does not contain a permanent identifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyAttestation(tt.obj, keyAuth, tt.roots)
+			if err != nil {
+				if tt.wantErr == "" || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("verifyAttestation() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				t.Errorf("verifyAttestation() error = nil, wantErr %v", tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.want.Format, got.Format)
+			assert.Equals(t, tt.want.PermanentIdentifiers, got.PermanentIdentifiers)
+			assert.NotNil(t, got.Certificate)
+		})
+	}
+}
+
+func TestParseTPMSAttest(t *testing.T) {
+	valid := []byte{
+		0xff, 0x54, 0x43, 0x47, 0x80, 0x17, // magic + type
+		0x00, 0x00, // qualifiedSigner
+		0x00, 0x02, 0x01, 0x02, // extraData
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // clockInfo
+		0, 0, 0, 0, 0, 0, 0, 0, // firmwareVersion
+		0x00, 0x03, 0x00, 0x0B, 0x03, // name
+		0x00, 0x00, // qualifiedName
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *tpmsAttest
+		wantErr bool
+	}{
+		{"ok", valid, &tpmsAttest{ExtraData: []byte{1, 2}, Name: []byte{0x00, 0x0B, 0x03}}, false},
+		{"fail empty", []byte{}, nil, true},
+		{"fail type", append([]byte{0xff, 0x54, 0x43, 0x47, 0x80, 0x18}, valid[6:]...), nil, true},
+		{"fail short", valid[:20], nil, true},
+		{"fail size", append(append([]byte{}, valid[:8]...), 0xff, 0xff), nil, true},
+		{"fail trailing", append(append([]byte{}, valid...), 0x00), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTPMSAttest(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTPMSAttest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func TestParsePermanentIdentifiers(t *testing.T) {
+	ca := newAttestationCA(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	parse := func(der []byte) *x509.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		assert.FatalError(t, err)
+		return cert
+	}
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		want    []string
+		wantErr bool
+	}{
+		{"ok", parse(ca.sign(t, &x509.Certificate{ExtraExtensions: []pkix.Extension{marshalPermanentIdentifierSAN(t, "EK-1234")}}, key.Public())), []string{"EK-1234"}, false},
+		{"ok dns", parse(ca.sign(t, &x509.Certificate{DNSNames: []string{"tpm.internal"}}, key.Public())), nil, false},
+		{"ok none", parse(ca.sign(t, &x509.Certificate{}, key.Public())), nil, false},
+		{"fail san", &x509.Certificate{Extensions: []pkix.Extension{{Id: oidSubjectAltName, Value: []byte{0x04, 0x00}}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePermanentIdentifiers(tt.cert)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePermanentIdentifiers() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
@@ -19,61 +20,109 @@ import (
 
 // Interface is the acme authority interface.
 type Interface interface {
+	AuthorizeAdmin(ctx context.Context, cert *x509.Certificate, token string, p provisioner.Interface) (string, error)
+	AuditAdminRequest(requester, method, path string, status int)
 	DeactivateAccount(provisioner.Interface, string) (*Account, error)
+	DeleteExternalAccountKey(provisioner.Interface, string) error
 	FinalizeOrder(provisioner.Interface, string, string, *x509.CertificateRequest) (*Order, error)
 	GetAccount(provisioner.Interface, string) (*Account, error)
 	GetAccountByKey(provisioner.Interface, *jose.JSONWebKey) (*Account, error)
 	GetAuthz(provisioner.Interface, string, string) (*Authz, error)
 	GetCertificate(string, string) ([]byte, error)
 	GetDirectory(provisioner.Interface) *Directory
+	GetExternalAccountKeys(provisioner.Interface) ([]*ExternalAccountKey, error)
 	GetLink(Link, string, bool, ...string) string
 	GetOrder(provisioner.Interface, string, string) (*Order, error)
 	GetOrdersByAccount(provisioner.Interface, string) ([]string, error)
+	GetRenewalInfo(provisioner.Interface, string) (*RenewalInfo, error)
 	LoadProvisionerByID(string) (provisioner.Interface, error)
 	NewAccount(provisioner.Interface, AccountOptions) (*Account, error)
+	NewExternalAccountKey(provisioner.Interface, string) (*ExternalAccountKey, error)
 	NewNonce() (string, error)
 	NewOrder(provisioner.Interface, OrderOptions) (*Order, error)
+	TriggerEarlyRenewal(provisioner.Interface, EarlyRenewalOptions) ([]string, error)
 	UpdateAccount(provisioner.Interface, string, []string) (*Account, error)
 	UseNonce(string) error
-	ValidateChallenge(provisioner.Interface, string, string, *jose.JSONWebKey) (*Challenge, error)
+	ValidateChallenge(provisioner.Interface, string, string, *jose.JSONWebKey, []byte) (*Challenge, error)
+}
+
+// PermanentIdentifierPolicy maps a permanent identifier, validated using the
+// device-attest-01 challenge, to the names that the certificate request must
+// contain. The default policy requires the permanent identifier itself as the
+// common name of the certificate request.
+type PermanentIdentifierPolicy func(p provisioner.Interface, identifier string) ([]string, error)
+
+// AuthorityOption is the type of options passed to NewAuthority.
+type AuthorityOption func(*Authority)
+
+// WithPermanentIdentifierPolicy sets the policy used to map the permanent
+// identifiers to the names in the certificate.
+func WithPermanentIdentifierPolicy(fn PermanentIdentifierPolicy) AuthorityOption {
+	return func(a *Authority) {
+		a.permanentIdentifierPolicy = fn
+	}
+}
+
+// OrderFailedFunc is called when an order becomes invalid, because one of its
+// authorizations failed or because it expired.
+type OrderFailedFunc func(p provisioner.Interface, accountID, orderID string, identifiers []Identifier, reason string)
+
+// WithOrderFailedFunc sets the function called when an order becomes invalid.
+func WithOrderFailedFunc(fn OrderFailedFunc) AuthorityOption {
+	return func(a *Authority) {
+		a.orderFailed = fn
+	}
 }
 
 // Authority is the layer that handles all ACME interactions.
 type Authority struct {
-	db       nosql.DB
-	dir      *directory
-	signAuth SignAuthority
+	db                        nosql.DB
+	dir                       *directory
+	signAuth                  SignAuthority
+	permanentIdentifierPolicy PermanentIdentifierPolicy
+	orderFailed               OrderFailedFunc
 }
 
 var (
-	accountTable           = []byte("acme_accounts")
-	accountByKeyIDTable    = []byte("acme_keyID_accountID_index")
-	authzTable             = []byte("acme_authzs")
-	challengeTable         = []byte("acme_challenges")
-	nonceTable             = []byte("nonces")
-	orderTable             = []byte("acme_orders")
-	ordersByAccountIDTable = []byte("acme_account_orders_index")
-	certTable              = []byte("acme_certs")
+	accountTable            = []byte("acme_accounts")
+	accountByKeyIDTable     = []byte("acme_keyID_accountID_index")
+	authzTable              = []byte("acme_authzs")
+	challengeTable          = []byte("acme_challenges")
+	nonceTable              = []byte("nonces")
+	orderTable              = []byte("acme_orders")
+	ordersByAccountIDTable  = []byte("acme_account_orders_index")
+	certTable               = []byte("acme_certs")
+	externalAccountKeyTable = []byte("acme_external_account_keys")
+	renewalInfoTable        = []byte("acme_renewal_info")
+	rateLimitTable          = []byte("acme_rate_limits")
 )
 
+// Tables returns the tables used by the ACME authority.
+func Tables() [][]byte {
+	return [][]byte{accountTable, accountByKeyIDTable, authzTable,
+		challengeTable, nonceTable, orderTable, ordersByAccountIDTable,
+		certTable, externalAccountKeyTable, renewalInfoTable, rateLimitTable}
+}
+
 // NewAuthority returns a new Authority that implements the ACME interface.
-func NewAuthority(db nosql.DB, dns, prefix string, signAuth SignAuthority) (*Authority, error) {
+func NewAuthority(db nosql.DB, dns, prefix string, signAuth SignAuthority, opts ...AuthorityOption) (*Authority, error) {
 	if _, ok := db.(*database.SimpleDB); !ok {
 		// If it's not a SimpleDB then go ahead and bootstrap the DB with the
 		// necessary ACME tables. SimpleDB should ONLY be used for testing.
-		tables := [][]byte{accountTable, accountByKeyIDTable, authzTable,
-			challengeTable, nonceTable, orderTable, ordersByAccountIDTable,
-			certTable}
-		for _, b := range tables {
+		for _, b := range Tables() {
 			if err := db.CreateTable(b); err != nil {
 				return nil, errors.Wrapf(err, "error creating table %s",
 					string(b))
 			}
 		}
 	}
-	return &Authority{
+	a := &Authority{
 		db: db, dir: newDirectory(dns, prefix), signAuth: signAuth,
-	}, nil
+	}
+	for _, fn := range opts {
+		fn(a)
+	}
+	return a, nil
 }
 
 // GetLink returns the requested link from the directory.
@@ -84,13 +133,20 @@ func (a *Authority) GetLink(typ Link, provID string, abs bool, inputs ...string)
 // GetDirectory returns the ACME directory object.
 func (a *Authority) GetDirectory(p provisioner.Interface) *Directory {
 	name := url.PathEscape(p.GetName())
-	return &Directory{
-		NewNonce:   a.dir.getLink(NewNonceLink, name, true),
-		NewAccount: a.dir.getLink(NewAccountLink, name, true),
-		NewOrder:   a.dir.getLink(NewOrderLink, name, true),
-		RevokeCert: a.dir.getLink(RevokeCertLink, name, true),
-		KeyChange:  a.dir.getLink(KeyChangeLink, name, true),
+	d := &Directory{
+		NewNonce:    a.dir.getLink(NewNonceLink, name, true),
+		NewAccount:  a.dir.getLink(NewAccountLink, name, true),
+		NewOrder:    a.dir.getLink(NewOrderLink, name, true),
+		RevokeCert:  a.dir.getLink(RevokeCertLink, name, true),
+		KeyChange:   a.dir.getLink(KeyChangeLink, name, true),
+		RenewalInfo: a.dir.getLink(RenewalInfoLink, name, true),
+	}
+	if isExternalAccountBindingRequired(p) {
+		d.Meta = &DirectoryMeta{
+			ExternalAccountRequired: true,
+		}
 	}
+	return d
 }
 
 // LoadProvisionerByID calls out to the SignAuthority interface to load a
@@ -113,12 +169,39 @@ func (a *Authority) UseNonce(nonce string) error {
 	return useNonce(a.db, nonce)
 }
 
-// NewAccount creates, stores, and returns a new ACME account.
+// NewAccount creates, stores, and returns a new ACME account. If the options
+// contain an External Account Binding, the account is bound to the external
+// account key used to sign it.
 func (a *Authority) NewAccount(p provisioner.Interface, ao AccountOptions) (*Account, error) {
+	var eak *externalAccountKey
+	switch {
+	case len(ao.ExternalAccountBinding) > 0:
+		var err error
+		if eak, err = validateExternalAccountBinding(a.db, a.dir, p, ao.Key, ao.ExternalAccountBinding); err != nil {
+			return nil, err
+		}
+		ao.externalAccount = &provisioner.ACMEExternalAccount{
+			KeyID:     eak.ID,
+			Reference: eak.Reference,
+		}
+	case isExternalAccountBindingRequired(p):
+		return nil, ExternalAccountRequiredErr(errors.Errorf("provisioner %s requires "+
+			"an external account binding", p.GetName()))
+	}
+
 	acc, err := newAccount(a.db, ao)
 	if err != nil {
 		return nil, err
 	}
+	if eak != nil {
+		// The key can only be bound once, if another account has been bound
+		// concurrently the new account is removed so its key can be used
+		// again.
+		if _, err := eak.bind(a.db, acc.ID); err != nil {
+			acc.remove(a.db)
+			return nil, Wrap(err, "error binding external account key")
+		}
+	}
 	return acc.toACME(a.db, a.dir, p)
 }
 
@@ -155,6 +238,115 @@ func (a *Authority) DeactivateAccount(p provisioner.Interface, id string) (*Acco
 	return acc.toACME(a.db, a.dir, p)
 }
 
+// AuthorizeAdmin returns the subject of the administrator that uses the given
+// client certificate or OIDC token. The administrator must be able to manage
+// the given provisioner.
+func (a *Authority) AuthorizeAdmin(ctx context.Context, cert *x509.Certificate, token string, p provisioner.Interface) (string, error) {
+	if auth, ok := a.signAuth.(interface {
+		AuthorizeACMEAdmin(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (string, error)
+	}); ok {
+		return auth.AuthorizeACMEAdmin(ctx, cert, token, p.GetName())
+	}
+	return "", UnauthorizedErr(errors.New("admin api is not supported"))
+}
+
+// AuditAdminRequest records a request of the admin API in the audit log of the
+// CA if it's supported.
+func (a *Authority) AuditAdminRequest(requester, method, path string, status int) {
+	if auth, ok := a.signAuth.(interface {
+		AuditAdminRequest(requester, method, path string, status int)
+	}); ok {
+		auth.AuditAdminRequest(requester, method, path, status)
+	}
+}
+
+// NewExternalAccountKey creates and stores a new external account key for the
+// given provisioner. The returned key is the only one that includes the HMAC
+// key.
+func (a *Authority) NewExternalAccountKey(p provisioner.Interface, reference string) (*ExternalAccountKey, error) {
+	k, err := newExternalAccountKey(a.db, p, reference)
+	if err != nil {
+		return nil, err
+	}
+	return k.toACME(true), nil
+}
+
+// GetExternalAccountKeys returns the external account keys of the given
+// provisioner.
+func (a *Authority) GetExternalAccountKeys(p provisioner.Interface) ([]*ExternalAccountKey, error) {
+	keys, err := getExternalAccountKeysByProvisioner(a.db, p)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*ExternalAccountKey, len(keys))
+	for i, k := range keys {
+		ret[i] = k.toACME(false)
+	}
+	return ret, nil
+}
+
+// DeleteExternalAccountKey deletes the external account key with the given id.
+// Accounts already bound to the key are not modified.
+func (a *Authority) DeleteExternalAccountKey(p provisioner.Interface, id string) error {
+	k, err := getExternalAccountKey(a.db, id)
+	if err != nil {
+		return err
+	}
+	if k.Provisioner != p.GetID() {
+		return MalformedErr(errors.Errorf("external account key %s not found", id))
+	}
+	if err := a.db.Del(externalAccountKeyTable, []byte(id)); err != nil {
+		return ServerInternalErr(errors.Wrapf(err, "error deleting external account key %s", id))
+	}
+	return nil
+}
+
+// GetRenewalInfo returns the suggested renewal window of the certificate with
+// the given renewalInfo identifier.
+func (a *Authority) GetRenewalInfo(p provisioner.Interface, id string) (*RenewalInfo, error) {
+	ri, err := getRenewalInfo(a.db, id)
+	if err != nil {
+		return nil, err
+	}
+	if ri.Provisioner != p.GetID() {
+		return nil, MalformedErr(errors.Errorf("renewal info for %s not found", id))
+	}
+	return ri.toACME(getRenewalInfoOptions(p)), nil
+}
+
+// TriggerEarlyRenewal marks the given certificates for early renewal. The
+// renewalInfo resource of these certificates will suggest an immediate
+// renewal. It returns the identifiers of the certificates signaled.
+func (a *Authority) TriggerEarlyRenewal(p provisioner.Interface, opts EarlyRenewalOptions) ([]string, error) {
+	var ris []*renewalInfo
+	if opts.All {
+		var err error
+		if ris, err = getRenewalInfosByProvisioner(a.db, p); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, id := range opts.IDs {
+			ri, err := getRenewalInfo(a.db, id)
+			if err != nil {
+				return nil, err
+			}
+			if ri.Provisioner != p.GetID() {
+				return nil, MalformedErr(errors.Errorf("renewal info for %s not found", id))
+			}
+			ris = append(ris, ri)
+		}
+	}
+
+	ids := make([]string, len(ris))
+	for i, ri := range ris {
+		if _, err := ri.signal(a.db, opts.ExplanationURL); err != nil {
+			return nil, err
+		}
+		ids[i] = ri.ID
+	}
+	return ids, nil
+}
+
 func keyToID(jwk *jose.JSONWebKey) (string, error) {
 	kid, err := jwk.Thumbprint(crypto.SHA256)
 	if err != nil {
@@ -185,12 +377,30 @@ func (a *Authority) GetOrder(p provisioner.Interface, accID, orderID string) (*O
 	if accID != o.AccountID {
 		return nil, UnauthorizedErr(errors.New("account does not own order"))
 	}
-	if o, err = o.updateStatus(a.db); err != nil {
+	if o, err = a.updateOrderStatus(p, o); err != nil {
 		return nil, err
 	}
 	return o.toACME(a.db, a.dir, p)
 }
 
+// updateOrderStatus updates the status of the order and reports it if the
+// order becomes invalid.
+func (a *Authority) updateOrderStatus(p provisioner.Interface, o *order) (*order, error) {
+	status := o.Status
+	o, err := o.updateStatus(a.db)
+	if err != nil {
+		return nil, err
+	}
+	if a.orderFailed != nil && status != StatusInvalid && o.Status == StatusInvalid {
+		reason := "authorization failed"
+		if o.Error != nil {
+			reason = o.Error.Error()
+		}
+		a.orderFailed(p, o.AccountID, o.ID, o.Identifiers, reason)
+	}
+	return o, nil
+}
+
 // GetOrdersByAccount returns the list of order urls owned by the account.
 func (a *Authority) GetOrdersByAccount(p provisioner.Interface, id string) ([]string, error) {
 	oids, err := getOrderIDsByAccount(a.db, id)
@@ -201,7 +411,11 @@ func (a *Authority) GetOrdersByAccount(p provisioner.Interface, id string) ([]st
 	var ret = []string{}
 	for _, oid := range oids {
 		o, err := getOrder(a.db, oid)
-		if err != nil {
+		switch {
+		case nosql.IsErrNotFound(err):
+			// Orders can expire from databases with a time to live.
+			continue
+		case err != nil:
 			return nil, ServerInternalErr(err)
 		}
 		if o.Status == StatusInvalid {
@@ -214,6 +428,25 @@ func (a *Authority) GetOrdersByAccount(p provisioner.Interface, id string) ([]st
 
 // NewOrder generates, stores, and returns a new ACME order.
 func (a *Authority) NewOrder(p provisioner.Interface, ops OrderOptions) (*Order, error) {
+	for _, id := range ops.Identifiers {
+		switch id.Type {
+		case "permanent-identifier":
+			if _, ok := getAttestationProvisioner(p); !ok {
+				return nil, UnsupportedIdentifierErr(errors.Errorf("identifier type %s is not "+
+					"enabled for provisioner %s", id.Type, p.GetName()))
+			}
+		case "ip":
+			if err := validateIPIdentifier(p, id.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if limits := getRateLimits(p); limits != nil {
+		if err := takeOrderRateLimit(a.db, limits, ops.AccountID); err != nil {
+			return nil, err
+		}
+		ops.rateLimits = limits
+	}
 	order, err := newOrder(a.db, ops)
 	if err != nil {
 		return nil, Wrap(err, "error creating order")
@@ -230,7 +463,12 @@ func (a *Authority) FinalizeOrder(p provisioner.Interface, accID, orderID string
 	if accID != o.AccountID {
 		return nil, UnauthorizedErr(errors.New("account does not own order"))
 	}
-	o, err = o.finalize(a.db, csr, a.signAuth, p)
+	if a.orderFailed != nil {
+		if o, err = a.updateOrderStatus(p, o); err != nil {
+			return nil, Wrap(err, "error finalizing order")
+		}
+	}
+	o, err = o.finalize(a.db, csr, a.signAuth, p, a.permanentIdentifierPolicy)
 	if err != nil {
 		return nil, Wrap(err, "error finalizing order")
 	}
@@ -254,8 +492,10 @@ func (a *Authority) GetAuthz(p provisioner.Interface, accID, authzID string) (*A
 	return az.toACME(a.db, a.dir, p)
 }
 
-// ValidateChallenge attempts to validate the challenge.
-func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID string, jwk *jose.JSONWebKey) (*Challenge, error) {
+// ValidateChallenge attempts to validate the challenge. The payload is the
+// body of the request, used by the challenges that require data from the
+// client, like device-attest-01.
+func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID string, jwk *jose.JSONWebKey, payload []byte) (*Challenge, error) {
 	ch, err := getChallenge(a.db, chID)
 	if err != nil {
 		return nil, err
@@ -269,12 +509,15 @@ func (a *Authority) ValidateChallenge(p provisioner.Interface, accID, chID strin
 	dialer := &net.Dialer{
 		Timeout: 30 * time.Second,
 	}
+	attProv, _ := getAttestationProvisioner(p)
 	ch, err = ch.validate(a.db, jwk, validateOptions{
 		httpGet:   client.Get,
-		lookupTxt: net.LookupTXT,
+		lookupTxt: newLookupTxt(p),
 		tlsDial: func(network, addr string, config *tls.Config) (*tls.Conn, error) {
 			return tls.DialWithDialer(dialer, network, addr, config)
 		},
+		payload:     payload,
+		attestation: attProv,
 	})
 	if err != nil {
 		return nil, Wrap(err, "error attempting challenge validation")
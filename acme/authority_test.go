@@ -1,6 +1,10 @@
 package acme
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
 	"testing"
@@ -8,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql/database"
@@ -80,6 +85,13 @@ func TestAuthorityGetDirectory(t *testing.T) {
 	//assert.Equals(t, acmeDir.NewOrder, "httsp://ca.smallstep.com/acme/new-authz")
 	assert.Equals(t, acmeDir.RevokeCert, fmt.Sprintf("https://ca.smallstep.com/acme/%s/revoke-cert", URLSafeProvisionerName(prov)))
 	assert.Equals(t, acmeDir.KeyChange, fmt.Sprintf("https://ca.smallstep.com/acme/%s/key-change", URLSafeProvisionerName(prov)))
+	assert.Equals(t, acmeDir.RenewalInfo, fmt.Sprintf("https://ca.smallstep.com/acme/%s/renewal-info", URLSafeProvisionerName(prov)))
+	assert.Nil(t, acmeDir.Meta)
+
+	eabProv := newProv()
+	eabProv.(*provisioner.ACME).RequireEAB = true
+	acmeDir = auth.GetDirectory(eabProv)
+	assert.Equals(t, acmeDir.Meta, &DirectoryMeta{ExternalAccountRequired: true})
 }
 
 func TestAuthorityNewNonce(t *testing.T) {
@@ -193,8 +205,13 @@ func TestAuthorityNewAccount(t *testing.T) {
 		Key: jwk, Contact: []string{"foo", "bar"},
 	}
 	prov := newProv()
+	eabProv := newProv()
+	eabProv.(*provisioner.ACME).RequireEAB = true
+	eabURL := newDirectory("ca.smallstep.com", "acme").getLink(NewAccountLink, URLSafeProvisionerName(eabProv), true)
+	eak := newEAK(eabProv)
 	type test struct {
 		auth *Authority
+		prov provisioner.Interface
 		ops  AccountOptions
 		err  *Error
 		acc  **Account
@@ -209,10 +226,77 @@ func TestAuthorityNewAccount(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				auth: auth,
+				prov: prov,
 				ops:  ops,
 				err:  ServerInternalErr(errors.New("error setting key-id to account-id index: force")),
 			}
 		},
+		"fail/eab-required": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				prov: eabProv,
+				ops:  ops,
+				err:  ExternalAccountRequiredErr(errors.Errorf("provisioner %s requires an external account binding", eabProv.GetName())),
+			}
+		},
+		"fail/eab-invalid": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			_ops := ops
+			_ops.ExternalAccountBinding = signEAB(t, eak, jose.HS256, eabURL, jwk, nil)
+			return test{
+				auth: auth,
+				prov: eabProv,
+				ops:  _ops,
+				err:  UnauthorizedErr(errors.New("external account key eakID not found")),
+			}
+		},
+		"fail/eab-bind-error": func(t *testing.T) test {
+			var accID string
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(eak)
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					switch string(bucket) {
+					case string(externalAccountKeyTable):
+						return nil, false, nil
+					case string(accountTable):
+						assert.Nil(t, old)
+						accID = string(key)
+					}
+					return nil, true, nil
+				},
+				MDel: func(bucket, key []byte) error {
+					switch string(bucket) {
+					case string(accountByKeyIDTable):
+						kid, err := keyToID(jwk)
+						assert.FatalError(t, err)
+						assert.Equals(t, string(key), kid)
+					case string(accountTable):
+						assert.Equals(t, string(key), accID)
+					default:
+						t.Errorf("unexpected bucket %s", bucket)
+					}
+					return nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			_ops := ops
+			_ops.ExternalAccountBinding = signEAB(t, eak, jose.HS256, eabURL, jwk, nil)
+			return test{
+				auth: auth,
+				prov: eabProv,
+				ops:  _ops,
+				err:  ServerInternalErr(errors.New("error binding external account key: error storing external account key; value has changed since last read")),
+			}
+		},
 		"ok": func(t *testing.T) test {
 			var (
 				_acmeacc = &Account{}
@@ -235,15 +319,52 @@ func TestAuthorityNewAccount(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				auth: auth,
+				prov: prov,
 				ops:  ops,
 				acc:  acmeacc,
 			}
 		},
+		"ok/eab": func(t *testing.T) test {
+			var (
+				_acmeacc = &Account{}
+				acmeacc  = &_acmeacc
+				dir      = newDirectory("ca.smallstep.com", "acme")
+			)
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, externalAccountKeyTable)
+					return json.Marshal(eak)
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					switch string(bucket) {
+					case string(accountTable):
+						var acc *account
+						assert.FatalError(t, json.Unmarshal(newval, &acc))
+						assert.Equals(t, acc.ExternalAccount, &provisioner.ACMEExternalAccount{KeyID: eak.ID, Reference: eak.Reference})
+						*acmeacc, err = acc.toACME(nil, dir, eabProv)
+					case string(externalAccountKeyTable):
+						var k *externalAccountKey
+						assert.FatalError(t, json.Unmarshal(newval, &k))
+						assert.Equals(t, k.AccountID, (*acmeacc).ID)
+					}
+					return nil, true, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			_ops := ops
+			_ops.ExternalAccountBinding = signEAB(t, eak, jose.HS256, eabURL, jwk, nil)
+			return test{
+				auth: auth,
+				prov: eabProv,
+				ops:  _ops,
+				acc:  acmeacc,
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeAcc, err := tc.auth.NewAccount(prov, tc.ops); err != nil {
+			if acmeAcc, err := tc.auth.NewAccount(tc.prov, tc.ops); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -560,6 +681,49 @@ func TestAuthorityGetOrder(t *testing.T) {
 	}
 }
 
+func TestAuthorityGetOrder_orderFailed(t *testing.T) {
+	prov := newProv()
+	o, err := newO()
+	assert.FatalError(t, err)
+	o.Expires = time.Now().UTC().Add(-time.Minute)
+	b, err := json.Marshal(o)
+	assert.FatalError(t, err)
+
+	var calls int
+	auth, err := NewAuthority(&db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			assert.Equals(t, bucket, orderTable)
+			return b, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			assert.Equals(t, bucket, orderTable)
+			b = newval
+			return newval, true, nil
+		},
+	}, "ca.smallstep.com", "acme", nil, WithOrderFailedFunc(func(p provisioner.Interface, accID, orderID string, identifiers []Identifier, reason string) {
+		calls++
+		assert.Equals(t, prov, p)
+		assert.Equals(t, o.AccountID, accID)
+		assert.Equals(t, o.ID, orderID)
+		assert.Equals(t, o.Identifiers, identifiers)
+		assert.Equals(t, "order has expired", reason)
+	}))
+	assert.FatalError(t, err)
+
+	acmeO, err := auth.GetOrder(prov, o.AccountID, o.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, StatusInvalid, acmeO.Status)
+	assert.Equals(t, 1, calls)
+
+	// Invalid orders are not reported again.
+	o.Status = StatusInvalid
+	b, err = json.Marshal(o)
+	assert.FatalError(t, err)
+	_, err = auth.GetOrder(prov, o.AccountID, o.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, calls)
+}
+
 func TestAuthorityGetCertificate(t *testing.T) {
 	type test struct {
 		auth      *Authority
@@ -850,8 +1014,14 @@ func TestAuthorityGetAuthz(t *testing.T) {
 
 func TestAuthorityNewOrder(t *testing.T) {
 	prov := newProv()
+	newRateLimitedProv := func(limits *provisioner.ACMERateLimits) provisioner.Interface {
+		p := newProv()
+		p.(*provisioner.ACME).RateLimits = limits
+		return p
+	}
 	type test struct {
 		auth *Authority
+		p    provisioner.Interface
 		ops  OrderOptions
 		err  *Error
 		o    **Order
@@ -870,6 +1040,126 @@ func TestAuthorityNewOrder(t *testing.T) {
 				err:  ServerInternalErr(errors.New("error creating order: error creating http challenge: error saving acme challenge: force")),
 			}
 		},
+		"fail/permanent-identifier-not-enabled": func(t *testing.T) test {
+			auth, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			ops := defaultOrderOps()
+			ops.Identifiers = append(ops.Identifiers, Identifier{Type: "permanent-identifier", Value: "SERIAL"})
+			return test{
+				auth: auth,
+				ops:  ops,
+				err:  UnsupportedIdentifierErr(errors.Errorf("identifier type permanent-identifier is not enabled for provisioner %s", prov.GetName())),
+			}
+		},
+		"fail/ip-invalid": func(t *testing.T) test {
+			auth, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			ops := defaultOrderOps()
+			ops.Identifiers = append(ops.Identifiers, Identifier{Type: "ip", Value: "10.0.0"})
+			return test{
+				auth: auth,
+				ops:  ops,
+				err:  MalformedErr(errors.New("ip identifier 10.0.0 is not a valid ip address")),
+			}
+		},
+		"fail/ip-not-allowed": func(t *testing.T) test {
+			auth, err := NewAuthority(new(db.MockNoSQLDB), "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			p := &provisioner.ACME{Type: "ACME", Name: "test@acme-provisioner.com", AllowedIPRanges: []string{"10.0.0.0/8"}}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims}))
+			ops := defaultOrderOps()
+			ops.Identifiers = append(ops.Identifiers, Identifier{Type: "ip", Value: "192.168.0.1"})
+			return test{
+				auth: auth,
+				p:    p,
+				ops:  ops,
+				err:  RejectedIdentifierErr(errors.Errorf("ip 192.168.0.1 is not allowed by provisioner %s", p.GetName())),
+			}
+		},
+		"fail/orders-rate-limited": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, rateLimitTable)
+					return json.Marshal(&rateLimit{Key: string(key), Events: []time.Time{clock.Now()}})
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    newRateLimitedProv(&provisioner.ACMERateLimits{OrdersPerAccountPerHour: 1}),
+				ops:  defaultOrderOps(),
+				err:  RateLimitedErr(errors.New("account accID cannot create more than 1 orders per hour")),
+			}
+		},
+		"fail/pending-authzs-rate-limited": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					if bytes.Equal(bucket, rateLimitTable) || bytes.Equal(bucket, orderTable) {
+						t.Errorf("unexpected write to %s", bucket)
+					}
+					return nil, true, nil
+				},
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    newRateLimitedProv(&provisioner.ACMERateLimits{PendingAuthorizationsPerAccount: 1}),
+				ops:  defaultOrderOps(),
+				err:  RateLimitedErr(errors.New("error creating order: account accID cannot have more than 1 pending authorizations")),
+			}
+		},
+		"ok/rate-limits": func(t *testing.T) test {
+			var (
+				_acmeO = &Order{}
+				acmeO  = &_acmeO
+				authzs []string
+				dir    = newDirectory("ca.smallstep.com", "acme")
+				p      = newRateLimitedProv(&provisioner.ACMERateLimits{
+					OrdersPerAccountPerHour:         10,
+					PendingAuthorizationsPerAccount: 10,
+				})
+			)
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					switch {
+					case bytes.Equal(bucket, rateLimitTable):
+						var rl rateLimit
+						assert.FatalError(t, json.Unmarshal(newval, &rl))
+						switch string(key) {
+						case ordersRateLimitKey("accID"):
+							assert.Equals(t, len(rl.Events), 1)
+						case pendingAuthzsRateLimitKey("accID"):
+							assert.Equals(t, rl.IDs, authzs)
+						default:
+							t.Fatalf("unexpected rate limit key %s", key)
+						}
+					case bytes.Equal(bucket, authzTable):
+						authzs = append(authzs, string(key))
+					case bytes.Equal(bucket, orderTable):
+						var o order
+						assert.FatalError(t, json.Unmarshal(newval, &o))
+						assert.Equals(t, o.Authorizations, authzs)
+						var err error
+						*acmeO, err = o.toACME(nil, dir, p)
+						assert.FatalError(t, err)
+					}
+					return nil, true, nil
+				},
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    p,
+				ops:  defaultOrderOps(),
+				o:    acmeO,
+			}
+		},
 		"ok": func(t *testing.T) test {
 			var (
 				_acmeO = &Order{}
@@ -928,7 +1218,10 @@ func TestAuthorityNewOrder(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeO, err := tc.auth.NewOrder(prov, tc.ops); err != nil {
+			if tc.p == nil {
+				tc.p = prov
+			}
+			if acmeO, err := tc.auth.NewOrder(tc.p, tc.ops); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1006,6 +1299,48 @@ func TestAuthorityGetOrdersByAccount(t *testing.T) {
 				err:  ServerInternalErr(errors.New("error loading order foo: force")),
 			}
 		},
+		"ok/order-not-found": func(t *testing.T) test {
+			var (
+				id    = "zap"
+				count = 0
+				err   error
+			)
+			foo, err := newO()
+			assert.FatalError(t, err)
+
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					var ret []byte
+					switch count {
+					case 0:
+						assert.Equals(t, bucket, ordersByAccountIDTable)
+						assert.Equals(t, key, []byte(id))
+						ret, err = json.Marshal([]string{"expired", foo.ID})
+						assert.FatalError(t, err)
+					case 1:
+						assert.Equals(t, bucket, orderTable)
+						assert.Equals(t, key, []byte("expired"))
+						count++
+						return nil, database.ErrNotFound
+					case 2:
+						assert.Equals(t, bucket, orderTable)
+						assert.Equals(t, key, []byte(foo.ID))
+						ret, err = json.Marshal(foo)
+						assert.FatalError(t, err)
+					}
+					count++
+					return ret, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   id,
+				res: []string{
+					fmt.Sprintf("https://ca.smallstep.com/acme/%s/order/%s", URLSafeProvisionerName(prov), foo.ID),
+				},
+			}
+		},
 		"ok": func(t *testing.T) test {
 			var (
 				id    = "zap"
@@ -1297,7 +1632,7 @@ func TestAuthorityValidateChallenge(t *testing.T) {
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := run(t)
-			if acmeCh, err := tc.auth.ValidateChallenge(prov, tc.accID, tc.id, nil); err != nil {
+			if acmeCh, err := tc.auth.ValidateChallenge(prov, tc.accID, tc.id, nil, nil); err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
 					assert.True(t, ok)
@@ -1531,3 +1866,396 @@ func TestAuthorityDeactivateAccount(t *testing.T) {
 		})
 	}
 }
+
+type mockAdminSignAuth struct {
+	mockSignAuth
+	authorizeAdmin    func(context.Context, *x509.Certificate, string, string) (string, error)
+	auditAdminRequest func(requester, method, path string, status int)
+}
+
+func (m *mockAdminSignAuth) AuthorizeACMEAdmin(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (string, error) {
+	return m.authorizeAdmin(ctx, cert, token, provisionerName)
+}
+
+func (m *mockAdminSignAuth) AuditAdminRequest(requester, method, path string, status int) {
+	m.auditAdminRequest(requester, method, path, status)
+}
+
+func TestAuthorityAuthorizeAdmin(t *testing.T) {
+	prov := newProv()
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}
+
+	auth, err := NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", &mockSignAuth{})
+	assert.FatalError(t, err)
+	_, err = auth.AuthorizeAdmin(context.Background(), cert, "", prov)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, err.(*Error).Type, unauthorizedErr)
+	}
+	// Without support the requests are not audited.
+	auth.AuditAdminRequest("admin", "GET", "/acme/admin/eab-keys", 200)
+
+	auth, err = NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", &mockAdminSignAuth{
+		authorizeAdmin: func(ctx context.Context, c *x509.Certificate, token, name string) (string, error) {
+			assert.Equals(t, c, cert)
+			assert.Equals(t, "", token)
+			assert.Equals(t, prov.GetName(), name)
+			return "", errors.New("force")
+		},
+	})
+	assert.FatalError(t, err)
+	_, err = auth.AuthorizeAdmin(context.Background(), cert, "", prov)
+	if assert.NotNil(t, err) {
+		assert.Equals(t, err.Error(), "force")
+	}
+
+	var audited bool
+	auth, err = NewAuthority(&db.MockNoSQLDB{}, "ca.smallstep.com", "acme", &mockAdminSignAuth{
+		authorizeAdmin: func(ctx context.Context, c *x509.Certificate, token, name string) (string, error) {
+			assert.Nil(t, c)
+			assert.Equals(t, "token", token)
+			return "admin", nil
+		},
+		auditAdminRequest: func(requester, method, path string, status int) {
+			assert.Equals(t, "admin", requester)
+			assert.Equals(t, "GET", method)
+			assert.Equals(t, "/acme/admin/eab-keys", path)
+			assert.Equals(t, 200, status)
+			audited = true
+		},
+	})
+	assert.FatalError(t, err)
+	subject, err := auth.AuthorizeAdmin(context.Background(), nil, "token", prov)
+	assert.FatalError(t, err)
+	assert.Equals(t, "admin", subject)
+	auth.AuditAdminRequest("admin", "GET", "/acme/admin/eab-keys", 200)
+	assert.True(t, audited)
+}
+
+func TestAuthorityDeleteExternalAccountKey(t *testing.T) {
+	prov := newProv()
+	eak := newEAK(prov)
+	type test struct {
+		auth *Authority
+		id   string
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   "foo",
+				err:  MalformedErr(errors.New("external account key foo not found")),
+			}
+		},
+		"fail/other-provisioner": func(t *testing.T) test {
+			other := newEAK(prov)
+			other.Provisioner = "acme/other"
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(other)
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   other.ID,
+				err:  MalformedErr(errors.New("external account key eakID not found")),
+			}
+		},
+		"fail/delete-error": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(eak)
+				},
+				MDel: func(bucket, key []byte) error {
+					return errors.New("force")
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   eak.ID,
+				err:  ServerInternalErr(errors.New("error deleting external account key eakID: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, externalAccountKeyTable)
+					return json.Marshal(eak)
+				},
+				MDel: func(bucket, key []byte) error {
+					assert.Equals(t, bucket, externalAccountKeyTable)
+					assert.Equals(t, key, []byte(eak.ID))
+					return nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				id:   eak.ID,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if err := tc.auth.DeleteExternalAccountKey(prov, tc.id); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthorityGetRenewalInfo(t *testing.T) {
+	prov := newProv()
+	ri := newRI(prov)
+	type test struct {
+		auth *Authority
+		p    provisioner.Interface
+		res  *RenewalInfo
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    prov,
+				err:  MalformedErr(errors.New("renewal info for aki.serial not found")),
+			}
+		},
+		"fail/other-provisioner": func(t *testing.T) test {
+			other := newRI(prov)
+			other.Provisioner = "acme/other"
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(other)
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    prov,
+				err:  MalformedErr(errors.New("renewal info for aki.serial not found")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, renewalInfoTable)
+					assert.Equals(t, key, []byte(ri.ID))
+					return json.Marshal(ri)
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    prov,
+				res: &RenewalInfo{
+					SuggestedWindow: RenewalWindow{Start: ri.NotAfter.Add(-2 * time.Hour), End: ri.NotAfter.Add(-time.Hour)},
+				},
+			}
+		},
+		"ok/maintenance": func(t *testing.T) test {
+			p := newProv()
+			p.(*provisioner.ACME).RenewalInfo = &provisioner.ACMERenewalInfoOptions{
+				ExplanationURL: "https://status.smallstep.com/maintenance",
+				Maintenance: []provisioner.ACMEMaintenanceWindow{
+					{Start: ri.NotAfter.Add(-90 * time.Minute), End: ri.NotAfter.Add(time.Hour)},
+				},
+			}
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(ri)
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				p:    p,
+				res: &RenewalInfo{
+					SuggestedWindow: RenewalWindow{Start: ri.NotAfter.Add(-150 * time.Minute), End: ri.NotAfter.Add(-90 * time.Minute)},
+					ExplanationURL:  "https://status.smallstep.com/maintenance",
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			res, err := tc.auth.GetRenewalInfo(tc.p, ri.ID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, res, tc.res)
+			}
+		})
+	}
+}
+
+func TestAuthorityTriggerEarlyRenewal(t *testing.T) {
+	prov := newProv()
+	ri := newRI(prov)
+	type test struct {
+		auth *Authority
+		opts EarlyRenewalOptions
+		res  []string
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				opts: EarlyRenewalOptions{IDs: []string{"foo"}},
+				err:  MalformedErr(errors.New("renewal info for foo not found")),
+			}
+		},
+		"fail/other-provisioner": func(t *testing.T) test {
+			other := newRI(prov)
+			other.Provisioner = "acme/other"
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(other)
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					t.Fatal("renewal info should not be modified")
+					return nil, false, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				opts: EarlyRenewalOptions{IDs: []string{other.ID}},
+				err:  MalformedErr(errors.New("renewal info for aki.serial not found")),
+			}
+		},
+		"fail/list-error": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return nil, errors.New("force")
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				opts: EarlyRenewalOptions{All: true},
+				err:  ServerInternalErr(errors.New("error listing renewal info: force")),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return json.Marshal(ri)
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				opts: EarlyRenewalOptions{IDs: []string{ri.ID}},
+				err:  ServerInternalErr(errors.New("error storing renewal info: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, renewalInfoTable)
+					assert.Equals(t, key, []byte(ri.ID))
+					return json.Marshal(ri)
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, bucket, renewalInfoTable)
+					var signaled renewalInfo
+					assert.FatalError(t, json.Unmarshal(newval, &signaled))
+					if assert.NotNil(t, signaled.Signal) {
+						assert.Equals(t, signaled.Signal.ExplanationURL, "https://status.smallstep.com/incident")
+					}
+					return nil, true, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				opts: EarlyRenewalOptions{IDs: []string{ri.ID}, ExplanationURL: "https://status.smallstep.com/incident"},
+				res:  []string{ri.ID},
+			}
+		},
+		"ok/all": func(t *testing.T) test {
+			other := newRI(prov)
+			other.ID = "other"
+			other.Provisioner = "acme/other"
+			var entries []*database.Entry
+			for _, r := range []*renewalInfo{ri, other} {
+				b, err := json.Marshal(r)
+				assert.FatalError(t, err)
+				entries = append(entries, &database.Entry{Bucket: renewalInfoTable, Key: []byte(r.ID), Value: b})
+			}
+			auth, err := NewAuthority(&db.MockNoSQLDB{
+				MList: func(bucket []byte) ([]*database.Entry, error) {
+					return entries, nil
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, key, []byte(ri.ID))
+					return nil, true, nil
+				},
+			}, "ca.smallstep.com", "acme", nil)
+			assert.FatalError(t, err)
+			return test{
+				auth: auth,
+				opts: EarlyRenewalOptions{All: true},
+				res:  []string{ri.ID},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			res, err := tc.auth.TriggerEarlyRenewal(prov, tc.opts)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, res, tc.res)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package acme
 
 import (
 	"encoding/json"
+	"net"
 	"strings"
 	"time"
 
@@ -183,7 +184,7 @@ func (ba *baseAuthz) save(db nosql.DB, old authz) error {
 	case err != nil:
 		return ServerInternalErr(errors.Wrapf(err, "error storing authz"))
 	case !swapped:
-		return ServerInternalErr(errors.Errorf("error storing authz; " +
+		return ServerInternalErr(changedError("error storing authz; " +
 			"value has changed since last read"))
 	default:
 		return nil
@@ -196,12 +197,30 @@ func (ba *baseAuthz) clone() *baseAuthz {
 }
 
 func (ba *baseAuthz) parent() authz {
+	if ba.Identifier.Type == "permanent-identifier" {
+		return &permanentIdentifierAuthz{ba}
+	}
 	return &dnsAuthz{ba}
 }
 
 // updateStatus attempts to update the status on a baseAuthz and stores the
-// updating object if necessary.
+// updating object if necessary. If the authz has been modified concurrently,
+// it's read again and the update is retried.
 func (ba *baseAuthz) updateStatus(db nosql.DB) (authz, error) {
+	az, err := ba.tryUpdateStatus(db)
+	for i := 0; i < maxStoreRetries && isChangedErr(err); i++ {
+		var cur authz
+		if cur, err = getAuthz(db, ba.ID); err != nil {
+			return ba, err
+		}
+		az, err = cur.clone().tryUpdateStatus(db)
+	}
+	return az, err
+}
+
+// tryUpdateStatus updates the authz status if necessary, it fails if the authz
+// has changed since it was read.
+func (ba *baseAuthz) tryUpdateStatus(db nosql.DB) (authz, error) {
 	newAuthz := ba.clone()
 
 	now := time.Now().UTC()
@@ -261,6 +280,18 @@ func unmarshalAuthz(data []byte) (authz, error) {
 			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into dnsAuthz"))
 		}
 		return &dnsAuthz{&ba}, nil
+	case "permanent-identifier":
+		var ba baseAuthz
+		if err := json.Unmarshal(data, &ba); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into permanentIdentifierAuthz"))
+		}
+		return &permanentIdentifierAuthz{&ba}, nil
+	case "ip":
+		var ba baseAuthz
+		if err := json.Unmarshal(data, &ba); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling authz type into ipAuthz"))
+		}
+		return &ipAuthz{&ba}, nil
 	default:
 		return nil, ServerInternalErr(errors.Errorf("unexpected authz type %s",
 			getType.Identifier.Type))
@@ -278,6 +309,10 @@ func newAuthz(db nosql.DB, accID string, identifier Identifier) (a authz, err er
 	switch identifier.Type {
 	case "dns":
 		a, err = newDNSAuthz(db, accID, identifier)
+	case "permanent-identifier":
+		a, err = newPermanentIdentifierAuthz(db, accID, identifier)
+	case "ip":
+		a, err = newIPAuthz(db, accID, identifier)
 	default:
 		err = MalformedErr(errors.Errorf("unexpected authz type %s",
 			identifier.Type))
@@ -331,6 +366,107 @@ func newDNSAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error
 	return da, nil
 }
 
+// permanentIdentifierAuthz represents a permanent-identifier acme
+// authorization, a device identifier validated using an attestation
+// statement.
+type permanentIdentifierAuthz struct {
+	*baseAuthz
+}
+
+// newPermanentIdentifierAuthz returns a new permanent-identifier acme
+// authorization object.
+func newPermanentIdentifierAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error) {
+	ba, err := newBaseAuthz(accID, identifier)
+	if err != nil {
+		return nil, err
+	}
+	// Wildcards do not apply to device identifiers.
+	ba.Wildcard = false
+	ba.Identifier = identifier
+
+	ch, err := newDeviceAttest01Challenge(db, ChallengeOptions{
+		AccountID:  accID,
+		AuthzID:    ba.ID,
+		Identifier: identifier,
+	})
+	if err != nil {
+		return nil, Wrap(err, "error creating device-attest challenge")
+	}
+	ba.Challenges = []string{ch.getID()}
+
+	pa := &permanentIdentifierAuthz{ba}
+	if err := pa.save(db, nil); err != nil {
+		return nil, err
+	}
+	return pa, nil
+}
+
+// ipAuthz represents an ip acme authorization (RFC 8738).
+type ipAuthz struct {
+	*baseAuthz
+}
+
+// newIPAuthz returns a new ip acme authorization object. IP addresses are
+// validated using the http-01 and tls-alpn-01 challenges.
+func newIPAuthz(db nosql.DB, accID string, identifier Identifier) (authz, error) {
+	ba, err := newBaseAuthz(accID, identifier)
+	if err != nil {
+		return nil, err
+	}
+	// Wildcards do not apply to ip addresses.
+	ba.Wildcard = false
+	ba.Identifier = identifier
+
+	ch1, err := newHTTP01Challenge(db, ChallengeOptions{
+		AccountID:  accID,
+		AuthzID:    ba.ID,
+		Identifier: identifier,
+	})
+	if err != nil {
+		return nil, Wrap(err, "error creating http challenge")
+	}
+	ch2, err := newTLSALPN01Challenge(db, ChallengeOptions{
+		AccountID:  accID,
+		AuthzID:    ba.ID,
+		Identifier: identifier,
+	})
+	if err != nil {
+		return nil, Wrap(err, "error creating alpn challenge")
+	}
+	ba.Challenges = []string{ch1.getID(), ch2.getID()}
+
+	ia := &ipAuthz{ba}
+	if err := ia.save(db, nil); err != nil {
+		return nil, err
+	}
+	return ia, nil
+}
+
+// ipProvisioner is the interface implemented by the provisioners that can
+// restrict the IP addresses allowed in the ip identifiers.
+type ipProvisioner interface {
+	IsIPAllowed(ip net.IP) bool
+}
+
+// validateIPIdentifier returns an error if the value of an ip identifier is
+// not a valid IP address or if it is not allowed by the provisioner.
+func validateIPIdentifier(p provisioner.Interface, value string) error {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return MalformedErr(errors.Errorf("ip identifier %s is not a valid ip address", value))
+	}
+	ipp, ok := p.(ipProvisioner)
+	if !ok {
+		return UnsupportedIdentifierErr(errors.Errorf("identifier type ip is not "+
+			"enabled for provisioner %s", p.GetName()))
+	}
+	if !ipp.IsIPAllowed(ip) {
+		return RejectedIdentifierErr(errors.Errorf("ip %s is not allowed by provisioner %s",
+			value, p.GetName()))
+	}
+	return nil
+}
+
 // getAuthz retrieves and unmarshals an ACME authz type from the database.
 func getAuthz(db nosql.DB, id string) (authz, error) {
 	b, err := db.Get(authzTable, []byte(id))
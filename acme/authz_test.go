@@ -296,6 +296,99 @@ func TestNewAuthz(t *testing.T) {
 				resChs: chs,
 			}
 		},
+		"fail/new-device-attest-chall-error": func(t *testing.T) test {
+			return test{
+				iden: Identifier{Type: "permanent-identifier", Value: "SERIAL"},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error creating device-attest challenge: error saving acme challenge: force")),
+			}
+		},
+		"ok/permanent-identifier": func(t *testing.T) test {
+			chs := &([]string{})
+			count := 0
+			_iden := Identifier{Type: "permanent-identifier", Value: "SERIAL"}
+			return test{
+				iden: _iden,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							assert.Equals(t, bucket, challengeTable)
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "device-attest-01")
+							assert.Equals(t, ch.getValue(), "SERIAL")
+						case 1:
+							assert.Equals(t, bucket, authzTable)
+							assert.Equals(t, old, nil)
+
+							az, err := unmarshalAuthz(newval)
+							assert.FatalError(t, err)
+							_, ok := az.(*permanentIdentifierAuthz)
+							assert.Fatal(t, ok)
+
+							assert.Equals(t, az.getID(), string(key))
+							assert.Equals(t, az.getIdentifier(), _iden)
+							assert.Equals(t, az.getWildcard(), false)
+
+							*chs = az.getChallenges()
+							assert.Len(t, 1, *chs)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+				resChs: chs,
+			}
+		},
+		"ok/ip": func(t *testing.T) test {
+			chs := &([]string{})
+			count := 0
+			_iden := Identifier{Type: "ip", Value: "10.0.0.1"}
+			return test{
+				iden: _iden,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch count {
+						case 0:
+							assert.Equals(t, bucket, challengeTable)
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "http-01")
+							assert.Equals(t, ch.getValue(), "10.0.0.1")
+						case 1:
+							assert.Equals(t, bucket, challengeTable)
+							ch, err := unmarshalChallenge(newval)
+							assert.FatalError(t, err)
+							assert.Equals(t, ch.getType(), "tls-alpn-01")
+							assert.Equals(t, ch.getValue(), "10.0.0.1")
+						case 2:
+							assert.Equals(t, bucket, authzTable)
+							assert.Equals(t, old, nil)
+
+							az, err := unmarshalAuthz(newval)
+							assert.FatalError(t, err)
+							_, ok := az.(*ipAuthz)
+							assert.Fatal(t, ok)
+
+							assert.Equals(t, az.getID(), string(key))
+							assert.Equals(t, az.getIdentifier(), _iden)
+							assert.Equals(t, az.getWildcard(), false)
+
+							*chs = az.getChallenges()
+							assert.Len(t, 2, *chs)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+				resChs: chs,
+			}
+		},
 	}
 	for name, run := range tests {
 		tc := run(t)
@@ -312,7 +405,7 @@ func TestNewAuthz(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, az.getAccountID(), accID)
-					assert.Equals(t, az.getType(), "dns")
+					assert.Equals(t, az.getType(), tc.iden.Type)
 					assert.Equals(t, az.getStatus(), StatusPending)
 
 					assert.True(t, az.getCreated().Before(time.Now().UTC().Add(time.Minute)))
@@ -584,6 +677,36 @@ func TestAuthzUnmarshal(t *testing.T) {
 				azb: b,
 			}
 		},
+		"ok/permanent-identifier": func(t *testing.T) test {
+			mockdb := &db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("foo"), true, nil
+				},
+			}
+			az, err := newAuthz(mockdb, "1234", Identifier{Type: "permanent-identifier", Value: "SERIAL"})
+			assert.FatalError(t, err)
+			b, err := json.Marshal(az)
+			assert.FatalError(t, err)
+			return test{
+				az:  az,
+				azb: b,
+			}
+		},
+		"ok/ip": func(t *testing.T) test {
+			mockdb := &db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("foo"), true, nil
+				},
+			}
+			az, err := newAuthz(mockdb, "1234", Identifier{Type: "ip", Value: "fd00::1"})
+			assert.FatalError(t, err)
+			b, err := json.Marshal(az)
+			assert.FatalError(t, err)
+			return test{
+				az:  az,
+				azb: b,
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -689,6 +812,33 @@ func TestAuthzUpdateStatus(t *testing.T) {
 				},
 			}
 		},
+		"ok/changed-concurrently": func(t *testing.T) test {
+			az, err := newAz()
+			assert.FatalError(t, err)
+			_az, ok := az.(*dnsAuthz)
+			assert.Fatal(t, ok)
+			_az.baseAuthz.Expires = time.Now().UTC().Add(-time.Minute)
+
+			// The authz has been invalidated by another request.
+			stored := az.clone()
+			stored.Status = StatusInvalid
+			b, err := json.Marshal(stored)
+			assert.FatalError(t, err)
+			return test{
+				az:  az,
+				res: stored.parent(),
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, authzTable)
+						assert.Equals(t, key, []byte(az.getID()))
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return b, false, nil
+					},
+				},
+			}
+		},
 		"fail/get-challenge-error": func(t *testing.T) test {
 			az, err := newAz()
 			assert.FatalError(t, err)
@@ -0,0 +1,182 @@
+package acme
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// cborMaxDepth is the maximum nesting level of arrays and maps supported by
+// the CBOR decoder.
+const cborMaxDepth = 16
+
+// cborUnmarshal decodes a CBOR (RFC 7049) encoded item. It only supports the
+// subset of CBOR used in WebAuthn like attestation objects: integers are
+// returned as int64, byte strings as []byte, text strings as string, arrays as
+// []interface{} and maps as map[interface{}]interface{}. Tags are ignored and
+// indefinite-length items are not supported.
+func cborUnmarshal(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, errors.New("error decoding cbor: unexpected trailing data")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, errors.New("error decoding cbor: unexpected end of data")
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// head reads the initial byte and the argument of a data item.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		if b, err = d.next(1); err == nil {
+			arg = uint64(b[0])
+		}
+	case info == 25:
+		if b, err = d.next(2); err == nil {
+			arg = uint64(binary.BigEndian.Uint16(b))
+		}
+	case info == 26:
+		if b, err = d.next(4); err == nil {
+			arg = uint64(binary.BigEndian.Uint32(b))
+		}
+	case info == 27:
+		if b, err = d.next(8); err == nil {
+			arg = binary.BigEndian.Uint64(b)
+		}
+	default:
+		err = errors.Errorf("error decoding cbor: unsupported additional information %d", info)
+	}
+	return
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("error decoding cbor: maximum nesting level exceeded")
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0: // unsigned integer
+		if arg > math.MaxInt64 {
+			return nil, errors.New("error decoding cbor: integer overflow")
+		}
+		return int64(arg), nil
+	case 1: // negative integer
+		if arg > math.MaxInt64 {
+			return nil, errors.New("error decoding cbor: integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case 2: // byte string
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 3: // text string
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4: // array
+		// Every item takes at least one byte.
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errors.New("error decoding cbor: unexpected end of data")
+		}
+		arr := make([]interface{}, arg)
+		for i := range arr {
+			if arr[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case 5: // map
+		if arg > uint64(len(d.data)-d.off)/2 {
+			return nil, errors.New("error decoding cbor: unexpected end of data")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errors.Errorf("error decoding cbor: unsupported map key type %T", k)
+			}
+			if _, ok := m[k]; ok {
+				return nil, errors.Errorf("error decoding cbor: duplicated map key %v", k)
+			}
+			if m[k], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6: // tag
+		return d.decode(depth + 1)
+	default: // simple values and floats
+		switch {
+		case info == 20:
+			return false, nil
+		case info == 21:
+			return true, nil
+		case info == 22, info == 23:
+			return nil, nil
+		case info == 25:
+			return float64(halfToFloat32(uint16(arg))), nil
+		case info == 26:
+			return float64(math.Float32frombits(uint32(arg))), nil
+		case info == 27:
+			return math.Float64frombits(arg), nil
+		default:
+			return nil, errors.Errorf("error decoding cbor: unsupported simple value %d", arg)
+		}
+	}
+}
+
+// halfToFloat32 converts an IEEE 754 half-precision number to a float32.
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	default:
+		return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+	}
+}
@@ -0,0 +1,131 @@
+package acme
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// cborMarshal encodes the given value using CBOR. It only supports the types
+// required to build attestation objects in tests.
+func cborMarshal(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= math.MaxUint8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= math.MaxUint16:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		case n <= math.MaxUint32:
+			b := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(n))
+			return b
+		default:
+			b := []byte{major<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint64(b[1:], n)
+			return b
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		return cborMarshal(int64(v))
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []interface{}:
+		b := head(4, uint64(len(v)))
+		for _, vv := range v {
+			b = append(b, cborMarshal(vv)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := head(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, cborMarshal(k)...)
+			b = append(b, cborMarshal(v[k])...)
+		}
+		return b
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	default:
+		panic(fmt.Sprintf("unsupported type %T", v))
+	}
+}
+
+func TestCborUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok uint", []byte{0x17}, int64(23), false},
+		{"ok uint8", []byte{0x18, 0x18}, int64(24), false},
+		{"ok uint16", []byte{0x19, 0x03, 0xe8}, int64(1000), false},
+		{"ok uint32", []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}, int64(1000000), false},
+		{"ok uint64", []byte{0x1b, 0x00, 0x00, 0x00, 0xe8, 0xd4, 0xa5, 0x10, 0x00}, int64(1000000000000), false},
+		{"ok negative", []byte{0x26}, int64(-7), false},
+		{"ok negative16", []byte{0x39, 0x01, 0x00}, int64(-257), false},
+		{"ok bytes", []byte{0x44, 0x01, 0x02, 0x03, 0x04}, []byte{1, 2, 3, 4}, false},
+		{"ok string", []byte{0x64, 0x49, 0x45, 0x54, 0x46}, "IETF", false},
+		{"ok array", []byte{0x83, 0x01, 0x02, 0x03}, []interface{}{int64(1), int64(2), int64(3)}, false},
+		{"ok map", []byte{0xa2, 0x61, 0x61, 0x01, 0x20, 0x61, 0x62}, map[interface{}]interface{}{"a": int64(1), int64(-1): "b"}, false},
+		{"ok tag", []byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, int64(1363896240), false},
+		{"ok false", []byte{0xf4}, false, false},
+		{"ok true", []byte{0xf5}, true, false},
+		{"ok null", []byte{0xf6}, nil, false},
+		{"ok half", []byte{0xf9, 0x3c, 0x00}, float64(1), false},
+		{"ok float", []byte{0xfa, 0x47, 0xc3, 0x50, 0x00}, float64(100000), false},
+		{"ok double", []byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, float64(1.1), false},
+		{"ok marshal", cborMarshal(map[string]interface{}{"fmt": "tpm", "attStmt": map[string]interface{}{"alg": -257, "x5c": []interface{}{[]byte("foo")}}}),
+			map[interface{}]interface{}{"fmt": "tpm", "attStmt": map[interface{}]interface{}{"alg": int64(-257), "x5c": []interface{}{[]byte("foo")}}}, false},
+		{"fail empty", []byte{}, nil, true},
+		{"fail trailing", []byte{0x01, 0x02}, nil, true},
+		{"fail short", []byte{0x19, 0x03}, nil, true},
+		{"fail short bytes", []byte{0x44, 0x01, 0x02}, nil, true},
+		{"fail short array", []byte{0x83, 0x01, 0x02}, nil, true},
+		{"fail large array", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{"fail large map", []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{"fail indefinite", []byte{0x5f, 0x41, 0x01, 0xff}, nil, true},
+		{"fail overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{"fail negative overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{"fail map key", []byte{0xa1, 0x41, 0x01, 0x01}, nil, true},
+		{"fail duplicated key", []byte{0xa2, 0x01, 0x01, 0x01, 0x02}, nil, true},
+		{"fail simple", []byte{0xf0}, nil, true},
+		{"fail depth", []byte{0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0x01}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cborUnmarshal(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cborUnmarshal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cborUnmarshal() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
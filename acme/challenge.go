@@ -59,9 +59,11 @@ type lookupTxt func(string) ([]string, error)
 type tlsDialer func(network, addr string, config *tls.Config) (*tls.Conn, error)
 
 type validateOptions struct {
-	httpGet   httpGetter
-	lookupTxt lookupTxt
-	tlsDial   tlsDialer
+	httpGet     httpGetter
+	lookupTxt   lookupTxt
+	tlsDial     tlsDialer
+	payload     []byte
+	attestation attestationProvisioner
 }
 
 // challenge is the interface ACME challenege types must implement.
@@ -272,6 +274,13 @@ func unmarshalChallenge(data []byte) (challenge, error) {
 				"challenge type into tlsALPN01Challenge"))
 		}
 		return &tlsALPN01Challenge{&bc}, nil
+	case "device-attest-01":
+		var bc baseChallenge
+		if err := json.Unmarshal(data, &bc); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling "+
+				"challenge type into deviceAttest01Challenge"))
+		}
+		return &deviceAttest01Challenge{&bc}, nil
 	default:
 		return nil, ServerInternalErr(errors.Errorf("unexpected challenge type %s", getType.Type))
 	}
@@ -306,7 +315,11 @@ func (hc *http01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo valida
 	if hc.getStatus() == StatusValid || hc.getStatus() == StatusInvalid {
 		return hc, nil
 	}
-	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", hc.Value, hc.Token)
+	host := hc.Value
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, hc.Token)
 
 	resp, err := vo.httpGet(url)
 	if err != nil {
@@ -384,9 +397,17 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 		return tc, nil
 	}
 
+	// IP addresses use the reverse DNS name as the server name (RFC 8738,
+	// section 6).
+	ip := net.ParseIP(tc.Value)
+	serverName := tc.Value
+	if ip != nil {
+		serverName = reverseAddr(ip)
+	}
+
 	config := &tls.Config{
 		NextProtos:         []string{"acme-tls/1"},
-		ServerName:         tc.Value,
+		ServerName:         serverName,
 		InsecureSkipVerify: true, // we expect a self-signed challenge certificate
 	}
 
@@ -394,6 +415,16 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 
 	conn, err := vo.tlsDial("tcp", hostPort, config)
 	if err != nil {
+		// Servers that do not support acme-tls/1 might abort the handshake
+		// with a no_application_protocol alert (RFC 7301, section 3.2).
+		if isNoApplicationProtocolErr(err) {
+			if err = tc.storeError(db,
+				RejectedIdentifierErr(errors.Errorf("cannot negotiate ALPN acme-tls/1 protocol for "+
+					"tls-alpn-01 challenge"))); err != nil {
+				return nil, err
+			}
+			return tc, nil
+		}
 		if err = tc.storeError(db,
 			ConnectionErr(errors.Wrapf(err, "error doing TLS dial for %s", hostPort))); err != nil {
 			return nil, err
@@ -425,7 +456,16 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 
 	leafCert := certs[0]
 
-	if len(leafCert.DNSNames) != 1 || !strings.EqualFold(leafCert.DNSNames[0], tc.Value) {
+	if ip != nil {
+		if len(leafCert.DNSNames) != 0 || len(leafCert.IPAddresses) != 1 || !leafCert.IPAddresses[0].Equal(ip) {
+			if err = tc.storeError(db,
+				RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
+					"leaf certificate must contain a single IP address, %v", tc.Value))); err != nil {
+				return nil, err
+			}
+			return tc, nil
+		}
+	} else if len(leafCert.DNSNames) != 1 || !strings.EqualFold(leafCert.DNSNames[0], tc.Value) {
 		if err = tc.storeError(db,
 			RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: "+
 				"leaf certificate must contain a single DNS name, %v", tc.Value))); err != nil {
@@ -510,6 +550,27 @@ func (tc *tlsALPN01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo val
 	return tc, nil
 }
 
+// isNoApplicationProtocolErr returns true if the error is the result of
+// receiving a no_application_protocol alert. The alert type is not exported
+// by crypto/tls, so the error message is used instead.
+func isNoApplicationProtocolErr(err error) bool {
+	return strings.HasSuffix(err.Error(), "tls: no application protocol")
+}
+
+// reverseAddr returns the in-addr.arpa or ip6.arpa reverse DNS name of the IP
+// address, without the trailing dot.
+func reverseAddr(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	b := make([]byte, 0, len(ip)*4+len("ip6.arpa"))
+	for i := len(ip) - 1; i >= 0; i-- {
+		b = append(b, hexDigits[ip[i]&0x0f], '.', hexDigits[ip[i]>>4], '.')
+	}
+	return string(append(b, "ip6.arpa"...))
+}
+
 // dns01Challenge represents an dns-01 acme challenge.
 type dns01Challenge struct {
 	*baseChallenge
@@ -601,6 +662,116 @@ func (dc *dns01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validat
 	return upd, nil
 }
 
+// deviceAttest01Challenge represents a device-attest-01 acme challenge.
+type deviceAttest01Challenge struct {
+	*baseChallenge
+}
+
+// newDeviceAttest01Challenge returns a new acme device-attest-01 challenge.
+func newDeviceAttest01Challenge(db nosql.DB, ops ChallengeOptions) (challenge, error) {
+	bc, err := newBaseChallenge(ops.AccountID, ops.AuthzID)
+	if err != nil {
+		return nil, err
+	}
+	bc.Type = "device-attest-01"
+	bc.Value = ops.Identifier.Value
+
+	dc := &deviceAttest01Challenge{bc}
+	if err := dc.save(db, nil); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// deviceAttest01Payload is the payload sent by the client to respond to a
+// device-attest-01 challenge.
+type deviceAttest01Payload struct {
+	AttObj string `json:"attObj"`
+}
+
+// validate attempts to validate the challenge using the attestation object
+// in the request payload. If the challenge has been satisfactorily validated,
+// the 'status' and 'validated' attributes are updated.
+func (dc *deviceAttest01Challenge) validate(db nosql.DB, jwk *jose.JSONWebKey, vo validateOptions) (challenge, error) {
+	// If already valid or invalid then return without performing validation.
+	if dc.getStatus() == StatusValid || dc.getStatus() == StatusInvalid {
+		return dc, nil
+	}
+
+	// An empty payload or POST-as-GET is just a request for the current
+	// status of the challenge.
+	var payload deviceAttest01Payload
+	if len(vo.payload) > 0 {
+		if err := json.Unmarshal(vo.payload, &payload); err != nil {
+			return nil, MalformedErr(errors.Wrap(err, "error unmarshaling device-attest-01 payload"))
+		}
+	}
+	if payload.AttObj == "" {
+		return dc, nil
+	}
+
+	if vo.attestation == nil {
+		if err := dc.storeError(db,
+			RejectedIdentifierErr(errors.New("device-attest-01 challenge is not enabled "+
+				"for this provisioner"))); err != nil {
+			return nil, err
+		}
+		return dc, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(payload.AttObj, "="))
+	if err != nil {
+		return nil, MalformedErr(errors.Wrap(err, "error decoding attObj"))
+	}
+	obj, err := parseAttestationObject(data)
+	if err != nil {
+		if err = dc.storeError(db,
+			BadAttestationStatementErr(errors.Wrap(err, "error parsing attObj"))); err != nil {
+			return nil, err
+		}
+		return dc, nil
+	}
+	if !vo.attestation.IsAttestationFormatEnabled(provisioner.ACMEAttestationFormat(obj.Format)) {
+		if err = dc.storeError(db,
+			BadAttestationStatementErr(errors.Errorf("attestation format %s is not enabled "+
+				"for this provisioner", obj.Format))); err != nil {
+			return nil, err
+		}
+		return dc, nil
+	}
+
+	keyAuth, err := KeyAuthorization(dc.Token, jwk)
+	if err != nil {
+		return nil, err
+	}
+	att, err := verifyAttestation(obj, keyAuth, vo.attestation.GetAttestationRoots())
+	if err != nil {
+		if err = dc.storeError(db, BadAttestationStatementErr(err)); err != nil {
+			return nil, err
+		}
+		return dc, nil
+	}
+	if !att.hasPermanentIdentifier(dc.Value) {
+		if err = dc.storeError(db,
+			RejectedIdentifierErr(errors.Errorf("permanent identifier does not match; "+
+				"expected %s, but got %s", dc.Value, att.PermanentIdentifiers))); err != nil {
+			return nil, err
+		}
+		return dc, nil
+	}
+
+	// Update and store the challenge.
+	upd := &deviceAttest01Challenge{dc.baseChallenge.clone()}
+	upd.Status = StatusValid
+	upd.Error = nil
+	upd.Validated = clock.Now()
+
+	if err := upd.save(db, dc); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
 // getChallenge retrieves and unmarshals an ACME challenge type from the database.
 func getChallenge(db nosql.DB, id string) (challenge, error) {
 	b, err := db.Get(challengeTable, []byte(id))
@@ -25,6 +25,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/cli/jose"
 	"github.com/smallstep/nosql"
@@ -67,6 +68,19 @@ func newHTTPCh() (challenge, error) {
 	return newHTTP01Challenge(mockdb, testOps)
 }
 
+func newDeviceAttestCh() (challenge, error) {
+	mockdb := &db.MockNoSQLDB{
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			return []byte("foo"), true, nil
+		},
+	}
+	return newDeviceAttest01Challenge(mockdb, ChallengeOptions{
+		AccountID:  "accID",
+		AuthzID:    "authzID",
+		Identifier: Identifier{Type: "permanent-identifier", Value: "SERIAL"},
+	})
+}
+
 func TestNewHTTP01Challenge(t *testing.T) {
 	ops := ChallengeOptions{
 		AccountID: "accID",
@@ -502,6 +516,16 @@ func TestChallengeUnmarshal(t *testing.T) {
 				chb: b,
 			}
 		},
+		"ok/device-attest": func(t *testing.T) test {
+			deviceAttestCh, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			b, err := json.Marshal(deviceAttestCh)
+			assert.FatalError(t, err)
+			return test{
+				ch:  deviceAttestCh,
+				chb: b,
+			}
+		},
 		"ok/err": func(t *testing.T) test {
 			httpCh, err := newHTTPCh()
 			assert.FatalError(t, err)
@@ -961,6 +985,52 @@ func TestHTTP01Validate(t *testing.T) {
 				},
 			}
 		},
+		"ok/ipv6": func(t *testing.T) test {
+			ch, err := newHTTP01Challenge(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("foo"), true, nil
+				},
+			}, ChallengeOptions{
+				AccountID:  "accID",
+				AuthzID:    "authzID",
+				Identifier: Identifier{Type: "ip", Value: "fd00::1"},
+			})
+			assert.FatalError(t, err)
+
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+
+			expKeyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+
+			baseClone := ch.clone()
+			baseClone.Status = StatusValid
+			baseClone.Error = nil
+			newCh := &http01Challenge{baseClone}
+
+			return test{
+				ch:  ch,
+				res: newCh,
+				vo: validateOptions{
+					httpGet: func(url string) (*http.Response, error) {
+						assert.Equals(t, url, "http://[fd00::1]/.well-known/acme-challenge/"+ch.getToken())
+						return &http.Response{
+							Body: ioutil.NopCloser(bytes.NewBufferString(expKeyAuth)),
+						}, nil
+					},
+				},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						httpCh, err := unmarshalChallenge(newval)
+						assert.FatalError(t, err)
+						assert.Equals(t, httpCh.getStatus(), StatusValid)
+						baseClone.Validated = httpCh.getValidated()
+						return nil, true, nil
+					},
+				},
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -1579,6 +1649,107 @@ func TestTLSALPN01Validate(t *testing.T) {
 				res: newCh,
 			}
 		},
+		"ok/ip-wrong-address": func(t *testing.T) test {
+			ch, err := newTLSALPN01Challenge(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("foo"), true, nil
+				},
+			}, ChallengeOptions{
+				AccountID:  "accID",
+				AuthzID:    "authzID",
+				Identifier: Identifier{Type: "ip", Value: "10.0.0.1"},
+			})
+			assert.FatalError(t, err)
+
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+
+			expKeyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+			expKeyAuthHash := sha256.Sum256([]byte(expKeyAuth))
+
+			cert, err := newTLSALPNValidationCert(expKeyAuthHash[:], false, true, "10.0.0.2")
+			assert.FatalError(t, err)
+
+			srv, tlsDial := newTestTLSALPNServer(cert)
+			srv.Start()
+
+			expErr := RejectedIdentifierErr(errors.Errorf("incorrect certificate for tls-alpn-01 challenge: " +
+				"leaf certificate must contain a single IP address, 10.0.0.1"))
+
+			return test{
+				srv: srv,
+				ch:  ch,
+				vo: validateOptions{
+					tlsDial: tlsDial,
+				},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						alpnCh, err := unmarshalChallenge(newval)
+						assert.FatalError(t, err)
+						assert.Equals(t, alpnCh.getStatus(), StatusPending)
+						assert.Equals(t, alpnCh.getError().Detail, expErr.ToACME().Detail)
+						return nil, true, nil
+					},
+				},
+				res: ch,
+			}
+		},
+		"ok/ip": func(t *testing.T) test {
+			ch, err := newTLSALPN01Challenge(&db.MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("foo"), true, nil
+				},
+			}, ChallengeOptions{
+				AccountID:  "accID",
+				AuthzID:    "authzID",
+				Identifier: Identifier{Type: "ip", Value: "fd00::1"},
+			})
+			assert.FatalError(t, err)
+
+			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+			assert.FatalError(t, err)
+
+			expKeyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+			assert.FatalError(t, err)
+			expKeyAuthHash := sha256.Sum256([]byte(expKeyAuth))
+
+			cert, err := newTLSALPNValidationCert(expKeyAuthHash[:], false, true, "fd00::1")
+			assert.FatalError(t, err)
+
+			srv, tlsDial := newTestTLSALPNServer(cert)
+			srv.Start()
+
+			baseClone := ch.clone()
+			baseClone.Status = StatusValid
+			baseClone.Error = nil
+			newCh := &tlsALPN01Challenge{baseClone}
+
+			return test{
+				srv: srv,
+				ch:  ch,
+				vo: validateOptions{
+					tlsDial: func(network, addr string, config *tls.Config) (conn *tls.Conn, err error) {
+						assert.Equals(t, addr, "[fd00::1]:443")
+						assert.Equals(t, config.ServerName,
+							"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa")
+						return tlsDial(network, addr, config)
+					},
+				},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						alpnCh, err := unmarshalChallenge(newval)
+						assert.FatalError(t, err)
+						assert.Equals(t, alpnCh.getStatus(), StatusValid)
+						baseClone.Validated = alpnCh.getValidated()
+						return nil, true, nil
+					},
+				},
+				res: newCh,
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -1645,6 +1816,19 @@ func newTestTLSALPNServer(validationCert *tls.Certificate) (*httptest.Server, tl
 	}
 }
 
+func TestReverseAddr(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1":    "1.0.0.10.in-addr.arpa",
+		"192.168.1.2": "2.1.168.192.in-addr.arpa",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	}
+	for ip, want := range tests {
+		t.Run(ip, func(t *testing.T) {
+			assert.Equals(t, want, reverseAddr(net.ParseIP(ip)))
+		})
+	}
+}
+
 // noopConn is a mock net.Conn that does nothing.
 type noopConn struct{}
 
@@ -1673,7 +1857,13 @@ func newTLSALPNValidationCert(keyAuthHash []byte, obsoleteOID, critical bool, na
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-		DNSNames:              names,
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			certTemplate.IPAddresses = append(certTemplate.IPAddresses, ip)
+		} else {
+			certTemplate.DNSNames = append(certTemplate.DNSNames, name)
+		}
 	}
 
 	if keyAuthHash != nil {
@@ -1970,3 +2160,256 @@ func TestDNS01Validate(t *testing.T) {
 		})
 	}
 }
+
+type mockAttestationProvisioner struct {
+	formats []provisioner.ACMEAttestationFormat
+	roots   *x509.CertPool
+}
+
+func (m *mockAttestationProvisioner) IsAttestationFormatEnabled(format provisioner.ACMEAttestationFormat) bool {
+	for _, f := range m.formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockAttestationProvisioner) GetAttestationRoots() *x509.CertPool {
+	return m.roots
+}
+
+func TestDeviceAttest01Validate(t *testing.T) {
+	ca := newAttestationCA(t)
+	attProv := &mockAttestationProvisioner{
+		formats: []provisioner.ACMEAttestationFormat{provisioner.AppleAttestationFormat},
+		roots:   ca.Pool,
+	}
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	newPayload := func(attObj []byte) []byte {
+		b, err := json.Marshal(deviceAttest01Payload{
+			AttObj: base64.RawURLEncoding.EncodeToString(attObj),
+		})
+		assert.FatalError(t, err)
+		return b
+	}
+	newAppleAttObj := func(ch challenge, serial string) []byte {
+		keyAuth, err := KeyAuthorization(ch.getToken(), jwk)
+		assert.FatalError(t, err)
+		sum := sha256.Sum256([]byte(keyAuth))
+		return newAppleAttestation(t, ca, sum[:], serial, "UDID")
+	}
+	// storeErrorDB returns a mock db that checks that the challenge is stored
+	// with the given error.
+	storeErrorDB := func(t *testing.T, ch challenge, expErr *Error) nosql.DB {
+		oldb, err := json.Marshal(ch)
+		assert.FatalError(t, err)
+		baseClone := ch.clone()
+		baseClone.Error = expErr.ToACME()
+		newb, err := json.Marshal(&deviceAttest01Challenge{baseClone})
+		assert.FatalError(t, err)
+		return &db.MockNoSQLDB{
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, bucket, challengeTable)
+				assert.Equals(t, key, []byte(ch.getID()))
+				assert.Equals(t, old, oldb)
+				assert.Equals(t, newval, newb)
+				return nil, true, nil
+			},
+		}
+	}
+
+	type test struct {
+		vo  validateOptions
+		ch  challenge
+		res challenge
+		jwk *jose.JSONWebKey
+		db  nosql.DB
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/status-already-valid": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			_ch, ok := ch.(*deviceAttest01Challenge)
+			assert.Fatal(t, ok)
+			_ch.baseChallenge.Status = StatusValid
+			return test{
+				ch:  ch,
+				res: ch,
+			}
+		},
+		"ok/status-already-invalid": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			_ch, ok := ch.(*deviceAttest01Challenge)
+			assert.Fatal(t, ok)
+			_ch.baseChallenge.Status = StatusInvalid
+			return test{
+				ch:  ch,
+				res: ch,
+			}
+		},
+		"ok/empty-payload": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: []byte("{}"), attestation: attProv},
+				res: ch,
+			}
+		},
+		"fail/payload": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: []byte("foo"), attestation: attProv},
+				err: MalformedErr(errors.New("error unmarshaling device-attest-01 payload")),
+			}
+		},
+		"fail/attObj-encoding": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: []byte(`{"attObj":"!!!"}`), attestation: attProv},
+				err: MalformedErr(errors.New("error decoding attObj")),
+			}
+		},
+		"ok/not-enabled": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			expErr := RejectedIdentifierErr(errors.New("device-attest-01 challenge is not enabled for this provisioner"))
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: newPayload(newAppleAttObj(ch, "SERIAL"))},
+				jwk: jwk,
+				db:  storeErrorDB(t, ch, expErr),
+				res: ch,
+			}
+		},
+		"ok/attObj-error": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			expErr := BadAttestationStatementErr(errors.New("error parsing attObj: attestation object is not a map"))
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: newPayload(cborMarshal("foo")), attestation: attProv},
+				jwk: jwk,
+				db:  storeErrorDB(t, ch, expErr),
+				res: ch,
+			}
+		},
+		"ok/format-not-enabled": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			expErr := BadAttestationStatementErr(errors.New("attestation format tpm is not enabled for this provisioner"))
+			attObj := cborMarshal(map[string]interface{}{"fmt": "tpm", "attStmt": map[string]interface{}{}})
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: newPayload(attObj), attestation: attProv},
+				jwk: jwk,
+				db:  storeErrorDB(t, ch, expErr),
+				res: ch,
+			}
+		},
+		"ok/verify-error": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			expErr := BadAttestationStatementErr(errors.New("apple attestation nonce does not match the key authorization"))
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: newPayload(newAppleAttestation(t, ca, []byte("foo"), "SERIAL", "UDID")), attestation: attProv},
+				jwk: jwk,
+				db:  storeErrorDB(t, ch, expErr),
+				res: ch,
+			}
+		},
+		"ok/identifier-mismatch": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			expErr := RejectedIdentifierErr(errors.Errorf("permanent identifier does not match; "+
+				"expected SERIAL, but got %s", []string{"OTHER", "UDID"}))
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: newPayload(newAppleAttObj(ch, "OTHER")), attestation: attProv},
+				jwk: jwk,
+				db:  storeErrorDB(t, ch, expErr),
+				res: ch,
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			return test{
+				ch:  ch,
+				vo:  validateOptions{payload: newPayload(newAppleAttObj(ch, "SERIAL")), attestation: attProv},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error saving acme challenge: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			ch, err := newDeviceAttestCh()
+			assert.FatalError(t, err)
+			oldb, err := json.Marshal(ch)
+			assert.FatalError(t, err)
+
+			baseClone := ch.clone()
+			baseClone.Status = StatusValid
+			baseClone.Error = nil
+			newCh := &deviceAttest01Challenge{baseClone}
+
+			return test{
+				ch:  ch,
+				res: newCh,
+				vo:  validateOptions{payload: newPayload(newAppleAttObj(ch, "SERIAL")), attestation: attProv},
+				jwk: jwk,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, challengeTable)
+						assert.Equals(t, key, []byte(ch.getID()))
+						assert.Equals(t, old, oldb)
+
+						daCh, err := unmarshalChallenge(newval)
+						assert.FatalError(t, err)
+						assert.Equals(t, daCh.getStatus(), StatusValid)
+						baseClone.Validated = daCh.getValidated()
+						return nil, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if ch, err := tc.ch.validate(tc.db, tc.jwk, tc.vo); err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else {
+				if assert.Nil(t, tc.err) {
+					assert.Equals(t, tc.res.getID(), ch.getID())
+					assert.Equals(t, tc.res.getAccountID(), ch.getAccountID())
+					assert.Equals(t, tc.res.getAuthzID(), ch.getAuthzID())
+					assert.Equals(t, tc.res.getStatus(), ch.getStatus())
+					assert.Equals(t, tc.res.getToken(), ch.getToken())
+					assert.Equals(t, tc.res.getCreated(), ch.getCreated())
+					assert.Equals(t, tc.res.getValidated(), ch.getValidated())
+					assert.Equals(t, tc.res.getError(), ch.getError())
+				}
+			}
+		})
+	}
+}
@@ -40,6 +40,36 @@ var (
 
 var idLen = 32
 
+// maxStoreRetries is the number of times a value is read and stored again
+// when it has been modified concurrently, by another request or by another
+// instance of the CA sharing the same database.
+const maxStoreRetries = 5
+
+// changedError is the cause of the errors returned when a value cannot be
+// stored because it has changed in the database since it was read.
+type changedError string
+
+func (e changedError) Error() string {
+	return string(e)
+}
+
+// isChangedErr returns true if the cause of the error is a changedError.
+func isChangedErr(err error) bool {
+	_, ok := errors.Cause(err).(changedError)
+	return ok
+}
+
+// retryOnChange calls fn until it returns an error that is not a changedError
+// or the number of retries is exhausted. The function must read again the
+// values it modifies.
+func retryOnChange(fn func() error) error {
+	err := fn()
+	for i := 0; i < maxStoreRetries && isChangedErr(err); i++ {
+		err = fn()
+	}
+	return err
+}
+
 func randID() (val string, err error) {
 	val, err = randutil.Alphanumeric(idLen)
 	if err != nil {
@@ -9,12 +9,19 @@ import (
 
 // Directory represents an ACME directory for configuring clients.
 type Directory struct {
-	NewNonce   string `json:"newNonce,omitempty"`
-	NewAccount string `json:"newAccount,omitempty"`
-	NewOrder   string `json:"newOrder,omitempty"`
-	NewAuthz   string `json:"newAuthz,omitempty"`
-	RevokeCert string `json:"revokeCert,omitempty"`
-	KeyChange  string `json:"keyChange,omitempty"`
+	NewNonce    string         `json:"newNonce,omitempty"`
+	NewAccount  string         `json:"newAccount,omitempty"`
+	NewOrder    string         `json:"newOrder,omitempty"`
+	NewAuthz    string         `json:"newAuthz,omitempty"`
+	RevokeCert  string         `json:"revokeCert,omitempty"`
+	KeyChange   string         `json:"keyChange,omitempty"`
+	RenewalInfo string         `json:"renewalInfo,omitempty"`
+	Meta        *DirectoryMeta `json:"meta,omitempty"`
+}
+
+// DirectoryMeta is the metadata included in the ACME directory.
+type DirectoryMeta struct {
+	ExternalAccountRequired bool `json:"externalAccountRequired,omitempty"`
 }
 
 // ToLog enables response logging for the Directory type.
@@ -67,6 +74,14 @@ const (
 	RevokeCertLink
 	// KeyChangeLink key rollover
 	KeyChangeLink
+	// ExternalAccountKeysLink list of external account keys (admin api)
+	ExternalAccountKeysLink
+	// ExternalAccountKeyLink external account key (admin api)
+	ExternalAccountKeyLink
+	// RenewalInfoLink renewal information (ARI)
+	RenewalInfoLink
+	// EarlyRenewalLink early renewal signals (admin api)
+	EarlyRenewalLink
 )
 
 func (l Link) String() string {
@@ -95,6 +110,12 @@ func (l Link) String() string {
 		return "revoke-cert"
 	case KeyChangeLink:
 		return "key-change"
+	case ExternalAccountKeysLink:
+		return "admin/eab-keys"
+	case RenewalInfoLink:
+		return "renewal-info"
+	case EarlyRenewalLink:
+		return "admin/renewal-info"
 	default:
 		return "unexpected"
 	}
@@ -112,6 +133,16 @@ func (d *directory) getLink(typ Link, provisionerName string, abs bool, inputs .
 		link = fmt.Sprintf("/%s/%s/%s/orders", provisionerName, AccountLink.String(), inputs[0])
 	case FinalizeLink:
 		link = fmt.Sprintf("/%s/%s/%s/finalize", provisionerName, OrderLink.String(), inputs[0])
+	case ExternalAccountKeysLink, EarlyRenewalLink:
+		link = fmt.Sprintf("/%s/%s", provisionerName, typ.String())
+	case ExternalAccountKeyLink:
+		link = fmt.Sprintf("/%s/%s/%s", provisionerName, ExternalAccountKeysLink.String(), inputs[0])
+	case RenewalInfoLink:
+		if len(inputs) == 0 {
+			link = fmt.Sprintf("/%s/%s", provisionerName, typ.String())
+		} else {
+			link = fmt.Sprintf("/%s/%s/%s", provisionerName, typ.String(), inputs[0])
+		}
 	}
 	if abs {
 		return fmt.Sprintf("https://%s/%s%s", d.dns, d.prefix, link)
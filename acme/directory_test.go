@@ -57,4 +57,16 @@ func TestDirectoryGetLink(t *testing.T) {
 
 	assert.Equals(t, dir.getLink(CertificateLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/certificate/1234", provID))
 	assert.Equals(t, dir.getLink(CertificateLink, provID, false, id), fmt.Sprintf("/%s/certificate/1234", provID))
+
+	assert.Equals(t, dir.getLink(ExternalAccountKeysLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/eab-keys", provID))
+	assert.Equals(t, dir.getLink(ExternalAccountKeysLink, provID, false), fmt.Sprintf("/%s/admin/eab-keys", provID))
+
+	assert.Equals(t, dir.getLink(ExternalAccountKeyLink, provID, true, id), fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/eab-keys/1234", provID))
+	assert.Equals(t, dir.getLink(ExternalAccountKeyLink, provID, false, id), fmt.Sprintf("/%s/admin/eab-keys/1234", provID))
+
+	assert.Equals(t, dir.getLink(RenewalInfoLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/renewal-info", provID))
+	assert.Equals(t, dir.getLink(RenewalInfoLink, provID, false, id), fmt.Sprintf("/%s/renewal-info/1234", provID))
+
+	assert.Equals(t, dir.getLink(EarlyRenewalLink, provID, true), fmt.Sprintf("https://ca.smallstep.com/acme/%s/admin/renewal-info", provID))
+	assert.Equals(t, dir.getLink(EarlyRenewalLink, provID, false), fmt.Sprintf("/%s/admin/renewal-info", provID))
 }
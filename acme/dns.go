@@ -0,0 +1,245 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsMaxCNAMEs is the maximum number of CNAME records followed when
+	// looking up a TXT record.
+	dnsMaxCNAMEs = 8
+	// dnsTimeout is the timeout of each DNS exchange.
+	dnsTimeout = 10 * time.Second
+	// dnsUDPSize is the UDP payload size advertised using EDNS(0).
+	dnsUDPSize = 4096
+	// dnsADBit is the AD (authenticated data) flag in the third and fourth
+	// bytes of the DNS header. It's not supported by dnsmessage.Header.
+	dnsADBit = 0x0020
+)
+
+// dnsProvisioner is the interface implemented by the provisioners that can
+// configure the lookups of the dns-01 challenge.
+type dnsProvisioner interface {
+	GetDNSOptions() *provisioner.ACMEDNSOptions
+}
+
+// dnsExchanger sends a DNS query to the given server and returns the response.
+type dnsExchanger func(network, server string, query []byte) ([]byte, error)
+
+// newLookupTxt returns the function used to look up TXT records in the dns-01
+// challenge. The system resolver is used if the provisioner does not define
+// any resolver.
+func newLookupTxt(p provisioner.Interface) lookupTxt {
+	dp, ok := p.(dnsProvisioner)
+	if !ok {
+		return net.LookupTXT
+	}
+	opts := dp.GetDNSOptions()
+	if opts == nil || len(opts.Resolvers) == 0 {
+		return net.LookupTXT
+	}
+	r := &dnsResolver{
+		servers:       make([]string, len(opts.Resolvers)),
+		requireDNSSEC: opts.RequireDNSSEC,
+		exchange:      dnsExchange,
+	}
+	for i, s := range opts.Resolvers {
+		r.servers[i] = dnsServerAddress(s)
+	}
+	return r.LookupTXT
+}
+
+// dnsServerAddress adds the default DNS port to the given address if it does
+// not have one.
+func dnsServerAddress(s string) string {
+	if net.ParseIP(s) != nil || !strings.Contains(s, ":") {
+		return net.JoinHostPort(s, "53")
+	}
+	return s
+}
+
+// dnsResolver is a stub resolver that sends the queries to the configured
+// recursive resolvers. Unlike the resolver in the net package, it can require
+// the responses to be authenticated using DNSSEC, and it follows the CNAME
+// records explicitly.
+type dnsResolver struct {
+	servers       []string
+	requireDNSSEC bool
+	exchange      dnsExchanger
+}
+
+// LookupTXT returns the TXT records of the given name, following the CNAME
+// records if necessary. The strings of each record are concatenated.
+func (r *dnsResolver) LookupTXT(name string) ([]string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+	var followed int
+	for {
+		msg, err := r.query(name, dnsmessage.TypeTXT)
+		if err != nil {
+			return nil, err
+		}
+		if msg.RCode == dnsmessage.RCodeNameError {
+			return nil, errors.Errorf("lookup %s: no such host", name)
+		}
+
+		// Resolvers usually include the CNAME chain in the answer, but the
+		// records of the last target might not be there.
+		target, txts := name, map[string][]string{}
+		cnames := map[string]string{}
+		for _, a := range msg.Answers {
+			owner := strings.ToLower(a.Header.Name.String())
+			switch rr := a.Body.(type) {
+			case *dnsmessage.CNAMEResource:
+				cnames[owner] = strings.ToLower(rr.CNAME.String())
+			case *dnsmessage.TXTResource:
+				txts[owner] = append(txts[owner], strings.Join(rr.TXT, ""))
+			}
+		}
+		for cnames[target] != "" {
+			if followed++; followed > dnsMaxCNAMEs {
+				return nil, errors.Errorf("lookup %s: too many CNAME records", name)
+			}
+			target = cnames[target]
+		}
+		if records, ok := txts[target]; ok {
+			return records, nil
+		}
+		if target == name {
+			return nil, errors.Errorf("lookup %s: no TXT records found", name)
+		}
+		name = target
+	}
+}
+
+// query sends the question to the configured servers, one after the other,
+// until one of them returns a valid response.
+func (r *dnsResolver) query(name string, typ dnsmessage.Type) (*dnsmessage.Message, error) {
+	q, id, err := r.newQuery(name, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, server := range r.servers {
+		b, err := r.exchange("udp", server, q)
+		if err == nil && len(b) > 2 && b[2]&0x02 != 0 {
+			// Truncated response, retry using TCP.
+			b, err = r.exchange("tcp", server, q)
+		}
+		if err != nil {
+			lastErr = errors.Wrapf(err, "lookup %s on %s", name, server)
+			continue
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(b); err != nil {
+			lastErr = errors.Wrapf(err, "lookup %s on %s: error parsing response", name, server)
+			continue
+		}
+		if !msg.Response || msg.ID != id {
+			lastErr = errors.Errorf("lookup %s on %s: unexpected response", name, server)
+			continue
+		}
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+		default:
+			// Validating resolvers answer SERVFAIL if DNSSEC fails.
+			lastErr = errors.Errorf("lookup %s on %s: server responded with %s", name, server, msg.RCode)
+			continue
+		}
+		if r.requireDNSSEC && binary.BigEndian.Uint16(b[2:4])&dnsADBit == 0 {
+			return nil, errors.Errorf("lookup %s on %s: response is not authenticated with DNSSEC", name, server)
+		}
+		return &msg, nil
+	}
+	return nil, lastErr
+}
+
+// newQuery creates a new recursive query for the given name and type. If
+// DNSSEC is required, the query sets the DO and AD flags to request the
+// validation status.
+func (r *dnsResolver) newQuery(name string, typ dnsmessage.Type) ([]byte, uint16, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error creating dns query for %s", name)
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return nil, 0, errors.Wrap(err, "error creating dns query id")
+	}
+	id := binary.BigEndian.Uint16(b[:])
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, r.requireDNSSEC); err != nil {
+		return nil, 0, errors.Wrap(err, "error creating dns query")
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               id,
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{
+			{Name: n, Type: typ, Class: dnsmessage.ClassINET},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: opt, Body: &dnsmessage.OPTResource{}},
+		},
+	}
+	q, err := msg.Pack()
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error creating dns query for %s", name)
+	}
+	if r.requireDNSSEC {
+		binary.BigEndian.PutUint16(q[2:4], binary.BigEndian.Uint16(q[2:4])|dnsADBit)
+	}
+	return q, id, nil
+}
+
+// dnsExchange sends the query to the server using the given network, udp or
+// tcp, and returns the response.
+func dnsExchange(network, server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(dnsTimeout)); err != nil {
+		return nil, err
+	}
+
+	if network == "tcp" {
+		b := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(b, uint16(len(query)))
+		copy(b[2:], query)
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, b[:2]); err != nil {
+			return nil, err
+		}
+		res := make([]byte, binary.BigEndian.Uint16(b[:2]))
+		if _, err := io.ReadFull(conn, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	res := make([]byte, dnsUDPSize)
+	n, err := conn.Read(res)
+	if err != nil {
+		return nil, err
+	}
+	return res[:n], nil
+}
@@ -0,0 +1,363 @@
+package acme
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type dnsRecord struct {
+	name string
+	body dnsmessage.ResourceBody
+}
+
+// dnsResponse returns a function that answers the queries with the given
+// records.
+func dnsResponse(t *testing.T, rcode dnsmessage.RCode, authenticated bool, zone map[string][]dnsRecord) dnsExchanger {
+	return func(network, server string, query []byte) ([]byte, error) {
+		var q dnsmessage.Message
+		assert.FatalError(t, q.Unpack(query))
+		name := q.Questions[0].Name.String()
+		msg := dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:                 q.ID,
+				Response:           true,
+				RecursionAvailable: true,
+				RCode:              rcode,
+			},
+			Questions: q.Questions,
+		}
+		for _, r := range zone[name] {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{
+					Name:  dnsmessage.MustNewName(r.name),
+					Class: dnsmessage.ClassINET,
+					TTL:   60,
+				},
+				Body: r.body,
+			})
+		}
+		b, err := msg.Pack()
+		assert.FatalError(t, err)
+		if authenticated {
+			b[3] |= 0x20
+		}
+		return b, nil
+	}
+}
+
+func TestNewLookupTxt(t *testing.T) {
+	system := reflect.ValueOf(net.LookupTXT).Pointer()
+	assert.Equals(t, reflect.ValueOf(newLookupTxt(&provisioner.JWK{})).Pointer(), system)
+	assert.Equals(t, reflect.ValueOf(newLookupTxt(&provisioner.ACME{})).Pointer(), system)
+	assert.Equals(t, reflect.ValueOf(newLookupTxt(&provisioner.ACME{DNS: &provisioner.ACMEDNSOptions{}})).Pointer(), system)
+	assert.NotEquals(t, reflect.ValueOf(newLookupTxt(&provisioner.ACME{DNS: &provisioner.ACMEDNSOptions{
+		Resolvers: []string{"127.0.0.1"},
+	}})).Pointer(), system)
+}
+
+func TestDNSServerAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"8.8.8.8", "8.8.8.8:53"},
+		{"8.8.8.8:5353", "8.8.8.8:5353"},
+		{"dns.internal", "dns.internal:53"},
+		{"dns.internal:5353", "dns.internal:5353"},
+		{"2001:4860:4860::8888", "[2001:4860:4860::8888]:53"},
+		{"[::1]:5353", "[::1]:5353"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equals(t, dnsServerAddress(tt.addr), tt.want)
+		})
+	}
+}
+
+func TestDNSResolverLookupTXT(t *testing.T) {
+	txt := func(name string, s ...string) dnsRecord {
+		return dnsRecord{name, &dnsmessage.TXTResource{TXT: s}}
+	}
+	cname := func(name, target string) dnsRecord {
+		return dnsRecord{name, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(target)}}
+	}
+	loop := map[string][]dnsRecord{}
+	for i := 0; i < 10; i++ {
+		name := string(rune('a'+i)) + ".example.com."
+		next := string(rune('a'+i+1)) + ".example.com."
+		loop[name] = []dnsRecord{cname(name, next)}
+	}
+
+	type test struct {
+		r    *dnsResolver
+		name string
+		want []string
+		err  error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/exchange-error": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53"},
+					exchange: func(network, server string, query []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com. on 127.0.0.1:53: force"),
+			}
+		},
+		"fail/bad-response": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53"},
+					exchange: func(network, server string, query []byte) ([]byte, error) {
+						return []byte{1, 2, 3}, nil
+					},
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com. on 127.0.0.1:53: error parsing response"),
+			}
+		},
+		"fail/unexpected-response": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53"},
+					exchange: func(network, server string, query []byte) ([]byte, error) {
+						return query, nil
+					},
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com. on 127.0.0.1:53: unexpected response"),
+			}
+		},
+		"fail/servfail": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers:  []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeServerFailure, false, nil),
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com. on 127.0.0.1:53: server responded with RCodeServerFailure"),
+			}
+		},
+		"fail/nxdomain": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers:  []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeNameError, false, nil),
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com.: no such host"),
+			}
+		},
+		"fail/no-records": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers:  []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeSuccess, false, nil),
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com.: no TXT records found"),
+			}
+		},
+		"fail/not-authenticated": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers:       []string{"127.0.0.1:53"},
+					requireDNSSEC: true,
+					exchange: dnsResponse(t, dnsmessage.RCodeSuccess, false, map[string][]dnsRecord{
+						"_acme-challenge.example.com.": {txt("_acme-challenge.example.com.", "foo")},
+					}),
+				},
+				name: "_acme-challenge.example.com",
+				err:  errors.New("lookup _acme-challenge.example.com. on 127.0.0.1:53: response is not authenticated with DNSSEC"),
+			}
+		},
+		"fail/cname-loop": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers:  []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeSuccess, false, loop),
+				},
+				name: "a.example.com",
+				err:  errors.New("lookup i.example.com.: too many CNAME records"),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeSuccess, false, map[string][]dnsRecord{
+						"_acme-challenge.example.com.": {
+							txt("_acme-challenge.example.com.", "foo"),
+							txt("_acme-challenge.example.com.", "bar", "baz"),
+						},
+					}),
+				},
+				name: "_acme-challenge.example.com",
+				want: []string{"foo", "barbaz"},
+			}
+		},
+		"ok/dnssec": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers:       []string{"127.0.0.1:53"},
+					requireDNSSEC: true,
+					exchange: func(network, server string, query []byte) ([]byte, error) {
+						// Check the AD and DO flags.
+						assert.True(t, query[3]&0x20 != 0)
+						var q dnsmessage.Message
+						assert.FatalError(t, q.Unpack(query))
+						assert.Len(t, 1, q.Additionals)
+						assert.True(t, q.Additionals[0].Header.DNSSECAllowed())
+						return dnsResponse(t, dnsmessage.RCodeSuccess, true, map[string][]dnsRecord{
+							"_acme-challenge.example.com.": {txt("_acme-challenge.example.com.", "foo")},
+						})(network, server, query)
+					},
+				},
+				name: "_acme-challenge.example.com",
+				want: []string{"foo"},
+			}
+		},
+		"ok/next-server": func(t *testing.T) test {
+			ok := dnsResponse(t, dnsmessage.RCodeSuccess, false, map[string][]dnsRecord{
+				"_acme-challenge.example.com.": {txt("_acme-challenge.example.com.", "foo")},
+			})
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53", "127.0.0.2:53"},
+					exchange: func(network, server string, query []byte) ([]byte, error) {
+						if server == "127.0.0.1:53" {
+							return nil, errors.New("force")
+						}
+						return ok(network, server, query)
+					},
+				},
+				name: "_acme-challenge.example.com",
+				want: []string{"foo"},
+			}
+		},
+		"ok/cname-in-answer": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeSuccess, false, map[string][]dnsRecord{
+						"_acme-challenge.example.com.": {
+							cname("_acme-challenge.example.com.", "_acme-challenge.acme.internal."),
+							txt("_acme-challenge.acme.internal.", "foo"),
+						},
+					}),
+				},
+				name: "_acme-challenge.example.com",
+				want: []string{"foo"},
+			}
+		},
+		"ok/cname-follow": func(t *testing.T) test {
+			return test{
+				r: &dnsResolver{
+					servers: []string{"127.0.0.1:53"},
+					exchange: dnsResponse(t, dnsmessage.RCodeSuccess, false, map[string][]dnsRecord{
+						"_acme-challenge.example.com.": {
+							cname("_acme-challenge.example.com.", "_acme-challenge.acme.internal."),
+						},
+						"_acme-challenge.acme.internal.": {
+							cname("_acme-challenge.acme.internal.", "zone.acme.internal."),
+							txt("zone.acme.internal.", "foo"),
+						},
+					}),
+				},
+				name: "_acme-challenge.EXAMPLE.com",
+				want: []string{"foo"},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			got, err := tc.r.LookupTXT(tc.name)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDNSExchange(t *testing.T) {
+	answer := func(query []byte, truncated bool) []byte {
+		var q dnsmessage.Message
+		assert.FatalError(t, q.Unpack(query))
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: q.ID, Response: true, Truncated: truncated},
+			Questions: q.Questions,
+		}
+		if !truncated {
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.TXTResource{TXT: []string{"tcp"}},
+			}}
+		}
+		b, err := msg.Pack()
+		assert.FatalError(t, err)
+		return b
+	}
+
+	// Use the same port for UDP and TCP.
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer udp.Close()
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Skipf("cannot listen on tcp %s: %v", udp.LocalAddr(), err)
+	}
+	defer tcp.Close()
+
+	go func() {
+		buf := make([]byte, dnsUDPSize)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(answer(buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			var l [2]byte
+			if _, err := io.ReadFull(conn, l[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(l[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					b := answer(query, false)
+					binary.BigEndian.PutUint16(l[:], uint16(len(b)))
+					conn.Write(append(l[:], b...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	r := &dnsResolver{
+		servers:  []string{udp.LocalAddr().String()},
+		exchange: dnsExchange,
+	}
+	got, err := r.LookupTXT("_acme-challenge.example.com")
+	assert.FatalError(t, err)
+	assert.Equals(t, got, []string{"tcp"})
+}
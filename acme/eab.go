@@ -0,0 +1,255 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+)
+
+// externalAccountKeySize is the size in bytes of the HMAC keys used in the
+// External Account Binding.
+const externalAccountKeySize = 32
+
+// ExternalAccountKey is a subset of the internal external account key type
+// containing only those attributes required for responses in the admin API.
+// The HMAC key is only returned when the key is created.
+type ExternalAccountKey struct {
+	ID          string    `json:"id"`
+	Provisioner string    `json:"provisioner"`
+	Reference   string    `json:"reference,omitempty"`
+	HmacKey     string    `json:"hmacKey,omitempty"`
+	AccountID   string    `json:"accountID,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	BoundAt     time.Time `json:"boundAt"`
+}
+
+// ToLog enables response logging. The HMAC key is never logged.
+func (k *ExternalAccountKey) ToLog() (interface{}, error) {
+	c := *k
+	c.HmacKey = ""
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error marshaling external account key for logging"))
+	}
+	return string(b), nil
+}
+
+// externalAccountProvisioner is the interface implemented by the provisioners
+// that can require an External Account Binding.
+type externalAccountProvisioner interface {
+	IsExternalAccountBindingRequired() bool
+}
+
+// isExternalAccountBindingRequired returns true if the provisioner requires
+// new accounts to be bound to an external account.
+func isExternalAccountBindingRequired(p provisioner.Interface) bool {
+	ep, ok := p.(externalAccountProvisioner)
+	return ok && ep.IsExternalAccountBindingRequired()
+}
+
+// externalAccountKey represents an external account key, the key identifier
+// and the HMAC key used by an ACME client to bind a new account to an
+// external account.
+type externalAccountKey struct {
+	ID          string    `json:"id"`
+	Provisioner string    `json:"provisioner"`
+	Reference   string    `json:"reference"`
+	Key         []byte    `json:"key"`
+	AccountID   string    `json:"accountID,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	BoundAt     time.Time `json:"boundAt"`
+}
+
+// newExternalAccountKey creates and stores a new external account key for the
+// given provisioner.
+func newExternalAccountKey(db nosql.DB, p provisioner.Interface, reference string) (*externalAccountKey, error) {
+	id, err := randID()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, externalAccountKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error generating external account key"))
+	}
+
+	k := &externalAccountKey{
+		ID:          id,
+		Provisioner: p.GetID(),
+		Reference:   reference,
+		Key:         key,
+		CreatedAt:   clock.Now(),
+	}
+	return k, k.save(db, nil)
+}
+
+// toACME converts the internal external account key into the public type. The
+// HMAC key is only included if withKey is true.
+func (k *externalAccountKey) toACME(withKey bool) *ExternalAccountKey {
+	eak := &ExternalAccountKey{
+		ID:          k.ID,
+		Provisioner: k.Provisioner,
+		Reference:   k.Reference,
+		AccountID:   k.AccountID,
+		CreatedAt:   k.CreatedAt,
+		BoundAt:     k.BoundAt,
+	}
+	if withKey {
+		eak.HmacKey = base64.RawURLEncoding.EncodeToString(k.Key)
+	}
+	return eak
+}
+
+// isBound returns true if the key has already been used to create an account.
+func (k *externalAccountKey) isBound() bool {
+	return k.AccountID != ""
+}
+
+// save writes the external account key to the DB if, and only if, the key
+// has not changed since the last read.
+func (k *externalAccountKey) save(db nosql.DB, old *externalAccountKey) error {
+	var (
+		err  error
+		oldB []byte
+	)
+	if old != nil {
+		if oldB, err = json.Marshal(old); err != nil {
+			return ServerInternalErr(errors.Wrap(err, "error marshaling old external account key"))
+		}
+	}
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error marshaling external account key"))
+	}
+	_, swapped, err := db.CmpAndSwap(externalAccountKeyTable, []byte(k.ID), oldB, b)
+	switch {
+	case err != nil:
+		return ServerInternalErr(errors.Wrap(err, "error storing external account key"))
+	case !swapped:
+		return ServerInternalErr(errors.New("error storing external account key; " +
+			"value has changed since last read"))
+	default:
+		return nil
+	}
+}
+
+// bind marks the external account key as used by the given account.
+func (k *externalAccountKey) bind(db nosql.DB, accID string) (*externalAccountKey, error) {
+	if k.isBound() {
+		return nil, UnauthorizedErr(errors.Errorf("external account key %s is already bound", k.ID))
+	}
+	b := *k
+	b.AccountID = accID
+	b.BoundAt = clock.Now()
+	if err := b.save(db, k); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// getExternalAccountKey retrieves the external account key with the given ID.
+func getExternalAccountKey(db nosql.DB, id string) (*externalAccountKey, error) {
+	b, err := db.Get(externalAccountKeyTable, []byte(id))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, MalformedErr(errors.Wrapf(err, "external account key %s not found", id))
+		}
+		return nil, ServerInternalErr(errors.Wrapf(err, "error loading external account key %s", id))
+	}
+	k := new(externalAccountKey)
+	if err := json.Unmarshal(b, k); err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling external account key"))
+	}
+	return k, nil
+}
+
+// getExternalAccountKeysByProvisioner retrieves all the external account keys
+// that belong to the given provisioner.
+func getExternalAccountKeysByProvisioner(db nosql.DB, p provisioner.Interface) ([]*externalAccountKey, error) {
+	entries, err := db.List(externalAccountKeyTable)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error listing external account keys"))
+	}
+	keys := []*externalAccountKey{}
+	for _, e := range entries {
+		k := new(externalAccountKey)
+		if err := json.Unmarshal(e.Value, k); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling external account key"))
+		}
+		if k.Provisioner == p.GetID() {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// validateExternalAccountBinding validates the externalAccountBinding field of
+// a newAccount request as described in RFC8555 section 7.3.4. It returns the
+// external account key used to sign the binding.
+func validateExternalAccountBinding(db nosql.DB, dir *directory, p provisioner.Interface, jwk *jose.JSONWebKey, raw []byte) (*externalAccountKey, error) {
+	jws, err := jose.ParseJWS(string(raw))
+	if err != nil {
+		return nil, MalformedErr(errors.Wrap(err, "error parsing externalAccountBinding"))
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, MalformedErr(errors.New("externalAccountBinding must contain exactly one signature"))
+	}
+
+	hdr := jws.Signatures[0].Protected
+	switch hdr.Algorithm {
+	case jose.HS256, jose.HS384, jose.HS512:
+	default:
+		return nil, MalformedErr(errors.Errorf("externalAccountBinding uses an unsuitable algorithm: %s", hdr.Algorithm))
+	}
+	if hdr.Nonce != "" {
+		return nil, MalformedErr(errors.New("externalAccountBinding must not contain a nonce"))
+	}
+	if hdr.KeyID == "" {
+		return nil, MalformedErr(errors.New("externalAccountBinding must contain a kid"))
+	}
+	newAccountURL := dir.getLink(NewAccountLink, URLSafeProvisionerName(p), true)
+	if u, _ := hdr.ExtraHeaders["url"].(string); u != newAccountURL {
+		return nil, MalformedErr(errors.Errorf("url header in externalAccountBinding (%s) does not match newAccount url (%s)", u, newAccountURL))
+	}
+
+	k, err := getExternalAccountKey(db, hdr.KeyID)
+	if err != nil {
+		if e, ok := err.(*Error); ok && e.Type == malformedErr {
+			return nil, UnauthorizedErr(errors.Errorf("external account key %s not found", hdr.KeyID))
+		}
+		return nil, err
+	}
+	if k.Provisioner != p.GetID() {
+		return nil, UnauthorizedErr(errors.Errorf("external account key %s not found", hdr.KeyID))
+	}
+	if k.isBound() {
+		return nil, UnauthorizedErr(errors.Errorf("external account key %s is already bound", k.ID))
+	}
+
+	payload, err := jws.Verify(k.Key)
+	if err != nil {
+		return nil, UnauthorizedErr(errors.Wrap(err, "error verifying externalAccountBinding signature"))
+	}
+	var boundKey jose.JSONWebKey
+	if err := json.Unmarshal(payload, &boundKey); err != nil {
+		return nil, MalformedErr(errors.Wrap(err, "error unmarshaling externalAccountBinding payload"))
+	}
+	boundKID, err := keyToID(&boundKey)
+	if err != nil {
+		return nil, MalformedErr(errors.Wrap(err, "error generating externalAccountBinding key thumbprint"))
+	}
+	accountKID, err := keyToID(jwk)
+	if err != nil {
+		return nil, err
+	}
+	if boundKID != accountKID {
+		return nil, MalformedErr(errors.New("externalAccountBinding key does not match account key"))
+	}
+	return k, nil
+}
@@ -0,0 +1,398 @@
+package acme
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/jose"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func newEAK(p provisioner.Interface) *externalAccountKey {
+	return &externalAccountKey{
+		ID:          "eakID",
+		Provisioner: p.GetID(),
+		Reference:   "ref",
+		Key:         []byte("a-32-byte-long-secret-for-hs256!"),
+		CreatedAt:   clock.Now(),
+	}
+}
+
+// signEAB returns an External Account Binding for the given account key,
+// signed with the external account key.
+func signEAB(t *testing.T, eak *externalAccountKey, alg jose.SignatureAlgorithm, url string, jwk *jose.JSONWebKey, headers map[jose.HeaderKey]interface{}) []byte {
+	opts := new(jose.SignerOptions).WithHeader("kid", eak.ID).WithHeader("url", url)
+	for k, v := range headers {
+		opts = opts.WithHeader(k, v)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: eak.Key}, opts)
+	assert.FatalError(t, err)
+	payload, err := json.Marshal(jwk.Public())
+	assert.FatalError(t, err)
+	jws, err := signer.Sign(payload)
+	assert.FatalError(t, err)
+	return []byte(jws.FullSerialize())
+}
+
+func TestNewExternalAccountKey(t *testing.T) {
+	prov := newProv()
+	type test struct {
+		db  nosql.DB
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing external account key: force")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						assert.Nil(t, old)
+						return nil, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			k, err := newExternalAccountKey(tc.db, prov, "ref")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, k.Provisioner, prov.GetID())
+				assert.Equals(t, k.Reference, "ref")
+				assert.Equals(t, len(k.Key), externalAccountKeySize)
+				assert.False(t, k.isBound())
+
+				eak := k.toACME(true)
+				assert.Equals(t, eak.ID, k.ID)
+				assert.True(t, len(eak.HmacKey) > 0)
+				assert.Equals(t, k.toACME(false).HmacKey, "")
+			}
+		})
+	}
+}
+
+func TestExternalAccountKeyBind(t *testing.T) {
+	prov := newProv()
+	type test struct {
+		eak *externalAccountKey
+		db  nosql.DB
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/already-bound": func(t *testing.T) test {
+			eak := newEAK(prov)
+			eak.AccountID = "otherAccID"
+			return test{
+				eak: eak,
+				err: UnauthorizedErr(errors.New("external account key eakID is already bound")),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				eak: newEAK(prov),
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing external account key; value has changed since last read")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				eak: eak,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						assert.Equals(t, key, []byte(eak.ID))
+						oldb, err := json.Marshal(eak)
+						assert.FatalError(t, err)
+						assert.Equals(t, old, oldb)
+						return nil, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			k, err := tc.eak.bind(tc.db, "accID")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, k.AccountID, "accID")
+				assert.True(t, k.isBound())
+				assert.False(t, tc.eak.isBound())
+				assert.True(t, !k.BoundAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestGetExternalAccountKeysByProvisioner(t *testing.T) {
+	prov := newProv()
+	eak := newEAK(prov)
+	eakb, err := json.Marshal(eak)
+	assert.FatalError(t, err)
+	other := newEAK(prov)
+	other.ID = "otherID"
+	other.Provisioner = "acme/other"
+	otherb, err := json.Marshal(other)
+	assert.FatalError(t, err)
+
+	type test struct {
+		db   nosql.DB
+		keys []*externalAccountKey
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/list-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error listing external account keys: force")),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{{Bucket: bucket, Key: []byte("foo"), Value: []byte("foo")}}, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error unmarshaling external account key")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, externalAccountKeyTable)
+						return []*database.Entry{
+							{Bucket: bucket, Key: []byte(eak.ID), Value: eakb},
+							{Bucket: bucket, Key: []byte(other.ID), Value: otherb},
+						}, nil
+					},
+				},
+				keys: []*externalAccountKey{eak},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			keys, err := getExternalAccountKeysByProvisioner(tc.db, prov)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, keys, tc.keys)
+			}
+		})
+	}
+}
+
+func TestValidateExternalAccountBinding(t *testing.T) {
+	prov := newProv()
+	dir := newDirectory("ca.smallstep.com", "acme")
+	url := dir.getLink(NewAccountLink, URLSafeProvisionerName(prov), true)
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	otherJWK, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+
+	getEAK := func(eak *externalAccountKey) nosql.DB {
+		return &db.MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, bucket, externalAccountKeyTable)
+				assert.Equals(t, key, []byte(eak.ID))
+				return json.Marshal(eak)
+			},
+		}
+	}
+
+	type test struct {
+		db  nosql.DB
+		raw []byte
+		eak *externalAccountKey
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/parse-error": func(t *testing.T) test {
+			return test{
+				raw: []byte("foo"),
+				err: MalformedErr(errors.New("error parsing externalAccountBinding")),
+			}
+		},
+		"fail/unsuitable-algorithm": func(t *testing.T) test {
+			eak := newEAK(prov)
+			signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+				new(jose.SignerOptions).WithHeader("kid", eak.ID).WithHeader("url", url))
+			assert.FatalError(t, err)
+			jws, err := signer.Sign([]byte("{}"))
+			assert.FatalError(t, err)
+			return test{
+				raw: []byte(jws.FullSerialize()),
+				err: MalformedErr(errors.New("externalAccountBinding uses an unsuitable algorithm: ES256")),
+			}
+		},
+		"fail/nonce": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				raw: signEAB(t, eak, jose.HS256, url, jwk, map[jose.HeaderKey]interface{}{"nonce": "foo"}),
+				err: MalformedErr(errors.New("externalAccountBinding must not contain a nonce")),
+			}
+		},
+		"fail/missing-kid": func(t *testing.T) test {
+			eak := newEAK(prov)
+			eak.ID = ""
+			return test{
+				raw: signEAB(t, eak, jose.HS256, url, jwk, nil),
+				err: MalformedErr(errors.New("externalAccountBinding must contain a kid")),
+			}
+		},
+		"fail/url-mismatch": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				raw: signEAB(t, eak, jose.HS256, "https://ca.smallstep.com/acme/other/new-account", jwk, nil),
+				err: MalformedErr(errors.Errorf("url header in externalAccountBinding (https://ca.smallstep.com/acme/other/new-account) does not match newAccount url (%s)", url)),
+			}
+		},
+		"fail/key-not-found": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+				},
+				raw: signEAB(t, eak, jose.HS256, url, jwk, nil),
+				err: UnauthorizedErr(errors.New("external account key eakID not found")),
+			}
+		},
+		"fail/db-error": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				raw: signEAB(t, eak, jose.HS256, url, jwk, nil),
+				err: ServerInternalErr(errors.New("error loading external account key eakID: force")),
+			}
+		},
+		"fail/other-provisioner": func(t *testing.T) test {
+			eak := newEAK(prov)
+			eak.Provisioner = "acme/other"
+			return test{
+				db:  getEAK(eak),
+				raw: signEAB(t, eak, jose.HS256, url, jwk, nil),
+				err: UnauthorizedErr(errors.New("external account key eakID not found")),
+			}
+		},
+		"fail/already-bound": func(t *testing.T) test {
+			eak := newEAK(prov)
+			eak.AccountID = "accID"
+			eak.BoundAt = time.Now()
+			return test{
+				db:  getEAK(eak),
+				raw: signEAB(t, eak, jose.HS256, url, jwk, nil),
+				err: UnauthorizedErr(errors.New("external account key eakID is already bound")),
+			}
+		},
+		"fail/bad-signature": func(t *testing.T) test {
+			eak := newEAK(prov)
+			bad := newEAK(prov)
+			bad.Key = []byte("another-32-byte-long-secret-key!")
+			return test{
+				db:  getEAK(eak),
+				raw: signEAB(t, bad, jose.HS256, url, jwk, nil),
+				err: UnauthorizedErr(errors.New("error verifying externalAccountBinding signature")),
+			}
+		},
+		"fail/key-mismatch": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				db:  getEAK(eak),
+				raw: signEAB(t, eak, jose.HS256, url, otherJWK, nil),
+				err: MalformedErr(errors.New("externalAccountBinding key does not match account key")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				db:  getEAK(eak),
+				raw: signEAB(t, eak, jose.HS256, url, jwk, nil),
+				eak: eak,
+			}
+		},
+		"ok/HS512": func(t *testing.T) test {
+			eak := newEAK(prov)
+			return test{
+				db:  getEAK(eak),
+				raw: signEAB(t, eak, jose.HS512, url, jwk, nil),
+				eak: eak,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			eak, err := validateExternalAccountBinding(tc.db, dir, prov, jwk, tc.raw)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, eak.ID, tc.eak.ID)
+				assert.Equals(t, eak.Key, tc.eak.Key)
+			}
+		})
+	}
+}
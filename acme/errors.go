@@ -1,6 +1,8 @@
 package acme
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 )
 
@@ -24,6 +26,16 @@ func AlreadyRevokedErr(err error) *Error {
 	}
 }
 
+// BadAttestationStatementErr returns a new acme error.
+func BadAttestationStatementErr(err error) *Error {
+	return &Error{
+		Type:   badAttestationStatementErr,
+		Detail: "The attestation statement is unacceptable",
+		Status: 400,
+		Err:    err,
+	}
+}
+
 // BadCSRErr returns a new acme error.
 func BadCSRErr(err error) *Error {
 	return &Error{
@@ -169,7 +181,7 @@ func RateLimitedErr(err error) *Error {
 	return &Error{
 		Type:   rateLimitedErr,
 		Detail: "The request exceeds a rate limit",
-		Status: 400,
+		Status: 429,
 		Err:    err,
 	}
 }
@@ -296,6 +308,8 @@ const (
 	unsupportedIdentifierErr
 	// Visit the “instance” URL and take actions specified there
 	userActionRequiredErr
+	// The attestation statement is unacceptable (e.g., not signed by a trusted root)
+	badAttestationStatementErr
 )
 
 // String returns the string representation of the acme problem type,
@@ -350,6 +364,8 @@ func (ap ProbType) String() string {
 		return "unsupportedIdentifier"
 	case userActionRequiredErr:
 		return "userActionRequired"
+	case badAttestationStatementErr:
+		return "badAttestationStatement"
 	default:
 		return "unsupported type"
 	}
@@ -363,6 +379,9 @@ type Error struct {
 	Status     int
 	Sub        []*Error
 	Identifier *Identifier
+	// RetryAfter is the time after which the request can be retried, it's
+	// sent in the Retry-After header.
+	RetryAfter time.Duration
 }
 
 // Wrap attempts to wrap the internal error.
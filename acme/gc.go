@@ -0,0 +1,176 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+)
+
+// GCOptions are the retention periods used by CollectGarbage.
+//
+// Nonces are deleted NonceRetention after they are created. Orders and
+// authorizations are deleted Retention after they expire, together with the
+// challenges of the authorizations. Certificates are deleted
+// CertificateRetention after they expire, they are never deleted if it's 0.
+type GCOptions struct {
+	Retention            time.Duration
+	NonceRetention       time.Duration
+	CertificateRetention time.Duration
+}
+
+// GCStats are the number of objects deleted by CollectGarbage.
+type GCStats struct {
+	Nonces         int `json:"nonces"`
+	Orders         int `json:"orders"`
+	Authorizations int `json:"authorizations"`
+	Challenges     int `json:"challenges"`
+	Certificates   int `json:"certificates"`
+}
+
+// maxGCAttempts is the number of compare-and-swap attempts to update the index
+// of the orders of an account.
+const maxGCAttempts = 3
+
+// CollectGarbage deletes the nonces, orders, authorizations, challenges and
+// certificates older than the retention periods. Objects that cannot be
+// parsed are kept.
+func (a *Authority) CollectGarbage(opts GCOptions) (*GCStats, error) {
+	now := clock.Now()
+	stats := new(GCStats)
+	var err error
+
+	if stats.Nonces, err = a.deleteEntries(nonceTable, func(b []byte) bool {
+		var n nonce
+		return json.Unmarshal(b, &n) == nil && n.Created.Add(opts.NonceRetention).Before(now)
+	}); err != nil {
+		return stats, err
+	}
+
+	// Orders are also removed from the index of orders of the account.
+	deletedOrders := make(map[string][]string)
+	if stats.Orders, err = a.deleteEntries(orderTable, func(b []byte) bool {
+		var o order
+		if json.Unmarshal(b, &o) != nil || o.Expires.IsZero() || !o.Expires.Add(opts.Retention).Before(now) {
+			return false
+		}
+		deletedOrders[o.AccountID] = append(deletedOrders[o.AccountID], o.ID)
+		return true
+	}); err != nil {
+		return stats, err
+	}
+	for accID, ids := range deletedOrders {
+		if err := a.removeOrderIDs(accID, ids); err != nil {
+			return stats, err
+		}
+	}
+
+	if stats.Authorizations, err = a.deleteEntries(authzTable, func(b []byte) bool {
+		var az baseAuthz
+		return json.Unmarshal(b, &az) == nil && !az.Expires.IsZero() && az.Expires.Add(opts.Retention).Before(now)
+	}); err != nil {
+		return stats, err
+	}
+
+	// Challenges are deleted if their authorization does not exist.
+	authzs, err := a.db.List(authzTable)
+	if err != nil && !nosql.IsErrNotFound(err) {
+		return stats, ServerInternalErr(errors.Wrap(err, "error listing authorizations"))
+	}
+	liveAuthzs := make(map[string]bool, len(authzs))
+	for _, e := range authzs {
+		liveAuthzs[string(e.Key)] = true
+	}
+	if stats.Challenges, err = a.deleteEntries(challengeTable, func(b []byte) bool {
+		var ch baseChallenge
+		return json.Unmarshal(b, &ch) == nil && !liveAuthzs[ch.AuthzID] && ch.Created.Add(opts.Retention).Before(now)
+	}); err != nil {
+		return stats, err
+	}
+
+	if opts.CertificateRetention > 0 {
+		if stats.Certificates, err = a.deleteEntries(certTable, func(b []byte) bool {
+			var c certificate
+			if json.Unmarshal(b, &c) != nil {
+				return false
+			}
+			block, _ := pem.Decode(c.Leaf)
+			if block == nil {
+				return false
+			}
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			return err == nil && leaf.NotAfter.Add(opts.CertificateRetention).Before(now)
+		}); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// deleteEntries deletes the entries of the table whose value is expired and
+// returns the number of entries deleted.
+func (a *Authority) deleteEntries(table []byte, expired func([]byte) bool) (int, error) {
+	entries, err := a.db.List(table)
+	switch {
+	case nosql.IsErrNotFound(err):
+		return 0, nil
+	case err != nil:
+		return 0, ServerInternalErr(errors.Wrapf(err, "error listing %s", table))
+	}
+	var n int
+	for _, e := range entries {
+		if !expired(e.Value) {
+			continue
+		}
+		if err := a.db.Del(table, e.Key); err != nil {
+			return n, ServerInternalErr(errors.Wrapf(err, "error deleting %s/%s", table, e.Key))
+		}
+		n++
+	}
+	return n, nil
+}
+
+// removeOrderIDs removes the deleted orders from the index of orders of the
+// account. The index is never left empty, an empty index cannot be updated
+// with a compare-and-swap when a new order is created, so the last order is
+// kept; GetOrdersByAccount skips the orders that do not exist.
+func (a *Authority) removeOrderIDs(accID string, ids []string) error {
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	for i := 0; i < maxGCAttempts; i++ {
+		oids, err := getOrderIDsByAccount(a.db, accID)
+		if err != nil {
+			return err
+		}
+		if len(oids) == 0 {
+			return nil
+		}
+		var newOids orderIDs
+		for _, id := range oids {
+			if !deleted[id] {
+				newOids = append(newOids, id)
+			}
+		}
+		switch {
+		case len(newOids) == len(oids):
+			return nil
+		case len(newOids) == 0:
+			if len(oids) == 1 {
+				return nil
+			}
+			newOids = orderIDs{oids[len(oids)-1]}
+		}
+		if err := newOids.save(a.db, oids, accID); err == nil {
+			return nil
+		}
+	}
+	// The index changed in every attempt, it will be updated in the next
+	// collection.
+	return nil
+}
@@ -0,0 +1,176 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql/database"
+)
+
+// newMemoryDB returns a MockNoSQLDB that keeps the entries in memory.
+func newMemoryDB() (*db.MockNoSQLDB, map[string]map[string][]byte) {
+	tables := make(map[string]map[string][]byte)
+	return &db.MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if v, ok := tables[string(bucket)][string(key)]; ok {
+				return v, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MDel: func(bucket, key []byte) error {
+			delete(tables[string(bucket)], string(key))
+			return nil
+		},
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			t, ok := tables[string(bucket)]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			var entries []*database.Entry
+			for k, v := range t {
+				entries = append(entries, &database.Entry{Bucket: bucket, Key: []byte(k), Value: v})
+			}
+			return entries, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			if tables[string(bucket)] == nil {
+				tables[string(bucket)] = make(map[string][]byte)
+			}
+			if v := tables[string(bucket)][string(key)]; string(v) != string(old) {
+				return v, false, nil
+			}
+			tables[string(bucket)][string(key)] = newval
+			return newval, true, nil
+		},
+	}, tables
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	assert.FatalError(t, err)
+	return b
+}
+
+func mustLeaf(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	assert.FatalError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func tableKeys(table map[string][]byte) []string {
+	var s []string
+	for k := range table {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestAuthority_CollectGarbage(t *testing.T) {
+	now := clock.Now()
+	day := 24 * time.Hour
+	mockdb, tables := newMemoryDB()
+	set := func(table []byte, key string, value []byte) {
+		if tables[string(table)] == nil {
+			tables[string(table)] = make(map[string][]byte)
+		}
+		tables[string(table)][key] = value
+	}
+
+	set(nonceTable, "old", mustJSON(t, &nonce{ID: "old", Created: now.Add(-2 * day)}))
+	set(nonceTable, "new", mustJSON(t, &nonce{ID: "new", Created: now.Add(-time.Hour)}))
+	set(nonceTable, "bad", []byte("{"))
+
+	set(orderTable, "o1", mustJSON(t, &order{ID: "o1", AccountID: "acc1", Expires: now.Add(-8 * day), Status: StatusValid}))
+	set(orderTable, "o2", mustJSON(t, &order{ID: "o2", AccountID: "acc1", Expires: now.Add(-6 * day), Status: StatusInvalid}))
+	set(orderTable, "o3", mustJSON(t, &order{ID: "o3", AccountID: "acc2", Expires: now.Add(-10 * day), Status: StatusPending}))
+	set(orderTable, "o4", mustJSON(t, &order{ID: "o4", AccountID: "acc3", Expires: now.Add(-10 * day), Status: StatusValid}))
+	set(orderTable, "o5", mustJSON(t, &order{ID: "o5", AccountID: "acc3", Expires: now.Add(-9 * day), Status: StatusValid}))
+	set(ordersByAccountIDTable, "acc1", []byte(`["o1","o2"]`))
+	set(ordersByAccountIDTable, "acc2", []byte(`["o3"]`))
+	set(ordersByAccountIDTable, "acc3", []byte(`["o4","o5"]`))
+
+	set(authzTable, "az1", mustJSON(t, &baseAuthz{ID: "az1", Expires: now.Add(-8 * day), Challenges: []string{"ch1"}}))
+	set(authzTable, "az2", mustJSON(t, &baseAuthz{ID: "az2", Expires: now.Add(day), Challenges: []string{"ch2"}}))
+	set(challengeTable, "ch1", mustJSON(t, &baseChallenge{ID: "ch1", AuthzID: "az1", Created: now.Add(-9 * day)}))
+	set(challengeTable, "ch2", mustJSON(t, &baseChallenge{ID: "ch2", AuthzID: "az2", Created: now.Add(-9 * day)}))
+	// Orphan challenge created recently.
+	set(challengeTable, "ch3", mustJSON(t, &baseChallenge{ID: "ch3", AuthzID: "az3", Created: now}))
+
+	set(certTable, "c1", mustJSON(t, &certificate{ID: "c1", Leaf: mustLeaf(t, now.Add(-31*day))}))
+	set(certTable, "c2", mustJSON(t, &certificate{ID: "c2", Leaf: mustLeaf(t, now.Add(-29*day))}))
+	set(certTable, "c3", mustJSON(t, &certificate{ID: "c3", Leaf: []byte("not a certificate")}))
+
+	a := &Authority{db: mockdb}
+
+	// Without certificate retention the certificates are kept.
+	stats, err := a.CollectGarbage(GCOptions{Retention: 7 * day, NonceRetention: day})
+	assert.FatalError(t, err)
+	assert.Equals(t, &GCStats{Nonces: 1, Orders: 4, Authorizations: 1, Challenges: 1}, stats)
+	assert.Equals(t, []string{"bad", "new"}, tableKeys(tables[string(nonceTable)]))
+	assert.Equals(t, []string{"o2"}, tableKeys(tables[string(orderTable)]))
+	assert.Equals(t, []string{"az2"}, tableKeys(tables[string(authzTable)]))
+	assert.Equals(t, []string{"ch2", "ch3"}, tableKeys(tables[string(challengeTable)]))
+	assert.Equals(t, []string{"c1", "c2", "c3"}, tableKeys(tables[string(certTable)]))
+
+	// The index of orders is never empty.
+	assert.Equals(t, []byte(`["o2"]`), tables[string(ordersByAccountIDTable)]["acc1"])
+	assert.Equals(t, []byte(`["o3"]`), tables[string(ordersByAccountIDTable)]["acc2"])
+	assert.Equals(t, []byte(`["o5"]`), tables[string(ordersByAccountIDTable)]["acc3"])
+
+	stats, err = a.CollectGarbage(GCOptions{Retention: 7 * day, NonceRetention: day, CertificateRetention: 30 * day})
+	assert.FatalError(t, err)
+	assert.Equals(t, &GCStats{Certificates: 1}, stats)
+	assert.Equals(t, []string{"c2", "c3"}, tableKeys(tables[string(certTable)]))
+}
+
+func TestAuthority_CollectGarbage_errors(t *testing.T) {
+	t.Run("ok/no-tables", func(t *testing.T) {
+		a := &Authority{db: &db.MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+			return nil, database.ErrNotFound
+		}}}
+		stats, err := a.CollectGarbage(GCOptions{CertificateRetention: time.Hour})
+		assert.FatalError(t, err)
+		assert.Equals(t, &GCStats{}, stats)
+	})
+
+	t.Run("fail/list", func(t *testing.T) {
+		a := &Authority{db: &db.MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+			return nil, errors.New("force")
+		}}}
+		_, err := a.CollectGarbage(GCOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("fail/del", func(t *testing.T) {
+		mockdb, tables := newMemoryDB()
+		tables[string(nonceTable)] = map[string][]byte{
+			"old": mustJSON(t, &nonce{ID: "old", Created: clock.Now().Add(-time.Hour)}),
+		}
+		mockdb.MDel = func(bucket, key []byte) error {
+			return errors.New("force")
+		}
+		a := &Authority{db: mockdb}
+		stats, err := a.CollectGarbage(GCOptions{})
+		assert.Error(t, err)
+		assert.Equals(t, 0, stats.Nonces)
+	})
+}
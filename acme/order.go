@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/json"
+	"net"
 	"sort"
 	"strings"
 	"time"
@@ -49,6 +50,10 @@ type OrderOptions struct {
 	Identifiers []Identifier `json:"identifiers"`
 	NotBefore   time.Time    `json:"notBefore"`
 	NotAfter    time.Time    `json:"notAfter"`
+	// ExternalAccount is the external account bound to the account creating
+	// the order, if any.
+	ExternalAccount *provisioner.ACMEExternalAccount `json:"-"`
+	rateLimits      *provisioner.ACMERateLimits
 }
 
 type order struct {
@@ -63,6 +68,9 @@ type order struct {
 	Error          *Error       `json:"error,omitempty"`
 	Authorizations []string     `json:"authorizations"`
 	Certificate    string       `json:"certificate,omitempty"`
+	// ExternalAccount is used to attribute the certificate to the external
+	// account bound to the ACME account.
+	ExternalAccount *provisioner.ACMEExternalAccount `json:"externalAccount,omitempty"`
 }
 
 // newOrder returns a new Order type.
@@ -81,29 +89,39 @@ func newOrder(db nosql.DB, ops OrderOptions) (*order, error) {
 		authzs[i] = az.getID()
 	}
 
+	// The pending authorizations are checked and recorded at once, so
+	// concurrent orders cannot exceed the limit.
+	if err := takePendingAuthzsRateLimit(db, ops.rateLimits, ops.AccountID, authzs); err != nil {
+		return nil, err
+	}
+
 	now := clock.Now()
 	o := &order{
-		ID:             id,
-		AccountID:      ops.AccountID,
-		Created:        now,
-		Status:         StatusPending,
-		Expires:        now.Add(defaultOrderExpiry),
-		Identifiers:    ops.Identifiers,
-		NotBefore:      ops.NotBefore,
-		NotAfter:       ops.NotAfter,
-		Authorizations: authzs,
+		ID:              id,
+		AccountID:       ops.AccountID,
+		Created:         now,
+		Status:          StatusPending,
+		Expires:         now.Add(defaultOrderExpiry),
+		Identifiers:     ops.Identifiers,
+		NotBefore:       ops.NotBefore,
+		NotAfter:        ops.NotAfter,
+		Authorizations:  authzs,
+		ExternalAccount: ops.ExternalAccount,
 	}
 	if err := o.save(db, nil); err != nil {
 		return nil, err
 	}
 
 	// Update the "order IDs by account ID" index //
-	oids, err := getOrderIDsByAccount(db, ops.AccountID)
+	err = retryOnChange(func() error {
+		oids, err := getOrderIDsByAccount(db, ops.AccountID)
+		if err != nil {
+			return err
+		}
+		newOids := append(oids, o.ID)
+		return orderIDs(newOids).save(db, oids, o.AccountID)
+	})
 	if err != nil {
-		return nil, err
-	}
-	newOids := append(oids, o.ID)
-	if err = orderIDs(newOids).save(db, oids, o.AccountID); err != nil {
 		db.Del(orderTable, []byte(o.ID))
 		return nil, err
 	}
@@ -134,8 +152,8 @@ func (oids orderIDs) save(db nosql.DB, old orderIDs, accID string) error {
 	case err != nil:
 		return ServerInternalErr(errors.Wrapf(err, "error storing order IDs for account %s", accID))
 	case !swapped:
-		return ServerInternalErr(errors.Errorf("error storing order IDs "+
-			"for account %s; order IDs changed since last read", accID))
+		return ServerInternalErr(changedError("error storing order IDs " +
+			"for account " + accID + "; order IDs changed since last read"))
 	default:
 		return nil
 	}
@@ -164,15 +182,30 @@ func (o *order) save(db nosql.DB, old *order) error {
 	case err != nil:
 		return ServerInternalErr(errors.Wrap(err, "error storing order"))
 	case !swapped:
-		return ServerInternalErr(errors.New("error storing order; " +
+		return ServerInternalErr(changedError("error storing order; " +
 			"value has changed since last read"))
 	default:
 		return nil
 	}
 }
 
-// updateStatus updates order status if necessary.
+// updateStatus updates order status if necessary. If the order has been
+// modified concurrently, it's read again and the update is retried.
 func (o *order) updateStatus(db nosql.DB) (*order, error) {
+	newOrder, err := o.tryUpdateStatus(db)
+	for i := 0; i < maxStoreRetries && isChangedErr(err); i++ {
+		var cur *order
+		if cur, err = getOrder(db, o.ID); err != nil {
+			return nil, err
+		}
+		newOrder, err = cur.tryUpdateStatus(db)
+	}
+	return newOrder, err
+}
+
+// tryUpdateStatus updates the order status if necessary, it fails if the
+// order has changed since it was read.
+func (o *order) tryUpdateStatus(db nosql.DB) (*order, error) {
 	_newOrder := *o
 	newOrder := &_newOrder
 
@@ -236,7 +269,7 @@ func (o *order) updateStatus(db nosql.DB) (*order, error) {
 
 // finalize signs a certificate if the necessary conditions for Order completion
 // have been met.
-func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAuthority, p provisioner.Interface) (*order, error) {
+func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAuthority, p provisioner.Interface, policy PermanentIdentifierPolicy) (*order, error) {
 	var err error
 	if o, err = o.updateStatus(db); err != nil {
 		return nil, err
@@ -259,15 +292,40 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 	// MUST appear either in the commonName portion of the requested subject
 	// name or in an extensionRequest attribute [RFC2985] requesting a
 	// subjectAltName extension, or both.
+	//
+	// Identifiers of type "ip" MUST appear in an extensionRequest attribute
+	// requesting a subjectAltName extension with iPAddress entries (RFC8738),
+	// a commonName with an IP address is also accepted.
 	if csr.Subject.CommonName != "" {
-		csr.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
+		if ip := net.ParseIP(csr.Subject.CommonName); ip != nil {
+			csr.IPAddresses = append(csr.IPAddresses, ip)
+		} else {
+			csr.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
+		}
 	}
 	csr.DNSNames = uniqueLowerNames(csr.DNSNames)
-	orderNames := make([]string, len(o.Identifiers))
-	for i, n := range o.Identifiers {
-		orderNames[i] = n.Value
+	csrIPs := uniqueIPs(csr.IPAddresses)
+	//
+	// Identifiers of type "permanent-identifier" are mapped to the names
+	// returned by the permanent identifier policy.
+	orderNames := make([]string, 0, len(o.Identifiers))
+	var orderIPs []net.IP
+	for _, n := range o.Identifiers {
+		switch {
+		case n.Type == "ip":
+			orderIPs = append(orderIPs, net.ParseIP(n.Value))
+		case n.Type != "permanent-identifier" || policy == nil:
+			orderNames = append(orderNames, n.Value)
+		default:
+			names, err := policy(p, n.Value)
+			if err != nil {
+				return nil, RejectedIdentifierErr(errors.Wrapf(err, "error mapping permanent identifier %s", n.Value))
+			}
+			orderNames = append(orderNames, names...)
+		}
 	}
 	orderNames = uniqueLowerNames(orderNames)
+	ips := uniqueIPs(orderIPs)
 
 	// Validate identifier names against CSR alternative names.
 	if len(csr.DNSNames) != len(orderNames) {
@@ -279,8 +337,28 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 		}
 	}
 
+	// Validate identifier IP addresses against CSR IP addresses.
+	if len(csrIPs) != len(ips) {
+		return nil, BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly: CSR IPs = %v, Order IPs = %v", csrIPs, ips))
+	}
+	for i := range csrIPs {
+		if csrIPs[i] != ips[i] {
+			return nil, BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly: CSR IPs = %v, Order IPs = %v", csrIPs, ips))
+		}
+	}
+
+	limits := getRateLimits(p)
+	if limits != nil {
+		if err := checkCertificatesRateLimit(db, p, limits, orderNames); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get authorizations from the ACME provisioner.
 	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+	if o.ExternalAccount != nil {
+		ctx = provisioner.NewContextWithACMEExternalAccount(ctx, o.ExternalAccount)
+	}
 	signOps, err := p.AuthorizeSign(ctx, "")
 	if err != nil {
 		return nil, ServerInternalErr(errors.Wrapf(err, "error retrieving authorization options from ACME provisioner"))
@@ -304,6 +382,14 @@ func (o *order) finalize(db nosql.DB, csr *x509.CertificateRequest, auth SignAut
 	if err != nil {
 		return nil, err
 	}
+	if _, err := newRenewalInfo(db, p, cert.ID, certChain[0]); err != nil {
+		return nil, err
+	}
+	if limits != nil {
+		if err := addCertificatesRateLimit(db, p, limits, orderNames); err != nil {
+			return nil, err
+		}
+	}
 
 	_newOrder := *o
 	newOrder := &_newOrder
@@ -369,3 +455,18 @@ func uniqueLowerNames(names []string) (unique []string) {
 	sort.Strings(unique)
 	return
 }
+
+// uniqueIPs returns the set of all unique IP addresses in the input in their
+// canonical text form, sorted alphabetically.
+func uniqueIPs(ips []net.IP) (unique []string) {
+	ipMap := make(map[string]int, len(ips))
+	for _, ip := range ips {
+		ipMap[ip.String()] = 1
+	}
+	unique = make([]string, 0, len(ipMap))
+	for ip := range ipMap {
+		unique = append(unique, ip)
+	}
+	sort.Strings(unique)
+	return
+}
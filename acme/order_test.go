@@ -1,11 +1,14 @@
 package acme
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"net"
 	"testing"
 	"time"
 
@@ -611,6 +614,55 @@ func TestOrderUpdateStatus(t *testing.T) {
 				err: ServerInternalErr(errors.New("error storing order: force")),
 			}
 		},
+		"ok/changed-concurrently": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Expires = time.Now().UTC().Add(-time.Minute)
+
+			// The order has been invalidated by another request.
+			_o := *o
+			stored := &_o
+			stored.Status = StatusInvalid
+			b, err := json.Marshal(stored)
+			assert.FatalError(t, err)
+			return test{
+				o:   o,
+				res: stored,
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, orderTable)
+						assert.Equals(t, key, []byte(o.ID))
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return b, false, nil
+					},
+				},
+			}
+		},
+		"fail/changed-concurrently": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Expires = time.Now().UTC().Add(-time.Minute)
+			b, err := json.Marshal(o)
+			assert.FatalError(t, err)
+			var count int
+			return test{
+				o:   o,
+				res: o,
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return b, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						count++
+						assert.True(t, count <= maxStoreRetries+1)
+						return nil, false, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing order; value has changed since last read")),
+			}
+		},
 		"ok/expired": func(t *testing.T) test {
 			o, err := newO()
 			assert.FatalError(t, err)
@@ -856,6 +908,7 @@ func TestOrderFinalize(t *testing.T) {
 		csr    *x509.CertificateRequest
 		sa     SignAuthority
 		prov   provisioner.Interface
+		policy PermanentIdentifierPolicy
 	}
 	tests := map[string]func(t *testing.T) test{
 		"fail/already-invalid": func(t *testing.T) test {
@@ -987,6 +1040,37 @@ func TestOrderFinalize(t *testing.T) {
 				err: BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly")),
 			}
 		},
+		"fail/ready/csr-ips-match-error": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = append(o.Identifiers, Identifier{Type: "ip", Value: "10.0.0.1"})
+
+			csr := &x509.CertificateRequest{
+				DNSNames:    []string{"acme.example.com", "step.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.2")},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly: CSR IPs = [10.0.0.2], Order IPs = [10.0.0.1]")),
+			}
+		},
+		"fail/ready/csr-ips-not-in-order": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				DNSNames:    []string{"acme.example.com", "step.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: BadCSRErr(errors.Errorf("CSR IP addresses do not match identifiers exactly: CSR IPs = [10.0.0.1], Order IPs = []")),
+			}
+		},
 		"fail/ready/provisioner-auth-sign-error": func(t *testing.T) test {
 			o, err := newO()
 			assert.FatalError(t, err)
@@ -1137,7 +1221,7 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 7)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1152,6 +1236,158 @@ func TestOrderFinalize(t *testing.T) {
 				},
 			}
 		},
+		"ok/ready/ip": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = append(o.Identifiers,
+				Identifier{Type: "ip", Value: "10.0.0.1"},
+				Identifier{Type: "ip", Value: "fd00::1"})
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "10.0.0.1",
+				},
+				DNSNames:    []string{"acme.example.com", "step.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("fd00:0::1")},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "10.0.0.1",
+				},
+			}
+			inter := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "intermediate",
+				},
+			}
+
+			_o := *o
+			clone := &_o
+			clone.Status = StatusValid
+
+			count := 0
+			return test{
+				o:   o,
+				res: clone,
+				csr: csr,
+				sa: &mockSignAuth{
+					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						assert.Equals(t, csr.DNSNames, []string{"acme.example.com", "step.example.com"})
+						assert.Equals(t, uniqueIPs(csr.IPAddresses), []string{"10.0.0.1", "fd00::1"})
+						return []*x509.Certificate{crt, inter}, nil
+					},
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if count == 0 {
+							clone.Certificate = string(key)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+			}
+		},
+		"fail/ready/store-renewal-info-error": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames: []string{"acme.example.com", "step.example.com"},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				AuthorityKeyId: []byte{1, 2, 3},
+				SerialNumber:   big.NewInt(1234),
+			}
+			inter := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "intermediate",
+				},
+			}
+			return test{
+				o:   o,
+				csr: csr,
+				err: ServerInternalErr(errors.Errorf("error storing renewal info: force")),
+				sa: &mockSignAuth{
+					ret1: crt, ret2: inter,
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if bytes.Equal(bucket, renewalInfoTable) {
+							return nil, false, errors.New("force")
+						}
+						return nil, true, nil
+					},
+				},
+			}
+		},
+		"ok/ready/renewal-info": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				DNSNames: []string{"acme.example.com", "step.example.com"},
+			}
+			now := clock.Now()
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "acme.example.com",
+				},
+				AuthorityKeyId: []byte{1, 2, 3},
+				SerialNumber:   big.NewInt(1234),
+				NotBefore:      now,
+				NotAfter:       now.Add(24 * time.Hour),
+			}
+			inter := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "intermediate",
+				},
+			}
+
+			_o := *o
+			clone := &_o
+			clone.Status = StatusValid
+
+			var stored bool
+			return test{
+				o:   o,
+				res: clone,
+				csr: csr,
+				sa: &mockSignAuth{
+					ret1: crt, ret2: inter,
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						switch {
+						case bytes.Equal(bucket, certTable):
+							clone.Certificate = string(key)
+						case bytes.Equal(bucket, renewalInfoTable):
+							assert.Equals(t, key, []byte("AQID.BNI"))
+							var ri renewalInfo
+							assert.FatalError(t, json.Unmarshal(newval, &ri))
+							assert.Equals(t, ri.CertificateID, clone.Certificate)
+							assert.Equals(t, ri.NotAfter, crt.NotAfter)
+							stored = true
+						case bytes.Equal(bucket, orderTable):
+							assert.True(t, stored)
+						}
+						return nil, true, nil
+					},
+				},
+			}
+		},
 		"ok/ready/no-sans": func(t *testing.T) test {
 			o, err := newO()
 			assert.FatalError(t, err)
@@ -1186,7 +1422,7 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 7)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1233,7 +1469,7 @@ func TestOrderFinalize(t *testing.T) {
 				csr: csr,
 				sa: &mockSignAuth{
 					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
-						assert.Equals(t, len(signOps), 4)
+						assert.Equals(t, len(signOps), 7)
 						return []*x509.Certificate{crt, inter}, nil
 					},
 				},
@@ -1248,6 +1484,132 @@ func TestOrderFinalize(t *testing.T) {
 				},
 			}
 		},
+		"fail/ready/permanent-identifier-policy-error": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "permanent-identifier", Value: "SERIAL"},
+			}
+			return test{
+				o: o,
+				csr: &x509.CertificateRequest{
+					Subject: pkix.Name{CommonName: "SERIAL"},
+				},
+				policy: func(p provisioner.Interface, identifier string) ([]string, error) {
+					return nil, errors.New("force")
+				},
+				err: RejectedIdentifierErr(errors.New("error mapping permanent identifier SERIAL: force")),
+			}
+		},
+		"fail/ready/permanent-identifier-names-mismatch": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "permanent-identifier", Value: "SERIAL"},
+			}
+			return test{
+				o: o,
+				csr: &x509.CertificateRequest{
+					Subject: pkix.Name{CommonName: "SERIAL"},
+				},
+				policy: func(p provisioner.Interface, identifier string) ([]string, error) {
+					return []string{identifier + ".device.internal"}, nil
+				},
+				err: BadCSRErr(errors.Errorf("CSR names do not match identifiers exactly: CSR names = %v, Order names = %v", []string{"serial"}, []string{"serial.device.internal"})),
+			}
+		},
+		"ok/ready/permanent-identifier": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "permanent-identifier", Value: "SERIAL"},
+			}
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "SERIAL",
+				},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "SERIAL",
+				},
+			}
+
+			clone := *o
+			clone.Status = StatusValid
+			count := 0
+			return test{
+				o:   o,
+				res: &clone,
+				csr: csr,
+				sa: &mockSignAuth{
+					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						return []*x509.Certificate{crt}, nil
+					},
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if count == 0 {
+							clone.Certificate = string(key)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+			}
+		},
+		"ok/ready/permanent-identifier-policy": func(t *testing.T) test {
+			o, err := newO()
+			assert.FatalError(t, err)
+			o.Status = StatusReady
+			o.Identifiers = []Identifier{
+				{Type: "permanent-identifier", Value: "SERIAL"},
+			}
+
+			csr := &x509.CertificateRequest{
+				Subject: pkix.Name{
+					CommonName: "serial.device.internal",
+				},
+				DNSNames: []string{"laptop.device.internal"},
+			}
+			crt := &x509.Certificate{
+				Subject: pkix.Name{
+					CommonName: "serial.device.internal",
+				},
+				DNSNames: []string{"laptop.device.internal", "serial.device.internal"},
+			}
+
+			clone := *o
+			clone.Status = StatusValid
+			count := 0
+			return test{
+				o:   o,
+				res: &clone,
+				csr: csr,
+				policy: func(p provisioner.Interface, identifier string) ([]string, error) {
+					assert.Equals(t, identifier, "SERIAL")
+					return []string{"serial.device.internal", "laptop.device.internal"}, nil
+				},
+				sa: &mockSignAuth{
+					sign: func(csr *x509.CertificateRequest, pops provisioner.Options, signOps ...provisioner.SignOption) ([]*x509.Certificate, error) {
+						return []*x509.Certificate{crt}, nil
+					},
+				},
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						if count == 0 {
+							clone.Certificate = string(key)
+						}
+						count++
+						return nil, true, nil
+					},
+				},
+			}
+		},
 	}
 	for name, run := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -1256,7 +1618,7 @@ func TestOrderFinalize(t *testing.T) {
 			if p == nil {
 				p = prov
 			}
-			o, err := tc.o.finalize(tc.db, tc.csr, tc.sa, p)
+			o, err := tc.o.finalize(tc.db, tc.csr, tc.sa, p, tc.policy)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					ae, ok := err.(*Error)
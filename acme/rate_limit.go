@@ -0,0 +1,271 @@
+package acme
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/nosql"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// ordersRateLimitWindow is the period of the orders per account limit.
+	ordersRateLimitWindow = time.Hour
+	// certificatesRateLimitWindow is the period of the certificates per
+	// registered domain limit.
+	certificatesRateLimitWindow = 7 * 24 * time.Hour
+)
+
+// rateLimitProvisioner is the interface implemented by the provisioners that
+// can limit the requests of the ACME accounts.
+type rateLimitProvisioner interface {
+	GetRateLimits() *provisioner.ACMERateLimits
+}
+
+// getRateLimits returns the rate limits of the provisioner, or nil if they are
+// not configured.
+func getRateLimits(p provisioner.Interface) *provisioner.ACMERateLimits {
+	if rp, ok := p.(rateLimitProvisioner); ok {
+		return rp.GetRateLimits()
+	}
+	return nil
+}
+
+// rateLimit is the persistent state of a rate limit. Limits over a period of
+// time keep the time of the events in the period, and the pending
+// authorizations limit keeps the IDs of the authorizations that might still be
+// pending.
+type rateLimit struct {
+	Key    string      `json:"key"`
+	Events []time.Time `json:"events,omitempty"`
+	IDs    []string    `json:"ids,omitempty"`
+}
+
+func ordersRateLimitKey(accID string) string {
+	return "orders/" + accID
+}
+
+func pendingAuthzsRateLimitKey(accID string) string {
+	return "pending-authzs/" + accID
+}
+
+func certificatesRateLimitKey(p provisioner.Interface, domain string) string {
+	return "certificates/" + p.GetID() + "/" + domain
+}
+
+// getRateLimit retrieves the rate limit with the given key. It returns nil if
+// the rate limit has not been stored yet.
+func getRateLimit(db nosql.DB, key string) (*rateLimit, error) {
+	b, err := db.Get(rateLimitTable, []byte(key))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, ServerInternalErr(errors.Wrapf(err, "error loading rate limit %s", key))
+	}
+	rl := new(rateLimit)
+	if err := json.Unmarshal(b, rl); err != nil {
+		return nil, ServerInternalErr(errors.Wrapf(err, "error unmarshaling rate limit %s", key))
+	}
+	return rl, nil
+}
+
+// save writes the rate limit to the DB if, and only if, it has not changed
+// since the last read.
+func (rl *rateLimit) save(db nosql.DB, old *rateLimit) error {
+	var (
+		err  error
+		oldB []byte
+	)
+	if old != nil {
+		if oldB, err = json.Marshal(old); err != nil {
+			return ServerInternalErr(errors.Wrap(err, "error marshaling old rate limit"))
+		}
+	}
+
+	b, err := json.Marshal(rl)
+	if err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error marshaling rate limit"))
+	}
+	_, swapped, err := db.CmpAndSwap(rateLimitTable, []byte(rl.Key), oldB, b)
+	switch {
+	case err != nil:
+		return ServerInternalErr(errors.Wrapf(err, "error storing rate limit %s", rl.Key))
+	case !swapped:
+		return ServerInternalErr(changedError("error storing rate limit " + rl.Key +
+			"; value has changed since last read"))
+	default:
+		return nil
+	}
+}
+
+// eventsSince returns the events of the rate limit after the given time. It's
+// safe to call it on a nil rate limit.
+func (rl *rateLimit) eventsSince(t time.Time) []time.Time {
+	if rl == nil {
+		return nil
+	}
+	var events []time.Time
+	for _, e := range rl.Events {
+		if e.After(t) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// newRateLimitedErr returns a rateLimited error that can be retried when the
+// oldest event leaves the window.
+func newRateLimitedErr(oldest time.Time, window time.Duration, err error) *Error {
+	e := RateLimitedErr(err)
+	if d := oldest.Add(window).Sub(clock.Now()); d > 0 {
+		e.RetryAfter = d
+	}
+	return e
+}
+
+// takeOrderRateLimit records a new order of the account, or returns a
+// rateLimited error if the account has created too many orders in the last
+// hour.
+func takeOrderRateLimit(db nosql.DB, limits *provisioner.ACMERateLimits, accID string) error {
+	if limits.OrdersPerAccountPerHour == 0 {
+		return nil
+	}
+	key := ordersRateLimitKey(accID)
+	return retryOnChange(func() error {
+		old, err := getRateLimit(db, key)
+		if err != nil {
+			return err
+		}
+		now := clock.Now()
+		events := old.eventsSince(now.Add(-ordersRateLimitWindow))
+		if len(events) >= limits.OrdersPerAccountPerHour {
+			return newRateLimitedErr(events[0], ordersRateLimitWindow, errors.Errorf("account %s "+
+				"cannot create more than %d orders per hour", accID, limits.OrdersPerAccountPerHour))
+		}
+		rl := &rateLimit{Key: key, Events: append(events, now)}
+		return rl.save(db, old)
+	})
+}
+
+// pendingAuthzs returns the IDs of the authorizations in the rate limit that
+// are still pending.
+func pendingAuthzs(db nosql.DB, rl *rateLimit) ([]string, error) {
+	if rl == nil {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	var ids []string
+	for _, id := range rl.IDs {
+		az, err := getAuthz(db, id)
+		if err != nil {
+			if ae, ok := err.(*Error); ok && ae.Type == malformedErr {
+				continue // not found
+			}
+			return nil, err
+		}
+		if az.getStatus() == StatusPending && now.Before(az.getExpiry()) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// takePendingAuthzsRateLimit records the new authorizations of the account,
+// and removes the ones that are not pending anymore, or returns a rateLimited
+// error if the new authorizations exceed the pending authorizations of the
+// account.
+func takePendingAuthzsRateLimit(db nosql.DB, limits *provisioner.ACMERateLimits, accID string, authzs []string) error {
+	if limits == nil || limits.PendingAuthorizationsPerAccount == 0 {
+		return nil
+	}
+	key := pendingAuthzsRateLimitKey(accID)
+	return retryOnChange(func() error {
+		old, err := getRateLimit(db, key)
+		if err != nil {
+			return err
+		}
+		ids, err := pendingAuthzs(db, old)
+		if err != nil {
+			return err
+		}
+		if len(ids)+len(authzs) > limits.PendingAuthorizationsPerAccount {
+			return RateLimitedErr(errors.Errorf("account %s cannot have more than %d pending "+
+				"authorizations", accID, limits.PendingAuthorizationsPerAccount))
+		}
+		rl := &rateLimit{Key: key, IDs: append(ids, authzs...)}
+		return rl.save(db, old)
+	})
+}
+
+// registeredDomain returns the registered domain of a name, the public suffix
+// plus one label. Names without a known public suffix use the last label as
+// the suffix.
+func registeredDomain(name string) string {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	if d, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return d
+	}
+	return name
+}
+
+// registeredDomains returns the unique registered domains of the names.
+func registeredDomains(names []string) []string {
+	var domains []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if d := registeredDomain(n); !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// checkCertificatesRateLimit returns a rateLimited error if any of the
+// registered domains of the names has reached the certificates per week
+// limit.
+func checkCertificatesRateLimit(db nosql.DB, p provisioner.Interface, limits *provisioner.ACMERateLimits, names []string) error {
+	if limits.CertificatesPerDomainPerWeek == 0 {
+		return nil
+	}
+	since := clock.Now().Add(-certificatesRateLimitWindow)
+	for _, d := range registeredDomains(names) {
+		rl, err := getRateLimit(db, certificatesRateLimitKey(p, d))
+		if err != nil {
+			return err
+		}
+		if events := rl.eventsSince(since); len(events) >= limits.CertificatesPerDomainPerWeek {
+			return newRateLimitedErr(events[0], certificatesRateLimitWindow, errors.Errorf("cannot issue "+
+				"more than %d certificates per week for %s", limits.CertificatesPerDomainPerWeek, d))
+		}
+	}
+	return nil
+}
+
+// addCertificatesRateLimit records a new certificate for the registered
+// domains of the names.
+func addCertificatesRateLimit(db nosql.DB, p provisioner.Interface, limits *provisioner.ACMERateLimits, names []string) error {
+	if limits.CertificatesPerDomainPerWeek == 0 {
+		return nil
+	}
+	now := clock.Now()
+	for _, d := range registeredDomains(names) {
+		key := certificatesRateLimitKey(p, d)
+		err := retryOnChange(func() error {
+			old, err := getRateLimit(db, key)
+			if err != nil {
+				return err
+			}
+			rl := &rateLimit{Key: key, Events: append(old.eventsSince(now.Add(-certificatesRateLimitWindow)), now)}
+			return rl.save(db, old)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
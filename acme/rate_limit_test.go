@@ -0,0 +1,429 @@
+package acme
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func assertAcmeError(t *testing.T, err error, expected *Error) {
+	t.Helper()
+	if assert.NotNil(t, expected) {
+		ae, ok := err.(*Error)
+		assert.True(t, ok)
+		assert.HasPrefix(t, ae.Error(), expected.Error())
+		assert.Equals(t, ae.StatusCode(), expected.StatusCode())
+		assert.Equals(t, ae.Type, expected.Type)
+	}
+}
+
+func TestRegisteredDomains(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"ok", []string{"foo.example.com"}, []string{"example.com"}},
+		{"ok/multiple", []string{"foo.example.com", "*.Bar.Example.com", "example.com", "foo.example.co.uk"}, []string{"example.com", "example.co.uk"}},
+		{"ok/internal", []string{"foo.bar.internal", "zap.internal"}, []string{"bar.internal", "zap.internal"}},
+		{"ok/single-label", []string{"localhost"}, []string{"localhost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, registeredDomains(tt.names), tt.want)
+		})
+	}
+}
+
+func TestTakeOrderRateLimit(t *testing.T) {
+	limits := &provisioner.ACMERateLimits{OrdersPerAccountPerHour: 2}
+	key := []byte(ordersRateLimitKey("accID"))
+	now := clock.Now()
+	type test struct {
+		db     nosql.DB
+		limits *provisioner.ACMERateLimits
+		err    *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/disabled": func(t *testing.T) test {
+			return test{
+				db:     &db.MockNoSQLDB{},
+				limits: &provisioner.ACMERateLimits{},
+			}
+		},
+		"fail/get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				limits: limits,
+				err:    ServerInternalErr(errors.New("error loading rate limit orders/accID: force")),
+			}
+		},
+		"fail/limited": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return json.Marshal(&rateLimit{Key: string(key), Events: []time.Time{
+							now.Add(-30 * time.Minute), now.Add(-time.Minute),
+						}})
+					},
+				},
+				limits: limits,
+				err:    RateLimitedErr(errors.New("account accID cannot create more than 2 orders per hour")),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, nil
+					},
+				},
+				limits: limits,
+				err:    ServerInternalErr(errors.New("error storing rate limit orders/accID; value has changed since last read")),
+			}
+		},
+		"fail/limited-after-change": func(t *testing.T) test {
+			// Another instance takes the last order between the read and the
+			// write.
+			var stored []byte
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if stored == nil {
+							return json.Marshal(&rateLimit{Key: string(key), Events: []time.Time{now.Add(-30 * time.Minute)}})
+						}
+						return stored, nil
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Nil(t, stored)
+						stored, _ = json.Marshal(&rateLimit{Key: string(key), Events: []time.Time{
+							now.Add(-30 * time.Minute), now.Add(-time.Second),
+						}})
+						return stored, false, nil
+					},
+				},
+				limits: limits,
+				err:    RateLimitedErr(errors.New("account accID cannot create more than 2 orders per hour")),
+			}
+		},
+		"ok/retry-changed": func(t *testing.T) test {
+			var calls int
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, k, old, newval []byte) ([]byte, bool, error) {
+						calls++
+						return nil, calls == 3, nil
+					},
+				},
+				limits: limits,
+			}
+		},
+		"ok/new": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, k, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, rateLimitTable)
+						assert.Equals(t, k, key)
+						assert.Nil(t, old)
+						var rl rateLimit
+						assert.FatalError(t, json.Unmarshal(newval, &rl))
+						assert.Equals(t, len(rl.Events), 1)
+						return nil, true, nil
+					},
+				},
+				limits: limits,
+			}
+		},
+		"ok/expired-events": func(t *testing.T) test {
+			old, err := json.Marshal(&rateLimit{Key: string(key), Events: []time.Time{
+				now.Add(-2 * time.Hour), now.Add(-61 * time.Minute), now.Add(-time.Minute),
+			}})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return old, nil
+					},
+					MCmpAndSwap: func(bucket, k, oldval, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, oldval, old)
+						var rl rateLimit
+						assert.FatalError(t, json.Unmarshal(newval, &rl))
+						assert.Equals(t, len(rl.Events), 2)
+						assert.Equals(t, rl.Events[0], now.Add(-time.Minute))
+						return nil, true, nil
+					},
+				},
+				limits: limits,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if err := takeOrderRateLimit(tc.db, tc.limits, "accID"); err != nil {
+				assertAcmeError(t, err, tc.err)
+				if tc.err != nil && tc.err.Type == rateLimitedErr {
+					ae := err.(*Error)
+					assert.True(t, ae.RetryAfter > 29*time.Minute && ae.RetryAfter <= 30*time.Minute)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestTakePendingAuthzsRateLimit(t *testing.T) {
+	limits := &provisioner.ACMERateLimits{PendingAuthorizationsPerAccount: 3}
+	pending, err := json.Marshal(&baseAuthz{ID: "pending", Identifier: Identifier{Type: "dns", Value: "example.com"}, Status: StatusPending, Expires: time.Now().Add(time.Hour)})
+	assert.FatalError(t, err)
+	expired, err := json.Marshal(&baseAuthz{ID: "expired", Identifier: Identifier{Type: "dns", Value: "example.com"}, Status: StatusPending, Expires: time.Now().Add(-time.Minute)})
+	assert.FatalError(t, err)
+	valid, err := json.Marshal(&baseAuthz{ID: "valid", Identifier: Identifier{Type: "dns", Value: "example.com"}, Status: StatusValid, Expires: time.Now().Add(time.Hour)})
+	assert.FatalError(t, err)
+	authzs := map[string][]byte{"pending": pending, "pending2": pending, "expired": expired, "valid": valid}
+	mget := func(ids ...string) func(bucket, key []byte) ([]byte, error) {
+		return func(bucket, key []byte) ([]byte, error) {
+			if string(bucket) == string(rateLimitTable) {
+				return json.Marshal(&rateLimit{Key: string(key), IDs: ids})
+			}
+			if b, ok := authzs[string(key)]; ok {
+				return b, nil
+			}
+			return nil, database.ErrNotFound
+		}
+	}
+
+	type test struct {
+		db     nosql.DB
+		limits *provisioner.ACMERateLimits
+		authzs []string
+		err    *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok/no-limits": func(t *testing.T) test {
+			return test{
+				db:     &db.MockNoSQLDB{},
+				authzs: []string{"az1", "az2", "az3", "az4"},
+			}
+		},
+		"ok/no-pending-limit": func(t *testing.T) test {
+			return test{
+				db:     &db.MockNoSQLDB{},
+				limits: &provisioner.ACMERateLimits{OrdersPerAccountPerHour: 1},
+				authzs: []string{"az1", "az2", "az3", "az4"},
+			}
+		},
+		"fail/get-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1"},
+				err:    ServerInternalErr(errors.New("error loading rate limit pending-authzs/accID: force")),
+			}
+		},
+		"fail/authz-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(rateLimitTable) {
+							return json.Marshal(&rateLimit{Key: string(key), IDs: []string{"foo"}})
+						}
+						return nil, errors.New("force")
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1"},
+				err:    ServerInternalErr(errors.New("error loading authz foo: force")),
+			}
+		},
+		"fail/limited": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: mget("pending", "pending2", "valid"),
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						t.Error("unexpected call to CmpAndSwap")
+						return nil, false, nil
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1", "az2"},
+				err:    RateLimitedErr(errors.New("account accID cannot have more than 3 pending authorizations")),
+			}
+		},
+		"fail/limited-after-change": func(t *testing.T) test {
+			// A concurrent order adds a pending authorization between the
+			// read and the write.
+			count := 0
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						if string(bucket) == string(rateLimitTable) {
+							count++
+							if count > 1 {
+								return mget("pending", "pending2")(bucket, key)
+							}
+						}
+						return mget("pending")(bucket, key)
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, nil
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1", "az2"},
+				err:    RateLimitedErr(errors.New("account accID cannot have more than 3 pending authorizations")),
+			}
+		},
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1", "az2"},
+				err:    ServerInternalErr(errors.New("error storing rate limit pending-authzs/accID: force")),
+			}
+		},
+		"ok/not-stored": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, rateLimitTable)
+						assert.Nil(t, old)
+						var rl rateLimit
+						assert.FatalError(t, json.Unmarshal(newval, &rl))
+						assert.Equals(t, rl.IDs, []string{"az1", "az2", "az3"})
+						return nil, true, nil
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1", "az2", "az3"},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			old, err := json.Marshal(&rateLimit{Key: pendingAuthzsRateLimitKey("accID"), IDs: []string{"pending", "expired", "valid", "missing"}})
+			assert.FatalError(t, err)
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: mget("pending", "expired", "valid", "missing"),
+					MCmpAndSwap: func(bucket, key, oldval, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, rateLimitTable)
+						assert.Equals(t, oldval, old)
+						var rl rateLimit
+						assert.FatalError(t, json.Unmarshal(newval, &rl))
+						assert.Equals(t, rl.IDs, []string{"pending", "az1", "az2"})
+						return nil, true, nil
+					},
+				},
+				limits: limits,
+				authzs: []string{"az1", "az2"},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			if err := takePendingAuthzsRateLimit(tc.db, tc.limits, "accID", tc.authzs); err != nil {
+				assertAcmeError(t, err, tc.err)
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestCertificatesRateLimit(t *testing.T) {
+	prov := newProv()
+	limits := &provisioner.ACMERateLimits{CertificatesPerDomainPerWeek: 2}
+	now := clock.Now()
+	names := []string{"foo.example.com", "bar.example.com", "foo.internal"}
+	events := map[string][]time.Time{
+		certificatesRateLimitKey(prov, "example.com"):  {now.Add(-8 * 24 * time.Hour), now.Add(-time.Hour)},
+		certificatesRateLimitKey(prov, "foo.internal"): {now.Add(-2 * time.Hour), now.Add(-time.Hour)},
+	}
+
+	t.Run("fail/limited", func(t *testing.T) {
+		db := &db.MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				return json.Marshal(&rateLimit{Key: string(key), Events: events[string(key)]})
+			},
+		}
+		err := checkCertificatesRateLimit(db, prov, limits, names)
+		assertAcmeError(t, err, RateLimitedErr(errors.New("cannot issue more than 2 certificates per week for foo.internal")))
+	})
+
+	t.Run("fail/get-error", func(t *testing.T) {
+		db := &db.MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, errors.New("force")
+			},
+		}
+		err := checkCertificatesRateLimit(db, prov, limits, names)
+		assertAcmeError(t, err, ServerInternalErr(errors.New("error loading rate limit certificates/acme/test@acme-provisioner.com/example.com: force")))
+		err = addCertificatesRateLimit(db, prov, limits, names)
+		assertAcmeError(t, err, ServerInternalErr(errors.New("error loading rate limit certificates/acme/test@acme-provisioner.com/example.com: force")))
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		saved := map[string]int{}
+		db := &db.MockNoSQLDB{
+			MGet: func(bucket, key []byte) ([]byte, error) {
+				if key := string(key); key != certificatesRateLimitKey(prov, "foo.internal") {
+					return json.Marshal(&rateLimit{Key: key, Events: events[key]})
+				}
+				return nil, database.ErrNotFound
+			},
+			MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				var rl rateLimit
+				assert.FatalError(t, json.Unmarshal(newval, &rl))
+				saved[string(key)] = len(rl.Events)
+				return nil, true, nil
+			},
+		}
+		assert.FatalError(t, checkCertificatesRateLimit(db, prov, limits, names))
+		assert.FatalError(t, addCertificatesRateLimit(db, prov, limits, names))
+		assert.Equals(t, saved, map[string]int{
+			certificatesRateLimitKey(prov, "example.com"):  2,
+			certificatesRateLimitKey(prov, "foo.internal"): 1,
+		})
+	})
+
+	t.Run("ok/disabled", func(t *testing.T) {
+		db := &db.MockNoSQLDB{}
+		assert.FatalError(t, checkCertificatesRateLimit(db, prov, &provisioner.ACMERateLimits{}, names))
+		assert.FatalError(t, addCertificatesRateLimit(db, prov, &provisioner.ACMERateLimits{}, names))
+	})
+}
@@ -0,0 +1,260 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/nosql"
+)
+
+// earlyRenewalWindow is the duration of the renewal window suggested after an
+// operator has requested the early renewal of a certificate.
+const earlyRenewalWindow = time.Hour
+
+// RenewalWindow is the period of time in which an ACME client should renew a
+// certificate.
+type RenewalWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// RenewalInfo is the renewalInfo resource defined in the ACME Renewal
+// Information (ARI) extension.
+type RenewalInfo struct {
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	ExplanationURL  string        `json:"explanationURL,omitempty"`
+}
+
+// ToLog enables response logging.
+func (ri *RenewalInfo) ToLog() (interface{}, error) {
+	b, err := json.Marshal(ri)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error marshaling renewal info for logging"))
+	}
+	return string(b), nil
+}
+
+// EarlyRenewalOptions are the options used to signal the early renewal of the
+// certificates of a provisioner.
+type EarlyRenewalOptions struct {
+	// IDs is the list of renewalInfo identifiers of the certificates.
+	IDs []string
+	// All signals all the certificates of the provisioner that have not
+	// expired yet. IDs is ignored if All is set.
+	All bool
+	// ExplanationURL is the URL of a page explaining the reason for the early
+	// renewal, e.g. an incident report.
+	ExplanationURL string
+}
+
+// renewalInfoProvisioner is the interface implemented by the provisioners that
+// can configure the renewal windows.
+type renewalInfoProvisioner interface {
+	GetRenewalInfoOptions() *provisioner.ACMERenewalInfoOptions
+}
+
+// getRenewalInfoOptions returns the renewal info options of the provisioner,
+// or nil if they are not configured.
+func getRenewalInfoOptions(p provisioner.Interface) *provisioner.ACMERenewalInfoOptions {
+	if rp, ok := p.(renewalInfoProvisioner); ok {
+		return rp.GetRenewalInfoOptions()
+	}
+	return nil
+}
+
+// renewalInfoID returns the identifier of a certificate in the renewalInfo
+// resource, the base64url encoding of the authority key identifier and the DER
+// encoded serial number, separated by a period. It returns false if the
+// certificate does not have an authority key identifier.
+func renewalInfoID(cert *x509.Certificate) (string, bool) {
+	if len(cert.AuthorityKeyId) == 0 || cert.SerialNumber == nil {
+		return "", false
+	}
+	serial := cert.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." +
+		base64.RawURLEncoding.EncodeToString(serial), true
+}
+
+// renewalSignal is an early renewal requested by an operator.
+type renewalSignal struct {
+	CreatedAt      time.Time `json:"createdAt"`
+	ExplanationURL string    `json:"explanationURL,omitempty"`
+}
+
+// renewalInfo is the information stored for each certificate issued by an
+// ACME provisioner, required to compute its renewal window.
+type renewalInfo struct {
+	ID            string         `json:"id"`
+	Provisioner   string         `json:"provisioner"`
+	CertificateID string         `json:"certificateID"`
+	NotBefore     time.Time      `json:"notBefore"`
+	NotAfter      time.Time      `json:"notAfter"`
+	Signal        *renewalSignal `json:"signal,omitempty"`
+}
+
+// newRenewalInfo creates and stores the renewal information of a new
+// certificate. It returns nil if the certificate cannot be identified in the
+// renewalInfo resource.
+func newRenewalInfo(db nosql.DB, p provisioner.Interface, certID string, leaf *x509.Certificate) (*renewalInfo, error) {
+	id, ok := renewalInfoID(leaf)
+	if !ok {
+		return nil, nil
+	}
+	ri := &renewalInfo{
+		ID:            id,
+		Provisioner:   p.GetID(),
+		CertificateID: certID,
+		NotBefore:     leaf.NotBefore.UTC(),
+		NotAfter:      leaf.NotAfter.UTC(),
+	}
+	return ri, ri.save(db, nil)
+}
+
+// save writes the renewal information to the DB if, and only if, it has not
+// changed since the last read.
+func (ri *renewalInfo) save(db nosql.DB, old *renewalInfo) error {
+	var (
+		err  error
+		oldB []byte
+	)
+	if old != nil {
+		if oldB, err = json.Marshal(old); err != nil {
+			return ServerInternalErr(errors.Wrap(err, "error marshaling old renewal info"))
+		}
+	}
+
+	b, err := json.Marshal(ri)
+	if err != nil {
+		return ServerInternalErr(errors.Wrap(err, "error marshaling renewal info"))
+	}
+	_, swapped, err := db.CmpAndSwap(renewalInfoTable, []byte(ri.ID), oldB, b)
+	switch {
+	case err != nil:
+		return ServerInternalErr(errors.Wrap(err, "error storing renewal info"))
+	case !swapped:
+		return ServerInternalErr(errors.New("error storing renewal info; " +
+			"value has changed since last read"))
+	default:
+		return nil
+	}
+}
+
+// signal marks the certificate for early renewal. Certificates already
+// signaled are not modified.
+func (ri *renewalInfo) signal(db nosql.DB, explanationURL string) (*renewalInfo, error) {
+	if ri.Signal != nil {
+		return ri, nil
+	}
+	s := *ri
+	s.Signal = &renewalSignal{
+		CreatedAt:      clock.Now(),
+		ExplanationURL: explanationURL,
+	}
+	if err := s.save(db, ri); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// toACME returns the renewalInfo resource of the certificate. The default
+// window is the first half of the last third of the certificate lifetime, and
+// it's moved earlier to avoid the maintenance windows of the provisioner.
+func (ri *renewalInfo) toACME(opts *provisioner.ACMERenewalInfoOptions) *RenewalInfo {
+	if ri.Signal != nil {
+		end := ri.Signal.CreatedAt.Add(earlyRenewalWindow)
+		if end.After(ri.NotAfter) {
+			end = ri.NotAfter
+		}
+		return &RenewalInfo{
+			SuggestedWindow: RenewalWindow{Start: ri.Signal.CreatedAt, End: end},
+			ExplanationURL:  ri.Signal.ExplanationURL,
+		}
+	}
+
+	lifetime := ri.NotAfter.Sub(ri.NotBefore)
+	w := RenewalWindow{
+		Start: ri.NotAfter.Add(-lifetime / 3),
+		End:   ri.NotAfter.Add(-lifetime / 6),
+	}
+	if opts == nil {
+		return &RenewalInfo{SuggestedWindow: w}
+	}
+	if moved, ok := avoidMaintenance(w, ri.NotBefore, opts.Maintenance); ok {
+		return &RenewalInfo{SuggestedWindow: moved, ExplanationURL: opts.ExplanationURL}
+	}
+	return &RenewalInfo{SuggestedWindow: w}
+}
+
+// avoidMaintenance moves the window earlier, keeping its duration, until it
+// does not overlap any maintenance window. The window never starts before
+// notBefore. It returns false if the window does not need to be moved or if
+// it cannot be moved.
+func avoidMaintenance(w RenewalWindow, notBefore time.Time, maintenance []provisioner.ACMEMaintenanceWindow) (RenewalWindow, bool) {
+	moved := w
+	// The window only moves earlier, so each pass skips at least one
+	// maintenance window.
+	for i := 0; i <= len(maintenance); i++ {
+		overlaps := false
+		for _, m := range maintenance {
+			if !moved.Start.Before(m.End) || !m.Start.Before(moved.End) {
+				continue
+			}
+			start, end := m.Start.Add(-moved.End.Sub(moved.Start)), m.Start
+			if start.Before(notBefore) {
+				start = notBefore
+			}
+			if !end.After(start) {
+				return w, false
+			}
+			moved, overlaps = RenewalWindow{Start: start, End: end}, true
+		}
+		if !overlaps {
+			break
+		}
+	}
+	return moved, moved != w
+}
+
+// getRenewalInfo retrieves the renewal information with the given ID.
+func getRenewalInfo(db nosql.DB, id string) (*renewalInfo, error) {
+	b, err := db.Get(renewalInfoTable, []byte(id))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, MalformedErr(errors.Wrapf(err, "renewal info for %s not found", id))
+		}
+		return nil, ServerInternalErr(errors.Wrapf(err, "error loading renewal info for %s", id))
+	}
+	ri := new(renewalInfo)
+	if err := json.Unmarshal(b, ri); err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling renewal info"))
+	}
+	return ri, nil
+}
+
+// getRenewalInfosByProvisioner retrieves the renewal information of all the
+// certificates of the given provisioner that have not expired yet.
+func getRenewalInfosByProvisioner(db nosql.DB, p provisioner.Interface) ([]*renewalInfo, error) {
+	entries, err := db.List(renewalInfoTable)
+	if err != nil {
+		return nil, ServerInternalErr(errors.Wrap(err, "error listing renewal info"))
+	}
+	now := clock.Now()
+	ris := []*renewalInfo{}
+	for _, e := range entries {
+		ri := new(renewalInfo)
+		if err := json.Unmarshal(e.Value, ri); err != nil {
+			return nil, ServerInternalErr(errors.Wrap(err, "error unmarshaling renewal info"))
+		}
+		if ri.Provisioner == p.GetID() && ri.NotAfter.After(now) {
+			ris = append(ris, ri)
+		}
+	}
+	return ris, nil
+}
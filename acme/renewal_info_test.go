@@ -0,0 +1,421 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+func newRI(p provisioner.Interface) *renewalInfo {
+	now := clock.Now()
+	return &renewalInfo{
+		ID:            "aki.serial",
+		Provisioner:   p.GetID(),
+		CertificateID: "certID",
+		NotBefore:     now.Add(-time.Hour),
+		NotAfter:      now.Add(5 * time.Hour),
+	}
+}
+
+func TestRenewalInfoID(t *testing.T) {
+	aki := []byte{0x69, 0x88, 0x5b, 0x6b, 0x87, 0x46, 0x40, 0x41, 0xe1, 0xb3, 0x7b, 0x84, 0x7b, 0xa0, 0xae, 0x2c, 0xde, 0x01, 0xc8, 0xd4}
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		want   string
+		wantOK bool
+	}{
+		{"ok", &x509.Certificate{AuthorityKeyId: aki, SerialNumber: big.NewInt(0x0087654321)}, "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE", true},
+		{"ok/no-padding", &x509.Certificate{AuthorityKeyId: aki, SerialNumber: big.NewInt(0x7654321)}, "aYhba4dGQEHhs3uEe6CuLN4ByNQ.B2VDIQ", true},
+		{"ok/zero", &x509.Certificate{AuthorityKeyId: aki, SerialNumber: big.NewInt(0)}, "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AA", true},
+		{"fail/no-aki", &x509.Certificate{SerialNumber: big.NewInt(1)}, "", false},
+		{"fail/no-serial", &x509.Certificate{AuthorityKeyId: aki}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := renewalInfoID(tt.cert)
+			assert.Equals(t, got, tt.want)
+			assert.Equals(t, ok, tt.wantOK)
+		})
+	}
+}
+
+func TestNewRenewalInfo(t *testing.T) {
+	prov := newProv()
+	now := clock.Now()
+	leaf := &x509.Certificate{
+		AuthorityKeyId: []byte{1, 2, 3},
+		SerialNumber:   big.NewInt(1234),
+		NotBefore:      now,
+		NotAfter:       now.Add(24 * time.Hour),
+	}
+	type test struct {
+		db   nosql.DB
+		leaf *x509.Certificate
+		err  *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				leaf: leaf,
+				err:  ServerInternalErr(errors.New("error storing renewal info: force")),
+			}
+		},
+		"ok/no-aki": func(t *testing.T) test {
+			return test{
+				db:   &db.MockNoSQLDB{},
+				leaf: &x509.Certificate{SerialNumber: big.NewInt(1234)},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, renewalInfoTable)
+						assert.Equals(t, key, []byte("AQID.BNI"))
+						assert.Nil(t, old)
+						return nil, true, nil
+					},
+				},
+				leaf: leaf,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			ri, err := newRenewalInfo(tc.db, prov, "certID", tc.leaf)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				if tc.leaf.AuthorityKeyId == nil {
+					assert.Nil(t, ri)
+					return
+				}
+				assert.Equals(t, ri, &renewalInfo{
+					ID:            "AQID.BNI",
+					Provisioner:   prov.GetID(),
+					CertificateID: "certID",
+					NotBefore:     leaf.NotBefore,
+					NotAfter:      leaf.NotAfter,
+				})
+			}
+		})
+	}
+}
+
+func TestRenewalInfoToACME(t *testing.T) {
+	prov := newProv()
+	notBefore := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(24 * time.Hour)
+	ri := &renewalInfo{
+		ID:          "aki.serial",
+		Provisioner: prov.GetID(),
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+	}
+	signaled := *ri
+	signaled.Signal = &renewalSignal{
+		CreatedAt:      notBefore.Add(2 * time.Hour),
+		ExplanationURL: "https://status.smallstep.com/incident",
+	}
+	signaledLate := *ri
+	signaledLate.Signal = &renewalSignal{CreatedAt: notAfter.Add(-30 * time.Minute)}
+
+	window := func(start, end time.Duration) RenewalWindow {
+		return RenewalWindow{Start: notBefore.Add(start), End: notBefore.Add(end)}
+	}
+	maintenance := func(start, end time.Duration) provisioner.ACMEMaintenanceWindow {
+		return provisioner.ACMEMaintenanceWindow{Start: notBefore.Add(start), End: notBefore.Add(end)}
+	}
+	explanationURL := "https://status.smallstep.com/maintenance"
+
+	tests := []struct {
+		name string
+		ri   *renewalInfo
+		opts *provisioner.ACMERenewalInfoOptions
+		want *RenewalInfo
+	}{
+		{"ok/default", ri, nil, &RenewalInfo{
+			SuggestedWindow: window(16*time.Hour, 20*time.Hour),
+		}},
+		{"ok/no-overlap", ri, &provisioner.ACMERenewalInfoOptions{
+			ExplanationURL: explanationURL,
+			Maintenance:    []provisioner.ACMEMaintenanceWindow{maintenance(10*time.Hour, 12*time.Hour), maintenance(20*time.Hour, 21*time.Hour)},
+		}, &RenewalInfo{
+			SuggestedWindow: window(16*time.Hour, 20*time.Hour),
+		}},
+		{"ok/maintenance", ri, &provisioner.ACMERenewalInfoOptions{
+			ExplanationURL: explanationURL,
+			Maintenance:    []provisioner.ACMEMaintenanceWindow{maintenance(18*time.Hour, 26*time.Hour)},
+		}, &RenewalInfo{
+			SuggestedWindow: window(14*time.Hour, 18*time.Hour),
+			ExplanationURL:  explanationURL,
+		}},
+		{"ok/multiple-maintenance", ri, &provisioner.ACMERenewalInfoOptions{
+			ExplanationURL: explanationURL,
+			Maintenance:    []provisioner.ACMEMaintenanceWindow{maintenance(12*time.Hour, 15*time.Hour), maintenance(18*time.Hour, 26*time.Hour)},
+		}, &RenewalInfo{
+			SuggestedWindow: window(8*time.Hour, 12*time.Hour),
+			ExplanationURL:  explanationURL,
+		}},
+		{"ok/maintenance-not-before", ri, &provisioner.ACMERenewalInfoOptions{
+			Maintenance: []provisioner.ACMEMaintenanceWindow{maintenance(2*time.Hour, 26*time.Hour)},
+		}, &RenewalInfo{
+			SuggestedWindow: window(0, 2*time.Hour),
+		}},
+		{"ok/maintenance-cannot-move", ri, &provisioner.ACMERenewalInfoOptions{
+			ExplanationURL: explanationURL,
+			Maintenance:    []provisioner.ACMEMaintenanceWindow{maintenance(-time.Hour, 26*time.Hour)},
+		}, &RenewalInfo{
+			SuggestedWindow: window(16*time.Hour, 20*time.Hour),
+		}},
+		{"ok/signal", &signaled, &provisioner.ACMERenewalInfoOptions{
+			ExplanationURL: explanationURL,
+		}, &RenewalInfo{
+			SuggestedWindow: window(2*time.Hour, 3*time.Hour),
+			ExplanationURL:  "https://status.smallstep.com/incident",
+		}},
+		{"ok/signal-not-after", &signaledLate, nil, &RenewalInfo{
+			SuggestedWindow: RenewalWindow{Start: notAfter.Add(-30 * time.Minute), End: notAfter},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.ri.toACME(tt.opts), tt.want)
+		})
+	}
+}
+
+func TestRenewalInfoSignal(t *testing.T) {
+	prov := newProv()
+	type test struct {
+		ri  *renewalInfo
+		db  nosql.DB
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/save-error": func(t *testing.T) test {
+			return test{
+				ri: newRI(prov),
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						return nil, false, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error storing renewal info: force")),
+			}
+		},
+		"ok/already-signaled": func(t *testing.T) test {
+			ri := newRI(prov)
+			ri.Signal = &renewalSignal{CreatedAt: clock.Now()}
+			return test{
+				ri: ri,
+				db: &db.MockNoSQLDB{},
+			}
+		},
+		"ok": func(t *testing.T) test {
+			ri := newRI(prov)
+			return test{
+				ri: ri,
+				db: &db.MockNoSQLDB{
+					MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+						assert.Equals(t, bucket, renewalInfoTable)
+						assert.Equals(t, key, []byte(ri.ID))
+						oldB, err := json.Marshal(ri)
+						assert.FatalError(t, err)
+						assert.Equals(t, old, oldB)
+						return nil, true, nil
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			ri, err := tc.ri.signal(tc.db, "https://status.smallstep.com/incident")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				if tc.ri.Signal != nil {
+					assert.Equals(t, ri, tc.ri)
+					return
+				}
+				assert.Nil(t, tc.ri.Signal)
+				if assert.NotNil(t, ri.Signal) {
+					assert.False(t, ri.Signal.CreatedAt.IsZero())
+					assert.Equals(t, ri.Signal.ExplanationURL, "https://status.smallstep.com/incident")
+				}
+			}
+		})
+	}
+}
+
+func TestGetRenewalInfo(t *testing.T) {
+	prov := newProv()
+	ri := newRI(prov)
+	type test struct {
+		db  nosql.DB
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/not-found": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, database.ErrNotFound
+					},
+				},
+				err: MalformedErr(errors.New("renewal info for aki.serial not found")),
+			}
+		},
+		"fail/db-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error loading renewal info for aki.serial: force")),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						return []byte("foo"), nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error unmarshaling renewal info")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MGet: func(bucket, key []byte) ([]byte, error) {
+						assert.Equals(t, bucket, renewalInfoTable)
+						assert.Equals(t, key, []byte(ri.ID))
+						return json.Marshal(ri)
+					},
+				},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			got, err := getRenewalInfo(tc.db, ri.ID)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, got, ri)
+			}
+		})
+	}
+}
+
+func TestGetRenewalInfosByProvisioner(t *testing.T) {
+	prov := newProv()
+	ri := newRI(prov)
+	expired := newRI(prov)
+	expired.ID = "expired"
+	expired.NotAfter = clock.Now().Add(-time.Minute)
+	other := newRI(prov)
+	other.ID = "other"
+	other.Provisioner = "acme/other"
+
+	type test struct {
+		db  nosql.DB
+		res []*renewalInfo
+		err *Error
+	}
+	tests := map[string]func(t *testing.T) test{
+		"fail/list-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return nil, errors.New("force")
+					},
+				},
+				err: ServerInternalErr(errors.New("error listing renewal info: force")),
+			}
+		},
+		"fail/unmarshal-error": func(t *testing.T) test {
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						return []*database.Entry{{Value: []byte("foo")}}, nil
+					},
+				},
+				err: ServerInternalErr(errors.New("error unmarshaling renewal info")),
+			}
+		},
+		"ok": func(t *testing.T) test {
+			var entries []*database.Entry
+			for _, r := range []*renewalInfo{ri, expired, other} {
+				b, err := json.Marshal(r)
+				assert.FatalError(t, err)
+				entries = append(entries, &database.Entry{Bucket: renewalInfoTable, Key: []byte(r.ID), Value: b})
+			}
+			return test{
+				db: &db.MockNoSQLDB{
+					MList: func(bucket []byte) ([]*database.Entry, error) {
+						assert.Equals(t, bucket, renewalInfoTable)
+						return entries, nil
+					},
+				},
+				res: []*renewalInfo{ri},
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			ris, err := getRenewalInfosByProvisioner(tc.db, prov)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					ae, ok := err.(*Error)
+					assert.True(t, ok)
+					assert.HasPrefix(t, ae.Error(), tc.err.Error())
+					assert.Equals(t, ae.StatusCode(), tc.err.StatusCode())
+					assert.Equals(t, ae.Type, tc.err.Type)
+				}
+			} else if assert.Nil(t, tc.err) {
+				assert.Equals(t, ris, tc.res)
+			}
+		})
+	}
+}
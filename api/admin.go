@@ -0,0 +1,504 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// ProvisionerKeysResponse is the response object of the admin API that lists
+// the additional keys of a JWK provisioner.
+type ProvisionerKeysResponse struct {
+	Keys []*provisioner.JWKKey `json:"keys"`
+}
+
+// AddProvisionerKeyRequest is the request body used in the admin API to add a
+// new key to a JWK provisioner.
+type AddProvisionerKeyRequest struct {
+	Key       *jose.JSONWebKey `json:"key"`
+	NotBefore time.Time        `json:"notBefore,omitempty"`
+	NotAfter  time.Time        `json:"notAfter,omitempty"`
+}
+
+// Validate validates an add provisioner key request body.
+func (r *AddProvisionerKeyRequest) Validate() error {
+	if r.Key == nil {
+		return errs.BadRequest("key cannot be empty")
+	}
+	return nil
+}
+
+// RetireProvisionerKeyRequest is the request body used in the admin API to
+// retire a key of a JWK provisioner. If NotAfter is not set, the key is
+// retired immediately.
+type RetireProvisionerKeyRequest struct {
+	NotAfter time.Time `json:"notAfter,omitempty"`
+}
+
+// ProvisionerRecordsResponse is the response object of the admin API that
+// lists the provisioners managed with it.
+type ProvisionerRecordsResponse struct {
+	Provisioners []*authority.ProvisionerRecord `json:"provisioners"`
+}
+
+// UpdateProvisionerRequest is the request body used in the admin API to
+// replace a provisioner. Version must be the current version of the
+// provisioner.
+type UpdateProvisionerRequest struct {
+	Version     int             `json:"version"`
+	Provisioner json.RawMessage `json:"provisioner"`
+}
+
+// Validate validates an update provisioner request body.
+func (r *UpdateProvisionerRequest) Validate() error {
+	switch {
+	case r.Version <= 0:
+		return errs.BadRequest("version must be greater than 0")
+	case len(r.Provisioner) == 0:
+		return errs.BadRequest("provisioner cannot be empty")
+	default:
+		return nil
+	}
+}
+
+// SetProvisionerSwitchesRequest is the request body used in the admin API to
+// disable or enable the issuance or the renewal of certificates on a
+// provisioner. Version must be the current version of the provisioner.
+type SetProvisionerSwitchesRequest struct {
+	Version int `json:"version"`
+	authority.ProvisionerSwitches
+}
+
+// Validate validates a set provisioner switches request body.
+func (r *SetProvisionerSwitchesRequest) Validate() error {
+	switch {
+	case r.Version <= 0:
+		return errs.BadRequest("version must be greater than 0")
+	case r.DisableIssuance == nil && r.DisableRenewal == nil:
+		return errs.BadRequest("disableIssuance or disableRenewal must be set")
+	default:
+		return nil
+	}
+}
+
+// AdminCredentials returns the credentials used by an administrator, the
+// client certificate used in the TLS connection or the OIDC token in the
+// Authorization header.
+func AdminCredentials(r *http.Request) (*x509.Certificate, string, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0], "", nil
+	}
+	if s := r.Header.Get("Authorization"); s != "" {
+		parts := strings.SplitN(s, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			return nil, "", errs.BadRequest("invalid authorization header")
+		}
+		return nil, strings.TrimSpace(parts[1]), nil
+	}
+	return nil, "", errs.Unauthorized("missing client certificate or token")
+}
+
+// AdminRecordsResponse is the response object of the admin API that lists
+// the administrators managed with it.
+type AdminRecordsResponse struct {
+	Admins []*authority.AdminRecord `json:"admins"`
+}
+
+// CreateAdminRequest is the request body used in the admin API to add a new
+// administrator.
+type CreateAdminRequest struct {
+	Subject      string   `json:"subject"`
+	Role         string   `json:"role"`
+	Provisioners []string `json:"provisioners,omitempty"`
+}
+
+// Validate validates a create admin request body.
+func (r *CreateAdminRequest) Validate() error {
+	switch {
+	case r.Subject == "":
+		return errs.BadRequest("subject cannot be empty")
+	case r.Role == "":
+		return errs.BadRequest("role cannot be empty")
+	default:
+		return nil
+	}
+}
+
+// UpdateAdminRequest is the request body used in the admin API to replace the
+// role and the provisioners of an administrator. Version must be the current
+// version of the administrator.
+type UpdateAdminRequest struct {
+	Version      int      `json:"version"`
+	Role         string   `json:"role"`
+	Provisioners []string `json:"provisioners,omitempty"`
+}
+
+// Validate validates an update admin request body.
+func (r *UpdateAdminRequest) Validate() error {
+	switch {
+	case r.Version <= 0:
+		return errs.BadRequest("version must be greater than 0")
+	case r.Role == "":
+		return errs.BadRequest("role cannot be empty")
+	default:
+		return nil
+	}
+}
+
+// UpdatePolicyRequest is the request body used in the admin API to replace
+// the policy of the authority. Version must be the current version of the
+// policy, 0 if it has not been modified with the admin API.
+type UpdatePolicyRequest struct {
+	Version int             `json:"version"`
+	Policy  *policy.Options `json:"policy"`
+}
+
+// Validate validates an update policy request body.
+func (r *UpdatePolicyRequest) Validate() error {
+	if r.Version < 0 {
+		return errs.BadRequest("version cannot be negative")
+	}
+	return nil
+}
+
+// requireAdmin is a middleware that only allows the request if the client
+// certificate used in the TLS connection or the OIDC token belongs to a
+// super-admin. The requests of the administrators are recorded in the audit
+// log.
+func (h *caHandler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.authorizeAdmin(w, r, "", next)
+	}
+}
+
+// requireProvisionerAdmin is a middleware that only allows the request if the
+// administrator can manage the provisioner in the name URL parameter, the
+// super-admins can manage all of them.
+func (h *caHandler) requireProvisionerAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.authorizeAdmin(w, r, chi.URLParam(r, "name"), next)
+	}
+}
+
+func (h *caHandler) authorizeAdmin(w http.ResponseWriter, r *http.Request, provisionerName string, next http.HandlerFunc) {
+	crt, token, err := AdminCredentials(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	admin, err := h.Authority.AuthorizeAdmin(r.Context(), crt, token, provisionerName)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	sw := &statusResponseWriter{ResponseWriter: w}
+	next(sw, r.WithContext(context.WithValue(r.Context(), adminKey{}, admin)))
+	h.Authority.Audit(&authority.AuditEvent{
+		Action:    authority.AuditAdmin,
+		Requester: admin.Subject,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    sw.statusCode(),
+	})
+}
+
+type adminKey struct{}
+
+// adminFromContext returns the administrator authorized by the admin
+// middlewares, or nil if there is none.
+func adminFromContext(ctx context.Context) *authority.Admin {
+	admin, _ := ctx.Value(adminKey{}).(*authority.Admin)
+	return admin
+}
+
+// statusResponseWriter is an http.ResponseWriter that keeps the status code
+// of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// ProvisionerKeys is the admin API resource that lists the additional keys of
+// a JWK provisioner.
+func (h *caHandler) ProvisionerKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Authority.GetProvisionerKeys(chi.URLParam(r, "name"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &ProvisionerKeysResponse{Keys: keys})
+}
+
+// AddProvisionerKey is the admin API resource used to add a new key to a JWK
+// provisioner. Tokens signed with the new key are accepted along with the ones
+// signed with the existing keys.
+func (h *caHandler) AddProvisionerKey(w http.ResponseWriter, r *http.Request) {
+	var body AddProvisionerKeyRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	key := &provisioner.JWKKey{
+		Key:       body.Key,
+		NotBefore: body.NotBefore,
+		NotAfter:  body.NotAfter,
+	}
+	if err := h.Authority.AddProvisionerKey(chi.URLParam(r, "name"), key); err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, key, http.StatusCreated)
+}
+
+// RetireProvisionerKey is the admin API resource used to retire a key of a JWK
+// provisioner. The tokens signed with it after the retirement time are not
+// accepted.
+func (h *caHandler) RetireProvisionerKey(w http.ResponseWriter, r *http.Request) {
+	var body RetireProvisionerKeyRequest
+	if r.ContentLength != 0 {
+		if err := ReadJSON(r.Body, &body); err != nil {
+			WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+			return
+		}
+	}
+	key, err := h.Authority.RetireProvisionerKey(chi.URLParam(r, "name"), chi.URLParam(r, "kid"), body.NotAfter)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, key)
+}
+
+// ProvisionerRecords is the admin API resource that lists the provisioners
+// managed with the admin API. The provisioners defined in the configuration
+// are not included.
+func (h *caHandler) ProvisionerRecords(w http.ResponseWriter, r *http.Request) {
+	JSON(w, &ProvisionerRecordsResponse{
+		Provisioners: h.Authority.GetProvisionerRecords(),
+	})
+}
+
+// ProvisionerRecord is the admin API resource that returns a provisioner
+// managed with the admin API and its current version.
+func (h *caHandler) ProvisionerRecord(w http.ResponseWriter, r *http.Request) {
+	record, err := h.Authority.GetProvisionerRecord(chi.URLParam(r, "name"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, record)
+}
+
+// CreateProvisioner is the admin API resource used to add a new provisioner.
+// The request body is the provisioner in the same format used in ca.json.
+func (h *caHandler) CreateProvisioner(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	record, err := h.Authority.CreateProvisioner(body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, record, http.StatusCreated)
+}
+
+// UpdateProvisioner is the admin API resource used to replace a provisioner
+// managed with the admin API. It fails with a conflict if the version in the
+// request is not the current one.
+func (h *caHandler) UpdateProvisioner(w http.ResponseWriter, r *http.Request) {
+	var body UpdateProvisionerRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	record, err := h.Authority.UpdateProvisioner(adminFromContext(r.Context()), chi.URLParam(r, "name"), body.Version, body.Provisioner)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, record)
+}
+
+// SetProvisionerSwitches is the admin API resource used to disable or enable
+// the issuance or renewal of certificates on a provisioner managed with the
+// admin API. It fails with a conflict if the version in the request is not the
+// current one.
+func (h *caHandler) SetProvisionerSwitches(w http.ResponseWriter, r *http.Request) {
+	var body SetProvisionerSwitchesRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	record, err := h.Authority.SetProvisionerSwitches(chi.URLParam(r, "name"), body.Version, body.ProvisionerSwitches)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, record)
+}
+
+// DeleteProvisioner is the admin API resource used to remove a provisioner
+// managed with the admin API. The current version of the provisioner must be
+// sent in the version query parameter.
+func (h *caHandler) DeleteProvisioner(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version <= 0 {
+		WriteError(w, errs.BadRequest("version query parameter must be greater than 0"))
+		return
+	}
+	if err := h.Authority.DeleteProvisioner(chi.URLParam(r, "name"), version); err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRecords is the admin API resource that lists the administrators managed
+// with the admin API. The administrators defined in the configuration are not
+// included.
+func (h *caHandler) AdminRecords(w http.ResponseWriter, r *http.Request) {
+	JSON(w, &AdminRecordsResponse{
+		Admins: h.Authority.GetAdminRecords(),
+	})
+}
+
+// AdminRecord is the admin API resource that returns an administrator managed
+// with the admin API and its current version.
+func (h *caHandler) AdminRecord(w http.ResponseWriter, r *http.Request) {
+	record, err := h.Authority.GetAdminRecord(chi.URLParam(r, "subject"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, record)
+}
+
+// CreateAdmin is the admin API resource used to add a new administrator.
+func (h *caHandler) CreateAdmin(w http.ResponseWriter, r *http.Request) {
+	var body CreateAdminRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	record, err := h.Authority.CreateAdmin(body.Subject, body.Role, body.Provisioners)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, record, http.StatusCreated)
+}
+
+// UpdateAdmin is the admin API resource used to replace the role and the
+// provisioners of an administrator. It fails with a conflict if the version in
+// the request is not the current one.
+func (h *caHandler) UpdateAdmin(w http.ResponseWriter, r *http.Request) {
+	var body UpdateAdminRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	record, err := h.Authority.UpdateAdmin(chi.URLParam(r, "subject"), body.Version, body.Role, body.Provisioners)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, record)
+}
+
+// DeleteAdmin is the admin API resource used to remove an administrator. The
+// current version of the administrator must be sent in the version query
+// parameter.
+func (h *caHandler) DeleteAdmin(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version <= 0 {
+		WriteError(w, errs.BadRequest("version query parameter must be greater than 0"))
+		return
+	}
+	if err := h.Authority.DeleteAdmin(chi.URLParam(r, "subject"), version); err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Policy is the admin API resource that returns the current policy of the
+// authority and its version.
+func (h *caHandler) Policy(w http.ResponseWriter, r *http.Request) {
+	JSON(w, h.Authority.GetPolicyRecord())
+}
+
+// UpdatePolicy is the admin API resource used to replace the policy of the
+// authority. It fails with a conflict if the version in the request is not the
+// current one.
+func (h *caHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	var body UpdatePolicyRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	record, err := h.Authority.UpdatePolicy(body.Version, body.Policy)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, record)
+}
@@ -0,0 +1,582 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func newAdminRequest(method, target, body string, params map[string]string) *http.Request {
+	chiCtx := chi.NewRouteContext()
+	for k, v := range params {
+		chiCtx.URLParams.Add(k, v)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	return req.WithContext(context.WithValue(context.Background(), chi.RouteCtxKey, chiCtx))
+}
+
+func Test_caHandler_requireAdmin(t *testing.T) {
+	withCert := httptest.NewRequest("GET", "http://example.com/admin/provisioners/jwk/keys", nil)
+	withCert.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{}},
+	}
+	withToken := httptest.NewRequest("GET", "http://example.com/admin/provisioners/jwk/keys", nil)
+	withToken.Header.Set("Authorization", "Bearer the-token")
+	withBadHeader := httptest.NewRequest("GET", "http://example.com/admin/provisioners/jwk/keys", nil)
+	withBadHeader.Header.Set("Authorization", "Basic foo")
+	tests := []struct {
+		name       string
+		auth       Authority
+		req        *http.Request
+		statusCode int
+	}{
+		{"ok", &mockAuthority{}, withCert, 200},
+		{"ok/token", &mockAuthority{authorizeAdmin: func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+			assert.Nil(t, cert)
+			assert.Equals(t, "the-token", token)
+			assert.Equals(t, "", provisionerName)
+			return &authority.Admin{Subject: "admin@smallstep.com", Role: authority.AdminRoleSuperAdmin}, nil
+		}}, withToken, 200},
+		{"fail/no-tls", &mockAuthority{}, httptest.NewRequest("GET", "http://example.com/admin/provisioners/jwk/keys", nil), 401},
+		{"fail/authorization-header", &mockAuthority{}, withBadHeader, 400},
+		{"fail/not-admin", &mockAuthority{err: errs.Forbidden("force")}, withCert, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, tt.req)
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_requireProvisionerAdmin(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := newAdminRequest("GET", "http://example.com/admin/provisioners/jwk/keys", "", map[string]string{"name": "jwk"})
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "jane"}}},
+		}
+		return req
+	}
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{authorizeAdmin: func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+			assert.Equals(t, "jwk", provisionerName)
+			return &authority.Admin{Subject: "jane", Role: authority.AdminRoleProvisionerAdmin, Provisioners: []string{"jwk"}}, nil
+		}}, 200},
+		{"fail", &mockAuthority{authorizeAdmin: func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+			return nil, errs.Forbidden("force")
+		}}, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.requireProvisionerAdmin(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, newRequest())
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_requireAdmin_audit(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "http://example.com/admin/provisioners/jwk?version=1", nil)
+	req.Header.Set("Authorization", "Bearer the-token")
+	var events []*authority.AuditEvent
+	h := &caHandler{Authority: &mockAuthority{
+		authorizeAdmin: func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+			return &authority.Admin{Subject: "admin@smallstep.com", Role: authority.AdminRoleSuperAdmin}, nil
+		},
+		audit: func(e *authority.AuditEvent) {
+			events = append(events, e)
+		},
+	}}
+	h.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, errs.NotFound("force"))
+	})(httptest.NewRecorder(), req)
+	assert.Equals(t, []*authority.AuditEvent{{
+		Action:    authority.AuditAdmin,
+		Requester: "admin@smallstep.com",
+		Method:    "DELETE",
+		Path:      "/admin/provisioners/jwk",
+		Status:    404,
+	}}, events)
+}
+
+func generateAdminTestKey(t *testing.T) *jose.JSONWebKey {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "admin-test-key", 0)
+	assert.FatalError(t, err)
+	pub := jwk.Public()
+	return &pub
+}
+
+func Test_caHandler_ProvisionerKeys(t *testing.T) {
+	key := &provisioner.JWKKey{
+		Key:      generateAdminTestKey(t),
+		NotAfter: time.Unix(1600000000, 0).UTC(),
+	}
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{ret1: []*provisioner.JWKKey{key}}, 200},
+		{"fail", &mockAuthority{ret1: []*provisioner.JWKKey(nil), err: errs.NotFound("force")}, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.ProvisionerKeys(w, newAdminRequest("GET", "http://example.com/admin/provisioners/jwk/keys", "",
+				map[string]string{"name": "jwk"}))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var resp ProvisionerKeysResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&resp))
+				assert.Len(t, 1, resp.Keys)
+				assert.Equals(t, key.Key.KeyID, resp.Keys[0].Key.KeyID)
+				assert.Equals(t, key.NotAfter, resp.Keys[0].NotAfter)
+			}
+		})
+	}
+}
+
+func Test_caHandler_AddProvisionerKey(t *testing.T) {
+	pub := generateAdminTestKey(t)
+	b, err := json.Marshal(pub)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{addProvisionerKey: func(name string, key *provisioner.JWKKey) error {
+			assert.Equals(t, "jwk", name)
+			assert.Equals(t, pub.KeyID, key.Key.KeyID)
+			assert.Equals(t, time.Unix(1600000000, 0).UTC(), key.NotBefore)
+			return nil
+		}}, `{"key":` + string(b) + `,"notBefore":"2020-09-13T12:26:40Z"}`, 201},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/empty-key", &mockAuthority{}, `{}`, 400},
+		{"fail/authority", &mockAuthority{err: errs.BadRequest("force")}, `{"key":` + string(b) + `}`, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.AddProvisionerKey(w, newAdminRequest("POST", "http://example.com/admin/provisioners/jwk/keys", tt.body,
+				map[string]string{"name": "jwk"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_RetireProvisionerKey(t *testing.T) {
+	pub := generateAdminTestKey(t)
+	notAfter := time.Unix(1600000000, 0).UTC()
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{retireProvisionerKey: func(name, kid string, t2 time.Time) (*provisioner.JWKKey, error) {
+			assert.Equals(t, "jwk", name)
+			assert.Equals(t, pub.KeyID, kid)
+			assert.Equals(t, notAfter, t2)
+			return &provisioner.JWKKey{Key: pub, NotAfter: t2}, nil
+		}}, `{"notAfter":"2020-09-13T12:26:40Z"}`, 200},
+		{"ok/empty", &mockAuthority{retireProvisionerKey: func(name, kid string, t2 time.Time) (*provisioner.JWKKey, error) {
+			assert.True(t, t2.IsZero())
+			return &provisioner.JWKKey{Key: pub}, nil
+		}}, ``, 200},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*provisioner.JWKKey)(nil), err: errs.NotFound("force")}, `{}`, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.RetireProvisionerKey(w, newAdminRequest("POST", "http://example.com/admin/provisioners/jwk/keys/"+pub.KeyID+"/retire", tt.body,
+				map[string]string{"name": "jwk", "kid": pub.KeyID}))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var key provisioner.JWKKey
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&key))
+				assert.Equals(t, pub.KeyID, key.Key.KeyID)
+			}
+		})
+	}
+}
+
+func Test_caHandler_ProvisionerRecords(t *testing.T) {
+	record := &authority.ProvisionerRecord{Name: "acme", Version: 2, Provisioner: json.RawMessage(`{"type":"ACME","name":"acme"}`)}
+	h := &caHandler{Authority: &mockAuthority{ret1: []*authority.ProvisionerRecord{record}}}
+	w := httptest.NewRecorder()
+	h.ProvisionerRecords(w, newAdminRequest("GET", "http://example.com/admin/provisioners", "", nil))
+	res := w.Result()
+	assert.Equals(t, 200, res.StatusCode)
+	var resp ProvisionerRecordsResponse
+	assert.FatalError(t, json.NewDecoder(res.Body).Decode(&resp))
+	assert.Equals(t, []*authority.ProvisionerRecord{record}, resp.Provisioners)
+}
+
+func Test_caHandler_ProvisionerRecord(t *testing.T) {
+	record := &authority.ProvisionerRecord{Name: "acme", Version: 2, Provisioner: json.RawMessage(`{"type":"ACME","name":"acme"}`)}
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{getProvisionerRecord: func(name string) (*authority.ProvisionerRecord, error) {
+			assert.Equals(t, "acme", name)
+			return record, nil
+		}}, 200},
+		{"fail", &mockAuthority{ret1: (*authority.ProvisionerRecord)(nil), err: errs.NotFound("force")}, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.ProvisionerRecord(w, newAdminRequest("GET", "http://example.com/admin/provisioners/acme", "",
+				map[string]string{"name": "acme"}))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got authority.ProvisionerRecord
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, record, &got)
+			}
+		})
+	}
+}
+
+func Test_caHandler_CreateProvisioner(t *testing.T) {
+	body := `{"type":"ACME","name":"acme"}`
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{createProvisioner: func(data []byte) (*authority.ProvisionerRecord, error) {
+			assert.Equals(t, body, string(data))
+			return &authority.ProvisionerRecord{Name: "acme", Version: 1, Provisioner: data}, nil
+		}}, body, 201},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.ProvisionerRecord)(nil), err: errs.Errorf(http.StatusConflict, "force")}, body, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.CreateProvisioner(w, newAdminRequest("POST", "http://example.com/admin/provisioners", tt.body, nil))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_UpdateProvisioner(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{updateProvisioner: func(admin *authority.Admin, name string, version int, data []byte) (*authority.ProvisionerRecord, error) {
+			assert.Equals(t, "acme", name)
+			assert.Equals(t, 2, version)
+			assert.Equals(t, `{"type":"ACME","name":"acme"}`, string(data))
+			return &authority.ProvisionerRecord{Name: name, Version: version + 1, Provisioner: data}, nil
+		}}, `{"version":2,"provisioner":{"type":"ACME","name":"acme"}}`, 200},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/version", &mockAuthority{}, `{"provisioner":{"type":"ACME","name":"acme"}}`, 400},
+		{"fail/provisioner", &mockAuthority{}, `{"version":2}`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.ProvisionerRecord)(nil), err: errs.Errorf(http.StatusConflict, "force")}, `{"version":1,"provisioner":{}}`, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.UpdateProvisioner(w, newAdminRequest("PUT", "http://example.com/admin/provisioners/acme", tt.body,
+				map[string]string{"name": "acme"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_UpdateProvisioner_provisionerAdmin(t *testing.T) {
+	admin := &authority.Admin{Subject: "jane", Role: authority.AdminRoleProvisionerAdmin, Provisioners: []string{"acme"}}
+	h := &caHandler{Authority: &mockAuthority{
+		authorizeAdmin: func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+			assert.Equals(t, "acme", provisionerName)
+			return admin, nil
+		},
+		updateProvisioner: func(a *authority.Admin, name string, version int, data []byte) (*authority.ProvisionerRecord, error) {
+			assert.Equals(t, admin, a)
+			return nil, errs.Forbidden("only a super-admin can modify the claims of a provisioner")
+		},
+		audit: func(e *authority.AuditEvent) {},
+	}}
+	req := newAdminRequest("PUT", "http://example.com/admin/provisioners/acme", `{"version":2,"provisioner":{"type":"ACME","name":"acme","claims":{"maxTLSCertDuration":"8760h"}}}`,
+		map[string]string{"name": "acme"})
+	req.Header.Set("Authorization", "Bearer the-token")
+	w := httptest.NewRecorder()
+	h.requireProvisionerAdmin(h.UpdateProvisioner)(w, req)
+	assert.Equals(t, http.StatusForbidden, w.Result().StatusCode)
+}
+
+func Test_caHandler_DeleteProvisioner(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		query      string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{deleteProvisioner: func(name string, version int) error {
+			assert.Equals(t, "acme", name)
+			assert.Equals(t, 3, version)
+			return nil
+		}}, "?version=3", 204},
+		{"fail/missing", &mockAuthority{}, "", 400},
+		{"fail/version", &mockAuthority{}, "?version=foo", 400},
+		{"fail/authority", &mockAuthority{err: errs.NotFound("force")}, "?version=1", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.DeleteProvisioner(w, newAdminRequest("DELETE", "http://example.com/admin/provisioners/acme"+tt.query, "",
+				map[string]string{"name": "acme"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_SetProvisionerSwitches(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{setProvisionerSwitches: func(name string, version int, switches authority.ProvisionerSwitches) (*authority.ProvisionerRecord, error) {
+			assert.Equals(t, "acme", name)
+			assert.Equals(t, 2, version)
+			assert.True(t, *switches.DisableIssuance)
+			assert.Nil(t, switches.DisableRenewal)
+			return &authority.ProvisionerRecord{Name: name, Version: version + 1}, nil
+		}}, `{"version":2,"disableIssuance":true}`, 200},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/version", &mockAuthority{}, `{"disableIssuance":true}`, 400},
+		{"fail/switches", &mockAuthority{}, `{"version":2}`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.ProvisionerRecord)(nil), err: errs.Errorf(http.StatusConflict, "force")}, `{"version":1,"disableRenewal":false}`, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.SetProvisionerSwitches(w, newAdminRequest("PUT", "http://example.com/admin/provisioners/acme/switches", tt.body,
+				map[string]string{"name": "acme"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_AdminRecords(t *testing.T) {
+	record := &authority.AdminRecord{Subject: "jane@smallstep.com", Role: authority.AdminRoleSuperAdmin, Version: 1}
+	h := &caHandler{Authority: &mockAuthority{ret1: []*authority.AdminRecord{record}}}
+	w := httptest.NewRecorder()
+	h.AdminRecords(w, newAdminRequest("GET", "http://example.com/admin/admins", "", nil))
+	res := w.Result()
+	assert.Equals(t, 200, res.StatusCode)
+	var resp AdminRecordsResponse
+	assert.FatalError(t, json.NewDecoder(res.Body).Decode(&resp))
+	assert.Equals(t, []*authority.AdminRecord{record}, resp.Admins)
+}
+
+func Test_caHandler_AdminRecord(t *testing.T) {
+	record := &authority.AdminRecord{Subject: "jane@smallstep.com", Role: authority.AdminRoleSuperAdmin, Version: 1}
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{getAdminRecord: func(subject string) (*authority.AdminRecord, error) {
+			assert.Equals(t, "jane@smallstep.com", subject)
+			return record, nil
+		}}, 200},
+		{"fail", &mockAuthority{ret1: (*authority.AdminRecord)(nil), err: errs.NotFound("force")}, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.AdminRecord(w, newAdminRequest("GET", "http://example.com/admin/admins/jane@smallstep.com", "",
+				map[string]string{"subject": "jane@smallstep.com"}))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got authority.AdminRecord
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, record, &got)
+			}
+		})
+	}
+}
+
+func Test_caHandler_CreateAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{createAdmin: func(subject, role string, provisioners []string) (*authority.AdminRecord, error) {
+			assert.Equals(t, "jane@smallstep.com", subject)
+			assert.Equals(t, authority.AdminRoleProvisionerAdmin, role)
+			assert.Equals(t, []string{"acme"}, provisioners)
+			return &authority.AdminRecord{Subject: subject, Role: role, Provisioners: provisioners, Version: 1}, nil
+		}}, `{"subject":"jane@smallstep.com","role":"provisioner-admin","provisioners":["acme"]}`, 201},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/subject", &mockAuthority{}, `{"role":"super-admin"}`, 400},
+		{"fail/role", &mockAuthority{}, `{"subject":"jane@smallstep.com"}`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.AdminRecord)(nil), err: errs.Errorf(http.StatusConflict, "force")}, `{"subject":"jane@smallstep.com","role":"super-admin"}`, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.CreateAdmin(w, newAdminRequest("POST", "http://example.com/admin/admins", tt.body, nil))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_UpdateAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{updateAdmin: func(subject string, version int, role string, provisioners []string) (*authority.AdminRecord, error) {
+			assert.Equals(t, "jane@smallstep.com", subject)
+			assert.Equals(t, 2, version)
+			assert.Equals(t, authority.AdminRoleSuperAdmin, role)
+			assert.Len(t, 0, provisioners)
+			return &authority.AdminRecord{Subject: subject, Role: role, Version: version + 1}, nil
+		}}, `{"version":2,"role":"super-admin"}`, 200},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/version", &mockAuthority{}, `{"role":"super-admin"}`, 400},
+		{"fail/role", &mockAuthority{}, `{"version":2}`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.AdminRecord)(nil), err: errs.Errorf(http.StatusConflict, "force")}, `{"version":1,"role":"super-admin"}`, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.UpdateAdmin(w, newAdminRequest("PUT", "http://example.com/admin/admins/jane@smallstep.com", tt.body,
+				map[string]string{"subject": "jane@smallstep.com"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_DeleteAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		query      string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{deleteAdmin: func(subject string, version int) error {
+			assert.Equals(t, "jane@smallstep.com", subject)
+			assert.Equals(t, 3, version)
+			return nil
+		}}, "?version=3", 204},
+		{"fail/missing", &mockAuthority{}, "", 400},
+		{"fail/version", &mockAuthority{}, "?version=foo", 400},
+		{"fail/authority", &mockAuthority{err: errs.NotFound("force")}, "?version=1", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.DeleteAdmin(w, newAdminRequest("DELETE", "http://example.com/admin/admins/jane@smallstep.com"+tt.query, "",
+				map[string]string{"subject": "jane@smallstep.com"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_Policy(t *testing.T) {
+	record := &authority.PolicyRecord{Version: 1, Policy: &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509Names{DNS: []string{".smallstep.com"}}},
+	}}
+	h := &caHandler{Authority: &mockAuthority{ret1: record}}
+	w := httptest.NewRecorder()
+	h.Policy(w, newAdminRequest("GET", "http://example.com/admin/policy", "", nil))
+	res := w.Result()
+	assert.Equals(t, 200, res.StatusCode)
+	var got authority.PolicyRecord
+	assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+	assert.Equals(t, record, &got)
+}
+
+func Test_caHandler_UpdatePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{updatePolicy: func(version int, opts *policy.Options) (*authority.PolicyRecord, error) {
+			assert.Equals(t, 0, version)
+			assert.Equals(t, []string{".smallstep.com"}, opts.X509.Allow.DNS)
+			return &authority.PolicyRecord{Version: 1, Policy: opts}, nil
+		}}, `{"version":0,"policy":{"x509":{"allow":{"dns":[".smallstep.com"]}}}}`, 200},
+		{"ok/remove", &mockAuthority{updatePolicy: func(version int, opts *policy.Options) (*authority.PolicyRecord, error) {
+			assert.Equals(t, 1, version)
+			assert.Nil(t, opts)
+			return &authority.PolicyRecord{Version: 2}, nil
+		}}, `{"version":1}`, 200},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/version", &mockAuthority{}, `{"version":-1}`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.PolicyRecord)(nil), err: errs.Errorf(http.StatusConflict, "force")}, `{"version":1}`, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.UpdatePolicy(w, newAdminRequest("PUT", "http://example.com/admin/policy", tt.body, nil))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
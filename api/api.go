@@ -11,6 +11,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -19,7 +20,9 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/cli/crypto/tlsutil"
@@ -35,13 +38,56 @@ type Authority interface {
 	Root(shasum string) (*x509.Certificate, error)
 	Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
 	Renew(peer *x509.Certificate) ([]*x509.Certificate, error)
+	AuthorizeRenewToken(ctx context.Context, ott string) (*x509.Certificate, error)
 	LoadProvisionerByCertificate(*x509.Certificate) (provisioner.Interface, error)
 	LoadProvisionerByID(string) (provisioner.Interface, error)
 	GetProvisioners(cursor string, limit int) (provisioner.List, string, error)
 	Revoke(context.Context, *authority.RevokeOptions) error
+	ReleaseHold(serial string, admin *authority.Admin) error
+	GetCertificateRevocationList() ([]byte, error)
+	GetOCSPResponse(req []byte) ([]byte, error)
 	GetEncryptedKey(kid string) (string, error)
 	GetRoots() (federation []*x509.Certificate, err error)
 	GetFederation() ([]*x509.Certificate, error)
+	GetKeyStoreProvisioners() []provisioner.KeyStoreProvisioner
+	AuthorizeAdmin(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error)
+	GetAdminRecords() []*authority.AdminRecord
+	GetAdminRecord(subject string) (*authority.AdminRecord, error)
+	CreateAdmin(subject, role string, provisioners []string) (*authority.AdminRecord, error)
+	UpdateAdmin(subject string, version int, role string, provisioners []string) (*authority.AdminRecord, error)
+	DeleteAdmin(subject string, version int) error
+	GetPolicyRecord() *authority.PolicyRecord
+	UpdatePolicy(version int, opts *policy.Options) (*authority.PolicyRecord, error)
+	GetProvisionerKeys(name string) ([]*provisioner.JWKKey, error)
+	AddProvisionerKey(name string, key *provisioner.JWKKey) error
+	RetireProvisionerKey(name, kid string, notAfter time.Time) (*provisioner.JWKKey, error)
+	GetProvisionerRecords() []*authority.ProvisionerRecord
+	GetProvisionerRecord(name string) (*authority.ProvisionerRecord, error)
+	CreateProvisioner(data []byte) (*authority.ProvisionerRecord, error)
+	UpdateProvisioner(admin *authority.Admin, name string, version int, data []byte) (*authority.ProvisionerRecord, error)
+	DeleteProvisioner(name string, version int) error
+	SetProvisionerSwitches(name string, version int, switches authority.ProvisionerSwitches) (*authority.ProvisionerRecord, error)
+	RequestSubCA(ctx context.Context, csr *x509.CertificateRequest, ott string) (*authority.SubCARequest, error)
+	GetSubCARequest(id string) (*authority.SubCARequest, error)
+	GetSubCARequests(status authority.SubCAStatus) ([]*authority.SubCARequest, error)
+	ApproveSubCARequest(id string) (*authority.SubCARequest, error)
+	RejectSubCARequest(id string) (*authority.SubCARequest, error)
+	GetRootRotation() (*authority.RootRotation, error)
+	StartRootRotation(opts *authority.RootRotationOptions) (*authority.RootRotation, error)
+	CancelRootRotation() (*authority.RootRotation, error)
+	GetIntermediateCSR() (*x509.CertificateRequest, error)
+	RenewIntermediate(opts *authority.IntermediateRenewalOptions) (*x509.Certificate, error)
+	ImportIntermediate(crt *x509.Certificate) error
+	RevokeBatch(opts *authority.RevokeBatchOptions) (*authority.RevocationBatch, error)
+	GetRevocationBatch(id string) (*authority.RevocationBatch, error)
+	GetRevocationBatches() ([]*authority.RevocationBatch, error)
+	GetShortLivedSummaries() ([]*authority.ShortLivedSummary, error)
+	ListCertificates(f *authority.CertificateFilter) (*authority.CertificateList, error)
+	GetExpirationReport(window time.Duration) (*authority.ExpirationReport, error)
+	Backup(w io.Writer) (*db.BackupManifest, error)
+	VerifyBackup(r io.Reader) (*db.BackupManifest, error)
+	CreateReceipt(cert *x509.Certificate, token string) (string, error)
+	Audit(e *authority.AuditEvent)
 	Version() authority.Version
 }
 
@@ -203,6 +249,41 @@ type HealthResponse struct {
 	Status string `json:"status"`
 }
 
+// ProvisionersHealthResponse is the response object that returns the health of
+// the keys used by the provisioners to validate tokens. Status is "ok" if the
+// keys of all the provisioners are fresh, and "degraded" otherwise.
+type ProvisionersHealthResponse struct {
+	Status       string              `json:"status"`
+	Provisioners []ProvisionerHealth `json:"provisioners"`
+}
+
+// ProvisionerHealth is the status of the keys used by a provisioner.
+type ProvisionerHealth struct {
+	Name   string                     `json:"name"`
+	Type   string                     `json:"type"`
+	Status provisioner.KeyStoreStatus `json:"status"`
+}
+
+// ProvisionersHealthDetailsResponse is the response object of the admin API
+// that returns the health of the keys used by the provisioners, including
+// where they are loaded from and the last error.
+type ProvisionersHealthDetailsResponse struct {
+	Status       string                     `json:"status"`
+	Provisioners []ProvisionerHealthDetails `json:"provisioners"`
+}
+
+// ProvisionerHealthDetails is the health of the keys used by a provisioner.
+type ProvisionerHealthDetails struct {
+	Name       string                     `json:"name"`
+	Type       string                     `json:"type"`
+	URI        string                     `json:"uri"`
+	Status     provisioner.KeyStoreStatus `json:"status"`
+	Keys       int                        `json:"keys"`
+	LastReload *time.Time                 `json:"lastReload,omitempty"`
+	Expiry     time.Time                  `json:"expiry"`
+	Error      string                     `json:"error,omitempty"`
+}
+
 // RootResponse is the response object that returns the PEM of a root certificate.
 type RootResponse struct {
 	RootPEM Certificate `json:"ca"`
@@ -246,18 +327,62 @@ func New(authority Authority) RouterHandler {
 func (h *caHandler) Route(r Router) {
 	r.MethodFunc("GET", "/version", h.Version)
 	r.MethodFunc("GET", "/health", h.Health)
+	r.MethodFunc("GET", "/health/provisioners", h.ProvisionersHealth)
 	r.MethodFunc("GET", "/root/{sha}", h.Root)
 	r.MethodFunc("POST", "/sign", h.Sign)
 	r.MethodFunc("POST", "/renew", h.Renew)
 	r.MethodFunc("POST", "/revoke", h.Revoke)
+	r.MethodFunc("GET", "/crl", h.CRL)
+	r.MethodFunc("GET", "/ocsp/*", h.OCSP)
+	r.MethodFunc("POST", "/ocsp", h.OCSP)
 	r.MethodFunc("GET", "/provisioners", h.Provisioners)
 	r.MethodFunc("GET", "/provisioners/{kid}/encrypted-key", h.ProvisionerKey)
 	r.MethodFunc("GET", "/roots", h.Roots)
 	r.MethodFunc("GET", "/federation", h.Federation)
+	r.MethodFunc("GET", "/roots/rotation", h.RootRotation)
+	r.MethodFunc("GET", "/spiffe/bundle", h.SPIFFEBundle)
+	r.MethodFunc("POST", "/subca", h.RequestSubCA)
+	r.MethodFunc("GET", "/subca/{id}", h.SubCARequest)
+	// Admin API
+	r.MethodFunc("GET", "/admin/provisioners", h.requireAdmin(h.ProvisionerRecords))
+	r.MethodFunc("GET", "/admin/health/provisioners", h.requireAdmin(h.ProvisionersHealthDetails))
+	r.MethodFunc("POST", "/admin/provisioners", h.requireAdmin(h.CreateProvisioner))
+	r.MethodFunc("GET", "/admin/provisioners/{name}", h.requireProvisionerAdmin(h.ProvisionerRecord))
+	r.MethodFunc("PUT", "/admin/provisioners/{name}", h.requireProvisionerAdmin(h.UpdateProvisioner))
+	r.MethodFunc("DELETE", "/admin/provisioners/{name}", h.requireAdmin(h.DeleteProvisioner))
+	r.MethodFunc("PUT", "/admin/provisioners/{name}/switches", h.requireProvisionerAdmin(h.SetProvisionerSwitches))
+	r.MethodFunc("GET", "/admin/provisioners/{name}/keys", h.requireProvisionerAdmin(h.ProvisionerKeys))
+	r.MethodFunc("POST", "/admin/provisioners/{name}/keys", h.requireProvisionerAdmin(h.AddProvisionerKey))
+	r.MethodFunc("POST", "/admin/provisioners/{name}/keys/{kid}/retire", h.requireProvisionerAdmin(h.RetireProvisionerKey))
+	r.MethodFunc("GET", "/admin/admins", h.requireAdmin(h.AdminRecords))
+	r.MethodFunc("POST", "/admin/admins", h.requireAdmin(h.CreateAdmin))
+	r.MethodFunc("GET", "/admin/admins/{subject}", h.requireAdmin(h.AdminRecord))
+	r.MethodFunc("PUT", "/admin/admins/{subject}", h.requireAdmin(h.UpdateAdmin))
+	r.MethodFunc("DELETE", "/admin/admins/{subject}", h.requireAdmin(h.DeleteAdmin))
+	r.MethodFunc("GET", "/admin/policy", h.requireAdmin(h.Policy))
+	r.MethodFunc("PUT", "/admin/policy", h.requireAdmin(h.UpdatePolicy))
+	r.MethodFunc("GET", "/admin/subca", h.requireAdmin(h.SubCARequests))
+	r.MethodFunc("POST", "/admin/subca/{id}/approve", h.requireAdmin(h.ApproveSubCARequest))
+	r.MethodFunc("POST", "/admin/subca/{id}/reject", h.requireAdmin(h.RejectSubCARequest))
+	r.MethodFunc("POST", "/admin/roots/rotation", h.requireAdmin(h.StartRootRotation))
+	r.MethodFunc("DELETE", "/admin/roots/rotation", h.requireAdmin(h.CancelRootRotation))
+	r.MethodFunc("GET", "/admin/intermediate/csr", h.requireAdmin(h.IntermediateCSR))
+	r.MethodFunc("POST", "/admin/intermediate/renew", h.requireAdmin(h.RenewIntermediate))
+	r.MethodFunc("PUT", "/admin/intermediate", h.requireAdmin(h.ImportIntermediate))
+	r.MethodFunc("GET", "/admin/revocations", h.requireAdmin(h.RevocationBatches))
+	r.MethodFunc("POST", "/admin/revocations", h.requireAdmin(h.RevokeBatch))
+	r.MethodFunc("GET", "/admin/revocations/{id}", h.requireAdmin(h.RevocationBatch))
+	r.MethodFunc("DELETE", "/admin/holds/{serial}", h.requireAdmin(h.ReleaseHold))
+	r.MethodFunc("GET", "/admin/short-lived", h.requireAdmin(h.ShortLivedSummaries))
+	r.MethodFunc("GET", "/admin/certificates", h.requireAdmin(h.Certificates))
+	r.MethodFunc("GET", "/admin/certificates/expiring", h.requireAdmin(h.ExpirationReport))
+	r.MethodFunc("GET", "/admin/backup", h.requireAdmin(h.Backup))
+	r.MethodFunc("POST", "/admin/backup/verify", h.requireAdmin(h.VerifyBackup))
 	// SSH CA
 	r.MethodFunc("POST", "/ssh/sign", h.SSHSign)
 	r.MethodFunc("POST", "/ssh/renew", h.SSHRenew)
 	r.MethodFunc("POST", "/ssh/revoke", h.SSHRevoke)
+	r.MethodFunc("GET", "/ssh/krl", h.SSHKRL)
 	r.MethodFunc("POST", "/ssh/rekey", h.SSHRekey)
 	r.MethodFunc("GET", "/ssh/roots", h.SSHRoots)
 	r.MethodFunc("GET", "/ssh/federation", h.SSHFederation)
@@ -287,6 +412,59 @@ func (h *caHandler) Health(w http.ResponseWriter, r *http.Request) {
 	JSON(w, HealthResponse{Status: "ok"})
 }
 
+// ProvisionersHealth is an HTTP handler that returns the status of the keys
+// used by the provisioners to validate tokens. The endpoint is public, the
+// details are only available to administrators in ProvisionersHealthDetails.
+func (h *caHandler) ProvisionersHealth(w http.ResponseWriter, r *http.Request) {
+	details := h.provisionersHealth()
+	resp := ProvisionersHealthResponse{
+		Status:       details.Status,
+		Provisioners: make([]ProvisionerHealth, len(details.Provisioners)),
+	}
+	for i, ph := range details.Provisioners {
+		resp.Provisioners[i] = ProvisionerHealth{Name: ph.Name, Type: ph.Type, Status: ph.Status}
+	}
+	JSON(w, resp)
+}
+
+// ProvisionersHealthDetails is the admin API resource that returns the health
+// of the keys used by the provisioners to validate tokens, with the uri of the
+// keys and the last error.
+func (h *caHandler) ProvisionersHealthDetails(w http.ResponseWriter, r *http.Request) {
+	JSON(w, h.provisionersHealth())
+}
+
+// provisionersHealth returns the health of the keys used by the provisioners.
+func (h *caHandler) provisionersHealth() ProvisionersHealthDetailsResponse {
+	now := time.Now()
+	resp := ProvisionersHealthDetailsResponse{
+		Status:       "ok",
+		Provisioners: []ProvisionerHealthDetails{},
+	}
+	for _, p := range h.Authority.GetKeyStoreProvisioners() {
+		stats := p.GetKeyStoreStats()
+		ph := ProvisionerHealthDetails{
+			Name:   p.GetName(),
+			Type:   p.GetType().String(),
+			URI:    stats.URI,
+			Status: stats.Status(now),
+			Keys:   stats.Keys,
+			Expiry: stats.Expiry,
+		}
+		if !stats.LastReload.IsZero() {
+			ph.LastReload = &stats.LastReload
+		}
+		if stats.Err != nil {
+			ph.Error = stats.Err.Error()
+		}
+		if ph.Status != provisioner.KeyStoreFresh {
+			resp.Status = "degraded"
+		}
+		resp.Provisioners = append(resp.Provisioners, ph)
+	}
+	return resp
+}
+
 // Root is an HTTP handler that using the SHA256 from the URL, returns the root
 // certificate for the given SHA256.
 func (h *caHandler) Root(w http.ResponseWriter, r *http.Request) {
@@ -397,6 +575,7 @@ func logCertificate(w http.ResponseWriter, cert *x509.Certificate) {
 		m := map[string]interface{}{
 			"serial":      cert.SerialNumber,
 			"subject":     cert.Subject.CommonName,
+			"sans":        certificateSANs(cert),
 			"issuer":      cert.Issuer.CommonName,
 			"valid-from":  cert.NotBefore.Format(time.RFC3339),
 			"valid-to":    cert.NotAfter.Format(time.RFC3339),
@@ -418,6 +597,20 @@ func logCertificate(w http.ResponseWriter, cert *x509.Certificate) {
 	}
 }
 
+// certificateSANs returns the DNS names, IP addresses, email addresses and
+// URIs in the certificate.
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
 func parseCursor(r *http.Request) (cursor string, limit int, err error) {
 	q := r.URL.Query()
 	cursor = q.Get("cursor")
@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net/http"
@@ -27,7 +28,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/certificates/sshutil"
@@ -441,7 +444,7 @@ type mockProvisioner struct {
 	authorizeRenewal   func(*x509.Certificate) error
 	authorizeSSHSign   func(ctx context.Context, token string) ([]provisioner.SignOption, error)
 	authorizeSSHRevoke func(ctx context.Context, token string) error
-	authorizeSSHRenew  func(ctx context.Context, token string) (*ssh.Certificate, error)
+	authorizeSSHRenew  func(ctx context.Context, token string) (*ssh.Certificate, []provisioner.SignOption, error)
 	authorizeSSHRekey  func(ctx context.Context, token string) (*ssh.Certificate, []provisioner.SignOption, error)
 }
 
@@ -530,11 +533,11 @@ func (m *mockProvisioner) AuthorizeSSHRevoke(ctx context.Context, token string)
 	}
 	return m.err
 }
-func (m *mockProvisioner) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, error) {
+func (m *mockProvisioner) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, []provisioner.SignOption, error) {
 	if m.authorizeSSHRenew != nil {
 		return m.authorizeSSHRenew(ctx, token)
 	}
-	return m.ret1.(*ssh.Certificate), m.err
+	return m.ret1.(*ssh.Certificate), m.ret2.([]provisioner.SignOption), m.err
 }
 func (m *mockProvisioner) AuthorizeSSHRekey(ctx context.Context, token string) (*ssh.Certificate, []provisioner.SignOption, error) {
 	if m.authorizeSSHRekey != nil {
@@ -551,23 +554,68 @@ type mockAuthority struct {
 	root                         func(shasum string) (*x509.Certificate, error)
 	sign                         func(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
 	renew                        func(cert *x509.Certificate) ([]*x509.Certificate, error)
+	authorizeRenewToken          func(ctx context.Context, ott string) (*x509.Certificate, error)
 	loadProvisionerByCertificate func(cert *x509.Certificate) (provisioner.Interface, error)
 	loadProvisionerByID          func(provID string) (provisioner.Interface, error)
 	getProvisioners              func(nextCursor string, limit int) (provisioner.List, string, error)
 	revoke                       func(context.Context, *authority.RevokeOptions) error
+	releaseHold                  func(serial string, admin *authority.Admin) error
+	getCertificateRevocationList func() ([]byte, error)
+	getOCSPResponse              func(req []byte) ([]byte, error)
 	getEncryptedKey              func(kid string) (string, error)
 	getRoots                     func() ([]*x509.Certificate, error)
 	getFederation                func() ([]*x509.Certificate, error)
 	signSSH                      func(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	signSSHAddUser               func(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
-	renewSSH                     func(ctx context.Context, cert *ssh.Certificate) (*ssh.Certificate, error)
+	renewSSH                     func(ctx context.Context, cert *ssh.Certificate, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	rekeySSH                     func(ctx context.Context, cert *ssh.Certificate, key ssh.PublicKey, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	getSSHHosts                  func(ctx context.Context, cert *x509.Certificate) ([]sshutil.Host, error)
 	getSSHRoots                  func(ctx context.Context) (*authority.SSHKeys, error)
 	getSSHFederation             func(ctx context.Context) (*authority.SSHKeys, error)
+	getSSHKRL                    func(ctx context.Context) ([]byte, error)
+	authorizeSSHKRL              func(ctx context.Context, cert *x509.Certificate, token string) error
 	getSSHConfig                 func(ctx context.Context, typ string, data map[string]string) ([]templates.Output, error)
 	checkSSHHost                 func(ctx context.Context, principal, token string) (bool, error)
 	getSSHBastion                func(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	getKeyStoreProvisioners      func() []provisioner.KeyStoreProvisioner
+	authorizeAdmin               func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error)
+	getAdminRecords              func() []*authority.AdminRecord
+	getAdminRecord               func(subject string) (*authority.AdminRecord, error)
+	createAdmin                  func(subject, role string, provisioners []string) (*authority.AdminRecord, error)
+	updateAdmin                  func(subject string, version int, role string, provisioners []string) (*authority.AdminRecord, error)
+	deleteAdmin                  func(subject string, version int) error
+	getPolicyRecord              func() *authority.PolicyRecord
+	updatePolicy                 func(version int, opts *policy.Options) (*authority.PolicyRecord, error)
+	getProvisionerKeys           func(name string) ([]*provisioner.JWKKey, error)
+	addProvisionerKey            func(name string, key *provisioner.JWKKey) error
+	retireProvisionerKey         func(name, kid string, notAfter time.Time) (*provisioner.JWKKey, error)
+	getProvisionerRecords        func() []*authority.ProvisionerRecord
+	getProvisionerRecord         func(name string) (*authority.ProvisionerRecord, error)
+	createProvisioner            func(data []byte) (*authority.ProvisionerRecord, error)
+	updateProvisioner            func(admin *authority.Admin, name string, version int, data []byte) (*authority.ProvisionerRecord, error)
+	deleteProvisioner            func(name string, version int) error
+	setProvisionerSwitches       func(name string, version int, switches authority.ProvisionerSwitches) (*authority.ProvisionerRecord, error)
+	requestSubCA                 func(ctx context.Context, csr *x509.CertificateRequest, ott string) (*authority.SubCARequest, error)
+	getSubCARequest              func(id string) (*authority.SubCARequest, error)
+	getSubCARequests             func(status authority.SubCAStatus) ([]*authority.SubCARequest, error)
+	approveSubCARequest          func(id string) (*authority.SubCARequest, error)
+	rejectSubCARequest           func(id string) (*authority.SubCARequest, error)
+	getRootRotation              func() (*authority.RootRotation, error)
+	startRootRotation            func(opts *authority.RootRotationOptions) (*authority.RootRotation, error)
+	cancelRootRotation           func() (*authority.RootRotation, error)
+	getIntermediateCSR           func() (*x509.CertificateRequest, error)
+	renewIntermediate            func(opts *authority.IntermediateRenewalOptions) (*x509.Certificate, error)
+	importIntermediate           func(crt *x509.Certificate) error
+	revokeBatch                  func(opts *authority.RevokeBatchOptions) (*authority.RevocationBatch, error)
+	getRevocationBatch           func(id string) (*authority.RevocationBatch, error)
+	getRevocationBatches         func() ([]*authority.RevocationBatch, error)
+	getShortLivedSummaries       func() ([]*authority.ShortLivedSummary, error)
+	listCertificates             func(f *authority.CertificateFilter) (*authority.CertificateList, error)
+	getExpirationReport          func(window time.Duration) (*authority.ExpirationReport, error)
+	backup                       func(w io.Writer) (*db.BackupManifest, error)
+	verifyBackup                 func(r io.Reader) (*db.BackupManifest, error)
+	createReceipt                func(cert *x509.Certificate, token string) (string, error)
+	audit                        func(e *authority.AuditEvent)
 	version                      func() authority.Version
 }
 
@@ -611,6 +659,13 @@ func (m *mockAuthority) Renew(cert *x509.Certificate) ([]*x509.Certificate, erro
 	return []*x509.Certificate{m.ret1.(*x509.Certificate), m.ret2.(*x509.Certificate)}, m.err
 }
 
+func (m *mockAuthority) AuthorizeRenewToken(ctx context.Context, ott string) (*x509.Certificate, error) {
+	if m.authorizeRenewToken != nil {
+		return m.authorizeRenewToken(ctx, ott)
+	}
+	return m.ret1.(*x509.Certificate), m.err
+}
+
 func (m *mockAuthority) GetProvisioners(nextCursor string, limit int) (provisioner.List, string, error) {
 	if m.getProvisioners != nil {
 		return m.getProvisioners(nextCursor, limit)
@@ -639,6 +694,34 @@ func (m *mockAuthority) Revoke(ctx context.Context, opts *authority.RevokeOption
 	return m.err
 }
 
+func (m *mockAuthority) ReleaseHold(serial string, admin *authority.Admin) error {
+	if m.releaseHold != nil {
+		return m.releaseHold(serial, admin)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) GetCertificateRevocationList() ([]byte, error) {
+	if m.getCertificateRevocationList != nil {
+		return m.getCertificateRevocationList()
+	}
+	return m.ret1.([]byte), m.err
+}
+
+func (m *mockAuthority) AuthorizeSSHKRL(ctx context.Context, cert *x509.Certificate, token string) error {
+	if m.authorizeSSHKRL != nil {
+		return m.authorizeSSHKRL(ctx, cert, token)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) GetOCSPResponse(req []byte) ([]byte, error) {
+	if m.getOCSPResponse != nil {
+		return m.getOCSPResponse(req)
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAuthority) GetEncryptedKey(kid string) (string, error) {
 	if m.getEncryptedKey != nil {
 		return m.getEncryptedKey(kid)
@@ -674,9 +757,9 @@ func (m *mockAuthority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, c
 	return m.ret1.(*ssh.Certificate), m.err
 }
 
-func (m *mockAuthority) RenewSSH(ctx context.Context, cert *ssh.Certificate) (*ssh.Certificate, error) {
+func (m *mockAuthority) RenewSSH(ctx context.Context, cert *ssh.Certificate, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	if m.renewSSH != nil {
-		return m.renewSSH(ctx, cert)
+		return m.renewSSH(ctx, cert, signOpts...)
 	}
 	return m.ret1.(*ssh.Certificate), m.err
 }
@@ -702,6 +785,13 @@ func (m *mockAuthority) GetSSHRoots(ctx context.Context) (*authority.SSHKeys, er
 	return m.ret1.(*authority.SSHKeys), m.err
 }
 
+func (m *mockAuthority) GetSSHKRL(ctx context.Context) ([]byte, error) {
+	if m.getSSHKRL != nil {
+		return m.getSSHKRL(ctx)
+	}
+	return m.ret1.([]byte), m.err
+}
+
 func (m *mockAuthority) GetSSHFederation(ctx context.Context) (*authority.SSHKeys, error) {
 	if m.getSSHFederation != nil {
 		return m.getSSHFederation(ctx)
@@ -730,6 +820,281 @@ func (m *mockAuthority) GetSSHBastion(ctx context.Context, user string, hostname
 	return m.ret1.(*authority.Bastion), m.err
 }
 
+func (m *mockAuthority) GetKeyStoreProvisioners() []provisioner.KeyStoreProvisioner {
+	if m.getKeyStoreProvisioners != nil {
+		return m.getKeyStoreProvisioners()
+	}
+	return m.ret1.([]provisioner.KeyStoreProvisioner)
+}
+
+func (m *mockAuthority) AuthorizeAdmin(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+	if m.authorizeAdmin != nil {
+		return m.authorizeAdmin(ctx, cert, token, provisionerName)
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &authority.Admin{Subject: "admin", Role: authority.AdminRoleSuperAdmin}, nil
+}
+
+func (m *mockAuthority) GetAdminRecords() []*authority.AdminRecord {
+	if m.getAdminRecords != nil {
+		return m.getAdminRecords()
+	}
+	return m.ret1.([]*authority.AdminRecord)
+}
+
+func (m *mockAuthority) GetAdminRecord(subject string) (*authority.AdminRecord, error) {
+	if m.getAdminRecord != nil {
+		return m.getAdminRecord(subject)
+	}
+	return m.ret1.(*authority.AdminRecord), m.err
+}
+
+func (m *mockAuthority) CreateAdmin(subject, role string, provisioners []string) (*authority.AdminRecord, error) {
+	if m.createAdmin != nil {
+		return m.createAdmin(subject, role, provisioners)
+	}
+	return m.ret1.(*authority.AdminRecord), m.err
+}
+
+func (m *mockAuthority) UpdateAdmin(subject string, version int, role string, provisioners []string) (*authority.AdminRecord, error) {
+	if m.updateAdmin != nil {
+		return m.updateAdmin(subject, version, role, provisioners)
+	}
+	return m.ret1.(*authority.AdminRecord), m.err
+}
+
+func (m *mockAuthority) DeleteAdmin(subject string, version int) error {
+	if m.deleteAdmin != nil {
+		return m.deleteAdmin(subject, version)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) GetPolicyRecord() *authority.PolicyRecord {
+	if m.getPolicyRecord != nil {
+		return m.getPolicyRecord()
+	}
+	return m.ret1.(*authority.PolicyRecord)
+}
+
+func (m *mockAuthority) UpdatePolicy(version int, opts *policy.Options) (*authority.PolicyRecord, error) {
+	if m.updatePolicy != nil {
+		return m.updatePolicy(version, opts)
+	}
+	return m.ret1.(*authority.PolicyRecord), m.err
+}
+
+func (m *mockAuthority) GetProvisionerKeys(name string) ([]*provisioner.JWKKey, error) {
+	if m.getProvisionerKeys != nil {
+		return m.getProvisionerKeys(name)
+	}
+	return m.ret1.([]*provisioner.JWKKey), m.err
+}
+
+func (m *mockAuthority) AddProvisionerKey(name string, key *provisioner.JWKKey) error {
+	if m.addProvisionerKey != nil {
+		return m.addProvisionerKey(name, key)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) RetireProvisionerKey(name, kid string, notAfter time.Time) (*provisioner.JWKKey, error) {
+	if m.retireProvisionerKey != nil {
+		return m.retireProvisionerKey(name, kid, notAfter)
+	}
+	return m.ret1.(*provisioner.JWKKey), m.err
+}
+
+func (m *mockAuthority) GetProvisionerRecords() []*authority.ProvisionerRecord {
+	if m.getProvisionerRecords != nil {
+		return m.getProvisionerRecords()
+	}
+	return m.ret1.([]*authority.ProvisionerRecord)
+}
+
+func (m *mockAuthority) GetProvisionerRecord(name string) (*authority.ProvisionerRecord, error) {
+	if m.getProvisionerRecord != nil {
+		return m.getProvisionerRecord(name)
+	}
+	return m.ret1.(*authority.ProvisionerRecord), m.err
+}
+
+func (m *mockAuthority) CreateProvisioner(data []byte) (*authority.ProvisionerRecord, error) {
+	if m.createProvisioner != nil {
+		return m.createProvisioner(data)
+	}
+	return m.ret1.(*authority.ProvisionerRecord), m.err
+}
+
+func (m *mockAuthority) UpdateProvisioner(admin *authority.Admin, name string, version int, data []byte) (*authority.ProvisionerRecord, error) {
+	if m.updateProvisioner != nil {
+		return m.updateProvisioner(admin, name, version, data)
+	}
+	return m.ret1.(*authority.ProvisionerRecord), m.err
+}
+
+func (m *mockAuthority) DeleteProvisioner(name string, version int) error {
+	if m.deleteProvisioner != nil {
+		return m.deleteProvisioner(name, version)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) SetProvisionerSwitches(name string, version int, switches authority.ProvisionerSwitches) (*authority.ProvisionerRecord, error) {
+	if m.setProvisionerSwitches != nil {
+		return m.setProvisionerSwitches(name, version, switches)
+	}
+	return m.ret1.(*authority.ProvisionerRecord), m.err
+}
+
+func (m *mockAuthority) RequestSubCA(ctx context.Context, csr *x509.CertificateRequest, ott string) (*authority.SubCARequest, error) {
+	if m.requestSubCA != nil {
+		return m.requestSubCA(ctx, csr, ott)
+	}
+	return m.ret1.(*authority.SubCARequest), m.err
+}
+
+func (m *mockAuthority) GetSubCARequest(id string) (*authority.SubCARequest, error) {
+	if m.getSubCARequest != nil {
+		return m.getSubCARequest(id)
+	}
+	return m.ret1.(*authority.SubCARequest), m.err
+}
+
+func (m *mockAuthority) GetSubCARequests(status authority.SubCAStatus) ([]*authority.SubCARequest, error) {
+	if m.getSubCARequests != nil {
+		return m.getSubCARequests(status)
+	}
+	return m.ret1.([]*authority.SubCARequest), m.err
+}
+
+func (m *mockAuthority) ApproveSubCARequest(id string) (*authority.SubCARequest, error) {
+	if m.approveSubCARequest != nil {
+		return m.approveSubCARequest(id)
+	}
+	return m.ret1.(*authority.SubCARequest), m.err
+}
+
+func (m *mockAuthority) RejectSubCARequest(id string) (*authority.SubCARequest, error) {
+	if m.rejectSubCARequest != nil {
+		return m.rejectSubCARequest(id)
+	}
+	return m.ret1.(*authority.SubCARequest), m.err
+}
+
+func (m *mockAuthority) GetRootRotation() (*authority.RootRotation, error) {
+	if m.getRootRotation != nil {
+		return m.getRootRotation()
+	}
+	return m.ret1.(*authority.RootRotation), m.err
+}
+
+func (m *mockAuthority) StartRootRotation(opts *authority.RootRotationOptions) (*authority.RootRotation, error) {
+	if m.startRootRotation != nil {
+		return m.startRootRotation(opts)
+	}
+	return m.ret1.(*authority.RootRotation), m.err
+}
+
+func (m *mockAuthority) CancelRootRotation() (*authority.RootRotation, error) {
+	if m.cancelRootRotation != nil {
+		return m.cancelRootRotation()
+	}
+	return m.ret1.(*authority.RootRotation), m.err
+}
+
+func (m *mockAuthority) GetIntermediateCSR() (*x509.CertificateRequest, error) {
+	if m.getIntermediateCSR != nil {
+		return m.getIntermediateCSR()
+	}
+	return m.ret1.(*x509.CertificateRequest), m.err
+}
+
+func (m *mockAuthority) RenewIntermediate(opts *authority.IntermediateRenewalOptions) (*x509.Certificate, error) {
+	if m.renewIntermediate != nil {
+		return m.renewIntermediate(opts)
+	}
+	return m.ret1.(*x509.Certificate), m.err
+}
+
+func (m *mockAuthority) ImportIntermediate(crt *x509.Certificate) error {
+	if m.importIntermediate != nil {
+		return m.importIntermediate(crt)
+	}
+	return m.err
+}
+
+func (m *mockAuthority) RevokeBatch(opts *authority.RevokeBatchOptions) (*authority.RevocationBatch, error) {
+	if m.revokeBatch != nil {
+		return m.revokeBatch(opts)
+	}
+	return m.ret1.(*authority.RevocationBatch), m.err
+}
+
+func (m *mockAuthority) GetRevocationBatch(id string) (*authority.RevocationBatch, error) {
+	if m.getRevocationBatch != nil {
+		return m.getRevocationBatch(id)
+	}
+	return m.ret1.(*authority.RevocationBatch), m.err
+}
+
+func (m *mockAuthority) GetRevocationBatches() ([]*authority.RevocationBatch, error) {
+	if m.getRevocationBatches != nil {
+		return m.getRevocationBatches()
+	}
+	return m.ret1.([]*authority.RevocationBatch), m.err
+}
+
+func (m *mockAuthority) GetShortLivedSummaries() ([]*authority.ShortLivedSummary, error) {
+	if m.getShortLivedSummaries != nil {
+		return m.getShortLivedSummaries()
+	}
+	return m.ret1.([]*authority.ShortLivedSummary), m.err
+}
+
+func (m *mockAuthority) ListCertificates(f *authority.CertificateFilter) (*authority.CertificateList, error) {
+	if m.listCertificates != nil {
+		return m.listCertificates(f)
+	}
+	return m.ret1.(*authority.CertificateList), m.err
+}
+
+func (m *mockAuthority) GetExpirationReport(window time.Duration) (*authority.ExpirationReport, error) {
+	if m.getExpirationReport != nil {
+		return m.getExpirationReport(window)
+	}
+	return m.ret1.(*authority.ExpirationReport), m.err
+}
+
+func (m *mockAuthority) Backup(w io.Writer) (*db.BackupManifest, error) {
+	if m.backup != nil {
+		return m.backup(w)
+	}
+	return m.ret1.(*db.BackupManifest), m.err
+}
+
+func (m *mockAuthority) VerifyBackup(r io.Reader) (*db.BackupManifest, error) {
+	if m.verifyBackup != nil {
+		return m.verifyBackup(r)
+	}
+	return m.ret1.(*db.BackupManifest), m.err
+}
+
+func (m *mockAuthority) CreateReceipt(cert *x509.Certificate, token string) (string, error) {
+	if m.createReceipt != nil {
+		return m.createReceipt(cert, token)
+	}
+	return "", nil
+}
+
+func (m *mockAuthority) Audit(e *authority.AuditEvent) {
+	if m.audit != nil {
+		m.audit(e)
+	}
+}
+
 func (m *mockAuthority) Version() authority.Version {
 	if m.version != nil {
 		return m.version()
@@ -783,6 +1148,106 @@ func Test_caHandler_Health(t *testing.T) {
 	}
 }
 
+type mockKeyStoreProvisioner struct {
+	provisioner.Interface
+	stats provisioner.KeyStoreStats
+}
+
+func (m *mockKeyStoreProvisioner) GetKeyStoreStats() provisioner.KeyStoreStats {
+	return m.stats
+}
+
+func Test_caHandler_ProvisionersHealth(t *testing.T) {
+	lastReload := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	fresh := &mockKeyStoreProvisioner{
+		Interface: &provisioner.OIDC{Name: "fresh", Type: "OIDC"},
+		stats:     provisioner.KeyStoreStats{URI: "https://example.com/jwks", Keys: 2, LastReload: lastReload, Expiry: expiry},
+	}
+	stale := &mockKeyStoreProvisioner{
+		Interface: &provisioner.GCP{Name: "stale", Type: "GCP"},
+		stats:     provisioner.KeyStoreStats{URI: "https://example.com/certs", Keys: 2, LastReload: lastReload, Expiry: expiry, Err: errors.New("an error")},
+	}
+	failed := &mockKeyStoreProvisioner{
+		Interface: &provisioner.Azure{Name: "failed", Type: "Azure"},
+		stats:     provisioner.KeyStoreStats{URI: "https://example.com/keys", Expiry: expiry, Err: errors.New("an error")},
+	}
+
+	tests := []struct {
+		name         string
+		provisioners []provisioner.KeyStoreProvisioner
+		want         ProvisionersHealthDetailsResponse
+	}{
+		{"empty", nil, ProvisionersHealthDetailsResponse{Status: "ok", Provisioners: []ProvisionerHealthDetails{}}},
+		{"ok", []provisioner.KeyStoreProvisioner{fresh}, ProvisionersHealthDetailsResponse{Status: "ok", Provisioners: []ProvisionerHealthDetails{
+			{Name: "fresh", Type: "OIDC", URI: "https://example.com/jwks", Status: provisioner.KeyStoreFresh, Keys: 2, LastReload: &lastReload, Expiry: expiry},
+		}}},
+		{"degraded", []provisioner.KeyStoreProvisioner{fresh, stale, failed}, ProvisionersHealthDetailsResponse{Status: "degraded", Provisioners: []ProvisionerHealthDetails{
+			{Name: "fresh", Type: "OIDC", URI: "https://example.com/jwks", Status: provisioner.KeyStoreFresh, Keys: 2, LastReload: &lastReload, Expiry: expiry},
+			{Name: "stale", Type: "GCP", URI: "https://example.com/certs", Status: provisioner.KeyStoreStale, Keys: 2, LastReload: &lastReload, Expiry: expiry, Error: "an error"},
+			{Name: "failed", Type: "Azure", URI: "https://example.com/keys", Status: provisioner.KeyStoreFailed, Expiry: expiry, Error: "an error"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{ret1: tt.provisioners}).(*caHandler)
+
+			// The public endpoint only returns the status
+			req := httptest.NewRequest("GET", "http://example.com/health/provisioners", nil)
+			w := httptest.NewRecorder()
+			h.ProvisionersHealth(w, req)
+			res := w.Result()
+			if res.StatusCode != 200 {
+				t.Errorf("caHandler.ProvisionersHealth StatusCode = %d, wants 200", res.StatusCode)
+			}
+			var got map[string]interface{}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("caHandler.ProvisionersHealth unexpected error = %v", err)
+			}
+			res.Body.Close()
+			want := map[string]interface{}{"status": tt.want.Status, "provisioners": []interface{}{}}
+			for _, ph := range tt.want.Provisioners {
+				want["provisioners"] = append(want["provisioners"].([]interface{}), map[string]interface{}{
+					"name": ph.Name, "type": ph.Type, "status": string(ph.Status),
+				})
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("caHandler.ProvisionersHealth Body = %+v, wants %+v", got, want)
+			}
+
+			// The admin endpoint returns the details
+			req = httptest.NewRequest("GET", "http://example.com/admin/health/provisioners", nil)
+			w = httptest.NewRecorder()
+			h.ProvisionersHealthDetails(w, req)
+			res = w.Result()
+			if res.StatusCode != 200 {
+				t.Errorf("caHandler.ProvisionersHealthDetails StatusCode = %d, wants 200", res.StatusCode)
+			}
+			var details ProvisionersHealthDetailsResponse
+			if err := json.NewDecoder(res.Body).Decode(&details); err != nil {
+				t.Fatalf("caHandler.ProvisionersHealthDetails unexpected error = %v", err)
+			}
+			res.Body.Close()
+			if !reflect.DeepEqual(details, tt.want) {
+				t.Errorf("caHandler.ProvisionersHealthDetails Body = %+v, wants %+v", details, tt.want)
+			}
+		})
+	}
+}
+
+func Test_caHandler_ProvisionersHealthDetails_requireAdmin(t *testing.T) {
+	r := chi.NewRouter()
+	New(&mockAuthority{ret1: []provisioner.KeyStoreProvisioner{}}).Route(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/admin/health/provisioners", nil))
+	assert.Equals(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/health/provisioners", nil))
+	assert.Equals(t, http.StatusOK, w.Result().StatusCode)
+}
+
 func Test_caHandler_Root(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -900,6 +1365,51 @@ func Test_caHandler_Sign(t *testing.T) {
 	}
 }
 
+func Test_caHandler_Sign_receipt(t *testing.T) {
+	body, err := json.Marshal(SignRequest{
+		CsrPEM: CertificateRequest{parseCertificateRequest(csrPEM)},
+		OTT:    "foobarzar",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		receiptErr error
+		statusCode int
+	}{
+		{"ok", nil, http.StatusCreated},
+		{"fail", errs.InternalServer("force"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				ret1: parseCertificate(certPEM), ret2: parseCertificate(rootPEM),
+				authorizeSign: func(ott string) ([]provisioner.SignOption, error) {
+					return nil, nil
+				},
+				getTLSOptions: func() *tlsutil.TLSOptions {
+					return nil
+				},
+				createReceipt: func(cert *x509.Certificate, token string) (string, error) {
+					assert.Equals(t, parseCertificate(certPEM), cert)
+					assert.Equals(t, "foobarzar", token)
+					return "the.receipt", tt.receiptErr
+				},
+			}).(*caHandler)
+			w := httptest.NewRecorder()
+			h.Sign(logging.NewResponseLogger(w), httptest.NewRequest("POST", "http://example.com/sign", bytes.NewReader(body)))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusCreated {
+				var sr SignResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&sr))
+				assert.Equals(t, "the.receipt", sr.Receipt)
+			}
+		})
+	}
+}
+
 func Test_caHandler_Renew(t *testing.T) {
 	cs := &tls.ConnectionState{
 		PeerCertificates: []*x509.Certificate{parseCertificate(certPEM)},
@@ -907,15 +1417,18 @@ func Test_caHandler_Renew(t *testing.T) {
 	tests := []struct {
 		name       string
 		tls        *tls.ConnectionState
+		header     string
 		cert       *x509.Certificate
 		root       *x509.Certificate
 		err        error
 		statusCode int
 	}{
-		{"ok", cs, parseCertificate(certPEM), parseCertificate(rootPEM), nil, http.StatusCreated},
-		{"no tls", nil, nil, nil, nil, http.StatusBadRequest},
-		{"no peer certificates", &tls.ConnectionState{}, nil, nil, nil, http.StatusBadRequest},
-		{"renew error", cs, nil, nil, errs.Forbidden("an error"), http.StatusForbidden},
+		{"ok", cs, "", parseCertificate(certPEM), parseCertificate(rootPEM), nil, http.StatusCreated},
+		{"ok token", nil, "Bearer token", parseCertificate(certPEM), parseCertificate(rootPEM), nil, http.StatusCreated},
+		{"no tls", nil, "", nil, nil, nil, http.StatusBadRequest},
+		{"no peer certificates", &tls.ConnectionState{}, "", nil, nil, nil, http.StatusBadRequest},
+		{"invalid authorization header", nil, "Basic token", nil, nil, nil, http.StatusBadRequest},
+		{"renew error", cs, "", nil, nil, errs.Forbidden("an error"), http.StatusForbidden},
 	}
 
 	expected := []byte(`{"crt":"` + strings.Replace(certPEM, "\n", `\n`, -1) + `\n","ca":"` + strings.Replace(rootPEM, "\n", `\n`, -1) + `\n","certChain":["` + strings.Replace(certPEM, "\n", `\n`, -1) + `\n","` + strings.Replace(rootPEM, "\n", `\n`, -1) + `\n"]}`)
@@ -924,12 +1437,21 @@ func Test_caHandler_Renew(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			h := New(&mockAuthority{
 				ret1: tt.cert, ret2: tt.root, err: tt.err,
+				authorizeRenewToken: func(ctx context.Context, ott string) (*x509.Certificate, error) {
+					if ott != "token" {
+						return nil, errs.Unauthorized("invalid token")
+					}
+					return parseCertificate(certPEM), nil
+				},
 				getTLSOptions: func() *tlsutil.TLSOptions {
 					return nil
 				},
 			}).(*caHandler)
 			req := httptest.NewRequest("POST", "http://example.com/renew", nil)
 			req.TLS = tt.tls
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
 			w := httptest.NewRecorder()
 			h.Renew(logging.NewResponseLogger(w), req)
 			res := w.Result()
@@ -952,6 +1474,20 @@ func Test_caHandler_Renew(t *testing.T) {
 	}
 }
 
+func Test_logRenewAfterExpiry(t *testing.T) {
+	valid := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	w := logging.NewResponseLogger(httptest.NewRecorder())
+	logRenewAfterExpiry(w, valid)
+	assert.Nil(t, w.Fields()["renew-after-expiry"])
+
+	expired := &x509.Certificate{SerialNumber: big.NewInt(2), NotAfter: time.Now().Add(-time.Hour)}
+	w = logging.NewResponseLogger(httptest.NewRecorder())
+	logRenewAfterExpiry(w, expired)
+	assert.Equals(t, true, w.Fields()["renew-after-expiry"])
+	assert.Equals(t, expired.SerialNumber, w.Fields()["expired-serial"])
+	assert.Equals(t, expired.NotAfter.Format(time.RFC3339), w.Fields()["expired-at"])
+}
+
 func Test_caHandler_Provisioners(t *testing.T) {
 	type fields struct {
 		Authority Authority
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// backupResponseWriter writes the headers of the backup before the first
+// write, so errors before the backup starts can still be written as JSON.
+type backupResponseWriter struct {
+	http.ResponseWriter
+	filename string
+	started  bool
+}
+
+func (w *backupResponseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.filename))
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Backup is the admin API resource that streams a gzipped backup of the
+// database. The manifest with the checksum is written at the end, so a backup
+// interrupted by an error fails the verification.
+func (h *caHandler) Backup(w http.ResponseWriter, r *http.Request) {
+	bw := &backupResponseWriter{
+		ResponseWriter: w,
+		filename:       "step-ca-" + time.Now().UTC().Format("20060102T150405Z") + ".backup.gz",
+	}
+	if _, err := h.Authority.Backup(bw); err != nil {
+		if bw.started {
+			LogError(w, errors.Wrap(err, "backup interrupted"))
+			return
+		}
+		WriteError(w, err)
+	}
+}
+
+// VerifyBackup is the admin API resource that verifies the backup in the body
+// of the request and returns its manifest.
+func (h *caHandler) VerifyBackup(w http.ResponseWriter, r *http.Request) {
+	m, err := h.Authority.VerifyBackup(r.Body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, m)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_Backup(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       *mockAuthority
+		statusCode int
+		body       string
+	}{
+		{"ok", &mockAuthority{backup: func(w io.Writer) (*db.BackupManifest, error) {
+			_, err := w.Write([]byte("backup"))
+			return &db.BackupManifest{}, err
+		}}, 200, "backup"},
+		{"fail/before", &mockAuthority{backup: func(w io.Writer) (*db.BackupManifest, error) {
+			return nil, errs.NotImplemented("force")
+		}}, 501, `{"status":501,"message":"The requested method is not implemented by the certificate authority. Please see the certificate authority logs for more info."}`},
+		{"fail/after", &mockAuthority{backup: func(w io.Writer) (*db.BackupManifest, error) {
+			w.Write([]byte("back"))
+			return nil, errors.New("force")
+		}}, 200, "back"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(tt.auth).(*caHandler)
+			w := httptest.NewRecorder()
+			h.Backup(w, httptest.NewRequest("GET", "http://example.com/admin/backup", nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			body, err := ioutil.ReadAll(res.Body)
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.body, strings.TrimSpace(string(body)))
+			if tt.statusCode == 200 {
+				assert.Equals(t, "application/gzip", res.Header.Get("Content-Type"))
+				assert.HasPrefix(t, res.Header.Get("Content-Disposition"), `attachment; filename="step-ca-`)
+			} else {
+				assert.Equals(t, "", res.Header.Get("Content-Disposition"))
+			}
+		})
+	}
+}
+
+func Test_caHandler_VerifyBackup(t *testing.T) {
+	manifest := &db.BackupManifest{Version: 1, Tables: map[string]int{"x509_certs": 2}, Checksum: "abc"}
+	tests := []struct {
+		name       string
+		auth       *mockAuthority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{verifyBackup: func(r io.Reader) (*db.BackupManifest, error) {
+			b, err := ioutil.ReadAll(r)
+			assert.FatalError(t, err)
+			assert.Equals(t, "backup", string(b))
+			return manifest, nil
+		}}, 200},
+		{"fail", &mockAuthority{ret1: (*db.BackupManifest)(nil), err: errs.BadRequest("force")}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(tt.auth).(*caHandler)
+			w := httptest.NewRecorder()
+			h.VerifyBackup(w, httptest.NewRequest("POST", "http://example.com/admin/backup/verify", strings.NewReader("backup")))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got db.BackupManifest
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, manifest, &got)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+// Certificates is the admin API resource that lists the issued certificates
+// that match the filter in the query string, newest first. The time parameters
+// use the RFC 3339 format and the nextCursor in the response is used as the
+// cursor parameter to get the next page.
+func (h *caHandler) Certificates(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseCertificateFilter(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	list, err := h.Authority.ListCertificates(filter)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, list)
+}
+
+// ExpirationReport is the admin API resource that lists the certificates that
+// expire in the window in the query string, e.g. 72h, and have not been
+// renewed. The configured window is used if it's not set.
+func (h *caHandler) ExpirationReport(w http.ResponseWriter, r *http.Request) {
+	var window time.Duration
+	if v := r.URL.Query().Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+			WriteError(w, errs.BadRequest("window must be a positive duration"))
+			return
+		}
+	}
+	report, err := h.Authority.GetExpirationReport(window)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, report)
+}
+
+func parseCertificateFilter(r *http.Request) (*authority.CertificateFilter, error) {
+	cursor, limit, err := parseCursor(r)
+	if err != nil {
+		return nil, errs.BadRequestErr(err)
+	}
+	q := r.URL.Query()
+	f := &authority.CertificateFilter{
+		SAN:         q.Get("san"),
+		CommonName:  q.Get("commonName"),
+		Serial:      q.Get("serial"),
+		Provisioner: q.Get("provisioner"),
+		Cursor:      cursor,
+		Limit:       limit,
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"issuedAfter", &f.IssuedAfter},
+		{"issuedBefore", &f.IssuedBefore},
+		{"expiresAfter", &f.ExpiresAfter},
+		{"expiresBefore", &f.ExpiresBefore},
+	} {
+		if *p.t, err = parseQueryTime(q, p.name); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func parseQueryTime(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errs.BadRequest("%s must be a time in RFC 3339 format", name)
+	}
+	return t, nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_Certificates(t *testing.T) {
+	list := &authority.CertificateList{
+		Certificates: []*authority.CertificateInfo{{Serial: "1234", SANs: []string{"foo.example.com"}}},
+		NextCursor:   "cursor",
+	}
+	issuedAfter := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresBefore := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		query      string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", "", &mockAuthority{ret1: list}, 200},
+		{"ok/filter", "?san=*.example.com&commonName=foo&serial=1234&provisioner=acme&issuedAfter=2020-01-01T00:00:00Z&expiresBefore=2020-02-01T00:00:00Z&cursor=abc&limit=10", &mockAuthority{
+			listCertificates: func(f *authority.CertificateFilter) (*authority.CertificateList, error) {
+				assert.Equals(t, &authority.CertificateFilter{
+					SAN:           "*.example.com",
+					CommonName:    "foo",
+					Serial:        "1234",
+					Provisioner:   "acme",
+					IssuedAfter:   issuedAfter,
+					ExpiresBefore: expiresBefore,
+					Cursor:        "abc",
+					Limit:         10,
+				}, f)
+				return list, nil
+			},
+		}, 200},
+		{"fail/limit", "?limit=foo", &mockAuthority{}, 400},
+		{"fail/time", "?issuedBefore=yesterday", &mockAuthority{}, 400},
+		{"fail/authority", "", &mockAuthority{ret1: (*authority.CertificateList)(nil), err: errs.NotImplemented("force")}, 501},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.Certificates(w, httptest.NewRequest("GET", "http://example.com/admin/certificates"+tt.query, nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got authority.CertificateList
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, list, &got)
+			}
+		})
+	}
+}
+
+func Test_caHandler_ExpirationReport(t *testing.T) {
+	report := &authority.ExpirationReport{
+		GeneratedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresBefore: time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC),
+		Certificates:  []*authority.CertificateInfo{{Serial: "1234", SANs: []string{"foo.example.com"}}},
+	}
+	tests := []struct {
+		name       string
+		query      string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", "", &mockAuthority{
+			getExpirationReport: func(window time.Duration) (*authority.ExpirationReport, error) {
+				assert.Equals(t, time.Duration(0), window)
+				return report, nil
+			},
+		}, 200},
+		{"ok/window", "?window=72h", &mockAuthority{
+			getExpirationReport: func(window time.Duration) (*authority.ExpirationReport, error) {
+				assert.Equals(t, 72*time.Hour, window)
+				return report, nil
+			},
+		}, 200},
+		{"fail/window", "?window=3d", &mockAuthority{}, 400},
+		{"fail/negative-window", "?window=-1h", &mockAuthority{}, 400},
+		{"fail/authority", "", &mockAuthority{ret1: (*authority.ExpirationReport)(nil), err: errs.NotImplemented("force")}, 501},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.ExpirationReport(w, httptest.NewRequest("GET", "http://example.com/admin/certificates/expiring"+tt.query, nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got authority.ExpirationReport
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, report, &got)
+			}
+		})
+	}
+}
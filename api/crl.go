@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+)
+
+// CRL is an HTTP handler that returns the certificate revocation list of the
+// CA in DER format.
+func (h *caHandler) CRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := h.Authority.GetCertificateRevocationList()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// IntermediateRenewalRequest is the request body used in the admin API to
+// renew the intermediate certificate with the key of the root.
+type IntermediateRenewalRequest struct {
+	RootKey  string                `json:"rootKey"`
+	Validity *provisioner.Duration `json:"validity,omitempty"`
+}
+
+// Validate validates an intermediate renewal request body.
+func (r *IntermediateRenewalRequest) Validate() error {
+	switch {
+	case r.RootKey == "":
+		return errs.BadRequest("rootKey cannot be empty")
+	case r.Validity != nil && r.Validity.Duration <= 0:
+		return errs.BadRequest("validity must be greater than 0")
+	default:
+		return nil
+	}
+}
+
+// IntermediateImportRequest is the request body used in the admin API to
+// replace the intermediate with a certificate signed offline by the root.
+type IntermediateImportRequest struct {
+	CertificatePEM Certificate `json:"crt"`
+}
+
+// Validate validates an intermediate import request body.
+func (r *IntermediateImportRequest) Validate() error {
+	if r.CertificatePEM.Certificate == nil {
+		return errs.BadRequest("crt cannot be empty")
+	}
+	return nil
+}
+
+// IntermediateResponse is the response object of the intermediate renewal
+// and import requests.
+type IntermediateResponse struct {
+	CertificatePEM Certificate `json:"crt"`
+}
+
+// IntermediateCSRResponse is the response object with the certificate request
+// of the intermediate.
+type IntermediateCSRResponse struct {
+	CSR CertificateRequest `json:"csr"`
+}
+
+// IntermediateCSR is the admin API resource that returns a certificate request
+// for the current intermediate, to be signed offline by the root.
+func (h *caHandler) IntermediateCSR(w http.ResponseWriter, r *http.Request) {
+	csr, err := h.Authority.GetIntermediateCSR()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &IntermediateCSRResponse{CSR: NewCertificateRequest(csr)})
+}
+
+// RenewIntermediate is the admin API resource that renews the intermediate
+// certificate with the key of the root.
+func (h *caHandler) RenewIntermediate(w http.ResponseWriter, r *http.Request) {
+	var body IntermediateRenewalRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	opts := &authority.IntermediateRenewalOptions{
+		RootKey: body.RootKey,
+	}
+	if body.Validity != nil {
+		opts.Validity = body.Validity.Duration
+	}
+	crt, err := h.Authority.RenewIntermediate(opts)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, &IntermediateResponse{CertificatePEM: NewCertificate(crt)}, http.StatusCreated)
+}
+
+// ImportIntermediate is the admin API resource that replaces the intermediate
+// certificate with one signed offline by the root.
+func (h *caHandler) ImportIntermediate(w http.ResponseWriter, r *http.Request) {
+	var body IntermediateImportRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := h.Authority.ImportIntermediate(body.CertificatePEM.Certificate); err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &IntermediateResponse{CertificatePEM: body.CertificatePEM})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_IntermediateCSR(t *testing.T) {
+	csr := parseCertificateRequest(csrPEM)
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{ret1: csr}, http.StatusOK},
+		{"fail", &mockAuthority{ret1: (*x509.CertificateRequest)(nil), err: errs.InternalServer("force")}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.IntermediateCSR(w, httptest.NewRequest("GET", "http://example.com/admin/intermediate/csr", nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				var got IntermediateCSRResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, csr.Raw, got.CSR.Raw)
+			}
+		})
+	}
+}
+
+func Test_caHandler_RenewIntermediate(t *testing.T) {
+	cert := parseCertificate(certPEM)
+	tests := []struct {
+		name       string
+		body       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", `{"rootKey":"root_ca_key","validity":"8760h"}`,
+			&mockAuthority{renewIntermediate: func(opts *authority.IntermediateRenewalOptions) (*x509.Certificate, error) {
+				assert.Equals(t, "root_ca_key", opts.RootKey)
+				assert.Equals(t, 8760*time.Hour, opts.Validity)
+				return cert, nil
+			}}, http.StatusCreated},
+		{"ok/defaults", `{"rootKey":"root_ca_key"}`,
+			&mockAuthority{renewIntermediate: func(opts *authority.IntermediateRenewalOptions) (*x509.Certificate, error) {
+				assert.Equals(t, time.Duration(0), opts.Validity)
+				return cert, nil
+			}}, http.StatusCreated},
+		{"fail/body", "{", &mockAuthority{}, http.StatusBadRequest},
+		{"fail/rootKey", `{}`, &mockAuthority{}, http.StatusBadRequest},
+		{"fail/validity", `{"rootKey":"root_ca_key","validity":"-1h"}`, &mockAuthority{}, http.StatusBadRequest},
+		{"fail/authority", `{"rootKey":"root_ca_key"}`, &mockAuthority{ret1: (*x509.Certificate)(nil), err: errs.BadRequest("force")}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.RenewIntermediate(w, httptest.NewRequest("POST", "http://example.com/admin/intermediate/renew", strings.NewReader(tt.body)))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusCreated {
+				var got IntermediateResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, cert.Raw, got.CertificatePEM.Raw)
+			}
+		})
+	}
+}
+
+func Test_caHandler_ImportIntermediate(t *testing.T) {
+	cert := parseCertificate(certPEM)
+	body, err := json.Marshal(&IntermediateImportRequest{CertificatePEM: NewCertificate(cert)})
+	assert.FatalError(t, err)
+	tests := []struct {
+		name       string
+		body       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", string(body), &mockAuthority{importIntermediate: func(crt *x509.Certificate) error {
+			assert.Equals(t, cert.Raw, crt.Raw)
+			return nil
+		}}, http.StatusOK},
+		{"fail/body", "{", &mockAuthority{}, http.StatusBadRequest},
+		{"fail/crt", `{}`, &mockAuthority{}, http.StatusBadRequest},
+		{"fail/authority", string(body), &mockAuthority{err: errs.Errorf(http.StatusConflict, "force")}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.ImportIntermediate(w, httptest.NewRequest("PUT", "http://example.com/admin/intermediate", strings.NewReader(tt.body)))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
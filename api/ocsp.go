@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/logging"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPRequestSize is the maximum size of the OCSP requests.
+const maxOCSPRequestSize = 10 * 1024
+
+// OCSP is an HTTP handler that returns the OCSP response for the request in
+// the body of a POST or base64 encoded in the path of a GET as defined in
+// RFC 6960, Appendix A.
+func (h *caHandler) OCSP(w http.ResponseWriter, r *http.Request) {
+	var req []byte
+	var err error
+	if r.Method == http.MethodGet {
+		var s string
+		if s, err = url.PathUnescape(chi.URLParam(r, "*")); err == nil {
+			req, err = base64.StdEncoding.DecodeString(s)
+		}
+	} else {
+		req, err = ioutil.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	}
+
+	var resp []byte
+	if err != nil {
+		resp = ocsp.MalformedRequestErrorResponse
+	} else if resp, err = h.Authority.GetOCSPResponse(req); err != nil {
+		if rl, ok := w.(logging.ResponseLogger); ok {
+			rl.WithFields(map[string]interface{}{
+				"error": err,
+			})
+		}
+		resp = ocsp.InternalErrorErrorResponse
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
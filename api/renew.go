@@ -1,24 +1,35 @@
 package api
 
 import (
+	"crypto/x509"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
 )
 
 // Renew uses the information of certificate in the TLS connection to create a
-// new one.
+// new one. Clients that cannot use mTLS, e.g. because their certificate has
+// expired, can send a renew token in the Authorization header instead.
 func (h *caHandler) Renew(w http.ResponseWriter, r *http.Request) {
-	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
-		WriteError(w, errs.BadRequest("missing peer certificate"))
+	cert, err := h.getPeerCertificate(r)
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	certChain, err := h.Authority.Renew(r.TLS.PeerCertificates[0])
+	certChain, err := h.Authority.Renew(cert)
 	if err != nil {
 		WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "cahandler.Renew"))
 		return
 	}
+	receipt, err := h.Authority.CreateReceipt(certChain[0], "")
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	certChainPEM := certChainToPEM(certChain)
 	var caPEM Certificate
 	if len(certChainPEM) > 1 {
@@ -26,10 +37,40 @@ func (h *caHandler) Renew(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logCertificate(w, certChain[0])
+	logRenewAfterExpiry(w, cert)
 	JSONStatus(w, &SignResponse{
 		ServerPEM:    certChainPEM[0],
 		CaPEM:        caPEM,
 		CertChainPEM: certChainPEM,
 		TLSOptions:   h.Authority.GetTLSOptions(),
+		Receipt:      receipt,
 	}, http.StatusCreated)
 }
+
+// getPeerCertificate returns the certificate to renew, the peer certificate in
+// the TLS connection or the one in the renew token in the Authorization
+// header.
+func (h *caHandler) getPeerCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0], nil
+	}
+	if s := r.Header.Get("Authorization"); s != "" {
+		parts := strings.SplitN(s, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			return nil, errs.BadRequest("invalid authorization header")
+		}
+		return h.Authority.AuthorizeRenewToken(r.Context(), strings.TrimSpace(parts[1]))
+	}
+	return nil, errs.BadRequest("missing peer certificate")
+}
+
+// logRenewAfterExpiry marks in the logs the renewals of expired certificates.
+func logRenewAfterExpiry(w http.ResponseWriter, cert *x509.Certificate) {
+	if rl, ok := w.(logging.ResponseLogger); ok && time.Now().After(cert.NotAfter) {
+		rl.WithFields(map[string]interface{}{
+			"renew-after-expiry": true,
+			"expired-serial":     cert.SerialNumber,
+			"expired-at":         cert.NotAfter.Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+// RevocationBatchRequest is the request body used in the admin API to revoke
+// all the certificates matching the criteria. With DryRun the certificates
+// are only listed.
+type RevocationBatchRequest struct {
+	authority.RevocationCriteria
+	ReasonCode int    `json:"reasonCode"`
+	Reason     string `json:"reason"`
+	DryRun     bool   `json:"dryRun"`
+}
+
+// Validate validates a revocation batch request body.
+func (r *RevocationBatchRequest) Validate() error {
+	return r.RevocationCriteria.Validate()
+}
+
+// RevocationBatchesResponse is the response object of the admin API that
+// lists the revocation batches.
+type RevocationBatchesResponse struct {
+	Batches []*authority.RevocationBatch `json:"batches"`
+}
+
+// RevokeBatch is the admin API resource that revokes all the certificates
+// matching the criteria in the request body and records the batch.
+func (h *caHandler) RevokeBatch(w http.ResponseWriter, r *http.Request) {
+	var body RevocationBatchRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	opts := &authority.RevokeBatchOptions{
+		Criteria:   body.RevocationCriteria,
+		ReasonCode: body.ReasonCode,
+		Reason:     body.Reason,
+		DryRun:     body.DryRun,
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		opts.Admin = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	batch, err := h.Authority.RevokeBatch(opts)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if body.DryRun {
+		JSON(w, batch)
+		return
+	}
+	JSONStatus(w, batch, http.StatusCreated)
+}
+
+// RevocationBatch is the admin API resource that returns the audit record of
+// a revocation batch.
+func (h *caHandler) RevocationBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := h.Authority.GetRevocationBatch(chi.URLParam(r, "id"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, batch)
+}
+
+// RevocationBatches is the admin API resource that lists the audit records of
+// the revocation batches.
+func (h *caHandler) RevocationBatches(w http.ResponseWriter, r *http.Request) {
+	batches, err := h.Authority.GetRevocationBatches()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &RevocationBatchesResponse{Batches: batches})
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_RevokeBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		body       string
+		statusCode int
+	}{
+		{"ok", &mockAuthority{revokeBatch: func(opts *authority.RevokeBatchOptions) (*authority.RevocationBatch, error) {
+			assert.Equals(t, "acme", opts.Criteria.Provisioner)
+			assert.Equals(t, "*.old.example.com", opts.Criteria.SAN)
+			assert.Equals(t, 4, opts.ReasonCode)
+			assert.Equals(t, "admin@example.com", opts.Admin)
+			assert.False(t, opts.DryRun)
+			return &authority.RevocationBatch{ID: "foo", Serials: []string{"1234"}}, nil
+		}}, `{"provisioner":"acme","san":"*.old.example.com","reasonCode":4}`, 201},
+		{"ok/dry-run", &mockAuthority{revokeBatch: func(opts *authority.RevokeBatchOptions) (*authority.RevocationBatch, error) {
+			assert.True(t, opts.DryRun)
+			return &authority.RevocationBatch{DryRun: true, Serials: []string{"1234"}}, nil
+		}}, `{"issuedAfter":"2020-01-01T00:00:00Z","dryRun":true}`, 200},
+		{"fail/body", &mockAuthority{}, `{`, 400},
+		{"fail/criteria", &mockAuthority{}, `{"reasonCode":4}`, 400},
+		{"fail/authority", &mockAuthority{ret1: (*authority.RevocationBatch)(nil), err: errs.NotImplemented("force")}, `{"provisioner":"acme"}`, 501},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			req := httptest.NewRequest("POST", "http://example.com/admin/revocations", strings.NewReader(tt.body))
+			req.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "admin@example.com"}}},
+			}
+			w := httptest.NewRecorder()
+			h.RevokeBatch(w, req)
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if res.StatusCode < http.StatusBadRequest {
+				var got authority.RevocationBatch
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, []string{"1234"}, got.Serials)
+			}
+		})
+	}
+}
+
+func Test_caHandler_RevocationBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{getRevocationBatch: func(id string) (*authority.RevocationBatch, error) {
+			assert.Equals(t, "foo", id)
+			return &authority.RevocationBatch{ID: id}, nil
+		}}, 200},
+		{"fail", &mockAuthority{ret1: (*authority.RevocationBatch)(nil), err: errs.NotFound("force")}, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.RevocationBatch(w, newAdminRequest("GET", "http://example.com/admin/revocations/foo", "",
+				map[string]string{"id": "foo"}))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_RevocationBatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{ret1: []*authority.RevocationBatch{{ID: "foo"}}}, 200},
+		{"fail", &mockAuthority{ret1: ([]*authority.RevocationBatch)(nil), err: errs.NotImplemented("force")}, 501},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.RevocationBatches(w, httptest.NewRequest("GET", "http://example.com/admin/revocations", nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got RevocationBatchesResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, []*authority.RevocationBatch{{ID: "foo"}}, got.Batches)
+			}
+		})
+	}
+}
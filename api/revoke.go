@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/go-chi/chi"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
@@ -34,6 +35,10 @@ func (r *RevokeRequest) Validate() (err error) {
 	if r.ReasonCode < ocsp.Unspecified || r.ReasonCode > ocsp.AACompromise {
 		return errs.BadRequest("reasonCode out of bounds")
 	}
+	// The reason code 7 is not used in RFC 5280.
+	if r.ReasonCode == 7 {
+		return errs.BadRequest("reasonCode 7 is not a valid reason")
+	}
 	if !r.Passive {
 		return errs.NotImplemented("non-passive revocation not implemented")
 	}
@@ -43,9 +48,9 @@ func (r *RevokeRequest) Validate() (err error) {
 
 // Revoke supports handful of different methods that revoke a Certificate.
 //
-// NOTE: currently only Passive revocation is supported.
-//
-// TODO: Add CRL and OCSP support.
+// NOTE: currently only Passive revocation is supported. The certificates
+// revoked with the certificateHold (6) reason code can be released using the
+// removeFromCRL (8) reason code.
 func (h *caHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 	var body RevokeRequest
 	if err := ReadJSON(r.Body, &body); err != nil {
@@ -104,6 +109,17 @@ func (h *caHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 	JSON(w, &RevokeResponse{Status: "ok"})
 }
 
+// ReleaseHold is the admin API resource that releases an X.509 certificate on
+// hold. Unlike the removeFromCRL reason in Revoke, it can release the
+// certificates placed on hold by any provisioner.
+func (h *caHandler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	if err := h.Authority.ReleaseHold(chi.URLParam(r, "serial"), adminFromContext(r.Context())); err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &RevokeResponse{Status: "ok"})
+}
+
 func logRevoke(w http.ResponseWriter, ri *authority.RevokeOptions) {
 	if rl, ok := w.(logging.ResponseLogger); ok {
 		rl.WithFields(map[string]interface{}{
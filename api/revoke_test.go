@@ -38,6 +38,14 @@ func TestRevokeRequestValidate(t *testing.T) {
 			},
 			err: &errs.Error{Err: errors.New("reasonCode out of bounds"), Status: http.StatusBadRequest},
 		},
+		"error/unused reasonCode": {
+			rr: &RevokeRequest{
+				Serial:     "sn",
+				ReasonCode: 7,
+				Passive:    true,
+			},
+			err: &errs.Error{Err: errors.New("reasonCode 7 is not a valid reason"), Status: http.StatusBadRequest},
+		},
 		"error/non-passive not implemented": {
 			rr: &RevokeRequest{
 				Serial:     "sn",
@@ -53,6 +61,20 @@ func TestRevokeRequestValidate(t *testing.T) {
 				Passive:    true,
 			},
 		},
+		"ok/certificateHold": {
+			rr: &RevokeRequest{
+				Serial:     "sn",
+				ReasonCode: 6,
+				Passive:    true,
+			},
+		},
+		"ok/removeFromCRL": {
+			rr: &RevokeRequest{
+				Serial:     "sn",
+				ReasonCode: 8,
+				Passive:    true,
+			},
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -245,3 +267,64 @@ func Test_caHandler_Revoke(t *testing.T) {
 		})
 	}
 }
+
+func Test_caHandler_CRL(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+		body       []byte
+	}{
+		{"ok", &mockAuthority{ret1: []byte("crl")}, http.StatusOK, []byte("crl")},
+		{"fail/not-configured", &mockAuthority{ret1: []byte(nil), err: errs.NotFound("force")}, http.StatusNotFound, nil},
+		{"fail/db", &mockAuthority{ret1: []byte(nil), err: errs.NotImplemented("force")}, http.StatusNotImplemented, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.CRL(w, httptest.NewRequest("GET", "http://example.com/crl", nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				body, err := ioutil.ReadAll(res.Body)
+				assert.FatalError(t, err)
+				assert.Equals(t, "application/pkix-crl", res.Header.Get("Content-Type"))
+				assert.Equals(t, tt.body, body)
+			}
+		})
+	}
+}
+
+func Test_caHandler_ReleaseHold(t *testing.T) {
+	admin := &authority.Admin{Subject: "jane", Role: authority.AdminRoleSuperAdmin}
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+	}{
+		{"ok", nil, http.StatusOK},
+		{"fail/not-on-hold", errs.BadRequest("force"), http.StatusBadRequest},
+		{"fail/db", errs.NotImplemented("force"), http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: &mockAuthority{
+				authorizeAdmin: func(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*authority.Admin, error) {
+					return admin, nil
+				},
+				releaseHold: func(serial string, a *authority.Admin) error {
+					assert.Equals(t, "1234", serial)
+					assert.Equals(t, admin, a)
+					return tt.err
+				},
+				audit: func(e *authority.AuditEvent) {},
+			}}
+			req := newAdminRequest("DELETE", "http://example.com/admin/holds/1234", "", map[string]string{"serial": "1234"})
+			req.Header.Set("Authorization", "Bearer the-token")
+			w := httptest.NewRecorder()
+			h.requireAdmin(h.ReleaseHold)(w, req)
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
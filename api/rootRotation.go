@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// RootRotationRequest is the request body used in the admin API to start a
+// rotation of the root certificate. Cutover and Retire can be a RFC 3339 time
+// or a duration from now, by default the cutover is immediate and the old
+// root is never retired.
+type RootRotationRequest struct {
+	Key        string                `json:"key"`
+	CommonName string                `json:"commonName,omitempty"`
+	Validity   *provisioner.Duration `json:"validity,omitempty"`
+	RootKey    string                `json:"rootKey,omitempty"`
+	Cutover    *TimeDuration         `json:"cutover,omitempty"`
+	Retire     *TimeDuration         `json:"retire,omitempty"`
+}
+
+// Validate validates a root rotation request body.
+func (r *RootRotationRequest) Validate() error {
+	switch {
+	case r.Key == "":
+		return errs.BadRequest("key cannot be empty")
+	case r.Validity != nil && r.Validity.Duration <= 0:
+		return errs.BadRequest("validity must be greater than 0")
+	default:
+		return nil
+	}
+}
+
+// RootRotationResponse is the response object of the root rotation request.
+// Clients must trust the new root before the retire time.
+type RootRotationResponse struct {
+	Status                     authority.RootRotationStatus `json:"status"`
+	RootPEM                    Certificate                  `json:"root"`
+	CrossSignedRootPEM         *Certificate                 `json:"crossSignedRoot,omitempty"`
+	CrossSignedIntermediatePEM Certificate                  `json:"crossSignedIntermediate"`
+	Cutover                    time.Time                    `json:"cutover"`
+	Retire                     *time.Time                   `json:"retire,omitempty"`
+}
+
+func newRootRotationResponse(r *authority.RootRotation) *RootRotationResponse {
+	res := &RootRotationResponse{
+		Status:                     r.Status(time.Now()),
+		RootPEM:                    NewCertificate(r.GetRoot()),
+		CrossSignedIntermediatePEM: NewCertificate(r.GetCrossSignedIntermediate()),
+		Cutover:                    r.Cutover,
+	}
+	if crt := r.GetCrossSignedRoot(); crt != nil {
+		c := NewCertificate(crt)
+		res.CrossSignedRootPEM = &c
+	}
+	if !r.Retire.IsZero() {
+		res.Retire = &r.Retire
+	}
+	return res
+}
+
+// RootRotation is an HTTP handler that returns the current rotation of the
+// root certificate.
+func (h *caHandler) RootRotation(w http.ResponseWriter, r *http.Request) {
+	rotation, err := h.Authority.GetRootRotation()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, newRootRotationResponse(rotation))
+}
+
+// StartRootRotation is the admin API resource that starts a rotation of the
+// root certificate.
+func (h *caHandler) StartRootRotation(w http.ResponseWriter, r *http.Request) {
+	var body RootRotationRequest
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	opts := &authority.RootRotationOptions{
+		Key:        body.Key,
+		CommonName: body.CommonName,
+		RootKey:    body.RootKey,
+		Cutover:    body.Cutover.Time(),
+		Retire:     body.Retire.Time(),
+	}
+	if body.Validity != nil {
+		opts.Validity = body.Validity.Duration
+	}
+	rotation, err := h.Authority.StartRootRotation(opts)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, newRootRotationResponse(rotation), http.StatusCreated)
+}
+
+// CancelRootRotation is the admin API resource that cancels the current
+// rotation of the root certificate.
+func (h *caHandler) CancelRootRotation(w http.ResponseWriter, r *http.Request) {
+	rotation, err := h.Authority.CancelRootRotation()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, newRootRotationResponse(rotation))
+}
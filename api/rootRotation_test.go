@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func testRootRotation(t *testing.T) *authority.RootRotation {
+	cert, root := parseCertificate(certPEM), parseCertificate(rootPEM)
+	b, err := json.Marshal(authority.RootRotation{
+		Root:                    root.Raw,
+		CrossSignedIntermediate: cert.Raw,
+		Cutover:                 time.Now().Add(time.Hour),
+	})
+	assert.FatalError(t, err)
+	var r authority.RootRotation
+	assert.FatalError(t, json.Unmarshal(b, &r))
+	return &r
+}
+
+func Test_caHandler_RootRotation(t *testing.T) {
+	rotation := testRootRotation(t)
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{ret1: rotation}, http.StatusOK},
+		{"fail", &mockAuthority{ret1: (*authority.RootRotation)(nil), err: errs.NotFound("force")}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.RootRotation(w, httptest.NewRequest("GET", "http://example.com/roots/rotation", nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				var got RootRotationResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, authority.RootRotationScheduled, got.Status)
+				assert.Equals(t, parseCertificate(rootPEM).Raw, got.RootPEM.Raw)
+				assert.Equals(t, parseCertificate(certPEM).Raw, got.CrossSignedIntermediatePEM.Raw)
+				assert.Nil(t, got.CrossSignedRootPEM)
+				assert.Nil(t, got.Retire)
+			}
+		})
+	}
+}
+
+func Test_caHandler_StartRootRotation(t *testing.T) {
+	rotation := testRootRotation(t)
+	tests := []struct {
+		name       string
+		body       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", `{"key":"root_ca_key","commonName":"New Root","validity":"24h","cutover":"1h","retire":"2030-01-01T00:00:00Z"}`,
+			&mockAuthority{startRootRotation: func(opts *authority.RootRotationOptions) (*authority.RootRotation, error) {
+				assert.Equals(t, "root_ca_key", opts.Key)
+				assert.Equals(t, "New Root", opts.CommonName)
+				assert.Equals(t, 24*time.Hour, opts.Validity)
+				assert.False(t, opts.Cutover.IsZero())
+				assert.Equals(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), opts.Retire)
+				return rotation, nil
+			}}, http.StatusCreated},
+		{"ok/defaults", `{"key":"root_ca_key"}`,
+			&mockAuthority{startRootRotation: func(opts *authority.RootRotationOptions) (*authority.RootRotation, error) {
+				assert.Equals(t, time.Duration(0), opts.Validity)
+				assert.True(t, opts.Cutover.IsZero())
+				assert.True(t, opts.Retire.IsZero())
+				return rotation, nil
+			}}, http.StatusCreated},
+		{"fail/body", "{", &mockAuthority{}, http.StatusBadRequest},
+		{"fail/key", `{}`, &mockAuthority{}, http.StatusBadRequest},
+		{"fail/validity", `{"key":"root_ca_key","validity":"-1h"}`, &mockAuthority{}, http.StatusBadRequest},
+		{"fail/authority", `{"key":"root_ca_key"}`, &mockAuthority{ret1: (*authority.RootRotation)(nil), err: errs.NotImplemented("force")}, http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.StartRootRotation(w, httptest.NewRequest("POST", "http://example.com/admin/roots/rotation", strings.NewReader(tt.body)))
+			assert.Equals(t, tt.statusCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func Test_caHandler_CancelRootRotation(t *testing.T) {
+	rotation := testRootRotation(t)
+	rotation.Cancelled = true
+	h := &caHandler{Authority: &mockAuthority{cancelRootRotation: func() (*authority.RootRotation, error) {
+		return rotation, nil
+	}}}
+	w := httptest.NewRecorder()
+	h.CancelRootRotation(w, httptest.NewRequest("DELETE", "http://example.com/admin/roots/rotation", nil))
+	res := w.Result()
+	assert.Equals(t, http.StatusOK, res.StatusCode)
+	var got RootRotationResponse
+	assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+	assert.Equals(t, authority.RootRotationCancelled, got.Status)
+
+	h = &caHandler{Authority: &mockAuthority{ret1: (*authority.RootRotation)(nil), err: errs.NotFound("force")}}
+	w = httptest.NewRecorder()
+	h.CancelRootRotation(w, httptest.NewRequest("DELETE", "http://example.com/admin/roots/rotation", nil))
+	assert.Equals(t, http.StatusNotFound, w.Result().StatusCode)
+}
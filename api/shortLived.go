@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/smallstep/certificates/authority"
+)
+
+// ShortLivedSummariesResponse is the response object of the admin API that
+// lists the summaries of the short-lived certificates.
+type ShortLivedSummariesResponse struct {
+	Summaries []*authority.ShortLivedSummary `json:"summaries"`
+}
+
+// ShortLivedSummaries is the admin API resource that lists the number of
+// short-lived certificates issued by each provisioner every hour.
+func (h *caHandler) ShortLivedSummaries(w http.ResponseWriter, r *http.Request) {
+	summaries, err := h.Authority.GetShortLivedSummaries()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, &ShortLivedSummariesResponse{Summaries: summaries})
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_ShortLivedSummaries(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{ret1: []*authority.ShortLivedSummary{{Provisioner: "foo", Count: 3}}}, 200},
+		{"fail", &mockAuthority{ret1: ([]*authority.ShortLivedSummary)(nil), err: errs.NotImplemented("force")}, 501},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.ShortLivedSummaries(w, httptest.NewRequest("GET", "http://example.com/admin/short-lived", nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == 200 {
+				var got ShortLivedSummariesResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, []*authority.ShortLivedSummary{{Provisioner: "foo", Count: 3}}, got.Summaries)
+			}
+		})
+	}
+}
@@ -11,10 +11,12 @@ import (
 
 // SignRequest is the request body for a certificate signature request.
 type SignRequest struct {
-	CsrPEM    CertificateRequest `json:"csr"`
-	OTT       string             `json:"ott"`
-	NotAfter  TimeDuration       `json:"notAfter"`
-	NotBefore TimeDuration       `json:"notBefore"`
+	CsrPEM       CertificateRequest `json:"csr"`
+	OTT          string             `json:"ott"`
+	NotAfter     TimeDuration       `json:"notAfter"`
+	NotBefore    TimeDuration       `json:"notBefore"`
+	Intermediate string             `json:"intermediate,omitempty"`
+	Profile      string             `json:"profile,omitempty"`
 }
 
 // Validate checks the fields of the SignRequest and returns nil if they are ok
@@ -39,6 +41,7 @@ type SignResponse struct {
 	CaPEM        Certificate          `json:"ca"`
 	CertChainPEM []Certificate        `json:"certChain"`
 	TLSOptions   *tlsutil.TLSOptions  `json:"tlsOptions,omitempty"`
+	Receipt      string               `json:"receipt,omitempty"`
 	TLS          *tls.ConnectionState `json:"-"`
 }
 
@@ -59,11 +62,14 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	opts := provisioner.Options{
-		NotBefore: body.NotBefore,
-		NotAfter:  body.NotAfter,
+		NotBefore:    body.NotBefore,
+		NotAfter:     body.NotAfter,
+		Intermediate: body.Intermediate,
+		Profile:      body.Profile,
 	}
 
-	signOpts, err := h.Authority.AuthorizeSign(body.OTT)
+	ctx := provisioner.NewContextWithMethod(r.Context(), provisioner.SignMethod)
+	signOpts, err := h.Authority.Authorize(ctx, body.OTT)
 	if err != nil {
 		WriteError(w, errs.UnauthorizedErr(err))
 		return
@@ -74,6 +80,11 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, errs.ForbiddenErr(err))
 		return
 	}
+	receipt, err := h.Authority.CreateReceipt(certChain[0], body.OTT)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
 	certChainPEM := certChainToPEM(certChain)
 	var caPEM Certificate
 	if len(certChainPEM) > 1 {
@@ -85,5 +96,6 @@ func (h *caHandler) Sign(w http.ResponseWriter, r *http.Request) {
 		CaPEM:        caPEM,
 		CertChainPEM: certChainPEM,
 		TLSOptions:   h.Authority.GetTLSOptions(),
+		Receipt:      receipt,
 	}, http.StatusCreated)
 }
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// spiffeBundleRefreshHint is the time the consumers of the SPIFFE bundle
+// should wait before refreshing it.
+const spiffeBundleRefreshHint = 5 * time.Minute
+
+// SPIFFEBundleResponse is the response object of the SPIFFE bundle request. It
+// is a JWK Set with the root certificates as x509-svid keys, as defined in
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE_Trust_Domain_and_Bundle.md
+type SPIFFEBundleResponse struct {
+	Keys        []jose.JSONWebKey `json:"keys"`
+	RefreshHint int64             `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// SPIFFEBundle returns the SPIFFE bundle of the trust domain of the CA, the
+// root certificates encoded as a JWK Set. It can be used as the bundle
+// endpoint of the trust domain in SPIFFE federation, e.g. by SPIRE servers.
+func (h *caHandler) SPIFFEBundle(w http.ResponseWriter, r *http.Request) {
+	roots, err := h.Authority.GetRoots()
+	if err != nil {
+		WriteError(w, errs.ForbiddenErr(err))
+		return
+	}
+
+	keys := make([]jose.JSONWebKey, len(roots))
+	for i, crt := range roots {
+		keys[i] = jose.JSONWebKey{
+			Key:          crt.PublicKey,
+			Use:          "x509-svid",
+			Certificates: []*x509.Certificate{crt},
+		}
+	}
+
+	JSON(w, &SPIFFEBundleResponse{
+		Keys:        keys,
+		RefreshHint: int64(spiffeBundleRefreshHint.Seconds()),
+	})
+}
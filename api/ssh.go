@@ -20,7 +20,7 @@ import (
 // SSHAuthority is the interface implemented by a SSH CA authority.
 type SSHAuthority interface {
 	SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
-	RenewSSH(ctx context.Context, cert *ssh.Certificate) (*ssh.Certificate, error)
+	RenewSSH(ctx context.Context, cert *ssh.Certificate, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	RekeySSH(ctx context.Context, cert *ssh.Certificate, key ssh.PublicKey, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
 	SignSSHAddUser(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
 	GetSSHRoots(ctx context.Context) (*authority.SSHKeys, error)
@@ -29,6 +29,8 @@ type SSHAuthority interface {
 	CheckSSHHost(ctx context.Context, principal string, token string) (bool, error)
 	GetSSHHosts(ctx context.Context, cert *x509.Certificate) ([]sshutil.Host, error)
 	GetSSHBastion(ctx context.Context, user string, hostname string) (*authority.Bastion, error)
+	GetSSHKRL(ctx context.Context) ([]byte, error)
+	AuthorizeSSHKRL(ctx context.Context, cert *x509.Certificate, token string) error
 }
 
 // SSHSignRequest is the request body of an SSH certificate request.
@@ -18,6 +18,7 @@ type SSHRevokeResponse struct {
 // SSHRevokeRequest is the request body for a revocation request.
 type SSHRevokeRequest struct {
 	Serial     string `json:"serial"`
+	KeyID      string `json:"keyID,omitempty"`
 	OTT        string `json:"ott"`
 	ReasonCode int    `json:"reasonCode"`
 	Reason     string `json:"reason"`
@@ -27,8 +28,11 @@ type SSHRevokeRequest struct {
 // Validate checks the fields of the RevokeRequest and returns nil if they are ok
 // or an error if something is wrong.
 func (r *SSHRevokeRequest) Validate() (err error) {
-	if r.Serial == "" {
-		return errs.BadRequest("missing serial")
+	switch {
+	case r.Serial == "" && r.KeyID == "":
+		return errs.BadRequest("missing serial or keyID")
+	case r.Serial != "" && r.KeyID != "":
+		return errs.BadRequest("serial and keyID cannot be used together")
 	}
 	if r.ReasonCode < ocsp.Unspecified || r.ReasonCode > ocsp.AACompromise {
 		return errs.BadRequest("reasonCode out of bounds")
@@ -59,6 +63,7 @@ func (h *caHandler) SSHRevoke(w http.ResponseWriter, r *http.Request) {
 
 	opts := &authority.RevokeOptions{
 		Serial:      body.Serial,
+		KeyID:       body.KeyID,
 		Reason:      body.Reason,
 		ReasonCode:  body.ReasonCode,
 		PassiveOnly: body.Passive,
@@ -87,6 +92,7 @@ func logSSHRevoke(w http.ResponseWriter, ri *authority.RevokeOptions) {
 	if rl, ok := w.(logging.ResponseLogger); ok {
 		rl.WithFields(map[string]interface{}{
 			"serial":      ri.Serial,
+			"keyID":       ri.KeyID,
 			"reasonCode":  ri.ReasonCode,
 			"reason":      ri.Reason,
 			"passiveOnly": ri.PassiveOnly,
@@ -95,3 +101,28 @@ func logSSHRevoke(w http.ResponseWriter, ri *authority.RevokeOptions) {
 		})
 	}
 }
+
+// SSHKRL returns the OpenSSH Key Revocation List with the revoked SSH
+// certificates. It requires a client certificate issued by one of the
+// sshKRLProvisioners, so sshd hosts can fetch it periodically using their
+// identity certificate, or the credentials of a super-admin.
+func (h *caHandler) SSHKRL(w http.ResponseWriter, r *http.Request) {
+	crt, token, err := AdminCredentials(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if err := h.Authority.AuthorizeSSHKRL(r.Context(), crt, token); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	krl, err := h.Authority.GetSSHKRL(r.Context())
+	if err != nil {
+		WriteError(w, errs.InternalServerErr(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(krl)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/logging"
+)
+
+func TestSSHRevokeRequestValidate(t *testing.T) {
+	tests := map[string]struct {
+		rr  *SSHRevokeRequest
+		err *errs.Error
+	}{
+		"error/missing serial": {
+			rr:  &SSHRevokeRequest{},
+			err: &errs.Error{Err: errors.New("missing serial or keyID"), Status: http.StatusBadRequest},
+		},
+		"error/serial and keyID": {
+			rr:  &SSHRevokeRequest{Serial: "1234", KeyID: "key-id", Passive: true, OTT: "ott"},
+			err: &errs.Error{Err: errors.New("serial and keyID cannot be used together"), Status: http.StatusBadRequest},
+		},
+		"error/missing ott": {
+			rr:  &SSHRevokeRequest{KeyID: "key-id", Passive: true},
+			err: &errs.Error{Err: errors.New("missing ott"), Status: http.StatusBadRequest},
+		},
+		"ok/serial": {
+			rr: &SSHRevokeRequest{Serial: "1234", Passive: true, OTT: "ott"},
+		},
+		"ok/keyID": {
+			rr: &SSHRevokeRequest{KeyID: "key-id", Passive: true, OTT: "ott"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.rr.Validate(); err != nil {
+				switch v := err.(type) {
+				case *errs.Error:
+					assert.HasPrefix(t, v.Error(), tc.err.Error())
+					assert.Equals(t, v.StatusCode(), tc.err.Status)
+				default:
+					t.Errorf("unexpected error type: %T", v)
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func Test_caHandler_SSHKRL(t *testing.T) {
+	krl := []byte("SSHKRL\n\x00krl")
+	connState := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{}},
+	}
+
+	tests := []struct {
+		name       string
+		tls        *tls.ConnectionState
+		authErr    error
+		krl        []byte
+		err        error
+		statusCode int
+	}{
+		{"ok", connState, nil, krl, nil, http.StatusOK},
+		{"fail no client certificate", nil, nil, nil, nil, http.StatusUnauthorized},
+		{"fail unauthorized certificate", connState, errs.Forbidden("certificate has not been issued by an ssh krl provisioner"), nil, nil, http.StatusForbidden},
+		{"fail not configured", connState, nil, nil, errs.NotFound("ssh is not configured"), http.StatusNotFound},
+		{"fail error", connState, nil, nil, errors.New("an error"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(&mockAuthority{
+				authorizeSSHKRL: func(ctx context.Context, cert *x509.Certificate, token string) error {
+					if cert != connState.PeerCertificates[0] {
+						t.Errorf("caHandler.SSHKRL certificate = %v, wants %v", cert, connState.PeerCertificates[0])
+					}
+					return tt.authErr
+				},
+				getSSHKRL: func(context.Context) ([]byte, error) {
+					return tt.krl, tt.err
+				},
+			}).(*caHandler)
+
+			req := httptest.NewRequest("GET", "http://example.com/ssh/krl", http.NoBody)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			h.SSHKRL(logging.NewResponseLogger(w), req)
+			res := w.Result()
+
+			if res.StatusCode != tt.statusCode {
+				t.Errorf("caHandler.SSHKRL StatusCode = %d, wants %d", res.StatusCode, tt.statusCode)
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Errorf("caHandler.SSHKRL unexpected error = %v", err)
+			}
+			if tt.statusCode < http.StatusBadRequest {
+				assert.Equals(t, "application/octet-stream", res.Header.Get("Content-Type"))
+				if !bytes.Equal(body, tt.krl) {
+					t.Errorf("caHandler.SSHKRL Body = %s, wants %s", body, tt.krl)
+				}
+			}
+		})
+	}
+}
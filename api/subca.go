@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+// SubCARequestBody is the request body used to request a subordinate CA
+// certificate with a token of a SubCA provisioner.
+type SubCARequestBody struct {
+	CsrPEM CertificateRequest `json:"csr"`
+	OTT    string             `json:"ott"`
+}
+
+// Validate checks the fields of the SubCARequestBody and returns nil if they
+// are ok or an error if something is wrong.
+func (s *SubCARequestBody) Validate() error {
+	if s.CsrPEM.CertificateRequest == nil {
+		return errs.BadRequest("missing csr")
+	}
+	if err := s.CsrPEM.CertificateRequest.CheckSignature(); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "invalid csr")
+	}
+	if s.OTT == "" {
+		return errs.BadRequest("missing ott")
+	}
+	return nil
+}
+
+// SubCAResponse is the response object of a sub-CA request. The certificates
+// are only set once the request has been approved.
+type SubCAResponse struct {
+	ID           string                `json:"id"`
+	Provisioner  string                `json:"provisioner"`
+	Status       authority.SubCAStatus `json:"status"`
+	Subject      string                `json:"subject"`
+	SANs         []string              `json:"sans,omitempty"`
+	ServerPEM    *Certificate          `json:"crt,omitempty"`
+	CaPEM        *Certificate          `json:"ca,omitempty"`
+	CertChainPEM []Certificate         `json:"certChain,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt"`
+	UpdatedAt    time.Time             `json:"updatedAt"`
+}
+
+// SubCARequestsResponse is the response object of the admin API that lists
+// the sub-CA requests.
+type SubCARequestsResponse struct {
+	Requests []*SubCAResponse `json:"requests"`
+}
+
+func newSubCAResponse(r *authority.SubCARequest) (*SubCAResponse, error) {
+	certChain, err := r.GetCertChain()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error parsing sub-CA certificate")
+	}
+	res := &SubCAResponse{
+		ID:          r.ID,
+		Provisioner: r.Provisioner,
+		Status:      r.Status,
+		Subject:     r.Subject,
+		SANs:        r.SANs,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+	}
+	if len(certChain) > 0 {
+		res.CertChainPEM = certChainToPEM(certChain)
+		res.ServerPEM = &res.CertChainPEM[0]
+		if len(certChain) > 1 {
+			res.CaPEM = &res.CertChainPEM[1]
+		}
+	}
+	return res, nil
+}
+
+// RequestSubCA is an HTTP handler that reads a certificate request and a
+// one-time-token of a SubCA provisioner and stores the request until an
+// administrator approves it.
+func (h *caHandler) RequestSubCA(w http.ResponseWriter, r *http.Request) {
+	var body SubCARequestBody
+	if err := ReadJSON(r.Body, &body); err != nil {
+		WriteError(w, errs.Wrap(http.StatusBadRequest, err, "error reading request body"))
+		return
+	}
+
+	logOtt(w, body.OTT)
+	if err := body.Validate(); err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	req, err := h.Authority.RequestSubCA(r.Context(), body.CsrPEM.CertificateRequest, body.OTT)
+	if err != nil {
+		WriteError(w, errs.UnauthorizedErr(err))
+		return
+	}
+	res, err := newSubCAResponse(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSONStatus(w, res, http.StatusCreated)
+}
+
+// SubCARequest is an HTTP handler that returns the status of a sub-CA request
+// and, if it has been approved, the certificate. The random identifier of the
+// request is only known to the requester and the administrators.
+func (h *caHandler) SubCARequest(w http.ResponseWriter, r *http.Request) {
+	req, err := h.Authority.GetSubCARequest(chi.URLParam(r, "id"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, err := newSubCAResponse(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, res)
+}
+
+// SubCARequests is the admin API resource that lists the sub-CA requests,
+// filtered by the status query parameter if present.
+func (h *caHandler) SubCARequests(w http.ResponseWriter, r *http.Request) {
+	status := authority.SubCAStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", authority.SubCAPending, authority.SubCAApproved, authority.SubCARejected:
+	default:
+		WriteError(w, errs.BadRequest("status query parameter %s is not valid", status))
+		return
+	}
+	list, err := h.Authority.GetSubCARequests(status)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res := &SubCARequestsResponse{Requests: []*SubCAResponse{}}
+	for _, req := range list {
+		sr, err := newSubCAResponse(req)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		res.Requests = append(res.Requests, sr)
+	}
+	JSON(w, res)
+}
+
+// ApproveSubCARequest is the admin API resource used to approve a pending
+// sub-CA request and sign its certificate.
+func (h *caHandler) ApproveSubCARequest(w http.ResponseWriter, r *http.Request) {
+	h.updateSubCARequest(w, r, h.Authority.ApproveSubCARequest)
+}
+
+// RejectSubCARequest is the admin API resource used to reject a pending
+// sub-CA request.
+func (h *caHandler) RejectSubCARequest(w http.ResponseWriter, r *http.Request) {
+	h.updateSubCARequest(w, r, h.Authority.RejectSubCARequest)
+}
+
+func (h *caHandler) updateSubCARequest(w http.ResponseWriter, r *http.Request, fn func(id string) (*authority.SubCARequest, error)) {
+	req, err := fn(chi.URLParam(r, "id"))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	res, err := newSubCAResponse(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	JSON(w, res)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/errs"
+)
+
+func Test_caHandler_RequestSubCA(t *testing.T) {
+	csr := parseCertificateRequest(csrPEM)
+	valid, err := json.Marshal(SubCARequestBody{CsrPEM: CertificateRequest{csr}, OTT: "foobarzar"})
+	assert.FatalError(t, err)
+	invalid, err := json.Marshal(SubCARequestBody{CsrPEM: CertificateRequest{csr}})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name       string
+		body       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", string(valid), &mockAuthority{requestSubCA: func(ctx context.Context, cr *x509.CertificateRequest, ott string) (*authority.SubCARequest, error) {
+			assert.Equals(t, csr.Raw, cr.Raw)
+			assert.Equals(t, "foobarzar", ott)
+			return &authority.SubCARequest{ID: "abc", Provisioner: "team-ca", Status: authority.SubCAPending, Subject: "Team CA"}, nil
+		}}, http.StatusCreated},
+		{"fail/body", "{", &mockAuthority{}, http.StatusBadRequest},
+		{"fail/validate", string(invalid), &mockAuthority{}, http.StatusBadRequest},
+		{"fail/authority", string(valid), &mockAuthority{ret1: (*authority.SubCARequest)(nil), err: errs.Forbidden("force")}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.RequestSubCA(w, httptest.NewRequest("POST", "http://example.com/subca", strings.NewReader(tt.body)))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusCreated {
+				var got map[string]interface{}
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, "abc", got["id"])
+				assert.Equals(t, "pending", got["status"])
+				assert.Nil(t, got["crt"])
+			}
+		})
+	}
+}
+
+func Test_caHandler_SubCARequest(t *testing.T) {
+	cert, root := parseCertificate(certPEM), parseCertificate(rootPEM)
+	approved := &authority.SubCARequest{ID: "abc", Status: authority.SubCAApproved, CertChain: [][]byte{cert.Raw, root.Raw}}
+	tests := []struct {
+		name       string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", &mockAuthority{getSubCARequest: func(id string) (*authority.SubCARequest, error) {
+			assert.Equals(t, "abc", id)
+			return approved, nil
+		}}, http.StatusOK},
+		{"fail", &mockAuthority{ret1: (*authority.SubCARequest)(nil), err: errs.NotFound("force")}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.SubCARequest(w, newAdminRequest("GET", "http://example.com/subca/abc", "", map[string]string{"id": "abc"}))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				var got SubCAResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Equals(t, authority.SubCAApproved, got.Status)
+				assert.Equals(t, cert.Raw, got.ServerPEM.Raw)
+				assert.Equals(t, root.Raw, got.CaPEM.Raw)
+				assert.Len(t, 2, got.CertChainPEM)
+			}
+		})
+	}
+}
+
+func Test_caHandler_SubCARequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		auth       Authority
+		statusCode int
+	}{
+		{"ok", "", &mockAuthority{getSubCARequests: func(status authority.SubCAStatus) ([]*authority.SubCARequest, error) {
+			assert.Equals(t, authority.SubCAStatus(""), status)
+			return []*authority.SubCARequest{{ID: "abc"}, {ID: "def"}}, nil
+		}}, http.StatusOK},
+		{"ok/status", "?status=pending", &mockAuthority{getSubCARequests: func(status authority.SubCAStatus) ([]*authority.SubCARequest, error) {
+			assert.Equals(t, authority.SubCAPending, status)
+			return []*authority.SubCARequest{{ID: "abc"}, {ID: "def"}}, nil
+		}}, http.StatusOK},
+		{"fail/status", "?status=foo", &mockAuthority{}, http.StatusBadRequest},
+		{"fail/authority", "", &mockAuthority{ret1: ([]*authority.SubCARequest)(nil), err: errs.NotImplemented("force")}, http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &caHandler{Authority: tt.auth}
+			w := httptest.NewRecorder()
+			h.SubCARequests(w, httptest.NewRequest("GET", "http://example.com/admin/subca"+tt.query, nil))
+			res := w.Result()
+			assert.Equals(t, tt.statusCode, res.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				var got SubCARequestsResponse
+				assert.FatalError(t, json.NewDecoder(res.Body).Decode(&got))
+				assert.Len(t, 2, got.Requests)
+			}
+		})
+	}
+}
+
+func Test_caHandler_ApproveSubCARequest(t *testing.T) {
+	h := &caHandler{Authority: &mockAuthority{approveSubCARequest: func(id string) (*authority.SubCARequest, error) {
+		assert.Equals(t, "abc", id)
+		return &authority.SubCARequest{ID: id, Status: authority.SubCAApproved}, nil
+	}}}
+	w := httptest.NewRecorder()
+	h.ApproveSubCARequest(w, newAdminRequest("POST", "http://example.com/admin/subca/abc/approve", "", map[string]string{"id": "abc"}))
+	assert.Equals(t, http.StatusOK, w.Result().StatusCode)
+
+	h = &caHandler{Authority: &mockAuthority{ret1: (*authority.SubCARequest)(nil), err: errs.BadRequest("force")}}
+	w = httptest.NewRecorder()
+	h.ApproveSubCARequest(w, newAdminRequest("POST", "http://example.com/admin/subca/abc/approve", "", map[string]string{"id": "abc"}))
+	assert.Equals(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func Test_caHandler_RejectSubCARequest(t *testing.T) {
+	h := &caHandler{Authority: &mockAuthority{rejectSubCARequest: func(id string) (*authority.SubCARequest, error) {
+		assert.Equals(t, "abc", id)
+		return &authority.SubCARequest{ID: id, Status: authority.SubCARejected}, nil
+	}}}
+	w := httptest.NewRecorder()
+	h.RejectSubCARequest(w, newAdminRequest("POST", "http://example.com/admin/subca/abc/reject", "", map[string]string{"id": "abc"}))
+	assert.Equals(t, http.StatusOK, w.Result().StatusCode)
+
+	h = &caHandler{Authority: &mockAuthority{ret1: (*authority.SubCARequest)(nil), err: errs.NotFound("force")}}
+	w = httptest.NewRecorder()
+	h.RejectSubCARequest(w, newAdminRequest("POST", "http://example.com/admin/subca/abc/reject", "", map[string]string{"id": "abc"}))
+	assert.Equals(t, http.StatusNotFound, w.Result().StatusCode)
+}
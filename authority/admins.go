@@ -0,0 +1,488 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+const (
+	// AdminRoleSuperAdmin is the role of the administrators that can use the
+	// whole admin API. The admins in the configuration are super-admins.
+	AdminRoleSuperAdmin = "super-admin"
+	// AdminRoleProvisionerAdmin is the role of the administrators that can
+	// only manage a list of provisioners and their ACME external account
+	// keys.
+	AdminRoleProvisionerAdmin = "provisioner-admin"
+)
+
+// Admin is an authenticated administrator of the CA.
+type Admin struct {
+	Subject      string   `json:"subject"`
+	Role         string   `json:"role"`
+	Provisioners []string `json:"provisioners,omitempty"`
+}
+
+// IsSuperAdmin returns true if the administrator is a super-admin.
+func (a *Admin) IsSuperAdmin() bool {
+	return a.Role == AdminRoleSuperAdmin
+}
+
+// CanManage returns true if the administrator can manage the provisioner with
+// the given name.
+func (a *Admin) CanManage(name string) bool {
+	if a.IsSuperAdmin() {
+		return true
+	}
+	for _, s := range a.Provisioners {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminRecord is an administrator managed with the admin API. The subject is
+// matched against the common name or the SANs of the client certificate, or
+// against the email of an OIDC token. Like in the provisioner records, the
+// version is incremented on every change.
+type AdminRecord struct {
+	Subject      string   `json:"subject"`
+	Role         string   `json:"role,omitempty"`
+	Provisioners []string `json:"provisioners,omitempty"`
+	Version      int      `json:"version"`
+	Deleted      bool     `json:"deleted,omitempty"`
+}
+
+// validateAdmin validates the role and the provisioners of an administrator.
+func validateAdmin(role string, provisioners []string) error {
+	switch role {
+	case AdminRoleSuperAdmin:
+		if len(provisioners) > 0 {
+			return errs.BadRequest("provisioners cannot be set for a %s", role)
+		}
+	case AdminRoleProvisionerAdmin:
+		if len(provisioners) == 0 {
+			return errs.BadRequest("provisioners cannot be empty for a %s", role)
+		}
+		for _, s := range provisioners {
+			if s == "" {
+				return errs.BadRequest("provisioners cannot contain an empty value")
+			}
+		}
+	default:
+		return errs.BadRequest("role %q is not valid", role)
+	}
+	return nil
+}
+
+// storedAdmin is the in memory representation of an admin record, with the
+// value stored in the database used to swap it.
+type storedAdmin struct {
+	record *AdminRecord
+	value  []byte
+}
+
+// loadStoredAdmins loads the administrators created using the admin API.
+func (a *Authority) loadStoredAdmins() error {
+	list, err := a.db.GetAdmins()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading admins")
+	}
+	a.storedAdmins = make(map[string]*storedAdmin, len(list))
+	for _, b := range list {
+		r := new(AdminRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling admin record")
+		}
+		a.storedAdmins[r.Subject] = &storedAdmin{record: r, value: b}
+	}
+	return nil
+}
+
+// syncStoredAdmins applies the changes to the administrators made with the
+// admin API by other instances of the CA sharing the database.
+func (a *Authority) syncStoredAdmins() error {
+	list, err := a.db.GetAdmins()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading admins")
+	}
+
+	a.adminsMutex.Lock()
+	defer a.adminsMutex.Unlock()
+	if a.storedAdmins == nil {
+		a.storedAdmins = make(map[string]*storedAdmin, len(list))
+	}
+	for _, b := range list {
+		r := new(AdminRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling admin record")
+		}
+		if old, ok := a.storedAdmins[r.Subject]; ok && (bytes.Equal(old.value, b) || old.record.Version > r.Version) {
+			continue
+		}
+		a.storedAdmins[r.Subject] = &storedAdmin{record: r, value: b}
+	}
+	return nil
+}
+
+// swapStoredAdmin stores the given record in the database if the current
+// value is still the one in sa.
+func (a *Authority) swapStoredAdmin(sa *storedAdmin, r *AdminRecord) ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error marshaling admin record")
+	}
+	var old []byte
+	if sa != nil {
+		old = sa.value
+	}
+	swapped, err := a.db.CmpAndSwapAdmin(r.Subject, old, b)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.Wrap(http.StatusNotImplemented, err, "storing admins is not implemented")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error storing admin")
+	case !swapped:
+		return nil, errs.Errorf(http.StatusConflict, "admin %s has been modified concurrently", r.Subject)
+	default:
+		return b, nil
+	}
+}
+
+// isConfigAdmin returns true if the given subject is one of the
+// administrators in the configuration.
+func (a *Authority) isConfigAdmin(subject string) bool {
+	if a.config.AuthorityConfig == nil {
+		return false
+	}
+	for _, s := range a.config.AuthorityConfig.Admins {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// loadStoredAdmin returns the stored administrator with the given subject. It
+// must be called with the admins lock held.
+func (a *Authority) loadStoredAdmin(subject string) (*storedAdmin, error) {
+	if sa, ok := a.storedAdmins[subject]; ok && !sa.record.Deleted {
+		return sa, nil
+	}
+	if a.isConfigAdmin(subject) {
+		return nil, errs.BadRequest("admin %s is defined in the configuration and cannot be modified", subject)
+	}
+	return nil, errs.NotFound("admin %s was not found", subject)
+}
+
+// GetAdminRecords returns the administrators managed with the admin API
+// sorted by subject.
+func (a *Authority) GetAdminRecords() []*AdminRecord {
+	a.adminsMutex.RLock()
+	defer a.adminsMutex.RUnlock()
+	list := []*AdminRecord{}
+	for _, sa := range a.storedAdmins {
+		if !sa.record.Deleted {
+			list = append(list, sa.record)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Subject < list[j].Subject
+	})
+	return list
+}
+
+// GetAdminRecord returns the administrator managed with the admin API with
+// the given subject.
+func (a *Authority) GetAdminRecord(subject string) (*AdminRecord, error) {
+	a.adminsMutex.RLock()
+	defer a.adminsMutex.RUnlock()
+	sa, err := a.loadStoredAdmin(subject)
+	if err != nil {
+		return nil, err
+	}
+	return sa.record, nil
+}
+
+// CreateAdmin adds a new administrator with the given role. Provisioner-admins
+// can only manage the given provisioners.
+func (a *Authority) CreateAdmin(subject, role string, provisioners []string) (*AdminRecord, error) {
+	if subject == "" {
+		return nil, errs.BadRequest("subject cannot be empty")
+	}
+	if err := validateAdmin(role, provisioners); err != nil {
+		return nil, err
+	}
+
+	a.adminsMutex.Lock()
+	defer a.adminsMutex.Unlock()
+
+	if a.isConfigAdmin(subject) {
+		return nil, errs.Errorf(http.StatusConflict, "admin %s already exists", subject)
+	}
+	// Deleted admins keep their version, so a new admin with the same
+	// subject continues with the next one.
+	r := &AdminRecord{Subject: subject, Role: role, Provisioners: provisioners, Version: 1}
+	sa, ok := a.storedAdmins[subject]
+	if ok {
+		if !sa.record.Deleted {
+			return nil, errs.Errorf(http.StatusConflict, "admin %s already exists", subject)
+		}
+		r.Version = sa.record.Version + 1
+	}
+	b, err := a.swapStoredAdmin(sa, r)
+	if err != nil {
+		return nil, err
+	}
+	if a.storedAdmins == nil {
+		a.storedAdmins = make(map[string]*storedAdmin)
+	}
+	a.storedAdmins[subject] = &storedAdmin{record: r, value: b}
+	return r, nil
+}
+
+// UpdateAdmin replaces the role and the provisioners of the administrator
+// with the given subject. The version must be the current version of the
+// administrator, otherwise it returns a conflict error.
+func (a *Authority) UpdateAdmin(subject string, version int, role string, provisioners []string) (*AdminRecord, error) {
+	if err := validateAdmin(role, provisioners); err != nil {
+		return nil, err
+	}
+
+	a.adminsMutex.Lock()
+	defer a.adminsMutex.Unlock()
+
+	sa, err := a.loadStoredAdmin(subject)
+	if err != nil {
+		return nil, err
+	}
+	if sa.record.Version != version {
+		return nil, errs.Errorf(http.StatusConflict, "admin %s version %d does not match the current version %d", subject, version, sa.record.Version)
+	}
+	r := &AdminRecord{Subject: subject, Role: role, Provisioners: provisioners, Version: version + 1}
+	b, err := a.swapStoredAdmin(sa, r)
+	if err != nil {
+		return nil, err
+	}
+	a.storedAdmins[subject] = &storedAdmin{record: r, value: b}
+	return r, nil
+}
+
+// DeleteAdmin removes the administrator with the given subject. The version
+// must be the current version of the administrator, otherwise it returns a
+// conflict error.
+func (a *Authority) DeleteAdmin(subject string, version int) error {
+	a.adminsMutex.Lock()
+	defer a.adminsMutex.Unlock()
+
+	sa, err := a.loadStoredAdmin(subject)
+	if err != nil {
+		return err
+	}
+	if sa.record.Version != version {
+		return errs.Errorf(http.StatusConflict, "admin %s version %d does not match the current version %d", subject, version, sa.record.Version)
+	}
+	r := &AdminRecord{Subject: subject, Version: version + 1, Deleted: true}
+	b, err := a.swapStoredAdmin(sa, r)
+	if err != nil {
+		return err
+	}
+	a.storedAdmins[subject] = &storedAdmin{record: r, value: b}
+	return nil
+}
+
+// findAdmin returns the administrator that matches one of the given names.
+// The administrators in the configuration take precedence over the ones
+// managed with the admin API.
+func (a *Authority) findAdmin(names []string) *Admin {
+	for _, name := range names {
+		if name != "" && a.isConfigAdmin(name) {
+			return &Admin{Subject: name, Role: AdminRoleSuperAdmin}
+		}
+	}
+	a.adminsMutex.RLock()
+	defer a.adminsMutex.RUnlock()
+	for _, name := range names {
+		if sa, ok := a.storedAdmins[name]; ok && !sa.record.Deleted {
+			return &Admin{
+				Subject:      name,
+				Role:         sa.record.Role,
+				Provisioners: sa.record.Provisioners,
+			}
+		}
+	}
+	return nil
+}
+
+// AuthorizeAdmin authenticates an administrator using the client certificate
+// or, if there's no certificate, the OIDC token of the admin provisioner. The
+// certificate must be issued by the CA using one of the admin provisioners and
+// it must not be revoked, and its common name or one of its SANs must match
+// one of the admins. If the name of
+// a provisioner is given, the admin must be able to manage it, otherwise the
+// admin must be a super-admin.
+func (a *Authority) AuthorizeAdmin(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (*Admin, error) {
+	var opts []interface{}
+	if cert != nil {
+		opts = append(opts, errs.WithKeyVal("serialNumber", cert.SerialNumber.String()))
+	}
+
+	if a.config.AuthorityConfig == nil || len(a.config.AuthorityConfig.Admins) == 0 {
+		return nil, errs.Unauthorized("authority.AuthorizeAdmin: admin api is not enabled", opts...)
+	}
+
+	var names []string
+	credential := "certificate"
+	switch {
+	case cert != nil:
+		if err := a.verifyAdminCertificate(cert, opts); err != nil {
+			return nil, err
+		}
+		names = append(names, cert.Subject.CommonName)
+		names = append(names, cert.DNSNames...)
+		names = append(names, cert.EmailAddresses...)
+		for _, u := range cert.URIs {
+			names = append(names, u.String())
+		}
+	case token != "":
+		email, err := a.authorizeAdminToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, email)
+		credential = "token"
+	default:
+		return nil, errs.Unauthorized("authority.AuthorizeAdmin: missing client certificate or token")
+	}
+
+	admin := a.findAdmin(names)
+	switch {
+	case admin == nil:
+		return nil, errs.Forbidden("authority.AuthorizeAdmin: %s does not belong to an admin", append([]interface{}{credential}, opts...)...)
+	case provisionerName == "" && !admin.IsSuperAdmin():
+		return nil, errs.Forbidden("authority.AuthorizeAdmin: admin %s is not a %s", append([]interface{}{admin.Subject, AdminRoleSuperAdmin}, opts...)...)
+	case provisionerName != "" && !admin.CanManage(provisionerName):
+		return nil, errs.Forbidden("authority.AuthorizeAdmin: admin %s cannot manage provisioner %s", append([]interface{}{admin.Subject, provisionerName}, opts...)...)
+	default:
+		return admin, nil
+	}
+}
+
+// verifyAdminCertificate verifies that the given client certificate has been
+// issued by the CA using one of the admin provisioners, and that it has not
+// been revoked. Without the provisioner check, any provisioner able to issue a
+// certificate with the name of an admin could be used to get admin access.
+func (a *Authority) verifyAdminCertificate(cert *x509.Certificate, opts []interface{}) error {
+	return a.verifyClientCertificate("authority.AuthorizeAdmin", cert, a.isAdminCertProvisioner,
+		"certificate has not been issued by an admin provisioner", opts)
+}
+
+// verifyClientCertificate verifies that the given client certificate has been
+// issued by the CA, that the given function accepts it, and that it has not
+// been revoked. The message is used in the error if the function rejects it.
+func (a *Authority) verifyClientCertificate(op string, cert *x509.Certificate, accept func(*x509.Certificate) bool, msg string, opts []interface{}) error {
+	roots := x509.NewCertPool()
+	for _, crt := range a.rootX509Certs {
+		roots.AddCert(crt)
+	}
+	intermediates := x509.NewCertPool()
+	if issuer, _ := a.GetIntermediate(); issuer != nil {
+		intermediates.AddCert(issuer)
+	}
+	for _, in := range a.x509Intermediates {
+		intermediates.AddCert(in.cert)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, op+": error verifying certificate", opts...)
+	}
+	if !accept(cert) {
+		return errs.Unauthorized(op+": "+msg, opts...)
+	}
+
+	// Check the passive revocation table.
+	isRevoked, err := a.db.IsRevoked(cert.SerialNumber.String())
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, op, opts...)
+	}
+	if isRevoked {
+		return errs.Unauthorized(op+": certificate has been revoked", opts...)
+	}
+	return nil
+}
+
+// isAdminCertProvisioner returns true if the provisioner extension of the
+// certificate has the name of the admin provisioner or of one of the
+// adminCertProvisioners.
+func (a *Authority) isAdminCertProvisioner(cert *x509.Certificate) bool {
+	name, ok := provisioner.GetProvisionerName(cert)
+	if !ok || name == "" {
+		return false
+	}
+	if name == a.config.AuthorityConfig.AdminProvisioner {
+		return true
+	}
+	for _, s := range a.config.AuthorityConfig.AdminCertProvisioners {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeAdminToken validates the token using the admin provisioner and
+// returns the email of the user.
+func (a *Authority) authorizeAdminToken(ctx context.Context, token string) (string, error) {
+	name := a.config.AuthorityConfig.AdminProvisioner
+	if name == "" {
+		return "", errs.Unauthorized("authority.AuthorizeAdmin: admin tokens are not enabled")
+	}
+	for _, p := range a.config.AuthorityConfig.Provisioners {
+		if p.GetName() != name {
+			continue
+		}
+		if o, ok := p.(interface {
+			AuthorizeIdentity(ctx context.Context, token string) (string, error)
+		}); ok {
+			email, err := o.AuthorizeIdentity(ctx, token)
+			if err != nil {
+				return "", errs.Wrap(http.StatusUnauthorized, err, "authority.AuthorizeAdmin")
+			}
+			return email, nil
+		}
+	}
+	return "", errs.InternalServer("authority.AuthorizeAdmin: admin provisioner %s was not found", name)
+}
+
+// AuthorizeACMEAdmin authorizes an administrator of the given ACME
+// provisioner and returns the subject of the admin. It's used by the ACME
+// admin API.
+func (a *Authority) AuthorizeACMEAdmin(ctx context.Context, cert *x509.Certificate, token, provisionerName string) (string, error) {
+	admin, err := a.AuthorizeAdmin(ctx, cert, token, provisionerName)
+	if err != nil {
+		return "", err
+	}
+	return admin.Subject, nil
+}
+
+// AuditAdminRequest records a request of the admin API in the audit log.
+func (a *Authority) AuditAdminRequest(requester, method, path string, status int) {
+	a.Audit(&AuditEvent{
+		Action:    AuditAdmin,
+		Requester: requester,
+		Method:    method,
+		Path:      path,
+		Status:    status,
+	})
+}
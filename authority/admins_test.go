@@ -0,0 +1,488 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+type mockIdentityProvisioner struct {
+	*provisioner.JWK
+	authorizeIdentity func(ctx context.Context, token string) (string, error)
+}
+
+func (m *mockIdentityProvisioner) AuthorizeIdentity(ctx context.Context, token string) (string, error) {
+	return m.authorizeIdentity(ctx, token)
+}
+
+func TestAdmin_CanManage(t *testing.T) {
+	super := &Admin{Subject: "admin", Role: AdminRoleSuperAdmin}
+	prov := &Admin{Subject: "other", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"foo", "bar"}}
+	assert.True(t, super.IsSuperAdmin())
+	assert.True(t, super.CanManage("foo"))
+	assert.True(t, super.CanManage("zar"))
+	assert.False(t, prov.IsSuperAdmin())
+	assert.True(t, prov.CanManage("foo"))
+	assert.True(t, prov.CanManage("bar"))
+	assert.False(t, prov.CanManage("zar"))
+}
+
+func TestAuthority_AuthorizeAdmin(t *testing.T) {
+	a := testAuthority(t)
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	newCert := func(cn, email, provisionerName string, signer crypto.Signer, issuer *x509.Certificate, issuerKey crypto.Signer) *x509.Certificate {
+		b, err := asn1.Marshal(stepProvisionerASN1{
+			Type:         provisionerTypeJWK,
+			Name:         []byte(provisionerName),
+			CredentialID: []byte("kid"),
+		})
+		assert.FatalError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:   big.NewInt(time.Now().UnixNano()),
+			Subject:        pkix.Name{CommonName: cn},
+			EmailAddresses: []string{email},
+			NotBefore:      time.Now().Add(-time.Minute),
+			NotAfter:       time.Now().Add(time.Hour),
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			ExtraExtensions: []pkix.Extension{
+				{Id: stepOIDProvisioner, Value: b},
+			},
+		}
+		if issuer == nil {
+			issuer = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, signer.Public(), issuerKey)
+		assert.FatalError(t, err)
+		crt, err := x509.ParseCertificate(der)
+		assert.FatalError(t, err)
+		return crt
+	}
+	adminCrt := newCert("admin", "admin@smallstep.com", "step-cli", signer, a.x509Issuer, a.x509Signer)
+	otherCrt := newCert("other", "other@smallstep.com", "step-cli", signer, a.x509Issuer, a.x509Signer)
+	selfSignedCrt := newCert("admin", "admin@smallstep.com", "step-cli", signer, nil, signer)
+	// Certificate with the name of an admin issued by a provisioner that is
+	// not an admin provisioner.
+	maxCrt := newCert("admin", "admin@smallstep.com", "Max", signer, a.x509Issuer, a.x509Signer)
+
+	// Additional intermediate signed by another root.
+	otherRootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	newCA := func(cn string, pub crypto.PublicKey, issuer *x509.Certificate, issuerKey crypto.Signer) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		if issuer == nil {
+			issuer = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, pub, issuerKey)
+		assert.FatalError(t, err)
+		crt, err := x509.ParseCertificate(der)
+		assert.FatalError(t, err)
+		return crt
+	}
+	otherRoot := newCA("Other Root", otherRootKey.Public(), nil, otherRootKey)
+	otherIntermediate := newCA("Other Intermediate", otherKey.Public(), otherRoot, otherRootKey)
+	secondaryCrt := newCert("admin", "admin@smallstep.com", "step-cli", signer, otherIntermediate, otherKey)
+
+	type authorizeTest struct {
+		auth        *Authority
+		cert        *x509.Certificate
+		token       string
+		provisioner string
+		want        *Admin
+		err         error
+		code        int
+	}
+	tests := map[string]func(t *testing.T) *authorizeTest{
+		"fail/not-enabled": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			return &authorizeTest{
+				auth: a,
+				cert: adminCrt,
+				err:  errors.New("authority.AuthorizeAdmin: admin api is not enabled"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/verify": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				cert: selfSignedCrt,
+				err:  errors.New("authority.AuthorizeAdmin: error verifying certificate"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/not-admin-provisioner": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				cert: maxCrt,
+				err:  errors.New("authority.AuthorizeAdmin: certificate has not been issued by an admin provisioner"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/no-admin-provisioners": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			return &authorizeTest{
+				auth: a,
+				cert: adminCrt,
+				err:  errors.New("authority.AuthorizeAdmin: certificate has not been issued by an admin provisioner"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/db.IsRevoked-error": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.db = &db.MockAuthDB{
+				MIsRevoked: func(key string) (bool, error) {
+					return false, errors.New("force")
+				},
+			}
+			return &authorizeTest{
+				auth: a,
+				cert: adminCrt,
+				err:  errors.New("authority.AuthorizeAdmin: force"),
+				code: http.StatusInternalServerError,
+			}
+		},
+		"fail/revoked": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.db = &db.MockAuthDB{
+				MIsRevoked: func(key string) (bool, error) {
+					return true, nil
+				},
+			}
+			return &authorizeTest{
+				auth: a,
+				cert: adminCrt,
+				err:  errors.New("authority.AuthorizeAdmin: certificate has been revoked"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/not-admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				cert: otherCrt,
+				err:  errors.New("authority.AuthorizeAdmin: certificate does not belong to an admin"),
+				code: http.StatusForbidden,
+			}
+		},
+		"fail/missing-credentials": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				err:  errors.New("authority.AuthorizeAdmin: missing client certificate or token"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/not-super-admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.storedAdmins = map[string]*storedAdmin{
+				"other": {record: &AdminRecord{Subject: "other", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"Max"}, Version: 1}},
+			}
+			return &authorizeTest{
+				auth: a,
+				cert: otherCrt,
+				err:  errors.New("authority.AuthorizeAdmin: admin other is not a super-admin"),
+				code: http.StatusForbidden,
+			}
+		},
+		"fail/not-provisioner-admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.storedAdmins = map[string]*storedAdmin{
+				"other": {record: &AdminRecord{Subject: "other", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"Max"}, Version: 1}},
+			}
+			return &authorizeTest{
+				auth:        a,
+				cert:        otherCrt,
+				provisioner: "step-cli",
+				err:         errors.New("authority.AuthorizeAdmin: admin other cannot manage provisioner step-cli"),
+				code:        http.StatusForbidden,
+			}
+		},
+		"fail/deleted-admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.storedAdmins = map[string]*storedAdmin{
+				"other": {record: &AdminRecord{Subject: "other", Version: 2, Deleted: true}},
+			}
+			return &authorizeTest{
+				auth:        a,
+				cert:        otherCrt,
+				provisioner: "Max",
+				err:         errors.New("authority.AuthorizeAdmin: certificate does not belong to an admin"),
+				code:        http.StatusForbidden,
+			}
+		},
+		"fail/token-not-enabled": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth:  a,
+				token: "token",
+				err:   errors.New("authority.AuthorizeAdmin: admin tokens are not enabled"),
+				code:  http.StatusUnauthorized,
+			}
+		},
+		"fail/token": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.config.AuthorityConfig.AdminProvisioner = "oidc"
+			a.config.AuthorityConfig.Provisioners = append(a.config.AuthorityConfig.Provisioners, &mockIdentityProvisioner{
+				JWK: &provisioner.JWK{Name: "oidc"},
+				authorizeIdentity: func(ctx context.Context, token string) (string, error) {
+					return "", errors.New("force")
+				},
+			})
+			return &authorizeTest{
+				auth:  a,
+				token: "token",
+				err:   errors.New("authority.AuthorizeAdmin: force"),
+				code:  http.StatusUnauthorized,
+			}
+		},
+		"ok/email": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				cert: adminCrt,
+				want: &Admin{Subject: "admin@smallstep.com", Role: AdminRoleSuperAdmin},
+			}
+		},
+		"fail/unknown-intermediate": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.rootX509Certs = append(a.rootX509Certs, otherRoot)
+			return &authorizeTest{
+				auth: a,
+				cert: secondaryCrt,
+				err:  errors.New("authority.AuthorizeAdmin: error verifying certificate"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"ok/secondary-intermediate": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.rootX509Certs = append(a.rootX509Certs, otherRoot)
+			a.x509Intermediates = append(a.x509Intermediates, &x509Intermediate{name: "other", cert: otherIntermediate, signer: otherKey})
+			return &authorizeTest{
+				auth: a,
+				cert: secondaryCrt,
+				want: &Admin{Subject: "admin@smallstep.com", Role: AdminRoleSuperAdmin},
+			}
+		},
+		"ok/common-name": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"foo", "other"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth:        a,
+				cert:        otherCrt,
+				provisioner: "Max",
+				want:        &Admin{Subject: "other", Role: AdminRoleSuperAdmin},
+			}
+		},
+		"ok/provisioner-admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.storedAdmins = map[string]*storedAdmin{
+				"other@smallstep.com": {record: &AdminRecord{Subject: "other@smallstep.com", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"Max"}, Version: 1}},
+			}
+			return &authorizeTest{
+				auth:        a,
+				cert:        otherCrt,
+				provisioner: "Max",
+				want:        &Admin{Subject: "other@smallstep.com", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"Max"}},
+			}
+		},
+		"ok/token": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			a.config.AuthorityConfig.AdminProvisioner = "oidc"
+			a.config.AuthorityConfig.Provisioners = append(a.config.AuthorityConfig.Provisioners, &mockIdentityProvisioner{
+				JWK: &provisioner.JWK{Name: "oidc"},
+				authorizeIdentity: func(ctx context.Context, token string) (string, error) {
+					assert.Equals(t, "token", token)
+					return "admin@smallstep.com", nil
+				},
+			})
+			return &authorizeTest{
+				auth:  a,
+				token: "token",
+				want:  &Admin{Subject: "admin@smallstep.com", Role: AdminRoleSuperAdmin},
+			}
+		},
+	}
+
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+
+			admin, err := tc.auth.AuthorizeAdmin(context.Background(), tc.cert, tc.token, tc.provisioner)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+
+					if tc.cert != nil {
+						ctxErr, ok := err.(*errs.Error)
+						assert.Fatal(t, ok, "error is not of type *errs.Error")
+						assert.Equals(t, ctxErr.Details["serialNumber"], tc.cert.SerialNumber.String())
+					}
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, admin)
+			}
+		})
+	}
+}
+
+func TestAuthority_AdminRecords(t *testing.T) {
+	stored := map[string][]byte{}
+	mockDB := &db.MockAuthDB{
+		MGetAdmins: func() ([][]byte, error) {
+			var list [][]byte
+			for _, b := range stored {
+				list = append(list, b)
+			}
+			return list, nil
+		},
+		MCmpAndSwapAdmin: func(subject string, oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(stored[subject], oldValue) {
+				return false, nil
+			}
+			stored[subject] = newValue
+			return true, nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+	a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+
+	assertError := func(err error, code int, msg string) {
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+			assert.HasPrefix(t, err.Error(), msg)
+		}
+	}
+
+	// Create
+	_, err := a.CreateAdmin("", AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusBadRequest, "subject cannot be empty")
+	_, err = a.CreateAdmin("jane@smallstep.com", "admin", nil)
+	assertError(err, http.StatusBadRequest, "role \"admin\" is not valid")
+	_, err = a.CreateAdmin("jane@smallstep.com", AdminRoleProvisionerAdmin, nil)
+	assertError(err, http.StatusBadRequest, "provisioners cannot be empty for a provisioner-admin")
+	_, err = a.CreateAdmin("jane@smallstep.com", AdminRoleSuperAdmin, []string{"Max"})
+	assertError(err, http.StatusBadRequest, "provisioners cannot be set for a super-admin")
+	_, err = a.CreateAdmin("admin@smallstep.com", AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusConflict, "admin admin@smallstep.com already exists")
+	r, err := a.CreateAdmin("jane@smallstep.com", AdminRoleProvisionerAdmin, []string{"Max"})
+	assert.FatalError(t, err)
+	assert.Equals(t, &AdminRecord{Subject: "jane@smallstep.com", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"Max"}, Version: 1}, r)
+	_, err = a.CreateAdmin("jane@smallstep.com", AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusConflict, "admin jane@smallstep.com already exists")
+
+	// Get
+	assert.Equals(t, []*AdminRecord{r}, a.GetAdminRecords())
+	got, err := a.GetAdminRecord("jane@smallstep.com")
+	assert.FatalError(t, err)
+	assert.Equals(t, r, got)
+	_, err = a.GetAdminRecord("admin@smallstep.com")
+	assertError(err, http.StatusBadRequest, "admin admin@smallstep.com is defined in the configuration and cannot be modified")
+	_, err = a.GetAdminRecord("foo")
+	assertError(err, http.StatusNotFound, "admin foo was not found")
+
+	// Update
+	_, err = a.UpdateAdmin("jane@smallstep.com", 2, AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusConflict, "admin jane@smallstep.com version 2 does not match the current version 1")
+	_, err = a.UpdateAdmin("admin@smallstep.com", 1, AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusBadRequest, "admin admin@smallstep.com is defined in the configuration and cannot be modified")
+	r, err = a.UpdateAdmin("jane@smallstep.com", 1, AdminRoleSuperAdmin, nil)
+	assert.FatalError(t, err)
+	assert.Equals(t, &AdminRecord{Subject: "jane@smallstep.com", Role: AdminRoleSuperAdmin, Version: 2}, r)
+	assert.Equals(t, &Admin{Subject: "jane@smallstep.com", Role: AdminRoleSuperAdmin}, a.findAdmin([]string{"jane", "jane@smallstep.com"}))
+
+	// A concurrent change in the database
+	a2 := testAuthority(t, WithDatabase(mockDB))
+	_, err = a2.UpdateAdmin("jane@smallstep.com", 2, AdminRoleProvisionerAdmin, []string{"step-cli"})
+	assert.FatalError(t, err)
+	_, err = a.UpdateAdmin("jane@smallstep.com", 2, AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusConflict, "admin jane@smallstep.com has been modified concurrently")
+
+	// Delete
+	a3 := testAuthority(t, WithDatabase(mockDB))
+	a3.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+	assertError(a3.DeleteAdmin("jane@smallstep.com", 2), http.StatusConflict, "admin jane@smallstep.com version 2 does not match the current version 3")
+	assertError(a3.DeleteAdmin("admin@smallstep.com", 1), http.StatusBadRequest, "admin admin@smallstep.com is defined in the configuration and cannot be modified")
+	assert.FatalError(t, a3.DeleteAdmin("jane@smallstep.com", 3))
+	assert.Equals(t, []*AdminRecord{}, a3.GetAdminRecords())
+	assert.Nil(t, a3.findAdmin([]string{"jane@smallstep.com"}))
+
+	// Create after delete
+	r, err = a3.CreateAdmin("jane@smallstep.com", AdminRoleSuperAdmin, nil)
+	assert.FatalError(t, err)
+	assert.Equals(t, 5, r.Version)
+
+	// Database without support
+	a4 := testAuthority(t)
+	_, err = a4.CreateAdmin("jane@smallstep.com", AdminRoleSuperAdmin, nil)
+	assertError(err, http.StatusNotImplemented, "storing admins is not implemented")
+}
@@ -0,0 +1,579 @@
+package authority
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/jose"
+)
+
+var (
+	auditWebhookTimeout = 30 * time.Second
+	// auditQueueSize is the number of events waiting to be written to the
+	// sinks before the requests block.
+	auditQueueSize = 1024
+)
+
+// Types of AuditSink.
+const (
+	AuditSinkFile    = "file"
+	AuditSinkSyslog  = "syslog"
+	AuditSinkWebhook = "webhook"
+	AuditSinkDB      = "db"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditX509Sign   = "x509.sign"
+	AuditX509Renew  = "x509.renew"
+	AuditX509Rekey  = "x509.rekey"
+	AuditX509Revoke = "x509.revoke"
+	AuditSSHSign    = "ssh.sign"
+	AuditSSHRenew   = "ssh.renew"
+	AuditSSHRekey   = "ssh.rekey"
+	AuditSSHRevoke  = "ssh.revoke"
+	AuditAdmin      = "admin"
+)
+
+// AuditConfig configures the audit log. Every event is written to all the
+// Sinks.
+type AuditConfig struct {
+	Sinks []*AuditSink `json:"sinks"`
+}
+
+// Validate checks the fields in AuditConfig, nil is ok.
+func (c *AuditConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Sinks) == 0 {
+		return errors.New("audit.sinks cannot be empty")
+	}
+	for _, s := range c.Sinks {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasDBSink returns true if the events are stored in the database.
+func (c *AuditConfig) hasDBSink() bool {
+	if c != nil {
+		for _, s := range c.Sinks {
+			if s != nil && s.Type == AuditSinkDB {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuditSink is a destination of the audit events.
+//
+// A file sink appends the events as JSON lines to the file in Path. A syslog
+// sink sends them to the syslog daemon in Address using Network, or to the
+// local one if they are empty, with the given Tag, step-ca by default. A
+// webhook receives a POST request with every event, signed with HMAC-SHA256
+// in the X-Smallstep-Signature header if the base64 encoded Secret is set. A
+// db sink stores them in the audit_log table of the database.
+type AuditSink struct {
+	Type    string `json:"type"`
+	Path    string `json:"path,omitempty"`
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+// Validate checks the fields required by the type of sink.
+func (s *AuditSink) Validate() error {
+	if s == nil {
+		return errors.New("audit.sinks cannot contain an empty value")
+	}
+	switch s.Type {
+	case AuditSinkFile:
+		if s.Path == "" {
+			return errors.New("audit.sinks path cannot be empty")
+		}
+	case AuditSinkSyslog:
+		if (s.Network == "") != (s.Address == "") {
+			return errors.New("audit.sinks network and address must be set together")
+		}
+	case AuditSinkWebhook:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.Errorf("audit.sinks contains an invalid url %s", s.URL)
+		}
+		if _, err := base64.StdEncoding.DecodeString(s.Secret); err != nil {
+			return errors.Wrap(err, "audit.sinks secret must be base64 encoded")
+		}
+	case AuditSinkDB:
+	default:
+		return errors.Errorf("audit.sinks type %q is not valid: it must be file, syslog, webhook or db", s.Type)
+	}
+	return nil
+}
+
+// AuditEvent is an entry of the audit log. The events are chained: PrevHash
+// is the Hash of the previous event, and Hash is the hex encoded SHA-256 of
+// the event in JSON without the Hash, so any modification, removal or
+// reordering of the events breaks the chain.
+//
+// Requester is the subject of the token, or the common name of the
+// certificate used to authenticate the request, TokenHash is the SHA-256 of
+// the token claims, and Serial is the serial number of the resulting
+// certificate, or the revoked one.
+type AuditEvent struct {
+	Seq            uint64    `json:"seq"`
+	Time           time.Time `json:"time"`
+	Action         string    `json:"action"`
+	Requester      string    `json:"requester,omitempty"`
+	Provisioner    string    `json:"provisioner,omitempty"`
+	TokenHash      string    `json:"tokenHash,omitempty"`
+	Serial         string    `json:"serial,omitempty"`
+	PreviousSerial string    `json:"previousSerial,omitempty"`
+	Subject        string    `json:"subject,omitempty"`
+	KeyID          string    `json:"keyID,omitempty"`
+	ReasonCode     int       `json:"reasonCode,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	Method         string    `json:"method,omitempty"`
+	Path           string    `json:"path,omitempty"`
+	Status         int       `json:"status,omitempty"`
+	PrevHash       string    `json:"prevHash"`
+	Hash           string    `json:"hash"`
+}
+
+// hash returns the hash of the event, the Hash field is ignored.
+func (e *AuditEvent) hash() (string, error) {
+	c := *e
+	c.Hash = ""
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling audit event")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyAuditEvents verifies the hash chain of the given events, sorted by
+// sequence number. The first event does not need to be the first one of the
+// log.
+func VerifyAuditEvents(events []*AuditEvent) error {
+	for i, e := range events {
+		h, err := e.hash()
+		if err != nil {
+			return err
+		}
+		if h != e.Hash {
+			return errors.Errorf("audit event %d has been modified", e.Seq)
+		}
+		if i == 0 {
+			if e.Seq == 1 && e.PrevHash != "" {
+				return errors.New("audit event 1 cannot have a previous hash")
+			}
+			continue
+		}
+		prev := events[i-1]
+		if e.Seq != prev.Seq+1 {
+			return errors.Errorf("audit events %d to %d are missing", prev.Seq+1, e.Seq-1)
+		}
+		if e.PrevHash != prev.Hash {
+			return errors.Errorf("audit event %d does not follow event %d", e.Seq, prev.Seq)
+		}
+	}
+	return nil
+}
+
+// ReadAuditLog reads the events written by a file sink.
+func ReadAuditLog(r io.Reader) ([]*AuditEvent, error) {
+	var events []*AuditEvent
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		e := new(AuditEvent)
+		if err := json.Unmarshal(line, e); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling audit event after %d events", len(events))
+		}
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading audit log")
+	}
+	return events, nil
+}
+
+// GetAuditEvents returns the events stored in the database by a db sink,
+// sorted by sequence number.
+func GetAuditEvents(d db.AuthDB) ([]*AuditEvent, error) {
+	list, err := d.GetAuditEvents()
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalAuditEvents(list)
+}
+
+// unmarshalAuditEvents decodes the events stored in the database.
+func unmarshalAuditEvents(list [][]byte) ([]*AuditEvent, error) {
+	events := make([]*AuditEvent, len(list))
+	for i, b := range list {
+		events[i] = new(AuditEvent)
+		if err := json.Unmarshal(b, events[i]); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling audit event")
+		}
+	}
+	return events, nil
+}
+
+// auditWriter is the interface implemented by the audit sinks.
+type auditWriter interface {
+	write(e *AuditEvent, b []byte) error
+	close() error
+}
+
+// auditHead is the interface implemented by the audit sinks that can return
+// the last event written, used to continue the hash chain after a restart.
+type auditHead interface {
+	last() (*AuditEvent, error)
+}
+
+// auditLogger assigns the sequence number and the hash to the events, and
+// writes them in order to all the sinks in the background.
+type auditLogger struct {
+	mu     sync.Mutex
+	seq    uint64
+	last   string
+	sinks  []auditWriter
+	events chan *AuditEvent
+	done   chan struct{}
+	closed bool
+}
+
+func newAuditLogger(c *AuditConfig, d db.AuthDB) (*auditLogger, error) {
+	l := &auditLogger{
+		events: make(chan *AuditEvent, auditQueueSize),
+		done:   make(chan struct{}),
+	}
+	for _, s := range c.Sinks {
+		w, err := newAuditWriter(s, d)
+		if err != nil {
+			l.closeSinks()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, w)
+	}
+
+	// Continue the chain from the most recent event in the sinks.
+	for _, w := range l.sinks {
+		h, ok := w.(auditHead)
+		if !ok {
+			continue
+		}
+		e, err := h.last()
+		if err != nil {
+			l.closeSinks()
+			return nil, err
+		}
+		if e != nil && e.Seq > l.seq {
+			l.seq, l.last = e.Seq, e.Hash
+		}
+	}
+
+	go l.run()
+	return l, nil
+}
+
+func newAuditWriter(s *AuditSink, d db.AuthDB) (auditWriter, error) {
+	switch s.Type {
+	case AuditSinkFile:
+		return newAuditFile(s.Path)
+	case AuditSinkSyslog:
+		return newAuditSyslog(s)
+	case AuditSinkWebhook:
+		secret, err := base64.StdEncoding.DecodeString(s.Secret)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding audit webhook secret")
+		}
+		return &auditWebhook{url: s.URL, secret: secret}, nil
+	case AuditSinkDB:
+		return &auditDB{db: d}, nil
+	default:
+		return nil, errors.Errorf("unsupported audit sink type %s", s.Type)
+	}
+}
+
+// record adds the event to the log. Nil loggers ignore the events, and closed
+// loggers discard them, e.g. if a request finishes after the shutdown timeout.
+func (l *auditLogger) record(e *AuditEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		log.Printf("error recording audit event: audit log is closed")
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Seq = l.seq + 1
+	e.PrevHash = l.last
+	h, err := e.hash()
+	if err != nil {
+		log.Printf("error recording audit event: %v", err)
+		return
+	}
+	e.Hash = h
+	l.seq, l.last = e.Seq, h
+	l.events <- e
+}
+
+func (l *auditLogger) run() {
+	defer close(l.done)
+	for e := range l.events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("error marshaling audit event %d: %v", e.Seq, err)
+			continue
+		}
+		for _, w := range l.sinks {
+			if err := w.write(e, b); err != nil {
+				log.Printf("error writing audit event %d: %v", e.Seq, err)
+			}
+		}
+	}
+}
+
+// close writes the pending events and closes the sinks.
+func (l *auditLogger) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+	<-l.done
+	return l.closeSinks()
+}
+
+func (l *auditLogger) closeSinks() error {
+	var firstErr error
+	for _, w := range l.sinks {
+		if err := w.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// auditFile appends the events to a file.
+type auditFile struct {
+	path string
+	f    *os.File
+}
+
+func newAuditFile(path string) (*auditFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", path)
+	}
+	return &auditFile{path: path, f: f}, nil
+}
+
+func (w *auditFile) write(e *AuditEvent, b []byte) error {
+	if _, err := w.f.Write(append(b, '\n')); err != nil {
+		return errors.Wrapf(err, "error writing %s", w.path)
+	}
+	return nil
+}
+
+func (w *auditFile) last() (*AuditEvent, error) {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", w.path)
+	}
+	defer f.Close()
+	events, err := ReadAuditLog(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", w.path)
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return events[len(events)-1], nil
+}
+
+func (w *auditFile) close() error {
+	return w.f.Close()
+}
+
+// auditWebhook posts the events to a URL.
+type auditWebhook struct {
+	url    string
+	secret []byte
+}
+
+func (w *auditWebhook) write(e *AuditEvent, b []byte) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "error creating request to %s", w.url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(b)
+		req.Header.Set(provisioner.WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := &http.Client{Timeout: auditWebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error posting audit event to %s", w.url)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("error posting audit event to %s: status code %d", w.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (w *auditWebhook) close() error {
+	return nil
+}
+
+// auditDB stores the events in the database.
+type auditDB struct {
+	db db.AuthDB
+}
+
+func (w *auditDB) write(e *AuditEvent, b []byte) error {
+	return w.db.StoreAuditEvent(e.Seq, b)
+}
+
+func (w *auditDB) last() (*AuditEvent, error) {
+	b, err := w.db.GetLastAuditEvent()
+	if err != nil || b == nil {
+		return nil, err
+	}
+	e := new(AuditEvent)
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling audit event")
+	}
+	return e, nil
+}
+
+func (w *auditDB) close() error {
+	return nil
+}
+
+// auditToken is the sign option added by Authorize when the audit log is
+// enabled, it identifies the requester of the certificate.
+type auditToken struct {
+	requester   string
+	provisioner string
+	hash        string
+}
+
+// newAuditToken returns the audit information of an already authorized
+// token.
+func (a *Authority) newAuditToken(token string) *auditToken {
+	at := new(auditToken)
+	tok, err := jose.ParseSigned(token)
+	if err != nil {
+		return at
+	}
+	var (
+		payload json.RawMessage
+		claims  Claims
+	)
+	if err := tok.UnsafeClaimsWithoutVerification(&payload, &claims); err == nil {
+		sum := sha256.Sum256(payload)
+		at.hash = hex.EncodeToString(sum[:])
+		at.requester = claims.Subject
+		if p, ok := a.provisioners.LoadByToken(tok, &claims.Claims); ok {
+			at.provisioner = p.GetName()
+		}
+	}
+	return at
+}
+
+// withAuditToken adds the audit information of the token to the sign options
+// if the audit log or the metrics are enabled.
+func (a *Authority) withAuditToken(signOpts []provisioner.SignOption, token string) []provisioner.SignOption {
+	if a.audit == nil && a.metrics == nil {
+		return signOpts
+	}
+	return append(signOpts, a.newAuditToken(token))
+}
+
+// event returns a new event with the token information, at can be nil.
+func (at *auditToken) event(action string) *AuditEvent {
+	e := &AuditEvent{Action: action}
+	if at != nil {
+		e.Requester = at.requester
+		e.Provisioner = at.provisioner
+		e.TokenHash = at.hash
+	}
+	return e
+}
+
+// findAuditToken returns the audit information in the sign options, if any.
+func findAuditToken(signOpts []provisioner.SignOption) *auditToken {
+	for _, op := range signOpts {
+		if at, ok := op.(*auditToken); ok {
+			return at
+		}
+	}
+	return nil
+}
+
+// initAudit starts the audit log if it's configured.
+func (a *Authority) initAudit() error {
+	if a.config.Audit == nil {
+		return nil
+	}
+	l, err := newAuditLogger(a.config.Audit, a.db)
+	if err != nil {
+		return errors.Wrap(err, "error initializing audit log")
+	}
+	a.audit = l
+	return nil
+}
+
+// Audit records the given event in the audit log, if it's enabled. The
+// sequence number, the time and the hashes of the event are set by the log.
+func (a *Authority) Audit(e *AuditEvent) {
+	a.audit.record(e)
+}
+
+// StopAudit writes the pending events and closes the sinks of the audit log.
+func (a *Authority) StopAudit() error {
+	err := a.audit.close()
+	a.audit = nil
+	return err
+}
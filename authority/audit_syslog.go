@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package authority
+
+import (
+	"log/syslog"
+
+	"github.com/pkg/errors"
+)
+
+// auditSyslog sends the events to a syslog daemon.
+type auditSyslog struct {
+	w *syslog.Writer
+}
+
+func newAuditSyslog(s *AuditSink) (auditWriter, error) {
+	tag := s.Tag
+	if tag == "" {
+		tag = "step-ca"
+	}
+	w, err := syslog.Dial(s.Network, s.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to syslog")
+	}
+	return &auditSyslog{w: w}, nil
+}
+
+func (w *auditSyslog) write(e *AuditEvent, b []byte) error {
+	if err := w.w.Info(string(b)); err != nil {
+		return errors.Wrap(err, "error writing to syslog")
+	}
+	return nil
+}
+
+func (w *auditSyslog) close() error {
+	return w.w.Close()
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package authority
+
+import (
+	"github.com/pkg/errors"
+)
+
+func newAuditSyslog(s *AuditSink) (auditWriter, error) {
+	return nil, errors.New("audit syslog sink is not supported on this platform")
+}
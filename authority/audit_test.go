@@ -0,0 +1,257 @@
+package authority
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestAuditConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *AuditConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", &AuditConfig{Sinks: []*AuditSink{
+			{Type: "file", Path: "audit.log"},
+			{Type: "syslog"},
+			{Type: "syslog", Network: "udp", Address: "localhost:514"},
+			{Type: "webhook", URL: "https://example.com/audit", Secret: "c2VjcmV0"},
+			{Type: "db"},
+		}}, false},
+		{"fail/empty", &AuditConfig{}, true},
+		{"fail/nil-sink", &AuditConfig{Sinks: []*AuditSink{nil}}, true},
+		{"fail/type", &AuditConfig{Sinks: []*AuditSink{{Type: "kafka"}}}, true},
+		{"fail/path", &AuditConfig{Sinks: []*AuditSink{{Type: "file"}}}, true},
+		{"fail/syslog", &AuditConfig{Sinks: []*AuditSink{{Type: "syslog", Network: "udp"}}}, true},
+		{"fail/url", &AuditConfig{Sinks: []*AuditSink{{Type: "webhook", URL: "ftp://example.com"}}}, true},
+		{"fail/secret", &AuditConfig{Sinks: []*AuditSink{{Type: "webhook", URL: "https://example.com", Secret: "%"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("AuditConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func readAuditFile(t *testing.T, path string) []*AuditEvent {
+	f, err := os.Open(path)
+	assert.FatalError(t, err)
+	defer f.Close()
+	events, err := ReadAuditLog(f)
+	assert.FatalError(t, err)
+	return events
+}
+
+func TestAuditLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	stored := map[uint64][]byte{}
+	var last []byte
+	mockDB := &db.MockAuthDB{
+		MStoreAuditEvent: func(seq uint64, event []byte) error {
+			stored[seq] = event
+			last = event
+			return nil
+		},
+		MGetLastAuditEvent: func() ([]byte, error) {
+			return last, nil
+		},
+	}
+	c := &AuditConfig{Sinks: []*AuditSink{{Type: "file", Path: path}, {Type: "db"}}}
+
+	l, err := newAuditLogger(c, mockDB)
+	assert.FatalError(t, err)
+	l.record(&AuditEvent{Action: AuditX509Sign, Requester: "foo", Serial: "1"})
+	l.record(&AuditEvent{Action: AuditX509Revoke, Requester: "foo", Serial: "1"})
+	assert.FatalError(t, l.close())
+
+	events := readAuditFile(t, path)
+	assert.Len(t, 2, events)
+	assert.Equals(t, uint64(1), events[0].Seq)
+	assert.Equals(t, "", events[0].PrevHash)
+	assert.Equals(t, events[0].Hash, events[1].PrevHash)
+	assert.Nil(t, VerifyAuditEvents(events))
+	assert.Len(t, 2, stored)
+	dbEvents, err := unmarshalAuditEvents([][]byte{stored[1], stored[2]})
+	assert.FatalError(t, err)
+	assert.Equals(t, events, dbEvents)
+
+	// The chain continues after a restart.
+	l, err = newAuditLogger(c, mockDB)
+	assert.FatalError(t, err)
+	l.record(&AuditEvent{Action: AuditAdmin, Requester: "admin"})
+	assert.FatalError(t, l.close())
+	events = readAuditFile(t, path)
+	assert.Len(t, 3, events)
+	assert.Equals(t, uint64(3), events[2].Seq)
+	assert.Nil(t, VerifyAuditEvents(events))
+
+	// The events recorded after the close are discarded.
+	l.record(&AuditEvent{Action: AuditAdmin, Requester: "admin"})
+	assert.FatalError(t, l.close())
+	assert.Len(t, 3, readAuditFile(t, path))
+
+	// Errors reading the head
+	mockDB.MGetLastAuditEvent = func() ([]byte, error) {
+		return nil, errors.New("force")
+	}
+	_, err = newAuditLogger(c, mockDB)
+	assert.Error(t, err)
+}
+
+func TestAuditLogger_webhook(t *testing.T) {
+	secret := []byte("secret")
+	var got []*AuditEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(b)
+		assert.Equals(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get(provisioner.WebhookSignatureHeader))
+		e := new(AuditEvent)
+		assert.FatalError(t, json.Unmarshal(b, e))
+		got = append(got, e)
+	}))
+	defer srv.Close()
+
+	l, err := newAuditLogger(&AuditConfig{Sinks: []*AuditSink{
+		{Type: "webhook", URL: srv.URL, Secret: "c2VjcmV0"},
+	}}, nil)
+	assert.FatalError(t, err)
+	l.record(&AuditEvent{Action: AuditSSHSign, Serial: "1234"})
+	assert.FatalError(t, l.close())
+	assert.Len(t, 1, got)
+	assert.Equals(t, "1234", got[0].Serial)
+	assert.Nil(t, VerifyAuditEvents(got))
+}
+
+func TestVerifyAuditEvents(t *testing.T) {
+	newChain := func() []*AuditEvent {
+		l := &auditLogger{events: make(chan *AuditEvent, 3)}
+		var events []*AuditEvent
+		for i := 0; i < 3; i++ {
+			e := &AuditEvent{Action: AuditX509Sign, Serial: "1"}
+			l.record(e)
+			events = append(events, e)
+		}
+		return events
+	}
+
+	events := newChain()
+	assert.Nil(t, VerifyAuditEvents(events))
+	assert.Nil(t, VerifyAuditEvents(events[1:]))
+	assert.Nil(t, VerifyAuditEvents(nil))
+
+	events[1].Serial = "2"
+	assert.Equals(t, "audit event 2 has been modified", VerifyAuditEvents(events).Error())
+
+	events = newChain()
+	assert.Equals(t, "audit events 2 to 2 are missing", VerifyAuditEvents([]*AuditEvent{events[0], events[2]}).Error())
+
+	events = newChain()
+	events[0].PrevHash = "foo"
+	events[0].Hash, _ = events[0].hash()
+	assert.Equals(t, "audit event 1 cannot have a previous hash", VerifyAuditEvents(events).Error())
+
+	events = newChain()
+	events[0].Requester = "foo"
+	events[0].Hash, _ = events[0].hash()
+	assert.Equals(t, "audit event 2 does not follow event 1", VerifyAuditEvents(events).Error())
+}
+
+func TestAuthority_audit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	a := testAuthority(t)
+	a.config.Audit = &AuditConfig{Sinks: []*AuditSink{{Type: "file", Path: path}}}
+	assert.FatalError(t, a.initAudit())
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return false, nil
+		},
+		MRevoke: func(rci *db.RevokedCertificateInfo) error {
+			return nil
+		},
+	}
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	signOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, signOpts...)
+	assert.FatalError(t, err)
+	renewed, err := a.Renew(certChain[0])
+	assert.FatalError(t, err)
+	assert.FatalError(t, a.Revoke(provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod), &RevokeOptions{
+		Serial:     renewed[0].SerialNumber.String(),
+		ReasonCode: 1,
+		Reason:     "key compromise",
+		MTLS:       true,
+		Crt:        renewed[0],
+	}))
+	assert.FatalError(t, a.StopAudit())
+
+	events := readAuditFile(t, path)
+	assert.Len(t, 3, events)
+	assert.Nil(t, VerifyAuditEvents(events))
+
+	tok, err := jose.ParseSigned(token)
+	assert.FatalError(t, err)
+	var payload json.RawMessage
+	assert.FatalError(t, tok.UnsafeClaimsWithoutVerification(&payload))
+	sum := sha256.Sum256(payload)
+
+	assert.Equals(t, AuditX509Sign, events[0].Action)
+	assert.Equals(t, "smallstep test", events[0].Requester)
+	assert.Equals(t, "step-cli", events[0].Provisioner)
+	assert.Equals(t, hex.EncodeToString(sum[:]), events[0].TokenHash)
+	assert.Equals(t, certChain[0].SerialNumber.String(), events[0].Serial)
+	assert.Equals(t, "smallstep test", events[0].Subject)
+
+	assert.Equals(t, AuditX509Renew, events[1].Action)
+	assert.Equals(t, "smallstep test", events[1].Requester)
+	assert.Equals(t, "step-cli", events[1].Provisioner)
+	assert.Equals(t, "", events[1].TokenHash)
+	assert.Equals(t, renewed[0].SerialNumber.String(), events[1].Serial)
+	assert.Equals(t, certChain[0].SerialNumber.String(), events[1].PreviousSerial)
+
+	assert.Equals(t, AuditX509Revoke, events[2].Action)
+	assert.Equals(t, "smallstep test", events[2].Requester)
+	assert.Equals(t, "step-cli", events[2].Provisioner)
+	assert.Equals(t, renewed[0].SerialNumber.String(), events[2].Serial)
+	assert.Equals(t, 1, events[2].ReasonCode)
+	assert.Equals(t, "key compromise", events[2].Reason)
+}
@@ -6,14 +6,19 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"log"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/ct"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/kms"
 	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/lint"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
 	"github.com/smallstep/cli/crypto/pemutil"
@@ -31,11 +36,34 @@ type Authority struct {
 	provisioners *provisioner.Collection
 	db           db.AuthDB
 
+	// Authority policy, the stored record replaces the configuration.
+	policy       *policy.Policy
+	policyRecord *PolicyRecord
+	policyValue  []byte
+	policyMutex  sync.RWMutex
+
+	// Serializes the changes of the provisioner keys.
+	provisionerKeysMutex sync.Mutex
+
+	// Provisioners managed with the admin API, and the configuration used to
+	// initialize them.
+	storedProvisioners  map[string]*storedProvisioner
+	provisionersMutex   sync.RWMutex
+	provisionerConfig   provisioner.Config
+	provisionersStopped bool
+
+	// Administrators managed with the admin API.
+	storedAdmins map[string]*storedAdmin
+	adminsMutex  sync.RWMutex
+
 	// X509 CA
 	rootX509Certs      []*x509.Certificate
 	federatedX509Certs []*x509.Certificate
 	x509Signer         crypto.Signer
 	x509Issuer         *x509.Certificate
+	x509IssuerMutex    sync.RWMutex
+	x509IssuerValue    []byte
+	x509Intermediates  []*x509Intermediate
 	certificates       *sync.Map
 
 	// SSH CA
@@ -45,6 +73,57 @@ type Authority struct {
 	sshCAHostCerts          []ssh.PublicKey
 	sshCAUserFederatedCerts []ssh.PublicKey
 	sshCAHostFederatedCerts []ssh.PublicKey
+	sshCAUserKeyRotation    *sshKeyRotation
+	sshCAHostKeyRotation    *sshKeyRotation
+
+	// CRL
+	crlIssuer *x509.Certificate
+	crlSigner crypto.Signer
+	crl       []byte
+	crlNumber int64
+	crlStop   chan struct{}
+	crlMutex  sync.RWMutex
+
+	// Certificate Transparency
+	ctLogs []*ct.Client
+
+	// CAA checks
+	caa *caaChecker
+
+	// Serial number generator
+	serial *serialGenerator
+
+	// Summaries of the short-lived certificates
+	shortLived *shortLivedIssuer
+
+	// Notifications of the expiring certificates
+	expirationStop chan struct{}
+
+	// Remote authorities in the federation
+	federatedAuthorities []*federatedAuthority
+	federationStop       chan struct{}
+	federationMutex      sync.RWMutex
+
+	// Synchronization with the other instances sharing the database
+	instanceID  string
+	clusterStop chan struct{}
+
+	// Audit log
+	audit *auditLogger
+
+	// Notifications of the lifecycle events
+	events *eventDispatcher
+
+	// Metrics of the operations and the database
+	metrics MetricsObserver
+
+	// Linters of the authority and the profiles
+	linter         *lint.Linter
+	profileLinters map[string]*lint.Linter
+
+	// Root rotation
+	rootRotation      *RootRotation
+	rootRotationMutex sync.RWMutex
 
 	// Do not re-initialize
 	initOnce  bool
@@ -112,6 +191,9 @@ func (a *Authority) init() error {
 		if a.db, err = db.New(a.config.DB); err != nil {
 			return err
 		}
+		if a.metrics != nil {
+			a.db = db.WithObserver(a.db, a.metrics.ObserveDB)
+		}
 	}
 
 	// Read root certificates and store them in the certificates map.
@@ -148,7 +230,7 @@ func (a *Authority) init() error {
 
 	// Read intermediate and create X509 signer.
 	if a.x509Signer == nil {
-		crt, err := pemutil.ReadCertificate(a.config.IntermediateCert)
+		crt, err := a.readCertificate(a.config.IntermediateCert)
 		if err != nil {
 			return err
 		}
@@ -162,6 +244,14 @@ func (a *Authority) init() error {
 		a.x509Signer = signer
 		a.x509Issuer = crt
 	}
+	if err := a.initRenewedIntermediate(); err != nil {
+		return err
+	}
+
+	// Read the additional intermediates.
+	if err := a.initIntermediates(); err != nil {
+		return err
+	}
 
 	// Decrypt and load SSH keys
 	if a.config.SSH != nil {
@@ -180,6 +270,11 @@ func (a *Authority) init() error {
 			// Append public key to list of host certs
 			a.sshCAHostCerts = append(a.sshCAHostCerts, a.sshCAHostCertSignKey.PublicKey())
 			a.sshCAHostFederatedCerts = append(a.sshCAHostFederatedCerts, a.sshCAHostCertSignKey.PublicKey())
+			if r := a.config.SSH.HostKeyRotation; r != nil {
+				if a.sshCAHostKeyRotation, err = a.newSSHKeyRotation(r); err != nil {
+					return err
+				}
+			}
 		}
 		if a.config.SSH.UserKey != "" {
 			signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
@@ -196,6 +291,11 @@ func (a *Authority) init() error {
 			// Append public key to list of user certs
 			a.sshCAUserCerts = append(a.sshCAUserCerts, a.sshCAUserCertSignKey.PublicKey())
 			a.sshCAUserFederatedCerts = append(a.sshCAUserFederatedCerts, a.sshCAUserCertSignKey.PublicKey())
+			if r := a.config.SSH.UserKeyRotation; r != nil {
+				if a.sshCAUserKeyRotation, err = a.newSSHKeyRotation(r); err != nil {
+					return err
+				}
+			}
 		}
 
 		// Append other public keys
@@ -219,8 +319,67 @@ func (a *Authority) init() error {
 		}
 	}
 
-	// Merge global and configuration claims
-	claimer, err := provisioner.NewClaimer(a.config.AuthorityConfig.Claims, globalProvisionerClaims)
+	// Load the CRL signer and start the generation of the CRL.
+	if err := a.initCRL(); err != nil {
+		return err
+	}
+
+	// Load the rotation of the root certificate.
+	if err := a.initRootRotation(); err != nil {
+		return err
+	}
+
+	// Initialize the clients of the Certificate Transparency logs.
+	a.initCT()
+
+	// Initialize the CAA checker.
+	if err := a.initCAA(); err != nil {
+		return err
+	}
+
+	// Initialize the serial number generator.
+	if err := a.initSerial(); err != nil {
+		return err
+	}
+
+	// Initialize the summaries of the short-lived certificates.
+	a.initShortLived()
+
+	// Add the certificates stored before the index of certificates existed.
+	a.initCertificateIndex()
+
+	// Start the notifications of the expiring certificates.
+	a.initExpirationNotifier()
+
+	// Start fetching the roots of the remote authorities in the federation.
+	a.initFederation()
+
+	// Start the audit log.
+	if err := a.initAudit(); err != nil {
+		return err
+	}
+
+	// Start the notifications of the lifecycle events.
+	if err := a.initEvents(); err != nil {
+		return err
+	}
+
+	// Initialize the authority policy.
+	if err := a.initPolicy(); err != nil {
+		return err
+	}
+
+	// Initialize the linters, the options are already validated.
+	if err := a.initLint(); err != nil {
+		return err
+	}
+
+	// Merge global and configuration claims, the backdate and the clock skew
+	// of the authority are the defaults of the provisioners.
+	globalClaims := globalProvisionerClaims
+	globalClaims.Backdate = a.config.AuthorityConfig.Backdate
+	globalClaims.ClockSkew = a.config.AuthorityConfig.ClockSkew
+	claimer, err := provisioner.NewClaimer(a.config.AuthorityConfig.Claims, globalClaims)
 	if err != nil {
 		return err
 	}
@@ -241,8 +400,15 @@ func (a *Authority) init() error {
 			HostKeys: sshKeys.HostKeys,
 		},
 		GetIdentityFunc: a.getIdentityFunc,
+		Proxy:           a.config.AuthorityConfig.Proxy,
+		TemplatesDir:    a.config.AuthorityConfig.TemplatesDirectory,
 	}
 	// Store all the provisioners
+	for _, p := range a.config.AuthorityConfig.Profiles {
+		if err := p.Init(config); err != nil {
+			return err
+		}
+	}
 	for _, p := range a.config.AuthorityConfig.Provisioners {
 		if err := p.Init(config); err != nil {
 			return err
@@ -251,6 +417,19 @@ func (a *Authority) init() error {
 			return err
 		}
 	}
+	a.provisionerConfig = config
+	if err := a.loadStoredProvisioners(); err != nil {
+		return err
+	}
+	if err := a.loadStoredAdmins(); err != nil {
+		return err
+	}
+	if err := a.loadProvisionerKeys(); err != nil {
+		return err
+	}
+
+	// Start the synchronization with the other instances of the cluster.
+	a.initCluster()
 
 	// Configure protected template variables:
 	if t := a.config.Templates; t != nil {
@@ -259,13 +438,21 @@ func (a *Authority) init() error {
 		}
 		var vars templates.Step
 		if a.config.SSH != nil {
+			// During a key rotation the new keys are trusted as federated
+			// ones.
 			if a.sshCAHostCertSignKey != nil {
 				vars.SSH.HostKey = a.sshCAHostCertSignKey.PublicKey()
 				vars.SSH.HostFederatedKeys = append(vars.SSH.HostFederatedKeys, a.sshCAHostFederatedCerts[1:]...)
+				if r := a.sshCAHostKeyRotation; r != nil {
+					vars.SSH.HostFederatedKeys = append(vars.SSH.HostFederatedKeys, r.signer.PublicKey())
+				}
 			}
 			if a.sshCAUserCertSignKey != nil {
 				vars.SSH.UserKey = a.sshCAUserCertSignKey.PublicKey()
 				vars.SSH.UserFederatedKeys = append(vars.SSH.UserFederatedKeys, a.sshCAUserFederatedCerts[1:]...)
+				if r := a.sshCAUserKeyRotation; r != nil {
+					vars.SSH.UserFederatedKeys = append(vars.SSH.UserFederatedKeys, r.signer.PublicKey())
+				}
 			}
 		}
 		t.Data["Step"] = vars
@@ -286,7 +473,38 @@ func (a *Authority) GetDatabase() db.AuthDB {
 	return a.db
 }
 
+// readCertificate reads the certificate in the given PEM file. If the file does
+// not exist and the key manager can store certificates, e.g. Azure Key Vault,
+// the certificate is loaded from the key manager.
+func (a *Authority) readCertificate(name string) (*x509.Certificate, error) {
+	if cm, ok := a.keyManager.(kms.CertificateManager); ok {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return cm.LoadCertificate(&kmsapi.LoadCertificateRequest{Name: name})
+		}
+	}
+	return pemutil.ReadCertificate(name)
+}
+
 // Shutdown safely shuts down any clients, databases, etc. held by the Authority.
 func (a *Authority) Shutdown() error {
+	a.StopCRLGenerator()
+	a.StopExpirationNotifier()
+	a.StopFederation()
+	a.StopCluster()
+	a.StopProvisioners()
+	if err := a.StopAudit(); err != nil {
+		log.Printf("error closing audit log: %v", err)
+	}
+	if err := a.StopEvents(); err != nil {
+		log.Printf("error stopping events: %v", err)
+	}
+	if err := a.StopShortLived(); err != nil {
+		log.Printf("error storing short-lived summaries: %v", err)
+	}
+	if a.keyManager != nil {
+		if err := a.keyManager.Close(); err != nil {
+			log.Printf("error closing key manager: %v", err)
+		}
+	}
 	return a.db.Shutdown()
 }
@@ -1,7 +1,9 @@
 package authority
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
 	"reflect"
 	"testing"
@@ -10,6 +12,9 @@ import (
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/cli/crypto/pemutil"
 	stepJOSE "github.com/smallstep/cli/jose"
 )
 
@@ -182,3 +187,43 @@ func TestAuthority_GetDatabase(t *testing.T) {
 		})
 	}
 }
+
+// certificateKMS is a softkms that can also load certificates.
+type certificateKMS struct {
+	*softkms.SoftKMS
+	certs map[string]*x509.Certificate
+}
+
+func (k *certificateKMS) LoadCertificate(req *kmsapi.LoadCertificateRequest) (*x509.Certificate, error) {
+	crt, ok := k.certs[req.Name]
+	if !ok {
+		return nil, errors.Errorf("certificate %s not found", req.Name)
+	}
+	return crt, nil
+}
+
+func TestAuthority_readCertificate(t *testing.T) {
+	crt, err := pemutil.ReadCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	soft, err := softkms.New(context.Background(), kmsapi.Options{})
+	assert.FatalError(t, err)
+
+	// The intermediate is loaded from the key manager.
+	a := testAuthority(t, WithKeyManager(&certificateKMS{
+		SoftKMS: soft,
+		certs:   map[string]*x509.Certificate{"kms:intermediate": crt},
+	}))
+	got, err := a.readCertificate("kms:intermediate")
+	assert.FatalError(t, err)
+	assert.Equals(t, crt, got)
+	got, err = a.readCertificate("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	assert.Equals(t, crt, got)
+	_, err = a.readCertificate("kms:missing")
+	assert.Error(t, err)
+
+	// Files are required without a certificate manager.
+	a = testAuthority(t)
+	_, err = a.readCertificate("kms:intermediate")
+	assert.Error(t, err)
+}
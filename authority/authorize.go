@@ -4,10 +4,13 @@ import (
 	"context"
 	"crypto/x509"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ssh"
 )
@@ -20,6 +23,10 @@ type Claims struct {
 	Nonce string   `json:"nonce,omitempty"`
 }
 
+// maxRenewTokenLifetime is the maximum time between the issued at and the
+// expiration of a renew token.
+const maxRenewTokenLifetime = 5 * time.Minute
+
 type skipTokenReuseKey struct{}
 
 // NewContextWithSkipTokenReuse creates a new context from ctx and attaches a
@@ -71,7 +78,9 @@ func (a *Authority) authorizeToken(ctx context.Context, token string) (provision
 	// Store the token to protect against reuse unless it's skipped.
 	if !SkipTokenReuseFromContext(ctx) {
 		if reuseKey, err := p.GetTokenID(token); err == nil {
+			_, span := tracing.Start(ctx, "db.UseToken")
 			ok, err := a.db.UseToken(reuseKey, token)
+			tracing.End(span, err)
 			if err != nil {
 				return nil, errs.Wrap(http.StatusInternalServerError, err,
 					"authority.authorizeToken: failed when attempting to store token")
@@ -85,15 +94,58 @@ func (a *Authority) authorizeToken(ctx context.Context, token string) (provision
 	return p, nil
 }
 
+// authorizeInstance records the instance of a token already authorized by a
+// cloud provisioner, so the identity document of an instance can only be used
+// to bootstrap one certificate. Tokens that do not identify an instance are
+// ignored.
+func (a *Authority) authorizeInstance(ctx context.Context, p provisioner.Interface, token string) error {
+	if SkipTokenReuseFromContext(ctx) {
+		return nil
+	}
+	ii, ok := p.(provisioner.InstanceIdentifier)
+	if !ok {
+		return nil
+	}
+	instanceKey, ok := ii.GetInstanceID(token)
+	if !ok {
+		return nil
+	}
+	ok, err := a.db.UseToken(instanceKey, token)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err,
+			"authority.authorizeInstance: failed when attempting to store instance")
+	}
+	if !ok {
+		return errs.Unauthorized("authority.authorizeInstance: instance already used")
+	}
+	return nil
+}
+
 // Authorize grabs the method from the context and authorizes the request by
 // validating the one-time-token.
 func (a *Authority) Authorize(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+	tctx, span := tracing.Start(ctx, "authority.Authorize")
+	signOpts, err := a.authorize(tctx, token)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	if provisioner.MethodFromContext(ctx) == provisioner.SignMethod {
+		signOpts = withTraceContext(signOpts, ctx)
+	}
+	return signOpts, nil
+}
+
+func (a *Authority) authorize(ctx context.Context, token string) ([]provisioner.SignOption, error) {
 	var opts = []interface{}{errs.WithKeyVal("token", token)}
 
 	switch m := provisioner.MethodFromContext(ctx); m {
 	case provisioner.SignMethod:
 		signOpts, err := a.authorizeSign(ctx, token)
-		return signOpts, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		}
+		return a.withAuditToken(signOpts, token), nil
 	case provisioner.RevokeMethod:
 		return nil, errs.Wrap(http.StatusInternalServerError, a.authorizeRevoke(ctx, token), "authority.Authorize", opts...)
 	case provisioner.SSHSignMethod:
@@ -101,13 +153,19 @@ func (a *Authority) Authorize(ctx context.Context, token string) ([]provisioner.
 			return nil, errs.NotImplemented("authority.Authorize; ssh certificate flows are not enabled", opts...)
 		}
 		signOpts, err := a.authorizeSSHSign(ctx, token)
-		return signOpts, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		}
+		return a.withAuditToken(signOpts, token), nil
 	case provisioner.SSHRenewMethod:
 		if a.sshCAHostCertSignKey == nil && a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("authority.Authorize; ssh certificate flows are not enabled", opts...)
 		}
-		_, err := a.authorizeSSHRenew(ctx, token)
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		_, signOpts, err := a.authorizeSSHRenew(ctx, token)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		}
+		return a.withAuditToken(signOpts, token), nil
 	case provisioner.SSHRevokeMethod:
 		return nil, errs.Wrap(http.StatusInternalServerError, a.authorizeSSHRevoke(ctx, token), "authority.Authorize", opts...)
 	case provisioner.SSHRekeyMethod:
@@ -115,7 +173,10 @@ func (a *Authority) Authorize(ctx context.Context, token string) ([]provisioner.
 			return nil, errs.NotImplemented("authority.Authorize; ssh certificate flows are not enabled", opts...)
 		}
 		_, signOpts, err := a.authorizeSSHRekey(ctx, token)
-		return signOpts, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Authorize", opts...)
+		}
+		return a.withAuditToken(signOpts, token), nil
 	default:
 		return nil, errs.InternalServer("authority.Authorize; method %d is not supported", append([]interface{}{m}, opts...)...)
 	}
@@ -128,10 +189,15 @@ func (a *Authority) authorizeSign(ctx context.Context, token string) ([]provisio
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSign")
 	}
-	signOpts, err := p.AuthorizeSign(ctx, token)
+	pctx, span := tracing.Start(ctx, "provisioner.AuthorizeSign", provisionerAttributes(p)...)
+	signOpts, err := p.AuthorizeSign(pctx, token)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSign")
 	}
+	if err := a.authorizeInstance(ctx, p, token); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.authorizeSign")
+	}
 	return signOpts, nil
 }
 
@@ -166,13 +232,16 @@ func (a *Authority) authorizeRevoke(ctx context.Context, token string) error {
 func (a *Authority) authorizeRenew(cert *x509.Certificate) error {
 	var opts = []interface{}{errs.WithKeyVal("serialNumber", cert.SerialNumber.String())}
 
-	// Check the passive revocation table.
-	isRevoked, err := a.db.IsRevoked(cert.SerialNumber.String())
-	if err != nil {
-		return errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeRenew", opts...)
-	}
-	if isRevoked {
-		return errs.Unauthorized("authority.authorizeRenew: certificate has been revoked", opts...)
+	// Check the passive revocation table, short-lived certificates cannot be
+	// revoked.
+	if _, ok := a.isShortLived(cert); !ok {
+		isRevoked, err := a.db.IsRevoked(cert.SerialNumber.String())
+		if err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeRenew", opts...)
+		}
+		if isRevoked {
+			return errs.Unauthorized("authority.authorizeRenew: certificate has been revoked", opts...)
+		}
 	}
 
 	p, ok := a.provisioners.LoadByCertificate(cert)
@@ -185,6 +254,76 @@ func (a *Authority) authorizeRenew(cert *x509.Certificate) error {
 	return nil
 }
 
+// AuthorizeRenewToken validates a renew token and returns the certificate to
+// renew. Renew tokens are used by clients that cannot use their certificate in
+// an mTLS connection, e.g. because it has expired. The token must contain the
+// certificate chain in the x5cInsecure header, it must be signed with the key
+// of the certificate, and its audience must be the renew endpoint. The token
+// must have the iat, nbf and exp claims, a lifetime of at most
+// maxRenewTokenLifetime, and a jti that is stored to prevent its reuse. The
+// period in which an expired certificate can be renewed is checked by the
+// provisioner.
+func (a *Authority) AuthorizeRenewToken(ctx context.Context, token string) (*x509.Certificate, error) {
+	jwt, chains, err := jose.ParseX5cInsecure(token, a.rootX509Certs)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.AuthorizeRenewToken")
+	}
+	leaf := chains[0][0]
+	var opts = []interface{}{errs.WithKeyVal("serialNumber", leaf.SerialNumber.String())}
+
+	var claims jose.Claims
+	if err := jwt.Claims(leaf.PublicKey, &claims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.AuthorizeRenewToken: error parsing token claims", opts...)
+	}
+	if err := claims.ValidateWithLeeway(jose.Expected{
+		Time: time.Now().UTC(),
+	}, a.config.AuthorityConfig.ClockSkew.Duration); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.AuthorizeRenewToken: invalid token claims", opts...)
+	}
+	if audiences := a.config.getAudiences().Renew; !matchesRenewAudience(claims.Audience, audiences) {
+		return nil, errs.Unauthorized("authority.AuthorizeRenewToken: invalid token audience claim (aud); "+
+			"want %s, but got %s", append([]interface{}{audiences, claims.Audience}, opts...)...)
+	}
+	switch {
+	case claims.IssuedAt == nil || claims.NotBefore == nil || claims.Expiry == nil:
+		return nil, errs.Unauthorized("authority.AuthorizeRenewToken: token must have the iat, nbf and exp claims", opts...)
+	case claims.Expiry.Time().Sub(claims.IssuedAt.Time()) > maxRenewTokenLifetime:
+		return nil, errs.Unauthorized("authority.AuthorizeRenewToken: token lifetime cannot be longer than %s", append([]interface{}{maxRenewTokenLifetime}, opts...)...)
+	case claims.ID == "":
+		return nil, errs.Unauthorized("authority.AuthorizeRenewToken: token must have the jti claim", opts...)
+	}
+
+	// Store the token to protect against reuse unless it's skipped.
+	if !SkipTokenReuseFromContext(ctx) {
+		ok, err := a.db.UseToken(claims.ID, token)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err,
+				"authority.AuthorizeRenewToken: failed when attempting to store token", opts...)
+		}
+		if !ok {
+			return nil, errs.Unauthorized("authority.AuthorizeRenewToken: token already used", opts...)
+		}
+	}
+	return leaf, nil
+}
+
+// matchesRenewAudience returns true if one of the audiences in a renew token
+// is one of the renew endpoints of the CA, ignoring the port.
+func matchesRenewAudience(audience, renew []string) bool {
+	for _, s := range audience {
+		if u, err := url.Parse(s); err == nil {
+			u.Host = u.Hostname()
+			s = u.String()
+		}
+		for _, r := range renew {
+			if s == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // authorizeSSHSign loads the provisioner from the token, checks that it has not
 // been used again and calls the provisioner AuthorizeSSHSign method. Returns a
 // list of methods to apply to the signing flow.
@@ -193,25 +332,30 @@ func (a *Authority) authorizeSSHSign(ctx context.Context, token string) ([]provi
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.authorizeSSHSign")
 	}
-	signOpts, err := p.AuthorizeSSHSign(ctx, token)
+	pctx, span := tracing.Start(ctx, "provisioner.AuthorizeSSHSign", provisionerAttributes(p)...)
+	signOpts, err := p.AuthorizeSSHSign(pctx, token)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.authorizeSSHSign")
 	}
+	if err := a.authorizeInstance(ctx, p, token); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.authorizeSSHSign")
+	}
 	return signOpts, nil
 }
 
 // authorizeSSHRenew authorizes an SSH certificate renewal request, by
 // validating the contents of an SSHPOP token.
-func (a *Authority) authorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, error) {
+func (a *Authority) authorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, []provisioner.SignOption, error) {
 	p, err := a.authorizeToken(ctx, token)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSSHRenew")
+		return nil, nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSSHRenew")
 	}
-	cert, err := p.AuthorizeSSHRenew(ctx, token)
+	cert, signOpts, err := p.AuthorizeSSHRenew(ctx, token)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSSHRenew")
+		return nil, nil, errs.Wrap(http.StatusInternalServerError, err, "authority.authorizeSSHRenew")
 	}
-	return cert, nil
+	return cert, signOpts, nil
 }
 
 // authorizeSSHRekey authorizes an SSH certificate rekey request, by
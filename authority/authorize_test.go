@@ -3,10 +3,14 @@ package authority
 import (
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"fmt"
+	"math/big"
 	"net/http"
 	"strconv"
 	"testing"
@@ -19,6 +23,7 @@ import (
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/randutil"
+	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/square/go-jose.v2/jwt"
@@ -449,13 +454,123 @@ func TestAuthority_authorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 8, got)
+					assert.Len(t, 11, got)
 				}
 			}
 		})
 	}
 }
 
+type mockInstanceProvisioner struct {
+	*provisioner.MockProvisioner
+	instanceID string
+}
+
+func (m *mockInstanceProvisioner) GetInstanceID(token string) (string, bool) {
+	return m.instanceID, m.instanceID != ""
+}
+
+func TestAuthority_authorizeInstance(t *testing.T) {
+	used := map[string]bool{}
+	a := testAuthority(t)
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			if id == "db-error" {
+				return false, errors.New("force")
+			}
+			if used[id] {
+				return false, nil
+			}
+			used[id] = true
+			return true, nil
+		},
+	}
+
+	type test struct {
+		ctx  context.Context
+		p    provisioner.Interface
+		err  error
+		code int
+	}
+	tests := map[string]test{
+		"ok/not-instance-identifier": {context.Background(), &provisioner.MockProvisioner{}, nil, 0},
+		"ok/no-instance":             {context.Background(), &mockInstanceProvisioner{&provisioner.MockProvisioner{}, ""}, nil, 0},
+		"ok/first-use":               {context.Background(), &mockInstanceProvisioner{&provisioner.MockProvisioner{}, "instance-1"}, nil, 0},
+		"ok/skip-token-reuse":        {NewContextWithSkipTokenReuse(context.Background()), &mockInstanceProvisioner{&provisioner.MockProvisioner{}, "instance-2"}, nil, 0},
+		"fail/already-used": {context.Background(), &mockInstanceProvisioner{&provisioner.MockProvisioner{}, "instance-3"},
+			errors.New("authority.authorizeInstance: instance already used"), http.StatusUnauthorized},
+		"fail/db-error": {context.Background(), &mockInstanceProvisioner{&provisioner.MockProvisioner{}, "db-error"},
+			errors.New("authority.authorizeInstance: failed when attempting to store instance: force"), http.StatusInternalServerError},
+	}
+	used["instance-2"] = true
+	used["instance-3"] = true
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := a.authorizeInstance(tc.ctx, tc.p, "token")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthority_authorizeSign_instance(t *testing.T) {
+	a := testAuthority(t)
+	p := &mockInstanceProvisioner{&provisioner.MockProvisioner{
+		MgetID:           func() string { return "mock-instance" },
+		MgetName:         func() string { return "mock-instance" },
+		MgetType:         func() provisioner.Type { return provisioner.TypeAWS },
+		MgetTokenID:      func(string) (string, error) { return "", errors.New("not supported") },
+		MgetEncryptedKey: func() (string, string, bool) { return "", "", false },
+		MauthorizeSign: func(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
+			return []provisioner.SignOption{}, nil
+		},
+	}, "instance-1"}
+	assert.FatalError(t, a.provisioners.Store(p))
+	used := map[string]bool{}
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			if used[id] {
+				return false, nil
+			}
+			used[id] = true
+			return true, nil
+		},
+	}
+
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	assert.FatalError(t, err)
+	now := time.Now().UTC()
+	raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+		Subject:   "instance-1",
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+		Audience:  []string{"https://example.com/sign#mock-instance"},
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	_, err = a.authorizeSign(context.Background(), raw)
+	assert.FatalError(t, err)
+	_, err = a.authorizeSign(context.Background(), raw)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+		assert.HasPrefix(t, err.Error(), "authority.authorizeSign: authority.authorizeInstance: instance already used")
+	}
+}
+
 func TestAuthority_Authorize(t *testing.T) {
 	a := testAuthority(t)
 
@@ -727,6 +842,25 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 	otherCrt, err := pemutil.ReadCertificate("testdata/certs/provisioner-not-found.crt")
 	assert.FatalError(t, err)
 
+	// foo.crt has expired, validCrt is the same certificate in its validity
+	// period.
+	validCrt := func() *x509.Certificate {
+		a := testAuthority(t)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.FatalError(t, err)
+		p := a.config.AuthorityConfig.Provisioners[1].(*provisioner.JWK)
+		leaf, err := x509util.NewLeafProfile(fooCrt.Subject.CommonName, a.x509Issuer, a.x509Signer,
+			x509util.WithNotBeforeAfterDuration(time.Now().Add(-time.Minute), time.Now().Add(time.Hour), 0),
+			x509util.WithPublicKey(key.Public()), x509util.WithHosts(fooCrt.Subject.CommonName),
+			withProvisionerOID(p.Name, p.Key.KeyID))
+		assert.FatalError(t, err)
+		der, err := leaf.CreateCertificate()
+		assert.FatalError(t, err)
+		crt, err := x509.ParseCertificate(der)
+		assert.FatalError(t, err)
+		return crt
+	}()
+
 	type authorizeTest struct {
 		auth *Authority
 		cert *x509.Certificate
@@ -792,6 +926,20 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 				code: http.StatusUnauthorized,
 			}
 		},
+		"fail/provisioner-authorize-renewal-expired": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.db = &db.MockAuthDB{
+				MIsRevoked: func(key string) (bool, error) {
+					return false, nil
+				},
+			}
+			return &authorizeTest{
+				auth: a,
+				cert: fooCrt,
+				err:  errors.New("authority.authorizeRenew: jwk.AuthorizeRenew: certificate expired on 2019-03-23T22:29:29Z"),
+				code: http.StatusUnauthorized,
+			}
+		},
 		"ok": func(t *testing.T) *authorizeTest {
 			a := testAuthority(t)
 			a.db = &db.MockAuthDB{
@@ -799,6 +947,21 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 					return false, nil
 				},
 			}
+			return &authorizeTest{
+				auth: a,
+				cert: validCrt,
+			}
+		},
+		"ok/renew-after-expiry": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.db = &db.MockAuthDB{
+				MIsRevoked: func(key string) (bool, error) {
+					return false, nil
+				},
+			}
+			p := a.config.AuthorityConfig.Provisioners[1].(*provisioner.JWK)
+			p.Claims.AllowRenewalAfterExpiry = &provisioner.Duration{Duration: time.Since(fooCrt.NotAfter) + time.Hour}
+			assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
 			return &authorizeTest{
 				auth: a,
 				cert: fooCrt,
@@ -829,6 +992,112 @@ func TestAuthority_authorizeRenew(t *testing.T) {
 	}
 }
 
+func TestAuthority_AuthorizeRenewToken(t *testing.T) {
+	a := testAuthority(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	newCert := func(issuer *x509.Certificate, issuerKey crypto.Signer) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(now.UnixNano()),
+			Subject:      pkix.Name{CommonName: "test.smallstep.com"},
+			DNSNames:     []string{"test.smallstep.com"},
+			NotBefore:    now.Add(-2 * time.Hour),
+			NotAfter:     now.Add(-time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		if issuer == nil {
+			issuer = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+		assert.FatalError(t, err)
+		crt, err := x509.ParseCertificate(der)
+		assert.FatalError(t, err)
+		return crt
+	}
+	expiredCrt := newCert(a.x509Issuer, a.x509Signer)
+	selfSignedCrt := newCert(nil, key)
+
+	newToken := func(aud string, crt *x509.Certificate, signer crypto.Signer, modifiers ...func(*jose.Claims)) string {
+		so := new(jose.SignerOptions)
+		so.WithType("JWT")
+		so.WithHeader(jose.HeaderKey(jose.X5cInsecureKey), []string{
+			base64.StdEncoding.EncodeToString(crt.Raw),
+			base64.StdEncoding.EncodeToString(a.x509Issuer.Raw),
+		})
+		sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signer}, so)
+		assert.FatalError(t, err)
+		id, err := randutil.ASCII(64)
+		assert.FatalError(t, err)
+		claims := jose.Claims{
+			ID:        id,
+			Subject:   crt.Subject.CommonName,
+			Audience:  []string{aud},
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+		}
+		for _, fn := range modifiers {
+			fn(&claims)
+		}
+		tok, err := jose.Signed(sig).Claims(claims).CompactSerialize()
+		assert.FatalError(t, err)
+		return tok
+	}
+	reusedToken := newToken("https://example.com/1.0/renew", expiredCrt, key)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"ok", newToken("https://example.com/1.0/renew", expiredCrt, key), false},
+		{"ok/port", newToken("https://example.com:9000/1.0/renew", expiredCrt, key), false},
+		{"ok/reused-first", reusedToken, false},
+		{"fail/reused", reusedToken, true},
+		{"fail/token", "foo", true},
+		{"fail/verify", newToken("https://example.com/1.0/renew", selfSignedCrt, key), true},
+		{"fail/signature", newToken("https://example.com/1.0/renew", expiredCrt, otherKey), true},
+		{"fail/audience", newToken("https://example.com/1.0/sign", expiredCrt, key), true},
+		{"fail/expired", newToken("https://example.com/1.0/renew", expiredCrt, key, func(c *jose.Claims) {
+			c.Expiry = jose.NewNumericDate(now.Add(-time.Minute))
+		}), true},
+		{"fail/missing-exp", newToken("https://example.com/1.0/renew", expiredCrt, key, func(c *jose.Claims) {
+			c.Expiry = nil
+		}), true},
+		{"fail/missing-iat", newToken("https://example.com/1.0/renew", expiredCrt, key, func(c *jose.Claims) {
+			c.IssuedAt = nil
+		}), true},
+		{"fail/missing-nbf", newToken("https://example.com/1.0/renew", expiredCrt, key, func(c *jose.Claims) {
+			c.NotBefore = nil
+		}), true},
+		{"fail/lifetime", newToken("https://example.com/1.0/renew", expiredCrt, key, func(c *jose.Claims) {
+			c.Expiry = jose.NewNumericDate(now.Add(24 * time.Hour))
+		}), true},
+		{"fail/missing-jti", newToken("https://example.com/1.0/renew", expiredCrt, key, func(c *jose.Claims) {
+			c.ID = ""
+		}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.AuthorizeRenewToken(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authority.AuthorizeRenewToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+			} else {
+				assert.Equals(t, expiredCrt.Raw, got.Raw)
+			}
+		})
+	}
+}
+
 func generateSimpleSSHUserToken(iss, aud string, jwk *jose.JSONWebKey) (string, error) {
 	return generateSSHToken("subject@localhost", iss, aud, time.Now(), &provisioner.SSHOptions{
 		CertType:   "user",
@@ -961,7 +1230,7 @@ func TestAuthority_authorizeSSHSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 11, got)
+					assert.Len(t, 12, got)
 				}
 			}
 		})
@@ -1046,7 +1315,7 @@ func TestAuthority_authorizeSSHRenew(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			tc := genTestCase(t)
 
-			got, err := tc.auth.authorizeSSHRenew(context.Background(), tc.token)
+			got, signOpts, err := tc.auth.authorizeSSHRenew(context.Background(), tc.token)
 			if err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
@@ -1057,6 +1326,7 @@ func TestAuthority_authorizeSSHRenew(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, tc.cert.Serial, got.Serial)
+					assert.Len(t, 3, signOpts)
 				}
 			}
 		})
@@ -1250,7 +1520,7 @@ func TestAuthority_authorizeSSHRekey(t *testing.T) {
 			} else {
 				if assert.Nil(t, tc.err) {
 					assert.Equals(t, tc.cert.Serial, cert.Serial)
-					assert.Len(t, 3, signOpts)
+					assert.Len(t, 4, signOpts)
 				}
 			}
 		})
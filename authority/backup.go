@@ -0,0 +1,90 @@
+package authority
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/nosql"
+)
+
+// Fingerprint returns the SHA-256 fingerprint of the configuration without the
+// password. It is stored in the backups of the database to detect if a backup
+// is restored with a different configuration.
+func (c *Config) Fingerprint() (string, error) {
+	cc := *c
+	cc.Password = ""
+	b, err := json.Marshal(&cc)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling configuration")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RootFingerprints returns the SHA-256 fingerprints of the root certificates.
+func (a *Authority) RootFingerprints() []string {
+	fps := make([]string, len(a.rootX509Certs))
+	for i, crt := range a.rootX509Certs {
+		sum := sha256.Sum256(crt.Raw)
+		fps[i] = hex.EncodeToString(sum[:])
+	}
+	return fps
+}
+
+// Backup writes a backup of the authority and ACME tables to w while the CA is
+// running. The manifest of the backup includes the fingerprints of the
+// configuration and the roots, so the backup can be verified before it's
+// restored.
+func (a *Authority) Backup(w io.Writer) (*db.BackupManifest, error) {
+	nosqlDB, ok := a.db.(nosql.DB)
+	if !ok || a.config.DB == nil {
+		return nil, errs.NotImplemented("authority.Backup: no persistence layer configured")
+	}
+	fp, err := a.config.Fingerprint()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Backup")
+	}
+	m := &db.BackupManifest{
+		Type:              a.config.DB.Type,
+		ConfigFingerprint: fp,
+		Roots:             a.RootFingerprints(),
+	}
+	if err := db.WriteBackup(w, nosqlDB, append(db.Tables(), acme.Tables()...), m); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Backup")
+	}
+	return m, nil
+}
+
+// VerifyBackup verifies the backup in r and checks that it was created with
+// the same roots as the authority.
+func (a *Authority) VerifyBackup(r io.Reader) (*db.BackupManifest, error) {
+	m, err := db.VerifyBackup(r)
+	if err != nil {
+		return nil, errs.BadRequestErr(err, errs.WithMessage("the backup is not valid"))
+	}
+	if err := CheckBackupRoots(m, a.RootFingerprints()); err != nil {
+		return nil, errs.BadRequestErr(err, errs.WithMessage(err.Error()))
+	}
+	return m, nil
+}
+
+// CheckBackupRoots returns an error if the roots in the manifest are not the
+// given roots.
+func CheckBackupRoots(m *db.BackupManifest, roots []string) error {
+	if len(m.Roots) != len(roots) {
+		return errors.New("the backup was created with different roots")
+	}
+	for i := range roots {
+		if m.Roots[i] != roots[i] {
+			return errors.New("the backup was created with different roots")
+		}
+	}
+	return nil
+}
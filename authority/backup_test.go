@@ -0,0 +1,123 @@
+package authority
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestConfig_Fingerprint(t *testing.T) {
+	c := &Config{Address: ":443", Password: "password"}
+	fp, err := c.Fingerprint()
+	assert.FatalError(t, err)
+	assert.Len(t, 64, fp)
+
+	// The password is not part of the fingerprint.
+	c.Password = "other"
+	got, err := c.Fingerprint()
+	assert.FatalError(t, err)
+	assert.Equals(t, fp, got)
+	assert.Equals(t, "other", c.Password)
+
+	c.Address = ":8443"
+	got, err = c.Fingerprint()
+	assert.FatalError(t, err)
+	assert.NotEquals(t, fp, got)
+}
+
+func TestAuthority_Backup(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		a := testAuthority(t)
+		a.config.DB = &db.Config{Type: "badgerv2"}
+		a.db = &db.DB{DB: &db.MockNoSQLDB{
+			MList: func(bucket []byte) ([]*database.Entry, error) {
+				switch string(bucket) {
+				case "x509_certs":
+					return []*database.Entry{{Bucket: bucket, Key: []byte("1"), Value: []byte("cert")}}, nil
+				case "acme_accounts":
+					return []*database.Entry{}, nil
+				default:
+					return nil, database.ErrNotFound
+				}
+			},
+		}}
+
+		var buf bytes.Buffer
+		m, err := a.Backup(&buf)
+		assert.FatalError(t, err)
+		fp, err := a.config.Fingerprint()
+		assert.FatalError(t, err)
+		assert.Equals(t, "badgerv2", m.Type)
+		assert.Equals(t, fp, m.ConfigFingerprint)
+		assert.Equals(t, a.RootFingerprints(), m.Roots)
+		assert.Len(t, 1, m.Roots)
+		assert.Equals(t, map[string]int{"x509_certs": 1, "acme_accounts": 0}, m.Tables)
+
+		got, err := db.VerifyBackup(&buf)
+		assert.FatalError(t, err)
+		assert.Equals(t, m.Checksum, got.Checksum)
+	})
+
+	t.Run("fail/not-implemented", func(t *testing.T) {
+		a := testAuthority(t)
+		_, err := a.Backup(new(bytes.Buffer))
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder")
+		assert.Equals(t, http.StatusNotImplemented, sc.StatusCode())
+	})
+
+	t.Run("fail/list", func(t *testing.T) {
+		a := testAuthority(t)
+		a.config.DB = &db.Config{Type: "badgerv2"}
+		a.db = &db.DB{DB: &db.MockNoSQLDB{
+			MList: func(bucket []byte) ([]*database.Entry, error) {
+				return nil, errors.New("force")
+			},
+		}}
+		_, err := a.Backup(new(bytes.Buffer))
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder")
+		assert.Equals(t, http.StatusInternalServerError, sc.StatusCode())
+	})
+}
+
+func TestAuthority_VerifyBackup(t *testing.T) {
+	a := testAuthority(t)
+	a.config.DB = &db.Config{Type: "badgerv2"}
+	a.db = &db.DB{DB: &db.MockNoSQLDB{
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			return nil, database.ErrNotFound
+		},
+	}}
+	var buf bytes.Buffer
+	m, err := a.Backup(&buf)
+	assert.FatalError(t, err)
+	b := buf.Bytes()
+
+	got, err := a.VerifyBackup(bytes.NewReader(b))
+	assert.FatalError(t, err)
+	assert.Equals(t, m.Checksum, got.Checksum)
+
+	_, err = a.VerifyBackup(bytes.NewReader(b[:len(b)-10]))
+	assert.Equals(t, "error reading backup: unexpected EOF", err.Error())
+
+	a.rootX509Certs = append(a.rootX509Certs, a.rootX509Certs[0])
+	_, err = a.VerifyBackup(bytes.NewReader(b))
+	assert.Equals(t, "the backup was created with different roots", err.Error())
+	sc, ok := err.(errs.StatusCoder)
+	assert.Fatal(t, ok, "error does not implement StatusCoder")
+	assert.Equals(t, http.StatusBadRequest, sc.StatusCode())
+}
+
+func TestCheckBackupRoots(t *testing.T) {
+	m := &db.BackupManifest{Roots: []string{"a", "b"}}
+	assert.NoError(t, CheckBackupRoots(m, []string{"a", "b"}))
+	assert.Error(t, CheckBackupRoots(m, []string{"a"}))
+	assert.Error(t, CheckBackupRoots(m, []string{"a", "c"}))
+}
@@ -0,0 +1,468 @@
+package authority
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsTypeCAA is the type of the CAA records, it's not defined in
+	// dnsmessage.
+	dnsTypeCAA dnsmessage.Type = 257
+	// caaFlagCritical is the issuer critical flag of a CAA record.
+	caaFlagCritical = 0x80
+	// caaUDPSize is the UDP payload size advertised using EDNS(0).
+	caaUDPSize = 4096
+)
+
+var (
+	defaultCAATimeout = 10 * time.Second
+	// resolvConfPath is the file with the system resolvers, used if the CAA
+	// configuration does not define any.
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// caaKnownTags are the CAA property tags understood by the authority, an
+// unknown tag with the critical flag prevents the issuance.
+var caaKnownTags = map[string]bool{
+	"issue":        true,
+	"issuewild":    true,
+	"iodef":        true,
+	"issuemail":    true,
+	"contactemail": true,
+	"contactphone": true,
+}
+
+// CAAConfig configures the checking of the CAA records (RFC 8659) of the DNS
+// names in the X.509 certificates. IssuerDomains are the domains that identify
+// the CA in the issue and issuewild properties, Resolvers the DNS servers used
+// for the lookups, by default the ones in /etc/resolv.conf, and Bypass the zones
+// that are not checked, e.g. internal zones without public DNS records. The
+// checks are enabled for all the provisioners, they can be disabled in the
+// caa options of a provisioner.
+type CAAConfig struct {
+	IssuerDomains []string              `json:"issuerDomains"`
+	Resolvers     []string              `json:"resolvers,omitempty"`
+	Bypass        []string              `json:"bypass,omitempty"`
+	Timeout       *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks the fields in CAAConfig, nil is ok.
+func (c *CAAConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.IssuerDomains) == 0 {
+		return errors.New("caa.issuerDomains cannot be empty")
+	}
+	for _, s := range c.IssuerDomains {
+		if s == "" || strings.ContainsAny(s, " ;") {
+			return errors.Errorf("caa.issuerDomains contains an invalid domain %s", s)
+		}
+	}
+	for _, s := range c.Resolvers {
+		if s == "" {
+			return errors.New("caa.resolvers cannot contain an empty value")
+		}
+	}
+	for _, s := range c.Bypass {
+		if strings.Trim(s, ".") == "" {
+			return errors.New("caa.bypass cannot contain an empty value")
+		}
+	}
+	if c.Timeout != nil && c.Timeout.Duration <= 0 {
+		return errors.New("caa.timeout must be greater than 0")
+	}
+	return nil
+}
+
+// caaExchanger sends a DNS query to the given server and returns the
+// response.
+type caaExchanger func(network, server string, query []byte, timeout time.Duration) ([]byte, error)
+
+// caaChecker checks the CAA records of the DNS names in a certificate.
+type caaChecker struct {
+	issuerDomains []string
+	servers       []string
+	bypass        []string
+	timeout       time.Duration
+	exchange      caaExchanger
+}
+
+// initCAA initializes the CAA checker if it's configured.
+func (a *Authority) initCAA() error {
+	c := a.config.CAA
+	if c == nil {
+		a.caa = nil
+		return nil
+	}
+	checker := &caaChecker{
+		timeout:  defaultCAATimeout,
+		exchange: caaExchange,
+		bypass:   normalizeCAAZones(c.Bypass),
+	}
+	for _, s := range c.IssuerDomains {
+		checker.issuerDomains = append(checker.issuerDomains, strings.ToLower(strings.TrimSuffix(s, ".")))
+	}
+	if c.Timeout != nil {
+		checker.timeout = c.Timeout.Duration
+	}
+	resolvers := c.Resolvers
+	if len(resolvers) == 0 {
+		var err error
+		if resolvers, err = readResolvConf(resolvConfPath); err != nil {
+			return err
+		}
+	}
+	for _, s := range resolvers {
+		if net.ParseIP(s) != nil || !strings.Contains(s, ":") {
+			s = net.JoinHostPort(s, "53")
+		}
+		checker.servers = append(checker.servers, s)
+	}
+	a.caa = checker
+	return nil
+}
+
+// readResolvConf returns the name servers in a resolv.conf file.
+func readResolvConf(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the system resolvers")
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", name)
+	}
+	if len(servers) == 0 {
+		return nil, errors.Errorf("error reading the system resolvers: %s does not have any nameserver", name)
+	}
+	return servers, nil
+}
+
+func normalizeCAAZones(zones []string) []string {
+	normalized := make([]string, len(zones))
+	for i, s := range zones {
+		normalized[i] = strings.ToLower(strings.Trim(s, "."))
+	}
+	return normalized
+}
+
+// isBypassed returns true if the name is one of the zones or a subdomain of
+// them.
+func isBypassed(name string, zones []string) bool {
+	for _, zone := range zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check verifies that the CAA records of all the DNS names authorize one of
+// the issuer domains. The provisioner options can disable the checks or add
+// more zones to bypass.
+func (c *caaChecker) Check(names []string, opts *provisioner.CAAOptions) error {
+	if c == nil || !opts.IsEnabled() {
+		return nil
+	}
+	var bypass []string
+	if opts != nil {
+		bypass = normalizeCAAZones(opts.Bypass)
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		wildcard := strings.HasPrefix(name, "*.")
+		if wildcard {
+			name = name[2:]
+		}
+		if isBypassed(name, c.bypass) || isBypassed(name, bypass) {
+			continue
+		}
+		if err := c.checkName(name, wildcard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// caaRecord is the data of a CAA record.
+type caaRecord struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+// checkName checks the relevant CAA record set of a name. If the name is a
+// wildcard the issuewild properties are used if present.
+func (c *caaChecker) checkName(name string, wildcard bool) error {
+	records, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	var issue, issuewild []caaRecord
+	for _, r := range records {
+		tag := strings.ToLower(r.Tag)
+		switch {
+		case tag == "issue":
+			issue = append(issue, r)
+		case tag == "issuewild":
+			issuewild = append(issuewild, r)
+		case !caaKnownTags[tag] && r.Flags&caaFlagCritical != 0:
+			return errors.Errorf("caa records of %s contain the unknown critical property %s", name, r.Tag)
+		}
+	}
+	props := issue
+	if wildcard && len(issuewild) > 0 {
+		props = issuewild
+	}
+	if len(props) == 0 {
+		// Other properties do not restrict the issuance.
+		return nil
+	}
+	for _, r := range props {
+		domain := strings.ToLower(strings.TrimSpace(strings.SplitN(r.Value, ";", 2)[0]))
+		for _, d := range c.issuerDomains {
+			if domain == d {
+				return nil
+			}
+		}
+	}
+	if wildcard {
+		return errors.Errorf("caa records do not authorize the issuance of *.%s", name)
+	}
+	return errors.Errorf("caa records do not authorize the issuance of %s", name)
+}
+
+// lookup returns the relevant CAA record set of the name, the records of the
+// name or the ones of the closest parent with CAA records.
+func (c *caaChecker) lookup(name string) ([]caaRecord, error) {
+	for name != "" {
+		records, err := c.query(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return nil, nil
+}
+
+// query returns the CAA records of a name, the resolver follows the CNAME
+// records.
+func (c *caaChecker) query(name string) ([]caaRecord, error) {
+	fqdn := name + "."
+	n, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating caa query for %s", name)
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return nil, errors.Wrap(err, "error creating caa query id")
+	}
+	id := binary.BigEndian.Uint16(b[:])
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(caaUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, errors.Wrap(err, "error creating caa query")
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: n, Type: dnsTypeCAA, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{
+			{Header: opt, Body: &dnsmessage.OPTResource{}},
+		},
+	}
+	q, err := msg.Pack()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating caa query for %s", name)
+	}
+
+	var lastErr error
+	for _, server := range c.servers {
+		b, err := c.exchange("udp", server, q, c.timeout)
+		if err == nil && len(b) > 2 && b[2]&0x02 != 0 {
+			// Truncated response, retry using TCP.
+			b, err = c.exchange("tcp", server, q, c.timeout)
+		}
+		if err != nil {
+			lastErr = errors.Wrapf(err, "caa lookup %s on %s", name, server)
+			continue
+		}
+		res, err := parseCAAResponse(b)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "caa lookup %s on %s: error parsing response", name, server)
+			continue
+		}
+		if res.id != id {
+			lastErr = errors.Errorf("caa lookup %s on %s: unexpected response", name, server)
+			continue
+		}
+		switch res.rcode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, nil
+		default:
+			lastErr = errors.Errorf("caa lookup %s on %s: server responded with %s", name, server, res.rcode)
+			continue
+		}
+		return res.records, nil
+	}
+	return nil, lastErr
+}
+
+// caaResponse is a parsed response to a CAA query.
+type caaResponse struct {
+	id      uint16
+	rcode   dnsmessage.RCode
+	records []caaRecord
+}
+
+// parseCAAResponse parses the response to a CAA query. The dnsmessage parser
+// does not support CAA records, so the answers are parsed here. The owner
+// names are skipped, the answers contain the CAA records of the name or the
+// ones of the target of its CNAME records.
+func parseCAAResponse(b []byte) (*caaResponse, error) {
+	if len(b) < 12 {
+		return nil, errors.New("message is too short")
+	}
+	if b[2]&0x80 == 0 {
+		return nil, errors.New("message is not a response")
+	}
+	res := &caaResponse{
+		id:    binary.BigEndian.Uint16(b[0:2]),
+		rcode: dnsmessage.RCode(b[3] & 0x0F),
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:6]))
+	ancount := int(binary.BigEndian.Uint16(b[6:8]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		var ok bool
+		if off, ok = skipDNSName(b, off); !ok || off+4 > len(b) {
+			return nil, errors.New("invalid question")
+		}
+		off += 4
+	}
+	for i := 0; i < ancount; i++ {
+		var ok bool
+		if off, ok = skipDNSName(b, off); !ok || off+10 > len(b) {
+			return nil, errors.New("invalid answer")
+		}
+		typ := dnsmessage.Type(binary.BigEndian.Uint16(b[off : off+2]))
+		length := int(binary.BigEndian.Uint16(b[off+8 : off+10]))
+		off += 10
+		if off+length > len(b) {
+			return nil, errors.New("invalid answer")
+		}
+		if typ == dnsTypeCAA {
+			r, err := parseCAARecord(b[off : off+length])
+			if err != nil {
+				return nil, err
+			}
+			res.records = append(res.records, r)
+		}
+		off += length
+	}
+	return res, nil
+}
+
+// skipDNSName returns the offset after the name that starts at the given
+// offset.
+func skipDNSName(b []byte, off int) (int, bool) {
+	for off < len(b) {
+		n := int(b[off])
+		switch {
+		case n == 0:
+			return off + 1, true
+		case n&0xC0 == 0xC0:
+			// Compression pointer
+			return off + 2, off+2 <= len(b)
+		default:
+			off += 1 + n
+		}
+	}
+	return 0, false
+}
+
+// parseCAARecord parses the data of a CAA record: the flags, the length of
+// the tag, the tag and the value.
+func parseCAARecord(b []byte) (caaRecord, error) {
+	if len(b) < 2 || int(b[1]) == 0 || len(b) < 2+int(b[1]) {
+		return caaRecord{}, errors.New("invalid caa record")
+	}
+	n := int(b[1])
+	return caaRecord{
+		Flags: b[0],
+		Tag:   string(b[2 : 2+n]),
+		Value: string(b[2+n:]),
+	}, nil
+}
+
+// caaExchange sends the query to the server using the given network, udp or
+// tcp, and returns the response.
+func caaExchange(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	if network == "tcp" {
+		b := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(b, uint16(len(query)))
+		copy(b[2:], query)
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, b[:2]); err != nil {
+			return nil, err
+		}
+		res := make([]byte, binary.BigEndian.Uint16(b[:2]))
+		if _, err := io.ReadFull(conn, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	res := make([]byte, caaUDPSize)
+	n, err := conn.Read(res)
+	if err != nil {
+		return nil, err
+	}
+	return res[:n], nil
+}
@@ -0,0 +1,310 @@
+package authority
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/keys"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type testCAARecord struct {
+	flags uint8
+	tag   string
+	value string
+}
+
+// testCAAZone returns an exchanger that answers the CAA queries with the
+// records in the zone, the names without records return NXDOMAIN if they are
+// in nxdomain.
+func testCAAZone(t *testing.T, zone map[string][]testCAARecord, nxdomain ...string) caaExchanger {
+	return func(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+		var q dnsmessage.Message
+		assert.FatalError(t, q.Unpack(query))
+		assert.Equals(t, dnsTypeCAA, q.Questions[0].Type)
+		name := strings.TrimSuffix(q.Questions[0].Name.String(), ".")
+
+		rcode := dnsmessage.RCodeSuccess
+		for _, s := range nxdomain {
+			if s == name {
+				rcode = dnsmessage.RCodeNameError
+			}
+		}
+		if name == "servfail.example.com" {
+			rcode = dnsmessage.RCodeServerFailure
+		}
+
+		// Header and question, the question name is used for the answers
+		// with a compression pointer.
+		b := make([]byte, 12)
+		binary.BigEndian.PutUint16(b[0:2], q.ID)
+		b[2], b[3] = 0x81, 0x80|byte(rcode)
+		binary.BigEndian.PutUint16(b[4:6], 1)
+		binary.BigEndian.PutUint16(b[6:8], uint16(len(zone[name])))
+		for _, l := range strings.Split(name, ".") {
+			b = append(b, byte(len(l)))
+			b = append(b, l...)
+		}
+		b = append(b, 0, 1, 1, 0, 1)
+		for _, r := range zone[name] {
+			data := append([]byte{r.flags, byte(len(r.tag))}, r.tag...)
+			data = append(data, r.value...)
+			b = append(b, 0xC0, 12, 1, 1, 0, 1, 0, 0, 0, 60)
+			b = append(b, byte(len(data)>>8), byte(len(data)))
+			b = append(b, data...)
+		}
+		return b, nil
+	}
+}
+
+func TestCAAConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *CAAConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &CAAConfig{
+			IssuerDomains: []string{"ca.example.com"},
+			Resolvers:     []string{"8.8.8.8", "[::1]:5353"},
+			Bypass:        []string{"internal", ".corp.example.com"},
+			Timeout:       &provisioner.Duration{Duration: time.Second},
+		}, false},
+		{"fail/issuerDomains", &CAAConfig{}, true},
+		{"fail/issuerDomains-empty", &CAAConfig{IssuerDomains: []string{""}}, true},
+		{"fail/issuerDomains-invalid", &CAAConfig{IssuerDomains: []string{"ca.example.com; account=1"}}, true},
+		{"fail/resolvers", &CAAConfig{IssuerDomains: []string{"ca.example.com"}, Resolvers: []string{""}}, true},
+		{"fail/bypass", &CAAConfig{IssuerDomains: []string{"ca.example.com"}, Bypass: []string{"."}}, true},
+		{"fail/timeout", &CAAConfig{IssuerDomains: []string{"ca.example.com"}, Timeout: &provisioner.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CAAConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthority_initCAA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caa")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(s string) { resolvConfPath = s }(resolvConfPath)
+
+	a := testAuthority(t)
+	assert.FatalError(t, a.initCAA())
+	assert.Nil(t, a.caa)
+
+	a.config.CAA = &CAAConfig{IssuerDomains: []string{"CA.example.com."}, Resolvers: []string{"10.0.0.53", "dns.internal:5353"}}
+	assert.FatalError(t, a.initCAA())
+	assert.Equals(t, []string{"ca.example.com"}, a.caa.issuerDomains)
+	assert.Equals(t, []string{"10.0.0.53:53", "dns.internal:5353"}, a.caa.servers)
+	assert.Equals(t, defaultCAATimeout, a.caa.timeout)
+
+	resolvConfPath = filepath.Join(dir, "resolv.conf")
+	a.config.CAA = &CAAConfig{IssuerDomains: []string{"ca.example.com"}}
+	assert.Error(t, a.initCAA())
+	assert.FatalError(t, ioutil.WriteFile(resolvConfPath, []byte("# comment\nsearch example.com\n"), 0600))
+	assert.Error(t, a.initCAA())
+	assert.FatalError(t, ioutil.WriteFile(resolvConfPath, []byte("nameserver 10.0.0.1\nnameserver ::1\n"), 0600))
+	assert.FatalError(t, a.initCAA())
+	assert.Equals(t, []string{"10.0.0.1:53", "[::1]:53"}, a.caa.servers)
+}
+
+func TestCAAChecker_Check(t *testing.T) {
+	zone := map[string][]testCAARecord{
+		"example.com": {
+			{0, "issue", "ca.example.com; account=1234"},
+			{0, "issuewild", ";"},
+			{0, "iodef", "mailto:security@example.com"},
+		},
+		"other.example.com":    {{0, "issue", "other-ca.com"}},
+		"wild.example.com":     {{0, "issue", "other-ca.com"}, {0, "issuewild", "ca.example.com"}},
+		"critical.example.com": {{caaFlagCritical, "future", "value"}, {0, "issue", "ca.example.com"}},
+		"unknown.example.com":  {{0, "future", "value"}, {0, "issue", "ca.example.com"}},
+		"iodef.example.org":    {{0, "iodef", "mailto:security@example.org"}},
+		"denied.example.org":   {{0, "issue", ";"}},
+	}
+	c := &caaChecker{
+		issuerDomains: []string{"ca.example.com"},
+		servers:       []string{"127.0.0.1:53"},
+		bypass:        []string{"internal"},
+		exchange:      testCAAZone(t, zone, "missing.example.com"),
+	}
+	disabled := false
+
+	tests := []struct {
+		name    string
+		names   []string
+		opts    *provisioner.CAAOptions
+		wantErr bool
+	}{
+		{"ok/issue", []string{"example.com"}, nil, false},
+		{"ok/parent", []string{"foo.bar.example.com", "Example.COM."}, nil, false},
+		{"ok/nxdomain", []string{"missing.example.com"}, nil, false},
+		{"ok/no-records", []string{"example.net"}, nil, false},
+		{"ok/issuewild", []string{"*.wild.example.com"}, nil, false},
+		{"ok/unknown", []string{"unknown.example.com"}, nil, false},
+		{"ok/no-issue", []string{"iodef.example.org"}, nil, false},
+		{"ok/bypass", []string{"host.internal", "internal", "other.example.com"}, &provisioner.CAAOptions{Bypass: []string{".other.example.com"}}, false},
+		{"ok/disabled", []string{"other.example.com"}, &provisioner.CAAOptions{Enabled: &disabled}, false},
+		{"fail/other-ca", []string{"example.com", "other.example.com"}, nil, true},
+		{"fail/issuewild", []string{"*.example.com"}, nil, true},
+		{"fail/issue-for-wildcard", []string{"wild.example.com"}, nil, true},
+		{"fail/empty-issue", []string{"denied.example.org"}, nil, true},
+		{"fail/critical", []string{"critical.example.com"}, nil, true},
+		{"fail/servfail", []string{"servfail.example.com"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Check(tt.names, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("caaChecker.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A nil checker does not check anything.
+	assert.FatalError(t, (*caaChecker)(nil).Check([]string{"other.example.com"}, nil))
+}
+
+func TestCAAChecker_query(t *testing.T) {
+	zone := map[string][]testCAARecord{
+		"example.com": {{0, "issue", "ca.example.com"}},
+	}
+	exchange := testCAAZone(t, zone)
+
+	// The first server fails, the second one answers using TCP.
+	var networks []string
+	c := &caaChecker{
+		servers: []string{"127.0.0.1:53", "127.0.0.2:53"},
+		exchange: func(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+			networks = append(networks, network+"/"+server)
+			if server == "127.0.0.1:53" {
+				return nil, errors.New("force")
+			}
+			b, err := exchange(network, server, query, timeout)
+			if network == "udp" {
+				b[2] |= 0x02
+			}
+			return b, err
+		},
+	}
+	records, err := c.query("example.com")
+	assert.FatalError(t, err)
+	assert.Equals(t, []caaRecord{{Tag: "issue", Value: "ca.example.com"}}, records)
+	assert.Equals(t, []string{"udp/127.0.0.1:53", "udp/127.0.0.2:53", "tcp/127.0.0.2:53"}, networks)
+
+	// Invalid responses
+	for _, res := range [][]byte{
+		{1, 2, 3},
+		make([]byte, 12),
+		{0, 0, 0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'f', 'o'},
+	} {
+		c = &caaChecker{
+			servers: []string{"127.0.0.1:53"},
+			exchange: func(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+				return res, nil
+			},
+		}
+		_, err = c.query("example.com")
+		assert.Error(t, err)
+	}
+
+	// Unexpected id
+	c = &caaChecker{
+		servers: []string{"127.0.0.1:53"},
+		exchange: func(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+			b, err := exchange(network, server, query, timeout)
+			b[0]++
+			return b, err
+		},
+	}
+	_, err = c.query("example.com")
+	assert.Error(t, err)
+}
+
+func Test_parseCAARecord(t *testing.T) {
+	r, err := parseCAARecord([]byte{128, 5, 'i', 's', 's', 'u', 'e', 'c', 'a'})
+	assert.FatalError(t, err)
+	assert.Equals(t, caaRecord{Flags: 128, Tag: "issue", Value: "ca"}, r)
+	r, err = parseCAARecord([]byte{0, 5, 'i', 's', 's', 'u', 'e'})
+	assert.FatalError(t, err)
+	assert.Equals(t, caaRecord{Tag: "issue"}, r)
+
+	for _, b := range [][]byte{nil, {0}, {0, 0}, {0, 5, 'i'}} {
+		_, err := parseCAARecord(b)
+		assert.Error(t, err)
+	}
+}
+
+func Test_caaExchange(t *testing.T) {
+	// UDP
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer pc.Close()
+	go func() {
+		b := make([]byte, 512)
+		n, addr, err := pc.ReadFrom(b)
+		if err == nil {
+			pc.WriteTo(append([]byte("re:"), b[:n]...), addr)
+		}
+	}()
+	b, err := caaExchange("udp", pc.LocalAddr().String(), []byte("query"), time.Second)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("re:query"), b)
+
+	// TCP
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 7)
+		if _, err := conn.Read(b); err == nil {
+			conn.Write(append([]byte{0, 8, 'r', 'e', ':'}, b[2:]...))
+		}
+	}()
+	b, err = caaExchange("tcp", l.Addr().String(), []byte("query"), time.Second)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("re:query"), b)
+}
+
+func TestAuthority_Sign_caa(t *testing.T) {
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	a.caa = &caaChecker{
+		issuerDomains: []string{"ca.example.com"},
+		servers:       []string{"127.0.0.1:53"},
+		exchange: testCAAZone(t, map[string][]testCAARecord{
+			"smallstep.com": {{0, "issue", "other-ca.com"}},
+		}),
+	}
+
+	// test.smallstep.com is not authorized
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{})
+	assertStatusCode(t, err, http.StatusForbidden)
+
+	// Provisioner options
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{}, &provisioner.CAAOptions{Bypass: []string{"smallstep.com"}})
+	assert.FatalError(t, err)
+	disabled := false
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{}, &provisioner.CAAOptions{Enabled: &disabled})
+	assert.FatalError(t, err)
+}
@@ -0,0 +1,367 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+const (
+	// certificateIndexName is the name of the index of certificates in the
+	// schema versions.
+	certificateIndexName = "x509_certs_index"
+	// certificateIndexVersion is the version of the index of certificates,
+	// certificates stored with an older version are indexed again.
+	certificateIndexVersion = 1
+)
+
+const (
+	// DefaultCertificatesLimit is the number of certificates returned in a
+	// page if a limit is not set.
+	DefaultCertificatesLimit = 20
+	// MaxCertificatesLimit is the maximum number of certificates returned in
+	// a page.
+	MaxCertificatesLimit = 100
+)
+
+// CertificateInfo is the information of an issued certificate stored in the
+// index of certificates.
+type CertificateInfo struct {
+	Serial      string    `json:"serial"`
+	CommonName  string    `json:"commonName,omitempty"`
+	SANs        []string  `json:"sans,omitempty"`
+	Provisioner string    `json:"provisioner,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	Revoked     bool      `json:"revoked,omitempty"`
+}
+
+func newCertificateInfo(cert *x509.Certificate) *CertificateInfo {
+	info := &CertificateInfo{
+		Serial:     cert.SerialNumber.String(),
+		CommonName: cert.Subject.CommonName,
+		SANs:       certificateSANs(cert),
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+	}
+	info.Provisioner, _ = provisioner.GetProvisionerName(cert)
+	return info
+}
+
+// names returns the common name and the SANs of the certificate without
+// duplicates.
+func (c *CertificateInfo) names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append([]string{c.CommonName}, c.SANs...) {
+		name = strings.ToLower(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// less returns true if the certificate goes before the given one in a list:
+// the certificates are sorted by issuance time, the newest first, and serial
+// number.
+func (c *CertificateInfo) less(notBefore time.Time, serial string) bool {
+	if !c.NotBefore.Equal(notBefore) {
+		return c.NotBefore.After(notBefore)
+	}
+	if len(c.Serial) != len(serial) {
+		return len(c.Serial) > len(serial)
+	}
+	return c.Serial > serial
+}
+
+// certificateSANs returns the DNS names, email addresses, IP addresses and URIs
+// of the certificate.
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// CertificateFilter selects the certificates listed. All the fields set must
+// match: SAN and CommonName are patterns like *.example.com matched against
+// the SANs and the common name of the certificate, Serial and Provisioner must
+// be equal, IssuedAfter and IssuedBefore limit the notBefore of the
+// certificate and ExpiresAfter and ExpiresBefore limit the notAfter. Cursor is
+// the value returned in the previous page and Limit the maximum number of
+// certificates returned.
+type CertificateFilter struct {
+	SAN           string
+	CommonName    string
+	Serial        string
+	Provisioner   string
+	IssuedAfter   time.Time
+	IssuedBefore  time.Time
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+	Cursor        string
+	Limit         int
+}
+
+// Validate checks that the patterns, the time ranges and the limit are valid.
+func (f *CertificateFilter) Validate() error {
+	switch {
+	case !f.IssuedAfter.IsZero() && !f.IssuedBefore.IsZero() && !f.IssuedAfter.Before(f.IssuedBefore):
+		return errs.BadRequest("issuedAfter must be before issuedBefore")
+	case !f.ExpiresAfter.IsZero() && !f.ExpiresBefore.IsZero() && !f.ExpiresAfter.Before(f.ExpiresBefore):
+		return errs.BadRequest("expiresAfter must be before expiresBefore")
+	case f.Limit < 0 || f.Limit > MaxCertificatesLimit:
+		return errs.BadRequest("limit must be between 1 and %d", MaxCertificatesLimit)
+	}
+	for _, p := range []string{f.SAN, f.CommonName} {
+		if _, err := path.Match(p, ""); err != nil {
+			return errs.BadRequest("%s is not a valid pattern", p)
+		}
+	}
+	if _, _, err := parseCertificatesCursor(f.Cursor); err != nil {
+		return errs.BadRequest("cursor is not valid")
+	}
+	return nil
+}
+
+// Match returns true if the certificate matches all the fields of the filter.
+func (f *CertificateFilter) Match(c *CertificateInfo) bool {
+	switch {
+	case f.Serial != "" && f.Serial != c.Serial:
+		return false
+	case f.Provisioner != "" && f.Provisioner != c.Provisioner:
+		return false
+	case !f.IssuedAfter.IsZero() && c.NotBefore.Before(f.IssuedAfter):
+		return false
+	case !f.IssuedBefore.IsZero() && !c.NotBefore.Before(f.IssuedBefore):
+		return false
+	case !f.ExpiresAfter.IsZero() && c.NotAfter.Before(f.ExpiresAfter):
+		return false
+	case !f.ExpiresBefore.IsZero() && !c.NotAfter.Before(f.ExpiresBefore):
+		return false
+	case f.CommonName != "" && !matchName(f.CommonName, c.CommonName):
+		return false
+	}
+	if f.SAN != "" {
+		for _, san := range c.SANs {
+			if matchName(f.SAN, san) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchName(pattern, name string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+// isPattern returns true if the name has special characters of a pattern.
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// CertificateList is a page of the list of certificates. NextCursor is used to
+// get the next page, it is empty in the last page.
+type CertificateList struct {
+	Certificates []*CertificateInfo `json:"certificates"`
+	NextCursor   string             `json:"nextCursor,omitempty"`
+}
+
+// ListCertificates returns a page of the issued certificates that match the
+// filter, sorted by issuance time, the newest first.
+func (a *Authority) ListCertificates(f *CertificateFilter) (*CertificateList, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	limit := f.Limit
+	if limit == 0 {
+		limit = DefaultCertificatesLimit
+	}
+
+	candidates, err := a.getCertificateInfos(f)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.ListCertificates: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.ListCertificates")
+	}
+
+	var cursor *CertificateInfo
+	if f.Cursor != "" {
+		cursor = new(CertificateInfo)
+		cursor.NotBefore, cursor.Serial, _ = parseCertificatesCursor(f.Cursor)
+	}
+	var infos []*CertificateInfo
+	for _, info := range candidates {
+		if f.Match(info) && (cursor == nil || cursor.less(info.NotBefore, info.Serial)) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].less(infos[j].NotBefore, infos[j].Serial)
+	})
+
+	list := &CertificateList{
+		Certificates: []*CertificateInfo{},
+	}
+	if len(infos) > limit {
+		infos = infos[:limit]
+		last := infos[limit-1]
+		list.NextCursor = certificatesCursor(last.NotBefore, last.Serial)
+	}
+	for _, info := range infos {
+		if info.Revoked, err = a.db.IsRevoked(info.Serial); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.ListCertificates")
+		}
+		list.Certificates = append(list.Certificates, info)
+	}
+	return list, nil
+}
+
+// getCertificateInfos returns the certificates in the index that can match the
+// filter, using the index of names if the filter has a serial number or an
+// exact name.
+func (a *Authority) getCertificateInfos(f *CertificateFilter) ([]*CertificateInfo, error) {
+	var serials []string
+	switch {
+	case f.Serial != "":
+		serials = []string{f.Serial}
+	case f.SAN != "" && !isPattern(f.SAN):
+		list, err := a.db.GetCertificateSerialsByName(f.SAN)
+		if err != nil {
+			return nil, err
+		}
+		serials = list
+	case f.CommonName != "" && !isPattern(f.CommonName):
+		list, err := a.db.GetCertificateSerialsByName(f.CommonName)
+		if err != nil {
+			return nil, err
+		}
+		serials = list
+	default:
+		list, err := a.db.GetCertificateIndexes()
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalCertificateInfos(list)
+	}
+
+	var list [][]byte
+	for _, serial := range serials {
+		b, err := a.db.GetCertificateIndex(serial)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			list = append(list, b)
+		}
+	}
+	return unmarshalCertificateInfos(list)
+}
+
+func unmarshalCertificateInfos(list [][]byte) ([]*CertificateInfo, error) {
+	infos := make([]*CertificateInfo, 0, len(list))
+	for _, b := range list {
+		info := new(CertificateInfo)
+		if err := json.Unmarshal(b, info); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling certificate index")
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// storeCertificate stores the certificate and its entry in the index of
+// certificates.
+func (a *Authority) storeCertificate(cert *x509.Certificate) error {
+	if err := a.db.StoreCertificate(cert); err != nil {
+		return err
+	}
+	return a.indexCertificate(cert)
+}
+
+func (a *Authority) indexCertificate(cert *x509.Certificate) error {
+	info := newCertificateInfo(cert)
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return a.db.StoreCertificateIndex(info.Serial, b, info.names())
+}
+
+// initCertificateIndex adds the certificates stored before the index of
+// certificates existed to the index. The index is only built once, errors are
+// logged and the certificates are indexed again on the next start.
+func (a *Authority) initCertificateIndex() {
+	version, err := a.db.GetSchemaVersion(certificateIndexName)
+	switch {
+	case err == db.ErrNotImplemented:
+		return
+	case err != nil:
+		log.Printf("error indexing certificates: %v", err)
+		return
+	case version >= certificateIndexVersion:
+		return
+	}
+
+	certs, err := a.db.GetCertificates()
+	if err != nil {
+		log.Printf("error indexing certificates: %v", err)
+		return
+	}
+	for _, cert := range certs {
+		if err := a.indexCertificate(cert); err != nil {
+			log.Printf("error indexing certificates: %v", err)
+			return
+		}
+	}
+	if err := a.db.SetSchemaVersion(certificateIndexName, certificateIndexVersion); err != nil {
+		log.Printf("error indexing certificates: %v", err)
+	}
+}
+
+// certificatesCursor returns the cursor of the page after the certificate
+// with the given notBefore and serial number.
+func certificatesCursor(notBefore time.Time, serial string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(notBefore.UnixNano(), 10) + ":" + serial))
+}
+
+func parseCertificatesCursor(cursor string) (time.Time, string, error) {
+	if cursor == "" {
+		return time.Time{}, "", nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", errs.BadRequest("invalid cursor")
+	}
+	n, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Unix(0, n).UTC(), parts[1], nil
+}
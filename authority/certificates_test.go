@@ -0,0 +1,376 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestCertificateFilter_Validate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		f       *CertificateFilter
+		wantErr bool
+	}{
+		{"ok/empty", &CertificateFilter{}, false},
+		{"ok/san", &CertificateFilter{SAN: "*.example.com", Limit: MaxCertificatesLimit}, false},
+		{"ok/time", &CertificateFilter{IssuedAfter: now.Add(-time.Hour), IssuedBefore: now, ExpiresAfter: now, ExpiresBefore: now.Add(time.Hour)}, false},
+		{"ok/cursor", &CertificateFilter{Cursor: certificatesCursor(now, "1234")}, false},
+		{"fail/issued", &CertificateFilter{IssuedAfter: now, IssuedBefore: now}, true},
+		{"fail/expires", &CertificateFilter{ExpiresAfter: now, ExpiresBefore: now.Add(-time.Hour)}, true},
+		{"fail/san", &CertificateFilter{SAN: "[*.example.com"}, true},
+		{"fail/commonName", &CertificateFilter{CommonName: "[foo"}, true},
+		{"fail/limit", &CertificateFilter{Limit: MaxCertificatesLimit + 1}, true},
+		{"fail/negative-limit", &CertificateFilter{Limit: -1}, true},
+		{"fail/cursor", &CertificateFilter{Cursor: "%%%"}, true},
+		{"fail/cursor-format", &CertificateFilter{Cursor: "Zm9v"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.f.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CertificateFilter.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertificateFilter_Match(t *testing.T) {
+	now := time.Now()
+	info := &CertificateInfo{
+		Serial:      "1234",
+		CommonName:  "Foo",
+		SANs:        []string{"foo.example.com", "www.OLD.example.com", "10.0.0.1"},
+		Provisioner: "acme",
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(time.Hour),
+	}
+	tests := []struct {
+		name string
+		f    *CertificateFilter
+		want bool
+	}{
+		{"empty", &CertificateFilter{}, true},
+		{"san/dns", &CertificateFilter{SAN: "*.old.example.com"}, true},
+		{"san/exact", &CertificateFilter{SAN: "FOO.example.com"}, true},
+		{"san/ip", &CertificateFilter{SAN: "10.0.0.*"}, true},
+		{"san/no-match", &CertificateFilter{SAN: "*.new.example.com"}, false},
+		{"commonName", &CertificateFilter{CommonName: "foo"}, true},
+		{"commonName/no-match", &CertificateFilter{CommonName: "bar"}, false},
+		{"serial", &CertificateFilter{Serial: "1234"}, true},
+		{"serial/no-match", &CertificateFilter{Serial: "123"}, false},
+		{"provisioner", &CertificateFilter{Provisioner: "acme"}, true},
+		{"provisioner/no-match", &CertificateFilter{Provisioner: "jwk"}, false},
+		{"issued", &CertificateFilter{IssuedAfter: now.Add(-2 * time.Hour), IssuedBefore: now}, true},
+		{"issued/after", &CertificateFilter{IssuedAfter: now.Add(-30 * time.Minute)}, false},
+		{"issued/before", &CertificateFilter{IssuedBefore: now.Add(-time.Hour)}, false},
+		{"expires", &CertificateFilter{ExpiresAfter: now, ExpiresBefore: now.Add(2 * time.Hour)}, true},
+		{"expires/after", &CertificateFilter{ExpiresAfter: now.Add(2 * time.Hour)}, false},
+		{"expires/before", &CertificateFilter{ExpiresBefore: now.Add(time.Hour)}, false},
+		{"all", &CertificateFilter{SAN: "*.example.com", Provisioner: "acme", ExpiresBefore: now.Add(2 * time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, tt.f.Match(info))
+		})
+	}
+}
+
+func TestCertificateInfo_names(t *testing.T) {
+	info := &CertificateInfo{
+		CommonName: "Foo.example.com",
+		SANs:       []string{"foo.example.com", "bar.example.com", "10.0.0.1"},
+	}
+	assert.Equals(t, []string{"foo.example.com", "bar.example.com", "10.0.0.1"}, info.names())
+	assert.Equals(t, []string{"bar"}, (&CertificateInfo{SANs: []string{"bar"}}).names())
+}
+
+// mockCertificateIndex returns a MockAuthDB with the index of the given
+// certificates.
+func mockCertificateIndex(t *testing.T, infos []*CertificateInfo) *db.MockAuthDB {
+	index := make(map[string][]byte)
+	names := make(map[string][]string)
+	for _, info := range infos {
+		b, err := json.Marshal(info)
+		assert.FatalError(t, err)
+		index[info.Serial] = b
+		for _, name := range info.names() {
+			names[name] = append(names[name], info.Serial)
+		}
+	}
+	return &db.MockAuthDB{
+		MGetCertIndex: func(serial string) ([]byte, error) {
+			return index[serial], nil
+		},
+		MGetCertIndexes: func() ([][]byte, error) {
+			var list [][]byte
+			for _, b := range index {
+				list = append(list, b)
+			}
+			return list, nil
+		},
+		MGetCertSerialsByName: func(name string) ([]string, error) {
+			return names[strings.ToLower(name)], nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return sn == "2", nil
+		},
+	}
+}
+
+func TestAuthority_ListCertificates(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	var infos []*CertificateInfo
+	for i := 1; i <= 25; i++ {
+		infos = append(infos, &CertificateInfo{
+			Serial:      strconv.Itoa(i),
+			CommonName:  "host" + strconv.Itoa(i%5),
+			SANs:        []string{"host" + strconv.Itoa(i%5) + ".example.com"},
+			Provisioner: "acme",
+			// Pairs of certificates with the same issuance time.
+			NotBefore: now.Add(time.Duration(i/2) * time.Minute),
+			NotAfter:  now.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	a := testAuthority(t)
+	a.db = mockCertificateIndex(t, infos)
+
+	serials := func(list *CertificateList) []string {
+		var s []string
+		for _, c := range list.Certificates {
+			s = append(s, c.Serial)
+		}
+		return s
+	}
+
+	t.Run("ok/pages", func(t *testing.T) {
+		var got []string
+		f := &CertificateFilter{Limit: 10}
+		for i := 0; i < 3; i++ {
+			list, err := a.ListCertificates(f)
+			assert.FatalError(t, err)
+			got = append(got, serials(list)...)
+			if i < 2 {
+				assert.NotEquals(t, "", list.NextCursor)
+			} else {
+				assert.Equals(t, "", list.NextCursor)
+			}
+			f.Cursor = list.NextCursor
+		}
+		want := []string{}
+		for i := 25; i >= 1; i-- {
+			want = append(want, strconv.Itoa(i))
+		}
+		assert.Equals(t, want, got)
+	})
+
+	t.Run("ok/default-limit", func(t *testing.T) {
+		list, err := a.ListCertificates(&CertificateFilter{})
+		assert.FatalError(t, err)
+		assert.Len(t, DefaultCertificatesLimit, list.Certificates)
+	})
+
+	t.Run("ok/san", func(t *testing.T) {
+		list, err := a.ListCertificates(&CertificateFilter{SAN: "HOST2.example.com"})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"22", "17", "12", "7", "2"}, serials(list))
+		assert.True(t, list.Certificates[4].Revoked)
+		assert.False(t, list.Certificates[0].Revoked)
+	})
+
+	t.Run("ok/san-pattern", func(t *testing.T) {
+		list, err := a.ListCertificates(&CertificateFilter{SAN: "host[12].example.com", IssuedAfter: now.Add(5 * time.Minute)})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"22", "21", "17", "16", "12", "11"}, serials(list))
+	})
+
+	t.Run("ok/commonName", func(t *testing.T) {
+		list, err := a.ListCertificates(&CertificateFilter{CommonName: "host0", Limit: 2})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"25", "20"}, serials(list))
+		list, err = a.ListCertificates(&CertificateFilter{CommonName: "host0", Limit: 2, Cursor: list.NextCursor})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"15", "10"}, serials(list))
+	})
+
+	t.Run("ok/serial", func(t *testing.T) {
+		list, err := a.ListCertificates(&CertificateFilter{Serial: "7"})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"7"}, serials(list))
+		list, err = a.ListCertificates(&CertificateFilter{Serial: "100"})
+		assert.FatalError(t, err)
+		assert.Equals(t, []*CertificateInfo{}, list.Certificates)
+	})
+
+	t.Run("ok/expires", func(t *testing.T) {
+		list, err := a.ListCertificates(&CertificateFilter{ExpiresAfter: now.Add(3 * time.Hour), ExpiresBefore: now.Add(6 * time.Hour)})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"5", "4", "3"}, serials(list))
+	})
+
+	t.Run("fail/validate", func(t *testing.T) {
+		_, err := a.ListCertificates(&CertificateFilter{Limit: -1})
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusBadRequest, sc.StatusCode())
+	})
+
+	t.Run("fail/not-implemented", func(t *testing.T) {
+		a := testAuthority(t)
+		_, err := a.ListCertificates(&CertificateFilter{})
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusNotImplemented, sc.StatusCode())
+	})
+
+	t.Run("fail/db", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{Err: errors.New("force")}
+		for _, f := range []*CertificateFilter{{}, {SAN: "foo"}, {CommonName: "foo"}} {
+			_, err := a.ListCertificates(f)
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok)
+			assert.Equals(t, http.StatusInternalServerError, sc.StatusCode())
+		}
+	})
+
+	t.Run("fail/unmarshal", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{MGetCertIndex: func(serial string) ([]byte, error) {
+			return []byte("foo"), nil
+		}}
+		_, err := a.ListCertificates(&CertificateFilter{Serial: "1"})
+		assert.Error(t, err)
+	})
+}
+
+func TestAuthority_storeCertificate(t *testing.T) {
+	a := testAuthority(t)
+	var stored bool
+	var index []byte
+	var names []string
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MStoreCertificate: func(crt *x509.Certificate) error {
+			stored = true
+			return nil
+		},
+		MStoreCertIndex: func(serial string, b []byte, n []string) error {
+			index, names = b, n
+			return nil
+		},
+	}
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	extraOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+
+	assert.True(t, stored)
+	assert.Equals(t, []string{"smallstep test", "test.smallstep.com"}, names)
+	var info CertificateInfo
+	assert.FatalError(t, json.Unmarshal(index, &info))
+	assert.Equals(t, certChain[0].SerialNumber.String(), info.Serial)
+	assert.Equals(t, "step-cli", info.Provisioner)
+	assert.Equals(t, []string{"test.smallstep.com"}, info.SANs)
+
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MStoreCertIndex: func(serial string, b []byte, n []string) error {
+			return errors.New("force")
+		},
+	}
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.Error(t, err)
+}
+
+func TestAuthority_initCertificateIndex(t *testing.T) {
+	now := time.Now()
+	certs := []*x509.Certificate{
+		{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "foo"}, DNSNames: []string{"foo.example.com"}, NotBefore: now, NotAfter: now.Add(time.Hour)},
+		{SerialNumber: big.NewInt(2), IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}, NotBefore: now, NotAfter: now.Add(time.Hour)},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		a := testAuthority(t)
+		indexed := map[string][]string{}
+		var version int
+		a.db = &db.MockAuthDB{
+			MGetCertificates: func() ([]*x509.Certificate, error) {
+				return certs, nil
+			},
+			MStoreCertIndex: func(serial string, b []byte, names []string) error {
+				indexed[serial] = names
+				return nil
+			},
+			MSetSchemaVersion: func(name string, v int) error {
+				assert.Equals(t, certificateIndexName, name)
+				version = v
+				return nil
+			},
+		}
+		a.initCertificateIndex()
+		assert.Equals(t, map[string][]string{
+			"1": {"foo", "foo.example.com"},
+			"2": {"10.0.0.1"},
+		}, indexed)
+		assert.Equals(t, certificateIndexVersion, version)
+	})
+
+	t.Run("ok/indexed", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{
+			MGetSchemaVersion: func(name string) (int, error) {
+				return certificateIndexVersion, nil
+			},
+			MGetCertificates: func() ([]*x509.Certificate, error) {
+				t.Error("GetCertificates should not be called")
+				return nil, nil
+			},
+		}
+		a.initCertificateIndex()
+	})
+
+	t.Run("fail/store", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{
+			MGetCertificates: func() ([]*x509.Certificate, error) {
+				return certs, nil
+			},
+			MStoreCertIndex: func(serial string, b []byte, names []string) error {
+				return errors.New("force")
+			},
+			MSetSchemaVersion: func(name string, v int) error {
+				t.Error("SetSchemaVersion should not be called")
+				return nil
+			},
+		}
+		a.initCertificateIndex()
+	})
+}
@@ -0,0 +1,144 @@
+package authority
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+var defaultClusterSyncInterval = 30 * time.Second
+
+// ClusterConfig configures an authority that shares its database with other
+// instances of the CA, usually behind a load balancer. Every SyncInterval, 30s
+// by default, the provisioners, administrators and policy managed with the
+// admin API are reloaded from the database, so the changes made in one
+// instance are applied in the others. The periodic jobs, like the expiration
+// report or the garbage collection, are claimed in the database and run by
+// only one of the instances in each period.
+type ClusterConfig struct {
+	SyncInterval *provisioner.Duration `json:"syncInterval,omitempty"`
+}
+
+// Validate checks the fields in ClusterConfig, nil is ok.
+func (c *ClusterConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.SyncInterval != nil && c.SyncInterval.Duration <= 0 {
+		return errors.New("cluster.syncInterval must be greater than 0")
+	}
+	return nil
+}
+
+func (c *ClusterConfig) syncInterval() time.Duration {
+	if c.SyncInterval == nil {
+		return defaultClusterSyncInterval
+	}
+	return c.SyncInterval.Duration
+}
+
+// jobRecord is the last period of a periodic job claimed by one of the
+// instances of the cluster.
+type jobRecord struct {
+	Instance string    `json:"instance"`
+	Period   time.Time `json:"period"`
+	Claimed  time.Time `json:"claimed"`
+}
+
+// initCluster starts the synchronization of the state managed with the admin
+// API if the authority is part of a cluster.
+func (a *Authority) initCluster() {
+	c := a.config.Cluster
+	if c == nil {
+		return
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	a.instanceID = fmt.Sprintf("%s/%d", hostname, os.Getpid())
+	a.clusterStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(c.syncInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.SyncCluster(); err != nil {
+					log.Printf("error synchronizing with the cluster: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(a.clusterStop)
+}
+
+// StopCluster stops the synchronization with the other instances of the
+// cluster.
+func (a *Authority) StopCluster() {
+	if a.clusterStop != nil {
+		close(a.clusterStop)
+		a.clusterStop = nil
+	}
+}
+
+// SyncCluster reloads from the database the provisioners, administrators,
+// policy and renewed intermediate managed with the admin API. It tries all of
+// them and returns the first error.
+func (a *Authority) SyncCluster() error {
+	var firstErr error
+	for _, fn := range []func() error{a.syncStoredProvisioners, a.syncStoredAdmins, a.syncPolicy, a.syncIntermediate} {
+		if err := fn(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ClaimJob returns true if this instance must run the periodic job with the
+// given name in the current period. The periods are aligned to the given
+// duration, and in a cluster only the first instance that claims a period in
+// the database runs it. Without a cluster, or if the database cannot store the
+// jobs, the job always runs.
+func (a *Authority) ClaimJob(name string, period time.Duration) (bool, error) {
+	if a.config.Cluster == nil {
+		return true, nil
+	}
+	now := time.Now().UTC()
+	current := now.Truncate(period)
+	old, err := a.db.GetJob(name)
+	if err != nil {
+		return false, errs.Wrapf(http.StatusInternalServerError, err, "error loading job %s", name)
+	}
+	if old != nil {
+		r := new(jobRecord)
+		if err := json.Unmarshal(old, r); err != nil {
+			return false, errs.Wrapf(http.StatusInternalServerError, err, "error unmarshaling job %s", name)
+		}
+		if !r.Period.Before(current) {
+			return false, nil
+		}
+	}
+	b, err := json.Marshal(&jobRecord{Instance: a.instanceID, Period: current, Claimed: now})
+	if err != nil {
+		return false, errs.Wrapf(http.StatusInternalServerError, err, "error marshaling job %s", name)
+	}
+	swapped, err := a.db.CmpAndSwapJob(name, old, b)
+	switch {
+	case err == db.ErrNotImplemented:
+		return true, nil
+	case err != nil:
+		return false, errs.Wrapf(http.StatusInternalServerError, err, "error storing job %s", name)
+	default:
+		return swapped, nil
+	}
+}
@@ -0,0 +1,212 @@
+package authority
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestClusterConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *ClusterConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", &ClusterConfig{}, false},
+		{"ok/syncInterval", &ClusterConfig{SyncInterval: &provisioner.Duration{Duration: time.Minute}}, false},
+		{"fail/syncInterval", &ClusterConfig{SyncInterval: &provisioner.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ClusterConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthority_ClaimJob(t *testing.T) {
+	jobs := map[string][]byte{}
+	mockDB := &db.MockAuthDB{
+		MGetJob: func(name string) ([]byte, error) {
+			return jobs[name], nil
+		},
+		MCmpAndSwapJob: func(name string, oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(jobs[name], oldValue) {
+				return false, nil
+			}
+			jobs[name] = newValue
+			return true, nil
+		},
+	}
+
+	// Without a cluster the jobs always run.
+	a := testAuthority(t, WithDatabase(mockDB))
+	for i := 0; i < 2; i++ {
+		ok, err := a.ClaimJob("expiration", time.Hour)
+		assert.FatalError(t, err)
+		assert.True(t, ok)
+	}
+	assert.Len(t, 0, jobs)
+
+	// Only one instance claims each period.
+	a.config.Cluster = &ClusterConfig{}
+	a.instanceID = "a"
+	b := testAuthority(t, WithDatabase(mockDB))
+	b.config.Cluster = &ClusterConfig{}
+	b.instanceID = "b"
+	ok, err := a.ClaimJob("expiration", time.Hour)
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+	ok, err = b.ClaimJob("expiration", time.Hour)
+	assert.FatalError(t, err)
+	assert.False(t, ok)
+	ok, err = a.ClaimJob("expiration", time.Hour)
+	assert.FatalError(t, err)
+	assert.False(t, ok)
+	ok, err = b.ClaimJob("gc", time.Hour)
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+
+	r := new(jobRecord)
+	assert.FatalError(t, json.Unmarshal(jobs["expiration"], r))
+	assert.Equals(t, "a", r.Instance)
+	assert.Equals(t, time.Now().UTC().Truncate(time.Hour), r.Period)
+
+	// The next period can be claimed by any instance.
+	r.Period = r.Period.Add(-time.Hour)
+	jobs["expiration"], err = json.Marshal(r)
+	assert.FatalError(t, err)
+	ok, err = b.ClaimJob("expiration", time.Hour)
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+
+	// A concurrent claim.
+	mockDB.MCmpAndSwapJob = func(name string, oldValue, newValue []byte) (bool, error) {
+		return false, nil
+	}
+	ok, err = a.ClaimJob("other", time.Hour)
+	assert.FatalError(t, err)
+	assert.False(t, ok)
+
+	// Errors.
+	mockDB.MGetJob = func(name string) ([]byte, error) {
+		return nil, errors.New("force")
+	}
+	_, err = a.ClaimJob("expiration", time.Hour)
+	assert.HasPrefix(t, err.Error(), "error loading job expiration: force")
+
+	// Database without support
+	c := testAuthority(t)
+	c.config.Cluster = &ClusterConfig{}
+	ok, err = c.ClaimJob("expiration", time.Hour)
+	assert.FatalError(t, err)
+	assert.True(t, ok)
+}
+
+func TestAuthority_SyncCluster(t *testing.T) {
+	provisioners := map[string][]byte{}
+	admins := map[string][]byte{}
+	var storedPolicy []byte
+	list := func(m map[string][]byte) [][]byte {
+		var l [][]byte
+		for _, b := range m {
+			l = append(l, b)
+		}
+		return l
+	}
+	swap := func(m map[string][]byte, key string, oldValue, newValue []byte) (bool, error) {
+		if !bytes.Equal(m[key], oldValue) {
+			return false, nil
+		}
+		m[key] = newValue
+		return true, nil
+	}
+	mockDB := &db.MockAuthDB{
+		MGetProvisioners: func() ([][]byte, error) {
+			return list(provisioners), nil
+		},
+		MCmpAndSwapProvisioner: func(name string, oldValue, newValue []byte) (bool, error) {
+			return swap(provisioners, name, oldValue, newValue)
+		},
+		MGetAdmins: func() ([][]byte, error) {
+			return list(admins), nil
+		},
+		MCmpAndSwapAdmin: func(subject string, oldValue, newValue []byte) (bool, error) {
+			return swap(admins, subject, oldValue, newValue)
+		},
+		MGetPolicy: func() ([]byte, error) {
+			return storedPolicy, nil
+		},
+		MCmpAndSwapPolicy: func(oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(storedPolicy, oldValue) {
+				return false, nil
+			}
+			storedPolicy = newValue
+			return true, nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	b := testAuthority(t, WithDatabase(mockDB))
+	assertLoad := func(a *Authority, id string, want bool) {
+		_, ok := a.provisioners.Load(id)
+		assert.Equals(t, want, ok, id)
+	}
+
+	// Changes in one instance
+	acme := []byte(`{"type":"ACME","name":"acme-cluster"}`)
+	_, err := a.CreateProvisioner(acme)
+	assert.FatalError(t, err)
+	_, err = a.CreateAdmin("jane@smallstep.com", AdminRoleSuperAdmin, nil)
+	assert.FatalError(t, err)
+	opts := &policy.Options{X509: &policy.X509Options{Allow: &policy.X509Names{DNS: []string{".example.com"}}}}
+	_, err = a.UpdatePolicy(0, opts)
+	assert.FatalError(t, err)
+
+	// are applied in the other one.
+	assertLoad(b, "acme/acme-cluster", false)
+	assert.FatalError(t, b.SyncCluster())
+	assertLoad(b, "acme/acme-cluster", true)
+	assert.Equals(t, a.GetProvisionerRecords(), b.GetProvisionerRecords())
+	assert.Equals(t, a.GetAdminRecords(), b.GetAdminRecords())
+	assert.Equals(t, &PolicyRecord{Version: 1, Policy: opts}, b.GetPolicyRecord())
+
+	// The other instance can continue with the next versions.
+	acme2 := []byte(`{"type":"ACME","name":"acme-cluster","requireEAB":true}`)
+	_, err = b.UpdateProvisioner(superAdmin, "acme-cluster", 1, acme2)
+	assert.FatalError(t, err)
+	assert.FatalError(t, b.DeleteAdmin("jane@smallstep.com", 1))
+	_, err = b.UpdatePolicy(1, nil)
+	assert.FatalError(t, err)
+
+	assert.FatalError(t, a.SyncCluster())
+	p, err := a.LoadProvisionerByID("acme/acme-cluster")
+	assert.FatalError(t, err)
+	assert.True(t, p.(*provisioner.ACME).RequireEAB)
+	assert.Equals(t, []*AdminRecord{}, a.GetAdminRecords())
+	assert.Equals(t, &PolicyRecord{Version: 2}, a.GetPolicyRecord())
+
+	assert.FatalError(t, a.DeleteProvisioner("acme-cluster", 2))
+	assert.FatalError(t, b.SyncCluster())
+	assertLoad(b, "acme/acme-cluster", false)
+	assert.Equals(t, []*ProvisionerRecord{}, b.GetProvisionerRecords())
+
+	// Invalid provisioners keep the previous version.
+	_, err = a.CreateProvisioner(acme)
+	assert.FatalError(t, err)
+	assert.FatalError(t, b.SyncCluster())
+	provisioners["acme-cluster"] = []byte(`{"name":"acme-cluster","version":5,"provisioner":{"type":"foo","name":"acme-cluster"}}`)
+	assert.HasPrefix(t, b.SyncCluster().Error(), "error loading provisioner acme-cluster")
+	assertLoad(b, "acme/acme-cluster", true)
+	r, err := b.GetProvisionerRecord("acme-cluster")
+	assert.FatalError(t, err)
+	assert.Equals(t, 4, r.Version)
+}
@@ -8,10 +8,14 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	kms "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/lint"
+	"github.com/smallstep/certificates/passphrase"
 	"github.com/smallstep/certificates/templates"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
 )
@@ -30,8 +34,11 @@ var (
 		Renegotiation: false,
 	}
 	defaultBackdate         = time.Minute
+	defaultShutdownTimeout  = 60 * time.Second
+	defaultClockSkew        = time.Minute
 	defaultDisableRenewal   = false
 	defaultEnableSSHCA      = false
+	defaultEnableSPIFFE     = false
 	globalProvisionerClaims = provisioner.Claims{
 		MinTLSDur:         &provisioner.Duration{Duration: 5 * time.Minute}, // TLS certs
 		MaxTLSDur:         &provisioner.Duration{Duration: 24 * time.Hour},
@@ -44,35 +51,69 @@ var (
 		MaxHostSSHDur:     &provisioner.Duration{Duration: 30 * 24 * time.Hour},
 		DefaultHostSSHDur: &provisioner.Duration{Duration: 30 * 24 * time.Hour},
 		EnableSSHCA:       &defaultEnableSSHCA,
+		EnableSPIFFE:      &defaultEnableSPIFFE,
 	}
 )
 
 // Config represents the CA configuration and it's mapped to a JSON object.
 type Config struct {
-	Root             multiString          `json:"root"`
-	FederatedRoots   []string             `json:"federatedRoots"`
-	IntermediateCert string               `json:"crt"`
-	IntermediateKey  string               `json:"key"`
-	Address          string               `json:"address"`
-	DNSNames         []string             `json:"dnsNames"`
-	KMS              *kms.Options         `json:"kms,omitempty"`
-	SSH              *SSHConfig           `json:"ssh,omitempty"`
-	Logger           json.RawMessage      `json:"logger,omitempty"`
-	DB               *db.Config           `json:"db,omitempty"`
-	Monitoring       json.RawMessage      `json:"monitoring,omitempty"`
-	AuthorityConfig  *AuthConfig          `json:"authority,omitempty"`
-	TLS              *tlsutil.TLSOptions  `json:"tls,omitempty"`
-	Password         string               `json:"password,omitempty"`
-	Templates        *templates.Templates `json:"templates,omitempty"`
+	Root             multiString           `json:"root"`
+	FederatedRoots   []string              `json:"federatedRoots"`
+	IntermediateCert string                `json:"crt"`
+	IntermediateKey  string                `json:"key"`
+	Intermediates    []*IntermediateConfig `json:"intermediates,omitempty"`
+	Address          string                `json:"address"`
+	DNSNames         []string              `json:"dnsNames"`
+	KMS              *kms.Options          `json:"kms,omitempty"`
+	SSH              *SSHConfig            `json:"ssh,omitempty"`
+	CRL              *CRLConfig            `json:"crl,omitempty"`
+	CT               *CTConfig             `json:"ct,omitempty"`
+	CAA              *CAAConfig            `json:"caa,omitempty"`
+	Serial           *SerialConfig         `json:"serial,omitempty"`
+	ShortLived       *ShortLivedConfig     `json:"shortLived,omitempty"`
+	Expiration       *ExpirationConfig     `json:"expiration,omitempty"`
+	GC               *GCConfig             `json:"gc,omitempty"`
+	Audit            *AuditConfig          `json:"audit,omitempty"`
+	Events           *EventsConfig         `json:"events,omitempty"`
+	Cluster          *ClusterConfig        `json:"cluster,omitempty"`
+	Federation       *FederationConfig     `json:"federation,omitempty"`
+	Tenants          []*TenantConfig       `json:"tenants,omitempty"`
+	Logger           json.RawMessage       `json:"logger,omitempty"`
+	DB               *db.Config            `json:"db,omitempty"`
+	Monitoring       json.RawMessage       `json:"monitoring,omitempty"`
+	MetricsAddress   string                `json:"metricsAddress,omitempty"`
+	GRPCAddress      string                `json:"grpcAddress,omitempty"`
+	ShutdownTimeout  *provisioner.Duration `json:"shutdownTimeout,omitempty"`
+	Tracing          *tracing.Config       `json:"tracing,omitempty"`
+	AuthorityConfig  *AuthConfig           `json:"authority,omitempty"`
+	TLS              *tlsutil.TLSOptions   `json:"tls,omitempty"`
+	Password         string                `json:"password,omitempty"`
+	PasswordProvider *passphrase.Config    `json:"passwordProvider,omitempty"`
+	Templates        *templates.Templates  `json:"templates,omitempty"`
+
+	// audienceBases are additional hosts and paths where the authority is
+	// served, e.g. the path of a tenant in the DNS names of the CA.
+	audienceBases []string
 }
 
 // AuthConfig represents the configuration options for the authority.
 type AuthConfig struct {
-	Provisioners         provisioner.List      `json:"provisioners"`
-	Template             *x509util.ASN1DN      `json:"template,omitempty"`
-	Claims               *provisioner.Claims   `json:"claims,omitempty"`
-	DisableIssuedAtCheck bool                  `json:"disableIssuedAtCheck,omitempty"`
-	Backdate             *provisioner.Duration `json:"backdate,omitempty"`
+	Provisioners          provisioner.List          `json:"provisioners"`
+	Template              *x509util.ASN1DN          `json:"template,omitempty"`
+	Claims                *provisioner.Claims       `json:"claims,omitempty"`
+	DisableIssuedAtCheck  bool                      `json:"disableIssuedAtCheck,omitempty"`
+	EnableReceipts        bool                      `json:"enableReceipts,omitempty"`
+	Backdate              *provisioner.Duration     `json:"backdate,omitempty"`
+	ClockSkew             *provisioner.Duration     `json:"clockSkew,omitempty"`
+	Proxy                 *provisioner.ProxyOptions `json:"proxy,omitempty"`
+	TemplatesDirectory    string                    `json:"templatesDirectory,omitempty"`
+	Admins                []string                  `json:"admins,omitempty"`
+	AdminProvisioner      string                    `json:"adminProvisioner,omitempty"`
+	AdminCertProvisioners []string                  `json:"adminCertProvisioners,omitempty"`
+	SSHKRLProvisioners    []string                  `json:"sshKRLProvisioners,omitempty"`
+	Policy                *policy.Options           `json:"policy,omitempty"`
+	Profiles              []*provisioner.Profile    `json:"profiles,omitempty"`
+	Lint                  *lint.Options             `json:"lint,omitempty"`
 }
 
 // Validate validates the authority configuration.
@@ -106,6 +147,87 @@ func (c *AuthConfig) Validate(audiences provisioner.Audiences) error {
 		}
 	}
 
+	if c.ClockSkew != nil {
+		if c.ClockSkew.Duration < 0 {
+			return errors.New("authority.clockSkew cannot be less than 0")
+		}
+	} else {
+		c.ClockSkew = &provisioner.Duration{
+			Duration: defaultClockSkew,
+		}
+	}
+
+	if err := c.Proxy.Validate(); err != nil {
+		return errors.Wrap(err, "error validating authority")
+	}
+
+	for _, s := range c.Admins {
+		if s == "" {
+			return errors.New("authority.admins cannot contain an empty value")
+		}
+	}
+
+	if c.AdminProvisioner != "" {
+		var found bool
+		for _, p := range c.Provisioners {
+			if p.GetName() == c.AdminProvisioner {
+				if p.GetType() != provisioner.TypeOIDC {
+					return errors.Errorf("authority.adminProvisioner %s is not an OIDC provisioner", c.AdminProvisioner)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("authority.adminProvisioner %s was not found", c.AdminProvisioner)
+		}
+	}
+
+	for _, name := range c.AdminCertProvisioners {
+		var found bool
+		for _, p := range c.Provisioners {
+			if p.GetName() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("authority.adminCertProvisioners %s was not found", name)
+		}
+	}
+
+	for _, name := range c.SSHKRLProvisioners {
+		var found bool
+		for _, p := range c.Provisioners {
+			if p.GetName() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("authority.sshKRLProvisioners %s was not found", name)
+		}
+	}
+
+	if _, err := policy.New(c.Policy); err != nil {
+		return errors.Wrap(err, "error validating authority.policy")
+	}
+
+	if err := c.Lint.Validate(); err != nil {
+		return errors.Wrap(err, "error validating authority")
+	}
+
+	names := make(map[string]bool, len(c.Profiles))
+	for _, p := range c.Profiles {
+		if err := p.Validate(); err != nil {
+			return errors.Wrap(err, "error validating authority.profiles")
+		}
+		if names[p.Name] {
+			return errors.Errorf("authority.profiles contains the name %s more than once", p.Name)
+		}
+		names[p.Name] = true
+	}
+
 	return nil
 }
 
@@ -163,6 +285,25 @@ func (c *Config) Validate() error {
 		return errors.Errorf("invalid address %s", c.Address)
 	}
 
+	// Validate metrics address if given
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return errors.Errorf("invalid metricsAddress %s", c.MetricsAddress)
+		}
+	}
+
+	// Validate gRPC address if given
+	if c.GRPCAddress != "" {
+		if _, _, err := net.SplitHostPort(c.GRPCAddress); err != nil {
+			return errors.Errorf("invalid grpcAddress %s", c.GRPCAddress)
+		}
+	}
+
+	// Validate shutdown timeout if given
+	if c.ShutdownTimeout != nil && c.ShutdownTimeout.Duration <= 0 {
+		return errors.New("shutdownTimeout must be greater than 0")
+	}
+
 	if c.TLS == nil {
 		c.TLS = &DefaultTLSOptions
 	} else {
@@ -186,11 +327,99 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// Validate password provider: nil is ok
+	if err := c.PasswordProvider.Validate(); err != nil {
+		return err
+	}
+
 	// Validate ssh: nil is ok
 	if err := c.SSH.Validate(); err != nil {
 		return err
 	}
 
+	// Validate additional intermediates
+	names := make(map[string]bool, len(c.Intermediates))
+	for _, in := range c.Intermediates {
+		if err := in.Validate(); err != nil {
+			return err
+		}
+		if names[in.Name] {
+			return errors.Errorf("intermediate %s is duplicated", in.Name)
+		}
+		names[in.Name] = true
+	}
+
+	// Validate crl: nil is ok
+	if err := c.CRL.Validate(); err != nil {
+		return err
+	}
+
+	// Validate serial: nil is ok
+	if err := c.Serial.Validate(); err != nil {
+		return err
+	}
+
+	// Validate shortLived: nil is ok
+	if err := c.ShortLived.Validate(); err != nil {
+		return err
+	}
+
+	// Validate expiration: nil is ok
+	if err := c.Expiration.Validate(); err != nil {
+		return err
+	}
+
+	// Validate gc: nil is ok
+	if err := c.GC.Validate(); err != nil {
+		return err
+	}
+	if c.GC != nil && c.DB == nil {
+		return errors.New("gc requires a db")
+	}
+
+	// Validate audit: nil is ok
+	if err := c.Audit.Validate(); err != nil {
+		return err
+	}
+	if c.Audit.hasDBSink() && c.DB == nil {
+		return errors.New("audit db sink requires a db")
+	}
+
+	// Validate events: nil is ok
+	if err := c.Events.Validate(); err != nil {
+		return err
+	}
+
+	// Validate cluster: nil is ok
+	if err := c.Cluster.Validate(); err != nil {
+		return err
+	}
+
+	// Validate federation: nil is ok
+	if err := c.Federation.Validate(); err != nil {
+		return err
+	}
+
+	// Validate tenants
+	if err := validateTenants(c.Tenants, c.DNSNames); err != nil {
+		return err
+	}
+
+	// Validate tracing: nil is ok
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+
+	// Validate caa: nil is ok
+	if err := c.CAA.Validate(); err != nil {
+		return err
+	}
+
+	// Validate ct: nil is ok
+	if err := c.CT.Validate(); err != nil {
+		return err
+	}
+
 	// Validate templates: nil is ok
 	if err := c.Templates.Validate(); err != nil {
 		return err
@@ -199,24 +428,38 @@ func (c *Config) Validate() error {
 	return c.AuthorityConfig.Validate(c.getAudiences())
 }
 
+// GetShutdownTimeout returns the time to wait for the active requests when the
+// CA is stopped, 60s by default.
+func (c *Config) GetShutdownTimeout() time.Duration {
+	if c.ShutdownTimeout == nil {
+		return defaultShutdownTimeout
+	}
+	return c.ShutdownTimeout.Duration
+}
+
 // getAudiences returns the legacy and possible urls without the ports that will
 // be used as the default provisioner audiences. The CA might have proxies in
 // front so we cannot rely on the port.
 func (c *Config) getAudiences() provisioner.Audiences {
 	audiences := provisioner.Audiences{
 		Sign:      []string{legacyAuthority},
+		Renew:     []string{},
 		Revoke:    []string{legacyAuthority},
 		SSHSign:   []string{},
 		SSHRevoke: []string{},
 		SSHRenew:  []string{},
 	}
 
-	for _, name := range c.DNSNames {
+	names := append(append([]string{}, c.DNSNames...), c.audienceBases...)
+	for _, name := range names {
 		audiences.Sign = append(audiences.Sign,
 			fmt.Sprintf("https://%s/1.0/sign", name),
 			fmt.Sprintf("https://%s/sign", name),
 			fmt.Sprintf("https://%s/1.0/ssh/sign", name),
 			fmt.Sprintf("https://%s/ssh/sign", name))
+		audiences.Renew = append(audiences.Renew,
+			fmt.Sprintf("https://%s/1.0/renew", name),
+			fmt.Sprintf("https://%s/renew", name))
 		audiences.Revoke = append(audiences.Revoke,
 			fmt.Sprintf("https://%s/1.0/revoke", name),
 			fmt.Sprintf("https://%s/revoke", name))
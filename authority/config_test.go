@@ -3,10 +3,14 @@ package authority
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/lint"
+	"github.com/smallstep/certificates/passphrase"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
 	stepJOSE "github.com/smallstep/cli/jose"
@@ -65,6 +69,51 @@ func TestConfigValidate(t *testing.T) {
 				err: errors.New("invalid address 127.0.0.1"),
 			}
 		},
+		"invalid-metrics-address": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					MetricsAddress:   "127.0.0.1",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("invalid metricsAddress 127.0.0.1"),
+			}
+		},
+		"invalid-grpc-address": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					GRPCAddress:      "127.0.0.1",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("invalid grpcAddress 127.0.0.1"),
+			}
+		},
+		"invalid-shutdown-timeout": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					ShutdownTimeout:  &provisioner.Duration{},
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					Password:         "pass",
+					AuthorityConfig:  ac,
+				},
+				err: errors.New("shutdownTimeout must be greater than 0"),
+			}
+		},
 		"empty-root": func(t *testing.T) ConfigValidateTest {
 			return ConfigValidateTest{
 				config: &Config{
@@ -197,6 +246,20 @@ func TestConfigValidate(t *testing.T) {
 				err: errors.New("tls minVersion cannot exceed tls maxVersion"),
 			}
 		},
+		"invalid-password-provider": func(t *testing.T) ConfigValidateTest {
+			return ConfigValidateTest{
+				config: &Config{
+					Address:          "127.0.0.1:443",
+					Root:             []string{"testdata/secrets/root_ca.crt"},
+					IntermediateCert: "testdata/secrets/intermediate_ca.crt",
+					IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+					DNSNames:         []string{"test.smallstep.com"},
+					AuthorityConfig:  ac,
+					PasswordProvider: &passphrase.Config{Type: passphrase.Socket},
+				},
+				err: errors.New("passwordProvider path is required with type socket"),
+			}
+		},
 	}
 
 	for name, get := range tests {
@@ -278,6 +341,177 @@ func TestAuthConfigValidate(t *testing.T) {
 				asn1dn: asn1dn,
 			}
 		},
+		"ok-proxy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Proxy:        &provisioner.ProxyOptions{URL: "http://proxy.example.com:3128", NoProxy: []string{".internal", "10.0.0.0/8"}},
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
+		"fail-proxy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Proxy:        &provisioner.ProxyOptions{URL: "ftp://proxy.example.com"},
+				},
+				err: errors.New("error validating authority: proxy.url ftp://proxy.example.com is not valid: scheme must be http, https or socks5"),
+			}
+		},
+		"ok-admins": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Admins:       []string{"admin@smallstep.com", "admin"},
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
+		"ok-admin-provisioner": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners:     append(provisioner.List{&provisioner.OIDC{Name: "Google", Type: "OIDC"}}, p...),
+					Admins:           []string{"admin@smallstep.com"},
+					AdminProvisioner: "Google",
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
+		"fail-admin-provisioner-type": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners:     p,
+					AdminProvisioner: "Max",
+				},
+				err: errors.New("authority.adminProvisioner Max is not an OIDC provisioner"),
+			}
+		},
+		"fail-admin-provisioner-not-found": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners:     p,
+					AdminProvisioner: "Google",
+				},
+				err: errors.New("authority.adminProvisioner Google was not found"),
+			}
+		},
+		"ok-admin-cert-provisioners": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners:          p,
+					Admins:                []string{"admin@smallstep.com"},
+					AdminCertProvisioners: []string{"Max"},
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
+		"fail-admin-cert-provisioners-not-found": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners:          p,
+					AdminCertProvisioners: []string{"Google"},
+				},
+				err: errors.New("authority.adminCertProvisioners Google was not found"),
+			}
+		},
+		"fail-ssh-krl-provisioners-not-found": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners:       p,
+					SSHKRLProvisioners: []string{"Google"},
+				},
+				err: errors.New("authority.sshKRLProvisioners Google was not found"),
+			}
+		},
+		"ok-policy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Policy: &policy.Options{
+						X509: &policy.X509Options{Allow: &policy.X509Names{DNS: []string{".smallstep.com"}}},
+					},
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
+		"fail-policy": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Policy: &policy.Options{
+						X509: &policy.X509Options{Deny: &policy.X509Names{IP: []string{"10.0.0.0/33"}}},
+					},
+				},
+				err: errors.New("error validating authority.policy: error parsing x509.deny: ip range 10.0.0.0/33 is not valid"),
+			}
+		},
+		"ok-profiles": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Profiles: []*provisioner.Profile{
+						{Name: "server", ExtKeyUsage: []string{"serverAuth"}},
+						{Name: "client", ExtKeyUsage: []string{"clientAuth"}},
+					},
+				},
+				asn1dn: x509util.ASN1DN{},
+			}
+		},
+		"fail-profiles": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Profiles:     []*provisioner.Profile{{Name: "server", KeyUsage: []string{"certSign"}}},
+				},
+				err: errors.New("error validating authority.profiles: error validating profile server: template keyUsage contains an unsupported value certSign"),
+			}
+		},
+		"fail-lint": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Lint:         &lint.Options{Disable: []string{"foo"}},
+				},
+				err: errors.New("error validating authority: lint.disable contains the unknown lint foo"),
+			}
+		},
+		"fail-duplicated-profiles": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Profiles:     []*provisioner.Profile{{Name: "server"}, {Name: "server"}},
+				},
+				err: errors.New("authority.profiles contains the name server more than once"),
+			}
+		},
+		"fail-backdate": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Backdate:     &provisioner.Duration{Duration: -time.Minute},
+				},
+				err: errors.New("authority.backdate cannot be less than 0"),
+			}
+		},
+		"fail-clock-skew": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					ClockSkew:    &provisioner.Duration{Duration: -time.Minute},
+				},
+				err: errors.New("authority.clockSkew cannot be less than 0"),
+			}
+		},
+		"fail-empty-admin": func(t *testing.T) AuthConfigValidateTest {
+			return AuthConfigValidateTest{
+				ac: &AuthConfig{
+					Provisioners: p,
+					Admins:       []string{"admin@smallstep.com", ""},
+				},
+				err: errors.New("authority.admins cannot contain an empty value"),
+			}
+		},
 	}
 
 	for name, get := range tests {
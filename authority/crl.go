@@ -0,0 +1,356 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+var (
+	defaultCRLRefreshPeriod = time.Hour
+	defaultCRLValidity      = 24 * time.Hour
+
+	oidExtensionCRLNumber  = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+// CRLConfig configures the certificate revocation list (CRL) generated with
+// the X.509 certificates revoked in the database. The CRL is generated every
+// RefreshPeriod and after every revocation, is valid for Validity, and it's
+// signed with the intermediate, or with the delegated CRL signer in Cert and
+// Key.
+type CRLConfig struct {
+	RefreshPeriod      *provisioner.Duration `json:"refreshPeriod,omitempty"`
+	Validity           *provisioner.Duration `json:"validity,omitempty"`
+	DistributionPoints []string              `json:"distributionPoints,omitempty"`
+	File               string                `json:"file,omitempty"`
+	Cert               string                `json:"crt,omitempty"`
+	Key                string                `json:"key,omitempty"`
+}
+
+// Validate checks the fields in CRLConfig, nil is ok.
+func (c *CRLConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.RefreshPeriod != nil && c.RefreshPeriod.Duration <= 0:
+		return errors.New("crl.refreshPeriod must be greater than 0")
+	case c.Validity != nil && c.Validity.Duration <= 0:
+		return errors.New("crl.validity must be greater than 0")
+	case c.validity() < c.refreshPeriod():
+		return errors.New("crl.validity cannot be shorter than crl.refreshPeriod")
+	case (c.Cert == "") != (c.Key == ""):
+		return errors.New("crl.crt and crl.key must be set together")
+	}
+	for _, s := range c.DistributionPoints {
+		if u, err := url.Parse(s); err != nil || !u.IsAbs() || u.Host == "" {
+			return errors.Errorf("crl.distributionPoints %s is not a valid url", s)
+		}
+	}
+	return nil
+}
+
+func (c *CRLConfig) refreshPeriod() time.Duration {
+	if c.RefreshPeriod == nil {
+		return defaultCRLRefreshPeriod
+	}
+	return c.RefreshPeriod.Duration
+}
+
+func (c *CRLConfig) validity() time.Duration {
+	if c.Validity == nil {
+		return defaultCRLValidity
+	}
+	return c.Validity.Duration
+}
+
+// initCRL loads the CRL signer and starts the generation of the CRL.
+func (a *Authority) initCRL() error {
+	c := a.config.CRL
+	if c == nil {
+		return nil
+	}
+	a.crlIssuer, a.crlSigner = a.x509Issuer, a.x509Signer
+	if c.Cert != "" {
+		crt, err := pemutil.ReadCertificate(c.Cert)
+		if err != nil {
+			return err
+		}
+		if crt.KeyUsage&x509.KeyUsageCRLSign == 0 {
+			return errors.Errorf("error loading %s: certificate does not have the cRLSign key usage", c.Cert)
+		}
+		signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+			SigningKey: c.Key,
+			Password:   []byte(a.config.Password),
+		})
+		if err != nil {
+			return err
+		}
+		a.crlIssuer, a.crlSigner = crt, signer
+	}
+
+	// The first CRL is generated in the background, errors are retried on
+	// the next refresh.
+	a.crlStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(c.refreshPeriod())
+		defer ticker.Stop()
+		for {
+			a.GenerateCRL()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(a.crlStop)
+	return nil
+}
+
+// StopCRLGenerator stops the periodic generation of the CRL.
+func (a *Authority) StopCRLGenerator() {
+	a.crlMutex.Lock()
+	defer a.crlMutex.Unlock()
+	if a.crlStop != nil {
+		close(a.crlStop)
+		a.crlStop = nil
+	}
+}
+
+// GetCertificateRevocationList returns the last CRL in DER format, it
+// generates it if it's not available.
+func (a *Authority) GetCertificateRevocationList() ([]byte, error) {
+	if a.config.CRL == nil {
+		return nil, errs.NotFound("authority.GetCertificateRevocationList: crl is not configured")
+	}
+	a.crlMutex.RLock()
+	crl := a.crl
+	a.crlMutex.RUnlock()
+	if crl != nil {
+		return crl, nil
+	}
+	return a.GenerateCRL()
+}
+
+// GenerateCRL generates a new CRL with all the revoked X.509 certificates in
+// the database, and writes it to the configured file.
+func (a *Authority) GenerateCRL() ([]byte, error) {
+	c := a.config.CRL
+	if c == nil {
+		return nil, errs.NotFound("authority.GenerateCRL: crl is not configured")
+	}
+	revoked, err := a.db.GetRevokedCertificates()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GenerateCRL: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GenerateCRL")
+	}
+
+	entries := make([]pkix.RevokedCertificate, 0, len(revoked))
+	for _, rci := range revoked {
+		sn, ok := new(big.Int).SetString(rci.Serial, 10)
+		if !ok {
+			continue
+		}
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   sn,
+			RevocationTime: rci.RevokedAt.UTC(),
+		}
+		// The reason code unspecified (0) should not be used.
+		if rci.ReasonCode > 0 {
+			b, err := asn1.Marshal(asn1.Enumerated(rci.ReasonCode))
+			if err != nil {
+				return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GenerateCRL")
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidExtensionReasonCode, Value: b}}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SerialNumber.Cmp(entries[j].SerialNumber) < 0
+	})
+
+	a.crlMutex.Lock()
+	defer a.crlMutex.Unlock()
+
+	// The CRL number must increase with every CRL, including the ones
+	// generated by previous runs.
+	now := time.Now().UTC().Truncate(time.Second)
+	number := now.Unix()
+	if number <= a.crlNumber {
+		number = a.crlNumber + 1
+	}
+	crl, err := createCRL(a.crlIssuer, a.crlSigner, entries, big.NewInt(number), now, now.Add(c.validity()))
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GenerateCRL")
+	}
+	a.crl, a.crlNumber = crl, number
+
+	if c.File != "" {
+		if err := writeFileAtomic(c.File, crl, 0644); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GenerateCRL")
+		}
+	}
+	return crl, nil
+}
+
+// createCRL returns a v2 CRL in DER format with the authority key identifier
+// and CRL number extensions.
+func createCRL(issuer *x509.Certificate, signer crypto.Signer, revoked []pkix.RevokedCertificate, number *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	sigAlg, hash, err := crlSignatureAlgorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	var issuerName pkix.RDNSequence
+	if _, err := asn1.Unmarshal(issuer.RawSubject, &issuerName); err != nil {
+		return nil, errors.Wrap(err, "error parsing issuer subject")
+	}
+	numberBytes, err := asn1.Marshal(number)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling crl number")
+	}
+	extensions := []pkix.Extension{{Id: oidExtensionCRLNumber, Value: numberBytes}}
+	if len(issuer.SubjectKeyId) > 0 {
+		aki, err := asn1.Marshal(struct {
+			ID []byte `asn1:"optional,tag:0"`
+		}{issuer.SubjectKeyId})
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling authority key identifier")
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidAuthorityKeyIdentifier, Value: aki})
+	}
+
+	tbs := pkix.TBSCertificateList{
+		Version:             1, // v2
+		Signature:           sigAlg,
+		Issuer:              issuerName,
+		ThisUpdate:          thisUpdate,
+		NextUpdate:          nextUpdate,
+		RevokedCertificates: revoked,
+		Extensions:          extensions,
+	}
+	if len(revoked) == 0 {
+		tbs.RevokedCertificates = nil
+	}
+	tbsBytes, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling crl")
+	}
+
+	digest := tbsBytes
+	if hash != 0 {
+		h := hash.New()
+		h.Write(tbsBytes)
+		digest = h.Sum(nil)
+	}
+	signature, err := signer.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing crl")
+	}
+
+	return asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        pkix.TBSCertificateList{Raw: tbsBytes},
+		SignatureAlgorithm: sigAlg,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+}
+
+// crlSignatureAlgorithm returns the signature algorithm and hash used to sign
+// a CRL with the given key.
+func crlSignatureAlgorithm(pub crypto.PublicKey) (pkix.AlgorithmIdentifier, crypto.Hash, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}, crypto.SHA256, nil
+		case elliptic.P384():
+			return pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}}, crypto.SHA384, nil
+		case elliptic.P521():
+			return pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}}, crypto.SHA512, nil
+		}
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{
+			Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11},
+			Parameters: asn1.NullRawValue,
+		}, crypto.SHA256, nil
+	case ed25519.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 101, 112}}, 0, nil
+	}
+	return pkix.AlgorithmIdentifier{}, 0, errors.Errorf("unsupported crl signer key type %T", pub)
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory
+// and renames it, so readers never see a partial file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", filename)
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	return nil
+}
+
+// crlDistributionPoints returns the distribution points to add in the
+// certificates signed by the given issuer with the given ones. The
+// certificates that already have them are not modified. The CRL is only
+// signed for the certificates of the configured intermediate, so the
+// certificates signed by the additional intermediates do not get the
+// configured distribution points, and they are removed from the renewals of
+// the old ones.
+func (a *Authority) crlDistributionPoints(current []string, issuer *x509.Certificate) []string {
+	c := a.config.CRL
+	if c == nil {
+		return current
+	}
+	if a.isAdditionalIntermediate(issuer) {
+		if reflect.DeepEqual(current, c.DistributionPoints) {
+			return nil
+		}
+		return current
+	}
+	if len(current) > 0 {
+		return current
+	}
+	return c.DistributionPoints
+}
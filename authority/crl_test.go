@@ -0,0 +1,282 @@
+package authority
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ocsp"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestCRLConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *CRLConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &CRLConfig{}, false},
+		{"ok", &CRLConfig{
+			RefreshPeriod:      &provisioner.Duration{Duration: 10 * time.Minute},
+			Validity:           &provisioner.Duration{Duration: time.Hour},
+			DistributionPoints: []string{"http://crl.example.com/ca.crl"},
+			Cert:               "crl.crt", Key: "crl.key",
+		}, false},
+		{"fail/refreshPeriod", &CRLConfig{RefreshPeriod: &provisioner.Duration{}}, true},
+		{"fail/validity", &CRLConfig{Validity: &provisioner.Duration{Duration: -time.Hour}}, true},
+		{"fail/validity-refreshPeriod", &CRLConfig{Validity: &provisioner.Duration{Duration: 30 * time.Minute}}, true},
+		{"fail/cert", &CRLConfig{Cert: "crl.crt"}, true},
+		{"fail/key", &CRLConfig{Key: "crl.key"}, true},
+		{"fail/distributionPoints", &CRLConfig{DistributionPoints: []string{"/crl"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CRLConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthority_GenerateCRL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crl")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetRevokedCerts: func() ([]*db.RevokedCertificateInfo, error) {
+			return []*db.RevokedCertificateInfo{
+				{Serial: "5678", RevokedAt: revokedAt},
+				{Serial: "1234", ReasonCode: 1, RevokedAt: revokedAt},
+				{Serial: "not-a-serial", RevokedAt: revokedAt},
+			}, nil
+		},
+	}))
+	a.config.CRL = &CRLConfig{
+		DistributionPoints: []string{"http://crl.example.com/ca.crl"},
+		File:               filepath.Join(dir, "ca.crl"),
+	}
+	assert.FatalError(t, a.initCRL())
+	defer a.StopCRLGenerator()
+
+	b, err := a.GenerateCRL()
+	assert.FatalError(t, err)
+	crl, err := x509.ParseCRL(b)
+	assert.FatalError(t, err)
+	assert.FatalError(t, a.x509Issuer.CheckCRLSignature(crl))
+	assert.Equals(t, 1, crl.TBSCertList.Version)
+	assert.Equals(t, a.x509Issuer.Subject.String(), crl.TBSCertList.Issuer.String())
+	assert.True(t, crl.TBSCertList.NextUpdate.Sub(crl.TBSCertList.ThisUpdate) == defaultCRLValidity)
+
+	revoked := crl.TBSCertList.RevokedCertificates
+	assert.Len(t, 2, revoked)
+	assert.Equals(t, big.NewInt(1234), revoked[0].SerialNumber)
+	assert.Equals(t, big.NewInt(5678), revoked[1].SerialNumber)
+	assert.True(t, revoked[0].RevocationTime.Equal(revokedAt))
+	assert.Len(t, 1, revoked[0].Extensions)
+	assert.Equals(t, oidExtensionReasonCode, revoked[0].Extensions[0].Id)
+	assert.Len(t, 0, revoked[1].Extensions)
+
+	crlNumber := func(crl *pkix.CertificateList) *big.Int {
+		for _, ext := range crl.TBSCertList.Extensions {
+			if ext.Id.Equal(oidExtensionCRLNumber) {
+				n := new(big.Int)
+				_, err := asn1.Unmarshal(ext.Value, &n)
+				assert.FatalError(t, err)
+				return n
+			}
+		}
+		t.Fatal("crl number not found")
+		return nil
+	}
+	number := crlNumber(crl)
+
+	// The file and the endpoint return the last CRL.
+	b2, err := a.GenerateCRL()
+	assert.FatalError(t, err)
+	crl2, err := x509.ParseCRL(b2)
+	assert.FatalError(t, err)
+	assert.True(t, crlNumber(crl2).Cmp(number) > 0)
+	exported, err := ioutil.ReadFile(a.config.CRL.File)
+	assert.FatalError(t, err)
+	assert.Equals(t, b2, exported)
+	got, err := a.GetCertificateRevocationList()
+	assert.FatalError(t, err)
+	assert.Equals(t, b2, got)
+
+	// Issued certificates contain the distribution points.
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"http://crl.example.com/ca.crl"}, certChain[0].CRLDistributionPoints)
+}
+
+func TestAuthority_CRL_additionalIntermediate(t *testing.T) {
+	var revoked []*db.RevokedCertificateInfo
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MRevoke: func(rci *db.RevokedCertificateInfo) error {
+			revoked = append(revoked, rci)
+			return nil
+		},
+		MGetRevokedCerts: func() ([]*db.RevokedCertificateInfo, error) {
+			return revoked, nil
+		},
+		MGetRevokedCert: func(sn string) (*db.RevokedCertificateInfo, error) {
+			for _, rci := range revoked {
+				if rci.Serial == sn {
+					return rci, nil
+				}
+			}
+			return nil, nil
+		},
+	}))
+	a.config.CRL = &CRLConfig{
+		DistributionPoints: []string{"http://crl.example.com/ca.crl"},
+	}
+	assert.FatalError(t, a.initCRL())
+	defer a.StopCRLGenerator()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other Intermediate"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "Other Intermediate"}}, key.Public(), key)
+	assert.FatalError(t, err)
+	other, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	a.x509Intermediates = append(a.x509Intermediates, &x509Intermediate{name: "other", cert: other, signer: key})
+
+	// The CRL is signed by the configured intermediate, so the certificates
+	// of other intermediates do not point to it.
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{Intermediate: "other"})
+	assert.FatalError(t, err)
+	crt := certChain[0]
+	assert.FatalError(t, crt.CheckSignatureFrom(other))
+	assert.Len(t, 0, crt.CRLDistributionPoints)
+
+	// Revoke the certificate, it is reported by the OCSP responses of the
+	// other intermediate.
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+	now := time.Now()
+	raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+		Subject:   crt.SerialNumber.String(),
+		Issuer:    "step-cli",
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+		Audience:  testAudiences.Revoke,
+		ID:        "1",
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+	assert.FatalError(t, a.Revoke(context.Background(), &RevokeOptions{
+		Serial:     crt.SerialNumber.String(),
+		ReasonCode: ocsp.KeyCompromise,
+		OTT:        raw,
+	}))
+	req, err := ocsp.CreateRequest(crt, other, nil)
+	assert.FatalError(t, err)
+	b, err := a.GetOCSPResponse(req)
+	assert.FatalError(t, err)
+	resp, err := ocsp.ParseResponseForCert(b, crt, other)
+	assert.FatalError(t, err)
+	assert.Equals(t, ocsp.Revoked, resp.Status)
+	assert.Equals(t, ocsp.KeyCompromise, resp.RevocationReason)
+
+	// The distribution points of old certificates are removed on renewals,
+	// the ones set by templates are kept.
+	assert.Equals(t, []string(nil), a.crlDistributionPoints([]string{"http://crl.example.com/ca.crl"}, other))
+	assert.Equals(t, []string{"http://crl.example.com/other.crl"}, a.crlDistributionPoints([]string{"http://crl.example.com/other.crl"}, other))
+	assert.Equals(t, []string{"http://crl.example.com/ca.crl"}, a.crlDistributionPoints(nil, a.x509Issuer))
+}
+
+func TestAuthority_GetCertificateRevocationList(t *testing.T) {
+	assertError := func(err error, code int) {
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+		}
+	}
+
+	a := testAuthority(t)
+	_, err := a.GetCertificateRevocationList()
+	assertError(err, http.StatusNotFound)
+
+	a.config.CRL = &CRLConfig{}
+	a.crlIssuer, a.crlSigner = a.x509Issuer, a.x509Signer
+	_, err = a.GetCertificateRevocationList()
+	assertError(err, http.StatusNotImplemented)
+}
+
+func Test_createCRL(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CRL Signer"},
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCRLSign | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, priv)
+	assert.FatalError(t, err)
+	signer, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	b, err := createCRL(signer, priv, nil, big.NewInt(1), now, now.Add(time.Hour))
+	assert.FatalError(t, err)
+	crl, err := x509.ParseCRL(b)
+	assert.FatalError(t, err)
+	assert.FatalError(t, signer.CheckCRLSignature(crl))
+	assert.Len(t, 0, crl.TBSCertList.RevokedCertificates)
+	assert.Equals(t, "CN=CRL Signer", crl.TBSCertList.Issuer.String())
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.FatalError(t, err)
+	_, _, err = crlSignatureAlgorithm(rsaKey.Public())
+	assert.FatalError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.FatalError(t, err)
+	_, _, err = crlSignatureAlgorithm(ecKey.Public())
+	assert.Error(t, err)
+}
@@ -0,0 +1,198 @@
+package authority
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/ct"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+var defaultCTTimeout = 10 * time.Second
+
+// CTConfig configures the submission of the X.509 certificates to
+// Certificate Transparency logs. A precertificate is submitted to all the
+// Logs and the signed certificate timestamps (SCTs) returned are embedded in
+// the final certificate. The certificate is not issued if less than MinSCTs
+// logs return an SCT, by default the submission is best-effort.
+type CTConfig struct {
+	Logs    []string              `json:"logs"`
+	MinSCTs int                   `json:"minSCTs,omitempty"`
+	Timeout *provisioner.Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks the fields in CTConfig, nil is ok.
+func (c *CTConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case len(c.Logs) == 0:
+		return errors.New("ct.logs cannot be empty")
+	case c.MinSCTs < 0 || c.MinSCTs > len(c.Logs):
+		return errors.Errorf("ct.minSCTs must be between 0 and %d", len(c.Logs))
+	case c.Timeout != nil && c.Timeout.Duration <= 0:
+		return errors.New("ct.timeout must be greater than 0")
+	}
+	for _, s := range c.Logs {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.Errorf("ct.logs contains an invalid url %s", s)
+		}
+	}
+	return nil
+}
+
+func (c *CTConfig) timeout() time.Duration {
+	if c.Timeout == nil {
+		return defaultCTTimeout
+	}
+	return c.Timeout.Duration
+}
+
+// CTSubmission is the status of the submission of a precertificate to a
+// Certificate Transparency log.
+type CTSubmission struct {
+	Log         string    `json:"log"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Timestamp   time.Time `json:"timestamp,omitempty"`
+	SubmittedAt time.Time `json:"submittedAt"`
+}
+
+// Status of a CTSubmission.
+const (
+	CTSubmissionOK    = "ok"
+	CTSubmissionError = "error"
+)
+
+// initCT creates the clients of the configured Certificate Transparency logs.
+func (a *Authority) initCT() {
+	a.ctLogs = nil
+	if a.config.CT == nil {
+		return
+	}
+	client := &http.Client{Timeout: a.config.CT.timeout()}
+	for _, u := range a.config.CT.Logs {
+		a.ctLogs = append(a.ctLogs, ct.NewClient(u, client))
+	}
+}
+
+// createCertificate signs the certificate in the given profile, the signer
+// must be the one of the profile issuer. If Certificate Transparency is
+// configured, it first signs a precertificate, submits it to the logs and
+// embeds the SCTs returned in the certificate.
+func (a *Authority) createCertificate(leaf x509util.Profile, signer crypto.Signer) ([]byte, error) {
+	if len(a.ctLogs) == 0 {
+		return leaf.CreateCertificate()
+	}
+
+	crt := leaf.Subject()
+	crt.ExtraExtensions = append(crt.ExtraExtensions, ct.PoisonExtension)
+	b, err := leaf.CreateCertificate()
+	if err != nil {
+		return nil, err
+	}
+	precert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing precertificate")
+	}
+
+	scts, submissions := a.submitPrecertificate(precert, leaf.Issuer())
+	if err := a.storeCTSubmissions(precert.SerialNumber, submissions); err != nil {
+		return nil, err
+	}
+	if len(scts) < a.config.CT.MinSCTs {
+		return nil, errors.Errorf("error submitting precertificate: %d SCTs received, %d required",
+			len(scts), a.config.CT.MinSCTs)
+	}
+
+	// Replace the poison extension with the SCTs. The serial number and the
+	// rest of the template are the same used in the precertificate.
+	var extensions []pkix.Extension
+	for _, ext := range crt.ExtraExtensions {
+		if !ext.Id.Equal(ct.OIDExtensionPoison) {
+			extensions = append(extensions, ext)
+		}
+	}
+	if len(scts) > 0 {
+		ext, err := ct.MarshalSCTList(scts)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, ext)
+	}
+	crt.ExtraExtensions = extensions
+
+	b, err = x509.CreateCertificate(rand.Reader, crt, leaf.Issuer(), leaf.SubjectPublicKey(), signer)
+	return b, errors.WithStack(err)
+}
+
+// submitPrecertificate submits the precertificate to all the logs in
+// parallel, it returns the SCTs received and the status of each submission.
+func (a *Authority) submitPrecertificate(precert, issuer *x509.Certificate) ([]*ct.SignedCertificateTimestamp, []CTSubmission) {
+	chain := []*x509.Certificate{precert, issuer}
+	if len(a.rootX509Certs) > 0 && !issuer.Equal(a.rootX509Certs[0]) {
+		chain = append(chain, a.rootX509Certs[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.CT.timeout())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	scts := make([]*ct.SignedCertificateTimestamp, len(a.ctLogs))
+	submissions := make([]CTSubmission, len(a.ctLogs))
+	for i, log := range a.ctLogs {
+		wg.Add(1)
+		go func(i int, log *ct.Client) {
+			defer wg.Done()
+			submissions[i] = CTSubmission{
+				Log:         log.URL(),
+				SubmittedAt: time.Now().UTC(),
+			}
+			sct, err := log.AddPreChain(ctx, chain)
+			if err != nil {
+				submissions[i].Status = CTSubmissionError
+				submissions[i].Error = err.Error()
+				return
+			}
+			scts[i] = sct
+			submissions[i].Status = CTSubmissionOK
+			submissions[i].Timestamp = sct.Time()
+		}(i, log)
+	}
+	wg.Wait()
+
+	var list []*ct.SignedCertificateTimestamp
+	for _, sct := range scts {
+		if sct != nil {
+			list = append(list, sct)
+		}
+	}
+	return list, submissions
+}
+
+// storeCTSubmissions stores the status of the submissions of the
+// precertificate with the given serial number.
+func (a *Authority) storeCTSubmissions(serialNumber *big.Int, submissions []CTSubmission) error {
+	b, err := json.Marshal(submissions)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling ct submissions")
+	}
+	if err := a.db.StoreCTSubmissions(serialNumber.String(), b); err != nil && err != db.ErrNotImplemented {
+		return errors.Wrap(err, "error storing ct submissions")
+	}
+	return nil
+}
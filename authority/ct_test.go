@@ -0,0 +1,189 @@
+package authority
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/ct"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+func TestCTConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *CTConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &CTConfig{Logs: []string{"https://ct.example.com/2020", "http://localhost:6962"}, MinSCTs: 2,
+			Timeout: &provisioner.Duration{Duration: time.Second}}, false},
+		{"fail/logs", &CTConfig{}, true},
+		{"fail/url", &CTConfig{Logs: []string{"ct.example.com"}}, true},
+		{"fail/scheme", &CTConfig{Logs: []string{"ftp://ct.example.com"}}, true},
+		{"fail/minSCTs", &CTConfig{Logs: []string{"https://ct.example.com"}, MinSCTs: 2}, true},
+		{"fail/minSCTs-negative", &CTConfig{Logs: []string{"https://ct.example.com"}, MinSCTs: -1}, true},
+		{"fail/timeout", &CTConfig{Logs: []string{"https://ct.example.com"}, Timeout: &provisioner.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CTConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testCTLog returns a log that returns an SCT for every precertificate
+// submitted.
+func testCTLog(t *testing.T, id byte, precerts *[]*x509.Certificate) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Chain [][]byte `json:"chain"`
+		}
+		assert.FatalError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Len(t, 3, body.Chain)
+		precert, err := x509.ParseCertificate(body.Chain[0])
+		assert.FatalError(t, err)
+		*precerts = append(*precerts, precert)
+		assert.FatalError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"sct_version": 0,
+			"id":          bytes.Repeat([]byte{id}, 32),
+			"timestamp":   time.Now().UnixNano() / int64(time.Millisecond),
+			"extensions":  "",
+			"signature":   []byte{4, 3, 0, 2, id, id},
+		}))
+	}))
+}
+
+func hasExtension(crt *x509.Certificate, ext []int) bool {
+	for _, e := range crt.Extensions {
+		if e.Id.Equal(ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAuthority_Sign_ct(t *testing.T) {
+	var precerts []*x509.Certificate
+	log := testCTLog(t, 1, &precerts)
+	defer log.Close()
+	failLog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad chain", http.StatusBadRequest)
+	}))
+	defer failLog.Close()
+
+	var stored map[string][]CTSubmission
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MStoreCertificate: func(crt *x509.Certificate) error { return nil },
+		MStoreCTSubmissions: func(serial string, b []byte) error {
+			var submissions []CTSubmission
+			assert.FatalError(t, json.Unmarshal(b, &submissions))
+			stored[serial] = submissions
+			return nil
+		},
+	}))
+	a.config.CT = &CTConfig{Logs: []string{log.URL, failLog.URL}}
+	a.initCT()
+
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	// Best-effort submission
+	stored = map[string][]CTSubmission{}
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	crt := certChain[0]
+	assert.Len(t, 1, precerts)
+	assert.Equals(t, crt.SerialNumber, precerts[0].SerialNumber)
+	assert.True(t, hasExtension(precerts[0], ct.OIDExtensionPoison))
+	assert.True(t, hasExtension(crt, ct.OIDExtensionSCTList))
+	assert.False(t, hasExtension(crt, ct.OIDExtensionPoison))
+	assert.FatalError(t, crt.CheckSignatureFrom(a.x509Issuer))
+
+	submissions := stored[crt.SerialNumber.String()]
+	assert.Len(t, 2, submissions)
+	assert.Equals(t, log.URL, submissions[0].Log)
+	assert.Equals(t, CTSubmissionOK, submissions[0].Status)
+	assert.False(t, submissions[0].Timestamp.IsZero())
+	assert.Equals(t, failLog.URL, submissions[1].Log)
+	assert.Equals(t, CTSubmissionError, submissions[1].Status)
+	assert.HasPrefix(t, submissions[1].Error, "error submitting chain")
+
+	for _, e := range crt.Extensions {
+		if e.Id.Equal(ct.OIDExtensionSCTList) {
+			scts, err := ct.ParseSCTList(e)
+			assert.FatalError(t, err)
+			assert.Len(t, 1, scts)
+			assert.Equals(t, bytes.Repeat([]byte{1}, 32), scts[0].LogID)
+		}
+	}
+
+	// Not enough SCTs
+	stored = map[string][]CTSubmission{}
+	a.config.CT.MinSCTs = 2
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.Error(t, err)
+	assert.Len(t, 1, stored)
+
+	// Without any SCT the certificate does not contain the extension.
+	stored = map[string][]CTSubmission{}
+	a.config.CT = &CTConfig{Logs: []string{failLog.URL}}
+	a.initCT()
+	certChain, err = a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.False(t, hasExtension(certChain[0], ct.OIDExtensionSCTList))
+	assert.False(t, hasExtension(certChain[0], ct.OIDExtensionPoison))
+}
+
+func TestAuthority_Renew_ct(t *testing.T) {
+	var precerts []*x509.Certificate
+	log := testCTLog(t, 2, &precerts)
+	defer log.Close()
+
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MIsRevoked:          func(sn string) (bool, error) { return false, nil },
+		MStoreCertificate:   func(crt *x509.Certificate) error { return nil },
+		MStoreCTSubmissions: func(serial string, b []byte) error { return nil },
+	}))
+	a.config.CT = &CTConfig{Logs: []string{log.URL}, MinSCTs: 1}
+	a.initCT()
+
+	pub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	now := time.Now()
+	leaf, err := x509util.NewLeafProfile("renew", a.x509Issuer, a.x509Signer,
+		x509util.WithNotBeforeAfterDuration(now, now.Add(time.Hour), 0),
+		x509util.WithPublicKey(pub), x509util.WithHosts("test.smallstep.com"),
+		withProvisionerOID("Max", a.config.AuthorityConfig.Provisioners[0].(*provisioner.JWK).Key.KeyID))
+	assert.FatalError(t, err)
+	b, err := a.createCertificate(leaf, a.x509Signer)
+	assert.FatalError(t, err)
+	cert, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	assert.True(t, hasExtension(cert, ct.OIDExtensionSCTList))
+
+	// The SCTs of the old certificate are not copied to the precertificate.
+	certChain, err := a.Renew(cert)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, precerts)
+	assert.False(t, hasExtension(precerts[1], ct.OIDExtensionSCTList))
+	assert.True(t, hasExtension(precerts[1], ct.OIDExtensionPoison))
+	var n int
+	for _, e := range certChain[0].Extensions {
+		if e.Id.Equal(ct.OIDExtensionSCTList) {
+			n++
+		}
+	}
+	assert.Equals(t, 1, n)
+	assert.False(t, hasExtension(certChain[0], ct.OIDExtensionPoison))
+}
@@ -0,0 +1,448 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	defaultEventMaxAttempts  = 5
+	defaultEventRetryBackoff = time.Second
+	eventWebhookTimeout      = 30 * time.Second
+	// eventQueueSize is the number of events waiting to be sent to each
+	// webhook, the events that don't fit are sent to the dead letter file.
+	eventQueueSize = 1024
+)
+
+// Types of the events sent to the webhooks.
+const (
+	EventCertificateIssued  = "certificate.issued"
+	EventCertificateRenewed = "certificate.renewed"
+	EventCertificateRevoked = "certificate.revoked"
+	EventACMEOrderFailed    = "acme.order.failed"
+	EventProvisionerAdded   = "provisioner.added"
+)
+
+// EventWebhookIDHeader is the header with the id of the event sent, the
+// retries of an event have the same id.
+const EventWebhookIDHeader = "X-Smallstep-Event-ID"
+
+var eventTypes = map[string]bool{
+	EventCertificateIssued:  true,
+	EventCertificateRenewed: true,
+	EventCertificateRevoked: true,
+	EventACMEOrderFailed:    true,
+	EventProvisionerAdded:   true,
+}
+
+// EventsConfig configures the notification of the lifecycle events to the
+// Webhooks. An event is sent up to MaxAttempts times, 5 by default, waiting
+// RetryBackoff, 1s by default, after the first failure and doubling it after
+// each one. The events that cannot be sent are appended as JSON lines to the
+// DeadLetter file if it's set, or logged otherwise.
+type EventsConfig struct {
+	Webhooks     []*EventWebhook       `json:"webhooks"`
+	MaxAttempts  int                   `json:"maxAttempts,omitempty"`
+	RetryBackoff *provisioner.Duration `json:"retryBackoff,omitempty"`
+	DeadLetter   string                `json:"deadLetter,omitempty"`
+}
+
+// Validate checks the fields in EventsConfig, nil is ok.
+func (c *EventsConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case len(c.Webhooks) == 0:
+		return errors.New("events.webhooks cannot be empty")
+	case c.MaxAttempts < 0:
+		return errors.New("events.maxAttempts cannot be negative")
+	case c.RetryBackoff != nil && c.RetryBackoff.Duration <= 0:
+		return errors.New("events.retryBackoff must be greater than 0")
+	}
+	for _, w := range c.Webhooks {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *EventsConfig) maxAttempts() int {
+	if c.MaxAttempts == 0 {
+		return defaultEventMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+func (c *EventsConfig) retryBackoff() time.Duration {
+	if c.RetryBackoff == nil {
+		return defaultEventRetryBackoff
+	}
+	return c.RetryBackoff.Duration
+}
+
+// EventWebhook is a URL that receives a POST request with each event in
+// JSON, signed with HMAC-SHA256 in the X-Smallstep-Signature header if the
+// base64 encoded Secret is set. If Events is not empty only those types of
+// events are sent.
+type EventWebhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Validate checks the fields in EventWebhook.
+func (w *EventWebhook) Validate() error {
+	if w == nil {
+		return errors.New("events.webhooks cannot contain an empty value")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.Errorf("events.webhooks contains an invalid url %s", w.URL)
+	}
+	if _, err := base64.StdEncoding.DecodeString(w.Secret); err != nil {
+		return errors.Wrap(err, "events.webhooks secret must be base64 encoded")
+	}
+	for _, t := range w.Events {
+		if !eventTypes[t] {
+			return errors.Errorf("events.webhooks event %q is not valid", t)
+		}
+	}
+	return nil
+}
+
+// Event is a lifecycle event of a certificate, an ACME order or a
+// provisioner. CertificateType is x509 or ssh, and the SANs of the SSH
+// certificates are their principals.
+type Event struct {
+	ID              string     `json:"id"`
+	Type            string     `json:"type"`
+	Time            time.Time  `json:"time"`
+	CertificateType string     `json:"certificateType,omitempty"`
+	Provisioner     string     `json:"provisioner,omitempty"`
+	ProvisionerType string     `json:"provisionerType,omitempty"`
+	Serial          string     `json:"serial,omitempty"`
+	PreviousSerial  string     `json:"previousSerial,omitempty"`
+	Subject         string     `json:"subject,omitempty"`
+	SANs            []string   `json:"sans,omitempty"`
+	NotBefore       *time.Time `json:"notBefore,omitempty"`
+	NotAfter        *time.Time `json:"notAfter,omitempty"`
+	KeyID           string     `json:"keyID,omitempty"`
+	ReasonCode      int        `json:"reasonCode,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	Account         string     `json:"account,omitempty"`
+	Order           string     `json:"order,omitempty"`
+	Identifiers     []string   `json:"identifiers,omitempty"`
+}
+
+func newEvent(typ string) *Event {
+	return &Event{
+		ID:   xid.New().String(),
+		Type: typ,
+		Time: time.Now().UTC(),
+	}
+}
+
+func newX509Event(typ string, crt *x509.Certificate) *Event {
+	e := newEvent(typ)
+	e.CertificateType = "x509"
+	e.Provisioner, _ = provisioner.GetProvisionerName(crt)
+	e.Serial = crt.SerialNumber.String()
+	e.Subject = crt.Subject.CommonName
+	e.SANs = certificateSANs(crt)
+	notBefore, notAfter := crt.NotBefore.UTC(), crt.NotAfter.UTC()
+	e.NotBefore, e.NotAfter = &notBefore, &notAfter
+	return e
+}
+
+func newSSHEvent(typ string, cert *ssh.Certificate) *Event {
+	e := newEvent(typ)
+	e.CertificateType = "ssh"
+	e.Serial = strconv.FormatUint(cert.Serial, 10)
+	e.Subject = cert.KeyId
+	e.SANs = cert.ValidPrincipals
+	notBefore := time.Unix(int64(cert.ValidAfter), 0).UTC()
+	e.NotBefore = &notBefore
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		notAfter := time.Unix(int64(cert.ValidBefore), 0).UTC()
+		e.NotAfter = &notAfter
+	}
+	return e
+}
+
+// deadLetter is a failed delivery of an event.
+type deadLetter struct {
+	Time  time.Time       `json:"time"`
+	URL   string          `json:"url"`
+	Error string          `json:"error"`
+	Event json.RawMessage `json:"event"`
+}
+
+// eventDispatcher sends the events in the background to the webhooks, each
+// webhook has its own queue so a failing one does not delay the rest.
+type eventDispatcher struct {
+	mu          sync.Mutex
+	webhooks    []*eventWebhook
+	maxAttempts int
+	backoff     time.Duration
+	deadLetter  *os.File
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+type eventWebhook struct {
+	url    string
+	secret []byte
+	events map[string]bool
+	queue  chan *queuedEvent
+}
+
+type queuedEvent struct {
+	id   string
+	body []byte
+}
+
+func newEventDispatcher(c *EventsConfig) (*eventDispatcher, error) {
+	d := &eventDispatcher{
+		maxAttempts: c.maxAttempts(),
+		backoff:     c.retryBackoff(),
+		stop:        make(chan struct{}),
+	}
+	for _, w := range c.Webhooks {
+		secret, err := base64.StdEncoding.DecodeString(w.Secret)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding events webhook secret")
+		}
+		wh := &eventWebhook{
+			url:    w.URL,
+			secret: secret,
+			queue:  make(chan *queuedEvent, eventQueueSize),
+		}
+		if len(w.Events) > 0 {
+			wh.events = make(map[string]bool, len(w.Events))
+			for _, t := range w.Events {
+				wh.events[t] = true
+			}
+		}
+		d.webhooks = append(d.webhooks, wh)
+	}
+	if c.DeadLetter != "" {
+		f, err := os.OpenFile(c.DeadLetter, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error opening %s", c.DeadLetter)
+		}
+		d.deadLetter = f
+	}
+	for _, w := range d.webhooks {
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d, nil
+}
+
+// publish queues the event in the webhooks subscribed to its type. Nil
+// dispatchers ignore the events.
+func (d *eventDispatcher) publish(e *Event) {
+	if d == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("error marshaling event %s: %v", e.ID, err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.webhooks == nil {
+		return
+	}
+	for _, w := range d.webhooks {
+		if w.events != nil && !w.events[e.Type] {
+			continue
+		}
+		select {
+		case w.queue <- &queuedEvent{id: e.ID, body: b}:
+		default:
+			d.fail(w, b, errors.New("event queue is full"))
+		}
+	}
+}
+
+func (d *eventDispatcher) run(w *eventWebhook) {
+	defer d.wg.Done()
+	for e := range w.queue {
+		if err := d.send(w, e); err != nil {
+			d.fail(w, e.body, err)
+		}
+	}
+}
+
+// send posts the event to the webhook until it succeeds or the attempts are
+// exhausted. After stop only one attempt is made.
+func (d *eventDispatcher) send(w *eventWebhook, e *queuedEvent) error {
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(e)
+		if err == nil || attempt >= d.maxAttempts {
+			return err
+		}
+		select {
+		case <-d.stop:
+			return err
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (w *eventWebhook) post(e *queuedEvent) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(e.body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating request to %s", w.url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventWebhookIDHeader, e.id)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(e.body)
+		req.Header.Set(provisioner.WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := &http.Client{Timeout: eventWebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error posting event to %s", w.url)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("error posting event to %s: status code %d", w.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// fail writes the event in the dead letter file, or logs it if there's none.
+func (d *eventDispatcher) fail(w *eventWebhook, body []byte, err error) {
+	if d.deadLetter == nil {
+		log.Printf("error sending event %s: %v", body, err)
+		return
+	}
+	b, mErr := json.Marshal(&deadLetter{
+		Time:  time.Now().UTC(),
+		URL:   w.url,
+		Error: err.Error(),
+		Event: body,
+	})
+	if mErr == nil {
+		_, mErr = d.deadLetter.Write(append(b, '\n'))
+	}
+	if mErr != nil {
+		log.Printf("error writing dead letter of event %s: %v", body, mErr)
+	}
+}
+
+// close sends the pending events, without waiting between attempts, and
+// closes the dead letter file.
+func (d *eventDispatcher) close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	close(d.stop)
+	for _, w := range d.webhooks {
+		close(w.queue)
+	}
+	d.webhooks = nil
+	d.mu.Unlock()
+	d.wg.Wait()
+	if d.deadLetter != nil {
+		return d.deadLetter.Close()
+	}
+	return nil
+}
+
+// initEvents starts the notification of events if it's configured.
+func (a *Authority) initEvents() error {
+	if a.config.Events == nil {
+		return nil
+	}
+	d, err := newEventDispatcher(a.config.Events)
+	if err != nil {
+		return errors.Wrap(err, "error initializing events")
+	}
+	a.events = d
+	return nil
+}
+
+// StopEvents sends the pending events and stops their notification.
+func (a *Authority) StopEvents() error {
+	err := a.events.close()
+	a.events = nil
+	return err
+}
+
+// notifyRevoke sends the certificate.revoked event.
+func (a *Authority) notifyRevoke(ctx context.Context, revokeOpts *RevokeOptions, p provisioner.Interface) {
+	if a.events == nil {
+		return
+	}
+	e := newEvent(EventCertificateRevoked)
+	e.CertificateType = "x509"
+	if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
+		e.CertificateType = "ssh"
+	}
+	e.Provisioner = p.GetName()
+	e.Serial = revokeOpts.Serial
+	e.KeyID = revokeOpts.KeyID
+	e.ReasonCode = revokeOpts.ReasonCode
+	e.Reason = revokeOpts.Reason
+	a.events.publish(e)
+}
+
+// notifyProvisionerAdded sends the provisioner.added event.
+func (a *Authority) notifyProvisionerAdded(p provisioner.Interface) {
+	if a.events == nil {
+		return
+	}
+	e := newEvent(EventProvisionerAdded)
+	e.Provisioner = p.GetName()
+	e.ProvisionerType = p.GetType().String()
+	a.events.publish(e)
+}
+
+// NotifyACMEOrderFailed sends the acme.order.failed event, it's called by the
+// ACME authority when an order becomes invalid.
+func (a *Authority) NotifyACMEOrderFailed(p provisioner.Interface, accountID, orderID string, identifiers []acme.Identifier, reason string) {
+	if a.events == nil {
+		return
+	}
+	e := newEvent(EventACMEOrderFailed)
+	if p != nil {
+		e.Provisioner = p.GetName()
+	}
+	e.Account = accountID
+	e.Order = orderID
+	for _, id := range identifiers {
+		e.Identifiers = append(e.Identifiers, id.Value)
+	}
+	e.Reason = reason
+	a.events.publish(e)
+}
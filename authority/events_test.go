@@ -0,0 +1,230 @@
+package authority
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestEventsConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *EventsConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", &EventsConfig{Webhooks: []*EventWebhook{
+			{URL: "https://example.com/events", Secret: "c2VjcmV0"},
+			{URL: "http://localhost:8080", Events: []string{"certificate.issued", "acme.order.failed"}},
+		}, MaxAttempts: 3, RetryBackoff: &provisioner.Duration{Duration: time.Minute}}, false},
+		{"fail/empty", &EventsConfig{}, true},
+		{"fail/nil-webhook", &EventsConfig{Webhooks: []*EventWebhook{nil}}, true},
+		{"fail/url", &EventsConfig{Webhooks: []*EventWebhook{{URL: "ftp://example.com"}}}, true},
+		{"fail/secret", &EventsConfig{Webhooks: []*EventWebhook{{URL: "https://example.com", Secret: "%"}}}, true},
+		{"fail/event", &EventsConfig{Webhooks: []*EventWebhook{{URL: "https://example.com", Events: []string{"certificate.expired"}}}}, true},
+		{"fail/maxAttempts", &EventsConfig{Webhooks: []*EventWebhook{{URL: "https://example.com"}}, MaxAttempts: -1}, true},
+		{"fail/retryBackoff", &EventsConfig{Webhooks: []*EventWebhook{{URL: "https://example.com"}}, RetryBackoff: &provisioner.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("EventsConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	deadLetterPath := filepath.Join(dir, "dead-letter.log")
+
+	secret := []byte("secret")
+	var (
+		mu       sync.Mutex
+		got      []*Event
+		attempts = map[string]int{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(b)
+		assert.Equals(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get(provisioner.WebhookSignatureHeader))
+		e := new(Event)
+		assert.FatalError(t, json.Unmarshal(b, e))
+		assert.Equals(t, e.ID, r.Header.Get(EventWebhookIDHeader))
+
+		mu.Lock()
+		defer mu.Unlock()
+		// The first attempt of each event fails.
+		attempts[e.ID]++
+		if attempts[e.ID] == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		got = append(got, e)
+	}))
+	defer srv.Close()
+	failSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failSrv.Close()
+
+	d, err := newEventDispatcher(&EventsConfig{
+		Webhooks: []*EventWebhook{
+			{URL: srv.URL, Secret: "c2VjcmV0", Events: []string{EventCertificateIssued}},
+			{URL: failSrv.URL},
+		},
+		MaxAttempts:  2,
+		RetryBackoff: &provisioner.Duration{Duration: time.Millisecond},
+		DeadLetter:   deadLetterPath,
+	})
+	assert.FatalError(t, err)
+	issued := newEvent(EventCertificateIssued)
+	issued.Serial = "1234"
+	d.publish(issued)
+	d.publish(newEvent(EventProvisionerAdded))
+
+	// Wait for the retry, close does not wait between attempts.
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.FatalError(t, d.close())
+
+	// Events after close are ignored.
+	d.publish(newEvent(EventCertificateIssued))
+
+	assert.Len(t, 1, got)
+	assert.Equals(t, issued.ID, got[0].ID)
+	assert.Equals(t, "1234", got[0].Serial)
+	assert.Equals(t, 2, attempts[issued.ID])
+
+	// Both events fail in the second webhook.
+	f, err := os.Open(deadLetterPath)
+	assert.FatalError(t, err)
+	defer f.Close()
+	var letters []*deadLetter
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		dl := new(deadLetter)
+		assert.FatalError(t, json.Unmarshal(sc.Bytes(), dl))
+		letters = append(letters, dl)
+	}
+	assert.Len(t, 2, letters)
+	for _, dl := range letters {
+		assert.Equals(t, failSrv.URL, dl.URL)
+		assert.HasPrefix(t, dl.Error, "error posting event")
+	}
+	e := new(Event)
+	assert.FatalError(t, json.Unmarshal(letters[0].Event, e))
+	assert.Equals(t, issued.ID, e.ID)
+}
+
+func TestAuthority_events(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []*Event
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := new(Event)
+		assert.FatalError(t, json.NewDecoder(r.Body).Decode(e))
+		mu.Lock()
+		got = append(got, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a := testAuthority(t)
+	a.config.Events = &EventsConfig{Webhooks: []*EventWebhook{{URL: srv.URL}}}
+	assert.FatalError(t, a.initEvents())
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return false, nil
+		},
+		MRevoke: func(rci *db.RevokedCertificateInfo) error {
+			return nil
+		},
+	}
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	signOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, signOpts...)
+	assert.FatalError(t, err)
+	renewed, err := a.Renew(certChain[0])
+	assert.FatalError(t, err)
+	assert.FatalError(t, a.Revoke(provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod), &RevokeOptions{
+		Serial:     renewed[0].SerialNumber.String(),
+		ReasonCode: 1,
+		Reason:     "key compromise",
+		MTLS:       true,
+		Crt:        renewed[0],
+	}))
+	p, err := a.LoadProvisionerByCertificate(renewed[0])
+	assert.FatalError(t, err)
+	a.NotifyACMEOrderFailed(p, "account-id", "order-id", []acme.Identifier{{Type: "dns", Value: "test.smallstep.com"}}, "order has expired")
+	assert.FatalError(t, a.StopEvents())
+
+	assert.Len(t, 4, got)
+
+	assert.Equals(t, EventCertificateIssued, got[0].Type)
+	assert.Equals(t, "x509", got[0].CertificateType)
+	assert.Equals(t, "step-cli", got[0].Provisioner)
+	assert.Equals(t, certChain[0].SerialNumber.String(), got[0].Serial)
+	assert.Equals(t, "smallstep test", got[0].Subject)
+	assert.Equals(t, []string{"test.smallstep.com"}, got[0].SANs)
+	assert.True(t, got[0].NotAfter.Equal(certChain[0].NotAfter))
+
+	assert.Equals(t, EventCertificateRenewed, got[1].Type)
+	assert.Equals(t, "step-cli", got[1].Provisioner)
+	assert.Equals(t, renewed[0].SerialNumber.String(), got[1].Serial)
+	assert.Equals(t, certChain[0].SerialNumber.String(), got[1].PreviousSerial)
+
+	assert.Equals(t, EventCertificateRevoked, got[2].Type)
+	assert.Equals(t, "x509", got[2].CertificateType)
+	assert.Equals(t, "step-cli", got[2].Provisioner)
+	assert.Equals(t, renewed[0].SerialNumber.String(), got[2].Serial)
+	assert.Equals(t, 1, got[2].ReasonCode)
+	assert.Equals(t, "key compromise", got[2].Reason)
+
+	assert.Equals(t, EventACMEOrderFailed, got[3].Type)
+	assert.Equals(t, "step-cli", got[3].Provisioner)
+	assert.Equals(t, "account-id", got[3].Account)
+	assert.Equals(t, "order-id", got[3].Order)
+	assert.Equals(t, []string{"test.smallstep.com"}, got[3].Identifiers)
+	assert.Equals(t, "order has expired", got[3].Reason)
+}
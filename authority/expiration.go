@@ -0,0 +1,367 @@
+package authority
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+var (
+	defaultExpirationWindow      = 7 * 24 * time.Hour
+	defaultExpirationCheckPeriod = 24 * time.Hour
+	expirationNotifierTimeout    = 30 * time.Second
+	// maxExpirationLines is the maximum number of certificates listed in the
+	// text of the email and Slack notifications.
+	maxExpirationLines = 100
+	// smtpSendMail sends the email notifications, it's replaced in tests.
+	smtpSendMail = smtp.SendMail
+)
+
+// Types of ExpirationNotifier.
+const (
+	ExpirationNotifierWebhook = "webhook"
+	ExpirationNotifierSlack   = "slack"
+	ExpirationNotifierEmail   = "email"
+)
+
+// ExpirationConfig configures the report of the X.509 certificates that expire
+// within Window, 7 days by default, and have not been renewed. Every
+// CheckPeriod, 24h by default, the report is sent to the Notifiers if it's not
+// empty.
+type ExpirationConfig struct {
+	Window      *provisioner.Duration `json:"window,omitempty"`
+	CheckPeriod *provisioner.Duration `json:"checkPeriod,omitempty"`
+	Notifiers   []*ExpirationNotifier `json:"notifiers,omitempty"`
+}
+
+// Validate checks the fields in ExpirationConfig, nil is ok.
+func (c *ExpirationConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Window != nil && c.Window.Duration <= 0:
+		return errors.New("expiration.window must be greater than 0")
+	case c.CheckPeriod != nil && c.CheckPeriod.Duration <= 0:
+		return errors.New("expiration.checkPeriod must be greater than 0")
+	}
+	for _, n := range c.Notifiers {
+		if err := n.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ExpirationConfig) window() time.Duration {
+	if c == nil || c.Window == nil {
+		return defaultExpirationWindow
+	}
+	return c.Window.Duration
+}
+
+func (c *ExpirationConfig) checkPeriod() time.Duration {
+	if c.CheckPeriod == nil {
+		return defaultExpirationCheckPeriod
+	}
+	return c.CheckPeriod.Duration
+}
+
+// ExpirationNotifier is a destination of the expiration report.
+//
+// A webhook receives a POST request with the report in JSON, signed with
+// HMAC-SHA256 in the X-Smallstep-Signature header if the base64 encoded Secret
+// is set. A slack notifier posts a text message to a Slack-compatible incoming
+// webhook URL. An email notifier sends the text message From the given
+// address To the recipients using the SMTP server in host:port, with the
+// Username and Password if they are set.
+type ExpirationNotifier struct {
+	Type     string   `json:"type"`
+	URL      string   `json:"url,omitempty"`
+	Secret   string   `json:"secret,omitempty"`
+	SMTP     string   `json:"smtp,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// Validate checks the fields required by the type of notifier.
+func (n *ExpirationNotifier) Validate() error {
+	if n == nil {
+		return errors.New("expiration.notifiers cannot contain an empty value")
+	}
+	switch n.Type {
+	case ExpirationNotifierWebhook, ExpirationNotifierSlack:
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.Errorf("expiration.notifiers contains an invalid url %s", n.URL)
+		}
+		if _, err := base64.StdEncoding.DecodeString(n.Secret); err != nil {
+			return errors.Wrap(err, "expiration.notifiers secret must be base64 encoded")
+		}
+	case ExpirationNotifierEmail:
+		if _, _, err := net.SplitHostPort(n.SMTP); err != nil {
+			return errors.Errorf("expiration.notifiers smtp %s must be host:port", n.SMTP)
+		}
+		switch {
+		case n.From == "":
+			return errors.New("expiration.notifiers from cannot be empty")
+		case len(n.To) == 0:
+			return errors.New("expiration.notifiers to cannot be empty")
+		}
+	default:
+		return errors.Errorf("expiration.notifiers type %q is not valid: it must be webhook, slack or email", n.Type)
+	}
+	return nil
+}
+
+// ExpirationReport lists the certificates that expire before ExpiresBefore and
+// have not been renewed, sorted by expiration.
+type ExpirationReport struct {
+	GeneratedAt   time.Time          `json:"generatedAt"`
+	ExpiresBefore time.Time          `json:"expiresBefore"`
+	Certificates  []*CertificateInfo `json:"certificates"`
+}
+
+// text returns the report as a plain text message.
+func (r *ExpirationReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d certificates expire before %s and have not been renewed:\n", len(r.Certificates), r.ExpiresBefore.Format(time.RFC3339))
+	for i, c := range r.Certificates {
+		if i == maxExpirationLines {
+			fmt.Fprintf(&b, "... and %d more\n", len(r.Certificates)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s expires %s, serial %s", strings.Join(c.names(), ", "), c.NotAfter.Format(time.RFC3339), c.Serial)
+		if c.Provisioner != "" {
+			fmt.Fprintf(&b, ", provisioner %s", c.Provisioner)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// renewalKey returns the key used to find renewals of the certificate, two
+// certificates with the same names are considered renewals.
+func (c *CertificateInfo) renewalKey() string {
+	names := c.names()
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// GetExpirationReport returns the certificates in the database that expire in
+// the given window and have not been renewed, a certificate is renewed if a
+// certificate with the same names that is not revoked expires later. If the
+// window is 0 the configured one is used.
+func (a *Authority) GetExpirationReport(window time.Duration) (*ExpirationReport, error) {
+	if window == 0 {
+		window = a.config.Expiration.window()
+	}
+	if window < 0 {
+		return nil, errs.BadRequest("window must be greater than 0")
+	}
+
+	list, err := a.db.GetCertificateIndexes()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetExpirationReport: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetExpirationReport")
+	}
+	infos, err := unmarshalCertificateInfos(list)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := a.db.GetRevokedCertificates()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetExpirationReport")
+	}
+	revokedSerials := make(map[string]bool, len(revoked))
+	for _, rci := range revoked {
+		revokedSerials[rci.Serial] = true
+	}
+
+	// Find the last expiration of the certificates with the same names.
+	lastExpiration := make(map[string]time.Time)
+	for _, info := range infos {
+		if revokedSerials[info.Serial] {
+			continue
+		}
+		key := info.renewalKey()
+		if info.NotAfter.After(lastExpiration[key]) {
+			lastExpiration[key] = info.NotAfter
+		}
+	}
+
+	now := time.Now().UTC()
+	report := &ExpirationReport{
+		GeneratedAt:   now,
+		ExpiresBefore: now.Add(window),
+		Certificates:  []*CertificateInfo{},
+	}
+	for _, info := range infos {
+		switch {
+		case revokedSerials[info.Serial]:
+		case info.NotAfter.Before(now) || !info.NotAfter.Before(report.ExpiresBefore):
+		case info.renewalKey() != "" && lastExpiration[info.renewalKey()].After(info.NotAfter):
+		default:
+			report.Certificates = append(report.Certificates, info)
+		}
+	}
+	sort.Slice(report.Certificates, func(i, j int) bool {
+		ci, cj := report.Certificates[i], report.Certificates[j]
+		if ci.NotAfter.Equal(cj.NotAfter) {
+			return ci.less(cj.NotBefore, cj.Serial)
+		}
+		return ci.NotAfter.Before(cj.NotAfter)
+	})
+	return report, nil
+}
+
+// initExpirationNotifier starts sending the expiration report periodically if
+// there are notifiers configured.
+func (a *Authority) initExpirationNotifier() {
+	c := a.config.Expiration
+	if c == nil || len(c.Notifiers) == 0 {
+		return
+	}
+	a.expirationStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(c.checkPeriod())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// In a cluster only one instance sends the report, if the
+				// period cannot be claimed it's sent anyway.
+				if ok, err := a.ClaimJob("expiration", c.checkPeriod()); err != nil {
+					log.Printf("error claiming expiration report: %v", err)
+				} else if !ok {
+					continue
+				}
+				if err := a.NotifyExpirations(); err != nil {
+					log.Printf("error sending expiration report: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(a.expirationStop)
+}
+
+// StopExpirationNotifier stops the periodic notifications of the expiration
+// report.
+func (a *Authority) StopExpirationNotifier() {
+	if a.expirationStop != nil {
+		close(a.expirationStop)
+		a.expirationStop = nil
+	}
+}
+
+// NotifyExpirations sends the expiration report to all the configured
+// notifiers if the report is not empty. It tries all the notifiers and returns
+// the first error.
+func (a *Authority) NotifyExpirations() error {
+	report, err := a.GetExpirationReport(0)
+	if err != nil {
+		return err
+	}
+	if len(report.Certificates) == 0 || a.config.Expiration == nil {
+		return nil
+	}
+	var firstErr error
+	for _, n := range a.config.Expiration.Notifiers {
+		if err := n.notify(report); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (n *ExpirationNotifier) notify(r *ExpirationReport) error {
+	switch n.Type {
+	case ExpirationNotifierWebhook:
+		b, err := json.Marshal(r)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling expiration report")
+		}
+		return n.post(b)
+	case ExpirationNotifierSlack:
+		b, err := json.Marshal(map[string]string{"text": r.text()})
+		if err != nil {
+			return errors.Wrap(err, "error marshaling expiration report")
+		}
+		return n.post(b)
+	case ExpirationNotifierEmail:
+		return n.sendMail(r)
+	default:
+		return errors.Errorf("unsupported notifier type %s", n.Type)
+	}
+}
+
+func (n *ExpirationNotifier) post(body []byte) error {
+	req, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating request to %s", n.URL)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		secret, err := base64.StdEncoding.DecodeString(n.Secret)
+		if err != nil {
+			return errors.Wrap(err, "error decoding notifier secret")
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		req.Header.Set(provisioner.WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := &http.Client{Timeout: expirationNotifierTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error posting expiration report to %s", n.URL)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("error posting expiration report to %s: status code %d", n.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *ExpirationNotifier) sendMail(r *ExpirationReport) error {
+	host, _, err := net.SplitHostPort(n.SMTP)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing smtp %s", n.SMTP)
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %d certificates expire before %s\r\n", len(r.Certificates), r.ExpiresBefore.Format(time.RFC3339))
+	fmt.Fprintf(&msg, "Date: %s\r\n", r.GeneratedAt.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(r.text(), "\n", "\r\n", -1))
+	if err := smtpSendMail(n.SMTP, auth, n.From, n.To, msg.Bytes()); err != nil {
+		return errors.Wrapf(err, "error sending expiration report to %s", n.SMTP)
+	}
+	return nil
+}
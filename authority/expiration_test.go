@@ -0,0 +1,258 @@
+package authority
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestExpirationConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *ExpirationConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &ExpirationConfig{}, false},
+		{"ok", &ExpirationConfig{
+			Window:      &provisioner.Duration{Duration: 72 * time.Hour},
+			CheckPeriod: &provisioner.Duration{Duration: time.Hour},
+			Notifiers: []*ExpirationNotifier{
+				{Type: "webhook", URL: "https://example.com/hook", Secret: "c2VjcmV0"},
+				{Type: "slack", URL: "https://hooks.slack.com/services/T/B/X"},
+				{Type: "email", SMTP: "smtp.example.com:587", From: "ca@example.com", To: []string{"ops@example.com"}},
+			},
+		}, false},
+		{"fail/window", &ExpirationConfig{Window: &provisioner.Duration{}}, true},
+		{"fail/checkPeriod", &ExpirationConfig{CheckPeriod: &provisioner.Duration{Duration: -time.Hour}}, true},
+		{"fail/nil-notifier", &ExpirationConfig{Notifiers: []*ExpirationNotifier{nil}}, true},
+		{"fail/type", &ExpirationConfig{Notifiers: []*ExpirationNotifier{{Type: "sms"}}}, true},
+		{"fail/url", &ExpirationConfig{Notifiers: []*ExpirationNotifier{{Type: "webhook", URL: "ftp://example.com"}}}, true},
+		{"fail/secret", &ExpirationConfig{Notifiers: []*ExpirationNotifier{{Type: "webhook", URL: "https://example.com", Secret: "%%%"}}}, true},
+		{"fail/smtp", &ExpirationConfig{Notifiers: []*ExpirationNotifier{{Type: "email", SMTP: "smtp.example.com", From: "ca@example.com", To: []string{"ops@example.com"}}}}, true},
+		{"fail/from", &ExpirationConfig{Notifiers: []*ExpirationNotifier{{Type: "email", SMTP: "smtp.example.com:25", To: []string{"ops@example.com"}}}}, true},
+		{"fail/to", &ExpirationConfig{Notifiers: []*ExpirationNotifier{{Type: "email", SMTP: "smtp.example.com:25", From: "ca@example.com"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ExpirationConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func expiringCertificates(now time.Time) []*CertificateInfo {
+	return []*CertificateInfo{
+		// Expires soon and not renewed.
+		{Serial: "1", SANs: []string{"a.example.com"}, NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(48 * time.Hour)},
+		// Renewed by 3.
+		{Serial: "2", SANs: []string{"b.example.com", "c.example.com"}, NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(24 * time.Hour)},
+		{Serial: "3", SANs: []string{"C.example.com", "b.example.com"}, NotBefore: now, NotAfter: now.Add(30 * 24 * time.Hour)},
+		// Revoked.
+		{Serial: "4", SANs: []string{"d.example.com"}, NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(24 * time.Hour)},
+		// Renewed by 6, but 6 is revoked.
+		{Serial: "5", CommonName: "e", NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(12 * time.Hour)},
+		{Serial: "6", CommonName: "e", NotBefore: now, NotAfter: now.Add(30 * 24 * time.Hour)},
+		// Already expired.
+		{Serial: "7", SANs: []string{"f.example.com"}, NotBefore: now.Add(-48 * time.Hour), NotAfter: now.Add(-time.Hour)},
+		// Expires after the window.
+		{Serial: "8", SANs: []string{"g.example.com"}, NotBefore: now, NotAfter: now.Add(10 * 24 * time.Hour)},
+	}
+}
+
+func mockExpirationDB(t *testing.T, now time.Time) *db.MockAuthDB {
+	m := mockCertificateIndex(t, expiringCertificates(now))
+	m.MGetRevokedCerts = func() ([]*db.RevokedCertificateInfo, error) {
+		return []*db.RevokedCertificateInfo{{Serial: "4"}, {Serial: "6"}}, nil
+	}
+	return m
+}
+
+func TestAuthority_GetExpirationReport(t *testing.T) {
+	now := time.Now()
+	serials := func(r *ExpirationReport) []string {
+		var s []string
+		for _, c := range r.Certificates {
+			s = append(s, c.Serial)
+		}
+		return s
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = mockExpirationDB(t, now)
+		report, err := a.GetExpirationReport(0)
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"5", "1"}, serials(report))
+		assert.True(t, report.ExpiresBefore.Sub(report.GeneratedAt) == defaultExpirationWindow)
+	})
+
+	t.Run("ok/window", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = mockExpirationDB(t, now)
+		report, err := a.GetExpirationReport(24 * time.Hour)
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"5"}, serials(report))
+
+		a.config.Expiration = &ExpirationConfig{Window: &provisioner.Duration{Duration: 15 * 24 * time.Hour}}
+		report, err = a.GetExpirationReport(0)
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"5", "1", "8"}, serials(report))
+	})
+
+	t.Run("ok/empty", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{}
+		report, err := a.GetExpirationReport(0)
+		assert.FatalError(t, err)
+		assert.Equals(t, []*CertificateInfo{}, report.Certificates)
+	})
+
+	t.Run("fail/window", func(t *testing.T) {
+		a := testAuthority(t)
+		_, err := a.GetExpirationReport(-time.Hour)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusBadRequest, sc.StatusCode())
+	})
+
+	t.Run("fail/not-implemented", func(t *testing.T) {
+		a := testAuthority(t)
+		_, err := a.GetExpirationReport(0)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusNotImplemented, sc.StatusCode())
+	})
+
+	t.Run("fail/revoked", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{
+			MGetCertIndexes: func() ([][]byte, error) {
+				return nil, nil
+			},
+			MGetRevokedCerts: func() ([]*db.RevokedCertificateInfo, error) {
+				return nil, errors.New("force")
+			},
+		}
+		_, err := a.GetExpirationReport(0)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusInternalServerError, sc.StatusCode())
+	})
+}
+
+func TestAuthority_NotifyExpirations(t *testing.T) {
+	now := time.Now()
+	secret := []byte("secret")
+
+	var webhook ExpirationReport
+	var signature string
+	webhookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(b)
+		signature = hex.EncodeToString(mac.Sum(nil))
+		assert.Equals(t, signature, r.Header.Get(provisioner.WebhookSignatureHeader))
+		assert.FatalError(t, json.Unmarshal(b, &webhook))
+	}))
+	defer webhookSrv.Close()
+
+	var slack map[string]string
+	slackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equals(t, "", r.Header.Get(provisioner.WebhookSignatureHeader))
+		assert.FatalError(t, json.NewDecoder(r.Body).Decode(&slack))
+	}))
+	defer slackSrv.Close()
+
+	failSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failSrv.Close()
+
+	var mail string
+	var mailTo []string
+	defer func(fn func(string, smtp.Auth, string, []string, []byte) error) {
+		smtpSendMail = fn
+	}(smtpSendMail)
+	smtpSendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equals(t, "smtp.example.com:587", addr)
+		assert.NotNil(t, auth)
+		assert.Equals(t, "ca@example.com", from)
+		mailTo, mail = to, string(msg)
+		return nil
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = mockExpirationDB(t, now)
+		a.config.Expiration = &ExpirationConfig{
+			Notifiers: []*ExpirationNotifier{
+				{Type: "webhook", URL: webhookSrv.URL, Secret: base64.StdEncoding.EncodeToString(secret)},
+				{Type: "slack", URL: slackSrv.URL},
+				{Type: "email", SMTP: "smtp.example.com:587", Username: "ca", Password: "pass", From: "ca@example.com", To: []string{"ops@example.com"}},
+			},
+		}
+		assert.FatalError(t, a.NotifyExpirations())
+		assert.Len(t, 2, webhook.Certificates)
+		assert.Equals(t, "5", webhook.Certificates[0].Serial)
+		assert.True(t, strings.HasPrefix(slack["text"], "2 certificates expire before"))
+		assert.True(t, strings.Contains(slack["text"], "- a.example.com expires"))
+		assert.Equals(t, []string{"ops@example.com"}, mailTo)
+		assert.True(t, strings.Contains(mail, "Subject: 2 certificates expire before"))
+		assert.True(t, strings.Contains(mail, "- e expires"))
+	})
+
+	t.Run("ok/empty", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{}
+		a.config.Expiration = &ExpirationConfig{
+			Notifiers: []*ExpirationNotifier{{Type: "webhook", URL: failSrv.URL}},
+		}
+		assert.FatalError(t, a.NotifyExpirations())
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		slack = nil
+		a := testAuthority(t)
+		a.db = mockExpirationDB(t, now)
+		a.config.Expiration = &ExpirationConfig{
+			Notifiers: []*ExpirationNotifier{
+				{Type: "webhook", URL: failSrv.URL},
+				{Type: "slack", URL: slackSrv.URL},
+			},
+		}
+		assert.Error(t, a.NotifyExpirations())
+		// The notifiers after the failed one are also called.
+		assert.NotNil(t, slack)
+	})
+}
+
+func TestExpirationReport_text(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &ExpirationReport{ExpiresBefore: now}
+	for i := 0; i < maxExpirationLines+5; i++ {
+		r.Certificates = append(r.Certificates, &CertificateInfo{Serial: "1", SANs: []string{"foo.example.com"}, Provisioner: "acme", NotAfter: now})
+	}
+	lines := strings.Split(strings.TrimSpace(r.text()), "\n")
+	assert.Len(t, maxExpirationLines+2, lines)
+	assert.Equals(t, "105 certificates expire before 2020-01-01T00:00:00Z and have not been renewed:", lines[0])
+	assert.Equals(t, "- foo.example.com expires 2020-01-01T00:00:00Z, serial 1, provisioner acme", lines[1])
+	assert.Equals(t, "... and 5 more", lines[len(lines)-1])
+}
@@ -0,0 +1,277 @@
+package authority
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+var (
+	defaultFederationRefreshPeriod = time.Hour
+	federationTimeout              = 30 * time.Second
+)
+
+// FederationConfig configures the remote authorities whose roots are added to
+// the federation. Every RefreshPeriod, 1h by default, the roots are fetched
+// from the /roots endpoint of each authority and served in /federation with
+// the federatedRoots in the configuration. If an authority cannot be reached
+// the roots fetched before are kept.
+type FederationConfig struct {
+	Authorities   []*FederatedAuthority `json:"authorities"`
+	RefreshPeriod *provisioner.Duration `json:"refreshPeriod,omitempty"`
+}
+
+// FederatedAuthority is a remote authority in the federation. URL is the base
+// URL of the CA, and Fingerprints are the SHA-256 fingerprints of the roots
+// pinned to authenticate it, like the fingerprint used to bootstrap a client.
+// The roots in /roots are trusted if the connection is verified by a pinned
+// root or by a root fetched before, so the remote authority can rotate them.
+type FederatedAuthority struct {
+	URL          string   `json:"url"`
+	Fingerprints []string `json:"fingerprints"`
+}
+
+// Validate checks the fields in FederationConfig, nil is ok.
+func (c *FederationConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case len(c.Authorities) == 0:
+		return errors.New("federation.authorities cannot be empty")
+	case c.RefreshPeriod != nil && c.RefreshPeriod.Duration <= 0:
+		return errors.New("federation.refreshPeriod must be greater than 0")
+	}
+	urls := make(map[string]bool)
+	for _, fa := range c.Authorities {
+		if fa == nil {
+			return errors.New("federation.authorities cannot contain empty values")
+		}
+		u, err := url.Parse(fa.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.Errorf("federation.authorities url %s is not a valid https url", fa.URL)
+		}
+		if urls[fa.URL] {
+			return errors.Errorf("federation.authorities url %s is duplicated", fa.URL)
+		}
+		urls[fa.URL] = true
+		if len(fa.Fingerprints) == 0 {
+			return errors.Errorf("federation.authorities %s fingerprints cannot be empty", fa.URL)
+		}
+		for _, fp := range fa.Fingerprints {
+			if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+				return errors.Errorf("federation.authorities %s fingerprint %s is not a valid SHA-256 fingerprint", fa.URL, fp)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *FederationConfig) refreshPeriod() time.Duration {
+	if c.RefreshPeriod == nil {
+		return defaultFederationRefreshPeriod
+	}
+	return c.RefreshPeriod.Duration
+}
+
+// federatedAuthority is the state of a remote authority in the federation,
+// pinned are the roots with the configured fingerprints and roots are the last
+// ones fetched from the authority.
+type federatedAuthority struct {
+	config *FederatedAuthority
+	pinned []*x509.Certificate
+	roots  []*x509.Certificate
+}
+
+// initFederation starts the periodic fetch of the roots of the remote
+// authorities in the federation.
+func (a *Authority) initFederation() {
+	c := a.config.Federation
+	if c == nil {
+		return
+	}
+	a.federatedAuthorities = make([]*federatedAuthority, len(c.Authorities))
+	for i, fa := range c.Authorities {
+		a.federatedAuthorities[i] = &federatedAuthority{config: fa}
+	}
+
+	// The roots are first fetched in the background, errors are retried on
+	// the next refresh.
+	a.federationStop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(c.refreshPeriod())
+		defer ticker.Stop()
+		for {
+			if err := a.RefreshFederation(); err != nil {
+				log.Printf("error refreshing federation: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(a.federationStop)
+}
+
+// StopFederation stops the periodic fetch of the roots of the remote
+// authorities.
+func (a *Authority) StopFederation() {
+	a.federationMutex.Lock()
+	defer a.federationMutex.Unlock()
+	if a.federationStop != nil {
+		close(a.federationStop)
+		a.federationStop = nil
+	}
+}
+
+// RefreshFederation fetches the roots of all the remote authorities in the
+// federation. It tries all of them and returns the first error, the roots of
+// the authorities that fail are not modified.
+func (a *Authority) RefreshFederation() error {
+	var firstErr error
+	for _, fa := range a.federatedAuthorities {
+		a.federationMutex.RLock()
+		pinned, roots := fa.pinned, fa.roots
+		a.federationMutex.RUnlock()
+
+		pinned, roots, err := fetchFederatedRoots(fa.config, pinned, roots)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "error fetching roots from %s", fa.config.URL)
+			}
+			continue
+		}
+
+		a.federationMutex.Lock()
+		fa.pinned, fa.roots = pinned, roots
+		a.federationMutex.Unlock()
+	}
+	return firstErr
+}
+
+// getFederatedAuthorityRoots returns the roots fetched from the remote
+// authorities in the federation.
+func (a *Authority) getFederatedAuthorityRoots() []*x509.Certificate {
+	a.federationMutex.RLock()
+	defer a.federationMutex.RUnlock()
+	var roots []*x509.Certificate
+	for _, fa := range a.federatedAuthorities {
+		roots = append(roots, fa.roots...)
+	}
+	return roots
+}
+
+// fetchFederatedRoots returns the pinned roots and the roots of a remote
+// authority. The pinned roots are fetched once from /root/{sha}, without
+// verifying the connection, and they are verified with their fingerprints.
+// Then /roots is fetched verifying the connection with the pinned roots and
+// the roots fetched before.
+func fetchFederatedRoots(fa *FederatedAuthority, pinned, roots []*x509.Certificate) ([]*x509.Certificate, []*x509.Certificate, error) {
+	base := strings.TrimSuffix(fa.URL, "/")
+	if len(pinned) == 0 {
+		client := newFederationClient(&tls.Config{
+			InsecureSkipVerify: true, // the root is verified with its fingerprint
+			MinVersion:         tls.VersionTLS12,
+		})
+		for _, fp := range fa.Fingerprints {
+			var res struct {
+				RootPEM string `json:"ca"`
+			}
+			if err := getFederationJSON(client, base+"/root/"+fp, &res); err != nil {
+				return nil, nil, err
+			}
+			crt, err := parseFederatedRoot(res.RootPEM)
+			if err != nil {
+				return nil, nil, err
+			}
+			sum := sha256.Sum256(crt.Raw)
+			if !strings.EqualFold(hex.EncodeToString(sum[:]), fp) {
+				return nil, nil, errors.Errorf("root %s does not match its fingerprint", fp)
+			}
+			pinned = append(pinned, crt)
+		}
+	}
+
+	pool := x509.NewCertPool()
+	for _, crt := range pinned {
+		pool.AddCert(crt)
+	}
+	for _, crt := range roots {
+		pool.AddCert(crt)
+	}
+	client := newFederationClient(&tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	})
+	var res struct {
+		Certificates []string `json:"crts"`
+	}
+	if err := getFederationJSON(client, base+"/roots", &res); err != nil {
+		return nil, nil, err
+	}
+	if len(res.Certificates) == 0 {
+		return nil, nil, errors.New("authority does not have roots")
+	}
+	fetched := make([]*x509.Certificate, len(res.Certificates))
+	for i, s := range res.Certificates {
+		crt, err := parseFederatedRoot(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		fetched[i] = crt
+	}
+	return pinned, fetched, nil
+}
+
+func newFederationClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: federationTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+func getFederationJSON(client *http.Client, u string, v interface{}) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return errors.Wrapf(err, "error requesting %s", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.Errorf("error requesting %s: status code %d", u, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "error decoding %s", u)
+	}
+	return nil
+}
+
+// parseFederatedRoot parses a PEM encoded root certificate.
+func parseFederatedRoot(s string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("error decoding root certificate")
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing root certificate")
+	}
+	if !crt.IsCA {
+		return nil, errors.New("error parsing root certificate: certificate is not a CA")
+	}
+	return crt, nil
+}
@@ -0,0 +1,153 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/keys"
+)
+
+func TestFederationConfig_Validate(t *testing.T) {
+	fp := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		config  *FederationConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", &FederationConfig{Authorities: []*FederatedAuthority{
+			{URL: "https://ca.example.com", Fingerprints: []string{fp}},
+			{URL: "https://ca.example.org:9000/", Fingerprints: []string{strings.ToUpper(fp)}},
+		}, RefreshPeriod: &provisioner.Duration{Duration: time.Minute}}, false},
+		{"fail/empty", &FederationConfig{}, true},
+		{"fail/nil", &FederationConfig{Authorities: []*FederatedAuthority{nil}}, true},
+		{"fail/url", &FederationConfig{Authorities: []*FederatedAuthority{{URL: "http://ca.example.com", Fingerprints: []string{fp}}}}, true},
+		{"fail/duplicated", &FederationConfig{Authorities: []*FederatedAuthority{
+			{URL: "https://ca.example.com", Fingerprints: []string{fp}},
+			{URL: "https://ca.example.com", Fingerprints: []string{fp}},
+		}}, true},
+		{"fail/no-fingerprints", &FederationConfig{Authorities: []*FederatedAuthority{{URL: "https://ca.example.com"}}}, true},
+		{"fail/fingerprint", &FederationConfig{Authorities: []*FederatedAuthority{{URL: "https://ca.example.com", Fingerprints: []string{"abcd"}}}}, true},
+		{"fail/refreshPeriod", &FederationConfig{Authorities: []*FederatedAuthority{{URL: "https://ca.example.com", Fingerprints: []string{fp}}}, RefreshPeriod: &provisioner.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("FederationConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthority_RefreshFederation(t *testing.T) {
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Remote Root CA"},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	newRoot, err := signCertificate(tmpl, tmpl, pub, priv.(crypto.Signer))
+	assert.FatalError(t, err)
+	encode := func(crt *x509.Certificate) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}))
+	}
+
+	// The remote authority serves its TLS root, and a new root during a
+	// rotation.
+	var (
+		mu       sync.Mutex
+		failing  bool
+		rootReqs int
+	)
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case failing:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasPrefix(r.URL.Path, "/root/"):
+			rootReqs++
+			assert.FatalError(t, json.NewEncoder(w).Encode(map[string]string{"ca": encode(srv.Certificate())}))
+		case r.URL.Path == "/roots":
+			assert.FatalError(t, json.NewEncoder(w).Encode(map[string][]string{
+				"crts": {encode(srv.Certificate()), encode(newRoot)},
+			}))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	remoteRoot := srv.Certificate()
+
+	a := testAuthority(t)
+	local, err := a.GetFederation()
+	assert.FatalError(t, err)
+	a.config.Federation = &FederationConfig{
+		Authorities: []*FederatedAuthority{
+			{URL: srv.URL, Fingerprints: []string{fingerprint(remoteRoot)}},
+		},
+		RefreshPeriod: &provisioner.Duration{Duration: time.Hour},
+	}
+	a.initFederation()
+	defer a.StopFederation()
+
+	assert.FatalError(t, a.RefreshFederation())
+	federation, err := a.GetFederation()
+	assert.FatalError(t, err)
+	assert.Len(t, len(local)+2, federation)
+	assert.Equals(t, remoteRoot.Raw, federation[len(local)].Raw)
+	assert.Equals(t, newRoot.Raw, federation[len(local)+1].Raw)
+
+	// The pinned root is only fetched once.
+	assert.FatalError(t, a.RefreshFederation())
+	mu.Lock()
+	assert.True(t, rootReqs <= 2, "pinned root fetched %d times", rootReqs)
+	failing = true
+	mu.Unlock()
+
+	// The roots are kept if the authority fails.
+	assert.NotNil(t, a.RefreshFederation())
+	federation, err = a.GetFederation()
+	assert.FatalError(t, err)
+	assert.Len(t, len(local)+2, federation)
+
+	// A root that does not match the pinned fingerprint is not trusted.
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	b := testAuthority(t)
+	b.config.Federation = &FederationConfig{
+		Authorities: []*FederatedAuthority{
+			{URL: srv.URL, Fingerprints: []string{fingerprint(newRoot)}},
+		},
+		RefreshPeriod: &provisioner.Duration{Duration: time.Hour},
+	}
+	b.initFederation()
+	defer b.StopFederation()
+	err = b.RefreshFederation()
+	if assert.NotNil(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "does not match its fingerprint"), err.Error())
+	}
+	federation, err = b.GetFederation()
+	assert.FatalError(t, err)
+	assert.Len(t, len(local), federation)
+}
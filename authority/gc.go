@@ -0,0 +1,110 @@
+package authority
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+var (
+	defaultGCPeriod         = time.Hour
+	defaultGCRetention      = 7 * 24 * time.Hour
+	defaultGCNonceRetention = 24 * time.Hour
+)
+
+// GCConfig configures the garbage collection of the database, run every
+// Period, 1h by default. Expired ACME orders and authorizations, and their
+// challenges, are deleted Retention after they expire, 168h by default, and
+// ACME nonces NonceRetention after they are created, 24h by default. If
+// CertificateRetention is set, the X.509 certificates and the ACME
+// certificates are also deleted that long after they expire; the revocations
+// are always kept.
+type GCConfig struct {
+	Period               *provisioner.Duration `json:"period,omitempty"`
+	Retention            *provisioner.Duration `json:"retention,omitempty"`
+	NonceRetention       *provisioner.Duration `json:"nonceRetention,omitempty"`
+	CertificateRetention *provisioner.Duration `json:"certificateRetention,omitempty"`
+}
+
+// Validate checks the fields in GCConfig, nil is ok.
+func (c *GCConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Period != nil && c.Period.Duration <= 0:
+		return errors.New("gc.period must be greater than 0")
+	case c.Retention != nil && c.Retention.Duration < 0:
+		return errors.New("gc.retention cannot be negative")
+	case c.NonceRetention != nil && c.NonceRetention.Duration < 0:
+		return errors.New("gc.nonceRetention cannot be negative")
+	case c.CertificateRetention != nil && c.CertificateRetention.Duration <= 0:
+		return errors.New("gc.certificateRetention must be greater than 0")
+	}
+	return nil
+}
+
+// GetPeriod returns how often the garbage collection runs.
+func (c *GCConfig) GetPeriod() time.Duration {
+	if c.Period == nil {
+		return defaultGCPeriod
+	}
+	return c.Period.Duration
+}
+
+// GetRetention returns how long the expired ACME objects are kept.
+func (c *GCConfig) GetRetention() time.Duration {
+	if c.Retention == nil {
+		return defaultGCRetention
+	}
+	return c.Retention.Duration
+}
+
+// GetNonceRetention returns how long the ACME nonces are kept.
+func (c *GCConfig) GetNonceRetention() time.Duration {
+	if c.NonceRetention == nil {
+		return defaultGCNonceRetention
+	}
+	return c.NonceRetention.Duration
+}
+
+// GetCertificateRetention returns how long the expired certificates are kept,
+// 0 if they are never deleted.
+func (c *GCConfig) GetCertificateRetention() time.Duration {
+	if c.CertificateRetention == nil {
+		return 0
+	}
+	return c.CertificateRetention.Duration
+}
+
+// DeleteExpiredCertificates deletes the X.509 certificates that expired more
+// than retention ago, and returns the number of certificates deleted.
+func (a *Authority) DeleteExpiredCertificates(retention time.Duration) (int, error) {
+	list, err := a.db.GetCertificateIndexes()
+	switch {
+	case err == db.ErrNotImplemented:
+		return 0, errs.NotImplemented("authority.DeleteExpiredCertificates: no persistence layer configured")
+	case err != nil:
+		return 0, errs.Wrap(http.StatusInternalServerError, err, "authority.DeleteExpiredCertificates")
+	}
+	infos, err := unmarshalCertificateInfos(list)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var n int
+	for _, info := range infos {
+		if !info.NotAfter.Add(retention).Before(now) {
+			continue
+		}
+		if err := a.db.DeleteCertificate(info.Serial, info.names()); err != nil {
+			return n, errs.Wrap(http.StatusInternalServerError, err, "authority.DeleteExpiredCertificates")
+		}
+		n++
+	}
+	return n, nil
+}
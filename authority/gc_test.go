@@ -0,0 +1,102 @@
+package authority
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestGCConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *GCConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/empty", &GCConfig{}, false},
+		{"ok", &GCConfig{
+			Period:               &provisioner.Duration{Duration: time.Minute},
+			Retention:            &provisioner.Duration{},
+			NonceRetention:       &provisioner.Duration{Duration: time.Hour},
+			CertificateRetention: &provisioner.Duration{Duration: 720 * time.Hour},
+		}, false},
+		{"fail/period", &GCConfig{Period: &provisioner.Duration{}}, true},
+		{"fail/retention", &GCConfig{Retention: &provisioner.Duration{Duration: -time.Hour}}, true},
+		{"fail/nonceRetention", &GCConfig{NonceRetention: &provisioner.Duration{Duration: -time.Hour}}, true},
+		{"fail/certificateRetention", &GCConfig{CertificateRetention: &provisioner.Duration{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("GCConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGCConfig_defaults(t *testing.T) {
+	c := &GCConfig{}
+	assert.Equals(t, time.Hour, c.GetPeriod())
+	assert.Equals(t, 168*time.Hour, c.GetRetention())
+	assert.Equals(t, 24*time.Hour, c.GetNonceRetention())
+	assert.Equals(t, time.Duration(0), c.GetCertificateRetention())
+}
+
+func TestAuthority_DeleteExpiredCertificates(t *testing.T) {
+	now := time.Now()
+	infos := []*CertificateInfo{
+		{Serial: "1", CommonName: "foo", SANs: []string{"foo.example.com"}, NotAfter: now.Add(-31 * 24 * time.Hour)},
+		{Serial: "2", SANs: []string{"bar.example.com"}, NotAfter: now.Add(-29 * 24 * time.Hour)},
+		{Serial: "3", SANs: []string{"baz.example.com"}, NotAfter: now.Add(time.Hour)},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		a := testAuthority(t)
+		deleted := map[string][]string{}
+		m := mockCertificateIndex(t, infos)
+		m.MDeleteCertificate = func(serial string, names []string) error {
+			deleted[serial] = names
+			return nil
+		}
+		a.db = m
+		n, err := a.DeleteExpiredCertificates(30 * 24 * time.Hour)
+		assert.FatalError(t, err)
+		assert.Equals(t, 1, n)
+		assert.Equals(t, map[string][]string{"1": {"foo", "foo.example.com"}}, deleted)
+	})
+
+	t.Run("fail/delete", func(t *testing.T) {
+		a := testAuthority(t)
+		m := mockCertificateIndex(t, infos)
+		m.MDeleteCertificate = func(serial string, names []string) error {
+			return errors.New("force")
+		}
+		a.db = m
+		n, err := a.DeleteExpiredCertificates(time.Hour)
+		assert.Error(t, err)
+		assert.Equals(t, 0, n)
+	})
+
+	t.Run("fail/not-implemented", func(t *testing.T) {
+		a := testAuthority(t)
+		_, err := a.DeleteExpiredCertificates(time.Hour)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusNotImplemented, sc.StatusCode())
+	})
+
+	t.Run("fail/index", func(t *testing.T) {
+		a := testAuthority(t)
+		a.db = &db.MockAuthDB{Err: errors.New("force")}
+		_, err := a.DeleteExpiredCertificates(time.Hour)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok)
+		assert.Equals(t, http.StatusInternalServerError, sc.StatusCode())
+	})
+}
@@ -0,0 +1,246 @@
+package authority
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+)
+
+// IntermediateRenewalOptions are the options used to renew the intermediate
+// certificate with the key of the root.
+//
+// RootKey is the name of the key of the current root in the KMS. Validity is
+// the lifetime of the new certificate, by default the lifetime of the current
+// intermediate, it never exceeds the lifetime of the root.
+type IntermediateRenewalOptions struct {
+	RootKey  string
+	Validity time.Duration
+}
+
+// IntermediateRecord is an intermediate certificate renewed with the admin
+// API. It is stored in the database so the other instances of the CA, and the
+// ones started later, use it instead of the certificate in the configuration.
+type IntermediateRecord struct {
+	Certificate []byte    `json:"certificate"`
+	RenewedAt   time.Time `json:"renewedAt"`
+}
+
+func unmarshalIntermediateRecord(b []byte) (*x509.Certificate, error) {
+	r := new(IntermediateRecord)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling intermediate record")
+	}
+	crt, err := x509.ParseCertificate(r.Certificate)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing intermediate certificate")
+	}
+	return crt, nil
+}
+
+// initRenewedIntermediate replaces the configured intermediate with the one
+// renewed with the admin API if it has the same key and expires later.
+func (a *Authority) initRenewedIntermediate() error {
+	a.x509IssuerValue = nil
+	b, err := a.db.GetIntermediate()
+	if err != nil {
+		return errors.Wrap(err, "error loading intermediate")
+	}
+	if b == nil {
+		return nil
+	}
+	crt, err := unmarshalIntermediateRecord(b)
+	if err != nil {
+		return err
+	}
+	a.x509IssuerValue = b
+	if isIntermediateRenewal(a.x509Issuer, a.x509Signer.Public(), crt) {
+		a.x509Issuer = crt
+	}
+	return nil
+}
+
+// syncIntermediate applies the intermediate renewed with the admin API by
+// other instances of the CA sharing the database.
+func (a *Authority) syncIntermediate() error {
+	b, err := a.db.GetIntermediate()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading intermediate")
+	}
+
+	a.x509IssuerMutex.Lock()
+	defer a.x509IssuerMutex.Unlock()
+	if b == nil || bytes.Equal(b, a.x509IssuerValue) {
+		return nil
+	}
+	crt, err := unmarshalIntermediateRecord(b)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading intermediate")
+	}
+	a.x509IssuerValue = b
+	if isIntermediateRenewal(a.x509Issuer, a.x509Signer.Public(), crt) {
+		a.setX509Issuer(crt)
+	}
+	return nil
+}
+
+// isIntermediateRenewal returns true if crt can replace the current
+// intermediate, it must have the key of the signer and expire later.
+func isIntermediateRenewal(current *x509.Certificate, pub interface{}, crt *x509.Certificate) bool {
+	return publicKeyEqual(pub, crt.PublicKey) && crt.NotAfter.After(current.NotAfter)
+}
+
+// setX509Issuer replaces the intermediate used to sign certificates, and the
+// one used to sign the CRL if it is the same. It must be called with the
+// x509IssuerMutex locked.
+func (a *Authority) setX509Issuer(crt *x509.Certificate) {
+	a.crlMutex.Lock()
+	if a.crlIssuer == a.x509Issuer {
+		a.crlIssuer = crt
+	}
+	a.crlMutex.Unlock()
+	a.x509Issuer = crt
+}
+
+// GetIntermediateCSR returns a certificate request for the current
+// intermediate, signed by its key. Once it is signed by the root, the new
+// certificate can be imported with ImportIntermediate.
+func (a *Authority) GetIntermediateCSR() (*x509.CertificateRequest, error) {
+	issuer, signer := a.GetIntermediate()
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject: issuer.RawSubject,
+	}, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetIntermediateCSR: error creating certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetIntermediateCSR: error parsing certificate request")
+	}
+	return csr, nil
+}
+
+// RenewIntermediate renews the current intermediate with the key of the root
+// and replaces it with ImportIntermediate. The new certificate has the same
+// subject, key and extensions as the current one.
+func (a *Authority) RenewIntermediate(opts *IntermediateRenewalOptions) (*x509.Certificate, error) {
+	switch {
+	case opts.RootKey == "":
+		return nil, errs.BadRequest("authority.RenewIntermediate: rootKey cannot be empty")
+	case opts.Validity < 0:
+		return nil, errs.BadRequest("authority.RenewIntermediate: validity cannot be negative")
+	case len(a.rootX509Certs) == 0:
+		return nil, errs.InternalServer("authority.RenewIntermediate: root certificate is not available")
+	}
+
+	root := a.rootX509Certs[0]
+	rootSigner, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+		SigningKey: opts.RootKey,
+		Password:   []byte(a.config.Password),
+	})
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.RenewIntermediate: error loading root key")
+	}
+	if !publicKeyEqual(rootSigner.Public(), root.PublicKey) {
+		return nil, errs.BadRequest("authority.RenewIntermediate: root key does not match the root certificate")
+	}
+
+	issuer, _ := a.GetIntermediate()
+	if opts.Validity == 0 {
+		opts.Validity = issuer.NotAfter.Sub(issuer.NotBefore)
+	}
+	now := time.Now().UTC()
+	tmpl := copyCertificate(issuer)
+	tmpl.NotBefore, tmpl.NotAfter = now, now.Add(opts.Validity)
+	if tmpl.NotAfter.After(root.NotAfter) {
+		tmpl.NotAfter = root.NotAfter
+	}
+	crt, err := signCertificate(tmpl, root, issuer.PublicKey, rootSigner)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RenewIntermediate: error signing intermediate certificate")
+	}
+	if err := a.ImportIntermediate(crt); err != nil {
+		return nil, err
+	}
+	return crt, nil
+}
+
+// ImportIntermediate replaces the current intermediate with the given
+// certificate, signed by the root for the key of the current intermediate.
+// The certificate is used immediately to sign and to chain the new
+// certificates, it is stored in the database for the other instances of the
+// CA and written in the crt file of the configuration.
+func (a *Authority) ImportIntermediate(crt *x509.Certificate) error {
+	if a.getRootRotation() != nil {
+		return errs.BadRequest("authority.ImportIntermediate: the intermediate cannot be renewed during a root rotation")
+	}
+
+	a.x509IssuerMutex.Lock()
+	defer a.x509IssuerMutex.Unlock()
+
+	now := time.Now().UTC()
+	switch {
+	case !crt.BasicConstraintsValid || !crt.IsCA:
+		return errs.BadRequest("authority.ImportIntermediate: certificate is not a CA")
+	case !publicKeyEqual(a.x509Signer.Public(), crt.PublicKey):
+		return errs.BadRequest("authority.ImportIntermediate: certificate does not match the intermediate key")
+	case !crt.NotAfter.After(a.x509Issuer.NotAfter):
+		return errs.BadRequest("authority.ImportIntermediate: certificate must expire after the current intermediate")
+	}
+	roots := x509.NewCertPool()
+	for _, root := range a.rootX509Certs {
+		roots.AddCert(root)
+	}
+	if _, err := crt.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errs.Wrap(http.StatusBadRequest, err, "authority.ImportIntermediate: error verifying certificate")
+	}
+
+	// The crt file is written first, it is always a valid replacement.
+	// Certificates stored in the KMS are not replaced.
+	if name := a.config.IntermediateCert; name != "" {
+		if fi, err := os.Stat(name); err == nil {
+			if err := writeFileAtomic(name, pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: crt.Raw,
+			}), fi.Mode().Perm()); err != nil {
+				return errs.Wrap(http.StatusInternalServerError, err, "authority.ImportIntermediate")
+			}
+		}
+	}
+
+	// Without support in the database the renewal only applies to this
+	// instance.
+	b, err := json.Marshal(&IntermediateRecord{
+		Certificate: crt.Raw,
+		RenewedAt:   now,
+	})
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.ImportIntermediate: error marshaling intermediate record")
+	}
+	swapped, err := a.db.CmpAndSwapIntermediate(a.x509IssuerValue, b)
+	switch {
+	case err == db.ErrNotImplemented:
+	case err != nil:
+		return errs.Wrap(http.StatusInternalServerError, err, "authority.ImportIntermediate: error storing intermediate")
+	case !swapped:
+		return errs.Errorf(http.StatusConflict, "authority.ImportIntermediate: intermediate has been modified concurrently")
+	default:
+		a.x509IssuerValue = b
+	}
+
+	a.setX509Issuer(crt)
+	return nil
+}
@@ -0,0 +1,204 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+// intermediateDB returns a database that stores the renewed intermediate in
+// memory.
+func intermediateDB() *db.MockAuthDB {
+	var value []byte
+	return &db.MockAuthDB{
+		MIsRevoked:        func(sn string) (bool, error) { return false, nil },
+		MStoreCertificate: func(crt *x509.Certificate) error { return nil },
+		MGetIntermediate:  func() ([]byte, error) { return value, nil },
+		MCmpAndSwapInter: func(oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(oldValue, value) {
+				return false, nil
+			}
+			value = newValue
+			return true, nil
+		},
+	}
+}
+
+// intermediateRoot creates a root certificate and writes its key encrypted
+// with the password of the CA.
+func intermediateRoot(t *testing.T, dir string) (*x509.Certificate, crypto.Signer, string) {
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	signer := priv.(crypto.Signer)
+	now := time.Now()
+	root, err := signCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Root CA"},
+		NotBefore:             now,
+		NotAfter:              now.Add(20 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "Root CA"}}, pub, signer)
+	assert.FatalError(t, err)
+	rootKey := filepath.Join(dir, "root_ca_key")
+	_, err = pemutil.Serialize(priv, pemutil.WithFilename(rootKey), pemutil.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	return root, signer, rootKey
+}
+
+// intermediateCertFile copies the test intermediate to the given directory.
+func intermediateCertFile(t *testing.T, dir string) string {
+	b, err := ioutil.ReadFile("testdata/certs/intermediate_ca.crt")
+	assert.FatalError(t, err)
+	name := filepath.Join(dir, "intermediate_ca.crt")
+	assert.FatalError(t, ioutil.WriteFile(name, b, 0644))
+	return name
+}
+
+func TestAuthority_RenewIntermediate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intermediate")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	root, _, rootKey := intermediateRoot(t, dir)
+	authDB := intermediateDB()
+	a := testAuthority(t, WithDatabase(authDB), WithX509RootCerts(root))
+	a.config.IntermediateCert = intermediateCertFile(t, dir)
+	old, signer := a.GetIntermediate()
+
+	// The key must match the current root.
+	_, err = a.RenewIntermediate(&IntermediateRenewalOptions{RootKey: "testdata/secrets/intermediate_ca_key"})
+	assertStatusCode(t, err, http.StatusBadRequest)
+	_, err = a.RenewIntermediate(&IntermediateRenewalOptions{})
+	assertStatusCode(t, err, http.StatusBadRequest)
+	_, err = a.RenewIntermediate(&IntermediateRenewalOptions{RootKey: rootKey, Validity: -time.Hour})
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	crt, err := a.RenewIntermediate(&IntermediateRenewalOptions{RootKey: rootKey})
+	assert.FatalError(t, err)
+	assert.FatalError(t, crt.CheckSignatureFrom(root))
+	assert.Equals(t, old.Subject, crt.Subject)
+	assert.Equals(t, old.SubjectKeyId, crt.SubjectKeyId)
+	assert.True(t, publicKeyEqual(old.PublicKey, crt.PublicKey))
+	assert.True(t, crt.NotAfter.Sub(crt.NotBefore) == old.NotAfter.Sub(old.NotBefore))
+
+	// The new intermediate is used immediately with the same signer.
+	issuer, s := a.GetIntermediate()
+	assert.Equals(t, crt, issuer)
+	assert.Equals(t, signer, s)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, crt, certChain[1])
+	assert.FatalError(t, certChain[0].CheckSignatureFrom(crt))
+
+	// The crt file is replaced.
+	written, err := pemutil.ReadCertificate(a.config.IntermediateCert)
+	assert.FatalError(t, err)
+	assert.Equals(t, crt.Raw, written.Raw)
+
+	// The renewed intermediate replaces the configured one in a new authority.
+	b := testAuthority(t, WithDatabase(authDB), WithX509RootCerts(root))
+	issuer, _ = b.GetIntermediate()
+	assert.Equals(t, crt.Raw, issuer.Raw)
+
+	// The same certificate cannot be imported again.
+	assertStatusCode(t, a.ImportIntermediate(crt), http.StatusBadRequest)
+}
+
+func TestAuthority_ImportIntermediate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intermediate")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	root, rootSigner, _ := intermediateRoot(t, dir)
+	authDB := intermediateDB()
+	a := testAuthority(t, WithDatabase(authDB), WithX509RootCerts(root))
+	a.config.IntermediateCert = intermediateCertFile(t, dir)
+	c := testAuthority(t, WithDatabase(authDB), WithX509RootCerts(root))
+	old, _ := a.GetIntermediate()
+
+	// The request has the subject and the key of the intermediate.
+	csr, err := a.GetIntermediateCSR()
+	assert.FatalError(t, err)
+	assert.FatalError(t, csr.CheckSignature())
+	assert.Equals(t, old.RawSubject, csr.RawSubject)
+	assert.True(t, publicKeyEqual(old.PublicKey, csr.PublicKey))
+
+	now := time.Now()
+	notAfter := now.Add(15 * 365 * 24 * time.Hour)
+	sign := func(pub crypto.PublicKey, isCA bool, parent *x509.Certificate, signer crypto.Signer) *x509.Certificate {
+		crt, err := signCertificate(&x509.Certificate{
+			Subject:               csr.Subject,
+			NotBefore:             now,
+			NotAfter:              notAfter,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  isCA,
+			MaxPathLen:            0,
+			MaxPathLenZero:        isCA,
+		}, parent, pub, signer)
+		assert.FatalError(t, err)
+		return crt
+	}
+
+	otherPub, otherPriv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	otherSigner := otherPriv.(crypto.Signer)
+	tests := []struct {
+		name string
+		crt  *x509.Certificate
+	}{
+		{"fail/not-ca", sign(csr.PublicKey, false, root, rootSigner)},
+		{"fail/key", sign(otherPub, true, root, rootSigner)},
+		{"fail/root", sign(csr.PublicKey, true, &x509.Certificate{Subject: root.Subject}, otherSigner)},
+		{"fail/not-after", old},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertStatusCode(t, a.ImportIntermediate(tt.crt), http.StatusBadRequest)
+		})
+	}
+
+	crt := sign(csr.PublicKey, true, root, rootSigner)
+	assert.FatalError(t, a.ImportIntermediate(crt))
+	issuer, _ := a.GetIntermediate()
+	assert.Equals(t, crt, issuer)
+
+	// Other instances load it from the database.
+	issuer, _ = c.GetIntermediate()
+	assert.Equals(t, old.Raw, issuer.Raw)
+	assert.FatalError(t, c.SyncCluster())
+	issuer, _ = c.GetIntermediate()
+	assert.Equals(t, crt.Raw, issuer.Raw)
+
+	// Concurrent renewals.
+	d := testAuthority(t, WithDatabase(authDB), WithX509RootCerts(root))
+	d.config.IntermediateCert = a.config.IntermediateCert
+	d.x509IssuerValue = nil
+	notAfter = notAfter.Add(time.Hour)
+	assertStatusCode(t, d.ImportIntermediate(sign(csr.PublicKey, true, root, rootSigner)), http.StatusConflict)
+
+	// Without support in the database it only applies to this instance.
+	e := testAuthority(t, WithX509RootCerts(root))
+	e.config.IntermediateCert = ""
+	assert.FatalError(t, e.ImportIntermediate(crt))
+	issuer, _ = e.GetIntermediate()
+	assert.Equals(t, crt, issuer)
+}
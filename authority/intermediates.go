@@ -0,0 +1,147 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+)
+
+// IntermediateConfig configures an additional intermediate used to sign X.509
+// certificates. By default it signs the certificates with a key of the same
+// type as its own key, and clients can request it by name.
+type IntermediateConfig struct {
+	Name string `json:"name"`
+	Cert string `json:"crt"`
+	Key  string `json:"key"`
+}
+
+// Validate checks the fields in IntermediateConfig.
+func (c *IntermediateConfig) Validate() error {
+	switch {
+	case c == nil:
+		return errors.New("intermediate cannot be empty")
+	case c.Name == "":
+		return errors.New("intermediate name cannot be empty")
+	case c.Cert == "":
+		return errors.Errorf("intermediate %s crt cannot be empty", c.Name)
+	case c.Key == "":
+		return errors.Errorf("intermediate %s key cannot be empty", c.Name)
+	default:
+		return nil
+	}
+}
+
+// x509Intermediate is a loaded IntermediateConfig.
+type x509Intermediate struct {
+	name   string
+	cert   *x509.Certificate
+	signer crypto.Signer
+}
+
+// initIntermediates loads the additional intermediates.
+func (a *Authority) initIntermediates() error {
+	a.x509Intermediates = nil
+	for _, c := range a.config.Intermediates {
+		crt, err := a.readCertificate(c.Cert)
+		if err != nil {
+			return err
+		}
+		signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+			SigningKey: c.Key,
+			Password:   []byte(a.config.Password),
+		})
+		if err != nil {
+			return err
+		}
+		a.x509Intermediates = append(a.x509Intermediates, &x509Intermediate{
+			name:   c.Name,
+			cert:   crt,
+			signer: signer,
+		})
+	}
+	return nil
+}
+
+// getX509Issuer returns the intermediate and the signer used to sign a
+// certificate with the given public key. If name is not empty it returns the
+// intermediate with that name, if not it returns the configured intermediate
+// if its key type matches the type of the public key, or the first additional
+// intermediate that matches it. The configured intermediate is used if none of
+// them match.
+func (a *Authority) getX509Issuer(name string, pub crypto.PublicKey) (*x509.Certificate, crypto.Signer, error) {
+	if name != "" {
+		for _, in := range a.x509Intermediates {
+			if in.name == name {
+				return in.cert, in.signer, nil
+			}
+		}
+		return nil, nil, errors.Errorf("intermediate %s was not found", name)
+	}
+	issuer, signer := a.GetIntermediate()
+	kty := keyType(pub)
+	if kty == "" || kty == keyType(issuer.PublicKey) {
+		return issuer, signer, nil
+	}
+	for _, in := range a.x509Intermediates {
+		if keyType(in.cert.PublicKey) == kty {
+			return in.cert, in.signer, nil
+		}
+	}
+	return issuer, signer, nil
+}
+
+// getX509IssuerByKeyID returns the intermediate with the given subject key
+// id, it returns false if there's none.
+func (a *Authority) getX509IssuerByKeyID(keyID []byte) (*x509.Certificate, crypto.Signer, bool) {
+	if len(keyID) > 0 {
+		if issuer, signer := a.GetIntermediate(); bytes.Equal(keyID, issuer.SubjectKeyId) {
+			return issuer, signer, true
+		}
+		for _, in := range a.x509Intermediates {
+			if bytes.Equal(keyID, in.cert.SubjectKeyId) {
+				return in.cert, in.signer, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// getX509IssuerChain returns the intermediate added to a certificate signed
+// by the given issuer.
+func (a *Authority) getX509IssuerChain(issuer *x509.Certificate) *x509.Certificate {
+	if a.isAdditionalIntermediate(issuer) {
+		return issuer
+	}
+	return a.getX509Intermediate()
+}
+
+// isAdditionalIntermediate returns true if the given issuer is one of the
+// additional intermediates.
+func (a *Authority) isAdditionalIntermediate(issuer *x509.Certificate) bool {
+	for _, in := range a.x509Intermediates {
+		if issuer == in.cert {
+			return true
+		}
+	}
+	return false
+}
+
+// keyType returns the JWK key type of a public key.
+func keyType(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "EC"
+	case ed25519.PublicKey:
+		return "OKP"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,149 @@
+package authority
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+func TestIntermediateConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *IntermediateConfig
+		wantErr bool
+	}{
+		{"ok", &IntermediateConfig{Name: "rsa", Cert: "rsa.crt", Key: "rsa.key"}, false},
+		{"fail/nil", nil, true},
+		{"fail/name", &IntermediateConfig{Cert: "rsa.crt", Key: "rsa.key"}, true},
+		{"fail/crt", &IntermediateConfig{Name: "rsa", Key: "rsa.key"}, true},
+		{"fail/key", &IntermediateConfig{Name: "rsa", Cert: "rsa.crt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("IntermediateConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_intermediates(t *testing.T) {
+	c, err := LoadConfiguration("../ca/testdata/ca.json")
+	assert.FatalError(t, err)
+	c.Intermediates = []*IntermediateConfig{
+		{Name: "rsa", Cert: "rsa.crt", Key: "rsa.key"},
+	}
+	assert.FatalError(t, c.Validate())
+	c.Intermediates = append(c.Intermediates, &IntermediateConfig{Name: "rsa", Cert: "other.crt", Key: "other.key"})
+	assert.Error(t, c.Validate())
+}
+
+func TestAuthority_Sign_intermediates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intermediates")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	// RSA intermediate
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "RSA Intermediate CA"},
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, rsaKey.Public(), rsaKey)
+	assert.FatalError(t, err)
+	rsaCert, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	certFile, keyFile := filepath.Join(dir, "rsa.crt"), filepath.Join(dir, "rsa.key")
+	assert.FatalError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	_, err = pemutil.Serialize(rsaKey, pemutil.WithFilename(keyFile), pemutil.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MIsRevoked:        func(sn string) (bool, error) { return false, nil },
+		MStoreCertificate: func(crt *x509.Certificate) error { return nil },
+	}))
+	a.config.Intermediates = []*IntermediateConfig{{Name: "rsa", Cert: certFile, Key: keyFile}}
+	assert.FatalError(t, a.initIntermediates())
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name   string
+		csr    *x509.CertificateRequest
+		opts   provisioner.Options
+		issuer *x509.Certificate
+		code   int
+	}{
+		{"ok/ec", getCSR(t, ecKey), provisioner.Options{}, a.x509Issuer, 0},
+		{"ok/rsa", getCSR(t, leafKey), provisioner.Options{}, rsaCert, 0},
+		{"ok/ed25519", getCSR(t, edKey), provisioner.Options{}, a.x509Issuer, 0},
+		{"ok/name", getCSR(t, ecKey), provisioner.Options{Intermediate: "rsa"}, rsaCert, 0},
+		{"fail/name", getCSR(t, ecKey), provisioner.Options{Intermediate: "foo"}, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certChain, err := a.Sign(tt.csr, tt.opts)
+			if tt.code != 0 {
+				assertStatusCode(t, err, tt.code)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.issuer, certChain[1])
+			assert.FatalError(t, certChain[0].CheckSignatureFrom(tt.issuer))
+			assert.Equals(t, tt.issuer.Subject.String(), certChain[0].Issuer.String())
+		})
+	}
+
+	// Renewals keep the intermediate, rekeys use the one for the new key.
+	now := time.Now()
+	leaf, err := x509util.NewLeafProfile("renew", rsaCert, rsaKey,
+		x509util.WithNotBeforeAfterDuration(now, now.Add(time.Hour), 0),
+		x509util.WithPublicKey(ecKey.Public()), x509util.WithHosts("test.smallstep.com"),
+		withProvisionerOID("Max", a.config.AuthorityConfig.Provisioners[0].(*provisioner.JWK).Key.KeyID))
+	assert.FatalError(t, err)
+	b, err := leaf.CreateCertificate()
+	assert.FatalError(t, err)
+	cert, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+
+	certChain, err := a.Renew(cert)
+	assert.FatalError(t, err)
+	assert.Equals(t, rsaCert, certChain[1])
+	assert.FatalError(t, certChain[0].CheckSignatureFrom(rsaCert))
+
+	certChain, err = a.Rekey(cert, ecKey.Public())
+	assert.FatalError(t, err)
+	assert.Equals(t, a.x509Issuer, certChain[1])
+	assert.FatalError(t, certChain[0].CheckSignatureFrom(a.x509Issuer))
+	assert.Equals(t, a.x509Issuer.Subject.String(), certChain[0].Issuer.String())
+}
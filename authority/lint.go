@@ -0,0 +1,52 @@
+package authority
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/lint"
+)
+
+// initLint initializes the linter of the authority and the ones of the
+// profiles with their own lint options.
+func (a *Authority) initLint() (err error) {
+	if a.linter, err = lint.New(a.config.AuthorityConfig.Lint); err != nil {
+		return err
+	}
+	a.profileLinters = make(map[string]*lint.Linter)
+	for _, p := range a.config.AuthorityConfig.Profiles {
+		if p.Lint != nil {
+			if a.profileLinters[p.Name], err = lint.New(p.Lint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getLinter returns the linter used for the certificates with the given
+// profile, nil if linting is not configured.
+func (a *Authority) getLinter(profile string) *lint.Linter {
+	if l, ok := a.profileLinters[profile]; ok {
+		return l
+	}
+	return a.linter
+}
+
+// storeLintResults stores the lint warnings of the certificate with the given
+// serial number.
+func (a *Authority) storeLintResults(serialNumber *big.Int, results lint.Results) error {
+	if len(results) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling lint results")
+	}
+	if err := a.db.StoreLintResults(serialNumber.String(), b); err != nil && err != db.ErrNotImplemented {
+		return errors.Wrap(err, "error storing lint results")
+	}
+	return nil
+}
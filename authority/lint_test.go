@@ -0,0 +1,91 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/lint"
+	"github.com/smallstep/cli/crypto/keys"
+)
+
+func TestAuthority_Sign_lint(t *testing.T) {
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	var stored map[string]lint.Results
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MIsRevoked:        func(sn string) (bool, error) { return false, nil },
+		MStoreCertificate: func(crt *x509.Certificate) error { return nil },
+		MStoreLintResults: func(serial string, b []byte) error {
+			var results lint.Results
+			assert.FatalError(t, json.Unmarshal(b, &results))
+			stored[serial] = results
+			return nil
+		},
+	}))
+	a.config.AuthorityConfig.Lint = &lint.Options{}
+	a.config.AuthorityConfig.Profiles = []*provisioner.Profile{
+		{Name: "relaxed", Lint: &lint.Options{Disable: []string{"*"}}},
+		{Name: "strict", Lint: &lint.Options{Error: []string{"*"}}},
+		{Name: "default"},
+	}
+	assert.FatalError(t, a.initLint())
+
+	withDNSNames := func(names ...string) func(*x509.CertificateRequest) {
+		return func(csr *x509.CertificateRequest) {
+			csr.DNSNames = names
+		}
+	}
+
+	// The warnings are stored.
+	stored = make(map[string]lint.Results)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	cert := certChain[0]
+	assert.Equals(t, map[string]lint.Results{
+		cert.SerialNumber.String(): {{
+			Lint:     "w_subject_common_name_not_in_san",
+			Severity: lint.Warning,
+			Message:  "common name smallstep test is not a subject alternative name",
+		}},
+	}, stored)
+
+	// The errors reject the certificate.
+	stored = make(map[string]lint.Results)
+	_, err = a.Sign(getCSR(t, priv, withDNSNames("foo_bar-.smallstep.com")), provisioner.Options{})
+	assertStatusCode(t, err, http.StatusBadRequest)
+	_, err = a.Sign(getCSR(t, priv, withDNSNames("foo_bar-.smallstep.com")), provisioner.Options{Profile: "default"})
+	assertStatusCode(t, err, http.StatusBadRequest)
+	assert.Len(t, 0, stored)
+
+	// Profile lints.
+	_, err = a.Sign(getCSR(t, priv, withDNSNames("foo_bar-.smallstep.com")), provisioner.Options{Profile: "relaxed"})
+	assert.FatalError(t, err)
+	assert.Len(t, 0, stored)
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "strict"})
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	// Renewals use the authority lints.
+	stored = make(map[string]lint.Results)
+	certChain, err = a.Renew(cert)
+	assert.FatalError(t, err)
+	assert.Len(t, 1, stored[certChain[0].SerialNumber.String()])
+
+	a.config.AuthorityConfig.Lint = &lint.Options{Error: []string{"w_subject_common_name_not_in_san"}}
+	assert.FatalError(t, a.initLint())
+	_, err = a.Renew(cert)
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	// Linting is disabled by default.
+	a.config.AuthorityConfig.Lint = nil
+	assert.FatalError(t, a.initLint())
+	stored = make(map[string]lint.Results)
+	_, err = a.Sign(getCSR(t, priv, withDNSNames("foo_bar-.smallstep.com")), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Len(t, 0, stored)
+}
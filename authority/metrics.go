@@ -0,0 +1,42 @@
+package authority
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+// MetricsObserver is the interface used to report the operations of the
+// authority and the database, it is implemented by monitoring.CAMetrics.
+type MetricsObserver interface {
+	ObserveOperation(operation, provisioner string, d time.Duration, err error)
+	ObserveDB(operation, table string, d time.Duration)
+}
+
+// observe reports the result and duration of an operation started at start.
+func (a *Authority) observe(operation, provisionerName string, start time.Time, err error) {
+	if a.metrics != nil {
+		a.metrics.ObserveOperation(operation, provisionerName, time.Since(start), err)
+	}
+}
+
+// x509ProvisionerName returns the name of the provisioner in the certificate
+// extension, or the one in the token if the certificate is not available.
+func x509ProvisionerName(crt *x509.Certificate, at *auditToken) string {
+	if crt != nil {
+		if name, ok := provisioner.GetProvisionerName(crt); ok {
+			return name
+		}
+	}
+	return at.provisionerName()
+}
+
+// provisionerName returns the name of the provisioner of the token, at can be
+// nil.
+func (at *auditToken) provisionerName() string {
+	if at != nil {
+		return at.provisioner
+	}
+	return ""
+}
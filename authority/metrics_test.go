@@ -0,0 +1,81 @@
+package authority
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+type observation struct {
+	operation   string
+	provisioner string
+	failed      bool
+}
+
+type mockMetrics struct {
+	operations []observation
+}
+
+func (m *mockMetrics) ObserveOperation(operation, provisioner string, d time.Duration, err error) {
+	m.operations = append(m.operations, observation{operation, provisioner, err != nil})
+}
+
+func (m *mockMetrics) ObserveDB(operation, table string, d time.Duration) {}
+
+func TestAuthority_metrics(t *testing.T) {
+	m := new(mockMetrics)
+	a := testAuthority(t, WithMetrics(m))
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return false, nil
+		},
+		MRevoke: func(rci *db.RevokedCertificateInfo) error {
+			return nil
+		},
+	}
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	signOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	csr := getCSR(t, priv)
+	certChain, err := a.Sign(csr, provisioner.Options{}, signOpts...)
+	assert.FatalError(t, err)
+	renewed, err := a.Renew(certChain[0])
+	assert.FatalError(t, err)
+
+	// Failures are reported with the provisioner of the token.
+	csr.Signature = []byte("foo")
+	_, err = a.Sign(csr, provisioner.Options{}, signOpts...)
+	assert.Error(t, err)
+
+	a.db.(*db.MockAuthDB).MRevoke = func(rci *db.RevokedCertificateInfo) error {
+		return errors.New("force")
+	}
+	assert.Error(t, a.Revoke(provisioner.NewContextWithMethod(context.Background(), provisioner.RevokeMethod), &RevokeOptions{
+		Serial: renewed[0].SerialNumber.String(),
+		MTLS:   true,
+		Crt:    renewed[0],
+	}))
+
+	assert.Equals(t, []observation{
+		{AuditX509Sign, "step-cli", false},
+		{AuditX509Renew, "step-cli", false},
+		{AuditX509Sign, "step-cli", true},
+		{AuditX509Revoke, "step-cli", true},
+	}, m.operations)
+}
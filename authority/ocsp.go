@@ -0,0 +1,110 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ocsp"
+)
+
+// defaultOCSPValidity is the validity of the OCSP responses, the responses
+// are signed on every request.
+var defaultOCSPValidity = time.Hour
+
+// GetOCSPResponse returns the OCSP response in DER format for the given OCSP
+// request. The response is signed by the intermediate that issued the
+// certificate, and the status of the certificate is good unless it is in the
+// revocation table, in that case the response includes the revocation time
+// and reason. Malformed requests and certificates issued by other CAs return
+// the corresponding OCSP error responses.
+func (a *Authority) GetOCSPResponse(req []byte) ([]byte, error) {
+	r, err := ocsp.ParseRequest(req)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	issuer, signer, ok := a.getOCSPIssuer(r)
+	if !ok {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	sn := r.SerialNumber.String()
+	rci, err := a.db.GetRevokedCertificate(sn)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetOCSPResponse: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse",
+			errs.WithKeyVal("serialNumber", sn))
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: r.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(defaultOCSPValidity),
+		IssuerHash:   r.HashAlgorithm,
+	}
+	if rci != nil {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = rci.RevokedAt
+		template.RevocationReason = rci.ReasonCode
+	}
+	resp, err := ocsp.CreateResponse(issuer, issuer, template, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetOCSPResponse",
+			errs.WithKeyVal("serialNumber", sn))
+	}
+	return resp, nil
+}
+
+// getOCSPIssuer returns the intermediate, and its signer, with the name and
+// key hashes of the OCSP request.
+func (a *Authority) getOCSPIssuer(r *ocsp.Request) (*x509.Certificate, crypto.Signer, bool) {
+	if !r.HashAlgorithm.Available() {
+		return nil, nil, false
+	}
+	if issuer, signer := a.GetIntermediate(); ocspIssuerMatches(r, issuer) {
+		return issuer, signer, true
+	}
+	for _, in := range a.x509Intermediates {
+		if ocspIssuerMatches(r, in.cert) {
+			return in.cert, in.signer, true
+		}
+	}
+	return nil, nil, false
+}
+
+func ocspIssuerMatches(r *ocsp.Request, issuer *x509.Certificate) bool {
+	nameHash, keyHash, err := ocspIssuerHashes(r.HashAlgorithm, issuer)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(r.IssuerNameHash, nameHash) && bytes.Equal(r.IssuerKeyHash, keyHash)
+}
+
+// ocspIssuerHashes returns the hashes of the subject and public key of the
+// issuer used in the OCSP requests.
+func ocspIssuerHashes(hash crypto.Hash, issuer *x509.Certificate) ([]byte, []byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing issuer public key")
+	}
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	return nameHash, h.Sum(nil), nil
+}
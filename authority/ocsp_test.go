@@ -0,0 +1,113 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestAuthority_GetOCSPResponse(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetRevokedCert: func(sn string) (*db.RevokedCertificateInfo, error) {
+			switch sn {
+			case "1234":
+				return &db.RevokedCertificateInfo{Serial: sn, ReasonCode: ocsp.CertificateHold, RevokedAt: revokedAt}, nil
+			case "9999":
+				return nil, errors.New("force")
+			default:
+				return nil, nil
+			}
+		},
+	}))
+
+	// Additional intermediate.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other Intermediate"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "Other Intermediate"}}, key.Public(), key)
+	assert.FatalError(t, err)
+	other, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	a.x509Intermediates = append(a.x509Intermediates, &x509Intermediate{name: "other", cert: other, signer: key})
+
+	newRequest := func(sn int64, issuer *x509.Certificate, hash crypto.Hash) []byte {
+		b, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(sn)}, issuer, &ocsp.RequestOptions{Hash: hash})
+		assert.FatalError(t, err)
+		return b
+	}
+
+	t.Run("ok/good", func(t *testing.T) {
+		b, err := a.GetOCSPResponse(newRequest(5678, a.x509Issuer, crypto.SHA1))
+		assert.FatalError(t, err)
+		resp, err := ocsp.ParseResponse(b, a.x509Issuer)
+		assert.FatalError(t, err)
+		assert.Equals(t, ocsp.Good, resp.Status)
+		assert.Equals(t, big.NewInt(5678), resp.SerialNumber)
+		assert.Equals(t, defaultOCSPValidity, resp.NextUpdate.Sub(resp.ThisUpdate))
+	})
+
+	t.Run("ok/revoked", func(t *testing.T) {
+		b, err := a.GetOCSPResponse(newRequest(1234, a.x509Issuer, crypto.SHA256))
+		assert.FatalError(t, err)
+		resp, err := ocsp.ParseResponse(b, a.x509Issuer)
+		assert.FatalError(t, err)
+		assert.Equals(t, ocsp.Revoked, resp.Status)
+		assert.Equals(t, ocsp.CertificateHold, resp.RevocationReason)
+		assert.True(t, resp.RevokedAt.Equal(revokedAt))
+		assert.Equals(t, crypto.SHA256, resp.IssuerHash)
+	})
+
+	t.Run("ok/intermediate", func(t *testing.T) {
+		b, err := a.GetOCSPResponse(newRequest(5678, other, crypto.SHA1))
+		assert.FatalError(t, err)
+		resp, err := ocsp.ParseResponse(b, other)
+		assert.FatalError(t, err)
+		assert.Equals(t, ocsp.Good, resp.Status)
+	})
+
+	t.Run("ok/malformed", func(t *testing.T) {
+		b, err := a.GetOCSPResponse([]byte("foo"))
+		assert.FatalError(t, err)
+		assert.Equals(t, ocsp.MalformedRequestErrorResponse, b)
+	})
+
+	t.Run("ok/unknown issuer", func(t *testing.T) {
+		unknown := &x509.Certificate{
+			RawSubject:              other.RawSubject,
+			RawSubjectPublicKeyInfo: a.x509Issuer.RawSubjectPublicKeyInfo,
+		}
+		b, err := a.GetOCSPResponse(newRequest(5678, unknown, crypto.SHA1))
+		assert.FatalError(t, err)
+		assert.Equals(t, ocsp.UnauthorizedErrorResponse, b)
+	})
+
+	t.Run("fail/db", func(t *testing.T) {
+		_, err := a.GetOCSPResponse(newRequest(9999, a.x509Issuer, crypto.SHA1))
+		assertStatusCode(t, err, http.StatusInternalServerError)
+	})
+
+	t.Run("fail/nil-db", func(t *testing.T) {
+		_, err := testAuthority(t).GetOCSPResponse(newRequest(5678, a.x509Issuer, crypto.SHA1))
+		assertStatusCode(t, err, http.StatusNotImplemented)
+	})
+}
@@ -26,6 +26,15 @@ func WithDatabase(db db.AuthDB) Option {
 	}
 }
 
+// WithMetrics sets the observer of the operations of the authority and the
+// database. The database is only observed if it is created by the authority.
+func WithMetrics(m MetricsObserver) Option {
+	return func(a *Authority) error {
+		a.metrics = m
+		return nil
+	}
+}
+
 // WithGetIdentityFunc sets a custom function to retrieve the identity from
 // an external resource.
 func WithGetIdentityFunc(fn func(ctx context.Context, p provisioner.Interface, email string) (*provisioner.Identity, error)) Option {
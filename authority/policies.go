@@ -0,0 +1,119 @@
+package authority
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+// PolicyRecord is the policy of the authority managed with the admin API. It
+// replaces the policy in the configuration. Version 0 is the policy in the
+// configuration, and it's incremented on every change.
+type PolicyRecord struct {
+	Version int             `json:"version"`
+	Policy  *policy.Options `json:"policy,omitempty"`
+}
+
+// initPolicy initializes the authority policy using the policy stored with the
+// admin API or, if there's none, the one in the configuration.
+func (a *Authority) initPolicy() error {
+	a.policyRecord = &PolicyRecord{Policy: a.config.AuthorityConfig.Policy}
+	a.policyValue = nil
+	b, err := a.db.GetPolicy()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading policy")
+	}
+	if b != nil {
+		r := new(PolicyRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling policy record")
+		}
+		a.policyRecord, a.policyValue = r, b
+	}
+	// The options in the configuration are already validated.
+	p, err := policy.New(a.policyRecord.Policy)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading policy")
+	}
+	a.policy = p
+	return nil
+}
+
+// syncPolicy applies the policy stored with the admin API by other instances
+// of the CA sharing the database.
+func (a *Authority) syncPolicy() error {
+	b, err := a.db.GetPolicy()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading policy")
+	}
+
+	a.policyMutex.Lock()
+	defer a.policyMutex.Unlock()
+	if b == nil || bytes.Equal(b, a.policyValue) {
+		return nil
+	}
+	r := new(PolicyRecord)
+	if err := json.Unmarshal(b, r); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling policy record")
+	}
+	if r.Version < a.policyRecord.Version {
+		return nil
+	}
+	p, err := policy.New(r.Policy)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading policy")
+	}
+	a.policy, a.policyRecord, a.policyValue = p, r, b
+	return nil
+}
+
+// getPolicy returns the current authority policy.
+func (a *Authority) getPolicy() *policy.Policy {
+	a.policyMutex.RLock()
+	defer a.policyMutex.RUnlock()
+	return a.policy
+}
+
+// GetPolicyRecord returns the current policy of the authority.
+func (a *Authority) GetPolicyRecord() *PolicyRecord {
+	a.policyMutex.RLock()
+	defer a.policyMutex.RUnlock()
+	return a.policyRecord
+}
+
+// UpdatePolicy replaces the policy of the authority with the given options, a
+// nil value removes all the restrictions. The version must be the current
+// version of the policy, otherwise it returns a conflict error.
+func (a *Authority) UpdatePolicy(version int, opts *policy.Options) (*PolicyRecord, error) {
+	p, err := policy.New(opts)
+	if err != nil {
+		return nil, errs.BadRequestErr(err, errs.WithMessage("invalid policy: %s", err))
+	}
+
+	a.policyMutex.Lock()
+	defer a.policyMutex.Unlock()
+
+	if a.policyRecord.Version != version {
+		return nil, errs.Errorf(http.StatusConflict, "policy version %d does not match the current version %d", version, a.policyRecord.Version)
+	}
+	r := &PolicyRecord{Version: version + 1, Policy: opts}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error marshaling policy record")
+	}
+	swapped, err := a.db.CmpAndSwapPolicy(a.policyValue, b)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.Wrap(http.StatusNotImplemented, err, "storing the policy is not implemented")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error storing policy")
+	case !swapped:
+		return nil, errs.Errorf(http.StatusConflict, "policy has been modified concurrently")
+	}
+	a.policy, a.policyRecord, a.policyValue = p, r, b
+	return r, nil
+}
@@ -0,0 +1,86 @@
+package authority
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestAuthority_UpdatePolicy(t *testing.T) {
+	var stored []byte
+	mockDB := &db.MockAuthDB{
+		MGetPolicy: func() ([]byte, error) {
+			return stored, nil
+		},
+		MCmpAndSwapPolicy: func(oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(stored, oldValue) {
+				return false, nil
+			}
+			stored = newValue
+			return true, nil
+		},
+	}
+	assertError := func(err error, code int, msg string) {
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+			assert.HasPrefix(t, err.Error(), msg)
+		}
+	}
+	allowed := func(a *Authority, name string) bool {
+		return a.getPolicy().AllowX509(&x509.Certificate{DNSNames: []string{name}}) == nil
+	}
+
+	configPolicy := &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509Names{DNS: []string{".smallstep.com"}}},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	a.config.AuthorityConfig.Policy = configPolicy
+	assert.FatalError(t, a.initPolicy())
+	assert.Equals(t, &PolicyRecord{Policy: configPolicy}, a.GetPolicyRecord())
+	assert.True(t, allowed(a, "ca.smallstep.com"))
+	assert.False(t, allowed(a, "example.com"))
+
+	// Update
+	newPolicy := &policy.Options{
+		X509: &policy.X509Options{Allow: &policy.X509Names{DNS: []string{".example.com"}}},
+	}
+	_, err := a.UpdatePolicy(1, newPolicy)
+	assertError(err, http.StatusConflict, "policy version 1 does not match the current version 0")
+	_, err = a.UpdatePolicy(0, &policy.Options{
+		X509: &policy.X509Options{Deny: &policy.X509Names{IP: []string{"10.0.0.0/33"}}},
+	})
+	assertError(err, http.StatusBadRequest, "error parsing x509.deny")
+	r, err := a.UpdatePolicy(0, newPolicy)
+	assert.FatalError(t, err)
+	assert.Equals(t, &PolicyRecord{Version: 1, Policy: newPolicy}, r)
+	assert.False(t, allowed(a, "ca.smallstep.com"))
+	assert.True(t, allowed(a, "www.example.com"))
+
+	// The stored policy replaces the configuration
+	a2 := testAuthority(t, WithDatabase(mockDB))
+	a2.config.AuthorityConfig.Policy = configPolicy
+	assert.FatalError(t, a2.initPolicy())
+	assert.Equals(t, 1, a2.GetPolicyRecord().Version)
+	assert.True(t, allowed(a2, "www.example.com"))
+
+	// A concurrent change in the database
+	r, err = a2.UpdatePolicy(1, nil)
+	assert.FatalError(t, err)
+	assert.Equals(t, &PolicyRecord{Version: 2}, r)
+	assert.True(t, allowed(a2, "ca.smallstep.com"))
+	_, err = a.UpdatePolicy(1, configPolicy)
+	assertError(err, http.StatusConflict, "policy has been modified concurrently")
+
+	// Database without support
+	a3 := testAuthority(t)
+	_, err = a3.UpdatePolicy(0, newPolicy)
+	assertError(err, http.StatusNotImplemented, "storing the policy is not implemented")
+}
@@ -0,0 +1,297 @@
+package policy
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NameConstraints are the X.509 name constraints added to the CA certificates
+// issued by a parent CA. The names use the format of the X.509 name
+// constraints extension:
+//
+// DNS are domains, "example.com" matches that name and any subdomain, and
+// ".example.com" matches only the subdomains.
+//
+// IP are IP addresses or ranges in CIDR notation.
+//
+// Email are email addresses, domains that match the domain of the email
+// addresses, or domains with a leading dot that match their subdomains.
+//
+// URI are domains that match the host of the URIs, with a leading dot they
+// match their subdomains.
+type NameConstraints struct {
+	Critical  bool       `json:"critical,omitempty"`
+	Permitted *X509Names `json:"permitted,omitempty"`
+	Excluded  *X509Names `json:"excluded,omitempty"`
+}
+
+// Validate validates the name constraints.
+func (n *NameConstraints) Validate() error {
+	return n.Apply(new(x509.Certificate))
+}
+
+// Apply sets the name constraints in the given CA certificate, nil is ok.
+func (n *NameConstraints) Apply(cert *x509.Certificate) error {
+	if n == nil {
+		return nil
+	}
+	var err error
+	if p := n.Permitted; p != nil {
+		if cert.PermittedDNSDomains, err = constraintDomains("dns", p.DNS); err != nil {
+			return errors.Wrap(err, "error parsing permitted")
+		}
+		if cert.PermittedIPRanges, err = constraintIPRanges(p.IP); err != nil {
+			return errors.Wrap(err, "error parsing permitted")
+		}
+		if cert.PermittedEmailAddresses, err = constraintEmails(p.Email); err != nil {
+			return errors.Wrap(err, "error parsing permitted")
+		}
+		if cert.PermittedURIDomains, err = constraintDomains("uri", p.URI); err != nil {
+			return errors.Wrap(err, "error parsing permitted")
+		}
+	}
+	if e := n.Excluded; e != nil {
+		if cert.ExcludedDNSDomains, err = constraintDomains("dns", e.DNS); err != nil {
+			return errors.Wrap(err, "error parsing excluded")
+		}
+		if cert.ExcludedIPRanges, err = constraintIPRanges(e.IP); err != nil {
+			return errors.Wrap(err, "error parsing excluded")
+		}
+		if cert.ExcludedEmailAddresses, err = constraintEmails(e.Email); err != nil {
+			return errors.Wrap(err, "error parsing excluded")
+		}
+		if cert.ExcludedURIDomains, err = constraintDomains("uri", e.URI); err != nil {
+			return errors.Wrap(err, "error parsing excluded")
+		}
+	}
+	cert.PermittedDNSDomainsCritical = n.Critical
+	return nil
+}
+
+func constraintDomains(typ string, names []string) ([]string, error) {
+	var domains []string
+	for _, s := range names {
+		d := strings.ToLower(strings.TrimSuffix(s, "."))
+		for _, l := range strings.Split(strings.TrimPrefix(d, "."), ".") {
+			if l == "" || strings.ContainsAny(l, "*:/@") {
+				return nil, errors.Errorf("%s %s is not valid", typ, s)
+			}
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+func constraintIPRanges(names []string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, s := range names {
+		ipNet, err := parseIPRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+func constraintEmails(names []string) ([]string, error) {
+	var emails []string
+	for _, s := range names {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			if i == 0 || i == len(s)-1 {
+				return nil, errors.Errorf("email %s is not valid", s)
+			}
+			emails = append(emails, s)
+			continue
+		}
+		d, err := constraintDomains("email", []string{s})
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, d...)
+	}
+	return emails, nil
+}
+
+// NameConstraintsError is the error returned when a name is not allowed by the
+// name constraints of the issuer.
+type NameConstraintsError struct {
+	Type     string
+	Name     string
+	Excluded bool
+}
+
+// Error implements the error interface.
+func (e *NameConstraintsError) Error() string {
+	if e.Excluded {
+		return "certificate " + e.Type + " " + e.Name + " is excluded by the name constraints of the issuer"
+	}
+	return "certificate " + e.Type + " " + e.Name + " is not permitted by the name constraints of the issuer"
+}
+
+// CheckNameConstraints returns an error if any of the names in the certificate
+// is not allowed by the name constraints of the issuer. A type of name is
+// only restricted by the permitted constraints if there is at least one of
+// that type. The common name is checked as an IP, email or DNS name if it's
+// not one of the SANs and it looks like one.
+func CheckNameConstraints(issuer, cert *x509.Certificate) error {
+	if !hasNameConstraints(issuer) {
+		return nil
+	}
+	check := func(typ, name string, permitted, excluded int, match func(i int, excluded bool) bool) error {
+		for i := 0; i < excluded; i++ {
+			if match(i, true) {
+				return &NameConstraintsError{Type: typ, Name: name, Excluded: true}
+			}
+		}
+		if permitted == 0 {
+			return nil
+		}
+		for i := 0; i < permitted; i++ {
+			if match(i, false) {
+				return nil
+			}
+		}
+		return &NameConstraintsError{Type: typ, Name: name}
+	}
+	checkDNS := func(typ, name string) error {
+		return check(typ, name, len(issuer.PermittedDNSDomains), len(issuer.ExcludedDNSDomains), func(i int, excluded bool) bool {
+			if excluded {
+				return matchDomainConstraint(issuer.ExcludedDNSDomains[i], name, true)
+			}
+			return matchDomainConstraint(issuer.PermittedDNSDomains[i], name, true)
+		})
+	}
+	checkIP := func(ip net.IP) error {
+		return check("ip address", ip.String(), len(issuer.PermittedIPRanges), len(issuer.ExcludedIPRanges), func(i int, excluded bool) bool {
+			if excluded {
+				return issuer.ExcludedIPRanges[i].Contains(ip)
+			}
+			return issuer.PermittedIPRanges[i].Contains(ip)
+		})
+	}
+	checkEmail := func(email string) error {
+		return check("email address", email, len(issuer.PermittedEmailAddresses), len(issuer.ExcludedEmailAddresses), func(i int, excluded bool) bool {
+			if excluded {
+				return matchEmailConstraint(issuer.ExcludedEmailAddresses[i], email)
+			}
+			return matchEmailConstraint(issuer.PermittedEmailAddresses[i], email)
+		})
+	}
+	checkURI := func(u *url.URL) error {
+		return check("uri", u.String(), len(issuer.PermittedURIDomains), len(issuer.ExcludedURIDomains), func(i int, excluded bool) bool {
+			if excluded {
+				return matchURIConstraint(issuer.ExcludedURIDomains[i], u)
+			}
+			return matchURIConstraint(issuer.PermittedURIDomains[i], u)
+		})
+	}
+
+	if cn := cert.Subject.CommonName; cn != "" && !isSAN(cert, cn) {
+		var err error
+		switch {
+		case net.ParseIP(cn) != nil:
+			err = checkIP(net.ParseIP(cn))
+		case strings.Contains(cn, "@"):
+			err = checkEmail(cn)
+		case isHostname(cn):
+			err = checkDNS("common name", cn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range cert.DNSNames {
+		if err := checkDNS("dns name", name); err != nil {
+			return err
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if err := checkIP(ip); err != nil {
+			return err
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if err := checkEmail(email); err != nil {
+			return err
+		}
+	}
+	for _, u := range cert.URIs {
+		if err := checkURI(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasNameConstraints(cert *x509.Certificate) bool {
+	return len(cert.PermittedDNSDomains) > 0 || len(cert.ExcludedDNSDomains) > 0 ||
+		len(cert.PermittedIPRanges) > 0 || len(cert.ExcludedIPRanges) > 0 ||
+		len(cert.PermittedEmailAddresses) > 0 || len(cert.ExcludedEmailAddresses) > 0 ||
+		len(cert.PermittedURIDomains) > 0 || len(cert.ExcludedURIDomains) > 0
+}
+
+// isHostname returns true if the name only contains the characters allowed in
+// a DNS name and at least one dot.
+func isHostname(name string) bool {
+	if !strings.Contains(name, ".") {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '*', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// matchDomainConstraint returns true if the name matches the domain
+// constraint. A constraint with a leading dot only matches the subdomains,
+// without it, it matches the domain and, if subdomains is true, its
+// subdomains.
+func matchDomainConstraint(constraint, name string, subdomains bool) bool {
+	constraint = strings.ToLower(constraint)
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case constraint == "":
+		return true
+	case strings.HasPrefix(constraint, "."):
+		return strings.HasSuffix(name, constraint) && len(name) > len(constraint)
+	case name == constraint:
+		return true
+	default:
+		return subdomains && strings.HasSuffix(name, "."+constraint)
+	}
+}
+
+// matchEmailConstraint returns true if the email matches the constraint, a
+// mailbox, a host or a domain with a leading dot.
+func matchEmailConstraint(constraint, email string) bool {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	if strings.Contains(constraint, "@") {
+		j := strings.LastIndex(constraint, "@")
+		return constraint[:j] == email[:i] && strings.EqualFold(constraint[j+1:], email[i+1:])
+	}
+	return matchDomainConstraint(constraint, email[i+1:], false)
+}
+
+// matchURIConstraint returns true if the host of the URI matches the
+// constraint, a host or a domain with a leading dot. URIs without a host or
+// with an IP address do not match.
+func matchURIConstraint(constraint string, u *url.URL) bool {
+	host := u.Hostname()
+	if host == "" || net.ParseIP(host) != nil {
+		return false
+	}
+	return matchDomainConstraint(constraint, host, false)
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+func TestNameConstraints_Apply(t *testing.T) {
+	nc := &NameConstraints{
+		Critical: true,
+		Permitted: &X509Names{
+			DNS: []string{"Example.com.", ".internal"}, IP: []string{"10.0.0.0/8", "192.168.1.1"},
+			Email: []string{"example.com", "root@example.org"}, URI: []string{".example.org"},
+		},
+		Excluded: &X509Names{DNS: []string{"admin.example.com"}, IP: []string{"10.1.0.0/16"}},
+	}
+	cert := new(x509.Certificate)
+	assert.FatalError(t, nc.Apply(cert))
+	assert.True(t, cert.PermittedDNSDomainsCritical)
+	assert.Equals(t, []string{"example.com", ".internal"}, cert.PermittedDNSDomains)
+	assert.Equals(t, []string{"admin.example.com"}, cert.ExcludedDNSDomains)
+	assert.Equals(t, []*net.IPNet{
+		{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+		{IP: net.IP{192, 168, 1, 1}, Mask: net.CIDRMask(32, 32)},
+	}, cert.PermittedIPRanges)
+	assert.Equals(t, []*net.IPNet{{IP: net.IP{10, 1, 0, 0}, Mask: net.CIDRMask(16, 32)}}, cert.ExcludedIPRanges)
+	assert.Equals(t, []string{"example.com", "root@example.org"}, cert.PermittedEmailAddresses)
+	assert.Equals(t, []string{".example.org"}, cert.PermittedURIDomains)
+
+	var nilConstraints *NameConstraints
+	assert.FatalError(t, nilConstraints.Apply(cert))
+	assert.FatalError(t, nilConstraints.Validate())
+
+	tests := []struct {
+		name string
+		nc   *NameConstraints
+	}{
+		{"fail/dns", &NameConstraints{Permitted: &X509Names{DNS: []string{"*.example.com"}}}},
+		{"fail/ip", &NameConstraints{Excluded: &X509Names{IP: []string{"10.0.0.0/33"}}}},
+		{"fail/email", &NameConstraints{Permitted: &X509Names{Email: []string{"root@"}}}},
+		{"fail/uri", &NameConstraints{Excluded: &X509Names{URI: []string{"spiffe://example.org"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.nc.Validate())
+		})
+	}
+}
+
+func TestCheckNameConstraints(t *testing.T) {
+	issuer := new(x509.Certificate)
+	assert.FatalError(t, (&NameConstraints{
+		Permitted: &X509Names{
+			DNS: []string{"example.com", ".internal"}, IP: []string{"10.0.0.0/8"},
+			Email: []string{"example.com", ".example.org"}, URI: []string{"example.org"},
+		},
+		Excluded: &X509Names{DNS: []string{"admin.example.com"}, IP: []string{"10.1.0.0/16"}, Email: []string{"root@example.com"}},
+	}).Apply(issuer))
+	excludeOnly := new(x509.Certificate)
+	assert.FatalError(t, (&NameConstraints{
+		Excluded: &X509Names{DNS: []string{".internal"}},
+	}).Apply(excludeOnly))
+
+	tests := []struct {
+		name    string
+		issuer  *x509.Certificate
+		cert    *x509.Certificate
+		wantErr string
+	}{
+		{"ok/no-constraints", &x509.Certificate{}, &x509.Certificate{DNSNames: []string{"foo.com"}}, ""},
+		{"ok", issuer, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "John Smith"},
+			DNSNames: []string{"example.com", "www.example.com", "db.eu.internal"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			EmailAddresses: []string{"jane@example.com", "jane@eu.example.org"}, URIs: []*url.URL{mustURL(t, "spiffe://example.org/foo")},
+		}, ""},
+		{"ok/cn-san", issuer, &x509.Certificate{Subject: pkix.Name{CommonName: "www.example.com"}, DNSNames: []string{"www.example.com"}}, ""},
+		{"ok/exclude-only", excludeOnly, &x509.Certificate{DNSNames: []string{"foo.com"}, IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}}, ""},
+		{"fail/exclude-only", excludeOnly, &x509.Certificate{DNSNames: []string{"db.internal"}}, "certificate dns name db.internal is excluded by the name constraints of the issuer"},
+		{"fail/dns", issuer, &x509.Certificate{DNSNames: []string{"example.com", "example.net"}}, "certificate dns name example.net is not permitted by the name constraints of the issuer"},
+		{"fail/dns-leading-dot", issuer, &x509.Certificate{DNSNames: []string{"internal"}}, "certificate dns name internal is not permitted by the name constraints of the issuer"},
+		{"fail/dns-excluded", issuer, &x509.Certificate{DNSNames: []string{"Admin.example.com"}}, "certificate dns name Admin.example.com is excluded by the name constraints of the issuer"},
+		{"fail/ip", issuer, &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}}, "certificate ip address 192.168.0.1 is not permitted by the name constraints of the issuer"},
+		{"fail/ip-excluded", issuer, &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, "certificate ip address 10.1.2.3 is excluded by the name constraints of the issuer"},
+		{"fail/email", issuer, &x509.Certificate{EmailAddresses: []string{"jane@eu.example.com"}}, "certificate email address jane@eu.example.com is not permitted by the name constraints of the issuer"},
+		{"fail/email-excluded", issuer, &x509.Certificate{EmailAddresses: []string{"root@EXAMPLE.com"}}, "certificate email address root@EXAMPLE.com is excluded by the name constraints of the issuer"},
+		{"fail/uri", issuer, &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://foo.example.org/bar")}}, "certificate uri spiffe://foo.example.org/bar is not permitted by the name constraints of the issuer"},
+		{"fail/uri-ip", issuer, &x509.Certificate{URIs: []*url.URL{mustURL(t, "https://10.0.0.1/")}}, "certificate uri https://10.0.0.1/ is not permitted by the name constraints of the issuer"},
+		{"fail/cn", issuer, &x509.Certificate{Subject: pkix.Name{CommonName: "www.example.net"}}, "certificate common name www.example.net is not permitted by the name constraints of the issuer"},
+		{"fail/cn-ip", issuer, &x509.Certificate{Subject: pkix.Name{CommonName: "10.1.0.1"}}, "certificate ip address 10.1.0.1 is excluded by the name constraints of the issuer"},
+		{"fail/cn-email", issuer, &x509.Certificate{Subject: pkix.Name{CommonName: "jane@example.net"}}, "certificate email address jane@example.net is not permitted by the name constraints of the issuer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckNameConstraints(tt.issuer, tt.cert)
+			if tt.wantErr == "" {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equals(t, tt.wantErr, err.Error())
+				_, ok := err.(*NameConstraintsError)
+				assert.True(t, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package policy
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Options is the configuration of the names that can be used in the X.509 and
+// SSH certificates signed by the CA or by a provisioner.
+type Options struct {
+	X509 *X509Options `json:"x509,omitempty"`
+	SSH  *SSHOptions  `json:"ssh,omitempty"`
+}
+
+// X509Options are the names allowed and denied in X.509 certificates.
+type X509Options struct {
+	Allow *X509Names `json:"allow,omitempty"`
+	Deny  *X509Names `json:"deny,omitempty"`
+}
+
+// X509Names is a list of rules for each type of name in an X.509 certificate:
+//
+// DNS are domains: "example.com" only matches that name, "*.example.com"
+// matches one label under example.com, and ".example.com" matches any
+// subdomain of example.com.
+//
+// IP are IP addresses or ranges in CIDR notation.
+//
+// Email are email addresses, or domains with the same format as DNS that
+// match the domain of the email addresses.
+//
+// URI are schemes, like "spiffe", or a scheme and a domain with the same
+// format as DNS, like "spiffe://.example.org".
+type X509Names struct {
+	DNS   []string `json:"dns,omitempty"`
+	IP    []string `json:"ip,omitempty"`
+	Email []string `json:"email,omitempty"`
+	URI   []string `json:"uri,omitempty"`
+}
+
+// SSHOptions are the principals allowed and denied in SSH certificates.
+type SSHOptions struct {
+	Allow *SSHNames `json:"allow,omitempty"`
+	Deny  *SSHNames `json:"deny,omitempty"`
+}
+
+// SSHNames is a list of rules for the principals of SSH certificates:
+//
+// User are the principals of user certificates, they can use shell patterns
+// like "ops-*".
+//
+// Host are the principals of host certificates, domains with the same format
+// as the X.509 DNS rules, or IP addresses and ranges.
+type SSHNames struct {
+	User []string `json:"user,omitempty"`
+	Host []string `json:"host,omitempty"`
+}
+
+// NamePolicyError is the error returned when a name is not allowed by a
+// policy.
+type NamePolicyError struct {
+	Type   string
+	Name   string
+	Denied bool
+}
+
+// Error implements the error interface.
+func (e *NamePolicyError) Error() string {
+	if e.Denied {
+		return "certificate " + e.Type + " " + e.Name + " is denied by the policy"
+	}
+	return "certificate " + e.Type + " " + e.Name + " is not allowed by the policy"
+}
+
+// Policy is the compiled representation of the policy options. A nil Policy
+// allows all the names.
+type Policy struct {
+	x509Allow *x509Rules
+	x509Deny  *x509Rules
+	sshAllow  *sshRules
+	sshDeny   *sshRules
+}
+
+// New validates the given options and returns the Policy that implements
+// them. It returns nil if the options are empty.
+func New(o *Options) (*Policy, error) {
+	if o == nil || (o.X509 == nil && o.SSH == nil) {
+		return nil, nil
+	}
+	var err error
+	p := new(Policy)
+	if o.X509 != nil {
+		if p.x509Allow, err = newX509Rules(o.X509.Allow); err != nil {
+			return nil, errors.Wrap(err, "error parsing x509.allow")
+		}
+		if p.x509Deny, err = newX509Rules(o.X509.Deny); err != nil {
+			return nil, errors.Wrap(err, "error parsing x509.deny")
+		}
+	}
+	if o.SSH != nil {
+		if p.sshAllow, err = newSSHRules(o.SSH.Allow); err != nil {
+			return nil, errors.Wrap(err, "error parsing ssh.allow")
+		}
+		if p.sshDeny, err = newSSHRules(o.SSH.Deny); err != nil {
+			return nil, errors.Wrap(err, "error parsing ssh.deny")
+		}
+	}
+	return p, nil
+}
+
+// AllowX509 returns an error if any of the names in the certificate is denied
+// or, if there are allow rules, it's not allowed by them. If any allow rule is
+// configured, all the names must match one. The common name is checked as an
+// IP, email or DNS name unless it's one of the SANs.
+func (p *Policy) AllowX509(cert *x509.Certificate) error {
+	if p == nil || (p.x509Allow == nil && p.x509Deny == nil) {
+		return nil
+	}
+	check := func(typ, name string, match func(r *x509Rules) bool) error {
+		if p.x509Deny != nil && match(p.x509Deny) {
+			return &NamePolicyError{Type: typ, Name: name, Denied: true}
+		}
+		if p.x509Allow != nil && !match(p.x509Allow) {
+			return &NamePolicyError{Type: typ, Name: name}
+		}
+		return nil
+	}
+	// A wildcard name is denied if any of the names it covers is denied.
+	checkDNS := func(typ, name string) error {
+		if p.x509Deny != nil && p.x509Deny.denyDNS(name) {
+			return &NamePolicyError{Type: typ, Name: name, Denied: true}
+		}
+		return check(typ, name, func(r *x509Rules) bool { return r.matchDNS(name) })
+	}
+	checkIP := func(ip net.IP) error {
+		return check("ip address", ip.String(), func(r *x509Rules) bool { return r.matchIP(ip) })
+	}
+	checkEmail := func(email string) error {
+		return check("email address", email, func(r *x509Rules) bool { return r.matchEmail(email) })
+	}
+
+	if cn := cert.Subject.CommonName; cn != "" && !isSAN(cert, cn) {
+		var err error
+		if ip := net.ParseIP(cn); ip != nil {
+			err = checkIP(ip)
+		} else if strings.Contains(cn, "@") {
+			err = checkEmail(cn)
+		} else {
+			err = checkDNS("common name", cn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range cert.DNSNames {
+		if err := checkDNS("dns name", name); err != nil {
+			return err
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if err := checkIP(ip); err != nil {
+			return err
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if err := checkEmail(email); err != nil {
+			return err
+		}
+	}
+	for _, u := range cert.URIs {
+		if err := check("uri", u.String(), func(r *x509Rules) bool { return r.matchURI(u) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowSSH returns an error if any of the principals in the certificate is
+// denied or, if there are allow rules for the type of certificate, it's not
+// allowed by them.
+func (p *Policy) AllowSSH(cert *ssh.Certificate) error {
+	if p == nil || (p.sshAllow == nil && p.sshDeny == nil) {
+		return nil
+	}
+	var match, deny func(r *sshRules, principal string) bool
+	var typ string
+	switch cert.CertType {
+	case ssh.UserCert:
+		typ, match, deny = "user principal", (*sshRules).matchUser, (*sshRules).matchUser
+	case ssh.HostCert:
+		typ, match, deny = "host principal", (*sshRules).matchHost, (*sshRules).denyHost
+	default:
+		return errors.Errorf("unknown ssh certificate type %d", cert.CertType)
+	}
+	for _, principal := range cert.ValidPrincipals {
+		if p.sshDeny != nil && deny(p.sshDeny, principal) {
+			return &NamePolicyError{Type: typ, Name: principal, Denied: true}
+		}
+		if p.sshAllow != nil && p.sshAllow.hasRules(cert.CertType) && !match(p.sshAllow, principal) {
+			return &NamePolicyError{Type: typ, Name: principal}
+		}
+	}
+	return nil
+}
+
+// isSAN returns true if the given name is one of the SANs of the certificate.
+func isSAN(cert *x509.Certificate, name string) bool {
+	for _, s := range cert.DNSNames {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	for _, s := range cert.EmailAddresses {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.String() == name {
+			return true
+		}
+	}
+	for _, u := range cert.URIs {
+		if u.String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+type uriRule struct {
+	scheme string
+	domain string
+}
+
+type x509Rules struct {
+	dns    []string
+	ipNets []*net.IPNet
+	emails []string
+	uris   []uriRule
+}
+
+func newX509Rules(n *X509Names) (*x509Rules, error) {
+	if n == nil || (len(n.DNS) == 0 && len(n.IP) == 0 && len(n.Email) == 0 && len(n.URI) == 0) {
+		return nil, nil
+	}
+	r := new(x509Rules)
+	for _, s := range n.DNS {
+		d, err := normalizeDomain(s)
+		if err != nil {
+			return nil, err
+		}
+		r.dns = append(r.dns, d)
+	}
+	for _, s := range n.IP {
+		ipNet, err := parseIPRange(s)
+		if err != nil {
+			return nil, err
+		}
+		r.ipNets = append(r.ipNets, ipNet)
+	}
+	for _, s := range n.Email {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			if i == 0 || i == len(s)-1 {
+				return nil, errors.Errorf("email %s is not valid", s)
+			}
+			r.emails = append(r.emails, strings.ToLower(s))
+			continue
+		}
+		d, err := normalizeDomain(s)
+		if err != nil {
+			return nil, err
+		}
+		r.emails = append(r.emails, d)
+	}
+	for _, s := range n.URI {
+		var u uriRule
+		if i := strings.Index(s, "://"); i >= 0 {
+			d, err := normalizeDomain(s[i+3:])
+			if err != nil {
+				return nil, err
+			}
+			u.scheme, u.domain = strings.ToLower(s[:i]), d
+		} else {
+			u.scheme = strings.ToLower(s)
+		}
+		if u.scheme == "" {
+			return nil, errors.Errorf("uri %s is not valid", s)
+		}
+		r.uris = append(r.uris, u)
+	}
+	return r, nil
+}
+
+func (r *x509Rules) matchDNS(name string) bool {
+	for _, d := range r.dns {
+		if matchDomain(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *x509Rules) denyDNS(name string) bool {
+	for _, d := range r.dns {
+		if denyDomain(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *x509Rules) matchIP(ip net.IP) bool {
+	for _, ipNet := range r.ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *x509Rules) matchEmail(email string) bool {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	for _, e := range r.emails {
+		if strings.Contains(e, "@") {
+			if strings.EqualFold(e, email) {
+				return true
+			}
+		} else if matchDomain(e, email[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *x509Rules) matchURI(u *url.URL) bool {
+	for _, rule := range r.uris {
+		if !strings.EqualFold(rule.scheme, u.Scheme) {
+			continue
+		}
+		if rule.domain == "" || matchDomain(rule.domain, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+type sshRules struct {
+	users  []string
+	hosts  []string
+	ipNets []*net.IPNet
+}
+
+func newSSHRules(n *SSHNames) (*sshRules, error) {
+	if n == nil || (len(n.User) == 0 && len(n.Host) == 0) {
+		return nil, nil
+	}
+	r := new(sshRules)
+	for _, s := range n.User {
+		if _, err := path.Match(s, ""); err != nil || s == "" {
+			return nil, errors.Errorf("user principal %s is not valid", s)
+		}
+		r.users = append(r.users, s)
+	}
+	for _, s := range n.Host {
+		if ipNet, err := parseIPRange(s); err == nil {
+			r.ipNets = append(r.ipNets, ipNet)
+			continue
+		}
+		d, err := normalizeDomain(s)
+		if err != nil {
+			return nil, err
+		}
+		r.hosts = append(r.hosts, d)
+	}
+	return r, nil
+}
+
+func (r *sshRules) hasRules(certType uint32) bool {
+	if certType == ssh.UserCert {
+		return len(r.users) > 0
+	}
+	return len(r.hosts) > 0 || len(r.ipNets) > 0
+}
+
+func (r *sshRules) matchUser(principal string) bool {
+	for _, pattern := range r.users {
+		if ok, _ := path.Match(pattern, principal); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sshRules) matchHost(principal string) bool {
+	if ip := net.ParseIP(principal); ip != nil {
+		for _, ipNet := range r.ipNets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range r.hosts {
+		if matchDomain(d, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sshRules) denyHost(principal string) bool {
+	if net.ParseIP(principal) != nil {
+		return r.matchHost(principal)
+	}
+	for _, d := range r.hosts {
+		if denyDomain(d, principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomain validates a domain rule and returns it in lower case and
+// without the trailing dot.
+func normalizeDomain(s string) (string, error) {
+	d := strings.ToLower(strings.TrimSuffix(s, "."))
+	labels := strings.Split(strings.TrimPrefix(d, "."), ".")
+	for i, l := range labels {
+		switch {
+		case l == "*" && i == 0 && !strings.HasPrefix(d, "."):
+		case l == "" || strings.Contains(l, "*"):
+			return "", errors.Errorf("domain %s is not valid", s)
+		}
+	}
+	return d, nil
+}
+
+// matchDomain returns true if the name matches the given domain rule.
+func matchDomain(rule, name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case strings.HasPrefix(rule, "."):
+		return strings.HasSuffix(name, rule) && len(name) > len(rule)
+	case strings.HasPrefix(rule, "*."):
+		i := strings.Index(name, ".")
+		return i > 0 && name[i:] == rule[1:]
+	default:
+		return name == rule
+	}
+}
+
+// denyDomain returns true if the name matches the given deny rule. Unlike
+// matchDomain, a wildcard name matches if the rule matches any of the names
+// under the wildcard domain, so *.example.com is denied by
+// secret.example.com, .example.com, .internal.example.com or *.example.com.
+func denyDomain(rule, name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if !strings.HasPrefix(name, "*.") {
+		return matchDomain(rule, name)
+	}
+	base := name[1:]
+	switch {
+	case strings.HasPrefix(rule, "."):
+		return strings.HasSuffix(base, rule) || strings.HasSuffix(rule, base)
+	case strings.HasPrefix(rule, "*."):
+		return strings.HasSuffix(rule[1:], base)
+	default:
+		return strings.HasSuffix(rule, base)
+	}
+}
+
+// parseIPRange parses an IP address or a range in CIDR notation.
+func parseIPRange(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Errorf("ip range %s is not valid", s)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Errorf("ip address %s is not valid", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
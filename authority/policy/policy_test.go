@@ -0,0 +1,240 @@
+package policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func mustURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	assert.FatalError(t, err)
+	return u
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		wantNil bool
+		wantErr bool
+	}{
+		{"ok/nil", nil, true, false},
+		{"ok/empty", &Options{}, true, false},
+		{"ok/x509", &Options{X509: &X509Options{
+			Allow: &X509Names{DNS: []string{"example.com", "*.example.com", ".internal."}, IP: []string{"10.0.0.0/8", "::1"},
+				Email: []string{"example.com", "root@example.org"}, URI: []string{"spiffe://.example.org", "urn"}},
+			Deny: &X509Names{DNS: []string{"admin.example.com"}},
+		}}, false, false},
+		{"ok/ssh", &Options{SSH: &SSHOptions{
+			Allow: &SSHNames{User: []string{"ops-*", "alice"}, Host: []string{".internal", "10.0.0.0/8", "192.168.1.1"}},
+			Deny:  &SSHNames{User: []string{"root"}},
+		}}, false, false},
+		{"fail/dns", &Options{X509: &X509Options{Allow: &X509Names{DNS: []string{"foo.*.com"}}}}, false, true},
+		{"fail/dns-empty", &Options{X509: &X509Options{Deny: &X509Names{DNS: []string{"foo..com"}}}}, false, true},
+		{"fail/dns-wildcard", &Options{X509: &X509Options{Allow: &X509Names{DNS: []string{".*.example.com"}}}}, false, true},
+		{"fail/ip", &Options{X509: &X509Options{Allow: &X509Names{IP: []string{"10.0.0.0/33"}}}}, false, true},
+		{"fail/ip-address", &Options{X509: &X509Options{Allow: &X509Names{IP: []string{"foo"}}}}, false, true},
+		{"fail/email", &Options{X509: &X509Options{Deny: &X509Names{Email: []string{"foo@"}}}}, false, true},
+		{"fail/email-domain", &Options{X509: &X509Options{Deny: &X509Names{Email: []string{"*foo.com"}}}}, false, true},
+		{"fail/uri", &Options{X509: &X509Options{Allow: &X509Names{URI: []string{"://example.com"}}}}, false, true},
+		{"fail/uri-domain", &Options{X509: &X509Options{Allow: &X509Names{URI: []string{"https://foo..com"}}}}, false, true},
+		{"fail/ssh-user", &Options{SSH: &SSHOptions{Allow: &SSHNames{User: []string{"ops-["}}}}, false, true},
+		{"fail/ssh-user-empty", &Options{SSH: &SSHOptions{Deny: &SSHNames{User: []string{""}}}}, false, true},
+		{"fail/ssh-host", &Options{SSH: &SSHOptions{Deny: &SSHNames{Host: []string{"foo..com"}}}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equals(t, tt.wantNil, got == nil)
+			}
+		})
+	}
+}
+
+func Test_matchDomain(t *testing.T) {
+	tests := []struct {
+		rule string
+		name string
+		want bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "foo.example.com", false},
+		{"*.example.com", "foo.example.com", true},
+		{"*.example.com", "*.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "foo.bar.example.com", false},
+		{"*.example.com", ".example.com", false},
+		{".example.com", "foo.example.com", true},
+		{".example.com", "foo.bar.example.com", true},
+		{".example.com", "example.com", false},
+		{".example.com", "fooexample.com", false},
+		{".example.com", ".example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule+"/"+tt.name, func(t *testing.T) {
+			if got := matchDomain(tt.rule, tt.name); got != tt.want {
+				t.Errorf("matchDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_denyDomain(t *testing.T) {
+	tests := []struct {
+		rule string
+		name string
+		want bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "*.example.com", false},
+		{"secret.example.com", "*.example.com", true},
+		{"secret.example.com", "*.EXAMPLE.com.", true},
+		{"db.secret.example.com", "*.example.com", true},
+		{"secret.example.org", "*.example.com", false},
+		{"secret.fooexample.com", "*.example.com", false},
+		{"secret.example.com", "foo.example.com", false},
+		{".example.com", "*.example.com", true},
+		{".internal.example.com", "*.example.com", true},
+		{".com", "*.example.com", true},
+		{".example.org", "*.example.com", false},
+		{".fooexample.com", "*.example.com", false},
+		{".sub.example.com", "*.other.example.com", false},
+		{"*.example.com", "*.example.com", true},
+		{"*.sub.example.com", "*.example.com", true},
+		{"*.example.com", "*.sub.example.com", false},
+		{"*.example.org", "*.example.com", false},
+		{"*.example.com", "foo.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule+"/"+tt.name, func(t *testing.T) {
+			if got := denyDomain(tt.rule, tt.name); got != tt.want {
+				t.Errorf("denyDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_AllowX509(t *testing.T) {
+	allowAll, err := New(&Options{SSH: &SSHOptions{Deny: &SSHNames{User: []string{"root"}}}})
+	assert.FatalError(t, err)
+	denyOnly, err := New(&Options{X509: &X509Options{
+		Deny: &X509Names{DNS: []string{".internal"}, IP: []string{"10.0.0.0/8"}, Email: []string{"root@example.com"}, URI: []string{"https"}},
+	}})
+	assert.FatalError(t, err)
+	allowDeny, err := New(&Options{X509: &X509Options{
+		Allow: &X509Names{DNS: []string{"*.example.com", ".internal"}, IP: []string{"192.168.0.0/16"}, Email: []string{"example.com"}, URI: []string{"spiffe://example.org"}},
+		Deny:  &X509Names{DNS: []string{"admin.example.com"}, Email: []string{"root@example.com"}},
+	}})
+	assert.FatalError(t, err)
+	denyWildcard, err := New(&Options{X509: &X509Options{
+		Deny: &X509Names{DNS: []string{"secret.example.com", ".internal.example.org", "*.example.net"}},
+	}})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		cert    *x509.Certificate
+		wantErr string
+	}{
+		{"ok/nil", nil, &x509.Certificate{DNSNames: []string{"foo.com"}}, ""},
+		{"ok/no-x509", allowAll, &x509.Certificate{DNSNames: []string{"foo.com"}}, ""},
+		{"ok/deny-only", denyOnly, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "John Smith"},
+			DNSNames: []string{"foo.com"}, IPAddresses: []net.IP{net.ParseIP("192.168.1.1")},
+			EmailAddresses: []string{"jane@example.com"}, URIs: []*url.URL{mustURL(t, "spiffe://example.org/foo")},
+		}, ""},
+		{"fail/deny-only/dns", denyOnly, &x509.Certificate{DNSNames: []string{"foo.com", "db.internal"}}, "certificate dns name db.internal is denied by the policy"},
+		{"fail/deny-only/ip", denyOnly, &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, "certificate ip address 10.1.2.3 is denied by the policy"},
+		{"fail/deny-only/email", denyOnly, &x509.Certificate{EmailAddresses: []string{"ROOT@example.com"}}, "certificate email address ROOT@example.com is denied by the policy"},
+		{"fail/deny-only/uri", denyOnly, &x509.Certificate{URIs: []*url.URL{mustURL(t, "https://example.com")}}, "certificate uri https://example.com is denied by the policy"},
+		{"fail/deny-only/cn", denyOnly, &x509.Certificate{Subject: pkix.Name{CommonName: "db.internal"}}, "certificate common name db.internal is denied by the policy"},
+		{"fail/deny-only/cn-ip", denyOnly, &x509.Certificate{Subject: pkix.Name{CommonName: "10.0.0.1"}}, "certificate ip address 10.0.0.1 is denied by the policy"},
+		{"ok/allow", allowDeny, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "www.example.com"},
+			DNSNames: []string{"www.example.com", "db.eu.internal"}, IPAddresses: []net.IP{net.ParseIP("192.168.1.1")},
+			EmailAddresses: []string{"jane@example.com"}, URIs: []*url.URL{mustURL(t, "spiffe://example.org/foo")},
+		}, ""},
+		{"ok/allow/cn-email", allowDeny, &x509.Certificate{Subject: pkix.Name{CommonName: "jane@example.com"}}, ""},
+		{"fail/allow/dns", allowDeny, &x509.Certificate{DNSNames: []string{"www.example.com", "example.com"}}, "certificate dns name example.com is not allowed by the policy"},
+		{"fail/allow/dns-denied", allowDeny, &x509.Certificate{DNSNames: []string{"admin.example.com"}}, "certificate dns name admin.example.com is denied by the policy"},
+		{"fail/allow/ip", allowDeny, &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}, "certificate ip address 10.0.0.1 is not allowed by the policy"},
+		{"fail/allow/email", allowDeny, &x509.Certificate{EmailAddresses: []string{"jane@example.org"}}, "certificate email address jane@example.org is not allowed by the policy"},
+		{"fail/allow/email-denied", allowDeny, &x509.Certificate{EmailAddresses: []string{"root@example.com"}}, "certificate email address root@example.com is denied by the policy"},
+		{"fail/allow/uri", allowDeny, &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://foo.example.org/bar")}}, "certificate uri spiffe://foo.example.org/bar is not allowed by the policy"},
+		{"fail/allow/wildcard-denied", allowDeny, &x509.Certificate{DNSNames: []string{"*.example.com"}}, "certificate dns name *.example.com is denied by the policy"},
+		{"ok/wildcard", denyWildcard, &x509.Certificate{DNSNames: []string{"*.foo.example.com", "*.example.io", "*.foo.example.net"}}, ""},
+		{"fail/wildcard/exact", denyWildcard, &x509.Certificate{DNSNames: []string{"*.example.com"}}, "certificate dns name *.example.com is denied by the policy"},
+		{"fail/wildcard/leading-dot", denyWildcard, &x509.Certificate{DNSNames: []string{"*.example.org"}}, "certificate dns name *.example.org is denied by the policy"},
+		{"fail/wildcard/leading-dot-sub", denyWildcard, &x509.Certificate{DNSNames: []string{"*.eu.internal.example.org"}}, "certificate dns name *.eu.internal.example.org is denied by the policy"},
+		{"fail/wildcard/wildcard", denyWildcard, &x509.Certificate{DNSNames: []string{"*.example.net"}}, "certificate dns name *.example.net is denied by the policy"},
+		{"fail/wildcard/cn", denyWildcard, &x509.Certificate{Subject: pkix.Name{CommonName: "*.example.com"}}, "certificate common name *.example.com is denied by the policy"},
+		{"fail/allow/cn", allowDeny, &x509.Certificate{Subject: pkix.Name{CommonName: "John Smith"}}, "certificate common name John Smith is not allowed by the policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.AllowX509(tt.cert)
+			if tt.wantErr == "" {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equals(t, tt.wantErr, err.Error())
+				_, ok := err.(*NamePolicyError)
+				assert.True(t, ok)
+			}
+		})
+	}
+}
+
+func TestPolicy_AllowSSH(t *testing.T) {
+	allowAll, err := New(&Options{X509: &X509Options{Deny: &X509Names{DNS: []string{"example.com"}}}})
+	assert.FatalError(t, err)
+	p, err := New(&Options{SSH: &SSHOptions{
+		Allow: &SSHNames{User: []string{"ops-*", "alice"}, Host: []string{".internal", "10.0.0.0/8"}},
+		Deny:  &SSHNames{User: []string{"ops-root"}, Host: []string{"bastion.internal"}},
+	}})
+	assert.FatalError(t, err)
+	denyOnly, err := New(&Options{SSH: &SSHOptions{Deny: &SSHNames{User: []string{"root"}}}})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		cert    *ssh.Certificate
+		wantErr string
+	}{
+		{"ok/nil", nil, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}}, ""},
+		{"ok/no-ssh", allowAll, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}}, ""},
+		{"ok/user", p, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"alice", "ops-deploy"}}, ""},
+		{"ok/host", p, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"db.eu.internal", "10.1.2.3"}}, ""},
+		{"ok/deny-only", denyOnly, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"root"}}, ""},
+		{"fail/user", p, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"alice", "bob"}}, "certificate user principal bob is not allowed by the policy"},
+		{"fail/user-denied", p, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"ops-root"}}, "certificate user principal ops-root is denied by the policy"},
+		{"fail/host", p, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"db.example.com"}}, "certificate host principal db.example.com is not allowed by the policy"},
+		{"fail/host-ip", p, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"192.168.1.1"}}, "certificate host principal 192.168.1.1 is not allowed by the policy"},
+		{"fail/host-wildcard-denied", p, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"*.internal"}}, "certificate host principal *.internal is denied by the policy"},
+		{"fail/host-denied", p, &ssh.Certificate{CertType: ssh.HostCert, ValidPrincipals: []string{"bastion.internal"}}, "certificate host principal bastion.internal is denied by the policy"},
+		{"fail/deny-only", denyOnly, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}}, "certificate user principal root is denied by the policy"},
+		{"fail/type", p, &ssh.Certificate{CertType: 3, ValidPrincipals: []string{"alice"}}, "unknown ssh certificate type 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.AllowSSH(tt.cert)
+			if tt.wantErr == "" {
+				assert.FatalError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Equals(t, tt.wantErr, err.Error())
+			}
+		})
+	}
+}
@@ -3,19 +3,200 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
 )
 
+// ACMEAttestationFormat is the format of the attestation statement used in the
+// ACME device-attest-01 challenge.
+type ACMEAttestationFormat string
+
+const (
+	// AppleAttestationFormat is the format used by Apple devices using the
+	// Managed Device Attestation.
+	AppleAttestationFormat ACMEAttestationFormat = "apple"
+	// TPMAttestationFormat is the format used by TPM 2.0 devices using an
+	// attestation key certificate.
+	TPMAttestationFormat ACMEAttestationFormat = "tpm"
+)
+
+// Validate returns an error if the attestation format is not supported.
+func (f ACMEAttestationFormat) Validate() error {
+	switch f {
+	case AppleAttestationFormat, TPMAttestationFormat:
+		return nil
+	default:
+		return errors.Errorf("acme attestation format %q is not supported", f)
+	}
+}
+
+// ACMEDNSOptions are the options used to look up the TXT records in the ACME
+// dns-01 challenge.
+type ACMEDNSOptions struct {
+	// Resolvers is the list of DNS servers, in the form host or host:port,
+	// used to look up the records. If empty the system resolver is used.
+	Resolvers []string `json:"resolvers,omitempty"`
+	// RequireDNSSEC requires the responses to be authenticated, the AD flag
+	// set, by the resolvers. The resolvers must validate DNSSEC, and the path
+	// to them must be trusted.
+	RequireDNSSEC bool `json:"requireDNSSEC,omitempty"`
+}
+
+// Validate validates the dns options, nil is ok.
+func (o *ACMEDNSOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Resolvers {
+		if s == "" {
+			return errors.New("dns.resolvers cannot contain an empty value")
+		}
+		// Addresses without a port, including IPv6 ones, use the port 53.
+		if net.ParseIP(s) != nil || !strings.Contains(s, ":") {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(s); err != nil {
+			return errors.Wrapf(err, "error parsing dns.resolvers %s", s)
+		} else if _, err := strconv.Atoi(port); err != nil {
+			return errors.Errorf("error parsing dns.resolvers %s: invalid port", s)
+		}
+	}
+	if o.RequireDNSSEC && len(o.Resolvers) == 0 {
+		return errors.New("dns.resolvers cannot be empty if dns.requireDNSSEC is set")
+	}
+	return nil
+}
+
+// ACMEMaintenanceWindow is a planned period of time in which the CA might not
+// be available to renew certificates.
+type ACMEMaintenanceWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ACMERenewalInfoOptions are the options used to compute the renewal windows
+// returned by the ACME renewalInfo resource.
+type ACMERenewalInfoOptions struct {
+	// ExplanationURL is the URL of a page explaining the suggested windows,
+	// it's returned with all the windows that are not the default one.
+	ExplanationURL string `json:"explanationURL,omitempty"`
+	// Maintenance is the list of planned maintenance windows. The suggested
+	// renewal windows are moved earlier to avoid them.
+	Maintenance []ACMEMaintenanceWindow `json:"maintenance,omitempty"`
+}
+
+// Validate validates the renewal info options, nil is ok.
+func (o *ACMERenewalInfoOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.ExplanationURL != "" {
+		if u, err := url.Parse(o.ExplanationURL); err != nil || !u.IsAbs() {
+			return errors.Errorf("renewalInfo.explanationURL %s is not a valid absolute url", o.ExplanationURL)
+		}
+	}
+	for i, m := range o.Maintenance {
+		if m.Start.IsZero() || m.End.IsZero() {
+			return errors.Errorf("renewalInfo.maintenance[%d] start and end cannot be empty", i)
+		}
+		if !m.End.After(m.Start) {
+			return errors.Errorf("renewalInfo.maintenance[%d] end must be after start", i)
+		}
+	}
+	return nil
+}
+
+// ACMERateLimits are the rate limits applied to the ACME accounts of a
+// provisioner. A zero value disables the limit.
+type ACMERateLimits struct {
+	// OrdersPerAccountPerHour is the maximum number of orders that an account
+	// can create in an hour.
+	OrdersPerAccountPerHour int `json:"ordersPerAccountPerHour,omitempty"`
+	// PendingAuthorizationsPerAccount is the maximum number of pending
+	// authorizations that an account can have.
+	PendingAuthorizationsPerAccount int `json:"pendingAuthorizationsPerAccount,omitempty"`
+	// CertificatesPerDomainPerWeek is the maximum number of certificates that
+	// can be issued for a registered domain, e.g. example.com, in a week.
+	CertificatesPerDomainPerWeek int `json:"certificatesPerDomainPerWeek,omitempty"`
+}
+
+// Validate validates the rate limits, nil is ok.
+func (l *ACMERateLimits) Validate() error {
+	switch {
+	case l == nil:
+		return nil
+	case l.OrdersPerAccountPerHour < 0:
+		return errors.New("rateLimits.ordersPerAccountPerHour cannot be negative")
+	case l.PendingAuthorizationsPerAccount < 0:
+		return errors.New("rateLimits.pendingAuthorizationsPerAccount cannot be negative")
+	case l.CertificatesPerDomainPerWeek < 0:
+		return errors.New("rateLimits.certificatesPerDomainPerWeek cannot be negative")
+	default:
+		return nil
+	}
+}
+
+// ACMEExternalAccount is the external account bound to an ACME account using
+// an External Account Binding (EAB).
+type ACMEExternalAccount struct {
+	KeyID     string `json:"keyID"`
+	Reference string `json:"reference,omitempty"`
+}
+
+type acmeExternalAccountKey struct{}
+
+// NewContextWithACMEExternalAccount creates a new context from ctx and attaches
+// the external account bound to the ACME account requesting the certificate.
+func NewContextWithACMEExternalAccount(ctx context.Context, ea *ACMEExternalAccount) context.Context {
+	return context.WithValue(ctx, acmeExternalAccountKey{}, ea)
+}
+
+// ACMEExternalAccountFromContext returns the external account saved in ctx.
+// Returns nil if the given context has no external account associated with it.
+func ACMEExternalAccountFromContext(ctx context.Context) *ACMEExternalAccount {
+	ea, _ := ctx.Value(acmeExternalAccountKey{}).(*ACMEExternalAccount)
+	return ea
+}
+
 // ACME is the acme provisioner type, an entity that can authorize the ACME
 // provisioning flow.
 type ACME struct {
 	*base
-	Type    string  `json:"type"`
-	Name    string  `json:"name"`
-	Claims  *Claims `json:"claims,omitempty"`
-	claimer *Claimer
+	Type    string              `json:"type"`
+	Name    string              `json:"name"`
+	Claims  *Claims             `json:"claims,omitempty"`
+	Options *CertificateOptions `json:"options,omitempty"`
+	// RequireEAB makes the External Account Binding mandatory when creating
+	// new ACME accounts.
+	RequireEAB bool `json:"requireEAB,omitempty"`
+	// DNS are the options used to validate the dns-01 challenge.
+	DNS *ACMEDNSOptions `json:"dns,omitempty"`
+	// RenewalInfo are the options used to compute the renewal windows of the
+	// ACME Renewal Information (ARI) extension.
+	RenewalInfo *ACMERenewalInfoOptions `json:"renewalInfo,omitempty"`
+	// RateLimits are the limits applied to the orders and certificates of the
+	// ACME accounts.
+	RateLimits *ACMERateLimits `json:"rateLimits,omitempty"`
+	// AllowedIPRanges is the list of CIDR ranges of the IP addresses allowed in
+	// ACME ip identifiers. If empty the ip identifiers are disabled.
+	AllowedIPRanges []string `json:"allowedIPRanges,omitempty"`
+	// AttestationFormats is the list of attestation formats accepted in the
+	// device-attest-01 challenge. If empty the challenge is disabled.
+	AttestationFormats []ACMEAttestationFormat `json:"attestationFormats,omitempty"`
+	// AttestationRoots is the path of a file with the PEM encoded root
+	// certificates used to verify the attestation certificates.
+	AttestationRoots string `json:"attestationRoots,omitempty"`
+	claimer          *Claimer
+	allowedIPNets    []*net.IPNet
+	attestationRoots *x509.CertPool
 }
 
 // GetID returns the provisioner unique identifier.
@@ -52,26 +233,127 @@ func (p *ACME) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	}
 
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
 
+	if err := p.DNS.Validate(); err != nil {
+		return err
+	}
+	if err := p.RenewalInfo.Validate(); err != nil {
+		return err
+	}
+	if err := p.RateLimits.Validate(); err != nil {
+		return err
+	}
+
+	// Initialize the allowed ip ranges
+	p.allowedIPNets = nil
+	for _, s := range p.AllowedIPRanges {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing allowedIPRanges %s", s)
+		}
+		p.allowedIPNets = append(p.allowedIPNets, ipNet)
+	}
+
+	// Initialize the device attestation
+	for _, f := range p.AttestationFormats {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(p.AttestationFormats) > 0 {
+		if p.AttestationRoots == "" {
+			return errors.New("provisioner attestationRoots cannot be empty if attestationFormats is set")
+		}
+		if p.attestationRoots, err = x509util.ReadCertPool(p.AttestationRoots); err != nil {
+			return errors.Wrap(err, "error reading attestationRoots")
+		}
+	}
+
 	return err
 }
 
+// IsExternalAccountBindingRequired returns true if new ACME accounts must be
+// bound to an external account.
+func (p *ACME) IsExternalAccountBindingRequired() bool {
+	return p.RequireEAB
+}
+
+// GetDNSOptions returns the options used to validate the dns-01 challenge.
+func (p *ACME) GetDNSOptions() *ACMEDNSOptions {
+	return p.DNS
+}
+
+// GetRenewalInfoOptions returns the options used to compute the renewal
+// windows of the certificates.
+func (p *ACME) GetRenewalInfoOptions() *ACMERenewalInfoOptions {
+	return p.RenewalInfo
+}
+
+// GetRateLimits returns the rate limits applied to the ACME accounts.
+func (p *ACME) GetRateLimits() *ACMERateLimits {
+	return p.RateLimits
+}
+
+// IsIPAllowed returns true if the given IP address is in one of the allowed
+// ranges of the ACME ip identifiers.
+func (p *ACME) IsIPAllowed(ip net.IP) bool {
+	for _, ipNet := range p.allowedIPNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAttestationFormatEnabled returns true if the given attestation format is
+// allowed in the device-attest-01 challenge.
+func (p *ACME) IsAttestationFormatEnabled(format ACMEAttestationFormat) bool {
+	for _, f := range p.AttestationFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAttestationRoots returns the pool of root certificates used to verify the
+// attestation certificates in the device-attest-01 challenge.
+func (p *ACME) GetAttestationRoots() *x509.CertPool {
+	return p.attestationRoots
+}
+
 // AuthorizeSign does not do any validation, because all validation is handled
 // in the ACME protocol. This method returns a list of modifiers / constraints
 // on the resulting certificate.
 func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	return []SignOption{
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("acme.AuthorizeSign; issuance is disabled for acme provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	// Attribute the certificate to the external account if the ACME account
+	// is bound to one.
+	extOption := newProvisionerExtensionOption(TypeACME, p.Name, "")
+	if ea := ACMEExternalAccountFromContext(ctx); ea != nil {
+		extOption = newProvisionerExtensionOption(TypeACME, p.Name, ea.KeyID, "ExternalAccountReference", ea.Reference)
+	}
+	return withX509CertificateOptions(p.Options, []SignOption{
 		// modifiers / withOptions
-		newProvisionerExtensionOption(TypeACME, p.Name, ""),
+		extOption,
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newSANDurationValidator(p.claimer),
+	}, newTemplateData(token, "", nil)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -80,7 +362,14 @@ func (p *ACME) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 // certificate was configured to allow renewals.
 func (p *ACME) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("acme.AuthorizeRenew; renew is disabled for acme provisioner %s", p.GetID())
+		return errs.Unauthorized("acme.AuthorizeRenew; renew is disabled for acme provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "acme.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "acme.AuthorizeRenew")
 	}
 	return nil
 }
@@ -3,6 +3,7 @@ package provisioner
 import (
 	"context"
 	"crypto/x509"
+	"net"
 	"net/http"
 	"testing"
 	"time"
@@ -64,11 +65,136 @@ func TestACME_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-bad-attestation-format": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{"foo"}, AttestationRoots: "testdata/certs/root_ca.crt"},
+				err: errors.New("acme attestation format \"foo\" is not supported"),
+			}
+		},
+		"fail-empty-attestation-roots": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{"apple"}},
+				err: errors.New("provisioner attestationRoots cannot be empty if attestationFormats is set"),
+			}
+		},
+		"fail-bad-attestation-roots": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{"apple"}, AttestationRoots: "testdata/certs/missing.crt"},
+				err: errors.New("error reading attestationRoots: open testdata/certs/missing.crt failed: no such file or directory"),
+			}
+		},
+		"fail-empty-dns-resolver": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", DNS: &ACMEDNSOptions{Resolvers: []string{"8.8.8.8", ""}}},
+				err: errors.New("dns.resolvers cannot contain an empty value"),
+			}
+		},
+		"fail-bad-dns-resolver": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", DNS: &ACMEDNSOptions{Resolvers: []string{"[::1"}}},
+				err: errors.New("error parsing dns.resolvers [::1: address [::1: missing ']' in address"),
+			}
+		},
+		"fail-bad-dns-resolver-port": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", DNS: &ACMEDNSOptions{Resolvers: []string{"dns.internal:domain"}}},
+				err: errors.New("error parsing dns.resolvers dns.internal:domain: invalid port"),
+			}
+		},
+		"fail-dnssec-without-resolvers": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", DNS: &ACMEDNSOptions{RequireDNSSEC: true}},
+				err: errors.New("dns.resolvers cannot be empty if dns.requireDNSSEC is set"),
+			}
+		},
+		"ok-dns": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", DNS: &ACMEDNSOptions{
+					Resolvers:     []string{"8.8.8.8", "dns.internal:5353", "2001:4860:4860::8888", "[::1]:53"},
+					RequireDNSSEC: true,
+				}},
+			}
+		},
+		"fail-bad-renewal-info-url": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RenewalInfo: &ACMERenewalInfoOptions{ExplanationURL: "/incidents"}},
+				err: errors.New("renewalInfo.explanationURL /incidents is not a valid absolute url"),
+			}
+		},
+		"fail-empty-maintenance": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", RenewalInfo: &ACMERenewalInfoOptions{
+					Maintenance: []ACMEMaintenanceWindow{{Start: time.Now()}},
+				}},
+				err: errors.New("renewalInfo.maintenance[0] start and end cannot be empty"),
+			}
+		},
+		"fail-bad-maintenance": func(t *testing.T) ProvisionerValidateTest {
+			now := time.Now()
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", RenewalInfo: &ACMERenewalInfoOptions{
+					Maintenance: []ACMEMaintenanceWindow{{Start: now, End: now}},
+				}},
+				err: errors.New("renewalInfo.maintenance[0] end must be after start"),
+			}
+		},
+		"fail-negative-orders-rate-limit": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{OrdersPerAccountPerHour: -1}},
+				err: errors.New("rateLimits.ordersPerAccountPerHour cannot be negative"),
+			}
+		},
+		"fail-negative-authorizations-rate-limit": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{PendingAuthorizationsPerAccount: -1}},
+				err: errors.New("rateLimits.pendingAuthorizationsPerAccount cannot be negative"),
+			}
+		},
+		"fail-negative-certificates-rate-limit": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{CertificatesPerDomainPerWeek: -1}},
+				err: errors.New("rateLimits.certificatesPerDomainPerWeek cannot be negative"),
+			}
+		},
+		"fail-bad-ip-range": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &ACME{Name: "foo", Type: "bar", AllowedIPRanges: []string{"10.0.0.0"}},
+				err: errors.New("error parsing allowedIPRanges 10.0.0.0: invalid CIDR address: 10.0.0.0"),
+			}
+		},
+		"ok-ip-ranges": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", AllowedIPRanges: []string{"10.0.0.0/8", "fd00::/8"}},
+			}
+		},
+		"ok-rate-limits": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", RateLimits: &ACMERateLimits{
+					OrdersPerAccountPerHour:         300,
+					PendingAuthorizationsPerAccount: 300,
+					CertificatesPerDomainPerWeek:    50,
+				}},
+			}
+		},
+		"ok-renewal-info": func(t *testing.T) ProvisionerValidateTest {
+			now := time.Now()
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", RenewalInfo: &ACMERenewalInfoOptions{
+					ExplanationURL: "https://status.smallstep.com/maintenance",
+					Maintenance:    []ACMEMaintenanceWindow{{Start: now, End: now.Add(time.Hour)}},
+				}},
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &ACME{Name: "foo", Type: "bar"},
 			}
 		},
+		"ok-attestation": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p: &ACME{Name: "foo", Type: "bar", AttestationFormats: []ACMEAttestationFormat{"apple", "tpm"}, AttestationRoots: "testdata/certs/root_ca.crt"},
+			}
+		},
 	}
 
 	config := Config{
@@ -90,6 +216,27 @@ func TestACME_Init(t *testing.T) {
 	}
 }
 
+func TestACME_IsIPAllowed(t *testing.T) {
+	p := &ACME{Name: "foo", Type: "bar", AllowedIPRanges: []string{"10.0.0.0/8", "fd00::/8"}}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	tests := map[string]struct {
+		ip   string
+		want bool
+	}{
+		"ok/ipv4":       {"10.1.2.3", true},
+		"ok/ipv6":       {"fd00::1", true},
+		"fail/ipv4":     {"192.168.1.1", false},
+		"fail/ipv6":     {"2001:db8::1", false},
+		"fail/loopback": {"127.0.0.1", false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equals(t, tc.want, p.IsIPAllowed(net.ParseIP(tc.ip)))
+		})
+	}
+	assert.False(t, (&ACME{}).IsIPAllowed(net.ParseIP("10.1.2.3")))
+}
+
 func TestACME_AuthorizeRenew(t *testing.T) {
 	type test struct {
 		p    *ACME
@@ -108,7 +255,7 @@ func TestACME_AuthorizeRenew(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: generateRenewCertificate(),
 				code: http.StatusUnauthorized,
 				err:  errors.Errorf("acme.AuthorizeRenew; renew is disabled for acme provisioner %s", p.GetID()),
 			}
@@ -118,7 +265,7 @@ func TestACME_AuthorizeRenew(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: generateRenewCertificate(),
 			}
 		},
 	}
@@ -142,9 +289,11 @@ func TestACME_AuthorizeRenew(t *testing.T) {
 func TestACME_AuthorizeSign(t *testing.T) {
 	type test struct {
 		p     *ACME
+		ctx   context.Context
 		token string
 		code  int
 		err   error
+		ea    *ACMEExternalAccount
 	}
 	tests := map[string]func(*testing.T) test{
 		"ok": func(t *testing.T) test {
@@ -152,14 +301,26 @@ func TestACME_AuthorizeSign(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:     p,
+				ctx:   context.Background(),
+				token: "foo",
+			}
+		},
+		"ok/external-account": func(t *testing.T) test {
+			p, err := generateACME()
+			assert.FatalError(t, err)
+			ea := &ACMEExternalAccount{KeyID: "kid", Reference: "ref"}
+			return test{
+				p:     p,
+				ctx:   NewContextWithACMEExternalAccount(context.Background(), ea),
 				token: "foo",
+				ea:    ea,
 			}
 		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if opts, err := tc.p.AuthorizeSign(context.Background(), tc.token); err != nil {
+			if opts, err := tc.p.AuthorizeSign(tc.ctx, tc.token); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -168,20 +329,31 @@ func TestACME_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) && assert.NotNil(t, opts) {
-					assert.Len(t, 4, opts)
+					assert.Len(t, 7, opts)
 					for _, o := range opts {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
 							assert.Equals(t, v.Type, int(TypeACME))
 							assert.Equals(t, v.Name, tc.p.GetName())
-							assert.Equals(t, v.CredentialID, "")
-							assert.Len(t, 0, v.KeyValuePairs)
+							if tc.ea == nil {
+								assert.Equals(t, v.CredentialID, "")
+								assert.Len(t, 0, v.KeyValuePairs)
+							} else {
+								assert.Equals(t, v.CredentialID, tc.ea.KeyID)
+								assert.Equals(t, v.KeyValuePairs, []string{"ExternalAccountReference", tc.ea.Reference})
+							}
 						case profileDefaultDuration:
 							assert.Equals(t, time.Duration(v), tc.p.claimer.DefaultTLSCertDuration())
+						case backdateOption:
+							assert.Equals(t, time.Duration(v), tc.p.claimer.Backdate())
 						case defaultPublicKeyValidator:
 						case *validityValidator:
 							assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+						case *sanDurationValidator:
+							assert.Equals(t, v.claimer, tc.p.claimer)
+						case x509ProfileValidator:
+							assert.Len(t, 0, v)
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
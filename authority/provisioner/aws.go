@@ -60,6 +60,9 @@ type awsConfig struct {
 	signatureURL       string
 	certificate        *x509.Certificate
 	signatureAlgorithm x509.SignatureAlgorithm
+	stsURL             string
+	ec2URL             string
+	credentials        func(withInstanceRole bool) (*awsCredentials, error)
 }
 
 func newAWSConfig() (*awsConfig, error) {
@@ -84,11 +87,13 @@ type awsPayload struct {
 	Amazon   awsAmazonPayload `json:"amazon"`
 	SANs     []string         `json:"sans"`
 	document awsInstanceIdentityDocument
+	identity *awsCallerIdentity
 }
 
 type awsAmazonPayload struct {
-	Document  []byte `json:"document"`
-	Signature []byte `json:"signature"`
+	Document  []byte         `json:"document,omitempty"`
+	Signature []byte         `json:"signature,omitempty"`
+	STS       *awsSTSRequest `json:"sts,omitempty"`
 }
 
 type awsInstanceIdentityDocument struct {
@@ -121,20 +126,44 @@ type awsInstanceIdentityDocument struct {
 // If InstanceAge is set, only the instances with a pendingTime within the given
 // period will be accepted.
 //
+// If EnableSTS is true, tokens with a signed sts:GetCallerIdentity request are
+// also accepted, this allows to get certificates without an instance identity
+// document, e.g. in AWS Lambda or AWS Fargate. IAMRoles constrains the role of
+// the caller and requires these tokens.
+//
+// If Tags or VPCIDs are set, the instance of the identity document must have
+// those tags and be in one of those VPCs. They are read using the
+// ec2:DescribeInstances API with the credentials of the CA.
+//
+// If SSHHostPrincipals is set, the principals of the SSH host certificates are
+// restricted to the given instance metadata fields, and they are used as the
+// default principals. The supported fields are privateIP, privateDNS,
+// instanceID, publicIP and publicDNS; the public ones are read using the
+// ec2:DescribeInstances API. Tokens without an instance identity document
+// cannot get SSH host certificates if this option is set.
+//
 // Amazon Identity docs are available at
 // https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instance-identity-documents.html
 type AWS struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	Accounts               []string `json:"accounts"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
+	Type                   string              `json:"type"`
+	Name                   string              `json:"name"`
+	Accounts               []string            `json:"accounts"`
+	DisableCustomSANs      bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse bool                `json:"disableTrustOnFirstUse"`
+	InstanceAge            Duration            `json:"instanceAge,omitempty"`
+	EnableSTS              bool                `json:"enableSTS,omitempty"`
+	IAMRoles               []string            `json:"iamRoles,omitempty"`
+	Tags                   map[string]string   `json:"tags,omitempty"`
+	VPCIDs                 []string            `json:"vpcIDs,omitempty"`
+	SSHHostPrincipals      []string            `json:"sshHostPrincipals,omitempty"`
+	Claims                 *Claims             `json:"claims,omitempty"`
+	Options                *CertificateOptions `json:"options,omitempty"`
+	HTTPClient             *HTTPClientOptions  `json:"httpClient,omitempty"`
 	claimer                *Claimer
 	config                 *awsConfig
 	audiences              Audiences
+	client                 *http.Client
 }
 
 // GetID returns the provisioner unique identifier.
@@ -142,19 +171,46 @@ func (p *AWS) GetID() string {
 	return "aws/" + p.Name
 }
 
-// GetTokenID returns the identifier of the token.
+// GetTokenID returns the identifier of the token, the SHA256 of the token, so
+// it cannot be reused. The instance of the identity document is recorded
+// separately after the token is authorized, see GetInstanceID.
 func (p *AWS) GetTokenID(token string) (string, error) {
 	payload, err := p.authorizeToken(token)
 	if err != nil {
 		return "", err
 	}
-	// If TOFU is disabled create an ID for the token, so it cannot be reused.
+	// Tokens with a sts:GetCallerIdentity request use the hash of the request
+	// signature, so they cannot be reused.
+	if sts := payload.Amazon.STS; sts != nil {
+		sum := sha256.Sum256([]byte(sts.Headers.Get("Authorization")))
+		return strings.ToLower(hex.EncodeToString(sum[:])), nil
+	}
 	// The timestamps, document and signatures should be mostly unique.
+	return tokenKey(token), nil
+}
+
+// GetInstanceID returns the key used to record the instance of the identity
+// document, the SHA256 of "provisioner_id.instance_id". The id of the token
+// cannot be used for Trust On First Use (TOFU) because it is set by the
+// client. Tokens with a sts:GetCallerIdentity request do not identify an
+// instance.
+func (p *AWS) GetInstanceID(token string) (string, bool) {
 	if p.DisableTrustOnFirstUse {
-		sum := sha256.Sum256([]byte(token))
-		return strings.ToLower(hex.EncodeToString(sum[:])), nil
+		return "", false
+	}
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", false
+	}
+	var payload awsPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&payload); err != nil || payload.Amazon.STS != nil {
+		return "", false
+	}
+	var doc awsInstanceIdentityDocument
+	if err := json.Unmarshal(payload.Amazon.Document, &doc); err != nil || doc.InstanceID == "" {
+		return "", false
 	}
-	return payload.ID, nil
+	return instanceKey(p.GetID(), doc.InstanceID), true
 }
 
 // GetName returns the name of the provisioner.
@@ -173,13 +229,21 @@ func (p *AWS) GetEncryptedKey() (kid string, key string, ok bool) {
 }
 
 // GetIdentityToken retrieves the identity document and it's signature and
-// generates a token with them.
+// generates a token with them. If sts tokens are enabled and the AWS
+// credentials are in the environment, it generates a token with a signed
+// sts:GetCallerIdentity request instead.
 func (p *AWS) GetIdentityToken(subject, caURL string) (string, error) {
 	// Initialize the config if this method is used from the cli.
 	if err := p.assertConfig(); err != nil {
 		return "", err
 	}
 
+	if p.EnableSTS {
+		if creds, err := p.getCredentials(false); err == nil {
+			return p.getSTSToken(subject, caURL, creds)
+		}
+	}
+
 	var idoc awsInstanceIdentityDocument
 	doc, err := p.readURL(p.config.identityURL)
 	if err != nil {
@@ -254,6 +318,27 @@ func (p *AWS) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	case p.InstanceAge.Value() < 0:
 		return errors.New("provisioner instanceAge cannot be negative")
+	case len(p.IAMRoles) > 0 && !p.EnableSTS:
+		return errors.New("provisioner iamRoles requires enableSTS")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	for _, r := range p.IAMRoles {
+		if r == "" {
+			return errors.New("provisioner iamRoles cannot contain empty values")
+		}
+	}
+	for k := range p.Tags {
+		if k == "" {
+			return errors.New("provisioner tags cannot contain empty keys")
+		}
+	}
+	if err := validateSSHHostPrincipals(p.SSHHostPrincipals, sshPrincipalPrivateIP, sshPrincipalPrivateDNS,
+		sshPrincipalInstanceID, sshPrincipalPublicIP, sshPrincipalPublicDNS); err != nil {
+		return err
 	}
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
@@ -263,6 +348,10 @@ func (p *AWS) Init(config Config) (err error) {
 	if p.config, err = newAWSConfig(); err != nil {
 		return err
 	}
+	// Create the client used to get the identity document
+	if p.client, err = newHTTPClient(p.HTTPClient.withDefaultProxy(config.Proxy)); err != nil {
+		return err
+	}
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
 }
@@ -270,10 +359,42 @@ func (p *AWS) Init(config Config) (err error) {
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("aws.AuthorizeSign; issuance is disabled for aws provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	payload, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSign")
 	}
+	if err := p.verifyIdentity(ctx, payload); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSign")
+	}
+
+	// Tokens with a sts:GetCallerIdentity request only allow the caller ARN as
+	// the subject if custom SANs are disabled.
+	if id := payload.identity; id != nil {
+		var so []SignOption
+		if p.DisableCustomSANs {
+			so = append(so,
+				dnsNamesValidator(nil),
+				emailAddressesValidator(nil),
+				ipAddressesValidator(nil),
+				urisValidator(nil),
+			)
+		}
+		return withX509CertificateOptions(p.Options, append(so,
+			// modifiers / withOptions
+			newProvisionerExtensionOption(TypeAWS, p.Name, id.Account, "ARN", id.Arn),
+			profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+			backdateOption(p.claimer.Backdate()),
+			// validators
+			defaultPublicKeyValidator{},
+			commonNameValidator(payload.Claims.Subject),
+			newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+			newSANDurationValidator(p.claimer),
+		), newTemplateData(token, payload.Claims.Subject, nil)), nil
+	}
 
 	doc := payload.document
 	// Enforce known CN and default DNS and IP if configured.
@@ -282,22 +403,24 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 	var so []SignOption
 	if p.DisableCustomSANs {
 		so = append(so, dnsNamesValidator([]string{
-			fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region),
+			awsPrivateDNS(doc),
 		}))
 		so = append(so, ipAddressesValidator([]net.IP{
 			net.ParseIP(doc.PrivateIP),
 		}))
 	}
 
-	return append(so,
+	return withX509CertificateOptions(p.Options, append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAWS, p.Name, doc.AccountID, "InstanceID", doc.InstanceID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		defaultPublicKeyValidator{},
 		commonNameValidator(payload.Claims.Subject),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	), nil
+		newSANDurationValidator(p.claimer),
+	), newTemplateData(token, payload.Claims.Subject, nil)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -306,11 +429,33 @@ func (p *AWS) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 // certificate was configured to allow renewals.
 func (p *AWS) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("aws.AuthorizeRenew; renew is disabled for aws provisioner %s", p.GetID())
+		return errs.Unauthorized("aws.AuthorizeRenew; renew is disabled for aws provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "aws.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "aws.AuthorizeRenew")
 	}
 	return nil
 }
 
+// verifyIdentity validates the caller of a token with a sts:GetCallerIdentity
+// request, or the instance of an identity document, using the AWS APIs.
+func (p *AWS) verifyIdentity(ctx context.Context, payload *awsPayload) error {
+	if payload.Amazon.STS != nil {
+		return p.verifyCaller(ctx, payload)
+	}
+	return p.verifyInstance(ctx, payload.document)
+}
+
+// awsPrivateDNS returns the internal DNS name of the instance in the identity
+// document.
+func awsPrivateDNS(doc awsInstanceIdentityDocument) string {
+	return fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region)
+}
+
 // assertConfig initializes the config if it has not been initialized
 func (p *AWS) assertConfig() (err error) {
 	if p.config != nil {
@@ -332,11 +477,18 @@ func (p *AWS) checkSignature(signed, signature []byte) error {
 // using pkg/errors to avoid verbose errors, the caller should use it and write
 // the appropriate error.
 func (p *AWS) readURL(url string) ([]byte, error) {
-	r, err := http.Get(url)
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	r, err := client.Get(url)
 	if err != nil {
 		return nil, err
 	}
 	defer r.Body.Close()
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: status=%d", url, r.StatusCode)
+	}
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return nil, err
@@ -361,6 +513,14 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "aws.authorizeToken; error unmarshaling claims")
 	}
 
+	// Tokens with a sts:GetCallerIdentity request
+	if unsafeClaims.Amazon.STS != nil {
+		return p.authorizeSTSToken(jwt, &unsafeClaims)
+	}
+	if len(p.IAMRoles) > 0 {
+		return nil, errs.Unauthorized("aws.authorizeToken; iamRoles require a token with a sts request")
+	}
+
 	var payload awsPayload
 	if err := jwt.Claims(unsafeClaims.Amazon.Signature, &payload); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "aws.authorizeToken; error verifying claims")
@@ -393,7 +553,7 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 	if err = payload.ValidateWithLeeway(jose.Expected{
 		Issuer: awsIssuer,
 		Time:   now,
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "aws.authorizeToken; invalid aws token")
 	}
 
@@ -406,7 +566,7 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 	if p.DisableCustomSANs {
 		if payload.Subject != doc.InstanceID &&
 			payload.Subject != doc.PrivateIP &&
-			payload.Subject != fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region) {
+			payload.Subject != awsPrivateDNS(doc) {
 			return nil, errs.Unauthorized("aws.authorizeToken; invalid token - invalid subject claim (sub)")
 		}
 	}
@@ -426,10 +586,8 @@ func (p *AWS) authorizeToken(token string) (*awsPayload, error) {
 	}
 
 	// validate instance age
-	if d := p.InstanceAge.Value(); d > 0 {
-		if now.Sub(doc.PendingTime) > d {
-			return nil, errs.Unauthorized("aws.authorizeToken; aws identity document pendingTime is too old")
-		}
+	if isInstanceTooOld(p.InstanceAge, doc.PendingTime, now) {
+		return nil, errs.Unauthorized("aws.authorizeToken; aws identity document pendingTime is too old")
 	}
 
 	payload.document = doc
@@ -441,24 +599,45 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("aws.AuthorizeSSHSign; ssh ca is disabled for aws provisioner %s", p.GetID())
 	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("aws.AuthorizeSSHSign; issuance is disabled for aws provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	claims, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
 	}
+	if err := p.verifyIdentity(ctx, claims); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
+	}
 
 	doc := claims.document
 
-	signOptions := []SignOption{
-		// set the key id to the instance id
-		sshCertKeyIDModifier(doc.InstanceID),
-	}
-
-	// Only enforce known principals if disable custom sans is true.
+	var signOptions []SignOption
 	var principals []string
-	if p.DisableCustomSANs {
-		principals = []string{
-			doc.PrivateIP,
-			fmt.Sprintf("ip-%s.%s.compute.internal", strings.Replace(doc.PrivateIP, ".", "-", -1), doc.Region),
+	switch id := claims.identity; {
+	case id != nil && len(p.SSHHostPrincipals) > 0:
+		return nil, errs.Unauthorized("aws.AuthorizeSSHSign; ssh certificates require an aws instance identity document")
+	case id != nil:
+		// set the key id to the caller ARN, and only enforce it as principal
+		// if disable custom sans is true.
+		signOptions = append(signOptions, sshCertKeyIDModifier(id.Arn))
+		if p.DisableCustomSANs {
+			principals = []string{id.Arn}
+		}
+	case len(p.SSHHostPrincipals) > 0:
+		// set the key id to the instance id, and enforce the principals in
+		// the configured metadata fields.
+		signOptions = append(signOptions, sshCertKeyIDModifier(doc.InstanceID))
+		if principals, err = p.sshHostPrincipals(ctx, doc); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.AuthorizeSSHSign")
+		}
+	default:
+		// set the key id to the instance id, and only enforce known principals
+		// if disable custom sans is true.
+		signOptions = append(signOptions, sshCertKeyIDModifier(doc.InstanceID))
+		if p.DisableCustomSANs {
+			principals = []string{doc.PrivateIP, awsPrivateDNS(doc)}
 		}
 	}
 
@@ -473,16 +652,17 @@ func (p *AWS) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	return withSSHCertificateOptions(p.Options, append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
+		backdateOption(p.claimer.Backdate()),
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	), newTemplateData(token, claims.Subject, principals)), nil
 }
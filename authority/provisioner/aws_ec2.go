@@ -0,0 +1,155 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// awsEC2URL is the url of the regional Amazon EC2 API, the %s is replaced by
+// the region of the instance.
+const awsEC2URL = "https://ec2.%s.amazonaws.com/"
+
+// awsEC2Instance contains the attributes of an EC2 instance used to
+// constrain the certificates.
+type awsEC2Instance struct {
+	InstanceID string `xml:"instanceId"`
+	VPCID      string `xml:"vpcId"`
+	DNSName    string `xml:"dnsName"`
+	IPAddress  string `xml:"ipAddress"`
+	Tags       []struct {
+		Key   string `xml:"key"`
+		Value string `xml:"value"`
+	} `xml:"tagSet>item"`
+}
+
+// describeInstance returns the attributes of the instance in the identity
+// document using the ec2:DescribeInstances API with the credentials of the
+// CA.
+func (p *AWS) describeInstance(ctx context.Context, doc awsInstanceIdentityDocument) (*awsEC2Instance, error) {
+	creds, err := p.getCredentials(true)
+	if err != nil {
+		return nil, err
+	}
+
+	ec2URL := p.config.ec2URL
+	if ec2URL == "" {
+		ec2URL = awsEC2URL
+	}
+	ec2URL = strings.Replace(ec2URL, "%s", doc.Region, 1)
+	if strings.HasPrefix(doc.Region, "cn-") {
+		ec2URL = strings.Replace(ec2URL, ".amazonaws.com/", ".amazonaws.com.cn/", 1)
+	}
+	query := url.Values{
+		"Action":       {"DescribeInstances"},
+		"Version":      {"2016-11-15"},
+		"InstanceId.1": {doc.InstanceID},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", ec2URL+"?"+query.Encode(), http.NoBody)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", ec2URL)
+	}
+	signAWSRequest(req, nil, creds, doc.Region, "ec2", time.Now())
+
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", ec2URL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, errors.Errorf("error describing aws instance: status=%d", resp.StatusCode)
+	}
+
+	var v struct {
+		Instances []awsEC2Instance `xml:"reservationSet>item>instancesSet>item"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "error decoding aws instance")
+	}
+	for i := range v.Instances {
+		if v.Instances[i].InstanceID == doc.InstanceID {
+			return &v.Instances[i], nil
+		}
+	}
+	return nil, errors.Errorf("error describing aws instance: instance %s not found", doc.InstanceID)
+}
+
+// verifyInstance validates the VPC and tags of the instance in the identity
+// document if the provisioner has any of those constraints.
+func (p *AWS) verifyInstance(ctx context.Context, doc awsInstanceIdentityDocument) error {
+	if len(p.Tags) == 0 && len(p.VPCIDs) == 0 {
+		return nil
+	}
+
+	instance, err := p.describeInstance(ctx, doc)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "aws.verifyInstance; error describing aws instance")
+	}
+
+	if len(p.VPCIDs) > 0 && !containsAllMembers(p.VPCIDs, []string{instance.VPCID}) {
+		return errs.Unauthorized("aws.verifyInstance; aws instance vpcId is not valid")
+	}
+
+	tags := make(map[string]string, len(instance.Tags))
+	for _, t := range instance.Tags {
+		tags[t.Key] = t.Value
+	}
+	for k, pattern := range p.Tags {
+		if v, ok := tags[k]; !ok || !matchesAnyAWSPattern([]string{pattern}, v) {
+			return errs.Unauthorized("aws.verifyInstance; aws instance tag %s is not valid", k)
+		}
+	}
+	return nil
+}
+
+// sshHostPrincipals returns the principals of the SSH host certificates of the
+// instance in the identity document, the values of the metadata fields in
+// SSHHostPrincipals. The public fields are read using the
+// ec2:DescribeInstances API and skipped if the instance does not have them.
+func (p *AWS) sshHostPrincipals(ctx context.Context, doc awsInstanceIdentityDocument) ([]string, error) {
+	var instance *awsEC2Instance
+	principals := make([]string, 0, len(p.SSHHostPrincipals))
+	for _, field := range p.SSHHostPrincipals {
+		var v string
+		switch field {
+		case sshPrincipalPrivateIP:
+			v = doc.PrivateIP
+		case sshPrincipalPrivateDNS:
+			v = awsPrivateDNS(doc)
+		case sshPrincipalInstanceID:
+			v = doc.InstanceID
+		case sshPrincipalPublicIP, sshPrincipalPublicDNS:
+			if instance == nil {
+				var err error
+				if instance, err = p.describeInstance(ctx, doc); err != nil {
+					return nil, errs.Wrap(http.StatusInternalServerError, err, "aws.sshHostPrincipals; error describing aws instance")
+				}
+			}
+			if field == sshPrincipalPublicIP {
+				v = instance.IPAddress
+			} else {
+				v = instance.DNSName
+			}
+		}
+		if v != "" {
+			principals = append(principals, v)
+		}
+	}
+	if len(principals) == 0 {
+		return nil, errs.Unauthorized("aws.sshHostPrincipals; aws instance %s does not have any of the sshHostPrincipals", doc.InstanceID)
+	}
+	return principals, nil
+}
@@ -0,0 +1,219 @@
+package provisioner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+const awsTestDescribeInstances = `<?xml version="1.0" encoding="UTF-8"?>
+<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+    <requestId>8f7724cf-496f-496e-8fe3-example</requestId>
+    <reservationSet>
+        <item>
+            <reservationId>r-1234567890abcdef0</reservationId>
+            <instancesSet>
+                <item>
+                    <instanceId>instance-id</instanceId>
+                    <vpcId>vpc-1a2b3c4d</vpcId>
+                    <dnsName>ec2-54-1-2-3.us-west-1.compute.amazonaws.com</dnsName>
+                    <ipAddress>54.1.2.3</ipAddress>
+                    <tagSet>
+                        <item>
+                            <key>env</key>
+                            <value>prod</value>
+                        </item>
+                        <item>
+                            <key>team</key>
+                            <value>platform-sre</value>
+                        </item>
+                    </tagSet>
+                </item>
+            </instancesSet>
+        </item>
+    </reservationSet>
+</DescribeInstancesResponse>`
+
+func generateEC2Server(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ca-key/"):
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case r.URL.Path != "/us-west-1/" || q.Get("Action") != "DescribeInstances":
+			http.Error(w, "bad request", http.StatusBadRequest)
+		case q.Get("InstanceId.1") == "instance-id":
+			w.Write([]byte(awsTestDescribeInstances))
+		case q.Get("InstanceId.1") == "private-id":
+			w.Write([]byte(`<DescribeInstancesResponse><reservationSet><item><instancesSet><item><instanceId>private-id</instanceId></item></instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+		default:
+			w.Write([]byte(`<DescribeInstancesResponse><reservationSet/></DescribeInstancesResponse>`))
+		}
+	}))
+}
+
+func TestAWS_verifyInstance(t *testing.T) {
+	srv := generateEC2Server(t)
+	defer srv.Close()
+
+	newProvisioner := func(tags map[string]string, vpcIDs []string, key string) *AWS {
+		p, err := generateAWS()
+		assert.FatalError(t, err)
+		p.Tags = tags
+		p.VPCIDs = vpcIDs
+		p.config.ec2URL = srv.URL + "/%s/"
+		p.config.credentials = func(withInstanceRole bool) (*awsCredentials, error) {
+			assert.True(t, withInstanceRole)
+			return &awsCredentials{AccessKeyID: key, SecretAccessKey: "secret"}, nil
+		}
+		return p
+	}
+	doc := awsInstanceIdentityDocument{InstanceID: "instance-id", Region: "us-west-1"}
+
+	tests := []struct {
+		name string
+		p    *AWS
+		doc  awsInstanceIdentityDocument
+		code int
+	}{
+		{"ok no constraints", newProvisioner(nil, nil, "bad-key"), doc, 0},
+		{"ok tags", newProvisioner(map[string]string{"env": "prod", "team": "platform-*"}, nil, "ca-key"), doc, 0},
+		{"ok vpc", newProvisioner(nil, []string{"vpc-00000000", "vpc-1a2b3c4d"}, "ca-key"), doc, 0},
+		{"ok tags and vpc", newProvisioner(map[string]string{"env": "*"}, []string{"vpc-1a2b3c4d"}, "ca-key"), doc, 0},
+		{"fail tag value", newProvisioner(map[string]string{"env": "dev"}, nil, "ca-key"), doc, http.StatusUnauthorized},
+		{"fail tag missing", newProvisioner(map[string]string{"owner": "*"}, nil, "ca-key"), doc, http.StatusUnauthorized},
+		{"fail vpc", newProvisioner(nil, []string{"vpc-00000000"}, "ca-key"), doc, http.StatusUnauthorized},
+		{"fail instance", newProvisioner(nil, []string{"vpc-1a2b3c4d"}, "ca-key"), awsInstanceIdentityDocument{InstanceID: "other-id", Region: "us-west-1"}, http.StatusInternalServerError},
+		{"fail credentials", newProvisioner(nil, []string{"vpc-1a2b3c4d"}, "bad-key"), doc, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.verifyInstance(context.Background(), tt.doc)
+			if tt.code == 0 {
+				assert.FatalError(t, err)
+				return
+			}
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, tt.code, sc.StatusCode())
+		})
+	}
+}
+
+func TestAWS_AuthorizeSign_instanceConstraints(t *testing.T) {
+	ec2Srv := generateEC2Server(t)
+	defer ec2Srv.Close()
+
+	p, srv, err := generateAWSWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+	p.config.ec2URL = ec2Srv.URL + "/%s/"
+	p.config.credentials = func(bool) (*awsCredentials, error) {
+		return &awsCredentials{AccessKeyID: "ca-key", SecretAccessKey: "secret"}, nil
+	}
+
+	token, err := p.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
+	p.Tags = map[string]string{"env": "prod"}
+	_, err = p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+	_, err = p.AuthorizeSSHSign(context.Background(), token)
+	assert.FatalError(t, err)
+
+	p.Tags = map[string]string{"env": "dev"}
+	_, err = p.AuthorizeSign(context.Background(), token)
+	assert.Error(t, err)
+	_, err = p.AuthorizeSSHSign(context.Background(), token)
+	assert.Error(t, err)
+
+	// IAM roles require sts tokens
+	p.Tags = nil
+	p.EnableSTS = true
+	p.IAMRoles = []string{"*"}
+	_, err = p.AuthorizeSign(context.Background(), token)
+	assert.Error(t, err)
+}
+
+func TestAWS_Init_constraints(t *testing.T) {
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+	tests := []struct {
+		name      string
+		enableSTS bool
+		iamRoles  []string
+		tags      map[string]string
+		wantErr   bool
+	}{
+		{"ok sts", true, nil, nil, false},
+		{"ok iamRoles", true, []string{"arn:aws:iam::123456789012:role/*"}, nil, false},
+		{"ok tags", false, nil, map[string]string{"env": "prod"}, false},
+		{"fail iamRoles without sts", false, []string{"arn:aws:iam::123456789012:role/*"}, nil, true},
+		{"fail empty iamRole", true, []string{""}, nil, true},
+		{"fail empty tag", false, nil, map[string]string{"": "prod"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &AWS{
+				Type:      "AWS",
+				Name:      "name",
+				EnableSTS: tt.enableSTS,
+				IAMRoles:  tt.iamRoles,
+				Tags:      tt.tags,
+			}
+			if err := p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("AWS.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAWS_sshHostPrincipals(t *testing.T) {
+	srv := generateEC2Server(t)
+	defer srv.Close()
+
+	newProvisioner := func(fields ...string) *AWS {
+		p, err := generateAWS()
+		assert.FatalError(t, err)
+		p.SSHHostPrincipals = fields
+		p.config.ec2URL = srv.URL + "/%s/"
+		p.config.credentials = func(bool) (*awsCredentials, error) {
+			return &awsCredentials{AccessKeyID: "ca-key", SecretAccessKey: "secret"}, nil
+		}
+		return p
+	}
+	doc := awsInstanceIdentityDocument{InstanceID: "instance-id", PrivateIP: "10.0.0.1", Region: "us-west-1"}
+
+	tests := []struct {
+		name string
+		p    *AWS
+		doc  awsInstanceIdentityDocument
+		want []string
+		code int
+	}{
+		{"ok private", newProvisioner("privateIP", "privateDNS", "instanceID"), doc, []string{"10.0.0.1", "ip-10-0-0-1.us-west-1.compute.internal", "instance-id"}, 0},
+		{"ok public", newProvisioner("publicDNS", "publicIP"), doc, []string{"ec2-54-1-2-3.us-west-1.compute.amazonaws.com", "54.1.2.3"}, 0},
+		{"ok no public address", newProvisioner("publicIP", "privateIP"), awsInstanceIdentityDocument{InstanceID: "private-id", PrivateIP: "10.0.0.1", Region: "us-west-1"}, []string{"10.0.0.1"}, 0},
+		{"fail no principals", newProvisioner("publicIP", "publicDNS"), awsInstanceIdentityDocument{InstanceID: "private-id", Region: "us-west-1"}, nil, http.StatusUnauthorized},
+		{"fail describe", newProvisioner("publicIP"), awsInstanceIdentityDocument{InstanceID: "other-id", Region: "us-west-1"}, nil, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.sshHostPrincipals(context.Background(), tt.doc)
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package provisioner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// awsContainerCredentialsURL is the url used to retrieve the credentials of
+// the task role in Amazon ECS and AWS Fargate.
+const awsContainerCredentialsURL = "http://169.254.170.2"
+
+// awsRoleCredentialsURL is the url used to retrieve the credentials of the
+// instance role in Amazon EC2.
+const awsRoleCredentialsURL = "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+
+// awsCredentials are the AWS credentials used to sign AWS API requests.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// getCredentials returns the AWS credentials in the environment, the ones of
+// the ECS task role, or, if withInstanceRole is true, the ones of the EC2
+// instance role.
+func (p *AWS) getCredentials(withInstanceRole bool) (*awsCredentials, error) {
+	if p.config.credentials != nil {
+		return p.config.credentials(withInstanceRole)
+	}
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: secret,
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return p.readCredentials(awsContainerCredentialsURL + uri)
+	}
+	if withInstanceRole {
+		b, err := p.readURL(awsRoleCredentialsURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "error retrieving aws instance role")
+		}
+		role := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+		if role == "" {
+			return nil, errors.New("error retrieving aws instance role: role not found")
+		}
+		return p.readCredentials(awsRoleCredentialsURL + url.PathEscape(role))
+	}
+	return nil, errors.New("aws credentials not found")
+}
+
+// readCredentials reads the AWS credentials from the given url.
+func (p *AWS) readCredentials(uri string) (*awsCredentials, error) {
+	b, err := p.readURL(uri)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving aws credentials")
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling aws credentials")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("error retrieving aws credentials: credentials not found")
+	}
+	return &creds, nil
+}
+
+// signAWSRequest signs the request with the given body using the AWS
+// Signature Version 4. All the headers already in the request are signed.
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region, service string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	// Canonical headers
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); k != "authorization" {
+			values := make([]string, len(v))
+			for i := range v {
+				values[i] = strings.Join(strings.Fields(v[i]), " ")
+			}
+			headers[k] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Canonical request
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	bodySum := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodySum[:]),
+	}, "\n")
+
+	// String to sign and signature
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, s := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = awsHMAC(key, s)
+	}
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsCanonicalQuery returns the query string sorted and encoded as required
+// by the AWS Signature Version 4.
+func awsCanonicalQuery(values url.Values) string {
+	escape := func(s string) string {
+		return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+	}
+	escaped := make(map[string][]string, len(values))
+	keys := make([]string, 0, len(values))
+	for k, vs := range values {
+		ek := escape(k)
+		keys = append(keys, ek)
+		for _, v := range vs {
+			escaped[ek] = append(escaped[ek], escape(v))
+		}
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		sort.Strings(escaped[k])
+		for _, v := range escaped[k] {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsHMAC(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// awsSignedHeaders returns the list of signed headers in the Authorization
+// header of a request signed with the AWS Signature Version 4.
+func awsSignedHeaders(authorization string) []string {
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+		if part = strings.TrimSpace(part); strings.HasPrefix(part, "SignedHeaders=") {
+			return strings.Split(strings.TrimPrefix(part, "SignedHeaders="), ";")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package provisioner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func Test_signAWSRequest(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation.
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", http.NoBody)
+	assert.FatalError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := &awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signAWSRequest(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equals(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equals(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+	assert.Equals(t, []string{"content-type", "host", "x-amz-date"}, awsSignedHeaders(req.Header.Get("Authorization")))
+
+	// Session tokens are signed
+	creds.Token = "session-token"
+	signAWSRequest(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equals(t, "session-token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Equals(t, []string{"content-type", "host", "x-amz-date", "x-amz-security-token"}, awsSignedHeaders(req.Header.Get("Authorization")))
+}
+
+func Test_awsCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		values url.Values
+		want   string
+	}{
+		{"empty", url.Values{}, ""},
+		{"sorted", url.Values{"b": {"2"}, "a": {"1"}, "aa": {"3"}}, "a=1&aa=3&b=2"},
+		{"multiple", url.Values{"a": {"2", "1"}}, "a=1&a=2"},
+		{"escaped", url.Values{"a b": {"c d~/"}}, "a%20b=c%20d~%2F"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := awsCanonicalQuery(tt.values); got != tt.want {
+				t.Errorf("awsCanonicalQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWS_getCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/task":
+			w.Write([]byte(`{"AccessKeyId":"task-id","SecretAccessKey":"task-secret","Token":"task-token","Expiration":"2030-01-01T00:00:00Z"}`))
+		case "/empty":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+
+	p, err := generateAWS()
+	assert.FatalError(t, err)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "env-id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	os.Setenv("AWS_SESSION_TOKEN", "env-token")
+	creds, err := p.getCredentials(false)
+	assert.FatalError(t, err)
+	assert.Equals(t, &awsCredentials{AccessKeyID: "env-id", SecretAccessKey: "env-secret", Token: "env-token"}, creds)
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	os.Unsetenv("AWS_SESSION_TOKEN")
+
+	// The container credentials url is fixed, so the test reads it through a
+	// proxy.
+	p.client = &http.Client{Transport: &http.Transport{Proxy: func(*http.Request) (*url.URL, error) {
+		return url.Parse(srv.URL)
+	}}}
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/task")
+	creds, err = p.getCredentials(false)
+	assert.FatalError(t, err)
+	assert.Equals(t, &awsCredentials{AccessKeyID: "task-id", SecretAccessKey: "task-secret", Token: "task-token"}, creds)
+
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/empty")
+	_, err = p.getCredentials(false)
+	assert.Error(t, err)
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/missing")
+	_, err = p.getCredentials(false)
+	assert.Error(t, err)
+	os.Unsetenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+
+	_, err = p.getCredentials(false)
+	assert.Error(t, err)
+
+	// Injected credentials
+	want := &awsCredentials{AccessKeyID: "id", SecretAccessKey: "secret"}
+	p.config.credentials = func(withInstanceRole bool) (*awsCredentials, error) {
+		assert.True(t, withInstanceRole)
+		return want, nil
+	}
+	creds, err = p.getCredentials(true)
+	assert.FatalError(t, err)
+	assert.True(t, reflect.DeepEqual(want, creds))
+}
@@ -0,0 +1,294 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// awsSTSIssuer is the string used as issuer in the tokens with a signed
+// sts:GetCallerIdentity request.
+const awsSTSIssuer = "sts.amazonaws.com"
+
+// awsSTSBody is the body of the sts:GetCallerIdentity request.
+const awsSTSBody = "Action=GetCallerIdentity&Version=2011-06-15"
+
+// awsSTSAudienceHeader is the signed header that binds a sts:GetCallerIdentity
+// request to the audience of a CA provisioner.
+const awsSTSAudienceHeader = "X-Step-Ca-Audience"
+
+// awsSTSMaxAge is the maximum age of a signed sts:GetCallerIdentity request,
+// AWS rejects older requests.
+const awsSTSMaxAge = 15 * time.Minute
+
+// awsSTSURLRegexp matches the global and regional STS endpoints.
+var awsSTSURLRegexp = regexp.MustCompile(`^https://sts(\.[a-z0-9-]+)?\.amazonaws\.com(\.cn)?/$`)
+
+// awsSTSRequest is a sts:GetCallerIdentity request signed by the client, the
+// CA sends it to AWS to get the identity of the caller.
+type awsSTSRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// awsCallerIdentity is the result of a sts:GetCallerIdentity request.
+type awsCallerIdentity struct {
+	Account string `json:"Account"`
+	Arn     string `json:"Arn"`
+	UserID  string `json:"UserId"`
+}
+
+// roleARN returns the ARN of the IAM role of an assumed role session, or the
+// caller ARN for other identities.
+func (c *awsCallerIdentity) roleARN() string {
+	// arn:aws:sts::123456789012:assumed-role/role-name/session-name
+	parts := strings.SplitN(c.Arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return c.Arn
+	}
+	resource := strings.Split(parts[5], "/")
+	return "arn:" + parts[1] + ":iam::" + parts[4] + ":role/" + resource[1]
+}
+
+// getSTSToken creates a token with a sts:GetCallerIdentity request signed with
+// the given credentials.
+func (p *AWS) getSTSToken(subject, caURL string, creds *awsCredentials) (string, error) {
+	audience, err := generateSignAudience(caURL, p.GetID())
+	if err != nil {
+		return "", err
+	}
+
+	// Use the regional endpoint if the region is known.
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	stsURL := p.config.stsURL
+	switch {
+	case stsURL != "":
+	case region == "":
+		stsURL, region = "https://sts.amazonaws.com/", "us-east-1"
+	case strings.HasPrefix(region, "cn-"):
+		stsURL = "https://sts." + region + ".amazonaws.com.cn/"
+	default:
+		stsURL = "https://sts." + region + ".amazonaws.com/"
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	req, err := http.NewRequest("POST", stsURL, strings.NewReader(awsSTSBody))
+	if err != nil {
+		return "", errors.Wrap(err, "error creating sts request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	req.Header.Set(awsSTSAudienceHeader, audience)
+	now := time.Now()
+	signAWSRequest(req, []byte(awsSTSBody), creds, region, "sts", now)
+
+	// The signature of the request is used as the key of the token, and its
+	// hash as the token id.
+	authorization := req.Header.Get("Authorization")
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: []byte(authorization)},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating signer")
+	}
+
+	sum := sha256.Sum256([]byte(authorization))
+	payload := awsPayload{
+		Claims: jose.Claims{
+			Issuer:    awsSTSIssuer,
+			Subject:   subject,
+			Audience:  []string{audience},
+			Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+			NotBefore: jose.NewNumericDate(now),
+			IssuedAt:  jose.NewNumericDate(now),
+			ID:        strings.ToLower(hex.EncodeToString(sum[:])),
+		},
+		Amazon: awsAmazonPayload{
+			STS: &awsSTSRequest{
+				Method:  req.Method,
+				URL:     stsURL,
+				Headers: req.Header,
+				Body:    awsSTSBody,
+			},
+		},
+	}
+
+	tok, err := jose.Signed(signer).Claims(payload).CompactSerialize()
+	if err != nil {
+		return "", errors.Wrap(err, "error serialiazing token")
+	}
+	return tok, nil
+}
+
+// authorizeSTSToken validates a token with a signed sts:GetCallerIdentity
+// request. The request is only sent to AWS by verifyIdentity.
+func (p *AWS) authorizeSTSToken(jwt *jose.JSONWebToken, unsafeClaims *awsPayload) (*awsPayload, error) {
+	if !p.EnableSTS {
+		return nil, errs.Unauthorized("aws.authorizeToken; sts tokens are not enabled for aws provisioner %s", p.GetID())
+	}
+	if len(p.Tags) > 0 || len(p.VPCIDs) > 0 {
+		return nil, errs.Unauthorized("aws.authorizeToken; sts tokens cannot be used with tags or vpcIDs constraints")
+	}
+
+	sts := unsafeClaims.Amazon.STS
+	authorization := sts.Headers.Get("Authorization")
+	if authorization == "" {
+		return nil, errs.Unauthorized("aws.authorizeToken; aws sts request authorization cannot be empty")
+	}
+
+	var payload awsPayload
+	if err := jwt.Claims([]byte(authorization), &payload); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "aws.authorizeToken; error verifying claims")
+	}
+	sts = payload.Amazon.STS
+
+	// Validate the request, only sts:GetCallerIdentity requests to the STS
+	// endpoints are allowed.
+	switch {
+	case sts.Method != "POST":
+		return nil, errs.Unauthorized("aws.authorizeToken; aws sts request method is not valid")
+	case !p.config.isSTSURL(sts.URL):
+		return nil, errs.Unauthorized("aws.authorizeToken; aws sts request url is not valid")
+	case sts.Body != awsSTSBody:
+		return nil, errs.Unauthorized("aws.authorizeToken; aws sts request body is not valid")
+	}
+
+	// The audience header must be signed.
+	var signed bool
+	for _, h := range awsSignedHeaders(authorization) {
+		if h == strings.ToLower(awsSTSAudienceHeader) {
+			signed = true
+			break
+		}
+	}
+	if !signed || !matchesAudience([]string{sts.Headers.Get(awsSTSAudienceHeader)}, p.audiences.Sign) {
+		return nil, errs.Unauthorized("aws.authorizeToken; aws sts request audience is not valid")
+	}
+
+	now := time.Now().UTC()
+	amzDate, err := time.Parse("20060102T150405Z", sts.Headers.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "aws.authorizeToken; aws sts request date is not valid")
+	}
+	if now.Sub(amzDate) > awsSTSMaxAge || amzDate.Sub(now) > time.Minute {
+		return nil, errs.Unauthorized("aws.authorizeToken; aws sts request date is not valid")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = payload.ValidateWithLeeway(jose.Expected{
+		Issuer: awsSTSIssuer,
+		Time:   now,
+	}, p.claimer.ClockSkew()); err != nil {
+		return nil, errs.Wrapf(http.StatusUnauthorized, err, "aws.authorizeToken; invalid aws token")
+	}
+
+	// validate audiences with the defaults
+	if !matchesAudience(payload.Audience, p.audiences.Sign) {
+		return nil, errs.Unauthorized("aws.authorizeToken; invalid token - invalid audience claim (aud)")
+	}
+
+	return &payload, nil
+}
+
+// verifyCaller sends the sts:GetCallerIdentity request in the token to AWS and
+// validates the identity of the caller.
+func (p *AWS) verifyCaller(ctx context.Context, payload *awsPayload) error {
+	sts := payload.Amazon.STS
+	req, err := http.NewRequestWithContext(ctx, sts.Method, sts.URL, strings.NewReader(sts.Body))
+	if err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "aws.verifyCaller; error creating aws sts request")
+	}
+	for k, v := range sts.Headers {
+		if k = http.CanonicalHeaderKey(k); k != "Host" && k != "Content-Length" {
+			req.Header[k] = v
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "aws.verifyCaller")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errs.Wrapf(http.StatusInternalServerError, err, "aws.verifyCaller; error connecting to %s", sts.URL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return errs.Unauthorized("aws.verifyCaller; aws sts request failed with status %d", resp.StatusCode)
+	}
+
+	var v struct {
+		Response struct {
+			Result awsCallerIdentity `json:"GetCallerIdentityResult"`
+		} `json:"GetCallerIdentityResponse"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "aws.verifyCaller; error decoding aws sts response")
+	}
+	identity := v.Response.Result
+	if identity.Account == "" || identity.Arn == "" {
+		return errs.Unauthorized("aws.verifyCaller; aws sts response does not contain the caller identity")
+	}
+
+	// validate accounts and roles
+	if len(p.Accounts) > 0 && !containsAllMembers(p.Accounts, []string{identity.Account}) {
+		return errs.Unauthorized("aws.verifyCaller; aws caller identity - account is not valid")
+	}
+	if len(p.IAMRoles) > 0 && !matchesAnyAWSPattern(p.IAMRoles, identity.roleARN()) {
+		return errs.Unauthorized("aws.verifyCaller; aws caller identity - iam role is not valid")
+	}
+
+	// Validate subject, it has to be the caller ARN if disableCustomSANs is
+	// enabled
+	if p.DisableCustomSANs && payload.Subject != identity.Arn {
+		return errs.Unauthorized("aws.verifyCaller; invalid token - invalid subject claim (sub)")
+	}
+
+	payload.identity = &identity
+	return nil
+}
+
+// isSTSURL returns true if the given url is an STS endpoint.
+func (c *awsConfig) isSTSURL(s string) bool {
+	if c.stsURL != "" {
+		return s == c.stsURL
+	}
+	return awsSTSURLRegexp.MatchString(s)
+}
+
+// matchesAnyAWSPattern returns true if the given value matches any of the
+// patterns, an asterisk in a pattern matches any sequence of characters.
+func matchesAnyAWSPattern(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		parts := strings.Split(pattern, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		if regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,361 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func generateSTSServer(t *testing.T) *httptest.Server {
+	identities := map[string]awsCallerIdentity{
+		"role-key":  {Account: "123456789012", Arn: "arn:aws:sts::123456789012:assumed-role/lambda-role/my-function", UserID: "AROAEXAMPLE:my-function"},
+		"user-key":  {Account: "123456789012", Arn: "arn:aws:iam::123456789012:user/jane", UserID: "AIDAEXAMPLE"},
+		"other-key": {Account: "210987654321", Arn: "arn:aws:sts::210987654321:assumed-role/lambda-role/my-function", UserID: "AROAEXAMPLE:my-function"},
+	}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+		auth := r.Header.Get("Authorization")
+		if r.Method != "POST" || string(body) != awsSTSBody || r.Header.Get("Accept") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		for k, v := range identities {
+			if strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+k+"/") {
+				var resp struct {
+					Response struct {
+						Result awsCallerIdentity `json:"GetCallerIdentityResult"`
+					} `json:"GetCallerIdentityResponse"`
+				}
+				resp.Response.Result = v
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+		}
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+}
+
+func generateAWSWithSTSServer(t *testing.T) (*AWS, *httptest.Server) {
+	srv := generateSTSServer(t)
+	p, err := generateAWS()
+	assert.FatalError(t, err)
+	p.Accounts = []string{"123456789012"}
+	p.EnableSTS = true
+	p.client = srv.Client()
+	p.config.stsURL = srv.URL + "/"
+	p.config.credentials = func(bool) (*awsCredentials, error) {
+		return &awsCredentials{AccessKeyID: "role-key", SecretAccessKey: "secret", Token: "token"}, nil
+	}
+	return p, srv
+}
+
+func Test_awsCallerIdentity_roleARN(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{"arn:aws:sts::123456789012:assumed-role/my-role/session", "arn:aws:iam::123456789012:role/my-role"},
+		{"arn:aws-cn:sts::123456789012:assumed-role/my-role/i-0123456789abcdef0", "arn:aws-cn:iam::123456789012:role/my-role"},
+		{"arn:aws:iam::123456789012:user/jane", "arn:aws:iam::123456789012:user/jane"},
+		{"arn:aws:sts::123456789012:federated-user/jane", "arn:aws:sts::123456789012:federated-user/jane"},
+		{"foo", "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			c := &awsCallerIdentity{Arn: tt.arn}
+			if got := c.roleARN(); got != tt.want {
+				t.Errorf("awsCallerIdentity.roleARN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchesAnyAWSPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		s        string
+		want     bool
+	}{
+		{"exact", []string{"arn:aws:iam::123:role/foo"}, "arn:aws:iam::123:role/foo", true},
+		{"wildcard", []string{"arn:aws:iam::*:role/lambda-*"}, "arn:aws:iam::123:role/lambda-foo", true},
+		{"any", []string{"other", "*"}, "foo", true},
+		{"fail exact", []string{"arn:aws:iam::123:role/foo"}, "arn:aws:iam::123:role/foobar", false},
+		{"fail wildcard", []string{"arn:aws:iam::*:role/lambda-*"}, "arn:aws:iam::123:role/ec2-foo", false},
+		{"fail meta", []string{"role.foo"}, "role-foo", false},
+		{"fail empty", nil, "foo", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesAnyAWSPattern(tt.patterns, tt.s); got != tt.want {
+				t.Errorf("matchesAnyAWSPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_awsConfig_isSTSURL(t *testing.T) {
+	c := &awsConfig{}
+	assert.True(t, c.isSTSURL("https://sts.amazonaws.com/"))
+	assert.True(t, c.isSTSURL("https://sts.us-west-2.amazonaws.com/"))
+	assert.True(t, c.isSTSURL("https://sts.cn-north-1.amazonaws.com.cn/"))
+	assert.False(t, c.isSTSURL("http://sts.amazonaws.com/"))
+	assert.False(t, c.isSTSURL("https://sts.amazonaws.com.example.com/"))
+	assert.False(t, c.isSTSURL("https://sts.amazonaws.com/foo"))
+	assert.False(t, c.isSTSURL("https://example.com/"))
+	c.stsURL = "https://127.0.0.1/"
+	assert.True(t, c.isSTSURL("https://127.0.0.1/"))
+	assert.False(t, c.isSTSURL("https://sts.amazonaws.com/"))
+}
+
+func TestAWS_GetIdentityToken_sts(t *testing.T) {
+	p, srv := generateAWSWithSTSServer(t)
+	defer srv.Close()
+
+	token, err := p.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
+	payload, err := p.authorizeToken(token)
+	assert.FatalError(t, err)
+	assert.Equals(t, awsSTSIssuer, payload.Issuer)
+	assert.Equals(t, "foo.local", payload.Subject)
+	assert.Equals(t, srv.URL+"/", payload.Amazon.STS.URL)
+	assert.Equals(t, "token", payload.Amazon.STS.Headers.Get("X-Amz-Security-Token"))
+	assert.Equals(t, []string{"https://ca.smallstep.com/1.0/sign#" + p.GetID()}, payload.Amazon.STS.Headers[awsSTSAudienceHeader])
+
+	// The token id is the hash of the signature
+	id, err := p.GetTokenID(token)
+	assert.FatalError(t, err)
+	assert.Equals(t, payload.ID, id)
+
+	// Disabled sts tokens use the identity document
+	p.EnableSTS = false
+	_, err = p.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.Error(t, err)
+}
+
+func TestAWS_authorizeToken_sts(t *testing.T) {
+	p, srv := generateAWSWithSTSServer(t)
+	defer srv.Close()
+
+	ok, err := p.getSTSToken("foo.local", "https://ca.smallstep.com", &awsCredentials{AccessKeyID: "role-key", SecretAccessKey: "secret"})
+	assert.FatalError(t, err)
+	badAudience, err := p.getSTSToken("foo.local", "https://ca.example.com", &awsCredentials{AccessKeyID: "role-key", SecretAccessKey: "secret"})
+	assert.FatalError(t, err)
+
+	// generate modifies a valid token and signs it again
+	generate := func(fn func(*awsPayload)) string {
+		tok, err := jose.ParseSigned(ok)
+		assert.FatalError(t, err)
+		var payload awsPayload
+		assert.FatalError(t, tok.UnsafeClaimsWithoutVerification(&payload))
+		fn(&payload)
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.HS256, Key: []byte(payload.Amazon.STS.Headers.Get("Authorization"))},
+			new(jose.SignerOptions).WithType("JWT"),
+		)
+		assert.FatalError(t, err)
+		s, err := jose.Signed(signer).Claims(payload).CompactSerialize()
+		assert.FatalError(t, err)
+		return s
+	}
+	badSignature := ok[:len(ok)-4] + "AAAA"
+	badMethod := generate(func(p *awsPayload) { p.Amazon.STS.Method = "GET" })
+	badURL := generate(func(p *awsPayload) { p.Amazon.STS.URL = "https://example.com/" })
+	badBody := generate(func(p *awsPayload) { p.Amazon.STS.Body = "Action=GetSessionToken&Version=2011-06-15" })
+	badDate := generate(func(p *awsPayload) {
+		p.Amazon.STS.Headers.Set("X-Amz-Date", time.Now().Add(-time.Hour).UTC().Format("20060102T150405Z"))
+	})
+	unsignedAudience := generate(func(p *awsPayload) {
+		auth := p.Amazon.STS.Headers.Get("Authorization")
+		p.Amazon.STS.Headers.Set("Authorization", strings.Replace(auth, ";x-step-ca-audience", "", 1))
+	})
+	noAuthorization := generate(func(p *awsPayload) { p.Amazon.STS.Headers.Set("Authorization", "") })
+	badIssuer := generate(func(p *awsPayload) { p.Issuer = "foo" })
+	expired := generate(func(p *awsPayload) { p.Expiry = jose.NewNumericDate(time.Now().Add(-time.Hour)) })
+	badTokenAudience := generate(func(p *awsPayload) { p.Audience = []string{"https://ca.example.com/1.0/sign"} })
+
+	p2, err := generateAWS()
+	assert.FatalError(t, err)
+	p2.config.stsURL = p.config.stsURL
+	p3, err := generateAWS()
+	assert.FatalError(t, err)
+	*p3 = *p
+	p3.Tags = map[string]string{"env": "prod"}
+
+	tests := []struct {
+		name    string
+		p       *AWS
+		token   string
+		code    int
+		wantErr bool
+	}{
+		{"ok", p, ok, http.StatusOK, false},
+		{"fail disabled", p2, ok, http.StatusUnauthorized, true},
+		{"fail tags", p3, ok, http.StatusUnauthorized, true},
+		{"fail signature", p, badSignature, http.StatusUnauthorized, true},
+		{"fail method", p, badMethod, http.StatusUnauthorized, true},
+		{"fail url", p, badURL, http.StatusUnauthorized, true},
+		{"fail body", p, badBody, http.StatusUnauthorized, true},
+		{"fail date", p, badDate, http.StatusUnauthorized, true},
+		{"fail audience", p, badAudience, http.StatusUnauthorized, true},
+		{"fail unsigned audience", p, unsignedAudience, http.StatusUnauthorized, true},
+		{"fail authorization", p, noAuthorization, http.StatusUnauthorized, true},
+		{"fail issuer", p, badIssuer, http.StatusUnauthorized, true},
+		{"fail expired", p, expired, http.StatusUnauthorized, true},
+		{"fail token audience", p, badTokenAudience, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.authorizeToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AWS.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+			} else {
+				assert.NotNil(t, got.Amazon.STS)
+			}
+		})
+	}
+}
+
+func TestAWS_AuthorizeSign_sts(t *testing.T) {
+	p, srv := generateAWSWithSTSServer(t)
+	defer srv.Close()
+
+	arn := "arn:aws:sts::123456789012:assumed-role/lambda-role/my-function"
+	newToken := func(sub, key string) string {
+		tok, err := p.getSTSToken(sub, "https://ca.smallstep.com", &awsCredentials{AccessKeyID: key, SecretAccessKey: "secret"})
+		assert.FatalError(t, err)
+		return tok
+	}
+	newProvisioner := func(fn func(*AWS)) *AWS {
+		p2, err := generateAWS()
+		assert.FatalError(t, err)
+		*p2 = *p
+		fn(p2)
+		return p2
+	}
+
+	pRoles := newProvisioner(func(p *AWS) { p.IAMRoles = []string{"arn:aws:iam::123456789012:role/lambda-*"} })
+	pOtherRoles := newProvisioner(func(p *AWS) { p.IAMRoles = []string{"arn:aws:iam::123456789012:role/ec2-*"} })
+	pNoCustomSANs := newProvisioner(func(p *AWS) { p.DisableCustomSANs = true })
+	pAnyAccount := newProvisioner(func(p *AWS) { p.Accounts = nil })
+
+	tests := []struct {
+		name    string
+		p       *AWS
+		token   string
+		wantLen int
+		code    int
+		wantErr bool
+	}{
+		{"ok", p, newToken("foo.local", "role-key"), 8, http.StatusOK, false},
+		{"ok user", p, newToken("foo.local", "user-key"), 8, http.StatusOK, false},
+		{"ok roles", pRoles, newToken("foo.local", "role-key"), 8, http.StatusOK, false},
+		{"ok disableCustomSANs", pNoCustomSANs, newToken(arn, "role-key"), 12, http.StatusOK, false},
+		{"ok any account", pAnyAccount, newToken("foo.local", "other-key"), 8, http.StatusOK, false},
+		{"fail account", p, newToken("foo.local", "other-key"), 0, http.StatusUnauthorized, true},
+		{"fail roles", pOtherRoles, newToken("foo.local", "role-key"), 0, http.StatusUnauthorized, true},
+		{"fail roles user", pRoles, newToken("foo.local", "user-key"), 0, http.StatusUnauthorized, true},
+		{"fail disableCustomSANs", pNoCustomSANs, newToken("foo.local", "role-key"), 0, http.StatusUnauthorized, true},
+		{"fail sts", p, newToken("foo.local", "unknown-key"), 0, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AWS.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+				return
+			}
+			assert.Len(t, tt.wantLen, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case *provisionerExtensionOption:
+					assert.Equals(t, v.Type, int(TypeAWS))
+					assert.Equals(t, v.Name, tt.p.GetName())
+					assert.Equals(t, v.KeyValuePairs[0], "ARN")
+				case commonNameValidator:
+					if tt.p.DisableCustomSANs {
+						assert.Equals(t, string(v), arn)
+					}
+				case dnsNamesValidator:
+					assert.Len(t, 0, v)
+				case emailAddressesValidator:
+					assert.Len(t, 0, v)
+				case ipAddressesValidator:
+					assert.Len(t, 0, v)
+				case urisValidator:
+					assert.Len(t, 0, v)
+				case profileDefaultDuration, backdateOption, defaultPublicKeyValidator, *validityValidator, *sanDurationValidator, x509ProfileValidator:
+				default:
+					assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
+				}
+			}
+		})
+	}
+}
+
+func TestAWS_AuthorizeSSHSign_sts(t *testing.T) {
+	p, srv := generateAWSWithSTSServer(t)
+	defer srv.Close()
+	p.DisableCustomSANs = true
+
+	arn := "arn:aws:sts::123456789012:assumed-role/lambda-role/my-function"
+	token, err := p.getSTSToken(arn, "https://ca.smallstep.com", &awsCredentials{AccessKeyID: "role-key", SecretAccessKey: "secret"})
+	assert.FatalError(t, err)
+
+	got, err := p.AuthorizeSSHSign(context.Background(), token)
+	assert.FatalError(t, err)
+	var found bool
+	for _, o := range got {
+		switch v := o.(type) {
+		case sshCertKeyIDModifier:
+			assert.Equals(t, arn, string(v))
+		case sshCertOptionsValidator:
+			found = true
+			assert.Equals(t, []string{arn}, v.Principals)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestAWS_AuthorizeSSHSign_stsHostPrincipals(t *testing.T) {
+	p, srv := generateAWSWithSTSServer(t)
+	defer srv.Close()
+	p.SSHHostPrincipals = []string{"privateIP"}
+
+	arn := "arn:aws:sts::123456789012:assumed-role/lambda-role/my-function"
+	token, err := p.getSTSToken(arn, "https://ca.smallstep.com", &awsCredentials{AccessKeyID: "role-key", SecretAccessKey: "secret"})
+	assert.FatalError(t, err)
+
+	_, err = p.AuthorizeSSHSign(context.Background(), token)
+	if assert.NotNil(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+}
@@ -55,9 +55,7 @@ func TestAWS_GetTokenID(t *testing.T) {
 
 	t1, err := p1.GetIdentityToken("foo.local", "https://ca.smallstep.com")
 	assert.FatalError(t, err)
-	_, claims, err := parseAWSToken(t1)
-	assert.FatalError(t, err)
-	sum := sha256.Sum256([]byte(fmt.Sprintf("%s.%s", p1.GetID(), claims.document.InstanceID)))
+	sum := sha256.Sum256([]byte(t1))
 	w1 := strings.ToLower(hex.EncodeToString(sum[:]))
 
 	t2, err := p2.GetIdentityToken("foo.local", "https://ca.smallstep.com")
@@ -93,6 +91,51 @@ func TestAWS_GetTokenID(t *testing.T) {
 	}
 }
 
+func TestAWS_GetInstanceID(t *testing.T) {
+	p1, srv, err := generateAWSWithServer()
+	assert.FatalError(t, err)
+	defer srv.Close()
+
+	p2, err := generateAWS()
+	assert.FatalError(t, err)
+	p2.Accounts = p1.Accounts
+	p2.config = p1.config
+	p2.DisableTrustOnFirstUse = true
+
+	t1, err := p1.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+	_, claims, err := parseAWSToken(t1)
+	assert.FatalError(t, err)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s.%s", p1.GetID(), claims.document.InstanceID)))
+	w1 := strings.ToLower(hex.EncodeToString(sum[:]))
+
+	t2, err := p2.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name   string
+		aws    *AWS
+		token  string
+		want   string
+		wantOk bool
+	}{
+		{"ok", p1, t1, w1, true},
+		{"no TOFU", p2, t2, "", false},
+		{"bad token", p1, "bad-token", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.aws.GetInstanceID(tt.token)
+			if ok != tt.wantOk {
+				t.Errorf("AWS.GetInstanceID() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got != tt.want {
+				t.Errorf("AWS.GetInstanceID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestAWS_GetIdentityToken(t *testing.T) {
 	p1, srv, err := generateAWSWithServer()
 	assert.FatalError(t, err)
@@ -539,11 +582,11 @@ func TestAWS_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 5, http.StatusOK, false},
-		{"ok", p2, args{t2}, 7, http.StatusOK, false},
-		{"ok", p2, args{t2Hostname}, 7, http.StatusOK, false},
-		{"ok", p2, args{t2PrivateIP}, 7, http.StatusOK, false},
-		{"ok", p1, args{t4}, 5, http.StatusOK, false},
+		{"ok", p1, args{t1}, 8, http.StatusOK, false},
+		{"ok", p2, args{t2}, 10, http.StatusOK, false},
+		{"ok", p2, args{t2Hostname}, 10, http.StatusOK, false},
+		{"ok", p2, args{t2PrivateIP}, 10, http.StatusOK, false},
+		{"ok", p1, args{t4}, 8, http.StatusOK, false},
 		{"fail account", p3, args{t3}, 0, http.StatusUnauthorized, true},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail subject", p1, args{failSubject}, 0, http.StatusUnauthorized, true},
@@ -599,12 +642,21 @@ func TestAWS_AuthorizeSSHSign(t *testing.T) {
 	p3.claimer, err = NewClaimer(p3.Claims, globalProvisionerClaims)
 	assert.FatalError(t, err)
 
+	p4, err := generateAWS()
+	assert.FatalError(t, err)
+	p4.Accounts = p1.Accounts
+	p4.config = p1.config
+	p4.SSHHostPrincipals = []string{"instanceID", "privateIP"}
+
 	t1, err := p1.GetIdentityToken("127.0.0.1", "https://ca.smallstep.com")
 	assert.FatalError(t, err)
 
 	t2, err := p2.GetIdentityToken("foo.local", "https://ca.smallstep.com")
 	assert.FatalError(t, err)
 
+	t4, err := p4.GetIdentityToken("foo.local", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
 	key, err := generateJSONWebKey()
 	assert.FatalError(t, err)
 
@@ -634,6 +686,10 @@ func TestAWS_AuthorizeSSHSign(t *testing.T) {
 		CertType: "host", Principals: []string{"foo.local"},
 		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(hostDuration)),
 	}
+	expectedMetadataOptions := &SSHOptions{
+		CertType: "host", Principals: []string{"instance-id", "127.0.0.1"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(hostDuration)),
+	}
 
 	type args struct {
 		token   string
@@ -657,6 +713,8 @@ func TestAWS_AuthorizeSSHSign(t *testing.T) {
 		{"ok-principal-hostname", p1, args{t1, SSHOptions{Principals: []string{"ip-127-0-0-1.us-west-1.compute.internal"}}, pub}, expectedHostOptionsHostname, http.StatusOK, false, false},
 		{"ok-options", p1, args{t1, SSHOptions{CertType: "host", Principals: []string{"127.0.0.1", "ip-127-0-0-1.us-west-1.compute.internal"}}, pub}, expectedHostOptions, http.StatusOK, false, false},
 		{"ok-custom", p2, args{t2, SSHOptions{Principals: []string{"foo.local"}}, pub}, expectedCustomOptions, http.StatusOK, false, false},
+		{"ok-sshHostPrincipals", p4, args{t4, SSHOptions{}, pub}, expectedMetadataOptions, http.StatusOK, false, false},
+		{"fail-sshHostPrincipals", p4, args{t4, SSHOptions{Principals: []string{"foo.local"}}, pub}, nil, http.StatusOK, false, true},
 		{"fail-rsa1024", p1, args{t1, SSHOptions{}, rsa1024.Public()}, expectedHostOptions, http.StatusOK, false, true},
 		{"fail-type", p1, args{t1, SSHOptions{CertType: "user"}, pub}, nil, http.StatusOK, false, true},
 		{"fail-principal", p1, args{t1, SSHOptions{Principals: []string{"smallstep.com"}}, pub}, nil, http.StatusOK, false, true},
@@ -714,8 +772,8 @@ func TestAWS_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{generateRenewCertificate()}, http.StatusOK, false},
+		{"fail/renew-disabled", p2, args{generateRenewCertificate()}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
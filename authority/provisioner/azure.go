@@ -28,17 +28,21 @@ const azureDefaultAudience = "https://management.azure.com/"
 
 // azureXMSMirIDRegExp is the regular expression used to parse the xms_mirid claim.
 // Using case insensitive as resourceGroups appears as resourcegroups.
-var azureXMSMirIDRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachines/([^/]+)$`)
+var azureXMSMirIDRegExp = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/(Microsoft\.Compute/virtualMachines|Microsoft\.Compute/virtualMachineScaleSets|Microsoft\.ManagedIdentity/userAssignedIdentities)/([^/]+)$`)
 
 type azureConfig struct {
 	oidcDiscoveryURL string
 	identityTokenURL string
+	loginURL         string
+	managementURL    string
 }
 
 func newAzureConfig(tenantID string) *azureConfig {
 	return &azureConfig{
 		oidcDiscoveryURL: azureOIDCBaseURL + "/" + tenantID + "/.well-known/openid-configuration",
 		identityTokenURL: azureIdentityTokenURL,
+		loginURL:         azureOIDCBaseURL,
+		managementURL:    azureManagementURL,
 	}
 }
 
@@ -68,7 +72,20 @@ type azurePayload struct {
 // Azure is the provisioner that supports identity tokens created from the
 // Microsoft Azure Instance Metadata service.
 //
-// The default audience is "https://management.azure.com/".
+// The default audience is "https://management.azure.com/", it can be changed
+// to the application ID URI of an app registration with Audience; the clients
+// will request the tokens for that resource.
+//
+// By default only the tokens of the system-assigned identity of a virtual
+// machine are accepted. If EnableScaleSets is true the tokens of the
+// system-assigned identity of a virtual machine scale set are also accepted,
+// and if EnableUserAssignedIdentities is true, the tokens of user-assigned
+// managed identities too. These tokens do not identify a single instance, so
+// trust on first use does not apply to them.
+//
+// SubscriptionIDs, ResourceGroups and Tags constrain the resource in the
+// token; the tags are read from the Azure Resource Manager API with the
+// credentials of the CA.
 //
 // If DisableCustomSANs is true, only the internal DNS and IP will be added as a
 // SAN. By default it will accept any SAN in the CSR.
@@ -77,23 +94,43 @@ type azurePayload struct {
 // with the same instance will be accepted. By default only the first request
 // will be accepted.
 //
+// If InstanceAge is set, only the virtual machines created in that interval
+// will be accepted; the creation time is read from the Azure Resource Manager
+// API.
+//
+// If SSHHostPrincipals is set, the principals of the SSH host certificates are
+// restricted to the given metadata fields, and they are used as the default
+// principals. The supported fields are instanceName, the name of the resource,
+// and instanceID, the vmId of a virtual machine read from the Azure Resource
+// Manager API.
+//
 // Microsoft Azure identity docs are available at
 // https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
 // and https://docs.microsoft.com/en-us/azure/virtual-machines/windows/instance-metadata-service
 type Azure struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	TenantID               string   `json:"tenantId"`
-	ResourceGroups         []string `json:"resourceGroups"`
-	Audience               string   `json:"audience,omitempty"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	Claims                 *Claims  `json:"claims,omitempty"`
-	claimer                *Claimer
-	config                 *azureConfig
-	oidcConfig             openIDConfiguration
-	keyStore               *keyStore
+	Type                         string              `json:"type"`
+	Name                         string              `json:"name"`
+	TenantID                     string              `json:"tenantId"`
+	ResourceGroups               []string            `json:"resourceGroups"`
+	SubscriptionIDs              []string            `json:"subscriptionIds,omitempty"`
+	Tags                         map[string]string   `json:"tags,omitempty"`
+	Audience                     string              `json:"audience,omitempty"`
+	EnableScaleSets              bool                `json:"enableScaleSets,omitempty"`
+	EnableUserAssignedIdentities bool                `json:"enableUserAssignedIdentities,omitempty"`
+	DisableCustomSANs            bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse       bool                `json:"disableTrustOnFirstUse"`
+	InstanceAge                  Duration            `json:"instanceAge,omitempty"`
+	SSHHostPrincipals            []string            `json:"sshHostPrincipals,omitempty"`
+	Claims                       *Claims             `json:"claims,omitempty"`
+	Options                      *CertificateOptions `json:"options,omitempty"`
+	HTTPClient                   *HTTPClientOptions  `json:"httpClient,omitempty"`
+	KeyStore                     *KeyStoreOptions    `json:"keyStore,omitempty"`
+	claimer                      *Claimer
+	config                       *azureConfig
+	oidcConfig                   openIDConfiguration
+	keyStore                     *keyStore
+	client                       *http.Client
 }
 
 // GetID returns the provisioner unique identifier.
@@ -101,9 +138,10 @@ func (p *Azure) GetID() string {
 	return p.TenantID
 }
 
-// GetTokenID returns the identifier of the token. The default value for Azure
-// the SHA256 of "xms_mirid", but if DisableTrustOnFirstUse is set to true, then
-// it will be the token kid.
+// GetTokenID returns the identifier of the token, the SHA256 of the token, but
+// if DisableTrustOnFirstUse is set to true, then it will be the token kid. The
+// instance of the token is recorded separately after the token is authorized,
+// see GetInstanceID.
 func (p *Azure) GetTokenID(token string) (string, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
@@ -122,9 +160,29 @@ func (p *Azure) GetTokenID(token string) (string, error) {
 	if p.DisableTrustOnFirstUse {
 		return claims.ID, nil
 	}
+	return tokenKey(token), nil
+}
 
+// GetInstanceID returns the key used to record the instance of the token, the
+// SHA256 of "xms_mirid". Only the tokens of a virtual machine identify an
+// instance.
+func (p *Azure) GetInstanceID(token string) (string, bool) {
+	if p.DisableTrustOnFirstUse {
+		return "", false
+	}
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", false
+	}
+	var claims azurePayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", false
+	}
+	if r, ok := parseAzureResource(claims.XMSMirID); !ok || !r.isVirtualMachine() {
+		return "", false
+	}
 	sum := sha256.Sum256([]byte(claims.XMSMirID))
-	return strings.ToLower(hex.EncodeToString(sum[:])), nil
+	return strings.ToLower(hex.EncodeToString(sum[:])), true
 }
 
 // GetName returns the name of the provisioner.
@@ -142,18 +200,39 @@ func (p *Azure) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetKeyStoreStats returns the statistics of the keys used to validate the
+// tokens.
+func (p *Azure) GetKeyStoreStats() KeyStoreStats {
+	return p.keyStore.Stats()
+}
+
+// Close releases the keys used to validate the tokens.
+func (p *Azure) Close() error {
+	releaseKeyStore(p.keyStore)
+	return nil
+}
+
 // GetIdentityToken retrieves from the metadata service the identity token and
-// returns it.
+// returns it. The token is requested for the audience of the provisioner, and
+// for the user-assigned identity in AZURE_CLIENT_ID if set.
 func (p *Azure) GetIdentityToken(subject, caURL string) (string, error) {
 	// Initialize the config if this method is used from the cli.
 	p.assertConfig()
 
-	req, err := http.NewRequest("GET", p.config.identityTokenURL, http.NoBody)
+	identityTokenURL, err := azureIdentityURL(p.config.identityTokenURL, p.Audience)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", identityTokenURL, http.NoBody)
 	if err != nil {
 		return "", errors.Wrap(err, "error creating request")
 	}
 	req.Header.Set("Metadata", "true")
-	resp, err := http.DefaultClient.Do(req)
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", errors.Wrap(err, "error getting identity token, are you in a Azure VM?")
 	}
@@ -184,9 +263,24 @@ func (p *Azure) Init(config Config) (err error) {
 		return errors.New("provisioner name cannot be empty")
 	case p.TenantID == "":
 		return errors.New("provisioner tenantId cannot be empty")
+	case p.InstanceAge.Value() < 0:
+		return errors.New("provisioner instanceAge cannot be negative")
 	case p.Audience == "": // use default audience
 		p.Audience = azureDefaultAudience
 	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	for k := range p.Tags {
+		if k == "" {
+			return errors.New("provisioner tags cannot contain an empty key")
+		}
+	}
+	if err := validateSSHHostPrincipals(p.SSHHostPrincipals, sshPrincipalInstanceName, sshPrincipalInstanceID); err != nil {
+		return err
+	}
 	// Initialize config
 	p.assertConfig()
 
@@ -195,15 +289,22 @@ func (p *Azure) Init(config Config) (err error) {
 		return err
 	}
 
+	// Create the client used to fetch the configuration and keys
+	clientOpts := p.HTTPClient.withDefaultProxy(config.Proxy)
+	if p.client, err = newHTTPClient(clientOpts); err != nil {
+		return err
+	}
+
 	// Decode and validate openid-configuration endpoint
-	if err := getAndDecode(p.config.oidcDiscoveryURL, &p.oidcConfig); err != nil {
+	ctx := context.Background()
+	if err := getAndDecode(ctx, p.client, p.config.oidcDiscoveryURL, &p.oidcConfig); err != nil {
 		return err
 	}
 	if err := p.oidcConfig.Validate(); err != nil {
 		return errors.Wrapf(err, "error parsing %s", p.config.oidcDiscoveryURL)
 	}
 	// Get JWK key set
-	if p.keyStore, err = newKeyStore(p.oidcConfig.JWKSetURI); err != nil {
+	if p.keyStore, err = getKeyStore(ctx, p.client, clientOpts, p.oidcConfig.JWKSetURI, p.KeyStore); err != nil {
 		return err
 	}
 
@@ -237,7 +338,7 @@ func (p *Azure) authorizeToken(token string) (*azurePayload, string, string, err
 		Audience: []string{p.Audience},
 		Issuer:   p.oidcConfig.Issuer,
 		Time:     time.Now(),
-	}, 1*time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, "", "", errs.Wrap(http.StatusUnauthorized, err, "azure.authorizeToken; failed to validate azure token payload")
 	}
 
@@ -246,34 +347,29 @@ func (p *Azure) authorizeToken(token string) (*azurePayload, string, string, err
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - invalid tenant id claim (tid)")
 	}
 
-	re := azureXMSMirIDRegExp.FindStringSubmatch(claims.XMSMirID)
-	if len(re) != 4 {
+	r, ok := parseAzureResource(claims.XMSMirID)
+	if !ok {
 		return nil, "", "", errs.Unauthorized("azure.authorizeToken; error parsing xms_mirid claim - %s", claims.XMSMirID)
 	}
-	group, name := re[2], re[3]
-	return &claims, name, group, nil
+	if !p.isAllowed(r) {
+		return nil, "", "", errs.Unauthorized("azure.authorizeToken; azure token validation failed - %s tokens are not enabled", r.Type)
+	}
+	return &claims, r.Name, r.ResourceGroup, nil
 }
 
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	_, name, group, err := p.authorizeToken(token)
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("azure.AuthorizeSign; issuance is disabled for azure provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, name, _, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSign")
 	}
-
-	// Filter by resource group
-	if len(p.ResourceGroups) > 0 {
-		var found bool
-		for _, g := range p.ResourceGroups {
-			if g == group {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil, errs.Unauthorized("azure.AuthorizeSign; azure token validation failed - invalid resource group")
-		}
+	if err := p.verifyResource(ctx, claims); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSign")
 	}
 
 	// Enforce known common name and default DNS if configured.
@@ -286,14 +382,16 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 		so = append(so, dnsNamesValidator([]string{name}))
 	}
 
-	return append(so,
+	return withX509CertificateOptions(p.Options, append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeAzure, p.Name, p.TenantID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	), nil
+		newSANDurationValidator(p.claimer),
+	), newTemplateData(token, name, nil)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -302,7 +400,14 @@ func (p *Azure) AuthorizeSign(ctx context.Context, token string) ([]SignOption,
 // certificate was configured to allow renewals.
 func (p *Azure) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("azure.AuthorizeRenew; renew is disabled for azure provisioner %s", p.GetID())
+		return errs.Unauthorized("azure.AuthorizeRenew; renew is disabled for azure provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "azure.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "azure.AuthorizeRenew")
 	}
 	return nil
 }
@@ -312,19 +417,32 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("azure.AuthorizeSSHSign; sshCA is disabled for provisioner %s", p.GetID())
 	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("azure.AuthorizeSSHSign; issuance is disabled for azure provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 
-	_, name, _, err := p.authorizeToken(token)
+	claims, name, _, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSSHSign")
 	}
+	if err := p.verifyResource(ctx, claims); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSSHSign")
+	}
 	signOptions := []SignOption{
 		// set the key id to the instance name
 		sshCertKeyIDModifier(name),
 	}
 
-	// Only enforce known principals if disable custom sans is true.
+	// Enforce the principals in the configured metadata fields, or only
+	// enforce known principals if disable custom sans is true.
 	var principals []string
-	if p.DisableCustomSANs {
+	switch {
+	case len(p.SSHHostPrincipals) > 0:
+		if principals, err = p.sshHostPrincipals(ctx, claims); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.AuthorizeSSHSign")
+		}
+	case p.DisableCustomSANs:
 		principals = []string{name}
 	}
 
@@ -338,18 +456,19 @@ func (p *Azure) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	return withSSHCertificateOptions(p.Options, append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
+		backdateOption(p.claimer.Backdate()),
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	), newTemplateData(token, name, principals)), nil
 }
 
 // assertConfig initializes the config if it has not been initialized
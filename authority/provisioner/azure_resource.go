@@ -0,0 +1,297 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// azureManagementURL is the url of the Azure Resource Manager API.
+const azureManagementURL = "https://management.azure.com"
+
+// Azure resource types of the identities accepted by the provisioner.
+const (
+	azureVirtualMachine           = "Microsoft.Compute/virtualMachines"
+	azureVirtualMachineScaleSet   = "Microsoft.Compute/virtualMachineScaleSets"
+	azureUserAssignedIdentity     = "Microsoft.ManagedIdentity/userAssignedIdentities"
+	azureVirtualMachineAPIVersion = "2021-11-01"
+	azureIdentityAPIVersion       = "2018-11-30"
+)
+
+// azureResource is the Azure resource in the xms_mirid claim of a token.
+type azureResource struct {
+	SubscriptionID string
+	ResourceGroup  string
+	Type           string
+	Name           string
+}
+
+// parseAzureResource parses the xms_mirid claim of a token.
+func parseAzureResource(xmsMirID string) (*azureResource, bool) {
+	re := azureXMSMirIDRegExp.FindStringSubmatch(xmsMirID)
+	if len(re) != 5 {
+		return nil, false
+	}
+	r := &azureResource{
+		SubscriptionID: re[1],
+		ResourceGroup:  re[2],
+		Name:           re[4],
+	}
+	// The regular expression is case insensitive.
+	for _, typ := range []string{azureVirtualMachine, azureVirtualMachineScaleSet, azureUserAssignedIdentity} {
+		if strings.EqualFold(typ, re[3]) {
+			r.Type = typ
+		}
+	}
+	return r, true
+}
+
+// isVirtualMachine returns true if the resource is a virtual machine; only the
+// tokens of a virtual machine identify a single instance.
+func (r *azureResource) isVirtualMachine() bool {
+	return r.Type == azureVirtualMachine
+}
+
+// isAllowed returns true if the provisioner accepts tokens of the resource
+// type.
+func (p *Azure) isAllowed(r *azureResource) bool {
+	switch r.Type {
+	case azureVirtualMachine:
+		return true
+	case azureVirtualMachineScaleSet:
+		return p.EnableScaleSets
+	case azureUserAssignedIdentity:
+		return p.EnableUserAssignedIdentities
+	default:
+		return false
+	}
+}
+
+// azureResourceInfo are the properties of a resource returned by the Azure
+// Resource Manager API.
+type azureResourceInfo struct {
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		TimeCreated time.Time `json:"timeCreated"`
+		VMID        string    `json:"vmId"`
+	} `json:"properties"`
+}
+
+// verifyResource validates the subscription, resource group, tags and age of
+// the resource in the token.
+func (p *Azure) verifyResource(ctx context.Context, claims *azurePayload) error {
+	r, ok := parseAzureResource(claims.XMSMirID)
+	if !ok {
+		return errs.Unauthorized("azure.verifyResource; error parsing xms_mirid claim - %s", claims.XMSMirID)
+	}
+
+	// Filter by subscription
+	if len(p.SubscriptionIDs) > 0 && !containsFold(p.SubscriptionIDs, r.SubscriptionID) {
+		return errs.Unauthorized("azure.verifyResource; azure token validation failed - invalid subscription id")
+	}
+
+	// Filter by resource group
+	if len(p.ResourceGroups) > 0 {
+		var found bool
+		for _, g := range p.ResourceGroups {
+			if g == r.ResourceGroup {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errs.Unauthorized("azure.verifyResource; azure token validation failed - invalid resource group")
+		}
+	}
+
+	if len(p.Tags) == 0 && p.InstanceAge.Value() == 0 {
+		return nil
+	}
+	// Only virtual machines have a creation time.
+	if p.InstanceAge.Value() > 0 && !r.isVirtualMachine() {
+		return errs.Unauthorized("azure.verifyResource; azure resource %s does not have a creation time", r.Type)
+	}
+	info, err := p.getResource(ctx, r, claims.XMSMirID)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "azure.verifyResource; error getting azure resource")
+	}
+	if isInstanceTooOld(p.InstanceAge, info.Properties.TimeCreated, time.Now()) {
+		return errs.Unauthorized("azure.verifyResource; azure virtual machine timeCreated is too old")
+	}
+	for k, pattern := range p.Tags {
+		var found bool
+		for tk, tv := range info.Tags {
+			// Azure tag names are case insensitive
+			if strings.EqualFold(k, tk) && matchAnyPattern([]string{pattern}, tv) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errs.Unauthorized("azure.verifyResource; azure resource tag %s is not valid", k)
+		}
+	}
+	return nil
+}
+
+// sshHostPrincipals returns the principals of the SSH host certificates of the
+// resource in the token, the values of the metadata fields in
+// SSHHostPrincipals.
+func (p *Azure) sshHostPrincipals(ctx context.Context, claims *azurePayload) ([]string, error) {
+	r, ok := parseAzureResource(claims.XMSMirID)
+	if !ok {
+		return nil, errs.Unauthorized("azure.sshHostPrincipals; error parsing xms_mirid claim - %s", claims.XMSMirID)
+	}
+	var principals []string
+	for _, field := range p.SSHHostPrincipals {
+		switch field {
+		case sshPrincipalInstanceName:
+			principals = append(principals, r.Name)
+		case sshPrincipalInstanceID:
+			// Only virtual machines have a vmId.
+			if !r.isVirtualMachine() {
+				return nil, errs.Unauthorized("azure.sshHostPrincipals; azure resource %s does not have a vmId", r.Type)
+			}
+			info, err := p.getResource(ctx, r, claims.XMSMirID)
+			if err != nil {
+				return nil, errs.Wrap(http.StatusInternalServerError, err, "azure.sshHostPrincipals; error getting azure resource")
+			}
+			if info.Properties.VMID == "" {
+				return nil, errs.Unauthorized("azure.sshHostPrincipals; azure virtual machine does not have a vmId")
+			}
+			principals = append(principals, info.Properties.VMID)
+		}
+	}
+	return principals, nil
+}
+
+// getResource returns the tags, creation time and vmId of the resource using the
+// Azure Resource Manager API with the credentials of the CA.
+func (p *Azure) getResource(ctx context.Context, r *azureResource, resourceID string) (*azureResourceInfo, error) {
+	token, err := p.getManagementToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiVersion := azureVirtualMachineAPIVersion
+	if r.Type == azureUserAssignedIdentity {
+		apiVersion = azureIdentityAPIVersion
+	}
+	resourceURL := p.config.managementURL + resourceID + "?api-version=" + apiVersion
+	req, err := http.NewRequestWithContext(ctx, "GET", resourceURL, http.NoBody)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", resourceURL)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var v azureResourceInfo
+	if err := p.doJSON(req, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// getManagementToken returns an access token for the Azure Resource Manager
+// API. It uses the service principal in the AZURE_TENANT_ID, AZURE_CLIENT_ID
+// and AZURE_CLIENT_SECRET environment variables, or the managed identity of the
+// CA.
+func (p *Azure) getManagementToken(ctx context.Context) (string, error) {
+	var req *http.Request
+	var err error
+	resource := p.config.managementURL + "/"
+	if secret := os.Getenv("AZURE_CLIENT_SECRET"); secret != "" {
+		tenantID := os.Getenv("AZURE_TENANT_ID")
+		if tenantID == "" {
+			tenantID = p.TenantID
+		}
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {os.Getenv("AZURE_CLIENT_ID")},
+			"client_secret": {secret},
+			"resource":      {resource},
+		}
+		tokenURL := p.config.loginURL + "/" + tenantID + "/oauth2/token"
+		if req, err = http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode())); err != nil {
+			return "", errors.Wrapf(err, "failed to connect to %s", tokenURL)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		tokenURL, err := azureIdentityURL(p.config.identityTokenURL, resource)
+		if err != nil {
+			return "", err
+		}
+		if req, err = http.NewRequestWithContext(ctx, "GET", tokenURL, http.NoBody); err != nil {
+			return "", errors.Wrapf(err, "failed to connect to %s", tokenURL)
+		}
+		req.Header.Set("Metadata", "true")
+	}
+
+	var identityToken azureIdentityToken
+	if err := p.doJSON(req, &identityToken); err != nil {
+		return "", err
+	}
+	if identityToken.AccessToken == "" {
+		return "", errors.New("error getting azure management token: access_token is empty")
+	}
+	return identityToken.AccessToken, nil
+}
+
+// doJSON sends the request and decodes the json response in v.
+func (p *Azure) doJSON(req *http.Request, v interface{}) error {
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %s", req.URL.String())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return errors.Errorf("error requesting %s: status=%d", req.URL.String(), resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "error decoding %s", req.URL.String())
+	}
+	return nil
+}
+
+// azureIdentityURL returns the url of the metadata service used to get an
+// access token for the given resource. The user-assigned identity in the
+// AZURE_CLIENT_ID environment variable is used if set.
+func azureIdentityURL(identityTokenURL, resource string) (string, error) {
+	u, err := url.Parse(identityTokenURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing %s", identityTokenURL)
+	}
+	q := u.Query()
+	if resource != "" {
+		q.Set("resource", resource)
+	}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		q.Set("client_id", clientID)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// containsFold returns true if the slice contains the value using a case
+// insensitive comparison.
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
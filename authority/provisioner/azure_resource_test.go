@@ -0,0 +1,349 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+const (
+	testAzureVM       = "/subscriptions/subscriptionID/resourceGroups/resourceGroup/providers/Microsoft.Compute/virtualMachines/virtualMachine"
+	testAzureVMSS     = "/subscriptions/subscriptionID/resourcegroups/resourceGroup/providers/Microsoft.Compute/virtualMachineScaleSets/scaleSet"
+	testAzureIdentity = "/subscriptions/subscriptionID/resourcegroups/resourceGroup/providers/Microsoft.ManagedIdentity/userAssignedIdentities/identity"
+)
+
+func generateAzureResourceToken(p *Azure, aud, xmsMirID string) (string, error) {
+	jwk := &p.keyStore.keySet.Keys[0]
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+	)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := azurePayload{
+		Claims: jose.Claims{
+			Subject:   "subject",
+			Issuer:    p.oidcConfig.Issuer,
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+			ID:        "the-jti",
+		},
+		TenantID: p.TenantID,
+		XMSMirID: xmsMirID,
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+// generateAzureManagementServer returns a server with the metadata service
+// and the resource manager API.
+func generateAzureManagementServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/metadata/identity/oauth2/token":
+			assert.Equals(t, "true", r.Header.Get("Metadata"))
+			assert.Equals(t, "http://"+r.Host+"/", r.URL.Query().Get("resource"))
+			w.Write([]byte(`{"access_token":"management-token"}`))
+		case r.URL.Path == "/tenant-id/oauth2/token":
+			r.ParseForm()
+			assert.Equals(t, "client_credentials", r.Form.Get("grant_type"))
+			if r.Form.Get("client_id") != "sp-id" || r.Form.Get("client_secret") != "sp-secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"access_token":"management-token"}`))
+		case r.Header.Get("Authorization") != "Bearer management-token":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path == testAzureVM:
+			assert.Equals(t, azureVirtualMachineAPIVersion, r.URL.Query().Get("api-version"))
+			timeCreated := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			w.Write([]byte(`{"name":"virtualMachine","tags":{"Environment":"prod","team":"platform-sre"},"properties":{"timeCreated":"` + timeCreated + `","vmId":"vm-id"}}`))
+		case r.URL.Path == testAzureIdentity:
+			assert.Equals(t, azureIdentityAPIVersion, r.URL.Query().Get("api-version"))
+			w.Write([]byte(`{"name":"identity","tags":{"environment":"dev"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_parseAzureResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		xmsMirID string
+		want     *azureResource
+		wantOK   bool
+	}{
+		{"virtual machine", testAzureVM, &azureResource{"subscriptionID", "resourceGroup", azureVirtualMachine, "virtualMachine"}, true},
+		{"scale set", testAzureVMSS, &azureResource{"subscriptionID", "resourceGroup", azureVirtualMachineScaleSet, "scaleSet"}, true},
+		{"identity", testAzureIdentity, &azureResource{"subscriptionID", "resourceGroup", azureUserAssignedIdentity, "identity"}, true},
+		{"lower case", "/subscriptions/s/resourcegroups/g/providers/microsoft.compute/virtualmachines/vm", &azureResource{"s", "g", azureVirtualMachine, "vm"}, true},
+		{"fail type", "/subscriptions/s/resourceGroups/g/providers/Microsoft.Web/sites/app", nil, false},
+		{"fail format", "foo", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseAzureResource(tt.xmsMirID)
+			if ok != tt.wantOK {
+				t.Errorf("parseAzureResource() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAzureResource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAzure_authorizeToken_resourceTypes(t *testing.T) {
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name                         string
+		xmsMirID                     string
+		enableScaleSets              bool
+		enableUserAssignedIdentities bool
+		wantName                     string
+		wantErr                      bool
+	}{
+		{"ok virtual machine", testAzureVM, false, false, "virtualMachine", false},
+		{"ok scale set", testAzureVMSS, true, false, "scaleSet", false},
+		{"ok identity", testAzureIdentity, false, true, "identity", false},
+		{"fail scale set", testAzureVMSS, false, true, "", true},
+		{"fail identity", testAzureIdentity, true, false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.EnableScaleSets = tt.enableScaleSets
+			p.EnableUserAssignedIdentities = tt.enableUserAssignedIdentities
+			tok, err := generateAzureResourceToken(p, azureDefaultAudience, tt.xmsMirID)
+			assert.FatalError(t, err)
+			_, name, group, err := p.authorizeToken(tok)
+			if tt.wantErr {
+				if assert.NotNil(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.wantName, name)
+			assert.Equals(t, "resourceGroup", group)
+		})
+	}
+}
+
+func TestAzure_authorizeToken_audience(t *testing.T) {
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+	p.Audience = "api://step-ca"
+
+	tok, err := generateAzureResourceToken(p, "api://step-ca", testAzureVM)
+	assert.FatalError(t, err)
+	_, _, _, err = p.authorizeToken(tok)
+	assert.FatalError(t, err)
+
+	tok, err = generateAzureResourceToken(p, azureDefaultAudience, testAzureVM)
+	assert.FatalError(t, err)
+	_, _, _, err = p.authorizeToken(tok)
+	assert.Error(t, err)
+}
+
+func TestAzure_GetInstanceID_resourceTypes(t *testing.T) {
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+	p.EnableScaleSets = true
+
+	vm, err := generateAzureResourceToken(p, azureDefaultAudience, testAzureVM)
+	assert.FatalError(t, err)
+	vmss, err := generateAzureResourceToken(p, azureDefaultAudience, testAzureVMSS)
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256([]byte(testAzureVM))
+	got, ok := p.GetInstanceID(vm)
+	assert.True(t, ok)
+	assert.Equals(t, hex.EncodeToString(sum[:]), got)
+
+	got, ok = p.GetInstanceID(vmss)
+	assert.False(t, ok)
+	assert.Equals(t, "", got)
+
+	p.DisableTrustOnFirstUse = true
+	got, ok = p.GetInstanceID(vm)
+	assert.False(t, ok)
+	assert.Equals(t, "", got)
+
+	id, err := p.GetTokenID(vmss)
+	assert.FatalError(t, err)
+	assert.Equals(t, "the-jti", id)
+}
+
+func TestAzure_GetIdentityToken_audience(t *testing.T) {
+	defer os.Setenv("AZURE_CLIENT_ID", os.Getenv("AZURE_CLIENT_ID"))
+	os.Setenv("AZURE_CLIENT_ID", "user-assigned-id")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equals(t, "2018-02-01", q.Get("api-version"))
+		assert.Equals(t, "api://step-ca", q.Get("resource"))
+		assert.Equals(t, "user-assigned-id", q.Get("client_id"))
+		w.Write([]byte(`{"access_token":"the-token"}`))
+	}))
+	defer srv.Close()
+
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+	p.Audience = "api://step-ca"
+	p.config.identityTokenURL = srv.URL + "?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F"
+	got, err := p.GetIdentityToken("subject", "caURL")
+	assert.FatalError(t, err)
+	assert.Equals(t, "the-token", got)
+}
+
+func TestAzure_verifyResource(t *testing.T) {
+	srv := generateAzureManagementServer(t)
+	defer srv.Close()
+
+	for _, k := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+
+	newAzure := func(subscriptions, groups []string, tags map[string]string) *Azure {
+		p, err := generateAzure()
+		assert.FatalError(t, err)
+		p.SubscriptionIDs = subscriptions
+		p.ResourceGroups = groups
+		p.Tags = tags
+		p.config.identityTokenURL = srv.URL + "/metadata/identity/oauth2/token?api-version=2018-02-01"
+		p.config.loginURL = srv.URL
+		p.config.managementURL = srv.URL
+		return p
+	}
+	withInstanceAge := func(p *Azure, d time.Duration) *Azure {
+		p.InstanceAge = Duration{Duration: d}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		p        *Azure
+		xmsMirID string
+		code     int
+	}{
+		{"ok", newAzure(nil, nil, nil), testAzureVM, 0},
+		{"ok subscription", newAzure([]string{"other", "SUBSCRIPTIONID"}, nil, nil), testAzureVM, 0},
+		{"ok resource group", newAzure(nil, []string{"resourceGroup"}, nil), testAzureVMSS, 0},
+		{"ok tags", newAzure(nil, nil, map[string]string{"environment": "prod", "Team": "platform-*"}), testAzureVM, 0},
+		{"ok identity tags", newAzure(nil, nil, map[string]string{"environment": "dev"}), testAzureIdentity, 0},
+		{"ok instance age", withInstanceAge(newAzure(nil, nil, nil), 2*time.Hour), testAzureVM, 0},
+		{"fail subscription", newAzure([]string{"other"}, nil, nil), testAzureVM, http.StatusUnauthorized},
+		{"fail resource group", newAzure(nil, []string{"other"}, nil), testAzureVM, http.StatusUnauthorized},
+		{"fail tag value", newAzure(nil, nil, map[string]string{"environment": "dev"}), testAzureVM, http.StatusUnauthorized},
+		{"fail tag missing", newAzure(nil, nil, map[string]string{"owner": "*"}), testAzureVM, http.StatusUnauthorized},
+		{"fail resource", newAzure(nil, nil, map[string]string{"environment": "*"}), testAzureVMSS, http.StatusInternalServerError},
+		{"fail xms_mirid", newAzure(nil, nil, nil), "foo", http.StatusUnauthorized},
+		{"fail instance age", withInstanceAge(newAzure(nil, nil, nil), 30*time.Minute), testAzureVM, http.StatusUnauthorized},
+		{"fail instance age identity", withInstanceAge(newAzure(nil, nil, nil), 2*time.Hour), testAzureIdentity, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.verifyResource(context.Background(), &azurePayload{XMSMirID: tt.xmsMirID})
+			if tt.code == 0 {
+				assert.FatalError(t, err)
+				return
+			}
+			if assert.NotNil(t, err) {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+			}
+		})
+	}
+
+	// Service principal credentials
+	p := newAzure(nil, nil, map[string]string{"environment": "prod"})
+	os.Setenv("AZURE_TENANT_ID", "tenant-id")
+	os.Setenv("AZURE_CLIENT_ID", "sp-id")
+	os.Setenv("AZURE_CLIENT_SECRET", "sp-secret")
+	assert.FatalError(t, p.verifyResource(context.Background(), &azurePayload{XMSMirID: testAzureVM}))
+	os.Setenv("AZURE_CLIENT_SECRET", "bad-secret")
+	assert.Error(t, p.verifyResource(context.Background(), &azurePayload{XMSMirID: testAzureVM}))
+}
+
+func TestAzure_AuthorizeSSHSign_resourceGroups(t *testing.T) {
+	p, err := generateAzure()
+	assert.FatalError(t, err)
+	p.ResourceGroups = []string{"other"}
+	tok, err := generateAzureResourceToken(p, azureDefaultAudience, testAzureVM)
+	assert.FatalError(t, err)
+
+	_, err = p.AuthorizeSSHSign(context.Background(), tok)
+	if assert.NotNil(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+	}
+}
+
+func TestAzure_sshHostPrincipals(t *testing.T) {
+	srv := generateAzureManagementServer(t)
+	defer srv.Close()
+
+	for _, k := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+
+	newAzure := func(fields ...string) *Azure {
+		p, err := generateAzure()
+		assert.FatalError(t, err)
+		p.SSHHostPrincipals = fields
+		p.config.identityTokenURL = srv.URL + "/metadata/identity/oauth2/token?api-version=2018-02-01"
+		p.config.loginURL = srv.URL
+		p.config.managementURL = srv.URL
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		p        *Azure
+		xmsMirID string
+		want     []string
+		code     int
+	}{
+		{"ok name", newAzure("instanceName"), testAzureVM, []string{"virtualMachine"}, 0},
+		{"ok vmId", newAzure("instanceID", "instanceName"), testAzureVM, []string{"vm-id", "virtualMachine"}, 0},
+		{"ok scale set name", newAzure("instanceName"), testAzureVMSS, []string{"scaleSet"}, 0},
+		{"fail scale set vmId", newAzure("instanceID"), testAzureVMSS, nil, http.StatusUnauthorized},
+		{"fail identity vmId", newAzure("instanceID"), testAzureIdentity, nil, http.StatusUnauthorized},
+		{"fail xms_mirid", newAzure("instanceName"), "foo", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.sshHostPrincipals(context.Background(), &azurePayload{XMSMirID: tt.xmsMirID})
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package provisioner
 
 import (
+	"crypto/x509"
 	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
+// defaultClockSkew is the backdate and the leeway of the token validation if
+// they are not configured.
+const defaultClockSkew = time.Minute
+
 // Claims so that individual provisioners can override global claims.
 type Claims struct {
 	// TLS CA properties
@@ -14,6 +19,22 @@ type Claims struct {
 	MaxTLSDur      *Duration `json:"maxTLSCertDuration,omitempty"`
 	DefaultTLSDur  *Duration `json:"defaultTLSCertDuration,omitempty"`
 	DisableRenewal *bool     `json:"disableRenewal,omitempty"`
+	// DisableIssuance rejects the signing of new certificates, renewals are
+	// still allowed unless DisableRenewal is also set.
+	DisableIssuance *bool `json:"disableIssuance,omitempty"`
+	// Maximum durations of the certificates with DNS, email or IP SANs, if set
+	// they lower the MaxTLSDur of the certificates with those names.
+	MaxDNSTLSDur   *Duration `json:"maxDNSCertDuration,omitempty"`
+	MaxEmailTLSDur *Duration `json:"maxEmailCertDuration,omitempty"`
+	MaxIPTLSDur    *Duration `json:"maxIPCertDuration,omitempty"`
+	// AllowRenewalAfterExpiry is the period after the expiration of a
+	// certificate in which it can still be renewed.
+	AllowRenewalAfterExpiry *Duration `json:"allowRenewalAfterExpiry,omitempty"`
+	// Backdate is subtracted from the notBefore of the new certificates, and
+	// ClockSkew is the leeway allowed validating the exp and nbf claims of the
+	// tokens, both accommodate clients with poor time synchronization.
+	Backdate  *Duration `json:"backdate,omitempty"`
+	ClockSkew *Duration `json:"clockSkew,omitempty"`
 	// SSH CA properties
 	MinUserSSHDur     *Duration `json:"minUserSSHCertDuration,omitempty"`
 	MaxUserSSHDur     *Duration `json:"maxUserSSHCertDuration,omitempty"`
@@ -22,6 +43,9 @@ type Claims struct {
 	MaxHostSSHDur     *Duration `json:"maxHostSSHCertDuration,omitempty"`
 	DefaultHostSSHDur *Duration `json:"defaultHostSSHCertDuration,omitempty"`
 	EnableSSHCA       *bool     `json:"enableSSHCA,omitempty"`
+	// SPIFFE properties
+	EnableSPIFFE      *bool  `json:"enableSPIFFE,omitempty"`
+	SPIFFETrustDomain string `json:"spiffeTrustDomain,omitempty"`
 }
 
 // Claimer is the type that controls claims. It provides an interface around the
@@ -40,19 +64,30 @@ func NewClaimer(claims *Claims, global Claims) (*Claimer, error) {
 // Claims returns the merge of the inner and global claims.
 func (c *Claimer) Claims() Claims {
 	disableRenewal := c.IsDisableRenewal()
+	disableIssuance := c.IsDisableIssuance()
 	enableSSHCA := c.IsSSHCAEnabled()
+	enableSPIFFE := c.IsSPIFFEEnabled()
 	return Claims{
-		MinTLSDur:         &Duration{c.MinTLSCertDuration()},
-		MaxTLSDur:         &Duration{c.MaxTLSCertDuration()},
-		DefaultTLSDur:     &Duration{c.DefaultTLSCertDuration()},
-		DisableRenewal:    &disableRenewal,
-		MinUserSSHDur:     &Duration{c.MinUserSSHCertDuration()},
-		MaxUserSSHDur:     &Duration{c.MaxUserSSHCertDuration()},
-		DefaultUserSSHDur: &Duration{c.DefaultUserSSHCertDuration()},
-		MinHostSSHDur:     &Duration{c.MinHostSSHCertDuration()},
-		MaxHostSSHDur:     &Duration{c.MaxHostSSHCertDuration()},
-		DefaultHostSSHDur: &Duration{c.DefaultHostSSHCertDuration()},
-		EnableSSHCA:       &enableSSHCA,
+		MinTLSDur:               &Duration{c.MinTLSCertDuration()},
+		MaxTLSDur:               &Duration{c.MaxTLSCertDuration()},
+		DefaultTLSDur:           &Duration{c.DefaultTLSCertDuration()},
+		DisableRenewal:          &disableRenewal,
+		DisableIssuance:         &disableIssuance,
+		MaxDNSTLSDur:            &Duration{c.MaxDNSCertDuration()},
+		MaxEmailTLSDur:          &Duration{c.MaxEmailCertDuration()},
+		MaxIPTLSDur:             &Duration{c.MaxIPCertDuration()},
+		AllowRenewalAfterExpiry: &Duration{c.AllowRenewalAfterExpiry()},
+		Backdate:                &Duration{c.Backdate()},
+		ClockSkew:               &Duration{c.ClockSkew()},
+		MinUserSSHDur:           &Duration{c.MinUserSSHCertDuration()},
+		MaxUserSSHDur:           &Duration{c.MaxUserSSHCertDuration()},
+		DefaultUserSSHDur:       &Duration{c.DefaultUserSSHCertDuration()},
+		MinHostSSHDur:           &Duration{c.MinHostSSHCertDuration()},
+		MaxHostSSHDur:           &Duration{c.MaxHostSSHCertDuration()},
+		DefaultHostSSHDur:       &Duration{c.DefaultHostSSHCertDuration()},
+		EnableSSHCA:             &enableSSHCA,
+		EnableSPIFFE:            &enableSPIFFE,
+		SPIFFETrustDomain:       c.SPIFFETrustDomain(),
 	}
 }
 
@@ -86,6 +121,71 @@ func (c *Claimer) MaxTLSCertDuration() time.Duration {
 	return c.claims.MaxTLSDur.Duration
 }
 
+// MaxDNSCertDuration returns the maximum duration of the certificates with DNS
+// names. If the maximum is not set within the provisioner, then the global
+// maximum from the authority configuration will be used. A zero value means
+// that only the MaxTLSCertDuration applies.
+func (c *Claimer) MaxDNSCertDuration() time.Duration {
+	if c.claims == nil || c.claims.MaxDNSTLSDur == nil {
+		if c.global.MaxDNSTLSDur == nil {
+			return 0
+		}
+		return c.global.MaxDNSTLSDur.Duration
+	}
+	return c.claims.MaxDNSTLSDur.Duration
+}
+
+// MaxEmailCertDuration returns the maximum duration of the certificates with
+// email addresses. If the maximum is not set within the provisioner, then the
+// global maximum from the authority configuration will be used. A zero value
+// means that only the MaxTLSCertDuration applies.
+func (c *Claimer) MaxEmailCertDuration() time.Duration {
+	if c.claims == nil || c.claims.MaxEmailTLSDur == nil {
+		if c.global.MaxEmailTLSDur == nil {
+			return 0
+		}
+		return c.global.MaxEmailTLSDur.Duration
+	}
+	return c.claims.MaxEmailTLSDur.Duration
+}
+
+// MaxIPCertDuration returns the maximum duration of the certificates with IP
+// addresses. If the maximum is not set within the provisioner, then the global
+// maximum from the authority configuration will be used. A zero value means
+// that only the MaxTLSCertDuration applies.
+func (c *Claimer) MaxIPCertDuration() time.Duration {
+	if c.claims == nil || c.claims.MaxIPTLSDur == nil {
+		if c.global.MaxIPTLSDur == nil {
+			return 0
+		}
+		return c.global.MaxIPTLSDur.Duration
+	}
+	return c.claims.MaxIPTLSDur.Duration
+}
+
+// checkSANDurations returns an error if the duration of the certificate is
+// greater than the maximum duration of any of the types of names in it. As
+// with the MaxTLSCertDuration, the backdate is not counted as part of the
+// duration.
+func (c *Claimer) checkSANDurations(cert *x509.Certificate, backdate time.Duration) error {
+	d := cert.NotAfter.Truncate(time.Second).Sub(cert.NotBefore.Truncate(time.Second))
+	for _, l := range []struct {
+		name    string
+		present bool
+		max     time.Duration
+	}{
+		{"DNS names", len(cert.DNSNames) > 0, c.MaxDNSCertDuration()},
+		{"email addresses", len(cert.EmailAddresses) > 0, c.MaxEmailCertDuration()},
+		{"IP addresses", len(cert.IPAddresses) > 0, c.MaxIPCertDuration()},
+	} {
+		if l.present && l.max > 0 && d > l.max+backdate {
+			return errors.Errorf("requested duration of %v is more than the authorized maximum certificate duration of %v for certificates with %s",
+				d, l.max+backdate, l.name)
+		}
+	}
+	return nil
+}
+
 // IsDisableRenewal returns if the renewal flow is disabled for the
 // provisioner. If the property is not set within the provisioner, then the
 // global value from the authority configuration will be used.
@@ -96,6 +196,78 @@ func (c *Claimer) IsDisableRenewal() bool {
 	return *c.claims.DisableRenewal
 }
 
+// IsDisableIssuance returns if the signing of new certificates is disabled for
+// the provisioner. If the property is not set within the provisioner, then the
+// global value from the authority configuration will be used.
+func (c *Claimer) IsDisableIssuance() bool {
+	if c.claims == nil || c.claims.DisableIssuance == nil {
+		return c.global.DisableIssuance != nil && *c.global.DisableIssuance
+	}
+	return *c.claims.DisableIssuance
+}
+
+// AllowRenewalAfterExpiry returns the period after the expiration of a
+// certificate in which it can still be renewed. If the property is not set
+// within the provisioner, then the global value from the authority
+// configuration will be used. A zero value does not allow the renewal of
+// expired certificates.
+func (c *Claimer) AllowRenewalAfterExpiry() time.Duration {
+	if c.claims == nil || c.claims.AllowRenewalAfterExpiry == nil {
+		if c.global.AllowRenewalAfterExpiry == nil {
+			return 0
+		}
+		return c.global.AllowRenewalAfterExpiry.Duration
+	}
+	return c.claims.AllowRenewalAfterExpiry.Duration
+}
+
+// Backdate returns the duration subtracted from the notBefore of the
+// certificates signed by the provisioner. If the property is not set within the
+// provisioner, then the global value from the authority configuration will be
+// used, or one minute if neither is set.
+func (c *Claimer) Backdate() time.Duration {
+	if c.claims == nil || c.claims.Backdate == nil {
+		if c.global.Backdate == nil {
+			return defaultClockSkew
+		}
+		return c.global.Backdate.Duration
+	}
+	return c.claims.Backdate.Duration
+}
+
+// ClockSkew returns the leeway allowed validating the expiration and not
+// before claims of the tokens of the provisioner. If the property is not set
+// within the provisioner, then the global value from the authority
+// configuration will be used, or one minute if neither is set.
+func (c *Claimer) ClockSkew() time.Duration {
+	if c.claims == nil || c.claims.ClockSkew == nil {
+		if c.global.ClockSkew == nil {
+			return defaultClockSkew
+		}
+		return c.global.ClockSkew.Duration
+	}
+	return c.claims.ClockSkew.Duration
+}
+
+// checkRenewalPeriod returns an error if the certificate is not yet valid, or
+// if it has expired and the period in which expired certificates can be
+// renewed has passed. A renewal started by a client with a valid certificate
+// might reach this check right after the expiration, so one minute of leeway
+// is allowed. Renewed certificates keep the duration of the old one, so it
+// also returns an error if that duration is over the maximum for the names in
+// the certificate, allowing the default backdate of one minute.
+func (c *Claimer) checkRenewalPeriod(cert *x509.Certificate) error {
+	t := now()
+	switch {
+	case t.Before(cert.NotBefore):
+		return errors.New("certificate is not yet valid")
+	case t.After(cert.NotAfter.Add(c.AllowRenewalAfterExpiry() + time.Minute)):
+		return errors.Errorf("certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	default:
+		return c.checkSANDurations(cert, time.Minute)
+	}
+}
+
 // DefaultSSHCertDuration returns the default SSH certificate duration for the
 // given certificate type.
 func (c *Claimer) DefaultSSHCertDuration(certType uint32) (time.Duration, error) {
@@ -181,6 +353,27 @@ func (c *Claimer) IsSSHCAEnabled() bool {
 	return *c.claims.EnableSSHCA
 }
 
+// IsSPIFFEEnabled returns if the certificates must be SPIFFE X.509 SVIDs. If
+// the property is not set within the provisioner, then the global value from
+// the authority configuration will be used.
+func (c *Claimer) IsSPIFFEEnabled() bool {
+	if c.claims == nil || c.claims.EnableSPIFFE == nil {
+		return c.global.EnableSPIFFE != nil && *c.global.EnableSPIFFE
+	}
+	return *c.claims.EnableSPIFFE
+}
+
+// SPIFFETrustDomain returns the trust domain of the SPIFFE IDs. If the
+// property is not set within the provisioner, then the global value from the
+// authority configuration will be used. An empty value allows any trust
+// domain.
+func (c *Claimer) SPIFFETrustDomain() string {
+	if c.claims == nil || c.claims.SPIFFETrustDomain == "" {
+		return c.global.SPIFFETrustDomain
+	}
+	return c.claims.SPIFFETrustDomain
+}
+
 // Validate validates and modifies the Claims with default values.
 func (c *Claimer) Validate() error {
 	var (
@@ -195,6 +388,18 @@ func (c *Claimer) Validate() error {
 		return errors.Errorf("claims: MaxTLSCertDuration must be greater than 0")
 	case def <= 0:
 		return errors.Errorf("claims: DefaultTLSCertDuration must be greater than 0")
+	case c.AllowRenewalAfterExpiry() < 0:
+		return errors.Errorf("claims: AllowRenewalAfterExpiry cannot be negative")
+	case c.Backdate() < 0:
+		return errors.Errorf("claims: Backdate cannot be negative")
+	case c.ClockSkew() < 0:
+		return errors.Errorf("claims: ClockSkew cannot be negative")
+	case !isValidSANDuration(c.MaxDNSCertDuration(), min):
+		return errors.Errorf("claims: MaxDNSCertDuration cannot be negative or less than MinCertDuration: MaxDNSCertDuration - %v, MinCertDuration - %v", c.MaxDNSCertDuration(), min)
+	case !isValidSANDuration(c.MaxEmailCertDuration(), min):
+		return errors.Errorf("claims: MaxEmailCertDuration cannot be negative or less than MinCertDuration: MaxEmailCertDuration - %v, MinCertDuration - %v", c.MaxEmailCertDuration(), min)
+	case !isValidSANDuration(c.MaxIPCertDuration(), min):
+		return errors.Errorf("claims: MaxIPCertDuration cannot be negative or less than MinCertDuration: MaxIPCertDuration - %v, MinCertDuration - %v", c.MaxIPCertDuration(), min)
 	case max < min:
 		return errors.Errorf("claims: MaxCertDuration cannot be less "+
 			"than MinCertDuration: MaxCertDuration - %v, MinCertDuration - %v", max, min)
@@ -202,7 +407,15 @@ func (c *Claimer) Validate() error {
 		return errors.Errorf("claims: DefaultCertDuration cannot be less than MinCertDuration: DefaultCertDuration - %v, MinCertDuration - %v", def, min)
 	case max < def:
 		return errors.Errorf("claims: MaxCertDuration cannot be less than DefaultCertDuration: MaxCertDuration - %v, DefaultCertDuration - %v", max, def)
+	case !isValidSPIFFETrustDomain(c.SPIFFETrustDomain()):
+		return errors.Errorf("claims: SPIFFETrustDomain %s is not valid", c.SPIFFETrustDomain())
 	default:
 		return nil
 	}
 }
+
+// isValidSANDuration returns true if the maximum duration for a type of name is
+// not set, or if it's not less than the minimum duration.
+func isValidSANDuration(d, min time.Duration) bool {
+	return d == 0 || d >= min
+}
@@ -1,6 +1,8 @@
 package provisioner
 
 import (
+	"crypto/x509"
+	"net"
 	"testing"
 	"time"
 
@@ -49,3 +51,162 @@ func TestClaimer_DefaultSSHCertDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestClaimer_checkRenewalPeriod(t *testing.T) {
+	window := Duration{Duration: 24 * time.Hour}
+	now := time.Now()
+	valid := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+	expired := &x509.Certificate{NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)}
+	tooOld := &x509.Certificate{NotBefore: now.Add(-48 * time.Hour), NotAfter: now.Add(-25 * time.Hour)}
+	notYetValid := &x509.Certificate{NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour)}
+
+	type fields struct {
+		global Claims
+		claims *Claims
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", fields{globalProvisionerClaims, nil}, valid, false},
+		{"ok expired", fields{globalProvisionerClaims, &Claims{AllowRenewalAfterExpiry: &window}}, expired, false},
+		{"ok expired global", fields{Claims{AllowRenewalAfterExpiry: &window}, nil}, expired, false},
+		{"fail expired", fields{globalProvisionerClaims, nil}, expired, true},
+		{"fail expired window", fields{globalProvisionerClaims, &Claims{AllowRenewalAfterExpiry: &window}}, tooOld, true},
+		{"fail not yet valid", fields{globalProvisionerClaims, &Claims{AllowRenewalAfterExpiry: &window}}, notYetValid, true},
+		{"fail san duration", fields{globalProvisionerClaims, &Claims{MaxDNSTLSDur: &Duration{Duration: time.Hour}}}, &x509.Certificate{
+			NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour), DNSNames: []string{"foo.smallstep.com"},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{
+				global: tt.fields.global,
+				claims: tt.fields.claims,
+			}
+			if err := c.checkRenewalPeriod(tt.cert); (err != nil) != tt.wantErr {
+				t.Errorf("Claimer.checkRenewalPeriod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimer_Validate_allowRenewalAfterExpiry(t *testing.T) {
+	negative := Duration{Duration: -time.Hour}
+	if _, err := NewClaimer(&Claims{AllowRenewalAfterExpiry: &negative}, globalProvisionerClaims); err == nil {
+		t.Error("NewClaimer() error = nil, wants an error")
+	}
+}
+
+func TestClaimer_Backdate(t *testing.T) {
+	global := Duration{Duration: 5 * time.Minute}
+	local := Duration{Duration: time.Hour}
+	zero := Duration{}
+	tests := []struct {
+		name          string
+		global        Claims
+		claims        *Claims
+		wantBackdate  time.Duration
+		wantClockSkew time.Duration
+	}{
+		{"default", globalProvisionerClaims, nil, time.Minute, time.Minute},
+		{"global", Claims{Backdate: &global, ClockSkew: &global}, nil, 5 * time.Minute, 5 * time.Minute},
+		{"provisioner", Claims{Backdate: &global, ClockSkew: &global}, &Claims{Backdate: &local, ClockSkew: &zero}, time.Hour, 0},
+		{"provisioner without global", globalProvisionerClaims, &Claims{Backdate: &zero}, 0, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{global: tt.global, claims: tt.claims}
+			if got := c.Backdate(); got != tt.wantBackdate {
+				t.Errorf("Claimer.Backdate() = %v, want %v", got, tt.wantBackdate)
+			}
+			if got := c.ClockSkew(); got != tt.wantClockSkew {
+				t.Errorf("Claimer.ClockSkew() = %v, want %v", got, tt.wantClockSkew)
+			}
+		})
+	}
+}
+
+func TestClaimer_Validate_backdate(t *testing.T) {
+	negative := Duration{Duration: -time.Minute}
+	if _, err := NewClaimer(&Claims{Backdate: &negative}, globalProvisionerClaims); err == nil {
+		t.Error("NewClaimer() error = nil, wants an error")
+	}
+	if _, err := NewClaimer(&Claims{ClockSkew: &negative}, globalProvisionerClaims); err == nil {
+		t.Error("NewClaimer() error = nil, wants an error")
+	}
+}
+
+func TestClaimer_checkSANDurations(t *testing.T) {
+	now := time.Now()
+	hour := Duration{Duration: time.Hour}
+	day := Duration{Duration: 24 * time.Hour}
+	claims := &Claims{MaxDNSTLSDur: &day, MaxEmailTLSDur: &hour, MaxIPTLSDur: &hour}
+	newCert := func(d time.Duration, fn func(*x509.Certificate)) *x509.Certificate {
+		cert := &x509.Certificate{NotBefore: now, NotAfter: now.Add(d)}
+		fn(cert)
+		return cert
+	}
+	dns := func(c *x509.Certificate) { c.DNSNames = []string{"foo.smallstep.com"} }
+	email := func(c *x509.Certificate) { c.EmailAddresses = []string{"foo@smallstep.com"} }
+	ip := func(c *x509.Certificate) { c.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")} }
+	all := func(c *x509.Certificate) { dns(c); email(c); ip(c) }
+	type args struct {
+		cert     *x509.Certificate
+		backdate time.Duration
+	}
+	tests := []struct {
+		name    string
+		claims  *Claims
+		args    args
+		wantErr bool
+	}{
+		{"ok dns", claims, args{newCert(24*time.Hour, dns), 0}, false},
+		{"ok email", claims, args{newCert(time.Hour, email), 0}, false},
+		{"ok ip", claims, args{newCert(time.Hour, ip), 0}, false},
+		{"ok backdate", claims, args{newCert(time.Hour+time.Minute, email), time.Minute}, false},
+		{"ok not set", nil, args{newCert(24*time.Hour, all), 0}, false},
+		{"ok no names", claims, args{newCert(24*time.Hour, func(*x509.Certificate) {}), 0}, false},
+		{"fail dns", claims, args{newCert(25*time.Hour, dns), 0}, true},
+		{"fail email", claims, args{newCert(2*time.Hour, email), 0}, true},
+		{"fail ip", claims, args{newCert(2*time.Hour, ip), 0}, true},
+		{"fail all", claims, args{newCert(2*time.Hour, all), 0}, true},
+		{"fail backdate", claims, args{newCert(time.Hour+2*time.Minute, email), time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{global: globalProvisionerClaims, claims: tt.claims}
+			if err := c.checkSANDurations(tt.args.cert, tt.args.backdate); (err != nil) != tt.wantErr {
+				t.Errorf("Claimer.checkSANDurations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimer_Validate_sanDurations(t *testing.T) {
+	negative := Duration{Duration: -time.Hour}
+	tooShort := Duration{Duration: time.Second}
+	valid := Duration{Duration: time.Hour}
+	tests := []struct {
+		name    string
+		claims  *Claims
+		wantErr bool
+	}{
+		{"ok", &Claims{MaxDNSTLSDur: &valid, MaxEmailTLSDur: &valid, MaxIPTLSDur: &valid}, false},
+		{"fail dns negative", &Claims{MaxDNSTLSDur: &negative}, true},
+		{"fail email negative", &Claims{MaxEmailTLSDur: &negative}, true},
+		{"fail ip negative", &Claims{MaxIPTLSDur: &negative}, true},
+		{"fail dns min", &Claims{MaxDNSTLSDur: &tooShort}, true},
+		{"fail email min", &Claims{MaxEmailTLSDur: &tooShort}, true},
+		{"fail ip min", &Claims{MaxIPTLSDur: &tooShort}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClaimer(tt.claims, globalProvisionerClaims); (err != nil) != tt.wantErr {
+				t.Errorf("NewClaimer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
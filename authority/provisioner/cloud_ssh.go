@@ -0,0 +1,43 @@
+package provisioner
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Instance metadata fields that the cloud provisioners can use as the
+// principals of SSH host certificates, see the SSHHostPrincipals option of the
+// AWS, GCP and Azure provisioners. Not all the fields are supported by all the
+// provisioners.
+const (
+	sshPrincipalPrivateIP    = "privateIP"
+	sshPrincipalPrivateDNS   = "privateDNS"
+	sshPrincipalPublicIP     = "publicIP"
+	sshPrincipalPublicDNS    = "publicDNS"
+	sshPrincipalInstanceID   = "instanceID"
+	sshPrincipalInstanceName = "instanceName"
+)
+
+// validateSSHHostPrincipals returns an error if the given metadata fields are
+// not in the supported ones or if they are repeated.
+func validateSSHHostPrincipals(fields []string, supported ...string) error {
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		var ok bool
+		for _, s := range supported {
+			if f == s {
+				ok = true
+				break
+			}
+		}
+		switch {
+		case !ok:
+			return errors.Errorf("provisioner sshHostPrincipals value %q is not valid, valid values are %s", f, strings.Join(supported, ", "))
+		case seen[f]:
+			return errors.Errorf("provisioner sshHostPrincipals value %q is duplicated", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
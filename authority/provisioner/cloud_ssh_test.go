@@ -0,0 +1,27 @@
+package provisioner
+
+import (
+	"testing"
+)
+
+func Test_validateSSHHostPrincipals(t *testing.T) {
+	supported := []string{"privateIP", "privateDNS", "instanceID"}
+	tests := []struct {
+		name    string
+		fields  []string
+		wantErr bool
+	}{
+		{"ok empty", nil, false},
+		{"ok", []string{"privateDNS", "instanceID"}, false},
+		{"fail unsupported", []string{"privateDNS", "publicDNS"}, true},
+		{"fail case", []string{"privatedns"}, true},
+		{"fail duplicated", []string{"instanceID", "privateIP", "instanceID"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSSHHostPrincipals(tt.fields, supported...); (err != nil) != tt.wantErr {
+				t.Errorf("validateSSHHostPrincipals() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// CMP is the CMP provisioner type, an entity that can authorize the
+// Certificate Management Protocol (RFC 4210) flows used by telecom and
+// industrial devices.
+//
+// Initialization and certification requests are authorized with a MAC based
+// protection using the SharedSecret, or with a signature based protection
+// using a certificate that chains to one of the Roots, e.g. a manufacturer
+// installed device certificate. Key update and revocation requests are
+// signed with a certificate issued by the CA.
+type CMP struct {
+	*base
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// SharedSecret is the secret used to verify the password based MAC of the
+	// requests. The responses to these requests are protected with the same
+	// secret.
+	SharedSecret string `json:"sharedSecret,omitempty"`
+	// Roots are the PEM encoded certificates used to verify the signature
+	// based protection of initialization and certification requests.
+	Roots    []byte              `json:"roots,omitempty"`
+	Claims   *Claims             `json:"claims,omitempty"`
+	Options  *CertificateOptions `json:"options,omitempty"`
+	claimer  *Claimer
+	rootPool *x509.CertPool
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *CMP) GetID() string {
+	return "cmp/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *CMP) GetTokenID(ott string) (string, error) {
+	return "", errors.New("cmp provisioner does not implement GetTokenID")
+}
+
+// GetName returns the name of the provisioner.
+func (p *CMP) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *CMP) GetType() Type {
+	return TypeCMP
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (p *CMP) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// Init initializes and validates the fields of a CMP type.
+func (p *CMP) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case p.SharedSecret == "" && len(p.Roots) == 0:
+		return errors.New("provisioner sharedSecret or roots must be set")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	p.rootPool = nil
+	if len(p.Roots) > 0 {
+		p.rootPool = x509.NewCertPool()
+		var (
+			block *pem.Block
+			rest  = p.Roots
+		)
+		for rest != nil {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return errors.Wrap(err, "error parsing x509 certificate from PEM block")
+			}
+			p.rootPool.AddCert(cert)
+		}
+		if len(p.rootPool.Subjects()) == 0 {
+			return errors.Errorf("no x509 certificates found in roots attribute for provisioner %s", p.GetName())
+		}
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetSharedSecret returns the secret used in the password based MAC
+// protection. It returns nil if the provisioner does not define one.
+func (p *CMP) GetSharedSecret() []byte {
+	if p.SharedSecret == "" {
+		return nil
+	}
+	return []byte(p.SharedSecret)
+}
+
+// VerifyCertificate returns an error if the certificate used in the signature
+// based protection of a request does not chain to one of the roots of the
+// provisioner.
+func (p *CMP) VerifyCertificate(cert *x509.Certificate, intermediates []*x509.Certificate) error {
+	if p.rootPool == nil {
+		return errs.Unauthorized("cmp.VerifyCertificate; provisioner %s does not allow signature protected requests", p.GetName())
+	}
+	pool := x509.NewCertPool()
+	for _, crt := range intermediates {
+		pool.AddCert(crt)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         p.rootPool,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "cmp.VerifyCertificate; error verifying certificate chain")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return errs.Unauthorized("cmp.VerifyCertificate; certificate cannot be used for digital signature")
+	}
+	return nil
+}
+
+// AuthorizeSign does not do any validation, because all validation is handled
+// in the CMP protocol. This method returns a list of modifiers / constraints
+// on the resulting certificate.
+func (p *CMP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("cmp.AuthorizeSign; issuance is disabled for cmp provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	return withX509CertificateOptions(p.Options, []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeCMP, p.Name, ""),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
+		// validators
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(p.claimer),
+	}, newTemplateData(token, "", nil)), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled or if the
+// certificate has expired and it cannot be renewed after expiry. CMP clients
+// renew with a key update request signed by the current certificate.
+func (p *CMP) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("cmp.AuthorizeRenew; renew is disabled for cmp provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "cmp.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "cmp.AuthorizeRenew")
+	}
+	return nil
+}
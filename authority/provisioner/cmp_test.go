@@ -0,0 +1,237 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+func generateCMP() (*CMP, error) {
+	p := &CMP{
+		Type:         "CMP",
+		Name:         "cmp@smallstep.com",
+		SharedSecret: "secret",
+	}
+	if err := p.Init(Config{Claims: globalProvisionerClaims}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func generateCMPCertificate(t *testing.T, tpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tpl.NotBefore = time.Now().Add(-time.Minute)
+	tpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = tpl
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, signer)
+	assert.FatalError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	return cert
+}
+
+func TestCMP_Getters(t *testing.T) {
+	p, err := generateCMP()
+	assert.FatalError(t, err)
+	id := "cmp/" + p.Name
+	if got := p.GetID(); got != id {
+		t.Errorf("CMP.GetID() = %v, want %v", got, id)
+	}
+	if got := p.GetName(); got != p.Name {
+		t.Errorf("CMP.GetName() = %v, want %v", got, p.Name)
+	}
+	if got := p.GetType(); got != TypeCMP {
+		t.Errorf("CMP.GetType() = %v, want %v", got, TypeCMP)
+	}
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("CMP.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+	if _, err := p.GetTokenID("token"); err == nil {
+		t.Error("CMP.GetTokenID() error = nil, want error")
+	}
+	assert.Equals(t, []byte("secret"), p.GetSharedSecret())
+	assert.Nil(t, (&CMP{}).GetSharedSecret())
+}
+
+func TestCMP_Init(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	root := generateCMPCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Vendor Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, nil, key.Public(), key)
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	badPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("foo")})
+
+	config := Config{Claims: globalProvisionerClaims}
+	tests := []struct {
+		name    string
+		p       *CMP
+		wantErr bool
+	}{
+		{"ok secret", &CMP{Type: "CMP", Name: "name", SharedSecret: "secret"}, false},
+		{"ok roots", &CMP{Type: "CMP", Name: "name", Roots: rootPEM}, false},
+		{"ok both", &CMP{Type: "CMP", Name: "name", SharedSecret: "secret", Roots: rootPEM}, false},
+		{"fail type", &CMP{Name: "name", SharedSecret: "secret"}, true},
+		{"fail name", &CMP{Type: "CMP", SharedSecret: "secret"}, true},
+		{"fail authentication", &CMP{Type: "CMP", Name: "name"}, true},
+		{"fail roots", &CMP{Type: "CMP", Name: "name", Roots: badPEM}, true},
+		{"fail roots empty", &CMP{Type: "CMP", Name: "name", Roots: []byte("foo")}, true},
+		{"fail claims", &CMP{Type: "CMP", Name: "name", SharedSecret: "secret", Claims: &Claims{DefaultTLSDur: &Duration{0}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("CMP.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCMP_VerifyCertificate(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	root := generateCMPCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Vendor Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, rootKey.Public(), rootKey)
+	intKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	intermediate := generateCMPCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Vendor Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root, intKey.Public(), rootKey)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	device := generateCMPCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "device"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, intermediate, key.Public(), intKey)
+	encipherment := generateCMPCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "device"},
+		KeyUsage: x509.KeyUsageKeyEncipherment,
+	}, intermediate, key.Public(), intKey)
+	untrusted := generateCMPCertificate(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "device"},
+	}, nil, key.Public(), key)
+
+	config := Config{Claims: globalProvisionerClaims}
+	p := &CMP{Type: "CMP", Name: "name", Roots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})}
+	assert.FatalError(t, p.Init(config))
+	noRoots, err := generateCMP()
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name          string
+		p             *CMP
+		cert          *x509.Certificate
+		intermediates []*x509.Certificate
+		wantErr       bool
+	}{
+		{"ok", p, device, []*x509.Certificate{intermediate}, false},
+		{"fail no roots", noRoots, device, []*x509.Certificate{intermediate}, true},
+		{"fail no intermediate", p, device, nil, true},
+		{"fail untrusted", p, untrusted, nil, true},
+		{"fail key usage", p, encipherment, []*x509.Certificate{intermediate}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.VerifyCertificate(tt.cert, tt.intermediates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CMP.VerifyCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+			}
+		})
+	}
+}
+
+func TestCMP_AuthorizeSign(t *testing.T) {
+	p, err := generateCMP()
+	assert.FatalError(t, err)
+	opts, err := p.AuthorizeSign(context.Background(), "")
+	assert.FatalError(t, err)
+	assert.Len(t, 7, opts)
+	for _, o := range opts {
+		switch v := o.(type) {
+		case *provisionerExtensionOption:
+			assert.Equals(t, v.Type, int(TypeCMP))
+			assert.Equals(t, v.Name, p.GetName())
+		case profileDefaultDuration:
+			assert.Equals(t, time.Duration(v), p.claimer.DefaultTLSCertDuration())
+		case backdateOption:
+			assert.Equals(t, time.Duration(v), p.claimer.Backdate())
+		case defaultPublicKeyValidator:
+		case *validityValidator:
+			assert.Equals(t, v.min, p.claimer.MinTLSCertDuration())
+			assert.Equals(t, v.max, p.claimer.MaxTLSCertDuration())
+		case *sanDurationValidator:
+			assert.Equals(t, v.claimer, p.claimer)
+		case x509ProfileValidator:
+			assert.Len(t, 0, v)
+		default:
+			t.Errorf("unexpected sign option of type %T", v)
+		}
+	}
+}
+
+func TestCMP_AuthorizeRenew(t *testing.T) {
+	p1, err := generateCMP()
+	assert.FatalError(t, err)
+	p2, err := generateCMP()
+	assert.FatalError(t, err)
+
+	// disable renewal
+	disable := true
+	p2.Claims = &Claims{DisableRenewal: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	valid := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+	expired := &x509.Certificate{NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name    string
+		p       *CMP
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", p1, valid, false},
+		{"fail disabled", p2, valid, true},
+		{"fail expired", p1, expired, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.AuthorizeRenew(context.Background(), tt.cert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CMP.AuthorizeRenew() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
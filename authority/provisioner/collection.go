@@ -37,16 +37,19 @@ func (p provisionerSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 // provisioner.
 type loadByTokenPayload struct {
 	jose.Claims
-	AuthorizedParty    string `json:"azp"`                                               // OIDC client id
-	TenantID           string `json:"tid"`                                               // Microsoft Azure tenant id
-	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name"` // Kubernetes Service Acct Name
+	AuthorizedParty    string    `json:"azp"`                                               // OIDC client id
+	TenantID           string    `json:"tid"`                                               // Microsoft Azure tenant id
+	ServiceAccountName string    `json:"kubernetes.io/serviceaccount/service-account.name"` // Kubernetes Service Acct Name
+	Kubernetes         *struct{} `json:"kubernetes.io"`                                     // Kubernetes bound tokens
 }
 
 // Collection is a memory map of provisioners.
 type Collection struct {
 	byID      *sync.Map
 	byKey     *sync.Map
+	mutex     sync.RWMutex
 	sorted    provisionerSlice
+	count     uint32
 	audiences Audiences
 }
 
@@ -67,6 +70,22 @@ func (c *Collection) Load(id string) (Interface, bool) {
 
 // LoadByToken parses the token claims and loads the provisioner associated.
 func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims) (Interface, bool) {
+	// Unverified claims used to find the provisioner.
+	var payload loadByTokenPayload
+	if err := token.UnsafeClaimsWithoutVerification(&payload); err != nil {
+		return nil, false
+	}
+
+	// Kubernetes Service Account tokens. Bound tokens might use the CA
+	// audiences, so they are checked first.
+	if len(payload.ServiceAccountName) > 0 || payload.Kubernetes != nil {
+		if p, ok := c.Load(K8sSAID); ok {
+			return p, ok
+		}
+		// Kubernetes service account provisioner not found
+		return nil, false
+	}
+
 	var audiences []string
 	// Get all audiences with the given fragment
 	fragment := extractFragment(claims.Audience)
@@ -87,26 +106,13 @@ func (c *Collection) LoadByToken(token *jose.JSONWebToken, claims *jose.Claims)
 		return c.Load(claims.Issuer + ":" + token.Headers[0].KeyID)
 	}
 
-	// The ID will be just the clientID stored in azp, aud or tid.
-	var payload loadByTokenPayload
-	if err := token.UnsafeClaimsWithoutVerification(&payload); err != nil {
-		return nil, false
-	}
-
-	// Kubernetes Service Account tokens.
-	if len(payload.ServiceAccountName) > 0 {
-		if p, ok := c.Load(K8sSAID); ok {
-			return p, ok
-		}
-		// Kubernetes service account provisioner not found
-		return nil, false
-	}
-
 	// Audience is required for non k8sSA tokens.
 	if len(payload.Audience) == 0 {
 		return nil, false
 	}
 
+	// The ID will be just the clientID stored in azp, aud or tid.
+
 	// Try with azp (OIDC)
 	if len(payload.AuthorizedParty) > 0 {
 		if p, ok := c.Load(payload.AuthorizedParty); ok {
@@ -145,6 +151,20 @@ func (c *Collection) LoadByCertificate(cert *x509.Certificate) (Interface, bool)
 				return c.Load("x5c/" + string(provisioner.Name))
 			case TypeK8sSA:
 				return c.Load(K8sSAID)
+			case TypeGitHubActions:
+				return c.Load("github-actions/" + string(provisioner.Name))
+			case TypeVault:
+				return c.Load("vault/" + string(provisioner.Name))
+			case TypeTPM:
+				return c.Load("tpm/" + string(provisioner.Name))
+			case TypeSCEP:
+				return c.Load("scep/" + string(provisioner.Name))
+			case TypeCMP:
+				return c.Load("cmp/" + string(provisioner.Name))
+			case TypeNebula:
+				return c.Load("nebula/" + string(provisioner.Name))
+			case TypeSubCA:
+				return c.Load("subca/" + string(provisioner.Name))
 			default:
 				return c.Load(string(provisioner.CredentialID))
 			}
@@ -175,6 +195,26 @@ func (c *Collection) Store(p Interface) error {
 		return errors.New("cannot add multiple provisioners with the same id")
 	}
 
+	// Store the additional client IDs of OIDC provisioners, the tokens of
+	// any of them are loaded using their azp or aud.
+	if o, ok := p.(*OIDC); ok {
+		for _, a := range o.Audiences {
+			if _, loaded := c.byID.LoadOrStore(a.ClientID, p); loaded {
+				return errors.New("cannot add multiple provisioners with the same id")
+			}
+		}
+	}
+
+	// Store the additional keys of JWK provisioners, the tokens signed with any
+	// of them are loaded using <issuer>:<kid>.
+	if j, ok := p.(*JWK); ok {
+		for _, k := range j.GetKeys() {
+			if err := c.StoreJWKKey(j, k.Key.KeyID); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Store provisioner in byKey if EncryptedKey is defined.
 	if kid, _, ok := p.GetEncryptedKey(); ok {
 		c.byKey.Store(kid, p)
@@ -184,10 +224,13 @@ func (c *Collection) Store(p Interface) error {
 	// Use the first 4 bytes (32bit) of the sum to insert the order
 	// Using big endian format to get the strings sorted:
 	// 0x00000000, 0x00000001, 0x00000002, ...
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	bi := make([]byte, 4)
 	sum := provisionerSum(p)
-	binary.BigEndian.PutUint32(bi, uint32(c.sorted.Len()))
+	binary.BigEndian.PutUint32(bi, c.count)
 	sum[0], sum[1], sum[2], sum[3] = bi[0], bi[1], bi[2], bi[3]
+	c.count++
 	c.sorted = append(c.sorted, uidProvisioner{
 		provisioner: p,
 		uid:         hex.EncodeToString(sum),
@@ -196,6 +239,40 @@ func (c *Collection) Store(p Interface) error {
 	return nil
 }
 
+// Remove deletes a provisioner from the collection, including the additional
+// ids and keys used to load it. Only the entries pointing to the given
+// provisioner are deleted, so it can be used to undo a failed Store.
+func (c *Collection) Remove(p Interface) {
+	remove := func(m *sync.Map) {
+		m.Range(func(k, v interface{}) bool {
+			if v == p {
+				m.Delete(k)
+			}
+			return true
+		})
+	}
+	remove(c.byID)
+	remove(c.byKey)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i := range c.sorted {
+		if c.sorted[i].provisioner == p {
+			c.sorted = append(c.sorted[:i], c.sorted[i+1:]...)
+			break
+		}
+	}
+}
+
+// StoreJWKKey indexes a JWK provisioner by an additional key id. It is used
+// when a key is added to a provisioner already in the collection.
+func (c *Collection) StoreJWKKey(p *JWK, kid string) error {
+	if v, loaded := c.byID.LoadOrStore(p.Name+":"+kid, p); loaded && v != p {
+		return errors.New("cannot add multiple provisioners with the same id")
+	}
+	return nil
+}
+
 // Find implements pagination on a list of sorted provisioners.
 func (c *Collection) Find(cursor string, limit int) (List, string) {
 	switch {
@@ -205,6 +282,9 @@ func (c *Collection) Find(cursor string, limit int) (List, string) {
 		limit = DefaultProvisionersMax
 	}
 
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	n := c.sorted.Len()
 	cursor = fmt.Sprintf("%040s", cursor)
 	i := sort.Search(n, func(i int) bool { return c.sorted[i].uid >= cursor })
@@ -105,6 +105,11 @@ func TestCollection_LoadByToken(t *testing.T) {
 	t5, c5, err := parseToken(token)
 	assert.FatalError(t, err)
 
+	token, err = generateK8sSAToken(jwk, getK8sSABoundPayload("https://kubernetes.default.svc", testAudiences.Sign))
+	assert.FatalError(t, err)
+	t6, c6, err := parseToken(token)
+	assert.FatalError(t, err)
+
 	type fields struct {
 		byID      *sync.Map
 		audiences Audiences
@@ -124,9 +129,11 @@ func TestCollection_LoadByToken(t *testing.T) {
 		{"ok2", fields{byID, testAudiences}, args{t2, c2}, p2, true},
 		{"ok3", fields{byID, testAudiences}, args{t3, c3}, p3, true},
 		{"ok4", fields{byID, testAudiences}, args{t5, c5}, p4, true},
+		{"ok5", fields{byID, testAudiences}, args{t6, c6}, p4, true},
 		{"bad", fields{byID, testAudiences}, args{t4, c4}, nil, false},
 		{"fail", fields{byID, Audiences{Sign: []string{"https://foo"}}}, args{t1, c1}, nil, false},
 		{"fail-no-k8sSa-provisioner", fields{byID2, testAudiences}, args{t5, c5}, nil, false},
+		{"fail-no-k8sSa-provisioner-bound", fields{byID2, testAudiences}, args{t6, c6}, nil, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -269,6 +276,18 @@ func TestCollection_Store(t *testing.T) {
 	assert.FatalError(t, err)
 	p2, err := generateOIDC()
 	assert.FatalError(t, err)
+	p3, err := generateOIDC()
+	assert.FatalError(t, err)
+	p3.Audiences = []*OIDCAudience{{ClientID: "web-client"}}
+	p4, err := generateOIDC()
+	assert.FatalError(t, err)
+	p4.Audiences = []*OIDCAudience{{ClientID: "web-client"}}
+	p5, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public()
+	assert.FatalError(t, p5.AddKey(&JWKKey{Key: &pub}))
 
 	type args struct {
 		p Interface
@@ -280,8 +299,11 @@ func TestCollection_Store(t *testing.T) {
 	}{
 		{"ok1", args{p1}, false},
 		{"ok2", args{p2}, false},
+		{"ok3", args{p3}, false},
+		{"ok4", args{p5}, false},
 		{"fail1", args{p1}, true},
 		{"fail2", args{p2}, true},
+		{"fail3", args{p4}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -290,6 +312,57 @@ func TestCollection_Store(t *testing.T) {
 			}
 		})
 	}
+
+	// Additional client IDs load the same provisioner
+	got, ok := c.Load("web-client")
+	assert.True(t, ok)
+	assert.Equals(t, p3, got)
+
+	// Additional JWK keys load the same provisioner
+	got, ok = c.Load(p5.Name + ":" + pub.KeyID)
+	assert.True(t, ok)
+	assert.Equals(t, p5, got)
+	assert.FatalError(t, c.StoreJWKKey(p5, pub.KeyID))
+	p1.Name = p5.Name
+	assert.Equals(t, "cannot add multiple provisioners with the same id", c.StoreJWKKey(p1, pub.KeyID).Error())
+}
+
+func TestCollection_Remove(t *testing.T) {
+	c := NewCollection(testAudiences)
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	p2, err := generateOIDC()
+	assert.FatalError(t, err)
+	p2.Audiences = []*OIDCAudience{{ClientID: "web-client"}}
+	p3, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public()
+	assert.FatalError(t, p3.AddKey(&JWKKey{Key: &pub}))
+	for _, p := range []Interface{p1, p2, p3} {
+		assert.FatalError(t, c.Store(p))
+	}
+
+	c.Remove(p2)
+	c.Remove(p3)
+	for _, id := range []string{p2.GetID(), "web-client", p3.GetID(), p3.Name + ":" + pub.KeyID} {
+		_, ok := c.Load(id)
+		assert.False(t, ok, id)
+	}
+	kid, _, _ := p3.GetEncryptedKey()
+	_, ok := c.LoadEncryptedKey(kid)
+	assert.False(t, ok)
+	list, _ := c.Find("", 0)
+	assert.Equals(t, List{p1}, list)
+	got, ok := c.Load(p1.GetID())
+	assert.True(t, ok)
+	assert.Equals(t, p1, got)
+
+	// A removed provisioner can be stored again
+	assert.FatalError(t, c.Store(p2))
+	list, _ = c.Find("", 0)
+	assert.Equals(t, List{p1, p2}, list)
 }
 
 func TestCollection_Find(t *testing.T) {
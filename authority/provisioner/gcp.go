@@ -3,9 +3,7 @@ package provisioner
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"crypto/x509"
-	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -27,10 +25,12 @@ const gcpIdentityURL = "http://metadata/computeMetadata/v1/instance/service-acco
 // gcpPayload extends jwt.Claims with custom GCP attributes.
 type gcpPayload struct {
 	jose.Claims
-	AuthorizedParty string           `json:"azp"`
-	Email           string           `json:"email"`
-	EmailVerified   bool             `json:"email_verified"`
-	Google          gcpGooglePayload `json:"google"`
+	AuthorizedParty string                 `json:"azp"`
+	Email           string                 `json:"email"`
+	EmailVerified   bool                   `json:"email_verified"`
+	Google          gcpGooglePayload       `json:"google"`
+	Kubernetes      *k8sSAKubernetesClaims `json:"kubernetes.io,omitempty"`
+	cluster         string
 }
 
 type gcpGooglePayload struct {
@@ -48,14 +48,16 @@ type gcpComputeEnginePayload struct {
 }
 
 type gcpConfig struct {
-	CertsURL    string
-	IdentityURL string
+	CertsURL     string
+	IdentityURL  string
+	ContainerURL string
 }
 
 func newGCPConfig() *gcpConfig {
 	return &gcpConfig{
-		CertsURL:    gcpCertsURL,
-		IdentityURL: gcpIdentityURL,
+		CertsURL:     gcpCertsURL,
+		IdentityURL:  gcpIdentityURL,
+		ContainerURL: gcpContainerURL,
 	}
 }
 
@@ -72,22 +74,47 @@ func newGCPConfig() *gcpConfig {
 // If InstanceAge is set, only the instances with an instance_creation_timestamp
 // within the given period will be accepted.
 //
+// If EnableServiceAccountTokens is true, the Google-signed ID tokens of service
+// accounts without an instance identity, like the ones of Cloud Run or GKE
+// workload identity, will be accepted. These tokens must match the
+// ServiceAccounts or ProjectIDs constraints, and the project is only known for
+// user-managed service accounts.
+//
+// If GKEClusters is set, the kubernetes service account tokens issued by those
+// clusters will be accepted; Namespaces and Pods are patterns that constrain
+// the namespace and the pod bound to the tokens.
+//
+// If SSHHostPrincipals is set, the principals of the SSH host certificates are
+// restricted to the given instance metadata fields, and they are used as the
+// default principals. The supported fields are privateDNS, the global and
+// zonal internal DNS names, instanceName and instanceID.
+//
 // Google Identity docs are available at
 // https://cloud.google.com/compute/docs/instances/verifying-instance-identity
 type GCP struct {
 	*base
-	Type                   string   `json:"type"`
-	Name                   string   `json:"name"`
-	ServiceAccounts        []string `json:"serviceAccounts"`
-	ProjectIDs             []string `json:"projectIDs"`
-	DisableCustomSANs      bool     `json:"disableCustomSANs"`
-	DisableTrustOnFirstUse bool     `json:"disableTrustOnFirstUse"`
-	InstanceAge            Duration `json:"instanceAge,omitempty"`
-	Claims                 *Claims  `json:"claims,omitempty"`
-	claimer                *Claimer
-	config                 *gcpConfig
-	keyStore               *keyStore
-	audiences              Audiences
+	Type                       string              `json:"type"`
+	Name                       string              `json:"name"`
+	ServiceAccounts            []string            `json:"serviceAccounts"`
+	ProjectIDs                 []string            `json:"projectIDs"`
+	DisableCustomSANs          bool                `json:"disableCustomSANs"`
+	DisableTrustOnFirstUse     bool                `json:"disableTrustOnFirstUse"`
+	InstanceAge                Duration            `json:"instanceAge,omitempty"`
+	EnableServiceAccountTokens bool                `json:"enableServiceAccountTokens,omitempty"`
+	GKEClusters                []string            `json:"gkeClusters,omitempty"`
+	Namespaces                 []string            `json:"namespaces,omitempty"`
+	Pods                       []string            `json:"pods,omitempty"`
+	SSHHostPrincipals          []string            `json:"sshHostPrincipals,omitempty"`
+	Claims                     *Claims             `json:"claims,omitempty"`
+	Options                    *CertificateOptions `json:"options,omitempty"`
+	HTTPClient                 *HTTPClientOptions  `json:"httpClient,omitempty"`
+	KeyStore                   *KeyStoreOptions    `json:"keyStore,omitempty"`
+	claimer                    *Claimer
+	config                     *gcpConfig
+	keyStore                   *keyStore
+	clusterKeyStores           map[string]*keyStore
+	client                     *http.Client
+	audiences                  Audiences
 }
 
 // GetID returns the provisioner unique identifier. The name should uniquely
@@ -96,33 +123,38 @@ func (p *GCP) GetID() string {
 	return "gcp/" + p.Name
 }
 
-// GetTokenID returns the identifier of the token. The default value for GCP the
-// SHA256 of "provisioner_id.instance_id", but if DisableTrustOnFirstUse is set
-// to true, then it will be the SHA256 of the token.
+// GetTokenID returns the identifier of the token, the SHA256 of the token, so
+// it cannot be reused. The instance of the token is recorded separately after
+// the token is authorized, see GetInstanceID.
 func (p *GCP) GetTokenID(token string) (string, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return "", errors.Wrap(err, "error parsing token")
 	}
-
-	// If TOFU is disabled create an ID for the token, so it cannot be reused.
-	if p.DisableTrustOnFirstUse {
-		sum := sha256.Sum256([]byte(token))
-		return strings.ToLower(hex.EncodeToString(sum[:])), nil
-	}
-
-	// Get claims w/out verification.
 	var claims gcpPayload
 	if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
 		return "", errors.Wrap(err, "error verifying claims")
 	}
+	return tokenKey(token), nil
+}
 
-	// Create unique ID for Trust On First Use (TOFU). Only the first instance
-	// per provisioner is allowed as we don't have a way to trust the given
-	// sans.
-	unique := fmt.Sprintf("%s.%s", p.GetID(), claims.Google.ComputeEngine.InstanceID)
-	sum := sha256.Sum256([]byte(unique))
-	return strings.ToLower(hex.EncodeToString(sum[:])), nil
+// GetInstanceID returns the key used to record the instance of the token, the
+// SHA256 of "provisioner_id.instance_id". Only the first instance per
+// provisioner is allowed as we don't have a way to trust the given sans.
+// Service account and workload tokens do not identify an instance.
+func (p *GCP) GetInstanceID(token string) (string, bool) {
+	if p.DisableTrustOnFirstUse {
+		return "", false
+	}
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", false
+	}
+	var claims gcpPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil || !claims.isInstance() {
+		return "", false
+	}
+	return instanceKey(p.GetID(), claims.Google.ComputeEngine.InstanceID), true
 }
 
 // GetName returns the name of the provisioner.
@@ -140,6 +172,22 @@ func (p *GCP) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetKeyStoreStats returns the statistics of the keys used to validate the
+// tokens.
+func (p *GCP) GetKeyStoreStats() KeyStoreStats {
+	return p.keyStore.Stats()
+}
+
+// Close releases the keys used to validate the tokens, including the keys of
+// the GKE clusters.
+func (p *GCP) Close() error {
+	releaseKeyStore(p.keyStore)
+	for _, ks := range p.clusterKeyStores {
+		releaseKeyStore(ks)
+	}
+	return nil
+}
+
 // GetIdentityURL returns the url that generates the GCP token.
 func (p *GCP) GetIdentityURL(audience string) string {
 	// Initialize config if required
@@ -164,7 +212,11 @@ func (p *GCP) GetIdentityToken(subject, caURL string) (string, error) {
 		return "", errors.Wrap(err, "error creating identity request")
 	}
 	req.Header.Set("Metadata-Flavor", "Google")
-	resp, err := http.DefaultClient.Do(req)
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", errors.Wrap(err, "error doing identity request, are you in a GCP VM?")
 	}
@@ -190,6 +242,18 @@ func (p *GCP) Init(config Config) error {
 	case p.InstanceAge.Value() < 0:
 		return errors.New("provisioner instanceAge cannot be negative")
 	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	if err := p.validateWorkloads(); err != nil {
+		return err
+	}
+	if err := validateSSHHostPrincipals(p.SSHHostPrincipals, sshPrincipalPrivateDNS,
+		sshPrincipalInstanceName, sshPrincipalInstanceID); err != nil {
+		return err
+	}
 	// Initialize config
 	p.assertConfig()
 	// Update claims with global ones
@@ -197,10 +261,17 @@ func (p *GCP) Init(config Config) error {
 		return err
 	}
 	// Initialize key store
-	p.keyStore, err = newKeyStore(p.config.CertsURL)
+	clientOpts := p.HTTPClient.withDefaultProxy(config.Proxy)
+	if p.client, err = newHTTPClient(clientOpts); err != nil {
+		return err
+	}
+	p.keyStore, err = getKeyStore(context.Background(), p.client, clientOpts, p.config.CertsURL, p.KeyStore)
 	if err != nil {
 		return err
 	}
+	if err := p.initClusterKeyStores(clientOpts); err != nil {
+		return err
+	}
 
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
@@ -209,10 +280,17 @@ func (p *GCP) Init(config Config) error {
 // AuthorizeSign validates the given token and returns the sign options that
 // will be used on certificate creation.
 func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("gcp.AuthorizeSign; issuance is disabled for gcp provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	claims, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSign")
 	}
+	if !claims.isInstance() {
+		return withX509CertificateOptions(p.Options, p.authorizeWorkloadSign(claims), newTemplateData(token, claims.Subject, nil)), nil
+	}
 
 	ce := claims.Google.ComputeEngine
 	// Enforce known common name and default DNS if configured.
@@ -230,20 +308,29 @@ func (p *GCP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		}))
 	}
 
-	return append(so,
+	return withX509CertificateOptions(p.Options, append(so,
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "InstanceID", ce.InstanceID, "InstanceName", ce.InstanceName),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	), nil
+		newSANDurationValidator(p.claimer),
+	), newTemplateData(token, claims.Subject, nil)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
 func (p *GCP) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("gcp.AuthorizeRenew; renew is disabled for gcp provisioner %s", p.GetID())
+		return errs.Unauthorized("gcp.AuthorizeRenew; renew is disabled for gcp provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "gcp.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "gcp.AuthorizeRenew")
 	}
 	return nil
 }
@@ -267,10 +354,21 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 		return nil, errs.Unauthorized("gcp.authorizeToken; error parsing gcp token - header is missing")
 	}
 
-	var found bool
+	// Get the issuer w/out verification to select the keys, GKE clusters
+	// have their own keys.
 	var claims gcpPayload
+	if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "gcp.authorizeToken; error parsing gcp token payload")
+	}
+	ks, issuer := p.keyStore, gcpIssuer
+	if cks, ok := p.clusterKeyStores[claims.Issuer]; ok {
+		ks, issuer = cks, claims.Issuer
+	}
+
+	var found bool
+	claims = gcpPayload{}
 	kid := jwt.Headers[0].KeyID
-	keys := p.keyStore.Get(kid)
+	keys := ks.Get(kid)
 	for _, key := range keys {
 		if err := jwt.Claims(key.Public(), &claims); err == nil {
 			found = true
@@ -285,9 +383,9 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 	// more than a few minutes.
 	now := time.Now().UTC()
 	if err = claims.ValidateWithLeeway(jose.Expected{
-		Issuer: "https://accounts.google.com",
+		Issuer: issuer,
 		Time:   now,
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "gcp.authorizeToken; invalid gcp token payload")
 	}
 
@@ -296,8 +394,12 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 		return nil, errs.Unauthorized("gcp.authorizeToken; invalid gcp token - invalid audience claim (aud)")
 	}
 
-	// validate subject (service account)
-	if len(p.ServiceAccounts) > 0 {
+	if issuer != gcpIssuer {
+		claims.cluster = strings.TrimPrefix(issuer, p.config.ContainerURL)
+	}
+
+	// validate subject (service account), it does not apply to GKE workloads
+	if len(p.ServiceAccounts) > 0 && !claims.isWorkload() {
 		var found bool
 		for _, sa := range p.ServiceAccounts {
 			if sa == claims.Subject || sa == claims.Email {
@@ -314,7 +416,7 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 	if len(p.ProjectIDs) > 0 {
 		var found bool
 		for _, pi := range p.ProjectIDs {
-			if pi == claims.Google.ComputeEngine.ProjectID {
+			if pi == claims.projectID() {
 				found = true
 				break
 			}
@@ -324,11 +426,17 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 		}
 	}
 
-	// validate instance age
-	if d := p.InstanceAge.Value(); d > 0 {
-		if now.Sub(claims.Google.ComputeEngine.InstanceCreationTimestamp.Time()) > d {
-			return nil, errs.Unauthorized("gcp.authorizeToken; token google.compute_engine.instance_creation_timestamp is too old")
+	// validate service account and workload tokens
+	if !claims.isInstance() {
+		if err := p.authorizeWorkload(&claims); err != nil {
+			return nil, err
 		}
+		return &claims, nil
+	}
+
+	// validate instance age
+	if isInstanceTooOld(p.InstanceAge, claims.Google.ComputeEngine.InstanceCreationTimestamp.Time(), now) {
+		return nil, errs.Unauthorized("gcp.authorizeToken; token google.compute_engine.instance_creation_timestamp is too old")
 	}
 
 	switch {
@@ -345,15 +453,48 @@ func (p *GCP) authorizeToken(token string) (*gcpPayload, error) {
 	return &claims, nil
 }
 
+// sshHostPrincipals returns the principals of the SSH host certificates of the
+// instance, the values of the metadata fields in SSHHostPrincipals.
+func (p *GCP) sshHostPrincipals(ce gcpComputeEnginePayload) []string {
+	var principals []string
+	for _, field := range p.SSHHostPrincipals {
+		switch field {
+		case sshPrincipalPrivateDNS:
+			principals = append(principals, gcpPrivateDNS(ce)...)
+		case sshPrincipalInstanceName:
+			principals = append(principals, ce.InstanceName)
+		case sshPrincipalInstanceID:
+			principals = append(principals, ce.InstanceID)
+		}
+	}
+	return principals
+}
+
+// gcpPrivateDNS returns the global and zonal internal DNS names of an
+// instance.
+func gcpPrivateDNS(ce gcpComputeEnginePayload) []string {
+	return []string{
+		fmt.Sprintf("%s.c.%s.internal", ce.InstanceName, ce.ProjectID),
+		fmt.Sprintf("%s.%s.c.%s.internal", ce.InstanceName, ce.Zone, ce.ProjectID),
+	}
+}
+
 // AuthorizeSSHSign returns the list of SignOption for a SignSSH request.
 func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("gcp.AuthorizeSSHSign; sshCA is disabled for gcp provisioner %s", p.GetID())
 	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("gcp.AuthorizeSSHSign; issuance is disabled for gcp provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	claims, err := p.authorizeToken(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "gcp.AuthorizeSSHSign")
 	}
+	if !claims.isInstance() {
+		return nil, errs.Unauthorized("gcp.AuthorizeSSHSign; ssh certificates require a gcp instance identity token")
+	}
 
 	ce := claims.Google.ComputeEngine
 
@@ -362,13 +503,14 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		sshCertKeyIDModifier(ce.InstanceName),
 	}
 
-	// Only enforce known principals if disable custom sans is true.
+	// Enforce the principals in the configured metadata fields, or only
+	// enforce known principals if disable custom sans is true.
 	var principals []string
-	if p.DisableCustomSANs {
-		principals = []string{
-			fmt.Sprintf("%s.c.%s.internal", ce.InstanceName, ce.ProjectID),
-			fmt.Sprintf("%s.%s.c.%s.internal", ce.InstanceName, ce.Zone, ce.ProjectID),
-		}
+	switch {
+	case len(p.SSHHostPrincipals) > 0:
+		principals = p.sshHostPrincipals(ce)
+	case p.DisableCustomSANs:
+		principals = gcpPrivateDNS(ce)
 	}
 
 	// Default to host + known hostnames
@@ -381,16 +523,17 @@ func (p *GCP) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Set defaults if not given as user options
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	return withSSHCertificateOptions(p.Options, append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
+		backdateOption(p.claimer.Backdate()),
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	), newTemplateData(token, ce.InstanceName, principals)), nil
 }
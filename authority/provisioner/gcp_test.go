@@ -69,7 +69,7 @@ func TestGCP_GetTokenID(t *testing.T) {
 		now, &p2.keyStore.keySet.Keys[0])
 	assert.FatalError(t, err)
 
-	sum := sha256.Sum256([]byte("gcp/name.instance-id"))
+	sum := sha256.Sum256([]byte(t1))
 	want1 := strings.ToLower(hex.EncodeToString(sum[:]))
 	sum = sha256.Sum256([]byte(t2))
 	want2 := strings.ToLower(hex.EncodeToString(sum[:]))
@@ -103,6 +103,59 @@ func TestGCP_GetTokenID(t *testing.T) {
 	}
 }
 
+func TestGCP_GetInstanceID(t *testing.T) {
+	p1, err := generateGCP()
+	assert.FatalError(t, err)
+	p1.Name = "name"
+
+	p2, err := generateGCP()
+	assert.FatalError(t, err)
+	p2.DisableTrustOnFirstUse = true
+
+	now := time.Now()
+	t1, err := generateGCPToken(p1.ServiceAccounts[0],
+		"https://accounts.google.com", "gcp/name",
+		"instance-id", "instance-name", "project-id", "zone",
+		now, &p1.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+	t2, err := generateGCPToken(p2.ServiceAccounts[0],
+		"https://accounts.google.com", p2.GetID(),
+		"instance-id", "instance-name", "project-id", "zone",
+		now, &p2.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+	t3, err := generateGCPWorkloadToken("https://accounts.google.com", p1.GetID(),
+		gcpServiceAccountClaims("signer@project-id.iam.gserviceaccount.com", true),
+		&p1.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256([]byte("gcp/name.instance-id"))
+	want1 := strings.ToLower(hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		name   string
+		gcp    *GCP
+		token  string
+		want   string
+		wantOk bool
+	}{
+		{"ok", p1, t1, want1, true},
+		{"no TOFU", p2, t2, "", false},
+		{"service account", p1, t3, "", false},
+		{"bad token", p1, "token", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.gcp.GetInstanceID(tt.token)
+			if ok != tt.wantOk {
+				t.Errorf("GCP.GetInstanceID() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got != tt.want {
+				t.Errorf("GCP.GetInstanceID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestGCP_GetIdentityToken(t *testing.T) {
 	p1, err := generateGCP()
 	assert.FatalError(t, err)
@@ -515,9 +568,9 @@ func TestGCP_AuthorizeSign(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{t1}, 4, http.StatusOK, false},
-		{"ok", p2, args{t2}, 6, http.StatusOK, false},
-		{"ok", p3, args{t3}, 4, http.StatusOK, false},
+		{"ok", p1, args{t1}, 7, http.StatusOK, false},
+		{"ok", p2, args{t2}, 9, http.StatusOK, false},
+		{"ok", p3, args{t3}, 7, http.StatusOK, false},
 		{"fail token", p1, args{"token"}, 0, http.StatusUnauthorized, true},
 		{"fail key", p1, args{failKey}, 0, http.StatusUnauthorized, true},
 		{"fail iss", p1, args{failIss}, 0, http.StatusUnauthorized, true},
@@ -691,8 +744,8 @@ func TestGCP_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renewal-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{generateRenewCertificate()}, http.StatusOK, false},
+		{"fail/renewal-disabled", p2, args{generateRenewCertificate()}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -706,3 +759,27 @@ func TestGCP_AuthorizeRenew(t *testing.T) {
 		})
 	}
 }
+
+func TestGCP_sshHostPrincipals(t *testing.T) {
+	ce := gcpComputeEnginePayload{
+		InstanceID:   "instance-id",
+		InstanceName: "instance-name",
+		ProjectID:    "project-id",
+		Zone:         "zone",
+	}
+	tests := []struct {
+		name   string
+		fields []string
+		want   []string
+	}{
+		{"privateDNS", []string{"privateDNS"}, []string{"instance-name.c.project-id.internal", "instance-name.zone.c.project-id.internal"}},
+		{"instance", []string{"instanceID", "instanceName"}, []string{"instance-id", "instance-name"}},
+		{"all", []string{"instanceName", "privateDNS", "instanceID"}, []string{"instance-name", "instance-name.c.project-id.internal", "instance-name.zone.c.project-id.internal", "instance-id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GCP{SSHHostPrincipals: tt.fields}
+			assert.Equals(t, tt.want, p.sshHostPrincipals(ce))
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package provisioner
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+)
+
+// gcpIssuer is the issuer of the Google-signed ID tokens.
+const gcpIssuer = "https://accounts.google.com"
+
+// gcpContainerURL is the base url of the GKE clusters, it is used as the
+// issuer of the kubernetes service account tokens of a cluster.
+const gcpContainerURL = "https://container.googleapis.com/v1/"
+
+// gcpServiceAccountDomain is the domain of the emails of the user-managed
+// service accounts, the subdomain is the project id.
+const gcpServiceAccountDomain = ".iam.gserviceaccount.com"
+
+// gcpClusterRegexp matches the resource name of a GKE cluster.
+var gcpClusterRegexp = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/clusters/[^/]+$`)
+
+// isInstance returns true if the token is an instance identity token.
+func (c *gcpPayload) isInstance() bool {
+	return c.Google.ComputeEngine.InstanceID != ""
+}
+
+// isWorkload returns true if the token is a kubernetes service account token
+// issued by a GKE cluster.
+func (c *gcpPayload) isWorkload() bool {
+	return c.cluster != ""
+}
+
+// projectID returns the project of the token. Service account tokens only
+// contain the project in the email of the user-managed service accounts.
+func (c *gcpPayload) projectID() string {
+	switch {
+	case c.isInstance():
+		return c.Google.ComputeEngine.ProjectID
+	case c.isWorkload():
+		return strings.SplitN(c.cluster, "/", 3)[1]
+	case strings.HasSuffix(c.Email, gcpServiceAccountDomain):
+		if i := strings.LastIndex(c.Email, "@"); i > 0 {
+			return strings.TrimSuffix(c.Email[i+1:], gcpServiceAccountDomain)
+		}
+	}
+	return ""
+}
+
+// podName returns the name of the pod bound to a GKE workload token, if any.
+func (c *gcpPayload) podName() string {
+	if c.Kubernetes != nil && c.Kubernetes.Pod != nil {
+		return c.Kubernetes.Pod.Name
+	}
+	return ""
+}
+
+// validateWorkloads validates the options used to accept service account and
+// GKE workload tokens.
+func (p *GCP) validateWorkloads() error {
+	if p.EnableServiceAccountTokens && len(p.ServiceAccounts) == 0 && len(p.ProjectIDs) == 0 {
+		return errors.New("provisioner enableServiceAccountTokens requires serviceAccounts or projectIDs")
+	}
+	seen := make(map[string]bool, len(p.GKEClusters))
+	for _, cluster := range p.GKEClusters {
+		if !gcpClusterRegexp.MatchString(cluster) {
+			return errors.Errorf("provisioner gkeClusters %s is not valid, it must be projects/<project>/locations/<location>/clusters/<cluster>", cluster)
+		}
+		if seen[cluster] {
+			return errors.Errorf("provisioner gkeClusters %s is duplicated", cluster)
+		}
+		seen[cluster] = true
+	}
+	if len(p.GKEClusters) == 0 && (len(p.Namespaces) > 0 || len(p.Pods) > 0) {
+		return errors.New("provisioner namespaces and pods require gkeClusters")
+	}
+	for _, pattern := range append(append([]string{}, p.Namespaces...), p.Pods...) {
+		if pattern == "" {
+			return errors.New("provisioner namespaces and pods cannot contain empty patterns")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "error parsing pattern %s", pattern)
+		}
+	}
+	return nil
+}
+
+// initClusterKeyStores initializes the key stores with the keys of the GKE
+// clusters.
+func (p *GCP) initClusterKeyStores(clientOpts *HTTPClientOptions) error {
+	// The cache file is only used for the Google keys.
+	var opts *KeyStoreOptions
+	if p.KeyStore != nil {
+		o := *p.KeyStore
+		o.CacheFile = ""
+		opts = &o
+	}
+	p.clusterKeyStores = make(map[string]*keyStore, len(p.GKEClusters))
+	for _, cluster := range p.GKEClusters {
+		ks, err := getKeyStore(context.Background(), p.client, clientOpts, p.config.ContainerURL+cluster+"/jwks", opts)
+		if err != nil {
+			return errors.Wrapf(err, "error initializing keys of gke cluster %s", cluster)
+		}
+		p.clusterKeyStores[p.config.ContainerURL+cluster] = ks
+	}
+	return nil
+}
+
+// authorizeWorkload validates the service account and GKE workload constraints
+// of a token without an instance identity.
+func (p *GCP) authorizeWorkload(claims *gcpPayload) error {
+	if !claims.isWorkload() {
+		switch {
+		case !p.EnableServiceAccountTokens:
+			// Only instance identity tokens are accepted by default.
+			return errs.Unauthorized("gcp.authorizeToken; gcp token google.compute_engine.instance_id cannot be empty")
+		case claims.Email == "" || !claims.EmailVerified:
+			return errs.Unauthorized("gcp.authorizeToken; gcp token email cannot be empty or unverified")
+		}
+		return nil
+	}
+
+	k := claims.Kubernetes
+	if k == nil || k.Namespace == "" || k.ServiceAccount.Name == "" {
+		return errs.Unauthorized("gcp.authorizeToken; gke token kubernetes.io claim is not valid")
+	}
+	if len(p.Namespaces) > 0 && !matchAnyPattern(p.Namespaces, k.Namespace) {
+		return errs.Unauthorized("gcp.authorizeToken; invalid gke token - invalid namespace")
+	}
+	if len(p.Pods) > 0 && !matchAnyPattern(p.Pods, claims.podName()) {
+		return errs.Unauthorized("gcp.authorizeToken; invalid gke token - invalid pod")
+	}
+	return nil
+}
+
+// authorizeWorkloadSign returns the sign options for a token without an
+// instance identity.
+func (p *GCP) authorizeWorkloadSign(claims *gcpPayload) []SignOption {
+	var so []SignOption
+	if p.DisableCustomSANs {
+		var emails []string
+		if !claims.isWorkload() {
+			emails = []string{claims.Email}
+		}
+		so = append(so,
+			commonNameValidator(claims.workloadName()),
+			dnsNamesValidator(nil),
+			emailAddressesValidator(emails),
+			ipAddressesValidator(nil),
+			urisValidator(nil),
+		)
+	}
+
+	var ext SignOption
+	if claims.isWorkload() {
+		ext = newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject,
+			"Cluster", claims.cluster, "Namespace", claims.Kubernetes.Namespace, "Pod", claims.podName())
+	} else {
+		ext = newProvisionerExtensionOption(TypeGCP, p.Name, claims.Subject, "ServiceAccount", claims.Email)
+	}
+
+	return append(so,
+		// modifiers / withOptions
+		ext,
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
+		// validators
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(p.claimer),
+	)
+}
+
+// workloadName returns the name used as the common name of the certificates:
+// the email of the service account, or the subject of the GKE workload.
+func (c *gcpPayload) workloadName() string {
+	if c.isWorkload() {
+		return c.Subject
+	}
+	return c.Email
+}
+
+// matchAnyPattern returns true if the value matches any of the patterns.
+func matchAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
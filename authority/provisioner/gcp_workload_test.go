@@ -0,0 +1,297 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+const testGKECluster = "projects/project-id/locations/us-central1/clusters/prod"
+
+func generateGCPWithWorkloads() (*GCP, error) {
+	p, err := generateGCP()
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := generateJSONWebKey()
+	if err != nil {
+		return nil, err
+	}
+	p.EnableServiceAccountTokens = true
+	p.ServiceAccounts = []string{"signer@project-id.iam.gserviceaccount.com"}
+	p.GKEClusters = []string{testGKECluster}
+	p.clusterKeyStores = map[string]*keyStore{
+		gcpContainerURL + testGKECluster: {
+			keySet: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{*jwk}},
+			expiry: time.Now().Add(24 * time.Hour),
+		},
+	}
+	return p, nil
+}
+
+func generateGCPWorkloadToken(iss, aud string, claims gcpPayload, jwk *jose.JSONWebKey) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+	)
+	if err != nil {
+		return "", err
+	}
+	aud, err = generateSignAudience("https://ca.smallstep.com", aud)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.Issuer = iss
+	claims.IssuedAt = jose.NewNumericDate(now)
+	claims.NotBefore = jose.NewNumericDate(now)
+	claims.Expiry = jose.NewNumericDate(now.Add(5 * time.Minute))
+	claims.Audience = []string{aud}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+func gcpServiceAccountClaims(email string, verified bool) gcpPayload {
+	return gcpPayload{
+		Claims:          jose.Claims{Subject: "112233445566778899"},
+		AuthorizedParty: "112233445566778899",
+		Email:           email,
+		EmailVerified:   verified,
+	}
+}
+
+func gkeWorkloadClaims(namespace, serviceAccount, pod string) gcpPayload {
+	k := &k8sSAKubernetesClaims{
+		Namespace:      namespace,
+		ServiceAccount: k8sSAObjectRefClaim{Name: serviceAccount, UID: "sa-uid"},
+	}
+	if pod != "" {
+		k.Pod = &k8sSAObjectRefClaim{Name: pod, UID: "pod-uid"}
+	}
+	return gcpPayload{
+		Claims:     jose.Claims{Subject: "system:serviceaccount:" + namespace + ":" + serviceAccount},
+		Kubernetes: k,
+	}
+}
+
+func Test_gcpPayload_projectID(t *testing.T) {
+	instance := gcpPayload{}
+	instance.Google.ComputeEngine.InstanceID = "instance-id"
+	instance.Google.ComputeEngine.ProjectID = "instance-project"
+	tests := []struct {
+		name   string
+		claims gcpPayload
+		want   string
+	}{
+		{"instance", instance, "instance-project"},
+		{"workload", gcpPayload{cluster: testGKECluster}, "project-id"},
+		{"service account", gcpPayload{Email: "signer@my-project.iam.gserviceaccount.com"}, "my-project"},
+		{"default service account", gcpPayload{Email: "123456789-compute@developer.gserviceaccount.com"}, ""},
+		{"user", gcpPayload{Email: "jane@example.com"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.projectID(); got != tt.want {
+				t.Errorf("gcpPayload.projectID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCP_Init_workloads(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	config := Config{
+		Claims: globalProvisionerClaims,
+	}
+	tests := []struct {
+		name                       string
+		enableServiceAccountTokens bool
+		serviceAccounts            []string
+		projectIDs                 []string
+		gkeClusters                []string
+		namespaces                 []string
+		pods                       []string
+		wantErr                    bool
+	}{
+		{"ok service accounts", true, []string{"signer@project-id.iam.gserviceaccount.com"}, nil, nil, nil, nil, false},
+		{"ok projects", true, nil, []string{"project-id"}, nil, nil, nil, false},
+		{"ok clusters", false, nil, nil, []string{testGKECluster}, []string{"prod-*"}, []string{"web-*"}, false},
+		{"fail unconstrained", true, nil, nil, nil, nil, nil, true},
+		{"fail cluster", false, nil, nil, []string{"prod"}, nil, nil, true},
+		{"fail duplicated cluster", false, nil, nil, []string{testGKECluster, testGKECluster}, nil, nil, true},
+		{"fail namespaces without clusters", false, nil, nil, nil, []string{"default"}, nil, true},
+		{"fail empty pod", false, nil, nil, []string{testGKECluster}, nil, []string{""}, true},
+		{"fail bad pattern", false, nil, nil, []string{testGKECluster}, []string{"["}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GCP{
+				Type:                       "GCP",
+				Name:                       "name",
+				ServiceAccounts:            tt.serviceAccounts,
+				ProjectIDs:                 tt.projectIDs,
+				EnableServiceAccountTokens: tt.enableServiceAccountTokens,
+				GKEClusters:                tt.gkeClusters,
+				Namespaces:                 tt.namespaces,
+				Pods:                       tt.pods,
+				config: &gcpConfig{
+					CertsURL:     srv.URL,
+					IdentityURL:  gcpIdentityURL,
+					ContainerURL: srv.URL + "/",
+				},
+			}
+			if err := p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("GCP.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equals(t, len(tt.gkeClusters), len(p.clusterKeyStores))
+			}
+		})
+	}
+}
+
+func TestGCP_authorizeToken_workloads(t *testing.T) {
+	p, err := generateGCPWithWorkloads()
+	assert.FatalError(t, err)
+	p.Namespaces = []string{"prod-*"}
+	p.Pods = []string{"web-*"}
+	googleKey := &p.keyStore.keySet.Keys[0]
+	clusterIssuer := gcpContainerURL + testGKECluster
+	clusterKey := &p.clusterKeyStores[clusterIssuer].keySet.Keys[0]
+
+	tests := []struct {
+		name   string
+		iss    string
+		claims gcpPayload
+		jwk    *jose.JSONWebKey
+		err    string
+	}{
+		{"ok service account", gcpIssuer, gcpServiceAccountClaims("signer@project-id.iam.gserviceaccount.com", true), googleKey, ""},
+		{"ok workload", clusterIssuer, gkeWorkloadClaims("prod-a", "web", "web-abc12"), clusterKey, ""},
+		{"fail service account", gcpIssuer, gcpServiceAccountClaims("other@project-id.iam.gserviceaccount.com", true), googleKey,
+			"gcp.authorizeToken; invalid gcp token - invalid subject claim"},
+		{"fail unverified email", gcpIssuer, gcpServiceAccountClaims("signer@project-id.iam.gserviceaccount.com", false), googleKey,
+			"gcp.authorizeToken; gcp token email cannot be empty or unverified"},
+		{"fail workload with google key", clusterIssuer, gkeWorkloadClaims("prod-a", "web", "web-abc12"), googleKey,
+			"gcp.authorizeToken; failed to validate gcp token payload - cannot find key for kid"},
+		{"fail unknown cluster", gcpContainerURL + "projects/project-id/locations/us-central1/clusters/dev", gkeWorkloadClaims("prod-a", "web", "web-abc12"), clusterKey,
+			"gcp.authorizeToken; failed to validate gcp token payload - cannot find key for kid"},
+		{"fail namespace", clusterIssuer, gkeWorkloadClaims("dev", "web", "web-abc12"), clusterKey,
+			"gcp.authorizeToken; invalid gke token - invalid namespace"},
+		{"fail pod", clusterIssuer, gkeWorkloadClaims("prod-a", "web", "db-abc12"), clusterKey,
+			"gcp.authorizeToken; invalid gke token - invalid pod"},
+		{"fail unbound pod", clusterIssuer, gkeWorkloadClaims("prod-a", "web", ""), clusterKey,
+			"gcp.authorizeToken; invalid gke token - invalid pod"},
+		{"fail kubernetes claim", clusterIssuer, gcpPayload{Claims: jose.Claims{Subject: "system:serviceaccount:prod-a:web"}}, clusterKey,
+			"gcp.authorizeToken; gke token kubernetes.io claim is not valid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := generateGCPWorkloadToken(tt.iss, p.GetID(), tt.claims, tt.jwk)
+			assert.FatalError(t, err)
+			claims, err := p.authorizeToken(tok)
+			if tt.err == "" {
+				assert.FatalError(t, err)
+				assert.Equals(t, tt.claims.Subject, claims.Subject)
+				return
+			}
+			if assert.NotNil(t, err) {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+				assert.HasPrefix(t, err.Error(), tt.err)
+			}
+		})
+	}
+
+	// Service account tokens must be enabled
+	p.EnableServiceAccountTokens = false
+	tok, err := generateGCPWorkloadToken(gcpIssuer, p.GetID(), gcpServiceAccountClaims("signer@project-id.iam.gserviceaccount.com", true), googleKey)
+	assert.FatalError(t, err)
+	_, err = p.authorizeToken(tok)
+	assert.Error(t, err)
+}
+
+func TestGCP_GetTokenID_workloads(t *testing.T) {
+	p, err := generateGCPWithWorkloads()
+	assert.FatalError(t, err)
+	tok, err := generateGCPWorkloadToken(gcpIssuer, p.GetID(), gcpServiceAccountClaims("signer@project-id.iam.gserviceaccount.com", true), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256([]byte(tok))
+	got, err := p.GetTokenID(tok)
+	assert.FatalError(t, err)
+	assert.Equals(t, hex.EncodeToString(sum[:]), got)
+}
+
+func TestGCP_AuthorizeSign_workloads(t *testing.T) {
+	p, err := generateGCPWithWorkloads()
+	assert.FatalError(t, err)
+	p.DisableCustomSANs = true
+	clusterKey := &p.clusterKeyStores[gcpContainerURL+testGKECluster].keySet.Keys[0]
+
+	saToken, err := generateGCPWorkloadToken(gcpIssuer, p.GetID(), gcpServiceAccountClaims("signer@project-id.iam.gserviceaccount.com", true), &p.keyStore.keySet.Keys[0])
+	assert.FatalError(t, err)
+	gkeToken, err := generateGCPWorkloadToken(gcpContainerURL+testGKECluster, p.GetID(), gkeWorkloadClaims("prod", "web", "web-abc12"), clusterKey)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name      string
+		token     string
+		cn        string
+		emails    []string
+		extension []string
+	}{
+		{"service account", saToken, "signer@project-id.iam.gserviceaccount.com", []string{"signer@project-id.iam.gserviceaccount.com"},
+			[]string{"ServiceAccount", "signer@project-id.iam.gserviceaccount.com"}},
+		{"workload", gkeToken, "system:serviceaccount:prod:web", nil,
+			[]string{"Cluster", testGKECluster, "Namespace", "prod", "Pod", "web-abc12"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := p.AuthorizeSign(context.Background(), tt.token)
+			assert.FatalError(t, err)
+			var found int
+			for _, o := range opts {
+				switch v := o.(type) {
+				case commonNameValidator:
+					assert.Equals(t, tt.cn, string(v))
+					found++
+				case emailAddressesValidator:
+					assert.Equals(t, tt.emails, []string(v))
+					found++
+				case *provisionerExtensionOption:
+					assert.Equals(t, tt.extension, v.KeyValuePairs)
+					found++
+				}
+			}
+			assert.Equals(t, 3, found)
+
+			_, err = p.AuthorizeSSHSign(context.Background(), tt.token)
+			if assert.NotNil(t, err) {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+			}
+		})
+	}
+
+	// Certificates with other names are not valid
+	opts, err := p.AuthorizeSign(context.Background(), saToken)
+	assert.FatalError(t, err)
+	for _, o := range opts {
+		if v, ok := o.(commonNameValidator); ok {
+			assert.Error(t, v.Valid(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "other@project-id.iam.gserviceaccount.com"}}))
+		}
+	}
+}
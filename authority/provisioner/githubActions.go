@@ -0,0 +1,422 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// githubActionsIssuer is the issuer of the GitHub Actions OIDC tokens.
+const githubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// githubActionsCertsURL is the url that serves the GitHub Actions public keys.
+const githubActionsCertsURL = githubActionsIssuer + "/.well-known/jwks"
+
+// githubActionsPayload extends jwt.Claims with the custom GitHub Actions
+// attributes.
+type githubActionsPayload struct {
+	jose.Claims
+	Repository      string `json:"repository"`
+	RepositoryOwner string `json:"repository_owner"`
+	Ref             string `json:"ref"`
+	RefType         string `json:"ref_type"`
+	Environment     string `json:"environment"`
+	Workflow        string `json:"workflow"`
+	JobWorkflowRef  string `json:"job_workflow_ref"`
+	Actor           string `json:"actor"`
+	EventName       string `json:"event_name"`
+	RunID           string `json:"run_id"`
+	SHA             string `json:"sha"`
+}
+
+// names returns the given names with the placeholders replaced by the values
+// in the payload. Names using a placeholder without a value are skipped.
+func (p *githubActionsPayload) names(names []string) []string {
+	return replacePlaceholders(names, map[string]string{
+		"{repository}":       p.Repository,
+		"{repository_owner}": p.RepositoryOwner,
+		"{ref}":              p.Ref,
+		"{environment}":      p.Environment,
+		"{workflow}":         p.Workflow,
+		"{actor}":            p.Actor,
+	})
+}
+
+// replacePlaceholders returns the given names with the placeholders replaced
+// by their values. Names using a placeholder without a value are skipped.
+func replacePlaceholders(names []string, values map[string]string) []string {
+	var ret []string
+	for _, name := range names {
+		ok := true
+		for k, v := range values {
+			if strings.Contains(name, k) {
+				if v == "" {
+					ok = false
+					break
+				}
+				name = strings.Replace(name, k, v, -1)
+			}
+		}
+		if ok {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+type githubActionsConfig struct {
+	Issuer   string
+	CertsURL string
+}
+
+func newGitHubActionsConfig() *githubActionsConfig {
+	return &githubActionsConfig{
+		Issuer:   githubActionsIssuer,
+		CertsURL: githubActionsCertsURL,
+	}
+}
+
+// GitHubActionsRule maps the workflow runs matching the repository, ref,
+// environment and workflow onto the names allowed in the certificates.
+//
+// Repository is required, the other fields are optional, and all of them are
+// shell patterns, e.g. "smallstep/*" or "refs/tags/v*". SANs and Principals
+// can use the placeholders {repository}, {repository_owner}, {ref},
+// {environment}, {workflow} and {actor}.
+type GitHubActionsRule struct {
+	Repository  string   `json:"repository"`
+	Ref         string   `json:"ref,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+	Workflow    string   `json:"workflow,omitempty"`
+	SANs        []string `json:"sans,omitempty"`
+	Principals  []string `json:"principals,omitempty"`
+}
+
+// Validate validates the patterns of the rule.
+func (r *GitHubActionsRule) Validate() error {
+	if r.Repository == "" {
+		return errors.New("repository cannot be empty")
+	}
+	for _, pattern := range []string{r.Repository, r.Ref, r.Environment, r.Workflow} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "error parsing pattern %s", pattern)
+		}
+	}
+	return nil
+}
+
+// matches returns true if the claims in the payload match the rule.
+func (r *GitHubActionsRule) matches(p *githubActionsPayload) bool {
+	return matchPattern(r.Repository, p.Repository) &&
+		matchPattern(r.Ref, p.Ref) &&
+		matchPattern(r.Environment, p.Environment) &&
+		matchPattern(r.Workflow, p.Workflow)
+}
+
+// matchPattern returns true if the pattern is empty or if the value matches it.
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// GitHubActions is the provisioner that supports the OIDC tokens issued to the
+// GitHub Actions workflows. The tokens must be requested with the sign URL of
+// the CA as the audience, with the provisioner id as the fragment, e.g.
+// https://ca.smallstep.com/1.0/sign#github-actions/name.
+//
+// Only the workflow runs matching one of the Rules are accepted, and the
+// certificates can only contain the SANs or principals of the matching rules.
+//
+// GitHub Actions docs are available at
+// https://docs.github.com/en/actions/deployment/security-hardening-your-deployments/about-security-hardening-with-openid-connect
+type GitHubActions struct {
+	*base
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Rules      []GitHubActionsRule `json:"rules"`
+	Claims     *Claims             `json:"claims,omitempty"`
+	Options    *CertificateOptions `json:"options,omitempty"`
+	HTTPClient *HTTPClientOptions  `json:"httpClient,omitempty"`
+	KeyStore   *KeyStoreOptions    `json:"keyStore,omitempty"`
+	claimer    *Claimer
+	config     *githubActionsConfig
+	keyStore   *keyStore
+	client     *http.Client
+	audiences  Audiences
+}
+
+// GetID returns the provisioner unique identifier. The name should uniquely
+// identify any GitHubActions provisioner.
+func (p *GitHubActions) GetID() string {
+	return "github-actions/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token, the jti claim if present or
+// the SHA256 of the token otherwise.
+func (p *GitHubActions) GetTokenID(token string) (string, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+
+	// Get claims w/out verification.
+	var claims githubActionsPayload
+	if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	if claims.ID != "" {
+		return claims.ID, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	return strings.ToLower(hex.EncodeToString(sum[:])), nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *GitHubActions) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *GitHubActions) GetType() Type {
+	return TypeGitHubActions
+}
+
+// GetEncryptedKey is not available in a GitHubActions provisioner.
+func (p *GitHubActions) GetEncryptedKey() (kid string, key string, ok bool) {
+	return "", "", false
+}
+
+// GetKeyStoreStats returns the statistics of the keys used to validate the
+// tokens.
+func (p *GitHubActions) GetKeyStoreStats() KeyStoreStats {
+	return p.keyStore.Stats()
+}
+
+// Close releases the keys used to validate the tokens.
+func (p *GitHubActions) Close() error {
+	releaseKeyStore(p.keyStore)
+	return nil
+}
+
+// Init validates and initializes the GitHubActions provisioner.
+func (p *GitHubActions) Init(config Config) error {
+	var err error
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case len(p.Rules) == 0:
+		return errors.New("provisioner rules cannot be empty")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	for i := range p.Rules {
+		if err := p.Rules[i].Validate(); err != nil {
+			return errors.Wrapf(err, "error validating rules[%d]", i)
+		}
+	}
+	// Initialize config
+	p.assertConfig()
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+	// Initialize key store
+	clientOpts := p.HTTPClient.withDefaultProxy(config.Proxy)
+	if p.client, err = newHTTPClient(clientOpts); err != nil {
+		return err
+	}
+	p.keyStore, err = getKeyStore(context.Background(), p.client, clientOpts, p.config.CertsURL, p.KeyStore)
+	if err != nil {
+		return err
+	}
+
+	p.audiences = config.Audiences.WithFragment(p.GetID())
+	return nil
+}
+
+// assertConfig initializes the config if it has not been initialized.
+func (p *GitHubActions) assertConfig() {
+	if p.config == nil {
+		p.config = newGitHubActionsConfig()
+	}
+}
+
+// authorizeToken performs common jwt authorization actions and returns the
+// claims and the rules matching them.
+func (p *GitHubActions) authorizeToken(token string, audiences []string) (*githubActionsPayload, []GitHubActionsRule, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, nil, errs.Wrap(http.StatusUnauthorized, err, "githubActions.authorizeToken; error parsing github actions token")
+	}
+	if len(jwt.Headers) == 0 {
+		return nil, nil, errs.Unauthorized("githubActions.authorizeToken; error parsing github actions token - header is missing")
+	}
+
+	var found bool
+	var claims githubActionsPayload
+	kid := jwt.Headers[0].KeyID
+	keys := p.keyStore.Get(kid)
+	for _, key := range keys {
+		if err := jwt.Claims(key.Public(), &claims); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil, errs.Unauthorized("githubActions.authorizeToken; failed to validate github actions token payload - cannot find key for kid %s", kid)
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = claims.ValidateWithLeeway(jose.Expected{
+		Issuer: p.config.Issuer,
+		Time:   time.Now().UTC(),
+	}, p.claimer.ClockSkew()); err != nil {
+		return nil, nil, errs.Wrap(http.StatusUnauthorized, err, "githubActions.authorizeToken; invalid github actions token payload")
+	}
+
+	// validate audiences with the defaults
+	if !matchesAudience(claims.Audience, audiences) {
+		return nil, nil, errs.Unauthorized("githubActions.authorizeToken; invalid github actions token - invalid audience claim (aud)")
+	}
+
+	if claims.Repository == "" {
+		return nil, nil, errs.Unauthorized("githubActions.authorizeToken; github actions token repository cannot be empty")
+	}
+
+	var rules []GitHubActionsRule
+	for _, r := range p.Rules {
+		if r.matches(&claims) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil, errs.Unauthorized("githubActions.authorizeToken; github actions token does not match any rule")
+	}
+
+	return &claims, rules, nil
+}
+
+// AuthorizeSign validates the given token and returns the sign options that
+// will be used on certificate creation.
+func (p *GitHubActions) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("githubActions.AuthorizeSign; issuance is disabled for github actions provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, rules, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "githubActions.AuthorizeSign")
+	}
+
+	var sans []string
+	for _, r := range rules {
+		sans = append(sans, claims.names(r.SANs)...)
+	}
+	if len(sans) == 0 {
+		return nil, errs.Unauthorized("githubActions.AuthorizeSign; github actions token does not allow any SAN")
+	}
+
+	signOptions := []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeGitHubActions, p.Name, claims.Subject, "Repository", claims.Repository,
+			"Ref", claims.Ref, "Workflow", claims.Workflow, "RunID", claims.RunID),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
+		// validators
+		allowedSANsValidator(sans),
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(p.claimer),
+	}
+
+	if p.claimer.IsSPIFFEEnabled() {
+		signOptions = append(signOptions, newSPIFFESignOptions(p.claimer, nil)...)
+	}
+
+	return withX509CertificateOptions(p.Options, signOptions, newTemplateData(token, claims.Subject, sans)), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *GitHubActions) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("githubActions.AuthorizeRenew; renew is disabled for github actions provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "githubActions.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "githubActions.AuthorizeRenew")
+	}
+	return nil
+}
+
+// AuthorizeSSHSign returns the list of SignOption for a SignSSH request.
+func (p *GitHubActions) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
+	if !p.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("githubActions.AuthorizeSSHSign; sshCA is disabled for github actions provisioner %s", p.GetID())
+	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("githubActions.AuthorizeSSHSign; issuance is disabled for github actions provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, rules, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "githubActions.AuthorizeSSHSign")
+	}
+
+	var principals []string
+	for _, r := range rules {
+		principals = append(principals, claims.names(r.Principals)...)
+	}
+	if len(principals) == 0 {
+		return nil, errs.Unauthorized("githubActions.AuthorizeSSHSign; github actions token does not allow any principal")
+	}
+
+	signOptions := []SignOption{
+		// set the key id to the token subject
+		sshCertKeyIDModifier(claims.Subject),
+	}
+
+	// Default to a user certificate with the allowed principals
+	defaults := SSHOptions{
+		CertType:   SSHUserCert,
+		Principals: principals,
+	}
+	// Validate user options
+	signOptions = append(signOptions, sshCertOptionsValidator(defaults))
+	// Set defaults if not given as user options
+	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
+
+	return withSSHCertificateOptions(p.Options, append(signOptions,
+		// Set the default extensions
+		&sshDefaultExtensionModifier{},
+		// Set the validity bounds if not set.
+		&sshDefaultDuration{p.claimer},
+		backdateOption(p.claimer.Backdate()),
+		// Validate public key
+		&sshDefaultPublicKeyValidator{},
+		// Validate the validity period.
+		&sshCertValidityValidator{p.claimer},
+		// Require all the fields in the SSH certificate
+		&sshCertDefaultValidator{},
+	), newTemplateData(token, claims.Subject, principals)), nil
+}
@@ -0,0 +1,352 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+func TestGitHubActions_Getters(t *testing.T) {
+	p, err := generateGitHubActions()
+	assert.FatalError(t, err)
+	id := "github-actions/" + p.Name
+	if got := p.GetID(); got != id {
+		t.Errorf("GitHubActions.GetID() = %v, want %v", got, id)
+	}
+	if got := p.GetName(); got != p.Name {
+		t.Errorf("GitHubActions.GetName() = %v, want %v", got, p.Name)
+	}
+	if got := p.GetType(); got != TypeGitHubActions {
+		t.Errorf("GitHubActions.GetType() = %v, want %v", got, TypeGitHubActions)
+	}
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("GitHubActions.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+}
+
+func TestGitHubActions_GetTokenID(t *testing.T) {
+	p1, err := generateGitHubActions()
+	assert.FatalError(t, err)
+
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+	t1, err := generateGitHubActionsToken(aud, time.Now(), &p1.keyStore.keySet.Keys[0], getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	noJTI := getGitHubActionsPayload()
+	noJTI.ID = ""
+	t2, err := generateGitHubActionsToken(aud, time.Now(), &p1.keyStore.keySet.Keys[0], noJTI)
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256([]byte(t2))
+	want2 := strings.ToLower(hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		name    string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{"ok", t1, "the-jti", false},
+		{"ok no jti", t2, want2, false},
+		{"fail token", "token", "", true},
+		{"fail claims", "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.ey.fooo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p1.GetTokenID(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GitHubActions.GetTokenID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GitHubActions.GetTokenID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubActions_Init(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+	badClaims := &Claims{
+		DefaultTLSDur: &Duration{0},
+	}
+	rules := []GitHubActionsRule{{Repository: "smallstep/*", SANs: []string{"ci.internal"}}}
+	tests := []struct {
+		name     string
+		typ      string
+		pname    string
+		rules    []GitHubActionsRule
+		claims   *Claims
+		certsURL string
+		wantErr  bool
+	}{
+		{"ok", "GitHubActions", "name", rules, nil, srv.URL, false},
+		{"ok patterns", "GitHubActions", "name", []GitHubActionsRule{{Repository: "smallstep/certificates", Ref: "refs/tags/v*", Environment: "prod*", Workflow: "Release"}}, nil, srv.URL, false},
+		{"bad type", "", "name", rules, nil, srv.URL, true},
+		{"bad name", "GitHubActions", "", rules, nil, srv.URL, true},
+		{"bad rules", "GitHubActions", "name", nil, nil, srv.URL, true},
+		{"bad repository", "GitHubActions", "name", []GitHubActionsRule{{Ref: "refs/heads/master"}}, nil, srv.URL, true},
+		{"bad pattern", "GitHubActions", "name", []GitHubActionsRule{{Repository: "smallstep/[certificates"}}, nil, srv.URL, true},
+		{"bad claims", "GitHubActions", "name", rules, badClaims, srv.URL, true},
+		{"bad certs", "GitHubActions", "name", rules, nil, srv.URL + "/error", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GitHubActions{
+				Type:   tt.typ,
+				Name:   tt.pname,
+				Rules:  tt.rules,
+				Claims: tt.claims,
+				config: &githubActionsConfig{
+					Issuer:   githubActionsIssuer,
+					CertsURL: tt.certsURL,
+				},
+			}
+			if err := p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("GitHubActions.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGitHubActions_AuthorizeSign(t *testing.T) {
+	p1, err := generateGitHubActions()
+	assert.FatalError(t, err)
+
+	aKey, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	key := &p1.keyStore.keySet.Keys[0]
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+
+	t1, err := generateGitHubActionsToken(aud, time.Now(), key, getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	failKey, err := generateGitHubActionsToken(aud, time.Now(), aKey, getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	iss := getGitHubActionsPayload()
+	iss.Issuer = "https://foo.bar.zar"
+	failIss, err := generateGitHubActionsToken(aud, time.Now(), key, iss)
+	assert.FatalError(t, err)
+	failAud, err := generateGitHubActionsToken("https://ca.smallstep.com/1.0/sign#github-actions/foo", time.Now(), key, getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	failExp, err := generateGitHubActionsToken(aud, time.Now().Add(-360*time.Second), key, getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	failNbf, err := generateGitHubActionsToken(aud, time.Now().Add(360*time.Second), key, getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	repo := getGitHubActionsPayload()
+	repo.Repository = ""
+	failRepository, err := generateGitHubActionsToken(aud, time.Now(), key, repo)
+	assert.FatalError(t, err)
+	rule := getGitHubActionsPayload()
+	rule.Repository = "octocat/hello-world"
+	failRule, err := generateGitHubActionsToken(aud, time.Now(), key, rule)
+	assert.FatalError(t, err)
+	ref := getGitHubActionsPayload()
+	ref.Ref = "refs/heads/feature"
+	failRef, err := generateGitHubActionsToken(aud, time.Now(), key, ref)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantLen int
+		code    int
+		wantErr bool
+	}{
+		{"ok", t1, 8, http.StatusOK, false},
+		{"fail token", "token", 0, http.StatusUnauthorized, true},
+		{"fail key", failKey, 0, http.StatusUnauthorized, true},
+		{"fail iss", failIss, 0, http.StatusUnauthorized, true},
+		{"fail aud", failAud, 0, http.StatusUnauthorized, true},
+		{"fail exp", failExp, 0, http.StatusUnauthorized, true},
+		{"fail nbf", failNbf, 0, http.StatusUnauthorized, true},
+		{"fail repository", failRepository, 0, http.StatusUnauthorized, true},
+		{"fail rule", failRule, 0, http.StatusUnauthorized, true},
+		{"fail ref", failRef, 0, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewContextWithMethod(context.Background(), SignMethod)
+			got, err := p1.AuthorizeSign(ctx, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GitHubActions.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			} else if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+			} else {
+				assert.Len(t, tt.wantLen, got)
+				for _, o := range got {
+					if v, ok := o.(allowedSANsValidator); ok {
+						// The environment is empty, so only the owner SAN is allowed.
+						assert.Equals(t, []string(v), []string{"smallstep.ci.internal"})
+						assert.NoError(t, v.Valid(&x509.CertificateRequest{
+							Subject:  pkix.Name{CommonName: "smallstep.ci.internal"},
+							DNSNames: []string{"smallstep.ci.internal"},
+						}))
+						assert.Error(t, v.Valid(&x509.CertificateRequest{
+							DNSNames: []string{"smallstep.ci.internal", "foo.ci.internal"},
+						}))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubActions_AuthorizeSign_noSANs(t *testing.T) {
+	p1, err := generateGitHubActions()
+	assert.FatalError(t, err)
+	p1.Rules[0].SANs = []string{"{environment}.ci.internal"}
+
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+	t1, err := generateGitHubActionsToken(aud, time.Now(), &p1.keyStore.keySet.Keys[0], getGitHubActionsPayload())
+	assert.FatalError(t, err)
+
+	_, err = p1.AuthorizeSign(context.Background(), t1)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+	}
+}
+
+func TestGitHubActions_AuthorizeSSHSign(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	p1, err := generateGitHubActions()
+	assert.FatalError(t, err)
+
+	p2, err := generateGitHubActions()
+	assert.FatalError(t, err)
+	// disable sshCA
+	disable := false
+	p2.Claims = &Claims{EnableSSHCA: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	t1, err := generateGitHubActionsToken("https://ca.smallstep.com/1.0/ssh/sign#"+p1.GetID(),
+		time.Now(), &p1.keyStore.keySet.Keys[0], getGitHubActionsPayload())
+	assert.FatalError(t, err)
+	failAud, err := generateGitHubActionsToken("https://ca.smallstep.com/1.0/sign#"+p1.GetID(),
+		time.Now(), &p1.keyStore.keySet.Keys[0], getGitHubActionsPayload())
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	pub := key.Public().Key
+	userDuration := p1.claimer.DefaultUserSSHCertDuration()
+	expectedUserOptions := &SSHOptions{
+		CertType: "user", Principals: []string{"octocat"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration)),
+	}
+
+	type args struct {
+		token   string
+		sshOpts SSHOptions
+		key     interface{}
+	}
+	tests := []struct {
+		name        string
+		p           *GitHubActions
+		args        args
+		expected    *SSHOptions
+		code        int
+		wantErr     bool
+		wantSignErr bool
+	}{
+		{"ok", p1, args{t1, SSHOptions{}, pub}, expectedUserOptions, http.StatusOK, false, false},
+		{"ok-principals", p1, args{t1, SSHOptions{Principals: []string{"octocat"}}, pub}, expectedUserOptions, http.StatusOK, false, false},
+		{"fail-type", p1, args{t1, SSHOptions{CertType: "host"}, pub}, nil, http.StatusOK, false, true},
+		{"fail-principal", p1, args{t1, SSHOptions{Principals: []string{"root"}}, pub}, nil, http.StatusOK, false, true},
+		{"fail-aud", p1, args{failAud, SSHOptions{}, pub}, nil, http.StatusUnauthorized, true, false},
+		{"fail-sshCA-disabled", p2, args{"foo", SSHOptions{}, pub}, nil, http.StatusUnauthorized, true, false},
+		{"fail-invalid-token", p1, args{"foo", SSHOptions{}, pub}, nil, http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSSHSign(context.Background(), tt.args.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GitHubActions.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+			} else if assert.NotNil(t, got) {
+				cert, err := signSSHCertificate(tt.args.key, tt.args.sshOpts, got, signer.Key.(crypto.Signer))
+				if (err != nil) != tt.wantSignErr {
+					t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				} else {
+					if tt.wantSignErr {
+						assert.Nil(t, cert)
+					} else {
+						assert.NoError(t, validateSSHCertificate(cert, tt.expected))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubActions_AuthorizeRenew(t *testing.T) {
+	p1, err := generateGitHubActions()
+	assert.FatalError(t, err)
+	p2, err := generateGitHubActions()
+	assert.FatalError(t, err)
+
+	// disable renewal
+	disable := true
+	p2.Claims = &Claims{DisableRenewal: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		p       *GitHubActions
+		code    int
+		wantErr bool
+	}{
+		{"ok", p1, http.StatusOK, false},
+		{"fail", p2, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.AuthorizeRenew(context.Background(), generateRenewCertificate()); (err != nil) != tt.wantErr {
+				t.Errorf("GitHubActions.AuthorizeRenew() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+			}
+		})
+	}
+}
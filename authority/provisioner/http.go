@@ -0,0 +1,228 @@
+package provisioner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultHTTPTimeout is the timeout used by the provisioners HTTP client if
+// one is not configured.
+const defaultHTTPTimeout = 30 * time.Second
+
+// HTTPClientOptions defines the options used to create the HTTP client that
+// provisioners use to fetch remote resources, like the OpenID configuration or
+// the JWK set used to validate tokens.
+//
+// RootCAs is a list of files with PEM encoded certificates used to validate
+// the server certificates, if not set the system roots will be used.
+//
+// Certificate and Key are the files with the PEM encoded client certificate
+// and private key used if the server requires client authentication.
+//
+// Proxy is the HTTP proxy used for the requests, if not set the one in the
+// authority configuration or the environment variables will be used.
+type HTTPClientOptions struct {
+	Timeout     *Duration     `json:"timeout,omitempty"`
+	RootCAs     []string      `json:"rootCAs,omitempty"`
+	Certificate string        `json:"crt,omitempty"`
+	Key         string        `json:"key,omitempty"`
+	Proxy       *ProxyOptions `json:"proxy,omitempty"`
+}
+
+// ProxyOptions defines the HTTP proxy used by the provisioners for all their
+// outbound requests, like the ones to get the OpenID configuration, the keys
+// or the cloud identity documents.
+//
+// URL is the address of the proxy, e.g. http://proxy.example.com:3128.
+// NoProxy is a list of hosts, domains, or IP ranges in CIDR notation that
+// will be accessed directly, a domain starting with a dot also matches its
+// subdomains.
+type ProxyOptions struct {
+	URL     string   `json:"url"`
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// Validate validates the proxy options, nil is ok.
+func (o *ProxyOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.URL == "" {
+		return errors.New("proxy.url cannot be empty")
+	}
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing proxy.url %s", o.URL)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return errors.Errorf("proxy.url %s is not valid: scheme must be http, https or socks5", o.URL)
+	}
+	if u.Host == "" {
+		return errors.Errorf("proxy.url %s is not valid: host cannot be empty", o.URL)
+	}
+	for _, s := range o.NoProxy {
+		if strings.Contains(s, "/") {
+			if _, _, err := net.ParseCIDR(s); err != nil {
+				return errors.Wrapf(err, "error parsing proxy.noProxy %s", s)
+			}
+		}
+	}
+	return nil
+}
+
+// proxyFunc returns the function used by the HTTP transport to select the
+// proxy of a request.
+func (o *ProxyOptions) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxyURL, _ := url.Parse(o.URL)
+	return func(req *http.Request) (*url.URL, error) {
+		if o.bypass(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// bypass returns true if the given host is in the NoProxy list.
+func (o *ProxyOptions) bypass(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, s := range o.NoProxy {
+		s = strings.ToLower(strings.TrimSpace(s))
+		switch {
+		case s == "*" || s == host:
+			return true
+		case strings.HasPrefix(s, ".") && strings.HasSuffix(host, s):
+			return true
+		case ip != nil && strings.Contains(s, "/"):
+			if _, ipNet, err := net.ParseCIDR(s); err == nil && ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withDefaultProxy returns the HTTP client options with the given proxy if a
+// proxy is not already configured.
+func (o *HTTPClientOptions) withDefaultProxy(proxy *ProxyOptions) *HTTPClientOptions {
+	switch {
+	case proxy == nil:
+		return o
+	case o == nil:
+		return &HTTPClientOptions{Proxy: proxy}
+	case o.Proxy != nil:
+		return o
+	default:
+		opts := *o
+		opts.Proxy = proxy
+		return &opts
+	}
+}
+
+// Validate validates the HTTP client options, nil is ok.
+func (o *HTTPClientOptions) Validate() error {
+	switch {
+	case o == nil:
+		return nil
+	case o.Timeout.Value() < 0:
+		return errors.New("httpClient.timeout cannot be negative")
+	case o.Certificate != "" && o.Key == "":
+		return errors.New("httpClient.key cannot be empty if httpClient.crt is set")
+	case o.Certificate == "" && o.Key != "":
+		return errors.New("httpClient.crt cannot be empty if httpClient.key is set")
+	default:
+		if err := o.Proxy.Validate(); err != nil {
+			return errors.Wrap(err, "error validating httpClient")
+		}
+		return nil
+	}
+}
+
+// newHTTPClient returns a new HTTP client configured with the given options.
+func newHTTPClient(o *HTTPClientOptions) (*http.Client, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return &http.Client{
+			Timeout: defaultHTTPTimeout,
+		}, nil
+	}
+
+	timeout := defaultHTTPTimeout
+	if o.Timeout.Value() > 0 {
+		timeout = o.Timeout.Value()
+	}
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+	}
+	if tlsConfig != nil || o.Proxy != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		if tlsConfig != nil {
+			tr.TLSClientConfig = tlsConfig
+		}
+		if o.Proxy != nil {
+			tr.Proxy = o.Proxy.proxyFunc()
+		}
+		client.Transport = tr
+	}
+	return client, nil
+}
+
+// getHTTPClient returns the given client or, if it's nil, a new one created
+// with the given options. It's used in the methods that can be called without
+// initializing the provisioner, like GetIdentityToken.
+func getHTTPClient(client *http.Client, o *HTTPClientOptions) (*http.Client, error) {
+	if client != nil {
+		return client, nil
+	}
+	return newHTTPClient(o)
+}
+
+// tlsConfig returns the TLS configuration with the root CAs and client
+// certificate in the options. It will return nil if none of them is set.
+func (o *HTTPClientOptions) tlsConfig() (*tls.Config, error) {
+	if len(o.RootCAs) == 0 && o.Certificate == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(o.RootCAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, name := range o.RootCAs {
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error reading %s", name)
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, errors.Errorf("error parsing %s: no certificates found", name)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if o.Certificate != "" {
+		cert, err := tls.LoadX509KeyPair(o.Certificate, o.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading %s and %s", o.Certificate, o.Key)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
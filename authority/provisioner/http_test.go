@@ -0,0 +1,207 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func TestHTTPClientOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *HTTPClientOptions
+		wantErr bool
+	}{
+		{"ok nil", nil, false},
+		{"ok empty", &HTTPClientOptions{}, false},
+		{"ok timeout", &HTTPClientOptions{Timeout: &Duration{time.Minute}}, false},
+		{"fail timeout", &HTTPClientOptions{Timeout: &Duration{-time.Minute}}, true},
+		{"ok crt and key", &HTTPClientOptions{Certificate: "testdata/certs/x5c-leaf.crt", Key: "testdata/secrets/x5c-leaf.key"}, false},
+		{"fail no key", &HTTPClientOptions{Certificate: "testdata/certs/x5c-leaf.crt"}, true},
+		{"fail no crt", &HTTPClientOptions{Key: "testdata/secrets/x5c-leaf.key"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("HTTPClientOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newHTTPClient(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        *HTTPClientOptions
+		wantTimeout time.Duration
+		wantErr     bool
+	}{
+		{"ok nil", nil, defaultHTTPTimeout, false},
+		{"ok empty", &HTTPClientOptions{}, defaultHTTPTimeout, false},
+		{"ok timeout", &HTTPClientOptions{Timeout: &Duration{time.Minute}}, time.Minute, false},
+		{"fail timeout", &HTTPClientOptions{Timeout: &Duration{-time.Minute}}, 0, true},
+		{"ok rootCAs", &HTTPClientOptions{RootCAs: []string{"testdata/certs/root_ca.crt"}}, defaultHTTPTimeout, false},
+		{"ok crt and key", &HTTPClientOptions{Certificate: "testdata/certs/x5c-leaf.crt", Key: "testdata/secrets/x5c-leaf.key"}, defaultHTTPTimeout, false},
+		{"fail rootCAs missing", &HTTPClientOptions{RootCAs: []string{"testdata/certs/missing.crt"}}, 0, true},
+		{"fail rootCAs no certificates", &HTTPClientOptions{RootCAs: []string{"testdata/secrets/x5c-leaf.key"}}, 0, true},
+		{"fail crt and key mismatch", &HTTPClientOptions{Certificate: "testdata/certs/x5c-leaf.crt", Key: "testdata/secrets/ecdsa.key"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newHTTPClient(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Timeout != tt.wantTimeout {
+				t.Errorf("newHTTPClient() timeout = %v, want %v", got.Timeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func Test_newHTTPClient_tls(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "http-client")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	rootCA := filepath.Join(dir, "root_ca.crt")
+	assert.FatalError(t, ioutil.WriteFile(rootCA, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: srv.Certificate().Raw,
+	}), 0600))
+
+	tests := []struct {
+		name    string
+		opts    *HTTPClientOptions
+		wantErr bool
+	}{
+		{"ok", &HTTPClientOptions{RootCAs: []string{rootCA}, Certificate: "testdata/certs/x5c-leaf.crt", Key: "testdata/secrets/x5c-leaf.key"}, false},
+		{"fail no rootCAs", &HTTPClientOptions{Certificate: "testdata/certs/x5c-leaf.crt", Key: "testdata/secrets/x5c-leaf.key"}, true},
+		{"fail wrong rootCAs", &HTTPClientOptions{RootCAs: []string{"testdata/certs/root_ca.crt"}, Certificate: "testdata/certs/x5c-leaf.crt", Key: "testdata/secrets/x5c-leaf.key"}, true},
+		{"fail no client certificate", &HTTPClientOptions{RootCAs: []string{rootCA}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newHTTPClient(tt.opts)
+			assert.FatalError(t, err)
+			_, _, err = getKeysFromJWKsURI(context.Background(), client, srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getKeysFromJWKsURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *ProxyOptions
+		wantErr bool
+	}{
+		{"ok nil", nil, false},
+		{"ok http", &ProxyOptions{URL: "http://proxy.example.com:3128"}, false},
+		{"ok https", &ProxyOptions{URL: "https://proxy.example.com"}, false},
+		{"ok socks5", &ProxyOptions{URL: "socks5://127.0.0.1:1080"}, false},
+		{"ok noProxy", &ProxyOptions{URL: "http://proxy.example.com:3128", NoProxy: []string{"localhost", ".internal", "169.254.169.254", "10.0.0.0/8"}}, false},
+		{"fail empty", &ProxyOptions{}, true},
+		{"fail parse", &ProxyOptions{URL: "http://proxy.example.com:port"}, true},
+		{"fail scheme", &ProxyOptions{URL: "ftp://proxy.example.com"}, true},
+		{"fail host", &ProxyOptions{URL: "http://"}, true},
+		{"fail noProxy", &ProxyOptions{URL: "http://proxy.example.com:3128", NoProxy: []string{"10.0.0.0/64"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ProxyOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := (&HTTPClientOptions{Proxy: tt.opts}).Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("HTTPClientOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyOptions_bypass(t *testing.T) {
+	opts := &ProxyOptions{
+		URL:     "http://proxy.example.com:3128",
+		NoProxy: []string{"metadata.google.internal", ".corp.example.com", "169.254.0.0/16"},
+	}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"metadata.google.internal", true},
+		{"METADATA.google.internal", true},
+		{"foo.metadata.google.internal", false},
+		{"idp.corp.example.com", true},
+		{"corp.example.com", false},
+		{"169.254.169.254", true},
+		{"10.0.0.1", false},
+		{"www.googleapis.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := opts.bypass(tt.host); got != tt.want {
+				t.Errorf("ProxyOptions.bypass() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	assert.True(t, (&ProxyOptions{NoProxy: []string{"*"}}).bypass("www.googleapis.com"))
+}
+
+func TestHTTPClientOptions_withDefaultProxy(t *testing.T) {
+	proxy := &ProxyOptions{URL: "http://global.example.com:3128"}
+	custom := &ProxyOptions{URL: "http://custom.example.com:3128"}
+	timeout := &Duration{time.Minute}
+
+	assert.Equals(t, (*HTTPClientOptions)(nil), (*HTTPClientOptions)(nil).withDefaultProxy(nil))
+	assert.Equals(t, &HTTPClientOptions{Proxy: proxy}, (*HTTPClientOptions)(nil).withDefaultProxy(proxy))
+	assert.Equals(t, &HTTPClientOptions{Timeout: timeout, Proxy: proxy}, (&HTTPClientOptions{Timeout: timeout}).withDefaultProxy(proxy))
+	assert.Equals(t, &HTTPClientOptions{Proxy: custom}, (&HTTPClientOptions{Proxy: custom}).withDefaultProxy(proxy))
+}
+
+func Test_newHTTPClient_proxy(t *testing.T) {
+	var requests []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(&HTTPClientOptions{
+		Proxy: &ProxyOptions{URL: proxy.URL, NoProxy: []string{"direct.invalid"}},
+	})
+	assert.FatalError(t, err)
+
+	_, _, err = getKeysFromJWKsURI(context.Background(), client, "http://jwks.invalid/keys")
+	assert.FatalError(t, err)
+	assert.Equals(t, []string{"http://jwks.invalid/keys"}, requests)
+
+	// Hosts in noProxy are accessed directly
+	_, _, err = getKeysFromJWKsURI(context.Background(), client, "http://direct.invalid/keys")
+	assert.Error(t, err)
+	assert.Len(t, 1, requests)
+}
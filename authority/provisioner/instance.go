@@ -0,0 +1,42 @@
+package provisioner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// InstanceIdentifier is implemented by the cloud provisioners whose tokens
+// identify an instance, AWS, GCP and Azure.
+//
+// GetInstanceID returns the key used to record in the CA database the instance
+// of a token already authorized, so the identity document of an instance can
+// only bootstrap one certificate. It returns false if the token does not
+// identify a single instance, or if trust on first use is disabled.
+type InstanceIdentifier interface {
+	GetInstanceID(token string) (string, bool)
+}
+
+// instanceKey returns the key used to record the instance of a provisioner,
+// the SHA256 of "provisioner_id.instance_id".
+func instanceKey(provisionerID, instanceID string) string {
+	sum := sha256.Sum256([]byte(provisionerID + "." + instanceID))
+	return strings.ToLower(hex.EncodeToString(sum[:]))
+}
+
+// tokenKey returns the SHA256 of the token, it is used as the token id of the
+// cloud provisioners.
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return strings.ToLower(hex.EncodeToString(sum[:]))
+}
+
+// isInstanceTooOld returns true if the instance age is set and the instance
+// was created before it. Instances with an unknown creation time are too old.
+func isInstanceTooOld(instanceAge Duration, createdAt, now time.Time) bool {
+	if d := instanceAge.Value(); d > 0 {
+		return createdAt.IsZero() || now.Sub(createdAt) > d
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -23,17 +24,52 @@ type stepPayload struct {
 	SSH *SSHOptions `json:"ssh,omitempty"`
 }
 
+// JWKKey is an additional public key of a JWK provisioner. Tokens signed with
+// it are only valid between NotBefore and NotAfter, if set. Multiple keys
+// allow operators to rotate the provisioning keys without a breaking cutover.
+type JWKKey struct {
+	Key       *jose.JSONWebKey `json:"key"`
+	NotBefore time.Time        `json:"notBefore,omitempty"`
+	NotAfter  time.Time        `json:"notAfter,omitempty"`
+}
+
+// Validate validates the fields of a JWKKey.
+func (k *JWKKey) Validate() error {
+	switch {
+	case k == nil || k.Key == nil:
+		return errors.New("key cannot be empty")
+	case k.Key.KeyID == "":
+		return errors.New("key id cannot be empty")
+	case !k.Key.IsPublic():
+		return errors.Errorf("key %s must be a public key", k.Key.KeyID)
+	case !k.NotBefore.IsZero() && !k.NotAfter.IsZero() && k.NotAfter.Before(k.NotBefore):
+		return errors.Errorf("key %s notAfter cannot be before notBefore", k.Key.KeyID)
+	default:
+		return nil
+	}
+}
+
+// isValid returns true if the key can be used at the given time.
+func (k *JWKKey) isValid(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) &&
+		(k.NotAfter.IsZero() || t.Before(k.NotAfter))
+}
+
 // JWK is the default provisioner, an entity that can sign tokens necessary for
 // signature requests.
 type JWK struct {
 	*base
-	Type         string           `json:"type"`
-	Name         string           `json:"name"`
-	Key          *jose.JSONWebKey `json:"key"`
-	EncryptedKey string           `json:"encryptedKey,omitempty"`
-	Claims       *Claims          `json:"claims,omitempty"`
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	Key          *jose.JSONWebKey    `json:"key"`
+	Keys         []*JWKKey           `json:"keys,omitempty"`
+	EncryptedKey string              `json:"encryptedKey,omitempty"`
+	Claims       *Claims             `json:"claims,omitempty"`
+	Options      *CertificateOptions `json:"options,omitempty"`
 	claimer      *Claimer
 	audiences    Audiences
+	keysMutex    sync.RWMutex
+	keys         []*JWKKey
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -86,6 +122,17 @@ func (p *JWK) Init(config Config) (err error) {
 		return errors.New("provisioner key cannot be empty")
 	}
 
+	p.keys = nil
+	for _, k := range p.Keys {
+		if err := p.addKey(k); err != nil {
+			return errors.Wrap(err, "error validating provisioner keys")
+		}
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
@@ -95,6 +142,76 @@ func (p *JWK) Init(config Config) (err error) {
 	return err
 }
 
+// GetKeys returns the additional keys of the provisioner, including the ones
+// added or retired after the initialization.
+func (p *JWK) GetKeys() []*JWKKey {
+	p.keysMutex.RLock()
+	defer p.keysMutex.RUnlock()
+	keys := make([]*JWKKey, len(p.keys))
+	for i, k := range p.keys {
+		kk := *k
+		keys[i] = &kk
+	}
+	return keys
+}
+
+// AddKey adds a new key to the provisioner, or replaces the validity of an
+// existing one with the same key id.
+func (p *JWK) AddKey(key *JWKKey) error {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+	return p.addKey(key)
+}
+
+func (p *JWK) addKey(key *JWKKey) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+	if key.Key.KeyID == p.Key.KeyID {
+		return errors.Errorf("key %s is the provisioner key", key.Key.KeyID)
+	}
+	k := *key
+	keys := make([]*JWKKey, 0, len(p.keys)+1)
+	for _, kk := range p.keys {
+		if kk.Key.KeyID != k.Key.KeyID {
+			keys = append(keys, kk)
+		}
+	}
+	p.keys = append(keys, &k)
+	return nil
+}
+
+// RetireKey sets the end of the validity of the additional key with the given
+// key id, the tokens signed with it after that time will not be accepted. The
+// provisioner key cannot be retired, it identifies the provisioner.
+func (p *JWK) RetireKey(kid string, notAfter time.Time) (*JWKKey, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+	for i, k := range p.keys {
+		if k.Key.KeyID == kid {
+			kk := *k
+			kk.NotAfter = notAfter
+			p.keys[i] = &kk
+			retired := kk
+			return &retired, nil
+		}
+	}
+	return nil, errors.Errorf("key %s not found", kid)
+}
+
+// getKey returns the key used to verify a token with the given key id. Tokens
+// with an unknown key id are verified with the provisioner key.
+func (p *JWK) getKey(kid string) (*jose.JSONWebKey, bool) {
+	p.keysMutex.RLock()
+	defer p.keysMutex.RUnlock()
+	for _, k := range p.keys {
+		if k.Key.KeyID == kid && kid != "" {
+			return k.Key, k.isValid(time.Now())
+		}
+	}
+	return p.Key, true
+}
+
 // authorizeToken performs common jwt authorization actions and returns the
 // claims for case specific downstream parsing.
 // e.g. a Sign request will auth/validate different fields than a Revoke request.
@@ -104,8 +221,13 @@ func (p *JWK) authorizeToken(token string, audiences []string) (*jwtPayload, err
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "jwk.authorizeToken; error parsing jwk token")
 	}
 
+	key, ok := p.getKey(jwt.Headers[0].KeyID)
+	if !ok {
+		return nil, errs.Unauthorized("jwk.authorizeToken; jwk token key %s is not valid at this time", jwt.Headers[0].KeyID)
+	}
+
 	var claims jwtPayload
-	if err = jwt.Claims(p.Key, &claims); err != nil {
+	if err = jwt.Claims(key, &claims); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "jwk.authorizeToken; error parsing jwk claims")
 	}
 
@@ -114,7 +236,7 @@ func (p *JWK) authorizeToken(token string, audiences []string) (*jwtPayload, err
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: p.Name,
 		Time:   time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "jwk.authorizeToken; invalid jwk claims")
 	}
 
@@ -140,6 +262,10 @@ func (p *JWK) AuthorizeRevoke(ctx context.Context, token string) error {
 
 // AuthorizeSign validates the given token.
 func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("jwk.AuthorizeSign; issuance is disabled for jwk provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	claims, err := p.authorizeToken(token, p.audiences.Sign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "jwk.AuthorizeSign")
@@ -152,11 +278,30 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		claims.SANs = []string{claims.Subject}
 	}
 
+	// SPIFFE X.509 SVIDs can only contain one of the SPIFFE IDs in the token.
+	if p.claimer.IsSPIFFEEnabled() {
+		ids := spiffeIDs(claims.SANs)
+		if len(ids) == 0 {
+			return nil, errs.Unauthorized("jwk.AuthorizeSign; jwk token does not contain any SPIFFE ID")
+		}
+		return withX509CertificateOptions(p.Options, append([]SignOption{
+			// modifiers / withOptions
+			newProvisionerExtensionOption(TypeJWK, p.Name, p.Key.KeyID),
+			profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+			backdateOption(p.claimer.Backdate()),
+			// validators
+			defaultPublicKeyValidator{},
+			newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+			newSANDurationValidator(p.claimer),
+		}, newSPIFFESignOptions(p.claimer, ids)...), newTemplateData(token, claims.Subject, claims.SANs)), nil
+	}
+
 	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
-	return []SignOption{
+	return withX509CertificateOptions(p.Options, []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeJWK, p.Name, p.Key.KeyID),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		commonNameValidator(claims.Subject),
 		defaultPublicKeyValidator{},
@@ -164,7 +309,8 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		emailAddressesValidator(emails),
 		ipAddressesValidator(ips),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newSANDurationValidator(p.claimer),
+	}, newTemplateData(token, claims.Subject, claims.SANs)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -173,7 +319,14 @@ func (p *JWK) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 // certificate was configured to allow renewals.
 func (p *JWK) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("jwk.AuthorizeRenew; renew is disabled for jwk provisioner %s", p.GetID())
+		return errs.Unauthorized("jwk.AuthorizeRenew; renew is disabled for jwk provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "jwk.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "jwk.AuthorizeRenew")
 	}
 	return nil
 }
@@ -183,6 +336,10 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("jwk.AuthorizeSSHSign; sshCA is disabled for jwk provisioner %s", p.GetID())
 	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("jwk.AuthorizeSSHSign; issuance is disabled for jwk provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "jwk.AuthorizeSSHSign")
@@ -221,11 +378,12 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Default to a user certificate with no principals if not set
 	signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
 
-	return append(signOptions,
+	signOptions = append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
+		backdateOption(p.claimer.Backdate()),
 		// Validate that the keyID is equivalent to the token subject.
 		sshCertKeyIDValidator(claims.Subject),
 		// Validate public key
@@ -234,7 +392,8 @@ func (p *JWK) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshCertValidityValidator{p.claimer},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
-	), nil
+	)
+	return withSSHCertificateOptions(p.Options, signOptions, newTemplateData(token, claims.Subject, opts.Principals)), nil
 }
 
 // AuthorizeSSHRevoke returns nil if the token is valid, false otherwise.
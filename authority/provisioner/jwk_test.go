@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -81,6 +82,12 @@ func TestJWK_Init(t *testing.T) {
 				err: errors.New("claims: DefaultTLSCertDuration must be greater than 0"),
 			}
 		},
+		"fail-bad-keys": func(t *testing.T) ProvisionerValidateTest {
+			return ProvisionerValidateTest{
+				p:   &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, Keys: []*JWKKey{{}}, audiences: testAudiences},
+				err: errors.New("error validating provisioner keys: key cannot be empty"),
+			}
+		},
 		"ok": func(t *testing.T) ProvisionerValidateTest {
 			return ProvisionerValidateTest{
 				p: &JWK{Name: "foo", Type: "bar", Key: &jose.JSONWebKey{}, audiences: testAudiences},
@@ -157,6 +164,12 @@ func TestJWK_authorizeToken(t *testing.T) {
 	// Remove encrypted key for p2
 	p2.EncryptedKey = ""
 
+	// p3 is p1 with a clock skew that accepts the expired and not yet valid
+	// tokens.
+	p3 := &JWK{Name: p1.Name, Type: p1.Type, Key: p1.Key, audiences: p1.audiences}
+	p3.claimer, err = NewClaimer(&Claims{ClockSkew: &Duration{Duration: 10 * time.Minute}}, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
 	type args struct {
 		token string
 	}
@@ -179,6 +192,8 @@ func TestJWK_authorizeToken(t *testing.T) {
 		{"ok", p1, args{t1}, http.StatusOK, nil},
 		{"ok-no-encrypted-key", p2, args{t2}, http.StatusOK, nil},
 		{"ok-no-sans", p1, args{t3}, http.StatusOK, nil},
+		{"ok-clock-skew-expired", p3, args{failExp}, http.StatusOK, nil},
+		{"ok-clock-skew-not-before", p3, args{failNbf}, http.StatusOK, nil},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -197,6 +212,95 @@ func TestJWK_authorizeToken(t *testing.T) {
 	}
 }
 
+func TestJWK_authorizeToken_keys(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	now := time.Now()
+
+	newKey := func() (*jose.JSONWebKey, *JWKKey) {
+		key, err := generateJSONWebKey()
+		assert.FatalError(t, err)
+		pub := key.Public()
+		return key, &JWKKey{Key: &pub}
+	}
+	validKey, valid := newKey()
+	futureKey, future := newKey()
+	future.NotBefore = now.Add(time.Hour)
+	retiredKey, retired := newKey()
+	p.Keys = []*JWKKey{valid, future, retired}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+	_, err = p.RetireKey(retired.Key.KeyID, now.Add(-time.Minute))
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name string
+		key  *jose.JSONWebKey
+		err  error
+	}{
+		{"ok", validKey, nil},
+		{"fail-not-before", futureKey, errors.Errorf("jwk.authorizeToken; jwk token key %s is not valid at this time", futureKey.KeyID)},
+		{"fail-retired", retiredKey, errors.Errorf("jwk.authorizeToken; jwk token key %s is not valid at this time", retiredKey.KeyID)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, err := generateSimpleToken(p.Name, testAudiences.Sign[0], tt.key)
+			assert.FatalError(t, err)
+			_, err = p.authorizeToken(tok, testAudiences.Sign)
+			if tt.err != nil {
+				if assert.NotNil(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+					assert.Equals(t, tt.err.Error(), err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestJWK_AddKey(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public()
+	primary := p.Key.Public()
+	assertError := func(want string, err error) {
+		if assert.NotNil(t, err) {
+			assert.Equals(t, want, err.Error())
+		}
+	}
+
+	assertError("key cannot be empty", p.AddKey(&JWKKey{}))
+	assertError("key id cannot be empty", p.AddKey(&JWKKey{Key: &jose.JSONWebKey{Key: pub.Key}}))
+	assertError(fmt.Sprintf("key %s must be a public key", key.KeyID), p.AddKey(&JWKKey{Key: key}))
+	assertError(fmt.Sprintf("key %s notAfter cannot be before notBefore", pub.KeyID), p.AddKey(&JWKKey{
+		Key:       &pub,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(-time.Minute),
+	}))
+	assertError(fmt.Sprintf("key %s is the provisioner key", primary.KeyID), p.AddKey(&JWKKey{Key: &primary}))
+
+	// Adding the same key replaces it
+	assert.FatalError(t, p.AddKey(&JWKKey{Key: &pub}))
+	notBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.FatalError(t, p.AddKey(&JWKKey{Key: &pub, NotBefore: notBefore}))
+	keys := p.GetKeys()
+	assert.Len(t, 1, keys)
+	assert.Equals(t, notBefore, keys[0].NotBefore)
+
+	// Retire
+	notAfter := notBefore.Add(time.Hour)
+	retired, err := p.RetireKey(pub.KeyID, notAfter)
+	assert.FatalError(t, err)
+	assert.Equals(t, notAfter, retired.NotAfter)
+	assert.Equals(t, notAfter, p.GetKeys()[0].NotAfter)
+	_, err = p.RetireKey(primary.KeyID, notAfter)
+	assertError(fmt.Sprintf("key %s not found", primary.KeyID), err)
+}
+
 func TestJWK_AuthorizeRevoke(t *testing.T) {
 	p1, err := generateJWK()
 	assert.FatalError(t, err)
@@ -249,6 +353,14 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 	// invalid signature
 	failSig := t1[0 : len(t1)-2]
 
+	// issuance disabled
+	p2, err := generateJWK()
+	assert.FatalError(t, err)
+	disable := true
+	p2.Claims = &Claims{DisableIssuance: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
 	type args struct {
 		token string
 	}
@@ -263,6 +375,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 		ips    []net.IP
 	}{
 		{name: "fail-signature", prov: p1, args: args{failSig}, code: http.StatusUnauthorized, err: errors.New("jwk.AuthorizeSign: jwk.authorizeToken; error parsing jwk claims: square/go-jose: error in cryptographic primitive")},
+		{name: "fail-issuance-disabled", prov: p2, args: args{t1}, code: http.StatusForbidden, err: errors.New("jwk.AuthorizeSign; issuance is disabled for jwk provisioner " + p2.GetID())},
 		{"ok-sans", p1, args{t1}, http.StatusOK, nil, []string{"foo"}, []string{"max@smallstep.com"}, []net.IP{net.ParseIP("127.0.0.1")}},
 		{"ok-no-sans", p1, args{t2}, http.StatusOK, nil, []string{"subject"}, []string{}, []net.IP{}},
 	}
@@ -278,7 +391,7 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 				}
 			} else {
 				if assert.NotNil(t, got) {
-					assert.Len(t, 8, got)
+					assert.Len(t, 11, got)
 					for _, o := range got {
 						switch v := o.(type) {
 						case *provisionerExtensionOption:
@@ -288,6 +401,8 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 							assert.Len(t, 0, v.KeyValuePairs)
 						case profileDefaultDuration:
 							assert.Equals(t, time.Duration(v), tt.prov.claimer.DefaultTLSCertDuration())
+						case backdateOption:
+							assert.Equals(t, time.Duration(v), tt.prov.claimer.Backdate())
 						case commonNameValidator:
 							assert.Equals(t, string(v), "subject")
 						case defaultPublicKeyValidator:
@@ -300,6 +415,10 @@ func TestJWK_AuthorizeSign(t *testing.T) {
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
+						case *sanDurationValidator:
+							assert.Equals(t, v.claimer, tt.prov.claimer)
+						case x509ProfileValidator:
+							assert.Len(t, 0, v)
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -332,8 +451,8 @@ func TestJWK_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{generateRenewCertificate()}, http.StatusOK, false},
+		{"fail/renew-disabled", p2, args{generateRenewCertificate()}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1,12 +1,21 @@
 package provisioner
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
@@ -25,29 +34,179 @@ const (
 	// K8sSAID is the default ID for kubernetes service account provisioners.
 	K8sSAID     = "k8ssa/" + K8sSAName
 	k8sSAIssuer = "kubernetes/serviceaccount"
+
+	k8sSAUsernamePrefix = "system:serviceaccount:"
+	k8sSATokenReviewURI = "/apis/authentication.k8s.io/v1/tokenreviews"
+)
+
+// In-cluster configuration used by the TokenReview API integration if the
+// API server and credentials are not configured. They are variables so they
+// can be modified in tests.
+var (
+	k8sSAInClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	k8sSAInClusterCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 )
 
-// jwtPayload extends jwt.Claims with step attributes.
+// k8sSAPayload extends jwt.Claims with the kubernetes service account
+// attributes. Legacy secret based tokens use the flat claims, while bound
+// tokens, like the projected ones, use the kubernetes.io claim.
 type k8sSAPayload struct {
 	jose.Claims
-	Namespace          string `json:"kubernetes.io/serviceaccount/namespace,omitempty"`
-	SecretName         string `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
-	ServiceAccountName string `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
-	ServiceAccountUID  string `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	Namespace          string                 `json:"kubernetes.io/serviceaccount/namespace,omitempty"`
+	SecretName         string                 `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
+	ServiceAccountName string                 `json:"kubernetes.io/serviceaccount/service-account.name,omitempty"`
+	ServiceAccountUID  string                 `json:"kubernetes.io/serviceaccount/service-account.uid,omitempty"`
+	Kubernetes         *k8sSAKubernetesClaims `json:"kubernetes.io,omitempty"`
+}
+
+// k8sSAKubernetesClaims represents the kubernetes.io claim in bound service
+// account tokens.
+type k8sSAKubernetesClaims struct {
+	Namespace      string               `json:"namespace"`
+	ServiceAccount k8sSAObjectRefClaim  `json:"serviceaccount"`
+	Pod            *k8sSAObjectRefClaim `json:"pod,omitempty"`
+}
+
+// k8sSAObjectRefClaim represents a reference to a kubernetes object in a bound
+// service account token.
+type k8sSAObjectRefClaim struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+}
+
+// normalize sets the flat service account claims using the kubernetes.io
+// claim of bound tokens.
+func (p *k8sSAPayload) normalize() {
+	if k := p.Kubernetes; k != nil {
+		p.Namespace = k.Namespace
+		p.ServiceAccountName = k.ServiceAccount.Name
+		p.ServiceAccountUID = k.ServiceAccount.UID
+	}
+}
+
+// podName returns the name of the pod bound to the token, if any.
+func (p *k8sSAPayload) podName() string {
+	if p.Kubernetes != nil && p.Kubernetes.Pod != nil {
+		return p.Kubernetes.Pod.Name
+	}
+	return ""
+}
+
+// names returns the given names with the placeholders replaced by the values
+// in the payload. Names using a placeholder without a value are skipped.
+func (p *k8sSAPayload) names(names []string) []string {
+	return replacePlaceholders(names, map[string]string{
+		"{namespace}":      p.Namespace,
+		"{serviceaccount}": p.ServiceAccountName,
+		"{pod}":            p.podName(),
+	})
+}
+
+// K8sSARule maps the service accounts matching the namespace and name onto
+// the names allowed in the certificates.
+//
+// Namespace is required and ServiceAccount is optional, both are shell
+// patterns, e.g. "team-*". SANs and Principals can use the placeholders
+// {namespace}, {serviceaccount} and {pod}, the last one is only available in
+// tokens bound to a pod.
+type K8sSARule struct {
+	Namespace      string   `json:"namespace"`
+	ServiceAccount string   `json:"serviceAccount,omitempty"`
+	SANs           []string `json:"sans,omitempty"`
+	Principals     []string `json:"principals,omitempty"`
+}
+
+// Validate validates the patterns of the rule.
+func (r *K8sSARule) Validate() error {
+	if r.Namespace == "" {
+		return errors.New("namespace cannot be empty")
+	}
+	for _, pattern := range []string{r.Namespace, r.ServiceAccount} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "error parsing pattern %s", pattern)
+		}
+	}
+	return nil
+}
+
+// matches returns true if the service account in the payload matches the
+// rule.
+func (r *K8sSARule) matches(p *k8sSAPayload) bool {
+	return matchPattern(r.Namespace, p.Namespace) &&
+		matchPattern(r.ServiceAccount, p.ServiceAccountName)
+}
+
+// K8sSATokenReview configures the use of the kubernetes TokenReview API to
+// validate the service account tokens.
+//
+// APIServer is the address of the kubernetes API server, and TokenFile is the
+// file with the bearer token used to authenticate the requests; the service
+// account of the CA needs permission to create tokenreviews. If they are not
+// set, the in-cluster configuration of the pod running the CA is used.
+type K8sSATokenReview struct {
+	APIServer string `json:"apiServer,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+// k8sSATokenReviewRequest is a authentication.k8s.io/v1 TokenReview request.
+type k8sSATokenReviewRequest struct {
+	APIVersion string                      `json:"apiVersion"`
+	Kind       string                      `json:"kind"`
+	Spec       k8sSATokenReviewRequestSpec `json:"spec"`
+}
+
+type k8sSATokenReviewRequestSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// k8sSATokenReviewResponse is the subset of the TokenReview response used by
+// the provisioner.
+type k8sSATokenReviewResponse struct {
+	Status struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string `json:"username"`
+			UID      string `json:"uid"`
+		} `json:"user"`
+		Audiences []string `json:"audiences"`
+		Error     string   `json:"error"`
+	} `json:"status"`
 }
 
 // K8sSA represents a Kubernetes ServiceAccount provisioner; an
 // entity trusted to make signature requests.
+//
+// The tokens are validated using one of the following methods:
+//   - PubKeys: the PEM encoded public keys used to sign the tokens.
+//   - Issuer: the service account issuer of the cluster, the keys are
+//     retrieved using its OpenID configuration endpoint.
+//   - TokenReview: the kubernetes TokenReview API.
+//
+// Issuer is also used to validate the iss claim of bound tokens when PubKeys
+// or TokenReview are used. If Audiences is set, the tokens must be bound to
+// one of them. If Rules is set, only the service accounts matching one of the
+// rules are accepted, and the certificates can only contain the SANs or
+// principals of the matching rules.
 type K8sSA struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Claims    *Claims `json:"claims,omitempty"`
-	PubKeys   []byte  `json:"publicKeys,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	//kauthn    kauthn.AuthenticationV1Interface
-	pubKeys []interface{}
+	Type        string              `json:"type"`
+	Name        string              `json:"name"`
+	Claims      *Claims             `json:"claims,omitempty"`
+	Options     *CertificateOptions `json:"options,omitempty"`
+	PubKeys     []byte              `json:"publicKeys,omitempty"`
+	Issuer      string              `json:"issuer,omitempty"`
+	TokenReview *K8sSATokenReview   `json:"tokenReview,omitempty"`
+	Audiences   []string            `json:"audiences,omitempty"`
+	Rules       []K8sSARule         `json:"rules,omitempty"`
+	HTTPClient  *HTTPClientOptions  `json:"httpClient,omitempty"`
+	KeyStore    *KeyStoreOptions    `json:"keyStore,omitempty"`
+	claimer     *Claimer
+	audiences   Audiences
+	pubKeys     []interface{}
+	keyStore    *keyStore
+	client      *http.Client
+	tokenReview *K8sSATokenReview
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -84,6 +243,20 @@ func (p *K8sSA) Init(config Config) (err error) {
 		return errors.New("provisioner type cannot be empty")
 	case p.Name == "":
 		return errors.New("provisioner name cannot be empty")
+	case p.PubKeys == nil && p.Issuer == "" && p.TokenReview == nil:
+		return errors.New("K8s Service Account provisioner cannot be initialized without publicKeys, issuer or tokenReview")
+	case p.PubKeys != nil && p.TokenReview != nil:
+		return errors.New("K8s Service Account provisioner cannot use publicKeys and tokenReview at the same time")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	for i, r := range p.Rules {
+		if err := r.Validate(); err != nil {
+			return errors.Wrapf(err, "error validating rules[%d]", i)
+		}
 	}
 
 	if p.PubKeys != nil {
@@ -107,121 +280,319 @@ func (p *K8sSA) Init(config Config) (err error) {
 			}
 			p.pubKeys = append(p.pubKeys, key)
 		}
-	} else {
-		// TODO: Use the TokenReview API if no pub keys provided. This will need to
-		// be configured with additional attributes in the K8sSA struct for
-		// connecting to the kubernetes API server.
-		return errors.New("K8s Service Account provisioner cannot be initialized without pub keys")
-	}
-	/*
-		// NOTE: Not sure if we should be doing this initialization here ...
-		// If you have a k8sSA provisioner defined in your config, but you're not
-		// in a kubernetes pod then your CA will fail to startup. Maybe we just postpone
-		// creating the authn until token validation time?
-		if err := checkAccess(k8s.AuthorizationV1()); err != nil {
-			return errors.Wrapf(err, "error verifying access to kubernetes authz service for provisioner %s", p.GetID())
-		}
-
-		p.kauthn = k8s.AuthenticationV1()
-	*/
+	}
 
 	// Update claims with global ones
 	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
 		return err
 	}
 
+	switch {
+	case p.TokenReview != nil:
+		if err := p.initTokenReview(config); err != nil {
+			return err
+		}
+	case p.PubKeys == nil:
+		if err := p.initKeyStore(config); err != nil {
+			return err
+		}
+	}
+
 	p.audiences = config.Audiences
 	return err
 }
 
+// initTokenReview initializes the client used to call the TokenReview API,
+// using the in-cluster configuration if the API server is not set.
+func (p *K8sSA) initTokenReview(config Config) error {
+	tr := *p.TokenReview
+	clientOpts := p.HTTPClient.withDefaultProxy(config.Proxy)
+	if tr.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return errors.New("tokenReview.apiServer cannot be empty if the CA is not running in a kubernetes cluster")
+		}
+		tr.APIServer = "https://" + net.JoinHostPort(host, port)
+		// Trust the cluster CA if no other roots are configured.
+		if clientOpts == nil || len(clientOpts.RootCAs) == 0 {
+			if _, err := os.Stat(k8sSAInClusterCAFile); err == nil {
+				var opts HTTPClientOptions
+				if clientOpts != nil {
+					opts = *clientOpts
+				}
+				opts.RootCAs = []string{k8sSAInClusterCAFile}
+				clientOpts = &opts
+			}
+		}
+	}
+	if tr.TokenFile == "" {
+		tr.TokenFile = k8sSAInClusterTokenFile
+	}
+	if _, err := url.Parse(tr.APIServer); err != nil {
+		return errors.Wrapf(err, "error parsing tokenReview.apiServer %s", tr.APIServer)
+	}
+	client, err := newHTTPClient(clientOpts)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	p.tokenReview = &tr
+	return nil
+}
+
+// initKeyStore initializes the keyStore using the jwks_uri in the OpenID
+// configuration of the issuer.
+func (p *K8sSA) initKeyStore(config Config) error {
+	clientOpts := p.HTTPClient.withDefaultProxy(config.Proxy)
+	client, err := newHTTPClient(clientOpts)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	uri := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	b, _, err := readURI(ctx, client, uri)
+	if err != nil {
+		return err
+	}
+	var configuration openIDConfiguration
+	if err := json.Unmarshal(b, &configuration); err != nil {
+		return errors.Wrapf(err, "error reading %s", uri)
+	}
+	if err := configuration.Validate(); err != nil {
+		return errors.Wrapf(err, "error parsing %s", uri)
+	}
+	if configuration.Issuer != p.Issuer {
+		return errors.Errorf("error parsing %s: issuer %s does not match %s", uri, configuration.Issuer, p.Issuer)
+	}
+	p.client = client
+	p.keyStore, err = getKeyStore(ctx, client, clientOpts, configuration.JWKSetURI, p.KeyStore)
+	return err
+}
+
+// Close releases the keys of the issuer if they are used to validate the
+// tokens.
+func (p *K8sSA) Close() error {
+	releaseKeyStore(p.keyStore)
+	return nil
+}
+
 // authorizeToken performs common jwt authorization actions and returns the
-// claims for case specific downstream parsing.
+// claims and matching rules for case specific downstream parsing.
 // e.g. a Sign request will auth/validate different fields than a Revoke request.
-func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload, error) {
+func (p *K8sSA) authorizeToken(token string, audiences []string) (*k8sSAPayload, []K8sSARule, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusUnauthorized, err,
+		return nil, nil, errs.Wrap(http.StatusUnauthorized, err,
 			"k8ssa.authorizeToken; error parsing k8sSA token")
 	}
 
-	var (
-		valid  bool
-		claims k8sSAPayload
-	)
-	if p.pubKeys == nil {
-		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented")
-		/* NOTE: We plan to support the TokenReview API in a future release.
-		         Below is some code that should be useful when we prioritize
-				 this integration.
+	var claims *k8sSAPayload
+	if p.tokenReview != nil {
+		if claims, err = p.reviewToken(jwt, token); err != nil {
+			return nil, nil, err
+		}
+	} else if claims, err = p.verifyToken(jwt); err != nil {
+		return nil, nil, err
+	}
 
-			tr := kauthnApi.TokenReview{Spec: kauthnApi.TokenReviewSpec{Token: string(token)}}
-			rvw, err := p.kauthn.TokenReviews().Create(&tr)
-			if err != nil {
-				return nil, errors.Wrap(err, "error using kubernetes TokenReview API")
-			}
-			if rvw.Status.Error != "" {
-				return nil, errors.Errorf("error from kubernetes TokenReviewAPI: %s", rvw.Status.Error)
-			}
-			if !rvw.Status.Authenticated {
-				return nil, errors.New("error from kubernetes TokenReviewAPI: token could not be authenticated")
-			}
-			if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
-				return nil, errors.Wrap(err, "error parsing claims")
+	// Legacy tokens use a fixed issuer, and bound tokens the issuer of the
+	// cluster. The TokenReview API validates the issuer for us.
+	expected := jose.Expected{
+		Time: time.Now().UTC(),
+	}
+	switch {
+	case p.Issuer != "":
+		expected.Issuer = p.Issuer
+	case p.tokenReview == nil:
+		expected.Issuer = k8sSAIssuer
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = claims.ValidateWithLeeway(expected, p.claimer.ClockSkew()); err != nil {
+		return nil, nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; invalid k8sSA token claims")
+	}
+
+	if len(p.Audiences) > 0 && !matchesAudience(claims.Audience, p.Audiences) {
+		return nil, nil, errs.Unauthorized("k8ssa.authorizeToken; invalid k8sSA token - invalid audience claim (aud)")
+	}
+
+	if claims.Subject == "" {
+		return nil, nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token subject cannot be empty")
+	}
+
+	if len(p.Rules) == 0 {
+		return claims, nil, nil
+	}
+
+	var rules []K8sSARule
+	for _, r := range p.Rules {
+		if r.matches(claims) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token does not match any rule")
+	}
+
+	return claims, rules, nil
+}
+
+// verifyToken verifies the signature of the token using the configured public
+// keys or the keys of the issuer.
+func (p *K8sSA) verifyToken(jwt *jose.JSONWebToken) (*k8sSAPayload, error) {
+	var claims k8sSAPayload
+	if p.keyStore != nil {
+		var kid string
+		if len(jwt.Headers) > 0 {
+			kid = jwt.Headers[0].KeyID
+		}
+		for _, key := range p.keyStore.Get(kid) {
+			if err := jwt.Claims(key.Public(), &claims); err == nil {
+				claims.normalize()
+				return &claims, nil
 			}
-		*/
+		}
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token and extracting claims - cannot find key for kid %s", kid)
 	}
+
 	for _, pk := range p.pubKeys {
-		if err = jwt.Claims(pk, &claims); err == nil {
-			valid = true
-			break
+		if err := jwt.Claims(pk, &claims); err == nil {
+			claims.normalize()
+			return &claims, nil
 		}
 	}
-	if !valid {
-		return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token and extracting claims")
+	return nil, errs.Unauthorized("k8ssa.authorizeToken; error validating k8sSA token and extracting claims")
+}
+
+// reviewToken validates the token using the kubernetes TokenReview API. The
+// service account in the claims is replaced by the one in the review.
+func (p *K8sSA) reviewToken(jwt *jose.JSONWebToken, token string) (*k8sSAPayload, error) {
+	var claims k8sSAPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; error parsing k8sSA token claims")
 	}
 
-	// According to "rfc7519 JSON Web Token" acceptable skew should be no
-	// more than a few minutes.
-	if err = claims.Validate(jose.Expected{
-		Issuer: k8sSAIssuer,
-	}); err != nil {
-		return nil, errs.Wrap(http.StatusUnauthorized, err, "k8ssa.authorizeToken; invalid k8sSA token claims")
+	bearer, err := ioutil.ReadFile(p.tokenReview.TokenFile)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err,
+			"k8ssa.authorizeToken; error reading %s", p.tokenReview.TokenFile)
+	}
+	body, err := json.Marshal(k8sSATokenReviewRequest{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec: k8sSATokenReviewRequestSpec{
+			Token:     token,
+			Audiences: p.Audiences,
+		},
+	})
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.authorizeToken; error marshaling TokenReview")
 	}
 
-	if claims.Subject == "" {
-		return nil, errs.Unauthorized("k8ssa.authorizeToken; k8sSA token subject cannot be empty")
+	u := strings.TrimSuffix(p.tokenReview.APIServer, "/") + k8sSATokenReviewURI
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.authorizeToken; error creating TokenReview request")
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(bearer)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.authorizeToken; error using kubernetes TokenReview API")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, errs.InternalServer("k8ssa.authorizeToken; error from kubernetes TokenReview API: %s", resp.Status)
+	}
+
+	var rvw k8sSATokenReviewResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rvw); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.authorizeToken; error decoding TokenReview response")
+	}
+	if rvw.Status.Error != "" {
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; error from kubernetes TokenReview API: %s", rvw.Status.Error)
+	}
+	if !rvw.Status.Authenticated {
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; error from kubernetes TokenReview API: token could not be authenticated")
+	}
+	if len(p.Audiences) > 0 && !matchesAudience(rvw.Status.Audiences, p.Audiences) {
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; error from kubernetes TokenReview API: token is not bound to the audiences")
 	}
 
+	// The username of a service account is system:serviceaccount:<namespace>:<name>.
+	parts := strings.Split(strings.TrimPrefix(rvw.Status.User.Username, k8sSAUsernamePrefix), ":")
+	if !strings.HasPrefix(rvw.Status.User.Username, k8sSAUsernamePrefix) || len(parts) != 2 {
+		return nil, errs.Unauthorized("k8ssa.authorizeToken; user %s is not a service account", rvw.Status.User.Username)
+	}
+	claims.normalize()
+	claims.Namespace = parts[0]
+	claims.ServiceAccountName = parts[1]
+	claims.ServiceAccountUID = rvw.Status.User.UID
 	return &claims, nil
 }
 
 // AuthorizeRevoke returns an error if the provisioner does not have rights to
 // revoke the certificate with serial number in the `sub` property.
 func (p *K8sSA) AuthorizeRevoke(ctx context.Context, token string) error {
-	_, err := p.authorizeToken(token, p.audiences.Revoke)
+	_, _, err := p.authorizeToken(token, p.audiences.Revoke)
 	return errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeRevoke")
 }
 
 // AuthorizeSign validates the given token.
 func (p *K8sSA) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	if _, err := p.authorizeToken(token, p.audiences.Sign); err != nil {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("k8ssa.AuthorizeSign; issuance is disabled for k8sSA provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, rules, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSign")
 	}
 
-	return []SignOption{
+	signOptions := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeK8sSA, p.Name, ""),
 		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newSANDurationValidator(p.claimer),
+	}
+
+	// Restrict the SANs to the ones in the matching rules.
+	if len(p.Rules) > 0 {
+		var sans []string
+		for _, r := range rules {
+			sans = append(sans, claims.names(r.SANs)...)
+		}
+		if len(sans) == 0 {
+			return nil, errs.Unauthorized("k8ssa.AuthorizeSign; k8sSA token does not allow any SAN")
+		}
+		signOptions = append(signOptions, allowedSANsValidator(sans))
+	}
+
+	if p.claimer.IsSPIFFEEnabled() {
+		signOptions = append(signOptions, newSPIFFESignOptions(p.claimer, nil)...)
+	}
+
+	return withX509CertificateOptions(p.Options, signOptions, newTemplateData(token, claims.Subject, nil)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
 func (p *K8sSA) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("k8ssa.AuthorizeRenew; renew is disabled for k8sSA provisioner %s", p.GetID())
+		return errs.Unauthorized("k8ssa.AuthorizeRenew; renew is disabled for k8sSA provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "k8ssa.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "k8ssa.AuthorizeRenew")
 	}
 	return nil
 }
@@ -231,47 +602,53 @@ func (p *K8sSA) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOptio
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("k8ssa.AuthorizeSSHSign; sshCA is disabled for k8sSA provisioner %s", p.GetID())
 	}
-	if _, err := p.authorizeToken(token, p.audiences.SSHSign); err != nil {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("k8ssa.AuthorizeSSHSign; issuance is disabled for k8sSA provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, rules, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "k8ssa.AuthorizeSSHSign")
 	}
 
-	// Default to a user certificate with no principals if not set
-	signOptions := []SignOption{sshCertDefaultsModifier{CertType: SSHUserCert}}
+	var signOptions []SignOption
+	if len(p.Rules) > 0 {
+		var principals []string
+		for _, r := range rules {
+			principals = append(principals, claims.names(r.Principals)...)
+		}
+		if len(principals) == 0 {
+			return nil, errs.Unauthorized("k8ssa.AuthorizeSSHSign; k8sSA token does not allow any principal")
+		}
+		// Default to a user certificate with the allowed principals
+		defaults := SSHOptions{
+			CertType:   SSHUserCert,
+			Principals: principals,
+		}
+		signOptions = append(signOptions,
+			// Set the key id to the token subject
+			sshCertKeyIDModifier(claims.Subject),
+			// Validate user options
+			sshCertOptionsValidator(defaults),
+			// Set defaults if not given as user options
+			sshCertDefaultsModifier(defaults),
+		)
+	} else {
+		// Default to a user certificate with no principals if not set
+		signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
+	}
 
-	return append(signOptions,
+	return withSSHCertificateOptions(p.Options, append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{p.claimer},
+		backdateOption(p.claimer.Backdate()),
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{p.claimer},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
-	), nil
-}
-
-/*
-func checkAccess(authz kauthz.AuthorizationV1Interface) error {
-	r := &kauthzApi.SelfSubjectAccessReview{
-		Spec: kauthzApi.SelfSubjectAccessReviewSpec{
-			ResourceAttributes: &kauthzApi.ResourceAttributes{
-				Group:    "authentication.k8s.io",
-				Version:  "v1",
-				Resource: "tokenreviews",
-				Verb:     "create",
-			},
-		},
-	}
-	rvw, err := authz.SelfSubjectAccessReviews().Create(r)
-	if err != nil {
-		return err
-	}
-	if !rvw.Status.Allowed {
-		return fmt.Errorf("Unable to create kubernetes token reviews: %s", rvw.Status.Reason)
-	}
-
-	return nil
+	), newTemplateData(token, claims.Subject, nil)), nil
 }
-*/
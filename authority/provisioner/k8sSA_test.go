@@ -2,8 +2,13 @@ package provisioner
 
 import (
 	"context"
+	"crypto"
 	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
@@ -51,7 +56,7 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 				err:   errors.New("k8ssa.authorizeToken; error parsing k8sSA token"),
 			}
 		},
-		"fail/not-implemented": func(t *testing.T) test {
+		"fail/no-keys": func(t *testing.T) test {
 			jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
 			assert.FatalError(t, err)
 			p, err := generateK8sSA(nil)
@@ -63,7 +68,7 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 			return test{
 				p:     p,
 				token: tok,
-				err:   errors.New("k8ssa.authorizeToken; k8sSA TokenReview API integration not implemented"),
+				err:   errors.New("k8ssa.authorizeToken; error validating k8sSA token and extracting claims"),
 				code:  http.StatusUnauthorized,
 			}
 		},
@@ -114,7 +119,7 @@ func TestK8sSA_authorizeToken(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if claims, err := tc.p.authorizeToken(tc.token, testAudiences.Sign); err != nil {
+			if claims, _, err := tc.p.authorizeToken(tc.token, testAudiences.Sign); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -196,7 +201,7 @@ func TestK8sSA_AuthorizeRenew(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: generateRenewCertificate(),
 				code: http.StatusUnauthorized,
 				err:  errors.Errorf("k8ssa.AuthorizeRenew; renew is disabled for k8sSA provisioner %s", p.GetID()),
 			}
@@ -206,7 +211,7 @@ func TestK8sSA_AuthorizeRenew(t *testing.T) {
 			assert.FatalError(t, err)
 			return test{
 				p:    p,
-				cert: &x509.Certificate{},
+				cert: generateRenewCertificate(),
 			}
 		},
 	}
@@ -281,16 +286,22 @@ func TestK8sSA_AuthorizeSign(t *testing.T) {
 								assert.Len(t, 0, v.KeyValuePairs)
 							case profileDefaultDuration:
 								assert.Equals(t, time.Duration(v), tc.p.claimer.DefaultTLSCertDuration())
+							case backdateOption:
+								assert.Equals(t, time.Duration(v), tc.p.claimer.Backdate())
 							case defaultPublicKeyValidator:
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *sanDurationValidator:
+								assert.Equals(t, v.claimer, tc.p.claimer)
+							case x509ProfileValidator:
+								assert.Len(t, 0, v)
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 4)
+						assert.Equals(t, tot, 7)
 					}
 				}
 			}
@@ -369,15 +380,365 @@ func TestK8sSA_AuthorizeSSHSign(t *testing.T) {
 							case *sshCertDefaultValidator:
 							case *sshDefaultDuration:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
+							case backdateOption:
+								assert.Equals(t, time.Duration(v), tc.p.claimer.Backdate())
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 6)
+						assert.Equals(t, tot, 7)
 					}
 				}
 			}
 		})
 	}
 }
+
+func TestK8sSA_Init(t *testing.T) {
+	pubKeys, err := ioutil.ReadFile("./testdata/certs/foo.pub")
+	assert.FatalError(t, err)
+
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	var issuer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.RequestURI {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(openIDConfiguration{Issuer: issuer, JWKSetURI: issuer + "/openid/v1/jwks"})
+		case "/openid/v1/jwks":
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk.Public()}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	issuer = srv.URL
+
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+	type fields struct {
+		Type        string
+		Name        string
+		PubKeys     []byte
+		Issuer      string
+		TokenReview *K8sSATokenReview
+		Rules       []K8sSARule
+	}
+	tests := []struct {
+		name         string
+		fields       fields
+		wantKeyStore bool
+		wantErr      bool
+	}{
+		{"ok publicKeys", fields{"K8sSA", K8sSAName, pubKeys, "", nil, nil}, false, false},
+		{"ok publicKeys and issuer", fields{"K8sSA", K8sSAName, pubKeys, issuer, nil, nil}, false, false},
+		{"ok issuer", fields{"K8sSA", K8sSAName, nil, issuer, nil, nil}, true, false},
+		{"ok tokenReview", fields{"K8sSA", K8sSAName, nil, "", &K8sSATokenReview{APIServer: srv.URL, TokenFile: "testdata/secrets/token"}, nil}, false, false},
+		{"ok rules", fields{"K8sSA", K8sSAName, pubKeys, "", nil, []K8sSARule{{Namespace: "team-*", SANs: []string{"{serviceaccount}.{namespace}.svc"}}}}, false, false},
+		{"fail type", fields{"", K8sSAName, pubKeys, "", nil, nil}, false, true},
+		{"fail name", fields{"K8sSA", "", pubKeys, "", nil, nil}, false, true},
+		{"fail no method", fields{"K8sSA", K8sSAName, nil, "", nil, nil}, false, true},
+		{"fail publicKeys and tokenReview", fields{"K8sSA", K8sSAName, pubKeys, "", &K8sSATokenReview{APIServer: srv.URL}, nil}, false, true},
+		{"fail issuer", fields{"K8sSA", K8sSAName, nil, srv.URL + "/foo", nil, nil}, false, true},
+		{"fail issuer mismatch", fields{"K8sSA", K8sSAName, nil, srv.URL + "/", nil, nil}, false, true},
+		{"fail rules namespace", fields{"K8sSA", K8sSAName, pubKeys, "", nil, []K8sSARule{{ServiceAccount: "foo"}}}, false, true},
+		{"fail rules pattern", fields{"K8sSA", K8sSAName, pubKeys, "", nil, []K8sSARule{{Namespace: "["}}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &K8sSA{
+				Type:        tt.fields.Type,
+				Name:        tt.fields.Name,
+				PubKeys:     tt.fields.PubKeys,
+				Issuer:      tt.fields.Issuer,
+				TokenReview: tt.fields.TokenReview,
+				Rules:       tt.fields.Rules,
+			}
+			if err := p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if ok := p.keyStore != nil; ok != tt.wantKeyStore {
+				t.Errorf("K8sSA.Init() keyStore = %v, want %v", ok, tt.wantKeyStore)
+			}
+		})
+	}
+}
+
+func TestK8sSA_authorizeToken_bound(t *testing.T) {
+	const issuer = "https://kubernetes.default.svc"
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+
+	p, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	p.Issuer = issuer
+	p.Audiences = []string{"step-ca"}
+	p.Rules = []K8sSARule{
+		{Namespace: "ns-*", ServiceAccount: "san-foo"},
+		{Namespace: "ns-bar"},
+	}
+
+	tok, err := generateK8sSAToken(jwk, getK8sSABoundPayload(issuer, []string{"step-ca"}))
+	assert.FatalError(t, err)
+	failIss, err := generateK8sSAToken(jwk, getK8sSABoundPayload("https://foo.bar.zar", []string{"step-ca"}))
+	assert.FatalError(t, err)
+	failAud, err := generateK8sSAToken(jwk, getK8sSABoundPayload(issuer, []string{"foo"}))
+	assert.FatalError(t, err)
+	expired := getK8sSABoundPayload(issuer, []string{"step-ca"})
+	expired.Expiry = jose.NewNumericDate(time.Now().Add(-time.Hour))
+	failExp, err := generateK8sSAToken(jwk, expired)
+	assert.FatalError(t, err)
+	noRule := getK8sSABoundPayload(issuer, []string{"step-ca"})
+	noRule.Kubernetes.Namespace = "kube-system"
+	failRule, err := generateK8sSAToken(jwk, noRule)
+	assert.FatalError(t, err)
+	// Legacy tokens do not have the issuer of the cluster.
+	failLegacy, err := generateK8sSAToken(jwk, nil)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name      string
+		token     string
+		wantRules []K8sSARule
+		wantErr   bool
+	}{
+		{"ok", tok, p.Rules[:1], false},
+		{"fail iss", failIss, nil, true},
+		{"fail aud", failAud, nil, true},
+		{"fail exp", failExp, nil, true},
+		{"fail rule", failRule, nil, true},
+		{"fail legacy", failLegacy, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, rules, err := p.authorizeToken(tt.token, testAudiences.Sign)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				return
+			}
+			assert.Equals(t, claims.Namespace, "ns-foo")
+			assert.Equals(t, claims.ServiceAccountName, "san-foo")
+			assert.Equals(t, claims.ServiceAccountUID, "sauid-foo")
+			assert.Equals(t, rules, tt.wantRules)
+		})
+	}
+}
+
+func TestK8sSA_authorizeToken_tokenReview(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	tok, err := generateK8sSAToken(jwk, getK8sSABoundPayload("https://kubernetes.default.svc", []string{"step-ca"}))
+	assert.FatalError(t, err)
+	legacy, err := generateK8sSAToken(jwk, nil)
+	assert.FatalError(t, err)
+
+	// The response is selected using the namespace in the token.
+	responses := map[string]string{
+		"ok":           `{"status":{"authenticated":true,"user":{"username":"system:serviceaccount:ns-bar:san-bar","uid":"sauid-bar"},"audiences":["step-ca"]}}`,
+		"error":        `{"status":{"error":"invalid bearer token"}}`,
+		"unauthorized": `{"status":{"authenticated":false}}`,
+		"user":         `{"status":{"authenticated":true,"user":{"username":"jane"},"audiences":["step-ca"]}}`,
+		"aud":          `{"status":{"authenticated":true,"user":{"username":"system:serviceaccount:ns-bar:san-bar"}}}`,
+		"forbidden":    ``,
+		"bad-json":     `{`,
+	}
+	var response string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req k8sSATokenReviewRequest
+		if r.RequestURI != k8sSATokenReviewURI || r.Method != http.MethodPost ||
+			r.Header.Get("Authorization") != "Bearer the-token" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Kind != "TokenReview" ||
+			!reflect.DeepEqual(req.Spec.Audiences, []string{"step-ca"}) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if response == "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	defer srv.Close()
+
+	p, err := generateK8sSA(nil)
+	assert.FatalError(t, err)
+	p.pubKeys = nil
+	p.Audiences = []string{"step-ca"}
+	p.client = srv.Client()
+	p.tokenReview = &K8sSATokenReview{
+		APIServer: srv.URL,
+		TokenFile: "testdata/secrets/token",
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		response string
+		code     int
+		wantErr  bool
+	}{
+		{"ok", tok, "ok", http.StatusOK, false},
+		{"fail legacy", legacy, "ok", http.StatusUnauthorized, true},
+		{"fail error", tok, "error", http.StatusUnauthorized, true},
+		{"fail unauthorized", tok, "unauthorized", http.StatusUnauthorized, true},
+		{"fail user", tok, "user", http.StatusUnauthorized, true},
+		{"fail aud", tok, "aud", http.StatusUnauthorized, true},
+		{"fail forbidden", tok, "forbidden", http.StatusInternalServerError, true},
+		{"fail bad json", tok, "bad-json", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response = responses[tt.response]
+			claims, _, err := p.authorizeToken(tt.token, testAudiences.Sign)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.authorizeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				return
+			}
+			// The service account in the review is used.
+			assert.Equals(t, claims.Namespace, "ns-bar")
+			assert.Equals(t, claims.ServiceAccountName, "san-bar")
+			assert.Equals(t, claims.ServiceAccountUID, "sauid-bar")
+		})
+	}
+}
+
+func TestK8sSA_AuthorizeSign_rules(t *testing.T) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	p, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	p.Rules = []K8sSARule{
+		{Namespace: "ns-*", SANs: []string{"{serviceaccount}.{namespace}.svc", "{pod}.pods.internal"}},
+	}
+	p2, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	p2.Rules = []K8sSARule{
+		{Namespace: "ns-*", SANs: []string{"{pod}.pods.internal"}},
+	}
+
+	legacy, err := generateK8sSAToken(jwk, nil)
+	assert.FatalError(t, err)
+	bound := getK8sSABoundPayload(k8sSAIssuer, nil)
+	tok, err := generateK8sSAToken(jwk, bound)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name     string
+		p        *K8sSA
+		token    string
+		wantSANs []string
+		wantErr  bool
+	}{
+		{"ok legacy", p, legacy, []string{"san-foo.ns-foo.svc"}, false},
+		{"ok bound", p, tok, []string{"san-foo.ns-foo.svc", "pod-foo.pods.internal"}, false},
+		{"fail no SANs", p2, legacy, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.p.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				return
+			}
+			assert.Len(t, 8, opts)
+			v, ok := opts[6].(allowedSANsValidator)
+			if assert.True(t, ok) {
+				assert.Equals(t, []string(v), tt.wantSANs)
+			}
+		})
+	}
+}
+
+func TestK8sSA_AuthorizeSSHSign_rules(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	p, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	p.Rules = []K8sSARule{
+		{Namespace: "ns-foo", Principals: []string{"{serviceaccount}"}},
+	}
+	p2, err := generateK8sSA(jwk.Public().Key)
+	assert.FatalError(t, err)
+	p2.Rules = []K8sSARule{
+		{Namespace: "ns-foo", SANs: []string{"{serviceaccount}.{namespace}.svc"}},
+	}
+
+	tok, err := generateK8sSAToken(jwk, nil)
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+
+	userDuration := p.claimer.DefaultUserSSHCertDuration()
+	expected := &SSHOptions{
+		CertType: "user", Principals: []string{"san-foo"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration)),
+	}
+
+	tests := []struct {
+		name        string
+		p           *K8sSA
+		sshOpts     SSHOptions
+		wantErr     bool
+		wantSignErr bool
+	}{
+		{"ok", p, SSHOptions{}, false, false},
+		{"ok principals", p, SSHOptions{Principals: []string{"san-foo"}}, false, false},
+		{"fail principal", p, SSHOptions{Principals: []string{"root"}}, false, true},
+		{"fail type", p, SSHOptions{CertType: "host"}, false, true},
+		{"fail no principals", p2, SSHOptions{}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSSHSign(context.Background(), tok)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("K8sSA.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				return
+			}
+			cert, err := signSSHCertificate(pub, tt.sshOpts, got, signer.Key.(crypto.Signer))
+			if (err != nil) != tt.wantSignErr {
+				t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+			} else if !tt.wantSignErr {
+				assert.NoError(t, validateSSHCertificate(cert, expected))
+			}
+		})
+	}
+}
@@ -1,124 +1,888 @@
 package provisioner
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"regexp"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/errors"
+	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
 )
 
 const (
 	defaultCacheAge    = 12 * time.Hour
 	defaultCacheJitter = 1 * time.Hour
+	// defaultFileCacheAge is the cache age of keys in local files; changes in
+	// the files are also detected using a file watcher.
+	defaultFileCacheAge = 5 * time.Minute
 )
 
-var maxAgeRegex = regexp.MustCompile("max-age=([0-9]+)")
+// Parameters of the exponential backoff used to retry failed reloads. After
+// maxReloadRetries consecutive failures the keyStore will fall back to the
+// regular reload schedule.
+const (
+	minReloadBackoff = 10 * time.Second
+	maxReloadBackoff = 10 * time.Minute
+	maxReloadRetries = 10
+)
+
+// KeyStoreOptions defines the options used by the provisioners to cache the
+// keys used to validate tokens.
+//
+// GracePeriod is the time the keys are still valid after they expire. During
+// this period the expired keys are used while they are refreshed in the
+// background, after it, the keys will not be used if the refresh fails.
+//
+// CacheFile is the path of a file used to persist the keys across restarts. If
+// set, the keys in the file will be used at startup if they have not expired
+// or if the keys cannot be fetched.
+//
+// MinCacheAge and MaxCacheAge bound the cache age sent by the server in the
+// Cache-Control header. MinCacheAge avoids refreshing the keys on every token
+// validation if the server sends max-age=0 or no-cache.
+//
+// X5CRoots is the path of a file with the PEM encoded root certificates used
+// to validate the x5c certificate chains in the keys. If set, keys without a
+// valid chain will not be used.
+type KeyStoreOptions struct {
+	GracePeriod *Duration `json:"gracePeriod,omitempty"`
+	CacheFile   string    `json:"cacheFile,omitempty"`
+	MinCacheAge *Duration `json:"minCacheAge,omitempty"`
+	MaxCacheAge *Duration `json:"maxCacheAge,omitempty"`
+	X5CRoots    string    `json:"x5cRoots,omitempty"`
+}
+
+// Validate validates the key store options, nil is ok.
+func (o *KeyStoreOptions) Validate() error {
+	switch {
+	case o == nil:
+		return nil
+	case o.GracePeriod.Value() < 0:
+		return errors.New("keyStore.gracePeriod cannot be negative")
+	case o.MinCacheAge.Value() < 0:
+		return errors.New("keyStore.minCacheAge cannot be negative")
+	case o.MaxCacheAge.Value() < 0:
+		return errors.New("keyStore.maxCacheAge cannot be negative")
+	case o.MaxCacheAge.Value() > 0 && o.MinCacheAge.Value() > o.MaxCacheAge.Value():
+		return errors.New("keyStore.minCacheAge cannot be greater than keyStore.maxCacheAge")
+	default:
+		return nil
+	}
+}
 
 type keyStore struct {
 	sync.RWMutex
+	client *http.Client
 	uri    string
 	keySet jose.JSONWebKeySet
 	timer  *time.Timer
 	expiry time.Time
 	jitter time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	// failures is the number of consecutive failed reloads, err the error of
+	// the last one and retry the time of the next retry.
+	failures int
+	err      error
+	retry    time.Time
+	// grace is the configured stale-serving period and stale the one sent by
+	// the server with the stale-while-revalidate directive.
+	grace time.Duration
+	stale time.Duration
+	// minAge and maxAge bound the cache age sent by the server.
+	minAge time.Duration
+	maxAge time.Duration
+	// roots are used to validate the x5c certificate chains in the keys.
+	roots *x509.CertPool
+	// inflight is set while a reload is in progress and it is closed when the
+	// reload finishes, reloads is the number of finished reloads.
+	inflight chan struct{}
+	reloads  uint64
+	// cacheFile is the file used to persist the keys.
+	cacheFile string
+	// watcher reloads the keys when a local file changes.
+	watcher *fsnotify.Watcher
+	// statistics
+	lastReload    time.Time
+	successes     uint64
+	totalFailures uint64
+	// key is the registry key of the keyStore and refs the number of
+	// provisioners using it, both are guarded by the keyStores lock.
+	key  string
+	refs int
 }
 
-func newKeyStore(uri string) (*keyStore, error) {
-	keys, age, err := getKeysFromJWKsURI(uri)
-	if err != nil {
+// keyStoreCache is the content of the keyStore cache file.
+type keyStoreCache struct {
+	URI    string             `json:"uri"`
+	Time   time.Time          `json:"time"`
+	Expiry time.Time          `json:"expiry"`
+	KeySet jose.JSONWebKeySet `json:"keySet"`
+}
+
+// KeyStoreStats contains the statistics of the keys that a provisioner uses to
+// validate tokens.
+type KeyStoreStats struct {
+	// URI is the address of the keys.
+	URI string
+	// Keys is the number of keys available.
+	Keys int
+	// Reloads and Failures are the total number of successful and failed
+	// reloads, including the initial one.
+	Reloads  uint64
+	Failures uint64
+	// LastReload is the time of the last successful reload.
+	LastReload time.Time
+	// Expiry is the time the keys expire.
+	Expiry time.Time
+	// GracePeriod is the time the keys are still used after they expire.
+	GracePeriod time.Duration
+	// Err is the error of the last reload, nil if it was successful.
+	Err error
+}
+
+// KeyStoreStatus is the health status of the keys that a provisioner uses to
+// validate tokens.
+type KeyStoreStatus string
+
+const (
+	// KeyStoreFresh is the status of keys that have not expired and whose last
+	// reload succeeded.
+	KeyStoreFresh KeyStoreStatus = "fresh"
+	// KeyStoreStale is the status of keys that are still used although they
+	// have expired or the last reload failed.
+	KeyStoreStale KeyStoreStatus = "stale"
+	// KeyStoreFailed is the status of keys that cannot be used because the
+	// reloads failed and there are no keys or the grace period has passed.
+	KeyStoreFailed KeyStoreStatus = "failed"
+)
+
+// Status returns the health status of the keys at the given time.
+func (s KeyStoreStats) Status(now time.Time) KeyStoreStatus {
+	switch {
+	case s.Err != nil && (s.Keys == 0 || (s.GracePeriod > 0 && now.After(s.Expiry.Add(s.GracePeriod)))):
+		return KeyStoreFailed
+	case s.Err == nil && now.Before(s.Expiry):
+		return KeyStoreFresh
+	default:
+		return KeyStoreStale
+	}
+}
+
+// KeyStoreProvisioner is the interface implemented by the provisioners that
+// validate tokens with keys fetched from a remote server.
+type KeyStoreProvisioner interface {
+	Interface
+	GetKeyStoreStats() KeyStoreStats
+}
+
+// Closer is the interface implemented by the provisioners that must release
+// background resources, like the reload timers and file watchers of their key
+// stores, when they are removed or replaced. Close must be called only once.
+type Closer interface {
+	Close() error
+}
+
+// newKeyStore creates a new keyStore with the keys in the given uri. The given
+// context is only used to bound the initial request, the reloads will use the
+// client timeout and will be cancelled when the keyStore is closed.
+func newKeyStore(ctx context.Context, client *http.Client, uri string, opts *KeyStoreOptions) (*keyStore, error) {
+	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
 	ks := &keyStore{
+		client: client,
 		uri:    uri,
-		keySet: keys,
-		expiry: getExpirationTime(age),
-		jitter: getCacheJitter(age),
 	}
-	next := ks.nextReloadDuration(age)
+	if opts != nil {
+		ks.grace = opts.GracePeriod.Value()
+		ks.cacheFile = opts.CacheFile
+		ks.minAge = opts.MinCacheAge.Value()
+		ks.maxAge = opts.MaxCacheAge.Value()
+		if opts.X5CRoots != "" {
+			roots, err := x509util.ReadCertPool(opts.X5CRoots)
+			if err != nil {
+				return nil, errors.Wrap(err, "error reading keyStore.x5cRoots")
+			}
+			ks.roots = roots
+		}
+	}
+
+	var next time.Duration
+	cache, cacheErr := ks.readCache()
+	if age := time.Until(cache.Expiry).Truncate(time.Second); cacheErr == nil && age > 0 {
+		// Use the cached keys if they have not expired.
+		ks.keySet = cache.KeySet
+		ks.expiry = cache.Expiry
+		ks.jitter = getCacheJitter(age)
+		ks.lastReload = cache.Time
+		next = ks.nextReloadDuration(age)
+	} else {
+		keys, policy, err := ks.fetchKeys(ctx)
+		switch {
+		case err == nil:
+			next = ks.setKeys(keys, policy)
+		case cacheErr == nil:
+			// Use the expired cached keys and retry.
+			ks.keySet = cache.KeySet
+			ks.expiry = cache.Expiry
+			ks.jitter = defaultCacheJitter
+			ks.lastReload = cache.Time
+			next = ks.setError(err)
+		default:
+			return nil, err
+		}
+	}
+
+	ks.ctx, ks.cancel = context.WithCancel(context.Background())
 	ks.timer = time.AfterFunc(next, ks.reload)
+	if name, ok := fileURIPath(uri); ok {
+		ks.watch(name)
+	}
+	return ks, nil
+}
+
+// keyStores is the process-wide registry of key stores. Provisioners using the
+// same uri and options will share the keys and the reload timer. The key stores
+// are reference counted, and they are closed and removed from the registry
+// when the last provisioner using them releases them. The key stores being
+// created are kept in pending, so the keys are fetched without the lock and
+// only once for concurrent provisioners.
+var keyStores = struct {
+	sync.Mutex
+	m       map[string]*keyStore
+	pending map[string]*keyStoreCall
+}{m: make(map[string]*keyStore), pending: make(map[string]*keyStoreCall)}
+
+// keyStoreCall is the creation of a keyStore in progress, done is closed when
+// it finishes.
+type keyStoreCall struct {
+	done chan struct{}
+	err  error
+}
+
+// getKeyStore returns the shared keyStore for the given uri and options,
+// creating it if necessary. The HTTP client options are part of the registry
+// key as the given client is created from them. Every keyStore returned must
+// be released with releaseKeyStore.
+func getKeyStore(ctx context.Context, client *http.Client, clientOpts *HTTPClientOptions, uri string, opts *KeyStoreOptions) (*keyStore, error) {
+	b, err := json.Marshal([]interface{}{uri, clientOpts, opts})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling keyStore options")
+	}
+	key := string(b)
+
+	keyStores.Lock()
+	for {
+		if ks, ok := keyStores.m[key]; ok && !ks.isClosed() {
+			ks.refs++
+			keyStores.Unlock()
+			return ks, nil
+		}
+		call, ok := keyStores.pending[key]
+		if !ok {
+			break
+		}
+		// Wait for the keyStore created by another provisioner.
+		keyStores.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "error reading %s", uri)
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		keyStores.Lock()
+	}
+	call := &keyStoreCall{done: make(chan struct{})}
+	keyStores.pending[key] = call
+	keyStores.Unlock()
+
+	ks, err := newKeyStore(ctx, client, uri, opts)
+
+	keyStores.Lock()
+	defer keyStores.Unlock()
+	delete(keyStores.pending, key)
+	call.err = err
+	close(call.done)
+	if err != nil {
+		return nil, err
+	}
+	ks.key = key
+	ks.refs = 1
+	keyStores.m[key] = ks
 	return ks, nil
 }
 
+// releaseKeyStore releases a keyStore returned by getKeyStore. The keyStore
+// is closed and removed from the registry if it is not used by any other
+// provisioner, nil is ok.
+func releaseKeyStore(ks *keyStore) {
+	if ks == nil {
+		return
+	}
+	keyStores.Lock()
+	defer keyStores.Unlock()
+	if ks.refs--; ks.refs > 0 {
+		return
+	}
+	if keyStores.m[ks.key] == ks {
+		delete(keyStores.m, ks.key)
+	}
+	ks.Close()
+}
+
+// Close stops the reload timer and cancels any reload in progress.
 func (ks *keyStore) Close() {
+	ks.Lock()
+	defer ks.Unlock()
 	ks.timer.Stop()
+	ks.cancel()
+	if ks.watcher != nil {
+		ks.watcher.Close()
+	}
+}
+
+// isClosed returns true if the keyStore has been closed.
+func (ks *keyStore) isClosed() bool {
+	return ks.ctx != nil && ks.ctx.Err() != nil
+}
+
+// watch reloads the keys when the given file changes. The directory is watched
+// so files replaced atomically are also detected. Errors creating the watcher
+// are ignored, the keys will still be reloaded periodically.
+func (ks *keyStore) watch(name string) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	if err := w.Add(filepath.Dir(name)); err != nil {
+		w.Close()
+		return
+	}
+	ks.watcher = w
+	name = filepath.Clean(name)
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == name && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					ks.reload()
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
 }
 
 func (ks *keyStore) Get(kid string) (keys []jose.JSONWebKey) {
 	ks.RLock()
+	now := time.Now()
+	grace := ks.gracePeriod()
+	// Serve the expired keys during the grace period and refresh them in the
+	// background.
+	if grace > 0 && now.After(ks.expiry) && now.Before(ks.expiry.Add(grace)) {
+		keys = ks.keySet.Key(kid)
+		ks.RUnlock()
+		ks.refresh()
+		return
+	}
 	// Force reload if expiration has passed
-	if time.Now().After(ks.expiry) {
+	if now.After(ks.expiry) {
+		n := ks.reloads
 		ks.RUnlock()
-		ks.reload()
+		ks.reloadSince(n)
 		ks.RLock()
+		// Do not use the keys if the grace period has passed and the reload
+		// failed.
+		if grace = ks.gracePeriod(); grace > 0 && time.Now().After(ks.expiry.Add(grace)) {
+			ks.RUnlock()
+			return nil
+		}
 	}
 	keys = ks.keySet.Key(kid)
 	ks.RUnlock()
 	return
 }
 
+// gracePeriod returns the time the keys can be used after they expire, the
+// greatest of the configured one and the one sent by the server. It must be
+// called with the keyStore lock held.
+func (ks *keyStore) gracePeriod() time.Duration {
+	if ks.stale > ks.grace {
+		return ks.stale
+	}
+	return ks.grace
+}
+
+// refresh reloads the keys in the background if there is not another reload
+// in progress and the backoff of a failed reload has passed.
+func (ks *keyStore) refresh() {
+	ks.Lock()
+	defer ks.Unlock()
+	if ks.inflight == nil && !time.Now().Before(ks.retry) {
+		ks.inflight = make(chan struct{})
+		go ks.doReload()
+	}
+}
+
+// Err returns the error of the last reload, or nil if it succeeded.
+func (ks *keyStore) Err() error {
+	ks.RLock()
+	defer ks.RUnlock()
+	return ks.err
+}
+
+// reload reloads the keys, it's the function called by the timer.
 func (ks *keyStore) reload() {
-	var next time.Duration
-	keys, age, err := getKeysFromJWKsURI(ks.uri)
-	if err != nil {
-		next = ks.nextReloadDuration(ks.jitter / 2)
-	} else {
-		ks.Lock()
-		ks.keySet = keys
-		ks.expiry = getExpirationTime(age)
-		ks.jitter = getCacheJitter(age)
-		next = ks.nextReloadDuration(age)
+	ks.RLock()
+	n := ks.reloads
+	ks.RUnlock()
+	ks.reloadSince(n)
+}
+
+// reloadSince reloads the keys if they have not been reloaded since the reload
+// number n. Only one reload is done at a time, if there is one in progress,
+// reloadSince will wait for it and return.
+func (ks *keyStore) reloadSince(n uint64) {
+	ks.Lock()
+	if done := ks.inflight; done != nil {
+		ks.Unlock()
+		<-done
+		return
+	}
+	if ks.reloads != n {
 		ks.Unlock()
+		return
 	}
+	ks.inflight = make(chan struct{})
+	ks.Unlock()
+	ks.doReload()
+}
+
+// doReload fetches the keys and updates the keyStore. It must be called after
+// setting ks.inflight.
+func (ks *keyStore) doReload() {
+	var next time.Duration
+	ctx := ks.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	keys, policy, err := ks.fetchKeys(ctx)
 
 	ks.Lock()
-	ks.timer.Reset(next)
+	if err != nil {
+		next = ks.setError(err)
+	} else {
+		next = ks.setKeys(keys, policy)
+	}
+	if !ks.isClosed() {
+		ks.timer.Reset(next)
+	}
+	ks.reloads++
+	close(ks.inflight)
+	ks.inflight = nil
 	ks.Unlock()
 }
 
+// fetchKeys fetches the keys in the keyStore uri and validates their x5c
+// certificate chains.
+func (ks *keyStore) fetchKeys(ctx context.Context) (jose.JSONWebKeySet, cachePolicy, error) {
+	keys, policy, err := getKeysFromJWKsURI(ctx, ks.client, ks.uri)
+	if err != nil {
+		return keys, policy, err
+	}
+	if keys, err = ks.validateKeys(keys); err != nil {
+		return keys, cachePolicy{}, errors.Wrapf(err, "error validating %s", ks.uri)
+	}
+	return keys, policy, nil
+}
+
+// validateKeys returns the keys with a valid x5c certificate chain, see
+// validateKey. It returns an error if all the keys are invalid.
+func (ks *keyStore) validateKeys(keys jose.JSONWebKeySet) (jose.JSONWebKeySet, error) {
+	var err error
+	var valid jose.JSONWebKeySet
+	for _, key := range keys.Keys {
+		if err = ks.validateKey(key); err == nil {
+			valid.Keys = append(valid.Keys, key)
+		}
+	}
+	if len(valid.Keys) == 0 && err != nil {
+		return valid, err
+	}
+	return valid, nil
+}
+
+// validateKey validates the x5c certificate chain of the given key. The leaf
+// certificate must contain the same public key. If roots are configured, the
+// chain must be signed by one of them and keys without a chain are not valid.
+func (ks *keyStore) validateKey(key jose.JSONWebKey) error {
+	if len(key.Certificates) == 0 {
+		if ks.roots != nil {
+			return errors.Errorf("key %s does not have an x5c certificate chain", key.KeyID)
+		}
+		return nil
+	}
+
+	leaf := key.Certificates[0]
+	leafKey, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling x5c certificate of key %s", key.KeyID)
+	}
+	pubKey, err := x509.MarshalPKIXPublicKey(key.Public().Key)
+	if err != nil {
+		return errors.Wrapf(err, "error marshaling key %s", key.KeyID)
+	}
+	if !bytes.Equal(leafKey, pubKey) {
+		return errors.Errorf("key %s does not match its x5c certificate", key.KeyID)
+	}
+
+	if ks.roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, crt := range key.Certificates[1:] {
+			intermediates.AddCert(crt)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         ks.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return errors.Wrapf(err, "error verifying x5c certificate chain of key %s", key.KeyID)
+		}
+	}
+	return nil
+}
+
+// setKeys updates the keys after a successful reload and returns the duration
+// until the next one. It must be called with the keyStore lock held.
+func (ks *keyStore) setKeys(keys jose.JSONWebKeySet, policy cachePolicy) time.Duration {
+	age := ks.cacheAge(policy.Age)
+	ks.keySet = keys
+	ks.stale = policy.Stale
+	ks.expiry = getExpirationTime(age)
+	ks.jitter = getCacheJitter(age)
+	ks.lastReload = time.Now()
+	ks.successes++
+	ks.failures = 0
+	ks.err = nil
+	ks.retry = time.Time{}
+	// Do not persist the keys if the server does not allow it.
+	if !policy.NoStore {
+		ks.writeCache()
+	}
+	return ks.nextReloadDuration(age)
+}
+
+// cacheAge returns the given age bounded by the configured minimum and maximum
+// cache ages.
+func (ks *keyStore) cacheAge(age time.Duration) time.Duration {
+	switch {
+	case age < ks.minAge:
+		return ks.minAge
+	case ks.maxAge > 0 && age > ks.maxAge:
+		return ks.maxAge
+	default:
+		return age
+	}
+}
+
+// setError records a failed reload and returns the duration until the next
+// retry. It must be called with the keyStore lock held.
+func (ks *keyStore) setError(err error) time.Duration {
+	ks.failures++
+	ks.totalFailures++
+	ks.err = err
+	d := ks.nextRetryDuration()
+	ks.retry = time.Now().Add(d)
+	return d
+}
+
+// Stats returns the statistics of the keyStore.
+func (ks *keyStore) Stats() KeyStoreStats {
+	if ks == nil {
+		return KeyStoreStats{}
+	}
+	ks.RLock()
+	defer ks.RUnlock()
+	return KeyStoreStats{
+		URI:         ks.uri,
+		Keys:        len(ks.keySet.Keys),
+		Reloads:     ks.successes,
+		Failures:    ks.totalFailures,
+		LastReload:  ks.lastReload,
+		Expiry:      ks.expiry,
+		GracePeriod: ks.gracePeriod(),
+		Err:         ks.err,
+	}
+}
+
+// readCache reads the cache file if it is configured. It returns an error if
+// the file is not configured, cannot be read, or it was created for a
+// different uri.
+func (ks *keyStore) readCache() (keyStoreCache, error) {
+	var cache keyStoreCache
+	if ks.cacheFile == "" {
+		return cache, errors.New("keyStore cache is not configured")
+	}
+	b, err := ioutil.ReadFile(ks.cacheFile)
+	if err != nil {
+		return cache, errors.Wrapf(err, "error reading %s", ks.cacheFile)
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return keyStoreCache{}, errors.Wrapf(err, "error parsing %s", ks.cacheFile)
+	}
+	if cache.URI != ks.uri {
+		return keyStoreCache{}, errors.Errorf("error parsing %s: uri does not match", ks.cacheFile)
+	}
+	if cache.KeySet, err = ks.validateKeys(cache.KeySet); err != nil {
+		return keyStoreCache{}, errors.Wrapf(err, "error validating %s", ks.cacheFile)
+	}
+	return cache, nil
+}
+
+// writeCache writes the current keys to the cache file if it is configured.
+// The cache is a best effort, so errors writing the file are ignored. It must
+// be called with the keyStore lock held.
+func (ks *keyStore) writeCache() {
+	if ks.cacheFile == "" {
+		return
+	}
+	b, err := json.Marshal(keyStoreCache{
+		URI:    ks.uri,
+		Time:   ks.lastReload,
+		Expiry: ks.expiry,
+		KeySet: ks.keySet,
+	})
+	if err != nil {
+		return
+	}
+	// Write to a temporary file and rename it to avoid partial writes.
+	tmp := ks.cacheFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, ks.cacheFile); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// nextRetryDuration returns the duration to wait before retrying a failed
+// reload. It uses an exponential backoff from minReloadBackoff up to
+// maxReloadBackoff with a 10% jitter. Once the retries are exhausted it will
+// wait until the regular reload time, but Get will still force a reload if the
+// keys are expired.
+func (ks *keyStore) nextRetryDuration() time.Duration {
+	if ks.failures > maxReloadRetries {
+		return ks.nextReloadDuration(defaultCacheAge)
+	}
+	return getRetryDuration(ks.failures)
+}
+
 // nextReloadDuration would return the duration for the next rotation. If age is
 // 0 it will randomly rotate between 0-12 hours, but every time we call to Get
 // it will automatically rotate.
 func (ks *keyStore) nextReloadDuration(age time.Duration) time.Duration {
-	n := rand.Int63n(int64(ks.jitter))
+	return getReloadDuration(age, ks.jitter)
+}
+
+// getRetryDuration returns the exponential backoff after the given number of
+// consecutive failures, from minReloadBackoff up to maxReloadBackoff with a
+// 10% jitter.
+func getRetryDuration(failures int) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	d := minReloadBackoff << uint(failures-1)
+	if d <= 0 || d > maxReloadBackoff {
+		d = maxReloadBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d/10)+1))
+}
+
+// getReloadDuration returns the given age minus a random jitter.
+func getReloadDuration(age, jitter time.Duration) time.Duration {
+	n := rand.Int63n(int64(jitter))
 	age -= time.Duration(n)
 	return abs(age)
 }
 
-func getKeysFromJWKsURI(uri string) (jose.JSONWebKeySet, time.Duration, error) {
+// getKeysFromJWKsURI fetches the JWK set in the given uri, see readURI for the
+// supported uris.
+func getKeysFromJWKsURI(ctx context.Context, client *http.Client, uri string) (jose.JSONWebKeySet, cachePolicy, error) {
 	var keys jose.JSONWebKeySet
-	resp, err := http.Get(uri)
+	b, policy, err := readURI(ctx, client, uri)
 	if err != nil {
-		return keys, 0, errors.Wrapf(err, "failed to connect to %s", uri)
+		return keys, policy, err
+	}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return keys, cachePolicy{}, errors.Wrapf(err, "error reading %s", uri)
+	}
+	return keys, policy, nil
+}
+
+// readURI returns the content of the given uri and its cache policy. Besides
+// http and https uris, it supports file and data uris, so keys can be loaded in
+// air-gapped environments. For http requests it will use the given context and
+// client, if client is nil the http.DefaultClient will be used.
+func readURI(ctx context.Context, client *http.Client, uri string) ([]byte, cachePolicy, error) {
+	if len(uri) >= 5 && strings.EqualFold(uri[:5], "data:") {
+		b, err := parseDataURI(uri)
+		if err != nil {
+			return nil, cachePolicy{}, err
+		}
+		return b, cachePolicy{Age: defaultCacheAge}, nil
+	}
+	if name, ok := fileURIPath(uri); ok {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, cachePolicy{}, errors.Wrapf(err, "error reading %s", uri)
+		}
+		return b, cachePolicy{Age: defaultFileCacheAge}, nil
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, http.NoBody)
+	if err != nil {
+		return nil, cachePolicy{}, errors.Wrapf(err, "failed to connect to %s", uri)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, cachePolicy{}, errors.Wrapf(err, "failed to connect to %s", uri)
 	}
 	defer resp.Body.Close()
-	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
-		return keys, 0, errors.Wrapf(err, "error reading %s", uri)
-	}
-	return keys, getCacheAge(resp.Header.Get("cache-control")), nil
-}
-
-func getCacheAge(cacheControl string) time.Duration {
-	age := defaultCacheAge
-	if len(cacheControl) > 0 {
-		match := maxAgeRegex.FindAllStringSubmatch(cacheControl, -1)
-		if len(match) > 0 {
-			if len(match[0]) == 2 {
-				maxAge := match[0][1]
-				maxAgeInt, err := strconv.ParseInt(maxAge, 10, 64)
-				if err != nil {
-					return defaultCacheAge
-				}
-				age = time.Duration(maxAgeInt) * time.Second
+	if resp.StatusCode >= 400 {
+		return nil, cachePolicy{}, errors.Errorf("error reading %s: status=%d", uri, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, cachePolicy{}, errors.Wrapf(err, "error reading %s", uri)
+	}
+	return b, getCachePolicy(resp.Header.Get("cache-control")), nil
+}
+
+// fileURIPath returns the path in a file uri, e.g. file:///etc/step/jwks.json.
+// It returns false if the uri is not a file uri.
+func fileURIPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || !strings.EqualFold(u.Scheme, "file") {
+		return "", false
+	}
+	if u.Opaque != "" {
+		return u.Opaque, true
+	}
+	return u.Path, true
+}
+
+// parseDataURI returns the data in a data uri as defined in RFC 2397, e.g.
+// data:application/json;base64,eyJrZXlzIjpbXX0=
+func parseDataURI(uri string) ([]byte, error) {
+	i := strings.Index(uri, ",")
+	if i < 0 {
+		return nil, errors.New("error parsing data uri: missing comma")
+	}
+	mediaType, data := uri[5:i], uri[i+1:]
+	if strings.HasSuffix(strings.ToLower(mediaType), ";base64") {
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			if b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "=")); err != nil {
+				return nil, errors.Wrap(err, "error parsing data uri")
+			}
+		}
+		return b, nil
+	}
+	s, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing data uri")
+	}
+	return []byte(s), nil
+}
+
+// cachePolicy is the caching policy of a resource.
+//
+// Age is the time the resource is fresh, Stale is the time it can be used
+// after expiring while it's revalidated, and NoStore is set if the resource
+// must not be stored.
+type cachePolicy struct {
+	Age     time.Duration
+	Stale   time.Duration
+	NoStore bool
+}
+
+// getCachePolicy parses the given Cache-Control header as defined in RFC 7234
+// and RFC 5861. The s-maxage directive takes precedence over max-age, and the
+// no-cache and no-store directives will always force a revalidation. If the
+// header does not define an age, defaultCacheAge will be used.
+func getCachePolicy(cacheControl string) cachePolicy {
+	var maxAge, sMaxAge = time.Duration(-1), time.Duration(-1)
+	var noCache bool
+	var policy cachePolicy
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-cache":
+			noCache = true
+		case "no-store":
+			noCache = true
+			policy.NoStore = true
+		case "max-age":
+			maxAge = parseDeltaSeconds(value)
+		case "s-maxage":
+			sMaxAge = parseDeltaSeconds(value)
+		case "stale-while-revalidate":
+			if d := parseDeltaSeconds(value); d > 0 {
+				policy.Stale = d
 			}
 		}
 	}
-	return age
+
+	switch {
+	case noCache:
+		policy.Age = 0
+	case sMaxAge >= 0:
+		policy.Age = sMaxAge
+	case maxAge >= 0:
+		policy.Age = maxAge
+	default:
+		policy.Age = defaultCacheAge
+	}
+	return policy
+}
+
+// parseDeltaSeconds parses the given number of seconds in a Cache-Control
+// directive, it returns -1 if the value is not valid.
+func parseDeltaSeconds(s string) time.Duration {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return time.Duration(n) * time.Second
 }
 
 func getCacheJitter(age time.Duration) time.Duration {
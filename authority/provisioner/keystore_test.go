@@ -1,12 +1,31 @@
 package provisioner
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/cli/jose"
 )
@@ -14,7 +33,7 @@ import (
 func Test_newKeyStore(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
-	ks, err := newKeyStore(srv.URL)
+	ks, err := newKeyStore(context.Background(), nil, srv.URL, nil)
 	assert.FatalError(t, err)
 	defer ks.Close()
 
@@ -32,7 +51,7 @@ func Test_newKeyStore(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := newKeyStore(tt.args.uri)
+			got, err := newKeyStore(context.Background(), nil, tt.args.uri, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("newKeyStore() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -47,11 +66,172 @@ func Test_newKeyStore(t *testing.T) {
 	}
 }
 
+func Test_newKeyStore_timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timeout, timeoutCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer timeoutCancel()
+
+	type args struct {
+		ctx    context.Context
+		client *http.Client
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		{"fail client timeout", args{context.Background(), &http.Client{Timeout: 100 * time.Millisecond}}},
+		{"fail context timeout", args{timeout, nil}},
+		{"fail context cancelled", args{cancelled, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			if _, err := newKeyStore(tt.args.ctx, tt.args.client, srv.URL, nil); err == nil {
+				t.Error("newKeyStore() error = nil, wantErr true")
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("newKeyStore() took %s, it should have been cancelled", d)
+			}
+		})
+	}
+}
+
+func Test_getKeyStore(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	ks1, err := getKeyStore(context.Background(), nil, nil, srv.URL, nil)
+	assert.FatalError(t, err)
+	ks2, err := getKeyStore(context.Background(), nil, nil, srv.URL, nil)
+	assert.FatalError(t, err)
+	assert.True(t, ks1 == ks2)
+
+	// Different options use different key stores
+	ks3, err := getKeyStore(context.Background(), nil, nil, srv.URL, &KeyStoreOptions{GracePeriod: &Duration{time.Minute}})
+	assert.FatalError(t, err)
+	defer releaseKeyStore(ks3)
+	assert.True(t, ks1 != ks3)
+	ks4, err := getKeyStore(context.Background(), nil, &HTTPClientOptions{Timeout: &Duration{time.Minute}}, srv.URL, nil)
+	assert.FatalError(t, err)
+	defer releaseKeyStore(ks4)
+	assert.True(t, ks1 != ks4)
+
+	// Key stores are closed and removed when they are no longer used
+	releaseKeyStore(ks2)
+	assert.False(t, ks1.isClosed())
+	releaseKeyStore(ks1)
+	assert.True(t, ks1.isClosed())
+	keyStores.Lock()
+	_, ok := keyStores.m[ks1.key]
+	keyStores.Unlock()
+	assert.False(t, ok)
+	releaseKeyStore(nil)
+
+	// Closed key stores are replaced
+	ks5, err := getKeyStore(context.Background(), nil, nil, srv.URL, nil)
+	assert.FatalError(t, err)
+	ks5.Close()
+	ks6, err := getKeyStore(context.Background(), nil, nil, srv.URL, nil)
+	assert.FatalError(t, err)
+	assert.True(t, ks5 != ks6)
+	releaseKeyStore(ks5)
+	keyStores.Lock()
+	assert.True(t, keyStores.m[ks6.key] == ks6)
+	keyStores.Unlock()
+	releaseKeyStore(ks6)
+
+	// Errors are not cached
+	_, err = getKeyStore(context.Background(), nil, nil, srv.URL+"/error", nil)
+	assert.Error(t, err)
+	keyStores.Lock()
+	_, ok = keyStores.m[`["`+srv.URL+`/error",null,null]`]
+	keyStores.Unlock()
+	assert.False(t, ok)
+}
+
+func Test_getKeyStore_concurrent(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	keySet := must(generateJSONWebKeySet(1))[0].(jose.JSONWebKeySet)
+	b, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{keySet.Keys[0].Public()}})
+	assert.FatalError(t, err)
+	var hits int32
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	defer slow.Close()
+
+	// Concurrent provisioners with the same uri share a single fetch.
+	var wg sync.WaitGroup
+	stores := make([]*keyStore, 3)
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ks, err := getKeyStore(context.Background(), nil, nil, slow.URL, nil)
+			assert.FatalError(t, err)
+			stores[i] = ks
+		}(i)
+	}
+	for atomic.LoadInt32(&hits) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A slow uri does not block other key stores.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ks, err := getKeyStore(context.Background(), nil, nil, srv.URL, nil)
+		assert.FatalError(t, err)
+		releaseKeyStore(ks)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("getKeyStore() is blocked by a slow uri")
+	}
+
+	// Waiting provisioners honor their context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = getKeyStore(ctx, nil, nil, slow.URL, nil)
+	assert.Error(t, err)
+
+	close(release)
+	wg.Wait()
+	assert.Equals(t, int32(1), atomic.LoadInt32(&hits))
+	for _, ks := range stores {
+		assert.True(t, ks == stores[0])
+	}
+	assert.Equals(t, 3, stores[0].refs)
+	for _, ks := range stores {
+		releaseKeyStore(ks)
+	}
+	assert.True(t, stores[0].isClosed())
+	keyStores.Lock()
+	assert.Len(t, 0, keyStores.pending)
+	keyStores.Unlock()
+}
+
 func Test_keyStore(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
 
-	ks, err := newKeyStore(srv.URL + "/random")
+	ks, err := newKeyStore(context.Background(), nil, srv.URL+"/random", nil)
 	assert.FatalError(t, err)
 	defer ks.Close()
 	ks.RLock()
@@ -95,7 +275,7 @@ func Test_keyStore_noCache(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
 
-	ks, err := newKeyStore(srv.URL + "/no-cache")
+	ks, err := newKeyStore(context.Background(), nil, srv.URL+"/no-cache", nil)
 	assert.FatalError(t, err)
 	defer ks.Close()
 	ks.RLock()
@@ -134,10 +314,85 @@ func Test_keyStore_noCache(t *testing.T) {
 	assert.True(t, hits.Hits > 1, fmt.Sprintf("invalid number of hits: %d is not greater than 1", hits.Hits))
 }
 
+func Test_keyStore_minCacheAge(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	ks, err := newKeyStore(context.Background(), nil, srv.URL+"/no-cache", &KeyStoreOptions{
+		MinCacheAge: &Duration{time.Minute},
+	})
+	assert.FatalError(t, err)
+	defer ks.Close()
+	ks.RLock()
+	keySet := ks.keySet
+	ks.RUnlock()
+
+	// The keys won't rotate on Get
+	assert.Len(t, 2, keySet.Keys)
+	for i := 0; i < 5; i++ {
+		assert.Len(t, 1, ks.Get(keySet.Keys[0].KeyID))
+		assert.Len(t, 1, ks.Get(keySet.Keys[1].KeyID))
+	}
+	assert.True(t, ks.expiry.After(time.Now().Add(50*time.Second)))
+}
+
+func Test_getCachePolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		want         cachePolicy
+	}{
+		{"empty", "", cachePolicy{Age: defaultCacheAge}},
+		{"max-age", "public, max-age=3600", cachePolicy{Age: time.Hour}},
+		{"max-age zero", "max-age=0", cachePolicy{Age: 0}},
+		{"max-age quoted", `max-age="60"`, cachePolicy{Age: time.Minute}},
+		{"max-age invalid", "max-age=foo", cachePolicy{Age: defaultCacheAge}},
+		{"max-age negative", "max-age=-1", cachePolicy{Age: defaultCacheAge}},
+		{"s-maxage", "max-age=60, s-maxage=3600", cachePolicy{Age: time.Hour}},
+		{"s-maxage first", "S-MAXAGE=3600,MAX-AGE=60", cachePolicy{Age: time.Hour}},
+		{"no-cache", "no-cache, max-age=3600", cachePolicy{Age: 0}},
+		{"no-store", "no-store, max-age=3600", cachePolicy{Age: 0, NoStore: true}},
+		{"no-cache no-store", "no-cache, no-store, max-age=0, must-revalidate", cachePolicy{Age: 0, NoStore: true}},
+		{"stale-while-revalidate", "max-age=60, stale-while-revalidate=600", cachePolicy{Age: time.Minute, Stale: 10 * time.Minute}},
+		{"stale-while-revalidate invalid", "max-age=60, stale-while-revalidate=foo", cachePolicy{Age: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getCachePolicy(tt.cacheControl); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCachePolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyStore_cacheAge(t *testing.T) {
+	tests := []struct {
+		name   string
+		minAge time.Duration
+		maxAge time.Duration
+		age    time.Duration
+		want   time.Duration
+	}{
+		{"ok", 0, 0, time.Hour, time.Hour},
+		{"ok zero", 0, 0, 0, 0},
+		{"min", time.Minute, 0, 0, time.Minute},
+		{"max", 0, time.Hour, 12 * time.Hour, time.Hour},
+		{"between", time.Minute, time.Hour, 10 * time.Minute, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := &keyStore{minAge: tt.minAge, maxAge: tt.maxAge}
+			if got := ks.cacheAge(tt.age); got != tt.want {
+				t.Errorf("keyStore.cacheAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_keyStore_Get(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
-	ks, err := newKeyStore(srv.URL)
+	ks, err := newKeyStore(context.Background(), nil, srv.URL, nil)
 	assert.FatalError(t, err)
 	defer ks.Close()
 
@@ -163,6 +418,498 @@ func Test_keyStore_Get(t *testing.T) {
 	}
 }
 
+func Test_keyStore_reloadError(t *testing.T) {
+	var fail bool
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, srv.URL, http.StatusFound)
+	}))
+	defer proxy.Close()
+
+	ks, err := newKeyStore(context.Background(), nil, proxy.URL, nil)
+	assert.FatalError(t, err)
+	defer ks.Close()
+	keySet := ks.keySet
+	assert.NoError(t, ks.Err())
+
+	// Failed reloads keep the keys
+	fail = true
+	ks.reload()
+	assert.Error(t, ks.Err())
+	assert.Equals(t, 1, ks.failures)
+	assert.Equals(t, keySet, ks.keySet)
+	ks.reload()
+	assert.Error(t, ks.Err())
+	assert.Equals(t, 2, ks.failures)
+	assert.Equals(t, keySet, ks.keySet)
+
+	// Successful reloads clear the error
+	fail = false
+	ks.reload()
+	assert.NoError(t, ks.Err())
+	assert.Equals(t, 0, ks.failures)
+}
+
+func Test_keyStore_concurrentReload(t *testing.T) {
+	var hits int32
+	keySet := must(generateJSONWebKeySet(2))[0].(jose.JSONWebKeySet)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Add("Cache-Control", "max-age=3600")
+		json.NewEncoder(w).Encode(keySet)
+	}))
+	defer srv.Close()
+
+	ks, err := newKeyStore(context.Background(), nil, srv.URL, nil)
+	assert.FatalError(t, err)
+	defer ks.Close()
+	assert.Equals(t, int32(1), atomic.LoadInt32(&hits))
+
+	// Force a reload on Get
+	ks.Lock()
+	ks.expiry = time.Now().Add(-time.Minute)
+	ks.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Len(t, 1, ks.Get(keySet.Keys[0].KeyID))
+		}()
+	}
+	wg.Wait()
+	assert.Equals(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func Test_keyStore_gracePeriod(t *testing.T) {
+	var fail, hits int32
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, srv.URL, http.StatusFound)
+	}))
+	defer proxy.Close()
+
+	ks, err := newKeyStore(context.Background(), nil, proxy.URL, &KeyStoreOptions{
+		GracePeriod: &Duration{time.Hour},
+	})
+	assert.FatalError(t, err)
+	defer ks.Close()
+	kid := ks.keySet.Keys[0].KeyID
+
+	isRefreshing := func() bool {
+		ks.RLock()
+		defer ks.RUnlock()
+		return ks.inflight != nil
+	}
+
+	// Expired keys are served during the grace period
+	atomic.StoreInt32(&fail, 1)
+	ks.Lock()
+	ks.expiry = time.Now().Add(-time.Minute)
+	ks.Unlock()
+	assert.Len(t, 1, ks.Get(kid))
+	for i := 0; i < 100 && isRefreshing(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, isRefreshing())
+	assert.Error(t, ks.Err())
+	assert.Len(t, 1, ks.Get(kid))
+
+	// Failed refreshes are not retried until the backoff has passed
+	n := atomic.LoadInt32(&hits)
+	for i := 0; i < 10; i++ {
+		assert.Len(t, 1, ks.Get(kid))
+	}
+	assert.False(t, isRefreshing())
+	assert.Equals(t, n, atomic.LoadInt32(&hits))
+	ks.Lock()
+	ks.retry = time.Now().Add(-time.Second)
+	ks.Unlock()
+	assert.Len(t, 1, ks.Get(kid))
+	for i := 0; i < 100 && isRefreshing(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equals(t, n+1, atomic.LoadInt32(&hits))
+
+	// No keys after the grace period
+	ks.Lock()
+	ks.expiry = time.Now().Add(-2 * time.Hour)
+	ks.Unlock()
+	assert.Len(t, 0, ks.Get(kid))
+
+	// Keys are available after a successful reload
+	atomic.StoreInt32(&fail, 0)
+	assert.Len(t, 1, ks.Get(kid))
+	assert.NoError(t, ks.Err())
+}
+
+func Test_keyStore_cacheFile(t *testing.T) {
+	var fail int32
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, srv.URL, http.StatusFound)
+	}))
+	defer proxy.Close()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "keystore")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "jwks.json")
+	opts := &KeyStoreOptions{CacheFile: cacheFile}
+
+	// The keys are written on creation
+	ks, err := newKeyStore(context.Background(), nil, proxy.URL, opts)
+	assert.FatalError(t, err)
+	ks.Close()
+	keySet := ks.keySet
+	cache, err := ks.readCache()
+	assert.FatalError(t, err)
+	assert.Equals(t, proxy.URL, cache.URI)
+	assert.Equals(t, ks.expiry.Unix(), cache.Expiry.Unix())
+	assert.Equals(t, 2, len(cache.KeySet.Keys))
+
+	// Unexpired cached keys are used without fetching them
+	atomic.StoreInt32(&fail, 1)
+	ks, err = newKeyStore(context.Background(), nil, proxy.URL, opts)
+	assert.FatalError(t, err)
+	ks.Close()
+	assert.NoError(t, ks.Err())
+	assert.Len(t, 1, ks.keySet.Key(keySet.Keys[0].KeyID))
+	assert.Len(t, 1, ks.keySet.Key(keySet.Keys[1].KeyID))
+
+	// Expired cached keys are used if the keys cannot be fetched
+	cache.Expiry = time.Now().Add(-time.Hour)
+	b, err := json.Marshal(cache)
+	assert.FatalError(t, err)
+	assert.FatalError(t, ioutil.WriteFile(cacheFile, b, 0600))
+	ks, err = newKeyStore(context.Background(), nil, proxy.URL, opts)
+	assert.FatalError(t, err)
+	ks.Close()
+	assert.Error(t, ks.Err())
+	assert.Equals(t, 1, ks.failures)
+	assert.Len(t, 1, ks.keySet.Key(keySet.Keys[0].KeyID))
+
+	// Cached keys from a different uri are not used
+	cache.URI = srv.URL + "/random"
+	b, err = json.Marshal(cache)
+	assert.FatalError(t, err)
+	assert.FatalError(t, ioutil.WriteFile(cacheFile, b, 0600))
+	_, err = newKeyStore(context.Background(), nil, proxy.URL, opts)
+	assert.Error(t, err)
+
+	// Fails without a cache
+	_, err = newKeyStore(context.Background(), nil, proxy.URL, &KeyStoreOptions{CacheFile: filepath.Join(dir, "missing.json")})
+	assert.Error(t, err)
+}
+
+func Test_keyStore_fileURI(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "keystore")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "jwks.json")
+	writeKeys := func(n int) jose.JSONWebKeySet {
+		keySet, err := generateJSONWebKeySet(n)
+		assert.FatalError(t, err)
+		b, err := json.Marshal(keySet)
+		assert.FatalError(t, err)
+		tmp := filepath.Join(dir, "jwks.json.tmp")
+		assert.FatalError(t, ioutil.WriteFile(tmp, b, 0600))
+		assert.FatalError(t, os.Rename(tmp, name))
+		return keySet
+	}
+
+	keySet := writeKeys(2)
+	ks, err := newKeyStore(context.Background(), nil, "file://"+name, nil)
+	assert.FatalError(t, err)
+	defer ks.Close()
+	assert.Len(t, 1, ks.Get(keySet.Keys[0].KeyID))
+	assert.Len(t, 1, ks.Get(keySet.Keys[1].KeyID))
+	assert.True(t, ks.expiry.Before(time.Now().Add(defaultFileCacheAge+time.Minute)))
+
+	// Keys are reloaded when the file changes
+	keySet = writeKeys(1)
+	for i := 0; i < 100; i++ {
+		if len(ks.Get(keySet.Keys[0].KeyID)) == 1 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Len(t, 1, ks.Get(keySet.Keys[0].KeyID))
+
+	// Fails with missing files
+	_, err = newKeyStore(context.Background(), nil, "file://"+filepath.Join(dir, "missing.json"), nil)
+	assert.Error(t, err)
+}
+
+func Test_keyStore_dataURI(t *testing.T) {
+	keySet, err := generateJSONWebKeySet(2)
+	assert.FatalError(t, err)
+	b, err := json.Marshal(keySet)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{"ok", "data:," + string(b), false},
+		{"ok escaped", "data:application/json," + url.PathEscape(string(b)), false},
+		{"ok base64", "data:application/json;base64," + base64.StdEncoding.EncodeToString(b), false},
+		{"ok base64url", "data:application/json;base64," + base64.RawURLEncoding.EncodeToString(b), false},
+		{"fail comma", "data:application/json", true},
+		{"fail base64", "data:application/json;base64,%%%", true},
+		{"fail json", "data:,{not-json}", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := newKeyStore(context.Background(), nil, tt.uri, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newKeyStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer ks.Close()
+				assert.Len(t, 1, ks.Get(keySet.Keys[0].KeyID))
+				assert.Len(t, 1, ks.Get(keySet.Keys[1].KeyID))
+			}
+		})
+	}
+}
+
+func generateX5CKey(t *testing.T, parent *x509.Certificate, parentKey crypto.Signer, isCA bool) (*jose.JSONWebKey, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "x5c"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	return &jose.JSONWebKey{
+		Key:          key.Public(),
+		KeyID:        fmt.Sprintf("%x", crt.SerialNumber),
+		Algorithm:    "ES256",
+		Use:          "sig",
+		Certificates: []*x509.Certificate{crt},
+	}, key
+}
+
+func Test_keyStore_x5c(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "keystore")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Root and intermediate
+	rootJWK, rootKey := generateX5CKey(t, nil, nil, true)
+	root := rootJWK.Certificates[0]
+	interJWK, interKey := generateX5CKey(t, root, rootKey, true)
+	inter := interJWK.Certificates[0]
+	rootsFile := filepath.Join(dir, "roots.crt")
+	assert.FatalError(t, ioutil.WriteFile(rootsFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0600))
+
+	// Keys signed by the root and the intermediate
+	signed, _ := generateX5CKey(t, root, rootKey, false)
+	chained, _ := generateX5CKey(t, inter, interKey, false)
+	chained.Certificates = append(chained.Certificates, inter)
+	// A self-signed key
+	selfSigned, _ := generateX5CKey(t, nil, nil, false)
+	// A key that does not match its certificate
+	mismatch, _ := generateX5CKey(t, root, rootKey, false)
+	other, _ := generateX5CKey(t, root, rootKey, false)
+	mismatch.Key = other.Key
+	// A key without x5c
+	noX5C, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	noX5CPublic := noX5C.Public()
+
+	dataURI := func(keys ...*jose.JSONWebKey) string {
+		var keySet jose.JSONWebKeySet
+		for _, k := range keys {
+			keySet.Keys = append(keySet.Keys, *k)
+		}
+		b, err := json.Marshal(keySet)
+		assert.FatalError(t, err)
+		return "data:application/json;base64," + base64.StdEncoding.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name     string
+		uri      string
+		opts     *KeyStoreOptions
+		wantKeys []*jose.JSONWebKey
+		wantErr  bool
+	}{
+		{"ok no roots", dataURI(signed, selfSigned, mismatch, &noX5CPublic), nil, []*jose.JSONWebKey{signed, selfSigned, &noX5CPublic}, false},
+		{"ok roots", dataURI(signed, chained, selfSigned, mismatch, &noX5CPublic), &KeyStoreOptions{X5CRoots: rootsFile}, []*jose.JSONWebKey{signed, chained}, false},
+		{"ok empty", dataURI(), &KeyStoreOptions{X5CRoots: rootsFile}, nil, false},
+		{"fail mismatch", dataURI(mismatch), nil, nil, true},
+		{"fail roots", dataURI(selfSigned, &noX5CPublic), &KeyStoreOptions{X5CRoots: rootsFile}, nil, true},
+		{"fail rootsFile", dataURI(signed), &KeyStoreOptions{X5CRoots: filepath.Join(dir, "missing.crt")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := newKeyStore(context.Background(), nil, tt.uri, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newKeyStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer ks.Close()
+				assert.Len(t, len(tt.wantKeys), ks.keySet.Keys)
+				for _, k := range tt.wantKeys {
+					assert.Len(t, 1, ks.Get(k.KeyID))
+				}
+			}
+		})
+	}
+}
+
+func Test_keyStore_Stats(t *testing.T) {
+	var fail int32
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, srv.URL, http.StatusFound)
+	}))
+	defer proxy.Close()
+
+	var nilStore *keyStore
+	assert.Equals(t, KeyStoreStats{}, nilStore.Stats())
+
+	ks, err := newKeyStore(context.Background(), nil, proxy.URL, nil)
+	assert.FatalError(t, err)
+	defer ks.Close()
+
+	stats := ks.Stats()
+	assert.Equals(t, proxy.URL, stats.URI)
+	assert.Equals(t, 2, stats.Keys)
+	assert.Equals(t, uint64(1), stats.Reloads)
+	assert.Equals(t, uint64(0), stats.Failures)
+	assert.Equals(t, ks.expiry, stats.Expiry)
+	assert.False(t, stats.LastReload.IsZero())
+	assert.NoError(t, stats.Err)
+
+	atomic.StoreInt32(&fail, 1)
+	ks.reload()
+	ks.reload()
+	stats2 := ks.Stats()
+	assert.Equals(t, uint64(1), stats2.Reloads)
+	assert.Equals(t, uint64(2), stats2.Failures)
+	assert.Equals(t, stats.LastReload, stats2.LastReload)
+	assert.Error(t, stats2.Err)
+
+	atomic.StoreInt32(&fail, 0)
+	ks.reload()
+	stats3 := ks.Stats()
+	assert.Equals(t, uint64(2), stats3.Reloads)
+	assert.Equals(t, uint64(2), stats3.Failures)
+	assert.NoError(t, stats3.Err)
+}
+
+func TestKeyStoreStats_Status(t *testing.T) {
+	now := time.Now()
+	err := errors.New("an error")
+	tests := []struct {
+		name  string
+		stats KeyStoreStats
+		want  KeyStoreStatus
+	}{
+		{"fresh", KeyStoreStats{Keys: 2, Expiry: now.Add(time.Hour)}, KeyStoreFresh},
+		{"stale expired", KeyStoreStats{Keys: 2, Expiry: now.Add(-time.Hour)}, KeyStoreStale},
+		{"stale error", KeyStoreStats{Keys: 2, Expiry: now.Add(time.Hour), Err: err}, KeyStoreStale},
+		{"stale error no grace", KeyStoreStats{Keys: 2, Expiry: now.Add(-time.Hour), Err: err}, KeyStoreStale},
+		{"stale error in grace", KeyStoreStats{Keys: 2, Expiry: now.Add(-time.Minute), GracePeriod: time.Hour, Err: err}, KeyStoreStale},
+		{"failed error after grace", KeyStoreStats{Keys: 2, Expiry: now.Add(-time.Hour), GracePeriod: time.Minute, Err: err}, KeyStoreFailed},
+		{"failed no keys", KeyStoreStats{Expiry: now.Add(time.Hour), Err: err}, KeyStoreFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.Status(now); got != tt.want {
+				t.Errorf("KeyStoreStats.Status() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyStoreOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *KeyStoreOptions
+		wantErr bool
+	}{
+		{"ok nil", nil, false},
+		{"ok empty", &KeyStoreOptions{}, false},
+		{"ok gracePeriod", &KeyStoreOptions{GracePeriod: &Duration{time.Hour}}, false},
+		{"fail gracePeriod", &KeyStoreOptions{GracePeriod: &Duration{-time.Hour}}, true},
+		{"ok cacheAge", &KeyStoreOptions{MinCacheAge: &Duration{time.Minute}, MaxCacheAge: &Duration{time.Hour}}, false},
+		{"ok minCacheAge", &KeyStoreOptions{MinCacheAge: &Duration{time.Minute}}, false},
+		{"fail minCacheAge", &KeyStoreOptions{MinCacheAge: &Duration{-time.Minute}}, true},
+		{"fail maxCacheAge", &KeyStoreOptions{MaxCacheAge: &Duration{-time.Minute}}, true},
+		{"fail cacheAge", &KeyStoreOptions{MinCacheAge: &Duration{time.Hour}, MaxCacheAge: &Duration{time.Minute}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("KeyStoreOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_keyStore_nextRetryDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		min, max time.Duration
+	}{
+		{"first", 1, minReloadBackoff, minReloadBackoff * 11 / 10},
+		{"second", 2, 2 * minReloadBackoff, 2 * minReloadBackoff * 11 / 10},
+		{"third", 3, 4 * minReloadBackoff, 4 * minReloadBackoff * 11 / 10},
+		{"max", maxReloadRetries, maxReloadBackoff, maxReloadBackoff * 11 / 10},
+		{"exhausted", maxReloadRetries + 1, defaultCacheAge - defaultCacheJitter, defaultCacheAge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := &keyStore{jitter: defaultCacheJitter, failures: tt.failures}
+			for i := 0; i < 10; i++ {
+				if got := ks.nextRetryDuration(); got < tt.min || got > tt.max {
+					t.Errorf("keyStore.nextRetryDuration() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
 func Test_abs(t *testing.T) {
 	maxInt64 := time.Duration(1<<63 - 1)
 	minInt64 := time.Duration(-1 << 63)
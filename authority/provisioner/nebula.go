@@ -0,0 +1,720 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+)
+
+const (
+	// nebulaCertificateHeader is the header of the token that contains the
+	// Nebula certificate.
+	nebulaCertificateHeader = "nebula"
+	// nebulaCertificatePEMType is the PEM type of the Nebula certificates.
+	nebulaCertificatePEMType = "NEBULA CERTIFICATE"
+	// nebulaXEdDSA is the algorithm of the tokens signed with a X25519 key.
+	nebulaXEdDSA = "XEdDSA"
+)
+
+// Nebula curves, as defined in the cert.proto file of Nebula.
+const (
+	nebulaCurve25519 = 0
+	nebulaCurveP256  = 1
+)
+
+// nebulaPayload extends jwt.Claims with step attributes.
+type nebulaPayload struct {
+	jose.Claims
+	SANs []string     `json:"sans,omitempty"`
+	Step *stepPayload `json:"step,omitempty"`
+	cert *nebulaCertificate
+}
+
+// nebulaCertificate is a Nebula certificate, the protobuf encoded
+// RawNebulaCertificate message.
+type nebulaCertificate struct {
+	Name        string
+	IPs         []*net.IPNet
+	Subnets     []*net.IPNet
+	Groups      []string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	PublicKey   []byte
+	IsCA        bool
+	Issuer      string
+	Curve       uint64
+	Signature   []byte
+	Fingerprint string
+	details     []byte
+}
+
+// protobufReader reads the protobuf wire format used by the Nebula
+// certificates.
+type protobufReader struct {
+	b []byte
+}
+
+func (r *protobufReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errors.New("invalid varint")
+	}
+	r.b = r.b[n:]
+	return v, nil
+}
+
+func (r *protobufReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.b)) < n {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+// field reads the next field, and returns its number, wire type and, for
+// length-delimited fields, its value.
+func (r *protobufReader) field() (num, typ uint64, v uint64, b []byte, err error) {
+	key, err := r.varint()
+	if err != nil {
+		return
+	}
+	num, typ = key>>3, key&7
+	switch typ {
+	case 0:
+		v, err = r.varint()
+	case 2:
+		b, err = r.bytes()
+	default:
+		err = errors.Errorf("unsupported wire type %d", typ)
+	}
+	return
+}
+
+// protobufUint32s appends the values of a repeated uint32 field, packed or not.
+func protobufUint32s(typ, v uint64, b []byte, values []uint32) ([]uint32, error) {
+	if typ == 0 {
+		return append(values, uint32(v)), nil
+	}
+	packed := &protobufReader{b}
+	for len(packed.b) > 0 {
+		v, err := packed.varint()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, uint32(v))
+	}
+	return values, nil
+}
+
+// parseNebulaCertificate parses a protobuf encoded Nebula certificate.
+func parseNebulaCertificate(b []byte) (*nebulaCertificate, error) {
+	var err error
+	var details []byte
+	cert := new(nebulaCertificate)
+	r := &protobufReader{b}
+	for len(r.b) > 0 {
+		num, typ, _, value, err := r.field()
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing nebula certificate")
+		}
+		switch {
+		case num == 1 && typ == 2:
+			details = value
+		case num == 2 && typ == 2:
+			cert.Signature = value
+		}
+	}
+	if details == nil {
+		return nil, errors.New("error parsing nebula certificate: certificate does not contain details")
+	}
+
+	var ips, subnets []uint32
+	r = &protobufReader{details}
+	for len(r.b) > 0 {
+		num, typ, v, value, err := r.field()
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing nebula certificate")
+		}
+		switch num {
+		case 1:
+			cert.Name = string(value)
+		case 2:
+			ips, err = protobufUint32s(typ, v, value, ips)
+		case 3:
+			subnets, err = protobufUint32s(typ, v, value, subnets)
+		case 4:
+			cert.Groups = append(cert.Groups, string(value))
+		case 5:
+			cert.NotBefore = time.Unix(int64(v), 0)
+		case 6:
+			cert.NotAfter = time.Unix(int64(v), 0)
+		case 7:
+			cert.PublicKey = value
+		case 8:
+			cert.IsCA = v != 0
+		case 9:
+			cert.Issuer = hex.EncodeToString(value)
+		case 100:
+			cert.Curve = v
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing nebula certificate")
+		}
+	}
+	if cert.IPs, err = nebulaIPNets(ips); err != nil {
+		return nil, errors.Wrap(err, "error parsing nebula certificate ips")
+	}
+	if cert.Subnets, err = nebulaIPNets(subnets); err != nil {
+		return nil, errors.Wrap(err, "error parsing nebula certificate subnets")
+	}
+
+	sum := sha256.Sum256(b)
+	cert.Fingerprint = hex.EncodeToString(sum[:])
+	cert.details = details
+	return cert, nil
+}
+
+// nebulaIPNets converts the pairs of address and mask in a Nebula certificate
+// to IP networks.
+func nebulaIPNets(values []uint32) ([]*net.IPNet, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("invalid number of values")
+	}
+	var nets []*net.IPNet
+	for i := 0; i < len(values); i += 2 {
+		ip, mask := make(net.IP, 4), make(net.IPMask, 4)
+		binary.BigEndian.PutUint32(ip, values[i])
+		binary.BigEndian.PutUint32(mask, values[i+1])
+		nets = append(nets, &net.IPNet{IP: ip, Mask: mask})
+	}
+	return nets, nil
+}
+
+// parseNebulaCertificatePEM parses all the Nebula certificates in the given
+// PEM data.
+func parseNebulaCertificatePEM(data []byte) ([]*nebulaCertificate, error) {
+	var certs []*nebulaCertificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != nebulaCertificatePEMType {
+			return nil, errors.Errorf("error parsing nebula certificate from PEM block: unexpected type %s", block.Type)
+		}
+		cert, err := parseNebulaCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// checkSignature verifies that the certificate is signed by the given CA.
+func (c *nebulaCertificate) checkSignature(ca *nebulaCertificate) error {
+	if c.Curve != ca.Curve {
+		return errors.New("nebula certificate curve does not match the issuer curve")
+	}
+	switch ca.Curve {
+	case nebulaCurve25519:
+		if len(ca.PublicKey) != ed25519.PublicKeySize {
+			return errors.New("nebula ca certificate contains an invalid ed25519 key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(ca.PublicKey), c.details, c.Signature) {
+			return errors.New("nebula certificate signature is not valid")
+		}
+		return nil
+	case nebulaCurveP256:
+		pub, err := nebulaP256PublicKey(ca.PublicKey)
+		if err != nil {
+			return err
+		}
+		var sig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(c.Signature, &sig); err != nil || len(rest) != 0 {
+			return errors.New("nebula certificate signature is not valid")
+		}
+		sum := sha256.Sum256(c.details)
+		if !ecdsa.Verify(pub, sum[:], sig.R, sig.S) {
+			return errors.New("nebula certificate signature is not valid")
+		}
+		return nil
+	default:
+		return errors.Errorf("nebula certificate curve %d is not supported", ca.Curve)
+	}
+}
+
+// checkConstraints verifies that the certificate is valid at the given time,
+// and that it complies with the validity, groups, ips and subnets of the CA.
+func (c *nebulaCertificate) checkConstraints(ca *nebulaCertificate, now time.Time) error {
+	switch {
+	case now.Before(c.NotBefore) || now.After(c.NotAfter):
+		return errors.New("nebula certificate is expired or not yet valid")
+	case now.Before(ca.NotBefore) || now.After(ca.NotAfter):
+		return errors.New("nebula ca certificate is expired or not yet valid")
+	case c.NotBefore.Before(ca.NotBefore):
+		return errors.New("nebula certificate is valid before the issuer")
+	case c.NotAfter.After(ca.NotAfter):
+		return errors.New("nebula certificate expires after the issuer")
+	}
+	if len(ca.Groups) > 0 && !containsAllMembers(ca.Groups, c.Groups) {
+		return errors.Errorf("nebula certificate groups %v are not allowed by the issuer", c.Groups)
+	}
+	if len(ca.IPs) > 0 {
+		for _, ip := range c.IPs {
+			if !nebulaNetsContain(ca.IPs, ip) {
+				return errors.Errorf("nebula certificate ip %s is not allowed by the issuer", ip)
+			}
+		}
+	}
+	if len(ca.Subnets) > 0 {
+		for _, subnet := range c.Subnets {
+			if !nebulaNetsContain(ca.Subnets, subnet) {
+				return errors.Errorf("nebula certificate subnet %s is not allowed by the issuer", subnet)
+			}
+		}
+	}
+	return nil
+}
+
+// nebulaNetsContain returns true if one of the networks contains the given
+// network.
+func nebulaNetsContain(nets []*net.IPNet, n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	for _, c := range nets {
+		cones, _ := c.Mask.Size()
+		if cones <= ones && c.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// nebulaP256PublicKey parses an uncompressed P-256 public key.
+func nebulaP256PublicKey(b []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), b)
+	if x == nil {
+		return nil, errors.New("nebula certificate contains an invalid P-256 key")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// nebulaEdwardsPublicKey converts a X25519 public key to the Ed25519 public
+// key used to verify XEdDSA signatures. The sign bit of the Edwards point is
+// always zero, see https://signal.org/docs/specifications/xeddsa/.
+func nebulaEdwardsPublicKey(u []byte) (ed25519.PublicKey, error) {
+	if len(u) != 32 {
+		return nil, errors.New("nebula certificate contains an invalid X25519 key")
+	}
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	// The key is little-endian, and the most significant bit is ignored.
+	le := make([]byte, 32)
+	for i := range u {
+		le[31-i] = u[i]
+	}
+	le[0] &= 0x7f
+	x := new(big.Int).SetBytes(le)
+	x.Mod(x, p)
+
+	// y = (u - 1) / (u + 1)
+	den := new(big.Int).Add(x, big.NewInt(1))
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return nil, errors.New("nebula certificate contains an invalid X25519 key")
+	}
+	y := new(big.Int).Sub(x, big.NewInt(1))
+	y.Mul(y, den.ModInverse(den, p))
+	y.Mod(y, p)
+
+	be := y.Bytes()
+	pub := make([]byte, ed25519.PublicKeySize)
+	for i := range be {
+		pub[i] = be[len(be)-1-i]
+	}
+	return pub, nil
+}
+
+// verifyXEdDSA verifies a compact JWS signed with XEdDSA using the given
+// X25519 public key.
+func verifyXEdDSA(token string, key []byte) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("error parsing token: invalid compact serialization")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.Wrap(err, "error parsing token signature")
+	}
+	pub, err := nebulaEdwardsPublicKey(key)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return errors.New("error verifying token: invalid XEdDSA signature")
+	}
+	return nil
+}
+
+// Nebula is the provisioner that grants certificates to the hosts of a Nebula
+// overlay network. The tokens are signed with the private key of a Nebula
+// certificate, the certificate is sent in the nebula header of the token, and
+// it must be signed by one of the Nebula CAs in Roots.
+//
+// The name of the Nebula certificate is used as the common name and DNS name,
+// and its IPs as the IP addresses of the X.509 certificates. SSH host
+// certificates can use the name and IPs as principals, and SSH user
+// certificates the name and the groups.
+//
+// If Groups is set, the Nebula certificate must contain at least one of them.
+type Nebula struct {
+	*base
+	Type      string              `json:"type"`
+	Name      string              `json:"name"`
+	Roots     []byte              `json:"roots"`
+	Groups    []string            `json:"groups,omitempty"`
+	Claims    *Claims             `json:"claims,omitempty"`
+	Options   *CertificateOptions `json:"options,omitempty"`
+	claimer   *Claimer
+	audiences Audiences
+	caPool    map[string]*nebulaCertificate
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *Nebula) GetID() string {
+	return "nebula/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *Nebula) GetTokenID(ott string) (string, error) {
+	token, err := jose.ParseSigned(ott)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+
+	// Get claims w/out verification.
+	var claims jose.Claims
+	if err = token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	return claims.ID, nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *Nebula) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *Nebula) GetType() Type {
+	return TypeNebula
+}
+
+// GetEncryptedKey is not available in a Nebula provisioner.
+func (p *Nebula) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// Init initializes and validates the fields of a Nebula type.
+func (p *Nebula) Init(config Config) error {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case len(p.Roots) == 0:
+		return errors.New("provisioner root(s) cannot be empty")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	roots, err := parseNebulaCertificatePEM(p.Roots)
+	if err != nil {
+		return err
+	}
+	p.caPool = make(map[string]*nebulaCertificate)
+	for _, ca := range roots {
+		if !ca.IsCA {
+			return errors.Errorf("nebula certificate %s in roots attribute is not a CA", ca.Name)
+		}
+		if err := ca.checkSignature(ca); err != nil {
+			return errors.Wrapf(err, "error verifying nebula ca certificate %s", ca.Name)
+		}
+		p.caPool[ca.Fingerprint] = ca
+	}
+
+	// Verify that at least one root was found.
+	if len(p.caPool) == 0 {
+		return errors.Errorf("no nebula certificates found in roots attribute for provisioner %s", p.GetName())
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+
+	p.audiences = config.Audiences.WithFragment(p.GetID())
+	return nil
+}
+
+// verifyCertificate parses and verifies the Nebula certificate in the nebula
+// header of a token.
+func (p *Nebula) verifyCertificate(header interface{}) (*nebulaCertificate, error) {
+	s, ok := header.(string)
+	if !ok {
+		return nil, errors.New("token missing nebula header")
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "error base64 decoding nebula header")
+	}
+	cert, err := parseNebulaCertificate(b)
+	if err != nil {
+		return nil, err
+	}
+	if cert.IsCA {
+		return nil, errors.New("nebula certificate cannot be a CA")
+	}
+	ca, ok := p.caPool[cert.Issuer]
+	if !ok {
+		return nil, errors.New("nebula certificate is not signed by a trusted CA")
+	}
+	if err := cert.checkSignature(ca); err != nil {
+		return nil, err
+	}
+	if err := cert.checkConstraints(ca, time.Now()); err != nil {
+		return nil, err
+	}
+	if len(p.Groups) > 0 {
+		var found bool
+		for _, g := range cert.Groups {
+			for _, allowed := range p.Groups {
+				if g == allowed {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("nebula certificate groups %v are not allowed", cert.Groups)
+		}
+	}
+	return cert, nil
+}
+
+// authorizeToken performs common jwt authorization actions and returns the
+// claims for case specific downstream parsing.
+// e.g. a Sign request will auth/validate different fields than a Revoke request.
+func (p *Nebula) authorizeToken(token string, audiences []string) (*nebulaPayload, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "nebula.authorizeToken; error parsing nebula token")
+	}
+	if len(jwt.Headers) == 0 {
+		return nil, errs.Unauthorized("nebula.authorizeToken; nebula token missing header")
+	}
+
+	cert, err := p.verifyCertificate(jwt.Headers[0].ExtraHeaders[nebulaCertificateHeader])
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "nebula.authorizeToken; error verifying nebula certificate")
+	}
+
+	// The token must be signed with the key of the Nebula certificate, X25519
+	// keys sign with XEdDSA, and P-256 keys with ES256.
+	var claims nebulaPayload
+	switch {
+	case cert.Curve == nebulaCurve25519 && jwt.Headers[0].Algorithm == nebulaXEdDSA:
+		if err = verifyXEdDSA(token, cert.PublicKey); err == nil {
+			err = jwt.UnsafeClaimsWithoutVerification(&claims)
+		}
+	case cert.Curve == nebulaCurveP256 && jwt.Headers[0].Algorithm == "ES256":
+		var pub *ecdsa.PublicKey
+		if pub, err = nebulaP256PublicKey(cert.PublicKey); err == nil {
+			err = jwt.Claims(pub, &claims)
+		}
+	default:
+		err = errors.Errorf("unsupported algorithm %s", jwt.Headers[0].Algorithm)
+	}
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "nebula.authorizeToken; error parsing nebula claims")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = claims.ValidateWithLeeway(jose.Expected{
+		Issuer: p.Name,
+		Time:   time.Now().UTC(),
+	}, p.claimer.ClockSkew()); err != nil {
+		return nil, errs.Wrapf(http.StatusUnauthorized, err, "nebula.authorizeToken; invalid nebula claims")
+	}
+
+	// validate audiences with the defaults
+	if !matchesAudience(claims.Audience, audiences) {
+		return nil, errs.Unauthorized("nebula.authorizeToken; nebula token has invalid audience "+
+			"claim (aud); expected %s, but got %s", audiences, claims.Audience)
+	}
+
+	if claims.Subject != cert.Name {
+		return nil, errs.Unauthorized("nebula.authorizeToken; nebula token subject must be the nebula certificate name")
+	}
+
+	claims.cert = cert
+	return &claims, nil
+}
+
+// AuthorizeRevoke returns an error if the provisioner does not have rights to
+// revoke the certificate with serial number in the `sub` property.
+func (p *Nebula) AuthorizeRevoke(ctx context.Context, token string) error {
+	_, err := p.authorizeToken(token, p.audiences.Revoke)
+	return errs.Wrap(http.StatusInternalServerError, err, "nebula.AuthorizeRevoke")
+}
+
+// AuthorizeSign validates the given token and returns the sign options that
+// will be used on certificate creation.
+func (p *Nebula) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("nebula.AuthorizeSign; issuance is disabled for nebula provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "nebula.AuthorizeSign")
+	}
+
+	// The name and IPs of the Nebula certificate are the only valid SANs.
+	cert := claims.cert
+	sans := []string{cert.Name}
+	for _, ip := range cert.IPs {
+		sans = append(sans, ip.IP.String())
+	}
+	if len(claims.SANs) == 0 {
+		claims.SANs = sans
+	} else if !containsAllMembers(sans, claims.SANs) {
+		return nil, errs.Unauthorized("nebula.AuthorizeSign; nebula token sans %v are not in the nebula certificate", claims.SANs)
+	}
+
+	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
+	return withX509CertificateOptions(p.Options, []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeNebula, p.Name, "", "Fingerprint", cert.Fingerprint,
+			"Groups", strings.Join(cert.Groups, ",")),
+		profileLimitDuration{p.claimer.DefaultTLSCertDuration(), cert.NotAfter},
+		backdateOption(p.claimer.Backdate()),
+		// validators
+		commonNameValidator(cert.Name),
+		defaultPublicKeyValidator{},
+		dnsNamesValidator(dnsNames),
+		emailAddressesValidator(emails),
+		ipAddressesValidator(ips),
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(p.claimer),
+	}, newTemplateData(token, cert.Name, claims.SANs)), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *Nebula) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("nebula.AuthorizeRenew; renew is disabled for nebula provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "nebula.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "nebula.AuthorizeRenew")
+	}
+	return nil
+}
+
+// AuthorizeSSHSign returns the list of SignOption for a SignSSH request. Host
+// certificates are the default, the valid principals are the name and IPs of
+// the Nebula certificate, and for user certificates the name and groups.
+func (p *Nebula) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
+	if !p.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("nebula.AuthorizeSSHSign; sshCA is disabled for nebula provisioner %s", p.GetID())
+	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("nebula.AuthorizeSSHSign; issuance is disabled for nebula provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+
+	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "nebula.AuthorizeSSHSign")
+	}
+
+	cert := claims.cert
+	certType := SSHHostCert
+	if claims.Step != nil && claims.Step.SSH != nil && claims.Step.SSH.CertType != "" {
+		certType = claims.Step.SSH.CertType
+	}
+	principals := []string{cert.Name}
+	switch certType {
+	case SSHHostCert:
+		for _, ip := range cert.IPs {
+			principals = append(principals, ip.IP.String())
+		}
+	case SSHUserCert:
+		principals = append(principals, cert.Groups...)
+	default:
+		return nil, errs.Unauthorized("nebula.AuthorizeSSHSign; ssh certificate type %s is not valid", certType)
+	}
+
+	defaults := SSHOptions{
+		CertType:   certType,
+		Principals: principals,
+	}
+	if claims.Step != nil && claims.Step.SSH != nil && len(claims.Step.SSH.Principals) > 0 {
+		if !containsAllMembers(principals, claims.Step.SSH.Principals) {
+			return nil, errs.Unauthorized("nebula.AuthorizeSSHSign; nebula token principals %v are not valid", claims.Step.SSH.Principals)
+		}
+		defaults.Principals = claims.Step.SSH.Principals
+	}
+
+	return withSSHCertificateOptions(p.Options, []SignOption{
+		// set the key id to the nebula certificate name
+		sshCertKeyIDModifier(cert.Name),
+		// Validate user options
+		sshCertOptionsValidator(defaults),
+		// Set defaults if not given as user options
+		sshCertDefaultsModifier(defaults),
+		// Set the default extensions.
+		&sshDefaultExtensionModifier{},
+		// Checks the validity bounds, and set the validity if has not been set.
+		&sshLimitDuration{p.claimer, cert.NotAfter},
+		backdateOption(p.claimer.Backdate()),
+		// Validate public key.
+		&sshDefaultPublicKeyValidator{},
+		// Validate the validity period.
+		&sshCertValidityValidator{p.claimer},
+		// Require all the fields in the SSH certificate
+		&sshCertDefaultValidator{},
+	}, newTemplateData(token, cert.Name, defaults.Principals)), nil
+}
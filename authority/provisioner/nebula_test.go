@@ -0,0 +1,645 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+// nebulaTestDetails are the details of a Nebula certificate used in the tests.
+type nebulaTestDetails struct {
+	Name      string
+	IPs       []string
+	Groups    []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	PublicKey []byte
+	IsCA      bool
+	Issuer    string
+	Curve     uint64
+}
+
+// protobufWriter writes the protobuf wire format.
+type protobufWriter []byte
+
+func (w *protobufWriter) varint(num, v uint64) {
+	*w = appendUvarint(appendUvarint(*w, num<<3), v)
+}
+
+func (w *protobufWriter) bytes(num uint64, b []byte) {
+	*w = append(appendUvarint(appendUvarint(*w, num<<3|2), uint64(len(b))), b...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+func (d *nebulaTestDetails) marshal() []byte {
+	var w protobufWriter
+	w.bytes(1, []byte(d.Name))
+	var packed protobufWriter
+	for _, s := range d.IPs {
+		ip, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		packed = appendUvarint(packed, uint64(binary.BigEndian.Uint32(ip.To4())))
+		packed = appendUvarint(packed, uint64(binary.BigEndian.Uint32(ipnet.Mask)))
+	}
+	if len(packed) > 0 {
+		w.bytes(2, packed)
+	}
+	for _, g := range d.Groups {
+		w.bytes(4, []byte(g))
+	}
+	w.varint(5, uint64(d.NotBefore.Unix()))
+	w.varint(6, uint64(d.NotAfter.Unix()))
+	w.bytes(7, d.PublicKey)
+	if d.IsCA {
+		w.varint(8, 1)
+	}
+	if d.Issuer != "" {
+		b, err := hex.DecodeString(d.Issuer)
+		if err != nil {
+			panic(err)
+		}
+		w.bytes(9, b)
+	}
+	if d.Curve != 0 {
+		w.varint(100, d.Curve)
+	}
+	return w
+}
+
+// nebulaTestSign signs the details with the CA key and returns the encoded
+// certificate.
+func nebulaTestSign(d *nebulaTestDetails, key crypto.Signer) ([]byte, error) {
+	details := d.marshal()
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, details)
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256(details)
+		if sig, err = k.Sign(rand.Reader, sum[:], crypto.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	var w protobufWriter
+	w.bytes(1, details)
+	w.bytes(2, sig)
+	return w, nil
+}
+
+// nebulaTestCA is a Nebula CA used in the tests.
+type nebulaTestCA struct {
+	key         crypto.Signer
+	cert        []byte
+	fingerprint string
+	curve       uint64
+}
+
+func newNebulaTestCA(curve uint64, groups []string, ips []string) (*nebulaTestCA, error) {
+	var key crypto.Signer
+	var pub []byte
+	switch curve {
+	case nebulaCurve25519:
+		p, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key, pub = k, p
+	default:
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key, pub = k, elliptic.Marshal(elliptic.P256(), k.X, k.Y)
+	}
+	cert, err := nebulaTestSign(&nebulaTestDetails{
+		Name:      "Nebula CA",
+		IPs:       ips,
+		Groups:    groups,
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+		PublicKey: pub,
+		IsCA:      true,
+		Curve:     curve,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(cert)
+	return &nebulaTestCA{key: key, cert: cert, fingerprint: hex.EncodeToString(sum[:]), curve: curve}, nil
+}
+
+func (ca *nebulaTestCA) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: nebulaCertificatePEMType, Bytes: ca.cert})
+}
+
+// nebulaTestHost is Nebula host with a certificate and the key used to sign
+// the tokens. X25519 hosts use an Ed25519 key with a public key with the sign
+// bit set to zero, its XEdDSA signatures are Ed25519 signatures.
+type nebulaTestHost struct {
+	cert []byte
+	key  crypto.Signer
+}
+
+// nebulaTestX25519Key returns an Ed25519 key and the X25519 public key of its
+// Montgomery form.
+func nebulaTestX25519Key() (ed25519.PrivateKey, []byte, error) {
+	for {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pub[31]&0x80 != 0 {
+			continue
+		}
+		// u = (1 + y) / (1 - y)
+		p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+		be := make([]byte, 32)
+		for i := range pub {
+			be[31-i] = pub[i]
+		}
+		y := new(big.Int).SetBytes(be)
+		den := new(big.Int).Sub(big.NewInt(1), y)
+		den.Mod(den, p)
+		u := new(big.Int).Add(big.NewInt(1), y)
+		u.Mul(u, den.ModInverse(den, p))
+		u.Mod(u, p)
+		be = u.Bytes()
+		le := make([]byte, 32)
+		for i := range be {
+			le[i] = be[len(be)-1-i]
+		}
+		return priv, le, nil
+	}
+}
+
+func (ca *nebulaTestCA) newHost(name string, ips, groups []string, notAfter time.Time) (*nebulaTestHost, error) {
+	var key crypto.Signer
+	var pub []byte
+	switch ca.curve {
+	case nebulaCurve25519:
+		k, u, err := nebulaTestX25519Key()
+		if err != nil {
+			return nil, err
+		}
+		key, pub = k, u
+	default:
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key, pub = k, elliptic.Marshal(elliptic.P256(), k.X, k.Y)
+	}
+	cert, err := nebulaTestSign(&nebulaTestDetails{
+		Name:      name,
+		IPs:       ips,
+		Groups:    groups,
+		NotBefore: time.Now().Add(-time.Minute),
+		NotAfter:  notAfter,
+		PublicKey: pub,
+		Issuer:    ca.fingerprint,
+		Curve:     ca.curve,
+	}, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return &nebulaTestHost{cert: cert, key: key}, nil
+}
+
+func generateNebula(roots ...*nebulaTestCA) (*Nebula, error) {
+	var b []byte
+	for _, ca := range roots {
+		b = append(b, ca.pem()...)
+	}
+	p := &Nebula{
+		Type:  "Nebula",
+		Name:  "nebula",
+		Roots: b,
+	}
+	return p, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences})
+}
+
+func generateNebulaToken(sub, iss, aud string, sans []string, step *stepPayload, iat time.Time, h *nebulaTestHost) (string, error) {
+	claims := nebulaPayload{
+		Claims: jose.Claims{
+			ID:        "the-jti",
+			Subject:   sub,
+			Issuer:    iss,
+			IssuedAt:  jose.NewNumericDate(iat),
+			NotBefore: jose.NewNumericDate(iat),
+			Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+		},
+		SANs: sans,
+		Step: step,
+	}
+	if k, ok := h.key.(*ecdsa.PrivateKey); ok {
+		sig, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.ES256, Key: k},
+			new(jose.SignerOptions).WithType("JWT").WithHeader(nebulaCertificateHeader, h.cert),
+		)
+		if err != nil {
+			return "", err
+		}
+		return jose.Signed(sig).Claims(claims).CompactSerialize()
+	}
+
+	header, err := json.Marshal(map[string]interface{}{
+		"alg":                   nebulaXEdDSA,
+		"typ":                   "JWT",
+		nebulaCertificateHeader: h.cert,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(h.key.(ed25519.PrivateKey), []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func TestNebula_Getters(t *testing.T) {
+	p := &Nebula{Type: "Nebula", Name: "nebula"}
+	assert.Equals(t, "nebula/nebula", p.GetID())
+	assert.Equals(t, "nebula", p.GetName())
+	assert.Equals(t, TypeNebula, p.GetType())
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("Nebula.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+}
+
+func TestNebula_Init(t *testing.T) {
+	ca, err := newNebulaTestCA(nebulaCurve25519, nil, nil)
+	assert.FatalError(t, err)
+	host, err := ca.newHost("host", nil, nil, time.Now().Add(time.Hour))
+	assert.FatalError(t, err)
+
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+	badClaims := &Claims{
+		DefaultTLSDur: &Duration{0},
+	}
+	hostPEM := pem.EncodeToMemory(&pem.Block{Type: nebulaCertificatePEMType, Bytes: host.cert})
+	tests := []struct {
+		name    string
+		p       *Nebula
+		wantErr bool
+	}{
+		{"ok", &Nebula{Type: "Nebula", Name: "nebula", Roots: ca.pem()}, false},
+		{"fail type", &Nebula{Type: "", Name: "nebula", Roots: ca.pem()}, true},
+		{"fail name", &Nebula{Type: "Nebula", Name: "", Roots: ca.pem()}, true},
+		{"fail roots", &Nebula{Type: "Nebula", Name: "nebula"}, true},
+		{"fail no roots", &Nebula{Type: "Nebula", Name: "nebula", Roots: []byte("foo")}, true},
+		{"fail pem type", &Nebula{Type: "Nebula", Name: "nebula", Roots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert})}, true},
+		{"fail bad roots", &Nebula{Type: "Nebula", Name: "nebula", Roots: pem.EncodeToMemory(&pem.Block{Type: nebulaCertificatePEMType, Bytes: []byte("foo")})}, true},
+		{"fail not ca", &Nebula{Type: "Nebula", Name: "nebula", Roots: hostPEM}, true},
+		{"fail claims", &Nebula{Type: "Nebula", Name: "nebula", Roots: ca.pem(), Claims: badClaims}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Nebula.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, config.Audiences.WithFragment(tt.p.GetID()), tt.p.audiences)
+			}
+		})
+	}
+}
+
+func Test_parseNebulaCertificate(t *testing.T) {
+	ca, err := newNebulaTestCA(nebulaCurveP256, []string{"servers", "web"}, []string{"10.1.0.0/16"})
+	assert.FatalError(t, err)
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	host, err := ca.newHost("host.nebula", []string{"10.1.2.3/16"}, []string{"servers", "web"}, notAfter)
+	assert.FatalError(t, err)
+
+	cert, err := parseNebulaCertificate(host.cert)
+	assert.FatalError(t, err)
+	assert.Equals(t, "host.nebula", cert.Name)
+	assert.Equals(t, []string{"servers", "web"}, cert.Groups)
+	assert.Len(t, 1, cert.IPs)
+	assert.Equals(t, "10.1.2.3/16", cert.IPs[0].String())
+	assert.Equals(t, notAfter, cert.NotAfter)
+	assert.Equals(t, ca.fingerprint, cert.Issuer)
+	assert.Equals(t, uint64(nebulaCurveP256), cert.Curve)
+	assert.False(t, cert.IsCA)
+
+	caCert, err := parseNebulaCertificate(ca.cert)
+	assert.FatalError(t, err)
+	assert.True(t, caCert.IsCA)
+	assert.Equals(t, ca.fingerprint, caCert.Fingerprint)
+	assert.NoError(t, cert.checkSignature(caCert))
+	assert.NoError(t, cert.checkConstraints(caCert, time.Now()))
+	assert.Error(t, cert.checkConstraints(caCert, time.Now().Add(2*time.Hour)))
+
+	_, err = parseNebulaCertificate([]byte("foo"))
+	assert.Error(t, err)
+	_, err = parseNebulaCertificate(nil)
+	assert.Error(t, err)
+}
+
+func Test_nebulaCertificate_checkConstraints(t *testing.T) {
+	ca, err := newNebulaTestCA(nebulaCurve25519, []string{"servers"}, []string{"10.1.0.0/16"})
+	assert.FatalError(t, err)
+	caCert, err := parseNebulaCertificate(ca.cert)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name     string
+		ips      []string
+		groups   []string
+		notAfter time.Time
+		wantErr  bool
+	}{
+		{"ok", []string{"10.1.2.3/16"}, []string{"servers"}, time.Now().Add(time.Hour), false},
+		{"fail group", []string{"10.1.2.3/16"}, []string{"servers", "admins"}, time.Now().Add(time.Hour), true},
+		{"fail ip", []string{"10.2.2.3/16"}, []string{"servers"}, time.Now().Add(time.Hour), true},
+		{"fail network", []string{"10.1.2.3/8"}, []string{"servers"}, time.Now().Add(time.Hour), true},
+		{"fail not after", []string{"10.1.2.3/16"}, []string{"servers"}, time.Now().Add(48 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := ca.newHost("host", tt.ips, tt.groups, tt.notAfter)
+			assert.FatalError(t, err)
+			cert, err := parseNebulaCertificate(host.cert)
+			assert.FatalError(t, err)
+			assert.NoError(t, cert.checkSignature(caCert))
+			if err := cert.checkConstraints(caCert, time.Now()); (err != nil) != tt.wantErr {
+				t.Errorf("nebulaCertificate.checkConstraints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_nebulaEdwardsPublicKey(t *testing.T) {
+	priv, u, err := nebulaTestX25519Key()
+	assert.FatalError(t, err)
+	pub, err := nebulaEdwardsPublicKey(u)
+	assert.FatalError(t, err)
+	assert.Equals(t, priv.Public(), pub)
+
+	_, err = nebulaEdwardsPublicKey([]byte("foo"))
+	assert.Error(t, err)
+	// u = -1 is not a valid point
+	minusOne := make([]byte, 32)
+	for i := range minusOne {
+		minusOne[i] = 0xff
+	}
+	minusOne[0], minusOne[31] = 0xec, 0x7f
+	_, err = nebulaEdwardsPublicKey(minusOne)
+	assert.Error(t, err)
+}
+
+func TestNebula_AuthorizeSign(t *testing.T) {
+	ca25519, err := newNebulaTestCA(nebulaCurve25519, nil, nil)
+	assert.FatalError(t, err)
+	caP256, err := newNebulaTestCA(nebulaCurveP256, nil, nil)
+	assert.FatalError(t, err)
+	other, err := newNebulaTestCA(nebulaCurve25519, nil, nil)
+	assert.FatalError(t, err)
+
+	p1, err := generateNebula(ca25519, caP256)
+	assert.FatalError(t, err)
+	p2, err := generateNebula(ca25519)
+	assert.FatalError(t, err)
+	p2.Groups = []string{"servers"}
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+
+	notAfter := time.Now().Add(time.Hour)
+	h1, err := ca25519.newHost("host.nebula", []string{"10.1.2.3/16"}, []string{"servers"}, notAfter)
+	assert.FatalError(t, err)
+	h2, err := caP256.newHost("host.nebula", []string{"10.1.2.3/16"}, []string{"web"}, notAfter)
+	assert.FatalError(t, err)
+	h3, err := other.newHost("host.nebula", []string{"10.1.2.3/16"}, nil, notAfter)
+	assert.FatalError(t, err)
+	expired, err := ca25519.newHost("host.nebula", []string{"10.1.2.3/16"}, nil, time.Now().Add(-time.Second))
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	t1, err := generateNebulaToken("host.nebula", "nebula", aud, nil, nil, now, h1)
+	assert.FatalError(t, err)
+	t2, err := generateNebulaToken("host.nebula", "nebula", aud, []string{"10.1.2.3"}, nil, now, h2)
+	assert.FatalError(t, err)
+	failSANs, err := generateNebulaToken("host.nebula", "nebula", aud, []string{"other.nebula"}, nil, now, h1)
+	assert.FatalError(t, err)
+	failCA, err := generateNebulaToken("host.nebula", "nebula", aud, nil, nil, now, h3)
+	assert.FatalError(t, err)
+	failExpired, err := generateNebulaToken("host.nebula", "nebula", aud, nil, nil, now, expired)
+	assert.FatalError(t, err)
+	// Token signed with a different key
+	failKey, err := generateNebulaToken("host.nebula", "nebula", aud, nil, nil, now, &nebulaTestHost{cert: h1.cert, key: h3.key})
+	assert.FatalError(t, err)
+	failIss, err := generateNebulaToken("host.nebula", "foo", aud, nil, nil, now, h1)
+	assert.FatalError(t, err)
+	failAud, err := generateNebulaToken("host.nebula", "nebula", "https://ca.smallstep.com/1.0/sign#nebula/foo", nil, nil, now, h1)
+	assert.FatalError(t, err)
+	failExp, err := generateNebulaToken("host.nebula", "nebula", aud, nil, nil, now.Add(-360*time.Second), h1)
+	assert.FatalError(t, err)
+	failSub, err := generateNebulaToken("other.nebula", "nebula", aud, nil, nil, now, h1)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		p       *Nebula
+		token   string
+		wantDNS []string
+		wantErr bool
+	}{
+		{"ok x25519", p1, t1, []string{"host.nebula"}, false},
+		{"ok p256", p1, t2, nil, false},
+		{"ok groups", p2, t1, []string{"host.nebula"}, false},
+		{"fail groups", p2, t2, nil, true},
+		{"fail token", p1, "foo", nil, true},
+		{"fail sans", p1, failSANs, nil, true},
+		{"fail ca", p1, failCA, nil, true},
+		{"fail expired", p1, failExpired, nil, true},
+		{"fail key", p1, failKey, nil, true},
+		{"fail iss", p1, failIss, nil, true},
+		{"fail aud", p1, failAud, nil, true},
+		{"fail exp", p1, failExp, nil, true},
+		{"fail sub", p1, failSub, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Nebula.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				assert.Nil(t, got)
+				return
+			}
+			assert.Len(t, 11, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case commonNameValidator:
+					assert.Equals(t, "host.nebula", string(v))
+				case profileLimitDuration:
+					assert.Equals(t, notAfter.Unix(), v.notAfter.Unix())
+				case backdateOption:
+				case dnsNamesValidator:
+					assert.NoError(t, v.Valid(&x509.CertificateRequest{DNSNames: tt.wantDNS}))
+				case ipAddressesValidator:
+					assert.Equals(t, []net.IP{net.ParseIP("10.1.2.3")}, []net.IP(v))
+				case *provisionerExtensionOption:
+					assert.Equals(t, TypeNebula, Type(v.Type))
+				}
+			}
+		})
+	}
+}
+
+func TestNebula_AuthorizeSSHSign(t *testing.T) {
+	ca, err := newNebulaTestCA(nebulaCurve25519, nil, nil)
+	assert.FatalError(t, err)
+	p1, err := generateNebula(ca)
+	assert.FatalError(t, err)
+	p2, err := generateNebula(ca)
+	assert.FatalError(t, err)
+	p2.claimer, err = NewClaimer(&Claims{EnableSSHCA: new(bool)}, globalProvisionerClaims)
+	assert.FatalError(t, err)
+	aud := "https://ca.smallstep.com/1.0/ssh/sign#" + p1.GetID()
+
+	h1, err := ca.newHost("host.nebula", []string{"10.1.2.3/16"}, []string{"admins"}, time.Now().Add(time.Hour))
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	tHost, err := generateNebulaToken("host.nebula", "nebula", aud, nil, nil, now, h1)
+	assert.FatalError(t, err)
+	tUser, err := generateNebulaToken("host.nebula", "nebula", aud, nil, &stepPayload{SSH: &SSHOptions{
+		CertType:   SSHUserCert,
+		Principals: []string{"admins"},
+	}}, now, h1)
+	assert.FatalError(t, err)
+	failPrincipals, err := generateNebulaToken("host.nebula", "nebula", aud, nil, &stepPayload{SSH: &SSHOptions{
+		CertType:   SSHUserCert,
+		Principals: []string{"root"},
+	}}, now, h1)
+	assert.FatalError(t, err)
+	failType, err := generateNebulaToken("host.nebula", "nebula", aud, nil, &stepPayload{SSH: &SSHOptions{
+		CertType: "foo",
+	}}, now, h1)
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+
+	tests := []struct {
+		name    string
+		p       *Nebula
+		token   string
+		opts    SSHOptions
+		want    *ssh.Certificate
+		wantErr bool
+	}{
+		{"ok host", p1, tHost, SSHOptions{}, &ssh.Certificate{CertType: ssh.HostCert, KeyId: "host.nebula", ValidPrincipals: []string{"host.nebula", "10.1.2.3"}}, false},
+		{"ok host principals", p1, tHost, SSHOptions{Principals: []string{"10.1.2.3"}}, &ssh.Certificate{CertType: ssh.HostCert, KeyId: "host.nebula", ValidPrincipals: []string{"10.1.2.3"}}, false},
+		{"ok user", p1, tUser, SSHOptions{}, &ssh.Certificate{CertType: ssh.UserCert, KeyId: "host.nebula", ValidPrincipals: []string{"admins"}}, false},
+		{"fail host principals", p1, tHost, SSHOptions{Principals: []string{"admins"}}, nil, true},
+		{"fail user type", p1, tUser, SSHOptions{CertType: SSHHostCert}, nil, true},
+		{"fail token principals", p1, failPrincipals, SSHOptions{}, nil, true},
+		{"fail type", p1, failType, SSHOptions{}, nil, true},
+		{"fail disabled", p2, tHost, SSHOptions{}, nil, true},
+		{"fail token", p1, "foo", SSHOptions{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cert *ssh.Certificate
+			got, err := tt.p.AuthorizeSSHSign(context.Background(), tt.token)
+			if err == nil {
+				cert, err = signSSHCertificate(pub, tt.opts, got, signer.Key.(crypto.Signer))
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Nebula.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.want.CertType, cert.CertType)
+				assert.Equals(t, tt.want.KeyId, cert.KeyId)
+				assert.Equals(t, tt.want.ValidPrincipals, cert.ValidPrincipals)
+			}
+		})
+	}
+}
+
+func TestNebula_AuthorizeRevoke(t *testing.T) {
+	ca, err := newNebulaTestCA(nebulaCurve25519, nil, nil)
+	assert.FatalError(t, err)
+	p1, err := generateNebula(ca)
+	assert.FatalError(t, err)
+	h1, err := ca.newHost("host.nebula", nil, nil, time.Now().Add(time.Hour))
+	assert.FatalError(t, err)
+
+	t1, err := generateNebulaToken("host.nebula", "nebula", "https://ca.smallstep.com/1.0/revoke#nebula/nebula", nil, nil, time.Now(), h1)
+	assert.FatalError(t, err)
+	failAud, err := generateNebulaToken("host.nebula", "nebula", "https://ca.smallstep.com/1.0/sign#nebula/nebula", nil, nil, time.Now(), h1)
+	assert.FatalError(t, err)
+
+	assert.NoError(t, p1.AuthorizeRevoke(context.Background(), t1))
+	err = p1.AuthorizeRevoke(context.Background(), failAud)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+	}
+}
+
+func TestNebula_GetTokenID(t *testing.T) {
+	ca, err := newNebulaTestCA(nebulaCurve25519, nil, nil)
+	assert.FatalError(t, err)
+	h1, err := ca.newHost("host.nebula", nil, nil, time.Now().Add(time.Hour))
+	assert.FatalError(t, err)
+	t1, err := generateNebulaToken("host.nebula", "nebula", "aud", nil, nil, time.Now(), h1)
+	assert.FatalError(t, err)
+
+	p := &Nebula{}
+	id, err := p.GetTokenID(t1)
+	assert.FatalError(t, err)
+	assert.Equals(t, "the-jti", id)
+	_, err = p.GetTokenID("foo")
+	assert.Error(t, err)
+}
@@ -49,8 +49,8 @@ func (p *noop) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	return []SignOption{}, nil
 }
 
-func (p *noop) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, error) {
-	return nil, nil
+func (p *noop) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, []SignOption, error) {
+	return nil, []SignOption{}, nil
 }
 
 func (p *noop) AuthorizeSSHRevoke(ctx context.Context, token string) error {
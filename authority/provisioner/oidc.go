@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,11 +17,16 @@ import (
 	"github.com/smallstep/cli/jose"
 )
 
+// minDiscoveryAge is the minimum time between OpenID discoveries.
+const minDiscoveryAge = 5 * time.Minute
+
 // openIDConfiguration contains the necessary properties in the
 // `/.well-known/openid-configuration` document.
 type openIDConfiguration struct {
-	Issuer    string `json:"issuer"`
-	JWKSetURI string `json:"jwks_uri"`
+	Issuer                      string `json:"issuer"`
+	JWKSetURI                   string `json:"jwks_uri"`
+	TokenEndpoint               string `json:"token_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
 }
 
 // Validate validates the values in a well-known OpenID configuration endpoint.
@@ -45,6 +51,58 @@ type openIDPayload struct {
 	Hd              string   `json:"hd"`
 	Nonce           string   `json:"nonce"`
 	Groups          []string `json:"groups"`
+	// ObjectID, TenantID, HasGroups and ClaimNames are Azure AD specific
+	// claims, the last two indicate a group overage.
+	ObjectID   string            `json:"oid"`
+	TenantID   string            `json:"tid"`
+	HasGroups  bool              `json:"hasgroups"`
+	ClaimNames map[string]string `json:"_claim_names"`
+	groups     []oidcGroup
+	raw        map[string]interface{}
+	policy     *oidcPolicy
+}
+
+// hasGroupsOverage returns true if the groups of the user are not in the
+// token because there are too many of them.
+func (p *openIDPayload) hasGroupsOverage() bool {
+	_, ok := p.ClaimNames["groups"]
+	return ok || p.HasGroups
+}
+
+// hasGroup returns true if any of the groups in the token, or the groups
+// read from the groups provider, match by id or name any of the given groups.
+func (p *openIDPayload) hasGroup(groups []string) bool {
+	for _, group := range groups {
+		for _, g := range p.Groups {
+			if g == group {
+				return true
+			}
+		}
+		for _, g := range p.groups {
+			if g.ID == group || g.Name == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupNames returns the names of the groups in the token and the ones read
+// from the groups provider.
+func (p *openIDPayload) groupNames() []string {
+	names := append([]string{}, p.Groups...)
+	for _, g := range p.groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+// newOIDCTemplateData returns the template data of an OIDC token, the groups
+// of the user are available in .Groups.
+func newOIDCTemplateData(token string, p *openIDPayload, sans []string) TemplateData {
+	data := newTemplateData(token, p.Email, sans)
+	data["Groups"] = p.groupNames()
+	return data
 }
 
 // OIDC represents an OAuth 2.0 OpenID Connect provider.
@@ -52,27 +110,67 @@ type openIDPayload struct {
 // ClientSecret is mandatory, but it can be an empty string.
 type OIDC struct {
 	*base
-	Type                  string   `json:"type"`
-	Name                  string   `json:"name"`
-	ClientID              string   `json:"clientID"`
-	ClientSecret          string   `json:"clientSecret"`
-	ConfigurationEndpoint string   `json:"configurationEndpoint"`
-	Admins                []string `json:"admins,omitempty"`
-	Domains               []string `json:"domains,omitempty"`
-	Groups                []string `json:"groups,omitempty"`
-	ListenAddress         string   `json:"listenAddress,omitempty"`
-	Claims                *Claims  `json:"claims,omitempty"`
+	Type                  string                   `json:"type"`
+	Name                  string                   `json:"name"`
+	ClientID              string                   `json:"clientID"`
+	Audiences             []*OIDCAudience          `json:"audiences,omitempty"`
+	ClientSecret          string                   `json:"clientSecret"`
+	ConfigurationEndpoint string                   `json:"configurationEndpoint"`
+	Admins                []string                 `json:"admins,omitempty"`
+	AdminGroups           []string                 `json:"adminGroups,omitempty"`
+	Domains               []string                 `json:"domains,omitempty"`
+	Groups                []string                 `json:"groups,omitempty"`
+	GroupsProvider        *OIDCGroupsProvider      `json:"groupsProvider,omitempty"`
+	ClaimMapping          *OIDCClaimMapping        `json:"claimMapping,omitempty"`
+	SSHGroups             []OIDCSSHGroup           `json:"sshGroups,omitempty"`
+	DeviceAuthorization   *OIDCDeviceAuthorization `json:"deviceAuthorization,omitempty"`
+	ListenAddress         string                   `json:"listenAddress,omitempty"`
+	Claims                *Claims                  `json:"claims,omitempty"`
+	Options               *CertificateOptions      `json:"options,omitempty"`
+	HTTPClient            *HTTPClientOptions       `json:"httpClient,omitempty"`
+	KeyStore              *KeyStoreOptions         `json:"keyStore,omitempty"`
 	configuration         openIDConfiguration
 	keyStore              *keyStore
 	claimer               *Claimer
 	getIdentityFunc       GetIdentityFunc
+	client                *http.Client
+	clientOpts            *HTTPClientOptions
+	discovery             discovery
+}
+
+// discovery contains the state used to re-run the OpenID discovery and detect
+// changes in the OpenID configuration, like a new jwks_uri.
+type discovery struct {
+	sync.RWMutex
+	uri        string
+	expiry     time.Time
+	failures   int
+	inProgress bool
+	closed     bool
 }
 
 // IsAdmin returns true if the given email is in the Admins whitelist, false
 // otherwise.
 func (o *OIDC) IsAdmin(email string) bool {
+	return containsEmail(o.Admins, email)
+}
+
+// isAdmin returns true if the email in the token is in the Admins whitelist,
+// or if the user belongs to one of the AdminGroups. The admins of the client
+// ID of the token are used if they are overridden.
+func (o *OIDC) isAdmin(p *openIDPayload) bool {
+	policy := p.policy
+	if policy == nil {
+		policy = o.getPolicy(o.ClientID)
+	}
+	return containsEmail(policy.admins, p.Email) || (len(policy.adminGroups) > 0 && p.hasGroup(policy.adminGroups))
+}
+
+// containsEmail returns true if the given email is in the list of emails, the
+// domains are compared case-insensitively.
+func containsEmail(emails []string, email string) bool {
 	email = sanitizeEmail(email)
-	for _, e := range o.Admins {
+	for _, e := range emails {
 		if email == sanitizeEmail(e) {
 			return true
 		}
@@ -127,6 +225,24 @@ func (o *OIDC) GetEncryptedKey() (kid string, key string, ok bool) {
 	return "", "", false
 }
 
+// GetKeyStoreStats returns the statistics of the keys used to validate the
+// tokens.
+func (o *OIDC) GetKeyStoreStats() KeyStoreStats {
+	_, ks := o.getConfiguration()
+	return ks.Stats()
+}
+
+// Close releases the keys used to validate the tokens.
+func (o *OIDC) Close() error {
+	o.discovery.Lock()
+	defer o.discovery.Unlock()
+	if !o.discovery.closed {
+		o.discovery.closed = true
+		releaseKeyStore(o.keyStore)
+	}
+	return nil
+}
+
 // Init validates and initializes the OIDC provider.
 func (o *OIDC) Init(config Config) (err error) {
 	switch {
@@ -140,6 +256,10 @@ func (o *OIDC) Init(config Config) (err error) {
 		return errors.New("configurationEndpoint cannot be empty")
 	}
 
+	if err := o.Options.load(config); err != nil {
+		return err
+	}
+
 	// Validate listenAddress if given
 	if o.ListenAddress != "" {
 		if _, _, err := net.SplitHostPort(o.ListenAddress); err != nil {
@@ -147,27 +267,70 @@ func (o *OIDC) Init(config Config) (err error) {
 		}
 	}
 
+	// Validate the additional client IDs if given
+	if err := o.validateAudiences(); err != nil {
+		return err
+	}
+
+	// Validate the groups provider if given
+	if o.GroupsProvider != nil {
+		if err := o.GroupsProvider.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the claim mapping if given
+	if o.ClaimMapping != nil {
+		if err := o.ClaimMapping.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate the ssh groups
+	for i := range o.SSHGroups {
+		if err := o.SSHGroups[i].Validate(); err != nil {
+			return errors.Wrapf(err, "error validating sshGroups[%d]", i)
+		}
+	}
+
 	// Update claims with global ones
 	if o.claimer, err = NewClaimer(o.Claims, config.Claims); err != nil {
 		return err
 	}
 
+	// Create the client used to fetch the configuration and keys
+	clientOpts := o.HTTPClient.withDefaultProxy(config.Proxy)
+	client, err := newHTTPClient(clientOpts)
+	if err != nil {
+		return err
+	}
+
 	// Decode and validate openid-configuration endpoint
 	u, err := url.Parse(o.ConfigurationEndpoint)
 	if err != nil {
 		return errors.Wrapf(err, "error parsing %s", o.ConfigurationEndpoint)
 	}
-	if !strings.Contains(u.Path, "/.well-known/openid-configuration") {
+	if (u.Scheme == "http" || u.Scheme == "https") && !strings.Contains(u.Path, "/.well-known/openid-configuration") {
 		u.Path = path.Join(u.Path, "/.well-known/openid-configuration")
 	}
-	if err := getAndDecode(u.String(), &o.configuration); err != nil {
+	ctx := context.Background()
+	o.client = client
+	o.discovery.uri = u.String()
+	configuration, policy, err := o.discover(ctx)
+	if err != nil {
 		return err
 	}
-	if err := o.configuration.Validate(); err != nil {
-		return errors.Wrapf(err, "error parsing %s", o.ConfigurationEndpoint)
+	o.configuration = configuration
+	o.discovery.expiry = time.Now().Add(getDiscoveryDuration(policy.Age))
+	// Set the device authorization endpoints if the grant is enabled
+	if o.DeviceAuthorization != nil {
+		if err := o.DeviceAuthorization.init(configuration); err != nil {
+			return err
+		}
 	}
 	// Get JWK key set
-	o.keyStore, err = newKeyStore(o.configuration.JWKSetURI)
+	o.clientOpts = clientOpts
+	o.keyStore, err = getKeyStore(ctx, client, clientOpts, o.configuration.JWKSetURI, o.KeyStore)
 	if err != nil {
 		return err
 	}
@@ -181,33 +344,146 @@ func (o *OIDC) Init(config Config) (err error) {
 	return nil
 }
 
+// discover fetches and validates the OpenID configuration.
+func (o *OIDC) discover(ctx context.Context) (openIDConfiguration, cachePolicy, error) {
+	var configuration openIDConfiguration
+	b, policy, err := readURI(ctx, o.client, o.discovery.uri)
+	if err != nil {
+		return configuration, policy, err
+	}
+	if err := json.Unmarshal(b, &configuration); err != nil {
+		return configuration, policy, errors.Wrapf(err, "error reading %s", o.discovery.uri)
+	}
+	if err := configuration.Validate(); err != nil {
+		return configuration, policy, errors.Wrapf(err, "error parsing %s", o.ConfigurationEndpoint)
+	}
+	return configuration, policy, nil
+}
+
+// getConfiguration returns the current OpenID configuration and the keyStore
+// with the keys in its jwks_uri. If the configuration has expired it will be
+// refreshed in the background. The write lock is only taken to start the
+// discovery, so fresh configurations do not serialize the authorizations.
+func (o *OIDC) getConfiguration() (openIDConfiguration, *keyStore) {
+	o.discovery.RLock()
+	configuration, ks := o.configuration, o.keyStore
+	expired := o.discovery.isExpired()
+	o.discovery.RUnlock()
+	if expired {
+		o.discovery.Lock()
+		// Another request might have started the discovery.
+		if o.discovery.isExpired() {
+			o.discovery.inProgress = true
+			go o.rediscover()
+		}
+		o.discovery.Unlock()
+	}
+	return configuration, ks
+}
+
+// isExpired returns true if the discovery must run again. It must be called
+// with the lock held.
+func (d *discovery) isExpired() bool {
+	return d.uri != "" && !d.inProgress && time.Now().After(d.expiry)
+}
+
+// rediscover runs the OpenID discovery again and updates the configuration
+// and the keyStore if the jwks_uri has changed. The previous keyStore is
+// released after the swap. Failed discoveries are retried with an exponential
+// backoff and the current configuration is kept.
+func (o *OIDC) rediscover() {
+	ctx := context.Background()
+	configuration, policy, err := o.discover(ctx)
+	var ks *keyStore
+	var acquired bool
+	if err == nil {
+		o.discovery.RLock()
+		ks = o.keyStore
+		if configuration.JWKSetURI != o.configuration.JWKSetURI {
+			ks = nil
+		}
+		o.discovery.RUnlock()
+		if ks == nil {
+			ks, err = getKeyStore(ctx, o.client, o.clientOpts, configuration.JWKSetURI, o.KeyStore)
+			acquired = err == nil
+		}
+	}
+
+	o.discovery.Lock()
+	defer o.discovery.Unlock()
+	switch {
+	case err != nil:
+		o.discovery.failures++
+		o.discovery.expiry = time.Now().Add(getRetryDuration(o.discovery.failures))
+	case o.discovery.closed:
+		// The provisioner was closed during the discovery.
+		if acquired {
+			releaseKeyStore(ks)
+		}
+	default:
+		if acquired {
+			releaseKeyStore(o.keyStore)
+		}
+		o.configuration = configuration
+		o.keyStore = ks
+		o.discovery.failures = 0
+		o.discovery.expiry = time.Now().Add(getDiscoveryDuration(policy.Age))
+	}
+	o.discovery.inProgress = false
+}
+
+// getDiscoveryDuration returns the time until the next OpenID discovery using
+// the age in the Cache-Control header and the same jitter used by the
+// keyStore. It will never be less than minDiscoveryAge.
+func getDiscoveryDuration(age time.Duration) time.Duration {
+	if age < minDiscoveryAge {
+		age = minDiscoveryAge
+	}
+	return getReloadDuration(age, getCacheJitter(age))
+}
+
 // ValidatePayload validates the given token payload.
 func (o *OIDC) ValidatePayload(p openIDPayload) error {
+	return o.validatePayload(context.Background(), &p)
+}
+
+// validatePayload validates the given token payload, and reads the groups of
+// the user from the groups provider if it's necessary.
+func (o *OIDC) validatePayload(ctx context.Context, p *openIDPayload) error {
+	configuration, _ := o.getConfiguration()
+
+	// Validate azp if present, and get the client ID of the token
+	clientID, ok := o.getClientID(p)
+	if !ok {
+		return errs.Unauthorized("validatePayload: failed to validate oidc token payload: invalid azp")
+	}
+
 	// According to "rfc7519 JSON Web Token" acceptable skew should be no more
 	// than a few minutes.
 	if err := p.ValidateWithLeeway(jose.Expected{
-		Issuer:   o.configuration.Issuer,
-		Audience: jose.Audience{o.ClientID},
+		Issuer:   configuration.Issuer,
+		Audience: jose.Audience{clientID},
 		Time:     time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, o.claimer.ClockSkew()); err != nil {
 		return errs.Wrap(http.StatusUnauthorized, err, "validatePayload: failed to validate oidc token payload")
 	}
-
-	// Validate azp if present
-	if p.AuthorizedParty != "" && p.AuthorizedParty != o.ClientID {
-		return errs.Unauthorized("validatePayload: failed to validate oidc token payload: invalid azp")
-	}
+	p.policy = o.getPolicy(clientID)
 
 	// Enforce an email claim
 	if p.Email == "" {
 		return errs.Unauthorized("validatePayload: failed to validate oidc token payload: email not found")
 	}
 
+	// Get the groups of the user if they are required
+	if err := o.resolveGroups(ctx, p); err != nil {
+		return err
+	}
+
 	// Validate domains (case-insensitive)
-	if !o.IsAdmin(p.Email) && len(o.Domains) > 0 {
+	if !o.isAdmin(p) && len(p.policy.domains) > 0 {
 		email := sanitizeEmail(p.Email)
 		var found bool
-		for _, d := range o.Domains {
+		for _, d := range p.policy.domains {
 			if strings.HasSuffix(email, "@"+strings.ToLower(d)) {
 				found = true
 				break
@@ -218,28 +494,39 @@ func (o *OIDC) ValidatePayload(p openIDPayload) error {
 		}
 	}
 
-	// Filter by oidc group claim
-	if len(o.Groups) > 0 {
-		var found bool
-		for _, group := range o.Groups {
-			for _, g := range p.Groups {
-				if g == group {
-					found = true
-					break
-				}
-			}
-		}
-		if !found {
-			return errs.Unauthorized("validatePayload: oidc token payload validation failed: invalid group")
-		}
+	// Filter by oidc group claim, admin groups are also allowed
+	if len(p.policy.groups) > 0 && !p.hasGroup(p.policy.groups) && !p.hasGroup(p.policy.adminGroups) {
+		return errs.Unauthorized("validatePayload: oidc token payload validation failed: invalid group")
 	}
 
 	return nil
 }
 
+// resolveGroups reads the groups of the user from the groups provider if the
+// provisioner has groups, admin groups or ssh groups configured. Without a
+// groups provider only the groups in the token are used, and tokens with a
+// group overage are rejected.
+func (o *OIDC) resolveGroups(ctx context.Context, p *openIDPayload) error {
+	if len(p.policy.groups) == 0 && len(p.policy.adminGroups) == 0 && len(o.SSHGroups) == 0 {
+		return nil
+	}
+	if o.GroupsProvider == nil {
+		if p.hasGroupsOverage() {
+			return errs.Unauthorized("validatePayload: oidc token payload validation failed: groups overage requires a groupsProvider")
+		}
+		return nil
+	}
+	groups, err := o.GroupsProvider.getGroups(ctx, o.client, p)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "validatePayload: error getting oidc groups")
+	}
+	p.groups = groups
+	return nil
+}
+
 // authorizeToken applies the most common provisioner authorization claims,
 // leaving the rest to context specific methods.
-func (o *OIDC) authorizeToken(token string) (*openIDPayload, error) {
+func (o *OIDC) authorizeToken(ctx context.Context, token string) (*openIDPayload, error) {
 	jwt, err := jose.ParseSigned(token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err,
@@ -255,9 +542,13 @@ func (o *OIDC) authorizeToken(token string) (*openIDPayload, error) {
 
 	found := false
 	kid := jwt.Headers[0].KeyID
-	keys := o.keyStore.Get(kid)
+	_, ks := o.getConfiguration()
+	keys := ks.Get(kid)
 	for _, key := range keys {
-		if err := jwt.Claims(key, &claims); err == nil {
+		// The raw claims are used by the claim mapping.
+		var raw map[string]interface{}
+		if err := jwt.Claims(key, &claims, &raw); err == nil {
+			claims.raw = raw
 			found = true
 			break
 		}
@@ -266,24 +557,34 @@ func (o *OIDC) authorizeToken(token string) (*openIDPayload, error) {
 		return nil, errs.Unauthorized("oidc.AuthorizeToken; cannot validate oidc token")
 	}
 
-	if err := o.ValidatePayload(claims); err != nil {
+	if err := o.validatePayload(ctx, &claims); err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeToken")
 	}
 
 	return &claims, nil
 }
 
+// AuthorizeIdentity validates the given token and returns the sanitized email
+// of the user. It's used to authenticate the administrators of the CA.
+func (o *OIDC) AuthorizeIdentity(ctx context.Context, token string) (string, error) {
+	claims, err := o.authorizeToken(ctx, token)
+	if err != nil {
+		return "", errs.Wrap(http.StatusUnauthorized, err, "oidc.AuthorizeIdentity")
+	}
+	return sanitizeEmail(claims.Email), nil
+}
+
 // AuthorizeRevoke returns an error if the provisioner does not have rights to
 // revoke the certificate with serial number in the `sub` property.
 // Only tokens generated by an admin have the right to revoke a certificate.
 func (o *OIDC) AuthorizeRevoke(ctx context.Context, token string) error {
-	claims, err := o.authorizeToken(token)
+	claims, err := o.authorizeToken(ctx, token)
 	if err != nil {
 		return errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeRevoke")
 	}
 
 	// Only admins can revoke certificates.
-	if o.IsAdmin(claims.Email) {
+	if o.isAdmin(claims) {
 		return nil
 	}
 	return errs.Unauthorized("oidc.AuthorizeRevoke; cannot revoke with non-admin oidc token")
@@ -291,25 +592,41 @@ func (o *OIDC) AuthorizeRevoke(ctx context.Context, token string) error {
 
 // AuthorizeSign validates the given token.
 func (o *OIDC) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
-	claims, err := o.authorizeToken(token)
+	if o.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("oidc.AuthorizeSign; issuance is disabled for oidc provisioner %s", o.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", o.GetName()))
+	}
+	claims, err := o.authorizeToken(ctx, token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSign")
 	}
+	data := newOIDCTemplateData(token, claims, []string{claims.Email})
 
 	so := []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeOIDC, o.Name, o.ClientID),
 		profileDefaultDuration(o.claimer.DefaultTLSCertDuration()),
+		backdateOption(o.claimer.Backdate()),
 		// validators
 		defaultPublicKeyValidator{},
 		newValidityValidator(o.claimer.MinTLSCertDuration(), o.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(o.claimer),
 	}
 	// Admins should be able to authorize any SAN
-	if o.IsAdmin(claims.Email) {
-		return so, nil
+	if o.isAdmin(claims) {
+		return withX509CertificateOptions(o.Options, so, data), nil
 	}
 
-	return append(so, emailOnlyIdentity(claims.Email)), nil
+	// Non-admin users can use the names mapped from the token claims, or only
+	// the email by default.
+	if o.ClaimMapping != nil && o.ClaimMapping.hasX509() {
+		mapped, err := o.ClaimMapping.x509Options(claims.raw)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "oidc.AuthorizeSign")
+		}
+		return withX509CertificateOptions(o.Options, append(so, mapped...), data), nil
+	}
+	return withX509CertificateOptions(o.Options, append(so, emailOnlyIdentity(claims.Email)), data), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
@@ -318,7 +635,14 @@ func (o *OIDC) AuthorizeSign(ctx context.Context, token string) ([]SignOption, e
 // certificate was configured to allow renewals.
 func (o *OIDC) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if o.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("oidc.AuthorizeRenew; renew is disabled for oidc provisioner %s", o.GetID())
+		return errs.Unauthorized("oidc.AuthorizeRenew; renew is disabled for oidc provisioner %s", o.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", o.GetName()))
+	}
+	if err := o.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "oidc.AuthorizeRenew")
+	}
+	if err := o.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "oidc.AuthorizeRenew")
 	}
 	return nil
 }
@@ -328,7 +652,11 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	if !o.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("oidc.AuthorizeSSHSign; sshCA is disabled for oidc provisioner %s", o.GetID())
 	}
-	claims, err := o.authorizeToken(token)
+	if o.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("oidc.AuthorizeSSHSign; issuance is disabled for oidc provisioner %s", o.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", o.GetName()))
+	}
+	claims, err := o.authorizeToken(ctx, token)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
 	}
@@ -337,21 +665,31 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 		sshCertKeyIDModifier(claims.Email),
 	}
 
-	// Get the identity using either the default identityFunc or one injected
-	// externally.
-	iden, err := o.getIdentityFunc(ctx, o, claims.Email)
-	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
+	// Get the principals from the claim mapping, or the identity using either
+	// the default identityFunc or one injected externally.
+	var principals []string
+	if o.ClaimMapping != nil && len(o.ClaimMapping.Principals) > 0 {
+		if principals, err = o.ClaimMapping.principals(claims.raw); err != nil {
+			return nil, errs.Wrap(http.StatusUnauthorized, err, "oidc.AuthorizeSSHSign")
+		}
+	} else {
+		iden, err := o.getIdentityFunc(ctx, o, claims.Email)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHSign")
+		}
+		principals = iden.Usernames
 	}
+	// Add the principals and extensions granted by the ssh groups.
+	principals, extensions := sshGroupGrants(o.SSHGroups, claims, principals)
 	defaults := SSHOptions{
 		CertType:   SSHUserCert,
-		Principals: iden.Usernames,
+		Principals: principals,
 	}
 
 	// Admin users can use any principal, and can sign user and host certificates.
-	// Non-admin users can only use principals returned by the identityFunc, and
-	// can only sign user certificates.
-	if !o.IsAdmin(claims.Email) {
+	// Non-admin users can only use the mapped principals or the ones returned by
+	// the identityFunc, and can only sign user certificates.
+	if !o.isAdmin(claims) {
 		signOptions = append(signOptions, sshCertOptionsValidator(defaults))
 	}
 
@@ -359,41 +697,43 @@ func (o *OIDC) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption
 	// are not set.
 	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
 
-	return append(signOptions,
+	return withSSHCertificateOptions(o.Options, append(signOptions,
 		// Set the default extensions
 		&sshDefaultExtensionModifier{},
+		// Add the extensions granted by the ssh groups
+		sshCertExtensionsModifier(extensions),
 		// Set the validity bounds if not set.
 		&sshDefaultDuration{o.claimer},
+		backdateOption(o.claimer.Backdate()),
 		// Validate public key
 		&sshDefaultPublicKeyValidator{},
 		// Validate the validity period.
 		&sshCertValidityValidator{o.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	), newOIDCTemplateData(token, claims, principals)), nil
 }
 
 // AuthorizeSSHRevoke returns nil if the token is valid, false otherwise.
 func (o *OIDC) AuthorizeSSHRevoke(ctx context.Context, token string) error {
-	claims, err := o.authorizeToken(token)
+	claims, err := o.authorizeToken(ctx, token)
 	if err != nil {
 		return errs.Wrap(http.StatusInternalServerError, err, "oidc.AuthorizeSSHRevoke")
 	}
 
 	// Only admins can revoke certificates.
-	if !o.IsAdmin(claims.Email) {
+	if !o.isAdmin(claims) {
 		return errs.Unauthorized("oidc.AuthorizeSSHRevoke; cannot revoke with non-admin oidc token")
 	}
 	return nil
 }
 
-func getAndDecode(uri string, v interface{}) error {
-	resp, err := http.Get(uri)
+func getAndDecode(ctx context.Context, client *http.Client, uri string, v interface{}) error {
+	b, _, err := readURI(ctx, client, uri)
 	if err != nil {
-		return errors.Wrapf(err, "failed to connect to %s", uri)
+		return err
 	}
-	defer resp.Body.Close()
-	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+	if err := json.Unmarshal(b, v); err != nil {
 		return errors.Wrapf(err, "error reading %s", uri)
 	}
 	return nil
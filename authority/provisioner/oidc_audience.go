@@ -0,0 +1,95 @@
+package provisioner
+
+import (
+	"github.com/pkg/errors"
+)
+
+// OIDCAudience is an additional client ID accepted by an OIDC provisioner,
+// e.g. the web client of the same tenant used along with the CLI client. The
+// admins, domains, and groups, if set, override the ones in the provisioner
+// for the tokens issued to this client.
+type OIDCAudience struct {
+	ClientID    string   `json:"clientID"`
+	Admins      []string `json:"admins,omitempty"`
+	AdminGroups []string `json:"adminGroups,omitempty"`
+	Domains     []string `json:"domains,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+}
+
+// oidcPolicy contains the admins, domains, and groups used to authorize the
+// tokens of a client ID.
+type oidcPolicy struct {
+	admins      []string
+	adminGroups []string
+	domains     []string
+	groups      []string
+}
+
+// validateAudiences validates the additional client IDs of the provisioner.
+func (o *OIDC) validateAudiences() error {
+	clientIDs := map[string]bool{o.ClientID: true}
+	for _, a := range o.Audiences {
+		switch {
+		case a == nil || a.ClientID == "":
+			return errors.New("audiences clientID cannot be empty")
+		case clientIDs[a.ClientID]:
+			return errors.Errorf("audiences clientID %s is duplicated", a.ClientID)
+		}
+		clientIDs[a.ClientID] = true
+	}
+	return nil
+}
+
+// getClientID returns the client ID the token was issued to, and false if it
+// is not accepted by the provisioner. The authorized party is used if present,
+// otherwise the first audience accepted.
+func (o *OIDC) getClientID(p *openIDPayload) (string, bool) {
+	if p.AuthorizedParty != "" {
+		return p.AuthorizedParty, o.getAudience(p.AuthorizedParty) != nil || p.AuthorizedParty == o.ClientID
+	}
+	if p.Audience.Contains(o.ClientID) {
+		return o.ClientID, true
+	}
+	for _, a := range o.Audiences {
+		if p.Audience.Contains(a.ClientID) {
+			return a.ClientID, true
+		}
+	}
+	return o.ClientID, true
+}
+
+// getAudience returns the additional audience with the given client ID.
+func (o *OIDC) getAudience(clientID string) *OIDCAudience {
+	for _, a := range o.Audiences {
+		if a.ClientID == clientID {
+			return a
+		}
+	}
+	return nil
+}
+
+// getPolicy returns the admins, domains, and groups used for the tokens of the
+// given client ID.
+func (o *OIDC) getPolicy(clientID string) *oidcPolicy {
+	policy := &oidcPolicy{
+		admins:      o.Admins,
+		adminGroups: o.AdminGroups,
+		domains:     o.Domains,
+		groups:      o.Groups,
+	}
+	if a := o.getAudience(clientID); a != nil {
+		if a.Admins != nil {
+			policy.admins = a.Admins
+		}
+		if a.AdminGroups != nil {
+			policy.adminGroups = a.AdminGroups
+		}
+		if a.Domains != nil {
+			policy.domains = a.Domains
+		}
+		if a.Groups != nil {
+			policy.groups = a.Groups
+		}
+	}
+	return policy
+}
@@ -0,0 +1,86 @@
+package provisioner
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestOIDC_Init_audiences(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	config := Config{Claims: globalProvisionerClaims}
+
+	newProvisioner := func(audiences ...*OIDCAudience) *OIDC {
+		return &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL, Audiences: audiences}
+	}
+	assert.FatalError(t, newProvisioner(&OIDCAudience{ClientID: "web-client"}, &OIDCAudience{ClientID: "other-client"}).Init(config))
+	assert.Error(t, newProvisioner(&OIDCAudience{}).Init(config))
+	assert.Error(t, newProvisioner(nil).Init(config))
+	assert.Error(t, newProvisioner(&OIDCAudience{ClientID: "client-id"}).Init(config))
+	assert.Error(t, newProvisioner(&OIDCAudience{ClientID: "web-client"}, &OIDCAudience{ClientID: "web-client"}).Init(config))
+}
+
+func TestOIDC_validatePayload_audiences(t *testing.T) {
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.Admins = []string{"root@smallstep.com"}
+	p.Domains = []string{"smallstep.com"}
+	p.Audiences = []*OIDCAudience{
+		{ClientID: "web-client"},
+		{ClientID: "admin-client", Admins: []string{"jane@example.com"}, Domains: []string{"example.com"}},
+		{ClientID: "group-client", Groups: []string{"Engineering"}},
+	}
+
+	payload := func(email, azp string, aud ...string) *openIDPayload {
+		return &openIDPayload{
+			Claims: jose.Claims{
+				Issuer:   p.configuration.Issuer,
+				Audience: aud,
+				Expiry:   jose.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+			AuthorizedParty: azp,
+			Email:           email,
+			Groups:          []string{"Sales"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		payload   *openIDPayload
+		wantAdmin bool
+		code      int
+	}{
+		{"ok", payload("name@smallstep.com", "", p.ClientID), false, 0},
+		{"ok admin", payload("root@smallstep.com", "", p.ClientID), true, 0},
+		{"ok web-client", payload("name@smallstep.com", "", "web-client"), false, 0},
+		{"ok web-client admin", payload("root@smallstep.com", "", "web-client"), true, 0},
+		{"ok web-client azp", payload("name@smallstep.com", "web-client", "web-client", "other"), false, 0},
+		{"ok admin-client", payload("name@example.com", "", "admin-client"), false, 0},
+		{"ok admin-client admin", payload("jane@example.com", "admin-client", "admin-client"), true, 0},
+		{"fail admin-client domain", payload("name@smallstep.com", "", "admin-client"), false, http.StatusUnauthorized},
+		{"ok admin-client root not admin", payload("root@example.com", "", "admin-client"), false, 0},
+		{"fail group-client", payload("name@smallstep.com", "", "group-client"), false, http.StatusUnauthorized},
+		{"fail audience", payload("name@smallstep.com", "", "unknown-client"), false, http.StatusUnauthorized},
+		{"fail azp", payload("name@smallstep.com", "unknown-client", "web-client"), false, http.StatusUnauthorized},
+		{"fail azp audience", payload("name@smallstep.com", "web-client", p.ClientID), false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.validatePayload(context.Background(), tt.payload)
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.wantAdmin, p.isAdmin(tt.payload))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package provisioner
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultDeviceInterval is the default polling interval of the device
+// authorization grant defined in RFC 8628.
+const defaultDeviceInterval = 5 * time.Second
+
+// OIDCDeviceAuthorization enables the OAuth 2.0 device authorization grant,
+// RFC 8628, in an OIDC provisioner. Clients without a browser, like headless
+// servers, request a user code in the device authorization endpoint, show the
+// verification URI to the user, and poll the token endpoint until the user
+// completes the authorization. The resulting ID token is validated by the
+// provisioner like the ones in the browser flow.
+//
+// The endpoints default to the ones in the OpenID configuration, and all the
+// values are exposed to the clients in the provisioners endpoint.
+type OIDCDeviceAuthorization struct {
+	// DeviceAuthorizationEndpoint is the URL used to request the device and
+	// user codes.
+	DeviceAuthorizationEndpoint string `json:"deviceAuthorizationEndpoint,omitempty"`
+	// TokenEndpoint is the URL polled to exchange the device code by the ID
+	// token.
+	TokenEndpoint string `json:"tokenEndpoint,omitempty"`
+	// Scopes are the scopes requested, they must include openid.
+	Scopes []string `json:"scopes,omitempty"`
+	// Interval is the minimum time between polling requests, used if the
+	// authorization response does not include it.
+	Interval *Duration `json:"interval,omitempty"`
+}
+
+// init validates the device authorization configuration and sets the default
+// values using the given OpenID configuration.
+func (d *OIDCDeviceAuthorization) init(c openIDConfiguration) error {
+	if d.DeviceAuthorizationEndpoint == "" {
+		d.DeviceAuthorizationEndpoint = c.DeviceAuthorizationEndpoint
+	}
+	if d.TokenEndpoint == "" {
+		d.TokenEndpoint = c.TokenEndpoint
+	}
+	switch {
+	case d.DeviceAuthorizationEndpoint == "":
+		return errors.New("deviceAuthorization deviceAuthorizationEndpoint cannot be empty")
+	case d.TokenEndpoint == "":
+		return errors.New("deviceAuthorization tokenEndpoint cannot be empty")
+	}
+	for _, s := range []string{d.DeviceAuthorizationEndpoint, d.TokenEndpoint} {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.Errorf("deviceAuthorization endpoint %s is not a valid url", s)
+		}
+	}
+
+	if len(d.Scopes) == 0 {
+		d.Scopes = []string{"openid", "email"}
+	} else if !containsAllMembers(d.Scopes, []string{"openid"}) {
+		return errors.New("deviceAuthorization scopes must contain openid")
+	}
+
+	switch {
+	case d.Interval == nil:
+		d.Interval = &Duration{Duration: defaultDeviceInterval}
+	case d.Interval.Duration < time.Second:
+		return errors.Errorf("deviceAuthorization interval cannot be lower than 1s, got %s", d.Interval)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func TestOIDCDeviceAuthorization_init(t *testing.T) {
+	discovered := openIDConfiguration{
+		Issuer:                      "https://example.com",
+		JWKSetURI:                   "https://example.com/jwks",
+		TokenEndpoint:               "https://example.com/token",
+		DeviceAuthorizationEndpoint: "https://example.com/device",
+	}
+	defaults := &OIDCDeviceAuthorization{
+		DeviceAuthorizationEndpoint: "https://example.com/device",
+		TokenEndpoint:               "https://example.com/token",
+		Scopes:                      []string{"openid", "email"},
+		Interval:                    &Duration{Duration: 5 * time.Second},
+	}
+	tests := []struct {
+		name    string
+		d       *OIDCDeviceAuthorization
+		c       openIDConfiguration
+		want    *OIDCDeviceAuthorization
+		wantErr bool
+	}{
+		{"ok", &OIDCDeviceAuthorization{}, discovered, defaults, false},
+		{"ok-configured", &OIDCDeviceAuthorization{
+			DeviceAuthorizationEndpoint: "https://idp.example.com/device",
+			TokenEndpoint:               "https://idp.example.com/token",
+			Scopes:                      []string{"openid", "profile"},
+			Interval:                    &Duration{Duration: 10 * time.Second},
+		}, openIDConfiguration{}, &OIDCDeviceAuthorization{
+			DeviceAuthorizationEndpoint: "https://idp.example.com/device",
+			TokenEndpoint:               "https://idp.example.com/token",
+			Scopes:                      []string{"openid", "profile"},
+			Interval:                    &Duration{Duration: 10 * time.Second},
+		}, false},
+		{"fail-device-endpoint", &OIDCDeviceAuthorization{}, openIDConfiguration{TokenEndpoint: "https://example.com/token"}, nil, true},
+		{"fail-token-endpoint", &OIDCDeviceAuthorization{}, openIDConfiguration{DeviceAuthorizationEndpoint: "https://example.com/device"}, nil, true},
+		{"fail-url", &OIDCDeviceAuthorization{DeviceAuthorizationEndpoint: "file:///device"}, discovered, nil, true},
+		{"fail-scopes", &OIDCDeviceAuthorization{Scopes: []string{"email"}}, discovered, nil, true},
+		{"fail-interval", &OIDCDeviceAuthorization{Interval: &Duration{Duration: time.Millisecond}}, discovered, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.d.init(tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDCDeviceAuthorization.init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.want != nil && !reflect.DeepEqual(tt.d, tt.want) {
+				t.Errorf("OIDCDeviceAuthorization.init() = %v, want %v", tt.d, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDC_Init_deviceAuthorization(t *testing.T) {
+	jwks := generateJWKServer(2)
+	defer jwks.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := openIDConfiguration{Issuer: "the-issuer", JWKSetURI: jwks.URL + "/jwks_uri"}
+		if r.URL.Path == "/device/.well-known/openid-configuration" {
+			c.TokenEndpoint = "https://idp.example.com/token"
+			c.DeviceAuthorizationEndpoint = "https://idp.example.com/device"
+		}
+		json.NewEncoder(w).Encode(c)
+	}))
+	defer srv.Close()
+	config := Config{Claims: globalProvisionerClaims}
+
+	p := &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL + "/device",
+		DeviceAuthorization: &OIDCDeviceAuthorization{}}
+	assert.FatalError(t, p.Init(config))
+	assert.Equals(t, "https://idp.example.com/device", p.DeviceAuthorization.DeviceAuthorizationEndpoint)
+	assert.Equals(t, "https://idp.example.com/token", p.DeviceAuthorization.TokenEndpoint)
+
+	// The grant metadata is exposed to the clients
+	b, err := json.Marshal(p)
+	assert.FatalError(t, err)
+	assert.True(t, strings.Contains(string(b), `"deviceAuthorization":{"deviceAuthorizationEndpoint":"https://idp.example.com/device","tokenEndpoint":"https://idp.example.com/token","scopes":["openid","email"],"interval":"5s"}`))
+
+	// Missing device_authorization_endpoint
+	p = &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL,
+		DeviceAuthorization: &OIDCDeviceAuthorization{}}
+	assert.Error(t, p.Init(config))
+}
@@ -0,0 +1,253 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// GroupsProviderAzure is the type of the groups provider that reads the
+	// groups of Azure AD users using the Microsoft Graph API.
+	GroupsProviderAzure = "azure"
+	// GroupsProviderOkta is the type of the groups provider that reads the
+	// groups of Okta users using the Okta API.
+	GroupsProviderOkta = "okta"
+)
+
+const (
+	azureLoginURL = "https://login.microsoftonline.com"
+	azureGraphURL = "https://graph.microsoft.com"
+)
+
+// maxGroupPages is the maximum number of pages read from a groups API.
+const maxGroupPages = 20
+
+// oidcGroup is a group of an OIDC user, the provisioner groups can match its
+// id or its name.
+type oidcGroup struct {
+	ID   string
+	Name string
+}
+
+// OIDCGroupsProvider configures the API used to get the groups of the users
+// of an OIDC provisioner. It's required for Azure AD tokens with a group
+// overage claim, for providers that do not include the groups in the token,
+// or to configure the groups by name when the token only has the ids.
+type OIDCGroupsProvider struct {
+	// Type is the identity provider type, azure or okta.
+	Type string `json:"type"`
+	// ClientID and ClientSecret are the credentials of the Azure AD
+	// application used to call the Microsoft Graph API. The application
+	// requires the GroupMember.Read.All application permission.
+	ClientID     string `json:"clientID,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// TenantID is the Azure AD tenant, it defaults to the tid claim of the
+	// token.
+	TenantID string `json:"tenantID,omitempty"`
+	// URL is the Okta organization URL, it defaults to the origin of the
+	// token issuer.
+	URL string `json:"url,omitempty"`
+	// APIToken is the Okta API token used to read the groups of the users.
+	APIToken string `json:"apiToken,omitempty"`
+	loginURL string
+	graphURL string
+}
+
+// Validate validates the groups provider configuration.
+func (g *OIDCGroupsProvider) Validate() error {
+	switch g.Type {
+	case GroupsProviderAzure:
+		if g.ClientID == "" || g.ClientSecret == "" {
+			return errors.New("groupsProvider clientID and clientSecret cannot be empty")
+		}
+		if g.loginURL == "" {
+			g.loginURL = azureLoginURL
+		}
+		if g.graphURL == "" {
+			g.graphURL = azureGraphURL
+		}
+	case GroupsProviderOkta:
+		if g.APIToken == "" {
+			return errors.New("groupsProvider apiToken cannot be empty")
+		}
+		if g.URL != "" {
+			if u, err := url.Parse(g.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+				return errors.Errorf("groupsProvider url %s is not a valid https url", g.URL)
+			}
+		}
+	default:
+		return errors.Errorf("groupsProvider type %q is not supported", g.Type)
+	}
+	return nil
+}
+
+// getGroups returns the groups of the user of the given token.
+func (g *OIDCGroupsProvider) getGroups(ctx context.Context, client *http.Client, p *openIDPayload) ([]oidcGroup, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	switch g.Type {
+	case GroupsProviderAzure:
+		return g.getAzureGroups(ctx, client, p)
+	case GroupsProviderOkta:
+		return g.getOktaGroups(ctx, client, p)
+	default:
+		return nil, errors.Errorf("groupsProvider type %q is not supported", g.Type)
+	}
+}
+
+// getAzureGroups returns the groups, including the nested ones, of the Azure
+// AD user with the oid in the token.
+func (g *OIDCGroupsProvider) getAzureGroups(ctx context.Context, client *http.Client, p *openIDPayload) ([]oidcGroup, error) {
+	tenantID := g.TenantID
+	if tenantID == "" {
+		tenantID = p.TenantID
+	}
+	switch {
+	case tenantID == "":
+		return nil, errors.New("error getting azure groups: tenant not found")
+	case p.ObjectID == "":
+		return nil, errors.New("error getting azure groups: oid claim not found")
+	}
+
+	// Get an access token for the Microsoft Graph API using the client
+	// credentials flow.
+	tokenURL := g.loginURL + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	form := url.Values{
+		"client_id":     {g.ClientID},
+		"client_secret": {g.ClientSecret},
+		"scope":         {g.graphURL + "/.default"},
+		"grant_type":    {"client_credentials"},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", tokenURL)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if _, err := doGroupsRequest(client, req, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.Errorf("error reading %s: access_token not found", tokenURL)
+	}
+
+	var groups []oidcGroup
+	uri := g.graphURL + "/v1.0/users/" + url.PathEscape(p.ObjectID) + "/transitiveMemberOf/microsoft.graph.group?$select=id,displayName"
+	for i := 0; uri != "" && i < maxGroupPages; i++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, http.NoBody)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to connect to %s", uri)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		var page struct {
+			Value []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"displayName"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if _, err := doGroupsRequest(client, req, &page); err != nil {
+			return nil, err
+		}
+		for _, v := range page.Value {
+			groups = append(groups, oidcGroup{ID: v.ID, Name: v.DisplayName})
+		}
+		if uri = page.NextLink; uri != "" && !strings.HasPrefix(uri, g.graphURL+"/") {
+			return nil, errors.Errorf("error reading azure groups: invalid next link %s", uri)
+		}
+	}
+	return groups, nil
+}
+
+// getOktaGroups returns the groups of the Okta user with the sub in the
+// token.
+func (g *OIDCGroupsProvider) getOktaGroups(ctx context.Context, client *http.Client, p *openIDPayload) ([]oidcGroup, error) {
+	orgURL := g.URL
+	if orgURL == "" {
+		u, err := url.Parse(p.Issuer)
+		if err != nil || u.Host == "" {
+			return nil, errors.Errorf("error getting okta groups: invalid issuer %s", p.Issuer)
+		}
+		orgURL = u.Scheme + "://" + u.Host
+	}
+	if p.Subject == "" {
+		return nil, errors.New("error getting okta groups: sub claim not found")
+	}
+
+	orgURL = strings.TrimSuffix(orgURL, "/")
+
+	var groups []oidcGroup
+	uri := orgURL + "/api/v1/users/" + url.PathEscape(p.Subject) + "/groups?limit=200"
+	for i := 0; uri != "" && i < maxGroupPages; i++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, http.NoBody)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to connect to %s", uri)
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "SSWS "+g.APIToken)
+		var page []struct {
+			ID      string `json:"id"`
+			Profile struct {
+				Name string `json:"name"`
+			} `json:"profile"`
+		}
+		header, err := doGroupsRequest(client, req, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page {
+			groups = append(groups, oidcGroup{ID: v.ID, Name: v.Profile.Name})
+		}
+		if uri = nextLink(header); uri != "" && !strings.HasPrefix(uri, orgURL+"/") {
+			return nil, errors.Errorf("error reading okta groups: invalid next link %s", uri)
+		}
+	}
+	return groups, nil
+}
+
+// doGroupsRequest sends the request and decodes the JSON response in v.
+func doGroupsRequest(client *http.Client, req *http.Request, v interface{}) (http.Header, error) {
+	uri := req.URL.String()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", uri)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, errors.Errorf("error reading %s: status=%d", uri, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", uri)
+	}
+	return resp.Header, nil
+}
+
+// nextLink returns the url of the Link header with the relation type next.
+func nextLink(header http.Header) string {
+	for _, link := range header["Link"] {
+		for _, l := range strings.Split(link, ",") {
+			parts := strings.Split(l, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				if strings.ReplaceAll(strings.TrimSpace(param), " ", "") == `rel="next"` {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func generateGroupsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tenant-id/oauth2/v2.0/token":
+			r.ParseForm()
+			if r.Form.Get("client_id") != "graph-client" || r.Form.Get("client_secret") != "graph-secret" ||
+				r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != srv.URL+"/.default" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "graph-token"})
+		case "/v1.0/users/user-oid/transitiveMemberOf/microsoft.graph.group":
+			if r.Header.Get("Authorization") != "Bearer graph-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("page") == "" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"value":           []map[string]string{{"id": "azure-id-1", "displayName": "Engineering"}},
+					"@odata.nextLink": srv.URL + r.URL.Path + "?page=2",
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"value": []map[string]string{{"id": "azure-id-2", "displayName": "Admins"}},
+			})
+		case "/api/v1/users/okta-sub/groups":
+			if r.Header.Get("Authorization") != "SSWS okta-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("after") == "" {
+				w.Header().Set("Link", `<`+srv.URL+r.URL.Path+`?limit=200>; rel="self", <`+srv.URL+r.URL.Path+`?after=1>; rel="next"`)
+				json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "okta-id-1", "profile": map[string]string{"name": "Everyone"}}})
+				return
+			}
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "okta-id-2", "profile": map[string]string{"name": "Engineering"}}})
+		case "/api/v1/users/bad-next/groups":
+			w.Header().Set("Link", `<https://evil.example.com/groups>; rel="next"`)
+			json.NewEncoder(w).Encode([]map[string]interface{}{})
+		case "/api/v1/users/bad-json/groups":
+			w.Write([]byte("{"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv
+}
+
+func TestOIDCGroupsProvider_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       *OIDCGroupsProvider
+		wantErr bool
+	}{
+		{"ok azure", &OIDCGroupsProvider{Type: "azure", ClientID: "id", ClientSecret: "secret"}, false},
+		{"ok okta", &OIDCGroupsProvider{Type: "okta", APIToken: "token"}, false},
+		{"ok okta url", &OIDCGroupsProvider{Type: "okta", APIToken: "token", URL: "https://example.okta.com"}, false},
+		{"fail type", &OIDCGroupsProvider{Type: "google", APIToken: "token"}, true},
+		{"fail azure clientID", &OIDCGroupsProvider{Type: "azure", ClientSecret: "secret"}, true},
+		{"fail azure clientSecret", &OIDCGroupsProvider{Type: "azure", ClientID: "id"}, true},
+		{"fail okta apiToken", &OIDCGroupsProvider{Type: "okta"}, true},
+		{"fail okta url", &OIDCGroupsProvider{Type: "okta", APIToken: "token", URL: "http://example.okta.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.g.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("OIDCGroupsProvider.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	g := &OIDCGroupsProvider{Type: "azure", ClientID: "id", ClientSecret: "secret"}
+	assert.FatalError(t, g.Validate())
+	assert.Equals(t, azureLoginURL, g.loginURL)
+	assert.Equals(t, azureGraphURL, g.graphURL)
+}
+
+func TestOIDC_Init_groupsProvider(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	config := Config{Claims: globalProvisionerClaims}
+
+	p := &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL,
+		GroupsProvider: &OIDCGroupsProvider{Type: "azure", ClientID: "id", ClientSecret: "secret"}}
+	assert.FatalError(t, p.Init(config))
+	assert.Equals(t, azureGraphURL, p.GroupsProvider.graphURL)
+
+	p = &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL,
+		GroupsProvider: &OIDCGroupsProvider{Type: "azure"}}
+	assert.Error(t, p.Init(config))
+}
+
+func TestOIDCGroupsProvider_getGroups(t *testing.T) {
+	srv := generateGroupsServer(t)
+	defer srv.Close()
+
+	azure := &OIDCGroupsProvider{Type: "azure", ClientID: "graph-client", ClientSecret: "graph-secret", loginURL: srv.URL, graphURL: srv.URL}
+	azureTenant := &OIDCGroupsProvider{Type: "azure", ClientID: "graph-client", ClientSecret: "graph-secret", TenantID: "tenant-id", loginURL: srv.URL, graphURL: srv.URL}
+	azureBadSecret := &OIDCGroupsProvider{Type: "azure", ClientID: "graph-client", ClientSecret: "foo", loginURL: srv.URL, graphURL: srv.URL}
+	okta := &OIDCGroupsProvider{Type: "okta", APIToken: "okta-token", URL: srv.URL}
+	oktaBadToken := &OIDCGroupsProvider{Type: "okta", APIToken: "foo", URL: srv.URL}
+
+	tests := []struct {
+		name    string
+		g       *OIDCGroupsProvider
+		p       *openIDPayload
+		want    []oidcGroup
+		wantErr bool
+	}{
+		{"ok azure", azure, &openIDPayload{ObjectID: "user-oid", TenantID: "tenant-id"},
+			[]oidcGroup{{"azure-id-1", "Engineering"}, {"azure-id-2", "Admins"}}, false},
+		{"ok azure tenant", azureTenant, &openIDPayload{ObjectID: "user-oid", TenantID: "other"},
+			[]oidcGroup{{"azure-id-1", "Engineering"}, {"azure-id-2", "Admins"}}, false},
+		{"ok okta", okta, &openIDPayload{Claims: jose.Claims{Subject: "okta-sub"}},
+			[]oidcGroup{{"okta-id-1", "Everyone"}, {"okta-id-2", "Engineering"}}, false},
+		{"ok okta issuer", &OIDCGroupsProvider{Type: "okta", APIToken: "okta-token"}, &openIDPayload{Claims: jose.Claims{Subject: "okta-sub", Issuer: srv.URL + "/oauth2/default"}},
+			[]oidcGroup{{"okta-id-1", "Everyone"}, {"okta-id-2", "Engineering"}}, false},
+		{"fail azure tenant", azure, &openIDPayload{ObjectID: "user-oid"}, nil, true},
+		{"fail azure oid", azure, &openIDPayload{TenantID: "tenant-id"}, nil, true},
+		{"fail azure secret", azureBadSecret, &openIDPayload{ObjectID: "user-oid", TenantID: "tenant-id"}, nil, true},
+		{"fail azure user", azure, &openIDPayload{ObjectID: "other", TenantID: "tenant-id"}, nil, true},
+		{"fail okta sub", okta, &openIDPayload{}, nil, true},
+		{"fail okta issuer", &OIDCGroupsProvider{Type: "okta", APIToken: "okta-token"}, &openIDPayload{Claims: jose.Claims{Subject: "okta-sub", Issuer: "foo"}}, nil, true},
+		{"fail okta token", oktaBadToken, &openIDPayload{Claims: jose.Claims{Subject: "okta-sub"}}, nil, true},
+		{"fail okta next link", okta, &openIDPayload{Claims: jose.Claims{Subject: "bad-next"}}, nil, true},
+		{"fail okta json", okta, &openIDPayload{Claims: jose.Claims{Subject: "bad-json"}}, nil, true},
+		{"fail type", &OIDCGroupsProvider{Type: "foo"}, &openIDPayload{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.g.getGroups(context.Background(), nil, tt.p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OIDCGroupsProvider.getGroups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func Test_nextLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{"ok", http.Header{"Link": {`<https://a/self>; rel="self", <https://a/next>; rel="next"`}}, "https://a/next"},
+		{"ok multiple", http.Header{"Link": {`<https://a/self>; rel="self"`, `<https://a/next>;rel="next"`}}, "https://a/next"},
+		{"ok none", http.Header{"Link": {`<https://a/self>; rel="self"`}}, ""},
+		{"ok empty", http.Header{}, ""},
+		{"ok invalid", http.Header{"Link": {`https://a/next; rel="next"`}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextLink(tt.header); got != tt.want {
+				t.Errorf("nextLink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDC_validatePayload_groups(t *testing.T) {
+	srv := generateGroupsServer(t)
+	defer srv.Close()
+
+	newProvisioner := func(groups, adminGroups []string, gp *OIDCGroupsProvider) *OIDC {
+		p, err := generateOIDC()
+		assert.FatalError(t, err)
+		p.Groups = groups
+		p.AdminGroups = adminGroups
+		p.Domains = []string{"smallstep.com"}
+		p.GroupsProvider = gp
+		return p
+	}
+	azure := &OIDCGroupsProvider{Type: "azure", ClientID: "graph-client", ClientSecret: "graph-secret", loginURL: srv.URL, graphURL: srv.URL}
+	okta := &OIDCGroupsProvider{Type: "okta", APIToken: "okta-token", URL: srv.URL}
+	oktaBadToken := &OIDCGroupsProvider{Type: "okta", APIToken: "foo", URL: srv.URL}
+
+	payload := func(p *OIDC, email string, fn func(*openIDPayload)) *openIDPayload {
+		v := &openIDPayload{
+			Claims: jose.Claims{
+				Subject:  "okta-sub",
+				Issuer:   p.configuration.Issuer,
+				Audience: jose.Audience{p.ClientID},
+				Expiry:   jose.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+			Email:    email,
+			ObjectID: "user-oid",
+			TenantID: "tenant-id",
+		}
+		if fn != nil {
+			fn(v)
+		}
+		return v
+	}
+	withGroups := func(groups ...string) func(*openIDPayload) {
+		return func(p *openIDPayload) { p.Groups = groups }
+	}
+	withOverage := func(p *openIDPayload) {
+		p.ClaimNames = map[string]string{"groups": "src1"}
+	}
+
+	tests := []struct {
+		name      string
+		p         *OIDC
+		email     string
+		fn        func(*openIDPayload)
+		wantAdmin bool
+		code      int
+	}{
+		{"ok token groups", newProvisioner([]string{"azure-id-1"}, nil, nil), "name@smallstep.com", withGroups("azure-id-1"), false, 0},
+		{"ok admin token groups", newProvisioner(nil, []string{"admins"}, nil), "name@example.com", withGroups("admins"), true, 0},
+		{"ok azure by name", newProvisioner([]string{"Engineering"}, nil, azure), "name@smallstep.com", withOverage, false, 0},
+		{"ok azure by id", newProvisioner([]string{"azure-id-2"}, nil, azure), "name@smallstep.com", withOverage, false, 0},
+		{"ok azure admin", newProvisioner([]string{"Engineering"}, []string{"Admins"}, azure), "name@example.com", withOverage, true, 0},
+		{"ok azure admin only", newProvisioner([]string{"Other"}, []string{"Admins"}, azure), "name@example.com", nil, true, 0},
+		{"ok okta", newProvisioner([]string{"Engineering"}, nil, okta), "name@smallstep.com", nil, false, 0},
+		{"ok no groups", newProvisioner(nil, nil, oktaBadToken), "name@smallstep.com", nil, false, 0},
+		{"fail token groups", newProvisioner([]string{"azure-id-1"}, nil, nil), "name@smallstep.com", withGroups("azure-id-2"), false, http.StatusUnauthorized},
+		{"fail overage", newProvisioner([]string{"azure-id-1"}, nil, nil), "name@smallstep.com", withOverage, false, http.StatusUnauthorized},
+		{"fail hasgroups", newProvisioner([]string{"azure-id-1"}, nil, nil), "name@smallstep.com", func(p *openIDPayload) { p.HasGroups = true }, false, http.StatusUnauthorized},
+		{"fail azure group", newProvisioner([]string{"Sales"}, nil, azure), "name@smallstep.com", withOverage, false, http.StatusUnauthorized},
+		{"fail domain not admin", newProvisioner([]string{"Engineering"}, []string{"Sales"}, okta), "name@example.com", nil, false, http.StatusUnauthorized},
+		{"fail provider", newProvisioner([]string{"Engineering"}, nil, oktaBadToken), "name@smallstep.com", nil, false, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := payload(tt.p, tt.email, tt.fn)
+			err := tt.p.validatePayload(context.Background(), p)
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.wantAdmin, tt.p.isAdmin(p))
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package provisioner
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OIDCClaimMapping maps the claims of an OIDC token to the names in the
+// certificates of non-admin users, instead of the default behavior that only
+// allows the email in X.509 certificates, and the principals returned by the
+// identity function in SSH certificates.
+//
+// Each value is the name of a claim, nested claims are separated by dots,
+// e.g. "ext.upn". Claims can be strings or arrays of strings.
+type OIDCClaimMapping struct {
+	CommonName string   `json:"commonName,omitempty"`
+	DNSNames   []string `json:"dnsNames,omitempty"`
+	Emails     []string `json:"emails,omitempty"`
+	IPs        []string `json:"ips,omitempty"`
+	URIs       []string `json:"uris,omitempty"`
+	Principals []string `json:"principals,omitempty"`
+}
+
+// Validate validates the claim mapping.
+func (m *OIDCClaimMapping) Validate() error {
+	names := [][]string{m.DNSNames, m.Emails, m.IPs, m.URIs, m.Principals}
+	for _, claims := range names {
+		for _, c := range claims {
+			if c == "" {
+				return errors.New("claimMapping cannot contain empty claim names")
+			}
+		}
+	}
+	return nil
+}
+
+// hasX509 returns true if the mapping defines any name of X.509 certificates.
+func (m *OIDCClaimMapping) hasX509() bool {
+	return m.CommonName != "" || len(m.DNSNames) > 0 || len(m.Emails) > 0 || len(m.IPs) > 0 || len(m.URIs) > 0
+}
+
+// x509Options returns the validators that require the names in the
+// certificate request to be the ones mapped from the token claims.
+func (m *OIDCClaimMapping) x509Options(claims map[string]interface{}) ([]SignOption, error) {
+	var so []SignOption
+	if m.CommonName != "" {
+		cn := getClaimValues(claims, m.CommonName)
+		if len(cn) != 1 {
+			return nil, errors.Errorf("claim %s must contain one value", m.CommonName)
+		}
+		so = append(so, commonNameValidator(cn[0]))
+	}
+
+	var ips []net.IP
+	for _, s := range getAllClaimValues(claims, m.IPs) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Errorf("claim value %s is not a valid IP address", s)
+		}
+		ips = append(ips, ip)
+	}
+	var uris []*url.URL
+	for _, s := range getAllClaimValues(claims, m.URIs) {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" {
+			return nil, errors.Errorf("claim value %s is not a valid URI", s)
+		}
+		uris = append(uris, u)
+	}
+
+	return append(so,
+		dnsNamesValidator(getAllClaimValues(claims, m.DNSNames)),
+		emailAddressesValidator(getAllClaimValues(claims, m.Emails)),
+		ipAddressesValidator(ips),
+		urisValidator(uris),
+	), nil
+}
+
+// principals returns the SSH principals mapped from the token claims.
+func (m *OIDCClaimMapping) principals(claims map[string]interface{}) ([]string, error) {
+	principals := getAllClaimValues(claims, m.Principals)
+	if len(principals) == 0 {
+		return nil, errors.Errorf("claims %s do not contain any principal", strings.Join(m.Principals, ", "))
+	}
+	return principals, nil
+}
+
+// getAllClaimValues returns the non-empty values of all the given claims
+// without duplicates.
+func getAllClaimValues(claims map[string]interface{}, names []string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		for _, v := range getClaimValues(claims, name) {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// getClaimValues returns the non-empty values of a claim. Claims whose name
+// contains dots are looked up first by the full name, and then as nested
+// objects. Numbers and booleans are converted to strings.
+func getClaimValues(claims map[string]interface{}, name string) []string {
+	v, ok := claims[name]
+	if !ok {
+		parts := strings.Split(name, ".")
+		var cur interface{} = claims
+		for _, part := range parts {
+			m, isMap := cur.(map[string]interface{})
+			if !isMap {
+				return nil
+			}
+			if cur, ok = m[part]; !ok {
+				return nil
+			}
+		}
+		v = cur
+	}
+
+	var values []string
+	add := func(v interface{}) {
+		switch t := v.(type) {
+		case string:
+			if t != "" {
+				values = append(values, t)
+			}
+		case float64, bool:
+			values = append(values, fmt.Sprint(t))
+		}
+	}
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			add(item)
+		}
+	} else {
+		add(v)
+	}
+	return values
+}
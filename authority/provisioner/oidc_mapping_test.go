@@ -0,0 +1,284 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+func generateMappingToken(aud string, extra map[string]interface{}, jwk *jose.JSONWebKey) (string, error) {
+	so := new(jose.SignerOptions)
+	so.WithType("JWT")
+	so.WithHeader("kid", jwk.KeyID)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key}, so)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub":   "subject",
+		"iss":   "the-issuer",
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"email": "name@smallstep.com",
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+func Test_getClaimValues(t *testing.T) {
+	claims := map[string]interface{}{
+		"preferred_username": "jane",
+		"empty":              "",
+		"roles":              []interface{}{"admin", "", 42.0, "dev"},
+		"uid":                1001.0,
+		"ext":                map[string]interface{}{"upn": "jane@example.com"},
+		"ext.upn":            "flat@example.com",
+		"obj":                map[string]interface{}{"a": "b"},
+	}
+	tests := []struct {
+		name  string
+		claim string
+		want  []string
+	}{
+		{"string", "preferred_username", []string{"jane"}},
+		{"empty", "empty", nil},
+		{"array", "roles", []string{"admin", "42", "dev"}},
+		{"number", "uid", []string{"1001"}},
+		{"flat", "ext.upn", []string{"flat@example.com"}},
+		{"nested", "obj.a", []string{"b"}},
+		{"object", "obj", nil},
+		{"missing", "missing", nil},
+		{"missing-nested", "preferred_username.foo", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getClaimValues(claims, tt.claim); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getClaimValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCClaimMapping_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *OIDCClaimMapping
+		wantErr bool
+	}{
+		{"ok", &OIDCClaimMapping{CommonName: "preferred_username", DNSNames: []string{"hosts"}, Principals: []string{"preferred_username"}}, false},
+		{"ok-empty", &OIDCClaimMapping{}, false},
+		{"fail-dnsNames", &OIDCClaimMapping{DNSNames: []string{""}}, true},
+		{"fail-principals", &OIDCClaimMapping{Principals: []string{"sub", ""}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("OIDCClaimMapping.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDC_Init_claimMapping(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	config := Config{Claims: globalProvisionerClaims}
+
+	p := &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL,
+		ClaimMapping: &OIDCClaimMapping{Emails: []string{"email"}}}
+	assert.FatalError(t, p.Init(config))
+
+	p = &OIDC{Type: "oidc", Name: "name", ClientID: "client-id", ConfigurationEndpoint: srv.URL,
+		ClaimMapping: &OIDCClaimMapping{Emails: []string{""}}}
+	assert.Error(t, p.Init(config))
+}
+
+func TestOIDC_AuthorizeSign_claimMapping(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
+
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p.ClaimMapping = &OIDCClaimMapping{
+		CommonName: "preferred_username",
+		DNSNames:   []string{"hosts"},
+		Emails:     []string{"email", "ext.upn"},
+		IPs:        []string{"ips"},
+		URIs:       []string{"spiffe"},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	token := func(extra map[string]interface{}) string {
+		tok, err := generateMappingToken(p.ClientID, extra, &keys.Keys[0])
+		assert.FatalError(t, err)
+		return tok
+	}
+	okToken := token(map[string]interface{}{
+		"preferred_username": "jane",
+		"hosts":              []string{"jane.internal", "jane.example.com"},
+		"ext":                map[string]interface{}{"upn": "jane@example.com"},
+		"ips":                "10.0.0.1",
+		"spiffe":             "spiffe://example.com/jane",
+	})
+	u, err := url.Parse("spiffe://example.com/jane")
+	assert.FatalError(t, err)
+	okCSR := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "jane"},
+		DNSNames:       []string{"jane.example.com", "jane.internal"},
+		EmailAddresses: []string{"name@smallstep.com", "jane@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{u},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		csr        *x509.CertificateRequest
+		code       int
+		wantErr    bool
+		wantCSRErr bool
+	}{
+		{"ok", okToken, okCSR, http.StatusOK, false, false},
+		{"fail-cn", okToken, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "john"},
+			DNSNames: okCSR.DNSNames, EmailAddresses: okCSR.EmailAddresses, IPAddresses: okCSR.IPAddresses, URIs: okCSR.URIs},
+			http.StatusOK, false, true},
+		{"fail-dnsNames", okToken, &x509.CertificateRequest{Subject: okCSR.Subject,
+			DNSNames: []string{"jane.internal"}, EmailAddresses: okCSR.EmailAddresses, IPAddresses: okCSR.IPAddresses, URIs: okCSR.URIs},
+			http.StatusOK, false, true},
+		{"fail-uris", okToken, &x509.CertificateRequest{Subject: okCSR.Subject,
+			DNSNames: okCSR.DNSNames, EmailAddresses: okCSR.EmailAddresses, IPAddresses: okCSR.IPAddresses},
+			http.StatusOK, false, true},
+		{"fail-missing-cn", token(nil), nil, http.StatusUnauthorized, true, false},
+		{"fail-ip", token(map[string]interface{}{"preferred_username": "jane", "ips": "not-an-ip"}), nil, http.StatusUnauthorized, true, false},
+		{"fail-uri", token(map[string]interface{}{"preferred_username": "jane", "spiffe": "jane"}), nil, http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+				return
+			}
+			var csrErr error
+			for _, o := range got {
+				switch o.(type) {
+				case emailOnlyIdentity:
+					t.Errorf("unexpected sign option of type %T", o)
+				case defaultPublicKeyValidator:
+					continue
+				}
+				if v, ok := o.(CertificateRequestValidator); ok && csrErr == nil {
+					csrErr = v.Valid(tt.csr)
+				}
+			}
+			if (csrErr != nil) != tt.wantCSRErr {
+				t.Errorf("CertificateRequestValidator.Valid() error = %v, wantCSRErr %v", csrErr, tt.wantCSRErr)
+			}
+		})
+	}
+}
+
+func TestOIDC_AuthorizeSSHSign_claimMapping(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
+
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p.ClaimMapping = &OIDCClaimMapping{Principals: []string{"preferred_username", "roles"}}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	okToken, err := generateMappingToken(p.ClientID, map[string]interface{}{
+		"preferred_username": "jane",
+		"roles":              []string{"dev", "jane"},
+	}, &keys.Keys[0])
+	assert.FatalError(t, err)
+	failToken, err := generateMappingToken(p.ClientID, nil, &keys.Keys[0])
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+
+	userDuration := p.claimer.DefaultUserSSHCertDuration()
+	expected := &SSHOptions{
+		CertType: "user", Principals: []string{"jane", "dev"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration)),
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		sshOpts     SSHOptions
+		expected    *SSHOptions
+		code        int
+		wantErr     bool
+		wantSignErr bool
+	}{
+		{"ok", okToken, SSHOptions{}, expected, http.StatusOK, false, false},
+		{"ok-principals", okToken, SSHOptions{Principals: []string{"dev"}}, &SSHOptions{
+			CertType: "user", Principals: []string{"dev"},
+			ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration)),
+		}, http.StatusOK, false, false},
+		{"fail-principals", okToken, SSHOptions{Principals: []string{"name"}}, nil, http.StatusOK, false, true},
+		{"fail-missing", failToken, SSHOptions{}, nil, http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.AuthorizeSSHSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+				return
+			}
+			cert, err := signSSHCertificate(pub, tt.sshOpts, got, signer.Key.(crypto.Signer))
+			if (err != nil) != tt.wantSignErr {
+				t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+			} else if !tt.wantSignErr {
+				assert.NoError(t, validateSSHCertificate(cert, tt.expected))
+				assert.Equals(t, "name@smallstep.com", cert.KeyId)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package provisioner
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// OIDCSSHGroup grants SSH principals and extensions to the members of an OIDC
+// group. The group is matched by id or name against the groups in the token,
+// or the ones read from the groups provider.
+//
+// The principals are added to the ones of the user, so members of the group
+// can request them along with their usernames, and they are included by
+// default if the user does not request any. The extensions are added to the
+// default extensions of user certificates.
+type OIDCSSHGroup struct {
+	Group      string            `json:"group"`
+	Principals []string          `json:"principals,omitempty"`
+	Extensions map[string]string `json:"extensions,omitempty"`
+}
+
+// Validate validates an SSH group.
+func (g *OIDCSSHGroup) Validate() error {
+	switch {
+	case g.Group == "":
+		return errors.New("group cannot be empty")
+	case len(g.Principals) == 0 && len(g.Extensions) == 0:
+		return errors.Errorf("group %s must define principals or extensions", g.Group)
+	}
+	for _, p := range g.Principals {
+		if p == "" {
+			return errors.Errorf("group %s cannot contain empty principals", g.Group)
+		}
+	}
+	for k := range g.Extensions {
+		if k == "" {
+			return errors.Errorf("group %s cannot contain empty extension names", g.Group)
+		}
+	}
+	return nil
+}
+
+// sshGroupGrants returns the principals and extensions granted to the user of
+// the given token by the SSH groups. The principals are appended to the given
+// ones, skipping the duplicates.
+func sshGroupGrants(groups []OIDCSSHGroup, p *openIDPayload, principals []string) ([]string, map[string]string) {
+	var extensions map[string]string
+	seen := make(map[string]bool, len(principals))
+	for _, principal := range principals {
+		seen[principal] = true
+	}
+	for _, g := range groups {
+		if !p.hasGroup([]string{g.Group}) {
+			continue
+		}
+		for _, principal := range g.Principals {
+			if !seen[principal] {
+				seen[principal] = true
+				principals = append(principals, principal)
+			}
+		}
+		for k, v := range g.Extensions {
+			if extensions == nil {
+				extensions = make(map[string]string)
+			}
+			extensions[k] = v
+		}
+	}
+	return principals, extensions
+}
+
+// sshCertExtensionsModifier is an SSHCertModifier that adds the given
+// extensions to user certificates. It must be added after the
+// sshDefaultExtensionModifier.
+type sshCertExtensionsModifier map[string]string
+
+func (m sshCertExtensionsModifier) Modify(cert *ssh.Certificate) error {
+	if cert.CertType != ssh.UserCert || len(m) == 0 {
+		return nil
+	}
+	if cert.Extensions == nil {
+		cert.Extensions = make(map[string]string, len(m))
+	}
+	for k, v := range m {
+		cert.Extensions[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"reflect"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOIDCSSHGroup_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   OIDCSSHGroup
+		wantErr bool
+	}{
+		{"ok principals", OIDCSSHGroup{Group: "ops", Principals: []string{"root"}}, false},
+		{"ok extensions", OIDCSSHGroup{Group: "ops", Extensions: map[string]string{"permit-pty": ""}}, false},
+		{"fail group", OIDCSSHGroup{Principals: []string{"root"}}, true},
+		{"fail empty", OIDCSSHGroup{Group: "ops"}, true},
+		{"fail principal", OIDCSSHGroup{Group: "ops", Principals: []string{"root", ""}}, true},
+		{"fail extension", OIDCSSHGroup{Group: "ops", Extensions: map[string]string{"": ""}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.group.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("OIDCSSHGroup.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDC_Init_sshGroups(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p.SSHGroups = []OIDCSSHGroup{{Group: "ops", Principals: []string{"root"}}, {Group: "dev"}}
+	err = p.Init(Config{Claims: globalProvisionerClaims})
+	if assert.Error(t, err) {
+		assert.HasPrefix(t, err.Error(), "error validating sshGroups[1]: group dev must define principals or extensions")
+	}
+}
+
+func Test_sshGroupGrants(t *testing.T) {
+	groups := []OIDCSSHGroup{
+		{Group: "ops", Principals: []string{"root", "jane"}, Extensions: map[string]string{"permit-pty": ""}},
+		{Group: "group-id", Principals: []string{"deploy"}, Extensions: map[string]string{"login@github.com": "jane"}},
+	}
+	tests := []struct {
+		name           string
+		payload        *openIDPayload
+		wantPrincipals []string
+		wantExtensions map[string]string
+	}{
+		{"none", &openIDPayload{Groups: []string{"dev"}}, []string{"jane"}, nil},
+		{"token group", &openIDPayload{Groups: []string{"dev", "ops"}}, []string{"jane", "root"}, map[string]string{"permit-pty": ""}},
+		{"provider group", &openIDPayload{groups: []oidcGroup{{ID: "group-id", Name: "Deployers"}}}, []string{"jane", "deploy"}, map[string]string{"login@github.com": "jane"}},
+		{"all", &openIDPayload{Groups: []string{"ops"}, groups: []oidcGroup{{ID: "group-id"}}}, []string{"jane", "root", "deploy"}, map[string]string{
+			"permit-pty": "", "login@github.com": "jane",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principals, extensions := sshGroupGrants(groups, tt.payload, []string{"jane"})
+			if !reflect.DeepEqual(principals, tt.wantPrincipals) {
+				t.Errorf("sshGroupGrants() principals = %v, want %v", principals, tt.wantPrincipals)
+			}
+			if !reflect.DeepEqual(extensions, tt.wantExtensions) {
+				t.Errorf("sshGroupGrants() extensions = %v, want %v", extensions, tt.wantExtensions)
+			}
+		})
+	}
+}
+
+func TestOIDC_AuthorizeSSHSign_sshGroups(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
+
+	p, err := generateOIDC()
+	assert.FatalError(t, err)
+	p.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	p.ClaimMapping = &OIDCClaimMapping{Principals: []string{"preferred_username"}}
+	p.SSHGroups = []OIDCSSHGroup{
+		{Group: "ops", Principals: []string{"root"}, Extensions: map[string]string{"login@github.com": "jane"}},
+	}
+	p.Options = &CertificateOptions{SSH: &TemplateOptions{
+		Template: `{"principals": {{ toJson .Insecure.User.Principals }}
+			{{- if not (has "ops" .Groups) }}, "criticalOptions": {"source-address": "10.0.0.0/8"}{{ end }}}`,
+	}}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+
+	opsToken, err := generateMappingToken(p.ClientID, map[string]interface{}{
+		"preferred_username": "jane",
+		"groups":             []string{"dev", "ops"},
+	}, &keys.Keys[0])
+	assert.FatalError(t, err)
+	devToken, err := generateMappingToken(p.ClientID, map[string]interface{}{
+		"preferred_username": "jane",
+		"groups":             []string{"dev"},
+	}, &keys.Keys[0])
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	pub := key.Public().Key
+
+	tests := []struct {
+		name            string
+		token           string
+		sshOpts         SSHOptions
+		wantPrincipals  []string
+		wantCritical    map[string]string
+		wantGroupGrants bool
+		wantSignErr     bool
+	}{
+		{"ok ops", opsToken, SSHOptions{}, []string{"jane", "root"}, nil, true, false},
+		{"ok ops root", opsToken, SSHOptions{Principals: []string{"root"}}, []string{"root"}, nil, true, false},
+		{"ok dev", devToken, SSHOptions{}, []string{"jane"}, map[string]string{"source-address": "10.0.0.0/8"}, false, false},
+		{"fail dev root", devToken, SSHOptions{Principals: []string{"root"}}, nil, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.AuthorizeSSHSign(context.Background(), tt.token)
+			assert.FatalError(t, err)
+			cert, err := signSSHCertificate(pub, tt.sshOpts, got, signer.Key.(crypto.Signer))
+			if (err != nil) != tt.wantSignErr {
+				t.Fatalf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+			}
+			if tt.wantSignErr {
+				return
+			}
+			assert.Equals(t, uint32(ssh.UserCert), cert.CertType)
+			assert.Equals(t, tt.wantPrincipals, cert.ValidPrincipals)
+			if tt.wantCritical == nil {
+				assert.Len(t, 0, cert.CriticalOptions)
+			} else {
+				assert.Equals(t, tt.wantCritical, cert.CriticalOptions)
+			}
+			_, ok := cert.Extensions["login@github.com"]
+			assert.Equals(t, tt.wantGroupGrants, ok)
+			_, ok = cert.Extensions["permit-pty"]
+			assert.True(t, ok)
+		})
+	}
+}
@@ -6,9 +6,16 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -75,6 +82,20 @@ func TestOIDC_Init(t *testing.T) {
 		DefaultTLSDur: &Duration{0},
 	}
 
+	// Offline configuration using file and data uris
+	keySet, err := generateJSONWebKeySet(2)
+	assert.FatalError(t, err)
+	b, err := json.Marshal(keySet)
+	assert.FatalError(t, err)
+	dir, err := ioutil.TempDir(os.TempDir(), "oidc")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	jwksFile := filepath.Join(dir, "jwks.json")
+	assert.FatalError(t, ioutil.WriteFile(jwksFile, b, 0600))
+	b, err = json.Marshal(openIDConfiguration{Issuer: "the-issuer", JWKSetURI: "file://" + jwksFile})
+	assert.FatalError(t, err)
+	dataConfiguration := "data:application/json;base64," + base64.StdEncoding.EncodeToString(b)
+
 	type fields struct {
 		Type                  string
 		Name                  string
@@ -101,6 +122,7 @@ func TestOIDC_Init(t *testing.T) {
 		{"ok-listen-port", fields{"oidc", "name", "client-id", "client-secret", srv.URL, nil, nil, nil, ":10000"}, args{config}, false},
 		{"ok-listen-host-port", fields{"oidc", "name", "client-id", "client-secret", srv.URL, nil, nil, nil, "127.0.0.1:10000"}, args{config}, false},
 		{"ok-no-secret", fields{"oidc", "name", "client-id", "", srv.URL, nil, nil, nil, ""}, args{config}, false},
+		{"ok-offline", fields{"oidc", "name", "client-id", "client-secret", dataConfiguration, nil, nil, nil, ""}, args{config}, false},
 		{"no-name", fields{"oidc", "", "client-id", "client-secret", srv.URL, nil, nil, nil, ""}, args{config}, true},
 		{"no-type", fields{"", "name", "client-id", "client-secret", srv.URL, nil, nil, nil, ""}, args{config}, true},
 		{"no-client-id", fields{"oidc", "name", "", "client-secret", srv.URL, nil, nil, nil, ""}, args{config}, true},
@@ -128,22 +150,143 @@ func TestOIDC_Init(t *testing.T) {
 				return
 			}
 			if tt.wantErr == false {
+				jwksURI := srv.URL + "/jwks_uri"
+				if tt.fields.ConfigurationEndpoint == dataConfiguration {
+					jwksURI = "file://" + jwksFile
+				}
 				assert.Len(t, 2, p.keyStore.keySet.Keys)
 				assert.Equals(t, openIDConfiguration{
 					Issuer:    "the-issuer",
-					JWKSetURI: srv.URL + "/jwks_uri",
+					JWKSetURI: jwksURI,
 				}, p.configuration)
 			}
 		})
 	}
 }
 
+func TestOIDC_rediscover(t *testing.T) {
+	var rotated int32
+	srv := generateJWKServer(2)
+	defer srv.Close()
+	discoverySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwksURI := srv.URL + "/jwks_uri"
+		if atomic.LoadInt32(&rotated) == 1 {
+			jwksURI = srv.URL + "/random"
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		json.NewEncoder(w).Encode(openIDConfiguration{Issuer: "the-issuer", JWKSetURI: jwksURI})
+	}))
+	defer discoverySrv.Close()
+
+	p := &OIDC{
+		Type:                  "oidc",
+		Name:                  "name",
+		ClientID:              "client-id",
+		ConfigurationEndpoint: discoverySrv.URL,
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims}))
+	configuration, ks := p.getConfiguration()
+	assert.Equals(t, srv.URL+"/jwks_uri", configuration.JWKSetURI)
+	assert.Equals(t, srv.URL+"/jwks_uri", ks.uri)
+	oldKS := ks
+	assert.True(t, p.discovery.expiry.After(time.Now().Add(minDiscoveryAge-defaultCacheJitter)))
+
+	// The configuration is not reloaded until it expires
+	atomic.StoreInt32(&rotated, 1)
+	configuration, _ = p.getConfiguration()
+	assert.Equals(t, srv.URL+"/jwks_uri", configuration.JWKSetURI)
+
+	// Fresh configurations only take the read lock
+	p.discovery.RLock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.getConfiguration()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("getConfiguration() is waiting for the write lock")
+	}
+	p.discovery.RUnlock()
+
+	// The new jwks_uri is used after the configuration expires
+	p.discovery.Lock()
+	p.discovery.expiry = time.Now().Add(-time.Second)
+	p.discovery.Unlock()
+	p.getConfiguration()
+	for i := 0; i < 100; i++ {
+		if configuration, ks = p.getConfiguration(); configuration.JWKSetURI != srv.URL+"/jwks_uri" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equals(t, srv.URL+"/random", configuration.JWKSetURI)
+	assert.Equals(t, srv.URL+"/random", ks.uri)
+
+	// The previous keyStore is released
+	assert.True(t, oldKS.isClosed())
+	keyStores.Lock()
+	_, ok := keyStores.m[oldKS.key]
+	keyStores.Unlock()
+	assert.False(t, ok)
+	assert.False(t, ks.isClosed())
+
+	// Failed discoveries keep the current configuration
+	discoverySrv.Close()
+	p.discovery.Lock()
+	p.discovery.expiry = time.Now().Add(-time.Second)
+	p.discovery.Unlock()
+	p.getConfiguration()
+	for i := 0; i < 100; i++ {
+		p.discovery.RLock()
+		failures := p.discovery.failures
+		p.discovery.RUnlock()
+		if failures > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	configuration, ks = p.getConfiguration()
+	assert.Equals(t, 1, p.discovery.failures)
+	assert.Equals(t, srv.URL+"/random", configuration.JWKSetURI)
+	assert.Equals(t, srv.URL+"/random", ks.uri)
+	assert.False(t, ks.isClosed())
+
+	// Closing the provisioner releases the current keyStore
+	assert.FatalError(t, p.Close())
+	assert.FatalError(t, p.Close())
+	assert.True(t, ks.isClosed())
+}
+
+func Test_getDiscoveryDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		age  time.Duration
+		min  time.Duration
+		max  time.Duration
+	}{
+		{"zero", 0, minDiscoveryAge * 2 / 3, minDiscoveryAge},
+		{"hour", time.Hour, 40 * time.Minute, time.Hour},
+		{"day", 24 * time.Hour, 23 * time.Hour, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				if got := getDiscoveryDuration(tt.age); got < tt.min || got > tt.max {
+					t.Errorf("getDiscoveryDuration() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
 func TestOIDC_authorizeToken(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
 
 	var keys jose.JSONWebKeySet
-	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
 
 	// Create test provisioners
 	p1, err := generateOIDC()
@@ -226,7 +369,7 @@ func TestOIDC_authorizeToken(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.prov.authorizeToken(tt.args.token)
+			got, err := tt.prov.authorizeToken(context.Background(), tt.args.token)
 			if (err != nil) != tt.wantErr {
 				fmt.Println(tt)
 				t.Errorf("OIDC.Authorize() error = %v, wantErr %v", err, tt.wantErr)
@@ -250,7 +393,7 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 	defer srv.Close()
 
 	var keys jose.JSONWebKeySet
-	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
 
 	// Create test provisioners
 	p1, err := generateOIDC()
@@ -310,9 +453,9 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 			} else {
 				if assert.NotNil(t, got) {
 					if tt.name == "admin" {
-						assert.Len(t, 4, got)
+						assert.Len(t, 7, got)
 					} else {
-						assert.Len(t, 5, got)
+						assert.Len(t, 8, got)
 					}
 					for _, o := range got {
 						switch v := o.(type) {
@@ -323,12 +466,18 @@ func TestOIDC_AuthorizeSign(t *testing.T) {
 							assert.Len(t, 0, v.KeyValuePairs)
 						case profileDefaultDuration:
 							assert.Equals(t, time.Duration(v), tt.prov.claimer.DefaultTLSCertDuration())
+						case backdateOption:
+							assert.Equals(t, time.Duration(v), tt.prov.claimer.Backdate())
 						case defaultPublicKeyValidator:
 						case *validityValidator:
 							assert.Equals(t, v.min, tt.prov.claimer.MinTLSCertDuration())
 							assert.Equals(t, v.max, tt.prov.claimer.MaxTLSCertDuration())
+						case *sanDurationValidator:
+							assert.Equals(t, v.claimer, tt.prov.claimer)
 						case emailOnlyIdentity:
 							assert.Equals(t, string(v), "name@smallstep.com")
+						case x509ProfileValidator:
+							assert.Len(t, 0, v)
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
@@ -344,7 +493,7 @@ func TestOIDC_AuthorizeRevoke(t *testing.T) {
 	defer srv.Close()
 
 	var keys jose.JSONWebKeySet
-	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
 
 	// Create test provisioners
 	p1, err := generateOIDC()
@@ -401,6 +550,54 @@ func TestOIDC_AuthorizeRevoke(t *testing.T) {
 	}
 }
 
+func TestOIDC_AuthorizeIdentity(t *testing.T) {
+	srv := generateJWKServer(2)
+	defer srv.Close()
+
+	var keys jose.JSONWebKeySet
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
+
+	p1, err := generateOIDC()
+	assert.FatalError(t, err)
+	p1.Domains = []string{"smallstep.com"}
+	p1.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
+	assert.FatalError(t, p1.Init(Config{Claims: globalProvisionerClaims}))
+
+	ok, err := generateToken("subject", "the-issuer", p1.ClientID, "Name@SmallStep.com", []string{}, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+	failDomain, err := generateToken("subject", "the-issuer", p1.ClientID, "name@example.com", []string{}, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+	failAudience, err := generateToken("subject", "the-issuer", "foo", "name@smallstep.com", []string{}, time.Now(), &keys.Keys[0])
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{"ok", ok, "Name@smallstep.com", false},
+		{"fail-domain", failDomain, "", true},
+		{"fail-audience", failAudience, "", true},
+		{"fail-token", "foo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p1.AuthorizeIdentity(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OIDC.AuthorizeIdentity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
 func TestOIDC_AuthorizeRenew(t *testing.T) {
 	p1, err := generateOIDC()
 	assert.FatalError(t, err)
@@ -423,8 +620,8 @@ func TestOIDC_AuthorizeRenew(t *testing.T) {
 		code    int
 		wantErr bool
 	}{
-		{"ok", p1, args{nil}, http.StatusOK, false},
-		{"fail/renew-disabled", p2, args{nil}, http.StatusUnauthorized, true},
+		{"ok", p1, args{generateRenewCertificate()}, http.StatusOK, false},
+		{"fail/renew-disabled", p2, args{generateRenewCertificate()}, http.StatusUnauthorized, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -448,7 +645,7 @@ func TestOIDC_AuthorizeSSHSign(t *testing.T) {
 	defer srv.Close()
 
 	var keys jose.JSONWebKeySet
-	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
 
 	// Create test provisioners
 	p1, err := generateOIDC()
@@ -616,7 +813,7 @@ func TestOIDC_AuthorizeSSHRevoke(t *testing.T) {
 	srv := generateJWKServer(2)
 	defer srv.Close()
 	var keys jose.JSONWebKeySet
-	assert.FatalError(t, getAndDecode(srv.URL+"/private", &keys))
+	assert.FatalError(t, getAndDecode(context.Background(), nil, srv.URL+"/private", &keys))
 
 	config := Config{Claims: globalProvisionerClaims}
 	p1.ConfigurationEndpoint = srv.URL + "/.well-known/openid-configuration"
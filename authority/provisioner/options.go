@@ -0,0 +1,741 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+var oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// CertificateOptions are the per-provisioner options used to customize the
+// certificates signed by a provisioner.
+//
+// X509 is the template used to customize the X.509 certificates, and SSH the
+// one used for the SSH certificates. Webhooks are the external services called
+// before signing a certificate to authorize the request or to add data to the
+// templates. Policy are the names allowed or denied in the certificates, they
+// are checked after the templates are applied and on renewals. Profiles are
+// the names of the authority profiles that can be requested, by default only
+// the default leaf profile is allowed. CAA configures the checks of the CAA records of the
+// authority for the provisioner.
+type CertificateOptions struct {
+	X509     *TemplateOptions `json:"x509,omitempty"`
+	SSH      *TemplateOptions `json:"ssh,omitempty"`
+	Webhooks []*Webhook       `json:"webhooks,omitempty"`
+	Policy   *policy.Options  `json:"policy,omitempty"`
+	Profiles []string         `json:"profiles,omitempty"`
+	CAA      *CAAOptions      `json:"caa,omitempty"`
+	policy   *policy.Policy
+}
+
+// CAAOptions are the per-provisioner options of the CAA checks configured in
+// the authority. Enabled can disable the checks for a provisioner, they are
+// enabled by default, and Bypass are additional zones that are not checked.
+//
+// CAAOptions is also a SignOption used by the authority to check the CAA
+// records.
+type CAAOptions struct {
+	Enabled *bool    `json:"enabled,omitempty"`
+	Bypass  []string `json:"bypass,omitempty"`
+}
+
+// IsEnabled returns true if the CAA checks are enabled, nil is ok.
+func (o *CAAOptions) IsEnabled() bool {
+	if o == nil || o.Enabled == nil {
+		return true
+	}
+	return *o.Enabled
+}
+
+// TemplateOptions defines a certificate template.
+//
+// Template is an inline template, and TemplateFile the path of a file with the
+// template in the templates directory of the authority, only one of them can
+// be set. Templates are rendered using the Go text/template package and the
+// sprig functions, except the ones that read the environment, and the output
+// must be a JSON object with the certificate fields to set.
+//
+// TemplateData are additional variables available in the template.
+type TemplateOptions struct {
+	Template     string                 `json:"template,omitempty"`
+	TemplateFile string                 `json:"templateFile,omitempty"`
+	TemplateData map[string]interface{} `json:"templateData,omitempty"`
+	tmpl         *template.Template
+}
+
+// TemplateData is the data used to render a certificate template. Besides the
+// variables configured in the provisioner it contains:
+//
+//   - Token: the claims of the token used, if any.
+//   - Subject: the subject of the token or the identity of the requester.
+//   - SANs: the SANs authorized by the provisioner.
+//   - Webhooks: the data returned by the enriching webhooks, by webhook name.
+//   - Groups: the groups of the user, only in OIDC provisioners.
+//   - Insecure: the values requested by the user. For X.509 certificates
+//     Insecure.CSR contains the subject and SANs in the certificate request.
+//     For SSH certificates Insecure.User contains the type, key id and
+//     principals requested.
+type TemplateData map[string]interface{}
+
+// reservedTemplateData are the keys that cannot be used in the template data
+// configured in a provisioner.
+var reservedTemplateData = []string{"Token", "Subject", "SANs", "Webhooks", "Groups", "Insecure"}
+
+// newTemplateData returns the data for a certificate template with the
+// given token and identity. The token claims are only added if the token is a
+// JWT, callers must have validated the token before.
+func newTemplateData(token, subject string, sans []string) TemplateData {
+	data := TemplateData{
+		"Subject": subject,
+		"SANs":    sans,
+	}
+	if jwt, err := jose.ParseSigned(token); err == nil {
+		var claims map[string]interface{}
+		if err := jwt.UnsafeClaimsWithoutVerification(&claims); err == nil {
+			data["Token"] = claims
+		}
+	}
+	return data
+}
+
+// load validates the certificate options and loads the templates, nil is ok.
+func (o *CertificateOptions) load(config Config) error {
+	if o == nil {
+		return nil
+	}
+	if err := o.X509.load("options.x509", config); err != nil {
+		return err
+	}
+	if err := o.SSH.load("options.ssh", config); err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(o.Webhooks))
+	for i, w := range o.Webhooks {
+		if err := w.init(config); err != nil {
+			return errors.Wrapf(err, "error validating options.webhooks[%d]", i)
+		}
+		if names[w.Name] {
+			return errors.Errorf("options.webhooks contains the name %s more than once", w.Name)
+		}
+		names[w.Name] = true
+	}
+	for _, name := range o.Profiles {
+		if name == "" {
+			return errors.New("options.profiles cannot contain an empty value")
+		}
+	}
+	if o.CAA != nil {
+		for _, s := range o.CAA.Bypass {
+			if strings.Trim(s, ".") == "" {
+				return errors.New("options.caa.bypass cannot contain an empty value")
+			}
+		}
+	}
+	p, err := policy.New(o.Policy)
+	if err != nil {
+		return errors.Wrap(err, "error validating options.policy")
+	}
+	o.policy = p
+	return nil
+}
+
+// load validates the template options and parses the template.
+func (o *TemplateOptions) load(name string, config Config) error {
+	if o == nil {
+		return nil
+	}
+	for _, k := range reservedTemplateData {
+		if _, ok := o.TemplateData[k]; ok {
+			return errors.Errorf("%s.templateData cannot contain the reserved property '%s'", name, k)
+		}
+	}
+
+	var text string
+	switch {
+	case o.Template != "" && o.TemplateFile != "":
+		return errors.Errorf("%s.template and %s.templateFile cannot be set at the same time", name, name)
+	case o.Template != "":
+		text = o.Template
+	case o.TemplateFile != "":
+		b, err := readTemplateFile(o.TemplateFile, config)
+		if err != nil {
+			return errors.Wrapf(err, "error loading %s.templateFile", name)
+		}
+		text = string(b)
+	default:
+		return errors.Errorf("%s.template or %s.templateFile must be set", name, name)
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs()).Parse(text)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing %s template", name)
+	}
+	o.tmpl = tmpl
+	return nil
+}
+
+// templateFuncs returns the sprig functions without the ones that read the
+// environment of the CA, which might contain secrets.
+func templateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	return funcs
+}
+
+// readTemplateFile reads a template from a file in the templates directory.
+// Relative paths are relative to the directory, and paths that resolve,
+// following the symbolic links, to a file outside it are rejected.
+func readTemplateFile(name string, config Config) ([]byte, error) {
+	if config.TemplatesDir == "" {
+		return nil, errors.New("templateFile requires the templatesDirectory of the authority")
+	}
+	dir, err := filepath.Abs(config.TemplatesDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", config.TemplatesDir)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", config.TemplatesDir)
+	}
+	filename := name
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	filename, err = filepath.EvalSymlinks(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", name)
+	}
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, errors.Errorf("%s is not in the templates directory", name)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", name)
+	}
+	return b, nil
+}
+
+// render executes the template with the configured and the given data and
+// decodes the resulting JSON object into v.
+func (o *TemplateOptions) render(data TemplateData, v interface{}) error {
+	merged := make(map[string]interface{}, len(o.TemplateData)+len(data))
+	for k, d := range o.TemplateData {
+		merged[k] = d
+	}
+	for k, d := range data {
+		merged[k] = d
+	}
+
+	buf := new(bytes.Buffer)
+	if err := o.tmpl.Execute(buf, merged); err != nil {
+		return errors.Wrapf(err, "error executing %s template", o.tmpl.Name())
+	}
+	dec := json.NewDecoder(buf)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.Wrapf(err, "error unmarshaling %s template", o.tmpl.Name())
+	}
+	return nil
+}
+
+// withX509CertificateOptions appends to the given sign options the validator
+// that checks the requested profile, the validator that calls the X.509
+// webhooks, the modifier that applies the X.509 template and the validator
+// that checks the policy in the certificate options, if they are configured,
+// and the CAA options used by the authority. The webhooks add their data to
+// the given template data before the template is rendered.
+func withX509CertificateOptions(o *CertificateOptions, so []SignOption, data TemplateData) []SignOption {
+	if o == nil {
+		return append(so, x509ProfileValidator(nil))
+	}
+	// The profile is checked before calling the webhooks.
+	so = append(so, x509ProfileValidator(o.Profiles))
+	if hooks := o.webhooks(WebhookCertTypeX509); len(hooks) > 0 {
+		so = append(so, &x509WebhookValidator{webhooks: hooks, data: data})
+	}
+	if o.X509 != nil && o.X509.tmpl != nil {
+		so = append(so, &x509TemplateModifier{options: o.X509, data: data})
+	}
+	if o.policy != nil {
+		so = append(so, &x509PolicyValidator{policy: o.policy})
+	}
+	if o.CAA != nil {
+		so = append(so, o.CAA)
+	}
+	return so
+}
+
+// withSSHCertificateOptions appends to the given sign options the modifiers
+// that call the SSH webhooks, apply the SSH template and check the policy in
+// the certificate options, if they are configured.
+func withSSHCertificateOptions(o *CertificateOptions, so []SignOption, data TemplateData) []SignOption {
+	if o == nil {
+		return so
+	}
+	if hooks := o.webhooks(WebhookCertTypeSSH); len(hooks) > 0 {
+		so = append(so, &sshWebhookModifier{webhooks: hooks, data: data})
+	}
+	if o.SSH != nil && o.SSH.tmpl != nil {
+		so = append(so, &sshTemplateModifier{options: o.SSH, data: data})
+	}
+	if o.policy != nil {
+		so = append(so, &sshPolicyModifier{policy: o.policy})
+	}
+	return so
+}
+
+// authorizeRenew checks the names of the certificate to renew against the
+// policy in the certificate options, nil is ok.
+func (o *CertificateOptions) authorizeRenew(cert *x509.Certificate) error {
+	if o == nil {
+		return nil
+	}
+	return o.policy.AllowX509(cert)
+}
+
+// webhooks returns the webhooks used for the given certificate type.
+func (o *CertificateOptions) webhooks(certType string) []*Webhook {
+	var hooks []*Webhook
+	for _, w := range o.Webhooks {
+		if w.CertType == "" || strings.EqualFold(w.CertType, certType) {
+			hooks = append(hooks, w)
+		}
+	}
+	return hooks
+}
+
+// x509Template is the JSON representation of the fields of an X.509
+// certificate that can be set in a template. Only the fields present in the
+// template are modified.
+type x509Template struct {
+	Subject        *x509TemplateSubject    `json:"subject,omitempty"`
+	DNSNames       []string                `json:"dnsNames,omitempty"`
+	EmailAddresses []string                `json:"emailAddresses,omitempty"`
+	IPAddresses    []string                `json:"ipAddresses,omitempty"`
+	URIs           []string                `json:"uris,omitempty"`
+	KeyUsage       []string                `json:"keyUsage,omitempty"`
+	ExtKeyUsage    []string                `json:"extKeyUsage,omitempty"`
+	Extensions     []x509TemplateExtension `json:"extensions,omitempty"`
+}
+
+// x509TemplateSubject is the JSON representation of the certificate subject.
+type x509TemplateSubject struct {
+	CommonName         string   `json:"commonName,omitempty"`
+	SerialNumber       string   `json:"serialNumber,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	StreetAddress      []string `json:"streetAddress,omitempty"`
+	PostalCode         []string `json:"postalCode,omitempty"`
+}
+
+// x509TemplateExtension is the JSON representation of a certificate
+// extension, the value is the base64 encoded DER value.
+type x509TemplateExtension struct {
+	ID       string `json:"id"`
+	Critical bool   `json:"critical,omitempty"`
+	Value    []byte `json:"value"`
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"ipsecEndSystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsecTunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecUser":       x509.ExtKeyUsageIPSECUser,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// newX509TemplateFromCertificate returns the template representation of the
+// subject and SANs of the given certificate.
+func newX509TemplateFromCertificate(crt *x509.Certificate) *x509Template {
+	t := &x509Template{
+		Subject: &x509TemplateSubject{
+			CommonName:         crt.Subject.CommonName,
+			SerialNumber:       crt.Subject.SerialNumber,
+			Country:            crt.Subject.Country,
+			Organization:       crt.Subject.Organization,
+			OrganizationalUnit: crt.Subject.OrganizationalUnit,
+			Locality:           crt.Subject.Locality,
+			Province:           crt.Subject.Province,
+			StreetAddress:      crt.Subject.StreetAddress,
+			PostalCode:         crt.Subject.PostalCode,
+		},
+		DNSNames:       crt.DNSNames,
+		EmailAddresses: crt.EmailAddresses,
+	}
+	for _, ip := range crt.IPAddresses {
+		t.IPAddresses = append(t.IPAddresses, ip.String())
+	}
+	for _, u := range crt.URIs {
+		t.URIs = append(t.URIs, u.String())
+	}
+	return t
+}
+
+// apply sets in the certificate the fields present in the template.
+func (t *x509Template) apply(crt *x509.Certificate) error {
+	if s := t.Subject; s != nil {
+		crt.Subject = pkix.Name{
+			CommonName:         s.CommonName,
+			SerialNumber:       s.SerialNumber,
+			Country:            s.Country,
+			Organization:       s.Organization,
+			OrganizationalUnit: s.OrganizationalUnit,
+			Locality:           s.Locality,
+			Province:           s.Province,
+			StreetAddress:      s.StreetAddress,
+			PostalCode:         s.PostalCode,
+		}
+	}
+	if t.DNSNames != nil {
+		crt.DNSNames = t.DNSNames
+	}
+	if t.EmailAddresses != nil {
+		crt.EmailAddresses = t.EmailAddresses
+	}
+	if t.IPAddresses != nil {
+		ips := make([]net.IP, len(t.IPAddresses))
+		for i, s := range t.IPAddresses {
+			if ips[i] = net.ParseIP(s); ips[i] == nil {
+				return errors.Errorf("template ipAddresses contains an invalid IP address %s", s)
+			}
+		}
+		crt.IPAddresses = ips
+	}
+	if t.URIs != nil {
+		uris := make([]*url.URL, len(t.URIs))
+		for i, s := range t.URIs {
+			u, err := url.Parse(s)
+			if err != nil {
+				return errors.Wrapf(err, "template uris contains an invalid URI %s", s)
+			}
+			uris[i] = u
+		}
+		crt.URIs = uris
+	}
+	if t.KeyUsage != nil {
+		var ku x509.KeyUsage
+		for _, s := range t.KeyUsage {
+			v, ok := keyUsageNames[s]
+			if !ok {
+				return errors.Errorf("template keyUsage contains an unsupported value %s", s)
+			}
+			ku |= v
+		}
+		crt.KeyUsage = ku
+	}
+	if t.ExtKeyUsage != nil {
+		crt.ExtKeyUsage, crt.UnknownExtKeyUsage = nil, nil
+		for _, s := range t.ExtKeyUsage {
+			if v, ok := extKeyUsageNames[s]; ok {
+				crt.ExtKeyUsage = append(crt.ExtKeyUsage, v)
+				continue
+			}
+			oid, err := parseObjectIdentifier(s)
+			if err != nil {
+				return errors.Errorf("template extKeyUsage contains an unsupported value %s", s)
+			}
+			crt.UnknownExtKeyUsage = append(crt.UnknownExtKeyUsage, oid)
+		}
+	}
+	for _, e := range t.Extensions {
+		oid, err := parseObjectIdentifier(e.ID)
+		if err != nil {
+			return errors.Wrapf(err, "template extension id %s is not valid", e.ID)
+		}
+		if oid.Equal(stepOIDProvisioner) || oid.Equal(oidExtensionBasicConstraints) {
+			return errors.Errorf("template cannot set the extension %s", e.ID)
+		}
+		crt.ExtraExtensions = append(crt.ExtraExtensions, pkix.Extension{
+			Id:       oid,
+			Critical: e.Critical,
+			Value:    e.Value,
+		})
+	}
+	return nil
+}
+
+// parseObjectIdentifier parses an object identifier in dot notation.
+func parseObjectIdentifier(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("invalid object identifier %s", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid object identifier %s", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// x509TemplateModifier is a ProfileModifier that renders the X.509 template of
+// a provisioner and applies it to the certificate.
+type x509TemplateModifier struct {
+	options *TemplateOptions
+	data    TemplateData
+}
+
+func (m *x509TemplateModifier) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		data := make(TemplateData, len(m.data)+1)
+		for k, v := range m.data {
+			data[k] = v
+		}
+		data["Insecure"] = map[string]interface{}{
+			"CSR": newX509TemplateFromCertificate(crt),
+		}
+		var t x509Template
+		if err := m.options.render(data, &t); err != nil {
+			return err
+		}
+		return t.apply(crt)
+	}
+}
+
+// sshTemplate is the JSON representation of the fields of an SSH certificate
+// that can be set in a template. Only the fields present in the template are
+// modified.
+//
+// ForceCommand and SourceAddress set the force-command and source-address
+// critical options, and Permit replaces the permit-* extensions with the given
+// ones, e.g. "pty" or "port-forwarding". They are applied after
+// CriticalOptions and Extensions.
+type sshTemplate struct {
+	Principals      []string          `json:"principals,omitempty"`
+	CriticalOptions map[string]string `json:"criticalOptions,omitempty"`
+	Extensions      map[string]string `json:"extensions,omitempty"`
+	ForceCommand    string            `json:"forceCommand,omitempty"`
+	SourceAddress   []string          `json:"sourceAddress,omitempty"`
+	Permit          []string          `json:"permit,omitempty"`
+}
+
+// sshCriticalOptionNames are the critical options defined by OpenSSH.
+var sshCriticalOptionNames = map[string]bool{
+	"force-command":   true,
+	"source-address":  true,
+	"verify-required": true,
+}
+
+// sshExtensionNames are the extensions defined by OpenSSH, all of them are
+// flags with an empty value.
+var sshExtensionNames = map[string]bool{
+	"no-touch-required":       true,
+	"permit-X11-forwarding":   true,
+	"permit-agent-forwarding": true,
+	"permit-port-forwarding":  true,
+	"permit-pty":              true,
+	"permit-user-rc":          true,
+}
+
+// sshPermitPrefix is the prefix of the extensions set with the permit field.
+const sshPermitPrefix = "permit-"
+
+// isSSHCustomName returns true if the given critical option or extension name
+// is a custom one, custom names must have the format name@domain.
+func isSSHCustomName(name string) bool {
+	i := strings.Index(name, "@")
+	return i > 0 && i < len(name)-1
+}
+
+// validateSSHSourceAddress validates a comma separated list of IP addresses
+// and CIDR ranges.
+func validateSSHSourceAddress(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if strings.Contains(s, "/") {
+			if _, _, err := net.ParseCIDR(s); err != nil {
+				return errors.Errorf("template source-address contains an invalid CIDR %s", s)
+			}
+		} else if net.ParseIP(s) == nil {
+			return errors.Errorf("template source-address contains an invalid IP address %s", s)
+		}
+	}
+	return nil
+}
+
+// sshTemplateUser is the template representation of the values requested by
+// the user in an SSH certificate.
+type sshTemplateUser struct {
+	Type       string   `json:"type"`
+	KeyID      string   `json:"keyId"`
+	Principals []string `json:"principals"`
+}
+
+// newSSHTemplateUser returns the template representation of the type, key id
+// and principals of the given certificate.
+func newSSHTemplateUser(cert *ssh.Certificate) sshTemplateUser {
+	user := sshTemplateUser{
+		KeyID:      cert.KeyId,
+		Principals: cert.ValidPrincipals,
+	}
+	switch cert.CertType {
+	case ssh.UserCert:
+		user.Type = SSHUserCert
+	case ssh.HostCert:
+		user.Type = SSHHostCert
+	}
+	return user
+}
+
+// validate checks the names of the critical options and extensions in the
+// template, and the values of the ones defined by OpenSSH.
+func (t *sshTemplate) validate() error {
+	for k, v := range t.CriticalOptions {
+		switch {
+		case k == "source-address":
+			if err := validateSSHSourceAddress(v); err != nil {
+				return err
+			}
+		case k == "verify-required" && v != "":
+			return errors.Errorf("template critical option %s cannot have a value", k)
+		case !sshCriticalOptionNames[k] && !isSSHCustomName(k):
+			return errors.Errorf("template critical option %s is not supported, custom options must have the format name@domain", k)
+		}
+	}
+	if len(t.SourceAddress) > 0 {
+		if err := validateSSHSourceAddress(strings.Join(t.SourceAddress, ",")); err != nil {
+			return err
+		}
+	}
+	for k, v := range t.Extensions {
+		switch {
+		case sshExtensionNames[k]:
+			if v != "" {
+				return errors.Errorf("template extension %s cannot have a value", k)
+			}
+		case !isSSHCustomName(k):
+			return errors.Errorf("template extension %s is not supported, custom extensions must have the format name@domain", k)
+		}
+	}
+	for _, s := range t.Permit {
+		if !sshExtensionNames[sshPermitPrefix+s] {
+			return errors.Errorf("template permit contains an unsupported value %s", s)
+		}
+	}
+	return nil
+}
+
+// apply sets in the certificate the fields present in the template.
+func (t *sshTemplate) apply(cert *ssh.Certificate) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if t.Principals != nil {
+		cert.ValidPrincipals = t.Principals
+	}
+	if t.CriticalOptions != nil {
+		cert.CriticalOptions = t.CriticalOptions
+	}
+	if t.Extensions != nil {
+		cert.Extensions = t.Extensions
+	}
+	if t.ForceCommand != "" || len(t.SourceAddress) > 0 {
+		options := make(map[string]string, len(cert.CriticalOptions)+2)
+		for k, v := range cert.CriticalOptions {
+			options[k] = v
+		}
+		if t.ForceCommand != "" {
+			options["force-command"] = t.ForceCommand
+		}
+		if len(t.SourceAddress) > 0 {
+			options["source-address"] = strings.Join(t.SourceAddress, ",")
+		}
+		cert.CriticalOptions = options
+	}
+	if t.Permit != nil {
+		extensions := make(map[string]string, len(cert.Extensions)+len(t.Permit))
+		for k, v := range cert.Extensions {
+			if !strings.HasPrefix(k, sshPermitPrefix) {
+				extensions[k] = v
+			}
+		}
+		for _, s := range t.Permit {
+			extensions[sshPermitPrefix+s] = ""
+		}
+		cert.Extensions = extensions
+	}
+	return nil
+}
+
+// sshTemplateModifier is an SSHCertModifier that renders the SSH template of a
+// provisioner and applies it to the certificate. It must be the last modifier
+// of a provisioner, so it can override the default extensions.
+type sshTemplateModifier struct {
+	options *TemplateOptions
+	data    TemplateData
+}
+
+func (m *sshTemplateModifier) Modify(cert *ssh.Certificate) error {
+	data := make(TemplateData, len(m.data)+1)
+	for k, v := range m.data {
+		data[k] = v
+	}
+	data["Insecure"] = map[string]interface{}{
+		"User": newSSHTemplateUser(cert),
+	}
+	var t sshTemplate
+	if err := m.options.render(data, &t); err != nil {
+		return err
+	}
+	return t.apply(cert)
+}
+
+// x509PolicyValidator is a CertificateValidator that checks the names of the
+// certificate against the policy of a provisioner.
+type x509PolicyValidator struct {
+	policy *policy.Policy
+}
+
+func (v *x509PolicyValidator) Valid(cert *x509.Certificate, o Options) error {
+	return v.policy.AllowX509(cert)
+}
+
+// sshPolicyModifier is an SSHCertModifier that checks the principals of the
+// certificate against the policy of a provisioner. It does not modify the
+// certificate, and it must be added after the template modifier.
+type sshPolicyModifier struct {
+	policy *policy.Policy
+}
+
+func (m *sshPolicyModifier) Modify(cert *ssh.Certificate) error {
+	return m.policy.AllowSSH(cert)
+}
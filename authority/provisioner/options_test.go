@@ -0,0 +1,386 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/cli/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateOptions_load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "options")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	tplDir := filepath.Join(dir, "templates")
+	assert.FatalError(t, os.Mkdir(tplDir, 0700))
+	filename := filepath.Join(tplDir, "x509.tpl")
+	assert.FatalError(t, ioutil.WriteFile(filename, []byte(`{"subject": {"commonName": "{{ .Subject }}"}}`), 0600))
+	assert.FatalError(t, ioutil.WriteFile(filepath.Join(tplDir, "ssh.tpl"), []byte(`{"principals": {{ toJson .SANs }}}`), 0600))
+	outside := filepath.Join(dir, "outside.tpl")
+	assert.FatalError(t, ioutil.WriteFile(outside, []byte(`{}`), 0600))
+	assert.FatalError(t, os.Symlink(outside, filepath.Join(tplDir, "link.tpl")))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	config := Config{TemplatesDir: tplDir}
+	tests := []struct {
+		name    string
+		options *CertificateOptions
+		config  Config
+		wantErr bool
+	}{
+		{"ok/nil", nil, config, false},
+		{"ok/empty", &CertificateOptions{}, config, false},
+		{"ok/template", &CertificateOptions{X509: &TemplateOptions{Template: `{"dnsNames": {{ toJson .SANs }}}`}}, config, false},
+		{"ok/file", &CertificateOptions{X509: &TemplateOptions{TemplateFile: filename}}, config, false},
+		{"ok/relative-file", &CertificateOptions{SSH: &TemplateOptions{TemplateFile: "ssh.tpl"}}, config, false},
+		{"ok/data", &CertificateOptions{X509: &TemplateOptions{Template: `{}`, TemplateData: map[string]interface{}{"Organization": "Smallstep"}}}, config, false},
+		{"fail/empty", &CertificateOptions{X509: &TemplateOptions{}}, config, true},
+		{"fail/both", &CertificateOptions{X509: &TemplateOptions{Template: `{}`, TemplateFile: filename}}, config, true},
+		{"fail/parse", &CertificateOptions{X509: &TemplateOptions{Template: `{{ .Subject `}}, config, true},
+		{"fail/env", &CertificateOptions{X509: &TemplateOptions{Template: `{"subject": {"commonName": "{{ env "HOME" }}"}}`}}, config, true},
+		{"fail/expandenv", &CertificateOptions{X509: &TemplateOptions{Template: `{"subject": {"commonName": "{{ expandenv "$HOME" }}"}}`}}, config, true},
+		{"fail/missing-file", &CertificateOptions{X509: &TemplateOptions{TemplateFile: filepath.Join(tplDir, "missing.tpl")}}, config, true},
+		{"fail/no-templates-dir", &CertificateOptions{X509: &TemplateOptions{TemplateFile: filename}}, Config{}, true},
+		{"fail/outside-file", &CertificateOptions{X509: &TemplateOptions{TemplateFile: outside}}, config, true},
+		{"fail/outside-relative-file", &CertificateOptions{X509: &TemplateOptions{TemplateFile: "../outside.tpl"}}, config, true},
+		{"fail/outside-symlink", &CertificateOptions{X509: &TemplateOptions{TemplateFile: "link.tpl"}}, config, true},
+		{"fail/url", &CertificateOptions{SSH: &TemplateOptions{TemplateFile: srv.URL + "/ssh.tpl"}}, config, true},
+		{"fail/reserved", &CertificateOptions{SSH: &TemplateOptions{Template: `{}`, TemplateData: map[string]interface{}{"Token": "foo"}}}, config, true},
+		{"ok/policy", &CertificateOptions{Policy: &policy.Options{X509: &policy.X509Options{Allow: &policy.X509Names{DNS: []string{".smallstep.com"}}}}}, config, false},
+		{"fail/policy", &CertificateOptions{Policy: &policy.Options{X509: &policy.X509Options{Deny: &policy.X509Names{IP: []string{"1.2.3"}}}}}, config, true},
+		{"ok/caa", &CertificateOptions{CAA: &CAAOptions{Bypass: []string{"internal"}}}, config, false},
+		{"fail/caa", &CertificateOptions{CAA: &CAAOptions{Bypass: []string{"."}}}, config, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.load(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("CertificateOptions.load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCAAOptions_IsEnabled(t *testing.T) {
+	enabled, disabled := true, false
+	assert.True(t, (*CAAOptions)(nil).IsEnabled())
+	assert.True(t, (&CAAOptions{}).IsEnabled())
+	assert.True(t, (&CAAOptions{Enabled: &enabled}).IsEnabled())
+	assert.False(t, (&CAAOptions{Enabled: &disabled}).IsEnabled())
+}
+
+func Test_newTemplateData(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := decryptJSONWebKey(p.EncryptedKey)
+	assert.FatalError(t, err)
+	token, err := generateSimpleToken(p.Name, testAudiences.Sign[0], key)
+	assert.FatalError(t, err)
+
+	data := newTemplateData(token, "subject", []string{"foo.smallstep.com"})
+	assert.Equals(t, "subject", data["Subject"])
+	assert.Equals(t, []string{"foo.smallstep.com"}, data["SANs"])
+	claims, ok := data["Token"].(map[string]interface{})
+	assert.Fatal(t, ok, "token claims not found")
+	assert.Equals(t, p.Name, claims["iss"])
+
+	data = newTemplateData("", "subject", nil)
+	_, ok = data["Token"]
+	assert.False(t, ok)
+}
+
+func Test_x509TemplateModifier_Option(t *testing.T) {
+	u, err := url.Parse("spiffe://smallstep.com/foo")
+	assert.FatalError(t, err)
+	newCert := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "foo"},
+			DNSNames:    []string{"foo.smallstep.com"},
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+		want     *x509.Certificate
+		wantErr  bool
+	}{
+		{"ok/empty", `{}`, nil, newCert(), false},
+		{"ok/subject", `{"subject": {"commonName": "{{ .Subject }}", "organization": ["{{ .Organization }}"]}}`,
+			map[string]interface{}{"Organization": "Smallstep"}, &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "subject", Organization: []string{"Smallstep"}},
+				DNSNames:    []string{"foo.smallstep.com"},
+				KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			}, false},
+		{"ok/sans", `{"dnsNames": {{ toJson .Insecure.CSR.DNSNames }}, "ipAddresses": ["127.0.0.1"], "emailAddresses": [], "uris": ["spiffe://smallstep.com/foo"]}`,
+			nil, &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "foo"},
+				DNSNames:       []string{"foo.smallstep.com"},
+				IPAddresses:    []net.IP{net.ParseIP("127.0.0.1")},
+				EmailAddresses: []string{},
+				URIs:           []*url.URL{u},
+				KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			}, false},
+		{"ok/usages", `{"keyUsage": ["digitalSignature"], "extKeyUsage": ["clientAuth", "1.2.3.4"]}`,
+			nil, &x509.Certificate{
+				Subject:            pkix.Name{CommonName: "foo"},
+				DNSNames:           []string{"foo.smallstep.com"},
+				KeyUsage:           x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				UnknownExtKeyUsage: []asn1.ObjectIdentifier{{1, 2, 3, 4}},
+			}, false},
+		{"ok/extensions", `{"extensions": [{"id": "1.2.3.4", "critical": true, "value": "BQA="}]}`,
+			nil, &x509.Certificate{
+				Subject:         pkix.Name{CommonName: "foo"},
+				DNSNames:        []string{"foo.smallstep.com"},
+				KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+				ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Critical: true, Value: []byte{5, 0}}},
+			}, false},
+		{"fail/execute", `{"subject": {"commonName": "{{ fail "bad" }}"}}`, nil, nil, true},
+		{"fail/json", `{"subject": `, nil, nil, true},
+		{"fail/unknown-field", `{"isCA": true}`, nil, nil, true},
+		{"fail/ipAddresses", `{"ipAddresses": ["foo"]}`, nil, nil, true},
+		{"fail/uris", `{"uris": ["%"]}`, nil, nil, true},
+		{"fail/keyUsage", `{"keyUsage": ["certSign"]}`, nil, nil, true},
+		{"fail/extKeyUsage", `{"extKeyUsage": ["foo"]}`, nil, nil, true},
+		{"fail/extension-id", `{"extensions": [{"id": "foo", "value": "BQA="}]}`, nil, nil, true},
+		{"fail/basicConstraints", `{"extensions": [{"id": "2.5.29.19", "value": "MAMBAf8="}]}`, nil, nil, true},
+		{"fail/provisioner", `{"extensions": [{"id": "1.3.6.1.4.1.37476.9000.64.1", "value": "BQA="}]}`, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &CertificateOptions{X509: &TemplateOptions{Template: tt.template, TemplateData: tt.data}}
+			assert.FatalError(t, o.load(Config{}))
+			so := withX509CertificateOptions(o, nil, newTemplateData("", "subject", []string{"foo.smallstep.com"}))
+			assert.Len(t, 2, so)
+			m, ok := so[1].(ProfileModifier)
+			assert.Fatal(t, ok, "sign option is not a ProfileModifier")
+
+			prof := &x509util.Leaf{}
+			prof.SetSubject(newCert())
+			err := m.Option(Options{})(prof)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("x509TemplateModifier.Option() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equals(t, tt.want, prof.Subject())
+			}
+		})
+	}
+}
+
+func Test_sshTemplateModifier_Modify(t *testing.T) {
+	newCert := func() *ssh.Certificate {
+		return &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{"permit-pty": ""},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     *ssh.Certificate
+		wantErr  bool
+	}{
+		{"ok/empty", `{}`, newCert(), false},
+		{"ok/principals", `{"principals": {{ toJson (append .Insecure.User.Principals .Subject) }}}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo", "subject"},
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{"permit-pty": ""},
+			},
+		}, false},
+		{"ok/options", `{"criticalOptions": {"force-command": "echo {{ .Insecure.User.Type }}"}, "extensions": {}}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				CriticalOptions: map[string]string{"force-command": "echo user"},
+				Extensions:      map[string]string{},
+			},
+		}, false},
+		{"ok/forceCommand", `{"forceCommand": "/usr/bin/backup {{ .Subject }}", "sourceAddress": ["10.0.0.0/8", "192.168.1.1"]}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				CriticalOptions: map[string]string{"force-command": "/usr/bin/backup subject", "source-address": "10.0.0.0/8,192.168.1.1"},
+				Extensions:      map[string]string{"permit-pty": ""},
+			},
+		}, false},
+		{"ok/forceCommand with criticalOptions", `{"criticalOptions": {"verify-required": "", "login@example.com": "foo"}, "forceCommand": "/bin/true"}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				CriticalOptions: map[string]string{"verify-required": "", "login@example.com": "foo", "force-command": "/bin/true"},
+				Extensions:      map[string]string{"permit-pty": ""},
+			},
+		}, false},
+		{"ok/permit", `{"extensions": {"permit-pty": "", "permit-user-rc": "", "team@example.com": "{{ .Webhooks.people.team }}"}, "permit": ["agent-forwarding", "X11-forwarding"]}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{"permit-agent-forwarding": "", "permit-X11-forwarding": "", "team@example.com": "engineering"},
+			},
+		}, false},
+		{"ok/permit empty", `{"permit": []}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{},
+			},
+		}, false},
+		{"ok/token", `{"extensions": {"email@example.com": {{ toJson .Token.email }}}}`, &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           "foo@smallstep.com",
+			ValidPrincipals: []string{"foo"},
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{"email@example.com": "foo@smallstep.com"},
+			},
+		}, false},
+		{"fail/json", `{`, nil, true},
+		{"fail/keyId", `{"keyId": "bar"}`, nil, true},
+		{"fail/criticalOption", `{"criticalOptions": {"no-pty": ""}}`, nil, true},
+		{"fail/verify-required", `{"criticalOptions": {"verify-required": "yes"}}`, nil, true},
+		{"fail/source-address option", `{"criticalOptions": {"source-address": "10.0.0.0/33"}}`, nil, true},
+		{"fail/sourceAddress", `{"sourceAddress": ["foo"]}`, nil, true},
+		{"fail/extension", `{"extensions": {"permit-everything": ""}}`, nil, true},
+		{"fail/extension value", `{"extensions": {"permit-pty": "yes"}}`, nil, true},
+		{"fail/custom extension", `{"extensions": {"@example.com": ""}}`, nil, true},
+		{"fail/permit", `{"permit": ["everything"]}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &CertificateOptions{SSH: &TemplateOptions{Template: tt.template}}
+			assert.FatalError(t, o.load(Config{}))
+			data := newTemplateData("", "subject", nil)
+			data["Token"] = map[string]interface{}{"email": "foo@smallstep.com"}
+			data["Webhooks"] = map[string]interface{}{"people": map[string]interface{}{"team": "engineering"}}
+			so := withSSHCertificateOptions(o, nil, data)
+			assert.Len(t, 1, so)
+			m, ok := so[0].(SSHCertModifier)
+			assert.Fatal(t, ok, "sign option is not a SSHCertModifier")
+
+			cert := newCert()
+			err := m.Modify(cert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sshTemplateModifier.Modify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equals(t, tt.want, cert)
+			}
+		})
+	}
+}
+
+func TestJWK_AuthorizeSign_template(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := decryptJSONWebKey(p.EncryptedKey)
+	assert.FatalError(t, err)
+
+	p.Options = &CertificateOptions{
+		X509: &TemplateOptions{
+			Template: `{"subject": {"commonName": "{{ .Subject }}", "organization": ["{{ .Organization }}"]}, "extKeyUsage": ["clientAuth"]}`,
+			TemplateData: map[string]interface{}{
+				"Organization": "Smallstep",
+			},
+		},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	token, err := generateToken("subject", p.Name, testAudiences.Sign[0], "", []string{"foo.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	so, err := p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+
+	// The template modifier must be the last one.
+	m, ok := so[len(so)-1].(*x509TemplateModifier)
+	assert.Fatal(t, ok, "last sign option is not the template modifier")
+	prof := &x509util.Leaf{}
+	prof.SetSubject(&x509.Certificate{DNSNames: []string{"foo.smallstep.com"}})
+	assert.FatalError(t, m.Option(Options{})(prof))
+	assert.Equals(t, pkix.Name{CommonName: "subject", Organization: []string{"Smallstep"}}, prof.Subject().Subject)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, prof.Subject().ExtKeyUsage)
+
+	p.Options = &CertificateOptions{X509: &TemplateOptions{Template: `{{ .Subject `}}
+	assert.Error(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+}
+
+func TestJWK_policy(t *testing.T) {
+	p, err := generateJWK()
+	assert.FatalError(t, err)
+	key, err := decryptJSONWebKey(p.EncryptedKey)
+	assert.FatalError(t, err)
+
+	p.Options = &CertificateOptions{
+		Policy: &policy.Options{
+			X509: &policy.X509Options{
+				Allow: &policy.X509Names{DNS: []string{".smallstep.com"}},
+			},
+			SSH: &policy.SSHOptions{
+				Deny: &policy.SSHNames{User: []string{"root"}},
+			},
+		},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	// X.509 policy validator must be the last one.
+	token, err := generateToken("foo.smallstep.com", p.Name, testAudiences.Sign[0], "", []string{"foo.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	so, err := p.AuthorizeSign(context.Background(), token)
+	assert.FatalError(t, err)
+	v, ok := so[len(so)-1].(*x509PolicyValidator)
+	assert.Fatal(t, ok, "last sign option is not the policy validator")
+	assert.NoError(t, v.Valid(&x509.Certificate{DNSNames: []string{"foo.smallstep.com"}}, Options{}))
+	assert.Error(t, v.Valid(&x509.Certificate{DNSNames: []string{"foo.example.com"}}, Options{}))
+
+	// Renewals are also checked.
+	cert := generateRenewCertificate()
+	cert.DNSNames = []string{"foo.smallstep.com"}
+	assert.NoError(t, p.AuthorizeRenew(context.Background(), cert))
+	cert.DNSNames = []string{"foo.example.com"}
+	assert.Error(t, p.AuthorizeRenew(context.Background(), cert))
+
+	// SSH policy modifier.
+	so = withSSHCertificateOptions(p.Options, nil, TemplateData{})
+	m, ok := so[len(so)-1].(*sshPolicyModifier)
+	assert.Fatal(t, ok, "last sign option is not the policy modifier")
+	assert.NoError(t, m.Modify(&ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"alice"}}))
+	assert.Error(t, m.Modify(&ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"alice", "root"}}))
+
+	var nilOptions *CertificateOptions
+	assert.NoError(t, nilOptions.authorizeRenew(&x509.Certificate{DNSNames: []string{"foo.example.com"}}))
+}
@@ -0,0 +1,118 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/lint"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+// Profile is a named set of properties of the X.509 certificates, e.g. for
+// server, client, code signing or email certificates. Profiles are defined in
+// the authority and clients can request them in the sign request.
+//
+// KeyUsage and ExtKeyUsage replace the default key usages of a certificate,
+// they use the same names as the templates. DefaultDuration is the duration
+// used if the request does not have a notAfter, and MaxDuration the maximum
+// duration of the certificates using the profile, the provisioner limits
+// still apply. X509 is an optional template applied after the key usages, and
+// Lint the lints run on the certificates using the profile instead of the
+// ones configured in the authority.
+type Profile struct {
+	Name            string           `json:"name"`
+	KeyUsage        []string         `json:"keyUsage,omitempty"`
+	ExtKeyUsage     []string         `json:"extKeyUsage,omitempty"`
+	DefaultDuration *Duration        `json:"defaultDuration,omitempty"`
+	MaxDuration     *Duration        `json:"maxDuration,omitempty"`
+	X509            *TemplateOptions `json:"x509,omitempty"`
+	Lint            *lint.Options    `json:"lint,omitempty"`
+}
+
+// Validate checks the fields of the profile, it does not load the template.
+func (p *Profile) Validate() error {
+	switch {
+	case p == nil:
+		return errors.New("profile cannot be empty")
+	case p.Name == "":
+		return errors.New("profile name cannot be empty")
+	case p.DefaultDuration != nil && p.DefaultDuration.Duration <= 0:
+		return errors.Errorf("profile %s defaultDuration must be greater than 0", p.Name)
+	case p.MaxDuration != nil && p.MaxDuration.Duration <= 0:
+		return errors.Errorf("profile %s maxDuration must be greater than 0", p.Name)
+	case p.DefaultDuration != nil && p.MaxDuration != nil && p.DefaultDuration.Duration > p.MaxDuration.Duration:
+		return errors.Errorf("profile %s defaultDuration cannot be greater than maxDuration", p.Name)
+	}
+	t := x509Template{KeyUsage: p.KeyUsage, ExtKeyUsage: p.ExtKeyUsage}
+	if err := t.apply(new(x509.Certificate)); err != nil {
+		return errors.Wrapf(err, "error validating profile %s", p.Name)
+	}
+	if err := p.Lint.Validate(); err != nil {
+		return errors.Wrapf(err, "error validating profile %s", p.Name)
+	}
+	return nil
+}
+
+// Init validates the profile and loads its template.
+func (p *Profile) Init(config Config) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return p.X509.load("profiles."+p.Name+".x509", config)
+}
+
+// ApplyDefaults sets in the options the default duration of the profile if
+// the notAfter is not set.
+func (p *Profile) ApplyDefaults(o *Options) {
+	if p.DefaultDuration != nil && o.NotAfter.IsZero() {
+		o.NotAfter.SetDuration(p.DefaultDuration.Duration)
+	}
+}
+
+// Option implements the ProfileModifier interface and sets the key usages of
+// the profile and applies its template.
+func (p *Profile) Option(Options) x509util.WithOption {
+	return func(prof x509util.Profile) error {
+		crt := prof.Subject()
+		t := x509Template{KeyUsage: p.KeyUsage, ExtKeyUsage: p.ExtKeyUsage}
+		if err := t.apply(crt); err != nil {
+			return err
+		}
+		if p.X509 != nil && p.X509.tmpl != nil {
+			return (&x509TemplateModifier{options: p.X509}).Option(Options{})(prof)
+		}
+		return nil
+	}
+}
+
+// Valid implements the CertificateValidator interface and checks the
+// duration of the certificate against the maximum duration of the profile.
+func (p *Profile) Valid(cert *x509.Certificate, o Options) error {
+	if p.MaxDuration == nil {
+		return nil
+	}
+	d := cert.NotAfter.Truncate(time.Second).Sub(cert.NotBefore.Truncate(time.Second))
+	if d > p.MaxDuration.Duration+o.Backdate {
+		return errors.Errorf("requested duration of %v is more than the maximum duration of the profile %s of %v",
+			d, p.Name, p.MaxDuration.Duration+o.Backdate)
+	}
+	return nil
+}
+
+// x509ProfileValidator is a CertificateValidator that checks that the profile
+// requested is one of the profiles allowed by a provisioner. The default leaf
+// profile, an empty one, is always allowed.
+type x509ProfileValidator []string
+
+func (v x509ProfileValidator) Valid(cert *x509.Certificate, o Options) error {
+	if o.Profile == "" {
+		return nil
+	}
+	for _, name := range v {
+		if name == o.Profile {
+			return nil
+		}
+	}
+	return errors.Errorf("profile %s is not allowed", o.Profile)
+}
@@ -0,0 +1,100 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+func TestProfile_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       *Profile
+		wantErr bool
+	}{
+		{"ok", &Profile{Name: "server", KeyUsage: []string{"digitalSignature"}, ExtKeyUsage: []string{"serverAuth", "1.2.3.4"}}, false},
+		{"ok/durations", &Profile{Name: "client", DefaultDuration: &Duration{Duration: time.Hour}, MaxDuration: &Duration{Duration: 24 * time.Hour}}, false},
+		{"fail/nil", nil, true},
+		{"fail/name", &Profile{}, true},
+		{"fail/keyUsage", &Profile{Name: "server", KeyUsage: []string{"certSign"}}, true},
+		{"fail/extKeyUsage", &Profile{Name: "server", ExtKeyUsage: []string{"foo"}}, true},
+		{"fail/defaultDuration", &Profile{Name: "server", DefaultDuration: &Duration{}}, true},
+		{"fail/maxDuration", &Profile{Name: "server", MaxDuration: &Duration{Duration: -time.Hour}}, true},
+		{"fail/defaultDuration-maxDuration", &Profile{Name: "server", DefaultDuration: &Duration{Duration: 2 * time.Hour}, MaxDuration: &Duration{Duration: time.Hour}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Profile.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfile_Init(t *testing.T) {
+	p := &Profile{Name: "email", X509: &TemplateOptions{Template: `{"emailAddresses": {{ toJson .Insecure.CSR.EmailAddresses }}}`}}
+	assert.FatalError(t, p.Init(Config{}))
+	assert.NotNil(t, p.X509.tmpl)
+
+	p = &Profile{Name: "email", X509: &TemplateOptions{}}
+	assert.Error(t, p.Init(Config{}))
+}
+
+func TestProfile_Option(t *testing.T) {
+	p := &Profile{
+		Name:        "codeSigning",
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"codeSigning"},
+		X509:        &TemplateOptions{Template: `{"subject": {"commonName": "{{ .Insecure.CSR.Subject.CommonName }}", "organization": ["Smallstep"]}}`},
+	}
+	assert.FatalError(t, p.Init(Config{}))
+
+	crt := &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	crt.Subject.CommonName = "signer"
+	prof := &x509util.Leaf{}
+	prof.SetSubject(crt)
+	assert.FatalError(t, p.Option(Options{})(prof))
+	assert.Equals(t, x509.KeyUsageDigitalSignature, crt.KeyUsage)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, crt.ExtKeyUsage)
+	assert.Equals(t, "signer", crt.Subject.CommonName)
+	assert.Equals(t, []string{"Smallstep"}, crt.Subject.Organization)
+}
+
+func TestProfile_ApplyDefaults(t *testing.T) {
+	p := &Profile{Name: "client", DefaultDuration: &Duration{Duration: time.Hour}}
+	o := Options{}
+	p.ApplyDefaults(&o)
+	assert.Equals(t, time.Hour, o.NotAfter.RelativeTime(time.Time{}).Sub(time.Time{}))
+
+	now := time.Now().UTC()
+	o = Options{NotAfter: NewTimeDuration(now)}
+	p.ApplyDefaults(&o)
+	assert.Equals(t, now, o.NotAfter.Time())
+}
+
+func TestProfile_Valid(t *testing.T) {
+	now := time.Now()
+	p := &Profile{Name: "client", MaxDuration: &Duration{Duration: time.Hour}}
+	assert.FatalError(t, p.Valid(&x509.Certificate{NotBefore: now, NotAfter: now.Add(time.Hour)}, Options{}))
+	assert.FatalError(t, p.Valid(&x509.Certificate{NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Hour)}, Options{Backdate: time.Minute}))
+	assert.Error(t, p.Valid(&x509.Certificate{NotBefore: now, NotAfter: now.Add(2 * time.Hour)}, Options{}))
+	assert.FatalError(t, (&Profile{Name: "server"}).Valid(&x509.Certificate{NotBefore: now, NotAfter: now.Add(2 * time.Hour)}, Options{}))
+}
+
+func Test_x509ProfileValidator_Valid(t *testing.T) {
+	v := x509ProfileValidator{"server", "client"}
+	assert.FatalError(t, v.Valid(&x509.Certificate{}, Options{}))
+	assert.FatalError(t, v.Valid(&x509.Certificate{}, Options{Profile: "client"}))
+	assert.Error(t, v.Valid(&x509.Certificate{}, Options{Profile: "codeSigning"}))
+
+	// Only the default profile is allowed if the provisioner does not list any
+	v = x509ProfileValidator(nil)
+	assert.FatalError(t, v.Valid(&x509.Certificate{}, Options{}))
+	assert.Error(t, v.Valid(&x509.Certificate{}, Options{Profile: "client"}))
+}
@@ -27,13 +27,14 @@ type Interface interface {
 	AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error
 	AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error)
 	AuthorizeSSHRevoke(ctx context.Context, token string) error
-	AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, error)
+	AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, []SignOption, error)
 	AuthorizeSSHRekey(ctx context.Context, token string) (*ssh.Certificate, []SignOption, error)
 }
 
 // Audiences stores all supported audiences by request type.
 type Audiences struct {
 	Sign      []string
+	Renew     []string
 	Revoke    []string
 	SSHSign   []string
 	SSHRevoke []string
@@ -44,6 +45,7 @@ type Audiences struct {
 // All returns all supported audiences across all request types in one list.
 func (a Audiences) All() (auds []string) {
 	auds = a.Sign
+	auds = append(auds, a.Renew...)
 	auds = append(auds, a.Revoke...)
 	auds = append(auds, a.SSHSign...)
 	auds = append(auds, a.SSHRevoke...)
@@ -57,6 +59,7 @@ func (a Audiences) All() (auds []string) {
 func (a Audiences) WithFragment(fragment string) Audiences {
 	ret := Audiences{
 		Sign:      make([]string, len(a.Sign)),
+		Renew:     make([]string, len(a.Renew)),
 		Revoke:    make([]string, len(a.Revoke)),
 		SSHSign:   make([]string, len(a.SSHSign)),
 		SSHRevoke: make([]string, len(a.SSHRevoke)),
@@ -70,6 +73,13 @@ func (a Audiences) WithFragment(fragment string) Audiences {
 			ret.Sign[i] = s
 		}
 	}
+	for i, s := range a.Renew {
+		if u, err := url.Parse(s); err == nil {
+			ret.Renew[i] = u.ResolveReference(&url.URL{Fragment: fragment}).String()
+		} else {
+			ret.Renew[i] = s
+		}
+	}
 	for i, s := range a.Revoke {
 		if u, err := url.Parse(s); err == nil {
 			ret.Revoke[i] = u.ResolveReference(&url.URL{Fragment: fragment}).String()
@@ -141,6 +151,20 @@ const (
 	TypeK8sSA Type = 8
 	// TypeSSHPOP is used to indicate the SSHPOP provisioners.
 	TypeSSHPOP Type = 9
+	// TypeGitHubActions is used to indicate the GitHubActions provisioners.
+	TypeGitHubActions Type = 10
+	// TypeVault is used to indicate the Vault provisioners.
+	TypeVault Type = 11
+	// TypeTPM is used to indicate the TPM provisioners.
+	TypeTPM Type = 12
+	// TypeSCEP is used to indicate the SCEP provisioners.
+	TypeSCEP Type = 13
+	// TypeCMP is used to indicate the CMP provisioners.
+	TypeCMP Type = 14
+	// TypeNebula is used to indicate the Nebula provisioners.
+	TypeNebula Type = 15
+	// TypeSubCA is used to indicate the SubCA provisioners.
+	TypeSubCA Type = 16
 )
 
 // String returns the string representation of the type.
@@ -164,6 +188,20 @@ func (t Type) String() string {
 		return "K8sSA"
 	case TypeSSHPOP:
 		return "SSHPOP"
+	case TypeGitHubActions:
+		return "GitHubActions"
+	case TypeVault:
+		return "Vault"
+	case TypeTPM:
+		return "TPM"
+	case TypeSCEP:
+		return "SCEP"
+	case TypeCMP:
+		return "CMP"
+	case TypeNebula:
+		return "Nebula"
+	case TypeSubCA:
+		return "SubCA"
 	default:
 		return ""
 	}
@@ -189,6 +227,12 @@ type Config struct {
 	// GetIdentityFunc is a function that returns an identity that will be
 	// used by the provisioner to populate certificate attributes.
 	GetIdentityFunc GetIdentityFunc
+	// Proxy is the default HTTP proxy used by the provisioners that do
+	// outbound requests.
+	Proxy *ProxyOptions
+	// TemplatesDir is the directory with the template files of the
+	// certificate options.
+	TemplatesDir string
 }
 
 type provisioner struct {
@@ -212,27 +256,8 @@ func (l *List) UnmarshalJSON(data []byte) error {
 		if err := json.Unmarshal(data, &typ); err != nil {
 			return errors.Errorf("error unmarshaling provisioner")
 		}
-		var p Interface
-		switch strings.ToLower(typ.Type) {
-		case "jwk":
-			p = &JWK{}
-		case "oidc":
-			p = &OIDC{}
-		case "gcp":
-			p = &GCP{}
-		case "aws":
-			p = &AWS{}
-		case "azure":
-			p = &Azure{}
-		case "acme":
-			p = &ACME{}
-		case "x5c":
-			p = &X5C{}
-		case "k8ssa":
-			p = &K8sSA{}
-		case "sshpop":
-			p = &SSHPOP{}
-		default:
+		p := newProvisionerByType(typ.Type)
+		if p == nil {
 			// Skip unsupported provisioners. A client using this method may be
 			// compiled with a version of smallstep/certificates that does not
 			// support a specific provisioner type. If we don't skip unknown
@@ -253,6 +278,64 @@ func (l *List) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Unmarshal parses the JSON representation of a single provisioner into the
+// right type. Unlike List, it fails if the type is not supported.
+func Unmarshal(data []byte) (Interface, error) {
+	var typ provisioner
+	if err := json.Unmarshal(data, &typ); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling provisioner")
+	}
+	p := newProvisionerByType(typ.Type)
+	if p == nil {
+		return nil, errors.Errorf("provisioner type '%s' is not supported", typ.Type)
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling provisioner")
+	}
+	return p, nil
+}
+
+// newProvisionerByType returns an empty provisioner of the given type, or nil
+// if the type is not supported.
+func newProvisionerByType(typ string) Interface {
+	switch strings.ToLower(typ) {
+	case "jwk":
+		return &JWK{}
+	case "oidc":
+		return &OIDC{}
+	case "gcp":
+		return &GCP{}
+	case "aws":
+		return &AWS{}
+	case "azure":
+		return &Azure{}
+	case "acme":
+		return &ACME{}
+	case "x5c":
+		return &X5C{}
+	case "k8ssa":
+		return &K8sSA{}
+	case "sshpop":
+		return &SSHPOP{}
+	case "githubactions":
+		return &GitHubActions{}
+	case "vault":
+		return &Vault{}
+	case "tpm":
+		return &TPM{}
+	case "scep":
+		return &SCEP{}
+	case "cmp":
+		return &CMP{}
+	case "nebula":
+		return &Nebula{}
+	case "subca":
+		return &SubCA{}
+	default:
+		return nil
+	}
+}
+
 var sshUserRegex = regexp.MustCompile("^[a-z][-a-z0-9_]*$")
 
 // SanitizeSSHUserPrincipal grabs an email or a string with the format
@@ -313,8 +396,8 @@ func (b *base) AuthorizeSSHRevoke(ctx context.Context, token string) error {
 
 // AuthorizeSSHRenew returns an unimplemented error. Provisioners should overwrite
 // this method if they will support authorizing tokens for renewing SSH Certificates.
-func (b *base) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, error) {
-	return nil, errs.Unauthorized("provisioner.AuthorizeSSHRenew not implemented")
+func (b *base) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, []SignOption, error) {
+	return nil, nil, errs.Unauthorized("provisioner.AuthorizeSSHRenew not implemented")
 }
 
 // AuthorizeSSHRekey returns an unimplemented error. Provisioners should overwrite
@@ -360,7 +443,7 @@ type MockProvisioner struct {
 	MauthorizeRenew     func(ctx context.Context, cert *x509.Certificate) error
 	MauthorizeRevoke    func(ctx context.Context, ott string) error
 	MauthorizeSSHSign   func(ctx context.Context, ott string) ([]SignOption, error)
-	MauthorizeSSHRenew  func(ctx context.Context, ott string) (*ssh.Certificate, error)
+	MauthorizeSSHRenew  func(ctx context.Context, ott string) (*ssh.Certificate, []SignOption, error)
 	MauthorizeSSHRekey  func(ctx context.Context, ott string) (*ssh.Certificate, []SignOption, error)
 	MauthorizeSSHRevoke func(ctx context.Context, ott string) error
 }
@@ -449,11 +532,11 @@ func (m *MockProvisioner) AuthorizeSSHSign(ctx context.Context, ott string) ([]S
 }
 
 // AuthorizeSSHRenew mock
-func (m *MockProvisioner) AuthorizeSSHRenew(ctx context.Context, ott string) (*ssh.Certificate, error) {
-	if m.MauthorizeRenew != nil {
+func (m *MockProvisioner) AuthorizeSSHRenew(ctx context.Context, ott string) (*ssh.Certificate, []SignOption, error) {
+	if m.MauthorizeSSHRenew != nil {
 		return m.MauthorizeSSHRenew(ctx, ott)
 	}
-	return m.Mret1.(*ssh.Certificate), m.Merr
+	return m.Mret1.(*ssh.Certificate), m.Mret2.([]SignOption), m.Merr
 }
 
 // AuthorizeSSHRekey mock
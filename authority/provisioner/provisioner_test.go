@@ -34,6 +34,30 @@ func TestType_String(t *testing.T) {
 	}
 }
 
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Interface
+		wantErr bool
+	}{
+		{"ok/jwk", `{"type":"JWK","name":"foo"}`, &JWK{Type: "JWK", Name: "foo"}, false},
+		{"ok/acme", `{"type":"acme","name":"bar"}`, &ACME{Type: "acme", Name: "bar"}, false},
+		{"fail/type", `{"type":"foo","name":"foo"}`, nil, true},
+		{"fail/json", `{"type":"JWK","name":1}`, nil, true},
+		{"fail/invalid", `{`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
 func TestSanitizeSSHUserPrincipal(t *testing.T) {
 	type args struct {
 		email string
@@ -143,6 +167,31 @@ func TestUnimplementedMethods(t *testing.T) {
 		{"k8ssa/sshRekey", &K8sSA{}, SSHRekeyMethod},
 		{"k8ssa/sshRenew", &K8sSA{}, SSHRenewMethod},
 		{"k8ssa/sshRevoke", &K8sSA{}, SSHRevokeMethod},
+		{"githubactions/revoke", &GitHubActions{}, RevokeMethod},
+		{"githubactions/sshRenew", &GitHubActions{}, SSHRenewMethod},
+		{"githubactions/sshRekey", &GitHubActions{}, SSHRekeyMethod},
+		{"githubactions/sshRevoke", &GitHubActions{}, SSHRevokeMethod},
+		{"vault/revoke", &Vault{}, RevokeMethod},
+		{"vault/sshRenew", &Vault{}, SSHRenewMethod},
+		{"vault/sshRekey", &Vault{}, SSHRekeyMethod},
+		{"vault/sshRevoke", &Vault{}, SSHRevokeMethod},
+		{"tpm/sshSign", &TPM{}, SSHSignMethod},
+		{"tpm/sshRenew", &TPM{}, SSHRenewMethod},
+		{"tpm/sshRekey", &TPM{}, SSHRekeyMethod},
+		{"tpm/sshRevoke", &TPM{}, SSHRevokeMethod},
+		{"scep/revoke", &SCEP{}, RevokeMethod},
+		{"scep/sshSign", &SCEP{}, SSHSignMethod},
+		{"scep/sshRenew", &SCEP{}, SSHRenewMethod},
+		{"scep/sshRekey", &SCEP{}, SSHRekeyMethod},
+		{"scep/sshRevoke", &SCEP{}, SSHRevokeMethod},
+		{"cmp/revoke", &CMP{}, RevokeMethod},
+		{"cmp/sshSign", &CMP{}, SSHSignMethod},
+		{"cmp/sshRenew", &CMP{}, SSHRenewMethod},
+		{"cmp/sshRekey", &CMP{}, SSHRekeyMethod},
+		{"cmp/sshRevoke", &CMP{}, SSHRevokeMethod},
+		{"nebula/sshRenew", &Nebula{}, SSHRenewMethod},
+		{"nebula/sshRekey", &Nebula{}, SSHRekeyMethod},
+		{"nebula/sshRevoke", &Nebula{}, SSHRevokeMethod},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -169,9 +218,13 @@ func TestUnimplementedMethods(t *testing.T) {
 				assert.Nil(t, signOpts)
 				msg = "provisioner.AuthorizeSSHSign not implemented"
 			case SSHRenewMethod:
-				var cert *ssh.Certificate
-				cert, err = tt.p.AuthorizeSSHRenew(context.Background(), "")
+				var (
+					cert     *ssh.Certificate
+					signOpts []SignOption
+				)
+				cert, signOpts, err = tt.p.AuthorizeSSHRenew(context.Background(), "")
 				assert.Nil(t, cert)
+				assert.Nil(t, signOpts)
 				msg = "provisioner.AuthorizeSSHRenew not implemented"
 			case SSHRekeyMethod:
 				var (
@@ -0,0 +1,259 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+// scepChallengeRequest is the body of the request sent to the challenge URL
+// to validate a dynamic challenge password.
+type scepChallengeRequest struct {
+	Provisioner   string `json:"provisioner"`
+	Challenge     string `json:"challenge"`
+	TransactionID string `json:"transactionID"`
+	CSR           string `json:"csr"`
+}
+
+// scepChallengeResponse is the body of the response of the challenge URL.
+type scepChallengeResponse struct {
+	Allow bool `json:"allow"`
+}
+
+// SCEP is the SCEP provisioner type, an entity that can authorize the SCEP
+// enrollment flow used by MDM platforms and network devices.
+//
+// The certificate requests are authorized using the challenge password in
+// the request. The password is compared with the static ChallengePassword, or
+// it is sent to the ChallengeURL to validate dynamic passwords, e.g. the
+// one-time passwords generated by an MDM. The SCEP messages are encrypted
+// for the decrypter certificate, if not set the CA intermediate certificate is
+// used if it has an RSA key.
+type SCEP struct {
+	*base
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// ChallengePassword is the static challenge password that the clients
+	// must include in the certificate requests.
+	ChallengePassword string `json:"challenge,omitempty"`
+	// ChallengeURL is the URL used to validate dynamic challenge passwords.
+	// The CA sends a POST request with the password, the transaction id and
+	// the certificate request, and the service must respond with a 200 status
+	// code and the JSON body {"allow": true} to authorize the request.
+	ChallengeURL string `json:"challengeURL,omitempty"`
+	// DecrypterCertificate is the PEM encoded RSA certificate used to decrypt
+	// the SCEP requests and to sign the responses.
+	DecrypterCertificate []byte `json:"decrypterCertificate,omitempty"`
+	// DecrypterKeyPEM is the PEM encoded private key of the decrypter
+	// certificate.
+	DecrypterKeyPEM []byte `json:"decrypterKeyPEM,omitempty"`
+	// DecrypterKeyPassword is the password used to decrypt the decrypter key.
+	DecrypterKeyPassword string              `json:"decrypterKeyPassword,omitempty"`
+	Claims               *Claims             `json:"claims,omitempty"`
+	Options              *CertificateOptions `json:"options,omitempty"`
+	HTTPClient           *HTTPClientOptions  `json:"httpClient,omitempty"`
+	claimer              *Claimer
+	client               *http.Client
+	decrypterCert        *x509.Certificate
+	decrypterKey         crypto.Decrypter
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *SCEP) GetID() string {
+	return "scep/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *SCEP) GetTokenID(ott string) (string, error) {
+	return "", errors.New("scep provisioner does not implement GetTokenID")
+}
+
+// GetName returns the name of the provisioner.
+func (p *SCEP) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *SCEP) GetType() Type {
+	return TypeSCEP
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (p *SCEP) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// Init initializes and validates the fields of a SCEP type.
+func (p *SCEP) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case p.ChallengePassword == "" && p.ChallengeURL == "":
+		return errors.New("provisioner challenge or challengeURL must be set")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	if p.ChallengeURL != "" {
+		u, err := url.Parse(p.ChallengeURL)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing challengeURL %s", p.ChallengeURL)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("challengeURL %s is not valid", p.ChallengeURL)
+		}
+	}
+
+	// Load the decrypter
+	p.decrypterCert, p.decrypterKey = nil, nil
+	switch {
+	case len(p.DecrypterCertificate) == 0 && len(p.DecrypterKeyPEM) == 0:
+	case len(p.DecrypterCertificate) == 0:
+		return errors.New("provisioner decrypterCertificate cannot be empty if decrypterKeyPEM is set")
+	case len(p.DecrypterKeyPEM) == 0:
+		return errors.New("provisioner decrypterKeyPEM cannot be empty if decrypterCertificate is set")
+	default:
+		block, _ := pem.Decode(p.DecrypterCertificate)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return errors.New("error parsing decrypterCertificate: no certificate found")
+		}
+		if p.decrypterCert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return errors.Wrap(err, "error parsing decrypterCertificate")
+		}
+		var opts []pemutil.Options
+		if p.DecrypterKeyPassword != "" {
+			opts = append(opts, pemutil.WithPassword([]byte(p.DecrypterKeyPassword)))
+		}
+		key, err := pemutil.ParseKey(p.DecrypterKeyPEM, opts...)
+		if err != nil {
+			return errors.Wrap(err, "error parsing decrypterKeyPEM")
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return errors.New("provisioner decrypterKeyPEM must be an RSA key")
+		}
+		pub, ok := p.decrypterCert.PublicKey.(*rsa.PublicKey)
+		if !ok || pub.N.Cmp(rsaKey.N) != 0 || pub.E != rsaKey.E {
+			return errors.New("provisioner decrypterKeyPEM does not match decrypterCertificate")
+		}
+		p.decrypterKey = rsaKey
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+	// Create the client used to validate the dynamic challenges
+	if p.client, err = newHTTPClient(p.HTTPClient.withDefaultProxy(config.Proxy)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDecrypter returns the certificate and key used to decrypt the SCEP
+// requests. It returns nil values if the provisioner does not define one.
+func (p *SCEP) GetDecrypter() (*x509.Certificate, crypto.Decrypter) {
+	return p.decrypterCert, p.decrypterKey
+}
+
+// ValidateChallenge validates the challenge password of a SCEP request. The
+// password is accepted if it matches the static challenge or if the challenge
+// URL authorizes it.
+func (p *SCEP) ValidateChallenge(ctx context.Context, challenge, transactionID string, csr *x509.CertificateRequest) error {
+	if challenge == "" {
+		return errs.Unauthorized("scep.ValidateChallenge; challenge password is missing")
+	}
+	if p.ChallengePassword != "" && subtle.ConstantTimeCompare([]byte(p.ChallengePassword), []byte(challenge)) == 1 {
+		return nil
+	}
+	if p.ChallengeURL == "" {
+		return errs.Unauthorized("scep.ValidateChallenge; invalid challenge password")
+	}
+
+	b, err := json.Marshal(scepChallengeRequest{
+		Provisioner:   p.Name,
+		Challenge:     challenge,
+		TransactionID: transactionID,
+		CSR: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: csr.Raw,
+		})),
+	})
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "scep.ValidateChallenge; error marshaling request")
+	}
+	req, err := http.NewRequest(http.MethodPost, p.ChallengeURL, bytes.NewReader(b))
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "scep.ValidateChallenge; error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "scep.ValidateChallenge; error connecting to %s", p.ChallengeURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errs.Unauthorized("scep.ValidateChallenge; challenge validation failed with status %s", resp.Status)
+	}
+	var cr scepChallengeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "scep.ValidateChallenge; error decoding response")
+	}
+	if !cr.Allow {
+		return errs.Unauthorized("scep.ValidateChallenge; invalid challenge password")
+	}
+	return nil
+}
+
+// AuthorizeSign does not do any validation, because all validation is handled
+// in the SCEP protocol. This method returns a list of modifiers / constraints
+// on the resulting certificate.
+func (p *SCEP) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("scep.AuthorizeSign; issuance is disabled for scep provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	return withX509CertificateOptions(p.Options, []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeSCEP, p.Name, ""),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
+		// validators
+		defaultPublicKeyValidator{},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(p.claimer),
+	}, newTemplateData(token, "", nil)), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled or if the
+// certificate has expired and it cannot be renewed after expiry. SCEP clients
+// renew with a request signed by the current certificate.
+func (p *SCEP) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("scep.AuthorizeRenew; renew is disabled for scep provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "scep.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "scep.AuthorizeRenew")
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+)
+
+func generateSCEP() (*SCEP, error) {
+	p := &SCEP{
+		Type:              "SCEP",
+		Name:              "scep@smallstep.com",
+		ChallengePassword: "password",
+	}
+	if err := p.Init(Config{Claims: globalProvisionerClaims}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func generateSCEPDecrypter(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "SCEP decrypter"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	assert.FatalError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestSCEP_Getters(t *testing.T) {
+	p, err := generateSCEP()
+	assert.FatalError(t, err)
+	id := "scep/" + p.Name
+	if got := p.GetID(); got != id {
+		t.Errorf("SCEP.GetID() = %v, want %v", got, id)
+	}
+	if got := p.GetName(); got != p.Name {
+		t.Errorf("SCEP.GetName() = %v, want %v", got, p.Name)
+	}
+	if got := p.GetType(); got != TypeSCEP {
+		t.Errorf("SCEP.GetType() = %v, want %v", got, TypeSCEP)
+	}
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("SCEP.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+	if _, err := p.GetTokenID("token"); err == nil {
+		t.Error("SCEP.GetTokenID() error = nil, want error")
+	}
+}
+
+func TestSCEP_Init(t *testing.T) {
+	certPEM, keyPEM := generateSCEPDecrypter(t)
+	_, otherKeyPEM := generateSCEPDecrypter(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	assert.FatalError(t, err)
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})
+
+	config := Config{Claims: globalProvisionerClaims}
+	tests := []struct {
+		name    string
+		p       *SCEP
+		wantErr bool
+	}{
+		{"ok static", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password"}, false},
+		{"ok dynamic", &SCEP{Type: "SCEP", Name: "name", ChallengeURL: "https://mdm.example.com/scep"}, false},
+		{"ok decrypter", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: certPEM, DecrypterKeyPEM: keyPEM}, false},
+		{"fail type", &SCEP{Name: "name", ChallengePassword: "password"}, true},
+		{"fail name", &SCEP{Type: "SCEP", ChallengePassword: "password"}, true},
+		{"fail challenge", &SCEP{Type: "SCEP", Name: "name"}, true},
+		{"fail challengeURL", &SCEP{Type: "SCEP", Name: "name", ChallengeURL: "/scep"}, true},
+		{"fail challengeURL scheme", &SCEP{Type: "SCEP", Name: "name", ChallengeURL: "ftp://mdm.example.com"}, true},
+		{"fail decrypterCertificate empty", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterKeyPEM: keyPEM}, true},
+		{"fail decrypterKeyPEM empty", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: certPEM}, true},
+		{"fail decrypterCertificate", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: keyPEM, DecrypterKeyPEM: keyPEM}, true},
+		{"fail decrypterKeyPEM", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: certPEM, DecrypterKeyPEM: certPEM}, true},
+		{"fail decrypterKeyPEM ecdsa", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: certPEM, DecrypterKeyPEM: ecPEM}, true},
+		{"fail decrypterKeyPEM mismatch", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: certPEM, DecrypterKeyPEM: otherKeyPEM}, true},
+		{"fail claims", &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", Claims: &Claims{DefaultTLSDur: &Duration{0}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("SCEP.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	p := &SCEP{Type: "SCEP", Name: "name", ChallengePassword: "password", DecrypterCertificate: certPEM, DecrypterKeyPEM: keyPEM}
+	assert.FatalError(t, p.Init(config))
+	crt, key := p.GetDecrypter()
+	assert.NotNil(t, crt)
+	assert.NotNil(t, key)
+	assert.Equals(t, "SCEP decrypter", crt.Subject.CommonName)
+}
+
+func TestSCEP_ValidateChallenge(t *testing.T) {
+	csr := &x509.CertificateRequest{Raw: []byte("csr")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req scepChallengeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch req.Challenge {
+		case "dynamic":
+			if req.TransactionID != "tid" || req.Provisioner != "scep" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(scepChallengeResponse{Allow: true})
+		case "denied":
+			json.NewEncoder(w).Encode(scepChallengeResponse{Allow: false})
+		case "bad-json":
+			w.Write([]byte("{"))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	config := Config{Claims: globalProvisionerClaims}
+	static := &SCEP{Type: "SCEP", Name: "scep", ChallengePassword: "password"}
+	assert.FatalError(t, static.Init(config))
+	dynamic := &SCEP{Type: "SCEP", Name: "scep", ChallengePassword: "password", ChallengeURL: srv.URL}
+	assert.FatalError(t, dynamic.Init(config))
+	offline := &SCEP{Type: "SCEP", Name: "scep", ChallengeURL: "http://127.0.0.1:0"}
+	assert.FatalError(t, offline.Init(config))
+
+	tests := []struct {
+		name      string
+		p         *SCEP
+		challenge string
+		code      int
+		wantErr   bool
+	}{
+		{"ok static", static, "password", 0, false},
+		{"ok static with url", dynamic, "password", 0, false},
+		{"ok dynamic", dynamic, "dynamic", 0, false},
+		{"fail empty", static, "", http.StatusUnauthorized, true},
+		{"fail static", static, "dynamic", http.StatusUnauthorized, true},
+		{"fail denied", dynamic, "denied", http.StatusUnauthorized, true},
+		{"fail status", dynamic, "other", http.StatusUnauthorized, true},
+		{"fail json", dynamic, "bad-json", http.StatusInternalServerError, true},
+		{"fail connect", offline, "dynamic", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.ValidateChallenge(context.Background(), tt.challenge, "tid", csr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SCEP.ValidateChallenge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+			}
+		})
+	}
+}
+
+func TestSCEP_AuthorizeSign(t *testing.T) {
+	p, err := generateSCEP()
+	assert.FatalError(t, err)
+	opts, err := p.AuthorizeSign(context.Background(), "")
+	assert.FatalError(t, err)
+	assert.Len(t, 7, opts)
+	for _, o := range opts {
+		switch v := o.(type) {
+		case *provisionerExtensionOption:
+			assert.Equals(t, v.Type, int(TypeSCEP))
+			assert.Equals(t, v.Name, p.GetName())
+		case profileDefaultDuration:
+			assert.Equals(t, time.Duration(v), p.claimer.DefaultTLSCertDuration())
+		case backdateOption:
+			assert.Equals(t, time.Duration(v), p.claimer.Backdate())
+		case defaultPublicKeyValidator:
+		case *validityValidator:
+			assert.Equals(t, v.min, p.claimer.MinTLSCertDuration())
+			assert.Equals(t, v.max, p.claimer.MaxTLSCertDuration())
+		case *sanDurationValidator:
+			assert.Equals(t, v.claimer, p.claimer)
+		case x509ProfileValidator:
+			assert.Len(t, 0, v)
+		default:
+			t.Errorf("unexpected sign option of type %T", v)
+		}
+	}
+}
+
+func TestSCEP_AuthorizeRenew(t *testing.T) {
+	p1, err := generateSCEP()
+	assert.FatalError(t, err)
+	p2, err := generateSCEP()
+	assert.FatalError(t, err)
+
+	// disable renewal
+	disable := true
+	p2.Claims = &Claims{DisableRenewal: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	valid := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+	expired := &x509.Certificate{NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name    string
+		p       *SCEP
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", p1, valid, false},
+		{"fail disabled", p2, valid, true},
+		{"fail expired", p1, expired, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.AuthorizeRenew(context.Background(), tt.cert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SCEP.AuthorizeRenew() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusUnauthorized, sc.StatusCode())
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"net"
+	"net/url"
 	"reflect"
 	"time"
 
@@ -16,11 +17,15 @@ import (
 )
 
 // Options contains the options that can be passed to the Sign method. Backdate
-// is automatically filled and can only be configured in the CA.
+// is automatically filled and can only be configured in the CA. Intermediate
+// is the optional name of the intermediate used to sign the certificate, and
+// Profile the optional name of the certificate profile.
 type Options struct {
-	NotAfter  TimeDuration  `json:"notAfter"`
-	NotBefore TimeDuration  `json:"notBefore"`
-	Backdate  time.Duration `json:"-"`
+	NotAfter     TimeDuration  `json:"notAfter"`
+	NotBefore    TimeDuration  `json:"notBefore"`
+	Intermediate string        `json:"intermediate,omitempty"`
+	Profile      string        `json:"profile,omitempty"`
+	Backdate     time.Duration `json:"-"`
 }
 
 // SignOption is the interface used to collect all extra options used in the
@@ -186,6 +191,60 @@ func (v emailAddressesValidator) Valid(req *x509.CertificateRequest) error {
 	return nil
 }
 
+// urisValidator validates the URI SANs of a certificate request.
+type urisValidator []*url.URL
+
+// Valid checks that certificate request URIs match the ones configured in the
+// provisioner.
+func (v urisValidator) Valid(req *x509.CertificateRequest) error {
+	want := make(map[string]bool)
+	for _, u := range v {
+		want[u.String()] = true
+	}
+	got := make(map[string]bool)
+	for _, u := range req.URIs {
+		got[u.String()] = true
+	}
+	if !reflect.DeepEqual(want, got) {
+		return errors.Errorf("certificate request does not contain the valid URIs - got %v, want %v", req.URIs, v)
+	}
+	return nil
+}
+
+// allowedSANsValidator validates that the common name and all the SANs of a
+// certificate request are in the list of allowed names. IP addresses and URIs
+// are compared using their string representation.
+type allowedSANsValidator []string
+
+// Valid checks that the certificate request only contains allowed names.
+func (v allowedSANsValidator) Valid(req *x509.CertificateRequest) error {
+	allowed := make(map[string]bool)
+	for _, s := range v {
+		allowed[s] = true
+	}
+	var names []string
+	if req.Subject.CommonName != "" {
+		names = append(names, req.Subject.CommonName)
+	}
+	names = append(names, req.DNSNames...)
+	names = append(names, req.EmailAddresses...)
+	for _, ip := range req.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, u := range req.URIs {
+		names = append(names, u.String())
+	}
+	if len(names) == 0 {
+		return errors.New("certificate request does not contain any name")
+	}
+	for _, name := range names {
+		if !allowed[name] {
+			return errors.Errorf("certificate request contains a name that is not allowed - got %s, want %v", name, []string(v))
+		}
+	}
+	return nil
+}
+
 // profileDefaultDuration is a wrapper against x509util.WithOption to conform
 // the SignOption interface.
 type profileDefaultDuration time.Duration
@@ -252,6 +311,21 @@ func (v profileLimitDuration) Option(so Options) x509util.WithOption {
 	}
 }
 
+// BackdateOption is the interface implemented by the SignOptions that set the
+// backdate of the certificates signed by a provisioner. The authority applies
+// it to the Options or SSHOptions before the rest of the options.
+type BackdateOption interface {
+	Backdate() time.Duration
+}
+
+// backdateOption is a SignOption with the backdate in the claims of a
+// provisioner.
+type backdateOption time.Duration
+
+func (v backdateOption) Backdate() time.Duration {
+	return time.Duration(v)
+}
+
 // validityValidator validates the certificate validity settings.
 type validityValidator struct {
 	min time.Duration
@@ -295,6 +369,24 @@ func (v *validityValidator) Valid(cert *x509.Certificate, o Options) error {
 	return nil
 }
 
+// sanDurationValidator validates the certificate duration against the maximum
+// durations of the types of names in the certificate.
+type sanDurationValidator struct {
+	claimer *Claimer
+}
+
+// newSANDurationValidator returns a new validator of the durations by type of
+// name.
+func newSANDurationValidator(claimer *Claimer) *sanDurationValidator {
+	return &sanDurationValidator{claimer: claimer}
+}
+
+// Valid validates the certificate duration against the configured maximums by
+// type of name.
+func (v *sanDurationValidator) Valid(cert *x509.Certificate, o Options) error {
+	return v.claimer.checkSANDurations(cert, o.Backdate)
+}
+
 var (
 	stepOIDRoot        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37476, 9000, 64}
 	stepOIDProvisioner = append(asn1.ObjectIdentifier(nil), append(stepOIDRoot, 1)...)
@@ -352,6 +444,22 @@ func createProvisionerExtension(typ int, name, credentialID string, keyValuePair
 	}, nil
 }
 
+// GetProvisionerName returns the name of the provisioner in the provisioner
+// extension of the certificate, it returns false if the certificate does not
+// have a valid extension. The provisioner might not exist anymore.
+func GetProvisionerName(cert *x509.Certificate) (string, bool) {
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(stepOIDProvisioner) {
+			var provisioner stepProvisionerASN1
+			if _, err := asn1.Unmarshal(e.Value, &provisioner); err != nil {
+				return "", false
+			}
+			return string(provisioner.Name), true
+		}
+	}
+	return "", false
+}
+
 func init() {
 	// Avoid dead-code warning in profileWithOption
 	_ = profileWithOption(nil)
@@ -246,6 +246,71 @@ func Test_ipAddressesValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_urisValidator_Valid(t *testing.T) {
+	u1 := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/foo"}
+	u2 := &url.URL{Scheme: "https", Host: "example.org"}
+	u3 := &url.URL{Scheme: "urn", Opaque: "uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6"}
+
+	type args struct {
+		req *x509.CertificateRequest
+	}
+	tests := []struct {
+		name    string
+		v       urisValidator
+		args    args
+		wantErr bool
+	}{
+		{"ok0", []*url.URL{}, args{&x509.CertificateRequest{URIs: []*url.URL{}}}, false},
+		{"ok1", []*url.URL{u1}, args{&x509.CertificateRequest{URIs: []*url.URL{u1}}}, false},
+		{"ok2", []*url.URL{u1, u2}, args{&x509.CertificateRequest{URIs: []*url.URL{u1, u2}}}, false},
+		{"ok3", []*url.URL{u1, u2}, args{&x509.CertificateRequest{URIs: []*url.URL{u2, u1}}}, false},
+		{"fail1", []*url.URL{u1}, args{&x509.CertificateRequest{URIs: []*url.URL{u2}}}, true},
+		{"fail2", []*url.URL{u1}, args{&x509.CertificateRequest{URIs: []*url.URL{u2, u1}}}, true},
+		{"fail3", []*url.URL{u1, u2}, args{&x509.CertificateRequest{URIs: []*url.URL{u1, u3}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("urisValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_allowedSANsValidator_Valid(t *testing.T) {
+	type args struct {
+		req *x509.CertificateRequest
+	}
+	tests := []struct {
+		name    string
+		v       allowedSANsValidator
+		args    args
+		wantErr bool
+	}{
+		{"ok cn", []string{"foo.bar.zar"}, args{&x509.CertificateRequest{Subject: pkix.Name{CommonName: "foo.bar.zar"}}}, false},
+		{"ok dns", []string{"foo.bar.zar", "bar.zar"}, args{&x509.CertificateRequest{DNSNames: []string{"bar.zar"}}}, false},
+		{"ok all", []string{"foo.bar.zar", "name@smallstep.com", "10.3.2.1", "spiffe://foo/bar"}, args{&x509.CertificateRequest{
+			Subject:        pkix.Name{CommonName: "foo.bar.zar"},
+			DNSNames:       []string{"foo.bar.zar"},
+			EmailAddresses: []string{"name@smallstep.com"},
+			IPAddresses:    []net.IP{net.IPv4(10, 3, 2, 1)},
+			URIs:           []*url.URL{{Scheme: "spiffe", Host: "foo", Path: "/bar"}},
+		}}, false},
+		{"fail empty", []string{"foo.bar.zar"}, args{&x509.CertificateRequest{}}, true},
+		{"fail cn", []string{"foo.bar.zar"}, args{&x509.CertificateRequest{Subject: pkix.Name{CommonName: "bar.zar"}}}, true},
+		{"fail dns", []string{"foo.bar.zar"}, args{&x509.CertificateRequest{DNSNames: []string{"foo.bar.zar", "bar.zar"}}}, true},
+		{"fail ip", []string{"10.3.2.1"}, args{&x509.CertificateRequest{IPAddresses: []net.IP{net.IPv4(10, 3, 2, 2)}}}, true},
+		{"fail nil", nil, args{&x509.CertificateRequest{DNSNames: []string{"foo.bar.zar"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("allowedSANsValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_validityValidator_Valid(t *testing.T) {
 	type test struct {
 		cert *x509.Certificate
@@ -344,6 +409,43 @@ func Test_validityValidator_Valid(t *testing.T) {
 	}
 }
 
+func Test_sanDurationValidator_Valid(t *testing.T) {
+	claimer, err := NewClaimer(&Claims{MaxEmailTLSDur: &Duration{time.Hour}}, globalProvisionerClaims)
+	assert.FatalError(t, err)
+	now := time.Now()
+	tests := map[string]struct {
+		cert *x509.Certificate
+		opts Options
+		err  error
+	}{
+		"ok": {
+			cert: &x509.Certificate{NotBefore: now, NotAfter: now.Add(time.Hour), EmailAddresses: []string{"foo@smallstep.com"}},
+		},
+		"ok/backdate": {
+			cert: &x509.Certificate{NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Hour), EmailAddresses: []string{"foo@smallstep.com"}},
+			opts: Options{Backdate: time.Minute},
+		},
+		"ok/other-names": {
+			cert: &x509.Certificate{NotBefore: now, NotAfter: now.Add(24 * time.Hour), DNSNames: []string{"foo.smallstep.com"}},
+		},
+		"fail/email": {
+			cert: &x509.Certificate{NotBefore: now, NotAfter: now.Add(2 * time.Hour), EmailAddresses: []string{"foo@smallstep.com"}},
+			err:  errors.New("requested duration of 2h0m0s is more than the authorized maximum certificate duration of 1h0m0s for certificates with email addresses"),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := newSANDurationValidator(claimer).Valid(tt.cert, tt.opts); err != nil {
+				if assert.NotNil(t, tt.err, fmt.Sprintf("expected no error, but got err = %s", err.Error())) {
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+			} else {
+				assert.Nil(t, tt.err, fmt.Sprintf("expected err = %s, but not <nil>", tt.err))
+			}
+		})
+	}
+}
+
 func Test_profileDefaultDuration_Option(t *testing.T) {
 	type test struct {
 		so    Options
@@ -543,3 +645,25 @@ func Test_profileLimitDuration_Option(t *testing.T) {
 		})
 	}
 }
+
+func TestGetProvisionerName(t *testing.T) {
+	ext, err := createProvisionerExtension(int(TypeJWK), "foo", "kid")
+	assert.FatalError(t, err)
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		want   string
+		wantOK bool
+	}{
+		{"ok", &x509.Certificate{Extensions: []pkix.Extension{ext}}, "foo", true},
+		{"fail/missing", &x509.Certificate{}, "", false},
+		{"fail/invalid", &x509.Certificate{Extensions: []pkix.Extension{{Id: stepOIDProvisioner, Value: []byte("foo")}}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := GetProvisionerName(tt.cert)
+			assert.Equals(t, tt.want, got)
+			assert.Equals(t, tt.wantOK, ok)
+		})
+	}
+}
@@ -427,6 +427,49 @@ func (v sshCertKeyIDValidator) Valid(cert *ssh.Certificate, o SSHOptions) error
 	return nil
 }
 
+// sshCertDelegationValidator implements a validator that prevents a renewed or
+// rekeyed certificate from getting more privileges than the certificate used
+// to authorize the request.
+type sshCertDelegationValidator struct {
+	cert *ssh.Certificate
+}
+
+// Valid returns an error if the given certificate has a different type or key
+// id, more principals, critical options or extensions, or a longer validity
+// than the presented certificate.
+func (v *sshCertDelegationValidator) Valid(cert *ssh.Certificate, o SSHOptions) error {
+	switch {
+	case cert.CertType != v.cert.CertType:
+		return errors.Errorf("ssh certificate type %d does not match the presented certificate type %d", cert.CertType, v.cert.CertType)
+	case cert.KeyId != v.cert.KeyId:
+		return errors.Errorf("ssh certificate key id %s does not match the presented certificate key id %s", cert.KeyId, v.cert.KeyId)
+	case !containsAllMembers(v.cert.ValidPrincipals, cert.ValidPrincipals):
+		return errors.Errorf("ssh certificate principals %v are not a subset of the presented certificate principals %v",
+			cert.ValidPrincipals, v.cert.ValidPrincipals)
+	}
+	for k := range cert.CriticalOptions {
+		if _, ok := v.cert.CriticalOptions[k]; !ok {
+			return errors.Errorf("ssh certificate critical option %s is not in the presented certificate", k)
+		}
+	}
+	// Critical options are restrictions, they must be kept.
+	for k, val := range v.cert.CriticalOptions {
+		if got, ok := cert.CriticalOptions[k]; !ok || got != val {
+			return errors.Errorf("ssh certificate critical option %s does not match the presented certificate", k)
+		}
+	}
+	for k := range cert.Extensions {
+		if _, ok := v.cert.Extensions[k]; !ok {
+			return errors.Errorf("ssh certificate extension %s is not in the presented certificate", k)
+		}
+	}
+	dur := time.Duration(cert.ValidBefore-cert.ValidAfter) * time.Second
+	if max := time.Duration(v.cert.ValidBefore-v.cert.ValidAfter) * time.Second; dur > max {
+		return errors.Errorf("requested duration of %s is greater than the duration of the presented certificate of %s", dur, max)
+	}
+	return nil
+}
+
 // sshCertTypeUInt32
 func sshCertTypeUInt32(ct string) uint32 {
 	switch ct {
@@ -779,6 +779,87 @@ func Test_sshCertValidityValidator(t *testing.T) {
 	}
 }
 
+func Test_sshCertDelegationValidator(t *testing.T) {
+	n := now()
+	presented := &ssh.Certificate{
+		CertType:        ssh.HostCert,
+		KeyId:           "foo.smallstep.com",
+		ValidPrincipals: []string{"foo.smallstep.com", "foo.internal"},
+		ValidAfter:      uint64(n.Unix()),
+		ValidBefore:     uint64(n.Add(24 * time.Hour).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: map[string]string{"source-address": "10.0.0.0/8"},
+			Extensions:      map[string]string{"permit-pty": ""},
+		},
+	}
+	newCert := func(fn func(cert *ssh.Certificate)) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			CertType:        ssh.HostCert,
+			KeyId:           "foo.smallstep.com",
+			ValidPrincipals: []string{"foo.smallstep.com"},
+			ValidAfter:      uint64(n.Unix()),
+			ValidBefore:     uint64(n.Add(24 * time.Hour).Unix()),
+			Permissions: ssh.Permissions{
+				CriticalOptions: map[string]string{"source-address": "10.0.0.0/8"},
+			},
+		}
+		if fn != nil {
+			fn(cert)
+		}
+		return cert
+	}
+	v := &sshCertDelegationValidator{presented}
+	tests := []struct {
+		name string
+		cert *ssh.Certificate
+		err  error
+	}{
+		{"ok", newCert(nil), nil},
+		{"ok/same", newCert(func(cert *ssh.Certificate) {
+			cert.ValidPrincipals = presented.ValidPrincipals
+			cert.Extensions = presented.Extensions
+		}), nil},
+		{"fail/cert-type", newCert(func(cert *ssh.Certificate) {
+			cert.CertType = ssh.UserCert
+		}), errors.New("ssh certificate type 1 does not match the presented certificate type 2")},
+		{"fail/key-id", newCert(func(cert *ssh.Certificate) {
+			cert.KeyId = "bar.smallstep.com"
+		}), errors.New("ssh certificate key id bar.smallstep.com does not match the presented certificate key id foo.smallstep.com")},
+		{"fail/principals", newCert(func(cert *ssh.Certificate) {
+			cert.ValidPrincipals = []string{"foo.smallstep.com", "bar.smallstep.com"}
+		}), errors.New("ssh certificate principals [foo.smallstep.com bar.smallstep.com] are not a subset of the presented certificate principals [foo.smallstep.com foo.internal]")},
+		{"fail/empty-principals", newCert(func(cert *ssh.Certificate) {
+			cert.ValidPrincipals = nil
+		}), errors.New("ssh certificate principals [] are not a subset of the presented certificate principals [foo.smallstep.com foo.internal]")},
+		{"fail/new-critical-option", newCert(func(cert *ssh.Certificate) {
+			cert.CriticalOptions["force-command"] = "/bin/true"
+		}), errors.New("ssh certificate critical option force-command is not in the presented certificate")},
+		{"fail/missing-critical-option", newCert(func(cert *ssh.Certificate) {
+			cert.CriticalOptions = nil
+		}), errors.New("ssh certificate critical option source-address does not match the presented certificate")},
+		{"fail/changed-critical-option", newCert(func(cert *ssh.Certificate) {
+			cert.CriticalOptions["source-address"] = "0.0.0.0/0"
+		}), errors.New("ssh certificate critical option source-address does not match the presented certificate")},
+		{"fail/extension", newCert(func(cert *ssh.Certificate) {
+			cert.Extensions = map[string]string{"permit-port-forwarding": ""}
+		}), errors.New("ssh certificate extension permit-port-forwarding is not in the presented certificate")},
+		{"fail/duration", newCert(func(cert *ssh.Certificate) {
+			cert.ValidBefore = uint64(n.Add(48 * time.Hour).Unix())
+		}), errors.New("requested duration of 48h0m0s is greater than the duration of the presented certificate of 24h0m0s")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Valid(tt.cert, SSHOptions{}); err != nil {
+				if assert.NotNil(t, tt.err) {
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+			} else {
+				assert.Nil(t, tt.err)
+			}
+		})
+	}
+}
+
 func Test_sshValidityModifier(t *testing.T) {
 	n, fn := mockNow()
 	defer fn()
@@ -0,0 +1,153 @@
+package provisioner
+
+import (
+	"crypto/x509"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+// spiffeScheme is the scheme of the SPIFFE IDs.
+const spiffeScheme = "spiffe"
+
+// maxSPIFFEIDLength is the maximum length of a SPIFFE ID, in bytes.
+const maxSPIFFEIDLength = 2048
+
+// isValidSPIFFETrustDomain returns true if the given name can be used as a
+// SPIFFE trust domain, an empty name is also valid. Trust domains can only
+// contain lowercase letters, numbers, dots, dashes, and underscores.
+func isValidSPIFFETrustDomain(td string) bool {
+	for _, c := range td {
+		if !isSPIFFETrustDomainChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSPIFFETrustDomainChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '-' || c == '_'
+}
+
+func isSPIFFEPathChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '.' || c == '-' || c == '_'
+}
+
+// validateSPIFFEID validates that the given URI is a SPIFFE ID as defined in
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE-ID.md
+func validateSPIFFEID(u *url.URL) error {
+	id := u.String()
+	switch {
+	case !strings.EqualFold(u.Scheme, spiffeScheme):
+		return errors.Errorf("%s is not a SPIFFE ID", id)
+	case len(id) > maxSPIFFEIDLength:
+		return errors.Errorf("SPIFFE ID %s cannot be longer than %d bytes", id, maxSPIFFEIDLength)
+	case u.Opaque != "" || u.Host == "":
+		return errors.Errorf("SPIFFE ID %s must contain a trust domain", id)
+	case u.User != nil || u.Port() != "":
+		return errors.Errorf("SPIFFE ID %s cannot contain a user info or a port", id)
+	case u.RawQuery != "" || u.ForceQuery || u.Fragment != "":
+		return errors.Errorf("SPIFFE ID %s cannot contain a query or a fragment", id)
+	case !isValidSPIFFETrustDomain(u.Host):
+		return errors.Errorf("SPIFFE ID %s contains an invalid trust domain", id)
+	}
+	if u.Path == "" {
+		return nil
+	}
+	for _, segment := range strings.Split(u.Path[1:], "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.Errorf("SPIFFE ID %s contains an invalid path", id)
+		}
+		for _, c := range segment {
+			if !isSPIFFEPathChar(c) {
+				return errors.Errorf("SPIFFE ID %s contains an invalid path", id)
+			}
+		}
+	}
+	return nil
+}
+
+// spiffeSVIDValidator validates that a certificate request can be used to
+// create a SPIFFE X.509 SVID. The request must contain exactly one SPIFFE ID
+// as URI SAN, in the trust domain if it's set, and one of the allowed IDs if
+// they are set. The request cannot contain a common name or DNS names.
+type spiffeSVIDValidator struct {
+	TrustDomain string
+	IDs         []string
+}
+
+// Valid checks that the certificate request is a valid X.509 SVID request.
+func (v *spiffeSVIDValidator) Valid(req *x509.CertificateRequest) error {
+	switch {
+	case len(req.URIs) != 1:
+		return errors.Errorf("certificate request must contain exactly one SPIFFE ID, got %d URIs", len(req.URIs))
+	case req.Subject.CommonName != "":
+		return errors.New("certificate request for a SPIFFE ID cannot contain a common name")
+	case len(req.DNSNames) > 0:
+		return errors.New("certificate request for a SPIFFE ID cannot contain DNS names")
+	}
+
+	u := req.URIs[0]
+	if err := validateSPIFFEID(u); err != nil {
+		return errors.Wrap(err, "certificate request does not contain a valid SPIFFE ID")
+	}
+	if v.TrustDomain != "" && u.Host != v.TrustDomain {
+		return errors.Errorf("certificate request SPIFFE ID %s is not in the trust domain %s", u, v.TrustDomain)
+	}
+	if len(v.IDs) > 0 {
+		for _, id := range v.IDs {
+			if u.String() == id {
+				return nil
+			}
+		}
+		return errors.Errorf("certificate request does not contain the valid SPIFFE ID - got %s, want %v", u, v.IDs)
+	}
+	return nil
+}
+
+// spiffeSVIDModifier sets the key usages and basic constraints required in
+// the SPIFFE X.509 SVIDs of workloads.
+type spiffeSVIDModifier struct{}
+
+// Option returns the x509util.WithOption that sets the SVID profile.
+func (m spiffeSVIDModifier) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.IsCA = false
+		crt.BasicConstraintsValid = true
+		crt.MaxPathLen = 0
+		crt.MaxPathLenZero = false
+		crt.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+		crt.ExtKeyUsage = []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		}
+		return nil
+	}
+}
+
+// spiffeIDs returns the names that are SPIFFE IDs.
+func spiffeIDs(names []string) []string {
+	var ids []string
+	for _, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), spiffeScheme+"://") {
+			ids = append(ids, name)
+		}
+	}
+	return ids
+}
+
+// newSPIFFESignOptions returns the sign options used to create SPIFFE X.509
+// SVIDs, the SPIFFE ID must be one of the given ids if they are set.
+func newSPIFFESignOptions(claimer *Claimer, ids []string) []SignOption {
+	return []SignOption{
+		spiffeSVIDModifier{},
+		&spiffeSVIDValidator{
+			TrustDomain: claimer.SPIFFETrustDomain(),
+			IDs:         ids,
+		},
+	}
+}
@@ -0,0 +1,222 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+)
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	assert.FatalError(t, err)
+	return u
+}
+
+func Test_isValidSPIFFETrustDomain(t *testing.T) {
+	tests := []struct {
+		name string
+		td   string
+		want bool
+	}{
+		{"ok", "example.org", true},
+		{"ok empty", "", true},
+		{"ok chars", "my_trust-domain.0", true},
+		{"fail uppercase", "Example.org", false},
+		{"fail port", "example.org:8443", false},
+		{"fail scheme", "spiffe://example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isValidSPIFFETrustDomain(tt.td); got != tt.want {
+				t.Errorf("isValidSPIFFETrustDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateSPIFFEID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{"ok", "spiffe://example.org/ns/default/sa/foo", false},
+		{"ok trust domain", "spiffe://example.org", false},
+		{"ok uppercase scheme", "SPIFFE://example.org/foo", false},
+		{"fail scheme", "https://example.org/foo", true},
+		{"fail no trust domain", "spiffe:///foo", true},
+		{"fail opaque", "spiffe:example.org", true},
+		{"fail user", "spiffe://user@example.org/foo", true},
+		{"fail port", "spiffe://example.org:8443/foo", true},
+		{"fail query", "spiffe://example.org/foo?bar=zar", true},
+		{"fail fragment", "spiffe://example.org/foo#bar", true},
+		{"fail trust domain", "spiffe://Example.org/foo", true},
+		{"fail trailing slash", "spiffe://example.org/foo/", true},
+		{"fail empty segment", "spiffe://example.org/foo//bar", true},
+		{"fail dot segment", "spiffe://example.org/foo/./bar", true},
+		{"fail dot dot segment", "spiffe://example.org/foo/../bar", true},
+		{"fail path char", "spiffe://example.org/foo%20bar", true},
+		{"fail length", "spiffe://example.org/" + strings.Repeat("a", maxSPIFFEIDLength), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSPIFFEID(mustParseURL(t, tt.id)); (err != nil) != tt.wantErr {
+				t.Errorf("validateSPIFFEID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_spiffeSVIDValidator_Valid(t *testing.T) {
+	id := mustParseURL(t, "spiffe://example.org/foo")
+	other := mustParseURL(t, "spiffe://example.org/bar")
+	tests := []struct {
+		name    string
+		v       *spiffeSVIDValidator
+		req     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"ok", &spiffeSVIDValidator{}, &x509.CertificateRequest{URIs: []*url.URL{id}}, false},
+		{"ok trust domain", &spiffeSVIDValidator{TrustDomain: "example.org"}, &x509.CertificateRequest{URIs: []*url.URL{id}}, false},
+		{"ok ids", &spiffeSVIDValidator{IDs: []string{"spiffe://example.org/bar", "spiffe://example.org/foo"}}, &x509.CertificateRequest{URIs: []*url.URL{id}}, false},
+		{"fail no uris", &spiffeSVIDValidator{}, &x509.CertificateRequest{}, true},
+		{"fail multiple uris", &spiffeSVIDValidator{}, &x509.CertificateRequest{URIs: []*url.URL{id, other}}, true},
+		{"fail common name", &spiffeSVIDValidator{}, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "foo"}, URIs: []*url.URL{id}}, true},
+		{"fail dns names", &spiffeSVIDValidator{}, &x509.CertificateRequest{DNSNames: []string{"foo.example.org"}, URIs: []*url.URL{id}}, true},
+		{"fail invalid id", &spiffeSVIDValidator{}, &x509.CertificateRequest{URIs: []*url.URL{mustParseURL(t, "https://example.org/foo")}}, true},
+		{"fail trust domain", &spiffeSVIDValidator{TrustDomain: "example.com"}, &x509.CertificateRequest{URIs: []*url.URL{id}}, true},
+		{"fail ids", &spiffeSVIDValidator{IDs: []string{"spiffe://example.org/bar"}}, &x509.CertificateRequest{URIs: []*url.URL{id}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Valid(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("spiffeSVIDValidator.Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_spiffeSVIDModifier_Option(t *testing.T) {
+	leaf := &x509util.Leaf{}
+	crt := &x509.Certificate{
+		IsCA:     true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	leaf.SetSubject(crt)
+	assert.FatalError(t, spiffeSVIDModifier{}.Option(Options{})(leaf))
+	assert.False(t, crt.IsCA)
+	assert.True(t, crt.BasicConstraintsValid)
+	assert.Equals(t, crt.KeyUsage, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment)
+	assert.Equals(t, crt.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+}
+
+func Test_spiffeIDs(t *testing.T) {
+	assert.Equals(t, spiffeIDs([]string{"foo", "spiffe://example.org/foo", "https://example.org", "SPIFFE://example.org/bar"}),
+		[]string{"spiffe://example.org/foo", "SPIFFE://example.org/bar"})
+	assert.Equals(t, spiffeIDs([]string{"foo"}), []string(nil))
+}
+
+func TestClaimer_IsSPIFFEEnabled(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name            string
+		global          Claims
+		claims          *Claims
+		want            bool
+		wantTrustDomain string
+	}{
+		{"default", globalProvisionerClaims, nil, false, ""},
+		{"global", Claims{EnableSPIFFE: &enabled, SPIFFETrustDomain: "example.org"}, nil, true, "example.org"},
+		{"provisioner", globalProvisionerClaims, &Claims{EnableSPIFFE: &enabled, SPIFFETrustDomain: "example.com"}, true, "example.com"},
+		{"override", Claims{EnableSPIFFE: &enabled, SPIFFETrustDomain: "example.org"}, &Claims{EnableSPIFFE: &disabled}, false, "example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Claimer{global: tt.global, claims: tt.claims}
+			if got := c.IsSPIFFEEnabled(); got != tt.want {
+				t.Errorf("Claimer.IsSPIFFEEnabled() = %v, want %v", got, tt.want)
+			}
+			if got := c.SPIFFETrustDomain(); got != tt.wantTrustDomain {
+				t.Errorf("Claimer.SPIFFETrustDomain() = %v, want %v", got, tt.wantTrustDomain)
+			}
+		})
+	}
+
+	_, err := NewClaimer(&Claims{SPIFFETrustDomain: "Example.org"}, globalProvisionerClaims)
+	assert.Error(t, err)
+}
+
+func TestJWK_AuthorizeSign_SPIFFE(t *testing.T) {
+	p1, err := generateJWK()
+	assert.FatalError(t, err)
+	enabled := true
+	p1.Claims = &Claims{EnableSPIFFE: &enabled, SPIFFETrustDomain: "example.org"}
+	p1.claimer, err = NewClaimer(p1.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+	key1, err := decryptJSONWebKey(p1.EncryptedKey)
+	assert.FatalError(t, err)
+
+	t1, err := generateToken("spiffe://example.org/foo", p1.Name, testAudiences.Sign[0], "", []string{}, time.Now(), key1)
+	assert.FatalError(t, err)
+	t2, err := generateToken("subject", p1.Name, testAudiences.Sign[0], "", []string{"foo.example.org", "spiffe://example.org/bar"}, time.Now(), key1)
+	assert.FatalError(t, err)
+	failNoID, err := generateToken("subject", p1.Name, testAudiences.Sign[0], "", []string{"foo.example.org"}, time.Now(), key1)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		ids     []string
+		code    int
+		wantErr bool
+	}{
+		{"ok subject", t1, []string{"spiffe://example.org/foo"}, http.StatusOK, false},
+		{"ok sans", t2, []string{"spiffe://example.org/bar"}, http.StatusOK, false},
+		{"fail no id", failNoID, nil, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewContextWithMethod(context.Background(), SignMethod)
+			got, err := p1.AuthorizeSign(ctx, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("JWK.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				return
+			}
+			assert.Len(t, 9, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case *provisionerExtensionOption:
+				case profileDefaultDuration:
+				case backdateOption:
+				case defaultPublicKeyValidator:
+				case *validityValidator:
+				case *sanDurationValidator:
+				case spiffeSVIDModifier:
+				case *spiffeSVIDValidator:
+					assert.Equals(t, v.TrustDomain, "example.org")
+					assert.Equals(t, v.IDs, tt.ids)
+				case x509ProfileValidator:
+					assert.Len(t, 0, v)
+				default:
+					assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
+				}
+			}
+		})
+	}
+}
@@ -64,6 +64,8 @@ func signSSHCertificate(key crypto.PublicKey, opts SSHOptions, signOpts []SignOp
 			if err := o.Valid(opts); err != nil {
 				return nil, err
 			}
+		// the backdate is set in the given SSHOptions
+		case BackdateOption:
 		default:
 			return nil, fmt.Errorf("signSSH: invalid extra option type %T", o)
 		}
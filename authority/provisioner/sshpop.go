@@ -114,6 +114,12 @@ func (p *SSHPOP) authorizeToken(token string, audiences []string) (*sshPOPPayloa
 	} else if isRevoked {
 		return nil, errs.Unauthorized("sshpop.authorizeToken; sshpop certificate is revoked")
 	}
+	if isRevoked, err := p.db.IsSSHKeyIDRevoked(sshCert.KeyId); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err,
+			"sshpop.authorizeToken; error checking checking sshpop cert revocation")
+	} else if isRevoked {
+		return nil, errs.Unauthorized("sshpop.authorizeToken; sshpop certificate key id is revoked")
+	}
 
 	// Check validity period of the certificate.
 	n := time.Now()
@@ -164,7 +170,7 @@ func (p *SSHPOP) authorizeToken(token string, audiences []string) (*sshPOPPayloa
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: p.Name,
 		Time:   time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrap(http.StatusUnauthorized, err, "sshpop.authorizeToken; invalid sshpop token")
 	}
 
@@ -198,17 +204,22 @@ func (p *SSHPOP) AuthorizeSSHRevoke(ctx context.Context, token string) error {
 
 // AuthorizeSSHRenew validates the authorization token and extracts/validates
 // the SSH certificate from the ssh-pop header.
-func (p *SSHPOP) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, error) {
+func (p *SSHPOP) AuthorizeSSHRenew(ctx context.Context, token string) (*ssh.Certificate, []SignOption, error) {
 	claims, err := p.authorizeToken(token, p.audiences.SSHRenew)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "sshpop.AuthorizeSSHRenew")
+		return nil, nil, errs.Wrap(http.StatusInternalServerError, err, "sshpop.AuthorizeSSHRenew")
 	}
 	if claims.sshCert.CertType != ssh.HostCert {
-		return nil, errs.BadRequest("sshpop.AuthorizeSSHRenew; sshpop certificate must be a host ssh certificate")
+		return nil, nil, errs.BadRequest("sshpop.AuthorizeSSHRenew; sshpop certificate must be a host ssh certificate")
 	}
-
-	return claims.sshCert, nil
-
+	return claims.sshCert, []SignOption{
+		// Validate the validity period.
+		&sshCertValidityValidator{p.claimer},
+		// Require and validate all the default fields in the SSH certificate.
+		&sshCertDefaultValidator{},
+		// Do not allow more privileges than the presented certificate.
+		&sshCertDelegationValidator{claims.sshCert},
+	}, nil
 }
 
 // AuthorizeSSHRekey validates the authorization token and extracts/validates
@@ -228,6 +239,8 @@ func (p *SSHPOP) AuthorizeSSHRekey(ctx context.Context, token string) (*ssh.Cert
 		&sshCertValidityValidator{p.claimer},
 		// Require and validate all the default fields in the SSH certificate.
 		&sshCertDefaultValidator{},
+		// Do not allow more privileges than the presented certificate.
+		&sshCertDelegationValidator{claims.sshCert},
 	}, nil
 
 }
@@ -121,6 +121,29 @@ func TestSSHPOP_authorizeToken(t *testing.T) {
 				err:   errors.New("sshpop.authorizeToken; sshpop certificate is revoked"),
 			}
 		},
+		"fail/key-id-already-revoked": func(t *testing.T) test {
+			p, err := generateSSHPOP()
+			assert.FatalError(t, err)
+			p.db = &db.MockAuthDB{
+				MIsSSHRevoked: func(sn string) (bool, error) {
+					return false, nil
+				},
+				MIsSSHKeyIDRevoked: func(keyID string) (bool, error) {
+					assert.Equals(t, "foo@smallstep.com", keyID)
+					return true, nil
+				},
+			}
+			cert, jwk, err := createSSHCert(&ssh.Certificate{CertType: ssh.UserCert, KeyId: "foo@smallstep.com"}, sshSigner)
+			assert.FatalError(t, err)
+			tok, err := generateSSHPOPToken(p, cert, jwk)
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("sshpop.authorizeToken; sshpop certificate key id is revoked"),
+			}
+		},
 		"fail/cert-not-yet-valid": func(t *testing.T) test {
 			p, err := generateSSHPOP()
 			assert.FatalError(t, err)
@@ -459,7 +482,7 @@ func TestSSHPOP_AuthorizeSSHRenew(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if cert, err := tc.p.AuthorizeSSHRenew(context.Background(), tc.token); err != nil {
+			if cert, opts, err := tc.p.AuthorizeSSHRenew(context.Background(), tc.token); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -468,6 +491,18 @@ func TestSSHPOP_AuthorizeSSHRenew(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
+					assert.Len(t, 3, opts)
+					for _, o := range opts {
+						switch v := o.(type) {
+						case *sshCertDefaultValidator:
+						case *sshCertValidityValidator:
+							assert.Equals(t, v.Claimer, tc.p.claimer)
+						case *sshCertDelegationValidator:
+							assert.Equals(t, tc.cert.Marshal(), v.cert.Marshal())
+						default:
+							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
+						}
+					}
 					assert.Equals(t, tc.cert.Nonce, cert.Nonce)
 				}
 			}
@@ -560,13 +595,15 @@ func TestSSHPOP_AuthorizeSSHRekey(t *testing.T) {
 				}
 			} else {
 				if assert.Nil(t, tc.err) {
-					assert.Len(t, 3, opts)
+					assert.Len(t, 4, opts)
 					for _, o := range opts {
 						switch v := o.(type) {
 						case *sshDefaultPublicKeyValidator:
 						case *sshCertDefaultValidator:
 						case *sshCertValidityValidator:
 							assert.Equals(t, v.Claimer, tc.p.claimer)
+						case *sshCertDelegationValidator:
+							assert.Equals(t, tc.cert.Marshal(), v.cert.Marshal())
 						default:
 							assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 						}
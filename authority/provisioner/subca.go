@@ -0,0 +1,188 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+)
+
+// SubCA is the provisioner type used to issue subordinate CA certificates, so
+// a team can run its own issuing CA chained to the root of the authority.
+//
+// The requests are authorized with tokens signed with the Key of the
+// provisioner, but the certificates are not signed until an administrator
+// approves them using the admin API. The issued CAs have the path length and
+// the name constraints configured in the provisioner; the ones in the request
+// are ignored.
+type SubCA struct {
+	*base
+	Type string           `json:"type"`
+	Name string           `json:"name"`
+	Key  *jose.JSONWebKey `json:"key"`
+	// MaxPathLen is the maximum number of CAs that can follow the issued CA
+	// in a chain, 0 only allows the issued CA to sign leaf certificates.
+	MaxPathLen int `json:"maxPathLen,omitempty"`
+	// NameConstraints are the name constraints added to the issued CAs.
+	NameConstraints *policy.NameConstraints `json:"nameConstraints,omitempty"`
+	Claims          *Claims                 `json:"claims,omitempty"`
+	claimer         *Claimer
+	audiences       Audiences
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *SubCA) GetID() string {
+	return "subca/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *SubCA) GetTokenID(ott string) (string, error) {
+	token, err := jose.ParseSigned(ott)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+	var claims jose.Claims
+	if err = token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	return claims.ID, nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *SubCA) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *SubCA) GetType() Type {
+	return TypeSubCA
+}
+
+// GetEncryptedKey returns the base provisioner encrypted key if it's defined.
+func (p *SubCA) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// Init initializes and validates the fields of a SubCA type.
+func (p *SubCA) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case p.Key == nil:
+		return errors.New("provisioner key cannot be empty")
+	case p.MaxPathLen < 0:
+		return errors.New("provisioner maxPathLen cannot be negative")
+	}
+	if err := p.NameConstraints.Validate(); err != nil {
+		return errors.Wrap(err, "error validating provisioner nameConstraints")
+	}
+
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+
+	p.audiences = config.Audiences.WithFragment(p.GetID())
+	return nil
+}
+
+// AuthorizeSign returns an error, the certificates of a SubCA provisioner
+// must be requested with AuthorizeSubCA and approved by an administrator.
+func (p *SubCA) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	return nil, errs.Forbidden("subca.AuthorizeSign; subca provisioner %s requires the approval of an administrator", p.GetID(),
+		errs.WithMessage("certificates of provisioner %s require the approval of an administrator", p.GetName()))
+}
+
+// AuthorizeSubCA validates the token used to request a sub-CA certificate and
+// returns the subject and the SANs authorized by it.
+func (p *SubCA) AuthorizeSubCA(ctx context.Context, token string) (string, []string, error) {
+	if p.claimer.IsDisableIssuance() {
+		return "", nil, errs.Forbidden("subca.AuthorizeSubCA; issuance is disabled for subca provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", nil, errs.Wrap(http.StatusUnauthorized, err, "subca.AuthorizeSubCA; error parsing subca token")
+	}
+	var claims jwtPayload
+	if err = jwt.Claims(p.Key, &claims); err != nil {
+		return "", nil, errs.Wrap(http.StatusUnauthorized, err, "subca.AuthorizeSubCA; error parsing subca claims")
+	}
+	if err = claims.ValidateWithLeeway(jose.Expected{
+		Issuer: p.Name,
+		Time:   time.Now().UTC(),
+	}, p.claimer.ClockSkew()); err != nil {
+		return "", nil, errs.Wrap(http.StatusUnauthorized, err, "subca.AuthorizeSubCA; invalid subca claims")
+	}
+	if !matchesAudience(claims.Audience, p.audiences.Sign) {
+		return "", nil, errs.Unauthorized("subca.AuthorizeSubCA; invalid subca token audience claim (aud); want %s, but got %s",
+			p.audiences.Sign, claims.Audience)
+	}
+	if claims.Subject == "" {
+		return "", nil, errs.Unauthorized("subca.AuthorizeSubCA; subca token subject cannot be empty")
+	}
+	return claims.Subject, claims.SANs, nil
+}
+
+// SignOptions returns the options used to sign the certificate of an approved
+// sub-CA with the given subject and SANs.
+func (p *SubCA) SignOptions(subject string, sans []string) []SignOption {
+	dnsNames, ips, emails := x509util.SplitSANs(sans)
+	return []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeSubCA, p.Name, p.Key.KeyID),
+		profileDefaultDuration(p.claimer.DefaultTLSCertDuration()),
+		backdateOption(p.claimer.Backdate()),
+		&subCAModifier{maxPathLen: p.MaxPathLen, nameConstraints: p.NameConstraints},
+		// validators
+		commonNameValidator(subject),
+		defaultPublicKeyValidator{},
+		dnsNamesValidator(dnsNames),
+		emailAddressesValidator(emails),
+		ipAddressesValidator(ips),
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+	}
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled. The renewed
+// certificate keeps the basic constraints and name constraints of the old one.
+func (p *SubCA) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("subca.AuthorizeRenew; renew is disabled for subca provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "subca.AuthorizeRenew")
+	}
+	return nil
+}
+
+// subCAModifier sets the basic constraints, key usages and name constraints
+// of a subordinate CA certificate.
+type subCAModifier struct {
+	maxPathLen      int
+	nameConstraints *policy.NameConstraints
+}
+
+// Option returns the x509util.WithOption that sets the CA profile.
+func (m *subCAModifier) Option(Options) x509util.WithOption {
+	return func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.IsCA = true
+		crt.BasicConstraintsValid = true
+		crt.MaxPathLen = m.maxPathLen
+		crt.MaxPathLenZero = m.maxPathLen == 0
+		crt.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+		crt.ExtKeyUsage, crt.UnknownExtKeyUsage = nil, nil
+		return m.nameConstraints.Apply(crt)
+	}
+}
@@ -0,0 +1,163 @@
+package provisioner
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+)
+
+func generateSubCA() (*SubCA, *jose.JSONWebKey, error) {
+	jwk, err := generateJSONWebKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	public := jwk.Public()
+	p := &SubCA{
+		Type:       "SubCA",
+		Name:       "team-ca",
+		Key:        &public,
+		MaxPathLen: 0,
+		NameConstraints: &policy.NameConstraints{
+			Critical:  true,
+			Permitted: &policy.X509Names{DNS: []string{".team.internal"}},
+		},
+	}
+	if err := p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}); err != nil {
+		return nil, nil, err
+	}
+	return p, jwk, nil
+}
+
+func TestSubCA_Init(t *testing.T) {
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+	config := Config{Claims: globalProvisionerClaims, Audiences: testAudiences}
+	tests := []struct {
+		name    string
+		p       *SubCA
+		wantErr bool
+	}{
+		{"ok", &SubCA{Type: "SubCA", Name: "team-ca", Key: jwk, MaxPathLen: 1}, false},
+		{"fail/type", &SubCA{Name: "team-ca", Key: jwk}, true},
+		{"fail/name", &SubCA{Type: "SubCA", Key: jwk}, true},
+		{"fail/key", &SubCA{Type: "SubCA", Name: "team-ca"}, true},
+		{"fail/maxPathLen", &SubCA{Type: "SubCA", Name: "team-ca", Key: jwk, MaxPathLen: -1}, true},
+		{"fail/nameConstraints", &SubCA{Type: "SubCA", Name: "team-ca", Key: jwk, NameConstraints: &policy.NameConstraints{
+			Permitted: &policy.X509Names{DNS: []string{"*.team.internal"}},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Init(config); (err != nil) != tt.wantErr {
+				t.Errorf("SubCA.Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	p := tests[0].p
+	assert.Equals(t, "subca/team-ca", p.GetID())
+	assert.Equals(t, TypeSubCA, p.GetType())
+	assert.Equals(t, []string{"https://ca.smallstep.com/1.0/sign#subca/team-ca", "https://ca.smallstep.com/sign#subca/team-ca"}, p.audiences.Sign)
+}
+
+func TestSubCA_AuthorizeSign(t *testing.T) {
+	p, _, err := generateSubCA()
+	assert.FatalError(t, err)
+	_, err = p.AuthorizeSign(context.Background(), "foo")
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusForbidden, sc.StatusCode())
+	}
+}
+
+func TestSubCA_AuthorizeSubCA(t *testing.T) {
+	p, key, err := generateSubCA()
+	assert.FatalError(t, err)
+	jwk, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	aud := testAudiences.Sign[0] + "#subca/team-ca"
+	sans := []string{"ca.team.internal"}
+	tests := []struct {
+		name       string
+		token      string
+		statusCode int
+	}{
+		{"ok", mustToken(t, "Team CA", p.Name, aud, sans, now, key), 0},
+		{"fail/token", "foo", http.StatusUnauthorized},
+		{"fail/key", mustToken(t, "Team CA", p.Name, aud, sans, now, jwk), http.StatusUnauthorized},
+		{"fail/issuer", mustToken(t, "Team CA", "foo", aud, sans, now, key), http.StatusUnauthorized},
+		{"fail/expired", mustToken(t, "Team CA", p.Name, aud, sans, now.Add(-time.Hour), key), http.StatusUnauthorized},
+		{"fail/audience", mustToken(t, "Team CA", p.Name, testAudiences.Sign[0], sans, now, key), http.StatusUnauthorized},
+		{"fail/subject", mustToken(t, "", p.Name, aud, sans, now, key), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, gotSANs, err := p.AuthorizeSubCA(context.Background(), tt.token)
+			if tt.statusCode != 0 {
+				if assert.Error(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tt.statusCode, sc.StatusCode())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, "Team CA", subject)
+			assert.Equals(t, sans, gotSANs)
+		})
+	}
+
+	disabled := true
+	p.claimer, err = NewClaimer(&Claims{DisableIssuance: &disabled}, globalProvisionerClaims)
+	assert.FatalError(t, err)
+	_, _, err = p.AuthorizeSubCA(context.Background(), tests[0].token)
+	if assert.Error(t, err) {
+		assert.Equals(t, http.StatusForbidden, err.(errs.StatusCoder).StatusCode())
+	}
+}
+
+func TestSubCA_SignOptions(t *testing.T) {
+	p, _, err := generateSubCA()
+	assert.FatalError(t, err)
+
+	var modifier *subCAModifier
+	for _, op := range p.SignOptions("Team CA", []string{"ca.team.internal"}) {
+		if m, ok := op.(*subCAModifier); ok {
+			modifier = m
+		}
+	}
+	assert.Fatal(t, modifier != nil, "subCAModifier not found")
+
+	prof := &x509util.Leaf{}
+	prof.SetSubject(&x509.Certificate{
+		KeyUsage:    x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	assert.FatalError(t, modifier.Option(Options{})(prof))
+	crt := prof.Subject()
+	assert.True(t, crt.IsCA)
+	assert.True(t, crt.BasicConstraintsValid)
+	assert.Equals(t, 0, crt.MaxPathLen)
+	assert.True(t, crt.MaxPathLenZero)
+	assert.Equals(t, x509.KeyUsageCertSign|x509.KeyUsageCRLSign|x509.KeyUsageDigitalSignature, crt.KeyUsage)
+	assert.Len(t, 0, crt.ExtKeyUsage)
+	assert.True(t, crt.PermittedDNSDomainsCritical)
+	assert.Equals(t, []string{".team.internal"}, crt.PermittedDNSDomains)
+}
+
+func mustToken(t *testing.T, sub, iss, aud string, sans []string, iat time.Time, jwk *jose.JSONWebKey) string {
+	t.Helper()
+	tok, err := generateToken(sub, iss, aud, "", sans, iat, jwk)
+	assert.FatalError(t, err)
+	return tok
+}
@@ -0,0 +1,673 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/x509util"
+	"github.com/smallstep/cli/jose"
+)
+
+// TPM 2.0 constants, as defined in the TPM 2.0 Library Specification, Part 2:
+// Structures.
+const (
+	tpmGeneratedValue   = 0xff544347
+	tpmSTAttestCertify  = 0x8017
+	tpmAlgRSA           = 0x0001
+	tpmAlgSHA1          = 0x0004
+	tpmAlgSHA256        = 0x000B
+	tpmAlgSHA384        = 0x000C
+	tpmAlgSHA512        = 0x000D
+	tpmAlgNull          = 0x0010
+	tpmAlgRSASSA        = 0x0014
+	tpmAlgRSAPSS        = 0x0016
+	tpmAlgECDSA         = 0x0018
+	tpmAlgECDAA         = 0x001A
+	tpmAlgECC           = 0x0023
+	tpmECCNistP256      = 0x0003
+	tpmECCNistP384      = 0x0004
+	tpmECCNistP521      = 0x0005
+	tpmaFixedTPM        = 1 << 1
+	tpmaFixedParent     = 1 << 4
+	tpmaSensitiveOrigin = 1 << 5
+	tpmaRestricted      = 1 << 16
+	tpmaSign            = 1 << 18
+)
+
+// tpmPayload extends jwt.Claims with the TPM attestation of the key used to
+// sign the token.
+type tpmPayload struct {
+	jose.Claims
+	SANs   []string       `json:"sans,omitempty"`
+	TPM    tpmAttestation `json:"tpm"`
+	chains [][]*x509.Certificate
+	key    *tpmPublic
+}
+
+// tpmAttestation contains the AK certificate chain, the public area of the
+// attested key, and the TPMS_ATTEST structure created by TPM2_Certify with
+// its signature.
+type tpmAttestation struct {
+	AKChain   [][]byte `json:"akChain"`
+	PubArea   []byte   `json:"pubArea"`
+	CertInfo  []byte   `json:"certInfo"`
+	Signature []byte   `json:"signature"`
+}
+
+// tpmPublic is the parsed TPMT_PUBLIC structure of a key.
+type tpmPublic struct {
+	Type       uint16
+	NameAlg    uint16
+	Attributes uint32
+	PublicKey  crypto.PublicKey
+	name       []byte
+}
+
+// tpmCertifyInfo is the parsed TPMS_ATTEST structure created by TPM2_Certify.
+type tpmCertifyInfo struct {
+	Type          uint16
+	ExtraData     []byte
+	Name          []byte
+	QualifiedName []byte
+}
+
+// tpmHash returns the crypto.Hash for the given TPM hash algorithm.
+func tpmHash(alg uint16) (crypto.Hash, error) {
+	var h crypto.Hash
+	switch alg {
+	case tpmAlgSHA1:
+		h = crypto.SHA1
+	case tpmAlgSHA256:
+		h = crypto.SHA256
+	case tpmAlgSHA384:
+		h = crypto.SHA384
+	case tpmAlgSHA512:
+		h = crypto.SHA512
+	}
+	if h == 0 || !h.Available() {
+		return 0, errors.Errorf("unsupported tpm hash algorithm 0x%04x", alg)
+	}
+	return h, nil
+}
+
+// tpmReader reads the big-endian structures used by the TPM.
+type tpmReader struct {
+	*bytes.Reader
+}
+
+func (r tpmReader) uint16() (uint16, error) {
+	var v uint16
+	err := binary.Read(r, binary.BigEndian, &v)
+	return v, err
+}
+
+func (r tpmReader) uint32() (uint32, error) {
+	var v uint32
+	err := binary.Read(r, binary.BigEndian, &v)
+	return v, err
+}
+
+// tpm2B reads a TPM2B structure, a size followed by that number of bytes.
+func (r tpmReader) tpm2B() ([]byte, error) {
+	size, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// skip discards n bytes.
+func (r tpmReader) skip(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	return err
+}
+
+// scheme reads a scheme or symmetric definition, the algorithm followed by
+// the given number of uint16 details if the algorithm is not TPM_ALG_NULL.
+func (r tpmReader) scheme(details func(alg uint16) int64) error {
+	alg, err := r.uint16()
+	if err != nil || alg == tpmAlgNull {
+		return err
+	}
+	return r.skip(2 * details(alg))
+}
+
+// parseTPMPublic parses a TPMT_PUBLIC structure with an RSA or ECC key.
+func parseTPMPublic(b []byte) (*tpmPublic, error) {
+	r := tpmReader{bytes.NewReader(b)}
+	pub := new(tpmPublic)
+	var err error
+	if pub.Type, err = r.uint16(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+	if pub.NameAlg, err = r.uint16(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+	if pub.Attributes, err = r.uint32(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+	if _, err = r.tpm2B(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+	// TPMT_SYM_DEF_OBJECT contains the key bits and mode.
+	if err = r.scheme(func(uint16) int64 { return 2 }); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+
+	switch pub.Type {
+	case tpmAlgRSA:
+		var bits uint16
+		var exp uint32
+		var n []byte
+		if err = r.scheme(func(uint16) int64 { return 1 }); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if bits, err = r.uint16(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if exp, err = r.uint32(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if n, err = r.tpm2B(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if exp == 0 {
+			exp = 65537
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp)}
+		if key.N.BitLen() != int(bits) {
+			return nil, errors.New("error parsing tpm public area: invalid rsa key size")
+		}
+		pub.PublicKey = key
+	case tpmAlgECC:
+		var curveID uint16
+		var x, y []byte
+		// TPMS_SCHEME_ECDAA also contains the count.
+		if err = r.scheme(func(alg uint16) int64 {
+			if alg == tpmAlgECDAA {
+				return 2
+			}
+			return 1
+		}); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if curveID, err = r.uint16(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if err = r.scheme(func(uint16) int64 { return 1 }); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if x, err = r.tpm2B(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		if y, err = r.tpm2B(); err != nil {
+			return nil, errors.Wrap(err, "error parsing tpm public area")
+		}
+		var curve elliptic.Curve
+		switch curveID {
+		case tpmECCNistP256:
+			curve = elliptic.P256()
+		case tpmECCNistP384:
+			curve = elliptic.P384()
+		case tpmECCNistP521:
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("error parsing tpm public area: unsupported curve 0x%04x", curveID)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("error parsing tpm public area: invalid ecdsa key")
+		}
+		pub.PublicKey = key
+	default:
+		return nil, errors.Errorf("error parsing tpm public area: unsupported key type 0x%04x", pub.Type)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("error parsing tpm public area: unexpected trailing data")
+	}
+
+	// The name of an object is the nameAlg followed by the digest of the
+	// public area.
+	h, err := tpmHash(pub.NameAlg)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm public area")
+	}
+	hh := h.New()
+	hh.Write(b)
+	pub.name = make([]byte, 2, 2+h.Size())
+	binary.BigEndian.PutUint16(pub.name, pub.NameAlg)
+	pub.name = hh.Sum(pub.name)
+	return pub, nil
+}
+
+// parseTPMCertifyInfo parses a TPMS_ATTEST structure created by TPM2_Certify.
+func parseTPMCertifyInfo(b []byte) (*tpmCertifyInfo, error) {
+	r := tpmReader{bytes.NewReader(b)}
+	info := new(tpmCertifyInfo)
+	magic, err := r.uint32()
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	if magic != tpmGeneratedValue {
+		return nil, errors.New("error parsing tpm attestation: invalid magic value")
+	}
+	if info.Type, err = r.uint16(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	if info.Type != tpmSTAttestCertify {
+		return nil, errors.Errorf("error parsing tpm attestation: unsupported type 0x%04x", info.Type)
+	}
+	// qualifiedSigner
+	if _, err = r.tpm2B(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	if info.ExtraData, err = r.tpm2B(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	// TPMS_CLOCK_INFO and firmwareVersion
+	if err = r.skip(17 + 8); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	if info.Name, err = r.tpm2B(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	if info.QualifiedName, err = r.tpm2B(); err != nil {
+		return nil, errors.Wrap(err, "error parsing tpm attestation")
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("error parsing tpm attestation: unexpected trailing data")
+	}
+	return info, nil
+}
+
+// verifyTPMSignature verifies the TPMT_SIGNATURE of the given data.
+func verifyTPMSignature(key crypto.PublicKey, data, sig []byte) error {
+	r := tpmReader{bytes.NewReader(sig)}
+	alg, err := r.uint16()
+	if err != nil {
+		return errors.Wrap(err, "error parsing tpm signature")
+	}
+	hashAlg, err := r.uint16()
+	if err != nil {
+		return errors.Wrap(err, "error parsing tpm signature")
+	}
+	h, err := tpmHash(hashAlg)
+	if err != nil {
+		return errors.Wrap(err, "error parsing tpm signature")
+	}
+	hh := h.New()
+	hh.Write(data)
+	digest := hh.Sum(nil)
+
+	switch alg {
+	case tpmAlgRSASSA, tpmAlgRSAPSS:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("error verifying tpm signature: the key is not an rsa key")
+		}
+		s, err := r.tpm2B()
+		if err != nil {
+			return errors.Wrap(err, "error parsing tpm signature")
+		}
+		if alg == tpmAlgRSASSA {
+			err = rsa.VerifyPKCS1v15(pub, h, digest, s)
+		} else {
+			err = rsa.VerifyPSS(pub, h, digest, s, nil)
+		}
+		return errors.Wrap(err, "error verifying tpm signature")
+	case tpmAlgECDSA:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("error verifying tpm signature: the key is not an ecdsa key")
+		}
+		sr, err := r.tpm2B()
+		if err != nil {
+			return errors.Wrap(err, "error parsing tpm signature")
+		}
+		ss, err := r.tpm2B()
+		if err != nil {
+			return errors.Wrap(err, "error parsing tpm signature")
+		}
+		if !ecdsa.Verify(pub, digest, new(big.Int).SetBytes(sr), new(big.Int).SetBytes(ss)) {
+			return errors.New("error verifying tpm signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("error parsing tpm signature: unsupported algorithm 0x%04x", alg)
+	}
+}
+
+// tpmPublicKeyValidator validates that the public key in the certificate
+// request is the attested key.
+type tpmPublicKeyValidator struct {
+	key crypto.PublicKey
+}
+
+// Valid checks that the certificate request contains the attested key.
+func (v *tpmPublicKeyValidator) Valid(req *x509.CertificateRequest) error {
+	want, err := x509.MarshalPKIXPublicKey(v.key)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling attested key")
+	}
+	got, err := x509.MarshalPKIXPublicKey(req.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling certificate request key")
+	}
+	if !bytes.Equal(want, got) {
+		return errors.New("certificate request public key does not match the attested key")
+	}
+	return nil
+}
+
+// TPM is the provisioner that grants certificates to the keys attested by a
+// TPM 2.0. The tokens are signed with the attested key, and contain the chain
+// of the attestation key (AK) certificate, and the TPM2_Certify data, created
+// by certifying the key with the AK, and using the SHA-256 digest of the token
+// id as the qualifying data.
+//
+// The AK certificate must chain to one of the Roots, the roots of the TPM
+// manufacturers or the attestation CA that issues the AK certificates, and the
+// attested key must have been created in the TPM and cannot leave it.
+type TPM struct {
+	*base
+	Type      string              `json:"type"`
+	Name      string              `json:"name"`
+	Roots     []byte              `json:"roots"`
+	Claims    *Claims             `json:"claims,omitempty"`
+	Options   *CertificateOptions `json:"options,omitempty"`
+	claimer   *Claimer
+	audiences Audiences
+	rootPool  *x509.CertPool
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *TPM) GetID() string {
+	return "tpm/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token.
+func (p *TPM) GetTokenID(ott string) (string, error) {
+	token, err := jose.ParseSigned(ott)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+
+	// Get claims w/out verification.
+	var claims jose.Claims
+	if err = token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	return claims.ID, nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *TPM) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *TPM) GetType() Type {
+	return TypeTPM
+}
+
+// GetEncryptedKey is not available in a TPM provisioner.
+func (p *TPM) GetEncryptedKey() (string, string, bool) {
+	return "", "", false
+}
+
+// Init initializes and validates the fields of a TPM type.
+func (p *TPM) Init(config Config) error {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case len(p.Roots) == 0:
+		return errors.New("provisioner root(s) cannot be empty")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	p.rootPool = x509.NewCertPool()
+
+	var (
+		block *pem.Block
+		rest  = p.Roots
+	)
+	for rest != nil {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrap(err, "error parsing x509 certificate from PEM block")
+		}
+		p.rootPool.AddCert(cert)
+	}
+
+	// Verify that at least one root was found.
+	if len(p.rootPool.Subjects()) == 0 {
+		return errors.Errorf("no x509 certificates found in roots attribute for provisioner %s", p.GetName())
+	}
+
+	// Update claims with global ones
+	var err error
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+
+	p.audiences = config.Audiences.WithFragment(p.GetID())
+	return nil
+}
+
+// verifyAttestation verifies the TPM attestation in the token and returns the
+// verified chains of the AK certificate and the attested key.
+func (p *TPM) verifyAttestation(att *tpmAttestation, jti string) ([][]*x509.Certificate, *tpmPublic, error) {
+	if len(att.AKChain) == 0 {
+		return nil, nil, errors.New("tpm attestation does not contain the ak certificate")
+	}
+	var certs []*x509.Certificate
+	for _, b := range att.AKChain {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error parsing ak certificate")
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         p.rootPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error verifying ak certificate chain")
+	}
+	ak := chains[0][0]
+	if ak.IsCA {
+		return nil, nil, errors.New("ak certificate cannot be a CA")
+	}
+
+	// The attestation must be signed by the AK.
+	if err := verifyTPMSignature(ak.PublicKey, att.CertInfo, att.Signature); err != nil {
+		return nil, nil, err
+	}
+	info, err := parseTPMCertifyInfo(att.CertInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := parseTPMPublic(att.PubArea)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The attestation must certify the given key, and be bound to the token.
+	if subtle.ConstantTimeCompare(info.Name, key.name) != 1 {
+		return nil, nil, errors.New("tpm attestation does not certify the attested key")
+	}
+	sum := sha256.Sum256([]byte(jti))
+	if subtle.ConstantTimeCompare(info.ExtraData, sum[:]) != 1 {
+		return nil, nil, errors.New("tpm attestation qualifying data does not match the token id")
+	}
+
+	// The key must be a signing key created and kept in the TPM.
+	attrs := uint32(tpmaFixedTPM | tpmaFixedParent | tpmaSensitiveOrigin | tpmaSign)
+	switch {
+	case key.Attributes&attrs != attrs:
+		return nil, nil, errors.New("attested key must be a signing key generated in the tpm")
+	case key.Attributes&tpmaRestricted != 0:
+		return nil, nil, errors.New("attested key cannot be a restricted key")
+	}
+	return chains, key, nil
+}
+
+// authorizeToken performs common jwt authorization actions and returns the
+// claims for case specific downstream parsing.
+// e.g. a Sign request will auth/validate different fields than a Revoke request.
+func (p *TPM) authorizeToken(token string, audiences []string) (*tpmPayload, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm token")
+	}
+
+	var unsafeClaims tpmPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&unsafeClaims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error unmarshaling claims")
+	}
+	if unsafeClaims.ID == "" {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token id cannot be empty")
+	}
+
+	chains, key, err := p.verifyAttestation(&unsafeClaims.TPM, unsafeClaims.ID)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error verifying tpm attestation")
+	}
+
+	// The token must be signed with the attested key.
+	var claims tpmPayload
+	if err = jwt.Claims(key.PublicKey, &claims); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "tpm.authorizeToken; error parsing tpm claims")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = claims.ValidateWithLeeway(jose.Expected{
+		Issuer: p.Name,
+		Time:   time.Now().UTC(),
+	}, p.claimer.ClockSkew()); err != nil {
+		return nil, errs.Wrapf(http.StatusUnauthorized, err, "tpm.authorizeToken; invalid tpm claims")
+	}
+
+	// validate audiences with the defaults
+	if !matchesAudience(claims.Audience, audiences) {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token has invalid audience "+
+			"claim (aud); expected %s, but got %s", audiences, claims.Audience)
+	}
+
+	if claims.Subject == "" {
+		return nil, errs.Unauthorized("tpm.authorizeToken; tpm token subject cannot be empty")
+	}
+
+	claims.chains = chains
+	claims.key = key
+	return &claims, nil
+}
+
+// AuthorizeRevoke returns an error if the provisioner does not have rights to
+// revoke the certificate with serial number in the `sub` property.
+func (p *TPM) AuthorizeRevoke(ctx context.Context, token string) error {
+	_, err := p.authorizeToken(token, p.audiences.Revoke)
+	return errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeRevoke")
+}
+
+// AuthorizeSign validates the given token and returns the sign options that
+// will be used on certificate creation.
+func (p *TPM) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("tpm.AuthorizeSign; issuance is disabled for tpm provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "tpm.AuthorizeSign")
+	}
+
+	if len(claims.SANs) == 0 {
+		claims.SANs = []string{claims.Subject}
+	}
+
+	ak := claims.chains[0][0]
+	signOptions := []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeTPM, p.Name, "", "KeyName", hex.EncodeToString(claims.key.name),
+			"AKSerialNumber", ak.SerialNumber.String()),
+		profileLimitDuration{p.claimer.DefaultTLSCertDuration(), ak.NotAfter},
+		backdateOption(p.claimer.Backdate()),
+		// validators
+		defaultPublicKeyValidator{},
+		&tpmPublicKeyValidator{claims.key.PublicKey},
+		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(p.claimer),
+	}
+
+	// SPIFFE X.509 SVIDs can only contain one of the SPIFFE IDs in the token.
+	if p.claimer.IsSPIFFEEnabled() {
+		ids := spiffeIDs(claims.SANs)
+		if len(ids) == 0 {
+			return nil, errs.Unauthorized("tpm.AuthorizeSign; tpm token does not contain any SPIFFE ID")
+		}
+		signOptions = append(signOptions, newSPIFFESignOptions(p.claimer, ids)...)
+		return withX509CertificateOptions(p.Options, signOptions, newTemplateData(token, claims.Subject, claims.SANs)), nil
+	}
+
+	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
+	signOptions = append(signOptions,
+		commonNameValidator(claims.Subject),
+		dnsNamesValidator(dnsNames),
+		emailAddressesValidator(emails),
+		ipAddressesValidator(ips),
+	)
+	return withX509CertificateOptions(p.Options, signOptions, newTemplateData(token, claims.Subject, claims.SANs)), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+func (p *TPM) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("tpm.AuthorizeRenew; renew is disabled for tpm provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "tpm.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "tpm.AuthorizeRenew")
+	}
+	return nil
+}
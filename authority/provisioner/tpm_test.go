@@ -0,0 +1,578 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+const tpmTestKeyAttributes = tpmaFixedTPM | tpmaFixedParent | tpmaSensitiveOrigin | tpmaSign
+
+// tpmTestDevice emulates a TPM with an AK certified by a manufacturer root,
+// and a signing key.
+type tpmTestDevice struct {
+	rootPEM []byte
+	akKey   *ecdsa.PrivateKey
+	akCert  *x509.Certificate
+	key     *ecdsa.PrivateKey
+}
+
+func newTPMTestDevice() (*tpmTestDevice, error) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TPM Manufacturer Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+	if err != nil {
+		return nil, err
+	}
+	root, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, err
+	}
+
+	akKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	akTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	b, err = x509.CreateCertificate(rand.Reader, akTmpl, root, akKey.Public(), rootKey)
+	if err != nil {
+		return nil, err
+	}
+	akCert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &tpmTestDevice{
+		rootPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+		akKey:   akKey,
+		akCert:  akCert,
+		key:     key,
+	}, nil
+}
+
+// tpmTestWriter writes the big-endian structures used by the TPM.
+type tpmTestWriter struct {
+	bytes.Buffer
+}
+
+func (w *tpmTestWriter) uint16(v uint16) { binary.Write(w, binary.BigEndian, v) }
+func (w *tpmTestWriter) uint32(v uint32) { binary.Write(w, binary.BigEndian, v) }
+func (w *tpmTestWriter) uint64(v uint64) { binary.Write(w, binary.BigEndian, v) }
+func (w *tpmTestWriter) tpm2B(b []byte) {
+	w.uint16(uint16(len(b)))
+	w.Write(b)
+}
+
+// tpmTestPubArea returns the TPMT_PUBLIC structure of the given key.
+func tpmTestPubArea(key crypto.PublicKey, attrs uint32) []byte {
+	w := new(tpmTestWriter)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		w.uint16(tpmAlgECC)
+		w.uint16(tpmAlgSHA256)
+		w.uint32(attrs)
+		w.tpm2B(nil)
+		w.uint16(tpmAlgNull) // symmetric
+		w.uint16(tpmAlgECDSA)
+		w.uint16(tpmAlgSHA256)
+		w.uint16(tpmECCNistP256)
+		w.uint16(tpmAlgNull) // kdf
+		w.tpm2B(k.X.Bytes())
+		w.tpm2B(k.Y.Bytes())
+	case *rsa.PublicKey:
+		w.uint16(tpmAlgRSA)
+		w.uint16(tpmAlgSHA256)
+		w.uint32(attrs)
+		w.tpm2B(nil)
+		w.uint16(tpmAlgNull) // symmetric
+		w.uint16(tpmAlgRSASSA)
+		w.uint16(tpmAlgSHA256)
+		w.uint16(uint16(k.N.BitLen()))
+		w.uint32(0) // default exponent
+		w.tpm2B(k.N.Bytes())
+	}
+	return w.Bytes()
+}
+
+// tpmTestName returns the name of the given public area.
+func tpmTestName(pubArea []byte) []byte {
+	sum := sha256.Sum256(pubArea)
+	return append([]byte{0x00, 0x0B}, sum[:]...)
+}
+
+// tpmTestCertifyInfo returns the TPMS_ATTEST structure created by
+// TPM2_Certify.
+func tpmTestCertifyInfo(name, extraData []byte) []byte {
+	w := new(tpmTestWriter)
+	w.uint32(tpmGeneratedValue)
+	w.uint16(tpmSTAttestCertify)
+	w.tpm2B([]byte("qualified-signer"))
+	w.tpm2B(extraData)
+	w.uint64(123456) // clock
+	w.uint32(1)      // resetCount
+	w.uint32(2)      // restartCount
+	w.Write([]byte{1})
+	w.uint64(0x2000) // firmwareVersion
+	w.tpm2B(name)
+	w.tpm2B([]byte("qualified-name"))
+	return w.Bytes()
+}
+
+// tpmTestSignature returns the TPMT_SIGNATURE of the data.
+func tpmTestSignature(key crypto.Signer, data []byte) ([]byte, error) {
+	sum := sha256.Sum256(data)
+	w := new(tpmTestWriter)
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		w.uint16(tpmAlgECDSA)
+		w.uint16(tpmAlgSHA256)
+		w.tpm2B(r.Bytes())
+		w.tpm2B(s.Bytes())
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		w.uint16(tpmAlgRSASSA)
+		w.uint16(tpmAlgSHA256)
+		w.tpm2B(sig)
+	}
+	return w.Bytes(), nil
+}
+
+// attestation returns the attestation of the device key for the given token id.
+func (d *tpmTestDevice) attestation(jti string, attrs uint32) (tpmAttestation, error) {
+	pubArea := tpmTestPubArea(d.key.Public(), attrs)
+	sum := sha256.Sum256([]byte(jti))
+	certInfo := tpmTestCertifyInfo(tpmTestName(pubArea), sum[:])
+	sig, err := tpmTestSignature(d.akKey, certInfo)
+	if err != nil {
+		return tpmAttestation{}, err
+	}
+	return tpmAttestation{
+		AKChain:   [][]byte{d.akCert.Raw},
+		PubArea:   pubArea,
+		CertInfo:  certInfo,
+		Signature: sig,
+	}, nil
+}
+
+func generateTPM(root []byte) (*TPM, error) {
+	p := &TPM{
+		Type:  "TPM",
+		Name:  "tpm",
+		Roots: root,
+	}
+	return p, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences})
+}
+
+func generateTPMToken(sub, iss, aud, jti string, sans []string, iat time.Time, att tpmAttestation, key crypto.Signer) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+	claims := tpmPayload{
+		Claims: jose.Claims{
+			ID:        jti,
+			Subject:   sub,
+			Issuer:    iss,
+			IssuedAt:  jose.NewNumericDate(iat),
+			NotBefore: jose.NewNumericDate(iat),
+			Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+		},
+		SANs: sans,
+		TPM:  att,
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+func TestTPM_Getters(t *testing.T) {
+	p := &TPM{Type: "TPM", Name: "tpm"}
+	assert.Equals(t, "tpm/tpm", p.GetID())
+	assert.Equals(t, "tpm", p.GetName())
+	assert.Equals(t, TypeTPM, p.GetType())
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("TPM.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+}
+
+func TestTPM_Init(t *testing.T) {
+	d, err := newTPMTestDevice()
+	assert.FatalError(t, err)
+
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+	badClaims := &Claims{
+		DefaultTLSDur: &Duration{0},
+	}
+	tests := []struct {
+		name    string
+		p       *TPM
+		wantErr bool
+	}{
+		{"ok", &TPM{Type: "TPM", Name: "tpm", Roots: d.rootPEM}, false},
+		{"fail type", &TPM{Type: "", Name: "tpm", Roots: d.rootPEM}, true},
+		{"fail name", &TPM{Type: "TPM", Name: "", Roots: d.rootPEM}, true},
+		{"fail roots", &TPM{Type: "TPM", Name: "tpm"}, true},
+		{"fail no roots", &TPM{Type: "TPM", Name: "tpm", Roots: []byte("foo")}, true},
+		{"fail bad roots", &TPM{Type: "TPM", Name: "tpm", Roots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("foo")})}, true},
+		{"fail claims", &TPM{Type: "TPM", Name: "tpm", Roots: d.rootPEM, Claims: badClaims}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPM.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, config.Audiences.WithFragment(tt.p.GetID()), tt.p.audiences)
+			}
+		})
+	}
+}
+
+func Test_parseTPMPublic(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+
+	ecPubArea := tpmTestPubArea(ecKey.Public(), tpmTestKeyAttributes)
+	rsaPubArea := tpmTestPubArea(rsaKey.Public(), tpmTestKeyAttributes)
+	badType := append([]byte{0x00, 0x08}, ecPubArea[2:]...)
+	badNameAlg := append([]byte{0x00, 0x23, 0x00, 0x12}, ecPubArea[4:]...)
+	badCurve := append([]byte{}, ecPubArea...)
+	badCurve[16] = 0x09
+	badPoint := append([]byte{}, ecPubArea...)
+	badPoint[len(badPoint)-1]++
+
+	tests := []struct {
+		name    string
+		pubArea []byte
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok ecdsa", ecPubArea, ecKey.Public(), false},
+		{"ok rsa", rsaPubArea, rsaKey.Public(), false},
+		{"fail empty", nil, nil, true},
+		{"fail truncated", ecPubArea[:len(ecPubArea)-1], nil, true},
+		{"fail trailing", append(append([]byte{}, ecPubArea...), 0), nil, true},
+		{"fail type", badType, nil, true},
+		{"fail nameAlg", badNameAlg, nil, true},
+		{"fail curve", badCurve, nil, true},
+		{"fail point", badPoint, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTPMPublic(tt.pubArea)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTPMPublic() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, tt.want, got.PublicKey)
+				assert.Equals(t, uint32(tpmTestKeyAttributes), got.Attributes)
+				assert.Equals(t, tpmTestName(tt.pubArea), got.name)
+			}
+		})
+	}
+}
+
+func Test_parseTPMCertifyInfo(t *testing.T) {
+	certInfo := tpmTestCertifyInfo([]byte("name"), []byte("extra-data"))
+	badMagic := append([]byte{0xff, 0x54, 0x43, 0x48}, certInfo[4:]...)
+	badType := append([]byte{}, certInfo...)
+	badType[5] = 0x18
+
+	got, err := parseTPMCertifyInfo(certInfo)
+	assert.FatalError(t, err)
+	assert.Equals(t, &tpmCertifyInfo{
+		Type:          tpmSTAttestCertify,
+		ExtraData:     []byte("extra-data"),
+		Name:          []byte("name"),
+		QualifiedName: []byte("qualified-name"),
+	}, got)
+
+	for name, b := range map[string][]byte{
+		"empty":     nil,
+		"magic":     badMagic,
+		"type":      badType,
+		"truncated": certInfo[:len(certInfo)-1],
+		"trailing":  append(append([]byte{}, certInfo...), 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseTPMCertifyInfo(b)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_verifyTPMSignature(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+
+	data := []byte("the-data")
+	ecSig, err := tpmTestSignature(ecKey, data)
+	assert.FatalError(t, err)
+	rsaSig, err := tpmTestSignature(rsaKey, data)
+	assert.FatalError(t, err)
+
+	sum := sha256.Sum256(data)
+	pss, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, sum[:], nil)
+	assert.FatalError(t, err)
+	w := new(tpmTestWriter)
+	w.uint16(tpmAlgRSAPSS)
+	w.uint16(tpmAlgSHA256)
+	w.tpm2B(pss)
+	pssSig := w.Bytes()
+
+	badAlg := append([]byte{0x00, 0x1C}, ecSig[2:]...)
+	badHash := append([]byte{0x00, 0x18, 0x00, 0x12}, ecSig[4:]...)
+
+	tests := []struct {
+		name    string
+		key     crypto.PublicKey
+		data    []byte
+		sig     []byte
+		wantErr bool
+	}{
+		{"ok ecdsa", ecKey.Public(), data, ecSig, false},
+		{"ok rsassa", rsaKey.Public(), data, rsaSig, false},
+		{"ok rsapss", rsaKey.Public(), data, pssSig, false},
+		{"fail ecdsa key", otherKey.Public(), data, ecSig, true},
+		{"fail ecdsa data", ecKey.Public(), []byte("other-data"), ecSig, true},
+		{"fail rsa data", rsaKey.Public(), []byte("other-data"), rsaSig, true},
+		{"fail ecdsa type", rsaKey.Public(), data, ecSig, true},
+		{"fail rsa type", ecKey.Public(), data, rsaSig, true},
+		{"fail alg", ecKey.Public(), data, badAlg, true},
+		{"fail hash", ecKey.Public(), data, badHash, true},
+		{"fail empty", ecKey.Public(), data, nil, true},
+		{"fail truncated", ecKey.Public(), data, ecSig[:len(ecSig)-1], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyTPMSignature(tt.key, tt.data, tt.sig); (err != nil) != tt.wantErr {
+				t.Errorf("verifyTPMSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTPM_AuthorizeSign(t *testing.T) {
+	d, err := newTPMTestDevice()
+	assert.FatalError(t, err)
+	other, err := newTPMTestDevice()
+	assert.FatalError(t, err)
+
+	p1, err := generateTPM(d.rootPEM)
+	assert.FatalError(t, err)
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	att, err := d.attestation("the-jti", tpmTestKeyAttributes)
+	assert.FatalError(t, err)
+
+	t1, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, att, d.key)
+	assert.FatalError(t, err)
+	t2, err := generateTPMToken("device", "tpm", aud, "the-jti", []string{"device.internal", "10.0.0.1"}, now, att, d.key)
+	assert.FatalError(t, err)
+
+	// AK certificate from a different manufacturer
+	otherAtt := att
+	otherAtt.AKChain = [][]byte{other.akCert.Raw}
+	failRoot, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, otherAtt, d.key)
+	assert.FatalError(t, err)
+	// Attestation signed by a different AK
+	otherAtt = att
+	otherAtt.Signature, err = tpmTestSignature(other.akKey, att.CertInfo)
+	assert.FatalError(t, err)
+	failAK, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, otherAtt, d.key)
+	assert.FatalError(t, err)
+	// Attestation of a different key
+	otherAtt = att
+	otherAtt.PubArea = tpmTestPubArea(other.key.Public(), tpmTestKeyAttributes)
+	failName, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, otherAtt, other.key)
+	assert.FatalError(t, err)
+	// Attestation for a different token
+	failJTI, err := generateTPMToken("device.internal", "tpm", aud, "other-jti", nil, now, att, d.key)
+	assert.FatalError(t, err)
+	// Token not signed by the attested key
+	failKey, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, att, other.key)
+	assert.FatalError(t, err)
+	// Key that can leave the TPM
+	exportable, err := d.attestation("the-jti", tpmaSign)
+	assert.FatalError(t, err)
+	failExportable, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, exportable, d.key)
+	assert.FatalError(t, err)
+	restricted, err := d.attestation("the-jti", tpmTestKeyAttributes|tpmaRestricted)
+	assert.FatalError(t, err)
+	failRestricted, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, restricted, d.key)
+	assert.FatalError(t, err)
+	// Missing AK chain
+	otherAtt = att
+	otherAtt.AKChain = nil
+	failChain, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now, otherAtt, d.key)
+	assert.FatalError(t, err)
+	failNoJTI, err := generateTPMToken("device.internal", "tpm", aud, "", nil, now, att, d.key)
+	assert.FatalError(t, err)
+	failIss, err := generateTPMToken("device.internal", "foo", aud, "the-jti", nil, now, att, d.key)
+	assert.FatalError(t, err)
+	failAud, err := generateTPMToken("device.internal", "tpm", "https://ca.smallstep.com/1.0/sign#tpm/foo", "the-jti", nil, now, att, d.key)
+	assert.FatalError(t, err)
+	failExp, err := generateTPMToken("device.internal", "tpm", aud, "the-jti", nil, now.Add(-360*time.Second), att, d.key)
+	assert.FatalError(t, err)
+	failSub, err := generateTPMToken("", "tpm", aud, "the-jti", nil, now, att, d.key)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"ok", t1, false},
+		{"ok sans", t2, false},
+		{"fail token", "foo", true},
+		{"fail root", failRoot, true},
+		{"fail ak", failAK, true},
+		{"fail name", failName, true},
+		{"fail jti", failJTI, true},
+		{"fail key", failKey, true},
+		{"fail exportable", failExportable, true},
+		{"fail restricted", failRestricted, true},
+		{"fail chain", failChain, true},
+		{"fail no jti", failNoJTI, true},
+		{"fail iss", failIss, true},
+		{"fail aud", failAud, true},
+		{"fail exp", failExp, true},
+		{"fail sub", failSub, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p1.AuthorizeSign(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPM.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+				assert.Nil(t, got)
+				return
+			}
+			assert.Len(t, 12, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case *tpmPublicKeyValidator:
+					assert.NoError(t, v.Valid(&x509.CertificateRequest{PublicKey: d.key.Public()}))
+					assert.Error(t, v.Valid(&x509.CertificateRequest{PublicKey: other.key.Public()}))
+				case profileLimitDuration:
+					assert.Equals(t, d.akCert.NotAfter, v.notAfter)
+				case backdateOption:
+				}
+			}
+		})
+	}
+}
+
+func TestTPM_AuthorizeRevoke(t *testing.T) {
+	d, err := newTPMTestDevice()
+	assert.FatalError(t, err)
+	p1, err := generateTPM(d.rootPEM)
+	assert.FatalError(t, err)
+
+	att, err := d.attestation("the-jti", tpmTestKeyAttributes)
+	assert.FatalError(t, err)
+	t1, err := generateTPMToken("serial-number", "tpm", "https://ca.smallstep.com/1.0/revoke#tpm/tpm", "the-jti", nil, time.Now(), att, d.key)
+	assert.FatalError(t, err)
+	failAud, err := generateTPMToken("serial-number", "tpm", "https://ca.smallstep.com/1.0/sign#tpm/tpm", "the-jti", nil, time.Now(), att, d.key)
+	assert.FatalError(t, err)
+
+	assert.NoError(t, p1.AuthorizeRevoke(context.Background(), t1))
+	err = p1.AuthorizeRevoke(context.Background(), failAud)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+	}
+}
+
+func TestTPM_AuthorizeRenew(t *testing.T) {
+	d, err := newTPMTestDevice()
+	assert.FatalError(t, err)
+	p1, err := generateTPM(d.rootPEM)
+	assert.FatalError(t, err)
+	p2, err := generateTPM(d.rootPEM)
+	assert.FatalError(t, err)
+
+	// disable renewal
+	disable := true
+	p2.Claims = &Claims{DisableRenewal: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	assert.NoError(t, p1.AuthorizeRenew(context.Background(), generateRenewCertificate()))
+	err = p2.AuthorizeRenew(context.Background(), generateRenewCertificate())
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+	}
+}
@@ -153,6 +153,13 @@ func decryptJSONWebKey(key string) (*jose.JSONWebKey, error) {
 	return jwk, nil
 }
 
+// generateRenewCertificate returns a certificate in its validity period, to be
+// used in the AuthorizeRenew tests.
+func generateRenewCertificate() *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+}
+
 func generateJWK() (*JWK, error) {
 	name, err := randutil.Alphanumeric(10)
 	if err != nil {
@@ -386,6 +393,39 @@ func generateGCP() (*GCP, error) {
 	}, nil
 }
 
+func generateGitHubActions() (*GitHubActions, error) {
+	name, err := randutil.Alphanumeric(10)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := generateJSONWebKey()
+	if err != nil {
+		return nil, err
+	}
+	claimer, err := NewClaimer(nil, globalProvisionerClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &GitHubActions{
+		Type: "GitHubActions",
+		Name: name,
+		Rules: []GitHubActionsRule{{
+			Repository: "smallstep/*",
+			Ref:        "refs/heads/master",
+			SANs:       []string{"{repository_owner}.ci.internal", "{environment}.ci.internal"},
+			Principals: []string{"{actor}"},
+		}},
+		Claims:  &globalProvisionerClaims,
+		claimer: claimer,
+		config:  newGitHubActionsConfig(),
+		keyStore: &keyStore{
+			keySet: jose.JSONWebKeySet{Keys: []jose.JSONWebKey{*jwk}},
+			expiry: time.Now().Add(24 * time.Hour),
+		},
+		audiences: testAudiences.WithFragment("github-actions/" + name),
+	}, nil
+}
+
 func generateAWS() (*AWS, error) {
 	name, err := randutil.Alphanumeric(10)
 	if err != nil {
@@ -691,6 +731,25 @@ func getK8sSAPayload() *k8sSAPayload {
 	}
 }
 
+func getK8sSABoundPayload(iss string, aud []string) *k8sSAPayload {
+	now := time.Now()
+	return &k8sSAPayload{
+		Claims: jose.Claims{
+			Issuer:    iss,
+			Subject:   "system:serviceaccount:ns-foo:san-foo",
+			Audience:  aud,
+			IssuedAt:  jose.NewNumericDate(now),
+			NotBefore: jose.NewNumericDate(now),
+			Expiry:    jose.NewNumericDate(now.Add(time.Hour)),
+		},
+		Kubernetes: &k8sSAKubernetesClaims{
+			Namespace:      "ns-foo",
+			ServiceAccount: k8sSAObjectRefClaim{Name: "san-foo", UID: "sauid-foo"},
+			Pod:            &k8sSAObjectRefClaim{Name: "pod-foo", UID: "poduid-foo"},
+		},
+	}
+}
+
 func generateK8sSAToken(jwk *jose.JSONWebKey, claims *k8sSAPayload, tokOpts ...tokOption) (string, error) {
 	so := new(jose.SignerOptions)
 	so.WithHeader("kid", jwk.KeyID)
@@ -798,6 +857,41 @@ func generateGCPToken(sub, iss, aud, instanceID, instanceName, projectID, zone s
 	return jose.Signed(sig).Claims(claims).CompactSerialize()
 }
 
+func getGitHubActionsPayload() *githubActionsPayload {
+	return &githubActionsPayload{
+		Claims: jose.Claims{
+			ID:      "the-jti",
+			Subject: "repo:smallstep/certificates:ref:refs/heads/master",
+			Issuer:  githubActionsIssuer,
+		},
+		Repository:      "smallstep/certificates",
+		RepositoryOwner: "smallstep",
+		Ref:             "refs/heads/master",
+		RefType:         "branch",
+		Workflow:        "CI",
+		Actor:           "octocat",
+		EventName:       "push",
+		RunID:           "1234",
+		SHA:             "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+	}
+}
+
+func generateGitHubActionsToken(aud string, iat time.Time, jwk *jose.JSONWebKey, claims *githubActionsPayload) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		new(jose.SignerOptions).WithType("JWT").WithHeader("kid", jwk.KeyID),
+	)
+	if err != nil {
+		return "", err
+	}
+	c := *claims
+	c.IssuedAt = jose.NewNumericDate(iat)
+	c.NotBefore = jose.NewNumericDate(iat)
+	c.Expiry = jose.NewNumericDate(iat.Add(5 * time.Minute))
+	c.Audience = []string{aud}
+	return jose.Signed(sig).Claims(c).CompactSerialize()
+}
+
 func generateAWSToken(sub, iss, aud, accountID, instanceID, privateIP, region string, iat time.Time, key crypto.Signer) (string, error) {
 	doc, err := json.MarshalIndent(awsInstanceIdentityDocument{
 		AccountID:        accountID,
@@ -995,3 +1089,52 @@ func parseCerts(b []byte) ([]*x509.Certificate, error) {
 	}
 	return certs, nil
 }
+
+func generateVault(address string) (*Vault, error) {
+	name, err := randutil.Alphanumeric(10)
+	if err != nil {
+		return nil, err
+	}
+	claimer, err := NewClaimer(nil, globalProvisionerClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{
+		Type:         "Vault",
+		Name:         name,
+		Address:      address,
+		AppRoleMount: vaultDefaultAppRoleMount,
+		Rules: []VaultRule{{
+			Policy:     "web*",
+			SANs:       []string{"{meta.service}.internal", "{meta.role_name}.internal"},
+			Principals: []string{"{display_name}"},
+		}},
+		Claims:    &globalProvisionerClaims,
+		claimer:   claimer,
+		client:    http.DefaultClient,
+		audiences: testAudiences.WithFragment("vault/" + name),
+	}, nil
+}
+
+func generateVaultToken(sub, iss, aud string, creds vaultCredentials, iat time.Time) (string, error) {
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: creds.key()},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+	claims := vaultPayload{
+		Claims: jose.Claims{
+			ID:        "the-jti",
+			Subject:   sub,
+			Issuer:    iss,
+			IssuedAt:  jose.NewNumericDate(iat),
+			NotBefore: jose.NewNumericDate(iat),
+			Expiry:    jose.NewNumericDate(iat.Add(5 * time.Minute)),
+			Audience:  []string{aud},
+		},
+		Vault: creds,
+	}
+	return jose.Signed(sig).Claims(claims).CompactSerialize()
+}
@@ -0,0 +1,616 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/randutil"
+	"github.com/smallstep/cli/jose"
+)
+
+// vaultIssuer is the string used as issuer in the generated tokens.
+const vaultIssuer = "vault"
+
+// vaultDefaultAppRoleMount is the path where the AppRole auth method is
+// mounted by default.
+const vaultDefaultAppRoleMount = "approle"
+
+const (
+	vaultLookupSelfURI = "/v1/auth/token/lookup-self"
+	vaultRevokeSelfURI = "/v1/auth/token/revoke-self"
+	vaultEntityURI     = "/v1/identity/entity/id/"
+)
+
+// vaultPayload extends jwt.Claims with the Vault credentials. The token is
+// signed with HS256 using the Vault token, or the AppRole secret id, as the key.
+type vaultPayload struct {
+	jose.Claims
+	Vault vaultCredentials `json:"vault"`
+}
+
+type vaultCredentials struct {
+	Token    string `json:"token,omitempty"`
+	RoleID   string `json:"role_id,omitempty"`
+	SecretID string `json:"secret_id,omitempty"`
+}
+
+// key returns the key used to sign the token.
+func (c *vaultCredentials) key() []byte {
+	if c.Token != "" {
+		return []byte(c.Token)
+	}
+	return []byte(c.SecretID)
+}
+
+// vaultTokenInfo is the data returned by the token lookup-self endpoint,
+// extended with the metadata of the entity.
+type vaultTokenInfo struct {
+	Accessor         string            `json:"accessor"`
+	DisplayName      string            `json:"display_name"`
+	EntityID         string            `json:"entity_id"`
+	Meta             map[string]string `json:"meta"`
+	Policies         []string          `json:"policies"`
+	IdentityPolicies []string          `json:"identity_policies"`
+	TTL              int64             `json:"ttl"`
+	EntityName       string            `json:"-"`
+	EntityMetadata   map[string]string `json:"-"`
+}
+
+// hasPolicy returns true if one of the policies of the token matches the
+// given pattern.
+func (i *vaultTokenInfo) hasPolicy(pattern string) bool {
+	for _, p := range append(i.Policies, i.IdentityPolicies...) {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the given names with the placeholders replaced by the values
+// of the token. Names using a placeholder without a value are skipped.
+func (i *vaultTokenInfo) names(names []string) []string {
+	values := map[string]string{
+		"{display_name}": i.DisplayName,
+		"{entity_id}":    i.EntityID,
+		"{entity_name}":  i.EntityName,
+	}
+	for k, v := range i.Meta {
+		values["{meta."+k+"}"] = v
+	}
+	// Entity metadata takes precedence over the token metadata.
+	for k, v := range i.EntityMetadata {
+		values["{meta."+k+"}"] = v
+	}
+	var ret []string
+	for _, name := range replacePlaceholders(names, values) {
+		// Skip names with metadata that is not available.
+		if !strings.Contains(name, "{meta.") {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+type vaultResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   *vaultAuth      `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+type vaultAuth struct {
+	ClientToken string `json:"client_token"`
+}
+
+type vaultEntity struct {
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// VaultRule maps the Vault tokens with a policy matching the Policy pattern
+// onto the names allowed in the certificates, and optionally limits the
+// duration of the certificates.
+//
+// SANs and Principals can use the placeholders {display_name}, {entity_id},
+// {entity_name} and {meta.<key>}, where <key> is a key in the metadata of the
+// token or the entity.
+type VaultRule struct {
+	Policy      string    `json:"policy"`
+	SANs        []string  `json:"sans,omitempty"`
+	Principals  []string  `json:"principals,omitempty"`
+	MaxDuration *Duration `json:"maxDuration,omitempty"`
+}
+
+// Validate validates the policy pattern and the duration of the rule.
+func (r *VaultRule) Validate() error {
+	if r.Policy == "" {
+		return errors.New("policy cannot be empty")
+	}
+	if _, err := path.Match(r.Policy, ""); err != nil {
+		return errors.Wrapf(err, "error parsing pattern %s", r.Policy)
+	}
+	if r.MaxDuration != nil && r.MaxDuration.Value() <= 0 {
+		return errors.New("maxDuration must be greater than 0")
+	}
+	return nil
+}
+
+// Vault is the provisioner that authenticates the requests using HashiCorp
+// Vault. The tokens are signed with a Vault token, or an AppRole secret id, and
+// contain the Vault credentials that the CA validates using the token
+// lookup-self endpoint or the AppRole login endpoint.
+//
+// Only the Vault tokens with a policy matching one of the Rules are accepted,
+// the certificates can only contain the SANs or principals of the matching
+// rules, and their duration cannot be longer than the TTL of the Vault token
+// or the maxDuration of the rules.
+//
+// If EntityMetadata is true, the metadata of the Vault entity is also used,
+// the Vault tokens must be allowed to read their own entity.
+//
+// Vault docs are available at https://www.vaultproject.io/api-docs/auth
+type Vault struct {
+	*base
+	Type           string              `json:"type"`
+	Name           string              `json:"name"`
+	Address        string              `json:"address"`
+	Namespace      string              `json:"namespace,omitempty"`
+	AppRoleMount   string              `json:"appRoleMount,omitempty"`
+	EntityMetadata bool                `json:"entityMetadata,omitempty"`
+	Rules          []VaultRule         `json:"rules"`
+	Claims         *Claims             `json:"claims,omitempty"`
+	Options        *CertificateOptions `json:"options,omitempty"`
+	HTTPClient     *HTTPClientOptions  `json:"httpClient,omitempty"`
+	claimer        *Claimer
+	audiences      Audiences
+	client         *http.Client
+}
+
+// GetID returns the provisioner unique identifier.
+func (p *Vault) GetID() string {
+	return "vault/" + p.Name
+}
+
+// GetTokenID returns the identifier of the token, the jti claim if present or
+// the SHA256 of the token otherwise.
+func (p *Vault) GetTokenID(token string) (string, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing token")
+	}
+
+	// Get claims w/out verification.
+	var claims vaultPayload
+	if err = jwt.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", errors.Wrap(err, "error verifying claims")
+	}
+	if claims.ID != "" {
+		return claims.ID, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	return strings.ToLower(hex.EncodeToString(sum[:])), nil
+}
+
+// GetName returns the name of the provisioner.
+func (p *Vault) GetName() string {
+	return p.Name
+}
+
+// GetType returns the type of provisioner.
+func (p *Vault) GetType() Type {
+	return TypeVault
+}
+
+// GetEncryptedKey is not available in a Vault provisioner.
+func (p *Vault) GetEncryptedKey() (kid string, key string, ok bool) {
+	return "", "", false
+}
+
+// GetIdentityToken creates a token with the Vault credentials in the
+// environment, the VAULT_TOKEN variable, or the VAULT_ROLE_ID and
+// VAULT_SECRET_ID variables if the AppRole auth method is used.
+func (p *Vault) GetIdentityToken(subject, caURL string) (string, error) {
+	creds := vaultCredentials{
+		Token:    os.Getenv("VAULT_TOKEN"),
+		RoleID:   os.Getenv("VAULT_ROLE_ID"),
+		SecretID: os.Getenv("VAULT_SECRET_ID"),
+	}
+	if creds.Token != "" {
+		creds.RoleID, creds.SecretID = "", ""
+	} else if creds.RoleID == "" || creds.SecretID == "" {
+		return "", errors.New("error getting vault credentials: VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID are not set")
+	}
+
+	audience, err := generateSignAudience(caURL, p.GetID())
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: creds.key()},
+		new(jose.SignerOptions).WithType("JWT"),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating signer")
+	}
+
+	id, err := randutil.Hex(64) // 256 bits
+	if err != nil {
+		return "", errors.Wrap(err, "error generating token id")
+	}
+
+	now := time.Now()
+	payload := vaultPayload{
+		Claims: jose.Claims{
+			Issuer:    vaultIssuer,
+			Subject:   subject,
+			Audience:  []string{audience},
+			Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+			NotBefore: jose.NewNumericDate(now),
+			IssuedAt:  jose.NewNumericDate(now),
+			ID:        id,
+		},
+		Vault: creds,
+	}
+
+	tok, err := jose.Signed(signer).Claims(payload).CompactSerialize()
+	if err != nil {
+		return "", errors.Wrap(err, "error serialiazing token")
+	}
+	return tok, nil
+}
+
+// Init validates and initializes the Vault provisioner.
+func (p *Vault) Init(config Config) (err error) {
+	switch {
+	case p.Type == "":
+		return errors.New("provisioner type cannot be empty")
+	case p.Name == "":
+		return errors.New("provisioner name cannot be empty")
+	case p.Address == "":
+		return errors.New("provisioner address cannot be empty")
+	case len(p.Rules) == 0:
+		return errors.New("provisioner rules cannot be empty")
+	}
+
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(p.Address)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing address %s", p.Address)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("address %s is not valid", p.Address)
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].Validate(); err != nil {
+			return errors.Wrapf(err, "error validating rules[%d]", i)
+		}
+	}
+	if p.AppRoleMount == "" {
+		p.AppRoleMount = vaultDefaultAppRoleMount
+	}
+	// Update claims with global ones
+	if p.claimer, err = NewClaimer(p.Claims, config.Claims); err != nil {
+		return err
+	}
+	// Create the client used to connect to Vault
+	if p.client, err = newHTTPClient(p.HTTPClient.withDefaultProxy(config.Proxy)); err != nil {
+		return err
+	}
+	p.audiences = config.Audiences.WithFragment(p.GetID())
+	return nil
+}
+
+// do sends a request to Vault and decodes the data of the response into v.
+func (p *Vault) do(method, uri, vaultToken string, body interface{}, v interface{}) error {
+	var r *bytes.Reader
+	if body == nil {
+		r = bytes.NewReader(nil)
+	} else {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling request")
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(p.Address, "/")+uri, r)
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if vaultToken != "" {
+		req.Header.Set("X-Vault-Token", vaultToken)
+	}
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to %s", p.Address)
+	}
+	defer resp.Body.Close()
+
+	var vr vaultResponse
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vr); err != nil {
+		return errors.Wrapf(err, "error decoding response from %s", uri)
+	}
+	if resp.StatusCode >= 400 {
+		return errors.Errorf("error from %s: %s %s", uri, resp.Status, strings.Join(vr.Errors, ", "))
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case *vaultAuth:
+		if vr.Auth == nil {
+			return errors.Errorf("error from %s: auth is missing", uri)
+		}
+		*v = *vr.Auth
+		return nil
+	default:
+		return errors.Wrapf(json.Unmarshal(vr.Data, v), "error decoding response from %s", uri)
+	}
+}
+
+// lookup validates the Vault credentials and returns the information of the
+// token. If the AppRole credentials are used, the token created in the login
+// is revoked after the lookup.
+func (p *Vault) lookup(creds vaultCredentials) (*vaultTokenInfo, error) {
+	vaultToken := creds.Token
+	if vaultToken == "" {
+		var auth vaultAuth
+		if err := p.do(http.MethodPost, "/v1/auth/"+strings.Trim(p.AppRoleMount, "/")+"/login", "", map[string]string{
+			"role_id":   creds.RoleID,
+			"secret_id": creds.SecretID,
+		}, &auth); err != nil {
+			return nil, err
+		}
+		vaultToken = auth.ClientToken
+		defer p.do(http.MethodPost, vaultRevokeSelfURI, vaultToken, nil, nil)
+	}
+
+	var info vaultTokenInfo
+	if err := p.do(http.MethodGet, vaultLookupSelfURI, vaultToken, nil, &info); err != nil {
+		return nil, err
+	}
+	if p.EntityMetadata && info.EntityID != "" {
+		var entity vaultEntity
+		if err := p.do(http.MethodGet, vaultEntityURI+info.EntityID, vaultToken, nil, &entity); err != nil {
+			return nil, err
+		}
+		info.EntityName = entity.Name
+		info.EntityMetadata = entity.Metadata
+	}
+	return &info, nil
+}
+
+// authorizeToken performs common jwt authorization actions and returns the
+// information of the Vault token and the rules matching it.
+func (p *Vault) authorizeToken(token string, audiences []string) (*vaultPayload, *vaultTokenInfo, []VaultRule, error) {
+	jwt, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, nil, nil, errs.Wrap(http.StatusUnauthorized, err, "vault.authorizeToken; error parsing vault token")
+	}
+	if len(jwt.Headers) == 0 {
+		return nil, nil, nil, errs.Unauthorized("vault.authorizeToken; error parsing vault token - header is missing")
+	}
+
+	var unsafeClaims vaultPayload
+	if err := jwt.UnsafeClaimsWithoutVerification(&unsafeClaims); err != nil {
+		return nil, nil, nil, errs.Wrap(http.StatusUnauthorized, err, "vault.authorizeToken; error unmarshaling claims")
+	}
+	creds := unsafeClaims.Vault
+	if creds.Token == "" && (creds.RoleID == "" || creds.SecretID == "") {
+		return nil, nil, nil, errs.Unauthorized("vault.authorizeToken; vault token does not contain any credentials")
+	}
+
+	var claims vaultPayload
+	if err := jwt.Claims(creds.key(), &claims); err != nil {
+		return nil, nil, nil, errs.Wrap(http.StatusUnauthorized, err, "vault.authorizeToken; error verifying claims")
+	}
+
+	// According to "rfc7519 JSON Web Token" acceptable skew should be no
+	// more than a few minutes.
+	if err = claims.ValidateWithLeeway(jose.Expected{
+		Issuer: vaultIssuer,
+		Time:   time.Now().UTC(),
+	}, p.claimer.ClockSkew()); err != nil {
+		return nil, nil, nil, errs.Wrap(http.StatusUnauthorized, err, "vault.authorizeToken; invalid vault token")
+	}
+
+	// validate audiences with the defaults
+	if !matchesAudience(claims.Audience, audiences) {
+		return nil, nil, nil, errs.Unauthorized("vault.authorizeToken; invalid vault token - invalid audience claim (aud)")
+	}
+
+	info, err := p.lookup(claims.Vault)
+	if err != nil {
+		return nil, nil, nil, errs.Wrap(http.StatusUnauthorized, err, "vault.authorizeToken; error validating vault credentials")
+	}
+
+	var rules []VaultRule
+	for _, r := range p.Rules {
+		if info.hasPolicy(r.Policy) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil, nil, errs.Unauthorized("vault.authorizeToken; vault token does not match any rule")
+	}
+
+	return &claims, info, rules, nil
+}
+
+// getClaimer returns a claimer with the durations limited by the TTL of the
+// Vault token and the maxDuration of the rules.
+func (p *Vault) getClaimer(info *vaultTokenInfo, rules []VaultRule) (*Claimer, error) {
+	// A rule without maxDuration does not limit the duration.
+	var limit time.Duration
+	for _, r := range rules {
+		if r.MaxDuration == nil {
+			limit = 0
+			break
+		}
+		if d := r.MaxDuration.Value(); d > limit {
+			limit = d
+		}
+	}
+	// Tokens with a TTL of 0 never expire.
+	if ttl := time.Duration(info.TTL) * time.Second; ttl > 0 && (limit == 0 || ttl < limit) {
+		limit = ttl
+	}
+	if limit == 0 {
+		return p.claimer, nil
+	}
+
+	claims := p.claimer.Claims()
+	for _, d := range []*Duration{
+		claims.MaxTLSDur, claims.DefaultTLSDur,
+		claims.MaxUserSSHDur, claims.DefaultUserSSHDur,
+		claims.MaxHostSSHDur, claims.DefaultHostSSHDur,
+	} {
+		if d.Duration > limit {
+			d.Duration = limit
+		}
+	}
+	return NewClaimer(&claims, p.claimer.global)
+}
+
+// AuthorizeSign validates the given token and returns the sign options that
+// will be used on certificate creation.
+func (p *Vault) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("vault.AuthorizeSign; issuance is disabled for vault provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, info, rules, err := p.authorizeToken(token, p.audiences.Sign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "vault.AuthorizeSign")
+	}
+
+	var sans []string
+	for _, r := range rules {
+		sans = append(sans, info.names(r.SANs)...)
+	}
+	if len(sans) == 0 {
+		return nil, errs.Unauthorized("vault.AuthorizeSign; vault token does not allow any SAN")
+	}
+
+	claimer, err := p.getClaimer(info, rules)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "vault.AuthorizeSign; vault token ttl is not valid")
+	}
+
+	signOptions := []SignOption{
+		// modifiers / withOptions
+		newProvisionerExtensionOption(TypeVault, p.Name, claims.Subject, "Accessor", info.Accessor,
+			"EntityID", info.EntityID),
+		profileDefaultDuration(claimer.DefaultTLSCertDuration()),
+		backdateOption(claimer.Backdate()),
+		// validators
+		allowedSANsValidator(sans),
+		defaultPublicKeyValidator{},
+		newValidityValidator(claimer.MinTLSCertDuration(), claimer.MaxTLSCertDuration()),
+		newSANDurationValidator(claimer),
+	}
+
+	if p.claimer.IsSPIFFEEnabled() {
+		signOptions = append(signOptions, newSPIFFESignOptions(p.claimer, nil)...)
+	}
+
+	return withX509CertificateOptions(p.Options, signOptions, newTemplateData(token, claims.Subject, sans)), nil
+}
+
+// AuthorizeRenew returns an error if the renewal is disabled.
+// NOTE: This method does not actually validate the certificate or check it's
+// revocation status. Just confirms that the provisioner that created the
+// certificate was configured to allow renewals.
+func (p *Vault) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
+	if p.claimer.IsDisableRenewal() {
+		return errs.Unauthorized("vault.AuthorizeRenew; renew is disabled for vault provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "vault.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "vault.AuthorizeRenew")
+	}
+	return nil
+}
+
+// AuthorizeSSHSign returns the list of SignOption for a SignSSH request.
+func (p *Vault) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption, error) {
+	if !p.claimer.IsSSHCAEnabled() {
+		return nil, errs.Unauthorized("vault.AuthorizeSSHSign; sshCA is disabled for vault provisioner %s", p.GetID())
+	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("vault.AuthorizeSSHSign; issuance is disabled for vault provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
+	claims, info, rules, err := p.authorizeToken(token, p.audiences.SSHSign)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "vault.AuthorizeSSHSign")
+	}
+
+	var principals []string
+	for _, r := range rules {
+		principals = append(principals, info.names(r.Principals)...)
+	}
+	if len(principals) == 0 {
+		return nil, errs.Unauthorized("vault.AuthorizeSSHSign; vault token does not allow any principal")
+	}
+
+	claimer, err := p.getClaimer(info, rules)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "vault.AuthorizeSSHSign; vault token ttl is not valid")
+	}
+
+	signOptions := []SignOption{
+		// set the key id to the token subject
+		sshCertKeyIDModifier(claims.Subject),
+	}
+
+	// Default to a user certificate with the allowed principals
+	defaults := SSHOptions{
+		CertType:   SSHUserCert,
+		Principals: principals,
+	}
+	// Validate user options
+	signOptions = append(signOptions, sshCertOptionsValidator(defaults))
+	// Set defaults if not given as user options
+	signOptions = append(signOptions, sshCertDefaultsModifier(defaults))
+
+	return withSSHCertificateOptions(p.Options, append(signOptions,
+		// Set the default extensions
+		&sshDefaultExtensionModifier{},
+		// Set the validity bounds if not set.
+		&sshDefaultDuration{claimer},
+		backdateOption(claimer.Backdate()),
+		// Validate public key
+		&sshDefaultPublicKeyValidator{},
+		// Validate the validity period.
+		&sshCertValidityValidator{claimer},
+		// Require all the fields in the SSH certificate
+		&sshCertDefaultValidator{},
+	), newTemplateData(token, claims.Subject, principals)), nil
+}
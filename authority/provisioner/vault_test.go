@@ -0,0 +1,451 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+)
+
+// newVaultServer returns a test server that emulates the Vault token and
+// AppRole APIs. The token "s.web" has the policy "web", "s.db" the policy
+// "db", and the AppRole login with "the-role" and "the-secret" returns the
+// token "s.approle" with the policy "web-approle".
+func newVaultServer(t *testing.T) (*httptest.Server, *[]string) {
+	var revoked []string
+	tokens := map[string]vaultTokenInfo{
+		"s.web": {
+			Accessor: "accessor-web", DisplayName: "token-web", EntityID: "entity-web",
+			Meta: map[string]string{"service": "api"}, Policies: []string{"default", "web"},
+		},
+		"s.db": {
+			Accessor: "accessor-db", DisplayName: "token-db", Policies: []string{"default", "db"},
+		},
+		"s.short": {
+			Accessor: "accessor-short", DisplayName: "token-short", EntityID: "entity-short", Meta: map[string]string{"service": "short"},
+			Policies: []string{"web"}, TTL: 600,
+		},
+		"s.approle": {
+			Accessor: "accessor-approle", DisplayName: "approle",
+			Meta: map[string]string{"role_name": "worker"}, Policies: []string{"web-approle"},
+		},
+	}
+	write := func(w http.ResponseWriter, code int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		assert.FatalError(t, json.NewEncoder(w).Encode(v))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Vault-Token")
+		switch {
+		case r.URL.Path == "/v1/auth/approle/login":
+			var body map[string]string
+			assert.FatalError(t, json.NewDecoder(r.Body).Decode(&body))
+			if body["role_id"] != "the-role" || body["secret_id"] != "the-secret" {
+				write(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid secret id"}})
+				return
+			}
+			write(w, http.StatusOK, map[string]interface{}{"auth": vaultAuth{ClientToken: "s.approle"}})
+		case r.URL.Path == vaultLookupSelfURI:
+			info, ok := tokens[token]
+			if !ok {
+				write(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			write(w, http.StatusOK, map[string]interface{}{"data": info})
+		case r.URL.Path == vaultRevokeSelfURI:
+			revoked = append(revoked, token)
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, vaultEntityURI):
+			if token != "s.web" || r.URL.Path != vaultEntityURI+"entity-web" {
+				write(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			write(w, http.StatusOK, map[string]interface{}{"data": vaultEntity{
+				Name: "web", Metadata: map[string]string{"service": "web"},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv, &revoked
+}
+
+func TestVault_Getters(t *testing.T) {
+	p, err := generateVault("https://vault.smallstep.com")
+	assert.FatalError(t, err)
+	if got := p.GetID(); got != "vault/"+p.Name {
+		t.Errorf("Vault.GetID() = %v, want %v", got, "vault/"+p.Name)
+	}
+	if got := p.GetName(); got != p.Name {
+		t.Errorf("Vault.GetName() = %v, want %v", got, p.Name)
+	}
+	if got := p.GetType(); got != TypeVault {
+		t.Errorf("Vault.GetType() = %v, want %v", got, TypeVault)
+	}
+	kid, key, ok := p.GetEncryptedKey()
+	if kid != "" || key != "" || ok == true {
+		t.Errorf("Vault.GetEncryptedKey() = (%v, %v, %v), want (%v, %v, %v)",
+			kid, key, ok, "", "", false)
+	}
+}
+
+func TestVault_GetIdentityToken(t *testing.T) {
+	p, err := generateVault("https://vault.smallstep.com")
+	assert.FatalError(t, err)
+
+	reset := func() {
+		for _, k := range []string{"VAULT_TOKEN", "VAULT_ROLE_ID", "VAULT_SECRET_ID"} {
+			assert.FatalError(t, os.Unsetenv(k))
+		}
+	}
+	defer reset()
+
+	reset()
+	_, err = p.GetIdentityToken("foo", "https://ca.smallstep.com")
+	assert.Error(t, err)
+
+	assert.FatalError(t, os.Setenv("VAULT_ROLE_ID", "the-role"))
+	assert.FatalError(t, os.Setenv("VAULT_SECRET_ID", "the-secret"))
+	tok, err := p.GetIdentityToken("foo", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+
+	jwt, err := jose.ParseSigned(tok)
+	assert.FatalError(t, err)
+	var claims vaultPayload
+	assert.FatalError(t, jwt.Claims([]byte("the-secret"), &claims))
+	assert.Equals(t, "foo", claims.Subject)
+	assert.Equals(t, vaultIssuer, claims.Issuer)
+	assert.Equals(t, []string{"https://ca.smallstep.com/1.0/sign#" + p.GetID()}, []string(claims.Audience))
+	assert.Equals(t, vaultCredentials{RoleID: "the-role", SecretID: "the-secret"}, claims.Vault)
+
+	assert.FatalError(t, os.Setenv("VAULT_TOKEN", "s.web"))
+	tok, err = p.GetIdentityToken("foo", "https://ca.smallstep.com")
+	assert.FatalError(t, err)
+	jwt, err = jose.ParseSigned(tok)
+	assert.FatalError(t, err)
+	var tokenClaims vaultPayload
+	assert.FatalError(t, jwt.Claims([]byte("s.web"), &tokenClaims))
+	assert.Equals(t, vaultCredentials{Token: "s.web"}, tokenClaims.Vault)
+}
+
+func TestVault_Init(t *testing.T) {
+	config := Config{
+		Claims:    globalProvisionerClaims,
+		Audiences: testAudiences,
+	}
+	rules := []VaultRule{{Policy: "web", SANs: []string{"{meta.service}.internal"}}}
+	badClaims := &Claims{
+		DefaultTLSDur: &Duration{0},
+	}
+
+	tests := []struct {
+		name    string
+		p       *Vault
+		wantErr bool
+	}{
+		{"ok", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:8200", Rules: rules}, false},
+		{"ok http", &Vault{Type: "Vault", Name: "vault", Address: "http://127.0.0.1:8200", Rules: rules}, false},
+		{"fail type", &Vault{Type: "", Name: "vault", Address: "https://vault:8200", Rules: rules}, true},
+		{"fail name", &Vault{Type: "Vault", Name: "", Address: "https://vault:8200", Rules: rules}, true},
+		{"fail address", &Vault{Type: "Vault", Name: "vault", Address: "", Rules: rules}, true},
+		{"fail address scheme", &Vault{Type: "Vault", Name: "vault", Address: "vault:8200", Rules: rules}, true},
+		{"fail address parse", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:port", Rules: rules}, true},
+		{"fail rules", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:8200"}, true},
+		{"fail policy", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:8200", Rules: []VaultRule{{}}}, true},
+		{"fail policy pattern", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:8200", Rules: []VaultRule{{Policy: "[web"}}}, true},
+		{"fail maxDuration", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:8200", Rules: []VaultRule{{Policy: "web", MaxDuration: &Duration{-time.Minute}}}}, true},
+		{"fail claims", &Vault{Type: "Vault", Name: "vault", Address: "https://vault:8200", Rules: rules, Claims: badClaims}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Init(config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Vault.Init() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equals(t, vaultDefaultAppRoleMount, tt.p.AppRoleMount)
+				assert.Equals(t, config.Audiences.WithFragment(tt.p.GetID()), tt.p.audiences)
+			}
+		})
+	}
+}
+
+func TestVault_AuthorizeSign(t *testing.T) {
+	srv, revoked := newVaultServer(t)
+	defer srv.Close()
+
+	p1, err := generateVault(srv.URL)
+	assert.FatalError(t, err)
+	p2, err := generateVault(srv.URL)
+	assert.FatalError(t, err)
+	p2.EntityMetadata = true
+	p3, err := generateVault(srv.URL)
+	assert.FatalError(t, err)
+	p3.Rules[0].MaxDuration = &Duration{time.Hour}
+
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+	aud2, err := generateSignAudience("https://ca.smallstep.com", p2.GetID())
+	assert.FatalError(t, err)
+	aud3, err := generateSignAudience("https://ca.smallstep.com", p3.GetID())
+	assert.FatalError(t, err)
+
+	now := time.Now()
+	web := vaultCredentials{Token: "s.web"}
+	t1, err := generateVaultToken("foo", vaultIssuer, aud, web, now)
+	assert.FatalError(t, err)
+	t2, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{RoleID: "the-role", SecretID: "the-secret"}, now)
+	assert.FatalError(t, err)
+	t3, err := generateVaultToken("foo", vaultIssuer, aud2, web, now)
+	assert.FatalError(t, err)
+	t4, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.short"}, now)
+	assert.FatalError(t, err)
+	t5, err := generateVaultToken("foo", vaultIssuer, aud3, web, now)
+	assert.FatalError(t, err)
+	failIss, err := generateVaultToken("foo", "bad-issuer", aud, web, now)
+	assert.FatalError(t, err)
+	failAud, err := generateVaultToken("foo", vaultIssuer, "https://ca.smallstep.com/1.0/sign#vault/foo", web, now)
+	assert.FatalError(t, err)
+	failExp, err := generateVaultToken("foo", vaultIssuer, aud, web, now.Add(-360*time.Second))
+	assert.FatalError(t, err)
+	failNbf, err := generateVaultToken("foo", vaultIssuer, aud, web, now.Add(360*time.Second))
+	assert.FatalError(t, err)
+	failCreds, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{RoleID: "the-role"}, now)
+	assert.FatalError(t, err)
+	failToken, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.unknown"}, now)
+	assert.FatalError(t, err)
+	failSecret, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{RoleID: "the-role", SecretID: "bad-secret"}, now)
+	assert.FatalError(t, err)
+	failRule, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.db"}, now)
+	assert.FatalError(t, err)
+	failEntity, err := generateVaultToken("foo", vaultIssuer, aud2, vaultCredentials{Token: "s.short"}, now)
+	assert.FatalError(t, err)
+
+	// Token signed with a different key
+	parts := strings.Split(t1, ".")
+	failSig, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.db"}, now)
+	assert.FatalError(t, err)
+	failSig = strings.Join(append(parts[:2], strings.Split(failSig, ".")[2]), ".")
+
+	tests := []struct {
+		name     string
+		p        *Vault
+		token    string
+		wantSANs []string
+		wantMax  time.Duration
+		code     int
+		wantErr  bool
+	}{
+		{"ok token", p1, t1, []string{"api.internal"}, p1.claimer.MaxTLSCertDuration(), http.StatusOK, false},
+		{"ok approle", p1, t2, []string{"worker.internal"}, p1.claimer.MaxTLSCertDuration(), http.StatusOK, false},
+		{"ok entity", p2, t3, []string{"web.internal"}, p2.claimer.MaxTLSCertDuration(), http.StatusOK, false},
+		{"ok ttl", p1, t4, []string{"short.internal"}, 10 * time.Minute, http.StatusOK, false},
+		{"ok maxDuration", p3, t5, []string{"api.internal"}, time.Hour, http.StatusOK, false},
+		{"fail token", p1, "token", nil, 0, http.StatusUnauthorized, true},
+		{"fail sig", p1, failSig, nil, 0, http.StatusUnauthorized, true},
+		{"fail iss", p1, failIss, nil, 0, http.StatusUnauthorized, true},
+		{"fail aud", p1, failAud, nil, 0, http.StatusUnauthorized, true},
+		{"fail exp", p1, failExp, nil, 0, http.StatusUnauthorized, true},
+		{"fail nbf", p1, failNbf, nil, 0, http.StatusUnauthorized, true},
+		{"fail creds", p1, failCreds, nil, 0, http.StatusUnauthorized, true},
+		{"fail lookup", p1, failToken, nil, 0, http.StatusUnauthorized, true},
+		{"fail login", p1, failSecret, nil, 0, http.StatusUnauthorized, true},
+		{"fail rule", p1, failRule, nil, 0, http.StatusUnauthorized, true},
+		{"fail entity", p2, failEntity, nil, 0, http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewContextWithMethod(context.Background(), SignMethod)
+			got, err := tt.p.AuthorizeSign(ctx, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Vault.AuthorizeSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+				return
+			}
+			assert.Len(t, 8, got)
+			for _, o := range got {
+				switch v := o.(type) {
+				case allowedSANsValidator:
+					assert.Equals(t, tt.wantSANs, []string(v))
+				case *validityValidator:
+					assert.Equals(t, tt.wantMax, v.max)
+				case profileDefaultDuration:
+					assert.True(t, time.Duration(v) <= tt.wantMax)
+				case backdateOption:
+				}
+			}
+		})
+	}
+
+	// The token created with the AppRole login is revoked.
+	assert.Equals(t, []string{"s.approle"}, *revoked)
+}
+
+func TestVault_AuthorizeSign_noSANs(t *testing.T) {
+	srv, _ := newVaultServer(t)
+	defer srv.Close()
+
+	p1, err := generateVault(srv.URL)
+	assert.FatalError(t, err)
+	p1.Rules[0].SANs = []string{"{meta.unknown}.internal", "{entity_name}.internal"}
+
+	aud, err := generateSignAudience("https://ca.smallstep.com", p1.GetID())
+	assert.FatalError(t, err)
+	t1, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.web"}, time.Now())
+	assert.FatalError(t, err)
+
+	_, err = p1.AuthorizeSign(context.Background(), t1)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+	}
+}
+
+func TestVault_AuthorizeSSHSign(t *testing.T) {
+	tm, fn := mockNow()
+	defer fn()
+
+	srv, _ := newVaultServer(t)
+	defer srv.Close()
+
+	p1, err := generateVault(srv.URL)
+	assert.FatalError(t, err)
+
+	p2, err := generateVault(srv.URL)
+	assert.FatalError(t, err)
+	// disable sshCA
+	disable := false
+	p2.Claims = &Claims{EnableSSHCA: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	aud := "https://ca.smallstep.com/1.0/ssh/sign#" + p1.GetID()
+	t1, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.web"}, time.Now())
+	assert.FatalError(t, err)
+	t2, err := generateVaultToken("foo", vaultIssuer, aud, vaultCredentials{Token: "s.short"}, time.Now())
+	assert.FatalError(t, err)
+	failAud, err := generateVaultToken("foo", vaultIssuer, "https://ca.smallstep.com/1.0/sign#"+p1.GetID(), vaultCredentials{Token: "s.web"}, time.Now())
+	assert.FatalError(t, err)
+
+	key, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	signer, err := generateJSONWebKey()
+	assert.FatalError(t, err)
+
+	pub := key.Public().Key
+	userDuration := p1.claimer.DefaultUserSSHCertDuration()
+	expectedUserOptions := &SSHOptions{
+		CertType: "user", Principals: []string{"token-web"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(userDuration)),
+	}
+	expectedShortOptions := &SSHOptions{
+		CertType: "user", Principals: []string{"token-short"},
+		ValidAfter: NewTimeDuration(tm), ValidBefore: NewTimeDuration(tm.Add(10 * time.Minute)),
+	}
+
+	type args struct {
+		token   string
+		sshOpts SSHOptions
+		key     interface{}
+	}
+	tests := []struct {
+		name        string
+		p           *Vault
+		args        args
+		expected    *SSHOptions
+		code        int
+		wantErr     bool
+		wantSignErr bool
+	}{
+		{"ok", p1, args{t1, SSHOptions{}, pub}, expectedUserOptions, http.StatusOK, false, false},
+		{"ok-principals", p1, args{t1, SSHOptions{Principals: []string{"token-web"}}, pub}, expectedUserOptions, http.StatusOK, false, false},
+		{"ok-ttl", p1, args{t2, SSHOptions{}, pub}, expectedShortOptions, http.StatusOK, false, false},
+		{"fail-ttl", p1, args{t2, SSHOptions{ValidBefore: NewTimeDuration(tm.Add(time.Hour))}, pub}, nil, http.StatusOK, false, true},
+		{"fail-type", p1, args{t1, SSHOptions{CertType: "host"}, pub}, nil, http.StatusOK, false, true},
+		{"fail-principal", p1, args{t1, SSHOptions{Principals: []string{"root"}}, pub}, nil, http.StatusOK, false, true},
+		{"fail-aud", p1, args{failAud, SSHOptions{}, pub}, nil, http.StatusUnauthorized, true, false},
+		{"fail-sshCA-disabled", p2, args{"foo", SSHOptions{}, pub}, nil, http.StatusUnauthorized, true, false},
+		{"fail-invalid-token", p1, args{"foo", SSHOptions{}, pub}, nil, http.StatusUnauthorized, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.AuthorizeSSHSign(context.Background(), tt.args.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Vault.AuthorizeSSHSign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+				assert.Nil(t, got)
+			} else if assert.NotNil(t, got) {
+				cert, err := signSSHCertificate(tt.args.key, tt.args.sshOpts, got, signer.Key.(crypto.Signer))
+				if (err != nil) != tt.wantSignErr {
+					t.Errorf("SignSSH error = %v, wantSignErr %v", err, tt.wantSignErr)
+				} else {
+					if tt.wantSignErr {
+						assert.Nil(t, cert)
+					} else {
+						assert.NoError(t, validateSSHCertificate(cert, tt.expected))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVault_AuthorizeRenew(t *testing.T) {
+	p1, err := generateVault("https://vault.smallstep.com")
+	assert.FatalError(t, err)
+	p2, err := generateVault("https://vault.smallstep.com")
+	assert.FatalError(t, err)
+
+	// disable renewal
+	disable := true
+	p2.Claims = &Claims{DisableRenewal: &disable}
+	p2.claimer, err = NewClaimer(p2.Claims, globalProvisionerClaims)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		prov    *Vault
+		cert    *x509.Certificate
+		code    int
+		wantErr bool
+	}{
+		{"ok", p1, generateRenewCertificate(), http.StatusOK, false},
+		{"fail/renew-disabled", p2, generateRenewCertificate(), http.StatusUnauthorized, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.prov.AuthorizeRenew(context.Background(), tt.cert); (err != nil) != tt.wantErr {
+				t.Errorf("Vault.AuthorizeRenew() error = %v, wantErr %v", err, tt.wantErr)
+			} else if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, sc.StatusCode(), tt.code)
+			}
+		})
+	}
+}
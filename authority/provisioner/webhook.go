@@ -0,0 +1,247 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// WebhookKindEnriching is the kind of the webhooks that can deny a request
+	// and return data for the certificate templates.
+	WebhookKindEnriching = "ENRICHING"
+	// WebhookKindAuthorizing is the kind of the webhooks that can only allow or
+	// deny a request.
+	WebhookKindAuthorizing = "AUTHORIZING"
+	// WebhookCertTypeX509 is the certificate type of the webhooks only called
+	// for X.509 certificates.
+	WebhookCertTypeX509 = "X509"
+	// WebhookCertTypeSSH is the certificate type of the webhooks only called
+	// for SSH certificates.
+	WebhookCertTypeSSH = "SSH"
+)
+
+// WebhookSignatureHeader is the header with the hex encoded HMAC-SHA256 of the
+// request body sent to the webhooks configured with a secret.
+const WebhookSignatureHeader = "X-Smallstep-Signature"
+
+// WebhookIDHeader is the header with the name of the webhook called.
+const WebhookIDHeader = "X-Smallstep-Webhook-ID"
+
+// maxWebhookResponseSize is the maximum size of a webhook response.
+const maxWebhookResponseSize = 1 << 20
+
+// Webhook is an external service called before signing a certificate. The CA
+// sends a POST request with the certificate request and the token claims, and
+// the service must respond with {"allow": true} to authorize the request. Any
+// other response or error denies the request.
+//
+// Kind is ENRICHING or AUTHORIZING, the data returned by enriching webhooks
+// is available in the templates as .Webhooks.<name>. CertType limits the
+// webhook to X509 or SSH certificates, by default it's called for both.
+//
+// Secret is the base64 encoded key used to sign the request body with
+// HMAC-SHA256, the signature is sent in the X-Smallstep-Signature header. The
+// HTTPClient options can be used to configure the timeout, the roots used to
+// validate the server and the client certificate for mTLS.
+type Webhook struct {
+	Name       string             `json:"name"`
+	URL        string             `json:"url"`
+	Kind       string             `json:"kind"`
+	CertType   string             `json:"certType,omitempty"`
+	Secret     string             `json:"secret,omitempty"`
+	HTTPClient *HTTPClientOptions `json:"httpClient,omitempty"`
+	secret     []byte
+	client     *http.Client
+}
+
+// init validates the webhook and creates its HTTP client.
+func (w *Webhook) init(config Config) (err error) {
+	switch {
+	case w == nil:
+		return errors.New("webhook cannot be empty")
+	case w.Name == "":
+		return errors.New("webhook name cannot be empty")
+	case w.URL == "":
+		return errors.New("webhook url cannot be empty")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing webhook url %s", w.URL)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("webhook url %s is not valid", w.URL)
+	}
+	switch strings.ToUpper(w.Kind) {
+	case WebhookKindEnriching, WebhookKindAuthorizing:
+	default:
+		return errors.Errorf("webhook kind %s is not valid, it must be %s or %s", w.Kind, WebhookKindEnriching, WebhookKindAuthorizing)
+	}
+	switch strings.ToUpper(w.CertType) {
+	case "", WebhookCertTypeX509, WebhookCertTypeSSH:
+	default:
+		return errors.Errorf("webhook certType %s is not valid, it must be %s or %s", w.CertType, WebhookCertTypeX509, WebhookCertTypeSSH)
+	}
+	w.secret = nil
+	if w.Secret != "" {
+		if w.secret, err = base64.StdEncoding.DecodeString(w.Secret); err != nil {
+			return errors.Wrap(err, "error decoding webhook secret")
+		}
+	}
+	if w.client, err = newHTTPClient(w.HTTPClient.withDefaultProxy(config.Proxy)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isEnriching returns true if the data returned by the webhook must be added
+// to the templates.
+func (w *Webhook) isEnriching() bool {
+	return strings.EqualFold(w.Kind, WebhookKindEnriching)
+}
+
+// webhookRequest is the body sent to a webhook.
+type webhookRequest struct {
+	Timestamp              time.Time                      `json:"timestamp"`
+	Token                  interface{}                    `json:"token,omitempty"`
+	X509CertificateRequest *webhookX509CertificateRequest `json:"x509CertificateRequest,omitempty"`
+	SSHCertificateRequest  *webhookSSHCertificateRequest  `json:"sshCertificateRequest,omitempty"`
+}
+
+// webhookX509CertificateRequest is the representation of a certificate
+// request sent to a webhook. Raw is the DER encoded request.
+type webhookX509CertificateRequest struct {
+	x509Template
+	PublicKeyAlgorithm string `json:"publicKeyAlgorithm"`
+	Raw                []byte `json:"raw"`
+}
+
+// webhookSSHCertificateRequest is the representation of an SSH certificate
+// request sent to a webhook. The public key uses the authorized_keys format.
+type webhookSSHCertificateRequest struct {
+	sshTemplateUser
+	PublicKey string `json:"publicKey"`
+}
+
+// webhookResponse is the response expected from a webhook.
+type webhookResponse struct {
+	Allow bool                   `json:"allow"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+// call sends the request to the webhook and returns an error if the request
+// is not allowed.
+func (w *Webhook) call(req *webhookRequest) (*webhookResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling webhook %s request", w.Name)
+	}
+	r, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating webhook %s request", w.Name)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(WebhookIDHeader, w.Name)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		r.Header.Set(WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error calling webhook %s", w.Name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("error calling webhook %s: status code %d", w.Name, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading webhook %s response", w.Name)
+	}
+	var res webhookResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling webhook %s response", w.Name)
+	}
+	if !res.Allow {
+		return nil, errors.Errorf("webhook %s denied the request", w.Name)
+	}
+	return &res, nil
+}
+
+// callWebhooks calls the given webhooks in order, and adds the data of the
+// enriching webhooks to the template data.
+func callWebhooks(webhooks []*Webhook, req *webhookRequest, data TemplateData) error {
+	req.Timestamp = now()
+	req.Token = data["Token"]
+	enriched := make(map[string]interface{})
+	for _, w := range webhooks {
+		res, err := w.call(req)
+		if err != nil {
+			return err
+		}
+		if w.isEnriching() {
+			enriched[w.Name] = res.Data
+		}
+	}
+	data["Webhooks"] = enriched
+	return nil
+}
+
+// x509WebhookValidator is a CertificateRequestValidator that calls the
+// webhooks with the certificate request. It must be added before the template
+// modifier.
+type x509WebhookValidator struct {
+	webhooks []*Webhook
+	data     TemplateData
+}
+
+func (v *x509WebhookValidator) Valid(req *x509.CertificateRequest) error {
+	return callWebhooks(v.webhooks, &webhookRequest{
+		X509CertificateRequest: &webhookX509CertificateRequest{
+			x509Template: *newX509TemplateFromCertificate(&x509.Certificate{
+				Subject:        req.Subject,
+				DNSNames:       req.DNSNames,
+				EmailAddresses: req.EmailAddresses,
+				IPAddresses:    req.IPAddresses,
+				URIs:           req.URIs,
+			}),
+			PublicKeyAlgorithm: req.PublicKeyAlgorithm.String(),
+			Raw:                req.Raw,
+		},
+	}, v.data)
+}
+
+// sshWebhookModifier is an SSHCertModifier that calls the webhooks with the
+// SSH certificate built by the previous modifiers. It does not modify the
+// certificate, and it must be added before the template modifier.
+type sshWebhookModifier struct {
+	webhooks []*Webhook
+	data     TemplateData
+}
+
+func (m *sshWebhookModifier) Modify(cert *ssh.Certificate) error {
+	req := &webhookSSHCertificateRequest{
+		sshTemplateUser: newSSHTemplateUser(cert),
+	}
+	if cert.Key != nil {
+		req.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert.Key)))
+	}
+	return callWebhooks(m.webhooks, &webhookRequest{
+		SSHCertificateRequest: req,
+	}, m.data)
+}
@@ -0,0 +1,245 @@
+package provisioner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/cli/crypto/x509util"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWebhook_init(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		wantErr bool
+	}{
+		{"ok", &Webhook{Name: "foo", URL: "https://example.com/hook", Kind: "ENRICHING"}, false},
+		{"ok/authorizing", &Webhook{Name: "foo", URL: "http://localhost:8080/hook", Kind: "authorizing", CertType: "ssh"}, false},
+		{"ok/secret", &Webhook{Name: "foo", URL: "https://example.com/hook", Kind: "ENRICHING", CertType: "X509", Secret: "c2VjcmV0"}, false},
+		{"fail/nil", nil, true},
+		{"fail/name", &Webhook{URL: "https://example.com/hook", Kind: "ENRICHING"}, true},
+		{"fail/url", &Webhook{Name: "foo", Kind: "ENRICHING"}, true},
+		{"fail/url-parse", &Webhook{Name: "foo", URL: "%", Kind: "ENRICHING"}, true},
+		{"fail/url-scheme", &Webhook{Name: "foo", URL: "ftp://example.com/hook", Kind: "ENRICHING"}, true},
+		{"fail/kind", &Webhook{Name: "foo", URL: "https://example.com/hook", Kind: "FOO"}, true},
+		{"fail/certType", &Webhook{Name: "foo", URL: "https://example.com/hook", Kind: "ENRICHING", CertType: "FOO"}, true},
+		{"fail/secret", &Webhook{Name: "foo", URL: "https://example.com/hook", Kind: "ENRICHING", Secret: "%%%"}, true},
+		{"fail/httpClient", &Webhook{Name: "foo", URL: "https://example.com/hook", Kind: "ENRICHING", HTTPClient: &HTTPClientOptions{Certificate: "crt.pem"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.webhook.init(Config{}); (err != nil) != tt.wantErr {
+				t.Errorf("Webhook.init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertificateOptions_load_webhooks(t *testing.T) {
+	o := &CertificateOptions{Webhooks: []*Webhook{
+		{Name: "foo", URL: "https://example.com/foo", Kind: "ENRICHING"},
+		{Name: "foo", URL: "https://example.com/bar", Kind: "ENRICHING"},
+	}}
+	assert.Error(t, o.load(Config{}))
+
+	o.Webhooks[1].Name = "bar"
+	assert.FatalError(t, o.load(Config{}))
+
+	o.Webhooks = append(o.Webhooks, &Webhook{Name: "zar", Kind: "ENRICHING"})
+	assert.Error(t, o.load(Config{}))
+}
+
+type webhookTestServer struct {
+	*httptest.Server
+	requests []webhookRequest
+	headers  []http.Header
+}
+
+func newWebhookTestServer(t *testing.T, responses map[string]string) *webhookTestServer {
+	srv := &webhookTestServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		assert.FatalError(t, err)
+		var req webhookRequest
+		assert.FatalError(t, json.Unmarshal(b, &req))
+		srv.requests = append(srv.requests, req)
+		srv.headers = append(srv.headers, r.Header)
+		if sig := r.Header.Get(WebhookSignatureHeader); sig != "" {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write(b)
+			if hex.EncodeToString(mac.Sum(nil)) != sig {
+				http.Error(w, "bad signature", http.StatusUnauthorized)
+				return
+			}
+		}
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(res))
+	}))
+	return srv
+}
+
+func TestWebhook_call(t *testing.T) {
+	srv := newWebhookTestServer(t, map[string]string{
+		"/allow":   `{"allow": true, "data": {"owner": "mariano"}}`,
+		"/deny":    `{"allow": false}`,
+		"/invalid": `{"allow": `,
+	})
+	defer srv.Close()
+
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+	tests := []struct {
+		name     string
+		webhook  *Webhook
+		wantData map[string]interface{}
+		wantErr  bool
+	}{
+		{"ok", &Webhook{Name: "allow", URL: srv.URL + "/allow", Kind: "ENRICHING"}, map[string]interface{}{"owner": "mariano"}, false},
+		{"ok/secret", &Webhook{Name: "allow", URL: srv.URL + "/allow", Kind: "ENRICHING", Secret: secret}, map[string]interface{}{"owner": "mariano"}, false},
+		{"fail/secret", &Webhook{Name: "allow", URL: srv.URL + "/allow", Kind: "ENRICHING", Secret: "Zm9v"}, nil, true},
+		{"fail/deny", &Webhook{Name: "deny", URL: srv.URL + "/deny", Kind: "AUTHORIZING"}, nil, true},
+		{"fail/invalid", &Webhook{Name: "invalid", URL: srv.URL + "/invalid", Kind: "AUTHORIZING"}, nil, true},
+		{"fail/notFound", &Webhook{Name: "missing", URL: srv.URL + "/missing", Kind: "AUTHORIZING"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.FatalError(t, tt.webhook.init(Config{}))
+			got, err := tt.webhook.call(&webhookRequest{Timestamp: now()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Webhook.call() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equals(t, tt.wantData, got.Data)
+				h := srv.headers[len(srv.headers)-1]
+				assert.Equals(t, tt.webhook.Name, h.Get(WebhookIDHeader))
+				assert.Equals(t, "application/json", h.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func Test_withX509CertificateOptions_webhooks(t *testing.T) {
+	srv := newWebhookTestServer(t, map[string]string{
+		"/enrich":    `{"allow": true, "data": {"owner": "mariano"}}`,
+		"/authorize": `{"allow": true, "data": {"ignored": true}}`,
+		"/deny":      `{"allow": false}`,
+	})
+	defer srv.Close()
+
+	o := &CertificateOptions{
+		X509: &TemplateOptions{
+			Template: `{"subject": {"commonName": {{ toJson .Insecure.CSR.Subject.CommonName }}, "organizationalUnit": [{{ toJson .Webhooks.enrich.owner }}]}}`,
+		},
+		Webhooks: []*Webhook{
+			{Name: "enrich", URL: srv.URL + "/enrich", Kind: "ENRICHING"},
+			{Name: "authorize", URL: srv.URL + "/authorize", Kind: "AUTHORIZING", CertType: "X509"},
+			{Name: "ssh", URL: srv.URL + "/deny", Kind: "AUTHORIZING", CertType: "SSH"},
+		},
+	}
+	assert.FatalError(t, o.load(Config{}))
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "foo"},
+		DNSNames: []string{"foo.smallstep.com"},
+	}, priv)
+	assert.FatalError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.FatalError(t, err)
+
+	so := withX509CertificateOptions(o, nil, newTemplateData("", "foo", []string{"foo.smallstep.com"}))
+	assert.Len(t, 3, so)
+	v, ok := so[1].(CertificateRequestValidator)
+	assert.Fatal(t, ok, "sign option is not a CertificateRequestValidator")
+	m, ok := so[2].(ProfileModifier)
+	assert.Fatal(t, ok, "sign option is not a ProfileModifier")
+
+	assert.FatalError(t, v.Valid(csr))
+	assert.Len(t, 2, srv.requests)
+	assert.Equals(t, "foo", srv.requests[0].X509CertificateRequest.Subject.CommonName)
+	assert.Equals(t, []string{"foo.smallstep.com"}, srv.requests[0].X509CertificateRequest.DNSNames)
+	assert.Equals(t, "ECDSA", srv.requests[0].X509CertificateRequest.PublicKeyAlgorithm)
+	assert.Equals(t, der, srv.requests[0].X509CertificateRequest.Raw)
+
+	prof := &x509util.Leaf{}
+	prof.SetSubject(&x509.Certificate{Subject: csr.Subject, DNSNames: csr.DNSNames})
+	assert.FatalError(t, m.Option(Options{})(prof))
+	assert.Equals(t, pkix.Name{CommonName: "foo", OrganizationalUnit: []string{"mariano"}}, prof.Subject().Subject)
+
+	// Denied by an authorizing webhook
+	o.Webhooks[1].URL = srv.URL + "/deny"
+	so = withX509CertificateOptions(o, nil, newTemplateData("", "foo", nil))
+	assert.Error(t, so[1].(CertificateRequestValidator).Valid(csr))
+
+	// Webhooks without templates
+	so = withX509CertificateOptions(&CertificateOptions{Webhooks: o.Webhooks[2:]}, nil, newTemplateData("", "foo", nil))
+	assert.Len(t, 1, so)
+}
+
+func Test_withSSHCertificateOptions_webhooks(t *testing.T) {
+	srv := newWebhookTestServer(t, map[string]string{
+		"/enrich": `{"allow": true, "data": {"role": "admin"}}`,
+		"/deny":   `{"allow": false}`,
+	})
+	defer srv.Close()
+
+	o := &CertificateOptions{
+		SSH: &TemplateOptions{
+			Template: `{"principals": {{ toJson (append .Insecure.User.Principals .Webhooks.enrich.role) }}}`,
+		},
+		Webhooks: []*Webhook{
+			{Name: "enrich", URL: srv.URL + "/enrich", Kind: "ENRICHING", CertType: "SSH"},
+			{Name: "x509", URL: srv.URL + "/deny", Kind: "AUTHORIZING", CertType: "X509"},
+		},
+	}
+	assert.FatalError(t, o.load(Config{}))
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pub, err := ssh.NewPublicKey(&priv.PublicKey)
+	assert.FatalError(t, err)
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		KeyId:           "foo@smallstep.com",
+		ValidPrincipals: []string{"foo"},
+	}
+
+	so := withSSHCertificateOptions(o, nil, newTemplateData("", "foo@smallstep.com", []string{"foo"}))
+	assert.Len(t, 2, so)
+	for _, op := range so {
+		m, ok := op.(SSHCertModifier)
+		assert.Fatal(t, ok, "sign option is not a SSHCertModifier")
+		assert.FatalError(t, m.Modify(cert))
+	}
+	assert.Equals(t, []string{"foo", "admin"}, cert.ValidPrincipals)
+	assert.Len(t, 1, srv.requests)
+	req := srv.requests[0].SSHCertificateRequest
+	assert.Equals(t, "user", req.Type)
+	assert.Equals(t, "foo@smallstep.com", req.KeyID)
+	assert.Equals(t, []string{"foo"}, req.Principals)
+	assert.HasPrefix(t, req.PublicKey, "ecdsa-sha2-nistp256 AAAA")
+
+	o.Webhooks[0].URL = srv.URL + "/deny"
+	so = withSSHCertificateOptions(o, nil, newTemplateData("", "foo@smallstep.com", nil))
+	assert.Error(t, so[0].(SSHCertModifier).Modify(cert))
+}
@@ -25,13 +25,19 @@ type x5cPayload struct {
 // signature requests.
 type X5C struct {
 	*base
-	Type      string  `json:"type"`
-	Name      string  `json:"name"`
-	Roots     []byte  `json:"roots"`
-	Claims    *Claims `json:"claims,omitempty"`
-	claimer   *Claimer
-	audiences Audiences
-	rootPool  *x509.CertPool
+	Type              string                `json:"type"`
+	Name              string                `json:"name"`
+	Roots             []byte                `json:"roots"`
+	DisableCustomSANs bool                  `json:"disableCustomSANs,omitempty"`
+	Revocation        *X5CRevocationOptions `json:"revocation,omitempty"`
+	HTTPClient        *HTTPClientOptions    `json:"httpClient,omitempty"`
+	Claims            *Claims               `json:"claims,omitempty"`
+	Options           *CertificateOptions   `json:"options,omitempty"`
+	claimer           *Claimer
+	audiences         Audiences
+	rootPool          *x509.CertPool
+	client            *http.Client
+	crls              *crlCache
 }
 
 // GetID returns the provisioner unique identifier. The name and credential id
@@ -84,6 +90,10 @@ func (p *X5C) Init(config Config) error {
 		return errors.New("provisioner root(s) cannot be empty")
 	}
 
+	if err := p.Options.load(config); err != nil {
+		return err
+	}
+
 	p.rootPool = x509.NewCertPool()
 
 	var (
@@ -113,6 +123,14 @@ func (p *X5C) Init(config Config) error {
 		return err
 	}
 
+	// Initialize the client used for the revocation checks
+	if p.Revocation.isEnabled() {
+		if p.client, err = newHTTPClient(p.HTTPClient.withDefaultProxy(config.Proxy)); err != nil {
+			return err
+		}
+	}
+	p.crls = newCRLCache()
+
 	p.audiences = config.Audiences.WithFragment(p.GetID())
 	return nil
 }
@@ -135,6 +153,11 @@ func (p *X5C) authorizeToken(token string, audiences []string) (*x5cPayload, err
 	}
 	leaf := verifiedChains[0][0]
 
+	if err := p.checkRevocation(context.Background(), verifiedChains[0]); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err,
+			"x5c.authorizeToken; error validating x5c certificate chain in token")
+	}
+
 	if leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
 		return nil, errs.Unauthorized("x5c.authorizeToken; certificate used to sign x5c token cannot be used for digital signature")
 	}
@@ -154,7 +177,7 @@ func (p *X5C) authorizeToken(token string, audiences []string) (*x5cPayload, err
 	if err = claims.ValidateWithLeeway(jose.Expected{
 		Issuer: p.Name,
 		Time:   time.Now().UTC(),
-	}, time.Minute); err != nil {
+	}, p.claimer.ClockSkew()); err != nil {
 		return nil, errs.Wrapf(http.StatusUnauthorized, err, "x5c.authorizeToken; invalid x5c claims")
 	}
 
@@ -182,11 +205,31 @@ func (p *X5C) AuthorizeRevoke(ctx context.Context, token string) error {
 
 // AuthorizeSign validates the given token.
 func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, error) {
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("x5c.AuthorizeSign; issuance is disabled for x5c provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 	claims, err := p.authorizeToken(token, p.audiences.Sign)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "x5c.AuthorizeSign")
 	}
 
+	// If custom SANs are disabled, the SANs in the token must be a subset of
+	// the ones in the leaf certificate, and they default to them.
+	if p.DisableCustomSANs {
+		cn, sans := x5cLeafNames(claims.chains[0][0])
+		if len(claims.SANs) == 0 {
+			claims.SANs = sans
+		}
+		allowed := append([]string{cn}, sans...)
+		if !containsAllMembers(allowed, []string{claims.Subject}) {
+			return nil, errs.Unauthorized("x5c.AuthorizeSign; x5c token subject %s is not allowed by the x5c certificate", claims.Subject)
+		}
+		if len(claims.SANs) > 0 && !containsAllMembers(allowed, claims.SANs) {
+			return nil, errs.Unauthorized("x5c.AuthorizeSign; x5c token SANs %v are not allowed by the x5c certificate", claims.SANs)
+		}
+	}
+
 	// NOTE: This is for backwards compatibility with older versions of cli
 	// and certificates. Older versions added the token subject as the only SAN
 	// in a CSR by default.
@@ -194,12 +237,31 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		claims.SANs = []string{claims.Subject}
 	}
 
+	// SPIFFE X.509 SVIDs can only contain one of the SPIFFE IDs in the token.
+	if p.claimer.IsSPIFFEEnabled() {
+		ids := spiffeIDs(claims.SANs)
+		if len(ids) == 0 {
+			return nil, errs.Unauthorized("x5c.AuthorizeSign; x5c token does not contain any SPIFFE ID")
+		}
+		return withX509CertificateOptions(p.Options, append([]SignOption{
+			// modifiers / withOptions
+			newProvisionerExtensionOption(TypeX5C, p.Name, ""),
+			profileLimitDuration{p.claimer.DefaultTLSCertDuration(), claims.chains[0][0].NotAfter},
+			backdateOption(p.claimer.Backdate()),
+			// validators
+			defaultPublicKeyValidator{},
+			newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
+			newSANDurationValidator(p.claimer),
+		}, newSPIFFESignOptions(p.claimer, ids)...), newTemplateData(token, claims.Subject, claims.SANs)), nil
+	}
+
 	dnsNames, ips, emails := x509util.SplitSANs(claims.SANs)
 
-	return []SignOption{
+	return withX509CertificateOptions(p.Options, []SignOption{
 		// modifiers / withOptions
 		newProvisionerExtensionOption(TypeX5C, p.Name, ""),
 		profileLimitDuration{p.claimer.DefaultTLSCertDuration(), claims.chains[0][0].NotAfter},
+		backdateOption(p.claimer.Backdate()),
 		// validators
 		commonNameValidator(claims.Subject),
 		defaultPublicKeyValidator{},
@@ -207,13 +269,21 @@ func (p *X5C) AuthorizeSign(ctx context.Context, token string) ([]SignOption, er
 		emailAddressesValidator(emails),
 		ipAddressesValidator(ips),
 		newValidityValidator(p.claimer.MinTLSCertDuration(), p.claimer.MaxTLSCertDuration()),
-	}, nil
+		newSANDurationValidator(p.claimer),
+	}, newTemplateData(token, claims.Subject, claims.SANs)), nil
 }
 
 // AuthorizeRenew returns an error if the renewal is disabled.
 func (p *X5C) AuthorizeRenew(ctx context.Context, cert *x509.Certificate) error {
 	if p.claimer.IsDisableRenewal() {
-		return errs.Unauthorized("x5c.AuthorizeRenew; renew is disabled for x5c provisioner %s", p.GetID())
+		return errs.Unauthorized("x5c.AuthorizeRenew; renew is disabled for x5c provisioner %s", p.GetID(),
+			errs.WithMessage("renewal is disabled for provisioner %s", p.GetName()))
+	}
+	if err := p.claimer.checkRenewalPeriod(cert); err != nil {
+		return errs.Wrap(http.StatusUnauthorized, err, "x5c.AuthorizeRenew")
+	}
+	if err := p.Options.authorizeRenew(cert); err != nil {
+		return errs.Wrap(http.StatusForbidden, err, "x5c.AuthorizeRenew")
 	}
 	return nil
 }
@@ -223,6 +293,10 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	if !p.claimer.IsSSHCAEnabled() {
 		return nil, errs.Unauthorized("x5c.AuthorizeSSHSign; sshCA is disabled for x5c provisioner %s", p.GetID())
 	}
+	if p.claimer.IsDisableIssuance() {
+		return nil, errs.Forbidden("x5c.AuthorizeSSHSign; issuance is disabled for x5c provisioner %s", p.GetID(),
+			errs.WithMessage("issuance is disabled for provisioner %s", p.GetName()))
+	}
 
 	claims, err := p.authorizeToken(token, p.audiences.SSHSign)
 	if err != nil {
@@ -234,6 +308,17 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	}
 
 	opts := claims.Step.SSH
+
+	// If custom SANs are disabled, the principals must be a subset of the names
+	// in the leaf certificate.
+	if p.DisableCustomSANs {
+		cn, sans := x5cLeafNames(claims.chains[0][0])
+		allowed := append([]string{cn}, sans...)
+		if len(opts.Principals) == 0 || !containsAllMembers(allowed, opts.Principals) {
+			return nil, errs.Unauthorized("x5c.AuthorizeSSHSign; x5c token principals %v are not allowed by the x5c certificate", opts.Principals)
+		}
+	}
+
 	signOptions := []SignOption{
 		// validates user's SSHOptions with the ones in the token
 		sshCertOptionsValidator(*opts),
@@ -262,11 +347,12 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 	// Default to a user certificate with no principals if not set
 	signOptions = append(signOptions, sshCertDefaultsModifier{CertType: SSHUserCert})
 
-	return append(signOptions,
+	return withSSHCertificateOptions(p.Options, append(signOptions,
 		// Set the default extensions.
 		&sshDefaultExtensionModifier{},
 		// Checks the validity bounds, and set the validity if has not been set.
 		&sshLimitDuration{p.claimer, claims.chains[0][0].NotAfter},
+		backdateOption(p.claimer.Backdate()),
 		// set the key id to the token subject
 		sshCertKeyIDValidator(claims.Subject),
 		// Validate public key.
@@ -275,5 +361,5 @@ func (p *X5C) AuthorizeSSHSign(ctx context.Context, token string) ([]SignOption,
 		&sshCertValidityValidator{p.claimer},
 		// Require all the fields in the SSH certificate
 		&sshCertDefaultValidator{},
-	), nil
+	), newTemplateData(token, claims.Subject, opts.Principals)), nil
 }
@@ -0,0 +1,232 @@
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxRevocationResponseSize is the maximum size of the OCSP responses and CRLs
+// downloaded by the X5C provisioner.
+const maxRevocationResponseSize = 10 << 20
+
+// errCertificateRevoked is the error returned when a certificate in the chain
+// of a token is revoked.
+var errCertificateRevoked = errors.New("certificate is revoked")
+
+// X5CRevocationOptions configures the revocation checks of the certificate
+// chains in the X5C tokens. The leaf and the intermediates are checked using
+// the OCSP servers and CRL distribution points in the certificates, OCSP is
+// tried first.
+//
+// Certificates without OCSP servers or CRL distribution points are always
+// valid. If SoftFail is true, the certificates are also valid if their status
+// cannot be determined, e.g. if the OCSP server is not available.
+type X5CRevocationOptions struct {
+	EnableOCSP bool `json:"enableOCSP,omitempty"`
+	EnableCRL  bool `json:"enableCRL,omitempty"`
+	SoftFail   bool `json:"softFail,omitempty"`
+}
+
+// isEnabled returns true if one of the revocation checks is enabled.
+func (o *X5CRevocationOptions) isEnabled() bool {
+	return o != nil && (o.EnableOCSP || o.EnableCRL)
+}
+
+// crlCache caches the CRLs by distribution point until their next update.
+type crlCache struct {
+	mu   sync.Mutex
+	crls map[string]*pkix.CertificateList
+}
+
+func newCRLCache() *crlCache {
+	return &crlCache{crls: make(map[string]*pkix.CertificateList)}
+}
+
+func (c *crlCache) get(url string, now time.Time) (*pkix.CertificateList, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	crl, ok := c.crls[url]
+	if !ok || crl.HasExpired(now) {
+		return nil, false
+	}
+	return crl, true
+}
+
+func (c *crlCache) set(url string, crl *pkix.CertificateList) {
+	c.mu.Lock()
+	c.crls[url] = crl
+	c.mu.Unlock()
+}
+
+// checkRevocation checks the revocation status of all the certificates in the
+// chain, but the root.
+func (p *X5C) checkRevocation(ctx context.Context, chain []*x509.Certificate) error {
+	if !p.Revocation.isEnabled() {
+		return nil
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		err := p.certificateStatus(ctx, chain[i], chain[i+1])
+		switch {
+		case err == nil:
+		case errors.Cause(err) == errCertificateRevoked:
+			return err
+		case !p.Revocation.SoftFail:
+			return err
+		}
+	}
+	return nil
+}
+
+// certificateStatus returns nil if the certificate is not revoked or if it
+// does not contain any OCSP server or CRL distribution point.
+func (p *X5C) certificateStatus(ctx context.Context, cert, issuer *x509.Certificate) error {
+	var lastErr error
+	if p.Revocation.EnableOCSP {
+		for _, server := range cert.OCSPServer {
+			revoked, err := p.ocspStatus(ctx, server, cert, issuer)
+			if err == nil {
+				return revocationError(cert, revoked)
+			}
+			lastErr = err
+		}
+	}
+	if p.Revocation.EnableCRL {
+		for _, dp := range cert.CRLDistributionPoints {
+			revoked, err := p.crlStatus(ctx, dp, cert, issuer)
+			if err == nil {
+				return revocationError(cert, revoked)
+			}
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return errors.Wrapf(lastErr, "error checking revocation status of certificate %s", cert.Subject)
+	}
+	return nil
+}
+
+func revocationError(cert *x509.Certificate, revoked bool) error {
+	if revoked {
+		return errors.Wrapf(errCertificateRevoked, "certificate %s", cert.Subject)
+	}
+	return nil
+}
+
+// ocspStatus requests the status of the certificate to the given OCSP server.
+func (p *X5C) ocspStatus(ctx context.Context, server string, cert, issuer *x509.Certificate) (bool, error) {
+	body, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "error creating ocsp request")
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", server, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to connect to %s", server)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	b, err := p.doRevocationRequest(req)
+	if err != nil {
+		return false, err
+	}
+	resp, err := ocsp.ParseResponseForCert(b, cert, issuer)
+	if err != nil {
+		return false, errors.Wrapf(err, "error parsing ocsp response from %s", server)
+	}
+	now := time.Now()
+	if resp.ThisUpdate.After(now.Add(time.Minute)) || (!resp.NextUpdate.IsZero() && resp.NextUpdate.Before(now)) {
+		return false, errors.Errorf("ocsp response from %s is not valid at this time", server)
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return false, nil
+	case ocsp.Revoked:
+		return true, nil
+	default:
+		return false, errors.Errorf("ocsp response from %s has an unknown status", server)
+	}
+}
+
+// crlStatus checks if the certificate is in the CRL of the given distribution
+// point.
+func (p *X5C) crlStatus(ctx context.Context, dp string, cert, issuer *x509.Certificate) (bool, error) {
+	if !strings.HasPrefix(dp, "http://") && !strings.HasPrefix(dp, "https://") {
+		return false, errors.Errorf("crl distribution point %s is not supported", dp)
+	}
+	now := time.Now()
+	crl, ok := p.crls.get(dp, now)
+	if !ok {
+		req, err := http.NewRequestWithContext(ctx, "GET", dp, http.NoBody)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to connect to %s", dp)
+		}
+		b, err := p.doRevocationRequest(req)
+		if err != nil {
+			return false, err
+		}
+		if crl, err = x509.ParseCRL(b); err != nil {
+			return false, errors.Wrapf(err, "error parsing crl from %s", dp)
+		}
+		if crl.HasExpired(now) {
+			return false, errors.Errorf("crl from %s has expired", dp)
+		}
+		p.crls.set(dp, crl)
+	}
+	// The signature is always verified, a cached CRL might have been fetched
+	// for a certificate with a different issuer.
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return false, errors.Wrapf(err, "error verifying crl from %s", dp)
+	}
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// doRevocationRequest sends the request and returns the body of the response.
+func (p *X5C) doRevocationRequest(req *http.Request) ([]byte, error) {
+	client, err := getHTTPClient(p.client, p.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", req.URL.String())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, errors.Errorf("error requesting %s: status=%d", req.URL.String(), resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", req.URL.String())
+	}
+	return b, nil
+}
+
+// x5cLeafNames returns the common name and the SANs of the leaf certificate,
+// the only names allowed if DisableCustomSANs is set.
+func x5cLeafNames(leaf *x509.Certificate) (cn string, sans []string) {
+	sans = append(sans, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, u := range leaf.URIs {
+		sans = append(sans, u.String())
+	}
+	return leaf.Subject.CommonName, sans
+}
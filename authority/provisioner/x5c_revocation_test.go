@@ -0,0 +1,355 @@
+package provisioner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ocsp"
+)
+
+type revocationTestPKI struct {
+	root, intermediate, leaf          *x509.Certificate
+	rootKey, intermediateKey, leafKey crypto.Signer
+}
+
+func newRevocationTestCert(t *testing.T, template, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	assert.FatalError(t, err)
+	cert, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return cert, key
+}
+
+// generateRevocationTestPKI creates a root, an intermediate and a leaf, the
+// intermediate and the leaf point to the given OCSP url and to their CRL urls.
+func generateRevocationTestPKI(t *testing.T, ocspURL, leafCRL, intermediateCRL string) *revocationTestPKI {
+	now := time.Now()
+	pki := new(revocationTestPKI)
+	pki.root, pki.rootKey = newRevocationTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Revocation Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+
+	var ocspServers []string
+	if ocspURL != "" {
+		ocspServers = []string{ocspURL}
+	}
+	crlDPs := func(url string) []string {
+		if url == "" {
+			return nil
+		}
+		return []string{url}
+	}
+	pki.intermediate, pki.intermediateKey = newRevocationTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Revocation Intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		OCSPServer:            ocspServers,
+		CRLDistributionPoints: crlDPs(intermediateCRL),
+	}, pki.root, pki.rootKey)
+	pki.leaf, pki.leafKey = newRevocationTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(3),
+		Subject:               pkix.Name{CommonName: "leaf.smallstep.com"},
+		DNSNames:              []string{"leaf.smallstep.com"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		OCSPServer:            ocspServers,
+		CRLDistributionPoints: crlDPs(leafCRL),
+	}, pki.intermediate, pki.intermediateKey)
+	return pki
+}
+
+func (pki *revocationTestPKI) chain() []*x509.Certificate {
+	return []*x509.Certificate{pki.leaf, pki.intermediate, pki.root}
+}
+
+func (pki *revocationTestPKI) rootPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.root.Raw})
+}
+
+// issuerOf returns the certificate and key that issued the given serial number.
+func (pki *revocationTestPKI) issuerOf(serial *big.Int) (*x509.Certificate, crypto.Signer) {
+	if serial.Cmp(pki.leaf.SerialNumber) == 0 {
+		return pki.intermediate, pki.intermediateKey
+	}
+	return pki.root, pki.rootKey
+}
+
+// revocationTestServer is an OCSP and CRL responder of a revocationTestPKI.
+type revocationTestServer struct {
+	*httptest.Server
+	pki      *revocationTestPKI
+	revoked  map[string]bool
+	status   int
+	requests int32
+}
+
+func newRevocationTestServer(t *testing.T) *revocationTestServer {
+	srv := &revocationTestServer{
+		revoked: make(map[string]bool),
+		status:  http.StatusOK,
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&srv.requests, 1)
+		if srv.status != http.StatusOK {
+			w.WriteHeader(srv.status)
+			return
+		}
+		now := time.Now()
+		switch r.URL.Path {
+		case "/ocsp":
+			body, err := ioutil.ReadAll(r.Body)
+			assert.FatalError(t, err)
+			req, err := ocsp.ParseRequest(body)
+			assert.FatalError(t, err)
+			issuer, key := srv.pki.issuerOf(req.SerialNumber)
+			tmpl := ocsp.Response{
+				Status:       ocsp.Good,
+				SerialNumber: req.SerialNumber,
+				ThisUpdate:   now.Add(-time.Minute),
+				NextUpdate:   now.Add(time.Hour),
+			}
+			if srv.revoked[req.SerialNumber.String()] {
+				tmpl.Status = ocsp.Revoked
+				tmpl.RevokedAt = now.Add(-time.Minute)
+			}
+			b, err := ocsp.CreateResponse(issuer, issuer, tmpl, key)
+			assert.FatalError(t, err)
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.Write(b)
+		case "/root.crl", "/intermediate.crl":
+			issuer, key := srv.pki.root, srv.pki.rootKey
+			if r.URL.Path == "/intermediate.crl" {
+				issuer, key = srv.pki.intermediate, srv.pki.intermediateKey
+			}
+			var revoked []pkix.RevokedCertificate
+			for _, c := range []*x509.Certificate{srv.pki.intermediate, srv.pki.leaf} {
+				if c.Issuer.String() == issuer.Subject.String() && srv.revoked[c.SerialNumber.String()] {
+					revoked = append(revoked, pkix.RevokedCertificate{
+						SerialNumber:   c.SerialNumber,
+						RevocationTime: now.Add(-time.Minute),
+					})
+				}
+			}
+			b, err := issuer.CreateCRL(rand.Reader, key, revoked, now.Add(-time.Minute), now.Add(time.Hour))
+			assert.FatalError(t, err)
+			w.Write(b)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv
+}
+
+func TestX5C_checkRevocation(t *testing.T) {
+	srv := newRevocationTestServer(t)
+	defer srv.Close()
+
+	type test struct {
+		opts    *X5CRevocationOptions
+		pki     *revocationTestPKI
+		revoked []*x509.Certificate
+		status  int
+		err     error
+	}
+	tests := map[string]func(*testing.T) test{
+		"ok/disabled": func(t *testing.T) test {
+			pki := generateRevocationTestPKI(t, srv.URL+"/ocsp", "", "")
+			return test{
+				opts:    &X5CRevocationOptions{SoftFail: true},
+				pki:     pki,
+				revoked: []*x509.Certificate{pki.leaf},
+			}
+		},
+		"ok/no-pointers": func(t *testing.T) test {
+			return test{
+				opts: &X5CRevocationOptions{EnableOCSP: true, EnableCRL: true},
+				pki:  generateRevocationTestPKI(t, "", "", ""),
+			}
+		},
+		"ok/ocsp": func(t *testing.T) test {
+			return test{
+				opts: &X5CRevocationOptions{EnableOCSP: true},
+				pki:  generateRevocationTestPKI(t, srv.URL+"/ocsp", "", ""),
+			}
+		},
+		"ok/crl": func(t *testing.T) test {
+			return test{
+				opts: &X5CRevocationOptions{EnableCRL: true},
+				pki:  generateRevocationTestPKI(t, "", srv.URL+"/intermediate.crl", srv.URL+"/root.crl"),
+			}
+		},
+		"ok/softFail": func(t *testing.T) test {
+			return test{
+				opts:   &X5CRevocationOptions{EnableOCSP: true, EnableCRL: true, SoftFail: true},
+				pki:    generateRevocationTestPKI(t, srv.URL+"/ocsp", srv.URL+"/intermediate.crl", srv.URL+"/root.crl"),
+				status: http.StatusInternalServerError,
+			}
+		},
+		"ok/ocsp-disabled-crl-enabled": func(t *testing.T) test {
+			return test{
+				opts: &X5CRevocationOptions{EnableCRL: true},
+				pki:  generateRevocationTestPKI(t, srv.URL+"/ocsp", "", ""),
+			}
+		},
+		"fail/ocsp-revoked-leaf": func(t *testing.T) test {
+			pki := generateRevocationTestPKI(t, srv.URL+"/ocsp", "", "")
+			return test{
+				opts:    &X5CRevocationOptions{EnableOCSP: true, SoftFail: true},
+				pki:     pki,
+				revoked: []*x509.Certificate{pki.leaf},
+				err:     errors.New("certificate CN=leaf.smallstep.com: certificate is revoked"),
+			}
+		},
+		"fail/ocsp-revoked-intermediate": func(t *testing.T) test {
+			pki := generateRevocationTestPKI(t, srv.URL+"/ocsp", "", "")
+			return test{
+				opts:    &X5CRevocationOptions{EnableOCSP: true},
+				pki:     pki,
+				revoked: []*x509.Certificate{pki.intermediate},
+				err:     errors.New("certificate CN=Revocation Intermediate: certificate is revoked"),
+			}
+		},
+		"fail/crl-revoked-intermediate": func(t *testing.T) test {
+			pki := generateRevocationTestPKI(t, "", srv.URL+"/intermediate.crl", srv.URL+"/root.crl")
+			return test{
+				opts:    &X5CRevocationOptions{EnableCRL: true},
+				pki:     pki,
+				revoked: []*x509.Certificate{pki.intermediate},
+				err:     errors.New("certificate CN=Revocation Intermediate: certificate is revoked"),
+			}
+		},
+		"fail/crl-revoked-leaf": func(t *testing.T) test {
+			pki := generateRevocationTestPKI(t, "", srv.URL+"/intermediate.crl", srv.URL+"/root.crl")
+			return test{
+				opts:    &X5CRevocationOptions{EnableCRL: true},
+				pki:     pki,
+				revoked: []*x509.Certificate{pki.leaf},
+				err:     errors.New("certificate CN=leaf.smallstep.com: certificate is revoked"),
+			}
+		},
+		"fail/crl-bad-signature": func(t *testing.T) test {
+			return test{
+				opts: &X5CRevocationOptions{EnableCRL: true},
+				pki:  generateRevocationTestPKI(t, "", srv.URL+"/root.crl", ""),
+				err:  errors.New("error checking revocation status of certificate CN=leaf.smallstep.com: error verifying crl from " + srv.URL + "/root.crl"),
+			}
+		},
+		"fail/unavailable": func(t *testing.T) test {
+			return test{
+				opts:   &X5CRevocationOptions{EnableOCSP: true, EnableCRL: true},
+				pki:    generateRevocationTestPKI(t, srv.URL+"/ocsp", srv.URL+"/intermediate.crl", srv.URL+"/root.crl"),
+				status: http.StatusInternalServerError,
+				err: errors.Errorf("error checking revocation status of certificate CN=leaf.smallstep.com: "+
+					"error requesting %s/intermediate.crl: status=500", srv.URL),
+			}
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := tt(t)
+			srv.pki = tc.pki
+			srv.revoked = make(map[string]bool)
+			for _, c := range tc.revoked {
+				srv.revoked[c.SerialNumber.String()] = true
+			}
+			srv.status = http.StatusOK
+			if tc.status != 0 {
+				srv.status = tc.status
+			}
+
+			p, err := generateX5C(tc.pki.rootPEM())
+			assert.FatalError(t, err)
+			p.Revocation = tc.opts
+			p.crls = newCRLCache()
+
+			err = p.checkRevocation(context.Background(), tc.pki.chain())
+			if tc.err != nil {
+				if assert.NotNil(t, err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.FatalError(t, err)
+			}
+		})
+	}
+}
+
+func TestX5C_checkRevocation_crlCache(t *testing.T) {
+	srv := newRevocationTestServer(t)
+	defer srv.Close()
+
+	srv.pki = generateRevocationTestPKI(t, "", srv.URL+"/intermediate.crl", srv.URL+"/root.crl")
+	p, err := generateX5C(srv.pki.rootPEM())
+	assert.FatalError(t, err)
+	p.Revocation = &X5CRevocationOptions{EnableCRL: true}
+	p.crls = newCRLCache()
+
+	for i := 0; i < 3; i++ {
+		assert.FatalError(t, p.checkRevocation(context.Background(), srv.pki.chain()))
+	}
+	assert.Equals(t, int32(2), atomic.LoadInt32(&srv.requests))
+}
+
+func TestX5C_authorizeToken_revocation(t *testing.T) {
+	srv := newRevocationTestServer(t)
+	defer srv.Close()
+
+	srv.pki = generateRevocationTestPKI(t, srv.URL+"/ocsp", "", "")
+	srv.revoked = map[string]bool{
+		srv.pki.leaf.SerialNumber.String(): true,
+	}
+
+	p := &X5C{
+		Name:       "x5c",
+		Type:       "X5C",
+		Roots:      srv.pki.rootPEM(),
+		Revocation: &X5CRevocationOptions{EnableOCSP: true},
+	}
+	assert.FatalError(t, p.Init(Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+	tok, err := generateToken("leaf.smallstep.com", p.GetName(), testAudiences.Sign[0], "",
+		[]string{"leaf.smallstep.com"}, time.Now(), &jose.JSONWebKey{Key: srv.pki.leafKey},
+		withX5CHdr(srv.pki.chain()[:2]))
+	assert.FatalError(t, err)
+
+	_, err = p.authorizeToken(tok, testAudiences.Sign)
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "x5c.authorizeToken; error validating x5c certificate chain in token")
+	}
+
+	srv.revoked = map[string]bool{}
+	_, err = p.authorizeToken(tok, testAudiences.Sign)
+	assert.FatalError(t, err)
+}
@@ -484,6 +484,8 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 								claims, err := tc.p.authorizeToken(tc.token, tc.p.audiences.Sign)
 								assert.FatalError(t, err)
 								assert.Equals(t, v.notAfter, claims.chains[0][0].NotAfter)
+							case backdateOption:
+								assert.Equals(t, time.Duration(v), tc.p.claimer.Backdate())
 							case commonNameValidator:
 								assert.Equals(t, string(v), "foo")
 							case defaultPublicKeyValidator:
@@ -496,12 +498,16 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 							case *validityValidator:
 								assert.Equals(t, v.min, tc.p.claimer.MinTLSCertDuration())
 								assert.Equals(t, v.max, tc.p.claimer.MaxTLSCertDuration())
+							case *sanDurationValidator:
+								assert.Equals(t, v.claimer, tc.p.claimer)
+							case x509ProfileValidator:
+								assert.Len(t, 0, v)
 							default:
 								assert.FatalError(t, errors.Errorf("unexpected sign option of type %T", v))
 							}
 							tot++
 						}
-						assert.Equals(t, tot, 8)
+						assert.Equals(t, tot, 11)
 					}
 				}
 			}
@@ -509,6 +515,56 @@ func TestX5C_AuthorizeSign(t *testing.T) {
 	}
 }
 
+func TestX5C_AuthorizeSign_disableCustomSANs(t *testing.T) {
+	certs, err := pemutil.ReadCertificateBundle("./testdata/certs/x5c-leaf.crt")
+	assert.FatalError(t, err)
+	jwk, err := jose.ParseKey("./testdata/secrets/x5c-leaf.key")
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		subject string
+		sans    []string
+		dns     []string
+		err     error
+	}{
+		{"ok/empty-sans", "leaf-test", []string{}, []string{"leaf-test"}, nil},
+		{"ok/sans", "leaf-test", []string{"leaf-test"}, []string{"leaf-test"}, nil},
+		{"fail/subject", "foo", []string{"leaf-test"}, nil, errors.New("x5c.AuthorizeSign; x5c token subject foo is not allowed by the x5c certificate")},
+		{"fail/sans", "leaf-test", []string{"leaf-test", "foo"}, nil, errors.New("x5c.AuthorizeSign; x5c token SANs [leaf-test foo] are not allowed by the x5c certificate")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.DisableCustomSANs = true
+			tok, err := generateToken(tt.subject, p.GetName(), testAudiences.Sign[0], "",
+				tt.sans, time.Now(), jwk, withX5CHdr(certs))
+			assert.FatalError(t, err)
+
+			opts, err := p.AuthorizeSign(context.Background(), tok)
+			if tt.err != nil {
+				if assert.NotNil(t, err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+				return
+			}
+			assert.FatalError(t, err)
+			var found bool
+			for _, o := range opts {
+				if v, ok := o.(dnsNamesValidator); ok {
+					assert.Equals(t, []string(v), tt.dns)
+					found = true
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
+
 func TestX5C_AuthorizeRevoke(t *testing.T) {
 	type test struct {
 		p     *X5C
@@ -594,7 +650,7 @@ func TestX5C_AuthorizeRenew(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			tc := tt(t)
-			if err := tc.p.AuthorizeRenew(context.Background(), nil); err != nil {
+			if err := tc.p.AuthorizeRenew(context.Background(), generateRenewCertificate()); err != nil {
 				if assert.NotNil(t, tc.err) {
 					sc, ok := err.(errs.StatusCoder)
 					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
@@ -691,6 +747,38 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 				err:   errors.New("x5c.AuthorizeSSHSign; x5c token must be an SSH provisioning token"),
 			}
 		},
+		"fail/disableCustomSANs": func(t *testing.T) test {
+			p, err := generateX5C(nil)
+			assert.FatalError(t, err)
+			p.DisableCustomSANs = true
+
+			id, err := randutil.ASCII(64)
+			assert.FatalError(t, err)
+			now := time.Now()
+			claims := &x5cPayload{
+				Claims: jose.Claims{
+					ID:        id,
+					Subject:   "leaf-test",
+					Issuer:    p.GetName(),
+					IssuedAt:  jose.NewNumericDate(now),
+					NotBefore: jose.NewNumericDate(now),
+					Expiry:    jose.NewNumericDate(now.Add(5 * time.Minute)),
+					Audience:  []string{testAudiences.SSHSign[0]},
+				},
+				Step: &stepPayload{SSH: &SSHOptions{
+					CertType:   SSHHostCert,
+					Principals: []string{"leaf-test", "foo"},
+				}},
+			}
+			tok, err := generateX5CSSHToken(x5cJWK, claims, withX5CHdr(x5cCerts))
+			assert.FatalError(t, err)
+			return test{
+				p:     p,
+				token: tok,
+				code:  http.StatusUnauthorized,
+				err:   errors.New("x5c.AuthorizeSSHSign; x5c token principals [leaf-test foo] are not allowed by the x5c certificate"),
+			}
+		},
 		"ok/with-claims": func(t *testing.T) test {
 			p, err := generateX5C(nil)
 			assert.FatalError(t, err)
@@ -787,6 +875,8 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 							case *sshLimitDuration:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
 								assert.Equals(t, v.NotAfter, x5cCerts[0].NotAfter)
+							case backdateOption:
+								assert.Equals(t, time.Duration(v), tc.p.claimer.Backdate())
 							case *sshCertValidityValidator:
 								assert.Equals(t, v.Claimer, tc.p.claimer)
 							case *sshDefaultExtensionModifier, *sshDefaultPublicKeyValidator,
@@ -799,9 +889,9 @@ func TestX5C_AuthorizeSSHSign(t *testing.T) {
 							tot++
 						}
 						if len(tc.claims.Step.SSH.CertType) > 0 {
-							assert.Equals(t, tot, 13)
+							assert.Equals(t, tot, 14)
 						} else {
-							assert.Equals(t, tot, 9)
+							assert.Equals(t, tot, 10)
 						}
 					}
 				}
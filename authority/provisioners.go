@@ -1,9 +1,18 @@
 package authority
 
 import (
+	"bytes"
 	"crypto/x509"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
 )
 
@@ -41,3 +50,615 @@ func (a *Authority) LoadProvisionerByID(id string) (provisioner.Interface, error
 	}
 	return p, nil
 }
+
+// GetKeyStoreProvisioners returns the provisioners that validate tokens with
+// keys fetched from a remote server.
+func (a *Authority) GetKeyStoreProvisioners() []provisioner.KeyStoreProvisioner {
+	var list []provisioner.KeyStoreProvisioner
+	for _, p := range a.config.AuthorityConfig.Provisioners {
+		if kp, ok := p.(provisioner.KeyStoreProvisioner); ok {
+			list = append(list, kp)
+		}
+	}
+	a.provisionersMutex.RLock()
+	defer a.provisionersMutex.RUnlock()
+	for _, sp := range a.storedProvisioners {
+		if kp, ok := sp.provisioner.(provisioner.KeyStoreProvisioner); ok {
+			list = append(list, kp)
+		}
+	}
+	return list
+}
+
+// loadJWKProvisioner returns the JWK provisioner with the given name. The keys
+// of a provisioner can only be managed if its name is unique.
+func (a *Authority) loadJWKProvisioner(name string) (*provisioner.JWK, error) {
+	var found *provisioner.JWK
+	for _, p := range a.config.AuthorityConfig.Provisioners {
+		if jwk, ok := p.(*provisioner.JWK); ok && jwk.Name == name {
+			if found != nil {
+				return nil, errs.BadRequest("multiple jwk provisioners with name %s", name)
+			}
+			found = jwk
+		}
+	}
+	if found == nil {
+		return nil, errs.NotFound("jwk provisioner %s was not found", name)
+	}
+	return found, nil
+}
+
+// loadProvisionerKeys adds to the JWK provisioners the keys added or retired
+// using the admin API. They take precedence over the ones in the
+// configuration.
+func (a *Authority) loadProvisionerKeys() error {
+	for _, p := range a.config.AuthorityConfig.Provisioners {
+		jwk, ok := p.(*provisioner.JWK)
+		if !ok {
+			continue
+		}
+		b, err := a.db.GetProvisionerKeys(jwk.GetID())
+		if err != nil {
+			return errs.Wrapf(http.StatusInternalServerError, err,
+				"error loading keys of provisioner %s", jwk.GetID())
+		}
+		if len(b) == 0 {
+			continue
+		}
+		var keys []*provisioner.JWKKey
+		if err := json.Unmarshal(b, &keys); err != nil {
+			return errs.Wrapf(http.StatusInternalServerError, err,
+				"error unmarshaling keys of provisioner %s", jwk.GetID())
+		}
+		for _, k := range keys {
+			if err := jwk.AddKey(k); err != nil {
+				return errs.Wrapf(http.StatusInternalServerError, err,
+					"error loading keys of provisioner %s", jwk.GetID())
+			}
+			if err := a.provisioners.StoreJWKKey(jwk, k.Key.KeyID); err != nil {
+				return errs.Wrapf(http.StatusInternalServerError, err,
+					"error loading keys of provisioner %s", jwk.GetID())
+			}
+		}
+	}
+	return nil
+}
+
+// storeProvisionerKeys persists the given list of additional keys of a JWK
+// provisioner.
+func (a *Authority) storeProvisionerKeys(p *provisioner.JWK, keys []*provisioner.JWKKey) error {
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error marshaling provisioner keys")
+	}
+	if err := a.db.StoreProvisionerKeys(p.GetID(), b); err != nil {
+		if err == db.ErrNotImplemented {
+			return errs.Wrap(http.StatusNotImplemented, err, "storeProvisionerKeys is not implemented")
+		}
+		return errs.Wrap(http.StatusInternalServerError, err, "error storing provisioner keys")
+	}
+	return nil
+}
+
+// GetProvisionerKeys returns the additional keys of the JWK provisioner with
+// the given name.
+func (a *Authority) GetProvisionerKeys(name string) ([]*provisioner.JWKKey, error) {
+	p, err := a.loadJWKProvisioner(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetKeys(), nil
+}
+
+// AddProvisionerKey adds a new key to the JWK provisioner with the given name.
+// If the provisioner already has a key with the same key id, its validity is
+// replaced with the one in the given key.
+func (a *Authority) AddProvisionerKey(name string, key *provisioner.JWKKey) error {
+	p, err := a.loadJWKProvisioner(name)
+	if err != nil {
+		return err
+	}
+	if err := key.Validate(); err != nil {
+		return errs.BadRequestErr(err, errs.WithMessage("invalid key: %s", err))
+	}
+	if key.Key.KeyID == p.Key.KeyID {
+		return errs.BadRequest("key %s is the provisioner key", key.Key.KeyID)
+	}
+
+	a.provisionerKeysMutex.Lock()
+	defer a.provisionerKeysMutex.Unlock()
+
+	if err := a.provisioners.StoreJWKKey(p, key.Key.KeyID); err != nil {
+		return errs.BadRequest("key %s is already used by another provisioner", key.Key.KeyID)
+	}
+	keys := []*provisioner.JWKKey{key}
+	for _, k := range p.GetKeys() {
+		if k.Key.KeyID != key.Key.KeyID {
+			keys = append(keys, k)
+		}
+	}
+	if err := a.storeProvisionerKeys(p, keys); err != nil {
+		return err
+	}
+	return errs.Wrap(http.StatusInternalServerError, p.AddKey(key), "error adding provisioner key")
+}
+
+// RetireProvisionerKey sets the end of the validity of a key of the JWK
+// provisioner with the given name. If notAfter is zero, the key is retired
+// immediately.
+func (a *Authority) RetireProvisionerKey(name, kid string, notAfter time.Time) (*provisioner.JWKKey, error) {
+	p, err := a.loadJWKProvisioner(name)
+	if err != nil {
+		return nil, err
+	}
+	if kid == p.Key.KeyID {
+		return nil, errs.BadRequest("key %s is the provisioner key and cannot be retired", kid)
+	}
+	if notAfter.IsZero() {
+		notAfter = time.Now()
+	}
+
+	a.provisionerKeysMutex.Lock()
+	defer a.provisionerKeysMutex.Unlock()
+
+	var found bool
+	keys := p.GetKeys()
+	for _, k := range keys {
+		if k.Key.KeyID == kid {
+			k.NotAfter = notAfter
+			found = true
+		}
+	}
+	if !found {
+		return nil, errs.NotFound("key %s was not found in provisioner %s", kid, name)
+	}
+	if err := a.storeProvisionerKeys(p, keys); err != nil {
+		return nil, err
+	}
+	key, err := p.RetireKey(kid, notAfter)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error retiring provisioner key")
+	}
+	return key, nil
+}
+
+// ProvisionerRecord is a provisioner managed with the admin API. The version
+// is incremented on every change, and the updates and deletes must use the
+// current one to detect concurrent modifications.
+type ProvisionerRecord struct {
+	Name        string          `json:"name"`
+	Version     int             `json:"version"`
+	Provisioner json.RawMessage `json:"provisioner,omitempty"`
+	Deleted     bool            `json:"deleted,omitempty"`
+}
+
+// storedProvisioner is the in memory representation of a provisioner record,
+// with the value stored in the database used to swap it.
+type storedProvisioner struct {
+	record      *ProvisionerRecord
+	value       []byte
+	provisioner provisioner.Interface
+}
+
+// loadStoredProvisioners initializes the provisioners created using the admin
+// API and adds them to the collection.
+func (a *Authority) loadStoredProvisioners() error {
+	list, err := a.db.GetProvisioners()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading provisioners")
+	}
+	a.storedProvisioners = make(map[string]*storedProvisioner, len(list))
+	for _, b := range list {
+		r := new(ProvisionerRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling provisioner record")
+		}
+		sp := &storedProvisioner{record: r, value: b}
+		if !r.Deleted {
+			if sp.provisioner, err = a.newStoredProvisioner(r.Name, r.Provisioner); err != nil {
+				return errs.Wrapf(http.StatusInternalServerError, err, "error loading provisioner %s", r.Name)
+			}
+			if err := a.provisioners.Store(sp.provisioner); err != nil {
+				return errs.Wrapf(http.StatusInternalServerError, err, "error loading provisioner %s", r.Name)
+			}
+		}
+		a.storedProvisioners[r.Name] = sp
+	}
+	return nil
+}
+
+// syncStoredProvisioners applies the changes to the provisioners made with the
+// admin API by other instances of the CA sharing the database. Provisioners
+// that cannot be initialized keep their previous version.
+func (a *Authority) syncStoredProvisioners() error {
+	list, err := a.db.GetProvisioners()
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error loading provisioners")
+	}
+
+	a.provisionersMutex.Lock()
+	defer a.provisionersMutex.Unlock()
+	if a.storedProvisioners == nil {
+		a.storedProvisioners = make(map[string]*storedProvisioner, len(list))
+	}
+	var firstErr error
+	for _, b := range list {
+		r := new(ProvisionerRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling provisioner record")
+		}
+		old, ok := a.storedProvisioners[r.Name]
+		if ok && (bytes.Equal(old.value, b) || old.record.Version > r.Version) {
+			continue
+		}
+		sp := &storedProvisioner{record: r, value: b}
+		if !r.Deleted {
+			if sp.provisioner, err = a.newStoredProvisioner(r.Name, r.Provisioner); err != nil {
+				if firstErr == nil {
+					firstErr = errs.Wrapf(http.StatusInternalServerError, err, "error loading provisioner %s", r.Name)
+				}
+				continue
+			}
+		}
+		if ok && old.provisioner != nil {
+			a.provisioners.Remove(old.provisioner)
+		}
+		if sp.provisioner != nil {
+			if err := a.provisioners.Store(sp.provisioner); err != nil {
+				a.provisioners.Remove(sp.provisioner)
+				closeProvisioner(sp.provisioner)
+				if ok && old.provisioner != nil {
+					_ = a.provisioners.Store(old.provisioner)
+				}
+				if firstErr == nil {
+					firstErr = errs.Wrapf(http.StatusInternalServerError, err, "error loading provisioner %s", r.Name)
+				}
+				continue
+			}
+		}
+		if ok && old.provisioner != nil {
+			closeProvisioner(old.provisioner)
+		}
+		a.storedProvisioners[r.Name] = sp
+	}
+	return firstErr
+}
+
+// newStoredProvisioner parses and initializes a provisioner. The name, if
+// given, must match the name of the provisioner.
+func (a *Authority) newStoredProvisioner(name string, data []byte) (provisioner.Interface, error) {
+	p, err := provisioner.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && p.GetName() != name {
+		return nil, errors.Errorf("provisioner name %s does not match %s", p.GetName(), name)
+	}
+	if err := p.Init(a.provisionerConfig); err != nil {
+		closeProvisioner(p)
+		return nil, err
+	}
+	return p, nil
+}
+
+// closeProvisioner releases the background resources of a provisioner that
+// has been removed or replaced, like the reload timers of its keys.
+func closeProvisioner(p provisioner.Interface) {
+	if c, ok := p.(provisioner.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("error closing provisioner %s: %v", p.GetName(), err)
+		}
+	}
+}
+
+// StopProvisioners releases the background resources of the provisioners in
+// the configuration and the ones managed with the admin API. It's used when
+// the authority is discarded.
+func (a *Authority) StopProvisioners() {
+	a.provisionersMutex.Lock()
+	defer a.provisionersMutex.Unlock()
+	if a.provisionersStopped {
+		return
+	}
+	a.provisionersStopped = true
+	if a.config != nil && a.config.AuthorityConfig != nil {
+		for _, p := range a.config.AuthorityConfig.Provisioners {
+			closeProvisioner(p)
+		}
+	}
+	for _, sp := range a.storedProvisioners {
+		if sp.provisioner != nil {
+			closeProvisioner(sp.provisioner)
+		}
+	}
+}
+
+// swapStoredProvisioner stores the given record in the database if the
+// current value is still the one in sp.
+func (a *Authority) swapStoredProvisioner(sp *storedProvisioner, r *ProvisionerRecord) ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error marshaling provisioner record")
+	}
+	var old []byte
+	if sp != nil {
+		old = sp.value
+	}
+	swapped, err := a.db.CmpAndSwapProvisioner(r.Name, old, b)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.Wrap(http.StatusNotImplemented, err, "storing provisioners is not implemented")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error storing provisioner")
+	case !swapped:
+		return nil, errs.Errorf(http.StatusConflict, "provisioner %s has been modified concurrently", r.Name)
+	default:
+		return b, nil
+	}
+}
+
+// loadStoredProvisioner returns the stored provisioner with the given name. It
+// must be called with the provisioners lock held.
+func (a *Authority) loadStoredProvisioner(name string) (*storedProvisioner, error) {
+	if sp, ok := a.storedProvisioners[name]; ok && !sp.record.Deleted {
+		return sp, nil
+	}
+	for _, p := range a.config.AuthorityConfig.Provisioners {
+		if p.GetName() == name {
+			return nil, errs.BadRequest("provisioner %s is defined in the configuration and cannot be modified", name)
+		}
+	}
+	return nil, errs.NotFound("provisioner %s was not found", name)
+}
+
+// GetProvisionerRecords returns the provisioners managed with the admin API
+// sorted by name.
+func (a *Authority) GetProvisionerRecords() []*ProvisionerRecord {
+	a.provisionersMutex.RLock()
+	defer a.provisionersMutex.RUnlock()
+	list := []*ProvisionerRecord{}
+	for _, sp := range a.storedProvisioners {
+		if !sp.record.Deleted {
+			list = append(list, sp.record)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// GetProvisionerRecord returns the provisioner managed with the admin API with
+// the given name.
+func (a *Authority) GetProvisionerRecord(name string) (*ProvisionerRecord, error) {
+	a.provisionersMutex.RLock()
+	defer a.provisionersMutex.RUnlock()
+	sp, err := a.loadStoredProvisioner(name)
+	if err != nil {
+		return nil, err
+	}
+	return sp.record, nil
+}
+
+// CreateProvisioner adds the given JSON encoded provisioner to the authority
+// and stores it in the database. The name of the provisioner must not be used
+// by any other provisioner.
+func (a *Authority) CreateProvisioner(data []byte) (*ProvisionerRecord, error) {
+	a.provisionersMutex.Lock()
+	defer a.provisionersMutex.Unlock()
+
+	p, err := a.newStoredProvisioner("", data)
+	if err != nil {
+		return nil, errs.BadRequestErr(err, errs.WithMessage("invalid provisioner: %s", err))
+	}
+	name := p.GetName()
+	for _, cp := range a.config.AuthorityConfig.Provisioners {
+		if cp.GetName() == name {
+			closeProvisioner(p)
+			return nil, errs.Errorf(http.StatusConflict, "provisioner %s already exists", name)
+		}
+	}
+	// Deleted provisioners keep their version, so a new provisioner with the
+	// same name continues with the next one.
+	r := &ProvisionerRecord{Name: name, Version: 1, Provisioner: data}
+	sp, ok := a.storedProvisioners[name]
+	if ok {
+		if !sp.record.Deleted {
+			closeProvisioner(p)
+			return nil, errs.Errorf(http.StatusConflict, "provisioner %s already exists", name)
+		}
+		r.Version = sp.record.Version + 1
+	}
+	if err := a.provisioners.Store(p); err != nil {
+		a.provisioners.Remove(p)
+		closeProvisioner(p)
+		return nil, errs.BadRequestErr(err, errs.WithMessage("invalid provisioner: %s", err))
+	}
+	b, err := a.swapStoredProvisioner(sp, r)
+	if err != nil {
+		a.provisioners.Remove(p)
+		closeProvisioner(p)
+		return nil, err
+	}
+	if a.storedProvisioners == nil {
+		a.storedProvisioners = make(map[string]*storedProvisioner)
+	}
+	a.storedProvisioners[name] = &storedProvisioner{record: r, value: b, provisioner: p}
+	a.notifyProvisionerAdded(p)
+	return r, nil
+}
+
+// UpdateProvisioner replaces the provisioner with the given name with the
+// JSON encoded one. The version must be the current version of the
+// provisioner, otherwise it returns a conflict error. Only super-admins can
+// modify the restricted properties of the provisioner.
+func (a *Authority) UpdateProvisioner(admin *Admin, name string, version int, data []byte) (*ProvisionerRecord, error) {
+	a.provisionersMutex.Lock()
+	defer a.provisionersMutex.Unlock()
+
+	sp, err := a.loadStoredProvisioner(name)
+	if err != nil {
+		return nil, err
+	}
+	if sp.record.Version != version {
+		return nil, errs.Errorf(http.StatusConflict, "provisioner %s version %d does not match the current version %d", name, version, sp.record.Version)
+	}
+	if admin == nil || !admin.IsSuperAdmin() {
+		if err := checkRestrictedProvisionerProperties(sp.record.Provisioner, data); err != nil {
+			return nil, err
+		}
+	}
+	return a.replaceStoredProvisioner(sp, data)
+}
+
+// restrictedProvisionerProperties are the properties of a provisioner that
+// only super-admins can modify. They define the certificates that the
+// provisioner can sign: the type, the claims, and the options with the
+// templates, webhooks, profiles and policy.
+var restrictedProvisionerProperties = []string{"type", "claims", "options"}
+
+// checkRestrictedProvisionerProperties returns a forbidden error if the
+// restricted properties of the old and new JSON encoded provisioners are not
+// equal.
+func checkRestrictedProvisionerProperties(old, data []byte) error {
+	var o, n map[string]interface{}
+	if err := json.Unmarshal(old, &o); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling provisioner")
+	}
+	if err := json.Unmarshal(data, &n); err != nil {
+		return errs.BadRequestErr(err, errs.WithMessage("invalid provisioner: %s", err))
+	}
+	for _, k := range restrictedProvisionerProperties {
+		if !reflect.DeepEqual(o[k], n[k]) {
+			return errs.Forbidden("only a %s can modify the %s of a provisioner", AdminRoleSuperAdmin, k)
+		}
+	}
+	return nil
+}
+
+// replaceStoredProvisioner replaces the given stored provisioner with the
+// JSON encoded one. It must be called with the provisioners lock held.
+func (a *Authority) replaceStoredProvisioner(sp *storedProvisioner, data []byte) (*ProvisionerRecord, error) {
+	name, version := sp.record.Name, sp.record.Version
+	p, err := a.newStoredProvisioner(name, data)
+	if err != nil {
+		return nil, errs.BadRequestErr(err, errs.WithMessage("invalid provisioner: %s", err))
+	}
+
+	a.provisioners.Remove(sp.provisioner)
+	// The old provisioner was already in the collection, so it can always be
+	// stored again.
+	rollback := func() {
+		a.provisioners.Remove(p)
+		_ = a.provisioners.Store(sp.provisioner)
+		closeProvisioner(p)
+	}
+	if err := a.provisioners.Store(p); err != nil {
+		rollback()
+		return nil, errs.BadRequestErr(err, errs.WithMessage("invalid provisioner: %s", err))
+	}
+	r := &ProvisionerRecord{Name: name, Version: version + 1, Provisioner: data}
+	b, err := a.swapStoredProvisioner(sp, r)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	a.storedProvisioners[name] = &storedProvisioner{record: r, value: b, provisioner: p}
+	closeProvisioner(sp.provisioner)
+	return r, nil
+}
+
+// ProvisionerSwitches are the claims of a provisioner that can be toggled
+// using the admin API without sending the whole provisioner, e.g. to stop the
+// enrollment of new clients during an incident while allowing renewals. Nil
+// values are not modified.
+type ProvisionerSwitches struct {
+	DisableIssuance *bool `json:"disableIssuance,omitempty"`
+	DisableRenewal  *bool `json:"disableRenewal,omitempty"`
+}
+
+// SetProvisionerSwitches sets the disableIssuance and disableRenewal claims of
+// the provisioner with the given name. The version must be the current version
+// of the provisioner, otherwise it returns a conflict error.
+func (a *Authority) SetProvisionerSwitches(name string, version int, switches ProvisionerSwitches) (*ProvisionerRecord, error) {
+	a.provisionersMutex.Lock()
+	defer a.provisionersMutex.Unlock()
+
+	sp, err := a.loadStoredProvisioner(name)
+	if err != nil {
+		return nil, err
+	}
+	if sp.record.Version != version {
+		return nil, errs.Errorf(http.StatusConflict, "provisioner %s version %d does not match the current version %d", name, version, sp.record.Version)
+	}
+	data, err := setProvisionerClaims(sp.record.Provisioner, map[string]*bool{
+		"disableIssuance": switches.DisableIssuance,
+		"disableRenewal":  switches.DisableRenewal,
+	})
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error setting provisioner claims")
+	}
+	return a.replaceStoredProvisioner(sp, data)
+}
+
+// setProvisionerClaims sets the given claims in the JSON encoded provisioner,
+// keeping the rest of its properties. Nil values are skipped.
+func setProvisionerClaims(data []byte, values map[string]*bool) ([]byte, error) {
+	var m, claims map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling provisioner")
+	}
+	if b, ok := m["claims"]; ok {
+		if err := json.Unmarshal(b, &claims); err != nil {
+			return nil, errors.Wrap(err, "error unmarshaling provisioner claims")
+		}
+	}
+	if claims == nil {
+		claims = make(map[string]json.RawMessage)
+	}
+	for k, v := range values {
+		if v == nil {
+			continue
+		}
+		b, err := json.Marshal(*v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling claim %s", k)
+		}
+		claims[k] = b
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling provisioner claims")
+	}
+	m["claims"] = b
+	return json.Marshal(m)
+}
+
+// DeleteProvisioner removes the provisioner with the given name from the
+// authority. The version must be the current version of the provisioner,
+// otherwise it returns a conflict error.
+func (a *Authority) DeleteProvisioner(name string, version int) error {
+	a.provisionersMutex.Lock()
+	defer a.provisionersMutex.Unlock()
+
+	sp, err := a.loadStoredProvisioner(name)
+	if err != nil {
+		return err
+	}
+	if sp.record.Version != version {
+		return errs.Errorf(http.StatusConflict, "provisioner %s version %d does not match the current version %d", name, version, sp.record.Version)
+	}
+
+	// The record is kept in the database so concurrent changes based on the
+	// deleted version are detected.
+	a.provisioners.Remove(sp.provisioner)
+	r := &ProvisionerRecord{Name: name, Version: version + 1, Deleted: true}
+	b, err := a.swapStoredProvisioner(sp, r)
+	if err != nil {
+		_ = a.provisioners.Store(sp.provisioner)
+		return err
+	}
+	a.storedProvisioners[name] = &storedProvisioner{record: r, value: b}
+	closeProvisioner(sp.provisioner)
+	return nil
+}
@@ -1,13 +1,19 @@
 package authority
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
 )
 
 func TestGetEncryptedKey(t *testing.T) {
@@ -104,3 +110,332 @@ func TestGetProvisioners(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_ProvisionerKeys(t *testing.T) {
+	stored := map[string][]byte{}
+	mockDB := &db.MockAuthDB{
+		MGetProvisionerKeys: func(id string) ([]byte, error) {
+			return stored[id], nil
+		},
+		MStoreProvisionerKeys: func(id string, keys []byte) error {
+			stored[id] = keys
+			return nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	p, err := a.loadJWKProvisioner("Max")
+	assert.FatalError(t, err)
+
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "new-key", 0)
+	assert.FatalError(t, err)
+	pub := jwk.Public()
+
+	assertError := func(err error, code int, msg string) {
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+			assert.HasPrefix(t, err.Error(), msg)
+		}
+	}
+
+	// Add
+	assertError(a.AddProvisionerKey("foo", &provisioner.JWKKey{Key: &pub}), http.StatusNotFound, "jwk provisioner foo was not found")
+	assertError(a.AddProvisionerKey("Max", &provisioner.JWKKey{Key: jwk}), http.StatusBadRequest, "key "+jwk.KeyID+" must be a public key")
+	assertError(a.AddProvisionerKey("Max", &provisioner.JWKKey{Key: p.Key}), http.StatusBadRequest, "key "+p.Key.KeyID+" is the provisioner key")
+	assert.FatalError(t, a.AddProvisionerKey("Max", &provisioner.JWKKey{Key: &pub}))
+	keys, err := a.GetProvisionerKeys("Max")
+	assert.FatalError(t, err)
+	assert.Len(t, 1, keys)
+	assert.Equals(t, pub.KeyID, keys[0].Key.KeyID)
+	got, ok := a.provisioners.Load("Max:" + pub.KeyID)
+	assert.True(t, ok)
+	assert.Equals(t, p, got)
+
+	// Retire
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, err = a.RetireProvisionerKey("Max", p.Key.KeyID, notAfter)
+	assertError(err, http.StatusBadRequest, "key "+p.Key.KeyID+" is the provisioner key and cannot be retired")
+	_, err = a.RetireProvisionerKey("Max", "foo", notAfter)
+	assertError(err, http.StatusNotFound, "key foo was not found in provisioner Max")
+	key, err := a.RetireProvisionerKey("Max", pub.KeyID, notAfter)
+	assert.FatalError(t, err)
+	assert.Equals(t, notAfter, key.NotAfter)
+
+	// The stored keys are loaded by a new authority
+	a2 := testAuthority(t, WithDatabase(mockDB))
+	keys, err = a2.GetProvisionerKeys("Max")
+	assert.FatalError(t, err)
+	assert.Len(t, 1, keys)
+	assert.Equals(t, pub.KeyID, keys[0].Key.KeyID)
+	assert.True(t, keys[0].NotAfter.Equal(notAfter))
+	_, ok = a2.provisioners.Load("Max:" + pub.KeyID)
+	assert.True(t, ok)
+
+	// Database without support
+	a3 := testAuthority(t)
+	assertError(a3.AddProvisionerKey("Max", &provisioner.JWKKey{Key: &pub}), http.StatusNotImplemented, "storeProvisionerKeys is not implemented")
+}
+
+var superAdmin = &Admin{Subject: "admin", Role: AdminRoleSuperAdmin}
+
+func TestAuthority_ProvisionerRecords(t *testing.T) {
+	stored := map[string][]byte{}
+	mockDB := &db.MockAuthDB{
+		MGetProvisioners: func() ([][]byte, error) {
+			var list [][]byte
+			for _, b := range stored {
+				list = append(list, b)
+			}
+			return list, nil
+		},
+		MCmpAndSwapProvisioner: func(name string, oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(stored[name], oldValue) {
+				return false, nil
+			}
+			stored[name] = newValue
+			return true, nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+
+	assertError := func(err error, code int, msg string) {
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+			assert.HasPrefix(t, err.Error(), msg)
+		}
+	}
+	assertLoad := func(a *Authority, id string, want bool) {
+		_, ok := a.provisioners.Load(id)
+		assert.Equals(t, want, ok, id)
+	}
+
+	// Create
+	acme := []byte(`{"type":"ACME","name":"acme-admin"}`)
+	_, err := a.CreateProvisioner([]byte(`{"type":"foo","name":"foo"}`))
+	assertError(err, http.StatusBadRequest, "provisioner type 'foo' is not supported")
+	_, err = a.CreateProvisioner([]byte(`{"type":"ACME"}`))
+	assertError(err, http.StatusBadRequest, "provisioner name cannot be empty")
+	_, err = a.CreateProvisioner([]byte(`{"type":"ACME","name":"Max"}`))
+	assertError(err, http.StatusConflict, "provisioner Max already exists")
+	r, err := a.CreateProvisioner(acme)
+	assert.FatalError(t, err)
+	assert.Equals(t, &ProvisionerRecord{Name: "acme-admin", Version: 1, Provisioner: acme}, r)
+	assertLoad(a, "acme/acme-admin", true)
+	_, err = a.CreateProvisioner(acme)
+	assertError(err, http.StatusConflict, "provisioner acme-admin already exists")
+
+	// Get
+	assert.Equals(t, []*ProvisionerRecord{r}, a.GetProvisionerRecords())
+	got, err := a.GetProvisionerRecord("acme-admin")
+	assert.FatalError(t, err)
+	assert.Equals(t, r, got)
+	_, err = a.GetProvisionerRecord("Max")
+	assertError(err, http.StatusBadRequest, "provisioner Max is defined in the configuration and cannot be modified")
+	_, err = a.GetProvisionerRecord("foo")
+	assertError(err, http.StatusNotFound, "provisioner foo was not found")
+
+	// Update
+	acme2 := []byte(`{"type":"ACME","name":"acme-admin","requireEAB":true}`)
+	_, err = a.UpdateProvisioner(superAdmin, "acme-admin", 2, acme2)
+	assertError(err, http.StatusConflict, "provisioner acme-admin version 2 does not match the current version 1")
+	_, err = a.UpdateProvisioner(superAdmin, "acme-admin", 1, []byte(`{"type":"ACME","name":"other"}`))
+	assertError(err, http.StatusBadRequest, "provisioner name other does not match acme-admin")
+	_, err = a.UpdateProvisioner(superAdmin, "Max", 1, acme2)
+	assertError(err, http.StatusBadRequest, "provisioner Max is defined in the configuration and cannot be modified")
+	r, err = a.UpdateProvisioner(superAdmin, "acme-admin", 1, acme2)
+	assert.FatalError(t, err)
+	assert.Equals(t, &ProvisionerRecord{Name: "acme-admin", Version: 2, Provisioner: acme2}, r)
+	p, err := a.LoadProvisionerByID("acme/acme-admin")
+	assert.FatalError(t, err)
+	assert.True(t, p.(*provisioner.ACME).RequireEAB)
+
+	// A concurrent change in the database
+	a2 := testAuthority(t, WithDatabase(mockDB))
+	_, err = a2.UpdateProvisioner(superAdmin, "acme-admin", 2, acme)
+	assert.FatalError(t, err)
+	_, err = a.UpdateProvisioner(superAdmin, "acme-admin", 2, acme)
+	assertError(err, http.StatusConflict, "provisioner acme-admin has been modified concurrently")
+	p, err = a.LoadProvisionerByID("acme/acme-admin")
+	assert.FatalError(t, err)
+	assert.True(t, p.(*provisioner.ACME).RequireEAB)
+
+	// Delete
+	a3 := testAuthority(t, WithDatabase(mockDB))
+	assertLoad(a3, "acme/acme-admin", true)
+	assertError(a3.DeleteProvisioner("acme-admin", 2), http.StatusConflict, "provisioner acme-admin version 2 does not match the current version 3")
+	assertError(a3.DeleteProvisioner("Max", 1), http.StatusBadRequest, "provisioner Max is defined in the configuration and cannot be modified")
+	assert.FatalError(t, a3.DeleteProvisioner("acme-admin", 3))
+	assertLoad(a3, "acme/acme-admin", false)
+	assert.Equals(t, []*ProvisionerRecord{}, a3.GetProvisionerRecords())
+	_, err = a3.UpdateProvisioner(superAdmin, "acme-admin", 4, acme)
+	assertError(err, http.StatusNotFound, "provisioner acme-admin was not found")
+	assertLoad(testAuthority(t, WithDatabase(mockDB)), "acme/acme-admin", false)
+
+	// Create after delete
+	r, err = a3.CreateProvisioner(acme)
+	assert.FatalError(t, err)
+	assert.Equals(t, 5, r.Version)
+	assertLoad(a3, "acme/acme-admin", true)
+
+	// Database without support
+	a4 := testAuthority(t)
+	_, err = a4.CreateProvisioner(acme)
+	assertError(err, http.StatusNotImplemented, "storing provisioners is not implemented")
+	assertLoad(a4, "acme/acme-admin", false)
+}
+
+func TestAuthority_UpdateProvisioner_restricted(t *testing.T) {
+	stored := map[string][]byte{}
+	mockDB := &db.MockAuthDB{
+		MGetProvisioners: func() ([][]byte, error) {
+			return nil, nil
+		},
+		MCmpAndSwapProvisioner: func(name string, oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(stored[name], oldValue) {
+				return false, nil
+			}
+			stored[name] = newValue
+			return true, nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	provisionerAdmin := &Admin{Subject: "other", Role: AdminRoleProvisionerAdmin, Provisioners: []string{"acme-admin"}}
+
+	_, err := a.CreateProvisioner([]byte(`{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"24h"},"options":{"x509":{"template":"{}"}}}`))
+	assert.FatalError(t, err)
+
+	// Provisioner-admins can modify the rest of the properties, the order
+	// of the restricted ones does not matter.
+	r, err := a.UpdateProvisioner(provisionerAdmin, "acme-admin", 1, []byte(`{"options":{"x509":{"template":"{}"}},"claims":{"maxTLSCertDuration":"24h"},"type":"ACME","name":"acme-admin","requireEAB":true}`))
+	assert.FatalError(t, err)
+	assert.Equals(t, 2, r.Version)
+
+	tests := []struct {
+		name  string
+		admin *Admin
+		data  string
+		msg   string
+	}{
+		{"type", provisionerAdmin, `{"type":"JWK","name":"acme-admin","claims":{"maxTLSCertDuration":"24h"},"options":{"x509":{"template":"{}"}}}`, "only a super-admin can modify the type of a provisioner"},
+		{"claims", provisionerAdmin, `{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"8760h"},"options":{"x509":{"template":"{}"}}}`, "only a super-admin can modify the claims of a provisioner"},
+		{"no-claims", provisionerAdmin, `{"type":"ACME","name":"acme-admin","options":{"x509":{"template":"{}"}}}`, "only a super-admin can modify the claims of a provisioner"},
+		{"templates", provisionerAdmin, `{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"24h"},"options":{"x509":{"templateFile":"/etc/passwd"}}}`, "only a super-admin can modify the options of a provisioner"},
+		{"webhooks", provisionerAdmin, `{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"24h"},"options":{"x509":{"template":"{}"},"webhooks":[{"name":"hook","url":"https://example.com"}]}}`, "only a super-admin can modify the options of a provisioner"},
+		{"nil-admin", nil, `{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"8760h"},"options":{"x509":{"template":"{}"}}}`, "only a super-admin can modify the claims of a provisioner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.UpdateProvisioner(tt.admin, "acme-admin", 2, []byte(tt.data))
+			if assert.Error(t, err) {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusForbidden, sc.StatusCode())
+				assert.Equals(t, tt.msg, err.Error())
+			}
+		})
+	}
+
+	// Super-admins can modify all of them.
+	r, err = a.UpdateProvisioner(superAdmin, "acme-admin", 2, []byte(`{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"8760h"}}`))
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, r.Version)
+}
+
+type mockCloserProvisioner struct {
+	*provisioner.MockProvisioner
+	closed int
+}
+
+func (p *mockCloserProvisioner) Close() error {
+	p.closed++
+	return nil
+}
+
+func TestAuthority_closeProvisioners(t *testing.T) {
+	mockDB := &db.MockAuthDB{
+		MGetProvisioners: func() ([][]byte, error) {
+			return nil, nil
+		},
+		MCmpAndSwapProvisioner: func(name string, oldValue, newValue []byte) (bool, error) {
+			return true, nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	deleted := &mockCloserProvisioner{MockProvisioner: &provisioner.MockProvisioner{}}
+	stored := &mockCloserProvisioner{MockProvisioner: &provisioner.MockProvisioner{}}
+	a.storedProvisioners = map[string]*storedProvisioner{
+		"deleted": {record: &ProvisionerRecord{Name: "deleted", Version: 1}, value: []byte("deleted"), provisioner: deleted},
+		"stored":  {record: &ProvisionerRecord{Name: "stored", Version: 1}, value: []byte("stored"), provisioner: stored},
+	}
+
+	// Removed provisioners are closed
+	assert.FatalError(t, a.DeleteProvisioner("deleted", 1))
+	assert.Equals(t, 1, deleted.closed)
+	assert.Equals(t, 0, stored.closed)
+
+	// The rest are closed once when the authority is stopped
+	a.StopProvisioners()
+	a.StopProvisioners()
+	assert.Equals(t, 1, deleted.closed)
+	assert.Equals(t, 1, stored.closed)
+}
+
+func TestAuthority_SetProvisionerSwitches(t *testing.T) {
+	stored := map[string][]byte{}
+	mockDB := &db.MockAuthDB{
+		MGetProvisioners: func() ([][]byte, error) {
+			return nil, nil
+		},
+		MCmpAndSwapProvisioner: func(name string, oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(stored[name], oldValue) {
+				return false, nil
+			}
+			stored[name] = newValue
+			return true, nil
+		},
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+	_, err := a.CreateProvisioner([]byte(`{"type":"ACME","name":"acme-admin","claims":{"maxTLSCertDuration":"48h"}}`))
+	assert.FatalError(t, err)
+
+	assertClaims := func(r *ProvisionerRecord, want string) {
+		var v struct {
+			Claims json.RawMessage `json:"claims"`
+		}
+		assert.FatalError(t, json.Unmarshal(r.Provisioner, &v))
+		assert.Equals(t, want, string(v.Claims))
+	}
+	yes, no := true, false
+
+	_, err = a.SetProvisionerSwitches("acme-admin", 2, ProvisionerSwitches{DisableIssuance: &yes})
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "provisioner acme-admin version 2 does not match the current version 1")
+	}
+	_, err = a.SetProvisionerSwitches("Max", 1, ProvisionerSwitches{DisableIssuance: &yes})
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "provisioner Max is defined in the configuration and cannot be modified")
+	}
+
+	r, err := a.SetProvisionerSwitches("acme-admin", 1, ProvisionerSwitches{DisableIssuance: &yes})
+	assert.FatalError(t, err)
+	assert.Equals(t, 2, r.Version)
+	assertClaims(r, `{"disableIssuance":true,"maxTLSCertDuration":"48h"}`)
+	p, err := a.LoadProvisionerByID("acme/acme-admin")
+	assert.FatalError(t, err)
+	_, err = p.AuthorizeSign(context.Background(), "")
+	if assert.NotNil(t, err) {
+		assert.HasPrefix(t, err.Error(), "acme.AuthorizeSign; issuance is disabled for acme provisioner acme/acme-admin")
+	}
+
+	r, err = a.SetProvisionerSwitches("acme-admin", 2, ProvisionerSwitches{DisableIssuance: &no, DisableRenewal: &yes})
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, r.Version)
+	assertClaims(r, `{"disableIssuance":false,"disableRenewal":true,"maxTLSCertDuration":"48h"}`)
+	p, err = a.LoadProvisionerByID("acme/acme-admin")
+	assert.FatalError(t, err)
+	_, err = p.AuthorizeSign(context.Background(), "")
+	assert.FatalError(t, err)
+}
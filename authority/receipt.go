@@ -0,0 +1,152 @@
+package authority
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/jose"
+	"gopkg.in/square/go-jose.v2/cryptosigner"
+)
+
+// receiptType is the JWS type of the issuance receipts.
+const receiptType = "step-receipt+jwt"
+
+// ReceiptProvisioner identifies the provisioner that authorized the
+// certificate in an issuance receipt.
+type ReceiptProvisioner struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+// ReceiptClaims are the claims of an issuance receipt. The subject is the
+// serial number of the certificate and CertificateHash the base64url encoded
+// SHA-256 hash of the DER certificate. Authorization contains the claims of the
+// token that authorized the certificate, if there was one.
+type ReceiptClaims struct {
+	jose.Claims
+	CertificateHash string                 `json:"certificateHash"`
+	Provisioner     *ReceiptProvisioner    `json:"provisioner,omitempty"`
+	Authorization   map[string]interface{} `json:"authorization,omitempty"`
+}
+
+// CreateReceipt returns the issuance receipt of the given certificate, a JWS
+// signed by the intermediate that issued the certificate with it in the x5c
+// header. The token is the one used to authorize the certificate, it can be
+// empty, e.g. on renewals. It returns an empty receipt if the receipts are not
+// enabled.
+func (a *Authority) CreateReceipt(cert *x509.Certificate, token string) (string, error) {
+	if !a.config.AuthorityConfig.EnableReceipts {
+		return "", nil
+	}
+	opts := []interface{}{errs.WithKeyVal("serialNumber", cert.SerialNumber.String())}
+
+	issuer, signer, ok := a.getX509IssuerByKeyID(cert.AuthorityKeyId)
+	if !ok {
+		return "", errs.InternalServer("authority.CreateReceipt: issuer not found", opts...)
+	}
+
+	now := time.Now().UTC()
+	sum := sha256.Sum256(cert.Raw)
+	claims := ReceiptClaims{
+		Claims: jose.Claims{
+			Issuer:   issuer.Subject.CommonName,
+			Subject:  cert.SerialNumber.String(),
+			IssuedAt: jose.NewNumericDate(now),
+		},
+		CertificateHash: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	if name, ok := provisioner.GetProvisionerName(cert); ok {
+		claims.Provisioner = &ReceiptProvisioner{Name: name}
+		if p, ok := a.provisioners.LoadByCertificate(cert); ok {
+			claims.Provisioner.ID = p.GetID()
+			claims.Provisioner.Type = p.GetType().String()
+		}
+	}
+	if token != "" {
+		tok, err := jose.ParseSigned(token)
+		if err != nil {
+			return "", errs.Wrap(http.StatusBadRequest, err, "authority.CreateReceipt; error parsing token", opts...)
+		}
+		// The token has been already verified by the authorization.
+		if err := tok.UnsafeClaimsWithoutVerification(&claims.Authorization); err != nil {
+			return "", errs.Wrap(http.StatusBadRequest, err, "authority.CreateReceipt; error parsing token", opts...)
+		}
+	}
+
+	opaque := cryptosigner.Opaque(signer)
+	algs := opaque.Algs()
+	if len(algs) == 0 {
+		return "", errs.InternalServer("authority.CreateReceipt: unsupported issuer key", opts...)
+	}
+	so := new(jose.SignerOptions)
+	so.WithType(receiptType)
+	so.WithHeader("x5c", []string{base64.StdEncoding.EncodeToString(issuer.Raw)})
+	s, err := jose.NewSigner(jose.SigningKey{Algorithm: algs[0], Key: opaque}, so)
+	if err != nil {
+		return "", errs.Wrap(http.StatusInternalServerError, err, "authority.CreateReceipt", opts...)
+	}
+	receipt, err := jose.Signed(s).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", errs.Wrap(http.StatusInternalServerError, err, "authority.CreateReceipt", opts...)
+	}
+	return receipt, nil
+}
+
+// VerifyReceipt verifies the issuance receipt of the given certificate and
+// returns its claims. The intermediate in the receipt must be valid for the
+// given roots at the time the receipt was issued, and it must have signed the
+// certificate. It does not require access to the authority.
+func VerifyReceipt(receipt string, cert *x509.Certificate, roots *x509.CertPool) (*ReceiptClaims, error) {
+	tok, err := jose.ParseSigned(receipt)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing receipt")
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("error parsing receipt: unexpected number of signatures")
+	}
+	if typ, _ := tok.Headers[0].ExtraHeaders["typ"].(string); !strings.EqualFold(typ, receiptType) {
+		return nil, errors.Errorf("error parsing receipt: unexpected type %s", typ)
+	}
+
+	// Get the time of the receipt before verifying it, so receipts signed by
+	// expired intermediates can be verified.
+	var claims ReceiptClaims
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return nil, errors.Wrap(err, "error parsing receipt")
+	}
+	if claims.IssuedAt == nil {
+		return nil, errors.New("error verifying receipt: iat is missing")
+	}
+	chains, err := tok.Headers[0].Certificates(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: claims.IssuedAt.Time(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error verifying receipt x5c")
+	}
+	issuer := chains[0][0]
+	if err := tok.Claims(issuer.PublicKey, &claims); err != nil {
+		return nil, errors.Wrap(err, "error verifying receipt")
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	switch {
+	case claims.CertificateHash != base64.RawURLEncoding.EncodeToString(sum[:]):
+		return nil, errors.New("error verifying receipt: certificate hash does not match")
+	case claims.Subject != cert.SerialNumber.String():
+		return nil, errors.New("error verifying receipt: serial number does not match")
+	}
+	if err := cert.CheckSignatureFrom(issuer); err != nil {
+		return nil, errors.Wrap(err, "error verifying receipt: certificate was not signed by the receipt issuer")
+	}
+	return &claims, nil
+}
@@ -0,0 +1,73 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestAuthority_CreateReceipt(t *testing.T) {
+	a := testAuthority(t)
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	extraOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+	other, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+
+	// Disabled by default
+	receipt, err := a.CreateReceipt(certChain[0], token)
+	assert.FatalError(t, err)
+	assert.Equals(t, "", receipt)
+
+	a.config.AuthorityConfig.EnableReceipts = true
+	receipt, err = a.CreateReceipt(certChain[0], token)
+	assert.FatalError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(a.rootX509Certs[0])
+	claims, err := VerifyReceipt(receipt, certChain[0], roots)
+	assert.FatalError(t, err)
+	assert.Equals(t, certChain[0].SerialNumber.String(), claims.Subject)
+	assert.Equals(t, &ReceiptProvisioner{ID: "step-cli:4UELJx8e0aS9m0CH3fZ0EB7D5aUPICb759zALHFejvc", Name: "step-cli", Type: "JWK"}, claims.Provisioner)
+	assert.Equals(t, "smallstep test", claims.Authorization["sub"])
+	assert.Equals(t, "step-cli", claims.Authorization["iss"])
+
+	// Renewals do not have a token
+	receipt2, err := a.CreateReceipt(other[0], "")
+	assert.FatalError(t, err)
+	claims, err = VerifyReceipt(receipt2, other[0], roots)
+	assert.FatalError(t, err)
+	assert.Nil(t, claims.Provisioner)
+	assert.Nil(t, claims.Authorization)
+
+	// Verification errors
+	_, err = VerifyReceipt(receipt, other[0], roots)
+	assert.Error(t, err)
+	_, err = VerifyReceipt(receipt, certChain[0], x509.NewCertPool())
+	assert.Error(t, err)
+	_, err = VerifyReceipt(token, certChain[0], roots)
+	assert.Error(t, err)
+	_, err = VerifyReceipt("foo", certChain[0], roots)
+	assert.Error(t, err)
+
+	// Token errors
+	_, err = a.CreateReceipt(certChain[0], "foo")
+	assertStatusCode(t, err, 400)
+
+	// Unknown issuer
+	_, err = a.CreateReceipt(&x509.Certificate{SerialNumber: certChain[0].SerialNumber}, token)
+	assertStatusCode(t, err, 500)
+}
@@ -0,0 +1,224 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/randutil"
+	"golang.org/x/crypto/ocsp"
+)
+
+// RevocationCriteria selects the X.509 certificates revoked in a batch. All
+// the criteria set must match: Provisioner is the name of the provisioner that
+// authorized the certificate, SAN a pattern like *.old.example.com matched
+// against the DNS names, email addresses, IP addresses and URIs of the
+// certificate, and IssuedAfter and IssuedBefore limit the notBefore of the
+// certificate.
+type RevocationCriteria struct {
+	Provisioner  string    `json:"provisioner,omitempty"`
+	SAN          string    `json:"san,omitempty"`
+	IssuedAfter  time.Time `json:"issuedAfter,omitempty"`
+	IssuedBefore time.Time `json:"issuedBefore,omitempty"`
+}
+
+// Validate checks that at least one criterion is set and that they are valid.
+func (c *RevocationCriteria) Validate() error {
+	switch {
+	case c.Provisioner == "" && c.SAN == "" && c.IssuedAfter.IsZero() && c.IssuedBefore.IsZero():
+		return errs.BadRequest("revocation criteria cannot be empty")
+	case !c.IssuedAfter.IsZero() && !c.IssuedBefore.IsZero() && !c.IssuedAfter.Before(c.IssuedBefore):
+		return errs.BadRequest("issuedAfter must be before issuedBefore")
+	}
+	if c.SAN != "" {
+		if _, err := path.Match(c.SAN, ""); err != nil {
+			return errs.BadRequest("san %s is not a valid pattern", c.SAN)
+		}
+	}
+	return nil
+}
+
+// Match returns true if the certificate matches all the criteria.
+func (c *RevocationCriteria) Match(cert *x509.Certificate) bool {
+	if c.Provisioner != "" {
+		if name, ok := provisioner.GetProvisionerName(cert); !ok || name != c.Provisioner {
+			return false
+		}
+	}
+	if !c.IssuedAfter.IsZero() && cert.NotBefore.Before(c.IssuedAfter) {
+		return false
+	}
+	if !c.IssuedBefore.IsZero() && !cert.NotBefore.Before(c.IssuedBefore) {
+		return false
+	}
+	if c.SAN != "" {
+		return c.matchSAN(cert)
+	}
+	return true
+}
+
+func (c *RevocationCriteria) matchSAN(cert *x509.Certificate) bool {
+	for _, s := range certificateSANs(cert) {
+		if matchName(c.SAN, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeBatchOptions are the options of a batch revocation. If DryRun is set
+// the matching certificates are returned but not revoked. Admin identifies the
+// administrator that requested the revocation.
+type RevokeBatchOptions struct {
+	Criteria   RevocationCriteria
+	ReasonCode int
+	Reason     string
+	DryRun     bool
+	Admin      string
+}
+
+// RevocationBatch is the audit record of a batch revocation with the serial
+// numbers of the certificates revoked.
+type RevocationBatch struct {
+	ID         string             `json:"id,omitempty"`
+	Admin      string             `json:"admin"`
+	Criteria   RevocationCriteria `json:"criteria"`
+	ReasonCode int                `json:"reasonCode"`
+	Reason     string             `json:"reason,omitempty"`
+	DryRun     bool               `json:"dryRun,omitempty"`
+	Serials    []string           `json:"serials"`
+	CreatedAt  time.Time          `json:"createdAt"`
+}
+
+// RevokeBatch revokes all the X.509 certificates in the database that match
+// the criteria and are not expired or already revoked. The certificates and
+// the audit record of the batch are stored in a single transaction. A dry run
+// returns the certificates that would be revoked and it is not recorded.
+func (a *Authority) RevokeBatch(opts *RevokeBatchOptions) (*RevocationBatch, error) {
+	if err := opts.Criteria.Validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.ReasonCode < ocsp.Unspecified || opts.ReasonCode > ocsp.AACompromise:
+		return nil, errs.BadRequest("authority.RevokeBatch: reasonCode out of bounds")
+	case opts.ReasonCode == 7 || opts.ReasonCode == ocsp.RemoveFromCRL:
+		return nil, errs.BadRequest("authority.RevokeBatch: reasonCode %d is not a valid reason", opts.ReasonCode)
+	}
+
+	certs, err := a.db.GetCertificates()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.RevokeBatch: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RevokeBatch")
+	}
+
+	now := time.Now().UTC()
+	batch := &RevocationBatch{
+		Admin:      opts.Admin,
+		Criteria:   opts.Criteria,
+		ReasonCode: opts.ReasonCode,
+		Reason:     opts.Reason,
+		DryRun:     opts.DryRun,
+		Serials:    []string{},
+		CreatedAt:  now,
+	}
+	var rcis []*db.RevokedCertificateInfo
+	for _, cert := range certs {
+		if now.After(cert.NotAfter) || !opts.Criteria.Match(cert) {
+			continue
+		}
+		sn := cert.SerialNumber.String()
+		revoked, err := a.db.IsRevoked(sn)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RevokeBatch")
+		}
+		if revoked {
+			continue
+		}
+		batch.Serials = append(batch.Serials, sn)
+		rcis = append(rcis, &db.RevokedCertificateInfo{
+			Serial:     sn,
+			ReasonCode: opts.ReasonCode,
+			Reason:     opts.Reason,
+			RevokedAt:  now,
+		})
+	}
+	sort.Strings(batch.Serials)
+	if opts.DryRun {
+		return batch, nil
+	}
+
+	if batch.ID, err = randutil.Hex(32); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RevokeBatch")
+	}
+	for _, rci := range rcis {
+		rci.BatchID = batch.ID
+	}
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RevokeBatch: error marshaling revocation batch")
+	}
+	if err := a.db.RevokeBatch(batch.ID, b, rcis); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RevokeBatch")
+	}
+	if len(rcis) > 0 && a.config.CRL != nil {
+		// Errors are ignored, the CRL is generated again on the next
+		// refresh.
+		a.GenerateCRL()
+	}
+	return batch, nil
+}
+
+// GetRevocationBatch returns the audit record of the revocation batch with the
+// given id.
+func (a *Authority) GetRevocationBatch(id string) (*RevocationBatch, error) {
+	b, err := a.db.GetRevocationBatch(id)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetRevocationBatch: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetRevocationBatch")
+	case b == nil:
+		return nil, errs.NotFound("authority.GetRevocationBatch: revocation batch %s was not found", id)
+	}
+	return unmarshalRevocationBatch(b)
+}
+
+// GetRevocationBatches returns the audit records of all the revocation
+// batches sorted by creation time.
+func (a *Authority) GetRevocationBatches() ([]*RevocationBatch, error) {
+	list, err := a.db.GetRevocationBatches()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetRevocationBatches: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetRevocationBatches")
+	}
+	batches := []*RevocationBatch{}
+	for _, b := range list {
+		batch, err := unmarshalRevocationBatch(b)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.Before(batches[j].CreatedAt)
+	})
+	return batches, nil
+}
+
+func unmarshalRevocationBatch(b []byte) (*RevocationBatch, error) {
+	batch := new(RevocationBatch)
+	if err := json.Unmarshal(b, batch); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling revocation batch")
+	}
+	return batch, nil
+}
@@ -0,0 +1,211 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestRevocationCriteria_Validate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		c       *RevocationCriteria
+		wantErr bool
+	}{
+		{"ok/provisioner", &RevocationCriteria{Provisioner: "acme"}, false},
+		{"ok/san", &RevocationCriteria{SAN: "*.old.example.com"}, false},
+		{"ok/time", &RevocationCriteria{IssuedAfter: now.Add(-time.Hour), IssuedBefore: now}, false},
+		{"fail/empty", &RevocationCriteria{}, true},
+		{"fail/time", &RevocationCriteria{IssuedAfter: now, IssuedBefore: now}, true},
+		{"fail/san", &RevocationCriteria{SAN: "[*.example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("RevocationCriteria.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevocationCriteria_Match(t *testing.T) {
+	now := time.Now()
+	cert := &x509.Certificate{
+		NotBefore:   now.Add(-time.Hour),
+		DNSNames:    []string{"foo.example.com", "www.OLD.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	tests := []struct {
+		name string
+		c    *RevocationCriteria
+		want bool
+	}{
+		{"san/dns", &RevocationCriteria{SAN: "*.old.example.com"}, true},
+		{"san/ip", &RevocationCriteria{SAN: "10.0.0.*"}, true},
+		{"san/no-match", &RevocationCriteria{SAN: "*.new.example.com"}, false},
+		{"time/match", &RevocationCriteria{IssuedAfter: now.Add(-2 * time.Hour), IssuedBefore: now}, true},
+		{"time/after", &RevocationCriteria{IssuedAfter: now.Add(-30 * time.Minute)}, false},
+		{"time/before", &RevocationCriteria{IssuedBefore: now.Add(-time.Hour)}, false},
+		{"provisioner/missing", &RevocationCriteria{Provisioner: "acme"}, false},
+		{"all", &RevocationCriteria{SAN: "*.example.com", IssuedBefore: now}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equals(t, tt.want, tt.c.Match(cert))
+		})
+	}
+}
+
+func TestAuthority_RevokeBatch(t *testing.T) {
+	a := testAuthority(t)
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	extraOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+
+	// The signed certificate, a revoked one, an expired one, and one with a
+	// different name.
+	now := time.Now()
+	certs := []*x509.Certificate{
+		certChain[0],
+		{SerialNumber: big.NewInt(2), DNSNames: []string{"test.smallstep.com"}, NotBefore: now, NotAfter: now.Add(time.Hour)},
+		{SerialNumber: big.NewInt(3), DNSNames: []string{"test.smallstep.com"}, NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)},
+		{SerialNumber: big.NewInt(4), DNSNames: []string{"other.smallstep.com"}, NotBefore: now, NotAfter: now.Add(time.Hour)},
+	}
+	var batchID string
+	var batchRecord []byte
+	var revoked []*db.RevokedCertificateInfo
+	a.db = &db.MockAuthDB{
+		MGetCertificates: func() ([]*x509.Certificate, error) {
+			return certs, nil
+		},
+		MIsRevoked: func(sn string) (bool, error) {
+			return sn == "2", nil
+		},
+		MRevokeBatch: func(id string, batch []byte, rcis []*db.RevokedCertificateInfo) error {
+			batchID, batchRecord, revoked = id, batch, rcis
+			return nil
+		},
+	}
+	sn := certChain[0].SerialNumber.String()
+
+	t.Run("ok/dry-run", func(t *testing.T) {
+		revoked = nil
+		batch, err := a.RevokeBatch(&RevokeBatchOptions{
+			Criteria: RevocationCriteria{SAN: "test.smallstep.com"},
+			DryRun:   true,
+		})
+		assert.FatalError(t, err)
+		assert.Equals(t, "", batch.ID)
+		assert.True(t, batch.DryRun)
+		assert.Equals(t, []string{sn}, batch.Serials)
+		assert.Nil(t, revoked)
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		batch, err := a.RevokeBatch(&RevokeBatchOptions{
+			Criteria:   RevocationCriteria{Provisioner: "step-cli", SAN: "*.smallstep.com"},
+			ReasonCode: 4,
+			Reason:     "superseded",
+			Admin:      "admin@example.com",
+		})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{sn}, batch.Serials)
+		assert.Equals(t, batchID, batch.ID)
+		assert.Len(t, 1, revoked)
+		assert.Equals(t, sn, revoked[0].Serial)
+		assert.Equals(t, 4, revoked[0].ReasonCode)
+		assert.Equals(t, batch.ID, revoked[0].BatchID)
+
+		var record RevocationBatch
+		assert.FatalError(t, json.Unmarshal(batchRecord, &record))
+		assert.Equals(t, "admin@example.com", record.Admin)
+		assert.Equals(t, []string{sn}, record.Serials)
+		assert.Equals(t, "step-cli", record.Criteria.Provisioner)
+	})
+
+	t.Run("fail/criteria", func(t *testing.T) {
+		_, err := a.RevokeBatch(&RevokeBatchOptions{})
+		assertStatusCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("fail/reasonCode", func(t *testing.T) {
+		_, err := a.RevokeBatch(&RevokeBatchOptions{Criteria: RevocationCriteria{Provisioner: "step-cli"}, ReasonCode: 8})
+		assertStatusCode(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("fail/nil-db", func(t *testing.T) {
+		_, err := testAuthority(t).RevokeBatch(&RevokeBatchOptions{Criteria: RevocationCriteria{Provisioner: "step-cli"}})
+		assertStatusCode(t, err, http.StatusNotImplemented)
+	})
+
+	t.Run("fail/db", func(t *testing.T) {
+		_a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+			MGetCertificates: func() ([]*x509.Certificate, error) {
+				return certs, nil
+			},
+			MIsRevoked: func(sn string) (bool, error) {
+				return false, nil
+			},
+			MRevokeBatch: func(id string, batch []byte, rcis []*db.RevokedCertificateInfo) error {
+				return errors.New("force")
+			},
+		}))
+		_, err := _a.RevokeBatch(&RevokeBatchOptions{Criteria: RevocationCriteria{Provisioner: "step-cli"}})
+		assertStatusCode(t, err, http.StatusInternalServerError)
+	})
+}
+
+func TestAuthority_GetRevocationBatches(t *testing.T) {
+	now := time.Now().UTC()
+	b1, err := json.Marshal(&RevocationBatch{ID: "foo", CreatedAt: now})
+	assert.FatalError(t, err)
+	b2, err := json.Marshal(&RevocationBatch{ID: "bar", CreatedAt: now.Add(-time.Hour)})
+	assert.FatalError(t, err)
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetRevocationBatch: func(id string) ([]byte, error) {
+			if id == "foo" {
+				return b1, nil
+			}
+			return nil, nil
+		},
+		MGetRevocationBatches: func() ([][]byte, error) {
+			return [][]byte{b1, b2}, nil
+		},
+	}))
+
+	batch, err := a.GetRevocationBatch("foo")
+	assert.FatalError(t, err)
+	assert.Equals(t, "foo", batch.ID)
+	_, err = a.GetRevocationBatch("zap")
+	assertStatusCode(t, err, http.StatusNotFound)
+
+	batches, err := a.GetRevocationBatches()
+	assert.FatalError(t, err)
+	if assert.Len(t, 2, batches) {
+		assert.Equals(t, "bar", batches[0].ID)
+		assert.Equals(t, "foo", batches[1].ID)
+	}
+
+	_, err = testAuthority(t).GetRevocationBatches()
+	assertStatusCode(t, err, http.StatusNotImplemented)
+}
@@ -1,11 +1,16 @@
 package authority
 
 import (
+	"crypto"
 	"crypto/x509"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/errs"
 )
 
+var defaultIntermediateValidity = 10 * 365 * 24 * time.Hour
+
 // Root returns the certificate corresponding to the given SHA sum argument.
 func (a *Authority) Root(sum string) (*x509.Certificate, error) {
 	val, ok := a.certificates.Load(sum)
@@ -36,13 +41,35 @@ func (a *Authority) GetRootCertificates() []*x509.Certificate {
 	return a.rootX509Certs
 }
 
-// GetRoots returns all the root certificates for this CA.
+// GetIntermediate returns the intermediate certificate and the signer used to
+// sign X.509 certificates. It is used by the enrollment protocols, like SCEP,
+// that need to sign or decrypt messages with the CA key.
+func (a *Authority) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	a.x509IssuerMutex.RLock()
+	defer a.x509IssuerMutex.RUnlock()
+	return a.x509Issuer, a.x509Signer
+}
+
+// GetRoots returns all the root certificates for this CA. During a rotation
+// of the root it includes the new root, and it excludes the old one once it
+// is retired.
 // This method implements the Authority interface.
 func (a *Authority) GetRoots() ([]*x509.Certificate, error) {
-	return a.rootX509Certs, nil
+	r := a.getRootRotation()
+	if r == nil {
+		return a.rootX509Certs, nil
+	}
+	roots := make([]*x509.Certificate, 0, len(a.rootX509Certs)+1)
+	for _, crt := range a.rootX509Certs {
+		if !a.isRetiredRoot(crt) {
+			roots = append(roots, crt)
+		}
+	}
+	return append(roots, r.root), nil
 }
 
-// GetFederation returns all the root certificates in the federation.
+// GetFederation returns all the root certificates in the federation,
+// including the roots fetched from the remote authorities.
 // This method implements the Authority interface.
 func (a *Authority) GetFederation() (federation []*x509.Certificate, err error) {
 	a.certificates.Range(func(k, v interface{}) bool {
@@ -52,8 +79,54 @@ func (a *Authority) GetFederation() (federation []*x509.Certificate, err error)
 			err = errs.InternalServer("stored value is not a *x509.Certificate")
 			return false
 		}
-		federation = append(federation, crt)
+		if !a.isRetiredRoot(crt) {
+			federation = append(federation, crt)
+		}
 		return true
 	})
+	if err != nil {
+		return
+	}
+	for _, crt := range a.getFederatedAuthorityRoots() {
+		if _, ok := a.certificates.Load(fingerprint(crt)); !ok {
+			federation = append(federation, crt)
+		}
+	}
 	return
 }
+
+// SignIntermediate signs the certificate signing request of a new intermediate
+// with the root, it does not require a running authority so it can be used
+// with offline signers, e.g. a root key split in shares. The validity is
+// limited by the validity of the root.
+func SignIntermediate(root *x509.Certificate, rootSigner crypto.Signer, csr *x509.CertificateRequest, validity time.Duration) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.Wrap(err, "error validating certificate request")
+	}
+	if !publicKeyEqual(rootSigner.Public(), root.PublicKey) {
+		return nil, errors.New("root key does not match the root certificate")
+	}
+	if validity <= 0 {
+		validity = defaultIntermediateValidity
+	}
+	skid, err := subjectKeyID(csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	tmpl := &x509.Certificate{
+		Subject:               csr.Subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          skid,
+	}
+	if tmpl.NotAfter.After(root.NotAfter) {
+		tmpl.NotAfter = root.NotAfter
+	}
+	return signCertificate(tmpl, root, csr.PublicKey, rootSigner)
+}
@@ -0,0 +1,457 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+var defaultRootRotationValidity = 10 * 365 * 24 * time.Hour
+
+// RootRotationStatus is the status of a rotation of the root certificate.
+type RootRotationStatus string
+
+const (
+	// RootRotationScheduled is the status of a rotation before the cutover
+	// time, both roots are published but certificates chain to the old one.
+	RootRotationScheduled RootRotationStatus = "scheduled"
+	// RootRotationCutover is the status of a rotation after the cutover time,
+	// both roots are published and certificates chain to the new one.
+	RootRotationCutover RootRotationStatus = "cutover"
+	// RootRotationRetired is the status of a rotation after the retire time,
+	// the old root is not published anymore.
+	RootRotationRetired RootRotationStatus = "retired"
+	// RootRotationCancelled is the status of a cancelled rotation.
+	RootRotationCancelled RootRotationStatus = "cancelled"
+)
+
+// RootRotationOptions are the options used to start a rotation of the root
+// certificate.
+//
+// Key is the name of the new root key in the KMS, with the default KMS it is
+// the file where the key is written encrypted with the password of the CA.
+// RootKey is the optional key of the current root, if set, the new root is
+// cross-signed with it. The current intermediate is always cross-signed with
+// the new root.
+type RootRotationOptions struct {
+	Key        string
+	CommonName string
+	Validity   time.Duration
+	RootKey    string
+	Cutover    time.Time
+	Retire     time.Time
+}
+
+// RootRotation is a rotation of the root certificate. Both roots are
+// published until the retire time, and after the cutover time the issued
+// certificates use the intermediate cross-signed by the new root.
+type RootRotation struct {
+	Key                     string    `json:"key"`
+	Root                    []byte    `json:"root"`
+	CrossSignedRoot         []byte    `json:"crossSignedRoot,omitempty"`
+	CrossSignedIntermediate []byte    `json:"crossSignedIntermediate"`
+	Cutover                 time.Time `json:"cutover"`
+	Retire                  time.Time `json:"retire,omitempty"`
+	Cancelled               bool      `json:"cancelled,omitempty"`
+	CreatedAt               time.Time `json:"createdAt"`
+	UpdatedAt               time.Time `json:"updatedAt"`
+	value                   []byte
+	root                    *x509.Certificate
+	crossSignedRoot         *x509.Certificate
+	crossSignedIntermediate *x509.Certificate
+}
+
+// Status returns the status of the rotation at the given time.
+func (r *RootRotation) Status(now time.Time) RootRotationStatus {
+	switch {
+	case r.Cancelled:
+		return RootRotationCancelled
+	case !r.Retire.IsZero() && !now.Before(r.Retire):
+		return RootRotationRetired
+	case !now.Before(r.Cutover):
+		return RootRotationCutover
+	default:
+		return RootRotationScheduled
+	}
+}
+
+// GetRoot returns the new root certificate.
+func (r *RootRotation) GetRoot() *x509.Certificate {
+	return r.root
+}
+
+// GetCrossSignedRoot returns the new root certificate signed by the old root,
+// or nil if the key of the old root was not available.
+func (r *RootRotation) GetCrossSignedRoot() *x509.Certificate {
+	return r.crossSignedRoot
+}
+
+// GetCrossSignedIntermediate returns the intermediate certificate signed by
+// the new root.
+func (r *RootRotation) GetCrossSignedIntermediate() *x509.Certificate {
+	return r.crossSignedIntermediate
+}
+
+func (r *RootRotation) parse() (err error) {
+	if r.root, err = x509.ParseCertificate(r.Root); err != nil {
+		return errors.Wrap(err, "error parsing root certificate")
+	}
+	if r.crossSignedIntermediate, err = x509.ParseCertificate(r.CrossSignedIntermediate); err != nil {
+		return errors.Wrap(err, "error parsing cross-signed intermediate certificate")
+	}
+	if len(r.CrossSignedRoot) > 0 {
+		if r.crossSignedRoot, err = x509.ParseCertificate(r.CrossSignedRoot); err != nil {
+			return errors.Wrap(err, "error parsing cross-signed root certificate")
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface and parses the
+// certificates of the rotation.
+func (r *RootRotation) UnmarshalJSON(data []byte) error {
+	type rootRotation RootRotation
+	var v rootRotation
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = RootRotation(v)
+	return r.parse()
+}
+
+func unmarshalRootRotation(b []byte) (*RootRotation, error) {
+	r := new(RootRotation)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling root rotation")
+	}
+	r.value = b
+	return r, nil
+}
+
+// initRootRotation loads the current rotation of the root from the database.
+func (a *Authority) initRootRotation() error {
+	b, err := a.db.GetRootRotation()
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return nil
+	}
+	r, err := unmarshalRootRotation(b)
+	if err != nil {
+		return err
+	}
+	if !r.Cancelled && !r.isComplete(a.rootX509Certs) {
+		a.setRootRotation(r)
+	}
+	return nil
+}
+
+// isComplete returns true if the new root has replaced the configured one.
+func (r *RootRotation) isComplete(roots []*x509.Certificate) bool {
+	return len(roots) > 0 && r.root.Equal(roots[0])
+}
+
+// setRootRotation sets the current rotation and publishes its root, a nil
+// rotation removes the root of the current one.
+func (a *Authority) setRootRotation(r *RootRotation) {
+	a.rootRotationMutex.Lock()
+	defer a.rootRotationMutex.Unlock()
+	if old := a.rootRotation; old != nil {
+		a.certificates.Delete(fingerprint(old.root))
+	}
+	if r != nil {
+		a.certificates.Store(fingerprint(r.root), r.root)
+	}
+	a.rootRotation = r
+}
+
+// getRootRotation returns the current rotation of the root or nil.
+func (a *Authority) getRootRotation() *RootRotation {
+	a.rootRotationMutex.RLock()
+	defer a.rootRotationMutex.RUnlock()
+	return a.rootRotation
+}
+
+// getX509Intermediate returns the intermediate added to the issued
+// certificates, the cross-signed intermediate after the cutover time of a
+// root rotation or the configured one.
+func (a *Authority) getX509Intermediate() *x509.Certificate {
+	if r := a.getRootRotation(); r != nil && r.Status(time.Now()) != RootRotationScheduled {
+		return r.crossSignedIntermediate
+	}
+	issuer, _ := a.GetIntermediate()
+	return issuer
+}
+
+// isRetiredRoot returns true if the given certificate is the old root of a
+// retired rotation.
+func (a *Authority) isRetiredRoot(crt *x509.Certificate) bool {
+	r := a.getRootRotation()
+	return r != nil && r.Status(time.Now()) == RootRotationRetired && crt.Equal(a.rootX509Certs[0])
+}
+
+// GetRootRotation returns the current rotation of the root certificate.
+func (a *Authority) GetRootRotation() (*RootRotation, error) {
+	r := a.getRootRotation()
+	if r == nil {
+		return nil, errs.NotFound("authority.GetRootRotation: there is no root rotation")
+	}
+	return r, nil
+}
+
+// StartRootRotation creates a new root with a key created in the KMS,
+// cross-signs the current intermediate with it, and, if the key of the
+// current root is available, cross-signs the new root with the old one.
+func (a *Authority) StartRootRotation(opts *RootRotationOptions) (*RootRotation, error) {
+	now := time.Now().UTC()
+	if opts.Cutover.IsZero() {
+		opts.Cutover = now
+	}
+	if opts.Validity == 0 {
+		opts.Validity = defaultRootRotationValidity
+	}
+	switch {
+	case opts.Key == "":
+		return nil, errs.BadRequest("authority.StartRootRotation: key cannot be empty")
+	case opts.Validity < 0:
+		return nil, errs.BadRequest("authority.StartRootRotation: validity cannot be negative")
+	case !opts.Retire.IsZero() && !opts.Retire.After(opts.Cutover):
+		return nil, errs.BadRequest("authority.StartRootRotation: retire must be after cutover")
+	case len(a.rootX509Certs) == 0:
+		return nil, errs.InternalServer("authority.StartRootRotation: root certificate is not available")
+	}
+	if _, err := os.Stat(opts.Key); err == nil {
+		return nil, errs.BadRequest("authority.StartRootRotation: key %s already exists", opts.Key)
+	}
+
+	current, err := a.db.GetRootRotation()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation")
+	}
+	var old *RootRotation
+	if current != nil {
+		if old, err = unmarshalRootRotation(current); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation")
+		}
+		if !old.Cancelled && !old.isComplete(a.rootX509Certs) {
+			return nil, errs.BadRequest("authority.StartRootRotation: a root rotation is already %s", old.Status(now))
+		}
+	}
+
+	oldRoot := a.rootX509Certs[0]
+	key, err := a.keyManager.CreateKey(&kmsapi.CreateKeyRequest{
+		Name:               opts.Key,
+		SignatureAlgorithm: kmsapi.ECDSAWithSHA256,
+	})
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation: error creating root key")
+	}
+	signer, err := a.keyManager.CreateSigner(&key.CreateSignerRequest)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation: error creating root signer")
+	}
+
+	// New root
+	subject := oldRoot.Subject
+	if opts.CommonName != "" {
+		subject.CommonName = opts.CommonName
+	}
+	skid, err := subjectKeyID(signer.Public())
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation")
+	}
+	tmpl := &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(opts.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+		SubjectKeyId:          skid,
+	}
+	root, err := signCertificate(tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation: error creating root certificate")
+	}
+
+	// Current intermediate signed by the new root.
+	issuer, _ := a.GetIntermediate()
+	tmpl = copyCertificate(issuer)
+	if tmpl.NotAfter.After(root.NotAfter) {
+		tmpl.NotAfter = root.NotAfter
+	}
+	intermediate, err := signCertificate(tmpl, root, issuer.PublicKey, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation: error cross-signing intermediate certificate")
+	}
+
+	r := &RootRotation{
+		Key:                     opts.Key,
+		Root:                    root.Raw,
+		CrossSignedIntermediate: intermediate.Raw,
+		Cutover:                 opts.Cutover.UTC(),
+		Retire:                  opts.Retire.UTC(),
+		CreatedAt:               now,
+		UpdatedAt:               now,
+		root:                    root,
+		crossSignedIntermediate: intermediate,
+	}
+
+	// New root signed by the current root.
+	if opts.RootKey != "" {
+		rootSigner, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+			SigningKey: opts.RootKey,
+			Password:   []byte(a.config.Password),
+		})
+		if err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err, "authority.StartRootRotation: error loading root key")
+		}
+		if !publicKeyEqual(rootSigner.Public(), oldRoot.PublicKey) {
+			return nil, errs.BadRequest("authority.StartRootRotation: root key does not match the root certificate")
+		}
+		tmpl = copyCertificate(root)
+		if tmpl.NotAfter.After(oldRoot.NotAfter) {
+			tmpl.NotAfter = oldRoot.NotAfter
+		}
+		cross, err := signCertificate(tmpl, oldRoot, root.PublicKey, rootSigner)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation: error cross-signing root certificate")
+		}
+		r.CrossSignedRoot, r.crossSignedRoot = cross.Raw, cross
+	}
+
+	if err := a.swapRootRotation(old, r); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation")
+	}
+
+	// Write the key once the rotation is stored, KMS like Cloud KMS do not
+	// return the private key.
+	if key.PrivateKey != nil {
+		opts := []pemutil.Options{pemutil.WithFilename(r.Key)}
+		if a.config.Password != "" {
+			opts = append(opts, pemutil.WithPassword([]byte(a.config.Password)))
+		}
+		if _, err := pemutil.Serialize(key.PrivateKey, opts...); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.StartRootRotation: error writing root key")
+		}
+	}
+
+	a.setRootRotation(r)
+	return r, nil
+}
+
+// CancelRootRotation cancels the current rotation of the root certificate, the
+// new root is not published anymore. A retired rotation cannot be cancelled.
+func (a *Authority) CancelRootRotation() (*RootRotation, error) {
+	r, err := a.GetRootRotation()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if status := r.Status(now); status == RootRotationRetired {
+		return nil, errs.BadRequest("authority.CancelRootRotation: root rotation is %s", status)
+	}
+	next := *r
+	next.Cancelled = true
+	next.UpdatedAt = now
+	if err := a.swapRootRotation(r, &next); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.CancelRootRotation")
+	}
+	a.setRootRotation(nil)
+	return &next, nil
+}
+
+// swapRootRotation stores the given rotation in the database if the current
+// value is still the one in old.
+func (a *Authority) swapRootRotation(old, r *RootRotation) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error marshaling root rotation")
+	}
+	var oldValue []byte
+	if old != nil {
+		oldValue = old.value
+	}
+	swapped, err := a.db.CmpAndSwapRootRotation(oldValue, b)
+	switch {
+	case err == db.ErrNotImplemented:
+		return errs.Wrap(http.StatusNotImplemented, err, "storing root rotations is not implemented")
+	case err != nil:
+		return errs.Wrap(http.StatusInternalServerError, err, "error storing root rotation")
+	case !swapped:
+		return errs.Errorf(http.StatusConflict, "root rotation has been modified concurrently")
+	default:
+		r.value = b
+		return nil
+	}
+}
+
+// copyCertificate returns a template with the values of the given
+// certificate and a new serial number.
+func copyCertificate(crt *x509.Certificate) *x509.Certificate {
+	tmpl := *crt
+	tmpl.SerialNumber = nil
+	tmpl.AuthorityKeyId = nil
+	tmpl.ExtraExtensions = nil
+	return &tmpl
+}
+
+// createCertificate signs the given template with a random serial number if
+// the template does not have one.
+func signCertificate(tmpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	if tmpl.SerialNumber == nil {
+		sn, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return nil, errors.Wrap(err, "error generating serial number")
+		}
+		tmpl.SerialNumber = sn
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate")
+	}
+	return x509.ParseCertificate(b)
+}
+
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling public key")
+	}
+	sum := sha1.Sum(b)
+	return sum[:], nil
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	ab, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bb, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ab, bb)
+}
+
+func fingerprint(crt *x509.Certificate) string {
+	sum := sha256.Sum256(crt.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+// rootRotationDB returns a database that stores the root rotation in memory.
+func rootRotationDB() *db.MockAuthDB {
+	var value []byte
+	return &db.MockAuthDB{
+		MIsRevoked:        func(sn string) (bool, error) { return false, nil },
+		MStoreCertificate: func(crt *x509.Certificate) error { return nil },
+		MGetRootRotation:  func() ([]byte, error) { return value, nil },
+		MCmpAndSwapRotation: func(oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(oldValue, value) {
+				return false, nil
+			}
+			value = newValue
+			return true, nil
+		},
+	}
+}
+
+func assertStatusCode(t *testing.T, err error, code int) {
+	t.Helper()
+	if assert.NotNil(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, code, sc.StatusCode())
+	}
+}
+
+func TestAuthority_StartRootRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "root-rotation")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	authDB := rootRotationDB()
+	a := testAuthority(t, WithDatabase(authDB))
+	oldRoot := a.GetRootCertificate()
+	_, err = a.GetRootRotation()
+	assertStatusCode(t, err, http.StatusNotFound)
+
+	// Scheduled rotation
+	keyFile := filepath.Join(dir, "root_ca_key")
+	r, err := a.StartRootRotation(&RootRotationOptions{
+		Key:        keyFile,
+		CommonName: "New Root CA",
+		Validity:   24 * time.Hour,
+		Cutover:    time.Now().Add(time.Hour),
+		Retire:     time.Now().Add(2 * time.Hour),
+	})
+	assert.FatalError(t, err)
+	assert.Equals(t, RootRotationScheduled, r.Status(time.Now()))
+	assert.Nil(t, r.GetCrossSignedRoot())
+
+	root := r.GetRoot()
+	assert.Equals(t, "New Root CA", root.Subject.CommonName)
+	assert.True(t, root.IsCA)
+	assert.FatalError(t, root.CheckSignatureFrom(root))
+	assert.True(t, root.NotAfter.Sub(root.NotBefore) == 24*time.Hour)
+
+	intermediate := r.GetCrossSignedIntermediate()
+	assert.FatalError(t, intermediate.CheckSignatureFrom(root))
+	assert.Equals(t, a.x509Issuer.Subject, intermediate.Subject)
+	assert.Equals(t, a.x509Issuer.SubjectKeyId, intermediate.SubjectKeyId)
+	assert.True(t, publicKeyEqual(a.x509Issuer.PublicKey, intermediate.PublicKey))
+	assert.False(t, intermediate.NotAfter.After(root.NotAfter))
+
+	// The key is encrypted with the password of the CA.
+	key, err := pemutil.Read(keyFile, pemutil.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	assert.True(t, publicKeyEqual(key.(crypto.Signer).Public(), root.PublicKey))
+
+	// Both roots are published, certificates chain to the old one.
+	roots, err := a.GetRoots()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{oldRoot, root}, roots)
+	federation, err := a.GetFederation()
+	assert.FatalError(t, err)
+	assert.Len(t, 2, federation)
+	crt, err := a.Root(fingerprint(root))
+	assert.FatalError(t, err)
+	assert.Equals(t, root, crt)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, a.x509Issuer, certChain[1])
+
+	// Only one rotation at a time.
+	_, err = a.StartRootRotation(&RootRotationOptions{Key: filepath.Join(dir, "other_key")})
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	// The rotation is loaded by a new authority.
+	b := testAuthority(t, WithDatabase(authDB))
+	loaded, err := b.GetRootRotation()
+	assert.FatalError(t, err)
+	assert.Equals(t, root.Raw, loaded.GetRoot().Raw)
+
+	// Cancel the rotation.
+	r, err = a.CancelRootRotation()
+	assert.FatalError(t, err)
+	assert.Equals(t, RootRotationCancelled, r.Status(time.Now()))
+	_, err = a.GetRootRotation()
+	assertStatusCode(t, err, http.StatusNotFound)
+	roots, err = a.GetRoots()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{oldRoot}, roots)
+	_, err = a.Root(fingerprint(root))
+	assertStatusCode(t, err, http.StatusNotFound)
+	_, err = a.CancelRootRotation()
+	assertStatusCode(t, err, http.StatusNotFound)
+
+	// Immediate cutover, certificates chain to the new root.
+	r, err = a.StartRootRotation(&RootRotationOptions{Key: filepath.Join(dir, "new_root_ca_key")})
+	assert.FatalError(t, err)
+	assert.Equals(t, RootRotationCutover, r.Status(time.Now()))
+	certChain, err = a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, r.GetCrossSignedIntermediate(), certChain[1])
+	rootPool, intermediatePool := x509.NewCertPool(), x509.NewCertPool()
+	rootPool.AddCert(r.GetRoot())
+	intermediatePool.AddCert(certChain[1])
+	_, err = certChain[0].Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediatePool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.FatalError(t, err)
+
+	// Once retired the old root is not published.
+	a.rootRotation.Retire = time.Now().Add(-time.Second)
+	assert.Equals(t, RootRotationRetired, r.Status(time.Now()))
+	roots, err = a.GetRoots()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{r.GetRoot()}, roots)
+	federation, err = a.GetFederation()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{r.GetRoot()}, federation)
+	_, err = a.CancelRootRotation()
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	// The rotation is complete once the new root is configured.
+	c := testAuthority(t, WithDatabase(authDB), WithX509RootCerts(r.GetRoot()))
+	_, err = c.GetRootRotation()
+	assertStatusCode(t, err, http.StatusNotFound)
+	_, err = c.StartRootRotation(&RootRotationOptions{Key: filepath.Join(dir, "next_root_ca_key")})
+	assert.FatalError(t, err)
+}
+
+func TestAuthority_StartRootRotation_crossSignedRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "root-rotation")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Current root with a key available to the CA.
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	signer := priv.(crypto.Signer)
+	now := time.Now()
+	oldRoot, err := signCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Old Root CA"},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "Old Root CA"}}, pub, signer)
+	assert.FatalError(t, err)
+	rootKey := filepath.Join(dir, "root_ca_key")
+	_, err = pemutil.Serialize(priv, pemutil.WithFilename(rootKey), pemutil.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+
+	a := testAuthority(t, WithDatabase(rootRotationDB()), WithX509RootCerts(oldRoot))
+
+	// The key must match the current root.
+	_, err = a.StartRootRotation(&RootRotationOptions{
+		Key:     filepath.Join(dir, "bad_key"),
+		RootKey: "testdata/secrets/intermediate_ca_key",
+	})
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	r, err := a.StartRootRotation(&RootRotationOptions{
+		Key:     filepath.Join(dir, "new_root_ca_key"),
+		RootKey: rootKey,
+	})
+	assert.FatalError(t, err)
+	assert.Equals(t, "Old Root CA", r.GetRoot().Subject.CommonName)
+	cross := r.GetCrossSignedRoot()
+	if assert.NotNil(t, cross) {
+		assert.FatalError(t, cross.CheckSignatureFrom(oldRoot))
+		assert.True(t, publicKeyEqual(r.GetRoot().PublicKey, cross.PublicKey))
+		assert.Equals(t, oldRoot.NotAfter, cross.NotAfter)
+	}
+}
+
+func TestAuthority_StartRootRotation_fail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "root-rotation")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "existing")
+	assert.FatalError(t, ioutil.WriteFile(existing, []byte("foo"), 0600))
+
+	a := testAuthority(t, WithDatabase(rootRotationDB()))
+	tests := []struct {
+		name string
+		opts *RootRotationOptions
+		code int
+	}{
+		{"fail/key", &RootRotationOptions{}, http.StatusBadRequest},
+		{"fail/key-exists", &RootRotationOptions{Key: existing}, http.StatusBadRequest},
+		{"fail/validity", &RootRotationOptions{Key: filepath.Join(dir, "key"), Validity: -time.Hour}, http.StatusBadRequest},
+		{"fail/retire", &RootRotationOptions{Key: filepath.Join(dir, "key"), Cutover: time.Now(), Retire: time.Now().Add(-time.Hour)}, http.StatusBadRequest},
+		{"fail/root-key", &RootRotationOptions{Key: filepath.Join(dir, "key"), RootKey: filepath.Join(dir, "missing")}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.StartRootRotation(tt.opts)
+			assertStatusCode(t, err, tt.code)
+		})
+	}
+
+	// Rotations require a database, the key is not written.
+	a = testAuthority(t)
+	_, err = a.StartRootRotation(&RootRotationOptions{Key: filepath.Join(dir, "key")})
+	assertStatusCode(t, err, http.StatusNotImplemented)
+	_, err = os.Stat(filepath.Join(dir, "key"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,10 +1,16 @@
 package authority
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
@@ -151,3 +157,77 @@ func TestAuthority_GetFederation(t *testing.T) {
 		})
 	}
 }
+
+func TestSignIntermediate(t *testing.T) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	root, err := signCertificate(rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "Intermediate CA"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badCSR := *csr
+	badCSR.Signature = []byte("bad")
+
+	tests := []struct {
+		name     string
+		signer   crypto.Signer
+		csr      *x509.CertificateRequest
+		validity time.Duration
+		wantErr  bool
+	}{
+		{"ok", rootKey, csr, time.Hour, false},
+		{"ok default validity", rootKey, csr, 0, false},
+		{"fail signature", rootKey, &badCSR, time.Hour, true},
+		{"fail key", otherKey, csr, time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SignIntermediate(root, tt.signer, tt.csr, tt.validity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SignIntermediate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if err := got.CheckSignatureFrom(root); err != nil {
+				t.Errorf("SignIntermediate() signature error = %v", err)
+			}
+			if !got.IsCA || !got.MaxPathLenZero || got.Subject.CommonName != "Intermediate CA" {
+				t.Errorf("SignIntermediate() = %v", got)
+			}
+			if got.NotAfter.After(root.NotAfter) {
+				t.Errorf("SignIntermediate() NotAfter = %v, want before %v", got.NotAfter, root.NotAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package authority
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+)
+
+// Serial number strategies.
+const (
+	// SerialStrategyRandom generates random serial numbers.
+	SerialStrategyRandom = "random"
+	// SerialStrategyTime generates serial numbers with the time in
+	// nanoseconds followed by random bits.
+	SerialStrategyTime = "time"
+	// SerialStrategySequential generates consecutive serial numbers using a
+	// counter in the database.
+	SerialStrategySequential = "sequential"
+)
+
+const (
+	// defaultSerialBits is the default number of random bits in the serial
+	// numbers generated by the random and time strategies.
+	defaultSerialBits = 128
+	// defaultTimeSerialBits is the default number of random bits after the
+	// time prefix.
+	defaultTimeSerialBits = 64
+	// minSerialBits is the minimum number of random bits, the CA/Browser
+	// Forum baseline requirements require at least 64 bits of entropy.
+	minSerialBits = 64
+	// maxSerialBits is the maximum number of bits of a positive serial number
+	// encoded in 20 octets.
+	maxSerialBits = 159
+	// timeSerialBits is the length of the time prefix.
+	timeSerialBits = 64
+	// maxSerialAttempts is the number of serial numbers generated before
+	// failing if all of them are already reserved.
+	maxSerialAttempts = 10
+)
+
+// SerialConfig configures the generation of the serial numbers of the X.509
+// certificates. Strategy is "random", "time" for the time in nanoseconds
+// followed by random bits, or "sequential" for a counter stored in the
+// database, Bits the number of random bits of the first two. Every serial
+// number is reserved in the database before signing the certificate, so the
+// serial numbers are unique even across replicas sharing the database.
+type SerialConfig struct {
+	Strategy string `json:"strategy"`
+	Bits     int    `json:"bits,omitempty"`
+}
+
+// Validate checks the fields in SerialConfig, nil is ok.
+func (c *SerialConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Strategy {
+	case SerialStrategyRandom:
+		if c.Bits != 0 && (c.Bits < minSerialBits || c.Bits > maxSerialBits) {
+			return errors.Errorf("serial.bits must be between %d and %d", minSerialBits, maxSerialBits)
+		}
+	case SerialStrategyTime:
+		if c.Bits != 0 && (c.Bits < minSerialBits || c.Bits > maxSerialBits-timeSerialBits) {
+			return errors.Errorf("serial.bits must be between %d and %d", minSerialBits, maxSerialBits-timeSerialBits)
+		}
+	case SerialStrategySequential:
+		if c.Bits != 0 {
+			return errors.New("serial.bits cannot be used with the sequential strategy")
+		}
+	default:
+		return errors.Errorf("serial.strategy %s is not supported", c.Strategy)
+	}
+	return nil
+}
+
+// serialGenerator generates the serial numbers of the X.509 certificates.
+type serialGenerator struct {
+	strategy string
+	bits     int
+	db       db.AuthDB
+}
+
+// initSerial initializes the serial number generator if it's configured,
+// otherwise the serial numbers are random and they are not reserved.
+func (a *Authority) initSerial() error {
+	c := a.config.Serial
+	if c == nil {
+		a.serial = nil
+		return nil
+	}
+	if c.Strategy == SerialStrategySequential && a.config.DB == nil {
+		return errors.New("serial.strategy sequential requires a database")
+	}
+	g := &serialGenerator{
+		strategy: c.Strategy,
+		bits:     c.Bits,
+		db:       a.db,
+	}
+	if g.bits == 0 {
+		switch g.strategy {
+		case SerialStrategyRandom:
+			g.bits = defaultSerialBits
+		case SerialStrategyTime:
+			g.bits = defaultTimeSerialBits
+		}
+	}
+	a.serial = g
+	return nil
+}
+
+// setSerialNumber sets the serial number of the certificate template using the
+// configured strategy, it does nothing if the strategy is not configured.
+func (a *Authority) setSerialNumber(crt *x509.Certificate) error {
+	if a.serial == nil {
+		return nil
+	}
+	sn, err := a.serial.Generate()
+	if err != nil {
+		return err
+	}
+	crt.SerialNumber = sn
+	return nil
+}
+
+// Generate returns a new serial number reserved in the database. If the
+// database does not support the reservations the serial number is returned
+// without reserving it.
+func (g *serialGenerator) Generate() (*big.Int, error) {
+	for i := 0; i < maxSerialAttempts; i++ {
+		sn, err := g.next()
+		if err != nil {
+			return nil, err
+		}
+		ok, err := g.db.ReserveSerialNumber(sn.String())
+		switch {
+		case err == db.ErrNotImplemented:
+			return sn, nil
+		case err != nil:
+			return nil, errors.Wrap(err, "error reserving serial number")
+		case ok:
+			return sn, nil
+		}
+	}
+	return nil, errors.Errorf("error generating serial number: %d serial numbers were already reserved", maxSerialAttempts)
+}
+
+func (g *serialGenerator) next() (*big.Int, error) {
+	switch g.strategy {
+	case SerialStrategyTime:
+		sn, err := randomSerial(g.bits)
+		if err != nil {
+			return nil, err
+		}
+		prefix := new(big.Int).SetUint64(uint64(time.Now().UnixNano()))
+		return sn.Or(sn, prefix.Lsh(prefix, uint(g.bits))), nil
+	case SerialStrategySequential:
+		return g.nextSequential()
+	default:
+		return randomSerial(g.bits)
+	}
+}
+
+// nextSequential increments the counter in the database, a concurrent update
+// by another replica makes it read the counter again.
+func (g *serialGenerator) nextSequential() (*big.Int, error) {
+	for {
+		old, err := g.db.GetSerialCounter()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting serial counter")
+		}
+		sn := big.NewInt(0)
+		if old != nil {
+			if _, ok := sn.SetString(string(old), 10); !ok {
+				return nil, errors.Errorf("error parsing serial counter %s", old)
+			}
+		}
+		sn.Add(sn, big.NewInt(1))
+		ok, err := g.db.CmpAndSwapSerialCounter(old, []byte(sn.String()))
+		if err != nil {
+			return nil, errors.Wrap(err, "error updating serial counter")
+		}
+		if ok {
+			return sn, nil
+		}
+	}
+}
+
+// randomSerial returns a positive random number with at most the given bits.
+func randomSerial(bits int) (*big.Int, error) {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	for {
+		sn, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, errors.Wrap(err, "error generating serial number")
+		}
+		if sn.Sign() > 0 {
+			return sn, nil
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package authority
+
+import (
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+)
+
+func TestSerialConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *SerialConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok/random", &SerialConfig{Strategy: "random"}, false},
+		{"ok/random-bits", &SerialConfig{Strategy: "random", Bits: 159}, false},
+		{"ok/time", &SerialConfig{Strategy: "time", Bits: 95}, false},
+		{"ok/sequential", &SerialConfig{Strategy: "sequential"}, false},
+		{"fail/strategy", &SerialConfig{}, true},
+		{"fail/unknown", &SerialConfig{Strategy: "foo"}, true},
+		{"fail/random-min", &SerialConfig{Strategy: "random", Bits: 63}, true},
+		{"fail/random-max", &SerialConfig{Strategy: "random", Bits: 160}, true},
+		{"fail/time-max", &SerialConfig{Strategy: "time", Bits: 96}, true},
+		{"fail/sequential-bits", &SerialConfig{Strategy: "sequential", Bits: 64}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("SerialConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthority_initSerial(t *testing.T) {
+	a := testAuthority(t)
+	assert.FatalError(t, a.initSerial())
+	assert.Nil(t, a.serial)
+
+	a.config.Serial = &SerialConfig{Strategy: "random"}
+	assert.FatalError(t, a.initSerial())
+	assert.Equals(t, defaultSerialBits, a.serial.bits)
+
+	a.config.Serial = &SerialConfig{Strategy: "time"}
+	assert.FatalError(t, a.initSerial())
+	assert.Equals(t, defaultTimeSerialBits, a.serial.bits)
+
+	a.config.Serial = &SerialConfig{Strategy: "sequential"}
+	assert.Error(t, a.initSerial())
+	a.config.DB = &db.Config{Type: "badger", DataSource: "/tmp/db"}
+	assert.FatalError(t, a.initSerial())
+	assert.Equals(t, "sequential", a.serial.strategy)
+}
+
+func TestSerialGenerator_Generate(t *testing.T) {
+	var reserved []string
+	reserve := func(serial string) (bool, error) {
+		for _, s := range reserved {
+			if s == serial {
+				return false, nil
+			}
+		}
+		reserved = append(reserved, serial)
+		return true, nil
+	}
+
+	// Random
+	g := &serialGenerator{strategy: "random", bits: 64, db: &db.MockAuthDB{MReserveSerialNumber: reserve}}
+	for i := 0; i < 10; i++ {
+		sn, err := g.Generate()
+		assert.FatalError(t, err)
+		assert.True(t, sn.Sign() > 0)
+		assert.True(t, sn.BitLen() <= 64)
+	}
+	assert.Len(t, 10, reserved)
+
+	// Time prefix
+	start := time.Now().UnixNano()
+	g = &serialGenerator{strategy: "time", bits: 64, db: &db.MockAuthDB{MReserveSerialNumber: reserve}}
+	sn, err := g.Generate()
+	assert.FatalError(t, err)
+	prefix := new(big.Int).Rsh(sn, 64).Int64()
+	assert.True(t, prefix >= start && prefix <= time.Now().UnixNano())
+	assert.True(t, sn.BitLen() <= 128)
+
+	// Sequential, the counter is updated by another replica on the first
+	// attempt.
+	counter := []byte("41")
+	var swaps int
+	g = &serialGenerator{strategy: "sequential", db: &db.MockAuthDB{
+		MReserveSerialNumber: reserve,
+		MGetSerialCounter: func() ([]byte, error) {
+			return counter, nil
+		},
+		MCmpAndSwapSerial: func(oldValue, newValue []byte) (bool, error) {
+			swaps++
+			if swaps == 1 {
+				counter = []byte("42")
+				return false, nil
+			}
+			assert.Equals(t, counter, oldValue)
+			counter = newValue
+			return true, nil
+		},
+	}}
+	sn, err = g.Generate()
+	assert.FatalError(t, err)
+	assert.Equals(t, big.NewInt(43), sn)
+	sn, err = g.Generate()
+	assert.FatalError(t, err)
+	assert.Equals(t, big.NewInt(44), sn)
+
+	// Sequential without counter
+	g = &serialGenerator{strategy: "sequential", db: &db.MockAuthDB{}}
+	sn, err = g.Generate()
+	assert.FatalError(t, err)
+	assert.Equals(t, big.NewInt(1), sn)
+
+	// Serial numbers already reserved
+	reserved = []string{"1", "2"}
+	counter = []byte("0")
+	g = &serialGenerator{strategy: "sequential", db: &db.MockAuthDB{
+		MReserveSerialNumber: reserve,
+		MGetSerialCounter: func() ([]byte, error) {
+			return counter, nil
+		},
+		MCmpAndSwapSerial: func(oldValue, newValue []byte) (bool, error) {
+			counter = newValue
+			return true, nil
+		},
+	}}
+	sn, err = g.Generate()
+	assert.FatalError(t, err)
+	assert.Equals(t, big.NewInt(3), sn)
+
+	g = &serialGenerator{strategy: "random", bits: 64, db: &db.MockAuthDB{MReserveSerialNumber: func(string) (bool, error) {
+		return false, nil
+	}}}
+	_, err = g.Generate()
+	assert.Error(t, err)
+
+	// Databases without reservations
+	g = &serialGenerator{strategy: "random", bits: 64, db: &db.MockAuthDB{Err: db.ErrNotImplemented}}
+	_, err = g.Generate()
+	assert.FatalError(t, err)
+
+	// Errors
+	for _, m := range []*db.MockAuthDB{
+		{Err: errors.New("force")},
+		{MGetSerialCounter: func() ([]byte, error) { return nil, errors.New("force") }},
+		{MGetSerialCounter: func() ([]byte, error) { return []byte("foo"), nil }},
+		{MCmpAndSwapSerial: func(oldValue, newValue []byte) (bool, error) { return false, errors.New("force") }},
+	} {
+		g = &serialGenerator{strategy: "sequential", db: m}
+		_, err = g.Generate()
+		assert.Error(t, err)
+	}
+}
+
+func TestAuthority_Sign_serial(t *testing.T) {
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	var counter []byte
+	var reserved []string
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MIsRevoked: func(string) (bool, error) {
+			return false, nil
+		},
+		MReserveSerialNumber: func(serial string) (bool, error) {
+			reserved = append(reserved, serial)
+			return true, nil
+		},
+		MGetSerialCounter: func() ([]byte, error) {
+			return counter, nil
+		},
+		MCmpAndSwapSerial: func(oldValue, newValue []byte) (bool, error) {
+			counter = newValue
+			return true, nil
+		},
+	}))
+	a.serial = &serialGenerator{strategy: "sequential", db: a.db}
+
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, big.NewInt(1), certChain[0].SerialNumber)
+
+	certChain, err = a.Rekey(certChain[0], pub)
+	assert.FatalError(t, err)
+	assert.Equals(t, big.NewInt(2), certChain[0].SerialNumber)
+	assert.Equals(t, []string{"1", "2"}, reserved)
+
+	// Rejected requests do not consume serial numbers
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "foo"})
+	assertStatusCode(t, err, http.StatusBadRequest)
+	assert.Equals(t, []byte("2"), counter)
+
+	a.serial.db = &db.MockAuthDB{Err: errors.New("force")}
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{})
+	assertStatusCode(t, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,301 @@
+package authority
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+)
+
+var (
+	// maxShortLivedDuration is the maximum validity of the short-lived
+	// certificates, and the default one.
+	maxShortLivedDuration        = 24 * time.Hour
+	defaultShortLivedFlushPeriod = time.Minute
+	// shortLivedSummaryPeriod is the period aggregated in every summary.
+	shortLivedSummaryPeriod = time.Hour
+	// maxShortLivedAttempts is the number of compare-and-swap attempts to
+	// write a summary shared with other replicas.
+	maxShortLivedAttempts = 10
+)
+
+// ShortLivedConfig configures the provisioners that issue short-lived X.509
+// certificates, with a validity of at most MaxDuration, 24h by default and the
+// maximum allowed. These certificates are not stored in the database and
+// cannot be revoked, they are expected to expire sooner than a revocation
+// would be effective. Instead of the certificates, the database stores a
+// summary with the number of certificates issued by each provisioner every
+// hour, the counts are kept in memory and written every FlushPeriod and on
+// shutdown.
+type ShortLivedConfig struct {
+	Provisioners []string              `json:"provisioners"`
+	MaxDuration  *provisioner.Duration `json:"maxDuration,omitempty"`
+	FlushPeriod  *provisioner.Duration `json:"flushPeriod,omitempty"`
+}
+
+// Validate checks the fields in ShortLivedConfig, nil is ok.
+func (c *ShortLivedConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Provisioners) == 0 {
+		return errors.New("shortLived.provisioners cannot be empty")
+	}
+	for _, s := range c.Provisioners {
+		if s == "" {
+			return errors.New("shortLived.provisioners cannot contain an empty value")
+		}
+	}
+	switch {
+	case c.MaxDuration != nil && c.MaxDuration.Duration <= 0:
+		return errors.New("shortLived.maxDuration must be greater than 0")
+	case c.MaxDuration != nil && c.MaxDuration.Duration > maxShortLivedDuration:
+		return errors.Errorf("shortLived.maxDuration cannot be greater than %s", maxShortLivedDuration)
+	case c.FlushPeriod != nil && c.FlushPeriod.Duration <= 0:
+		return errors.New("shortLived.flushPeriod must be greater than 0")
+	}
+	return nil
+}
+
+func (c *ShortLivedConfig) maxDuration() time.Duration {
+	if c.MaxDuration == nil {
+		return maxShortLivedDuration
+	}
+	return c.MaxDuration.Duration
+}
+
+func (c *ShortLivedConfig) flushPeriod() time.Duration {
+	if c.FlushPeriod == nil {
+		return defaultShortLivedFlushPeriod
+	}
+	return c.FlushPeriod.Duration
+}
+
+// ShortLivedSummary is the audit record of the short-lived certificates issued
+// by a provisioner in the hour starting at Period.
+type ShortLivedSummary struct {
+	Provisioner string    `json:"provisioner"`
+	Period      time.Time `json:"period"`
+	Count       int64     `json:"count"`
+	FirstIssued time.Time `json:"firstIssued"`
+	LastIssued  time.Time `json:"lastIssued"`
+}
+
+func (s *ShortLivedSummary) key() string {
+	return s.Provisioner + "/" + s.Period.Format(time.RFC3339)
+}
+
+// merge adds the counts of o to the summary.
+func (s *ShortLivedSummary) merge(o *ShortLivedSummary) {
+	s.Count += o.Count
+	if s.FirstIssued.IsZero() || o.FirstIssued.Before(s.FirstIssued) {
+		s.FirstIssued = o.FirstIssued
+	}
+	if o.LastIssued.After(s.LastIssued) {
+		s.LastIssued = o.LastIssued
+	}
+}
+
+// shortLivedIssuer keeps the summaries of the short-lived certificates not yet
+// written to the database.
+type shortLivedIssuer struct {
+	provisioners map[string]bool
+	maxDuration  time.Duration
+	db           db.AuthDB
+	pending      map[string]*ShortLivedSummary
+	stop         chan struct{}
+	mutex        sync.Mutex
+}
+
+// initShortLived initializes the short-lived certificates if they are
+// configured, and starts writing the summaries periodically.
+func (a *Authority) initShortLived() {
+	c := a.config.ShortLived
+	if c == nil {
+		return
+	}
+	s := &shortLivedIssuer{
+		provisioners: make(map[string]bool, len(c.Provisioners)),
+		maxDuration:  c.maxDuration(),
+		db:           a.db,
+		pending:      make(map[string]*ShortLivedSummary),
+		stop:         make(chan struct{}),
+	}
+	for _, name := range c.Provisioners {
+		s.provisioners[name] = true
+	}
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(c.flushPeriod())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Errors are ignored, the summaries are written again on
+				// the next flush.
+				s.flush()
+			case <-stop:
+				return
+			}
+		}
+	}(s.stop)
+	a.shortLived = s
+}
+
+// enabled returns true if the provisioner with the given name issues
+// short-lived certificates.
+func (s *shortLivedIssuer) enabled(name string) bool {
+	return s != nil && s.provisioners[name]
+}
+
+// check validates that the duration of the certificate, without the backdate,
+// is not greater than the maximum duration.
+func (s *shortLivedIssuer) check(crt *x509.Certificate, backdate time.Duration) error {
+	if d := crt.NotAfter.Sub(crt.NotBefore) - backdate; d > s.maxDuration {
+		return errors.Errorf("requested duration of %s is more than the maximum of %s for short-lived certificates", d, s.maxDuration)
+	}
+	return nil
+}
+
+// record adds a certificate issued by the given provisioner to the pending
+// summaries.
+func (s *shortLivedIssuer) record(name string, now time.Time) {
+	sum := &ShortLivedSummary{
+		Provisioner: name,
+		Period:      now.Truncate(shortLivedSummaryPeriod),
+		Count:       1,
+		FirstIssued: now,
+		LastIssued:  now,
+	}
+	s.mutex.Lock()
+	s.add(sum)
+	s.mutex.Unlock()
+}
+
+func (s *shortLivedIssuer) add(sum *ShortLivedSummary) {
+	if p, ok := s.pending[sum.key()]; ok {
+		p.merge(sum)
+	} else {
+		s.pending[sum.key()] = sum
+	}
+}
+
+// flush merges the pending summaries with the ones in the database. The
+// summaries that cannot be written are kept for the next flush.
+func (s *shortLivedIssuer) flush() error {
+	s.mutex.Lock()
+	pending := s.pending
+	s.pending = make(map[string]*ShortLivedSummary)
+	s.mutex.Unlock()
+
+	var err error
+	for key, sum := range pending {
+		if err == nil {
+			if err = s.store(sum); err == nil {
+				delete(pending, key)
+			}
+		}
+	}
+	switch {
+	case err == nil:
+		return nil
+	case err == db.ErrNotImplemented:
+		// There is nowhere to write the summaries.
+		return nil
+	default:
+		s.mutex.Lock()
+		for _, sum := range pending {
+			s.add(sum)
+		}
+		s.mutex.Unlock()
+		return err
+	}
+}
+
+// store merges the summary with the one in the database using a
+// compare-and-swap, so it can be shared by multiple replicas.
+func (s *shortLivedIssuer) store(sum *ShortLivedSummary) error {
+	key := sum.key()
+	for i := 0; i < maxShortLivedAttempts; i++ {
+		old, err := s.db.GetShortLivedSummary(key)
+		if err != nil {
+			return err
+		}
+		merged := *sum
+		if old != nil {
+			stored := new(ShortLivedSummary)
+			if err := json.Unmarshal(old, stored); err != nil {
+				return errors.Wrapf(err, "error unmarshaling short-lived summary %s", key)
+			}
+			stored.merge(sum)
+			merged = *stored
+		}
+		b, err := json.Marshal(merged)
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling short-lived summary %s", key)
+		}
+		swapped, err := s.db.CmpAndSwapShortLivedSummary(key, old, b)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			return nil
+		}
+	}
+	return errors.Errorf("error storing short-lived summary %s: too many attempts", key)
+}
+
+// StopShortLived stops the periodic writes of the summaries of the
+// short-lived certificates and writes the pending ones.
+func (a *Authority) StopShortLived() error {
+	if a.shortLived == nil {
+		return nil
+	}
+	a.shortLived.mutex.Lock()
+	if a.shortLived.stop != nil {
+		close(a.shortLived.stop)
+		a.shortLived.stop = nil
+	}
+	a.shortLived.mutex.Unlock()
+	return a.shortLived.flush()
+}
+
+// isShortLived returns the name of the provisioner in the certificate and
+// true if the provisioner issues short-lived certificates.
+func (a *Authority) isShortLived(crt *x509.Certificate) (string, bool) {
+	name, ok := provisioner.GetProvisionerName(crt)
+	return name, ok && a.shortLived.enabled(name)
+}
+
+// GetShortLivedSummaries returns the audit records of the short-lived
+// certificates written to the database, sorted by period and provisioner.
+func (a *Authority) GetShortLivedSummaries() ([]*ShortLivedSummary, error) {
+	list, err := a.db.GetShortLivedSummaries()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("authority.GetShortLivedSummaries: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetShortLivedSummaries")
+	}
+	summaries := []*ShortLivedSummary{}
+	for _, b := range list {
+		sum := new(ShortLivedSummary)
+		if err := json.Unmarshal(b, sum); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetShortLivedSummaries: error unmarshaling summary")
+		}
+		summaries = append(summaries, sum)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Period.Equal(summaries[j].Period) {
+			return summaries[i].Provisioner < summaries[j].Provisioner
+		}
+		return summaries[i].Period.Before(summaries[j].Period)
+	})
+	return summaries, nil
+}
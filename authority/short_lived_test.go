@@ -0,0 +1,207 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestShortLivedConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *ShortLivedConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &ShortLivedConfig{Provisioners: []string{"step-cli"}}, false},
+		{"ok/durations", &ShortLivedConfig{Provisioners: []string{"step-cli"}, MaxDuration: &provisioner.Duration{Duration: 24 * time.Hour}, FlushPeriod: &provisioner.Duration{Duration: time.Second}}, false},
+		{"fail/provisioners", &ShortLivedConfig{}, true},
+		{"fail/empty", &ShortLivedConfig{Provisioners: []string{""}}, true},
+		{"fail/maxDuration", &ShortLivedConfig{Provisioners: []string{"step-cli"}, MaxDuration: &provisioner.Duration{}}, true},
+		{"fail/maxDuration-limit", &ShortLivedConfig{Provisioners: []string{"step-cli"}, MaxDuration: &provisioner.Duration{Duration: 25 * time.Hour}}, true},
+		{"fail/flushPeriod", &ShortLivedConfig{Provisioners: []string{"step-cli"}, FlushPeriod: &provisioner.Duration{Duration: -time.Second}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ShortLivedConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShortLivedIssuer_flush(t *testing.T) {
+	period := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	stored := map[string][]byte{}
+	var swaps int
+	s := &shortLivedIssuer{
+		pending: make(map[string]*ShortLivedSummary),
+		db: &db.MockAuthDB{
+			MGetShortLived: func(key string) ([]byte, error) {
+				return stored[key], nil
+			},
+			MCmpAndSwapShortLived: func(key string, oldValue, newValue []byte) (bool, error) {
+				// Another replica writes the summary on the first attempt.
+				swaps++
+				if swaps == 1 {
+					stored[key] = []byte(`{"provisioner":"foo","count":10}`)
+					return false, nil
+				}
+				assert.Equals(t, stored[key], oldValue)
+				stored[key] = newValue
+				return true, nil
+			},
+		},
+	}
+	s.record("foo", period.Add(time.Minute))
+	s.record("foo", period.Add(2*time.Minute))
+	s.record("bar", period.Add(time.Hour))
+	assert.Len(t, 2, s.pending)
+	assert.FatalError(t, s.flush())
+	assert.Len(t, 0, s.pending)
+
+	var sum ShortLivedSummary
+	assert.FatalError(t, json.Unmarshal(stored["foo/2020-01-01T10:00:00Z"], &sum))
+	assert.Equals(t, int64(12), sum.Count)
+	assert.Equals(t, period.Add(time.Minute), sum.FirstIssued)
+	assert.Equals(t, period.Add(2*time.Minute), sum.LastIssued)
+	assert.FatalError(t, json.Unmarshal(stored["bar/2020-01-01T11:00:00Z"], &sum))
+	assert.Equals(t, int64(1), sum.Count)
+	assert.Equals(t, period.Add(time.Hour), sum.Period)
+
+	// Failed writes are kept for the next flush.
+	s.db = &db.MockAuthDB{MGetShortLived: func(string) ([]byte, error) {
+		return nil, errors.New("force")
+	}}
+	s.record("foo", period)
+	assert.Error(t, s.flush())
+	assert.Len(t, 1, s.pending)
+	s.record("foo", period)
+	assert.Equals(t, int64(2), s.pending["foo/2020-01-01T10:00:00Z"].Count)
+
+	// Summaries without database are discarded.
+	s.db = &db.MockAuthDB{MGetShortLived: func(string) ([]byte, error) {
+		return nil, db.ErrNotImplemented
+	}}
+	assert.FatalError(t, s.flush())
+	assert.Len(t, 0, s.pending)
+}
+
+func TestAuthority_Sign_shortLived(t *testing.T) {
+	var stored, revocationChecks int
+	summaries := map[string][]byte{}
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MIsRevoked: func(string) (bool, error) {
+			revocationChecks++
+			return false, nil
+		},
+		MStoreCertificate: func(*x509.Certificate) error {
+			stored++
+			return nil
+		},
+		MCmpAndSwapShortLived: func(key string, oldValue, newValue []byte) (bool, error) {
+			summaries[key] = newValue
+			return true, nil
+		},
+	}))
+	a.config.ShortLived = &ShortLivedConfig{Provisioners: []string{"step-cli"}, MaxDuration: &provisioner.Duration{Duration: 8 * time.Hour}}
+	a.initShortLived()
+	defer a.StopShortLived()
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	extraOpts, err := a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	// Certificates are not stored and are not checked for revocation.
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{
+		NotAfter: provisioner.NewTimeDuration(time.Now().Add(time.Hour)),
+	}, extraOpts...)
+	assert.FatalError(t, err)
+	_, err = a.Renew(certChain[0])
+	assert.FatalError(t, err)
+	_, err = a.Rekey(certChain[0], pub)
+	assert.FatalError(t, err)
+	assert.Equals(t, 0, stored)
+	assert.Equals(t, 0, revocationChecks)
+	assert.FatalError(t, a.StopShortLived())
+	assert.Len(t, 1, summaries)
+	for _, b := range summaries {
+		var sum ShortLivedSummary
+		assert.FatalError(t, json.Unmarshal(b, &sum))
+		assert.Equals(t, "step-cli", sum.Provisioner)
+		assert.Equals(t, int64(3), sum.Count)
+	}
+
+	// Other provisioners are not affected.
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, 1, stored)
+
+	// Maximum duration
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{
+		NotAfter: provisioner.NewTimeDuration(time.Now().Add(9 * time.Hour)),
+	}, extraOpts...)
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	// Short-lived certificates cannot be revoked.
+	err = a.Revoke(context.Background(), &RevokeOptions{
+		Serial:     certChain[0].SerialNumber.String(),
+		ReasonCode: ocsp.KeyCompromise,
+		MTLS:       true,
+		Crt:        certChain[0],
+	})
+	assertStatusCode(t, err, http.StatusBadRequest)
+}
+
+func TestAuthority_GetShortLivedSummaries(t *testing.T) {
+	period := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	a := testAuthority(t, WithDatabase(&db.MockAuthDB{
+		MGetShortLivedList: func() ([][]byte, error) {
+			return [][]byte{
+				[]byte(`{"provisioner":"foo","period":"2020-01-01T11:00:00Z","count":1}`),
+				[]byte(`{"provisioner":"foo","period":"2020-01-01T10:00:00Z","count":2}`),
+				[]byte(`{"provisioner":"bar","period":"2020-01-01T10:00:00Z","count":3}`),
+			}, nil
+		},
+	}))
+	summaries, err := a.GetShortLivedSummaries()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*ShortLivedSummary{
+		{Provisioner: "bar", Period: period, Count: 3},
+		{Provisioner: "foo", Period: period, Count: 2},
+		{Provisioner: "foo", Period: period.Add(time.Hour), Count: 1},
+	}, summaries)
+
+	a.db = &db.MockAuthDB{MGetShortLivedList: func() ([][]byte, error) {
+		return [][]byte{[]byte("foo")}, nil
+	}}
+	_, err = a.GetShortLivedSummaries()
+	assertStatusCode(t, err, http.StatusInternalServerError)
+
+	a.db = &db.MockAuthDB{Err: errors.New("force")}
+	_, err = a.GetShortLivedSummaries()
+	assertStatusCode(t, err, http.StatusInternalServerError)
+
+	a.db = &db.MockAuthDB{Err: db.ErrNotImplemented}
+	_, err = a.GetShortLivedSummaries()
+	assertStatusCode(t, err, http.StatusNotImplemented)
+}
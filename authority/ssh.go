@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/binary"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/smallstep/certificates/errs"
 	"github.com/smallstep/certificates/sshutil"
 	"github.com/smallstep/certificates/templates"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/randutil"
 	"github.com/smallstep/cli/jose"
 	"golang.org/x/crypto/ssh"
@@ -36,12 +38,37 @@ const (
 type SSHConfig struct {
 	HostKey          string          `json:"hostKey"`
 	UserKey          string          `json:"userKey"`
+	HostKeyRotation  *SSHKeyRotation `json:"hostKeyRotation,omitempty"`
+	UserKeyRotation  *SSHKeyRotation `json:"userKeyRotation,omitempty"`
 	Keys             []*SSHPublicKey `json:"keys,omitempty"`
 	AddUserPrincipal string          `json:"addUserPrincipal,omitempty"`
 	AddUserCommand   string          `json:"addUserCommand,omitempty"`
 	Bastion          *Bastion        `json:"bastion,omitempty"`
 }
 
+// SSHKeyRotation configures the rotation of the SSH host or user CA key. The
+// new key is published with the current one from the start of the rotation,
+// it is used to sign the certificates after the Cutover time, and the current
+// key is no longer published after the Retire time, if set.
+type SSHKeyRotation struct {
+	Key     string    `json:"key"`
+	Cutover time.Time `json:"cutover"`
+	Retire  time.Time `json:"retire,omitempty"`
+}
+
+// Validate checks the fields in SSHKeyRotation.
+func (r *SSHKeyRotation) Validate() error {
+	switch {
+	case r.Key == "":
+		return errors.New("key cannot be empty")
+	case r.Cutover.IsZero():
+		return errors.New("cutover cannot be empty")
+	case !r.Retire.IsZero() && r.Retire.Before(r.Cutover):
+		return errors.New("retire cannot be before cutover")
+	}
+	return nil
+}
+
 // Bastion contains the custom properties used on bastion.
 type Bastion struct {
 	Hostname string `json:"hostname"`
@@ -56,6 +83,22 @@ func (c *SSHConfig) Validate() error {
 	if c == nil {
 		return nil
 	}
+	if r := c.HostKeyRotation; r != nil {
+		if c.HostKey == "" {
+			return errors.New("hostKeyRotation requires a hostKey")
+		}
+		if err := r.Validate(); err != nil {
+			return errors.Wrap(err, "error validating hostKeyRotation")
+		}
+	}
+	if r := c.UserKeyRotation; r != nil {
+		if c.UserKey == "" {
+			return errors.New("userKeyRotation requires a userKey")
+		}
+		if err := r.Validate(); err != nil {
+			return errors.Wrap(err, "error validating userKeyRotation")
+		}
+	}
 	for _, k := range c.Keys {
 		if err := k.Validate(); err != nil {
 			return err
@@ -103,19 +146,22 @@ type SSHKeys struct {
 	HostKeys []ssh.PublicKey
 }
 
-// GetSSHRoots returns the SSH User and Host public keys.
+// GetSSHRoots returns the SSH User and Host public keys. During a key
+// rotation it returns the current and the new keys.
 func (a *Authority) GetSSHRoots(context.Context) (*SSHKeys, error) {
+	now := time.Now()
 	return &SSHKeys{
-		HostKeys: a.sshCAHostCerts,
-		UserKeys: a.sshCAUserCerts,
+		HostKeys: a.sshCAHostKeyRotation.keys(a.sshCAHostCerts, a.sshCAHostCertSignKey, now),
+		UserKeys: a.sshCAUserKeyRotation.keys(a.sshCAUserCerts, a.sshCAUserCertSignKey, now),
 	}, nil
 }
 
 // GetSSHFederation returns the public keys for federated SSH signers.
 func (a *Authority) GetSSHFederation(context.Context) (*SSHKeys, error) {
+	now := time.Now()
 	return &SSHKeys{
-		HostKeys: a.sshCAHostFederatedCerts,
-		UserKeys: a.sshCAUserFederatedCerts,
+		HostKeys: a.sshCAHostKeyRotation.keys(a.sshCAHostFederatedCerts, a.sshCAHostCertSignKey, now),
+		UserKeys: a.sshCAUserKeyRotation.keys(a.sshCAUserFederatedCerts, a.sshCAUserCertSignKey, now),
 	}, nil
 }
 
@@ -182,11 +228,26 @@ func (a *Authority) GetSSHBastion(ctx context.Context, user string, hostname str
 
 // SignSSH creates a signed SSH certificate with the given public key and options.
 func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "authority.SignSSH")
+	cert, err := a.signSSH(ctx, key, opts, signOpts...)
+	tracing.End(span, err)
+	a.observe(AuditSSHSign, findAuditToken(signOpts).provisionerName(), start, err)
+	return cert, err
+}
+
+func (a *Authority) signSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	var mods []provisioner.SSHCertModifier
 	var validators []provisioner.SSHCertValidator
+	var at *auditToken
 
-	// Set backdate with the configured value
+	// Set backdate with the configured value, the provisioner can override it.
 	opts.Backdate = a.config.AuthorityConfig.Backdate.Duration
+	for _, op := range signOpts {
+		if b, ok := op.(provisioner.BackdateOption); ok {
+			opts.Backdate = b.Backdate()
+		}
+	}
 
 	for _, op := range signOpts {
 		switch o := op.(type) {
@@ -204,6 +265,10 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 			if err := o.Valid(opts); err != nil {
 				return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
 			}
+		case provisioner.BackdateOption:
+			// already applied
+		case *auditToken:
+			at = o
 		default:
 			return nil, errs.InternalServer("signSSH: invalid extra option type %T", o)
 		}
@@ -238,6 +303,11 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		}
 	}
 
+	// Check the principals against the authority policy
+	if err := a.getPolicy().AllowSSH(cert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "signSSH")
+	}
+
 	// Get signer from authority keys
 	var signer ssh.Signer
 	switch cert.CertType {
@@ -245,12 +315,12 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		if a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("signSSH: user certificate signing is not enabled")
 		}
-		signer = a.sshCAUserCertSignKey
+		signer = a.getSSHUserSigner()
 	case ssh.HostCert:
 		if a.sshCAHostCertSignKey == nil {
 			return nil, errs.NotImplemented("signSSH: host certificate signing is not enabled")
 		}
-		signer = a.sshCAHostCertSignKey
+		signer = a.getSSHHostSigner()
 	default:
 		return nil, errs.InternalServer("signSSH: unexpected ssh certificate type: %d", cert.CertType)
 	}
@@ -261,7 +331,9 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 	data = data[:len(data)-4]
 
 	// Sign the certificate
+	_, span := tracing.Start(ctx, "kms.Sign")
 	sig, err := signer.Sign(rand.Reader, data)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error signing certificate")
 	}
@@ -274,15 +346,49 @@ func (a *Authority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisi
 		}
 	}
 
-	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
+	_, span = tracing.Start(ctx, "db.StoreSSHCertificate")
+	err = a.db.StoreSSHCertificate(cert)
+	tracing.End(span, err)
+	if err != nil && err != db.ErrNotImplemented {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSH: error storing certificate in db")
 	}
 
+	e := at.event(AuditSSHSign)
+	e.Serial = strconv.FormatUint(cert.Serial, 10)
+	e.Subject = cert.KeyId
+	a.audit.record(e)
+
+	ev := newSSHEvent(EventCertificateIssued, cert)
+	ev.Provisioner = e.Provisioner
+	a.events.publish(ev)
+
 	return cert, nil
 }
 
 // RenewSSH creates a signed SSH certificate using the old SSH certificate as a template.
-func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ssh.Certificate, error) {
+func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+	start := time.Now()
+	cert, err := a.renewSSH(ctx, oldCert, signOpts...)
+	a.observe(AuditSSHRenew, findAuditToken(signOpts).provisionerName(), start, err)
+	return cert, err
+}
+
+func (a *Authority) renewSSH(ctx context.Context, oldCert *ssh.Certificate, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+	var validators []provisioner.SSHCertValidator
+	var at *auditToken
+
+	for _, op := range signOpts {
+		switch o := op.(type) {
+		// validate the ssh.Certificate
+		case provisioner.SSHCertValidator:
+			validators = append(validators, o)
+		case *auditToken:
+			at = o
+		default:
+			return nil, errs.InternalServer("renewSSH: invalid extra option type %T", o)
+		}
+	}
+
 	nonce, err := randutil.ASCII(32)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH")
@@ -316,6 +422,11 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 		ValidBefore:     uint64(vb.Unix()),
 	}
 
+	// Check the principals against the authority policy
+	if err := a.getPolicy().AllowSSH(cert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "renewSSH")
+	}
+
 	// Get signer from authority keys
 	var signer ssh.Signer
 	switch cert.CertType {
@@ -323,12 +434,12 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 		if a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("renewSSH: user certificate signing is not enabled")
 		}
-		signer = a.sshCAUserCertSignKey
+		signer = a.getSSHUserSigner()
 	case ssh.HostCert:
 		if a.sshCAHostCertSignKey == nil {
 			return nil, errs.NotImplemented("renewSSH: host certificate signing is not enabled")
 		}
-		signer = a.sshCAHostCertSignKey
+		signer = a.getSSHHostSigner()
 	default:
 		return nil, errs.InternalServer("renewSSH: unexpected ssh certificate type: %d", cert.CertType)
 	}
@@ -345,22 +456,50 @@ func (a *Authority) RenewSSH(ctx context.Context, oldCert *ssh.Certificate) (*ss
 	}
 	cert.Signature = sig
 
+	// Apply validators from provisioner.
+	for _, v := range validators {
+		if err := v.Valid(cert, provisioner.SSHOptions{Backdate: backdate}); err != nil {
+			return nil, errs.Wrap(http.StatusForbidden, err, "renewSSH")
+		}
+	}
+
 	if err = a.db.StoreSSHCertificate(cert); err != nil && err != db.ErrNotImplemented {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "renewSSH: error storing certificate in db")
 	}
 
+	e := at.event(AuditSSHRenew)
+	e.Serial = strconv.FormatUint(cert.Serial, 10)
+	e.PreviousSerial = strconv.FormatUint(oldCert.Serial, 10)
+	e.Subject = cert.KeyId
+	a.audit.record(e)
+
+	ev := newSSHEvent(EventCertificateRenewed, cert)
+	ev.Provisioner = e.Provisioner
+	ev.PreviousSerial = e.PreviousSerial
+	a.events.publish(ev)
+
 	return cert, nil
 }
 
 // RekeySSH creates a signed SSH certificate using the old SSH certificate as a template.
 func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub ssh.PublicKey, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+	start := time.Now()
+	cert, err := a.rekeySSH(ctx, oldCert, pub, signOpts...)
+	a.observe(AuditSSHRekey, findAuditToken(signOpts).provisionerName(), start, err)
+	return cert, err
+}
+
+func (a *Authority) rekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub ssh.PublicKey, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
 	var validators []provisioner.SSHCertValidator
+	var at *auditToken
 
 	for _, op := range signOpts {
 		switch o := op.(type) {
 		// validate the ssh.Certificate
 		case provisioner.SSHCertValidator:
 			validators = append(validators, o)
+		case *auditToken:
+			at = o
 		default:
 			return nil, errs.InternalServer("rekeySSH; invalid extra option type %T", o)
 		}
@@ -399,6 +538,11 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 		ValidBefore:     uint64(vb.Unix()),
 	}
 
+	// Check the principals against the authority policy
+	if err := a.getPolicy().AllowSSH(cert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "rekeySSH")
+	}
+
 	// Get signer from authority keys
 	var signer ssh.Signer
 	switch cert.CertType {
@@ -406,12 +550,12 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 		if a.sshCAUserCertSignKey == nil {
 			return nil, errs.NotImplemented("rekeySSH; user certificate signing is not enabled")
 		}
-		signer = a.sshCAUserCertSignKey
+		signer = a.getSSHUserSigner()
 	case ssh.HostCert:
 		if a.sshCAHostCertSignKey == nil {
 			return nil, errs.NotImplemented("rekeySSH; host certificate signing is not enabled")
 		}
-		signer = a.sshCAHostCertSignKey
+		signer = a.getSSHHostSigner()
 	default:
 		return nil, errs.BadRequest("rekeySSH; unexpected ssh certificate type: %d", cert.CertType)
 	}
@@ -439,6 +583,17 @@ func (a *Authority) RekeySSH(ctx context.Context, oldCert *ssh.Certificate, pub
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "rekeySSH; error storing certificate in db")
 	}
 
+	e := at.event(AuditSSHRekey)
+	e.Serial = strconv.FormatUint(cert.Serial, 10)
+	e.PreviousSerial = strconv.FormatUint(oldCert.Serial, 10)
+	e.Subject = cert.KeyId
+	a.audit.record(e)
+
+	ev := newSSHEvent(EventCertificateRenewed, cert)
+	ev.Provisioner = e.Provisioner
+	ev.PreviousSerial = e.PreviousSerial
+	a.events.publish(ev)
+
 	return cert, nil
 }
 
@@ -464,7 +619,7 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHAddUser: error reading random number")
 	}
 
-	signer := a.sshCAUserCertSignKey
+	signer := a.getSSHUserSigner()
 	principal := subject.ValidPrincipals[0]
 	addUserPrincipal := a.getAddUserPrincipal()
 
@@ -500,6 +655,14 @@ func (a *Authority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, subje
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "signSSHAddUser: error storing certificate in db")
 	}
 
+	a.audit.record(&AuditEvent{
+		Action:    AuditSSHSign,
+		Requester: subject.KeyId,
+		Serial:    strconv.FormatUint(cert.Serial, 10),
+		Subject:   cert.KeyId,
+	})
+	a.events.publish(newSSHEvent(EventCertificateIssued, cert))
+
 	return cert, nil
 }
 
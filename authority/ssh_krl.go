@@ -0,0 +1,179 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH Key Revocation List constants, the format is described in the
+// PROTOCOL.krl file of the OpenSSH sources.
+const (
+	krlMagic                 uint64 = 0x5353484b524c0a00
+	krlFormatVersion         uint32 = 1
+	krlSectionCertificates   byte   = 1
+	krlSectionCertSerialList byte   = 0x20
+	krlSectionCertKeyID      byte   = 0x23
+)
+
+// GetSSHKRL returns an OpenSSH Key Revocation List (KRL) with the SSH
+// certificates revoked by serial number or by key ID. The revocations are
+// added for all the SSH user and host CA keys. The KRL can be used in sshd
+// with the RevokedKeys option.
+func (a *Authority) GetSSHKRL(ctx context.Context) ([]byte, error) {
+	if a.sshCAUserCertSignKey == nil && a.sshCAHostCertSignKey == nil {
+		return nil, errs.NotFound("getSSHKRL: ssh is not configured")
+	}
+
+	revoked, err := a.db.GetRevokedSSHCertificates()
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented("getSSHKRL: no persistence layer configured")
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "getSSHKRL")
+	}
+
+	var serials []uint64
+	var keyIDs []string
+	for _, rci := range revoked {
+		if rci.KeyID != "" {
+			keyIDs = append(keyIDs, rci.KeyID)
+			continue
+		}
+		// A KRL cannot contain the serial 0.
+		if sn, err := strconv.ParseUint(rci.Serial, 10, 64); err == nil && sn != 0 {
+			serials = append(serials, sn)
+		}
+	}
+	sort.Slice(serials, func(i, j int) bool { return serials[i] < serials[j] })
+	sort.Strings(keyIDs)
+
+	// The same key can be used for users and hosts. The keys of a rotation
+	// are always included, certificates signed by a retired key might still
+	// be valid.
+	now := time.Now()
+	userKeys := a.sshCAUserKeyRotation.keys(a.sshCAUserCerts, nil, now)
+	hostKeys := a.sshCAHostKeyRotation.keys(a.sshCAHostCerts, nil, now)
+	var caKeys []ssh.PublicKey
+	seen := make(map[string]bool)
+	for _, k := range append(append([]ssh.PublicKey{}, userKeys...), hostKeys...) {
+		if b := string(k.Marshal()); !seen[b] {
+			seen[b] = true
+			caKeys = append(caKeys, k)
+		}
+	}
+
+	return marshalSSHKRL(uint64(now.Unix()), now, caKeys, serials, keyIDs), nil
+}
+
+// AuthorizeSSHKRL authorizes a request of the SSH KRL. The client certificate
+// must be issued by the CA using one of the sshKRLProvisioners, like the
+// identity certificates of the hosts, and it must not be revoked. Super-admins
+// can also get the KRL using their client certificate or OIDC token.
+func (a *Authority) AuthorizeSSHKRL(ctx context.Context, cert *x509.Certificate, token string) error {
+	var opts []interface{}
+	if cert != nil {
+		opts = append(opts, errs.WithKeyVal("serialNumber", cert.SerialNumber.String()))
+	}
+	switch {
+	case cert != nil && a.isSSHKRLProvisioner(cert):
+		return a.verifyClientCertificate("authority.AuthorizeSSHKRL", cert, a.isSSHKRLProvisioner,
+			"certificate has not been issued by an ssh krl provisioner", opts)
+	case a.config.AuthorityConfig != nil && len(a.config.AuthorityConfig.Admins) > 0:
+		_, err := a.AuthorizeAdmin(ctx, cert, token, "")
+		return err
+	case cert == nil:
+		return errs.Unauthorized("authority.AuthorizeSSHKRL: missing client certificate")
+	default:
+		return errs.Forbidden("authority.AuthorizeSSHKRL: certificate has not been issued by an ssh krl provisioner", opts...)
+	}
+}
+
+// isSSHKRLProvisioner returns true if the provisioner extension of the
+// certificate has the name of one of the sshKRLProvisioners.
+func (a *Authority) isSSHKRLProvisioner(cert *x509.Certificate) bool {
+	name, ok := provisioner.GetProvisionerName(cert)
+	if !ok || name == "" || a.config.AuthorityConfig == nil {
+		return false
+	}
+	for _, s := range a.config.AuthorityConfig.SSHKRLProvisioners {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalSSHKRL returns the binary encoding of a KRL that revokes the given
+// serial numbers and key IDs of the certificates signed by the given CA keys.
+func marshalSSHKRL(version uint64, generatedAt time.Time, caKeys []ssh.PublicKey, serials []uint64, keyIDs []string) []byte {
+	b := new(krlBuffer)
+	b.writeUint64(krlMagic)
+	b.writeUint32(krlFormatVersion)
+	b.writeUint64(version)
+	b.writeUint64(uint64(generatedAt.Unix()))
+	b.writeUint64(0)   // flags
+	b.writeString(nil) // reserved
+	b.writeString(nil) // comment
+
+	if len(serials) == 0 && len(keyIDs) == 0 {
+		return b.Bytes()
+	}
+
+	for _, k := range caKeys {
+		section := new(krlBuffer)
+		section.writeString(k.Marshal())
+		section.writeString(nil) // reserved
+		if len(serials) > 0 {
+			list := new(krlBuffer)
+			for _, sn := range serials {
+				list.writeUint64(sn)
+			}
+			section.WriteByte(krlSectionCertSerialList)
+			section.writeString(list.Bytes())
+		}
+		if len(keyIDs) > 0 {
+			list := new(krlBuffer)
+			for _, id := range keyIDs {
+				list.writeString([]byte(id))
+			}
+			section.WriteByte(krlSectionCertKeyID)
+			section.writeString(list.Bytes())
+		}
+		b.WriteByte(krlSectionCertificates)
+		b.writeString(section.Bytes())
+	}
+	return b.Bytes()
+}
+
+// krlBuffer is a bytes.Buffer with the methods to write the SSH wire types.
+type krlBuffer struct {
+	bytes.Buffer
+}
+
+func (b *krlBuffer) writeUint32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	b.Write(buf[:])
+}
+
+func (b *krlBuffer) writeUint64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	b.Write(buf[:])
+}
+
+func (b *krlBuffer) writeString(s []byte) {
+	b.writeUint32(uint32(len(s)))
+	b.Write(s)
+}
@@ -0,0 +1,329 @@
+package authority
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ssh"
+)
+
+// testKRL is the decoded content of a KRL.
+type testKRL struct {
+	version uint64
+	caKeys  [][]byte
+	serials map[string][]uint64
+	keyIDs  map[string][]string
+}
+
+func decodeTestKRL(t *testing.T, b []byte) *testKRL {
+	t.Helper()
+	readUint32 := func() uint32 {
+		assert.Fatal(t, len(b) >= 4, "unexpected end of krl")
+		v := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		return v
+	}
+	readUint64 := func() uint64 {
+		assert.Fatal(t, len(b) >= 8, "unexpected end of krl")
+		v := binary.BigEndian.Uint64(b)
+		b = b[8:]
+		return v
+	}
+	readString := func() []byte {
+		n := int(readUint32())
+		assert.Fatal(t, len(b) >= n, "unexpected end of krl")
+		v := b[:n]
+		b = b[n:]
+		return v
+	}
+
+	assert.Equals(t, "SSHKRL\n\x00", string(b[:8]))
+	b = b[8:]
+	assert.Equals(t, uint32(1), readUint32())
+	krl := &testKRL{
+		version: readUint64(),
+		serials: make(map[string][]uint64),
+		keyIDs:  make(map[string][]string),
+	}
+	readUint64() // generated date
+	assert.Equals(t, uint64(0), readUint64())
+	assert.Len(t, 0, readString())
+	assert.Len(t, 0, readString())
+
+	for len(b) > 0 {
+		assert.Equals(t, byte(1), b[0])
+		b = b[1:]
+		section := readString()
+		rest := b
+		b = section
+		caKey := readString()
+		krl.caKeys = append(krl.caKeys, caKey)
+		assert.Len(t, 0, readString())
+		for len(b) > 0 {
+			typ := b[0]
+			b = b[1:]
+			data := readString()
+			rest2 := b
+			b = data
+			for len(b) > 0 {
+				switch typ {
+				case 0x20:
+					krl.serials[string(caKey)] = append(krl.serials[string(caKey)], readUint64())
+				case 0x23:
+					krl.keyIDs[string(caKey)] = append(krl.keyIDs[string(caKey)], string(readString()))
+				default:
+					t.Fatalf("unexpected certificate section %x", typ)
+				}
+			}
+			b = rest2
+		}
+		b = rest
+	}
+	return krl
+}
+
+func TestAuthority_GetSSHKRL(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.FatalError(t, err)
+		signer, err := ssh.NewSignerFromKey(key)
+		assert.FatalError(t, err)
+		return signer
+	}
+	userSigner, hostSigner, oldSigner := newSigner(), newSigner(), newSigner()
+
+	revoked := []*db.RevokedCertificateInfo{
+		{Serial: "1234"}, {Serial: "12"}, {Serial: "0"}, {Serial: "not-a-number"}, {KeyID: "mariano@smallstep.com"},
+	}
+	newAuthority := func(user, host ssh.Signer, mdb db.AuthDB) *Authority {
+		a := testAuthority(t, WithDatabase(mdb))
+		a.sshCAUserCertSignKey = user
+		a.sshCAHostCertSignKey = host
+		if user != nil {
+			a.sshCAUserCerts = []ssh.PublicKey{user.PublicKey(), oldSigner.PublicKey()}
+		}
+		if host != nil {
+			a.sshCAHostCerts = []ssh.PublicKey{host.PublicKey()}
+		}
+		return a
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		a := newAuthority(userSigner, hostSigner, &db.MockAuthDB{
+			MGetRevokedSSHCerts: func() ([]*db.RevokedCertificateInfo, error) {
+				return revoked, nil
+			},
+		})
+		before := uint64(time.Now().Unix())
+		b, err := a.GetSSHKRL(context.Background())
+		assert.FatalError(t, err)
+		krl := decodeTestKRL(t, b)
+		assert.True(t, krl.version >= before)
+		assert.Equals(t, [][]byte{userSigner.PublicKey().Marshal(), oldSigner.PublicKey().Marshal(), hostSigner.PublicKey().Marshal()}, krl.caKeys)
+		for _, k := range krl.caKeys {
+			assert.Equals(t, []uint64{12, 1234}, krl.serials[string(k)])
+			assert.Equals(t, []string{"mariano@smallstep.com"}, krl.keyIDs[string(k)])
+		}
+	})
+
+	t.Run("ok same key", func(t *testing.T) {
+		a := newAuthority(userSigner, userSigner, &db.MockAuthDB{
+			MGetRevokedSSHCerts: func() ([]*db.RevokedCertificateInfo, error) {
+				return []*db.RevokedCertificateInfo{{Serial: "1"}}, nil
+			},
+		})
+		b, err := a.GetSSHKRL(context.Background())
+		assert.FatalError(t, err)
+		krl := decodeTestKRL(t, b)
+		assert.Equals(t, [][]byte{userSigner.PublicKey().Marshal(), oldSigner.PublicKey().Marshal()}, krl.caKeys)
+		assert.Len(t, 0, krl.keyIDs)
+	})
+
+	t.Run("ok empty", func(t *testing.T) {
+		a := newAuthority(nil, hostSigner, &db.MockAuthDB{
+			MGetRevokedSSHCerts: func() ([]*db.RevokedCertificateInfo, error) {
+				return nil, nil
+			},
+		})
+		b, err := a.GetSSHKRL(context.Background())
+		assert.FatalError(t, err)
+		krl := decodeTestKRL(t, b)
+		assert.Len(t, 0, krl.caKeys)
+	})
+
+	tests := []struct {
+		name string
+		a    *Authority
+		code int
+	}{
+		{"fail not configured", newAuthority(nil, nil, &db.MockAuthDB{}), http.StatusNotFound},
+		{"fail no db", newAuthority(userSigner, hostSigner, &db.MockAuthDB{Err: db.ErrNotImplemented}), http.StatusNotImplemented},
+		{"fail db", newAuthority(userSigner, hostSigner, &db.MockAuthDB{Err: errors.New("force")}), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.a.GetSSHKRL(context.Background())
+			if assert.NotNil(t, err) {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+			}
+		})
+	}
+}
+
+func TestAuthority_AuthorizeSSHKRL(t *testing.T) {
+	a := testAuthority(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	newCert := func(cn, provisionerName string, selfSigned bool) *x509.Certificate {
+		b, err := asn1.Marshal(stepProvisionerASN1{
+			Type:         provisionerTypeJWK,
+			Name:         []byte(provisionerName),
+			CredentialID: []byte("kid"),
+		})
+		assert.FatalError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:   big.NewInt(time.Now().UnixNano()),
+			Subject:        pkix.Name{CommonName: cn},
+			EmailAddresses: []string{cn + "@smallstep.com"},
+			NotBefore:      time.Now().Add(-time.Minute),
+			NotAfter:       time.Now().Add(time.Hour),
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			ExtraExtensions: []pkix.Extension{
+				{Id: stepOIDProvisioner, Value: b},
+			},
+		}
+		issuer, issuerKey := a.x509Issuer, a.x509Signer
+		if selfSigned {
+			issuer, issuerKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+		assert.FatalError(t, err)
+		crt, err := x509.ParseCertificate(der)
+		assert.FatalError(t, err)
+		return crt
+	}
+	hostCrt := newCert("host", "hosts", false)
+	leafCrt := newCert("leaf", "step-cli", false)
+	adminCrt := newCert("admin", "step-cli", false)
+	selfSignedCrt := newCert("host", "hosts", true)
+
+	type authorizeTest struct {
+		auth  *Authority
+		cert  *x509.Certificate
+		token string
+		err   error
+		code  int
+	}
+	tests := map[string]func(t *testing.T) *authorizeTest{
+		"fail/no-certificate": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			return &authorizeTest{
+				auth: a,
+				err:  errors.New("authority.AuthorizeSSHKRL: missing client certificate"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/leaf": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			return &authorizeTest{
+				auth: a,
+				cert: leafCrt,
+				err:  errors.New("authority.AuthorizeSSHKRL: certificate has not been issued by an ssh krl provisioner"),
+				code: http.StatusForbidden,
+			}
+		},
+		"fail/leaf-not-admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				cert: leafCrt,
+				err:  errors.New("authority.AuthorizeAdmin: certificate does not belong to an admin"),
+				code: http.StatusForbidden,
+			}
+		},
+		"fail/verify": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			return &authorizeTest{
+				auth: a,
+				cert: selfSignedCrt,
+				err:  errors.New("authority.AuthorizeSSHKRL: error verifying certificate"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"fail/revoked": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			a.db = &db.MockAuthDB{
+				MIsRevoked: func(key string) (bool, error) {
+					assert.Equals(t, hostCrt.SerialNumber.String(), key)
+					return true, nil
+				},
+			}
+			return &authorizeTest{
+				auth: a,
+				cert: hostCrt,
+				err:  errors.New("authority.AuthorizeSSHKRL: certificate has been revoked"),
+				code: http.StatusUnauthorized,
+			}
+		},
+		"ok": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			return &authorizeTest{
+				auth: a,
+				cert: hostCrt,
+			}
+		},
+		"ok/admin": func(t *testing.T) *authorizeTest {
+			a := testAuthority(t)
+			a.config.AuthorityConfig.SSHKRLProvisioners = []string{"hosts"}
+			a.config.AuthorityConfig.Admins = []string{"admin@smallstep.com"}
+			a.config.AuthorityConfig.AdminCertProvisioners = []string{"step-cli"}
+			return &authorizeTest{
+				auth: a,
+				cert: adminCrt,
+			}
+		},
+	}
+
+	for name, genTestCase := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := genTestCase(t)
+
+			err := tc.auth.AuthorizeSSHKRL(context.Background(), tc.cert, tc.token)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, sc.StatusCode(), tc.code)
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
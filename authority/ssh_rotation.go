@@ -0,0 +1,81 @@
+package authority
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pkg/errors"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeyRotation is the loaded state of an SSHKeyRotation.
+type sshKeyRotation struct {
+	signer  ssh.Signer
+	cutover time.Time
+	retire  time.Time
+}
+
+// newSSHKeyRotation loads the new key of the given rotation.
+func (a *Authority) newSSHKeyRotation(r *SSHKeyRotation) (*sshKeyRotation, error) {
+	signer, err := a.keyManager.CreateSigner(&kmsapi.CreateSignerRequest{
+		SigningKey: r.Key,
+		Password:   []byte(a.config.Password),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating ssh signer")
+	}
+	return &sshKeyRotation{
+		signer:  sshSigner,
+		cutover: r.Cutover,
+		retire:  r.Retire,
+	}, nil
+}
+
+// isCutover returns true if the new key must be used to sign certificates.
+func (r *sshKeyRotation) isCutover(now time.Time) bool {
+	return r != nil && !now.Before(r.cutover)
+}
+
+// isRetired returns true if the old key must not be published anymore.
+func (r *sshKeyRotation) isRetired(now time.Time) bool {
+	return r != nil && !r.retire.IsZero() && !now.Before(r.retire)
+}
+
+// keys returns the given public keys with the new key of the rotation, and
+// without the old signing key once it is retired.
+func (r *sshKeyRotation) keys(keys []ssh.PublicKey, old ssh.Signer, now time.Time) []ssh.PublicKey {
+	if r == nil {
+		return keys
+	}
+	list := make([]ssh.PublicKey, 0, len(keys)+1)
+	for _, k := range keys {
+		if old != nil && r.isRetired(now) && bytes.Equal(k.Marshal(), old.PublicKey().Marshal()) {
+			continue
+		}
+		list = append(list, k)
+	}
+	return append(list, r.signer.PublicKey())
+}
+
+// getSSHUserSigner returns the signer of SSH user certificates, the new key of
+// a rotation after the cutover time or the configured user key.
+func (a *Authority) getSSHUserSigner() ssh.Signer {
+	if a.sshCAUserKeyRotation.isCutover(time.Now()) {
+		return a.sshCAUserKeyRotation.signer
+	}
+	return a.sshCAUserCertSignKey
+}
+
+// getSSHHostSigner returns the signer of SSH host certificates, the new key of
+// a rotation after the cutover time or the configured host key.
+func (a *Authority) getSSHHostSigner() ssh.Signer {
+	if a.sshCAHostKeyRotation.isCutover(time.Now()) {
+		return a.sshCAHostKeyRotation.signer
+	}
+	return a.sshCAHostCertSignKey
+}
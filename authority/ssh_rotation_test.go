@@ -0,0 +1,170 @@
+package authority
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/templates"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthority_newSSHKeyRotation(t *testing.T) {
+	now := time.Now()
+	config := &Config{
+		Address:          "127.0.0.1:443",
+		Root:             []string{"testdata/certs/root_ca.crt"},
+		IntermediateCert: "testdata/certs/intermediate_ca.crt",
+		IntermediateKey:  "testdata/secrets/intermediate_ca_key",
+		SSH: &SSHConfig{
+			HostKey: "testdata/secrets/ssh_host_ca_key",
+			UserKey: "testdata/secrets/ssh_user_ca_key",
+			HostKeyRotation: &SSHKeyRotation{
+				Key:     "testdata/secrets/ssh_user_ca_key",
+				Cutover: now.Add(time.Hour),
+				Retire:  now.Add(2 * time.Hour),
+			},
+		},
+		Templates: &templates.Templates{},
+		DNSNames:  []string{"example.com"},
+		Password:  "pass",
+		AuthorityConfig: &AuthConfig{
+			Provisioners: testAuthority(t).config.AuthorityConfig.Provisioners,
+		},
+	}
+
+	a, err := New(config)
+	assert.FatalError(t, err)
+	assert.Nil(t, a.sshCAUserKeyRotation)
+	if assert.NotNil(t, a.sshCAHostKeyRotation) {
+		assert.Equals(t, a.sshCAUserCertSignKey.PublicKey().Marshal(), a.sshCAHostKeyRotation.signer.PublicKey().Marshal())
+		assert.Equals(t, config.SSH.HostKeyRotation.Cutover, a.sshCAHostKeyRotation.cutover)
+		assert.Equals(t, config.SSH.HostKeyRotation.Retire, a.sshCAHostKeyRotation.retire)
+	}
+	vars := config.Templates.Data["Step"].(templates.Step)
+	assert.Equals(t, []ssh.PublicKey{a.sshCAHostKeyRotation.signer.PublicKey()}, vars.SSH.HostFederatedKeys)
+	assert.Len(t, 0, vars.SSH.UserFederatedKeys)
+
+	config.SSH.HostKeyRotation.Key = "testdata/secrets/missing_key"
+	_, err = New(config)
+	assert.NotNil(t, err)
+}
+
+func Test_sshKeyRotation(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.FatalError(t, err)
+		signer, err := ssh.NewSignerFromKey(key)
+		assert.FatalError(t, err)
+		return signer
+	}
+	oldSigner, newKeySigner, otherSigner := newSigner(), newSigner(), newSigner()
+
+	now := time.Now()
+	cutover := now.Add(time.Hour)
+	retire := now.Add(2 * time.Hour)
+	rotation := &sshKeyRotation{signer: newKeySigner, cutover: cutover, retire: retire}
+	noRetire := &sshKeyRotation{signer: newKeySigner, cutover: cutover}
+	keys := []ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey()}
+
+	tests := []struct {
+		name         string
+		rotation     *sshKeyRotation
+		now          time.Time
+		wantCutover  bool
+		wantRetired  bool
+		wantKeys     []ssh.PublicKey
+		wantFullKeys []ssh.PublicKey
+	}{
+		{"nil", nil, now, false, false, keys, keys},
+		{"before cutover", rotation, now, false, false,
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()},
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()}},
+		{"at cutover", rotation, cutover, true, false,
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()},
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()}},
+		{"retired", rotation, retire, true, true,
+			[]ssh.PublicKey{otherSigner.PublicKey(), newKeySigner.PublicKey()},
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()}},
+		{"no retire", noRetire, retire, true, false,
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()},
+			[]ssh.PublicKey{oldSigner.PublicKey(), otherSigner.PublicKey(), newKeySigner.PublicKey()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rotation.isCutover(tt.now); got != tt.wantCutover {
+				t.Errorf("sshKeyRotation.isCutover() = %v, want %v", got, tt.wantCutover)
+			}
+			if got := tt.rotation.isRetired(tt.now); got != tt.wantRetired {
+				t.Errorf("sshKeyRotation.isRetired() = %v, want %v", got, tt.wantRetired)
+			}
+			if got := tt.rotation.keys(keys, oldSigner, tt.now); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("sshKeyRotation.keys() = %v, want %v", got, tt.wantKeys)
+			}
+			if got := tt.rotation.keys(keys, nil, tt.now); !reflect.DeepEqual(got, tt.wantFullKeys) {
+				t.Errorf("sshKeyRotation.keys() = %v, want %v", got, tt.wantFullKeys)
+			}
+		})
+	}
+}
+
+func TestAuthority_sshKeyRotation(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.FatalError(t, err)
+		signer, err := ssh.NewSignerFromKey(key)
+		assert.FatalError(t, err)
+		return signer
+	}
+	userSigner, hostSigner := newSigner(), newSigner()
+	newUserSigner, newHostSigner := newSigner(), newSigner()
+
+	now := time.Now()
+	a := testAuthority(t)
+	a.sshCAUserCertSignKey = userSigner
+	a.sshCAHostCertSignKey = hostSigner
+	a.sshCAUserCerts = []ssh.PublicKey{userSigner.PublicKey()}
+	a.sshCAHostCerts = []ssh.PublicKey{hostSigner.PublicKey()}
+	a.sshCAUserFederatedCerts = []ssh.PublicKey{userSigner.PublicKey()}
+	a.sshCAHostFederatedCerts = []ssh.PublicKey{hostSigner.PublicKey()}
+
+	// No rotation
+	assert.Equals(t, userSigner, a.getSSHUserSigner())
+	assert.Equals(t, hostSigner, a.getSSHHostSigner())
+
+	// Transition window
+	a.sshCAUserKeyRotation = &sshKeyRotation{signer: newUserSigner, cutover: now.Add(time.Hour)}
+	a.sshCAHostKeyRotation = &sshKeyRotation{signer: newHostSigner, cutover: now.Add(time.Hour)}
+	assert.Equals(t, userSigner, a.getSSHUserSigner())
+	assert.Equals(t, hostSigner, a.getSSHHostSigner())
+	roots, err := a.GetSSHRoots(context.Background())
+	assert.FatalError(t, err)
+	assert.Equals(t, &SSHKeys{
+		UserKeys: []ssh.PublicKey{userSigner.PublicKey(), newUserSigner.PublicKey()},
+		HostKeys: []ssh.PublicKey{hostSigner.PublicKey(), newHostSigner.PublicKey()},
+	}, roots)
+	federation, err := a.GetSSHFederation(context.Background())
+	assert.FatalError(t, err)
+	assert.Equals(t, roots, federation)
+
+	// After the cutover
+	a.sshCAUserKeyRotation.cutover = now.Add(-time.Hour)
+	a.sshCAHostKeyRotation.cutover = now.Add(-time.Hour)
+	assert.Equals(t, newUserSigner, a.getSSHUserSigner())
+	assert.Equals(t, newHostSigner, a.getSSHHostSigner())
+
+	// After the retirement
+	a.sshCAUserKeyRotation.retire = now.Add(-time.Minute)
+	a.sshCAHostKeyRotation.retire = now.Add(-time.Minute)
+	roots, err = a.GetSSHRoots(context.Background())
+	assert.FatalError(t, err)
+	assert.Equals(t, &SSHKeys{
+		UserKeys: []ssh.PublicKey{newUserSigner.PublicKey()},
+		HostKeys: []ssh.PublicKey{newHostSigner.PublicKey()},
+	}, roots)
+}
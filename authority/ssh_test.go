@@ -15,6 +15,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
@@ -173,6 +174,55 @@ func TestAuthority_SignSSH(t *testing.T) {
 	}
 }
 
+func TestAuthority_SignSSH_policy(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pub, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	signKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	signer, err := ssh.NewSignerFromKey(signKey)
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	a.sshCAUserCertSignKey = signer
+	a.sshCAHostCertSignKey = signer
+	a.policy, err = policy.New(&policy.Options{
+		SSH: &policy.SSHOptions{
+			Allow: &policy.SSHNames{Host: []string{".test.com"}},
+			Deny:  &policy.SSHNames{User: []string{"root"}},
+		},
+	})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		opts    provisioner.SSHOptions
+		wantErr bool
+	}{
+		{"ok-user", provisioner.SSHOptions{CertType: "user", Principals: []string{"user"}}, false},
+		{"ok-host", provisioner.SSHOptions{CertType: "host", Principals: []string{"foo.test.com"}}, false},
+		{"fail-user", provisioner.SSHOptions{CertType: "user", Principals: []string{"user", "root"}}, true},
+		{"fail-host", provisioner.SSHOptions{CertType: "host", Principals: []string{"foo.test.com", "foo.example.com"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.SignSSH(context.Background(), pub, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authority.SignSSH() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, http.StatusForbidden, sc.StatusCode())
+			} else {
+				assert.Equals(t, tt.opts.Principals, got.ValidPrincipals)
+			}
+		})
+	}
+}
+
 func TestAuthority_SignSSHAddUser(t *testing.T) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	assert.FatalError(t, err)
@@ -522,6 +572,7 @@ func TestAuthority_CheckSSHHost(t *testing.T) {
 func TestSSHConfig_Validate(t *testing.T) {
 	key, err := jose.GenerateJWK("EC", "P-256", "", "sig", "", 0)
 	assert.FatalError(t, err)
+	now := time.Now()
 
 	tests := []struct {
 		name      string
@@ -533,6 +584,13 @@ func TestSSHConfig_Validate(t *testing.T) {
 		{"ok", &SSHConfig{Keys: []*SSHPublicKey{{Type: "host", Key: key.Public()}}}, false},
 		{"badType", &SSHConfig{Keys: []*SSHPublicKey{{Type: "bad", Key: key.Public()}}}, true},
 		{"badKey", &SSHConfig{Keys: []*SSHPublicKey{{Type: "user", Key: *key}}}, true},
+		{"ok hostKeyRotation", &SSHConfig{HostKey: "host.key", HostKeyRotation: &SSHKeyRotation{Key: "new.key", Cutover: now}}, false},
+		{"ok userKeyRotation", &SSHConfig{UserKey: "user.key", UserKeyRotation: &SSHKeyRotation{Key: "new.key", Cutover: now, Retire: now.Add(time.Hour)}}, false},
+		{"fail hostKeyRotation no hostKey", &SSHConfig{HostKeyRotation: &SSHKeyRotation{Key: "new.key", Cutover: now}}, true},
+		{"fail userKeyRotation no userKey", &SSHConfig{UserKeyRotation: &SSHKeyRotation{Key: "new.key", Cutover: now}}, true},
+		{"fail rotation no key", &SSHConfig{UserKey: "user.key", UserKeyRotation: &SSHKeyRotation{Cutover: now}}, true},
+		{"fail rotation no cutover", &SSHConfig{UserKey: "user.key", UserKeyRotation: &SSHKeyRotation{Key: "new.key"}}, true},
+		{"fail rotation retire before cutover", &SSHConfig{HostKey: "host.key", HostKeyRotation: &SSHKeyRotation{Key: "new.key", Cutover: now, Retire: now.Add(-time.Hour)}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -917,3 +975,58 @@ func TestAuthority_RekeySSH(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_RenewSSH(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	pub, err := ssh.NewPublicKey(key.Public())
+	assert.FatalError(t, err)
+	signKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	signer, err := ssh.NewSignerFromKey(signKey)
+	assert.FatalError(t, err)
+
+	now := time.Now().UTC()
+	oldCert := &ssh.Certificate{
+		Key:             pub,
+		ValidAfter:      uint64(now.Add(-24 * time.Hour).Unix()),
+		ValidBefore:     uint64(now.Add(-23 * time.Hour).Unix()),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"foo.smallstep.com"},
+		KeyId:           "foo.smallstep.com",
+	}
+
+	a := testAuthority(t)
+	a.sshCAHostCertSignKey = signer
+
+	tests := []struct {
+		name     string
+		cert     *ssh.Certificate
+		signOpts []provisioner.SignOption
+		err      error
+		code     int
+	}{
+		{"ok", oldCert, nil, nil, 0},
+		{"ok with validators", oldCert, []provisioner.SignOption{sshTestCertValidator("")}, nil, 0},
+		{"fail/opts-type", oldCert, []provisioner.SignOption{sshTestModifier{}}, errors.New("renewSSH: invalid extra option type"), http.StatusInternalServerError},
+		{"fail/validator", oldCert, []provisioner.SignOption{sshTestCertValidator("an error")}, errors.New("renewSSH: an error"), http.StatusForbidden},
+		{"fail/no-validity", &ssh.Certificate{CertType: ssh.HostCert}, nil, errors.New("rewnewSSH: cannot renew certificate without validity period"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := a.RenewSSH(context.Background(), tt.cert, tt.signOpts...)
+			if err != nil {
+				if assert.NotNil(t, tt.err) {
+					sc, ok := err.(errs.StatusCoder)
+					assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+					assert.Equals(t, tt.code, sc.StatusCode())
+					assert.HasPrefix(t, err.Error(), tt.err.Error())
+				}
+			} else if assert.Nil(t, tt.err) {
+				assert.Equals(t, tt.cert.ValidPrincipals, cert.ValidPrincipals)
+				assert.Equals(t, tt.cert.ValidBefore-tt.cert.ValidAfter, cert.ValidBefore-cert.ValidAfter)
+				assert.Equals(t, signer.PublicKey().Marshal(), cert.SignatureKey.Marshal())
+			}
+		})
+	}
+}
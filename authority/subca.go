@@ -0,0 +1,239 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/randutil"
+)
+
+// SubCAStatus is the status of a sub-CA request.
+type SubCAStatus string
+
+const (
+	// SubCAPending is the status of a request waiting for the approval of an
+	// administrator.
+	SubCAPending SubCAStatus = "pending"
+	// SubCAApproved is the status of an approved request, the certificate
+	// has been signed.
+	SubCAApproved SubCAStatus = "approved"
+	// SubCARejected is the status of a request rejected by an administrator.
+	SubCARejected SubCAStatus = "rejected"
+)
+
+// SubCARequest is a request for a subordinate CA certificate authorized by a
+// SubCA provisioner. The certificate is signed when an administrator approves
+// it.
+type SubCARequest struct {
+	ID          string      `json:"id"`
+	Provisioner string      `json:"provisioner"`
+	Status      SubCAStatus `json:"status"`
+	Subject     string      `json:"subject"`
+	SANs        []string    `json:"sans,omitempty"`
+	CSR         []byte      `json:"csr"`
+	CertChain   [][]byte    `json:"certChain,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+	value       []byte
+}
+
+// GetCertificateRequest returns the parsed certificate request.
+func (r *SubCARequest) GetCertificateRequest() (*x509.CertificateRequest, error) {
+	return x509.ParseCertificateRequest(r.CSR)
+}
+
+// GetCertChain returns the sub-CA certificate followed by its issuer, or nil
+// if the request has not been approved.
+func (r *SubCARequest) GetCertChain() ([]*x509.Certificate, error) {
+	var certChain []*x509.Certificate
+	for _, b := range r.CertChain {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, err
+		}
+		certChain = append(certChain, cert)
+	}
+	return certChain, nil
+}
+
+// RequestSubCA authorizes the token of a SubCA provisioner and stores the
+// certificate request until an administrator approves or rejects it.
+func (a *Authority) RequestSubCA(ctx context.Context, csr *x509.CertificateRequest, token string) (*SubCARequest, error) {
+	p, err := a.authorizeToken(ctx, token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RequestSubCA")
+	}
+	sp, ok := p.(*provisioner.SubCA)
+	if !ok {
+		return nil, errs.Unauthorized("authority.RequestSubCA: provisioner %s is not a subca provisioner", p.GetName())
+	}
+	subject, sans, err := sp.AuthorizeSubCA(ctx, token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RequestSubCA")
+	}
+
+	// Validate the request now, it's validated again when it's signed.
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.RequestSubCA: invalid certificate request")
+	}
+	for _, op := range sp.SignOptions(subject, sans) {
+		if v, ok := op.(provisioner.CertificateRequestValidator); ok {
+			if err := v.Valid(csr); err != nil {
+				return nil, errs.Wrap(http.StatusUnauthorized, err, "authority.RequestSubCA")
+			}
+		}
+	}
+
+	id, err := randutil.Hex(32)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RequestSubCA")
+	}
+	now := time.Now().UTC()
+	r := &SubCARequest{
+		ID:          id,
+		Provisioner: sp.GetName(),
+		Status:      SubCAPending,
+		Subject:     subject,
+		SANs:        sans,
+		CSR:         csr.Raw,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := a.swapSubCARequest(nil, r); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RequestSubCA")
+	}
+	return r, nil
+}
+
+// GetSubCARequest returns the sub-CA request with the given id.
+func (a *Authority) GetSubCARequest(id string) (*SubCARequest, error) {
+	b, err := a.db.GetSubCARequest(id)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetSubCARequest")
+	}
+	if b == nil {
+		return nil, errs.NotFound("authority.GetSubCARequest: sub-CA request %s was not found", id)
+	}
+	return unmarshalSubCARequest(b)
+}
+
+// GetSubCARequests returns the sub-CA requests with the given status, or all of
+// them if the status is empty, sorted by creation time.
+func (a *Authority) GetSubCARequests(status SubCAStatus) ([]*SubCARequest, error) {
+	list, err := a.db.GetSubCARequests()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetSubCARequests")
+	}
+	requests := []*SubCARequest{}
+	for _, b := range list {
+		r, err := unmarshalSubCARequest(b)
+		if err != nil {
+			return nil, err
+		}
+		if status == "" || r.Status == status {
+			requests = append(requests, r)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+// ApproveSubCARequest signs the certificate of a pending sub-CA request using
+// the current configuration of its provisioner.
+func (a *Authority) ApproveSubCARequest(id string) (*SubCARequest, error) {
+	r, err := a.GetSubCARequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != SubCAPending {
+		return nil, errs.BadRequest("authority.ApproveSubCARequest: sub-CA request %s is %s", id, r.Status)
+	}
+	p, ok := a.provisioners.Load("subca/" + r.Provisioner)
+	if !ok {
+		return nil, errs.BadRequest("authority.ApproveSubCARequest: provisioner %s was not found", r.Provisioner)
+	}
+	sp, ok := p.(*provisioner.SubCA)
+	if !ok {
+		return nil, errs.BadRequest("authority.ApproveSubCARequest: provisioner %s is not a subca provisioner", r.Provisioner)
+	}
+	csr, err := r.GetCertificateRequest()
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.ApproveSubCARequest: error parsing certificate request")
+	}
+	certChain, err := a.Sign(csr, provisioner.Options{}, sp.SignOptions(r.Subject, r.SANs)...)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *r
+	next.Status = SubCAApproved
+	for _, cert := range certChain {
+		next.CertChain = append(next.CertChain, cert.Raw)
+	}
+	next.UpdatedAt = time.Now().UTC()
+	if err := a.swapSubCARequest(r, &next); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.ApproveSubCARequest")
+	}
+	return &next, nil
+}
+
+// RejectSubCARequest rejects a pending sub-CA request.
+func (a *Authority) RejectSubCARequest(id string) (*SubCARequest, error) {
+	r, err := a.GetSubCARequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != SubCAPending {
+		return nil, errs.BadRequest("authority.RejectSubCARequest: sub-CA request %s is %s", id, r.Status)
+	}
+	next := *r
+	next.Status = SubCARejected
+	next.UpdatedAt = time.Now().UTC()
+	if err := a.swapSubCARequest(r, &next); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.RejectSubCARequest")
+	}
+	return &next, nil
+}
+
+func unmarshalSubCARequest(b []byte) (*SubCARequest, error) {
+	r := new(SubCARequest)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "error unmarshaling sub-CA request")
+	}
+	r.value = b
+	return r, nil
+}
+
+// swapSubCARequest stores the given request in the database if the current
+// value is still the one in old.
+func (a *Authority) swapSubCARequest(old, r *SubCARequest) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, "error marshaling sub-CA request")
+	}
+	var oldValue []byte
+	if old != nil {
+		oldValue = old.value
+	}
+	swapped, err := a.db.CmpAndSwapSubCARequest(r.ID, oldValue, b)
+	switch {
+	case err == db.ErrNotImplemented:
+		return errs.Wrap(http.StatusNotImplemented, err, "storing sub-CA requests is not implemented")
+	case err != nil:
+		return errs.Wrap(http.StatusInternalServerError, err, "error storing sub-CA request")
+	case !swapped:
+		return errs.Errorf(http.StatusConflict, "sub-CA request %s has been modified concurrently", r.ID)
+	default:
+		r.value = b
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package authority
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+)
+
+func TestAuthority_SubCARequests(t *testing.T) {
+	provisioners := map[string][]byte{}
+	requests := map[string][]byte{}
+	cas := func(m map[string][]byte) func(id string, oldValue, newValue []byte) (bool, error) {
+		return func(id string, oldValue, newValue []byte) (bool, error) {
+			if !bytes.Equal(m[id], oldValue) {
+				return false, nil
+			}
+			m[id] = newValue
+			return true, nil
+		}
+	}
+	mockDB := &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+		MGetProvisioners: func() ([][]byte, error) {
+			var list [][]byte
+			for _, b := range provisioners {
+				list = append(list, b)
+			}
+			return list, nil
+		},
+		MCmpAndSwapProvisioner: cas(provisioners),
+		MGetSubCARequest: func(id string) ([]byte, error) {
+			return requests[id], nil
+		},
+		MGetSubCARequests: func() ([][]byte, error) {
+			var list [][]byte
+			for _, b := range requests {
+				list = append(list, b)
+			}
+			return list, nil
+		},
+		MCmpAndSwapSubCA: cas(requests),
+	}
+	a := testAuthority(t, WithDatabase(mockDB))
+
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	assert.FatalError(t, err)
+	pub, err := json.Marshal(jwk.Public())
+	assert.FatalError(t, err)
+	_, err = a.CreateProvisioner([]byte(fmt.Sprintf(`{"type":"SubCA","name":"team-ca","key":%s,"maxPathLen":0,`+
+		`"nameConstraints":{"permitted":{"dns":[".team.internal"]}}}`, pub)))
+	assert.FatalError(t, err)
+
+	assertError := func(err error, code int) {
+		if assert.NotNil(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+		}
+	}
+	newToken := func(sub string, sans []string) string {
+		tok, err := generateToken(sub, "team-ca", testAudiences.Sign[0]+"#subca/team-ca", sans, time.Now(), jwk)
+		assert.FatalError(t, err)
+		return tok
+	}
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	csr := getCSR(t, priv, func(csr *x509.CertificateRequest) {
+		csr.Subject.CommonName = "Team CA"
+		csr.DNSNames = []string{"ca.team.internal"}
+	})
+
+	// The token cannot be used to sign certificates directly.
+	_, err = a.AuthorizeSign(newToken("Team CA", []string{"ca.team.internal"}))
+	assertError(err, http.StatusForbidden)
+
+	// Request
+	_, err = a.RequestSubCA(context.Background(), csr, newToken("Other CA", []string{"ca.team.internal"}))
+	assertError(err, http.StatusUnauthorized)
+	r1, err := a.RequestSubCA(context.Background(), csr, newToken("Team CA", []string{"ca.team.internal"}))
+	assert.FatalError(t, err)
+	assert.Equals(t, SubCAPending, r1.Status)
+	assert.Equals(t, "team-ca", r1.Provisioner)
+	r2, err := a.RequestSubCA(context.Background(), csr, newToken("Team CA", []string{"ca.team.internal"}))
+	assert.FatalError(t, err)
+
+	// Get
+	got, err := a.GetSubCARequest(r1.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, r1.Subject, got.Subject)
+	_, err = a.GetSubCARequest("foo")
+	assertError(err, http.StatusNotFound)
+	list, err := a.GetSubCARequests(SubCAPending)
+	assert.FatalError(t, err)
+	assert.Len(t, 2, list)
+
+	// Approve
+	r1, err = a.ApproveSubCARequest(r1.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, SubCAApproved, r1.Status)
+	certChain, err := r1.GetCertChain()
+	assert.FatalError(t, err)
+	assert.Len(t, 2, certChain)
+	crt := certChain[0]
+	assert.Equals(t, "Team CA", crt.Subject.CommonName)
+	assert.True(t, crt.IsCA)
+	assert.True(t, crt.MaxPathLenZero)
+	assert.Equals(t, []string{".team.internal"}, crt.PermittedDNSDomains)
+	assert.Equals(t, x509.KeyUsageCertSign|x509.KeyUsageCRLSign|x509.KeyUsageDigitalSignature, crt.KeyUsage)
+	p, err := a.LoadProvisionerByCertificate(crt)
+	assert.FatalError(t, err)
+	assert.Equals(t, provisioner.TypeSubCA, p.GetType())
+	_, err = a.ApproveSubCARequest(r1.ID)
+	assertError(err, http.StatusBadRequest)
+
+	// Reject
+	r2, err = a.RejectSubCARequest(r2.ID)
+	assert.FatalError(t, err)
+	assert.Equals(t, SubCARejected, r2.Status)
+	_, err = a.ApproveSubCARequest(r2.ID)
+	assertError(err, http.StatusBadRequest)
+	_, err = a.RejectSubCARequest("foo")
+	assertError(err, http.StatusNotFound)
+
+	list, err = a.GetSubCARequests("")
+	assert.FatalError(t, err)
+	assert.Len(t, 2, list)
+	list, err = a.GetSubCARequests(SubCAPending)
+	assert.FatalError(t, err)
+	assert.Len(t, 0, list)
+}
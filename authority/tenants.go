@@ -0,0 +1,140 @@
+package authority
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+)
+
+var tenantNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// TenantConfig configures an independent authority hosted by the same CA
+// process. Config is the path to the ca.json of the tenant, with its own roots,
+// intermediate, provisioners and settings. The tenant is served in
+// /tenants/{name}, and in the TLS connections to one of the Hosts, selected
+// by SNI, it's served in the root path with its own TLS certificate. Tenants
+// do not configure a database, they keep their tables in the database of the
+// CA in their own namespace.
+type TenantConfig struct {
+	Name   string   `json:"name"`
+	Config string   `json:"config"`
+	Hosts  []string `json:"hosts,omitempty"`
+}
+
+// Validate checks the fields in TenantConfig.
+func (t *TenantConfig) Validate() error {
+	switch {
+	case t == nil:
+		return errors.New("tenants cannot contain empty values")
+	case !tenantNameRegexp.MatchString(t.Name):
+		return errors.Errorf("tenant name %s is not valid, it can only contain lowercase letters, numbers and dashes", t.Name)
+	case t.Config == "":
+		return errors.Errorf("tenant %s config cannot be empty", t.Name)
+	}
+	for _, h := range t.Hosts {
+		if h == "" || strings.ContainsAny(h, "/: ") {
+			return errors.Errorf("tenant %s host %s is not valid", t.Name, h)
+		}
+	}
+	return nil
+}
+
+// Prefix returns the path where the tenant is served.
+func (t *TenantConfig) Prefix() string {
+	return "/tenants/" + t.Name
+}
+
+// Namespace returns the namespace of the tables of the tenant in the database
+// of the CA.
+func (t *TenantConfig) Namespace() string {
+	return "tenant_" + strings.ReplaceAll(t.Name, "-", "_")
+}
+
+// LoadConfiguration reads and validates the configuration of the tenant. The
+// settings that belong to the CA process, like the database or the additional
+// servers, are not allowed. The hosts of the tenant must be in its dnsNames,
+// and the tokens with the urls of the tenant in the given DNS names of the CA
+// are accepted.
+func (t *TenantConfig) LoadConfiguration(dnsNames []string) (*Config, error) {
+	c, err := LoadConfiguration(t.Config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading tenant %s", t.Name)
+	}
+	switch {
+	case len(c.Tenants) > 0:
+		return nil, errors.Errorf("tenant %s cannot have tenants", t.Name)
+	case c.DB != nil:
+		return nil, errors.Errorf("tenant %s cannot have a db, it uses the db of the ca", t.Name)
+	case c.MetricsAddress != "" || c.GRPCAddress != "":
+		return nil, errors.Errorf("tenant %s cannot have a metricsAddress or a grpcAddress", t.Name)
+	}
+	for _, h := range t.Hosts {
+		if !containsFold(c.DNSNames, h) {
+			return nil, errors.Errorf("tenant %s host %s is not in its dnsNames", t.Name, h)
+		}
+	}
+	for _, name := range dnsNames {
+		c.audienceBases = append(c.audienceBases, name+t.Prefix())
+	}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "error validating tenant %s", t.Name)
+	}
+	return c, nil
+}
+
+// Database returns the database of the tenant in the given database of the
+// CA.
+func (t *TenantConfig) Database(parent db.AuthDB) (db.AuthDB, error) {
+	d, err := db.WithNamespace(parent, t.Namespace())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initializing database of tenant %s", t.Name)
+	}
+	return d, nil
+}
+
+func containsFold(names []string, name string) bool {
+	for _, s := range names {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTenants checks the tenants of the CA, the names and hosts cannot be
+// repeated and the hosts cannot be the DNS names of the CA. The namespace of a
+// tenant cannot be a prefix of the namespace of another one, e.g. a and
+// a-revoked, as the table names would collide: a-revoked + x509_certs and a +
+// revoked_x509_certs.
+func validateTenants(tenants []*TenantConfig, dnsNames []string) error {
+	names := make(map[string]bool, len(tenants))
+	hosts := make(map[string]bool)
+	for _, dns := range dnsNames {
+		hosts[strings.ToLower(dns)] = true
+	}
+	for _, t := range tenants {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if names[t.Name] {
+			return errors.Errorf("tenant %s is duplicated", t.Name)
+		}
+		names[t.Name] = true
+		for _, h := range t.Hosts {
+			if hosts[strings.ToLower(h)] {
+				return errors.Errorf("tenant %s host %s is already in use", t.Name, h)
+			}
+			hosts[strings.ToLower(h)] = true
+		}
+	}
+	for _, t := range tenants {
+		for _, o := range tenants {
+			if t != o && strings.HasPrefix(o.Namespace()+"_", t.Namespace()+"_") {
+				return errors.Errorf("tenant %s cannot be used with tenant %s, their database namespaces overlap", o.Name, t.Name)
+			}
+		}
+	}
+	return nil
+}
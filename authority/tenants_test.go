@@ -0,0 +1,123 @@
+package authority
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/db"
+)
+
+func TestTenantConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *TenantConfig
+		wantErr bool
+	}{
+		{"ok", &TenantConfig{Name: "team-a", Config: "team-a.json", Hosts: []string{"ca.team-a.example.com"}}, false},
+		{"ok/no-hosts", &TenantConfig{Name: "team1", Config: "team1.json"}, false},
+		{"fail/nil", nil, true},
+		{"fail/name", &TenantConfig{Config: "team-a.json"}, true},
+		{"fail/name-chars", &TenantConfig{Name: "Team/A", Config: "team-a.json"}, true},
+		{"fail/config", &TenantConfig{Name: "team-a"}, true},
+		{"fail/host", &TenantConfig{Name: "team-a", Config: "team-a.json", Hosts: []string{"ca.team-a.example.com:443"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("TenantConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateTenants(t *testing.T) {
+	a := &TenantConfig{Name: "team-a", Config: "team-a.json", Hosts: []string{"ca.team-a.example.com"}}
+	b := &TenantConfig{Name: "team-b", Config: "team-b.json", Hosts: []string{"CA.team-a.example.com"}}
+	c := &TenantConfig{Name: "team-a", Config: "team-c.json"}
+	d := &TenantConfig{Name: "team-d", Config: "team-d.json", Hosts: []string{"ca.example.com"}}
+	e := &TenantConfig{Name: "team", Config: "team.json"}
+	f := &TenantConfig{Name: "team-ab", Config: "team-ab.json"}
+	tests := []struct {
+		name    string
+		tenants []*TenantConfig
+		wantErr bool
+	}{
+		{"ok", []*TenantConfig{a}, false},
+		{"ok/empty", nil, false},
+		{"ok/similar", []*TenantConfig{a, f}, false},
+		{"fail/host", []*TenantConfig{a, b}, true},
+		{"fail/name", []*TenantConfig{a, c}, true},
+		{"fail/dnsNames", []*TenantConfig{d}, true},
+		{"fail/namespace", []*TenantConfig{a, e}, true},
+		{"fail/namespace-reverse", []*TenantConfig{e, a}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTenants(tt.tenants, []string{"ca.example.com"}); (err != nil) != tt.wantErr {
+				t.Errorf("validateTenants() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTenantConfig_LoadConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	save := func(name string, fn func(c *Config)) string {
+		c, err := LoadConfiguration("../ca/testdata/ca.json")
+		assert.FatalError(t, err)
+		c.DNSNames = []string{"ca.team-a.example.com"}
+		fn(c)
+		filename := filepath.Join(dir, name)
+		assert.FatalError(t, c.Save(filename))
+		return filename
+	}
+
+	tc := &TenantConfig{Name: "team-a", Config: save("ok.json", func(c *Config) {}), Hosts: []string{"ca.team-a.example.com"}}
+	c, err := tc.LoadConfiguration([]string{"ca.example.com"})
+	assert.FatalError(t, err)
+	audiences := c.getAudiences()
+	assert.True(t, containsAudience(audiences.Sign, "https://ca.team-a.example.com/1.0/sign"))
+	assert.True(t, containsAudience(audiences.Sign, "https://ca.example.com/tenants/team-a/1.0/sign"))
+	assert.False(t, containsAudience(audiences.Sign, "https://ca.example.com/1.0/sign"))
+
+	tests := []struct {
+		name   string
+		tenant *TenantConfig
+	}{
+		{"fail/missing", &TenantConfig{Name: "team-a", Config: filepath.Join(dir, "missing.json")}},
+		{"fail/host", &TenantConfig{Name: "team-a", Config: tc.Config, Hosts: []string{"ca.team-b.example.com"}}},
+		{"fail/db", &TenantConfig{Name: "team-a", Config: save("db.json", func(c *Config) {
+			c.DB = &db.Config{Type: "badgerv2", DataSource: "db"}
+		})}},
+		{"fail/tenants", &TenantConfig{Name: "team-a", Config: save("tenants.json", func(c *Config) {
+			c.Tenants = []*TenantConfig{{Name: "team-b", Config: "team-b.json"}}
+		})}},
+		{"fail/grpcAddress", &TenantConfig{Name: "team-a", Config: save("grpc.json", func(c *Config) {
+			c.GRPCAddress = "127.0.0.1:0"
+		})}},
+		{"fail/validate", &TenantConfig{Name: "team-a", Config: save("invalid.json", func(c *Config) {
+			c.Address = ""
+		})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.tenant.LoadConfiguration([]string{"ca.example.com"})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func containsAudience(audiences []string, aud string) bool {
+	for _, a := range audiences {
+		if a == aud {
+			return true
+		}
+	}
+	return false
+}
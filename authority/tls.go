@@ -2,6 +2,7 @@ package authority
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
@@ -12,13 +13,17 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/ct"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/tlsutil"
 	"github.com/smallstep/cli/crypto/x509util"
 	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ocsp"
 )
 
 // GetTLSOptions returns the tls options configured.
@@ -26,7 +31,10 @@ func (a *Authority) GetTLSOptions() *tlsutil.TLSOptions {
 	return a.config.TLS
 }
 
-var oidAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
+var (
+	oidAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidSubjectKeyIdentifier   = asn1.ObjectIdentifier{2, 5, 29, 14}
+)
 
 func withDefaultASN1DN(def *x509util.ASN1DN) x509util.WithOption {
 	return func(p x509util.Profile) error {
@@ -58,16 +66,71 @@ func withDefaultASN1DN(def *x509util.ASN1DN) x509util.WithOption {
 	}
 }
 
+// getProfile returns the profile with the given name.
+func (a *Authority) getProfile(name string) (*provisioner.Profile, error) {
+	for _, p := range a.config.AuthorityConfig.Profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, errors.Errorf("profile %s not found", name)
+}
+
 // Sign creates a signed certificate from a certificate signing request.
 func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return a.observeSign(csr, true, signOpts, extraOpts...)
+}
+
+// SignVerified creates a signed certificate from a certificate request
+// without checking its signature. It's used by protocols like CMP where the
+// proof of possession of the key is not a PKCS #10 signature; the caller must
+// verify it before calling this method.
+func (a *Authority) SignVerified(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return a.observeSign(csr, false, signOpts, extraOpts...)
+}
+
+// observeSign signs the certificate request and reports the operation.
+func (a *Authority) observeSign(csr *x509.CertificateRequest, checkSignature bool, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	start := time.Now()
+	ctx, span := tracing.Start(findTraceContext(extraOpts), "authority.Sign")
+	certs, err := a.sign(ctx, csr, checkSignature, signOpts, extraOpts...)
+	tracing.End(span, err)
+	var crt *x509.Certificate
+	if err == nil {
+		crt = certs[0]
+	}
+	a.observe(AuditX509Sign, x509ProvisionerName(crt, findAuditToken(extraOpts)), start, err)
+	return certs, err
+}
+
+func (a *Authority) sign(ctx context.Context, csr *x509.CertificateRequest, checkSignature bool, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
 	var (
 		opts           = []interface{}{errs.WithKeyVal("csr", csr), errs.WithKeyVal("signOptions", signOpts)}
 		mods           = []x509util.WithOption{withDefaultASN1DN(a.config.AuthorityConfig.Template)}
 		certValidators = []provisioner.CertificateValidator{}
+		caaOpts        *provisioner.CAAOptions
+		at             *auditToken
 	)
 
-	// Set backdate with the configured value
+	// Set backdate with the configured value, the provisioner can override it.
 	signOpts.Backdate = a.config.AuthorityConfig.Backdate.Duration
+	for _, op := range extraOpts {
+		if b, ok := op.(provisioner.BackdateOption); ok {
+			signOpts.Backdate = b.Backdate()
+		}
+	}
+
+	// Apply the requested profile before the provisioner options, so the
+	// provisioner templates can override it.
+	if signOpts.Profile != "" {
+		p, err := a.getProfile(signOpts.Profile)
+		if err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign", opts...)
+		}
+		p.ApplyDefaults(&signOpts)
+		mods = append(mods, p.Option(signOpts))
+		certValidators = append(certValidators, p)
+	}
 
 	for _, op := range extraOpts {
 		switch k := op.(type) {
@@ -79,16 +142,39 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 			}
 		case provisioner.ProfileModifier:
 			mods = append(mods, k.Option(signOpts))
+		case *provisioner.CAAOptions:
+			caaOpts = k
+		case provisioner.BackdateOption:
+			// already applied
+		case *auditToken:
+			at = k
+		case *traceContext:
+			// already applied
 		default:
 			return nil, errs.InternalServer("authority.Sign; invalid extra option type %T", append([]interface{}{k}, opts...)...)
 		}
 	}
 
-	if err := csr.CheckSignature(); err != nil {
-		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign; invalid certificate request", opts...)
+	if checkSignature {
+		if err := csr.CheckSignature(); err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign; invalid certificate request", opts...)
+		}
+	}
+
+	issuer, signer, err := a.getX509Issuer(signOpts.Intermediate, csr.PublicKey)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign", opts...)
 	}
+	signer = tracing.NewSigner(ctx, "kms.Sign", signer)
 
-	leaf, err := x509util.NewLeafProfileWithCSR(csr, a.x509Issuer, a.x509Signer, mods...)
+	// Add the CRL distribution points if the templates have not set them.
+	mods = append(mods, func(p x509util.Profile) error {
+		crt := p.Subject()
+		crt.CRLDistributionPoints = a.crlDistributionPoints(crt.CRLDistributionPoints, issuer)
+		return nil
+	})
+
+	leaf, err := x509util.NewLeafProfileWithCSR(csr, issuer, signer, mods...)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
 	}
@@ -99,7 +185,49 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 		}
 	}
 
-	crtBytes, err := leaf.CreateCertificate()
+	// Check the names against the authority policy
+	if err := a.getPolicy().AllowX509(leaf.Subject()); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "authority.Sign", opts...)
+	}
+
+	// Short-lived certificates are not stored, they only require a maximum
+	// duration.
+	name, shortLived := a.isShortLived(&x509.Certificate{Extensions: leaf.Subject().ExtraExtensions})
+	if shortLived {
+		if err := a.shortLived.check(leaf.Subject(), signOpts.Backdate); err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign", opts...)
+		}
+	}
+
+	// Check the names against the name constraints of the intermediate
+	if err := policy.CheckNameConstraints(issuer, leaf.Subject()); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "authority.Sign", opts...)
+	}
+
+	// Check that the CAA records of the DNS names authorize the CA
+	if err := a.caa.Check(leaf.Subject().DNSNames, caaOpts); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "authority.Sign", opts...)
+	}
+
+	// Lint the certificate before signing it, the warnings are stored with
+	// the serial number of the certificate.
+	results := a.getLinter(signOpts.Profile).Run(leaf.Subject())
+	if err := results.Err(); err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "authority.Sign", opts...)
+	}
+
+	// Set the serial number after all the checks, so rejected requests do not
+	// consume serial numbers.
+	if err := a.setSerialNumber(leaf.Subject()); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
+	}
+	if !shortLived {
+		if err := a.storeLintResults(leaf.Subject().SerialNumber, results.Warnings()); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Sign", opts...)
+		}
+	}
+
+	crtBytes, err := a.createCertificate(leaf, signer)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err,
 			"authority.Sign; error creating new leaf certificate", opts...)
@@ -111,24 +239,70 @@ func (a *Authority) Sign(csr *x509.CertificateRequest, signOpts provisioner.Opti
 			"authority.Sign; error parsing new leaf certificate", opts...)
 	}
 
-	if err = a.db.StoreCertificate(serverCert); err != nil {
+	if shortLived {
+		a.shortLived.record(name, time.Now().UTC())
+	} else if err = a.traceStoreCertificate(ctx, serverCert); err != nil {
 		if err != db.ErrNotImplemented {
 			return nil, errs.Wrap(http.StatusInternalServerError, err,
 				"authority.Sign; error storing certificate in db", opts...)
 		}
 	}
 
-	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
+	e := at.event(AuditX509Sign)
+	if e.Provisioner == "" {
+		e.Provisioner, _ = provisioner.GetProvisionerName(serverCert)
+	}
+	e.Serial = serverCert.SerialNumber.String()
+	e.Subject = serverCert.Subject.CommonName
+	a.audit.record(e)
+
+	ev := newX509Event(EventCertificateIssued, serverCert)
+	ev.Provisioner = e.Provisioner
+	a.events.publish(ev)
+
+	return []*x509.Certificate{serverCert, a.getX509IssuerChain(issuer)}, nil
 }
 
 // Renew creates a new Certificate identical to the old certificate, except
 // with a validity window that begins 'now'.
 func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error) {
+	start := time.Now()
+	certs, err := a.rekey(oldCert, nil, "authority.Renew")
+	a.observe(AuditX509Renew, x509ProvisionerName(oldCert, nil), start, err)
+	return certs, err
+}
+
+// Rekey creates a new Certificate identical to the old certificate, except
+// with a validity window that begins 'now' and the given public key.
+func (a *Authority) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+	start := time.Now()
+	certs, err := a.rekey(oldCert, pk, "authority.Rekey")
+	a.observe(AuditX509Rekey, x509ProvisionerName(oldCert, nil), start, err)
+	return certs, err
+}
+
+// rekey renews the old certificate, if pk is nil the new certificate will use
+// the public key of the old one.
+func (a *Authority) rekey(oldCert *x509.Certificate, pk crypto.PublicKey, method string) ([]*x509.Certificate, error) {
 	opts := []interface{}{errs.WithKeyVal("serialNumber", oldCert.SerialNumber.String())}
 
 	// Check step provisioner extensions
 	if err := a.authorizeRenew(oldCert); err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew", opts...)
+		return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+	}
+	isRekey := pk != nil
+	if !isRekey {
+		pk = oldCert.PublicKey
+	}
+
+	// Renewals use the intermediate that signed the old certificate, rekeys
+	// the one for the new key.
+	issuer, signer, ok := a.getX509IssuerByKeyID(oldCert.AuthorityKeyId)
+	if isRekey || !ok {
+		var err error
+		if issuer, signer, err = a.getX509Issuer("", pk); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+		}
 	}
 
 	// Durations
@@ -137,8 +311,8 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 	now := time.Now().UTC()
 
 	newCert := &x509.Certificate{
-		PublicKey:                   oldCert.PublicKey,
-		Issuer:                      a.x509Issuer.Subject,
+		PublicKey:                   pk,
+		Issuer:                      issuer.Subject,
 		Subject:                     oldCert.Subject,
 		NotBefore:                   now.Add(-1 * backdate),
 		NotAfter:                    now.Add(duration - backdate),
@@ -165,47 +339,100 @@ func (a *Authority) Renew(oldCert *x509.Certificate) ([]*x509.Certificate, error
 		ExcludedEmailAddresses:      oldCert.ExcludedEmailAddresses,
 		PermittedURIDomains:         oldCert.PermittedURIDomains,
 		ExcludedURIDomains:          oldCert.ExcludedURIDomains,
-		CRLDistributionPoints:       oldCert.CRLDistributionPoints,
+		CRLDistributionPoints:       a.crlDistributionPoints(oldCert.CRLDistributionPoints, issuer),
 		PolicyIdentifiers:           oldCert.PolicyIdentifiers,
 	}
 
 	// Copy all extensions except for Authority Key Identifier. This one might
 	// be different if we rotate the intermediate certificate and it will cause
-	// a TLS bad certificate error.
+	// a TLS bad certificate error. The Subject Key Identifier is also skipped
+	// on rekeys, it's calculated again from the new key. The SCTs of the old
+	// certificate are not valid for the new one.
 	for _, ext := range oldCert.Extensions {
-		if !ext.Id.Equal(oidAuthorityKeyIdentifier) {
-			newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
+		if ext.Id.Equal(oidAuthorityKeyIdentifier) || (isRekey && ext.Id.Equal(oidSubjectKeyIdentifier)) ||
+			ext.Id.Equal(ct.OIDExtensionSCTList) || ext.Id.Equal(ct.OIDExtensionPoison) {
+			continue
+		}
+		newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
+	}
+
+	// Check the names against the authority policy, it might have changed
+	// since the old certificate was signed.
+	if err := a.getPolicy().AllowX509(newCert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, method, opts...)
+	}
+	if err := policy.CheckNameConstraints(issuer, newCert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, method, opts...)
+	}
+	name, shortLived := a.isShortLived(oldCert)
+	if shortLived {
+		if err := a.shortLived.check(newCert, backdate); err != nil {
+			return nil, errs.Wrap(http.StatusBadRequest, err, method, opts...)
 		}
 	}
 
-	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, a.x509Issuer, a.x509Signer)
+	leaf, err := x509util.NewLeafProfileWithTemplate(newCert, issuer, signer)
 	if err != nil {
-		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew", opts...)
+		return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+	}
+
+	// Lint the certificate with the authority lints.
+	results := a.linter.Run(leaf.Subject())
+	if err := results.Err(); err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, method, opts...)
 	}
-	crtBytes, err := leaf.CreateCertificate()
+	if err := a.setSerialNumber(leaf.Subject()); err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+	}
+	if !shortLived {
+		if err := a.storeLintResults(leaf.Subject().SerialNumber, results.Warnings()); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, method, opts...)
+		}
+	}
+	crtBytes, err := a.createCertificate(leaf, signer)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err,
-			"authority.Renew; error renewing certificate from existing server certificate", opts...)
+			method+"; error renewing certificate from existing server certificate", opts...)
 	}
 
 	serverCert, err := x509.ParseCertificate(crtBytes)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err,
-			"authority.Renew; error parsing new server certificate", opts...)
+			method+"; error parsing new server certificate", opts...)
 	}
 
-	if err = a.db.StoreCertificate(serverCert); err != nil {
+	if shortLived {
+		a.shortLived.record(name, now)
+	} else if err = a.storeCertificate(serverCert); err != nil {
 		if err != db.ErrNotImplemented {
-			return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.Renew; error storing certificate in db", opts...)
+			return nil, errs.Wrap(http.StatusInternalServerError, err, method+"; error storing certificate in db", opts...)
 		}
 	}
 
-	return []*x509.Certificate{serverCert, a.x509Issuer}, nil
+	e := &AuditEvent{
+		Action:         AuditX509Renew,
+		Requester:      oldCert.Subject.CommonName,
+		Serial:         serverCert.SerialNumber.String(),
+		PreviousSerial: oldCert.SerialNumber.String(),
+		Subject:        serverCert.Subject.CommonName,
+	}
+	if isRekey {
+		e.Action = AuditX509Rekey
+	}
+	e.Provisioner, _ = provisioner.GetProvisionerName(serverCert)
+	a.audit.record(e)
+
+	ev := newX509Event(EventCertificateRenewed, serverCert)
+	ev.PreviousSerial = e.PreviousSerial
+	a.events.publish(ev)
+
+	return []*x509.Certificate{serverCert, a.getX509IssuerChain(issuer)}, nil
 }
 
 // RevokeOptions are the options for the Revoke API.
 type RevokeOptions struct {
 	Serial      string
+	KeyID       string
 	Reason      string
 	ReasonCode  int
 	PassiveOnly bool
@@ -217,12 +444,36 @@ type RevokeOptions struct {
 // Revoke revokes a certificate.
 //
 // NOTE: Only supports passive revocation - prevent existing certificates from
-// being renewed.
+// being renewed - the CRL if it's configured, and the OCSP responses. The CRL
+// is generated again after an X.509 revocation.
 //
-// TODO: Add OCSP and CRL support.
+// X.509 certificates revoked with the certificateHold reason can be revoked
+// again with a different reason, or released using the removeFromCRL reason
+// with a token of the same provisioner and subject that placed the hold.
+// Administrators can release any certificate on hold using ReleaseHold.
 func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error {
+	start := time.Now()
+	err := a.revoke(ctx, revokeOpts)
+	if a.metrics != nil {
+		operation := AuditX509Revoke
+		if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
+			operation = AuditSSHRevoke
+		}
+		var name string
+		if revokeOpts.MTLS {
+			name = x509ProvisionerName(revokeOpts.Crt, nil)
+		} else {
+			name = a.newAuditToken(revokeOpts.OTT).provisionerName()
+		}
+		a.observe(operation, name, start, err)
+	}
+	return err
+}
+
+func (a *Authority) revoke(ctx context.Context, revokeOpts *RevokeOptions) error {
 	opts := []interface{}{
 		errs.WithKeyVal("serialNumber", revokeOpts.Serial),
+		errs.WithKeyVal("keyID", revokeOpts.KeyID),
 		errs.WithKeyVal("reasonCode", revokeOpts.ReasonCode),
 		errs.WithKeyVal("reason", revokeOpts.Reason),
 		errs.WithKeyVal("passiveOnly", revokeOpts.PassiveOnly),
@@ -237,6 +488,7 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 
 	rci := &db.RevokedCertificateInfo{
 		Serial:     revokeOpts.Serial,
+		KeyID:      revokeOpts.KeyID,
 		ReasonCode: revokeOpts.ReasonCode,
 		Reason:     revokeOpts.Reason,
 		MTLS:       revokeOpts.MTLS,
@@ -260,6 +512,7 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 		if err = token.UnsafeClaimsWithoutVerification(&claims); err != nil {
 			return errs.Wrap(http.StatusUnauthorized, err, "authority.Revoke", opts...)
 		}
+		rci.Subject = claims.Subject
 
 		// This method will also validate the audiences for JWK provisioners.
 		var ok bool
@@ -284,17 +537,45 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 	rci.ProvisionerID = p.GetID()
 	opts = append(opts, errs.WithKeyVal("provisionerID", rci.ProvisionerID))
 
-	if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
+	// Short-lived certificates are not tracked for revocation.
+	if provisioner.MethodFromContext(ctx) != provisioner.SSHRevokeMethod && a.shortLived.enabled(p.GetName()) {
+		return errs.BadRequest("authority.Revoke; certificates issued by the short-lived "+
+			"provisioner %s cannot be revoked", append([]interface{}{p.GetName()}, opts...)...)
+	}
+
+	if rci.ReasonCode == ocsp.RemoveFromCRL {
+		return a.unrevoke(ctx, revokeOpts, rci, p, opts)
+	}
+
+	switch {
+	case provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod && rci.KeyID != "":
+		// An SSHPOP token can only revoke its own certificate.
+		if p.GetType() == provisioner.TypeSSHPOP {
+			return errs.Forbidden("authority.Revoke; sshpop tokens cannot revoke "+
+				"certificates by key id", opts...)
+		}
+		err = a.db.RevokeSSHKeyID(rci)
+	case provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod:
 		err = a.db.RevokeSSH(rci)
-	} else { // default to revoke x509
-		err = a.db.Revoke(rci)
+	default: // default to revoke x509
+		if err = a.db.Revoke(rci); err == nil && a.config.CRL != nil {
+			// Errors are ignored, the CRL is generated again on the next
+			// refresh.
+			a.GenerateCRL()
+		}
 	}
 	switch err {
 	case nil:
+		a.auditRevoke(ctx, revokeOpts, p)
+		a.notifyRevoke(ctx, revokeOpts, p)
 		return nil
 	case db.ErrNotImplemented:
 		return errs.NotImplemented("authority.Revoke; no persistence layer configured", opts...)
 	case db.ErrAlreadyExists:
+		if rci.KeyID != "" {
+			return errs.BadRequest("authority.Revoke; certificates with key id "+
+				"%s have already been revoked", append([]interface{}{rci.KeyID}, opts...)...)
+		}
 		return errs.BadRequest("authority.Revoke; certificate with serial "+
 			"number %s has already been revoked", append([]interface{}{rci.Serial}, opts...)...)
 	default:
@@ -302,9 +583,128 @@ func (a *Authority) Revoke(ctx context.Context, revokeOpts *RevokeOptions) error
 	}
 }
 
+// unrevoke removes an X.509 certificate on hold from the revocation table,
+// the certificates revoked with other reasons cannot be unrevoked. The hold
+// can only be released with a token of the same provisioner and subject that
+// placed it.
+func (a *Authority) unrevoke(ctx context.Context, revokeOpts *RevokeOptions, rci *db.RevokedCertificateInfo, p provisioner.Interface, opts []interface{}) error {
+	switch {
+	case provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod:
+		return errs.BadRequest("authority.Revoke; ssh certificates cannot be "+
+			"removed from the revocation list", opts...)
+	case revokeOpts.MTLS:
+		return errs.Forbidden("authority.Revoke; certificates cannot be "+
+			"removed from the revocation list using mTLS", opts...)
+	}
+
+	held, err := a.loadHeldCertificate("authority.Revoke", revokeOpts.Serial, opts)
+	if err != nil {
+		return err
+	}
+	if held.MTLS || held.ProvisionerID != rci.ProvisionerID || held.Subject != rci.Subject {
+		return errs.Forbidden("authority.Revoke; certificate with serial number %s "+
+			"can only be released by the provisioner and subject that placed the "+
+			"hold, or by an administrator", append([]interface{}{revokeOpts.Serial}, opts...)...)
+	}
+	if err := a.releaseHold("authority.Revoke", revokeOpts.Serial, opts); err != nil {
+		return err
+	}
+	a.auditRevoke(ctx, revokeOpts, p)
+	return nil
+}
+
+// ReleaseHold removes an X.509 certificate on hold from the revocation table.
+// It's used by administrators, and it can release the certificates placed on
+// hold by any provisioner or by a revocation batch.
+func (a *Authority) ReleaseHold(serial string, admin *Admin) error {
+	opts := []interface{}{errs.WithKeyVal("serialNumber", serial)}
+	if _, err := a.loadHeldCertificate("authority.ReleaseHold", serial, opts); err != nil {
+		return err
+	}
+	if err := a.releaseHold("authority.ReleaseHold", serial, opts); err != nil {
+		return err
+	}
+	if a.audit != nil {
+		e := &AuditEvent{
+			Action:     AuditX509Revoke,
+			Serial:     serial,
+			ReasonCode: ocsp.RemoveFromCRL,
+		}
+		if admin != nil {
+			e.Requester = admin.Subject
+		}
+		a.audit.record(e)
+	}
+	return nil
+}
+
+// loadHeldCertificate returns the revocation of the certificate with the given
+// serial number, it fails if the certificate is not on hold.
+func (a *Authority) loadHeldCertificate(op, serial string, opts []interface{}) (*db.RevokedCertificateInfo, error) {
+	rci, err := a.db.GetRevokedCertificate(serial)
+	switch {
+	case err == db.ErrNotImplemented:
+		return nil, errs.NotImplemented(op+"; no persistence layer configured", opts...)
+	case err != nil:
+		return nil, errs.Wrap(http.StatusInternalServerError, err, op, opts...)
+	case rci == nil:
+		return nil, errs.BadRequest(op+"; certificate with serial "+
+			"number %s has not been revoked", append([]interface{}{serial}, opts...)...)
+	case rci.ReasonCode != ocsp.CertificateHold:
+		return nil, errs.BadRequest(op+"; certificate with serial "+
+			"number %s is not on hold", append([]interface{}{serial}, opts...)...)
+	default:
+		return rci, nil
+	}
+}
+
+// releaseHold removes the certificate with the given serial number from the
+// revocation table and generates the CRL again.
+func (a *Authority) releaseHold(op, serial string, opts []interface{}) error {
+	if err := a.db.Unrevoke(serial); err != nil {
+		return errs.Wrap(http.StatusInternalServerError, err, op, opts...)
+	}
+	if a.config.CRL != nil {
+		// Errors are ignored, the CRL is generated again on the next refresh.
+		a.GenerateCRL()
+	}
+	return nil
+}
+
+// auditRevoke records a revocation, or the release of a certificate on hold,
+// in the audit log.
+func (a *Authority) auditRevoke(ctx context.Context, revokeOpts *RevokeOptions, p provisioner.Interface) {
+	if a.audit == nil {
+		return
+	}
+	var e *AuditEvent
+	if revokeOpts.MTLS {
+		e = &AuditEvent{Requester: revokeOpts.Crt.Subject.CommonName}
+	} else {
+		e = a.newAuditToken(revokeOpts.OTT).event("")
+	}
+	e.Action = AuditX509Revoke
+	if provisioner.MethodFromContext(ctx) == provisioner.SSHRevokeMethod {
+		e.Action = AuditSSHRevoke
+	}
+	e.Provisioner = p.GetName()
+	e.Serial = revokeOpts.Serial
+	e.KeyID = revokeOpts.KeyID
+	e.ReasonCode = revokeOpts.ReasonCode
+	e.Reason = revokeOpts.Reason
+	a.audit.record(e)
+}
+
+// IsRevoked returns true if the certificate with the given serial number has
+// been revoked.
+func (a *Authority) IsRevoked(serialNumber string) (bool, error) {
+	return a.db.IsRevoked(serialNumber)
+}
+
 // GetTLSCertificate creates a new leaf certificate to be used by the CA HTTPS server.
 func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
-	profile, err := x509util.NewLeafProfile("Step Online CA", a.x509Issuer, a.x509Signer,
+	issuer, signer := a.GetIntermediate()
+	profile, err := x509util.NewLeafProfile("Step Online CA", issuer, signer,
 		x509util.WithHosts(strings.Join(a.config.DNSNames, ",")))
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
@@ -327,7 +727,7 @@ func (a *Authority) GetTLSCertificate() (*tls.Certificate, error) {
 
 	// Load the x509 key pair (combining server and intermediate blocks)
 	// to a tls.Certificate.
-	intermediatePEM, err := pemutil.Serialize(a.x509Issuer)
+	intermediatePEM, err := pemutil.Serialize(issuer)
 	if err != nil {
 		return nil, errs.Wrap(http.StatusInternalServerError, err, "authority.GetTLSCertificate")
 	}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
@@ -168,6 +169,26 @@ func TestAuthority_Sign(t *testing.T) {
 				code:      http.StatusInternalServerError,
 			}
 		},
+		"fail authority policy": func(t *testing.T) *signTest {
+			_a := testAuthority(t)
+			_a.config.AuthorityConfig.Template = a.config.AuthorityConfig.Template
+			pol, err := policy.New(&policy.Options{
+				X509: &policy.X509Options{
+					Deny: &policy.X509Names{DNS: []string{"*.smallstep.com"}},
+				},
+			})
+			assert.FatalError(t, err)
+			_a.policy = pol
+			csr := getCSR(t, priv)
+			return &signTest{
+				auth:      _a,
+				csr:       csr,
+				extraOpts: extraOpts,
+				signOpts:  signOpts,
+				err:       errors.New("authority.Sign: certificate dns name test.smallstep.com is denied by the policy"),
+				code:      http.StatusForbidden,
+			}
+		},
 		"fail provisioner duration claim": func(t *testing.T) *signTest {
 			csr := getCSR(t, priv)
 			_signOpts := provisioner.Options{
@@ -332,6 +353,238 @@ ZYtQ9Ot36qc=
 	}
 }
 
+func TestAuthority_SignVerified(t *testing.T) {
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+	extraOpts, err := a.Authorize(ctx, token)
+	assert.FatalError(t, err)
+
+	// The signature of the request is not checked.
+	csr := getCSR(t, priv)
+	csr.Signature = []byte("foo")
+	_, err = a.Sign(csr, provisioner.Options{}, extraOpts...)
+	assert.Error(t, err)
+
+	certChain, err := a.SignVerified(csr, provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+	leaf := certChain[0]
+	assert.Equals(t, leaf.PublicKey, pub)
+	assert.Equals(t, leaf.DNSNames, []string{"test.smallstep.com"})
+	assert.Equals(t, leaf.Subject.CommonName, "smallstep test")
+}
+
+func TestAuthority_Sign_nameConstraints(t *testing.T) {
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+	extraOpts, err := a.Authorize(ctx, token)
+	assert.FatalError(t, err)
+
+	rootProfile, err := x509util.NewRootProfile("constrained-root")
+	assert.FatalError(t, err)
+	rootBytes, err := rootProfile.CreateCertificate()
+	assert.FatalError(t, err)
+	rootCert, err := x509.ParseCertificate(rootBytes)
+	assert.FatalError(t, err)
+	setIntermediate := func(nc *policy.NameConstraints) {
+		profile, err := x509util.NewIntermediateProfile("constrained-intermediate", rootCert, rootProfile.SubjectPrivateKey(),
+			func(p x509util.Profile) error {
+				return nc.Apply(p.Subject())
+			})
+		assert.FatalError(t, err)
+		b, err := profile.CreateCertificate()
+		assert.FatalError(t, err)
+		a.x509Issuer, err = x509.ParseCertificate(b)
+		assert.FatalError(t, err)
+		a.x509Signer = profile.SubjectPrivateKey().(crypto.Signer)
+	}
+
+	// Permitted by the intermediate
+	setIntermediate(&policy.NameConstraints{
+		Critical:  true,
+		Permitted: &policy.X509Names{DNS: []string{"smallstep.com"}},
+	})
+	assert.Equals(t, []string{"smallstep.com"}, a.x509Issuer.PermittedDNSDomains)
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+	cert := certChain[0]
+	assert.Equals(t, []string{"test.smallstep.com"}, cert.DNSNames)
+
+	// Not permitted by the new intermediate
+	setIntermediate(&policy.NameConstraints{
+		Excluded: &policy.X509Names{DNS: []string{".smallstep.com"}},
+	})
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusForbidden, sc.StatusCode())
+		assert.HasPrefix(t, err.Error(), "authority.Sign: certificate dns name test.smallstep.com is excluded by the name constraints of the issuer")
+	}
+	_, err = a.Renew(cert)
+	if assert.Error(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusForbidden, sc.StatusCode())
+	}
+}
+
+type testBackdateOption time.Duration
+
+func (o testBackdateOption) Backdate() time.Duration {
+	return time.Duration(o)
+}
+
+func TestAuthority_Sign_backdate(t *testing.T) {
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	authorize := func() []provisioner.SignOption {
+		token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+		assert.FatalError(t, err)
+		ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+		extraOpts, err := a.Authorize(ctx, token)
+		assert.FatalError(t, err)
+		return extraOpts
+	}
+	assertBackdate := func(cert *x509.Certificate, backdate time.Duration) {
+		d := time.Since(cert.NotBefore) - backdate
+		assert.True(t, d >= 0 && d < 10*time.Second, fmt.Sprintf("unexpected notBefore %s", cert.NotBefore))
+	}
+
+	// Authority backdate
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, authorize()...)
+	assert.FatalError(t, err)
+	assertBackdate(certChain[0], a.config.AuthorityConfig.Backdate.Duration)
+
+	// Sign option
+	certChain, err = a.Sign(getCSR(t, priv), provisioner.Options{}, append(authorize(), testBackdateOption(time.Hour))...)
+	assert.FatalError(t, err)
+	assertBackdate(certChain[0], time.Hour)
+
+	// Provisioner claims
+	var jwk *provisioner.JWK
+	for _, p := range a.config.AuthorityConfig.Provisioners {
+		if p.GetName() == "step-cli" {
+			jwk = p.(*provisioner.JWK)
+		}
+	}
+	jwk.Claims = &provisioner.Claims{Backdate: &provisioner.Duration{Duration: 5 * time.Minute}}
+	assert.FatalError(t, jwk.Init(a.provisionerConfig))
+	certChain, err = a.Sign(getCSR(t, priv), provisioner.Options{}, authorize()...)
+	assert.FatalError(t, err)
+	assertBackdate(certChain[0], 5*time.Minute)
+	assert.Equals(t, 5*time.Minute+24*time.Hour, certChain[0].NotAfter.Sub(certChain[0].NotBefore))
+}
+
+func TestAuthority_Sign_profiles(t *testing.T) {
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	a.config.AuthorityConfig.Profiles = []*provisioner.Profile{
+		{
+			Name:            "client",
+			KeyUsage:        []string{"digitalSignature"},
+			ExtKeyUsage:     []string{"clientAuth"},
+			DefaultDuration: &provisioner.Duration{Duration: time.Hour},
+			MaxDuration:     &provisioner.Duration{Duration: 2 * time.Hour},
+		},
+		{
+			Name:        "codeSigning",
+			ExtKeyUsage: []string{"codeSigning"},
+			X509:        &provisioner.TemplateOptions{Template: `{"subject": {"commonName": "signer"}}`},
+		},
+	}
+	for _, p := range a.config.AuthorityConfig.Profiles {
+		assert.FatalError(t, p.Init(a.provisionerConfig))
+	}
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod)
+	extraOpts, err := a.Authorize(ctx, token)
+	assert.FatalError(t, err)
+
+	// Default extensions
+	certChain, err := a.Sign(getCSR(t, priv), provisioner.Options{}, extraOpts...)
+	assert.FatalError(t, err)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, certChain[0].ExtKeyUsage)
+
+	// Provisioners only allow the default profile unless they list others
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "client"}, extraOpts...)
+	assert.Error(t, err)
+	p, err := a.LoadProvisionerByID("step-cli:" + key.KeyID)
+	assert.FatalError(t, err)
+	p.(*provisioner.JWK).Options = &provisioner.CertificateOptions{Profiles: []string{"client", "codeSigning", "foo"}}
+	token, err = generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	extraOpts, err = a.Authorize(ctx, token)
+	assert.FatalError(t, err)
+
+	// Client profile with the default duration
+	certChain, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "client"}, extraOpts...)
+	assert.FatalError(t, err)
+	cert := certChain[0]
+	assert.Equals(t, x509.KeyUsageDigitalSignature, cert.KeyUsage)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	assert.Equals(t, time.Hour+a.config.AuthorityConfig.Backdate.Duration, cert.NotAfter.Sub(cert.NotBefore))
+
+	// Profile template
+	certChain, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "codeSigning"}, extraOpts...)
+	assert.FatalError(t, err)
+	assert.Equals(t, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, certChain[0].ExtKeyUsage)
+	assert.Equals(t, "signer", certChain[0].Subject.CommonName)
+
+	assertError := func(err error, code int) {
+		if assert.Error(t, err) {
+			sc, ok := err.(errs.StatusCoder)
+			assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+			assert.Equals(t, code, sc.StatusCode())
+		}
+	}
+
+	// Profile maximum duration
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{
+		Profile:  "client",
+		NotAfter: provisioner.NewTimeDuration(time.Now().Add(3 * time.Hour)),
+	}, extraOpts...)
+	assertError(err, http.StatusUnauthorized)
+
+	// Unknown profile
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "foo"}, extraOpts...)
+	assertError(err, http.StatusBadRequest)
+
+	// Profiles not allowed by the provisioner
+	p.(*provisioner.JWK).Options = &provisioner.CertificateOptions{Profiles: []string{"client"}}
+	token, err = generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	extraOpts, err = a.Authorize(ctx, token)
+	assert.FatalError(t, err)
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "client"}, extraOpts...)
+	assert.FatalError(t, err)
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{Profile: "codeSigning"}, extraOpts...)
+	assertError(err, http.StatusUnauthorized)
+}
+
 func TestAuthority_Renew(t *testing.T) {
 	pub, _, err := keys.GenerateDefaultKeyPair()
 	assert.FatalError(t, err)
@@ -348,7 +601,7 @@ func TestAuthority_Renew(t *testing.T) {
 
 	now := time.Now().UTC()
 	nb1 := now.Add(-time.Minute * 7)
-	na1 := now
+	na1 := now.Add(time.Minute)
 	so := &provisioner.Options{
 		NotBefore: provisioner.NewTimeDuration(nb1),
 		NotAfter:  provisioner.NewTimeDuration(na1),
@@ -377,6 +630,17 @@ func TestAuthority_Renew(t *testing.T) {
 	certNoRenew, err := x509.ParseCertificate(certBytesNoRenew)
 	assert.FatalError(t, err)
 
+	leafExpired, err := x509util.NewLeafProfile("expired", a.x509Issuer, a.x509Signer,
+		x509util.WithNotBeforeAfterDuration(now.Add(-time.Hour), now.Add(-5*time.Minute), 0),
+		withDefaultASN1DN(a.config.AuthorityConfig.Template),
+		x509util.WithPublicKey(pub), x509util.WithHosts("test.smallstep.com,test"),
+		withProvisionerOID("Max", a.config.AuthorityConfig.Provisioners[0].(*provisioner.JWK).Key.KeyID))
+	assert.FatalError(t, err)
+	certBytesExpired, err := leafExpired.CreateCertificate()
+	assert.FatalError(t, err)
+	certExpired, err := x509.ParseCertificate(certBytesExpired)
+	assert.FatalError(t, err)
+
 	type renewTest struct {
 		auth *Authority
 		cert *x509.Certificate
@@ -407,6 +671,14 @@ func TestAuthority_Renew(t *testing.T) {
 				cert: cert,
 			}, nil
 		},
+		"fail-expired": func() (*renewTest, error) {
+			return &renewTest{
+				auth: a,
+				cert: certExpired,
+				err:  errors.New("authority.Renew: authority.authorizeRenew: jwk.AuthorizeRenew: certificate expired on "),
+				code: http.StatusUnauthorized,
+			}, nil
+		},
 		"success-new-intermediate": func() (*renewTest, error) {
 			newRootProfile, err := x509util.NewRootProfile("new-root")
 			assert.FatalError(t, err)
@@ -541,6 +813,70 @@ func TestAuthority_Renew(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("success-renew-after-expiry", func(t *testing.T) {
+		_a := testAuthority(t)
+		_a.config.AuthorityConfig.Template = a.config.AuthorityConfig.Template
+		p := _a.config.AuthorityConfig.Provisioners[0].(*provisioner.JWK)
+		p.Claims = &provisioner.Claims{
+			AllowRenewalAfterExpiry: &provisioner.Duration{Duration: time.Hour},
+		}
+		assert.FatalError(t, p.Init(provisioner.Config{Claims: globalProvisionerClaims, Audiences: testAudiences}))
+
+		certChain, err := _a.Renew(certExpired)
+		assert.FatalError(t, err)
+		leaf := certChain[0]
+		assert.Equals(t, leaf.NotAfter.Sub(leaf.NotBefore), certExpired.NotAfter.Sub(certExpired.NotBefore))
+		assert.True(t, leaf.NotAfter.After(time.Now()))
+		assert.Equals(t, leaf.DNSNames, certExpired.DNSNames)
+	})
+}
+
+func TestAuthority_Rekey(t *testing.T) {
+	pub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+	newPub, _, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	a := testAuthority(t)
+	now := time.Now().UTC()
+	newLeaf := func(name string, p *provisioner.JWK) *x509.Certificate {
+		leaf, err := x509util.NewLeafProfile(name, a.x509Issuer, a.x509Signer,
+			x509util.WithNotBeforeAfterDuration(now.Add(-7*time.Minute), now.Add(time.Minute), 0),
+			x509util.WithPublicKey(pub), x509util.WithHosts("test.smallstep.com,test"),
+			withProvisionerOID(p.Name, p.Key.KeyID))
+		assert.FatalError(t, err)
+		certBytes, err := leaf.CreateCertificate()
+		assert.FatalError(t, err)
+		cert, err := x509.ParseCertificate(certBytes)
+		assert.FatalError(t, err)
+		return cert
+	}
+	cert := newLeaf("rekey", a.config.AuthorityConfig.Provisioners[0].(*provisioner.JWK))
+	certNoRenew := newLeaf("norenew", a.config.AuthorityConfig.Provisioners[2].(*provisioner.JWK))
+
+	t.Run("ok", func(t *testing.T) {
+		certChain, err := a.Rekey(cert, newPub)
+		assert.FatalError(t, err)
+		leaf := certChain[0]
+		assert.Equals(t, leaf.PublicKey, newPub)
+		assert.Equals(t, leaf.DNSNames, cert.DNSNames)
+		assert.Equals(t, leaf.NotAfter.Sub(leaf.NotBefore), cert.NotAfter.Sub(cert.NotBefore))
+
+		pubBytes, err := x509.MarshalPKIXPublicKey(newPub)
+		assert.FatalError(t, err)
+		hash := sha1.Sum(pubBytes)
+		assert.Equals(t, leaf.SubjectKeyId, hash[:])
+	})
+
+	t.Run("fail-unauthorized", func(t *testing.T) {
+		certChain, err := a.Rekey(certNoRenew, newPub)
+		assert.Nil(t, certChain)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, sc.StatusCode(), http.StatusUnauthorized)
+		assert.HasPrefix(t, err.Error(), "authority.Rekey: authority.authorizeRenew: jwk.AuthorizeRenew; renew is disabled")
+	})
 }
 
 func TestAuthority_GetTLSOptions(t *testing.T) {
@@ -791,3 +1127,173 @@ func TestAuthority_Revoke(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthority_Revoke_unrevoke(t *testing.T) {
+	now := time.Now().UTC()
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+	newToken := func(aud []string) string {
+		raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+			Subject:   "sn",
+			Issuer:    "step-cli",
+			NotBefore: jwt.NewNumericDate(now),
+			Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+			Audience:  aud,
+			ID:        "44",
+		}).CompactSerialize()
+		assert.FatalError(t, err)
+		return raw
+	}
+
+	var unrevoked string
+	newAuthority := func(rci *db.RevokedCertificateInfo, err error) *Authority {
+		return testAuthority(t, WithDatabase(&db.MockAuthDB{
+			MGetRevokedCert: func(sn string) (*db.RevokedCertificateInfo, error) {
+				assert.Equals(t, "sn", sn)
+				return rci, err
+			},
+			MUnrevoke: func(sn string) error {
+				unrevoked = sn
+				return nil
+			},
+			MRevoke: func(rci *db.RevokedCertificateInfo) error {
+				return errors.New("unexpected revocation")
+			},
+		}))
+	}
+	provisionerID := "step-cli:" + jwk.KeyID
+	onHold := &db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, ProvisionerID: provisionerID, Subject: "sn"}
+
+	crt, err := pemutil.ReadCertificate("./testdata/certs/foo.crt")
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name   string
+		auth   *Authority
+		method provisioner.Method
+		opts   *RevokeOptions
+		code   int
+	}{
+		{"ok", newAuthority(onHold, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, 0},
+		{"fail/nil-db", testAuthority(t), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusNotImplemented},
+		{"fail/db", newAuthority(nil, errors.New("force")), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusInternalServerError},
+		{"fail/not-revoked", newAuthority(nil, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusBadRequest},
+		{"fail/not-on-hold", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 1}, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusBadRequest},
+		{"fail/other-provisioner", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, ProvisionerID: "other", Subject: "sn"}, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusForbidden},
+		{"fail/other-subject", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, ProvisionerID: provisionerID, Subject: "other"}, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusForbidden},
+		{"fail/held-with-mTLS", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, ProvisionerID: provisionerID, MTLS: true}, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusForbidden},
+		{"fail/batch", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, BatchID: "batch"}, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.Revoke)}, http.StatusForbidden},
+		{"fail/mTLS", newAuthority(onHold, nil), provisioner.RevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, Crt: crt, MTLS: true}, http.StatusForbidden},
+		{"fail/ssh", newAuthority(onHold, nil), provisioner.SSHRevokeMethod, &RevokeOptions{Serial: "sn", ReasonCode: 8, OTT: newToken(testAudiences.SSHRevoke)}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unrevoked = ""
+			ctx := provisioner.NewContextWithMethod(context.Background(), tt.method)
+			err := tt.auth.Revoke(ctx, tt.opts)
+			if tt.code != 0 {
+				assertStatusCode(t, err, tt.code)
+				assert.Equals(t, "", unrevoked)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, "sn", unrevoked)
+		})
+	}
+}
+
+func TestAuthority_ReleaseHold(t *testing.T) {
+	var unrevoked string
+	newAuthority := func(rci *db.RevokedCertificateInfo, err error) *Authority {
+		return testAuthority(t, WithDatabase(&db.MockAuthDB{
+			MGetRevokedCert: func(sn string) (*db.RevokedCertificateInfo, error) {
+				assert.Equals(t, "sn", sn)
+				return rci, err
+			},
+			MUnrevoke: func(sn string) error {
+				unrevoked = sn
+				return nil
+			},
+		}))
+	}
+	admin := &Admin{Subject: "jane", Role: AdminRoleSuperAdmin}
+
+	tests := []struct {
+		name string
+		auth *Authority
+		code int
+	}{
+		{"ok/provisioner", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, ProvisionerID: "other", Subject: "other"}, nil), 0},
+		{"ok/mTLS", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, MTLS: true}, nil), 0},
+		{"ok/batch", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 6, BatchID: "batch"}, nil), 0},
+		{"fail/nil-db", testAuthority(t), http.StatusNotImplemented},
+		{"fail/db", newAuthority(nil, errors.New("force")), http.StatusInternalServerError},
+		{"fail/not-revoked", newAuthority(nil, nil), http.StatusBadRequest},
+		{"fail/not-on-hold", newAuthority(&db.RevokedCertificateInfo{Serial: "sn", ReasonCode: 1}, nil), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unrevoked = ""
+			err := tt.auth.ReleaseHold("sn", admin)
+			if tt.code != 0 {
+				assertStatusCode(t, err, tt.code)
+				assert.Equals(t, "", unrevoked)
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, "sn", unrevoked)
+		})
+	}
+}
+
+func TestAuthority_Revoke_sshKeyID(t *testing.T) {
+	now := time.Now().UTC()
+	jwk, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", jwk.KeyID))
+	assert.FatalError(t, err)
+	raw, err := jwt.Signed(sig).Claims(jwt.Claims{
+		Subject:   "key-id",
+		Issuer:    "step-cli",
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Minute)),
+		Audience:  testAudiences.SSHRevoke,
+		ID:        "44",
+	}).CompactSerialize()
+	assert.FatalError(t, err)
+
+	var revoked *db.RevokedCertificateInfo
+	newAuthority := func(err error) *Authority {
+		return testAuthority(t, WithDatabase(&db.MockAuthDB{
+			MRevokeSSH: func(rci *db.RevokedCertificateInfo) error {
+				return errors.New("unexpected serial revocation")
+			},
+			MRevokeSSHKeyID: func(rci *db.RevokedCertificateInfo) error {
+				revoked = rci
+				return err
+			},
+		}))
+	}
+	opts := &RevokeOptions{KeyID: "key-id", ReasonCode: 1, Reason: "key compromise", OTT: raw}
+	ctx := provisioner.NewContextWithMethod(context.Background(), provisioner.SSHRevokeMethod)
+
+	assert.FatalError(t, newAuthority(nil).Revoke(ctx, opts))
+	if assert.NotNil(t, revoked) {
+		assert.Equals(t, "", revoked.Serial)
+		assert.Equals(t, "key-id", revoked.KeyID)
+		assert.Equals(t, 1, revoked.ReasonCode)
+		assert.Equals(t, "44", revoked.TokenID)
+	}
+
+	err = newAuthority(db.ErrAlreadyExists).Revoke(ctx, opts)
+	if assert.NotNil(t, err) {
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusBadRequest, sc.StatusCode())
+		assert.HasPrefix(t, err.Error(), "authority.Revoke; certificates with key id key-id have already been revoked")
+	}
+}
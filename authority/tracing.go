@@ -0,0 +1,53 @@
+package authority
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/tracing"
+	"go.opentelemetry.io/otel/api/core"
+	"go.opentelemetry.io/otel/api/key"
+)
+
+// traceContext is the sign option with the context of a traced request. The
+// X.509 signing methods do not have a context, the option is used to create
+// their spans as children of the span of the request.
+type traceContext struct {
+	ctx context.Context
+}
+
+// withTraceContext adds the context to the sign options if it is traced.
+func withTraceContext(signOpts []provisioner.SignOption, ctx context.Context) []provisioner.SignOption {
+	if !tracing.IsTraced(ctx) {
+		return signOpts
+	}
+	return append(signOpts, &traceContext{ctx: ctx})
+}
+
+// findTraceContext returns the context in the sign options, or an empty
+// context if the request is not traced.
+func findTraceContext(signOpts []provisioner.SignOption) context.Context {
+	for _, op := range signOpts {
+		if tc, ok := op.(*traceContext); ok {
+			return tc.ctx
+		}
+	}
+	return context.Background()
+}
+
+// provisionerAttributes returns the span attributes of a provisioner.
+func provisionerAttributes(p provisioner.Interface) []core.KeyValue {
+	return []core.KeyValue{
+		key.String("provisioner.name", p.GetName()),
+		key.String("provisioner.type", p.GetType().String()),
+	}
+}
+
+// traceStoreCertificate stores the certificate in a span of the database.
+func (a *Authority) traceStoreCertificate(ctx context.Context, cert *x509.Certificate) error {
+	_, span := tracing.Start(ctx, "db.StoreCertificate")
+	err := a.storeCertificate(cert)
+	tracing.End(span, err)
+	return err
+}
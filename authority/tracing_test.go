@@ -0,0 +1,85 @@
+package authority
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/tracing"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/jose"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type spanRecorder struct {
+	spans []*export.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(ctx context.Context, s *export.SpanData) {
+	r.spans = append(r.spans, s)
+}
+
+func TestAuthority_tracing(t *testing.T) {
+	rec := new(spanRecorder)
+	tp, err := sdktrace.NewProvider(sdktrace.WithSyncer(rec))
+	assert.FatalError(t, err)
+	global.SetTraceProvider(tp)
+	defer global.SetTraceProvider(trace.NoopProvider{})
+
+	a := testAuthority(t)
+	a.db = &db.MockAuthDB{
+		MUseToken: func(id, tok string) (bool, error) {
+			return true, nil
+		},
+	}
+
+	key, err := jose.ParseKey("testdata/secrets/step_cli_key_priv.jwk", jose.WithPassword([]byte("pass")))
+	assert.FatalError(t, err)
+	token, err := generateToken("smallstep test", "step-cli", testAudiences.Sign[0], []string{"test.smallstep.com"}, time.Now(), key)
+	assert.FatalError(t, err)
+	_, priv, err := keys.GenerateDefaultKeyPair()
+	assert.FatalError(t, err)
+
+	ctx, span := tracing.Start(context.Background(), "POST /1.0/sign")
+	signOpts, err := a.Authorize(provisioner.NewContextWithMethod(ctx, provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	_, err = a.Sign(getCSR(t, priv), provisioner.Options{}, signOpts...)
+	assert.FatalError(t, err)
+	span.End()
+
+	// Spans are exported when they end, the children before the parents.
+	names := map[string]*export.SpanData{}
+	for _, s := range rec.spans {
+		names[s.Name] = s
+	}
+	parents := map[string]string{
+		"db.UseToken":               "authority.Authorize",
+		"provisioner.AuthorizeSign": "authority.Authorize",
+		"authority.Authorize":       "POST /1.0/sign",
+		"kms.Sign":                  "authority.Sign",
+		"db.StoreCertificate":       "authority.Sign",
+		"authority.Sign":            "POST /1.0/sign",
+	}
+	assert.Len(t, len(parents)+1, rec.spans)
+	for child, parent := range parents {
+		c, ok := names[child]
+		assert.Fatal(t, ok, child+" not found")
+		assert.Equals(t, names[parent].SpanContext.SpanID, c.ParentSpanID)
+		assert.Equals(t, names[parent].SpanContext.TraceID, c.SpanContext.TraceID)
+	}
+	assert.Equals(t, "step-cli", names["provisioner.AuthorizeSign"].Attributes[0].Value.AsString())
+
+	// Requests without a span are not propagated to the signature.
+	signOpts, err = a.Authorize(provisioner.NewContextWithMethod(context.Background(), provisioner.SignMethod), token)
+	assert.FatalError(t, err)
+	for _, op := range signOpts {
+		_, ok := op.(*traceContext)
+		assert.False(t, ok)
+	}
+}
@@ -1,13 +1,16 @@
 package ca
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
+	"sync"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
@@ -15,17 +18,28 @@ import (
 	acmeAPI "github.com/smallstep/certificates/acme/api"
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/cmp"
+	cmpAPI "github.com/smallstep/certificates/cmp/api"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/est"
+	estAPI "github.com/smallstep/certificates/est/api"
+	"github.com/smallstep/certificates/grpcapi"
 	"github.com/smallstep/certificates/logging"
 	"github.com/smallstep/certificates/monitoring"
+	"github.com/smallstep/certificates/scep"
+	scepAPI "github.com/smallstep/certificates/scep/api"
 	"github.com/smallstep/certificates/server"
+	"github.com/smallstep/certificates/tracing"
 	"github.com/smallstep/nosql"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type options struct {
 	configFile string
 	password   []byte
 	database   db.AuthDB
+	metrics    *monitoring.CAMetrics
 }
 
 func (o *options) apply(opts []Option) {
@@ -60,21 +74,42 @@ func WithDatabase(db db.AuthDB) Option {
 	}
 }
 
+// WithMetrics sets the metrics of the CA, it is used on reloads to keep the
+// counters of the previous CA.
+func WithMetrics(m *monitoring.CAMetrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
 // CA is the type used to build the complete certificate authority. It builds
 // the HTTP server, set ups the middlewares and the HTTP handlers.
 type CA struct {
-	auth    *authority.Authority
-	config  *authority.Config
-	srv     *server.Server
-	opts    *options
-	renewer *TLSRenewer
+	auth        *authority.Authority
+	tenants     []*tenant
+	config      *authority.Config
+	srv         *server.Server
+	metricsSrv  *server.Server
+	grpcSrv     *grpc.Server
+	grpcAPI     *grpcapi.Server
+	grpcTLS     *tls.Config
+	grpcMutex   sync.RWMutex
+	watcher     *configWatcher
+	reloadMutex sync.Mutex
+	tracer      *tracing.Tracer
+	logger      *logging.Logger
+	opts        *options
+	renewer     *TLSRenewer
+	gc          *garbageCollector
+	stopped     chan struct{}
 }
 
 // New creates and initializes the CA with the given configuration and options.
 func New(config *authority.Config, opts ...Option) (*CA, error) {
 	ca := &CA{
-		config: config,
-		opts:   new(options),
+		config:  config,
+		opts:    new(options),
+		stopped: make(chan struct{}),
 	}
 	ca.opts.apply(opts)
 	return ca.Init(config)
@@ -86,10 +121,26 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 		ca.config.Password = string(ca.opts.password)
 	}
 
+	// Export the traces if configured, the spans of the authority use the
+	// global provider.
+	var tracer *tracing.Tracer
+	if config.Tracing != nil {
+		var err error
+		if tracer, err = tracing.New(config.Tracing); err != nil {
+			return nil, err
+		}
+	}
+
 	var opts []authority.Option
 	if ca.opts.database != nil {
 		opts = append(opts, authority.WithDatabase(ca.opts.database))
 	}
+	if config.MetricsAddress != "" {
+		if ca.opts.metrics == nil {
+			ca.opts.metrics = monitoring.NewCAMetrics()
+		}
+		opts = append(opts, authority.WithMetrics(ca.opts.metrics))
+	}
 
 	auth, err := authority.New(config, opts...)
 	if err != nil {
@@ -101,18 +152,7 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 		return nil, err
 	}
 
-	// Using chi as the main router
-	mux := chi.NewRouter()
-	handler := http.Handler(mux)
-
-	// Add regular CA api endpoints in / and /1.0
-	routerHandler := api.New(auth)
-	routerHandler.Route(mux)
-	mux.Route("/1.0", func(r chi.Router) {
-		routerHandler.Route(r)
-	})
-
-	//Add ACME api endpoints in /acme and /1.0/acme
+	// The ACME links use the first DNS name and the port if it's not 443.
 	dns := config.DNSNames[0]
 	u, err := url.Parse("https://" + config.Address)
 	if err != nil {
@@ -123,20 +163,48 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 		dns = fmt.Sprintf("%s:%s", dns, port)
 	}
 
-	prefix := "acme"
-	acmeAuth, err := acme.NewAuthority(auth.GetDatabase().(nosql.DB), dns, prefix, auth)
+	// Initialize the tenants, they are served with their own TLS
+	// configuration in their hosts.
+	tenants, err := ca.initTenants(config, auth, config.DNSNames[0], port)
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating ACME authority")
+		return nil, err
+	}
+	if len(tenants) > 0 {
+		tlsConfig.GetConfigForClient = getTenantTLSConfig(tenants)
+	}
+
+	// Using chi as the main router
+	mux := chi.NewRouter()
+	handler := http.Handler(mux)
+	if tracer != nil {
+		mux.Use(tracer.Middleware)
+	}
+	if ca.opts.metrics != nil {
+		mux.Use(ca.opts.metrics.Middleware)
+	}
+	if len(tenants) > 0 {
+		mux.Use(tenantMiddleware(tenants))
+	}
+
+	acmeAuth, err := routeAuthority(mux, auth, dns, "acme")
+	if err != nil {
+		stopTenants(tenants)
+		return nil, err
+	}
+	// Start the garbage collection of the database if configured.
+	if ca.gc != nil {
+		ca.gc.Stop()
+		ca.gc = nil
+	}
+	if config.GC != nil {
+		ca.gc = newGarbageCollector(auth, acmeAuth, config.GC)
+		ca.gc.Run()
+	}
+
+	// Add the tenants in /tenants/{name}
+	for _, t := range tenants {
+		mux.Mount(t.config.Prefix(), t.handler)
 	}
-	acmeRouterHandler := acmeAPI.New(acmeAuth)
-	mux.Route("/"+prefix, func(r chi.Router) {
-		acmeRouterHandler.Route(r)
-	})
-	// Use 2.0 because, at the moment, our ACME api is only compatible with v2.0
-	// of the ACME spec.
-	mux.Route("/2.0/"+prefix, func(r chi.Router) {
-		acmeRouterHandler.Route(r)
-	})
 
 	/*
 		// helpful routine for logging all routes //
@@ -159,40 +227,258 @@ func (ca *CA) Init(config *authority.Config) (*CA, error) {
 	}
 
 	// Add logger if configured
+	var logger *logging.Logger
 	if len(config.Logger) > 0 {
-		logger, err := logging.New("ca", config.Logger)
-		if err != nil {
+		var err error
+		if logger, err = logging.New("ca", config.Logger); err != nil {
 			return nil, err
 		}
 		handler = logger.Middleware(handler)
 	}
 
+	// Add metrics server if configured
+	if config.MetricsAddress != "" {
+		m, err := monitoring.NewMetrics(
+			monitoring.NewKeyStoreCollector(auth.GetKeyStoreProvisioners),
+			monitoring.NewCertificateCollector(ca.renewer.getLeaf),
+			ca.opts.metrics,
+		)
+		if err != nil {
+			return nil, err
+		}
+		metricsMux := chi.NewRouter()
+		metricsMux.Method("GET", "/metrics", m.Handler())
+		ca.metricsSrv = server.New(config.MetricsAddress, metricsMux, nil)
+	}
+
+	// Add gRPC server if configured, the TLS configuration is replaced on
+	// reloads, so it's obtained on every connection.
+	if config.GRPCAddress != "" {
+		ca.grpcTLS = grpcTLSConfig(tlsConfig)
+		ca.grpcAPI = grpcapi.NewServer(auth)
+		ca.grpcSrv = grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+			GetConfigForClient: ca.getGRPCTLSConfig,
+		})))
+		grpcapi.RegisterCertificateAuthorityServer(ca.grpcSrv, ca.grpcAPI)
+	}
+
 	ca.auth = auth
+	ca.tenants = tenants
+	ca.tracer = tracer
+	ca.logger = logger
 	ca.srv = server.New(config.Address, handler, tlsConfig)
 	return ca, nil
 }
 
-// Run starts the CA calling to the server ListenAndServe method.
+// routeAuthority adds the endpoints of the CA, ACME, SCEP, EST and CMP apis
+// of the authority to the router, and returns the ACME authority. The dns and
+// the acmePrefix are used to build the ACME links.
+func routeAuthority(mux chi.Router, auth *authority.Authority, dns, acmePrefix string) (*acme.Authority, error) {
+	// Add regular CA api endpoints in / and /1.0
+	routerHandler := api.New(auth)
+	routerHandler.Route(mux)
+	mux.Route("/1.0", func(r chi.Router) {
+		routerHandler.Route(r)
+	})
+
+	//Add ACME api endpoints in /acme and /2.0/acme
+	acmeAuth, err := acme.NewAuthority(auth.GetDatabase().(nosql.DB), dns, acmePrefix, auth,
+		acme.WithOrderFailedFunc(auth.NotifyACMEOrderFailed))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating ACME authority")
+	}
+	acmeRouterHandler := acmeAPI.New(acmeAuth)
+	mux.Route("/acme", func(r chi.Router) {
+		acmeRouterHandler.Route(r)
+	})
+	// Use 2.0 because, at the moment, our ACME api is only compatible with v2.0
+	// of the ACME spec.
+	mux.Route("/2.0/acme", func(r chi.Router) {
+		acmeRouterHandler.Route(r)
+	})
+
+	// Add SCEP api endpoints in /scep
+	scepRouterHandler := scepAPI.New(scep.NewAuthority(auth))
+	mux.Route("/scep", func(r chi.Router) {
+		scepRouterHandler.Route(r)
+	})
+
+	// Add EST api endpoints in /.well-known/est
+	estRouterHandler := estAPI.New(est.NewAuthority(auth))
+	mux.Route("/.well-known/est", func(r chi.Router) {
+		estRouterHandler.Route(r)
+	})
+
+	// Add CMP api endpoints in /cmp
+	cmpRouterHandler := cmpAPI.New(cmp.NewAuthority(auth))
+	mux.Route("/cmp", func(r chi.Router) {
+		cmpRouterHandler.Route(r)
+	})
+
+	return acmeAuth, nil
+}
+
+// Run starts the CA calling to the server ListenAndServe method. If the CA is
+// started with systemd socket activation, the servers use the sockets named
+// "ca", "metrics" and "grpc", or the only socket if it does not have one of
+// those names. After the CA is stopped, Run returns when the shutdown is
+// completed.
 func (ca *CA) Run() error {
-	return ca.srv.ListenAndServe()
+	listeners, err := server.SystemdListeners()
+	if err != nil {
+		return err
+	}
+	caLn, metricsLn, grpcLn := listeners["ca"], listeners["metrics"], listeners["grpc"]
+	if caLn == nil && metricsLn == nil && grpcLn == nil && len(listeners) == 1 {
+		for _, ln := range listeners {
+			caLn = ln
+		}
+	}
+	for name, ln := range listeners {
+		if ln != caLn && (ln != metricsLn || ca.metricsSrv == nil) && (ln != grpcLn || ca.grpcSrv == nil) {
+			log.Printf("systemd socket %s is not used", name)
+			ln.Close()
+		}
+	}
+
+	if ca.metricsSrv != nil {
+		go func() {
+			var err error
+			if metricsLn != nil {
+				err = ca.metricsSrv.Serve(metricsLn)
+			} else {
+				err = ca.metricsSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("error serving metrics: %+v\n", err)
+			}
+		}()
+	}
+	if ca.grpcSrv != nil {
+		go func() {
+			if err := ca.serveGRPC(grpcLn); err != nil {
+				log.Printf("error serving gRPC: %+v\n", err)
+			}
+		}()
+	}
+	if caLn != nil {
+		err = ca.srv.Serve(caLn)
+	} else {
+		err = ca.srv.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		<-ca.stopped
+	}
+	return err
+}
+
+// serveGRPC starts the gRPC server in the given listener, or in the
+// configured address if it's nil.
+func (ca *CA) serveGRPC(ln net.Listener) error {
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", ca.config.GRPCAddress); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return ca.grpcSrv.Serve(ln)
+}
+
+// stopGRPC gracefully stops the gRPC server. The streams are long-lived, so
+// when the context is done the remaining connections are closed.
+func (ca *CA) stopGRPC(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		ca.grpcSrv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		ca.grpcSrv.Stop()
+	}
+}
+
+// getGRPCTLSConfig returns the current TLS configuration of the gRPC server.
+func (ca *CA) getGRPCTLSConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	ca.grpcMutex.RLock()
+	defer ca.grpcMutex.RUnlock()
+	return ca.grpcTLS, nil
 }
 
-// Stop stops the CA calling to the server Shutdown method.
+// grpcTLSConfig returns a copy of the TLS configuration of the CA server that
+// negotiates HTTP/2, required by gRPC.
+func grpcTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	c := tlsConfig.Clone()
+	c.NextProtos = []string{"h2"}
+	c.GetConfigForClient = nil
+	return c
+}
+
+// Stop gracefully stops the CA. The servers stop accepting connections and
+// wait for the active requests until the shutdownTimeout in the configuration,
+// then the remaining connections are closed. After that, the background tasks
+// are stopped and the authorities are shut down, writing the pending events of
+// the audit log before closing the database.
 func (ca *CA) Stop() error {
+	if ca.watcher != nil {
+		if err := ca.watcher.Stop(); err != nil {
+			log.Printf("error stopping configuration watcher: %+v\n", err)
+		}
+	}
+
+	// Drain all the servers at the same time.
+	ctx, cancel := context.WithTimeout(context.Background(), ca.config.GetShutdownTimeout())
+	defer cancel()
+	var wg sync.WaitGroup
+	if ca.metricsSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ca.metricsSrv.ShutdownContext(ctx); err != nil {
+				log.Printf("error stopping metrics server: %+v\n", err)
+			}
+		}()
+	}
+	if ca.grpcSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ca.stopGRPC(ctx)
+		}()
+	}
+	err := ca.srv.ShutdownContext(ctx)
+	wg.Wait()
+
 	ca.renewer.Stop()
+	if ca.gc != nil {
+		ca.gc.Stop()
+	}
+	shutdownTenants(ca.tenants)
 	if err := ca.auth.Shutdown(); err != nil {
 		log.Printf("error stopping ca.Authority: %+v\n", err)
 	}
-	return ca.srv.Shutdown()
+	if ca.tracer != nil {
+		if err := ca.tracer.Stop(); err != nil {
+			log.Printf("error stopping tracer: %+v\n", err)
+		}
+	}
+	if ca.logger != nil {
+		if err := ca.logger.Close(); err != nil {
+			log.Printf("error closing logger: %+v\n", err)
+		}
+	}
+	close(ca.stopped)
+	return err
 }
 
 // Reload reloads the configuration of the CA and calls to the server Reload
-// method.
+// method. The new configuration is validated and initialized before replacing
+// the current one, if any step fails the CA continues running with the
+// previous configuration.
 func (ca *CA) Reload() error {
-	config, err := authority.LoadConfiguration(ca.opts.configFile)
-	if err != nil {
-		return errors.Wrap(err, "error reloading ca configuration")
-	}
+	ca.reloadMutex.Lock()
+	defer ca.reloadMutex.Unlock()
 
 	logContinue := func(reason string) {
 		log.Println(reason)
@@ -200,62 +486,179 @@ func (ca *CA) Reload() error {
 		log.Println("You can force a restart by sending a SIGTERM signal and then restarting the step-ca.")
 	}
 
+	config, err := authority.LoadConfiguration(ca.opts.configFile)
+	if err != nil {
+		logContinue("Reload failed because the configuration could not be read.")
+		return errors.Wrap(err, "error reloading ca configuration")
+	}
+
+	// Validate the configuration before doing any change.
+	if err := config.Validate(); err != nil {
+		logContinue("Reload failed because the configuration is not valid.")
+		return errors.Wrap(err, "error reloading ca: invalid configuration")
+	}
+
 	// Do not allow reload if the database configuration has changed.
 	if !reflect.DeepEqual(ca.config.DB, config.DB) {
 		logContinue("Reload failed because the database configuration has changed.")
 		return errors.New("error reloading ca: database configuration cannot change")
 	}
 
+	// Do not allow reload if the metrics server is enabled or disabled.
+	if (ca.config.MetricsAddress == "") != (config.MetricsAddress == "") {
+		logContinue("Reload failed because the metrics configuration has changed.")
+		return errors.New("error reloading ca: metricsAddress cannot be added or removed")
+	}
+
+	// Do not allow reload if the gRPC address has changed.
+	if ca.config.GRPCAddress != config.GRPCAddress {
+		logContinue("Reload failed because the gRPC configuration has changed.")
+		return errors.New("error reloading ca: grpcAddress cannot change")
+	}
+
 	newCA, err := New(config,
 		WithPassword(ca.opts.password),
 		WithConfigFile(ca.opts.configFile),
 		WithDatabase(ca.auth.GetDatabase()),
+		WithMetrics(ca.opts.metrics),
 	)
 	if err != nil {
 		logContinue("Reload failed because the CA with new configuration could not be initialized.")
 		return errors.Wrap(err, "error reloading ca")
 	}
 
+	// Keep the current server to roll back if the metrics server cannot be
+	// replaced.
+	prevSrv := ca.srv.Server
 	if err = ca.srv.Reload(newCA.srv); err != nil {
+		newCA.stopBackground()
 		logContinue("Reload failed because server could not be replaced.")
 		return errors.Wrap(err, "error reloading server")
 	}
 
-	// 1. Stop previous renewer
+	if ca.metricsSrv != nil {
+		if err = ca.metricsSrv.Reload(newCA.metricsSrv); err != nil {
+			if err := ca.srv.Reload(server.New(prevSrv.Addr, prevSrv.Handler, prevSrv.TLSConfig)); err != nil {
+				log.Printf("error restoring previous server: %+v\n", err)
+			}
+			newCA.stopBackground()
+			logContinue("Reload failed because metrics server could not be replaced.")
+			return errors.Wrap(err, "error reloading metrics server")
+		}
+	}
+
+	if ca.grpcSrv != nil {
+		ca.grpcAPI.Reload(newCA.auth)
+		ca.grpcMutex.Lock()
+		ca.grpcTLS = newCA.grpcTLS
+		ca.grpcMutex.Unlock()
+	}
+
+	// 1. Stop previous renewer, CRL generator, expiration notifier, events
+	// and garbage collector
 	// 2. Replace ca properties
 	// Do not replace ca.srv
-	ca.renewer.Stop()
+	ca.stopBackground()
 	ca.auth = newCA.auth
+	ca.tenants = newCA.tenants
+	ca.tracer = newCA.tracer
+	ca.logger = newCA.logger
 	ca.config = newCA.config
 	ca.opts = newCA.opts
 	ca.renewer = newCA.renewer
+	ca.gc = newCA.gc
 	return nil
 }
 
-// getTLSConfig returns a TLSConfig for the CA server with a self-renewing
-// server certificate.
-func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
-	// Create initial TLS certificate
-	tlsCrt, err := auth.GetTLSCertificate()
+// WatchConfig reloads the CA when the configuration file changes, in addition
+// to the reloads on SIGHUP. The watcher is stopped with the CA.
+func (ca *CA) WatchConfig() error {
+	if ca.opts.configFile == "" {
+		return errors.New("error watching ca configuration: configuration file is not set")
+	}
+	w, err := newConfigWatcher(ca.opts.configFile, configWatchDelay, func() {
+		log.Println("configuration changed, reloading ...")
+		if err := ca.Reload(); err != nil {
+			log.Printf("error reloading ca: %+v\n", err)
+		}
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
+	ca.watcher = w
+	return nil
+}
 
-	// Start tls renewer with the new certificate.
+// stopBackground stops the renewer, CRL generator, expiration notifier,
+// events, garbage collector, tenants, tracer and logger of the CA. The
+// database and the servers are kept, it's used to discard a CA on reloads.
+func (ca *CA) stopBackground() {
+	ca.renewer.Stop()
+	stopAuthority(ca.auth)
+	stopTenants(ca.tenants)
+	if ca.gc != nil {
+		ca.gc.Stop()
+	}
+	if ca.tracer != nil {
+		if err := ca.tracer.Stop(); err != nil {
+			log.Printf("error stopping tracer: %+v\n", err)
+		}
+	}
+	if ca.logger != nil {
+		if err := ca.logger.Close(); err != nil {
+			log.Printf("error closing logger: %+v\n", err)
+		}
+	}
+}
+
+// stopAuthority stops the CRL generator, expiration notifier, federation,
+// cluster synchronization, provisioners and events of an authority.
+func stopAuthority(auth *authority.Authority) {
+	auth.StopCRLGenerator()
+	auth.StopExpirationNotifier()
+	auth.StopFederation()
+	auth.StopCluster()
+	auth.StopProvisioners()
+	if err := auth.StopEvents(); err != nil {
+		log.Printf("error stopping events: %+v\n", err)
+	}
+}
+
+// getTLSConfig returns a TLSConfig for the CA server with a self-renewing
+// server certificate.
+func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
 	// If a renewer was started, attempt to stop it before.
 	if ca.renewer != nil {
 		ca.renewer.Stop()
 	}
 
-	ca.renewer, err = NewTLSRenewer(tlsCrt, auth.GetTLSCertificate)
+	tlsConfig, renewer, err := newTLSConfig(ca.config, auth)
 	if err != nil {
 		return nil, err
 	}
-	ca.renewer.Run()
+	ca.renewer = renewer
+	return tlsConfig, nil
+}
+
+// newTLSConfig returns a TLSConfig for the given configuration and authority,
+// and the renewer of its server certificate.
+func newTLSConfig(config *authority.Config, auth *authority.Authority) (*tls.Config, *TLSRenewer, error) {
+	// Create initial TLS certificate
+	tlsCrt, err := auth.GetTLSCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Start tls renewer with the new certificate.
+	renewer, err := NewTLSRenewer(tlsCrt, auth.GetTLSCertificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	renewer.Run()
 
 	var tlsConfig *tls.Config
-	if ca.config.TLS != nil {
-		tlsConfig = ca.config.TLS.TLSConfig()
+	if config.TLS != nil {
+		tlsConfig = config.TLS.TLSConfig()
 	} else {
 		tlsConfig = &tls.Config{
 			MinVersion: tls.VersionTLS12,
@@ -275,7 +678,7 @@ func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
 	// empty we are implicitly forcing GetCertificate to be the only mechanism
 	// by which the server can find it's own leaf Certificate.
 	tlsConfig.Certificates = []tls.Certificate{}
-	tlsConfig.GetCertificate = ca.renewer.GetCertificateForCA
+	tlsConfig.GetCertificate = renewer.GetCertificateForCA
 
 	// Add support for mutual tls to renew certificates
 	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
@@ -284,5 +687,5 @@ func (ca *CA) getTLSConfig(auth *authority.Authority) (*tls.Config, error) {
 	// Use server's most preferred ciphersuite
 	tlsConfig.PreferServerCipherSuites = true
 
-	return tlsConfig, nil
+	return tlsConfig, renewer, nil
 }
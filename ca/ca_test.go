@@ -2,6 +2,7 @@ package ca
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/tls"
@@ -10,9 +11,12 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -22,12 +26,16 @@ import (
 	"github.com/smallstep/certificates/api"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/db"
 	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/grpcapi"
 	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/crypto/randutil"
 	"github.com/smallstep/cli/crypto/x509util"
 	stepJOSE "github.com/smallstep/cli/jose"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	jose "gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
@@ -496,6 +504,161 @@ func TestCARoot(t *testing.T) {
 	}
 }
 
+func TestCAGRPC(t *testing.T) {
+	config, err := authority.LoadConfiguration("testdata/ca.json")
+	assert.FatalError(t, err)
+	config.GRPCAddress = "127.0.0.1:0"
+	ca, err := New(config)
+	assert.FatalError(t, err)
+
+	rootCrt, err := pemutil.ReadCertificate("testdata/secrets/root_ca.crt")
+	assert.FatalError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(rootCrt)
+
+	ln, err := net.Listen("tcp", config.GRPCAddress)
+	assert.FatalError(t, err)
+	go ca.grpcSrv.Serve(ln)
+	defer ca.grpcSrv.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:    pool,
+		ServerName: "127.0.0.1",
+	})))
+	assert.FatalError(t, err)
+	defer conn.Close()
+
+	resp, err := grpcapi.NewCertificateAuthorityClient(conn).Roots(context.Background(), &grpcapi.RootsRequest{})
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, resp.Certificates) {
+		assert.Equals(t, rootCrt.Raw, resp.Certificates[0])
+	}
+}
+
+func TestCAReload(t *testing.T) {
+	config, err := authority.LoadConfiguration("testdata/ca.json")
+	assert.FatalError(t, err)
+	ca, err := New(config)
+	assert.FatalError(t, err)
+
+	dir, err := ioutil.TempDir("", "reload")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	ca.opts.configFile = filepath.Join(dir, "ca.json")
+
+	writeConfig := func(fn func(c *authority.Config)) {
+		c, err := authority.LoadConfiguration("testdata/ca.json")
+		assert.FatalError(t, err)
+		fn(c)
+		assert.FatalError(t, c.Save(ca.opts.configFile))
+	}
+
+	tests := []struct {
+		name   string
+		write  func()
+		errMsg string
+	}{
+		{"fail/parse", func() {
+			assert.FatalError(t, ioutil.WriteFile(ca.opts.configFile, []byte(`{"address":`), 0600))
+		}, "error reloading ca configuration: error parsing"},
+		{"fail/validate", func() {
+			writeConfig(func(c *authority.Config) { c.Address = "127.0.0.1" })
+		}, "error reloading ca: invalid configuration: invalid address 127.0.0.1"},
+		{"fail/db", func() {
+			writeConfig(func(c *authority.Config) { c.DB = &db.Config{Type: "badger", DataSource: dir} })
+		}, "error reloading ca: database configuration cannot change"},
+		{"fail/grpc", func() {
+			writeConfig(func(c *authority.Config) { c.GRPCAddress = "127.0.0.1:0" })
+		}, "error reloading ca: grpcAddress cannot change"},
+		{"fail/init", func() {
+			writeConfig(func(c *authority.Config) { c.Password = "bad-password" })
+		}, "error reloading ca"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.write()
+			err := ca.Reload()
+			if assert.Error(t, err) {
+				assert.HasPrefix(t, err.Error(), tt.errMsg)
+			}
+			// The previous configuration is kept.
+			assert.Equals(t, config, ca.config)
+			assert.Equals(t, "127.0.0.1:0", ca.srv.Addr)
+		})
+	}
+}
+
+func TestCAStop(t *testing.T) {
+	rootCrt, err := pemutil.ReadCertificate("testdata/secrets/root_ca.crt")
+	assert.FatalError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(rootCrt)
+
+	// startCA starts a CA with a handler that waits until release is closed.
+	startCA := func(timeout time.Duration) (*CA, string, chan error, chan struct{}) {
+		config, err := authority.LoadConfiguration("testdata/ca.json")
+		assert.FatalError(t, err)
+		config.ShutdownTimeout = &provisioner.Duration{Duration: timeout}
+		ln := newLocalListener()
+		config.Address = ln.Addr().String()
+		ca, err := New(config)
+		assert.FatalError(t, err)
+
+		started, release := make(chan struct{}), make(chan struct{})
+		handler := ca.srv.Handler
+		ca.srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				close(started)
+				<-release
+			}
+			handler.ServeHTTP(w, r)
+		})
+		go ca.srv.Serve(ln)
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"},
+		}}
+		errc := make(chan error, 1)
+		go func() {
+			resp, err := client.Get("https://" + config.Address + "/slow")
+			if err == nil {
+				resp.Body.Close()
+			}
+			errc <- err
+		}()
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not started")
+		}
+		return ca, config.Address, errc, release
+	}
+
+	// The active requests are completed.
+	ca, addr, errc, release := startCA(time.Minute)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- ca.Stop()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop() returned %v before the request finished", err)
+	default:
+	}
+	close(release)
+	assert.FatalError(t, <-errc)
+	assert.FatalError(t, <-stopped)
+
+	// The connections are closed after the timeout.
+	ca, _, errc, release = startCA(100 * time.Millisecond)
+	defer close(release)
+	assert.Error(t, ca.Stop())
+	assert.Error(t, <-errc)
+}
+
 func TestCAHealth(t *testing.T) {
 	config, err := authority.LoadConfiguration("testdata/ca.json")
 	assert.FatalError(t, err)
@@ -614,6 +614,39 @@ retry:
 	return &sign, nil
 }
 
+// RenewWithToken performs the renew request to the CA using a renew token in
+// the Authorization header instead of the client certificate. This allows the
+// renewal of expired certificates if the provisioner allows it. The token must
+// contain the certificate chain in the x5cInsecure header and it must be signed
+// with the key of the certificate.
+func (c *Client) RenewWithToken(token string) (*api.SignResponse, error) {
+	var retried bool
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/renew"})
+retry:
+	req, err := http.NewRequest("POST", u.String(), http.NoBody)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request POST %s failed", u)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := c.client.Client.Do(req)
+	if err != nil {
+		return nil, errs.Wrapf(http.StatusInternalServerError, err, "client.RenewWithToken; client POST %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	var sign api.SignResponse
+	if err := readJSON(resp.Body, &sign); err != nil {
+		return nil, errs.Wrapf(http.StatusInternalServerError, err, "client.RenewWithToken; error reading %s", u)
+	}
+	return &sign, nil
+}
+
 // Revoke performs the revoke request to the CA and returns the api.RevokeResponse
 // struct.
 func (c *Client) Revoke(req *api.RevokeRequest, tr http.RoundTripper) (*api.RevokeResponse, error) {
@@ -756,6 +789,31 @@ retry:
 	return &federation, nil
 }
 
+// RootRotation performs the get root rotation request to the CA and returns
+// the api.RootRotationResponse struct. It returns a not found error if there
+// is no rotation.
+func (c *Client) RootRotation() (*api.RootRotationResponse, error) {
+	var retried bool
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/roots/rotation"})
+retry:
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "client GET %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	var rotation api.RootRotationResponse
+	if err := readJSON(resp.Body, &rotation); err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", u)
+	}
+	return &rotation, nil
+}
+
 // SSHSign performs the POST /ssh/sign request to the CA and returns the
 // api.SSHSignResponse struct.
 func (c *Client) SSHSign(req *api.SSHSignRequest) (*api.SSHSignResponse, error) {
@@ -868,6 +926,32 @@ retry:
 	return &revoke, nil
 }
 
+// SSHKRL performs the GET /ssh/krl request to the CA and returns the OpenSSH
+// Key Revocation List. The client must use a client certificate issued by the
+// CA.
+func (c *Client) SSHKRL() ([]byte, error) {
+	var retried bool
+	u := c.endpoint.ResolveReference(&url.URL{Path: "/ssh/krl"})
+retry:
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "client GET %s failed", u)
+	}
+	if resp.StatusCode >= 400 {
+		if !retried && c.retryOnError(resp) {
+			retried = true
+			goto retry
+		}
+		return nil, readError(resp.Body)
+	}
+	defer resp.Body.Close()
+	krl, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", u)
+	}
+	return krl, nil
+}
+
 // SSHRoots performs the GET /ssh/roots request to the CA and returns the
 // api.SSHRootsResponse struct.
 func (c *Client) SSHRoots() (*api.SSHRootsResponse, error) {
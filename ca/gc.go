@@ -0,0 +1,85 @@
+package ca
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority"
+)
+
+// garbageCollector periodically deletes the expired objects in the database.
+type garbageCollector struct {
+	auth     *authority.Authority
+	acmeAuth *acme.Authority
+	config   *authority.GCConfig
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// newGarbageCollector returns a garbage collector for the ACME objects and the
+// certificates in the database.
+func newGarbageCollector(auth *authority.Authority, acmeAuth *acme.Authority, config *authority.GCConfig) *garbageCollector {
+	return &garbageCollector{
+		auth:     auth,
+		acmeAuth: acmeAuth,
+		config:   config,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Run starts the periodic collection in a new goroutine.
+func (gc *garbageCollector) Run() {
+	go func() {
+		ticker := time.NewTicker(gc.config.GetPeriod())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				gc.collect()
+			case <-gc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic collection.
+func (gc *garbageCollector) Stop() {
+	gc.once.Do(func() {
+		close(gc.stop)
+	})
+}
+
+// collect deletes the expired objects, errors are logged and the objects are
+// deleted in the next collection. In a cluster only one instance collects the
+// garbage in each period, if the period cannot be claimed it runs anyway.
+func (gc *garbageCollector) collect() {
+	if ok, err := gc.auth.ClaimJob("gc", gc.config.GetPeriod()); err != nil {
+		log.Printf("error claiming garbage collection: %v", err)
+	} else if !ok {
+		return
+	}
+
+	stats, err := gc.acmeAuth.CollectGarbage(acme.GCOptions{
+		Retention:            gc.config.GetRetention(),
+		NonceRetention:       gc.config.GetNonceRetention(),
+		CertificateRetention: gc.config.GetCertificateRetention(),
+	})
+	if err != nil {
+		log.Printf("error collecting ACME garbage: %v", err)
+	}
+	if stats != nil {
+		log.Printf("garbage collection deleted %d nonces, %d orders, %d authorizations, %d challenges and %d ACME certificates",
+			stats.Nonces, stats.Orders, stats.Authorizations, stats.Challenges, stats.Certificates)
+	}
+
+	if retention := gc.config.GetCertificateRetention(); retention > 0 {
+		n, err := gc.auth.DeleteExpiredCertificates(retention)
+		if err != nil {
+			log.Printf("error deleting expired certificates: %v", err)
+		}
+		log.Printf("garbage collection deleted %d expired certificates", n)
+	}
+}
@@ -3,6 +3,7 @@ package ca
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"math/rand"
 	"sync"
 	"time"
@@ -138,6 +139,14 @@ func (r *TLSRenewer) getCertificate() *tls.Certificate {
 	return cert
 }
 
+// getLeaf returns the parsed leaf of the current certificate.
+func (r *TLSRenewer) getLeaf() *x509.Certificate {
+	if cert := r.getCertificate(); cert != nil {
+		return cert.Leaf
+	}
+	return nil
+}
+
 // getCertificateForCA returns the certificate using a read-only lock. It will
 // automatically renew the certificate if it has expired.
 func (r *TLSRenewer) getCertificateForCA() *tls.Certificate {
@@ -0,0 +1,415 @@
+package ca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/crypto/randutil"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshRekeyRetryDuration is the time to wait before retrying a failed rekey.
+var sshRekeyRetryDuration = time.Minute
+
+// SSHPOPToken returns a token for the given path, e.g. /ssh/rekey, signed with
+// the private key of the given SSH certificate. The certificate is sent in the
+// sshpop header, and it is used as the credential for the SSHPOP provisioner
+// with the given name.
+func (c *Client) SSHPOPToken(path, provisionerName string, cert *ssh.Certificate, key crypto.PrivateKey) (string, error) {
+	alg, err := sshPOPAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	so := new(jose.SignerOptions)
+	so.WithType("JWT")
+	so.WithHeader("sshpop", base64.StdEncoding.EncodeToString(cert.Marshal()))
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, so)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating token signer")
+	}
+
+	id, err := randutil.Hex(64)
+	if err != nil {
+		return "", err
+	}
+	aud := c.endpoint.ResolveReference(&url.URL{
+		Path:     "/1.0" + path,
+		Fragment: "sshpop/" + provisionerName,
+	})
+	now := time.Now()
+	claims := jose.Claims{
+		ID:        id,
+		Subject:   strconv.FormatUint(cert.Serial, 10),
+		Issuer:    provisionerName,
+		IssuedAt:  jose.NewNumericDate(now),
+		NotBefore: jose.NewNumericDate(now),
+		Expiry:    jose.NewNumericDate(now.Add(tokenLifetime)),
+		Audience:  []string{aud.String()},
+	}
+	tok, err := jose.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", errors.Wrap(err, "error signing token")
+	}
+	return tok, nil
+}
+
+// sshPOPAlgorithm returns the signature algorithm used in SSHPOP tokens for
+// the given key.
+func sshPOPAlgorithm(key crypto.PrivateKey) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		default:
+			return "", errors.Errorf("unsupported elliptic curve %s", k.Curve.Params().Name)
+		}
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	default:
+		return "", errors.Errorf("unsupported key type %T", key)
+	}
+}
+
+// SSHPOPProvisioner returns the name of the first SSHPOP provisioner in the
+// CA.
+func (c *Client) SSHPOPProvisioner() (string, error) {
+	var cursor string
+	for {
+		resp, err := c.Provisioners(WithProvisionerCursor(cursor), WithProvisionerLimit(100))
+		if err != nil {
+			return "", err
+		}
+		for _, p := range resp.Provisioners {
+			if p.GetType() == provisioner.TypeSSHPOP {
+				return p.GetName(), nil
+			}
+		}
+		if resp.NextCursor == "" {
+			return "", errors.New("the CA does not have an SSHPOP provisioner")
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+// SSHRekeyHost rekeys an SSH host certificate using the certificate and its
+// private key as the credential. It returns the new certificate and the new
+// private key.
+func (c *Client) SSHRekeyHost(provisionerName string, cert *ssh.Certificate, key crypto.PrivateKey) (*ssh.Certificate, crypto.Signer, error) {
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.Errorf("key of type %T is not a crypto.Signer", priv)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error converting public key")
+	}
+	tok, err := c.SSHPOPToken("/ssh/rekey", provisionerName, cert, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.SSHRekey(&api.SSHRekeyRequest{
+		OTT:       tok,
+		PublicKey: sshPub.Marshal(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Certificate.Certificate, signer, nil
+}
+
+// SSHHostRekeyer periodically rekeys an SSH host certificate before it
+// expires. The new key and certificate replace the files used by sshd, and
+// sshd is signaled to load them.
+type SSHHostRekeyer struct {
+	sync.Mutex
+	client      *Client
+	provisioner string
+	keyFile     string
+	certFile    string
+	pidFile     string
+	reload      func() error
+	rekeyBefore time.Duration
+	timer       *time.Timer
+	done        bool
+}
+
+// SSHHostRekeyerOption is the type of the options used to configure an
+// SSHHostRekeyer.
+type SSHHostRekeyerOption func(r *SSHHostRekeyer) error
+
+// WithSSHProvisioner sets the name of the SSHPOP provisioner, by default the
+// first one in the CA is used.
+func WithSSHProvisioner(name string) SSHHostRekeyerOption {
+	return func(r *SSHHostRekeyer) error {
+		r.provisioner = name
+		return nil
+	}
+}
+
+// WithSSHRekeyBefore sets the time before the expiration of the certificate to
+// rekey it, by default it is a third of the validity period.
+func WithSSHRekeyBefore(d time.Duration) SSHHostRekeyerOption {
+	return func(r *SSHHostRekeyer) error {
+		r.rekeyBefore = d
+		return nil
+	}
+}
+
+// WithSSHDPIDFile sets the pid file of sshd, the process is signaled with
+// SIGHUP after a rekey.
+func WithSSHDPIDFile(filename string) SSHHostRekeyerOption {
+	return func(r *SSHHostRekeyer) error {
+		r.pidFile = filename
+		return nil
+	}
+}
+
+// WithSSHReloadFunc sets the function called after a rekey, instead of
+// signaling sshd.
+func WithSSHReloadFunc(fn func() error) SSHHostRekeyerOption {
+	return func(r *SSHHostRekeyer) error {
+		r.reload = fn
+		return nil
+	}
+}
+
+// NewSSHHostRekeyer creates an SSHHostRekeyer for the given host private key
+// and certificate files. The private key is written in PEM format, the public
+// key in <keyFile>.pub and the certificate in certFile, both in the
+// authorized keys format.
+func NewSSHHostRekeyer(client *Client, keyFile, certFile string, opts ...SSHHostRekeyerOption) (*SSHHostRekeyer, error) {
+	r := &SSHHostRekeyer{
+		client:   client,
+		keyFile:  keyFile,
+		certFile: certFile,
+	}
+	for _, fn := range opts {
+		if err := fn(r); err != nil {
+			return nil, errors.Wrap(err, "error applying options")
+		}
+	}
+	if r.provisioner == "" {
+		name, err := client.SSHPOPProvisioner()
+		if err != nil {
+			return nil, err
+		}
+		r.provisioner = name
+	}
+	return r, nil
+}
+
+// Rekey rekeys the host certificate, replaces the files and reloads sshd.
+func (r *SSHHostRekeyer) Rekey() error {
+	cert, key, err := r.readFiles()
+	if err != nil {
+		return err
+	}
+	newCert, newKey, err := r.client.SSHRekeyHost(r.provisioner, cert, key)
+	if err != nil {
+		return err
+	}
+	if err := r.writeFiles(newCert, newKey); err != nil {
+		return err
+	}
+	return r.reloadSSHD()
+}
+
+// Run starts rekeying the host certificate before it expires.
+func (r *SSHHostRekeyer) Run() error {
+	cert, _, err := r.readFiles()
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.done = false
+	r.timer = time.AfterFunc(r.nextRekeyDuration(cert), r.rekey)
+	r.Unlock()
+	return nil
+}
+
+// RunContext starts rekeying the host certificate until the given context is
+// done.
+func (r *SSHHostRekeyer) RunContext(ctx context.Context) error {
+	if err := r.Run(); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		r.Stop()
+	}()
+	return nil
+}
+
+// Stop prevents the rekey timer from firing.
+func (r *SSHHostRekeyer) Stop() bool {
+	r.Lock()
+	defer r.Unlock()
+	r.done = true
+	if r.timer != nil {
+		return r.timer.Stop()
+	}
+	return true
+}
+
+func (r *SSHHostRekeyer) rekey() {
+	next := sshRekeyRetryDuration
+	if err := r.Rekey(); err == nil {
+		if cert, _, err := r.readFiles(); err == nil {
+			next = r.nextRekeyDuration(cert)
+		}
+	}
+	r.Lock()
+	if !r.done {
+		r.timer.Reset(next)
+	}
+	r.Unlock()
+}
+
+// nextRekeyDuration returns the time to wait before rekeying the given
+// certificate.
+func (r *SSHHostRekeyer) nextRekeyDuration(cert *ssh.Certificate) time.Duration {
+	validAfter := time.Unix(int64(cert.ValidAfter), 0)
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	before := r.rekeyBefore
+	if before == 0 {
+		before = validBefore.Sub(validAfter) / 3
+	}
+	next := time.Until(validBefore.Add(-before))
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+
+func (r *SSHHostRekeyer) readFiles() (*ssh.Certificate, crypto.PrivateKey, error) {
+	b, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error reading %s", r.certFile)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error parsing %s", r.certFile)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, nil, errors.Errorf("error parsing %s: file is not an SSH certificate", r.certFile)
+	}
+	if cert.CertType != ssh.HostCert {
+		return nil, nil, errors.Errorf("error parsing %s: certificate is not a host certificate", r.certFile)
+	}
+
+	b, err = ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error reading %s", r.keyFile)
+	}
+	key, err := ssh.ParseRawPrivateKey(b)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error parsing %s", r.keyFile)
+	}
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		key = *k
+	}
+	return cert, key, nil
+}
+
+func (r *SSHHostRekeyer) writeFiles(cert *ssh.Certificate, key crypto.Signer) error {
+	block, err := pemutil.Serialize(key)
+	if err != nil {
+		return err
+	}
+	sshPub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return errors.Wrap(err, "error converting public key")
+	}
+	// The key is written last, so sshd does not load a key without its
+	// certificate if it is reloaded in the middle.
+	if err := writeFileAtomic(r.certFile, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.keyFile+".pub", ssh.MarshalAuthorizedKey(sshPub), 0644); err != nil {
+		return err
+	}
+	return writeFileAtomic(r.keyFile, pem.EncodeToMemory(block), 0600)
+}
+
+func (r *SSHHostRekeyer) reloadSSHD() error {
+	if r.reload != nil {
+		return r.reload()
+	}
+	if r.pidFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(r.pidFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", r.pidFile)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return errors.Wrapf(err, "error parsing %s", r.pidFile)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return errors.Wrapf(err, "error finding process %d", pid)
+	}
+	return errors.Wrapf(p.Signal(syscall.SIGHUP), "error signaling process %d", pid)
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory
+// and renames it to avoid partial writes.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", filename)
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/jose"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSSHHostCert(t *testing.T, ca ssh.Signer, key crypto.PublicKey, d time.Duration) *ssh.Certificate {
+	sshKey, err := ssh.NewPublicKey(key)
+	assert.FatalError(t, err)
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             sshKey,
+		Serial:          uint64(now.UnixNano()),
+		CertType:        ssh.HostCert,
+		KeyId:           "foo.internal",
+		ValidPrincipals: []string{"foo.internal"},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(d).Unix()),
+	}
+	assert.FatalError(t, cert.SignCert(rand.Reader, ca))
+	return cert
+}
+
+// sshRekeyHandler returns a handler that verifies the sshpop token and signs
+// a new certificate.
+func sshRekeyHandler(t *testing.T, ca ssh.Signer, rekeyed *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/ssh/rekey" {
+			http.NotFound(w, req)
+			return
+		}
+		var body api.SSHRekeyRequest
+		assert.FatalError(t, json.NewDecoder(req.Body).Decode(&body))
+		cert, jwt, err := provisioner.ExtractSSHPOPCert(body.OTT)
+		assert.FatalError(t, err)
+		var claims jose.Claims
+		assert.FatalError(t, jwt.Claims(cert.Key.(ssh.CryptoPublicKey).CryptoPublicKey(), &claims))
+		assert.FatalError(t, claims.Validate(jose.Expected{
+			Issuer:   "sshpop",
+			Audience: []string{"http://" + req.Host + "/1.0/ssh/rekey#sshpop/sshpop"},
+			Time:     time.Now(),
+		}))
+		assert.NotEquals(t, "", claims.ID)
+		assert.NotEquals(t, "", claims.Subject)
+
+		pub, err := ssh.ParsePublicKey(body.PublicKey)
+		assert.FatalError(t, err)
+		newCert := newTestSSHHostCert(t, ca, pub.(ssh.CryptoPublicKey).CryptoPublicKey(), time.Hour)
+		*rekeyed++
+		api.JSONStatus(w, &api.SSHRekeyResponse{
+			Certificate: api.SSHCertificate{Certificate: newCert},
+		}, http.StatusCreated)
+	})
+}
+
+func TestClient_SSHPOPToken(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.FatalError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+	caSigner, err := ssh.NewSignerFromKey(ecKey)
+	assert.FatalError(t, err)
+
+	c, err := NewClient("https://ca.smallstep.com", WithTransport(http.DefaultTransport))
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		wantAlg string
+		wantErr bool
+	}{
+		{"ok ecdsa", ecKey, jose.ES384, false},
+		{"ok ed25519", edKey, jose.EdDSA, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := newTestSSHHostCert(t, caSigner, tt.key.Public(), time.Hour)
+			tok, err := c.SSHPOPToken("/ssh/rekey", "sshpop", cert, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.SSHPOPToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			gotCert, jwt, err := provisioner.ExtractSSHPOPCert(tok)
+			assert.FatalError(t, err)
+			assert.Equals(t, cert.Marshal(), gotCert.Marshal())
+			assert.Equals(t, tt.wantAlg, jwt.Headers[0].Algorithm)
+			var claims jose.Claims
+			assert.FatalError(t, jwt.Claims(tt.key.Public(), &claims))
+			assert.Equals(t, []string{"https://ca.smallstep.com/1.0/ssh/rekey#sshpop/sshpop"}, []string(claims.Audience))
+			assert.Equals(t, "sshpop", claims.Issuer)
+		})
+	}
+
+	_, err = c.SSHPOPToken("/ssh/rekey", "sshpop", newTestSSHHostCert(t, caSigner, ecKey.Public(), time.Hour), "not a key")
+	assert.Error(t, err)
+}
+
+func TestSSHHostRekeyer_Rekey(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	assert.FatalError(t, err)
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	hostCert := newTestSSHHostCert(t, caSigner, hostKey.Public(), 10*time.Minute)
+
+	dir, err := ioutil.TempDir(os.TempDir(), "ssh-rekey")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "ssh_host_ecdsa_key")
+	certFile := filepath.Join(dir, "ssh_host_ecdsa_key-cert.pub")
+	block, err := pemutil.Serialize(hostKey)
+	assert.FatalError(t, err)
+	assert.FatalError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+	assert.FatalError(t, ioutil.WriteFile(certFile, ssh.MarshalAuthorizedKey(hostCert), 0644))
+
+	var rekeyed int
+	srv := httptest.NewServer(sshRekeyHandler(t, caSigner, &rekeyed))
+	defer srv.Close()
+	c, err := NewClient(srv.URL, WithTransport(http.DefaultTransport))
+	assert.FatalError(t, err)
+
+	var reloaded int
+	r, err := NewSSHHostRekeyer(c, keyFile, certFile, WithSSHProvisioner("sshpop"), WithSSHReloadFunc(func() error {
+		reloaded++
+		return nil
+	}))
+	assert.FatalError(t, err)
+	// The certificate is valid for 11 minutes, rekey when a third is left.
+	if d := r.nextRekeyDuration(hostCert); d < 6*time.Minute || d > 6*time.Minute+20*time.Second {
+		t.Errorf("SSHHostRekeyer.nextRekeyDuration() = %v, want ~6m20s", d)
+	}
+
+	assert.FatalError(t, r.Rekey())
+	assert.Equals(t, 1, rekeyed)
+	assert.Equals(t, 1, reloaded)
+
+	// The files have the new key and certificate
+	cert, key, err := r.readFiles()
+	assert.FatalError(t, err)
+	assert.NotEquals(t, hostCert.Marshal(), cert.Marshal())
+	sshPub, err := ssh.NewPublicKey(key.(crypto.Signer).Public())
+	assert.FatalError(t, err)
+	assert.Equals(t, sshPub.Marshal(), cert.Key.Marshal())
+	b, err := ioutil.ReadFile(keyFile + ".pub")
+	assert.FatalError(t, err)
+	assert.Equals(t, ssh.MarshalAuthorizedKey(sshPub), b)
+	fi, err := os.Stat(keyFile)
+	assert.FatalError(t, err)
+	assert.Equals(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// The new certificate is the credential of the next rekey
+	assert.FatalError(t, r.Rekey())
+	assert.Equals(t, 2, rekeyed)
+	assert.Equals(t, 2, reloaded)
+
+	// Not a host certificate
+	userCert := newTestSSHHostCert(t, caSigner, hostKey.Public(), time.Hour)
+	userCert.CertType = ssh.UserCert
+	assert.FatalError(t, userCert.SignCert(rand.Reader, caSigner))
+	assert.FatalError(t, ioutil.WriteFile(certFile, ssh.MarshalAuthorizedKey(userCert), 0644))
+	assert.Error(t, r.Rekey())
+	assert.Equals(t, 2, rekeyed)
+}
@@ -0,0 +1,151 @@
+package ca
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+)
+
+// tenant is an authority hosted by the CA in addition to the main one. It's
+// served in its prefix, and in the TLS connections to its hosts with its own
+// TLS configuration.
+type tenant struct {
+	config    *authority.TenantConfig
+	auth      *authority.Authority
+	renewer   *TLSRenewer
+	tlsConfig *tls.Config
+	handler   http.Handler
+}
+
+// initTenants initializes the authorities of the tenants in the configuration.
+// The tenants keep their tables in the database of the main authority. The
+// ACME links of a tenant use its first host, or its prefix in the given DNS
+// name if it does not have hosts.
+func (ca *CA) initTenants(config *authority.Config, auth *authority.Authority, dns, port string) ([]*tenant, error) {
+	var tenants []*tenant
+	for _, tc := range config.Tenants {
+		t, err := ca.initTenant(tc, config, auth, dns, port)
+		if err != nil {
+			stopTenants(tenants)
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func (ca *CA) initTenant(tc *authority.TenantConfig, config *authority.Config, auth *authority.Authority, dns, port string) (*tenant, error) {
+	c, err := tc.LoadConfiguration(config.DNSNames)
+	if err != nil {
+		return nil, err
+	}
+	if c.Password == "" && c.PasswordProvider == nil {
+		c.Password = config.Password
+	}
+	tdb, err := tc.Database(auth.GetDatabase())
+	if err != nil {
+		return nil, err
+	}
+	tauth, err := authority.New(c, authority.WithDatabase(tdb))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initializing tenant %s", tc.Name)
+	}
+	tlsConfig, renewer, err := newTLSConfig(c, tauth)
+	if err != nil {
+		stopAuthority(tauth)
+		return nil, errors.Wrapf(err, "error initializing tenant %s", tc.Name)
+	}
+	// Negotiate HTTP/2 as the server does with the main configuration.
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+
+	acmePrefix := strings.TrimPrefix(tc.Prefix(), "/") + "/acme"
+	if len(tc.Hosts) > 0 {
+		dns, acmePrefix = tc.Hosts[0], "acme"
+		if port != "" && port != "443" {
+			dns += ":" + port
+		}
+	}
+	mux := chi.NewRouter()
+	if _, err := routeAuthority(mux, tauth, dns, acmePrefix); err != nil {
+		renewer.Stop()
+		stopAuthority(tauth)
+		return nil, errors.Wrapf(err, "error initializing tenant %s", tc.Name)
+	}
+
+	return &tenant{
+		config:    tc,
+		auth:      tauth,
+		renewer:   renewer,
+		tlsConfig: tlsConfig,
+		handler:   mux,
+	}, nil
+}
+
+// stop stops the renewer and the background tasks of the tenant.
+func (t *tenant) stop() {
+	t.renewer.Stop()
+	stopAuthority(t.auth)
+}
+
+// stopTenants stops the renewer and the background tasks of the tenants.
+func stopTenants(tenants []*tenant) {
+	for _, t := range tenants {
+		t.stop()
+	}
+}
+
+// shutdownTenants stops the tenants and shuts down their authorities.
+func shutdownTenants(tenants []*tenant) {
+	for _, t := range tenants {
+		t.stop()
+		if err := t.auth.Shutdown(); err != nil {
+			log.Printf("error stopping tenant %s: %+v\n", t.config.Name, err)
+		}
+	}
+}
+
+// tenantsByHost returns the tenants indexed by their hosts in lowercase.
+func tenantsByHost(tenants []*tenant) map[string]*tenant {
+	hosts := make(map[string]*tenant)
+	for _, t := range tenants {
+		for _, h := range t.config.Hosts {
+			hosts[strings.ToLower(h)] = t
+		}
+	}
+	return hosts
+}
+
+// tenantMiddleware serves the requests in the TLS connections to the hosts of
+// a tenant with the handler of the tenant.
+func tenantMiddleware(tenants []*tenant) func(http.Handler) http.Handler {
+	hosts := tenantsByHost(tenants)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				if t, ok := hosts[strings.ToLower(r.TLS.ServerName)]; ok {
+					t.handler.ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// getTenantTLSConfig returns a GetConfigForClient function that uses the TLS
+// configuration of the tenants in the connections to their hosts, and the
+// configuration of the CA in the rest.
+func getTenantTLSConfig(tenants []*tenant) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	hosts := tenantsByHost(tenants)
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if t, ok := hosts[strings.ToLower(hello.ServerName)]; ok {
+			return t.tlsConfig, nil
+		}
+		return nil, nil
+	}
+}
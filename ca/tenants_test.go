@@ -0,0 +1,115 @@
+package ca
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/crypto/pemutil"
+)
+
+func TestCATenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The tenant uses the rotated root.
+	tc, err := authority.LoadConfiguration("testdata/federated-ca.json")
+	assert.FatalError(t, err)
+	tc.DNSNames = []string{"ca.team-a.example.com"}
+	assert.FatalError(t, tc.Save(filepath.Join(dir, "team-a.json")))
+
+	config, err := authority.LoadConfiguration("testdata/ca.json")
+	assert.FatalError(t, err)
+	config.Tenants = []*authority.TenantConfig{{
+		Name:   "team-a",
+		Config: filepath.Join(dir, "team-a.json"),
+		Hosts:  []string{"ca.team-a.example.com"},
+	}}
+	ca, err := New(config)
+	assert.FatalError(t, err)
+	defer ca.stopBackground()
+
+	rootCrt, err := pemutil.ReadCertificate("testdata/secrets/root_ca.crt")
+	assert.FatalError(t, err)
+	tenantRootCrt, err := pemutil.ReadCertificate("testdata/rotated/root_ca.crt")
+	assert.FatalError(t, err)
+
+	getRoots := func(r *http.Request) []api.Certificate {
+		rr := httptest.NewRecorder()
+		ca.srv.Handler.ServeHTTP(rr, r)
+		assert.Equals(t, http.StatusCreated, rr.Code)
+		var roots api.RootsResponse
+		assert.FatalError(t, readJSON(&ClosingBuffer{rr.Body}, &roots))
+		return roots.Certificates
+	}
+
+	// The tenant is served in its prefix.
+	roots := getRoots(httptest.NewRequest("GET", "/roots", nil))
+	if assert.Len(t, 1, roots) {
+		assert.Equals(t, rootCrt, roots[0].Certificate)
+	}
+	roots = getRoots(httptest.NewRequest("GET", "/tenants/team-a/roots", nil))
+	if assert.Len(t, 1, roots) {
+		assert.Equals(t, tenantRootCrt, roots[0].Certificate)
+	}
+	roots = getRoots(httptest.NewRequest("GET", "/tenants/team-a/1.0/roots", nil))
+	if assert.Len(t, 1, roots) {
+		assert.Equals(t, tenantRootCrt, roots[0].Certificate)
+	}
+
+	// And in the connections to its hosts.
+	r := httptest.NewRequest("GET", "/roots", nil)
+	r.TLS = &tls.ConnectionState{ServerName: "CA.team-a.example.com"}
+	roots = getRoots(r)
+	if assert.Len(t, 1, roots) {
+		assert.Equals(t, tenantRootCrt, roots[0].Certificate)
+	}
+	r = httptest.NewRequest("GET", "/roots", nil)
+	r.TLS = &tls.ConnectionState{ServerName: "127.0.0.1"}
+	roots = getRoots(r)
+	if assert.Len(t, 1, roots) {
+		assert.Equals(t, rootCrt, roots[0].Certificate)
+	}
+
+	// The TLS connections to its hosts use its own certificate.
+	tlsConfig, err := ca.srv.TLSConfig.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "ca.team-a.example.com"})
+	assert.FatalError(t, err)
+	if assert.NotNil(t, tlsConfig) {
+		crt, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "ca.team-a.example.com"})
+		assert.FatalError(t, err)
+		assert.Equals(t, []string{"ca.team-a.example.com"}, crt.Leaf.DNSNames)
+		assert.FatalError(t, crt.Leaf.VerifyHostname("ca.team-a.example.com"))
+		assert.Equals(t, 1, len(tlsConfig.ClientCAs.Subjects()))
+	}
+	tlsConfig, err = ca.srv.TLSConfig.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "127.0.0.1"})
+	assert.FatalError(t, err)
+	assert.Nil(t, tlsConfig)
+}
+
+func TestCATenants_invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	tc, err := authority.LoadConfiguration("testdata/federated-ca.json")
+	assert.FatalError(t, err)
+	tc.DB = &db.Config{Type: "badger", DataSource: dir}
+	assert.FatalError(t, tc.Save(filepath.Join(dir, "team-a.json")))
+
+	config, err := authority.LoadConfiguration("testdata/ca.json")
+	assert.FatalError(t, err)
+	config.Tenants = []*authority.TenantConfig{{Name: "team-a", Config: filepath.Join(dir, "team-a.json")}}
+	_, err = New(config)
+	if assert.Error(t, err) {
+		assert.Equals(t, "tenant team-a cannot have a db, it uses the db of the ca", err.Error())
+	}
+}
@@ -0,0 +1,83 @@
+package ca
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// configWatchDelay is the time to wait after a change in the configuration
+// file before reloading it. Editors and tools often write a file in several
+// steps, all the changes during this time trigger only one reload.
+const configWatchDelay = time.Second
+
+// configWatcher calls a function when a file changes. It watches the
+// directory of the file, so files replaced atomically are also detected.
+type configWatcher struct {
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	timer   *time.Timer
+	done    chan struct{}
+}
+
+// newConfigWatcher starts watching the given file, fn is called after the
+// file changes and delay has passed without other changes.
+func newConfigWatcher(name string, delay time.Duration, fn func()) (*configWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating file watcher")
+	}
+	if err := w.Add(filepath.Dir(name)); err != nil {
+		w.Close()
+		return nil, errors.Wrapf(err, "error watching %s", name)
+	}
+	cw := &configWatcher{
+		watcher: w,
+		done:    make(chan struct{}),
+	}
+	name = filepath.Clean(name)
+	go func() {
+		defer close(cw.done)
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == name && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					cw.schedule(delay, fn)
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return cw, nil
+}
+
+// schedule calls fn after the given delay, a pending call is postponed.
+func (cw *configWatcher) schedule(delay time.Duration, fn func()) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.timer != nil {
+		cw.timer.Stop()
+	}
+	cw.timer = time.AfterFunc(delay, fn)
+}
+
+// Stop stops watching the file and cancels a pending call.
+func (cw *configWatcher) Stop() error {
+	err := cw.watcher.Close()
+	<-cw.done
+	cw.mu.Lock()
+	if cw.timer != nil {
+		cw.timer.Stop()
+	}
+	cw.mu.Unlock()
+	return err
+}
@@ -0,0 +1,57 @@
+package ca
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	assert.FatalError(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "ca.json")
+	assert.FatalError(t, ioutil.WriteFile(name, []byte("{}"), 0600))
+
+	var calls int32
+	w, err := newConfigWatcher(name, 50*time.Millisecond, func() {
+		atomic.AddInt32(&calls, 1)
+	})
+	assert.FatalError(t, err)
+	defer w.Stop()
+
+	waitCalls := func(n int32) {
+		for i := 0; i < 100 && atomic.LoadInt32(&calls) < n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Wait for unexpected calls.
+		time.Sleep(100 * time.Millisecond)
+		assert.Equals(t, n, atomic.LoadInt32(&calls))
+	}
+
+	// Other files are ignored.
+	assert.FatalError(t, ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), 0600))
+	waitCalls(0)
+
+	// Multiple writes trigger one call.
+	assert.FatalError(t, ioutil.WriteFile(name, []byte(`{"address":`), 0600))
+	assert.FatalError(t, ioutil.WriteFile(name, []byte(`{"address":":443"}`), 0600))
+	waitCalls(1)
+
+	// Files replaced atomically.
+	tmp := filepath.Join(dir, "ca.json.tmp")
+	assert.FatalError(t, ioutil.WriteFile(tmp, []byte(`{"address":":8443"}`), 0600))
+	assert.FatalError(t, os.Rename(tmp, name))
+	waitCalls(2)
+
+	// No calls after stop.
+	assert.FatalError(t, w.Stop())
+	assert.FatalError(t, ioutil.WriteFile(name, []byte("{}"), 0600))
+	waitCalls(2)
+}
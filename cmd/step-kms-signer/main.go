@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/remotekms"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	var address, crt, key, root, kmsConfig, allowedKeys string
+	var allowCreateKey bool
+	flag.StringVar(&address, "address", ":9443", "The address to listen on.")
+	flag.StringVar(&crt, "crt", "", "Path to the PEM encoded server certificate.")
+	flag.StringVar(&key, "key", "", "Path to the PEM encoded server private key.")
+	flag.StringVar(&root, "root", "", "Path to the PEM encoded bundle used to verify the client certificates.")
+	flag.StringVar(&kmsConfig, "kms", "", "Path to a JSON file with the KMS options, the same ones used in the \"kms\" property of the ca.json. By default softkms is used.")
+	flag.StringVar(&allowedKeys, "allowed-keys", "", "Comma separated list of the key names that clients can use, by default all the keys are allowed.")
+	flag.BoolVar(&allowCreateKey, "allow-create-key", false, "Allow clients to create new keys.")
+	flag.Usage = usage
+	flag.Parse()
+
+	if crt == "" || key == "" || root == "" {
+		fmt.Fprintln(os.Stderr, "flags `--crt`, `--key` and `--root` are required")
+		os.Exit(1)
+	}
+
+	var opts apiv1.Options
+	if kmsConfig != "" {
+		b, err := ioutil.ReadFile(kmsConfig)
+		if err != nil {
+			fatal(err)
+		}
+		if err := json.Unmarshal(b, &opts); err != nil {
+			fatal(fmt.Errorf("error parsing %s: %v", kmsConfig, err))
+		}
+	}
+	if apiv1.Type(strings.ToLower(opts.Type)) == apiv1.RemoteKMS {
+		fatal(fmt.Errorf("kms type %s cannot be used in the signer", opts.Type))
+	}
+
+	km, err := kms.New(context.Background(), opts)
+	if err != nil {
+		fatal(err)
+	}
+	defer km.Close()
+
+	tlsConfig, err := remotekms.NewServerTLSConfig(crt, key, root)
+	if err != nil {
+		fatal(err)
+	}
+
+	var serverOpts remotekms.ServerOptions
+	serverOpts.AllowCreateKey = allowCreateKey
+	for _, name := range strings.Split(allowedKeys, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			serverOpts.AllowedKeys = append(serverOpts.AllowedKeys, name)
+		}
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	remotekms.RegisterKeyManagerServer(srv, remotekms.NewServer(km, serverOpts))
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		log.Println("shutting down signer ...")
+		srv.GracefulStop()
+	}()
+
+	log.Printf("serving remotekms on %s ...", l.Addr())
+	if err := srv.Serve(l); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: step-kms-signer")
+	fmt.Fprintln(os.Stderr, `
+The step-kms-signer command runs a minimal signer daemon that exposes the keys
+of a KMS over gRPC. Step-ca connects to it using the remotekms type, so the
+private keys can live in a locked-down host while the CA API runs elsewhere.
+Clients are authenticated using mutual TLS.
+
+This tool is experimental.
+
+OPTIONS`)
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `
+COPYRIGHT
+
+  (c) 2018-2020 Smallstep Labs, Inc.`)
+	os.Exit(1)
+}
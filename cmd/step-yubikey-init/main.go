@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/yubikey"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+)
+
+type config struct {
+	RootSlot      string
+	CrtSlot       string
+	TouchPolicy   apiv1.TouchPolicy
+	Attestation   bool
+	ManagementKey string
+}
+
+func main() {
+	var c config
+	var touch string
+	flag.StringVar(&c.ManagementKey, "management-key", "", "Management key to use in hexadecimal format, by default the YubiKey default key is used.")
+	flag.StringVar(&c.RootSlot, "root-slot", "9a", "Slot to store the root certificate and key.")
+	flag.StringVar(&c.CrtSlot, "crt-slot", "9c", "Slot to store the intermediate certificate and key.")
+	flag.StringVar(&touch, "touch-policy", "never", "Touch policy of the intermediate key, never, always or cached.")
+	flag.BoolVar(&c.Attestation, "attestation", false, "Export the attestation certificates of the keys.")
+	flag.Usage = usage
+	flag.Parse()
+
+	switch touch {
+	case "never":
+		c.TouchPolicy = apiv1.TouchPolicyNever
+	case "always":
+		c.TouchPolicy = apiv1.TouchPolicyAlways
+	case "cached":
+		c.TouchPolicy = apiv1.TouchPolicyCached
+	default:
+		fmt.Fprintf(os.Stderr, "invalid value `%s` for flag `--touch-policy`; options are `never`, `always` or `cached`\n", touch)
+		os.Exit(1)
+	}
+	if c.RootSlot == c.CrtSlot {
+		fmt.Fprintln(os.Stderr, "flags `--root-slot` and `--crt-slot` cannot be the same")
+		os.Exit(1)
+	}
+
+	pin, err := ui.PromptPassword("What is the YubiKey PIN?")
+	if err != nil {
+		fatal(err)
+	}
+
+	k, err := yubikey.New(context.Background(), apiv1.Options{
+		Type:          string(apiv1.YubiKey),
+		Pin:           string(pin),
+		ManagementKey: c.ManagementKey,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	defer k.Close()
+
+	if err := createPKI(k, c); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: step-yubikey-init")
+	fmt.Fprintln(os.Stderr, `
+The step-yubikey-init command initializes a public key infrastructure (PKI)
+to be used by step-ca, with the keys stored in a YubiKey.
+
+This tool is experimental and in the future it will be integrated in step cli.
+
+OPTIONS`)
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `
+COPYRIGHT
+
+  (c) 2018-2020 Smallstep Labs, Inc.`)
+	os.Exit(1)
+}
+
+func createPKI(k *yubikey.YubiKey, c config) error {
+	ui.Println("Creating PKI ...")
+	now := time.Now()
+
+	// Root Certificate
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "yubikey:slot-id=" + c.RootSlot,
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	})
+	if err != nil {
+		return err
+	}
+
+	signer, err := k.CreateSigner(&resp.CreateSignerRequest)
+	if err != nil {
+		return err
+	}
+
+	root := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour * 24 * 365 * 10),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLen:            1,
+		MaxPathLenZero:        false,
+		Issuer:                pkix.Name{CommonName: "YubiKey Smallstep Root"},
+		Subject:               pkix.Name{CommonName: "YubiKey Smallstep Root"},
+		SerialNumber:          mustSerialNumber(),
+		SubjectKeyId:          mustSubjectKeyID(resp.PublicKey),
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, root, root, resp.PublicKey, signer)
+	if err != nil {
+		return err
+	}
+	if root, err = x509.ParseCertificate(b); err != nil {
+		return err
+	}
+	if err := k.StoreCertificate(&apiv1.StoreCertificateRequest{
+		Name:        resp.Name,
+		Certificate: root,
+	}); err != nil {
+		return err
+	}
+	if err := writeCertificates("root_ca.crt", root); err != nil {
+		return err
+	}
+
+	ui.PrintSelected("Root Key", resp.Name)
+	ui.PrintSelected("Root Certificate", "root_ca.crt")
+
+	if c.Attestation {
+		if err := writeAttestation(k, resp.Name, "root_ca_attestation.crt"); err != nil {
+			return err
+		}
+	}
+
+	// Intermediate Certificate
+	resp, err = k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "yubikey:slot-id=" + c.CrtSlot,
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		TouchPolicy:        c.TouchPolicy,
+	})
+	if err != nil {
+		return err
+	}
+
+	intermediate := &x509.Certificate{
+		IsCA:                  true,
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour * 24 * 365 * 10),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+		Issuer:                root.Subject,
+		Subject:               pkix.Name{CommonName: "YubiKey Smallstep Intermediate"},
+		SerialNumber:          mustSerialNumber(),
+		SubjectKeyId:          mustSubjectKeyID(resp.PublicKey),
+	}
+
+	b, err = x509.CreateCertificate(rand.Reader, intermediate, root, resp.PublicKey, signer)
+	if err != nil {
+		return err
+	}
+	if intermediate, err = x509.ParseCertificate(b); err != nil {
+		return err
+	}
+	if err := k.StoreCertificate(&apiv1.StoreCertificateRequest{
+		Name:        resp.Name,
+		Certificate: intermediate,
+	}); err != nil {
+		return err
+	}
+	if err := writeCertificates("intermediate_ca.crt", intermediate); err != nil {
+		return err
+	}
+
+	ui.PrintSelected("Intermediate Key", resp.Name)
+	ui.PrintSelected("Intermediate Certificate", "intermediate_ca.crt")
+
+	if c.Attestation {
+		if err := writeAttestation(k, resp.Name, "intermediate_ca_attestation.crt"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeAttestation writes the attestation certificate of the key followed by
+// the attestation certificate of the YubiKey.
+func writeAttestation(k *yubikey.YubiKey, name, filename string) error {
+	resp, err := k.CreateAttestation(&apiv1.CreateAttestationRequest{
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeCertificates(filename, append([]*x509.Certificate{resp.Certificate}, resp.CertificateChain...)...); err != nil {
+		return err
+	}
+	ui.PrintSelected("Attestation Certificate", filename)
+	return nil
+}
+
+func writeCertificates(filename string, certs ...*x509.Certificate) error {
+	var b []byte
+	for _, crt := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: crt.Raw,
+		})...)
+	}
+	return utils.WriteFile(filename, b, 0600)
+}
+
+func mustSerialNumber() *big.Int {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	sn, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		panic(err)
+	}
+	return sn
+}
+
+func mustSubjectKeyID(key crypto.PublicKey) []byte {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha1.Sum(b)
+	return hash[:]
+}
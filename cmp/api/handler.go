@@ -0,0 +1,67 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/cmp"
+	"github.com/smallstep/certificates/errs"
+)
+
+// maxPKIMessageSize is the maximum size of a CMP request.
+const maxPKIMessageSize = 1 << 20
+
+// contentTypePKIXCMP is the content type of the CMP messages sent over HTTP
+// as defined in RFC 6712.
+const contentTypePKIXCMP = "application/pkixcmp"
+
+// New returns a new CMP API router.
+func New(cmpAuth *cmp.Authority) api.RouterHandler {
+	return &Handler{cmpAuth}
+}
+
+// Handler is the CMP request handler.
+type Handler struct {
+	Auth *cmp.Authority
+}
+
+// Route traffic and implement the Router interface.
+func (h *Handler) Route(r api.Router) {
+	r.MethodFunc("POST", "/{provisionerID}", h.Post)
+}
+
+// Post handles the CMP messages sent using the HTTP transfer.
+func (h *Handler) Post(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(chi.URLParam(r, "provisionerID"))
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusBadRequest, err, "cmp.Post; error url unescaping provisioner id"))
+		return
+	}
+	p, err := h.Auth.LoadProvisionerByName(name)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	der, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPKIMessageSize))
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusBadRequest, err, "cmp.Post; error reading request"))
+		return
+	}
+	resp, err := h.Auth.PKIOperation(r.Context(), p, der)
+	if resp == nil {
+		api.WriteError(w, err)
+		return
+	}
+	// Rejected requests are answered with an error message.
+	if err != nil {
+		api.LogError(w, err)
+	}
+	w.Header().Set("Content-Type", contentTypePKIXCMP)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(resp); err != nil {
+		api.LogError(w, err)
+	}
+}
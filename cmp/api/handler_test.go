@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/cmp"
+)
+
+type mockSignAuth struct {
+	provisioner  provisioner.Interface
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func (m *mockSignAuth) SignVerified(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) Revoke(ctx context.Context, opts *authority.RevokeOptions) error {
+	return errors.New("not implemented")
+}
+
+func (m *mockSignAuth) LoadProvisionerByID(id string) (provisioner.Interface, error) {
+	if id == m.provisioner.GetID() {
+		return m.provisioner, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockSignAuth) GetRootCertificates() []*x509.Certificate {
+	return []*x509.Certificate{m.intermediate}
+}
+
+func (m *mockSignAuth) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	return m.intermediate, m.signer
+}
+
+func mustSignAuth(t *testing.T) *mockSignAuth {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Intermediate CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	assert.FatalError(t, err)
+	intermediate, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+
+	disableRenewal := false
+	p := &provisioner.CMP{Type: "CMP", Name: "my cmp", SharedSecret: "secret"}
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: provisioner.Claims{
+		MinTLSDur:      &provisioner.Duration{Duration: 5 * time.Minute},
+		MaxTLSDur:      &provisioner.Duration{Duration: 24 * time.Hour},
+		DefaultTLSDur:  &provisioner.Duration{Duration: 24 * time.Hour},
+		DisableRenewal: &disableRenewal,
+	}}))
+	return &mockSignAuth{provisioner: p, intermediate: intermediate, signer: key}
+}
+
+// unprotectedCertConf returns a certConf message without protection.
+func unprotectedCertConf(t *testing.T) []byte {
+	t.Helper()
+	header, err := asn1.Marshal(cmp.Header{
+		PVNO:      2,
+		Sender:    asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: []byte{0x30, 0x00}},
+		Recipient: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: []byte{0x30, 0x00}},
+	})
+	assert.FatalError(t, err)
+	body, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: int(cmp.CertConf), IsCompound: true, Bytes: asn1.NullBytes})
+	assert.FatalError(t, err)
+	b, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: append(header, body...)})
+	assert.FatalError(t, err)
+	return b
+}
+
+func TestHandler_Post(t *testing.T) {
+	sa := mustSignAuth(t)
+	mux := chi.NewRouter()
+	New(cmp.NewAuthority(sa)).Route(mux)
+
+	tests := []struct {
+		name        string
+		target      string
+		body        []byte
+		status      int
+		contentType string
+	}{
+		{"ok rejected", "/my%20cmp", unprotectedCertConf(t), 200, contentTypePKIXCMP},
+		{"fail provisioner", "/other", unprotectedCertConf(t), 404, "application/json"},
+		{"fail message", "/my%20cmp", []byte("foo"), 400, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://ca.smallstep.com"+tt.target, bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			res := w.Result()
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			assert.Equals(t, tt.status, res.StatusCode)
+			assert.Equals(t, tt.contentType, res.Header.Get("Content-Type"))
+			if tt.contentType == contentTypePKIXCMP {
+				msg, err := cmp.ParsePKIMessage(body)
+				assert.FatalError(t, err)
+				assert.Equals(t, cmp.Error, msg.BodyType)
+				assert.Equals(t, []*x509.Certificate{sa.intermediate}, msg.ExtraCerts)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package cmp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// SignAuthority is the interface implemented by a CA authority.
+type SignAuthority interface {
+	SignVerified(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error)
+	Revoke(ctx context.Context, opts *authority.RevokeOptions) error
+	LoadProvisionerByID(string) (provisioner.Interface, error)
+	GetRootCertificates() []*x509.Certificate
+	GetIntermediate() (*x509.Certificate, crypto.Signer)
+}
+
+// Authority is the layer that handles all CMP interactions.
+type Authority struct {
+	signAuth SignAuthority
+}
+
+// NewAuthority returns a new CMP authority.
+func NewAuthority(signAuth SignAuthority) *Authority {
+	return &Authority{
+		signAuth: signAuth,
+	}
+}
+
+// LoadProvisionerByName loads the CMP provisioner with the given name.
+func (a *Authority) LoadProvisionerByName(name string) (*provisioner.CMP, error) {
+	p, err := a.signAuth.LoadProvisionerByID("cmp/" + name)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusNotFound, err, "cmp.LoadProvisionerByName; provisioner %s not found", name)
+	}
+	cp, ok := p.(*provisioner.CMP)
+	if !ok {
+		return nil, errs.NotFound("cmp.LoadProvisionerByName; provisioner %s is not a cmp provisioner", name)
+	}
+	return cp, nil
+}
+
+// transaction holds the state required to answer a request.
+type transaction struct {
+	req          *PKIMessage
+	protection   protection
+	secret       []byte
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+// failure is an error with the failure info sent in the error message.
+type failure struct {
+	info FailureInfo
+	err  error
+}
+
+func fail(info FailureInfo, err error) *failure {
+	return &failure{info: info, err: err}
+}
+
+// PKIOperation processes a CMP request and returns the DER encoded response.
+// If the request is rejected the response is an error message with the
+// failure and the error with the reason is also returned. A nil response is
+// returned if the request cannot be answered.
+func (a *Authority) PKIOperation(ctx context.Context, p *provisioner.CMP, der []byte) ([]byte, error) {
+	msg, err := ParsePKIMessage(der)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation; error parsing message")
+	}
+	intermediate, signer := a.signAuth.GetIntermediate()
+	if intermediate == nil || signer == nil {
+		return nil, errs.InternalServer("cmp.PKIOperation; intermediate is not configured")
+	}
+	tx := &transaction{
+		req:          msg,
+		intermediate: intermediate,
+		signer:       signer,
+	}
+
+	var bodyType BodyType
+	var content []byte
+	var f *failure
+	if tx.protection, err = msg.verifyProtection(p.GetSharedSecret()); err != nil {
+		f = fail(BadMessageCheck, errs.Wrap(http.StatusUnauthorized, err, "cmp.PKIOperation"))
+	} else {
+		if tx.protection == macProtection {
+			tx.secret = p.GetSharedSecret()
+		}
+		switch msg.BodyType {
+		case IR, CR:
+			bodyType, content, f = a.certify(ctx, p, tx)
+		case P10CR:
+			bodyType, content, f = a.certifyPKCS10(ctx, p, tx)
+		case KUR:
+			bodyType, content, f = a.keyUpdate(ctx, tx)
+		case RR:
+			bodyType, content, f = a.revoke(ctx, tx)
+		case CertConf:
+			bodyType, content = PKIConf, asn1.NullBytes
+		default:
+			f = fail(BadRequest, errs.BadRequest("cmp.PKIOperation; message type %s is not supported", msg.BodyType))
+		}
+	}
+
+	if f != nil {
+		b, e := asn1.Marshal(errorMsgContent{
+			PKIStatusInfo: newStatusInfo(StatusRejection, f.info, errorMessage(f.err)),
+		})
+		if e != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, e, "cmp.PKIOperation; error creating response")
+		}
+		resp, e := tx.response(Error, b, false)
+		if e != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, e, "cmp.PKIOperation; error creating response")
+		}
+		return resp, f.err
+	}
+
+	resp, err := tx.response(bodyType, content, bodyType != PKIConf && bodyType != RP && msg.Header.hasImplicitConfirm())
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "cmp.PKIOperation; error creating response")
+	}
+	return resp, nil
+}
+
+// errorMessage returns the message of the error sent to the client.
+func errorMessage(err error) string {
+	if e, ok := err.(*errs.Error); ok {
+		return e.Message()
+	}
+	return "The request could not be processed"
+}
+
+// singleCertRequest parses the content of the request and returns its
+// certificate request, only one request per message is supported.
+func singleCertRequest(content []byte) (*CertRequest, *failure) {
+	reqs, err := parseCertReqMessages(content)
+	if err != nil {
+		return nil, fail(BadDataFormat, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation"))
+	}
+	if len(reqs) != 1 {
+		return nil, fail(BadRequest, errs.BadRequest("cmp.PKIOperation; messages with %d requests are not supported", len(reqs)))
+	}
+	if err := reqs[0].VerifyProofOfPossession(); err != nil {
+		return nil, fail(BadPOP, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation"))
+	}
+	return reqs[0], nil
+}
+
+// certify processes an initialization or certification request. MAC
+// protected requests are authorized by the shared secret, signature protected
+// ones must be signed by a certificate trusted by the provisioner.
+func (a *Authority) certify(ctx context.Context, p *provisioner.CMP, tx *transaction) (BodyType, []byte, *failure) {
+	if tx.protection == signatureProtection {
+		if err := p.VerifyCertificate(tx.req.ExtraCerts[0], tx.req.ExtraCerts[1:]); err != nil {
+			return 0, nil, fail(SignerNotTrusted, err)
+		}
+	}
+	req, f := singleCertRequest(tx.req.Content)
+	if f != nil {
+		return 0, nil, f
+	}
+	csr, err := req.Template.CertificateRequest()
+	if err != nil {
+		return 0, nil, fail(BadCertTemplate, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation"))
+	}
+	opts := provisioner.Options{}
+	if !req.Template.NotBefore.IsZero() {
+		opts.NotBefore = provisioner.NewTimeDuration(req.Template.NotBefore)
+	}
+	if !req.Template.NotAfter.IsZero() {
+		opts.NotAfter = provisioner.NewTimeDuration(req.Template.NotAfter)
+	}
+	certs, f := a.sign(ctx, p, csr, opts)
+	if f != nil {
+		return 0, nil, f
+	}
+
+	// Initialization responses include the roots of the CA.
+	var caPubs []*x509.Certificate
+	if tx.req.BodyType == IR {
+		caPubs = a.signAuth.GetRootCertificates()
+	}
+	b, err := newCertRepMessage(req.ID, certs[0], caPubs)
+	if err != nil {
+		return 0, nil, fail(SystemFailure, errs.Wrap(http.StatusInternalServerError, err, "cmp.PKIOperation"))
+	}
+	return tx.req.BodyType + 1, b, nil
+}
+
+// certifyPKCS10 processes a PKCS #10 certification request, authorized as
+// the certification requests.
+func (a *Authority) certifyPKCS10(ctx context.Context, p *provisioner.CMP, tx *transaction) (BodyType, []byte, *failure) {
+	if tx.protection == signatureProtection {
+		if err := p.VerifyCertificate(tx.req.ExtraCerts[0], tx.req.ExtraCerts[1:]); err != nil {
+			return 0, nil, fail(SignerNotTrusted, err)
+		}
+	}
+	csr, err := x509.ParseCertificateRequest(tx.req.Content)
+	if err != nil {
+		return 0, nil, fail(BadDataFormat, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation; error parsing certificate request"))
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return 0, nil, fail(BadPOP, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation; error verifying certificate request"))
+	}
+	certs, f := a.sign(ctx, p, csr, provisioner.Options{})
+	if f != nil {
+		return 0, nil, f
+	}
+	b, err := newCertRepMessage(0, certs[0], nil)
+	if err != nil {
+		return 0, nil, fail(SystemFailure, errs.Wrap(http.StatusInternalServerError, err, "cmp.PKIOperation"))
+	}
+	return CP, b, nil
+}
+
+func (a *Authority) sign(ctx context.Context, p *provisioner.CMP, csr *x509.CertificateRequest, opts provisioner.Options) ([]*x509.Certificate, *failure) {
+	signOps, err := p.AuthorizeSign(ctx, "")
+	if err != nil {
+		return nil, fail(NotAuthorized, err)
+	}
+	certs, err := a.signAuth.SignVerified(csr, opts, signOps...)
+	if err != nil {
+		return nil, fail(BadCertTemplate, err)
+	}
+	return certs, nil
+}
+
+// keyUpdate processes a key update request. The request must be signed by
+// the current certificate, and the new certificate keeps the attributes of
+// the current one with the key in the request.
+func (a *Authority) keyUpdate(ctx context.Context, tx *transaction) (BodyType, []byte, *failure) {
+	cert, f := a.issuedCertificate(tx)
+	if f != nil {
+		return 0, nil, f
+	}
+	req, f := singleCertRequest(tx.req.Content)
+	if f != nil {
+		return 0, nil, f
+	}
+	certs, err := a.signAuth.Rekey(cert, req.Template.PublicKey)
+	if err != nil {
+		return 0, nil, fail(NotAuthorized, err)
+	}
+	b, err := newCertRepMessage(req.ID, certs[0], nil)
+	if err != nil {
+		return 0, nil, fail(SystemFailure, errs.Wrap(http.StatusInternalServerError, err, "cmp.PKIOperation"))
+	}
+	return KUP, b, nil
+}
+
+// revoke processes a revocation request. The request must be signed by the
+// certificate that is being revoked.
+func (a *Authority) revoke(ctx context.Context, tx *transaction) (BodyType, []byte, *failure) {
+	cert, f := a.issuedCertificate(tx)
+	if f != nil {
+		return 0, nil, f
+	}
+	reqs, err := parseRevReqContent(tx.req.Content)
+	if err != nil {
+		return 0, nil, fail(BadDataFormat, errs.Wrap(http.StatusBadRequest, err, "cmp.PKIOperation"))
+	}
+	if len(reqs) != 1 {
+		return 0, nil, fail(BadRequest, errs.BadRequest("cmp.PKIOperation; messages with %d requests are not supported", len(reqs)))
+	}
+	req := reqs[0]
+	if req.Template.SerialNumber == nil || req.Template.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return 0, nil, fail(BadCertID, errs.Unauthorized("cmp.PKIOperation; revocation request is not signed by the certificate to revoke"))
+	}
+	if req.Template.Issuer != nil && !bytes.Equal(req.Template.Issuer, cert.RawIssuer) {
+		return 0, nil, fail(BadCertID, errs.BadRequest("cmp.PKIOperation; revocation request issuer does not match"))
+	}
+
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.RevokeMethod)
+	if err := a.signAuth.Revoke(ctx, &authority.RevokeOptions{
+		Serial:     cert.SerialNumber.String(),
+		ReasonCode: req.ReasonCode,
+		MTLS:       true,
+		Crt:        cert,
+	}); err != nil {
+		return 0, nil, fail(BadRequest, err)
+	}
+	b, err := asn1.Marshal(revRepContent{
+		Status: []pkiStatusInfo{newStatusInfo(StatusAccepted, 0, "")},
+	})
+	if err != nil {
+		return 0, nil, fail(SystemFailure, errs.Wrap(http.StatusInternalServerError, err, "cmp.PKIOperation"))
+	}
+	return RP, b, nil
+}
+
+// issuedCertificate returns the protection certificate of a signature
+// protected request if it's a valid certificate issued by the CA.
+func (a *Authority) issuedCertificate(tx *transaction) (*x509.Certificate, *failure) {
+	if tx.protection != signatureProtection {
+		return nil, fail(NotAuthorized, errs.Unauthorized("cmp.PKIOperation; %s messages must be signature protected", tx.req.BodyType))
+	}
+	cert := tx.req.ExtraCerts[0]
+	roots := x509.NewCertPool()
+	for _, crt := range a.signAuth.GetRootCertificates() {
+		roots.AddCert(crt)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(tx.intermediate)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fail(SignerNotTrusted, errs.Wrap(http.StatusUnauthorized, err, "cmp.PKIOperation; certificate is not issued by the CA"))
+	}
+	return cert, nil
+}
+
+// newCertRepMessage returns the DER encoded response with the issued
+// certificate.
+func newCertRepMessage(id int64, cert *x509.Certificate, caPubs []*x509.Certificate) ([]byte, error) {
+	rep := certRepMessage{
+		Response: []certResponse{{
+			CertReqID: id,
+			Status:    newStatusInfo(StatusAccepted, 0, ""),
+			CertifiedKeyPair: certifiedKeyPair{
+				CertOrEncCert: asn1.RawValue{
+					Class:      asn1.ClassContextSpecific,
+					Tag:        0,
+					IsCompound: true,
+					Bytes:      cert.Raw,
+				},
+			},
+		}},
+	}
+	for _, crt := range caPubs {
+		rep.CAPubs = append(rep.CAPubs, asn1.RawValue{FullBytes: crt.Raw})
+	}
+	return asn1.Marshal(rep)
+}
+
+// response creates the response of the transaction with the given body.
+// Responses to MAC protected requests are protected with the same secret and
+// parameters, and the rest are signed by the intermediate.
+func (tx *transaction) response(bodyType BodyType, content []byte, implicitConfirm bool) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "error generating nonce")
+	}
+	header := &Header{
+		PVNO:          2,
+		Sender:        directoryName(tx.intermediate.RawSubject),
+		Recipient:     tx.req.Header.Sender,
+		MessageTime:   time.Now().UTC(),
+		TransactionID: tx.req.Header.TransactionID,
+		SenderNonce:   nonce,
+		RecipNonce:    tx.req.Header.SenderNonce,
+	}
+	if implicitConfirm {
+		header.GeneralInfo = []InfoTypeAndValue{{Type: oidImplicitConfirm, Value: asn1.NullRawValue}}
+	}
+
+	extraCerts := []*x509.Certificate{tx.intermediate}
+	var protect func([]byte) ([]byte, error)
+	if tx.secret != nil {
+		header.ProtectionAlg = tx.req.Header.ProtectionAlg
+		header.SenderKID = tx.req.Header.SenderKID
+		protect = func(data []byte) ([]byte, error) {
+			return passwordBasedMAC(header.ProtectionAlg, tx.secret, data)
+		}
+	} else {
+		alg, h, err := signatureAlgorithmIdentifier(tx.signer.Public())
+		if err != nil {
+			return nil, err
+		}
+		header.ProtectionAlg = alg
+		header.SenderKID = tx.intermediate.SubjectKeyId
+		protect = func(data []byte) ([]byte, error) {
+			return sign(tx.signer, h, data)
+		}
+	}
+	return marshalMessage(header, bodyType, content, extraCerts, protect)
+}
@@ -0,0 +1,406 @@
+package cmp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+type mockSignAuth struct {
+	signVerified func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	rekey        func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error)
+	revoke       func(ctx context.Context, opts *authority.RevokeOptions) error
+	provisioners map[string]provisioner.Interface
+	roots        []*x509.Certificate
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func (m *mockSignAuth) SignVerified(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	if m.signVerified != nil {
+		return m.signVerified(csr, signOpts, extraOpts...)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+	if m.rekey != nil {
+		return m.rekey(oldCert, pk)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) Revoke(ctx context.Context, opts *authority.RevokeOptions) error {
+	if m.revoke != nil {
+		return m.revoke(ctx, opts)
+	}
+	return errors.New("not implemented")
+}
+
+func (m *mockSignAuth) LoadProvisionerByID(id string) (provisioner.Interface, error) {
+	if p, ok := m.provisioners[id]; ok {
+		return p, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockSignAuth) GetRootCertificates() []*x509.Certificate {
+	return m.roots
+}
+
+func (m *mockSignAuth) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	return m.intermediate, m.signer
+}
+
+func mustProvisioner(t *testing.T, p *provisioner.CMP) *provisioner.CMP {
+	t.Helper()
+	disableRenewal := false
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: provisioner.Claims{
+		MinTLSDur:      &provisioner.Duration{Duration: 5 * time.Minute},
+		MaxTLSDur:      &provisioner.Duration{Duration: 24 * time.Hour},
+		DefaultTLSDur:  &provisioner.Duration{Duration: 24 * time.Hour},
+		DisableRenewal: &disableRenewal,
+	}}))
+	return p
+}
+
+// issue returns a sign function that issues the certificate with the
+// intermediate of the CA.
+func (ca *testCA) issue(t *testing.T) func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+		crt := mustCertificate(t, &x509.Certificate{
+			Subject:  csr.Subject,
+			DNSNames: csr.DNSNames,
+		}, ca.intermediate, csr.PublicKey, ca.signer)
+		return []*x509.Certificate{crt, ca.intermediate}, nil
+	}
+}
+
+func TestAuthority_LoadProvisionerByName(t *testing.T) {
+	p := mustProvisioner(t, &provisioner.CMP{Type: "CMP", Name: "cmp", SharedSecret: "secret"})
+	a := NewAuthority(&mockSignAuth{provisioners: map[string]provisioner.Interface{
+		"cmp/cmp":   p,
+		"cmp/other": &provisioner.SCEP{Type: "SCEP", Name: "other"},
+	}})
+
+	got, err := a.LoadProvisionerByName("cmp")
+	assert.FatalError(t, err)
+	assert.Equals(t, p, got)
+
+	for _, name := range []string{"other", "missing"} {
+		_, err := a.LoadProvisionerByName(name)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusNotFound, sc.StatusCode())
+	}
+}
+
+type response struct {
+	msg        *PKIMessage
+	protection protection
+	certs      []*x509.Certificate
+	caPubs     []*x509.Certificate
+	failInfo   asn1.BitString
+}
+
+func parseResponse(t *testing.T, der, secret []byte) *response {
+	t.Helper()
+	msg, err := ParsePKIMessage(der)
+	assert.FatalError(t, err)
+	r := &response{msg: msg}
+	r.protection, err = msg.verifyProtection(secret)
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("transaction"), msg.Header.TransactionID)
+	assert.Equals(t, []byte("nonce"), msg.Header.RecipNonce)
+	assert.Len(t, 16, msg.Header.SenderNonce)
+
+	switch msg.BodyType {
+	case IP, CP, KUP:
+		var rep certRepMessage
+		_, err := asn1.Unmarshal(msg.Content, &rep)
+		assert.FatalError(t, err)
+		assert.Len(t, 1, rep.Response)
+		assert.Equals(t, int(StatusAccepted), rep.Response[0].Status.Status)
+		crt, err := x509.ParseCertificate(rep.Response[0].CertifiedKeyPair.CertOrEncCert.Bytes)
+		assert.FatalError(t, err)
+		r.certs = append(r.certs, crt)
+		for _, raw := range rep.CAPubs {
+			crt, err := x509.ParseCertificate(raw.FullBytes)
+			assert.FatalError(t, err)
+			r.caPubs = append(r.caPubs, crt)
+		}
+	case RP:
+		var rep revRepContent
+		_, err := asn1.Unmarshal(msg.Content, &rep)
+		assert.FatalError(t, err)
+		assert.Len(t, 1, rep.Status)
+		assert.Equals(t, int(StatusAccepted), rep.Status[0].Status)
+	case Error:
+		var rep errorMsgContent
+		_, err := asn1.Unmarshal(msg.Content, &rep)
+		assert.FatalError(t, err)
+		assert.Equals(t, int(StatusRejection), rep.PKIStatusInfo.Status)
+		r.failInfo = rep.PKIStatusInfo.FailInfo
+	}
+	return r
+}
+
+func TestAuthority_PKIOperation(t *testing.T) {
+	ca := mustCA(t, "Test")
+	vendor := mustCA(t, "Vendor")
+	secret := []byte("secret")
+
+	p := mustProvisioner(t, &provisioner.CMP{
+		Type:         "CMP",
+		Name:         "cmp",
+		SharedSecret: string(secret),
+		Roots:        pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vendor.root.Raw}),
+	})
+	onlyRoots := mustProvisioner(t, &provisioner.CMP{
+		Type:  "CMP",
+		Name:  "cmp",
+		Roots: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vendor.root.Raw}),
+	})
+
+	key := mustKey(t)
+	deviceCert, deviceKey := vendor.mustLeaf(t, "device")
+	leaf, leafKey := ca.mustLeaf(t, "leaf")
+	otherLeaf, _ := ca.mustLeaf(t, "other")
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  otherLeaf.Subject,
+		DNSNames: []string{"p10.example.com"},
+	}, key)
+	assert.FatalError(t, err)
+	badCSR := append([]byte{}, csrDER...)
+	badCSR[len(badCSR)-1] ^= 0xff
+
+	ir := newCertReqMessages(t, key.Public(), key, certTemplateOptions{subject: "device"})
+	withMAC := messageOptions{secret: secret}
+	withDevice := messageOptions{signer: deviceKey, extraCerts: []*x509.Certificate{deviceCert, vendor.intermediate}}
+	withLeaf := messageOptions{signer: leafKey, extraCerts: []*x509.Certificate{leaf}}
+
+	type test struct {
+		p            *provisioner.CMP
+		der          []byte
+		secret       []byte
+		signVerified func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+		rekey        func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error)
+		revoke       func(ctx context.Context, opts *authority.RevokeOptions) error
+		noSigner     bool
+		wantType     BodyType
+		wantFail     FailureInfo
+		code         int
+		check        func(t *testing.T, r *response)
+	}
+	tests := map[string]test{
+		"ok ir mac": {
+			p: p, der: newMessage(t, IR, ir, withMAC), secret: secret,
+			signVerified: func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+				assert.Equals(t, "device", csr.Subject.CommonName)
+				assert.Equals(t, key.Public(), csr.PublicKey)
+				assert.Len(t, 7, extraOpts)
+				return ca.issue(t)(csr, signOpts, extraOpts...)
+			},
+			wantType: IP,
+			check: func(t *testing.T, r *response) {
+				assert.Equals(t, macProtection, r.protection)
+				assert.Equals(t, []byte("kid"), r.msg.Header.SenderKID)
+				assert.Equals(t, "device", r.certs[0].Subject.CommonName)
+				assert.Equals(t, []*x509.Certificate{ca.root}, r.caPubs)
+				assert.False(t, r.msg.Header.hasImplicitConfirm())
+			},
+		},
+		"ok ir implicit confirm": {
+			p: p, der: newMessage(t, IR, ir, messageOptions{secret: secret, implicitConfirm: true}), secret: secret,
+			signVerified: ca.issue(t),
+			wantType:     IP,
+			check: func(t *testing.T, r *response) {
+				assert.True(t, r.msg.Header.hasImplicitConfirm())
+			},
+		},
+		"ok ir signature": {
+			p: p, der: newMessage(t, IR, ir, withDevice),
+			signVerified: ca.issue(t),
+			wantType:     IP,
+			check: func(t *testing.T, r *response) {
+				assert.Equals(t, signatureProtection, r.protection)
+				assert.Equals(t, ca.intermediate, r.msg.ExtraCerts[0])
+				assert.Equals(t, ca.intermediate.SubjectKeyId, r.msg.Header.SenderKID)
+			},
+		},
+		"ok cr validity": {
+			p: p, der: newMessage(t, CR, newCertReqMessages(t, key.Public(), key, certTemplateOptions{
+				subject:  "device",
+				notAfter: time.Now().Add(time.Hour).Truncate(time.Second),
+			}), withMAC), secret: secret,
+			signVerified: func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+				assert.True(t, signOpts.NotBefore.IsZero())
+				assert.False(t, signOpts.NotAfter.IsZero())
+				return ca.issue(t)(csr, signOpts, extraOpts...)
+			},
+			wantType: CP,
+			check: func(t *testing.T, r *response) {
+				assert.Len(t, 0, r.caPubs)
+			},
+		},
+		"ok p10cr": {
+			p: p, der: newMessage(t, P10CR, csrDER, withMAC), secret: secret,
+			signVerified: ca.issue(t),
+			wantType:     CP,
+			check: func(t *testing.T, r *response) {
+				assert.Equals(t, []string{"p10.example.com"}, r.certs[0].DNSNames)
+			},
+		},
+		"ok kur": {
+			p: p, der: newMessage(t, KUR, ir, withLeaf),
+			rekey: func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+				assert.Equals(t, leaf, oldCert)
+				assert.Equals(t, key.Public(), pk)
+				crt := mustCertificate(t, &x509.Certificate{Subject: oldCert.Subject}, ca.intermediate, pk, ca.signer)
+				return []*x509.Certificate{crt, ca.intermediate}, nil
+			},
+			wantType: KUP,
+			check: func(t *testing.T, r *response) {
+				assert.Equals(t, "leaf", r.certs[0].Subject.CommonName)
+			},
+		},
+		"ok rr": {
+			p: p, der: newMessage(t, RR, newRevReqContent(t, leaf.SerialNumber, leaf.RawIssuer, 1), withLeaf),
+			revoke: func(ctx context.Context, opts *authority.RevokeOptions) error {
+				assert.Equals(t, provisioner.RevokeMethod, provisioner.MethodFromContext(ctx))
+				assert.Equals(t, leaf.SerialNumber.String(), opts.Serial)
+				assert.Equals(t, 1, opts.ReasonCode)
+				assert.True(t, opts.MTLS)
+				assert.Equals(t, leaf, opts.Crt)
+				return nil
+			},
+			wantType: RP,
+		},
+		"ok certConf": {
+			p: p, der: newMessage(t, CertConf, asn1.NullBytes, messageOptions{secret: secret, implicitConfirm: true}), secret: secret,
+			wantType: PKIConf,
+			check: func(t *testing.T, r *response) {
+				assert.False(t, r.msg.Header.hasImplicitConfirm())
+			},
+		},
+		"fail parse": {
+			p: p, der: []byte("foo"), code: http.StatusBadRequest,
+		},
+		"fail intermediate": {
+			p: p, der: newMessage(t, IR, ir, withMAC), noSigner: true, code: http.StatusInternalServerError,
+		},
+		"fail mac": {
+			p: p, der: newMessage(t, IR, ir, messageOptions{secret: []byte("other")}),
+			wantType: Error, wantFail: BadMessageCheck, code: http.StatusUnauthorized,
+		},
+		"fail mac not allowed": {
+			p: onlyRoots, der: newMessage(t, IR, ir, withMAC),
+			wantType: Error, wantFail: BadMessageCheck, code: http.StatusUnauthorized,
+		},
+		"fail ir signer not trusted": {
+			p: p, der: newMessage(t, IR, ir, withLeaf),
+			wantType: Error, wantFail: SignerNotTrusted, code: http.StatusUnauthorized,
+		},
+		"fail ir pop": {
+			p: p, der: newMessage(t, IR, newCertReqMessages(t, key.Public(), mustKey(t), certTemplateOptions{subject: "device"}), withMAC), secret: secret,
+			wantType: Error, wantFail: BadPOP, code: http.StatusBadRequest,
+		},
+		"fail ir format": {
+			p: p, der: newMessage(t, IR, asn1.NullBytes, withMAC), secret: secret,
+			wantType: Error, wantFail: BadDataFormat, code: http.StatusBadRequest,
+		},
+		"fail ir sign": {
+			p: p, der: newMessage(t, IR, ir, withMAC), secret: secret,
+			signVerified: func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+				return nil, errs.Forbidden("not allowed")
+			},
+			wantType: Error, wantFail: BadCertTemplate, code: http.StatusForbidden,
+		},
+		"fail p10cr signature": {
+			p: p, der: newMessage(t, P10CR, badCSR, withMAC), secret: secret,
+			wantType: Error, code: http.StatusBadRequest,
+		},
+		"fail kur mac": {
+			p: p, der: newMessage(t, KUR, ir, withMAC), secret: secret,
+			wantType: Error, wantFail: NotAuthorized, code: http.StatusUnauthorized,
+		},
+		"fail kur not issued": {
+			p: p, der: newMessage(t, KUR, ir, withDevice),
+			wantType: Error, wantFail: SignerNotTrusted, code: http.StatusUnauthorized,
+		},
+		"fail kur rekey": {
+			p: p, der: newMessage(t, KUR, ir, withLeaf),
+			rekey: func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+				return nil, errs.Unauthorized("renew is disabled")
+			},
+			wantType: Error, wantFail: NotAuthorized, code: http.StatusUnauthorized,
+		},
+		"fail rr serial": {
+			p: p, der: newMessage(t, RR, newRevReqContent(t, otherLeaf.SerialNumber, nil, 0), withLeaf),
+			wantType: Error, wantFail: BadCertID, code: http.StatusUnauthorized,
+		},
+		"fail rr issuer": {
+			p: p, der: newMessage(t, RR, newRevReqContent(t, leaf.SerialNumber, vendor.intermediate.RawSubject, 0), withLeaf),
+			wantType: Error, wantFail: BadCertID, code: http.StatusBadRequest,
+		},
+		"fail rr revoke": {
+			p: p, der: newMessage(t, RR, newRevReqContent(t, leaf.SerialNumber, nil, 0), withLeaf),
+			revoke: func(ctx context.Context, opts *authority.RevokeOptions) error {
+				return errs.BadRequest("already revoked")
+			},
+			wantType: Error, wantFail: BadRequest, code: http.StatusBadRequest,
+		},
+		"fail unsupported": {
+			p: p, der: newMessage(t, BodyType(21), asn1.NullBytes, withMAC), secret: secret,
+			wantType: Error, wantFail: BadRequest, code: http.StatusBadRequest,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := &mockSignAuth{
+				signVerified: tt.signVerified,
+				rekey:        tt.rekey,
+				revoke:       tt.revoke,
+				roots:        []*x509.Certificate{ca.root},
+				intermediate: ca.intermediate,
+				signer:       ca.signer,
+			}
+			if tt.noSigner {
+				m.signer = nil
+			}
+			resp, err := NewAuthority(m).PKIOperation(context.Background(), tt.p, tt.der)
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+			} else {
+				assert.FatalError(t, err)
+			}
+			if tt.wantType == 0 && tt.code != 0 {
+				assert.Nil(t, resp)
+				return
+			}
+			r := parseResponse(t, resp, tt.secret)
+			assert.Equals(t, tt.wantType, r.msg.BodyType)
+			if tt.wantType == Error && tt.wantFail != 0 {
+				assert.Equals(t, 1, r.failInfo.At(int(tt.wantFail)))
+			}
+			if tt.check != nil {
+				tt.check(t, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package cmp implements the Certificate Management Protocol (CMP) version 2
+// defined in RFC 4210, with the Certificate Request Message Format (CRMF)
+// defined in RFC 4211.
+package cmp
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BodyType is the type of the body of a CMP message.
+type BodyType int
+
+const (
+	// IR is an initialization request.
+	IR BodyType = 0
+	// IP is an initialization response.
+	IP BodyType = 1
+	// CR is a certification request.
+	CR BodyType = 2
+	// CP is a certification response.
+	CP BodyType = 3
+	// P10CR is a PKCS #10 certification request.
+	P10CR BodyType = 4
+	// KUR is a key update request.
+	KUR BodyType = 7
+	// KUP is a key update response.
+	KUP BodyType = 8
+	// RR is a revocation request.
+	RR BodyType = 11
+	// RP is a revocation response.
+	RP BodyType = 12
+	// PKIConf is the confirmation message.
+	PKIConf BodyType = 19
+	// Error is an error message.
+	Error BodyType = 23
+	// CertConf is a certificate confirmation message.
+	CertConf BodyType = 24
+)
+
+// String returns the name of the body type used in RFC 4210.
+func (t BodyType) String() string {
+	switch t {
+	case IR:
+		return "ir"
+	case IP:
+		return "ip"
+	case CR:
+		return "cr"
+	case CP:
+		return "cp"
+	case P10CR:
+		return "p10cr"
+	case KUR:
+		return "kur"
+	case KUP:
+		return "kup"
+	case RR:
+		return "rr"
+	case RP:
+		return "rp"
+	case PKIConf:
+		return "pkiconf"
+	case Error:
+		return "error"
+	case CertConf:
+		return "certConf"
+	default:
+		return "unknown"
+	}
+}
+
+// PKIStatus is the status of a response.
+type PKIStatus int
+
+const (
+	// StatusAccepted indicates that the request was granted.
+	StatusAccepted PKIStatus = 0
+	// StatusRejection indicates that the request was rejected.
+	StatusRejection PKIStatus = 2
+)
+
+// FailureInfo is the bit of the PKIFailureInfo indicating the reason of a
+// rejected request.
+type FailureInfo int
+
+const (
+	// BadAlg indicates an unrecognized or unsupported algorithm.
+	BadAlg FailureInfo = 0
+	// BadMessageCheck indicates that the integrity check failed.
+	BadMessageCheck FailureInfo = 1
+	// BadRequest indicates that the transaction is not permitted or
+	// supported.
+	BadRequest FailureInfo = 2
+	// BadCertID indicates that no certificate could be found matching the
+	// provided criteria.
+	BadCertID FailureInfo = 4
+	// BadDataFormat indicates that the data submitted has the wrong format.
+	BadDataFormat FailureInfo = 5
+	// BadPOP indicates that the proof of possession failed.
+	BadPOP FailureInfo = 9
+	// BadCertTemplate indicates that the certificate template is not valid.
+	BadCertTemplate FailureInfo = 19
+	// SignerNotTrusted indicates that the signer of the message is unknown or
+	// not trusted.
+	SignerNotTrusted FailureInfo = 20
+	// NotAuthorized indicates that the transaction is not authorized.
+	NotAuthorized FailureInfo = 23
+	// SystemFailure indicates that the request cannot be handled due to a
+	// system failure.
+	SystemFailure FailureInfo = 25
+)
+
+var (
+	oidImplicitConfirm = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 4, 13}
+)
+
+// InfoTypeAndValue is an entry of the general info of a header.
+type InfoTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+// Header is the header of a CMP message.
+type Header struct {
+	PVNO          int
+	Sender        asn1.RawValue
+	Recipient     asn1.RawValue
+	MessageTime   time.Time                `asn1:"optional,explicit,tag:0,generalized"`
+	ProtectionAlg pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SenderKID     []byte                   `asn1:"optional,explicit,tag:2"`
+	RecipKID      []byte                   `asn1:"optional,explicit,tag:3"`
+	TransactionID []byte                   `asn1:"optional,explicit,tag:4"`
+	SenderNonce   []byte                   `asn1:"optional,explicit,tag:5"`
+	RecipNonce    []byte                   `asn1:"optional,explicit,tag:6"`
+	FreeText      asn1.RawValue            `asn1:"optional,explicit,tag:7"`
+	GeneralInfo   []InfoTypeAndValue       `asn1:"optional,explicit,tag:8"`
+}
+
+// hasImplicitConfirm returns true if the general info of the header includes
+// the implicit confirm entry.
+func (h *Header) hasImplicitConfirm() bool {
+	for _, info := range h.GeneralInfo {
+		if info.Type.Equal(oidImplicitConfirm) {
+			return true
+		}
+	}
+	return false
+}
+
+type pkiMessage struct {
+	Header     asn1.RawValue
+	Body       asn1.RawValue
+	Protection asn1.BitString  `asn1:"optional,explicit,tag:0"`
+	ExtraCerts []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+}
+
+type protectedPart struct {
+	Header asn1.RawValue
+	Body   asn1.RawValue
+}
+
+// PKIMessage is a parsed CMP message.
+type PKIMessage struct {
+	Header   Header
+	BodyType BodyType
+	// Content is the DER encoded content of the body.
+	Content    []byte
+	Protection []byte
+	// ExtraCerts are the extra certificates of the message, the first one
+	// must be the protection certificate in signature protected messages.
+	ExtraCerts    []*x509.Certificate
+	protectedPart []byte
+}
+
+// ParsePKIMessage parses a DER encoded CMP message. The protection of the
+// message is not verified.
+func ParsePKIMessage(der []byte) (*PKIMessage, error) {
+	var m pkiMessage
+	rest, err := asn1.Unmarshal(der, &m)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing cmp message")
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("error parsing cmp message: trailing data")
+	}
+	msg := &PKIMessage{}
+	if _, err := asn1.Unmarshal(m.Header.FullBytes, &msg.Header); err != nil {
+		return nil, errors.Wrap(err, "error parsing cmp message header")
+	}
+	if m.Body.Class != asn1.ClassContextSpecific || !m.Body.IsCompound {
+		return nil, errors.New("error parsing cmp message: invalid body")
+	}
+	msg.BodyType = BodyType(m.Body.Tag)
+	msg.Content = m.Body.Bytes
+	msg.Protection = m.Protection.RightAlign()
+	for _, raw := range m.ExtraCerts {
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing cmp message extra certificates")
+		}
+		msg.ExtraCerts = append(msg.ExtraCerts, cert)
+	}
+	if msg.protectedPart, err = asn1.Marshal(protectedPart{Header: m.Header, Body: m.Body}); err != nil {
+		return nil, errors.Wrap(err, "error marshaling cmp message")
+	}
+	return msg, nil
+}
+
+// marshalMessage creates the DER encoded CMP message with the given header
+// and body. The header protection algorithm must be set, the protect function
+// is called with the protected part of the message.
+func marshalMessage(header *Header, bodyType BodyType, content []byte, extraCerts []*x509.Certificate, protect func([]byte) ([]byte, error)) ([]byte, error) {
+	headerBytes, err := asn1.Marshal(*header)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling cmp message header")
+	}
+	m := pkiMessage{
+		Header: asn1.RawValue{FullBytes: headerBytes},
+		Body: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        int(bodyType),
+			IsCompound: true,
+			Bytes:      content,
+		},
+	}
+	part, err := asn1.Marshal(protectedPart{Header: m.Header, Body: m.Body})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling cmp message")
+	}
+	protection, err := protect(part)
+	if err != nil {
+		return nil, err
+	}
+	m.Protection = asn1.BitString{Bytes: protection, BitLength: 8 * len(protection)}
+	for _, crt := range extraCerts {
+		m.ExtraCerts = append(m.ExtraCerts, asn1.RawValue{FullBytes: crt.Raw})
+	}
+	return asn1.Marshal(m)
+}
+
+// directoryName returns the GeneralName with the given DER encoded name.
+func directoryName(rawName []byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        4,
+		IsCompound: true,
+		Bytes:      rawName,
+	}
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+// newStatusInfo returns a PKIStatusInfo with the given status, failure and
+// text.
+func newStatusInfo(status PKIStatus, failInfo FailureInfo, text string) pkiStatusInfo {
+	info := pkiStatusInfo{Status: int(status)}
+	if status == StatusRejection {
+		b := make([]byte, int(failInfo)/8+1)
+		b[failInfo/8] = 0x80 >> uint(failInfo%8)
+		info.FailInfo = asn1.BitString{Bytes: b, BitLength: int(failInfo) + 1}
+	}
+	if text != "" {
+		info.StatusString = []asn1.RawValue{{Tag: asn1.TagUTF8String, Bytes: []byte(text)}}
+	}
+	return info
+}
+
+type errorMsgContent struct {
+	PKIStatusInfo pkiStatusInfo
+}
+
+type certRepMessage struct {
+	CAPubs   []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	Response []certResponse
+}
+
+type certResponse struct {
+	CertReqID        int64
+	Status           pkiStatusInfo
+	CertifiedKeyPair certifiedKeyPair `asn1:"optional"`
+}
+
+type certifiedKeyPair struct {
+	CertOrEncCert asn1.RawValue
+}
+
+type revRepContent struct {
+	Status []pkiStatusInfo
+}
@@ -0,0 +1,348 @@
+package cmp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	return key
+}
+
+func mustCertificate(t *testing.T, tpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	if tpl.SerialNumber == nil {
+		tpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	if tpl.NotBefore.IsZero() {
+		tpl.NotBefore = time.Now().Add(-time.Minute)
+		tpl.NotAfter = time.Now().Add(time.Hour)
+	}
+	if parent == nil {
+		parent = tpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, signer)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return crt
+}
+
+type testCA struct {
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func mustCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	rootKey := mustKey(t)
+	root := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name + " Root CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, rootKey.Public(), rootKey)
+	key := mustKey(t)
+	intermediate := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name + " Intermediate CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		SubjectKeyId:          []byte("intermediate"),
+	}, root, key.Public(), rootKey)
+	return &testCA{root: root, intermediate: intermediate, signer: key}
+}
+
+// mustLeaf returns a certificate issued by the intermediate and its key.
+func (ca *testCA) mustLeaf(t *testing.T, cn string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key := mustKey(t)
+	return mustCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: cn},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, ca.intermediate, key.Public(), ca.signer), key
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := asn1.Marshal(v)
+	assert.FatalError(t, err)
+	return b
+}
+
+// contents returns the contents of a DER encoded value.
+func contents(t *testing.T, der []byte) []byte {
+	t.Helper()
+	var v asn1.RawValue
+	_, err := asn1.Unmarshal(der, &v)
+	assert.FatalError(t, err)
+	return v.Bytes
+}
+
+func tagged(tag int, content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: content}
+}
+
+func pbmAlgorithm(t *testing.T, iterationCount int) pkix.AlgorithmIdentifier {
+	t.Helper()
+	return pkix.AlgorithmIdentifier{
+		Algorithm: oidPasswordBasedMAC,
+		Parameters: asn1.RawValue{FullBytes: mustMarshal(t, pbmParameter{
+			Salt:           []byte("salt"),
+			OWF:            pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			IterationCount: iterationCount,
+			MAC:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256},
+		})},
+	}
+}
+
+type certTemplateOptions struct {
+	serial    *big.Int
+	issuer    []byte
+	subject   string
+	publicKey crypto.PublicKey
+	notAfter  time.Time
+}
+
+func newCertTemplate(t *testing.T, o certTemplateOptions) []byte {
+	t.Helper()
+	var fields []byte
+	if o.serial != nil {
+		fields = append(fields, mustMarshal(t, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: contents(t, mustMarshal(t, o.serial))})...)
+	}
+	if o.issuer != nil {
+		fields = append(fields, mustMarshal(t, tagged(3, o.issuer))...)
+	}
+	if !o.notAfter.IsZero() {
+		fields = append(fields, mustMarshal(t, tagged(4, mustMarshal(t, tagged(1, mustMarshal(t, o.notAfter.UTC())))))...)
+	}
+	if o.subject != "" {
+		name := mustMarshal(t, pkix.Name{CommonName: o.subject}.ToRDNSequence())
+		fields = append(fields, mustMarshal(t, tagged(5, name))...)
+	}
+	if o.publicKey != nil {
+		spki, err := x509.MarshalPKIXPublicKey(o.publicKey)
+		assert.FatalError(t, err)
+		fields = append(fields, mustMarshal(t, tagged(6, contents(t, spki)))...)
+	}
+	return mustMarshal(t, asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
+
+// newCertReqMessages returns the content of an ir, cr or kur with a single
+// request for the given key and subject, signed by the popKey.
+func newCertReqMessages(t *testing.T, key crypto.PublicKey, popKey crypto.Signer, o certTemplateOptions) []byte {
+	t.Helper()
+	o.publicKey = key
+	certReq := mustMarshal(t, certRequest{
+		CertReqID:    0,
+		CertTemplate: asn1.RawValue{FullBytes: newCertTemplate(t, o)},
+	})
+	alg, h, err := signatureAlgorithmIdentifier(popKey.Public())
+	assert.FatalError(t, err)
+	sig, err := sign(popKey, h, certReq)
+	assert.FatalError(t, err)
+	pop := append(mustMarshal(t, alg), mustMarshal(t, asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)})...)
+	msg := append(certReq, mustMarshal(t, tagged(1, pop))...)
+	return mustMarshal(t, []asn1.RawValue{{Tag: asn1.TagSequence, IsCompound: true, Bytes: msg}})
+}
+
+// newRevReqContent returns the content of an rr for the given certificate.
+func newRevReqContent(t *testing.T, serial *big.Int, issuer []byte, reason int) []byte {
+	t.Helper()
+	return mustMarshal(t, []revDetails{{
+		CertDetails: asn1.RawValue{FullBytes: newCertTemplate(t, certTemplateOptions{serial: serial, issuer: issuer})},
+		CRLEntryDetails: []pkix.Extension{{
+			Id:    oidCRLReason,
+			Value: mustMarshal(t, asn1.Enumerated(reason)),
+		}},
+	}})
+}
+
+type messageOptions struct {
+	secret          []byte
+	signer          crypto.Signer
+	extraCerts      []*x509.Certificate
+	implicitConfirm bool
+}
+
+// newMessage returns a request protected with the secret if it's set, or
+// with the signer.
+func newMessage(t *testing.T, bodyType BodyType, content []byte, o messageOptions) []byte {
+	t.Helper()
+	header := &Header{
+		PVNO:          2,
+		Sender:        directoryName(mustMarshal(t, pkix.Name{CommonName: "client"}.ToRDNSequence())),
+		Recipient:     directoryName([]byte{0x30, 0x00}),
+		TransactionID: []byte("transaction"),
+		SenderNonce:   []byte("nonce"),
+	}
+	if o.implicitConfirm {
+		header.GeneralInfo = []InfoTypeAndValue{{Type: oidImplicitConfirm, Value: asn1.NullRawValue}}
+	}
+	var protect func([]byte) ([]byte, error)
+	if o.secret != nil {
+		header.ProtectionAlg = pbmAlgorithm(t, 500)
+		header.SenderKID = []byte("kid")
+		protect = func(data []byte) ([]byte, error) {
+			return passwordBasedMAC(header.ProtectionAlg, o.secret, data)
+		}
+	} else {
+		alg, h, err := signatureAlgorithmIdentifier(o.signer.Public())
+		assert.FatalError(t, err)
+		header.ProtectionAlg = alg
+		protect = func(data []byte) ([]byte, error) {
+			return sign(o.signer, h, data)
+		}
+	}
+	b, err := marshalMessage(header, bodyType, content, o.extraCerts, protect)
+	assert.FatalError(t, err)
+	return b
+}
+
+func TestBodyType_String(t *testing.T) {
+	assert.Equals(t, "ir", IR.String())
+	assert.Equals(t, "p10cr", P10CR.String())
+	assert.Equals(t, "certConf", CertConf.String())
+	assert.Equals(t, "unknown", BodyType(21).String())
+}
+
+func TestNewStatusInfo(t *testing.T) {
+	info := newStatusInfo(StatusAccepted, BadPOP, "")
+	assert.Equals(t, 0, info.Status)
+	assert.Equals(t, 0, info.FailInfo.BitLength)
+	assert.Len(t, 0, info.StatusString)
+
+	info = newStatusInfo(StatusRejection, BadPOP, "bad pop")
+	assert.Equals(t, 2, info.Status)
+	assert.Equals(t, asn1.BitString{Bytes: []byte{0x00, 0x40}, BitLength: 10}, info.FailInfo)
+	assert.Equals(t, 1, info.FailInfo.At(int(BadPOP)))
+	assert.Len(t, 1, info.StatusString)
+	assert.Equals(t, []byte("bad pop"), info.StatusString[0].Bytes)
+
+	// The status string must be encoded as an UTF8String.
+	var parsed pkiStatusInfo
+	_, err := asn1.Unmarshal(mustMarshal(t, info), &parsed)
+	assert.FatalError(t, err)
+	assert.Equals(t, asn1.TagUTF8String, parsed.StatusString[0].Tag)
+	assert.Equals(t, info.FailInfo, parsed.FailInfo)
+}
+
+func TestParsePKIMessage(t *testing.T) {
+	ca := mustCA(t, "Test")
+	secret := []byte("secret")
+	der := newMessage(t, CertConf, asn1.NullBytes, messageOptions{secret: secret, extraCerts: []*x509.Certificate{ca.intermediate}, implicitConfirm: true})
+
+	msg, err := ParsePKIMessage(der)
+	assert.FatalError(t, err)
+	assert.Equals(t, CertConf, msg.BodyType)
+	assert.Equals(t, asn1.NullBytes, msg.Content)
+	assert.Equals(t, 2, msg.Header.PVNO)
+	assert.Equals(t, []byte("transaction"), msg.Header.TransactionID)
+	assert.Equals(t, []byte("nonce"), msg.Header.SenderNonce)
+	assert.Equals(t, []byte("kid"), msg.Header.SenderKID)
+	assert.True(t, msg.Header.hasImplicitConfirm())
+	assert.Equals(t, []*x509.Certificate{ca.intermediate}, msg.ExtraCerts)
+
+	_, err = ParsePKIMessage([]byte("foo"))
+	assert.Error(t, err)
+	_, err = ParsePKIMessage(append(der, 0x00))
+	assert.Error(t, err)
+	_, err = ParsePKIMessage(mustMarshal(t, pkiMessage{
+		Header: asn1.RawValue{FullBytes: mustMarshal(t, Header{PVNO: 2, Sender: directoryName([]byte{0x30, 0x00}), Recipient: directoryName([]byte{0x30, 0x00})})},
+		Body:   asn1.RawValue{FullBytes: asn1.NullBytes},
+	}))
+	assert.Error(t, err)
+}
+
+func TestPKIMessage_verifyProtection(t *testing.T) {
+	ca := mustCA(t, "Test")
+	leaf, key := ca.mustLeaf(t, "device")
+	secret := []byte("secret")
+
+	tests := []struct {
+		name    string
+		der     []byte
+		secret  []byte
+		want    protection
+		wantErr bool
+	}{
+		{"ok mac", newMessage(t, CertConf, asn1.NullBytes, messageOptions{secret: secret}), secret, macProtection, false},
+		{"ok signature", newMessage(t, CertConf, asn1.NullBytes, messageOptions{signer: key, extraCerts: []*x509.Certificate{leaf}}), secret, signatureProtection, false},
+		{"ok signature without secret", newMessage(t, CertConf, asn1.NullBytes, messageOptions{signer: key, extraCerts: []*x509.Certificate{leaf}}), nil, signatureProtection, false},
+		{"fail mac secret", newMessage(t, CertConf, asn1.NullBytes, messageOptions{secret: secret}), []byte("other"), 0, true},
+		{"fail mac not allowed", newMessage(t, CertConf, asn1.NullBytes, messageOptions{secret: secret}), nil, 0, true},
+		{"fail signature key", newMessage(t, CertConf, asn1.NullBytes, messageOptions{signer: mustKey(t), extraCerts: []*x509.Certificate{leaf}}), secret, 0, true},
+		{"fail signature no certs", newMessage(t, CertConf, asn1.NullBytes, messageOptions{signer: key}), secret, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ParsePKIMessage(tt.der)
+			assert.FatalError(t, err)
+			got, err := msg.verifyProtection(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PKIMessage.verifyProtection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func TestPasswordBasedMAC(t *testing.T) {
+	data := []byte("data")
+	secret := []byte("secret")
+	mac1, err := passwordBasedMAC(pbmAlgorithm(t, 500), secret, data)
+	assert.FatalError(t, err)
+	mac2, err := passwordBasedMAC(pbmAlgorithm(t, 500), secret, data)
+	assert.FatalError(t, err)
+	assert.Equals(t, mac1, mac2)
+	assert.Len(t, 32, mac1)
+
+	mac3, err := passwordBasedMAC(pbmAlgorithm(t, 501), secret, data)
+	assert.FatalError(t, err)
+	assert.NotEquals(t, mac1, mac3)
+
+	_, err = passwordBasedMAC(pbmAlgorithm(t, 0), secret, data)
+	assert.Error(t, err)
+	_, err = passwordBasedMAC(pbmAlgorithm(t, maxIterationCount+1), secret, data)
+	assert.Error(t, err)
+	_, err = passwordBasedMAC(pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, secret, data)
+	assert.Error(t, err)
+}
+
+func TestCertTemplate_CertificateRequest(t *testing.T) {
+	key := mustKey(t)
+	content := newCertReqMessages(t, key.Public(), key, certTemplateOptions{subject: "device"})
+	reqs, err := parseCertReqMessages(content)
+	assert.FatalError(t, err)
+	assert.Len(t, 1, reqs)
+	assert.FatalError(t, reqs[0].VerifyProofOfPossession())
+
+	csr, err := reqs[0].Template.CertificateRequest()
+	assert.FatalError(t, err)
+	assert.Equals(t, "device", csr.Subject.CommonName)
+	assert.Equals(t, key.Public(), csr.PublicKey)
+
+	// Proof of possession signed with a different key.
+	content = newCertReqMessages(t, key.Public(), mustKey(t), certTemplateOptions{subject: "device"})
+	reqs, err = parseCertReqMessages(content)
+	assert.FatalError(t, err)
+	assert.Error(t, reqs[0].VerifyProofOfPossession())
+
+	// Template without public key.
+	_, err = (&CertTemplate{}).CertificateRequest()
+	assert.Error(t, err)
+}
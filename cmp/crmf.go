@@ -0,0 +1,288 @@
+package cmp
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	oidExtensionRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	oidCRLReason        = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidNoSignature      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 6, 2}
+)
+
+// CertTemplate is a parsed CRMF certificate template.
+type CertTemplate struct {
+	SerialNumber *big.Int
+	// Issuer and Subject are the DER encoded names, nil if not present.
+	Issuer     []byte
+	Subject    []byte
+	PublicKey  crypto.PublicKey
+	NotBefore  time.Time
+	NotAfter   time.Time
+	Extensions []pkix.Extension
+	rawSPKI    []byte
+}
+
+// CertRequest is a parsed CRMF certificate request.
+type CertRequest struct {
+	ID       int64
+	Template *CertTemplate
+	raw      []byte
+	pop      asn1.RawValue
+}
+
+type certReqMsg struct {
+	CertReq asn1.RawValue
+	POPO    asn1.RawValue `asn1:"optional"`
+	RegInfo asn1.RawValue `asn1:"optional"`
+}
+
+type certRequest struct {
+	CertReqID    int64
+	CertTemplate asn1.RawValue
+	Controls     asn1.RawValue `asn1:"optional"`
+}
+
+type popoSigningKey struct {
+	Input     asn1.RawValue `asn1:"optional,tag:0"`
+	Algorithm pkix.AlgorithmIdentifier
+	Signature asn1.BitString
+}
+
+type revDetails struct {
+	CertDetails     asn1.RawValue
+	CRLEntryDetails []pkix.Extension `asn1:"optional"`
+}
+
+// withSequenceTag returns the DER encoding of the contents of an implicitly
+// tagged value as a sequence.
+func withSequenceTag(v asn1.RawValue) ([]byte, error) {
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      v.Bytes,
+	})
+}
+
+// parseCertReqMessages parses the content of an ir, cr or kur message.
+func parseCertReqMessages(content []byte) ([]*CertRequest, error) {
+	var msgs []certReqMsg
+	if rest, err := asn1.Unmarshal(content, &msgs); err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate request messages")
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing certificate request messages: trailing data")
+	}
+	var reqs []*CertRequest
+	for _, msg := range msgs {
+		var cr certRequest
+		if _, err := asn1.Unmarshal(msg.CertReq.FullBytes, &cr); err != nil {
+			return nil, errors.Wrap(err, "error parsing certificate request")
+		}
+		tpl, err := parseCertTemplate(cr.CertTemplate)
+		if err != nil {
+			return nil, err
+		}
+		req := &CertRequest{
+			ID:       cr.CertReqID,
+			Template: tpl,
+			raw:      msg.CertReq.FullBytes,
+		}
+		// The proof of possession is a choice of context specific tags, the
+		// registration info is a sequence.
+		if msg.POPO.Class == asn1.ClassContextSpecific {
+			req.pop = msg.POPO
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// parseCertTemplate parses a CRMF certificate template. All the fields are
+// optional and implicitly tagged, except the names and times that are
+// explicitly tagged because they are a choice.
+func parseCertTemplate(v asn1.RawValue) (*CertTemplate, error) {
+	tpl := new(CertTemplate)
+	for rest := v.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, errors.Wrap(err, "error parsing certificate template")
+		}
+		if field.Class != asn1.ClassContextSpecific {
+			return nil, errors.New("error parsing certificate template: invalid field")
+		}
+		switch field.Tag {
+		case 1: // serialNumber
+			tpl.SerialNumber = new(big.Int).SetBytes(field.Bytes)
+		case 3: // issuer
+			tpl.Issuer = field.Bytes
+		case 4: // validity
+			for r := field.Bytes; len(r) > 0; {
+				var t asn1.RawValue
+				if r, err = asn1.Unmarshal(r, &t); err != nil {
+					return nil, errors.Wrap(err, "error parsing certificate template validity")
+				}
+				var tm time.Time
+				if _, err := asn1.Unmarshal(t.Bytes, &tm); err != nil {
+					return nil, errors.Wrap(err, "error parsing certificate template validity")
+				}
+				switch t.Tag {
+				case 0:
+					tpl.NotBefore = tm
+				case 1:
+					tpl.NotAfter = tm
+				}
+			}
+		case 5: // subject
+			tpl.Subject = field.Bytes
+		case 6: // publicKey
+			if tpl.rawSPKI, err = withSequenceTag(field); err != nil {
+				return nil, errors.Wrap(err, "error parsing certificate template public key")
+			}
+			if tpl.PublicKey, err = x509.ParsePKIXPublicKey(tpl.rawSPKI); err != nil {
+				return nil, errors.Wrap(err, "error parsing certificate template public key")
+			}
+		case 9: // extensions
+			b, err := withSequenceTag(field)
+			if err != nil {
+				return nil, errors.Wrap(err, "error parsing certificate template extensions")
+			}
+			if _, err := asn1.Unmarshal(b, &tpl.Extensions); err != nil {
+				return nil, errors.Wrap(err, "error parsing certificate template extensions")
+			}
+		}
+	}
+	return tpl, nil
+}
+
+// VerifyProofOfPossession verifies the signature based proof of possession
+// of the request. The signature must be calculated over the certificate
+// request with the key in the template.
+func (r *CertRequest) VerifyProofOfPossession() error {
+	if r.Template.PublicKey == nil {
+		return errors.New("certificate template does not have a public key")
+	}
+	if r.pop.Tag != 1 || !r.pop.IsCompound {
+		return errors.New("only signature based proof of possession is supported")
+	}
+	b, err := withSequenceTag(r.pop)
+	if err != nil {
+		return errors.Wrap(err, "error parsing proof of possession")
+	}
+	var pop popoSigningKey
+	if _, err := asn1.Unmarshal(b, &pop); err != nil {
+		return errors.Wrap(err, "error parsing proof of possession")
+	}
+	if len(pop.Input.FullBytes) > 0 {
+		return errors.New("proof of possession with signing key input is not supported")
+	}
+	if err := verifySignature(r.Template.PublicKey, pop.Algorithm, r.raw, pop.Signature.RightAlign()); err != nil {
+		return errors.Wrap(err, "error verifying proof of possession")
+	}
+	return nil
+}
+
+type csrAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type tbsCertificateRequest struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []csrAttribute `asn1:"tag:0"`
+}
+
+type certificateRequest struct {
+	TBSCertificateRequest asn1.RawValue
+	SignatureAlgorithm    pkix.AlgorithmIdentifier
+	Signature             asn1.BitString
+}
+
+// CertificateRequest returns the template as an x509.CertificateRequest, so
+// the subject, key and extensions are parsed in the same way as in a PKCS
+// #10 request. The certificate request does not have a valid signature; the
+// proof of possession must be verified with VerifyProofOfPossession.
+func (t *CertTemplate) CertificateRequest() (*x509.CertificateRequest, error) {
+	if t.PublicKey == nil {
+		return nil, errors.New("certificate template does not have a public key")
+	}
+	subject := t.Subject
+	if len(subject) == 0 {
+		subject = []byte{0x30, 0x00}
+	}
+	tbs := tbsCertificateRequest{
+		Subject:   asn1.RawValue{FullBytes: subject},
+		PublicKey: asn1.RawValue{FullBytes: t.rawSPKI},
+	}
+	if len(t.Extensions) > 0 {
+		b, err := asn1.Marshal(t.Extensions)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling certificate template extensions")
+		}
+		tbs.Attributes = []csrAttribute{{
+			Type:   oidExtensionRequest,
+			Values: []asn1.RawValue{{FullBytes: b}},
+		}}
+	}
+	tbsBytes, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling certificate request")
+	}
+	der, err := asn1.Marshal(certificateRequest{
+		TBSCertificateRequest: asn1.RawValue{FullBytes: tbsBytes},
+		SignatureAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidNoSignature},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate template")
+	}
+	return csr, nil
+}
+
+// RevocationRequest is a parsed revocation request.
+type RevocationRequest struct {
+	Template   *CertTemplate
+	ReasonCode int
+}
+
+// parseRevReqContent parses the content of an rr message.
+func parseRevReqContent(content []byte) ([]*RevocationRequest, error) {
+	var details []revDetails
+	if rest, err := asn1.Unmarshal(content, &details); err != nil {
+		return nil, errors.Wrap(err, "error parsing revocation request")
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing revocation request: trailing data")
+	}
+	var reqs []*RevocationRequest
+	for _, d := range details {
+		tpl, err := parseCertTemplate(d.CertDetails)
+		if err != nil {
+			return nil, err
+		}
+		req := &RevocationRequest{Template: tpl}
+		for _, ext := range d.CRLEntryDetails {
+			if ext.Id.Equal(oidCRLReason) {
+				var reason asn1.Enumerated
+				if _, err := asn1.Unmarshal(ext.Value, &reason); err != nil {
+					return nil, errors.Wrap(err, "error parsing revocation reason")
+				}
+				req.ReasonCode = int(reason)
+			}
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
@@ -0,0 +1,219 @@
+package cmp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+
+	"github.com/pkg/errors"
+)
+
+// maxIterationCount is the maximum iteration count accepted in the password
+// based MAC parameters.
+const maxIterationCount = 100000
+
+var (
+	oidPasswordBasedMAC = asn1.ObjectIdentifier{1, 2, 840, 113533, 7, 66, 13}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 1, 2}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+)
+
+var signatureAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	algo x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+// getSignatureAlgorithm returns the signature algorithm with the given
+// algorithm identifier.
+func getSignatureAlgorithm(alg pkix.AlgorithmIdentifier) (x509.SignatureAlgorithm, error) {
+	for _, sa := range signatureAlgorithms {
+		if sa.oid.Equal(alg.Algorithm) {
+			return sa.algo, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, errors.Errorf("unsupported signature algorithm %s", alg.Algorithm)
+}
+
+// verifySignature verifies the signature of the data using the given public
+// key and algorithm identifier.
+func verifySignature(pub crypto.PublicKey, alg pkix.AlgorithmIdentifier, data, signature []byte) error {
+	algo, err := getSignatureAlgorithm(alg)
+	if err != nil {
+		return err
+	}
+	return (&x509.Certificate{PublicKey: pub}).CheckSignature(algo, data, signature)
+}
+
+// signatureAlgorithmIdentifier returns the algorithm identifier and hash used
+// to sign with the given key.
+func signatureAlgorithmIdentifier(pub crypto.PublicKey) (pkix.AlgorithmIdentifier, crypto.Hash, error) {
+	var algo x509.SignatureAlgorithm
+	var h crypto.Hash
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P384():
+			algo, h = x509.ECDSAWithSHA384, crypto.SHA384
+		case elliptic.P521():
+			algo, h = x509.ECDSAWithSHA512, crypto.SHA512
+		default:
+			algo, h = x509.ECDSAWithSHA256, crypto.SHA256
+		}
+	case *rsa.PublicKey:
+		algo, h = x509.SHA256WithRSA, crypto.SHA256
+	case ed25519.PublicKey:
+		algo, h = x509.PureEd25519, crypto.Hash(0)
+	default:
+		return pkix.AlgorithmIdentifier{}, 0, errors.Errorf("unsupported key type %T", pub)
+	}
+	for _, sa := range signatureAlgorithms {
+		if sa.algo == algo {
+			alg := pkix.AlgorithmIdentifier{Algorithm: sa.oid}
+			if _, ok := pub.(*rsa.PublicKey); ok {
+				alg.Parameters = asn1.NullRawValue
+			}
+			return alg, h, nil
+		}
+	}
+	return pkix.AlgorithmIdentifier{}, 0, errors.Errorf("unsupported key type %T", pub)
+}
+
+// sign signs the data with the given signer and hash.
+func sign(signer crypto.Signer, h crypto.Hash, data []byte) ([]byte, error) {
+	digest := data
+	if h != 0 {
+		hh := h.New()
+		hh.Write(data)
+		digest = hh.Sum(nil)
+	}
+	return signer.Sign(rand.Reader, digest, h)
+}
+
+type pbmParameter struct {
+	Salt           []byte
+	OWF            pkix.AlgorithmIdentifier
+	IterationCount int
+	MAC            pkix.AlgorithmIdentifier
+}
+
+func getHash(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1), oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidSHA256), oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidSHA384), oid.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidSHA512), oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	default:
+		return nil, errors.Errorf("unsupported algorithm %s", oid)
+	}
+}
+
+// passwordBasedMAC calculates the password based MAC of the data as described
+// in RFC 4210 section 5.1.3.1. The key is the result of hashing the secret
+// and the salt, and hashing the result iterationCount - 1 more times.
+func passwordBasedMAC(alg pkix.AlgorithmIdentifier, secret, data []byte) ([]byte, error) {
+	if !alg.Algorithm.Equal(oidPasswordBasedMAC) {
+		return nil, errors.Errorf("unsupported mac algorithm %s", alg.Algorithm)
+	}
+	var params pbmParameter
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.Wrap(err, "error parsing password based mac parameters")
+	}
+	if params.IterationCount < 1 || params.IterationCount > maxIterationCount {
+		return nil, errors.Errorf("invalid password based mac iteration count %d", params.IterationCount)
+	}
+	owf, err := getHash(params.OWF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := getHash(params.MAC.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	h := owf()
+	h.Write(secret)
+	h.Write(params.Salt)
+	key := h.Sum(nil)
+	for i := 1; i < params.IterationCount; i++ {
+		h.Reset()
+		h.Write(key)
+		key = h.Sum(nil)
+	}
+
+	m := hmac.New(mac, key)
+	m.Write(data)
+	return m.Sum(nil), nil
+}
+
+// protection is the kind of protection of a message.
+type protection int
+
+const (
+	macProtection protection = iota + 1
+	signatureProtection
+)
+
+// verifyProtection verifies the protection of the message. Password based MAC
+// protected messages are verified with the given secret, and signature
+// protected messages with the first of the extra certificates.
+func (m *PKIMessage) verifyProtection(secret []byte) (protection, error) {
+	alg := m.Header.ProtectionAlg
+	switch {
+	case len(alg.Algorithm) == 0 || len(m.Protection) == 0:
+		return 0, errors.New("cmp message is not protected")
+	case alg.Algorithm.Equal(oidPasswordBasedMAC):
+		if len(secret) == 0 {
+			return 0, errors.New("mac protection is not allowed")
+		}
+		mac, err := passwordBasedMAC(alg, secret, m.protectedPart)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare(mac, m.Protection) != 1 {
+			return 0, errors.New("invalid mac protection")
+		}
+		return macProtection, nil
+	default:
+		if len(m.ExtraCerts) == 0 {
+			return 0, errors.New("signature protected cmp message does not have extra certificates")
+		}
+		if err := verifySignature(m.ExtraCerts[0].PublicKey, alg, m.protectedPart, m.Protection); err != nil {
+			return 0, errors.Wrap(err, "invalid signature protection")
+		}
+		return signatureProtection, nil
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"unicode"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority"
 	"github.com/smallstep/certificates/ca"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/passphrase"
+	"github.com/smallstep/cli/crypto/pemutil"
 	"github.com/smallstep/cli/errs"
 	"github.com/urfave/cli"
 )
@@ -23,17 +28,25 @@ var AppCommand = cli.Command{
 	Action: appAction,
 	UsageText: `**step-ca** <config>
 	[**--password-file**=<file>]
-	[**--resolver**=<addr>]`,
+	[**--resolver**=<addr>]
+	[**--watch**]`,
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name: "password-file",
 			Usage: `path to the <file> containing the password to decrypt the
-intermediate private key.`,
+intermediate private key. It takes precedence over the passwordProvider in the
+configuration.`,
 		},
 		cli.StringFlag{
 			Name:  "resolver",
 			Usage: "address of a DNS resolver to be used instead of the default.",
 		},
+		cli.BoolFlag{
+			Name: "watch",
+			Usage: `reload the configuration when the file changes, as when a SIGHUP is
+received. If the new configuration is not valid the CA keeps running with the
+previous one.`,
+		},
 	},
 }
 
@@ -62,6 +75,10 @@ func appAction(ctx *cli.Context) error {
 			fatal(errors.Wrapf(err, "error reading %s", passFile))
 		}
 		password = bytes.TrimRightFunc(password, unicode.IsSpace)
+	} else if config.PasswordProvider != nil {
+		if password, err = providerPassword(config); err != nil {
+			fatal(err)
+		}
 	}
 
 	// replace resolver if requested
@@ -77,6 +94,12 @@ func appAction(ctx *cli.Context) error {
 		fatal(err)
 	}
 
+	if ctx.Bool("watch") {
+		if err := srv.WatchConfig(); err != nil {
+			fatal(err)
+		}
+	}
+
 	go ca.StopReloaderHandler(srv)
 	if err = srv.Run(); err != nil && err != http.ErrServerClosed {
 		fatal(err)
@@ -84,6 +107,32 @@ func appAction(ctx *cli.Context) error {
 	return nil
 }
 
+// providerPassword returns the password of the intermediate key using the
+// password provider in the configuration. If the intermediate key is a file,
+// the password is only accepted if it decrypts the key.
+func providerPassword(config *authority.Config) ([]byte, error) {
+	p, err := passphrase.New(config.PasswordProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	var check passphrase.CheckFunc
+	var kmsType kmsapi.Type
+	if config.KMS != nil {
+		kmsType = kmsapi.Type(strings.ToLower(config.KMS.Type))
+	}
+	if kmsType == kmsapi.DefaultKMS || kmsType == kmsapi.SoftKMS {
+		check = func(password []byte) error {
+			_, err := pemutil.Read(config.IntermediateKey, pemutil.WithPassword(password))
+			return err
+		}
+	}
+	if config.PasswordProvider.Type == passphrase.Socket {
+		log.Printf("Waiting for the password on %s, use `step-ca unlock %s` to send it", config.PasswordProvider.Path, config.PasswordProvider.Path)
+	}
+	return p.Password(context.Background(), check)
+}
+
 // fatal writes the passed error on the standard error and exits with the exit
 // code 1. If the environment variable STEPDEBUG is set to 1 it shows the
 // stack trace of the error.
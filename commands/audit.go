@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:  "audit-verify",
+		Usage: "verify the hash chain of the audit log",
+		UsageText: `**step-ca audit-verify** <audit-log>
+**step-ca audit-verify** **--config**=<file>`,
+		Action: auditVerifyAction,
+		Description: `**step-ca audit-verify** verifies the hash chain of the events written by a
+file sink of the audit log in <audit-log>, or, with the **--config** flag, the
+events stored by a db sink in the database configured in <file>.
+
+The command fails if an event has been modified, or if events have been
+removed or reordered. Events removed at the end of the log can only be
+detected comparing the last sequence number with another sink.
+
+## POSITIONAL ARGUMENTS
+
+<audit-log>
+:  The path of the file written by a file sink.
+
+## EXAMPLES
+
+Verify the events written to a file:
+'''
+$ step-ca audit-verify /var/log/step-ca/audit.log
+'''
+
+Verify the events stored in the database:
+'''
+$ step-ca audit-verify --config $(step path)/config/ca.json
+'''`,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "config",
+				Usage: `The path to the ca.json <file> with the database.`,
+			},
+		},
+	})
+}
+
+func auditVerifyAction(ctx *cli.Context) error {
+	configFile := ctx.String("config")
+	if ctx.NArg() == 0 && configFile == "" {
+		return cli.ShowCommandHelp(ctx, "audit-verify")
+	}
+
+	var (
+		events []*authority.AuditEvent
+		err    error
+	)
+	if configFile != "" {
+		if err := errs.NumberOfArguments(ctx, 0); err != nil {
+			return err
+		}
+		events, err = readAuditDB(configFile)
+	} else {
+		if err := errs.NumberOfArguments(ctx, 1); err != nil {
+			return err
+		}
+		events, err = readAuditFile(ctx.Args().Get(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := authority.VerifyAuditEvents(events); err != nil {
+		return err
+	}
+	ui.PrintSelected("Events", strconv.Itoa(len(events)))
+	if n := len(events); n > 0 {
+		ui.PrintSelected("First", strconv.FormatUint(events[0].Seq, 10))
+		ui.PrintSelected("Last", strconv.FormatUint(events[n-1].Seq, 10))
+		ui.PrintSelected("Hash", events[n-1].Hash)
+	}
+	fmt.Println("The audit log is valid.")
+	return nil
+}
+
+func readAuditFile(filename string) ([]*authority.AuditEvent, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening %s", filename)
+	}
+	defer f.Close()
+	return authority.ReadAuditLog(f)
+}
+
+func readAuditDB(configFile string) ([]*authority.AuditEvent, error) {
+	config, err := authority.LoadConfiguration(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.DB == nil {
+		return nil, errors.Errorf("db is not configured in %s", configFile)
+	}
+	authDB, err := db.New(config.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer authDB.Shutdown()
+	return authority.GetAuditEvents(authDB)
+}
@@ -0,0 +1,268 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/acme"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/nosql"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:      "backup",
+		Usage:     "write a backup of the CA database",
+		UsageText: `**step-ca backup** <config> <backup-file>`,
+		Action:    backupAction,
+		Description: `**step-ca backup** writes a gzipped backup of the database configured in
+<config> to <backup-file>. The backup includes a manifest with the checksum of
+the data, the fingerprints of the roots and the fingerprint of the
+configuration, and it can be restored with **step-ca restore**.
+
+The database is opened directly, with the default database the CA must be
+stopped. Use the **/admin/backup** endpoint to backup a running CA.
+
+## POSITIONAL ARGUMENTS
+
+<config>
+:  The path to the ca.json configuration file.
+
+<backup-file>
+:  The path where the backup is written.
+
+## EXAMPLES
+
+Write a backup of the database:
+'''
+$ step-ca backup $(step path)/config/ca.json ca.backup.gz
+'''
+
+Download a backup from a running CA, using an admin certificate:
+'''
+$ curl --cacert $(step path)/certs/root_ca.crt --cert admin.crt --key admin.key \
+  -o ca.backup.gz https://ca.example.com/admin/backup
+'''`,
+	})
+
+	command.Register(cli.Command{
+		Name:  "restore",
+		Usage: "restore a backup of the CA database",
+		UsageText: `**step-ca restore** <config> <backup-file>
+	[**--verify**] [**--force**]`,
+		Action: restoreAction,
+		Description: `**step-ca restore** verifies the backup in <backup-file> and restores it in the
+database configured in <config>. The tables in the backup replace the ones in
+the database. The CA must be stopped while the backup is restored.
+
+The checksum and the number of entries in the backup are always verified
+before the database is modified. A backup created with different roots is not
+restored unless the **--force** flag is used, a different configuration only
+prints a warning.
+
+## POSITIONAL ARGUMENTS
+
+<config>
+:  The path to the ca.json configuration file.
+
+<backup-file>
+:  The path of the backup.
+
+## EXAMPLES
+
+Verify a backup without restoring it:
+'''
+$ step-ca restore --verify $(step path)/config/ca.json ca.backup.gz
+'''
+
+Restore a backup:
+'''
+$ step-ca restore $(step path)/config/ca.json ca.backup.gz
+'''`,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "verify",
+				Usage: `verify the backup without restoring it.`,
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: `restore a backup created with different roots.`,
+			},
+		},
+	})
+}
+
+func backupAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "backup")
+	}
+	if err := errs.NumberOfArguments(ctx, 2); err != nil {
+		return err
+	}
+
+	configFile, backupFile := ctx.Args().Get(0), ctx.Args().Get(1)
+	config, nosqlDB, err := openBackupDB(configFile)
+	if err != nil {
+		return err
+	}
+	defer nosqlDB.Close()
+
+	fp, err := config.Fingerprint()
+	if err != nil {
+		return err
+	}
+	roots, err := rootFingerprints(config)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(backupFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", backupFile)
+	}
+	m := &db.BackupManifest{
+		Type:              config.DB.Type,
+		ConfigFingerprint: fp,
+		Roots:             roots,
+	}
+	if err := db.WriteBackup(f, nosqlDB, append(db.Tables(), acme.Tables()...), m); err != nil {
+		f.Close()
+		os.Remove(backupFile)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(backupFile)
+		return errors.Wrapf(err, "error writing %s", backupFile)
+	}
+
+	ui.PrintSelected("Backup", backupFile)
+	printBackupManifest(m)
+	return nil
+}
+
+func restoreAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "restore")
+	}
+	if err := errs.NumberOfArguments(ctx, 2); err != nil {
+		return err
+	}
+
+	configFile, backupFile := ctx.Args().Get(0), ctx.Args().Get(1)
+	f, err := os.Open(backupFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", backupFile)
+	}
+	defer f.Close()
+
+	m, err := db.VerifyBackup(f)
+	if err != nil {
+		return errors.Wrapf(err, "error verifying %s", backupFile)
+	}
+
+	config, err := authority.LoadConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	roots, err := rootFingerprints(config)
+	if err != nil {
+		return err
+	}
+	if err := authority.CheckBackupRoots(m, roots); err != nil {
+		if !ctx.Bool("force") {
+			return errors.Errorf("%v, use --force to restore it", err)
+		}
+		ui.Printf("%s {{ \"%s\" | yellow }}\n", ui.IconWarn, err)
+	}
+	if fp, err := config.Fingerprint(); err == nil && fp != m.ConfigFingerprint {
+		ui.Printf("%s {{ \"the backup was created with a different configuration\" | yellow }}\n", ui.IconWarn)
+	}
+	if config.DB != nil && m.Type != "" && m.Type != config.DB.Type {
+		ui.Printf("%s {{ \"the backup was created from a %s database\" | yellow }}\n", ui.IconWarn, m.Type)
+	}
+
+	printBackupManifest(m)
+	if ctx.Bool("verify") {
+		fmt.Println("The backup is valid.")
+		return nil
+	}
+
+	_, nosqlDB, err := openBackupDB(configFile)
+	if err != nil {
+		return err
+	}
+	defer nosqlDB.Close()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "error reading %s", backupFile)
+	}
+	if _, err := db.RestoreBackup(f, nosqlDB); err != nil {
+		return err
+	}
+	fmt.Println("The backup has been restored.")
+	return nil
+}
+
+// openBackupDB loads the configuration and opens its database, with the
+// encryption layer if it is configured.
+func openBackupDB(configFile string) (*authority.Config, nosql.DB, error) {
+	config, err := authority.LoadConfiguration(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if config.DB == nil {
+		return nil, nil, errors.Errorf("db is not configured in %s", configFile)
+	}
+	authDB, err := db.New(config.DB)
+	if err != nil {
+		return nil, nil, err
+	}
+	nosqlDB, ok := authDB.(nosql.DB)
+	if !ok {
+		return nil, nil, errors.Errorf("db is not configured in %s", configFile)
+	}
+	return config, nosqlDB, nil
+}
+
+// rootFingerprints returns the SHA-256 fingerprints of the roots in the
+// configuration.
+func rootFingerprints(config *authority.Config) ([]string, error) {
+	fps := make([]string, len(config.Root))
+	for i, path := range config.Root {
+		crt, err := pemutil.ReadCertificate(path)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(crt.Raw)
+		fps[i] = hex.EncodeToString(sum[:])
+	}
+	return fps, nil
+}
+
+func printBackupManifest(m *db.BackupManifest) {
+	var entries int
+	for _, n := range m.Tables {
+		entries += n
+	}
+	ui.PrintSelected("Created At", m.CreatedAt.Format(time.RFC3339))
+	ui.PrintSelected("Checksum", m.Checksum)
+	ui.PrintSelected("Tables", strconv.Itoa(len(m.Tables)))
+	ui.PrintSelected("Entries", strconv.Itoa(entries))
+}
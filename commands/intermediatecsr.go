@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/kms"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:  "intermediate-csr",
+		Usage: "create a new intermediate key in the KMS and its certificate request",
+		UsageText: `**step-ca intermediate-csr** <config> <key> <csr-file>
+	[**--name**=<name>] [**--password-file**=<file>]`,
+		Action: intermediateCSRAction,
+		Description: `**step-ca intermediate-csr** creates a new intermediate key directly in the KMS
+configured in <config>, and writes a certificate signing request signed by the
+new key. The private key never leaves the KMS, with the default KMS it is
+written encrypted with the password of the CA in <key>.
+
+To complete the rotation of the intermediate, sign the request with the root,
+e.g. with **step-ca root-sign**, replace the **crt** and **key** properties in
+<config> with the new certificate and <key>, and restart or reload the CA.
+
+## POSITIONAL ARGUMENTS
+
+<config>
+:  The path to the ca.json configuration file.
+
+<key>
+:  The name of the new key in the KMS, or the path of the new key with the
+default KMS.
+
+<csr-file>
+:  The path where the certificate request is written.
+
+## EXAMPLES
+
+Create a new intermediate key in a PKCS #11 module:
+'''
+$ step-ca intermediate-csr --name "Smallstep Intermediate CA" \
+  $(step path)/config/ca.json "pkcs11:id=7332;object=intermediate-2" intermediate.csr
+'''`,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name",
+				Usage: `the common <name> of the new intermediate, by default the one of the current intermediate.`,
+			},
+			cli.StringFlag{
+				Name:  "password-file",
+				Usage: `path to the <file> containing the password to encrypt the new key with the default KMS.`,
+			},
+		},
+	})
+}
+
+func intermediateCSRAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "intermediate-csr")
+	}
+	if err := errs.NumberOfArguments(ctx, 3); err != nil {
+		return err
+	}
+
+	configFile, keyName, csrFile := ctx.Args().Get(0), ctx.Args().Get(1), ctx.Args().Get(2)
+	config, err := authority.LoadConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+
+	name := ctx.String("name")
+	if name == "" {
+		crt, err := pemutil.ReadCertificate(config.IntermediateCert)
+		if err != nil {
+			return err
+		}
+		name = crt.Subject.CommonName
+	}
+
+	var opts kmsapi.Options
+	if config.KMS != nil {
+		opts = *config.KMS
+	}
+	km, err := kms.New(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	defer km.Close()
+
+	resp, csr, err := kms.CreateCertificateRequest(km, &kmsapi.CreateKeyRequest{
+		Name:               keyName,
+		SignatureAlgorithm: kmsapi.ECDSAWithSHA256,
+	}, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The default KMS returns the private key.
+	if resp.PrivateKey != nil {
+		var password []byte
+		if passFile := ctx.String("password-file"); passFile != "" {
+			if password, err = ioutil.ReadFile(passFile); err != nil {
+				return errors.Wrapf(err, "error reading %s", passFile)
+			}
+			password = bytes.TrimRightFunc(password, unicode.IsSpace)
+		} else {
+			if password, err = ui.PromptPassword("Please enter the password to encrypt the new key"); err != nil {
+				return err
+			}
+		}
+		if _, err := pemutil.Serialize(resp.PrivateKey, pemutil.WithFilename(keyName), pemutil.WithPassword(password)); err != nil {
+			return err
+		}
+	}
+	if err := utils.WriteFile(csrFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: csr.Raw,
+	}), 0600); err != nil {
+		return err
+	}
+
+	ui.PrintSelected("Intermediate key", resp.Name)
+	ui.PrintSelected("Certificate request", csrFile)
+	return nil
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:      "reencrypt",
+		Usage:     "encrypt the sensitive database values with the current data key",
+		UsageText: `**step-ca reencrypt** <config>`,
+		Action:    reencryptAction,
+		Description: `**step-ca reencrypt** encrypts with the current data key the sensitive values
+in the database configured in <config> that are encrypted with an older data
+key, or that were written before the encryption was enabled.
+
+To rotate the data key, encrypt a new 32 bytes key with the KMS, add it as the
+first key in **db.encryption.keys**, keeping the old ones, and restart or
+reload the CA. Then run this command and remove the old keys. The values are
+replaced using compare and swap, so this command can run while the CA is
+running, except with the default database.
+
+## POSITIONAL ARGUMENTS
+
+<config>
+:  The path to the ca.json configuration file.
+
+## EXAMPLES
+
+Encrypt the values with the current data key:
+'''
+$ step-ca reencrypt $(step path)/config/ca.json
+'''`,
+	})
+}
+
+func reencryptAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "reencrypt")
+	}
+	if err := errs.NumberOfArguments(ctx, 1); err != nil {
+		return err
+	}
+
+	configFile := ctx.Args().Get(0)
+	config, nosqlDB, err := openBackupDB(configFile)
+	if err != nil {
+		return err
+	}
+	defer nosqlDB.Close()
+	if config.DB.Encryption == nil {
+		return errors.Errorf("db.encryption is not configured in %s", configFile)
+	}
+
+	n, err := db.Reencrypt(nosqlDB)
+	if err != nil {
+		return err
+	}
+	ui.PrintSelected("Current key", config.DB.Encryption.Keys[0].ID)
+	fmt.Printf("%d values have been encrypted with the current key.\n", n)
+	return nil
+}
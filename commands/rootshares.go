@@ -0,0 +1,251 @@
+package commands
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/kms/threshold"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:  "root-split",
+		Usage: "split the root key in shares",
+		UsageText: `**step-ca root-split** <key-file> <output-dir>
+	[**--shares**=<number>] [**--threshold**=<number>]
+	[**--password-file**=<file>]`,
+		Action: rootSplitAction,
+		Description: `**step-ca root-split** splits the root private key in shares using Shamir's
+secret sharing, so a number of custodians must cooperate to use it. Any
+<threshold> of the <shares> shares can reconstruct the key, fewer shares do not
+reveal anything about it.
+
+Each share is encrypted with a password chosen by its custodian and written in
+<output-dir> as root_ca_key.share-<n>. Once the shares are distributed, the
+original <key-file> should be securely deleted, and operations like signing a
+new intermediate are done with **step-ca root-sign**.
+
+## POSITIONAL ARGUMENTS
+
+<key-file>
+:  The path to the root private key.
+
+<output-dir>
+:  The directory where the shares are written.
+
+## EXAMPLES
+
+Split the root key in five shares, any three of them can use the key:
+'''
+$ step-ca root-split --shares 5 --threshold 3 \
+  $(step path)/secrets/root_ca_key shares/
+'''`,
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "shares",
+				Value: 5,
+				Usage: `the <number> of shares to create.`,
+			},
+			cli.IntFlag{
+				Name:  "threshold",
+				Value: 3,
+				Usage: `the <number> of shares required to reconstruct the key.`,
+			},
+			cli.StringFlag{
+				Name:  "password-file",
+				Usage: `path to the <file> containing the password to decrypt the root key.`,
+			},
+		},
+	})
+
+	command.Register(cli.Command{
+		Name:  "root-sign",
+		Usage: "sign an intermediate certificate with a root key split in shares",
+		UsageText: `**step-ca root-sign** <root-file> <csr-file> <crt-file>
+	**--share**=<file> [**--share**=<file> ...] [**--not-after**=<duration>]`,
+		Action: rootSignAction,
+		Description: `**step-ca root-sign** signs the certificate signing request of a new
+intermediate with a root key split using **step-ca root-split**. The key is
+reconstructed in memory from the shares only to sign the certificate, and it
+is discarded afterwards. The password of each encrypted share is requested
+separately, so each custodian can type their own.
+
+## POSITIONAL ARGUMENTS
+
+<root-file>
+:  The path to the root certificate.
+
+<csr-file>
+:  The path to the certificate signing request of the intermediate.
+
+<crt-file>
+:  The path where the intermediate certificate is written.
+
+## EXAMPLES
+
+Sign a new intermediate with three shares of the root key:
+'''
+$ step-ca root-sign --share alice.share --share bob.share --share carol.share \
+  $(step path)/certs/root_ca.crt intermediate.csr intermediate_ca.crt
+'''`,
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "share",
+				Usage: `the <file> of a share of the root key. Use the flag multiple times to add more shares.`,
+			},
+			cli.DurationFlag{
+				Name:  "not-after",
+				Usage: `the validity <duration> of the intermediate, by default 10 years, limited by the validity of the root.`,
+			},
+		},
+	})
+}
+
+func rootSplitAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "root-split")
+	}
+	if err := errs.NumberOfArguments(ctx, 2); err != nil {
+		return err
+	}
+	keyFile, outputDir := ctx.Args().Get(0), ctx.Args().Get(1)
+
+	n, k := ctx.Int("shares"), ctx.Int("threshold")
+	switch {
+	case k < 2:
+		return errs.InvalidFlagValue(ctx, "threshold", strconv.Itoa(k), "")
+	case n < k || n > threshold.MaxShares:
+		return errs.InvalidFlagValue(ctx, "shares", strconv.Itoa(n), "")
+	}
+
+	opts := []pemutil.Options{}
+	if passFile := ctx.String("password-file"); passFile != "" {
+		opts = append(opts, pemutil.WithPasswordFile(passFile))
+	}
+	key, err := pemutil.Read(keyFile, opts...)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return errors.Errorf("key of type %T is not a crypto.Signer", key)
+	}
+
+	shares, err := threshold.SplitKey(signer, n, k)
+	if err != nil {
+		return err
+	}
+	for _, s := range shares {
+		password, err := ui.PromptPassword(fmt.Sprintf("Please enter the password to encrypt the share %d", s.Index))
+		if err != nil {
+			return err
+		}
+		b, err := threshold.EncodeShare(s, password)
+		if err != nil {
+			return err
+		}
+		s.Zero()
+		filename := filepath.Join(outputDir, "root_ca_key.share-"+strconv.Itoa(s.Index))
+		if err := utils.WriteFile(filename, b, 0600); err != nil {
+			return err
+		}
+		ui.PrintSelected(fmt.Sprintf("Share %d", s.Index), filename)
+	}
+	fmt.Printf("Any %d of the %d shares can reconstruct the root key.\n", k, n)
+	return nil
+}
+
+func rootSignAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "root-sign")
+	}
+	if err := errs.NumberOfArguments(ctx, 3); err != nil {
+		return err
+	}
+	rootFile, csrFile, crtFile := ctx.Args().Get(0), ctx.Args().Get(1), ctx.Args().Get(2)
+	shareFiles := ctx.StringSlice("share")
+	if len(shareFiles) == 0 {
+		return errs.RequiredFlag(ctx, "share")
+	}
+
+	root, err := pemutil.ReadCertificate(rootFile)
+	if err != nil {
+		return err
+	}
+	csr, err := readCertificateRequest(csrFile)
+	if err != nil {
+		return err
+	}
+
+	signer, err := threshold.NewSigner(root.PublicKey, func() ([]*threshold.Share, error) {
+		return readShares(shareFiles)
+	})
+	if err != nil {
+		return err
+	}
+	crt, err := authority.SignIntermediate(root, signer, csr, ctx.Duration("not-after"))
+	if err != nil {
+		return err
+	}
+	if _, err := pemutil.Serialize(crt, pemutil.WithFilename(crtFile)); err != nil {
+		return err
+	}
+
+	ui.PrintSelected("Intermediate Certificate", crtFile)
+	ui.PrintSelected("Not After", crt.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// readShares reads the given share files, asking for the password of the
+// encrypted ones.
+func readShares(filenames []string) ([]*threshold.Share, error) {
+	shares := make([]*threshold.Share, 0, len(filenames))
+	for _, filename := range filenames {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", filename)
+		}
+		var password []byte
+		if threshold.IsEncrypted(b) {
+			if password, err = ui.PromptPassword(fmt.Sprintf("Please enter the password to decrypt %s", filename)); err != nil {
+				return nil, err
+			}
+		}
+		s, err := threshold.DecodeShare(b, password)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", filename)
+		}
+		shares = append(shares, s)
+	}
+	return shares, nil
+}
+
+// readCertificateRequest reads a PEM or DER encoded certificate request.
+func readCertificateRequest(filename string) (*x509.CertificateRequest, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", filename)
+	}
+	return csr, nil
+}
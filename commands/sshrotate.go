@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/crypto/keys"
+	"github.com/smallstep/cli/crypto/pemutil"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/smallstep/cli/utils"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:  "ssh-rotate",
+		Usage: "start the rotation of an SSH CA key",
+		UsageText: `**step-ca ssh-rotate** <config> <key-file>
+	[**--type**=<type>] [**--cutover**=<time|duration>] [**--retire**=<time|duration>]
+	[**--password-file**=<file>]`,
+		Action: sshRotateAction,
+		Description: `**step-ca ssh-rotate** generates a new SSH CA key and adds the rotation to
+the configuration. Both the old and the new public keys are published in the
+/ssh/roots endpoint, the new key is used to sign certificates after the cutover
+time, and the old key is not published anymore after the retire time.
+
+The private key is encrypted with the password of the CA and written in
+<key-file>, the public key is written in <key-file>.pub. To complete the
+rotation replace the **hostKey** or **userKey** with the new key, remove
+the **hostKeyRotation** or **userKeyRotation** and restart the CA.
+
+## POSITIONAL ARGUMENTS
+
+<config>
+:  The path to the ca.json configuration file.
+
+<key-file>
+:  The path of the new private key.
+
+## EXAMPLES
+
+Rotate the host key, sign with the new key in a week and stop publishing the
+old one in a month:
+'''
+$ step-ca ssh-rotate --type host --cutover 168h --retire 720h \
+  $(step path)/config/ca.json $(step path)/secrets/ssh_host_ca_key_2
+'''`,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "type",
+				Value: "user",
+				Usage: `the <type> of the key to rotate. Options are "user" or "host".`,
+			},
+			cli.StringFlag{
+				Name: "cutover",
+				Usage: `the <time|duration> when the new key starts signing certificates. It can be
+a RFC 3339 time or a duration from now, e.g. "24h".`,
+			},
+			cli.StringFlag{
+				Name: "retire",
+				Usage: `the <time|duration> when the old key stops being published. It can be a
+RFC 3339 time or a duration from now, e.g. "720h".`,
+			},
+			cli.StringFlag{
+				Name:  "password-file",
+				Usage: `path to the <file> containing the password to encrypt the new key.`,
+			},
+		},
+	})
+}
+
+func sshRotateAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "ssh-rotate")
+	}
+	if err := errs.NumberOfArguments(ctx, 2); err != nil {
+		return err
+	}
+
+	configFile, keyFile := ctx.Args().Get(0), ctx.Args().Get(1)
+	config, err := authority.LoadConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+	if config.SSH == nil {
+		return errors.Errorf("ssh is not configured in %s", configFile)
+	}
+
+	now := time.Now()
+	cutover, err := parseRotationTime(ctx, "cutover", now)
+	if err != nil {
+		return err
+	}
+	retire, err := parseRotationTime(ctx, "retire", now)
+	if err != nil {
+		return err
+	}
+
+	rotation := &authority.SSHKeyRotation{
+		Key:     keyFile,
+		Cutover: cutover,
+		Retire:  retire,
+	}
+	switch typ := ctx.String("type"); typ {
+	case "user":
+		if config.SSH.UserKey == "" {
+			return errors.Errorf("userKey is not configured in %s", configFile)
+		}
+		config.SSH.UserKeyRotation = rotation
+	case "host":
+		if config.SSH.HostKey == "" {
+			return errors.Errorf("hostKey is not configured in %s", configFile)
+		}
+		config.SSH.HostKeyRotation = rotation
+	default:
+		return errs.InvalidFlagValue(ctx, "type", typ, "user, host")
+	}
+	if err := rotation.Validate(); err != nil {
+		return err
+	}
+
+	var password []byte
+	if passFile := ctx.String("password-file"); passFile != "" {
+		if password, err = ioutil.ReadFile(passFile); err != nil {
+			return errors.Wrapf(err, "error reading %s", passFile)
+		}
+		password = bytes.TrimRightFunc(password, unicode.IsSpace)
+	} else {
+		if password, err = ui.PromptPassword("Please enter the password to encrypt the new key"); err != nil {
+			return err
+		}
+	}
+
+	pub, priv, err := keys.GenerateDefaultKeyPair()
+	if err != nil {
+		return err
+	}
+	if _, ok := priv.(crypto.Signer); !ok {
+		return errors.Errorf("key of type %T is not a crypto.Signer", priv)
+	}
+	sshKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return errors.Wrapf(err, "error converting public key")
+	}
+	if _, err := pemutil.Serialize(priv, pemutil.WithFilename(keyFile), pemutil.WithPassword(password)); err != nil {
+		return err
+	}
+	if err := utils.WriteFile(keyFile+".pub", ssh.MarshalAuthorizedKey(sshKey), 0600); err != nil {
+		return err
+	}
+	if err := config.Save(configFile); err != nil {
+		return err
+	}
+
+	ui.PrintSelected("SSH public key", keyFile+".pub")
+	ui.PrintSelected("SSH private key", keyFile)
+	ui.PrintSelected("Cutover", cutover.Format(time.RFC3339))
+	if !retire.IsZero() {
+		ui.PrintSelected("Retire", retire.Format(time.RFC3339))
+	}
+	fmt.Println("Restart or reload the CA to start the rotation.")
+	return nil
+}
+
+// parseRotationTime parses the value of the given flag as a RFC 3339 time or
+// as a duration from now.
+func parseRotationTime(ctx *cli.Context, name string, now time.Time) (time.Time, error) {
+	s := ctx.String(name)
+	if s == "" {
+		if name == "cutover" {
+			return now, nil
+		}
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, errs.InvalidFlagValue(ctx, name, s, "")
+	}
+	return now.Add(d), nil
+}
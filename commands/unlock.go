@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/smallstep/certificates/passphrase"
+	"github.com/smallstep/cli/command"
+	"github.com/smallstep/cli/errs"
+	"github.com/smallstep/cli/ui"
+	"github.com/urfave/cli"
+)
+
+func init() {
+	command.Register(cli.Command{
+		Name:      "unlock",
+		Usage:     "send the password of the intermediate key to a starting CA",
+		UsageText: `**step-ca unlock** <socket>`,
+		Action:    unlockAction,
+		Description: `**step-ca unlock** sends the password of the intermediate key to a CA
+configured with a password provider of type **socket**. The CA waits on the
+unix <socket> until it receives a password that decrypts the key, so the
+password is never written to disk.
+
+## POSITIONAL ARGUMENTS
+
+<socket>
+:  The path to the unix socket in the **passwordProvider** configuration.
+
+## EXAMPLES
+
+Unlock a CA configured with '"passwordProvider": {"type": "socket", "path": "/run/step-ca/unlock.sock"}':
+'''
+$ sudo -u step step-ca unlock /run/step-ca/unlock.sock
+'''`,
+	})
+}
+
+func unlockAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.ShowCommandHelp(ctx, "unlock")
+	}
+	if err := errs.NumberOfArguments(ctx, 1); err != nil {
+		return err
+	}
+
+	password, err := ui.PromptPassword("Please enter the password to decrypt the intermediate key")
+	if err != nil {
+		return err
+	}
+	if err := passphrase.Send(ctx.Args().Get(0), password); err != nil {
+		return err
+	}
+	fmt.Println("The password was accepted, the CA is starting.")
+	return nil
+}
@@ -0,0 +1,266 @@
+// Package ct implements a client of the Certificate Transparency logs defined
+// in RFC 6962, used to submit precertificates and embed the signed certificate
+// timestamps (SCTs) returned by the logs in the final certificates.
+package ct
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// OIDExtensionSCTList is the object identifier of the embedded signed
+	// certificate timestamp list extension, defined in RFC 6962 section 3.3.
+	OIDExtensionSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// OIDExtensionPoison is the object identifier of the precertificate poison
+	// extension, defined in RFC 6962 section 3.1.
+	OIDExtensionPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+)
+
+// PoisonExtension is the critical extension that makes a precertificate
+// unusable as a certificate.
+var PoisonExtension = pkix.Extension{
+	Id:       OIDExtensionPoison,
+	Critical: true,
+	Value:    asn1.NullBytes,
+}
+
+// SignedCertificateTimestamp is the promise of a log to include a certificate
+// or precertificate, as defined in RFC 6962 section 3.2.
+type SignedCertificateTimestamp struct {
+	Version    uint8
+	LogID      []byte
+	Timestamp  uint64
+	Extensions []byte
+	Signature  []byte
+}
+
+// addChainResponse is the JSON response of the add-chain and add-pre-chain
+// methods of a log.
+type addChainResponse struct {
+	Version    uint8  `json:"sct_version"`
+	ID         []byte `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions []byte `json:"extensions"`
+	Signature  []byte `json:"signature"`
+}
+
+// Client is a client of a single Certificate Transparency log.
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// NewClient returns a client for the log with the given base URL, e.g.
+// https://ct.example.com/logs/2020. If the http client is nil the default
+// one is used.
+func NewClient(url string, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+	}
+}
+
+// URL returns the base URL of the log.
+func (c *Client) URL() string {
+	return c.url
+}
+
+// AddPreChain submits a precertificate to the log. The chain must start with
+// the precertificate followed by its issuer and the rest of the chain up to a
+// root accepted by the log.
+func (c *Client) AddPreChain(ctx context.Context, chain []*x509.Certificate) (*SignedCertificateTimestamp, error) {
+	return c.addChain(ctx, "/ct/v1/add-pre-chain", chain)
+}
+
+// AddChain submits a certificate to the log. The chain must start with the
+// certificate followed by its issuer and the rest of the chain up to a root
+// accepted by the log.
+func (c *Client) AddChain(ctx context.Context, chain []*x509.Certificate) (*SignedCertificateTimestamp, error) {
+	return c.addChain(ctx, "/ct/v1/add-chain", chain)
+}
+
+func (c *Client) addChain(ctx context.Context, path string, chain []*x509.Certificate) (*SignedCertificateTimestamp, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("chain cannot be empty")
+	}
+	body := struct {
+		Chain [][]byte `json:"chain"`
+	}{}
+	for _, crt := range chain {
+		body.Chain = append(body.Chain, crt.Raw)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling request")
+	}
+
+	req, err := http.NewRequest("POST", c.url+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating request for %s", c.url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error submitting chain to %s", c.url)
+	}
+	defer resp.Body.Close()
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading response from %s", c.url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("error submitting chain to %s: %s %s", c.url, resp.Status, bytes.TrimSpace(b))
+	}
+
+	var r addChainResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling response from %s", c.url)
+	}
+	return r.sct()
+}
+
+func (r *addChainResponse) sct() (*SignedCertificateTimestamp, error) {
+	if r.Version != 0 {
+		return nil, errors.Errorf("unsupported sct version %d", r.Version)
+	}
+	if len(r.ID) != 32 {
+		return nil, errors.New("invalid sct: log id must be 32 bytes")
+	}
+	if len(r.Signature) < 4 {
+		return nil, errors.New("invalid sct: signature is too short")
+	}
+	return &SignedCertificateTimestamp{
+		Version:    r.Version,
+		LogID:      r.ID,
+		Timestamp:  r.Timestamp,
+		Extensions: r.Extensions,
+		Signature:  r.Signature,
+	}, nil
+}
+
+// Time returns the timestamp of the SCT.
+func (s *SignedCertificateTimestamp) Time() time.Time {
+	return time.Unix(0, int64(s.Timestamp)*int64(time.Millisecond)).UTC()
+}
+
+// Marshal returns the TLS encoding of the SCT. The signature is already a TLS
+// encoded DigitallySigned struct.
+func (s *SignedCertificateTimestamp) Marshal() ([]byte, error) {
+	if len(s.LogID) != 32 {
+		return nil, errors.New("log id must be 32 bytes")
+	}
+	if len(s.Extensions) > 0xFFFF {
+		return nil, errors.New("sct extensions are too long")
+	}
+	b := make([]byte, 0, 43+len(s.Extensions)+len(s.Signature))
+	b = append(b, s.Version)
+	b = append(b, s.LogID...)
+	b = appendUint64(b, s.Timestamp)
+	b = appendUint16(b, len(s.Extensions))
+	b = append(b, s.Extensions...)
+	b = append(b, s.Signature...)
+	return b, nil
+}
+
+// MarshalSCTList returns the extension with the list of SCTs to embed in a
+// certificate, as defined in RFC 6962 section 3.3.
+func MarshalSCTList(scts []*SignedCertificateTimestamp) (pkix.Extension, error) {
+	if len(scts) == 0 {
+		return pkix.Extension{}, errors.New("sct list cannot be empty")
+	}
+	var list []byte
+	for _, sct := range scts {
+		b, err := sct.Marshal()
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		if len(b) > 0xFFFF {
+			return pkix.Extension{}, errors.New("sct is too long")
+		}
+		list = appendUint16(list, len(b))
+		list = append(list, b...)
+	}
+	if len(list) > 0xFFFF {
+		return pkix.Extension{}, errors.New("sct list is too long")
+	}
+	value, err := asn1.Marshal(append(appendUint16(nil, len(list)), list...))
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling sct list")
+	}
+	return pkix.Extension{
+		Id:    OIDExtensionSCTList,
+		Value: value,
+	}, nil
+}
+
+// ParseSCTList parses the value of the embedded SCT list extension.
+func ParseSCTList(ext pkix.Extension) ([]*SignedCertificateTimestamp, error) {
+	if !ext.Id.Equal(OIDExtensionSCTList) {
+		return nil, errors.Errorf("unexpected extension %s", ext.Id)
+	}
+	var list []byte
+	if rest, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+		return nil, errors.Wrap(err, "error parsing sct list")
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing sct list: trailing data")
+	}
+	list, rest, ok := readUint16Bytes(list)
+	if !ok || len(rest) > 0 {
+		return nil, errors.New("error parsing sct list: invalid length")
+	}
+
+	var scts []*SignedCertificateTimestamp
+	for len(list) > 0 {
+		var b []byte
+		if b, list, ok = readUint16Bytes(list); !ok || len(b) < 43 {
+			return nil, errors.New("error parsing sct list: invalid sct")
+		}
+		sct := &SignedCertificateTimestamp{
+			Version:   b[0],
+			LogID:     b[1:33],
+			Timestamp: binary.BigEndian.Uint64(b[33:41]),
+		}
+		if sct.Extensions, b, ok = readUint16Bytes(b[41:]); !ok || len(b) < 4 {
+			return nil, errors.New("error parsing sct list: invalid sct")
+		}
+		sct.Signature = b
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+func appendUint16(b []byte, n int) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+func readUint16Bytes(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(b[0])<<8 | int(b[1])
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
@@ -0,0 +1,144 @@
+package ct
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func mustCertificate(t *testing.T, extensions ...pkix.Extension) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1234),
+		Subject:         pkix.Name{CommonName: "test.example.com"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(der)
+	assert.FatalError(t, err)
+	return crt
+}
+
+func testSCT() *SignedCertificateTimestamp {
+	return &SignedCertificateTimestamp{
+		Version:   0,
+		LogID:     bytes.Repeat([]byte{1}, 32),
+		Timestamp: 1589000000123,
+		Signature: []byte{4, 3, 0, 2, 0xAA, 0xBB},
+	}
+}
+
+func TestClient_AddPreChain(t *testing.T) {
+	precert := mustCertificate(t, PoisonExtension)
+	sct := testSCT()
+
+	var status int
+	var response interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equals(t, "POST", r.Method)
+		assert.Equals(t, "/log/ct/v1/add-pre-chain", r.URL.Path)
+		var body struct {
+			Chain [][]byte `json:"chain"`
+		}
+		assert.FatalError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equals(t, [][]byte{precert.Raw}, body.Chain)
+		w.WriteHeader(status)
+		assert.FatalError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		status   int
+		response interface{}
+		want     *SignedCertificateTimestamp
+		wantErr  bool
+	}{
+		{"ok", http.StatusOK, map[string]interface{}{
+			"sct_version": 0, "id": sct.LogID, "timestamp": sct.Timestamp, "extensions": "", "signature": sct.Signature,
+		}, sct, false},
+		{"fail/status", http.StatusBadRequest, map[string]interface{}{"error": "bad chain"}, nil, true},
+		{"fail/json", http.StatusOK, "not an object", nil, true},
+		{"fail/version", http.StatusOK, map[string]interface{}{
+			"sct_version": 1, "id": sct.LogID, "timestamp": sct.Timestamp, "signature": sct.Signature,
+		}, nil, true},
+		{"fail/id", http.StatusOK, map[string]interface{}{
+			"sct_version": 0, "id": []byte{1, 2, 3}, "timestamp": sct.Timestamp, "signature": sct.Signature,
+		}, nil, true},
+		{"fail/signature", http.StatusOK, map[string]interface{}{
+			"sct_version": 0, "id": sct.LogID, "timestamp": sct.Timestamp, "signature": []byte{4},
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response = tt.status, tt.response
+			c := NewClient(srv.URL+"/log/", nil)
+			assert.Equals(t, srv.URL+"/log", c.URL())
+			got, err := c.AddPreChain(context.Background(), []*x509.Certificate{precert})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.AddPreChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil {
+				assert.Equals(t, tt.want.LogID, got.LogID)
+				assert.Equals(t, tt.want.Timestamp, got.Timestamp)
+				assert.Equals(t, tt.want.Signature, got.Signature)
+				assert.Len(t, 0, got.Extensions)
+				assert.Equals(t, time.Date(2020, 5, 9, 4, 53, 20, 123000000, time.UTC), got.Time())
+			}
+		})
+	}
+
+	_, err := NewClient(srv.URL, nil).AddChain(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestMarshalSCTList(t *testing.T) {
+	sct1, sct2 := testSCT(), testSCT()
+	sct1.Extensions = []byte{}
+	sct2.Extensions = []byte{1, 2, 3}
+	sct2.Timestamp++
+
+	ext, err := MarshalSCTList([]*SignedCertificateTimestamp{sct1, sct2})
+	assert.FatalError(t, err)
+	assert.Equals(t, OIDExtensionSCTList, ext.Id)
+	assert.False(t, ext.Critical)
+
+	// The extension is embedded and parsed back.
+	crt := mustCertificate(t, ext)
+	var found bool
+	for _, e := range crt.Extensions {
+		if e.Id.Equal(OIDExtensionSCTList) {
+			found = true
+			scts, err := ParseSCTList(e)
+			assert.FatalError(t, err)
+			assert.Equals(t, []*SignedCertificateTimestamp{sct1, sct2}, scts)
+		}
+	}
+	assert.True(t, found)
+
+	_, err = MarshalSCTList(nil)
+	assert.Error(t, err)
+	_, err = MarshalSCTList([]*SignedCertificateTimestamp{{LogID: []byte{1}}})
+	assert.Error(t, err)
+	_, err = ParseSCTList(PoisonExtension)
+	assert.Error(t, err)
+	_, err = ParseSCTList(pkix.Extension{Id: OIDExtensionSCTList, Value: []byte{4, 2, 0, 9}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// BackupVersion is the version of the backup format written by WriteBackup.
+const BackupVersion = 1
+
+// BackupManifest describes the contents of a backup. It is written at the end
+// of the backup, so a truncated backup does not have a manifest and fails the
+// verification.
+type BackupManifest struct {
+	Version           int            `json:"version"`
+	CreatedAt         time.Time      `json:"createdAt"`
+	Type              string         `json:"type,omitempty"`
+	ConfigFingerprint string         `json:"configFingerprint,omitempty"`
+	Roots             []string       `json:"roots,omitempty"`
+	Tables            map[string]int `json:"tables"`
+	Checksum          string         `json:"checksum"`
+}
+
+// backupRecord is a line in a backup, an entry of a table or the manifest.
+type backupRecord struct {
+	Table    string          `json:"table,omitempty"`
+	Key      []byte          `json:"key,omitempty"`
+	Value    []byte          `json:"value,omitempty"`
+	Manifest *BackupManifest `json:"manifest,omitempty"`
+}
+
+// Snapshotter is the interface implemented by the databases that can read
+// multiple tables from a consistent snapshot. The entries of a table that does
+// not exist are nil.
+type Snapshotter interface {
+	Snapshot(buckets [][]byte) ([][]*database.Entry, error)
+}
+
+// Tables returns the tables used by the authority database.
+func Tables() [][]byte {
+	return append([][]byte{}, tables...)
+}
+
+// WriteBackup writes a gzipped backup of the given tables to w. Each entry is
+// written in a JSON line, followed by the manifest m with the number of
+// entries of each table and the SHA-256 checksum of the entries. The tables
+// that do not exist are skipped.
+//
+// If the database implements Snapshotter the backup is a consistent snapshot
+// of the database, otherwise the tables are read one after the other. The
+// encrypted values are written encrypted.
+func WriteBackup(w io.Writer, db nosql.DB, tables [][]byte, m *BackupManifest) error {
+	snapshot, err := snapshotTables(unwrap(db), tables)
+	if err != nil {
+		return err
+	}
+
+	m.Version = BackupVersion
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	m.Tables = make(map[string]int)
+
+	h := sha256.New()
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(io.MultiWriter(gz, h))
+	for i, entries := range snapshot {
+		if entries == nil {
+			continue
+		}
+		table := string(tables[i])
+		m.Tables[table] = len(entries)
+		for _, e := range entries {
+			if err := enc.Encode(&backupRecord{Table: table, Key: e.Key, Value: e.Value}); err != nil {
+				return errors.Wrap(err, "error writing backup")
+			}
+		}
+	}
+
+	m.Checksum = hex.EncodeToString(h.Sum(nil))
+	if err := json.NewEncoder(gz).Encode(&backupRecord{Manifest: m}); err != nil {
+		return errors.Wrap(err, "error writing backup")
+	}
+	return errors.Wrap(gz.Close(), "error writing backup")
+}
+
+// VerifyBackup reads the backup in r and checks the number of entries of each
+// table and the checksum in the manifest. It returns the manifest of a valid
+// backup.
+func VerifyBackup(r io.Reader) (*BackupManifest, error) {
+	return readBackup(r, nil)
+}
+
+// RestoreBackup verifies the backup in r and writes it in the database. The
+// tables in the backup are deleted and created again before writing the
+// entries, the rest of the tables are not modified.
+func RestoreBackup(r io.ReadSeeker, db nosql.DB) (*BackupManifest, error) {
+	m, err := VerifyBackup(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "error reading backup")
+	}
+
+	db = unwrap(db)
+	for table := range m.Tables {
+		if err := db.DeleteTable([]byte(table)); err != nil && !nosql.IsErrNotFound(err) {
+			return nil, errors.Wrapf(err, "error deleting table %s", table)
+		}
+		if err := db.CreateTable([]byte(table)); err != nil {
+			return nil, errors.Wrapf(err, "error creating table %s", table)
+		}
+	}
+	return readBackup(r, func(rec *backupRecord) error {
+		return errors.Wrapf(db.Set([]byte(rec.Table), rec.Key, rec.Value),
+			"error restoring %s/%s", rec.Table, rec.Key)
+	})
+}
+
+// readBackup reads and verifies a backup calling fn with each entry.
+func readBackup(r io.Reader, fn func(*backupRecord) error) (*BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading backup")
+	}
+	defer gz.Close()
+
+	h := sha256.New()
+	counts := make(map[string]int)
+	br := bufio.NewReader(gz)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil, errors.New("error verifying backup: manifest not found")
+		}
+		if err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "error reading backup")
+		}
+
+		var rec backupRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, errors.Wrap(err, "error verifying backup")
+		}
+		if m := rec.Manifest; m != nil {
+			rest, err := br.Peek(1)
+			switch {
+			case len(rest) > 0:
+				return nil, errors.New("error verifying backup: unexpected data after the manifest")
+			case err != io.EOF:
+				return nil, errors.Wrap(err, "error reading backup")
+			}
+			return m, verifyManifest(m, counts, h.Sum(nil))
+		}
+		if rec.Table == "" {
+			return nil, errors.New("error verifying backup: entry without table")
+		}
+		h.Write(line)
+		counts[rec.Table]++
+		if fn != nil {
+			if err := fn(&rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// verifyManifest checks the manifest against the entries read.
+func verifyManifest(m *BackupManifest, counts map[string]int, sum []byte) error {
+	if m.Version != BackupVersion {
+		return errors.Errorf("error verifying backup: unsupported version %d", m.Version)
+	}
+	checksum, err := hex.DecodeString(m.Checksum)
+	if err != nil || !bytes.Equal(checksum, sum) {
+		return errors.New("error verifying backup: checksum does not match")
+	}
+	for table, n := range counts {
+		if m.Tables[table] != n {
+			return errors.Errorf("error verifying backup: table %s has %d entries, want %d", table, n, m.Tables[table])
+		}
+	}
+	for table, n := range m.Tables {
+		if counts[table] != n {
+			return errors.Errorf("error verifying backup: table %s has %d entries, want %d", table, counts[table], n)
+		}
+	}
+	return nil
+}
+
+// snapshotTables returns the entries of the given tables.
+func snapshotTables(db nosql.DB, tables [][]byte) ([][]*database.Entry, error) {
+	if s, ok := db.(Snapshotter); ok {
+		snapshot, err := s.Snapshot(tables)
+		return snapshot, errors.Wrap(err, "error reading database snapshot")
+	}
+	snapshot := make([][]*database.Entry, len(tables))
+	for i, table := range tables {
+		entries, err := db.List(table)
+		switch {
+		case nosql.IsErrNotFound(err):
+			continue
+		case err != nil:
+			return nil, errors.Wrapf(err, "error listing table %s", table)
+		case entries == nil:
+			entries = []*database.Entry{}
+		}
+		snapshot[i] = entries
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+// newBackupDB returns a MockNoSQLDB that keeps the tables in memory.
+func newBackupDB(tables map[string]map[string]string) *MockNoSQLDB {
+	return &MockNoSQLDB{
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			t, ok := tables[string(bucket)]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			var entries []*database.Entry
+			for k, v := range t {
+				entries = append(entries, &database.Entry{Bucket: bucket, Key: []byte(k), Value: []byte(v)})
+			}
+			return entries, nil
+		},
+		MCreateTable: func(bucket []byte) error {
+			if _, ok := tables[string(bucket)]; !ok {
+				tables[string(bucket)] = map[string]string{}
+			}
+			return nil
+		},
+		MDeleteTable: func(bucket []byte) error {
+			if _, ok := tables[string(bucket)]; !ok {
+				return database.ErrNotFound
+			}
+			delete(tables, string(bucket))
+			return nil
+		},
+		MSet: func(bucket, key, value []byte) error {
+			tables[string(bucket)][string(key)] = string(value)
+			return nil
+		},
+	}
+}
+
+func mustBackup(t *testing.T, tables map[string]map[string]string, m *BackupManifest) []byte {
+	var buf bytes.Buffer
+	err := WriteBackup(&buf, &DB{newBackupDB(tables), true}, [][]byte{certsTable, revokedCertsTable, usedOTTTable}, m)
+	assert.FatalError(t, err)
+	return buf.Bytes()
+}
+
+// rewriteBackup decompresses a backup, modifies it and compresses it again.
+func rewriteBackup(t *testing.T, b []byte, fn func(string) string) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	assert.FatalError(t, err)
+	data, err := ioutil.ReadAll(gz)
+	assert.FatalError(t, err)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write([]byte(fn(string(data))))
+	assert.FatalError(t, err)
+	assert.FatalError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestBackup(t *testing.T) {
+	src := map[string]map[string]string{
+		"x509_certs":         {"1": "cert1", "2": "cert2"},
+		"revoked_x509_certs": {},
+		"other":              {"key": "value"},
+	}
+	m := &BackupManifest{Type: "badgerv2", ConfigFingerprint: "abc", Roots: []string{"def"}}
+	b := mustBackup(t, src, m)
+	assert.Equals(t, BackupVersion, m.Version)
+	assert.False(t, m.CreatedAt.IsZero())
+	assert.Equals(t, map[string]int{"x509_certs": 2, "revoked_x509_certs": 0}, m.Tables)
+
+	got, err := VerifyBackup(bytes.NewReader(b))
+	assert.FatalError(t, err)
+	assert.Equals(t, m.Checksum, got.Checksum)
+	assert.Equals(t, m.Tables, got.Tables)
+	assert.Equals(t, "abc", got.ConfigFingerprint)
+	assert.Equals(t, []string{"def"}, got.Roots)
+
+	// Restore replaces the tables in the backup.
+	dst := map[string]map[string]string{
+		"x509_certs": {"3": "cert3"},
+		"other":      {"key": "other"},
+	}
+	got, err = RestoreBackup(bytes.NewReader(b), newBackupDB(dst))
+	assert.FatalError(t, err)
+	assert.Equals(t, m.Checksum, got.Checksum)
+	assert.Equals(t, map[string]map[string]string{
+		"x509_certs":         {"1": "cert1", "2": "cert2"},
+		"revoked_x509_certs": {},
+		"other":              {"key": "other"},
+	}, dst)
+}
+
+func TestVerifyBackup_errors(t *testing.T) {
+	b := mustBackup(t, map[string]map[string]string{
+		"x509_certs": {"1": "cert1", "2": "cert2"},
+	}, &BackupManifest{})
+
+	tests := map[string]struct {
+		backup []byte
+		err    string
+	}{
+		"fail/not-gzip":  {[]byte("not a backup"), "error reading backup"},
+		"fail/truncated": {b[:len(b)/2], "error reading backup"},
+		"fail/no-manifest": {rewriteBackup(t, b, func(s string) string {
+			return s[:strings.Index(s, `{"manifest"`)]
+		}), "manifest not found"},
+		"fail/modified": {rewriteBackup(t, b, func(s string) string {
+			return strings.Replace(s, `"Y2VydDE="`, `"Y2VydDM="`, 1)
+		}), "checksum does not match"},
+		"fail/removed-entry": {rewriteBackup(t, b, func(s string) string {
+			return s[strings.Index(s, "\n")+1:]
+		}), "checksum does not match"},
+		"fail/trailing-data": {rewriteBackup(t, b, func(s string) string {
+			return s + "{}\n"
+		}), "unexpected data after the manifest"},
+		"fail/version": {rewriteBackup(t, b, func(s string) string {
+			return strings.Replace(s, `"version":1`, `"version":2`, 1)
+		}), "unsupported version 2"},
+		"fail/count": {rewriteBackup(t, b, func(s string) string {
+			return strings.Replace(s, `"x509_certs":2`, `"x509_certs":3`, 1)
+		}), "table x509_certs has 2 entries, want 3"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := VerifyBackup(bytes.NewReader(tc.backup))
+			if assert.NotNil(t, err) {
+				assert.HasPrefix(t, err.Error(), "error")
+				assert.True(t, strings.Contains(err.Error(), tc.err), err.Error())
+			}
+		})
+	}
+}
+
+func TestWriteBackup_errors(t *testing.T) {
+	db := &MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+		return nil, errors.New("force")
+	}}
+	var buf bytes.Buffer
+	err := WriteBackup(&buf, db, [][]byte{certsTable}, &BackupManifest{})
+	assert.Equals(t, "error listing table x509_certs: force", err.Error())
+	assert.Equals(t, 0, buf.Len())
+}
+
+func TestRestoreBackup_errors(t *testing.T) {
+	b := mustBackup(t, map[string]map[string]string{
+		"x509_certs": {"1": "cert1"},
+	}, &BackupManifest{})
+
+	// An invalid backup does not modify the database.
+	db := &MockNoSQLDB{}
+	_, err := RestoreBackup(bytes.NewReader(b[:len(b)-10]), db)
+	assert.Error(t, err)
+
+	db.MDeleteTable = func(bucket []byte) error { return errors.New("force") }
+	_, err = RestoreBackup(bytes.NewReader(b), db)
+	assert.Equals(t, "error deleting table x509_certs: force", err.Error())
+
+	db.MDeleteTable = func(bucket []byte) error { return nil }
+	db.MCreateTable = func(bucket []byte) error { return nil }
+	db.MSet = func(bucket, key, value []byte) error { return errors.New("force") }
+	_, err = RestoreBackup(bytes.NewReader(b), db)
+	assert.Equals(t, "error restoring x509_certs/1: force", err.Error())
+}
+
+func TestTables(t *testing.T) {
+	got := Tables()
+	assert.Equals(t, tables, got)
+	got[0] = []byte("modified")
+	assert.Equals(t, revokedCertsTable, tables[0])
+}
@@ -1,15 +1,23 @@
 package db
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/db/dynamodb"
+	"github.com/smallstep/certificates/db/etcd"
+	"github.com/smallstep/certificates/db/mysql"
+	"github.com/smallstep/certificates/db/sqlite"
 	"github.com/smallstep/nosql"
 	"github.com/smallstep/nosql/database"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -17,11 +25,50 @@ var (
 	certsTable             = []byte("x509_certs")
 	revokedCertsTable      = []byte("revoked_x509_certs")
 	revokedSSHCertsTable   = []byte("revoked_ssh_certs")
+	revokedSSHKeyIDsTable  = []byte("revoked_ssh_key_ids")
 	usedOTTTable           = []byte("used_ott")
 	sshCertsTable          = []byte("ssh_certs")
 	sshHostsTable          = []byte("ssh_hosts")
 	sshUsersTable          = []byte("ssh_users")
 	sshHostPrincipalsTable = []byte("ssh_host_principals")
+	provisionerKeysTable   = []byte("provisioner_keys")
+	provisionersTable      = []byte("provisioners")
+	subCARequestsTable     = []byte("subca_requests")
+	ctSubmissionsTable     = []byte("ct_submissions")
+	lintResultsTable       = []byte("lint_results")
+	rootRotationTable      = []byte("root_rotation")
+	serialNumbersTable     = []byte("x509_serial_numbers")
+	serialCounterTable     = []byte("x509_serial_counter")
+	revocationBatchesTable = []byte("revocation_batches")
+	shortLivedTable        = []byte("short_lived_summaries")
+	certsIndexTable        = []byte("x509_certs_index")
+	certsByNameTable       = []byte("x509_certs_by_name")
+	schemaVersionsTable    = []byte("schema_versions")
+	auditLogTable          = []byte("audit_log")
+	auditHeadTable         = []byte("audit_log_head")
+	adminsTable            = []byte("admins")
+	policiesTable          = []byte("policies")
+	jobsTable              = []byte("jobs")
+	intermediateTable      = []byte("intermediate")
+
+	// tables are the tables created by New.
+	tables = [][]byte{
+		revokedCertsTable, certsTable, usedOTTTable,
+		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
+		revokedSSHCertsTable, revokedSSHKeyIDsTable, provisionerKeysTable,
+		provisionersTable, subCARequestsTable, ctSubmissionsTable,
+		rootRotationTable, lintResultsTable, serialNumbersTable,
+		serialCounterTable, revocationBatchesTable, shortLivedTable,
+		certsIndexTable, certsByNameTable, schemaVersionsTable,
+		auditLogTable, auditHeadTable, adminsTable, policiesTable,
+		jobsTable, intermediateTable,
+	}
+
+	rootRotationKey  = []byte("current")
+	intermediateKey  = []byte("current")
+	serialCounterKey = []byte("current")
+	auditHeadKey     = []byte("current")
+	authorityPolicy  = []byte("authority")
 )
 
 // ErrAlreadyExists can be returned if the DB attempts to set a key that has
@@ -34,6 +81,9 @@ type Config struct {
 	DataSource string `json:"dataSource"`
 	ValueDir   string `json:"valueDir,omitempty"`
 	Database   string `json:"database,omitempty"`
+
+	// Encryption configures the encryption of the sensitive values.
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
 }
 
 // AuthDB is an interface over an Authority DB client that implements a nosql.DB interface.
@@ -41,12 +91,57 @@ type AuthDB interface {
 	IsRevoked(sn string) (bool, error)
 	IsSSHRevoked(sn string) (bool, error)
 	Revoke(rci *RevokedCertificateInfo) error
+	GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error)
+	Unrevoke(sn string) error
 	RevokeSSH(rci *RevokedCertificateInfo) error
+	IsSSHKeyIDRevoked(keyID string) (bool, error)
+	RevokeSSHKeyID(rci *RevokedCertificateInfo) error
+	GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error)
+	GetRevokedCertificates() ([]*RevokedCertificateInfo, error)
+	RevokeBatch(id string, batch []byte, rcis []*RevokedCertificateInfo) error
+	GetRevocationBatch(id string) ([]byte, error)
+	GetRevocationBatches() ([][]byte, error)
 	StoreCertificate(crt *x509.Certificate) error
+	GetCertificates() ([]*x509.Certificate, error)
+	StoreCTSubmissions(serial string, submissions []byte) error
+	StoreLintResults(serial string, results []byte) error
 	UseToken(id, tok string) (bool, error)
 	IsSSHHost(name string) (bool, error)
 	StoreSSHCertificate(crt *ssh.Certificate) error
 	GetSSHHostPrincipals() ([]string, error)
+	GetProvisionerKeys(provisionerID string) ([]byte, error)
+	StoreProvisionerKeys(provisionerID string, keys []byte) error
+	GetProvisioners() ([][]byte, error)
+	CmpAndSwapProvisioner(name string, oldValue, newValue []byte) (bool, error)
+	GetAdmins() ([][]byte, error)
+	CmpAndSwapAdmin(subject string, oldValue, newValue []byte) (bool, error)
+	GetPolicy() ([]byte, error)
+	CmpAndSwapPolicy(oldValue, newValue []byte) (bool, error)
+	GetJob(name string) ([]byte, error)
+	CmpAndSwapJob(name string, oldValue, newValue []byte) (bool, error)
+	GetSubCARequest(id string) ([]byte, error)
+	GetSubCARequests() ([][]byte, error)
+	CmpAndSwapSubCARequest(id string, oldValue, newValue []byte) (bool, error)
+	GetRootRotation() ([]byte, error)
+	CmpAndSwapRootRotation(oldValue, newValue []byte) (bool, error)
+	GetIntermediate() ([]byte, error)
+	CmpAndSwapIntermediate(oldValue, newValue []byte) (bool, error)
+	ReserveSerialNumber(serial string) (bool, error)
+	GetSerialCounter() ([]byte, error)
+	CmpAndSwapSerialCounter(oldValue, newValue []byte) (bool, error)
+	GetShortLivedSummary(key string) ([]byte, error)
+	GetShortLivedSummaries() ([][]byte, error)
+	CmpAndSwapShortLivedSummary(key string, oldValue, newValue []byte) (bool, error)
+	StoreCertificateIndex(serial string, index []byte, names []string) error
+	DeleteCertificate(serial string, names []string) error
+	GetCertificateIndex(serial string) ([]byte, error)
+	GetCertificateIndexes() ([][]byte, error)
+	GetCertificateSerialsByName(name string) ([]string, error)
+	GetSchemaVersion(name string) (int, error)
+	SetSchemaVersion(name string, version int) error
+	StoreAuditEvent(seq uint64, event []byte) error
+	GetLastAuditEvent() ([]byte, error)
+	GetAuditEvents() ([][]byte, error)
 	Shutdown() error
 }
 
@@ -62,17 +157,23 @@ func New(c *Config) (AuthDB, error) {
 		return newSimpleDB(c)
 	}
 
-	db, err := nosql.New(c.Type, c.DataSource, nosql.WithDatabase(c.Database),
-		nosql.WithValueDir(c.ValueDir))
+	if err := c.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+
+	db, err := open(c)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error opening database of Type %s with source %s", c.Type, c.DataSource)
 	}
-
-	tables := [][]byte{
-		revokedCertsTable, certsTable, usedOTTTable,
-		sshCertsTable, sshHostsTable, sshHostPrincipalsTable, sshUsersTable,
-		revokedSSHCertsTable,
+	if c.Encryption != nil {
+		edb, err := newEncryptedDB(db, c.Encryption)
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "error loading database encryption keys")
+		}
+		db = edb
 	}
+
 	for _, b := range tables {
 		if err := db.CreateTable(b); err != nil {
 			return nil, errors.Wrapf(err, "error creating table %s",
@@ -83,16 +184,56 @@ func New(c *Config) (AuthDB, error) {
 	return &DB{db, true}, nil
 }
 
+// open opens the database in the configuration. MySQL, MariaDB, SQLite, etcd
+// and DynamoDB use the drivers in this module, the rest of the types use the
+// nosql drivers.
+func open(c *Config) (nosql.DB, error) {
+	switch strings.ToLower(c.Type) {
+	case "etcd":
+		db := &etcd.DB{}
+		if err := db.Open(c.DataSource, nosql.WithDatabase(c.Database)); err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "dynamodb":
+		db := &dynamodb.DB{}
+		if err := db.Open(c.DataSource, nosql.WithDatabase(c.Database)); err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "mysql", "mariadb":
+		db := &mysql.DB{}
+		if err := db.Open(c.DataSource, nosql.WithDatabase(c.Database)); err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "sqlite", "sqlite3":
+		db := &sqlite.DB{}
+		if err := db.Open(c.DataSource, nosql.WithDatabase(c.Database)); err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nosql.New(c.Type, c.DataSource, nosql.WithDatabase(c.Database),
+			nosql.WithValueDir(c.ValueDir))
+	}
+}
+
 // RevokedCertificateInfo contains information regarding the certificate
-// revocation action.
+// revocation action. SSH certificates can also be revoked by key ID, in that
+// case the Serial is empty. BatchID is the id of the batch revocation that
+// revoked the certificate.
 type RevokedCertificateInfo struct {
 	Serial        string
+	KeyID         string `json:",omitempty"`
 	ProvisionerID string
 	ReasonCode    int
 	Reason        string
 	RevokedAt     time.Time
 	TokenID       string
+	Subject       string `json:",omitempty"`
 	MTLS          bool
+	BatchID       string `json:",omitempty"`
 }
 
 // IsRevoked returns whether or not a certificate with the given identifier
@@ -141,14 +282,29 @@ func (db *DB) IsSSHRevoked(sn string) (bool, error) {
 	return true, nil
 }
 
-// Revoke adds a certificate to the revocation table.
+// Revoke adds a certificate to the revocation table. A certificate on hold
+// can be revoked again with a different reason, the new revocation replaces
+// the hold.
 func (db *DB) Revoke(rci *RevokedCertificateInfo) error {
 	rcib, err := json.Marshal(rci)
 	if err != nil {
 		return errors.Wrap(err, "error marshaling revoked certificate info")
 	}
 
-	_, swapped, err := db.CmpAndSwap(revokedCertsTable, []byte(rci.Serial), nil, rcib)
+	old, swapped, err := db.CmpAndSwap(revokedCertsTable, []byte(rci.Serial), nil, rcib)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "error AuthDB CmpAndSwap")
+	case swapped:
+		return nil
+	}
+
+	var current RevokedCertificateInfo
+	if err := json.Unmarshal(old, &current); err != nil ||
+		current.ReasonCode != ocsp.CertificateHold || rci.ReasonCode == ocsp.CertificateHold {
+		return ErrAlreadyExists
+	}
+	_, swapped, err = db.CmpAndSwap(revokedCertsTable, []byte(rci.Serial), old, rcib)
 	switch {
 	case err != nil:
 		return errors.Wrap(err, "error AuthDB CmpAndSwap")
@@ -159,6 +315,32 @@ func (db *DB) Revoke(rci *RevokedCertificateInfo) error {
 	}
 }
 
+// GetRevokedCertificate returns the revocation information of the X.509
+// certificate with the given serial number, or nil if it has not been
+// revoked.
+func (db *DB) GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error) {
+	b, err := db.Get(revokedCertsTable, []byte(sn))
+	if err != nil {
+		if nosql.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "error checking revocation bucket")
+	}
+	rci := new(RevokedCertificateInfo)
+	if err := json.Unmarshal(b, rci); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling revoked certificate info %s", sn)
+	}
+	return rci, nil
+}
+
+// Unrevoke removes a certificate from the revocation table.
+func (db *DB) Unrevoke(sn string) error {
+	if err := db.Del(revokedCertsTable, []byte(sn)); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	return nil
+}
+
 // RevokeSSH adds a SSH certificate to the revocation table.
 func (db *DB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	rcib, err := json.Marshal(rci)
@@ -177,6 +359,126 @@ func (db *DB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	}
 }
 
+// IsSSHKeyIDRevoked returns whether or not the SSH certificates with the given
+// key ID have been revoked.
+func (db *DB) IsSSHKeyIDRevoked(keyID string) (bool, error) {
+	// If the DB is nil then act as pass through.
+	if db == nil {
+		return false, nil
+	}
+
+	if _, err := db.Get(revokedSSHKeyIDsTable, []byte(keyID)); err != nil {
+		if nosql.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "error checking revocation bucket")
+	}
+	return true, nil
+}
+
+// RevokeSSHKeyID adds a SSH certificate key ID to the revocation table, all
+// the certificates with that key ID are revoked.
+func (db *DB) RevokeSSHKeyID(rci *RevokedCertificateInfo) error {
+	rcib, err := json.Marshal(rci)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling revoked certificate info")
+	}
+
+	_, swapped, err := db.CmpAndSwap(revokedSSHKeyIDsTable, []byte(rci.KeyID), nil, rcib)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "error AuthDB CmpAndSwap")
+	case !swapped:
+		return ErrAlreadyExists
+	default:
+		return nil
+	}
+}
+
+// GetRevokedSSHCertificates returns the SSH certificates revoked by serial
+// number and by key ID.
+func (db *DB) GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error) {
+	var list []*RevokedCertificateInfo
+	for _, table := range [][]byte{revokedSSHCertsTable, revokedSSHKeyIDsTable} {
+		entries, err := db.List(table)
+		if err != nil {
+			return nil, errors.Wrap(err, "database List error")
+		}
+		for _, e := range entries {
+			rci := new(RevokedCertificateInfo)
+			if err := json.Unmarshal(e.Value, rci); err != nil {
+				return nil, errors.Wrapf(err, "error unmarshaling revoked certificate info %s", e.Key)
+			}
+			list = append(list, rci)
+		}
+	}
+	return list, nil
+}
+
+// GetRevokedCertificates returns the X.509 certificates revoked by serial
+// number.
+func (db *DB) GetRevokedCertificates() ([]*RevokedCertificateInfo, error) {
+	entries, err := db.List(revokedCertsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	var list []*RevokedCertificateInfo
+	for _, e := range entries {
+		rci := new(RevokedCertificateInfo)
+		if err := json.Unmarshal(e.Value, rci); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling revoked certificate info %s", e.Key)
+		}
+		list = append(list, rci)
+	}
+	return list, nil
+}
+
+// RevokeBatch adds the given certificates to the revocation table and stores
+// the JSON encoded audit record of the batch with the given id in a single
+// transaction. The certificates that are already revoked are not modified.
+func (db *DB) RevokeBatch(id string, batch []byte, rcis []*RevokedCertificateInfo) error {
+	tx := new(database.Tx)
+	for _, rci := range rcis {
+		rcib, err := json.Marshal(rci)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling revoked certificate info")
+		}
+		tx.Cas(revokedCertsTable, []byte(rci.Serial), rcib)
+	}
+	tx.Set(revocationBatchesTable, []byte(id), batch)
+	if err := db.Update(tx); err != nil {
+		return errors.Wrap(err, "database Update error")
+	}
+	return nil
+}
+
+// GetRevocationBatch returns the JSON encoded audit record of the revocation
+// batch with the given id, or nil if it does not exist.
+func (db *DB) GetRevocationBatch(id string) ([]byte, error) {
+	b, err := db.Get(revocationBatchesTable, []byte(id))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// GetRevocationBatches returns all the JSON encoded audit records of the
+// revocation batches.
+func (db *DB) GetRevocationBatches() ([][]byte, error) {
+	entries, err := db.List(revocationBatchesTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
 // StoreCertificate stores a certificate PEM.
 func (db *DB) StoreCertificate(crt *x509.Certificate) error {
 	if err := db.Set(certsTable, []byte(crt.SerialNumber.String()), crt.Raw); err != nil {
@@ -185,6 +487,42 @@ func (db *DB) StoreCertificate(crt *x509.Certificate) error {
 	return nil
 }
 
+// GetCertificates returns all the X.509 certificates stored.
+func (db *DB) GetCertificates() ([]*x509.Certificate, error) {
+	entries, err := db.List(certsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([]*x509.Certificate, len(entries))
+	for i, e := range entries {
+		crt, err := x509.ParseCertificate(e.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing certificate %s", e.Key)
+		}
+		list[i] = crt
+	}
+	return list, nil
+}
+
+// StoreCTSubmissions stores the JSON encoded status of the submissions of the
+// precertificate with the given serial number to the Certificate Transparency
+// logs.
+func (db *DB) StoreCTSubmissions(serial string, submissions []byte) error {
+	if err := db.Set(ctSubmissionsTable, []byte(serial), submissions); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// StoreLintResults stores the JSON encoded lint warnings of the certificate
+// with the given serial number.
+func (db *DB) StoreLintResults(serial string, results []byte) error {
+	if err := db.Set(lintResultsTable, []byte(serial), results); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
 // UseToken returns true if we were able to successfully store the token for
 // for the first time, false otherwise.
 func (db *DB) UseToken(id, tok string) (bool, error) {
@@ -259,6 +597,511 @@ func (db *DB) GetSSHHostPrincipals() ([]string, error) {
 	return principals, nil
 }
 
+// GetProvisionerKeys returns the JSON encoded keys added to a provisioner
+// using the admin API, or nil if none has been added.
+func (db *DB) GetProvisionerKeys(provisionerID string) ([]byte, error) {
+	b, err := db.Get(provisionerKeysTable, []byte(provisionerID))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// StoreProvisionerKeys stores the JSON encoded keys of a provisioner.
+func (db *DB) StoreProvisionerKeys(provisionerID string, keys []byte) error {
+	if err := db.Set(provisionerKeysTable, []byte(provisionerID), keys); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// GetProvisioners returns the JSON encoded provisioners stored using the admin
+// API.
+func (db *DB) GetProvisioners() ([][]byte, error) {
+	entries, err := db.List(provisionersTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
+// CmpAndSwapProvisioner stores the JSON encoded provisioner with the given
+// name if the current value matches oldValue, a nil oldValue requires the
+// provisioner to not exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapProvisioner(name string, oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(provisionersTable, []byte(name), oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetAdmins returns the JSON encoded administrators stored using the admin
+// API.
+func (db *DB) GetAdmins() ([][]byte, error) {
+	entries, err := db.List(adminsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
+// CmpAndSwapAdmin stores the JSON encoded administrator with the given subject
+// if the current value matches oldValue, a nil oldValue requires the
+// administrator to not exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapAdmin(subject string, oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(adminsTable, []byte(subject), oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetPolicy returns the JSON encoded policy of the authority stored using the
+// admin API, or nil if there is none.
+func (db *DB) GetPolicy() ([]byte, error) {
+	b, err := db.Get(policiesTable, authorityPolicy)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// CmpAndSwapPolicy stores the JSON encoded policy of the authority if the
+// current value matches oldValue, a nil oldValue requires the policy to not
+// exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapPolicy(oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(policiesTable, authorityPolicy, oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetJob returns the JSON encoded state of the periodic job with the given
+// name, or nil if it does not exist.
+func (db *DB) GetJob(name string) ([]byte, error) {
+	b, err := db.Get(jobsTable, []byte(name))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// CmpAndSwapJob stores the JSON encoded state of the periodic job with the
+// given name if the current value matches oldValue, a nil oldValue requires
+// the job to not exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapJob(name string, oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(jobsTable, []byte(name), oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetSubCARequest returns the JSON encoded sub-CA request with the given id,
+// or nil if it does not exist.
+func (db *DB) GetSubCARequest(id string) ([]byte, error) {
+	b, err := db.Get(subCARequestsTable, []byte(id))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// GetSubCARequests returns all the JSON encoded sub-CA requests.
+func (db *DB) GetSubCARequests() ([][]byte, error) {
+	entries, err := db.List(subCARequestsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
+// CmpAndSwapSubCARequest stores the JSON encoded sub-CA request with the given
+// id if the current value matches oldValue, a nil oldValue requires the
+// request to not exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapSubCARequest(id string, oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(subCARequestsTable, []byte(id), oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetRootRotation returns the JSON encoded rotation of the root certificate,
+// or nil if there is no rotation.
+func (db *DB) GetRootRotation() ([]byte, error) {
+	b, err := db.Get(rootRotationTable, rootRotationKey)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// CmpAndSwapRootRotation stores the JSON encoded rotation of the root
+// certificate if the current value matches oldValue, a nil oldValue requires
+// the rotation to not exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapRootRotation(oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(rootRotationTable, rootRotationKey, oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetIntermediate returns the JSON encoded intermediate certificate renewed
+// with the admin API, or nil if it has never been renewed.
+func (db *DB) GetIntermediate() ([]byte, error) {
+	b, err := db.Get(intermediateTable, intermediateKey)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// CmpAndSwapIntermediate stores the JSON encoded intermediate certificate if
+// the current value matches oldValue, a nil oldValue requires the value to
+// not exist. It returns false if the value was not swapped.
+func (db *DB) CmpAndSwapIntermediate(oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(intermediateTable, intermediateKey, oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// ReserveSerialNumber marks a serial number as used before signing a
+// certificate with it. It returns false if the serial number was already
+// reserved, e.g. by another replica of the CA.
+func (db *DB) ReserveSerialNumber(serial string) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(serialNumbersTable, []byte(serial), nil, []byte(time.Now().UTC().Format(time.RFC3339)))
+	if err != nil {
+		return false, errors.Wrapf(err, "error reserving serial number %s/%s",
+			string(serialNumbersTable), serial)
+	}
+	return swapped, nil
+}
+
+// GetSerialCounter returns the last serial number generated by the sequential
+// strategy, or nil if there is none.
+func (db *DB) GetSerialCounter() ([]byte, error) {
+	b, err := db.Get(serialCounterTable, serialCounterKey)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// CmpAndSwapSerialCounter stores the last serial number generated by the
+// sequential strategy if the current value matches oldValue, a nil oldValue
+// requires the counter to not exist. It returns false if the value was not
+// swapped.
+func (db *DB) CmpAndSwapSerialCounter(oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(serialCounterTable, serialCounterKey, oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// GetShortLivedSummary returns the JSON encoded summary of the short-lived
+// certificates with the given key, or nil if it does not exist.
+func (db *DB) GetShortLivedSummary(key string) ([]byte, error) {
+	b, err := db.Get(shortLivedTable, []byte(key))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// GetShortLivedSummaries returns all the JSON encoded summaries of the
+// short-lived certificates.
+func (db *DB) GetShortLivedSummaries() ([][]byte, error) {
+	entries, err := db.List(shortLivedTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
+// CmpAndSwapShortLivedSummary stores the summary of the short-lived
+// certificates with the given key if the current value matches oldValue, a nil
+// oldValue requires the summary to not exist. It returns false if the value
+// was not swapped.
+func (db *DB) CmpAndSwapShortLivedSummary(key string, oldValue, newValue []byte) (bool, error) {
+	_, swapped, err := db.CmpAndSwap(shortLivedTable, []byte(key), oldValue, newValue)
+	if err != nil {
+		return false, errors.Wrap(err, "database CmpAndSwap error")
+	}
+	return swapped, nil
+}
+
+// StoreCertificateIndex stores the JSON encoded index entry of the certificate
+// with the given serial number, and adds the serial number to the list of
+// certificates of each one of the names, compared in lowercase.
+func (db *DB) StoreCertificateIndex(serial string, index []byte, names []string) error {
+	if err := db.Set(certsIndexTable, []byte(serial), index); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	for _, name := range names {
+		if err := db.addCertificateName(strings.ToLower(name), serial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) addCertificateName(name, serial string) error {
+	for {
+		var serials []string
+		old, err := db.Get(certsByNameTable, []byte(name))
+		switch {
+		case database.IsErrNotFound(err):
+			old = nil
+		case err != nil:
+			return errors.Wrap(err, "database Get error")
+		default:
+			if err := json.Unmarshal(old, &serials); err != nil {
+				return errors.Wrapf(err, "error unmarshaling certificates of %s", name)
+			}
+		}
+		for _, s := range serials {
+			if s == serial {
+				return nil
+			}
+		}
+		b, err := json.Marshal(append(serials, serial))
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling certificates of %s", name)
+		}
+		_, swapped, err := db.CmpAndSwap(certsByNameTable, []byte(name), old, b)
+		if err != nil {
+			return errors.Wrap(err, "database CmpAndSwap error")
+		}
+		if swapped {
+			return nil
+		}
+	}
+}
+
+// GetCertificateIndex returns the JSON encoded index entry of the certificate
+// with the given serial number, or nil if it does not exist.
+func (db *DB) GetCertificateIndex(serial string) ([]byte, error) {
+	b, err := db.Get(certsIndexTable, []byte(serial))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// GetCertificateIndexes returns all the JSON encoded index entries of the
+// certificates.
+func (db *DB) GetCertificateIndexes() ([][]byte, error) {
+	entries, err := db.List(certsIndexTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
+// GetCertificateSerialsByName returns the serial numbers of the certificates
+// with the given name, compared in lowercase.
+func (db *DB) GetCertificateSerialsByName(name string) ([]string, error) {
+	b, err := db.Get(certsByNameTable, []byte(strings.ToLower(name)))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	var serials []string
+	if err := json.Unmarshal(b, &serials); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling certificates of %s", name)
+	}
+	return serials, nil
+}
+
+// DeleteCertificate deletes the certificate with the given serial number and
+// its entries in the index of certificates. The revocation of the certificate
+// is kept.
+func (db *DB) DeleteCertificate(serial string, names []string) error {
+	if err := db.Del(certsTable, []byte(serial)); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	for _, name := range names {
+		if err := db.removeCertificateName(strings.ToLower(name), serial); err != nil {
+			return err
+		}
+	}
+	if err := db.Del(certsIndexTable, []byte(serial)); err != nil {
+		return errors.Wrap(err, "database Del error")
+	}
+	return nil
+}
+
+func (db *DB) removeCertificateName(name, serial string) error {
+	for {
+		old, err := db.Get(certsByNameTable, []byte(name))
+		switch {
+		case database.IsErrNotFound(err):
+			return nil
+		case err != nil:
+			return errors.Wrap(err, "database Get error")
+		}
+		var serials []string
+		if err := json.Unmarshal(old, &serials); err != nil {
+			return errors.Wrapf(err, "error unmarshaling certificates of %s", name)
+		}
+		newSerials := []string{}
+		for _, s := range serials {
+			if s != serial {
+				newSerials = append(newSerials, s)
+			}
+		}
+		if len(newSerials) == len(serials) {
+			return nil
+		}
+		b, err := json.Marshal(newSerials)
+		if err != nil {
+			return errors.Wrapf(err, "error marshaling certificates of %s", name)
+		}
+		_, swapped, err := db.CmpAndSwap(certsByNameTable, []byte(name), old, b)
+		if err != nil {
+			return errors.Wrap(err, "database CmpAndSwap error")
+		}
+		if swapped {
+			return nil
+		}
+	}
+}
+
+// GetSchemaVersion returns the version of the data with the given name, or 0
+// if it is not set.
+func (db *DB) GetSchemaVersion(name string) (int, error) {
+	b, err := db.Get(schemaVersionsTable, []byte(name))
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "database Get error")
+	}
+	v, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error parsing schema version of %s", name)
+	}
+	return v, nil
+}
+
+// SetSchemaVersion sets the version of the data with the given name.
+func (db *DB) SetSchemaVersion(name string, version int) error {
+	if err := db.Set(schemaVersionsTable, []byte(name), []byte(strconv.Itoa(version))); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// auditEventKey returns the key of the audit event with the given sequence
+// number, padded so the keys are sorted by sequence number.
+func auditEventKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", seq))
+}
+
+// StoreAuditEvent appends the JSON encoded audit event with the given sequence
+// number to the audit log and makes it the last event. The events cannot be
+// replaced, it returns ErrAlreadyExists if the sequence number is already
+// used.
+func (db *DB) StoreAuditEvent(seq uint64, event []byte) error {
+	_, swapped, err := db.CmpAndSwap(auditLogTable, auditEventKey(seq), nil, event)
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "database CmpAndSwap error")
+	case !swapped:
+		return ErrAlreadyExists
+	}
+	if err := db.Set(auditHeadTable, auditHeadKey, event); err != nil {
+		return errors.Wrap(err, "database Set error")
+	}
+	return nil
+}
+
+// GetLastAuditEvent returns the JSON encoded last event of the audit log, or
+// nil if the log is empty.
+func (db *DB) GetLastAuditEvent() ([]byte, error) {
+	b, err := db.Get(auditHeadTable, auditHeadKey)
+	if err != nil {
+		if database.IsErrNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "database Get error")
+	}
+	return b, nil
+}
+
+// GetAuditEvents returns all the JSON encoded events of the audit log sorted
+// by sequence number.
+func (db *DB) GetAuditEvents() ([][]byte, error) {
+	entries, err := db.List(auditLogTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "database List error")
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	list := make([][]byte, len(entries))
+	for i, e := range entries {
+		list[i] = e.Value
+	}
+	return list, nil
+}
+
 // Shutdown sends a shutdown message to the database.
 func (db *DB) Shutdown() error {
 	if db.isUp {
@@ -272,18 +1115,63 @@ func (db *DB) Shutdown() error {
 
 // MockAuthDB mocks the AuthDB interface. //
 type MockAuthDB struct {
-	Err                   error
-	Ret1                  interface{}
-	MIsRevoked            func(string) (bool, error)
-	MIsSSHRevoked         func(string) (bool, error)
-	MRevoke               func(rci *RevokedCertificateInfo) error
-	MRevokeSSH            func(rci *RevokedCertificateInfo) error
-	MStoreCertificate     func(crt *x509.Certificate) error
-	MUseToken             func(id, tok string) (bool, error)
-	MIsSSHHost            func(principal string) (bool, error)
-	MStoreSSHCertificate  func(crt *ssh.Certificate) error
-	MGetSSHHostPrincipals func() ([]string, error)
-	MShutdown             func() error
+	Err                    error
+	Ret1                   interface{}
+	MIsRevoked             func(string) (bool, error)
+	MIsSSHRevoked          func(string) (bool, error)
+	MRevoke                func(rci *RevokedCertificateInfo) error
+	MGetRevokedCert        func(sn string) (*RevokedCertificateInfo, error)
+	MUnrevoke              func(sn string) error
+	MRevokeSSH             func(rci *RevokedCertificateInfo) error
+	MIsSSHKeyIDRevoked     func(keyID string) (bool, error)
+	MRevokeSSHKeyID        func(rci *RevokedCertificateInfo) error
+	MGetRevokedSSHCerts    func() ([]*RevokedCertificateInfo, error)
+	MGetRevokedCerts       func() ([]*RevokedCertificateInfo, error)
+	MStoreCertificate      func(crt *x509.Certificate) error
+	MStoreCTSubmissions    func(serial string, submissions []byte) error
+	MStoreLintResults      func(serial string, results []byte) error
+	MUseToken              func(id, tok string) (bool, error)
+	MIsSSHHost             func(principal string) (bool, error)
+	MStoreSSHCertificate   func(crt *ssh.Certificate) error
+	MGetSSHHostPrincipals  func() ([]string, error)
+	MGetProvisionerKeys    func(provisionerID string) ([]byte, error)
+	MStoreProvisionerKeys  func(provisionerID string, keys []byte) error
+	MGetProvisioners       func() ([][]byte, error)
+	MCmpAndSwapProvisioner func(name string, oldValue, newValue []byte) (bool, error)
+	MGetAdmins             func() ([][]byte, error)
+	MCmpAndSwapAdmin       func(subject string, oldValue, newValue []byte) (bool, error)
+	MGetPolicy             func() ([]byte, error)
+	MCmpAndSwapPolicy      func(oldValue, newValue []byte) (bool, error)
+	MGetJob                func(name string) ([]byte, error)
+	MCmpAndSwapJob         func(name string, oldValue, newValue []byte) (bool, error)
+	MRevokeBatch           func(id string, batch []byte, rcis []*RevokedCertificateInfo) error
+	MGetRevocationBatch    func(id string) ([]byte, error)
+	MGetRevocationBatches  func() ([][]byte, error)
+	MGetCertificates       func() ([]*x509.Certificate, error)
+	MGetSubCARequest       func(id string) ([]byte, error)
+	MGetSubCARequests      func() ([][]byte, error)
+	MCmpAndSwapSubCA       func(id string, oldValue, newValue []byte) (bool, error)
+	MGetRootRotation       func() ([]byte, error)
+	MCmpAndSwapRotation    func(oldValue, newValue []byte) (bool, error)
+	MGetIntermediate       func() ([]byte, error)
+	MCmpAndSwapInter       func(oldValue, newValue []byte) (bool, error)
+	MReserveSerialNumber   func(serial string) (bool, error)
+	MGetSerialCounter      func() ([]byte, error)
+	MCmpAndSwapSerial      func(oldValue, newValue []byte) (bool, error)
+	MGetShortLived         func(key string) ([]byte, error)
+	MGetShortLivedList     func() ([][]byte, error)
+	MCmpAndSwapShortLived  func(key string, oldValue, newValue []byte) (bool, error)
+	MStoreCertIndex        func(serial string, index []byte, names []string) error
+	MDeleteCertificate     func(serial string, names []string) error
+	MGetCertIndex          func(serial string) ([]byte, error)
+	MGetCertIndexes        func() ([][]byte, error)
+	MGetCertSerialsByName  func(name string) ([]string, error)
+	MGetSchemaVersion      func(name string) (int, error)
+	MSetSchemaVersion      func(name string, version int) error
+	MStoreAuditEvent       func(seq uint64, event []byte) error
+	MGetLastAuditEvent     func() ([]byte, error)
+	MGetAuditEvents        func() ([][]byte, error)
+	MShutdown              func() error
 }
 
 // IsRevoked mock.
@@ -321,6 +1209,25 @@ func (m *MockAuthDB) Revoke(rci *RevokedCertificateInfo) error {
 	return m.Err
 }
 
+// GetRevokedCertificate mock.
+func (m *MockAuthDB) GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCert != nil {
+		return m.MGetRevokedCert(sn)
+	}
+	if m.Ret1 == nil {
+		return nil, m.Err
+	}
+	return m.Ret1.(*RevokedCertificateInfo), m.Err
+}
+
+// Unrevoke mock.
+func (m *MockAuthDB) Unrevoke(sn string) error {
+	if m.MUnrevoke != nil {
+		return m.MUnrevoke(sn)
+	}
+	return m.Err
+}
+
 // RevokeSSH mock.
 func (m *MockAuthDB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	if m.MRevokeSSH != nil {
@@ -329,6 +1236,38 @@ func (m *MockAuthDB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	return m.Err
 }
 
+// IsSSHKeyIDRevoked mock.
+func (m *MockAuthDB) IsSSHKeyIDRevoked(keyID string) (bool, error) {
+	if m.MIsSSHKeyIDRevoked != nil {
+		return m.MIsSSHKeyIDRevoked(keyID)
+	}
+	return false, m.Err
+}
+
+// RevokeSSHKeyID mock.
+func (m *MockAuthDB) RevokeSSHKeyID(rci *RevokedCertificateInfo) error {
+	if m.MRevokeSSHKeyID != nil {
+		return m.MRevokeSSHKeyID(rci)
+	}
+	return m.Err
+}
+
+// GetRevokedSSHCertificates mock.
+func (m *MockAuthDB) GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error) {
+	if m.MGetRevokedSSHCerts != nil {
+		return m.MGetRevokedSSHCerts()
+	}
+	return nil, m.Err
+}
+
+// GetRevokedCertificates mock.
+func (m *MockAuthDB) GetRevokedCertificates() ([]*RevokedCertificateInfo, error) {
+	if m.MGetRevokedCerts != nil {
+		return m.MGetRevokedCerts()
+	}
+	return nil, m.Err
+}
+
 // StoreCertificate mock.
 func (m *MockAuthDB) StoreCertificate(crt *x509.Certificate) error {
 	if m.MStoreCertificate != nil {
@@ -337,6 +1276,22 @@ func (m *MockAuthDB) StoreCertificate(crt *x509.Certificate) error {
 	return m.Err
 }
 
+// StoreCTSubmissions mock.
+func (m *MockAuthDB) StoreCTSubmissions(serial string, submissions []byte) error {
+	if m.MStoreCTSubmissions != nil {
+		return m.MStoreCTSubmissions(serial, submissions)
+	}
+	return m.Err
+}
+
+// StoreLintResults mock.
+func (m *MockAuthDB) StoreLintResults(serial string, results []byte) error {
+	if m.MStoreLintResults != nil {
+		return m.MStoreLintResults(serial, results)
+	}
+	return m.Err
+}
+
 // IsSSHHost mock.
 func (m *MockAuthDB) IsSSHHost(principal string) (bool, error) {
 	if m.MIsSSHHost != nil {
@@ -361,6 +1316,302 @@ func (m *MockAuthDB) GetSSHHostPrincipals() ([]string, error) {
 	return m.Ret1.([]string), m.Err
 }
 
+// GetProvisionerKeys mock, by default it does not return any key.
+func (m *MockAuthDB) GetProvisionerKeys(provisionerID string) ([]byte, error) {
+	if m.MGetProvisionerKeys != nil {
+		return m.MGetProvisionerKeys(provisionerID)
+	}
+	return nil, nil
+}
+
+// StoreProvisionerKeys mock.
+func (m *MockAuthDB) StoreProvisionerKeys(provisionerID string, keys []byte) error {
+	if m.MStoreProvisionerKeys != nil {
+		return m.MStoreProvisionerKeys(provisionerID, keys)
+	}
+	return m.Err
+}
+
+// GetProvisioners mock, by default it does not return any provisioner.
+func (m *MockAuthDB) GetProvisioners() ([][]byte, error) {
+	if m.MGetProvisioners != nil {
+		return m.MGetProvisioners()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapProvisioner mock.
+func (m *MockAuthDB) CmpAndSwapProvisioner(name string, oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapProvisioner != nil {
+		return m.MCmpAndSwapProvisioner(name, oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetAdmins mock, by default it does not return any administrator.
+func (m *MockAuthDB) GetAdmins() ([][]byte, error) {
+	if m.MGetAdmins != nil {
+		return m.MGetAdmins()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapAdmin mock.
+func (m *MockAuthDB) CmpAndSwapAdmin(subject string, oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapAdmin != nil {
+		return m.MCmpAndSwapAdmin(subject, oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetPolicy mock, by default there is no policy.
+func (m *MockAuthDB) GetPolicy() ([]byte, error) {
+	if m.MGetPolicy != nil {
+		return m.MGetPolicy()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapPolicy mock.
+func (m *MockAuthDB) CmpAndSwapPolicy(oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapPolicy != nil {
+		return m.MCmpAndSwapPolicy(oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetJob mock, by default the job has never run.
+func (m *MockAuthDB) GetJob(name string) ([]byte, error) {
+	if m.MGetJob != nil {
+		return m.MGetJob(name)
+	}
+	return nil, nil
+}
+
+// CmpAndSwapJob mock.
+func (m *MockAuthDB) CmpAndSwapJob(name string, oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapJob != nil {
+		return m.MCmpAndSwapJob(name, oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// RevokeBatch mock.
+func (m *MockAuthDB) RevokeBatch(id string, batch []byte, rcis []*RevokedCertificateInfo) error {
+	if m.MRevokeBatch != nil {
+		return m.MRevokeBatch(id, batch, rcis)
+	}
+	return m.Err
+}
+
+// GetRevocationBatch mock, by default it does not return any batch.
+func (m *MockAuthDB) GetRevocationBatch(id string) ([]byte, error) {
+	if m.MGetRevocationBatch != nil {
+		return m.MGetRevocationBatch(id)
+	}
+	return nil, m.Err
+}
+
+// GetRevocationBatches mock, by default it does not return any batch.
+func (m *MockAuthDB) GetRevocationBatches() ([][]byte, error) {
+	if m.MGetRevocationBatches != nil {
+		return m.MGetRevocationBatches()
+	}
+	return nil, m.Err
+}
+
+// GetCertificates mock, by default it does not return any certificate.
+func (m *MockAuthDB) GetCertificates() ([]*x509.Certificate, error) {
+	if m.MGetCertificates != nil {
+		return m.MGetCertificates()
+	}
+	return nil, m.Err
+}
+
+// GetSubCARequest mock, by default it does not return any request.
+func (m *MockAuthDB) GetSubCARequest(id string) ([]byte, error) {
+	if m.MGetSubCARequest != nil {
+		return m.MGetSubCARequest(id)
+	}
+	return nil, nil
+}
+
+// GetSubCARequests mock, by default it does not return any request.
+func (m *MockAuthDB) GetSubCARequests() ([][]byte, error) {
+	if m.MGetSubCARequests != nil {
+		return m.MGetSubCARequests()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapSubCARequest mock.
+func (m *MockAuthDB) CmpAndSwapSubCARequest(id string, oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapSubCA != nil {
+		return m.MCmpAndSwapSubCA(id, oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetRootRotation mock, by default there is no rotation.
+func (m *MockAuthDB) GetRootRotation() ([]byte, error) {
+	if m.MGetRootRotation != nil {
+		return m.MGetRootRotation()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapRootRotation mock.
+func (m *MockAuthDB) CmpAndSwapRootRotation(oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapRotation != nil {
+		return m.MCmpAndSwapRotation(oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetIntermediate mock, by default the intermediate has not been renewed.
+func (m *MockAuthDB) GetIntermediate() ([]byte, error) {
+	if m.MGetIntermediate != nil {
+		return m.MGetIntermediate()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapIntermediate mock.
+func (m *MockAuthDB) CmpAndSwapIntermediate(oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapInter != nil {
+		return m.MCmpAndSwapInter(oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// ReserveSerialNumber mock.
+func (m *MockAuthDB) ReserveSerialNumber(serial string) (bool, error) {
+	if m.MReserveSerialNumber != nil {
+		return m.MReserveSerialNumber(serial)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetSerialCounter mock, by default there is no counter.
+func (m *MockAuthDB) GetSerialCounter() ([]byte, error) {
+	if m.MGetSerialCounter != nil {
+		return m.MGetSerialCounter()
+	}
+	return nil, nil
+}
+
+// CmpAndSwapSerialCounter mock.
+func (m *MockAuthDB) CmpAndSwapSerialCounter(oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapSerial != nil {
+		return m.MCmpAndSwapSerial(oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// GetShortLivedSummary mock, by default there is no summary.
+func (m *MockAuthDB) GetShortLivedSummary(key string) ([]byte, error) {
+	if m.MGetShortLived != nil {
+		return m.MGetShortLived(key)
+	}
+	return nil, nil
+}
+
+// GetShortLivedSummaries mock, by default it does not return any summary.
+func (m *MockAuthDB) GetShortLivedSummaries() ([][]byte, error) {
+	if m.MGetShortLivedList != nil {
+		return m.MGetShortLivedList()
+	}
+	return nil, m.Err
+}
+
+// CmpAndSwapShortLivedSummary mock.
+func (m *MockAuthDB) CmpAndSwapShortLivedSummary(key string, oldValue, newValue []byte) (bool, error) {
+	if m.MCmpAndSwapShortLived != nil {
+		return m.MCmpAndSwapShortLived(key, oldValue, newValue)
+	}
+	return m.Err == nil, m.Err
+}
+
+// StoreCertificateIndex mock.
+func (m *MockAuthDB) StoreCertificateIndex(serial string, index []byte, names []string) error {
+	if m.MStoreCertIndex != nil {
+		return m.MStoreCertIndex(serial, index, names)
+	}
+	return m.Err
+}
+
+// DeleteCertificate mock.
+func (m *MockAuthDB) DeleteCertificate(serial string, names []string) error {
+	if m.MDeleteCertificate != nil {
+		return m.MDeleteCertificate(serial, names)
+	}
+	return m.Err
+}
+
+// GetCertificateIndex mock, by default there is no index entry.
+func (m *MockAuthDB) GetCertificateIndex(serial string) ([]byte, error) {
+	if m.MGetCertIndex != nil {
+		return m.MGetCertIndex(serial)
+	}
+	return nil, nil
+}
+
+// GetCertificateIndexes mock, by default it does not return any index entry.
+func (m *MockAuthDB) GetCertificateIndexes() ([][]byte, error) {
+	if m.MGetCertIndexes != nil {
+		return m.MGetCertIndexes()
+	}
+	return nil, m.Err
+}
+
+// GetCertificateSerialsByName mock, by default it does not return any serial.
+func (m *MockAuthDB) GetCertificateSerialsByName(name string) ([]string, error) {
+	if m.MGetCertSerialsByName != nil {
+		return m.MGetCertSerialsByName(name)
+	}
+	return nil, m.Err
+}
+
+// GetSchemaVersion mock.
+func (m *MockAuthDB) GetSchemaVersion(name string) (int, error) {
+	if m.MGetSchemaVersion != nil {
+		return m.MGetSchemaVersion(name)
+	}
+	return 0, m.Err
+}
+
+// SetSchemaVersion mock.
+func (m *MockAuthDB) SetSchemaVersion(name string, version int) error {
+	if m.MSetSchemaVersion != nil {
+		return m.MSetSchemaVersion(name, version)
+	}
+	return m.Err
+}
+
+// StoreAuditEvent mock.
+func (m *MockAuthDB) StoreAuditEvent(seq uint64, event []byte) error {
+	if m.MStoreAuditEvent != nil {
+		return m.MStoreAuditEvent(seq, event)
+	}
+	return m.Err
+}
+
+// GetLastAuditEvent mock, by default the audit log is empty.
+func (m *MockAuthDB) GetLastAuditEvent() ([]byte, error) {
+	if m.MGetLastAuditEvent != nil {
+		return m.MGetLastAuditEvent()
+	}
+	return nil, m.Err
+}
+
+// GetAuditEvents mock, by default the audit log is empty.
+func (m *MockAuthDB) GetAuditEvents() ([][]byte, error) {
+	if m.MGetAuditEvents != nil {
+		return m.MGetAuditEvents()
+	}
+	return nil, m.Err
+}
+
 // Shutdown mock.
 func (m *MockAuthDB) Shutdown() error {
 	if m.MShutdown != nil {
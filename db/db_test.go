@@ -1,8 +1,16 @@
 package db
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/smallstep/assert"
 	"github.com/smallstep/nosql/database"
@@ -72,6 +80,27 @@ func TestRevoke(t *testing.T) {
 			}, true},
 			err: ErrAlreadyExists,
 		},
+		"error/was already on hold": {
+			rci: &RevokedCertificateInfo{Serial: "sn", ReasonCode: 6},
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, sn, old, newval []byte) ([]byte, bool, error) {
+					return []byte(`{"Serial":"sn","ReasonCode":6}`), false, nil
+				},
+			}, true},
+			err: ErrAlreadyExists,
+		},
+		"error/revoke on hold": {
+			rci: &RevokedCertificateInfo{Serial: "sn", ReasonCode: 1},
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, sn, old, newval []byte) ([]byte, bool, error) {
+					if old == nil {
+						return []byte(`{"Serial":"sn","ReasonCode":6}`), false, nil
+					}
+					return nil, false, errors.New("force")
+				},
+			}, true},
+			err: errors.New("error AuthDB CmpAndSwap: force"),
+		},
 		"ok": {
 			rci: &RevokedCertificateInfo{Serial: "sn"},
 			db: &DB{&MockNoSQLDB{
@@ -80,6 +109,17 @@ func TestRevoke(t *testing.T) {
 				},
 			}, true},
 		},
+		"ok/revoke on hold": {
+			rci: &RevokedCertificateInfo{Serial: "sn", ReasonCode: 1},
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, sn, old, newval []byte) ([]byte, bool, error) {
+					if old == nil {
+						return []byte(`{"Serial":"sn","ReasonCode":6}`), false, nil
+					}
+					return newval, true, nil
+				},
+			}, true},
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -94,6 +134,244 @@ func TestRevoke(t *testing.T) {
 	}
 }
 
+func TestGetRevokedCertificate(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want *RevokedCertificateInfo
+		err  error
+	}{
+		"error/force get": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, errors.New("force")
+				},
+			}, true},
+			err: errors.New("error checking revocation bucket: force"),
+		},
+		"error/unmarshal": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return []byte("foo"), nil
+				},
+			}, true},
+			err: errors.New("error unmarshaling revoked certificate info sn"),
+		},
+		"ok/not found": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					return nil, database.ErrNotFound
+				},
+			}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, bucket, revokedCertsTable)
+					assert.Equals(t, key, []byte("sn"))
+					return []byte(`{"Serial":"sn","ReasonCode":6}`), nil
+				},
+			}, true},
+			want: &RevokedCertificateInfo{Serial: "sn", ReasonCode: 6},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rci, err := tc.db.GetRevokedCertificate("sn")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, rci)
+			}
+		})
+	}
+}
+
+func TestUnrevoke(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"error/force del": {
+			db: &DB{&MockNoSQLDB{
+				MDel: func(bucket, key []byte) error {
+					return errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Del error: force"),
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MDel: func(bucket, key []byte) error {
+					assert.Equals(t, bucket, revokedCertsTable)
+					assert.Equals(t, key, []byte("sn"))
+					return nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.db.Unrevoke("sn"); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestRevokeSSHKeyID(t *testing.T) {
+	tests := map[string]struct {
+		rci *RevokedCertificateInfo
+		db  *DB
+		err error
+	}{
+		"error/force CmpAndSwap": {
+			rci: &RevokedCertificateInfo{KeyID: "key-id"},
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			}, true},
+			err: errors.New("error AuthDB CmpAndSwap: force"),
+		},
+		"error/was already revoked": {
+			rci: &RevokedCertificateInfo{KeyID: "key-id"},
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return []byte("foo"), false, nil
+				},
+			}, true},
+			err: ErrAlreadyExists,
+		},
+		"ok": {
+			rci: &RevokedCertificateInfo{KeyID: "key-id"},
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, revokedSSHKeyIDsTable, bucket)
+					assert.Equals(t, []byte("key-id"), key)
+					return []byte("foo"), true, nil
+				},
+			}, true},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.db.RevokeSSHKeyID(tc.rci); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, tc.err.Error(), err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestGetRevokedSSHCertificates(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []*RevokedCertificateInfo
+		err  error
+	}{
+		"ok/empty": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, nil }}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				switch string(bucket) {
+				case string(revokedSSHCertsTable):
+					return []*database.Entry{
+						{Bucket: bucket, Key: []byte("1234"), Value: []byte(`{"Serial":"1234","ReasonCode":1}`)},
+					}, nil
+				case string(revokedSSHKeyIDsTable):
+					return []*database.Entry{
+						{Bucket: bucket, Key: []byte("key-id"), Value: []byte(`{"Serial":"","KeyID":"key-id"}`)},
+					}, nil
+				default:
+					return nil, errors.New("unexpected bucket")
+				}
+			}}, true},
+			want: []*RevokedCertificateInfo{{Serial: "1234", ReasonCode: 1}, {KeyID: "key-id"}},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+		"error/unmarshal": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				return []*database.Entry{{Bucket: bucket, Key: []byte("1234"), Value: []byte(`{`)}}, nil
+			}}, true},
+			err: errors.New("error unmarshaling revoked certificate info 1234"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedSSHCertificates()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRevokedCertificates(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []*RevokedCertificateInfo
+		err  error
+	}{
+		"ok/empty": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, nil }}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				if string(bucket) != string(revokedCertsTable) {
+					return nil, errors.New("unexpected bucket")
+				}
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("1234"), Value: []byte(`{"Serial":"1234","ReasonCode":1}`)},
+					{Bucket: bucket, Key: []byte("5678"), Value: []byte(`{"Serial":"5678"}`)},
+				}, nil
+			}}, true},
+			want: []*RevokedCertificateInfo{{Serial: "1234", ReasonCode: 1}, {Serial: "5678"}},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+		"error/unmarshal": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				return []*database.Entry{{Bucket: bucket, Key: []byte("1234"), Value: []byte(`{`)}}, nil
+			}}, true},
+			err: errors.New("error unmarshaling revoked certificate info 1234"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevokedCertificates()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
 func TestUseToken(t *testing.T) {
 	type result struct {
 		err error
@@ -158,3 +436,1596 @@ func TestUseToken(t *testing.T) {
 		})
 	}
 }
+
+func TestGetProvisionerKeys(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		keys []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db:   &DB{&MockNoSQLDB{Ret1: []byte(`[{"key":{}}]`)}, true},
+			keys: []byte(`[{"key":{}}]`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys, err := tc.db.GetProvisionerKeys("jwk:kid")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.keys, keys)
+			}
+		})
+	}
+}
+
+func TestGetProvisioners(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok/empty": {
+			db:   &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, nil }}, true},
+			want: [][]byte{},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, provisionersTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("foo"), Value: []byte(`{"name":"foo"}`)},
+					{Bucket: bucket, Key: []byte("bar"), Value: []byte(`{"name":"bar"}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"name":"foo"}`), []byte(`{"name":"bar"}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetProvisioners()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapProvisioner(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, provisionersTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				assert.Equals(t, []byte(`{"version":1}`), old)
+				assert.Equals(t, []byte(`{"version":2}`), newval)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"ok/not-swapped": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte(`{"version":3}`), false, nil
+			}}, true},
+			want: false,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapProvisioner("foo", []byte(`{"version":1}`), []byte(`{"version":2}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetAdmins(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok/empty": {
+			db:   &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, nil }}, true},
+			want: [][]byte{},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, adminsTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("foo"), Value: []byte(`{"subject":"foo"}`)},
+					{Bucket: bucket, Key: []byte("bar"), Value: []byte(`{"subject":"bar"}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"subject":"foo"}`), []byte(`{"subject":"bar"}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetAdmins()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapAdmin(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, adminsTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				assert.Equals(t, []byte(`{"version":1}`), old)
+				assert.Equals(t, []byte(`{"version":2}`), newval)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"ok/not-swapped": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte(`{"version":3}`), false, nil
+			}}, true},
+			want: false,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapAdmin("foo", []byte(`{"version":1}`), []byte(`{"version":2}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, policiesTable, bucket)
+				assert.Equals(t, authorityPolicy, key)
+				return []byte(`{"version":1}`), nil
+			}}, true},
+			want: []byte(`{"version":1}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetPolicy()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapPolicy(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, policiesTable, bucket)
+				assert.Equals(t, authorityPolicy, key)
+				assert.Nil(t, old)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapPolicy(nil, []byte(`{"version":1}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, jobsTable, bucket)
+				assert.Equals(t, []byte("expiration"), key)
+				return []byte(`{"instance":"foo"}`), nil
+			}}, true},
+			want: []byte(`{"instance":"foo"}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetJob("expiration")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapJob(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, jobsTable, bucket)
+				assert.Equals(t, []byte("expiration"), key)
+				assert.Nil(t, old)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"ok/not-swapped": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte(`{"instance":"bar"}`), false, nil
+			}}, true},
+			want: false,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapJob("expiration", nil, []byte(`{"instance":"foo"}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSubCARequest(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, subCARequestsTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				return []byte(`{"id":"foo"}`), nil
+			}}, true},
+			want: []byte(`{"id":"foo"}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetSubCARequest("foo")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSubCARequests(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, subCARequestsTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("foo"), Value: []byte(`{"id":"foo"}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"id":"foo"}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetSubCARequests()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapSubCARequest(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, subCARequestsTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				assert.Nil(t, old)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapSubCARequest("foo", nil, []byte(`{"id":"foo"}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreCTSubmissions(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MSet: func(bucket, key, value []byte) error {
+				assert.Equals(t, ctSubmissionsTable, bucket)
+				assert.Equals(t, []byte("1234"), key)
+				assert.Equals(t, []byte(`[{"log":"https://ct.example.com","status":"ok"}]`), value)
+				return nil
+			}}, true},
+		},
+		"error/set": {
+			db: &DB{&MockNoSQLDB{MSet: func(bucket, key, value []byte) error {
+				return errors.New("force")
+			}}, true},
+			err: errors.New("database Set error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreCTSubmissions("1234", []byte(`[{"log":"https://ct.example.com","status":"ok"}]`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestStoreLintResults(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MSet: func(bucket, key, value []byte) error {
+				assert.Equals(t, lintResultsTable, bucket)
+				assert.Equals(t, []byte("1234"), key)
+				assert.Equals(t, []byte(`[{"lint":"w_ext_san_missing","severity":"warn"}]`), value)
+				return nil
+			}}, true},
+		},
+		"error/set": {
+			db: &DB{&MockNoSQLDB{MSet: func(bucket, key, value []byte) error {
+				return errors.New("force")
+			}}, true},
+			err: errors.New("database Set error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreLintResults("1234", []byte(`[{"lint":"w_ext_san_missing","severity":"warn"}]`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestGetRootRotation(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, rootRotationTable, bucket)
+				assert.Equals(t, rootRotationKey, key)
+				return []byte(`{"status":"scheduled"}`), nil
+			}}, true},
+			want: []byte(`{"status":"scheduled"}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRootRotation()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapRootRotation(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, rootRotationTable, bucket)
+				assert.Equals(t, rootRotationKey, key)
+				assert.Nil(t, old)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapRootRotation(nil, []byte(`{"status":"scheduled"}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetIntermediate(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, intermediateTable, bucket)
+				assert.Equals(t, intermediateKey, key)
+				return []byte(`{"certificate":"MIIB"}`), nil
+			}}, true},
+			want: []byte(`{"certificate":"MIIB"}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetIntermediate()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapIntermediate(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, intermediateTable, bucket)
+				assert.Equals(t, intermediateKey, key)
+				assert.Nil(t, old)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"ok/not-swapped": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte(`{"certificate":"MIIC"}`), false, nil
+			}}, true},
+			want: false,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapIntermediate(nil, []byte(`{"certificate":"MIIB"}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestReserveSerialNumber(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, serialNumbersTable, bucket)
+				assert.Equals(t, []byte("1234"), key)
+				assert.Nil(t, old)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"ok/already-reserved": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte("2020-01-01T00:00:00Z"), false, nil
+			}}, true},
+			want: false,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("error reserving serial number x509_serial_numbers/1234: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.ReserveSerialNumber("1234")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSerialCounter(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, serialCounterTable, bucket)
+				assert.Equals(t, serialCounterKey, key)
+				return []byte("42"), nil
+			}}, true},
+			want: []byte("42"),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetSerialCounter()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapSerialCounter(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, serialCounterTable, bucket)
+				assert.Equals(t, serialCounterKey, key)
+				assert.Equals(t, []byte("41"), old)
+				assert.Equals(t, []byte("42"), newval)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapSerialCounter([]byte("41"), []byte("42"))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetShortLivedSummary(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, shortLivedTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				return []byte(`{"count":1}`), nil
+			}}, true},
+			want: []byte(`{"count":1}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetShortLivedSummary("foo")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetShortLivedSummaries(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, shortLivedTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("foo"), Value: []byte(`{"count":1}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"count":1}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetShortLivedSummaries()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCmpAndSwapShortLivedSummary(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want bool
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				assert.Equals(t, shortLivedTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				assert.Equals(t, []byte(`{"count":1}`), old)
+				assert.Equals(t, []byte(`{"count":2}`), newval)
+				return newval, true, nil
+			}}, true},
+			want: true,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.CmpAndSwapShortLivedSummary("foo", []byte(`{"count":1}`), []byte(`{"count":2}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRevokeBatch(t *testing.T) {
+	rcis := []*RevokedCertificateInfo{{Serial: "1"}, {Serial: "2", ReasonCode: 1}}
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MUpdate: func(tx *database.Tx) error {
+				assert.Len(t, 3, tx.Operations)
+				for i, rci := range rcis {
+					op := tx.Operations[i]
+					assert.Equals(t, database.CmpAndSwap, op.Cmd)
+					assert.Equals(t, revokedCertsTable, op.Bucket)
+					assert.Equals(t, []byte(rci.Serial), op.Key)
+					assert.Nil(t, op.CmpValue)
+				}
+				op := tx.Operations[2]
+				assert.Equals(t, database.Set, op.Cmd)
+				assert.Equals(t, revocationBatchesTable, op.Bucket)
+				assert.Equals(t, []byte("foo"), op.Key)
+				assert.Equals(t, []byte(`{"id":"foo"}`), op.Value)
+				return nil
+			}}, true},
+		},
+		"error/update": {
+			db:  &DB{&MockNoSQLDB{MUpdate: func(tx *database.Tx) error { return errors.New("force") }}, true},
+			err: errors.New("database Update error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tc.db.RevokeBatch("foo", []byte(`{"id":"foo"}`), rcis); err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestGetRevocationBatch(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, revocationBatchesTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				return []byte(`{"id":"foo"}`), nil
+			}}, true},
+			want: []byte(`{"id":"foo"}`),
+		},
+		"ok/not found": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, database.ErrNotFound
+			}}, true},
+		},
+		"error/get": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, errors.New("force")
+			}}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevocationBatch("foo")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRevocationBatches(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, revocationBatchesTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("foo"), Value: []byte(`{"id":"foo"}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"id":"foo"}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetRevocationBatches()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCertificates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	assert.FatalError(t, err)
+
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, certsTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("1234"), Value: der},
+				}, nil
+			}}, true},
+			want: der,
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+		"error/parse": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("1234"), Value: []byte("foo")},
+				}, nil
+			}}, true},
+			err: errors.New("error parsing certificate 1234"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetCertificates()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				if assert.Len(t, 1, got) {
+					assert.Equals(t, tc.want, got[0].Raw)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreCertificateIndex(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MSet: func(bucket, key, value []byte) error {
+					assert.Equals(t, certsIndexTable, bucket)
+					assert.Equals(t, []byte("1234"), key)
+					assert.Equals(t, []byte(`{"serial":"1234"}`), value)
+					return nil
+				},
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, certsByNameTable, bucket)
+					switch string(key) {
+					case "foo.example.com":
+						return nil, database.ErrNotFound
+					case "bar.example.com":
+						return []byte(`["1000"]`), nil
+					case "baz.example.com":
+						return []byte(`["1234"]`), nil
+					default:
+						t.Errorf("unexpected key %s", key)
+						return nil, database.ErrNotFound
+					}
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, certsByNameTable, bucket)
+					switch string(key) {
+					case "foo.example.com":
+						assert.Nil(t, old)
+						assert.Equals(t, []byte(`["1234"]`), newval)
+					case "bar.example.com":
+						assert.Equals(t, []byte(`["1000"]`), old)
+						assert.Equals(t, []byte(`["1000","1234"]`), newval)
+					default:
+						t.Errorf("unexpected key %s", key)
+					}
+					return newval, true, nil
+				},
+			}, true},
+		},
+		"error/set": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Set error: force"),
+		},
+		"error/get": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, errors.New("force")
+			}}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"error/unmarshal": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				return []byte("foo"), nil
+			}}, true},
+			err: errors.New("error unmarshaling certificates of foo.example.com"),
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) { return nil, database.ErrNotFound },
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreCertificateIndex("1234", []byte(`{"serial":"1234"}`), []string{"Foo.Example.com", "bar.example.com", "baz.example.com"})
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestStoreCertificateIndex_retry(t *testing.T) {
+	var calls int
+	db := &DB{&MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if calls == 0 {
+				return nil, database.ErrNotFound
+			}
+			return []byte(`["1000"]`), nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			calls++
+			if calls == 1 {
+				return []byte(`["1000"]`), false, nil
+			}
+			assert.Equals(t, []byte(`["1000","1234"]`), newval)
+			return newval, true, nil
+		},
+	}, true}
+	assert.FatalError(t, db.StoreCertificateIndex("1234", []byte("{}"), []string{"foo"}))
+	assert.Equals(t, 2, calls)
+}
+
+func TestDeleteCertificate(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MDel: func(bucket, key []byte) error {
+					if !bytes.Equal(bucket, certsTable) && !bytes.Equal(bucket, certsIndexTable) {
+						t.Errorf("unexpected table %s", bucket)
+					}
+					assert.Equals(t, []byte("1234"), key)
+					return nil
+				},
+				MGet: func(bucket, key []byte) ([]byte, error) {
+					assert.Equals(t, certsByNameTable, bucket)
+					switch string(key) {
+					case "foo.example.com":
+						return nil, database.ErrNotFound
+					case "bar.example.com":
+						return []byte(`["1000","1234"]`), nil
+					case "baz.example.com":
+						return []byte(`["1234"]`), nil
+					case "qux.example.com":
+						return []byte(`["1000"]`), nil
+					default:
+						t.Errorf("unexpected key %s", key)
+						return nil, database.ErrNotFound
+					}
+				},
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, certsByNameTable, bucket)
+					switch string(key) {
+					case "bar.example.com":
+						assert.Equals(t, []byte(`["1000","1234"]`), old)
+						assert.Equals(t, []byte(`["1000"]`), newval)
+					case "baz.example.com":
+						assert.Equals(t, []byte(`["1234"]`), old)
+						assert.Equals(t, []byte(`[]`), newval)
+					default:
+						t.Errorf("unexpected key %s", key)
+					}
+					return newval, true, nil
+				},
+			}, true},
+		},
+		"error/del": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Del error: force"),
+		},
+		"error/get": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				return nil, errors.New("force")
+			}}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{
+				MGet: func(bucket, key []byte) ([]byte, error) { return []byte(`["1234"]`), nil },
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return nil, false, errors.New("force")
+				},
+			}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.DeleteCertificate("1234", []string{"Foo.Example.com", "bar.example.com", "baz.example.com", "qux.example.com"})
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestGetCertificateIndex(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, certsIndexTable, bucket)
+				assert.Equals(t, []byte("1234"), key)
+				return []byte(`{"serial":"1234"}`), nil
+			}}, true},
+			want: []byte(`{"serial":"1234"}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetCertificateIndex("1234")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCertificateIndexes(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, certsIndexTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("1234"), Value: []byte(`{"serial":"1234"}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"serial":"1234"}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetCertificateIndexes()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetCertificateSerialsByName(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []string
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, certsByNameTable, bucket)
+				assert.Equals(t, []byte("foo.example.com"), key)
+				return []byte(`["1000","1234"]`), nil
+			}}, true},
+			want: []string{"1000", "1234"},
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"error/unmarshal": {
+			db:  &DB{&MockNoSQLDB{Ret1: []byte("foo")}, true},
+			err: errors.New("error unmarshaling certificates of Foo.example.com"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetCertificateSerialsByName("Foo.example.com")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSchemaVersion(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want int
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, schemaVersionsTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				return []byte("2"), nil
+			}}, true},
+			want: 2,
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+		"error/parse": {
+			db:  &DB{&MockNoSQLDB{Ret1: []byte("foo")}, true},
+			err: errors.New("error parsing schema version of foo"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetSchemaVersion("foo")
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSetSchemaVersion(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MSet: func(bucket, key, value []byte) error {
+				assert.Equals(t, schemaVersionsTable, bucket)
+				assert.Equals(t, []byte("foo"), key)
+				assert.Equals(t, []byte("2"), value)
+				return nil
+			}}, true},
+		},
+		"error/set": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Set error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.SetSchemaVersion("foo", 2)
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestStoreAuditEvent(t *testing.T) {
+	tests := map[string]struct {
+		db  *DB
+		err error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					assert.Equals(t, auditLogTable, bucket)
+					assert.Equals(t, []byte("00000000000000000042"), key)
+					assert.Nil(t, old)
+					assert.Equals(t, []byte(`{"seq":42}`), newval)
+					return newval, true, nil
+				},
+				MSet: func(bucket, key, value []byte) error {
+					assert.Equals(t, auditHeadTable, bucket)
+					assert.Equals(t, auditHeadKey, key)
+					assert.Equals(t, []byte(`{"seq":42}`), value)
+					return nil
+				},
+			}, true},
+		},
+		"error/already-exists": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return []byte(`{"seq":42,"action":"x509.sign"}`), false, nil
+			}}, true},
+			err: ErrAlreadyExists,
+		},
+		"error/cmpAndSwap": {
+			db: &DB{&MockNoSQLDB{MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+				return nil, false, errors.New("force")
+			}}, true},
+			err: errors.New("database CmpAndSwap error: force"),
+		},
+		"error/set": {
+			db: &DB{&MockNoSQLDB{
+				MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+					return newval, true, nil
+				},
+				MSet: func(bucket, key, value []byte) error {
+					return errors.New("force")
+				},
+			}, true},
+			err: errors.New("database Set error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.db.StoreAuditEvent(42, []byte(`{"seq":42}`))
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+			}
+		})
+	}
+}
+
+func TestGetLastAuditEvent(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want []byte
+		err  error
+	}{
+		"ok/not-found": {
+			db: &DB{&MockNoSQLDB{Err: database.ErrNotFound}, true},
+		},
+		"ok": {
+			db: &DB{&MockNoSQLDB{MGet: func(bucket, key []byte) ([]byte, error) {
+				assert.Equals(t, auditHeadTable, bucket)
+				assert.Equals(t, auditHeadKey, key)
+				return []byte(`{"seq":42}`), nil
+			}}, true},
+			want: []byte(`{"seq":42}`),
+		},
+		"error/get": {
+			db:  &DB{&MockNoSQLDB{Err: errors.New("force")}, true},
+			err: errors.New("database Get error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetLastAuditEvent()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	tests := map[string]struct {
+		db   *DB
+		want [][]byte
+		err  error
+	}{
+		"ok": {
+			db: &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) {
+				assert.Equals(t, auditLogTable, bucket)
+				return []*database.Entry{
+					{Bucket: bucket, Key: []byte("00000000000000000010"), Value: []byte(`{"seq":10}`)},
+					{Bucket: bucket, Key: []byte("00000000000000000002"), Value: []byte(`{"seq":2}`)},
+					{Bucket: bucket, Key: []byte("00000000000000000001"), Value: []byte(`{"seq":1}`)},
+				}, nil
+			}}, true},
+			want: [][]byte{[]byte(`{"seq":1}`), []byte(`{"seq":2}`), []byte(`{"seq":10}`)},
+		},
+		"error/list": {
+			db:  &DB{&MockNoSQLDB{MList: func(bucket []byte) ([]*database.Entry, error) { return nil, errors.New("force") }}, true},
+			err: errors.New("database List error: force"),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.db.GetAuditEvents()
+			if err != nil {
+				if assert.NotNil(t, tc.err) {
+					assert.HasPrefix(t, err.Error(), tc.err.Error())
+				}
+			} else {
+				assert.Nil(t, tc.err)
+				assert.Equals(t, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,600 @@
+// Package dynamodb implements the nosql database interface over Amazon
+// DynamoDB, so the CA can run on AWS without managing a database server.
+//
+// All the tables of the CA are stored in a single DynamoDB table with the
+// partition key "pk", the name of the table, the sort key "sk", the key of the
+// entry, and the value in the attribute "v". Nonces and ACME orders and
+// authorizations have an expiration time in the attribute "ttl", so DynamoDB
+// removes them once they are not needed anymore.
+package dynamodb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// DefaultTable is the name of the DynamoDB table if a database is not set.
+const DefaultTable = "step-ca"
+
+// Attribute names.
+const (
+	partitionKey = "pk"
+	sortKey      = "sk"
+	valueKey     = "v"
+	ttlKey       = "ttl"
+)
+
+// tablesKey is the partition key of the markers of the tables.
+const tablesKey = "#tables"
+
+// maxRetries is the number of times a transaction is retried if an item it
+// read has been modified in the meantime.
+const maxRetries = 10
+
+// requestTimeout is the maximum time used by a request.
+var requestTimeout = 15 * time.Second
+
+// Client is the interface with the methods of the DynamoDB client used.
+type Client interface {
+	GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error)
+	PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error)
+	DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error)
+	QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error
+	TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error)
+	DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error)
+	CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error)
+	WaitUntilTableExistsWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.WaiterOption) error
+	UpdateTimeToLiveWithContext(ctx aws.Context, input *dynamodb.UpdateTimeToLiveInput, opts ...request.Option) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
+// DB is a DynamoDB implementation of the nosql database.DB interface.
+type DB struct {
+	client Client
+	table  string
+}
+
+// NewDB creates a new DB using the given client and DynamoDB table.
+func NewDB(client Client, table string) *DB {
+	return &DB{
+		client: client,
+		table:  table,
+	}
+}
+
+// Open initializes the client and creates the DynamoDB table if it does not
+// exist. The data source is a list of query parameters: region, the AWS
+// region, endpoint, an optional custom endpoint, and profile, the optional
+// profile in the shared configuration; e.g. "region=us-east-1". The
+// credentials are loaded from the environment, the shared configuration or the
+// IAM role. The database option is the name of the DynamoDB table, by default
+// "step-ca".
+func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
+	opts := &database.Options{}
+	for _, o := range opt {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	params, err := url.ParseQuery(dataSourceName)
+	if err != nil {
+		return errors.Wrap(err, "error parsing dynamodb dataSource")
+	}
+	cfg := aws.NewConfig()
+	if v := params.Get("region"); v != "" {
+		cfg = cfg.WithRegion(v)
+	}
+	if v := params.Get("endpoint"); v != "" {
+		cfg = cfg.WithEndpoint(v)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		Profile:           params.Get("profile"),
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error creating AWS session")
+	}
+
+	db.client = dynamodb.New(sess)
+	db.table = opts.Database
+	if db.table == "" {
+		db.table = DefaultTable
+	}
+	return db.createTable()
+}
+
+// createTable creates the DynamoDB table with on-demand capacity and enables
+// the expiration of the items, if the table does not exist.
+func (db *DB) createTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	_, err := db.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(db.table),
+	})
+	switch {
+	case err == nil:
+		return nil
+	case !isErrorCode(err, dynamodb.ErrCodeResourceNotFoundException):
+		return errors.Wrapf(err, "error describing table %s", db.table)
+	}
+
+	if _, err := db.client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(db.table),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String(partitionKey), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String(sortKey), AttributeType: aws.String(dynamodb.ScalarAttributeTypeB)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(partitionKey), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String(sortKey), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+	}); err != nil {
+		return errors.Wrapf(err, "error creating table %s", db.table)
+	}
+	if err := db.client.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(db.table),
+	}); err != nil {
+		return errors.Wrapf(err, "error creating table %s", db.table)
+	}
+	if _, err := db.client.UpdateTimeToLiveWithContext(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(db.table),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlKey),
+			Enabled:       aws.Bool(true),
+		},
+	}); err != nil {
+		return errors.Wrapf(err, "error enabling time to live on table %s", db.table)
+	}
+	return nil
+}
+
+// Close does nothing, the client does not keep any connection open.
+func (db *DB) Close() error {
+	return nil
+}
+
+// Get returns the value with the given key.
+func (db *DB) Get(bucket, key []byte) ([]byte, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	value, ok, err := db.get(ctx, bucket, key)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	case !ok:
+		return nil, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+	default:
+		return value, nil
+	}
+}
+
+// Set sets the value with the given key.
+func (db *DB) Set(bucket, key, value []byte) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := db.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.table),
+		Item:      item(bucket, key, value),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// Del deletes the value with the given key.
+func (db *DB) Del(bucket, key []byte) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := db.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.table),
+		Key:       itemKey(string(bucket), key),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to delete %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// List returns all the entries in the given table.
+func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	_, ok, err := db.get(ctx, []byte(tablesKey), bucket)
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "error listing table %s", bucket)
+	case !ok:
+		return nil, errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+	}
+
+	var entries []*database.Entry
+	if err := db.query(ctx, bucket, func(item map[string]*dynamodb.AttributeValue) {
+		entries = append(entries, &database.Entry{
+			Bucket: bucket,
+			Key:    item[sortKey].B,
+			Value:  item[valueKey].B,
+		})
+	}); err != nil {
+		return nil, errors.Wrapf(err, "error listing table %s", bucket)
+	}
+	return entries, nil
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	cond, values := condition(oldValue, true)
+	_, err := db.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(db.table),
+		Item:                      item(bucket, key, newValue),
+		ConditionExpression:       cond,
+		ExpressionAttributeValues: values,
+	})
+	switch {
+	case err == nil:
+		return newValue, true, nil
+	case isErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException):
+		current, _, err := db.get(ctx, bucket, key)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+		}
+		return current, false, nil
+	default:
+		return nil, false, errors.Wrapf(err, "failed to compare and swap %s/%s", bucket, key)
+	}
+}
+
+// Update performs multiple commands on one transaction. The reads are done
+// first, and the writes are only committed if none of the items read has been
+// modified in the meantime, otherwise the transaction is retried. DynamoDB
+// limits the number of items in a transaction.
+func (db *DB) Update(tx *database.Tx) error {
+	for i := 0; i < maxRetries; i++ {
+		ok, err := db.update(tx)
+		if err != nil {
+			return errors.Wrap(err, "UPDATE failed")
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("UPDATE failed: too many conflicts")
+}
+
+// read is an item read in a transaction.
+type read struct {
+	value  []byte
+	exists bool
+}
+
+// write is a put or delete of an item in a transaction.
+type write struct {
+	bucket, key, value []byte
+	delete             bool
+}
+
+func (db *DB) update(tx *database.Tx) (bool, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	reads := make(map[string]*read)
+	writes := make(map[string]*write)
+	var order []string
+	var deletedTables [][]byte
+
+	id := func(bucket, key []byte) string {
+		return strconv.Itoa(len(bucket)) + ":" + string(bucket) + string(key)
+	}
+	// get returns the current value of the key, including the writes of the
+	// transaction.
+	get := func(bucket, key []byte) ([]byte, bool, error) {
+		k := id(bucket, key)
+		if w, ok := writes[k]; ok {
+			return w.value, !w.delete, nil
+		}
+		if r, ok := reads[k]; ok {
+			return r.value, r.exists, nil
+		}
+		value, exists, err := db.get(ctx, bucket, key)
+		if err != nil {
+			return nil, false, err
+		}
+		reads[k] = &read{value: value, exists: exists}
+		return value, exists, nil
+	}
+	set := func(w *write) {
+		k := id(w.bucket, w.key)
+		if _, ok := writes[k]; !ok {
+			order = append(order, k)
+		}
+		writes[k] = w
+	}
+
+	for _, q := range tx.Operations {
+		switch q.Cmd {
+		case database.CreateTable:
+			set(&write{bucket: []byte(tablesKey), key: q.Bucket, value: []byte{}})
+		case database.DeleteTable:
+			set(&write{bucket: []byte(tablesKey), key: q.Bucket, delete: true})
+			deletedTables = append(deletedTables, q.Bucket)
+		case database.Get:
+			value, exists, err := get(q.Bucket, q.Key)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key)
+			}
+			if !exists {
+				return false, errors.Wrapf(database.ErrNotFound, "%s/%s not found", q.Bucket, q.Key)
+			}
+			q.Result = value
+		case database.Set:
+			set(&write{bucket: q.Bucket, key: q.Key, value: q.Value})
+		case database.Delete:
+			set(&write{bucket: q.Bucket, key: q.Key, delete: true})
+		case database.CmpAndSwap:
+			current, exists, err := get(q.Bucket, q.Key)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key)
+			}
+			// A nil CmpValue only swaps if the key does not exist.
+			if (q.CmpValue == nil && !exists) || (q.CmpValue != nil && exists && bytes.Equal(current, q.CmpValue)) {
+				set(&write{bucket: q.Bucket, key: q.Key, value: q.Value})
+				q.Result, q.Swapped = q.Value, true
+			} else {
+				q.Result, q.Swapped = current, false
+			}
+		default:
+			return false, database.ErrOpNotSupported
+		}
+	}
+
+	// Each item can only appear once in a transaction, so the condition of an
+	// item read and written is part of the write.
+	var items []*dynamodb.TransactWriteItem
+	for _, k := range order {
+		w := writes[k]
+		var cond *string
+		var values map[string]*dynamodb.AttributeValue
+		if r, ok := reads[k]; ok {
+			cond, values = condition(r.value, r.exists)
+		}
+		if w.delete {
+			items = append(items, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+				TableName:                 aws.String(db.table),
+				Key:                       itemKey(string(w.bucket), w.key),
+				ConditionExpression:       cond,
+				ExpressionAttributeValues: values,
+			}})
+		} else {
+			items = append(items, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+				TableName:                 aws.String(db.table),
+				Item:                      item(w.bucket, w.key, w.value),
+				ConditionExpression:       cond,
+				ExpressionAttributeValues: values,
+			}})
+		}
+	}
+	for k, r := range reads {
+		if _, ok := writes[k]; ok {
+			continue
+		}
+		cond, values := condition(r.value, r.exists)
+		bucket, key := splitID(k)
+		items = append(items, &dynamodb.TransactWriteItem{ConditionCheck: &dynamodb.ConditionCheck{
+			TableName:                 aws.String(db.table),
+			Key:                       itemKey(bucket, key),
+			ConditionExpression:       cond,
+			ExpressionAttributeValues: values,
+		}})
+	}
+
+	if len(items) > 0 {
+		_, err := db.client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		switch {
+		case isErrorCode(err, dynamodb.ErrCodeTransactionCanceledException):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+	}
+
+	// The entries of the deleted tables are removed after the transaction.
+	for _, bucket := range deletedTables {
+		if err := db.deleteEntries(ctx, bucket); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// CreateTable creates the marker of the table.
+func (db *DB) CreateTable(bucket []byte) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := db.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.table),
+		Item:      item([]byte(tablesKey), bucket, []byte{}),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to create table %s", bucket)
+	}
+	return nil
+}
+
+// DeleteTable deletes the marker and all the entries of the table.
+func (db *DB) DeleteTable(bucket []byte) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+	_, err := db.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(db.table),
+		Key:                 itemKey(tablesKey, bucket),
+		ConditionExpression: aws.String("attribute_exists(" + partitionKey + ")"),
+	})
+	switch {
+	case isErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException):
+		return errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+	case err != nil:
+		return errors.Wrapf(err, "failed to delete table %s", bucket)
+	}
+	if err := db.deleteEntries(ctx, bucket); err != nil {
+		return errors.Wrapf(err, "failed to delete table %s", bucket)
+	}
+	return nil
+}
+
+// deleteEntries deletes all the items of a table.
+func (db *DB) deleteEntries(ctx context.Context, bucket []byte) error {
+	var keys [][]byte
+	if err := db.query(ctx, bucket, func(item map[string]*dynamodb.AttributeValue) {
+		keys = append(keys, item[sortKey].B)
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := db.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(db.table),
+			Key:       itemKey(string(bucket), key),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the value of an item using a strongly consistent read.
+func (db *DB) get(ctx context.Context, bucket, key []byte) ([]byte, bool, error) {
+	resp, err := db.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.table),
+		Key:            itemKey(string(bucket), key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.Item == nil {
+		return nil, false, nil
+	}
+	value := resp.Item[valueKey].B
+	if value == nil {
+		value = []byte{}
+	}
+	return value, true, nil
+}
+
+// query calls fn with all the items of a table using strongly consistent
+// reads.
+func (db *DB) query(ctx context.Context, bucket []byte, fn func(map[string]*dynamodb.AttributeValue)) error {
+	return db.client.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(db.table),
+		KeyConditionExpression: aws.String(partitionKey + " = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(string(bucket))},
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(out *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range out.Items {
+			fn(item)
+		}
+		return true
+	})
+}
+
+// condition returns the condition expression that checks that an item has the
+// given value, or that it does not exist.
+func condition(value []byte, exists bool) (*string, map[string]*dynamodb.AttributeValue) {
+	if value == nil || !exists {
+		return aws.String("attribute_not_exists(" + partitionKey + ")"), nil
+	}
+	return aws.String(valueKey + " = :v"), map[string]*dynamodb.AttributeValue{
+		":v": {B: value},
+	}
+}
+
+func itemKey(bucket string, key []byte) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		partitionKey: {S: aws.String(bucket)},
+		sortKey:      {B: key},
+	}
+}
+
+// item returns the item with the given value and its expiration time if the
+// table has one.
+func item(bucket, key, value []byte) map[string]*dynamodb.AttributeValue {
+	m := itemKey(string(bucket), key)
+	m[valueKey] = &dynamodb.AttributeValue{B: value}
+	if fn, ok := expirations[string(bucket)]; ok {
+		if t, ok := fn(value); ok {
+			m[ttlKey] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+		}
+	}
+	return m
+}
+
+// splitID returns the bucket and key of an item id in a transaction.
+func splitID(id string) (string, []byte) {
+	i := 0
+	for id[i] != ':' {
+		i++
+	}
+	n, _ := strconv.Atoi(id[:i])
+	return id[i+1 : i+1+n], []byte(id[i+1+n:])
+}
+
+// NonceExpiration is the time a nonce is kept after it is created.
+var NonceExpiration = 24 * time.Hour
+
+// ExpiredRetention is the time an expired ACME order or authorization is kept.
+var ExpiredRetention = 7 * 24 * time.Hour
+
+// expirations are the functions that return the time an item can be removed
+// for the tables with items that expire.
+var expirations = map[string]func(value []byte) (time.Time, bool){
+	"nonces": func(value []byte) (time.Time, bool) {
+		var v struct {
+			Created time.Time
+		}
+		if err := json.Unmarshal(value, &v); err != nil || v.Created.IsZero() {
+			return time.Time{}, false
+		}
+		return v.Created.Add(NonceExpiration), true
+	},
+	"acme_orders": expires,
+	"acme_authzs": expires,
+}
+
+func expires(value []byte) (time.Time, bool) {
+	var v struct {
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.Unmarshal(value, &v); err != nil || v.Expires.IsZero() {
+		return time.Time{}, false
+	}
+	return v.Expires.Add(ExpiredRetention), true
+}
+
+func isErrorCode(err error, code string) bool {
+	e, ok := errors.Cause(err).(awserr.Error)
+	return ok && e.Code() == code
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
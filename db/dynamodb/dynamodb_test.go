@@ -0,0 +1,300 @@
+package dynamodb
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+func TestDB_createTable(t *testing.T) {
+	notFound := newMockClient()
+	notFound.exists = false
+	var ttlEnabled bool
+	notFound.updateTimeToLive = func(in *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+		ttlEnabled = *in.TimeToLiveSpecification.AttributeName == ttlKey && *in.TimeToLiveSpecification.Enabled
+		return &dynamodb.UpdateTimeToLiveOutput{}, nil
+	}
+
+	failDescribe := newMockClient()
+	failDescribe.describeTable = func(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+		return nil, errors.New("an error")
+	}
+	failCreate := newMockClient()
+	failCreate.exists = false
+	failCreate.createTable = func(*dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+		return nil, errors.New("an error")
+	}
+	failTTL := newMockClient()
+	failTTL.exists = false
+	failTTL.updateTimeToLive = func(*dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+		return nil, errors.New("an error")
+	}
+
+	tests := []struct {
+		name    string
+		client  *MockClient
+		wantErr bool
+	}{
+		{"ok exists", newMockClient(), false},
+		{"ok create", notFound, false},
+		{"fail describe", failDescribe, true},
+		{"fail create", failCreate, true},
+		{"fail ttl", failTTL, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB(tt.client, DefaultTable)
+			if err := db.createTable(); (err != nil) != tt.wantErr {
+				t.Errorf("DB.createTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if !ttlEnabled {
+		t.Error("DB.createTable() did not enable the time to live")
+	}
+}
+
+func TestDB(t *testing.T) {
+	db := NewDB(newMockClient(), DefaultTable)
+	bucket := []byte("test_table")
+
+	if _, err := db.List(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.List() error = %v, want not found", err)
+	}
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatalf("DB.CreateTable() error = %v", err)
+	}
+	if _, err := db.Get(bucket, []byte("missing")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+	if err := db.Set(bucket, []byte("key"), []byte("value")); err != nil {
+		t.Fatalf("DB.Set() error = %v", err)
+	}
+	if got, err := db.Get(bucket, []byte("key")); err != nil || string(got) != "value" {
+		t.Errorf("DB.Get() = %s, %v, want value, nil", got, err)
+	}
+
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("other"), []byte("new")); err != nil || swapped || string(got) != "value" {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want value, false, nil", got, swapped, err)
+	}
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("value"), []byte("new")); err != nil || !swapped || string(got) != "new" {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want new, true, nil", got, swapped, err)
+	}
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("key"), nil, []byte("value")); err != nil || swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want false, nil", swapped, err)
+	}
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("new-key"), nil, []byte("value")); err != nil || !swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want true, nil", swapped, err)
+	}
+
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("tx-key"), []byte("tx-value"))
+	tx.Del(bucket, []byte("new-key"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: bucket, Key: []byte("key"), CmpValue: []byte("new"), Value: []byte("newer"), Cmd: database.CmpAndSwap,
+	})
+	tx.Get(bucket, []byte("key"))
+	if err := db.Update(tx); err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+	if !tx.Operations[2].Swapped {
+		t.Error("DB.Update() compare and swap was not swapped")
+	}
+	if got := string(tx.Operations[3].Result); got != "newer" {
+		t.Errorf("DB.Update() get = %s, want newer", got)
+	}
+
+	entries, err := db.List(bucket)
+	if err != nil {
+		t.Fatalf("DB.List() error = %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		got[string(e.Key)] = string(e.Value)
+	}
+	if want := map[string]string{"key": "newer", "tx-key": "tx-value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DB.List() = %v, want %v", got, want)
+	}
+
+	if err := db.Del(bucket, []byte("key")); err != nil {
+		t.Errorf("DB.Del() error = %v", err)
+	}
+	if err := db.DeleteTable(bucket); err != nil {
+		t.Errorf("DB.DeleteTable() error = %v", err)
+	}
+	if _, err := db.List(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.List() error = %v, want not found", err)
+	}
+	if _, err := db.Get(bucket, []byte("tx-key")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+	if err := db.DeleteTable(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.DeleteTable() error = %v, want not found", err)
+	}
+}
+
+func TestDB_Update(t *testing.T) {
+	bucket := []byte("test_table")
+
+	t.Run("fail not found", func(t *testing.T) {
+		db := NewDB(newMockClient(), DefaultTable)
+		tx := new(database.Tx)
+		tx.Get(bucket, []byte("missing"))
+		tx.Del(bucket, []byte("missing"))
+		if err := db.Update(tx); !database.IsErrNotFound(err) {
+			t.Errorf("DB.Update() error = %v, want not found", err)
+		}
+	})
+
+	t.Run("fail get", func(t *testing.T) {
+		client := newMockClient()
+		client.getItem = func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return nil, errors.New("an error")
+		}
+		tx := new(database.Tx)
+		tx.Get(bucket, []byte("key"))
+		if err := NewDB(client, DefaultTable).Update(tx); err == nil {
+			t.Error("DB.Update() error = nil, wantErr true")
+		}
+	})
+
+	t.Run("fail conflicts", func(t *testing.T) {
+		client := newMockClient()
+		var calls int
+		client.transactWriteItems = func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			calls++
+			return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException, "Transaction cancelled", nil)
+		}
+		tx := new(database.Tx)
+		tx.Set(bucket, []byte("key"), []byte("value"))
+		if err := NewDB(client, DefaultTable).Update(tx); err == nil {
+			t.Error("DB.Update() error = nil, wantErr true")
+		}
+		if calls != maxRetries {
+			t.Errorf("DB.Update() transactions = %d, want %d", calls, maxRetries)
+		}
+	})
+
+	t.Run("ok retry", func(t *testing.T) {
+		client := newMockClient()
+		db := NewDB(client, DefaultTable)
+		if err := db.Set(bucket, []byte("key"), []byte("0")); err != nil {
+			t.Fatal(err)
+		}
+		// The first transaction finds the item modified after the read.
+		var modified bool
+		client.transactWriteItems = func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			if !modified {
+				modified = true
+				client.put(item(bucket, []byte("key"), []byte("1")))
+			}
+			client.transactWriteItems = nil
+			return client.TransactWriteItemsWithContext(aws.BackgroundContext(), in)
+		}
+		tx := new(database.Tx)
+		tx.Operations = append(tx.Operations, &database.TxEntry{
+			Bucket: bucket, Key: []byte("key"), CmpValue: []byte("0"), Value: []byte("2"), Cmd: database.CmpAndSwap,
+		})
+		if err := db.Update(tx); err != nil {
+			t.Fatalf("DB.Update() error = %v", err)
+		}
+		if tx.Operations[0].Swapped || string(tx.Operations[0].Result) != "1" {
+			t.Errorf("DB.Update() = %s, %v, want 1, false", tx.Operations[0].Result, tx.Operations[0].Swapped)
+		}
+	})
+}
+
+// TestDB_nonces checks that a nonce is only used once by concurrent requests.
+func TestDB_nonces(t *testing.T) {
+	db := NewDB(newMockClient(), DefaultTable)
+	bucket := []byte("nonces")
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(bucket, []byte("nonce"), []byte(`{"ID":"nonce"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var used int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := new(database.Tx)
+			tx.Get(bucket, []byte("nonce"))
+			tx.Del(bucket, []byte("nonce"))
+			if err := db.Update(tx); err == nil {
+				mu.Lock()
+				used++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if used != 1 {
+		t.Errorf("nonce used %d times, want 1", used)
+	}
+}
+
+func Test_item(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ttl := func(t time.Time) *string {
+		return aws.String(strconv.FormatInt(t.Unix(), 10))
+	}
+	tests := []struct {
+		name   string
+		bucket string
+		value  string
+		want   *string
+	}{
+		{"nonce", "nonces", fmt.Sprintf(`{"ID":"nonce","Created":%q}`, now.Format(time.RFC3339)), ttl(now.Add(NonceExpiration))},
+		{"order", "acme_orders", fmt.Sprintf(`{"id":"order","expires":%q}`, now.Format(time.RFC3339)), ttl(now.Add(ExpiredRetention))},
+		{"authz", "acme_authzs", fmt.Sprintf(`{"id":"authz","expires":%q}`, now.Format(time.RFC3339)), ttl(now.Add(ExpiredRetention))},
+		{"no expires", "acme_orders", `{"id":"order"}`, nil},
+		{"bad json", "nonces", `{`, nil},
+		{"certificate", "x509_certs", `{"expires":"2020-01-01T00:00:00Z"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := item([]byte(tt.bucket), []byte("key"), []byte(tt.value))
+			if tt.want == nil {
+				if v, ok := got[ttlKey]; ok {
+					t.Errorf("item() ttl = %v, want none", v)
+				}
+				return
+			}
+			if v, ok := got[ttlKey]; !ok || *v.N != *tt.want {
+				t.Errorf("item() ttl = %v, want %s", v, *tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitID(t *testing.T) {
+	tests := []struct {
+		bucket, key string
+	}{
+		{"nonces", "key"},
+		{"a:b", "c:d"},
+		{"", ""},
+		{"1:", ":1"},
+	}
+	for _, tt := range tests {
+		id := strconv.Itoa(len(tt.bucket)) + ":" + tt.bucket + tt.key
+		bucket, key := splitID(id)
+		if bucket != tt.bucket || string(key) != tt.key {
+			t.Errorf("splitID(%q) = %q, %q, want %q, %q", id, bucket, key, tt.bucket, tt.key)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package dynamodb
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// MockClient is an in-memory DynamoDB table that supports the condition
+// expressions used by the driver. The function fields, if set, replace the
+// in-memory implementation.
+type MockClient struct {
+	mu     sync.Mutex
+	items  map[string]map[string]map[string]*dynamodb.AttributeValue
+	exists bool
+
+	getItem            func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	transactWriteItems func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+	describeTable      func(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error)
+	createTable        func(*dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error)
+	updateTimeToLive   func(*dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
+func newMockClient() *MockClient {
+	return &MockClient{
+		items:  make(map[string]map[string]map[string]*dynamodb.AttributeValue),
+		exists: true,
+	}
+}
+
+func (m *MockClient) lookup(key map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	return m.items[*key[partitionKey].S][string(key[sortKey].B)]
+}
+
+func (m *MockClient) check(key map[string]*dynamodb.AttributeValue, cond *string, values map[string]*dynamodb.AttributeValue) bool {
+	if cond == nil {
+		return true
+	}
+	item := m.lookup(key)
+	switch *cond {
+	case "attribute_not_exists(pk)":
+		return item == nil
+	case "attribute_exists(pk)":
+		return item != nil
+	case "v = :v":
+		return item != nil && bytes.Equal(item[valueKey].B, values[":v"].B)
+	default:
+		panic("unsupported condition " + *cond)
+	}
+}
+
+func (m *MockClient) put(item map[string]*dynamodb.AttributeValue) {
+	pk := *item[partitionKey].S
+	if m.items[pk] == nil {
+		m.items[pk] = make(map[string]map[string]*dynamodb.AttributeValue)
+	}
+	m.items[pk][string(item[sortKey].B)] = item
+}
+
+func (m *MockClient) delete(key map[string]*dynamodb.AttributeValue) {
+	delete(m.items[*key[partitionKey].S], string(key[sortKey].B))
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func (m *MockClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if m.getItem != nil {
+		return m.getItem(input)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: m.lookup(input.Key)}, nil
+}
+
+func (m *MockClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.check(input.Item, input.ConditionExpression, input.ExpressionAttributeValues) {
+		return nil, conditionFailed()
+	}
+	m.put(input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *MockClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.check(input.Key, input.ConditionExpression, input.ExpressionAttributeValues) {
+		return nil, conditionFailed()
+	}
+	m.delete(input.Key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (m *MockClient) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+	m.mu.Lock()
+	pk := *input.ExpressionAttributeValues[":pk"].S
+	var keys []string
+	for k := range m.items[pk] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := &dynamodb.QueryOutput{}
+	for _, k := range keys {
+		out.Items = append(out.Items, m.items[pk][k])
+	}
+	m.mu.Unlock()
+	fn(out, true)
+	return nil
+}
+
+func (m *MockClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if m.transactWriteItems != nil {
+		return m.transactWriteItems(input)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range input.TransactItems {
+		var ok bool
+		switch {
+		case item.Put != nil:
+			ok = m.check(item.Put.Item, item.Put.ConditionExpression, item.Put.ExpressionAttributeValues)
+		case item.Delete != nil:
+			ok = m.check(item.Delete.Key, item.Delete.ConditionExpression, item.Delete.ExpressionAttributeValues)
+		case item.ConditionCheck != nil:
+			ok = m.check(item.ConditionCheck.Key, item.ConditionCheck.ConditionExpression, item.ConditionCheck.ExpressionAttributeValues)
+		}
+		if !ok {
+			return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException, "Transaction cancelled, please refer cancellation reasons for specific reasons [ConditionalCheckFailed]", nil)
+		}
+	}
+	for _, item := range input.TransactItems {
+		switch {
+		case item.Put != nil:
+			m.put(item.Put.Item)
+		case item.Delete != nil:
+			m.delete(item.Delete.Key)
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (m *MockClient) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if m.describeTable != nil {
+		return m.describeTable(input)
+	}
+	if !m.exists {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+	}
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+func (m *MockClient) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	if m.createTable != nil {
+		return m.createTable(input)
+	}
+	m.exists = true
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (m *MockClient) WaitUntilTableExistsWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.WaiterOption) error {
+	return nil
+}
+
+func (m *MockClient) UpdateTimeToLiveWithContext(ctx aws.Context, input *dynamodb.UpdateTimeToLiveInput, opts ...request.Option) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	if m.updateTimeToLive != nil {
+		return m.updateTimeToLive(input)
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
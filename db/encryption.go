@@ -0,0 +1,375 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// encryptedTables are the tables with sensitive values, the used tokens, the
+// provisioners and their keys, and the ACME accounts and external account
+// keys.
+var encryptedTables = map[string]bool{
+	string(usedOTTTable):         true,
+	string(provisionerKeysTable): true,
+	string(provisionersTable):    true,
+	"acme_accounts":              true,
+	"acme_external_account_keys": true,
+}
+
+// encryptedPrefix is the prefix of the encrypted values. Values without it
+// were written before the encryption was enabled and are read as they are.
+var encryptedPrefix = []byte("\x00enc1")
+
+// EncryptionConfig configures the encryption at rest of the sensitive values
+// in the database. The values are encrypted with AES-256-GCM using a data key
+// that is stored encrypted with a key in a KMS, e.g. Google's Cloud KMS or
+// HashiCorp Vault Transit. The first key is used to encrypt, the rest are
+// only used to decrypt the values encrypted before a rotation.
+type EncryptionConfig struct {
+	KMS  *apiv1.Options   `json:"kms"`
+	Keys []*EncryptionKey `json:"keys"`
+}
+
+// EncryptionKey is a data key. Path is the path of the file with the data key
+// encrypted with the key Key of the KMS, the decrypted data key is 32 bytes,
+// raw or base64 encoded. ID identifies the data key in the encrypted values.
+type EncryptionKey struct {
+	ID   string `json:"id"`
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
+
+// Validate checks the fields in EncryptionConfig, nil is ok.
+func (c *EncryptionConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.KMS == nil {
+		return errors.New("db.encryption.kms is required")
+	}
+	if len(c.Keys) == 0 {
+		return errors.New("db.encryption.keys cannot be empty")
+	}
+	ids := make(map[string]bool)
+	for _, k := range c.Keys {
+		switch {
+		case k.ID == "" || len(k.ID) > 255:
+			return errors.New("db.encryption.keys id must have between 1 and 255 characters")
+		case ids[k.ID]:
+			return errors.Errorf("db.encryption.keys id %s is duplicated", k.ID)
+		case k.Key == "":
+			return errors.New("db.encryption.keys key cannot be empty")
+		case k.Path == "":
+			return errors.New("db.encryption.keys path cannot be empty")
+		}
+		ids[k.ID] = true
+	}
+	return c.KMS.Validate()
+}
+
+// newKMS is the function used to initialize the KMS, it can be replaced in
+// tests.
+var newKMS = kms.New
+
+// loadKeys decrypts the data keys with the KMS.
+func (c *EncryptionConfig) loadKeys() (map[string]cipher.AEAD, error) {
+	km, err := newKMS(context.Background(), *c.KMS)
+	if err != nil {
+		return nil, err
+	}
+	defer km.Close()
+
+	decrypter, ok := km.(kms.Decrypter)
+	if !ok {
+		return nil, errors.Errorf("kms %s does not support decryption", c.KMS.Type)
+	}
+	keys := make(map[string]cipher.AEAD, len(c.Keys))
+	for _, k := range c.Keys {
+		ciphertext, err := ioutil.ReadFile(k.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", k.Path)
+		}
+		key, err := decrypter.Decrypt(&apiv1.DecryptRequest{
+			Name:       k.Key,
+			Ciphertext: ciphertext,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decrypting %s", k.Path)
+		}
+		if len(key) != 32 {
+			b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(key)))
+			if err != nil || len(b) != 32 {
+				return nil, errors.Errorf("data key in %s is not a 32 bytes key", k.Path)
+			}
+			key = b
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if keys[k.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return keys, nil
+}
+
+// encryptedDB is a nosql.DB that encrypts the values of the encrypted tables.
+type encryptedDB struct {
+	nosql.DB
+	keyID string
+	keys  map[string]cipher.AEAD
+}
+
+// newEncryptedDB returns a nosql.DB that encrypts the sensitive values in db.
+func newEncryptedDB(db nosql.DB, c *EncryptionConfig) (*encryptedDB, error) {
+	keys, err := c.loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedDB{DB: db, keyID: c.Keys[0].ID, keys: keys}, nil
+}
+
+// unwrap returns the database without the observer and encryption layers,
+// reading and writing the values as they are stored. Backups are written and
+// restored using the stored values, so they do not contain the plaintext
+// values.
+func unwrap(db nosql.DB) nosql.DB {
+	if d, ok := db.(*DB); ok {
+		db = d.DB
+	}
+	if o, ok := db.(*observedDB); ok {
+		db = o.DB
+	}
+	if e, ok := db.(*encryptedDB); ok {
+		db = e.DB
+	}
+	return db
+}
+
+// encrypt returns the value encrypted with the current key. The bucket and key
+// are authenticated, so a value cannot be moved to another key.
+func (db *encryptedDB) encrypt(bucket, key, value []byte) ([]byte, error) {
+	if !encryptedTables[string(bucket)] {
+		return value, nil
+	}
+	aead := db.keys[db.keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "error generating nonce")
+	}
+	b := make([]byte, 0, len(encryptedPrefix)+1+len(db.keyID)+len(nonce)+len(value)+aead.Overhead())
+	b = append(b, encryptedPrefix...)
+	b = append(b, byte(len(db.keyID)))
+	b = append(b, db.keyID...)
+	b = append(b, nonce...)
+	return aead.Seal(b, nonce, value, additionalData(bucket, key)), nil
+}
+
+// decrypt returns the plaintext of an encrypted value, values that are not
+// encrypted are returned as they are.
+func (db *encryptedDB) decrypt(bucket, key, value []byte) ([]byte, error) {
+	if !encryptedTables[string(bucket)] {
+		return value, nil
+	}
+	id, nonce, ciphertext, ok := parseEncrypted(value)
+	if !ok {
+		return value, nil
+	}
+	aead, ok := db.keys[id]
+	if !ok {
+		return nil, errors.Errorf("error decrypting %s/%s: key %s not found", bucket, key, id)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.Errorf("error decrypting %s/%s: invalid value", bucket, key)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(bucket, key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decrypting %s/%s", bucket, key)
+	}
+	return plaintext, nil
+}
+
+// parseEncrypted splits an encrypted value in the key id, the nonce and the
+// ciphertext.
+func parseEncrypted(value []byte) (string, []byte, []byte, bool) {
+	if !bytes.HasPrefix(value, encryptedPrefix) || len(value) < len(encryptedPrefix)+1 {
+		return "", nil, nil, false
+	}
+	b := value[len(encryptedPrefix):]
+	n := int(b[0])
+	if len(b) < 1+n+12 {
+		return "", nil, nil, false
+	}
+	return string(b[1 : 1+n]), b[1+n : 1+n+12], b[1+n+12:], true
+}
+
+func additionalData(bucket, key []byte) []byte {
+	return append(append(append([]byte{}, bucket...), '/'), key...)
+}
+
+// Get returns the decrypted value.
+func (db *encryptedDB) Get(bucket, key []byte) ([]byte, error) {
+	v, err := db.DB.Get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return db.decrypt(bucket, key, v)
+}
+
+// Set encrypts and stores the value.
+func (db *encryptedDB) Set(bucket, key, value []byte) error {
+	v, err := db.encrypt(bucket, key, value)
+	if err != nil {
+		return err
+	}
+	return db.DB.Set(bucket, key, v)
+}
+
+// List returns the decrypted entries of a table.
+func (db *encryptedDB) List(bucket []byte) ([]*database.Entry, error) {
+	entries, err := db.DB.List(bucket)
+	if err != nil || !encryptedTables[string(bucket)] {
+		return entries, err
+	}
+	for _, e := range entries {
+		if e.Value, err = db.decrypt(bucket, e.Key, e.Value); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// CmpAndSwap compares the plaintext of the stored value with oldValue, and
+// swaps the stored value if they match. As encrypted values are not
+// deterministic, the stored value is compared and swapped in the underlying
+// database.
+func (db *encryptedDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	if !encryptedTables[string(bucket)] {
+		return db.DB.CmpAndSwap(bucket, key, oldValue, newValue)
+	}
+	stored, err := db.DB.Get(bucket, key)
+	switch {
+	case nosql.IsErrNotFound(err):
+		if oldValue != nil {
+			return nil, false, nil
+		}
+		stored = nil
+	case err != nil:
+		return nil, false, err
+	default:
+		current, err := db.decrypt(bucket, key, stored)
+		if err != nil {
+			return nil, false, err
+		}
+		if !bytes.Equal(current, oldValue) {
+			return current, false, nil
+		}
+	}
+
+	v, err := db.encrypt(bucket, key, newValue)
+	if err != nil {
+		return nil, false, err
+	}
+	result, swapped, err := db.DB.CmpAndSwap(bucket, key, stored, v)
+	if err != nil || swapped {
+		return newValue, swapped, err
+	}
+	// The value changed after it was read.
+	if result == nil {
+		return nil, false, nil
+	}
+	current, err := db.decrypt(bucket, key, result)
+	return current, false, err
+}
+
+// Update encrypts the values set and decrypts the values read in the
+// transaction. Compare and swap operations are not supported in the encrypted
+// tables.
+func (db *encryptedDB) Update(tx *database.Tx) error {
+	var err error
+	for _, q := range tx.Operations {
+		if !encryptedTables[string(q.Bucket)] {
+			continue
+		}
+		switch q.Cmd {
+		case database.Set:
+			if q.Value, err = db.encrypt(q.Bucket, q.Key, q.Value); err != nil {
+				return err
+			}
+		case database.CmpAndSwap:
+			return errors.Wrapf(database.ErrOpNotSupported, "compare and swap in encrypted table %s", q.Bucket)
+		}
+	}
+	if err := db.DB.Update(tx); err != nil {
+		return err
+	}
+	for _, q := range tx.Operations {
+		if q.Cmd == database.Get && encryptedTables[string(q.Bucket)] {
+			if q.Result, err = db.decrypt(q.Bucket, q.Key, q.Result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reencrypt encrypts with the current data key the values of the encrypted
+// tables encrypted with other keys or not encrypted, e.g. after a rotation of
+// the data key or after enabling the encryption. It returns the number of
+// values encrypted again. The values are replaced using compare and swap, so
+// it can run while the CA is running.
+func Reencrypt(d nosql.DB) (int, error) {
+	if v, ok := d.(*DB); ok {
+		d = v.DB
+	}
+	db, ok := d.(*encryptedDB)
+	if !ok {
+		return 0, errors.New("database encryption is not configured")
+	}
+
+	var n int
+	for table := range encryptedTables {
+		bucket := []byte(table)
+		entries, err := db.DB.List(bucket)
+		switch {
+		case nosql.IsErrNotFound(err):
+			continue
+		case err != nil:
+			return n, errors.Wrapf(err, "error listing table %s", table)
+		}
+		for _, e := range entries {
+			if id, _, _, ok := parseEncrypted(e.Value); ok && id == db.keyID {
+				continue
+			}
+			plaintext, err := db.decrypt(bucket, e.Key, e.Value)
+			if err != nil {
+				return n, err
+			}
+			v, err := db.encrypt(bucket, e.Key, plaintext)
+			if err != nil {
+				return n, err
+			}
+			// A value modified concurrently is already encrypted with the
+			// current key.
+			if _, swapped, err := db.DB.CmpAndSwap(bucket, e.Key, e.Value, v); err != nil {
+				return n, errors.Wrapf(err, "error updating %s/%s", table, e.Key)
+			} else if swapped {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
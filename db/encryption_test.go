@@ -0,0 +1,346 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/nosql/database"
+)
+
+// mockKMS decrypts the ciphertexts prefixing them with the key name.
+type mockKMS struct {
+	keys map[string][]byte
+}
+
+func (m *mockKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockKMS) Close() error {
+	return nil
+}
+
+func (m *mockKMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	key, ok := m.keys[req.Name+":"+string(req.Ciphertext)]
+	if !ok {
+		return nil, errors.New("decryption failed")
+	}
+	return key, nil
+}
+
+// newMemoryDB returns a MockNoSQLDB that keeps the tables in memory.
+func newMemoryDB() (*MockNoSQLDB, map[string]map[string][]byte) {
+	tables := map[string]map[string][]byte{}
+	return &MockNoSQLDB{
+		MGet: func(bucket, key []byte) ([]byte, error) {
+			if v, ok := tables[string(bucket)][string(key)]; ok {
+				return v, nil
+			}
+			return nil, database.ErrNotFound
+		},
+		MSet: func(bucket, key, value []byte) error {
+			if tables[string(bucket)] == nil {
+				tables[string(bucket)] = map[string][]byte{}
+			}
+			tables[string(bucket)][string(key)] = value
+			return nil
+		},
+		MList: func(bucket []byte) ([]*database.Entry, error) {
+			t, ok := tables[string(bucket)]
+			if !ok {
+				return nil, database.ErrNotFound
+			}
+			var entries []*database.Entry
+			for k, v := range t {
+				entries = append(entries, &database.Entry{Bucket: bucket, Key: []byte(k), Value: v})
+			}
+			return entries, nil
+		},
+		MCmpAndSwap: func(bucket, key, old, newval []byte) ([]byte, bool, error) {
+			if tables[string(bucket)] == nil {
+				tables[string(bucket)] = map[string][]byte{}
+			}
+			if v := tables[string(bucket)][string(key)]; !bytes.Equal(v, old) {
+				return v, false, nil
+			}
+			tables[string(bucket)][string(key)] = newval
+			return newval, true, nil
+		},
+		MUpdate: func(tx *database.Tx) error {
+			for _, q := range tx.Operations {
+				switch q.Cmd {
+				case database.Set:
+					if tables[string(q.Bucket)] == nil {
+						tables[string(q.Bucket)] = map[string][]byte{}
+					}
+					tables[string(q.Bucket)][string(q.Key)] = q.Value
+				case database.Get:
+					q.Result = tables[string(q.Bucket)][string(q.Key)]
+				}
+			}
+			return nil
+		},
+	}, tables
+}
+
+// mustEncryptionConfig writes the data keys in dir and returns an encryption
+// configuration using them, the first key is the current one. The keys are
+// decrypted with km.
+func mustEncryptionConfig(t *testing.T, dir string, km *mockKMS, ids ...string) *EncryptionConfig {
+	t.Helper()
+	c := &EncryptionConfig{KMS: &apiv1.Options{Type: "cloudkms"}}
+	for i, id := range ids {
+		path := filepath.Join(dir, id)
+		assert.FatalError(t, ioutil.WriteFile(path, []byte("ciphertext-"+id), 0600))
+		key := bytes.Repeat([]byte(id), 32)[:32]
+		// The keys after the first one are base64 encoded.
+		if i > 0 {
+			key = []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+		}
+		km.keys["key:ciphertext-"+id] = key
+		c.Keys = append(c.Keys, &EncryptionKey{ID: id, Key: "key", Path: path})
+	}
+	return c
+}
+
+// mockNewKMS replaces newKMS with a function returning a mockKMS, it returns
+// the mock, a temporary directory and a function to clean up both.
+func mockNewKMS(t *testing.T) (*mockKMS, string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "encryption")
+	assert.FatalError(t, err)
+	km := &mockKMS{keys: map[string][]byte{}}
+	tmp := newKMS
+	newKMS = func(ctx context.Context, opts apiv1.Options) (kms.KeyManager, error) {
+		return km, nil
+	}
+	return km, dir, func() {
+		newKMS = tmp
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEncryptionConfig_Validate(t *testing.T) {
+	key := &EncryptionKey{ID: "1", Key: "key", Path: "key.enc"}
+	opts := &apiv1.Options{Type: "cloudkms"}
+	tests := []struct {
+		name    string
+		c       *EncryptionConfig
+		wantErr bool
+	}{
+		{"ok/nil", nil, false},
+		{"ok", &EncryptionConfig{KMS: opts, Keys: []*EncryptionKey{key, {ID: "2", Key: "key", Path: "key2.enc"}}}, false},
+		{"fail/kms", &EncryptionConfig{Keys: []*EncryptionKey{key}}, true},
+		{"fail/kms-type", &EncryptionConfig{KMS: &apiv1.Options{Type: "foo"}, Keys: []*EncryptionKey{key}}, true},
+		{"fail/keys", &EncryptionConfig{KMS: opts}, true},
+		{"fail/id", &EncryptionConfig{KMS: opts, Keys: []*EncryptionKey{{Key: "key", Path: "key.enc"}}}, true},
+		{"fail/duplicated", &EncryptionConfig{KMS: opts, Keys: []*EncryptionKey{key, key}}, true},
+		{"fail/key", &EncryptionConfig{KMS: opts, Keys: []*EncryptionKey{{ID: "1", Path: "key.enc"}}}, true},
+		{"fail/path", &EncryptionConfig{KMS: opts, Keys: []*EncryptionKey{{ID: "1", Key: "key"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("EncryptionConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptionConfig_loadKeys(t *testing.T) {
+	km, dir, cleanup := mockNewKMS(t)
+	defer cleanup()
+	c := mustEncryptionConfig(t, dir, km, "1", "2")
+	keys, err := c.loadKeys()
+	assert.FatalError(t, err)
+	assert.Len(t, 2, keys)
+
+	// Invalid data key.
+	km.keys["key:ciphertext-2"] = []byte("short")
+	_, err = c.loadKeys()
+	assert.Equals(t, "data key in "+c.Keys[1].Path+" is not a 32 bytes key", err.Error())
+
+	// Decryption error.
+	c.Keys[1].Key = "other"
+	_, err = c.loadKeys()
+	assert.Error(t, err)
+
+	// Missing file.
+	c.Keys[1].Path = c.Keys[1].Path + ".missing"
+	_, err = c.loadKeys()
+	assert.Error(t, err)
+
+	// KMS without decryption.
+	newKMS = func(ctx context.Context, opts apiv1.Options) (kms.KeyManager, error) {
+		return struct{ kms.KeyManager }{km}, nil
+	}
+	_, err = c.loadKeys()
+	assert.Equals(t, "kms cloudkms does not support decryption", err.Error())
+}
+
+func TestEncryptedDB(t *testing.T) {
+	km, dir, cleanup := mockNewKMS(t)
+	defer cleanup()
+	mem, tables := newMemoryDB()
+	edb, err := newEncryptedDB(mem, mustEncryptionConfig(t, dir, km, "1"))
+	assert.FatalError(t, err)
+
+	// Sensitive values are encrypted.
+	assert.FatalError(t, edb.Set(usedOTTTable, []byte("id"), []byte("token")))
+	stored := tables["used_ott"]["id"]
+	assert.True(t, bytes.HasPrefix(stored, encryptedPrefix))
+	assert.False(t, bytes.Contains(stored, []byte("token")))
+	v, err := edb.Get(usedOTTTable, []byte("id"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("token"), v)
+
+	// Other values are not.
+	assert.FatalError(t, edb.Set(certsTable, []byte("1"), []byte("cert")))
+	assert.Equals(t, []byte("cert"), tables["x509_certs"]["1"])
+	v, err = edb.Get(certsTable, []byte("1"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("cert"), v)
+
+	// Values written before the encryption are read as they are.
+	tables["used_ott"]["plain"] = []byte("plain-token")
+	entries, err := edb.List(usedOTTTable)
+	assert.FatalError(t, err)
+	got := map[string]string{}
+	for _, e := range entries {
+		got[string(e.Key)] = string(e.Value)
+	}
+	assert.Equals(t, map[string]string{"id": "token", "plain": "plain-token"}, got)
+
+	// An encrypted value cannot be moved to another key.
+	tables["used_ott"]["moved"] = stored
+	_, err = edb.Get(usedOTTTable, []byte("moved"))
+	assert.Error(t, err)
+
+	// Compare and swap compares the plaintext.
+	_, swapped, err := edb.CmpAndSwap(usedOTTTable, []byte("id"), nil, []byte("other"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	current, swapped, err := edb.CmpAndSwap(usedOTTTable, []byte("id"), []byte("wrong"), []byte("other"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	assert.Equals(t, []byte("token"), current)
+	_, swapped, err = edb.CmpAndSwap(usedOTTTable, []byte("id"), []byte("token"), []byte("other"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	_, swapped, err = edb.CmpAndSwap(usedOTTTable, []byte("new"), nil, []byte("new-token"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	_, swapped, err = edb.CmpAndSwap(usedOTTTable, []byte("missing"), []byte("old"), []byte("new"))
+	assert.FatalError(t, err)
+	assert.False(t, swapped)
+	v, err = edb.Get(usedOTTTable, []byte("new"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("new-token"), v)
+
+	// Transactions.
+	tx := new(database.Tx)
+	tx.Set(provisionersTable, []byte("p1"), []byte("provisioner"))
+	assert.FatalError(t, edb.Update(tx))
+	assert.True(t, bytes.HasPrefix(tables["provisioners"]["p1"], encryptedPrefix))
+	tx = new(database.Tx)
+	tx.Get(provisionersTable, []byte("p1"))
+	assert.FatalError(t, edb.Update(tx))
+	assert.Equals(t, []byte("provisioner"), tx.Operations[0].Result)
+	tx = &database.Tx{Operations: []*database.TxEntry{{
+		Bucket: provisionersTable, Key: []byte("p1"), CmpValue: []byte("provisioner"), Value: []byte("other"), Cmd: database.CmpAndSwap,
+	}}}
+	assert.Error(t, edb.Update(tx))
+}
+
+func TestReencrypt(t *testing.T) {
+	km, dir, cleanup := mockNewKMS(t)
+	defer cleanup()
+	mem, tables := newMemoryDB()
+	old, err := newEncryptedDB(mem, mustEncryptionConfig(t, dir, km, "1"))
+	assert.FatalError(t, err)
+	assert.FatalError(t, old.Set(provisionerKeysTable, []byte("p1"), []byte("keys")))
+	assert.FatalError(t, old.Set([]byte("acme_external_account_keys"), []byte("eab"), []byte("hmac")))
+	tables["acme_accounts"] = map[string][]byte{"acc": []byte("account")}
+
+	_, err = Reencrypt(mem)
+	assert.Equals(t, "database encryption is not configured", err.Error())
+
+	// Rotate the key, the old one is still available.
+	c := mustEncryptionConfig(t, dir, km, "2", "1")
+	edb, err := newEncryptedDB(mem, c)
+	assert.FatalError(t, err)
+	n, err := Reencrypt(&DB{DB: edb})
+	assert.FatalError(t, err)
+	assert.Equals(t, 3, n)
+	for _, table := range []string{"provisioner_keys", "acme_external_account_keys", "acme_accounts"} {
+		for _, v := range tables[table] {
+			id, _, _, ok := parseEncrypted(v)
+			assert.True(t, ok)
+			assert.Equals(t, "2", id)
+		}
+	}
+	n, err = Reencrypt(edb)
+	assert.FatalError(t, err)
+	assert.Equals(t, 0, n)
+
+	// The old key is not needed anymore.
+	edb, err = newEncryptedDB(mem, &EncryptionConfig{KMS: c.KMS, Keys: c.Keys[:1]})
+	assert.FatalError(t, err)
+	v, err := edb.Get([]byte("acme_external_account_keys"), []byte("eab"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("hmac"), v)
+
+	// Values encrypted with an unknown key cannot be read.
+	_, err = old.Get(provisionerKeysTable, []byte("p1"))
+	assert.Equals(t, "error decrypting provisioner_keys/p1: key 2 not found", err.Error())
+}
+
+func TestBackup_encrypted(t *testing.T) {
+	km, dir, cleanup := mockNewKMS(t)
+	defer cleanup()
+	c := mustEncryptionConfig(t, dir, km, "1")
+	mem, tables := newMemoryDB()
+	edb, err := newEncryptedDB(mem, c)
+	assert.FatalError(t, err)
+	assert.FatalError(t, edb.Set(usedOTTTable, []byte("id"), []byte("token")))
+	stored := tables["used_ott"]["id"]
+
+	// The backup has the encrypted values.
+	var buf bytes.Buffer
+	assert.FatalError(t, WriteBackup(&buf, &DB{DB: edb}, [][]byte{usedOTTTable}, &BackupManifest{}))
+
+	dst, dstTables := newMemoryDB()
+	dst.MDeleteTable = func(bucket []byte) error { return nil }
+	dst.MCreateTable = func(bucket []byte) error { return nil }
+	dstDB, err := newEncryptedDB(dst, c)
+	assert.FatalError(t, err)
+	_, err = RestoreBackup(bytes.NewReader(buf.Bytes()), &DB{DB: dstDB})
+	assert.FatalError(t, err)
+	assert.Equals(t, stored, dstTables["used_ott"]["id"])
+	v, err := dstDB.Get(usedOTTTable, []byte("id"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("token"), v)
+}
+
+func TestNew_encryption(t *testing.T) {
+	_, err := New(&Config{Type: "badgerv2", DataSource: "db", Encryption: &EncryptionConfig{}})
+	assert.Equals(t, "db.encryption.kms is required", err.Error())
+}
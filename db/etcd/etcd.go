@@ -0,0 +1,446 @@
+// Package etcd implements the nosql database interface over etcd, so multiple
+// replicas of the CA can share the same state.
+//
+// The entries are stored with the key <prefix>/<table>/<key>, and each table
+// has a marker key <prefix>/<table>. All the reads are linearizable, and the
+// compare and swap operations and the transactions are atomic across all the
+// replicas.
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// DefaultPrefix is the prefix of all the keys if a database is not set.
+const DefaultPrefix = "step-ca"
+
+// requestTimeout is the maximum time used by a request.
+var requestTimeout = 15 * time.Second
+
+// maxRetries is the number of times a transaction is retried if a key it read
+// has been modified by another replica.
+const maxRetries = 10
+
+// DB is an etcd implementation of the nosql database.DB interface.
+type DB struct {
+	client *clientv3.Client
+	kv     clientv3.KV
+	prefix string
+}
+
+// Open connects to the etcd cluster. The data source is a comma separated
+// list of endpoints, optionally followed by the query parameters ca, cert and
+// key, the paths of the CA certificates and client certificate used with TLS,
+// and username and password, e.g.:
+//
+//	https://etcd1:2379,https://etcd2:2379?ca=/etc/etcd/ca.crt&cert=/etc/etcd/client.crt&key=/etc/etcd/client.key
+//
+// The database option is used as the prefix of the keys, by default "step-ca".
+func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
+	opts := &database.Options{}
+	for _, o := range opt {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := parseDataSource(dataSourceName)
+	if err != nil {
+		return err
+	}
+	client, err := clientv3.New(*cfg)
+	if err != nil {
+		return errors.Wrap(err, "error connecting to etcd")
+	}
+
+	prefix := opts.Database
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	// Fail early if the cluster is not reachable.
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := client.Get(ctx, prefix, clientv3.WithCountOnly()); err != nil {
+		client.Close()
+		return errors.Wrap(err, "error connecting to etcd")
+	}
+
+	db.client = client
+	db.kv = client.KV
+	db.prefix = prefix
+	return nil
+}
+
+// parseDataSource returns the client configuration for the given data source.
+func parseDataSource(dataSourceName string) (*clientv3.Config, error) {
+	endpoints, rawQuery := dataSourceName, ""
+	if i := strings.Index(dataSourceName, "?"); i >= 0 {
+		endpoints, rawQuery = dataSourceName[:i], dataSourceName[i+1:]
+	}
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing etcd dataSource")
+	}
+
+	cfg := &clientv3.Config{
+		DialTimeout: requestTimeout,
+		Username:    params.Get("username"),
+		Password:    params.Get("password"),
+	}
+	for _, e := range strings.Split(endpoints, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			cfg.Endpoints = append(cfg.Endpoints, e)
+		}
+	}
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("etcd dataSource cannot be empty")
+	}
+
+	ca, cert, key := params.Get("ca"), params.Get("cert"), params.Get("key")
+	if ca == "" && cert == "" && key == "" {
+		return cfg, nil
+	}
+	cfg.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		b, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", ca)
+		}
+		cfg.TLS.RootCAs = x509.NewCertPool()
+		if !cfg.TLS.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("error parsing %s: no certificates found", ca)
+		}
+	}
+	if cert != "" || key != "" {
+		crt, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "error loading etcd client certificate")
+		}
+		cfg.TLS.Certificates = []tls.Certificate{crt}
+	}
+	return cfg, nil
+}
+
+// Close closes the connection to the cluster.
+func (db *DB) Close() error {
+	return errors.WithStack(db.client.Close())
+}
+
+// Get returns the value with the given key.
+func (db *DB) Get(bucket, key []byte) ([]byte, error) {
+	k, err := db.key(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+	resp, err := db.kv.Get(ctx, k)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+// Set sets the value with the given key.
+func (db *DB) Set(bucket, key, value []byte) error {
+	k, err := db.key(bucket, key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := db.kv.Put(ctx, k, string(value)); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// Del deletes the value with the given key.
+func (db *DB) Del(bucket, key []byte) error {
+	k, err := db.key(bucket, key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := db.kv.Delete(ctx, k); err != nil {
+		return errors.Wrapf(err, "failed to delete %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// List returns all the entries in the given table.
+func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
+	table, err := db.table(bucket)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	// The marker and the entries are read in the same revision.
+	resp, err := db.kv.Txn(ctx).Then(
+		clientv3.OpGet(table),
+		clientv3.OpGet(table+"/", clientv3.WithPrefix()),
+	).Commit()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing table %s", bucket)
+	}
+	if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+		return nil, errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+	}
+
+	kvs := resp.Responses[1].GetResponseRange().Kvs
+	entries := make([]*database.Entry, 0, len(kvs))
+	for _, kv := range kvs {
+		entries = append(entries, &database.Entry{
+			Bucket: bucket,
+			Key:    kv.Key[len(table)+1:],
+			Value:  kv.Value,
+		})
+	}
+	return entries, nil
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	k, err := db.key(bucket, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var cmp clientv3.Cmp
+	if oldValue == nil {
+		cmp = clientv3.Compare(clientv3.CreateRevision(k), "=", 0)
+	} else {
+		cmp = clientv3.Compare(clientv3.Value(k), "=", string(oldValue))
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+	resp, err := db.kv.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(k, string(newValue))).
+		Else(clientv3.OpGet(k)).
+		Commit()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to compare and swap %s/%s", bucket, key)
+	}
+	if resp.Succeeded {
+		return newValue, true, nil
+	}
+	if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+		return kvs[0].Value, false, nil
+	}
+	return nil, false, nil
+}
+
+// Update performs multiple commands on one transaction. The reads are done
+// first, and the writes are only committed if none of the keys read has been
+// modified in the meantime, otherwise the transaction is retried.
+func (db *DB) Update(tx *database.Tx) error {
+	for i := 0; i < maxRetries; i++ {
+		ok, err := db.update(tx)
+		if err != nil {
+			return errors.Wrap(err, "UPDATE failed")
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("UPDATE failed: too many conflicts")
+}
+
+// read is a key read in a transaction and its revision at that time.
+type read struct {
+	value    []byte
+	exists   bool
+	revision int64
+}
+
+func (db *DB) update(tx *database.Tx) (bool, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	reads := make(map[string]*read)
+	writes := make(map[string]*clientv3.Op)
+	var order []string
+
+	// get returns the current value of the key, including the writes of the
+	// transaction.
+	get := func(k string) ([]byte, bool, error) {
+		if op, ok := writes[k]; ok {
+			if op.IsPut() {
+				return op.ValueBytes(), true, nil
+			}
+			return nil, false, nil
+		}
+		if r, ok := reads[k]; ok {
+			return r.value, r.exists, nil
+		}
+		resp, err := db.kv.Get(ctx, k)
+		if err != nil {
+			return nil, false, err
+		}
+		r := &read{}
+		if len(resp.Kvs) > 0 {
+			r.value, r.exists, r.revision = resp.Kvs[0].Value, true, resp.Kvs[0].ModRevision
+		}
+		reads[k] = r
+		return r.value, r.exists, nil
+	}
+	write := func(k string, op clientv3.Op) {
+		if _, ok := writes[k]; !ok {
+			order = append(order, k)
+		}
+		writes[k] = &op
+	}
+
+	var ops []clientv3.Op
+	for _, q := range tx.Operations {
+		switch q.Cmd {
+		case database.CreateTable:
+			table, err := db.table(q.Bucket)
+			if err != nil {
+				return false, err
+			}
+			write(table, clientv3.OpPut(table, ""))
+		case database.DeleteTable:
+			table, err := db.table(q.Bucket)
+			if err != nil {
+				return false, err
+			}
+			ops = append(ops, clientv3.OpDelete(table+"/", clientv3.WithPrefix()))
+			write(table, clientv3.OpDelete(table))
+		case database.Get:
+			k, err := db.key(q.Bucket, q.Key)
+			if err != nil {
+				return false, err
+			}
+			value, exists, err := get(k)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key)
+			}
+			if !exists {
+				return false, errors.Wrapf(database.ErrNotFound, "%s/%s not found", q.Bucket, q.Key)
+			}
+			q.Result = value
+		case database.Set:
+			k, err := db.key(q.Bucket, q.Key)
+			if err != nil {
+				return false, err
+			}
+			write(k, clientv3.OpPut(k, string(q.Value)))
+		case database.Delete:
+			k, err := db.key(q.Bucket, q.Key)
+			if err != nil {
+				return false, err
+			}
+			write(k, clientv3.OpDelete(k))
+		case database.CmpAndSwap:
+			k, err := db.key(q.Bucket, q.Key)
+			if err != nil {
+				return false, err
+			}
+			current, _, err := get(k)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to get %s/%s", q.Bucket, q.Key)
+			}
+			if bytes.Equal(current, q.CmpValue) {
+				write(k, clientv3.OpPut(k, string(q.Value)))
+				q.Result, q.Swapped = q.Value, true
+			} else {
+				q.Result, q.Swapped = current, false
+			}
+		default:
+			return false, database.ErrOpNotSupported
+		}
+	}
+
+	cmps := make([]clientv3.Cmp, 0, len(reads))
+	for k, r := range reads {
+		if r.exists {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(k), "=", r.revision))
+		} else {
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(k), "=", 0))
+		}
+	}
+	for _, k := range order {
+		ops = append(ops, *writes[k])
+	}
+	resp, err := db.kv.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// CreateTable creates the marker of the table.
+func (db *DB) CreateTable(bucket []byte) error {
+	table, err := db.table(bucket)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+	if _, err := db.kv.Put(ctx, table, ""); err != nil {
+		return errors.Wrapf(err, "failed to create table %s", bucket)
+	}
+	return nil
+}
+
+// DeleteTable deletes the marker and all the entries of the table.
+func (db *DB) DeleteTable(bucket []byte) error {
+	table, err := db.table(bucket)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := defaultContext()
+	defer cancel()
+	resp, err := db.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(table), ">", 0)).
+		Then(clientv3.OpDelete(table), clientv3.OpDelete(table+"/", clientv3.WithPrefix())).
+		Commit()
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete table %s", bucket)
+	}
+	if !resp.Succeeded {
+		return errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+	}
+	return nil
+}
+
+func (db *DB) table(bucket []byte) (string, error) {
+	if len(bucket) == 0 || bytes.IndexByte(bucket, '/') >= 0 {
+		return "", errors.Errorf("invalid table name %q", bucket)
+	}
+	return db.prefix + "/" + string(bucket), nil
+}
+
+func (db *DB) key(bucket, key []byte) (string, error) {
+	table, err := db.table(bucket)
+	if err != nil {
+		return "", err
+	}
+	return table + "/" + string(key), nil
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
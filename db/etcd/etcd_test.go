@@ -0,0 +1,316 @@
+package etcd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/smallstep/nosql/database"
+	"go.etcd.io/etcd/embed"
+)
+
+// startEtcd starts a single node etcd server and returns its client endpoint.
+func startEtcd(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := url.URL{Scheme: "http", Host: "127.0.0.1:0"}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LCUrls, cfg.ACUrls = []url.URL{local}, []url.URL{local}
+	cfg.LPUrls, cfg.APUrls = []url.URL{local}, []url.URL{local}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	cfg.LogOutput = "default"
+	cfg.Debug = false
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	<-e.Server.ReadyNotify()
+	return e.Clients[0].Addr().String(), func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func mustOpen(t *testing.T, endpoint, prefix string) *DB {
+	t.Helper()
+	db := &DB{}
+	if err := db.Open(endpoint, database.WithDatabase(prefix)); err != nil {
+		t.Fatalf("DB.Open() error = %v", err)
+	}
+	return db
+}
+
+func Test_parseDataSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	badCA := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(badCA, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		dataSource    string
+		wantEndpoints []string
+		wantTLS       bool
+		wantErr       bool
+	}{
+		{"ok", "127.0.0.1:2379", []string{"127.0.0.1:2379"}, false, false},
+		{"ok multiple", "https://etcd1:2379, https://etcd2:2379,", []string{"https://etcd1:2379", "https://etcd2:2379"}, false, false},
+		{"ok credentials", "https://etcd1:2379?username=step&password=pass", []string{"https://etcd1:2379"}, false, false},
+		{"fail empty", "", nil, false, true},
+		{"fail query", "https://etcd1:2379?ca=%zz", nil, false, true},
+		{"fail ca missing", "https://etcd1:2379?ca=" + filepath.Join(dir, "missing"), nil, false, true},
+		{"fail ca", "https://etcd1:2379?ca=" + badCA, nil, false, true},
+		{"fail cert", "https://etcd1:2379?cert=" + badCA + "&key=" + badCA, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDataSource(tt.dataSource)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDataSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.Endpoints, tt.wantEndpoints) {
+				t.Errorf("parseDataSource() endpoints = %v, want %v", got.Endpoints, tt.wantEndpoints)
+			}
+			if (got.TLS != nil) != tt.wantTLS {
+				t.Errorf("parseDataSource() tls = %v, want %v", got.TLS, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestDB(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+
+	db := mustOpen(t, endpoint, "test")
+	defer db.Close()
+	bucket := []byte("table")
+
+	if _, err := db.List(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.List() error = %v, want not found", err)
+	}
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatalf("DB.CreateTable() error = %v", err)
+	}
+	if entries, err := db.List(bucket); err != nil || len(entries) != 0 {
+		t.Errorf("DB.List() = %v, %v, want empty", entries, err)
+	}
+	if _, err := db.Get(bucket, []byte("key")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+	if err := db.Set(bucket, []byte("key"), []byte("value")); err != nil {
+		t.Fatalf("DB.Set() error = %v", err)
+	}
+	if got, err := db.Get(bucket, []byte("key")); err != nil || string(got) != "value" {
+		t.Errorf("DB.Get() = %s, %v, want value", got, err)
+	}
+
+	// Compare and swap.
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("key"), nil, []byte("other")); err != nil || swapped || string(got) != "value" {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want value, false", got, swapped, err)
+	}
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("wrong"), []byte("other")); err != nil || swapped || string(got) != "value" {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want value, false", got, swapped, err)
+	}
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("value"), []byte("new")); err != nil || !swapped || string(got) != "new" {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want new, true", got, swapped, err)
+	}
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("missing"), []byte("value"), []byte("new")); err != nil || swapped || got != nil {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want nil, false", got, swapped, err)
+	}
+	if got, swapped, err := db.CmpAndSwap(bucket, []byte("created"), nil, []byte("value")); err != nil || !swapped || string(got) != "value" {
+		t.Errorf("DB.CmpAndSwap() = %s, %v, %v, want value, true", got, swapped, err)
+	}
+
+	// Keys with the same prefix in other tables or databases are not listed.
+	if err := db.Set([]byte("table2"), []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	other := mustOpen(t, endpoint, "other")
+	defer other.Close()
+	if err := other.CreateTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Set(bucket, []byte("other-key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := db.List(bucket)
+	if err != nil {
+		t.Fatalf("DB.List() error = %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		if !bytes.Equal(e.Bucket, bucket) {
+			t.Errorf("DB.List() bucket = %s, want %s", e.Bucket, bucket)
+		}
+		got[string(e.Key)] = string(e.Value)
+	}
+	if want := map[string]string{"key": "new", "created": "value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DB.List() = %v, want %v", got, want)
+	}
+
+	if err := db.Del(bucket, []byte("key")); err != nil {
+		t.Errorf("DB.Del() error = %v", err)
+	}
+	if _, err := db.Get(bucket, []byte("key")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+	if err := db.DeleteTable(bucket); err != nil {
+		t.Errorf("DB.DeleteTable() error = %v", err)
+	}
+	if _, err := db.Get(bucket, []byte("created")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+	if err := db.DeleteTable(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.DeleteTable() error = %v, want not found", err)
+	}
+	if _, err := other.Get(bucket, []byte("other-key")); err != nil {
+		t.Errorf("DB.Get() error = %v", err)
+	}
+
+	// Invalid tables.
+	if err := db.Set([]byte("a/b"), []byte("key"), []byte("value")); err == nil {
+		t.Error("DB.Set() error = nil, wantErr true")
+	}
+	if err := db.CreateTable(nil); err == nil {
+		t.Error("DB.CreateTable() error = nil, wantErr true")
+	}
+}
+
+func TestDB_Update(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+
+	db := mustOpen(t, endpoint, "test")
+	defer db.Close()
+	bucket := []byte("nonces")
+
+	tx := new(database.Tx)
+	tx.CreateTable(bucket)
+	tx.Set(bucket, []byte("a"), []byte("1"))
+	tx.Set(bucket, []byte("b"), []byte("2"))
+	tx.Get(bucket, []byte("a"))
+	tx.Cas(bucket, []byte("c"), []byte("3"))
+	tx.Cas(bucket, []byte("a"), []byte("4"))
+	if err := db.Update(tx); err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+	if got := tx.Operations[3].Result; string(got) != "1" {
+		t.Errorf("DB.Update() get = %s, want 1", got)
+	}
+	if op := tx.Operations[4]; !op.Swapped || string(op.Result) != "3" {
+		t.Errorf("DB.Update() cas = %s, %v, want 3, true", op.Result, op.Swapped)
+	}
+	if op := tx.Operations[5]; op.Swapped || string(op.Result) != "1" {
+		t.Errorf("DB.Update() cas = %s, %v, want 1, false", op.Result, op.Swapped)
+	}
+
+	// Use a nonce: get and delete.
+	useNonce := func(key string) error {
+		return db.Update(&database.Tx{Operations: []*database.TxEntry{
+			{Bucket: bucket, Key: []byte(key), Cmd: database.Get},
+			{Bucket: bucket, Key: []byte(key), Cmd: database.Delete},
+		}})
+	}
+	if err := useNonce("b"); err != nil {
+		t.Errorf("DB.Update() error = %v", err)
+	}
+	if err := useNonce("b"); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Update() error = %v, want not found", err)
+	}
+
+	// Writes are not applied if an operation fails.
+	tx = new(database.Tx)
+	tx.Set(bucket, []byte("d"), []byte("5"))
+	tx.Get(bucket, []byte("missing"))
+	if err := db.Update(tx); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Update() error = %v, want not found", err)
+	}
+	if _, err := db.Get(bucket, []byte("d")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+
+	tx = new(database.Tx)
+	tx.Cmp(bucket, []byte("a"), []byte("1"))
+	if err := db.Update(tx); err == nil {
+		t.Error("DB.Update() error = nil, wantErr true")
+	}
+}
+
+// TestDB_replicas checks that a nonce can only be used once when multiple
+// replicas try to use it at the same time.
+func TestDB_replicas(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+
+	const replicas, nonces = 4, 20
+	dbs := make([]*DB, replicas)
+	for i := range dbs {
+		dbs[i] = mustOpen(t, endpoint, "test")
+		defer dbs[i].Close()
+	}
+	bucket := []byte("nonces")
+	for i := 0; i < nonces; i++ {
+		if _, swapped, err := dbs[0].CmpAndSwap(bucket, []byte(strconv.Itoa(i)), nil, []byte("nonce")); err != nil || !swapped {
+			t.Fatalf("DB.CmpAndSwap() = %v, %v", swapped, err)
+		}
+	}
+
+	var mutex sync.Mutex
+	var used []string
+	var wg sync.WaitGroup
+	for _, db := range dbs {
+		wg.Add(1)
+		go func(db *DB) {
+			defer wg.Done()
+			for i := 0; i < nonces; i++ {
+				key := []byte(strconv.Itoa(i))
+				err := db.Update(&database.Tx{Operations: []*database.TxEntry{
+					{Bucket: bucket, Key: key, Cmd: database.Get},
+					{Bucket: bucket, Key: key, Cmd: database.Delete},
+				}})
+				switch {
+				case err == nil:
+					mutex.Lock()
+					used = append(used, string(key))
+					mutex.Unlock()
+				case !database.IsErrNotFound(err):
+					t.Errorf("DB.Update() error = %v", err)
+				}
+			}
+		}(db)
+	}
+	wg.Wait()
+
+	sort.Strings(used)
+	if len(used) != nonces {
+		t.Errorf("%d nonces were used, want %d: %v", len(used), nonces, used)
+	}
+	for i := 1; i < len(used); i++ {
+		if used[i] == used[i-1] {
+			t.Errorf("nonce %s was used twice", used[i])
+		}
+	}
+}
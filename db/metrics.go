@@ -0,0 +1,90 @@
+package db
+
+import (
+	"time"
+
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+// ObserveFunc is the function called with the operation, the table and the
+// duration of each database operation.
+type ObserveFunc func(operation, table string, d time.Duration)
+
+// observedDB is a nosql.DB that reports the duration of the operations.
+type observedDB struct {
+	nosql.DB
+	observe ObserveFunc
+}
+
+// WithObserver returns an AuthDB that calls fn after each database operation.
+// Only the databases created with New are observed, the rest are returned as
+// they are.
+func WithObserver(d AuthDB, fn ObserveFunc) AuthDB {
+	db, ok := d.(*DB)
+	if !ok || fn == nil {
+		return d
+	}
+	if _, ok := db.DB.(*observedDB); ok {
+		return d
+	}
+	return &DB{&observedDB{DB: db.DB, observe: fn}, db.isUp}
+}
+
+func (db *observedDB) done(operation string, bucket []byte, start time.Time) {
+	db.observe(operation, string(bucket), time.Since(start))
+}
+
+// Get returns the value stored in the given table and key.
+func (db *observedDB) Get(bucket, key []byte) ([]byte, error) {
+	defer db.done("get", bucket, time.Now())
+	return db.DB.Get(bucket, key)
+}
+
+// Set sets the given value in the given table and key.
+func (db *observedDB) Set(bucket, key, value []byte) error {
+	defer db.done("set", bucket, time.Now())
+	return db.DB.Set(bucket, key, value)
+}
+
+// CmpAndSwap swaps the value in the given table and key if the current value
+// is equivalent to oldValue.
+func (db *observedDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	defer db.done("cmp_and_swap", bucket, time.Now())
+	return db.DB.CmpAndSwap(bucket, key, oldValue, newValue)
+}
+
+// Del deletes the value in the given table and key.
+func (db *observedDB) Del(bucket, key []byte) error {
+	defer db.done("del", bucket, time.Now())
+	return db.DB.Del(bucket, key)
+}
+
+// List returns all the entries in the given table.
+func (db *observedDB) List(bucket []byte) ([]*database.Entry, error) {
+	defer db.done("list", bucket, time.Now())
+	return db.DB.List(bucket)
+}
+
+// Update performs a transaction, it is reported with the table of its first
+// operation.
+func (db *observedDB) Update(tx *database.Tx) error {
+	var bucket []byte
+	if tx != nil && len(tx.Operations) > 0 {
+		bucket = tx.Operations[0].Bucket
+	}
+	defer db.done("update", bucket, time.Now())
+	return db.DB.Update(tx)
+}
+
+// CreateTable creates the given table.
+func (db *observedDB) CreateTable(bucket []byte) error {
+	defer db.done("create_table", bucket, time.Now())
+	return db.DB.CreateTable(bucket)
+}
+
+// DeleteTable deletes the given table.
+func (db *observedDB) DeleteTable(bucket []byte) error {
+	defer db.done("delete_table", bucket, time.Now())
+	return db.DB.DeleteTable(bucket)
+}
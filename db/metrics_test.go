@@ -0,0 +1,49 @@
+package db
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+func TestWithObserver(t *testing.T) {
+	type observation struct {
+		operation, table string
+	}
+	var got []observation
+	fn := func(operation, table string, d time.Duration) {
+		assert.True(t, d >= 0)
+		got = append(got, observation{operation, table})
+	}
+
+	// Only the databases created with New are observed.
+	simple := &SimpleDB{}
+	assert.Equals(t, simple, WithObserver(simple, fn))
+	mock := &MockAuthDB{}
+	assert.Equals(t, mock, WithObserver(mock, fn))
+
+	mem, _ := newMemoryDB()
+	d := &DB{mem, true}
+	assert.Equals(t, d, WithObserver(d, nil))
+
+	od := WithObserver(d, fn)
+	assert.Type(t, &DB{}, od)
+	assert.Type(t, &observedDB{}, od.(*DB).DB)
+	// Observers are not nested.
+	assert.Equals(t, od, WithObserver(od, fn))
+
+	assert.FatalError(t, od.StoreCertificate(&x509.Certificate{SerialNumber: big.NewInt(1234), Raw: []byte("raw")}))
+	isRevoked, err := od.IsRevoked("1234")
+	assert.FatalError(t, err)
+	assert.False(t, isRevoked)
+	assert.Equals(t, []observation{
+		{"set", "x509_certs"},
+		{"get", "revoked_x509_certs"},
+	}, got)
+
+	// Backups use the stored values.
+	assert.Equals(t, mem, unwrap(od.(*DB)))
+}
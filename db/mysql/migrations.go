@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// migrationsTable keeps the applied schema versions.
+	migrationsTable = "step_schema_migrations"
+	// migrationsLock is the name of the advisory lock that prevents two CAs
+	// from migrating the same database at the same time.
+	migrationsLock = "step_schema_migrations"
+	// migrationsLockTimeout is the number of seconds to wait for the lock.
+	migrationsLockTimeout = 60
+)
+
+// migration is a change of the schema. Migrations are applied in order and
+// only once, new migrations must be appended to the list.
+type migration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, conn *sql.Conn) error
+}
+
+var migrations = []migration{
+	{1, "use LONGBLOB values in tables created by the nosql driver", longBlobValues},
+}
+
+// migrate applies the pending migrations.
+func migrate(ctx context.Context, db *sql.DB) error {
+	return applyMigrations(ctx, db, migrations)
+}
+
+func applyMigrations(ctx context.Context, db *sql.DB, list []migration) error {
+	// The advisory lock belongs to the connection, so all the migrations run
+	// on the same one.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error connecting to mysql")
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationsLock, migrationsLockTimeout).Scan(&locked); err != nil {
+		return errors.Wrap(err, "error acquiring the migrations lock")
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return errors.New("error acquiring the migrations lock: timeout")
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationsLock)
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+quoteIdentifier(migrationsTable)+
+		" (version INT NOT NULL, description VARCHAR(255) NOT NULL, applied_at DATETIME NOT NULL, PRIMARY KEY (version))"); err != nil {
+		return errors.Wrap(err, "error creating migrations table")
+	}
+
+	var current sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT MAX(version) FROM "+quoteIdentifier(migrationsTable)).Scan(&current); err != nil {
+		return errors.Wrap(err, "error reading schema version")
+	}
+	for _, m := range pending(list, int(current.Int64)) {
+		// MySQL commits DDL statements implicitly, so migrations cannot be
+		// rolled back and they must be safe to run again.
+		if err := m.up(ctx, conn); err != nil {
+			return errors.Wrapf(err, "error applying migration %d", m.version)
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO "+quoteIdentifier(migrationsTable)+" (version, description, applied_at) VALUES (?, ?, ?)",
+			m.version, m.description, time.Now().UTC()); err != nil {
+			return errors.Wrapf(err, "error recording migration %d", m.version)
+		}
+	}
+	return nil
+}
+
+// pending returns the migrations after the current version.
+func pending(list []migration, current int) []migration {
+	var ms []migration
+	for _, m := range list {
+		if m.version > current {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// longBlobValues changes the value column of the tables created by the nosql
+// driver from BLOB, limited to 64KB, to LONGBLOB.
+func longBlobValues(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, `SELECT table_name FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND column_name = 'nvalue' AND data_type = 'blob'`)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range tables {
+		if err := validateTable([]byte(name)); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "ALTER TABLE "+quoteIdentifier(name)+" MODIFY nvalue LONGBLOB"); err != nil {
+			return errors.Wrapf(err, "error altering table %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+// Package mysql implements the nosql database interface over MySQL and
+// MariaDB, including managed services like Amazon RDS or Google Cloud SQL.
+//
+// Compared with the nosql driver, the queries use prepared statements that are
+// cached per table, the values are stored in LONGBLOB columns, and the schema
+// is versioned so existing databases are upgraded on startup.
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// MySQL error numbers.
+const (
+	errBadTable    = 1051
+	errNoSuchTable = 1146
+	errDeadlock    = 1213
+)
+
+// connMaxLifetime is the maximum time a connection is reused, it must be
+// shorter than the wait_timeout of the server and of any proxy in front of it.
+var connMaxLifetime = 3 * time.Minute
+
+// validTable is the regular expression used to validate the table names, the
+// names cannot be sent as parameters of a prepared statement.
+var validTable = regexp.MustCompile(`^[a-zA-Z0-9_]{1,64}$`)
+
+type queryType int
+
+const (
+	getQuery queryType = iota
+	lockQuery
+	setQuery
+	delQuery
+	listQuery
+)
+
+// DB is a MySQL implementation of the nosql database.DB interface.
+type DB struct {
+	db    *sql.DB
+	mutex sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// Open connects to the database with the given data source name, e.g.
+// "user:password@tcp(127.0.0.1:3306)/". The database in the options is
+// created if it does not exist, and the schema is migrated to the latest
+// version.
+func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
+	opts := &database.Options{}
+	for _, o := range opt {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := gomysql.ParseDSN(dataSourceName)
+	if err != nil {
+		return errors.Wrap(err, "error parsing mysql dataSource")
+	}
+	if opts.Database != "" {
+		cfg.DBName = opts.Database
+	}
+	if cfg.DBName == "" {
+		return errors.New("mysql database cannot be empty")
+	}
+	if err := createDatabase(cfg); err != nil {
+		return err
+	}
+
+	sqlDB, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return errors.Wrap(err, "error connecting to mysql")
+	}
+	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+	if err := migrate(context.Background(), sqlDB); err != nil {
+		sqlDB.Close()
+		return err
+	}
+
+	db.db = sqlDB
+	db.stmts = make(map[string]*sql.Stmt)
+	return nil
+}
+
+// createDatabase creates the database in the configuration if it does not
+// exist.
+func createDatabase(cfg *gomysql.Config) error {
+	c := *cfg
+	c.DBName = ""
+	sqlDB, err := sql.Open("mysql", c.FormatDSN())
+	if err != nil {
+		return errors.Wrap(err, "error connecting to mysql")
+	}
+	defer sqlDB.Close()
+	if _, err := sqlDB.Exec("CREATE DATABASE IF NOT EXISTS " + quoteIdentifier(cfg.DBName)); err != nil {
+		return errors.Wrapf(err, "error creating database %s (if not exists)", cfg.DBName)
+	}
+	return nil
+}
+
+// Close closes the prepared statements and the database.
+func (db *DB) Close() error {
+	db.mutex.Lock()
+	for _, stmt := range db.stmts {
+		stmt.Close()
+	}
+	db.stmts = nil
+	db.mutex.Unlock()
+	return errors.WithStack(db.db.Close())
+}
+
+// Get retrieves the value with the given key.
+func (db *DB) Get(bucket, key []byte) ([]byte, error) {
+	stmt, err := db.stmt(getQuery, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return get(stmt, bucket, key)
+}
+
+// Set inserts or updates the value with the given key.
+func (db *DB) Set(bucket, key, value []byte) error {
+	stmt, err := db.stmt(setQuery, bucket)
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.Exec(key, value); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// Del deletes the value with the given key.
+func (db *DB) Del(bucket, key []byte) error {
+	stmt, err := db.stmt(delQuery, bucket)
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.Exec(key); err != nil {
+		return errors.Wrapf(err, "failed to delete %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// List returns all the entries in the given table.
+func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
+	stmt, err := db.stmt(listQuery, bucket)
+	if err != nil {
+		if isError(err, errNoSuchTable) {
+			return nil, errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+		}
+		return nil, err
+	}
+	rows, err := stmt.Query()
+	if err != nil {
+		if isError(err, errNoSuchTable) {
+			return nil, errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+		}
+		return nil, errors.Wrapf(err, "error querying table %s", bucket)
+	}
+	defer rows.Close()
+
+	var entries []*database.Entry
+	for rows.Next() {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, errors.Wrap(err, "error getting key and value from row")
+		}
+		entries = append(entries, &database.Entry{
+			Bucket: bucket,
+			Key:    key,
+			Value:  value,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error accessing row")
+	}
+	return entries, nil
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	getStmt, err := db.stmt(lockQuery, bucket)
+	if err != nil {
+		return nil, false, err
+	}
+	setStmt, err := db.stmt(setQuery, bucket)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	val, swapped, err := cmpAndSwap(tx.Stmt(getStmt), tx.Stmt(setStmt), bucket, key, oldValue, newValue)
+	switch {
+	case err != nil:
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, false, errors.Wrapf(err, "failed to execute CmpAndSwap transaction on %s/%s and failed to rollback transaction", bucket, key)
+		}
+		// Two transactions storing a missing key at the same time lock the
+		// same gap, and the server aborts one of them. For that one the value
+		// has changed.
+		if isError(err, errDeadlock) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	case swapped:
+		if err := tx.Commit(); err != nil {
+			return nil, false, errors.Wrap(err, "failed to commit mysql transaction")
+		}
+		return val, true, nil
+	default:
+		if err := tx.Rollback(); err != nil {
+			return nil, false, errors.Wrapf(err, "failed to rollback read-only CmpAndSwap transaction on %s/%s", bucket, key)
+		}
+		return val, false, nil
+	}
+}
+
+// Update performs multiple commands on one read-write transaction.
+func (db *DB) Update(txn *database.Tx) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	rollback := func(err error) error {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Wrap(err, "UPDATE failed, unable to rollback transaction")
+		}
+		return errors.Wrap(err, "UPDATE failed")
+	}
+	txStmt := func(t queryType, bucket []byte) (*sql.Stmt, error) {
+		stmt, err := db.stmt(t, bucket)
+		if err != nil {
+			return nil, err
+		}
+		return tx.Stmt(stmt), nil
+	}
+
+	for _, q := range txn.Operations {
+		switch q.Cmd {
+		case database.CreateTable:
+			if err := createTable(tx, q.Bucket); err != nil {
+				return rollback(err)
+			}
+		case database.DeleteTable:
+			if err := deleteTable(tx, q.Bucket); err != nil {
+				return rollback(err)
+			}
+			db.forget(q.Bucket)
+		case database.Get:
+			// Reads lock the row, so a value read and deleted in a
+			// transaction, like an ACME nonce, is only read once when
+			// multiple instances share the database.
+			stmt, err := txStmt(lockQuery, q.Bucket)
+			if err != nil {
+				return rollback(err)
+			}
+			if q.Result, err = get(stmt, q.Bucket, q.Key); err != nil {
+				return rollback(err)
+			}
+		case database.Set:
+			stmt, err := txStmt(setQuery, q.Bucket)
+			if err != nil {
+				return rollback(err)
+			}
+			if _, err := stmt.Exec(q.Key, q.Value); err != nil {
+				return rollback(errors.Wrapf(err, "failed to set %s/%s", q.Bucket, q.Key))
+			}
+		case database.Delete:
+			stmt, err := txStmt(delQuery, q.Bucket)
+			if err != nil {
+				return rollback(err)
+			}
+			if _, err := stmt.Exec(q.Key); err != nil {
+				return rollback(errors.Wrapf(err, "failed to delete %s/%s", q.Bucket, q.Key))
+			}
+		case database.CmpAndSwap:
+			getStmt, err := txStmt(lockQuery, q.Bucket)
+			if err != nil {
+				return rollback(err)
+			}
+			setStmt, err := txStmt(setQuery, q.Bucket)
+			if err != nil {
+				return rollback(err)
+			}
+			q.Result, q.Swapped, err = cmpAndSwap(getStmt, setStmt, q.Bucket, q.Key, q.CmpValue, q.Value)
+			if err != nil {
+				return rollback(errors.Wrapf(err, "failed to load-or-store %s/%s", q.Bucket, q.Key))
+			}
+		default:
+			return rollback(database.ErrOpNotSupported)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return rollback(errors.WithStack(err))
+	}
+	return nil
+}
+
+// CreateTable creates a table in the database.
+func (db *DB) CreateTable(bucket []byte) error {
+	return createTable(db.db, bucket)
+}
+
+// DeleteTable deletes a table in the database.
+func (db *DB) DeleteTable(bucket []byte) error {
+	if err := deleteTable(db.db, bucket); err != nil {
+		return err
+	}
+	db.forget(bucket)
+	return nil
+}
+
+// stmt returns the prepared statement of the given type for the table,
+// preparing it the first time it is used.
+func (db *DB) stmt(t queryType, bucket []byte) (*sql.Stmt, error) {
+	if err := validateTable(bucket); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%d/%s", t, bucket)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if stmt, ok := db.stmts[name]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.db.Prepare(query(t, bucket))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error preparing statement for table %s", bucket)
+	}
+	db.stmts[name] = stmt
+	return stmt, nil
+}
+
+// forget closes and removes the prepared statements of a deleted table.
+func (db *DB) forget(bucket []byte) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for _, t := range []queryType{getQuery, lockQuery, setQuery, delQuery, listQuery} {
+		name := fmt.Sprintf("%d/%s", t, bucket)
+		if stmt, ok := db.stmts[name]; ok {
+			stmt.Close()
+			delete(db.stmts, name)
+		}
+	}
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func createTable(e execer, bucket []byte) error {
+	if err := validateTable(bucket); err != nil {
+		return err
+	}
+	if _, err := e.Exec(createTableQuery(bucket)); err != nil {
+		return errors.Wrapf(err, "failed to create table %s", bucket)
+	}
+	return nil
+}
+
+func deleteTable(e execer, bucket []byte) error {
+	if err := validateTable(bucket); err != nil {
+		return err
+	}
+	if _, err := e.Exec("DROP TABLE " + quoteIdentifier(string(bucket))); err != nil {
+		if isError(err, errBadTable) {
+			return errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+		}
+		return errors.Wrapf(err, "failed to delete table %s", bucket)
+	}
+	return nil
+}
+
+func get(stmt *sql.Stmt, bucket, key []byte) ([]byte, error) {
+	var val []byte
+	err := stmt.QueryRow(key).Scan(&val)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	default:
+		return val, nil
+	}
+}
+
+// cmpAndSwap compares and swaps the value inside a transaction, getStmt must
+// lock the row.
+func cmpAndSwap(getStmt, setStmt *sql.Stmt, bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	var current []byte
+	if err := getStmt.QueryRow(key).Scan(&current); err != nil && err != sql.ErrNoRows {
+		return nil, false, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	if !bytes.Equal(current, oldValue) {
+		return current, false, nil
+	}
+	if _, err := setStmt.Exec(key, newValue); err != nil {
+		return nil, false, errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return newValue, true, nil
+}
+
+func query(t queryType, bucket []byte) string {
+	table := quoteIdentifier(string(bucket))
+	switch t {
+	case getQuery:
+		return "SELECT nvalue FROM " + table + " WHERE nkey = ?"
+	case lockQuery:
+		return "SELECT nvalue FROM " + table + " WHERE nkey = ? FOR UPDATE"
+	case setQuery:
+		return "INSERT INTO " + table + " (nkey, nvalue) VALUES (?, ?) ON DUPLICATE KEY UPDATE nvalue = VALUES(nvalue)"
+	case delQuery:
+		return "DELETE FROM " + table + " WHERE nkey = ?"
+	default:
+		return "SELECT nkey, nvalue FROM " + table
+	}
+}
+
+func createTableQuery(bucket []byte) string {
+	return "CREATE TABLE IF NOT EXISTS " + quoteIdentifier(string(bucket)) +
+		" (nkey VARBINARY(255) NOT NULL, nvalue LONGBLOB, PRIMARY KEY (nkey))"
+}
+
+func validateTable(bucket []byte) error {
+	if !validTable.Match(bucket) {
+		return errors.Errorf("invalid table name %q", bucket)
+	}
+	return nil
+}
+
+func quoteIdentifier(s string) string {
+	return "`" + s + "`"
+}
+
+func isError(err error, number uint16) bool {
+	e, ok := errors.Cause(err).(*gomysql.MySQLError)
+	return ok && e.Number == number
+}
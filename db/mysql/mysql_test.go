@@ -0,0 +1,247 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallstep/nosql/database"
+)
+
+func Test_query(t *testing.T) {
+	tests := []struct {
+		name string
+		t    queryType
+		want string
+	}{
+		{"get", getQuery, "SELECT nvalue FROM `x509_certs` WHERE nkey = ?"},
+		{"lock", lockQuery, "SELECT nvalue FROM `x509_certs` WHERE nkey = ? FOR UPDATE"},
+		{"set", setQuery, "INSERT INTO `x509_certs` (nkey, nvalue) VALUES (?, ?) ON DUPLICATE KEY UPDATE nvalue = VALUES(nvalue)"},
+		{"del", delQuery, "DELETE FROM `x509_certs` WHERE nkey = ?"},
+		{"list", listQuery, "SELECT nkey, nvalue FROM `x509_certs`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := query(tt.t, []byte("x509_certs")); got != tt.want {
+				t.Errorf("query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  []byte
+		wantErr bool
+	}{
+		{"ok", []byte("x509_certs"), false},
+		{"ok max", bytes.Repeat([]byte("a"), 64), false},
+		{"fail empty", []byte(""), true},
+		{"fail long", bytes.Repeat([]byte("a"), 65), true},
+		{"fail quote", []byte("x509`; DROP TABLE x509_certs; --"), true},
+		{"fail dash", []byte("x509-certs"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTable(tt.bucket); (err != nil) != tt.wantErr {
+				t.Errorf("validateTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_pending(t *testing.T) {
+	list := []migration{{version: 1}, {version: 2}, {version: 3}}
+	tests := []struct {
+		name    string
+		current int
+		want    []int
+	}{
+		{"all", 0, []int{1, 2, 3}},
+		{"some", 1, []int{2, 3}},
+		{"none", 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, m := range pending(list, tt.current) {
+				got = append(got, m.version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pending() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDB_Open_errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		dataSource string
+		opts       []database.Option
+	}{
+		{"fail dataSource", "user:password@tcp(127.0.0.1:3306", nil},
+		{"fail database", "user:password@tcp(127.0.0.1:3306)/", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &DB{}
+			if err := db.Open(tt.dataSource, tt.opts...); err == nil {
+				t.Error("DB.Open() error = nil, wantErr true")
+			}
+		})
+	}
+}
+
+// TestDB runs against a real server if STEP_TEST_MYSQL_DSN is set, e.g.
+// "root:password@tcp(127.0.0.1:3306)/".
+func TestDB(t *testing.T) {
+	dsn := os.Getenv("STEP_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("STEP_TEST_MYSQL_DSN is not set")
+	}
+	name := fmt.Sprintf("step_test_%d", time.Now().UnixNano())
+
+	db := &DB{}
+	if err := db.Open(dsn, database.WithDatabase(name)); err != nil {
+		t.Fatalf("DB.Open() error = %v", err)
+	}
+	defer func() {
+		db.db.Exec("DROP DATABASE " + quoteIdentifier(name))
+		db.Close()
+	}()
+
+	// Opening it again does not apply the migrations twice.
+	other := &DB{}
+	if err := other.Open(dsn, database.WithDatabase(name)); err != nil {
+		t.Fatalf("DB.Open() error = %v", err)
+	}
+	other.Close()
+
+	bucket := []byte("test_table")
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatalf("DB.CreateTable() error = %v", err)
+	}
+	if _, err := db.Get(bucket, []byte("missing")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+
+	// Values larger than a BLOB.
+	large := bytes.Repeat([]byte("a"), 1<<17)
+	if err := db.Set(bucket, []byte("key"), large); err != nil {
+		t.Fatalf("DB.Set() error = %v", err)
+	}
+	if got, err := db.Get(bucket, []byte("key")); err != nil || !bytes.Equal(got, large) {
+		t.Errorf("DB.Get() = %d bytes, %v", len(got), err)
+	}
+
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("other"), []byte("new")); err != nil || swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want false, nil", swapped, err)
+	}
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("key"), large, []byte("new")); err != nil || !swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want true, nil", swapped, err)
+	}
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("new-key"), nil, []byte("value")); err != nil || !swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want true, nil", swapped, err)
+	}
+
+	// Concurrent inserts and uses of the same key only succeed once.
+	var wg sync.WaitGroup
+	var swaps, uses int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, swapped, err := db.CmpAndSwap(bucket, []byte("once"), nil, []byte("value"))
+			if err != nil {
+				t.Errorf("DB.CmpAndSwap() error = %v", err)
+			}
+			if swapped {
+				atomic.AddInt32(&swaps, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := new(database.Tx)
+			tx.Get(bucket, []byte("once"))
+			tx.Del(bucket, []byte("once"))
+			if err := db.Update(tx); err == nil {
+				atomic.AddInt32(&uses, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if swaps != 1 || uses != 1 {
+		t.Errorf("concurrent swaps = %d, uses = %d, want 1 and 1", swaps, uses)
+	}
+
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("tx-key"), []byte("tx-value"))
+	tx.Del(bucket, []byte("new-key"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: bucket, Key: []byte("key"), CmpValue: []byte("new"), Value: []byte("newer"), Cmd: database.CmpAndSwap,
+	})
+	if err := db.Update(tx); err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+	if !tx.Operations[2].Swapped {
+		t.Error("DB.Update() compare and swap was not swapped")
+	}
+
+	entries, err := db.List(bucket)
+	if err != nil {
+		t.Fatalf("DB.List() error = %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		got[string(e.Key)] = string(e.Value)
+	}
+	if want := map[string]string{"key": "newer", "tx-key": "tx-value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DB.List() = %v, want %v", got, want)
+	}
+
+	if err := db.Del(bucket, []byte("key")); err != nil {
+		t.Errorf("DB.Del() error = %v", err)
+	}
+	if err := db.DeleteTable(bucket); err != nil {
+		t.Errorf("DB.DeleteTable() error = %v", err)
+	}
+	if _, err := db.List(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.List() error = %v, want not found", err)
+	}
+	if err := db.DeleteTable(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.DeleteTable() error = %v, want not found", err)
+	}
+
+	// Tables created by the nosql driver are migrated.
+	if _, err := db.db.Exec("CREATE TABLE `old_table` (nkey VARBINARY(255), nvalue BLOB, PRIMARY KEY (nkey))"); err != nil {
+		t.Fatal(err)
+	}
+	if err := longBlobValues(context.Background(), mustConn(t, db)); err != nil {
+		t.Fatalf("longBlobValues() error = %v", err)
+	}
+	if err := db.Set([]byte("old_table"), []byte("key"), large); err != nil {
+		t.Errorf("DB.Set() error = %v", err)
+	}
+}
+
+func mustConn(t *testing.T, db *DB) *sql.Conn {
+	t.Helper()
+	conn, err := db.db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql"
+	"github.com/smallstep/nosql/database"
+)
+
+var namespaceRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// validateNamespace checks that the namespace can be used as a prefix of the
+// table names in all the supported databases.
+func validateNamespace(ns string) error {
+	if !namespaceRegexp.MatchString(ns) {
+		return errors.Errorf("namespace %s is not valid, it can only contain letters, numbers and underscores", ns)
+	}
+	return nil
+}
+
+// namespacedDB is a nosql.DB that prefixes the tables with a namespace, so
+// multiple authorities can keep their data in the same database. The
+// underlying database belongs to another authority and it's not closed.
+type namespacedDB struct {
+	nosql.DB
+	prefix string
+}
+
+// newNamespacedDB returns a nosql.DB that stores the tables of db in the given
+// namespace.
+func newNamespacedDB(db nosql.DB, ns string) *namespacedDB {
+	return &namespacedDB{DB: db, prefix: ns + "_"}
+}
+
+// WithNamespace returns an AuthDB that keeps its tables in the database of d
+// under the given namespace, using the same encryption. The new database does
+// not close the one in d. If d is not a database created with New, e.g. if the
+// authority does not have a database, d is returned.
+func WithNamespace(d AuthDB, ns string) (AuthDB, error) {
+	if err := validateNamespace(ns); err != nil {
+		return nil, err
+	}
+	parent, ok := d.(*DB)
+	if !ok {
+		return d, nil
+	}
+	var db nosql.DB = newNamespacedDB(unwrap(parent), ns)
+	if e, ok := findEncryptedDB(parent.DB); ok {
+		db = &encryptedDB{DB: db, keyID: e.keyID, keys: e.keys}
+	}
+	for _, b := range tables {
+		if err := db.CreateTable(b); err != nil {
+			return nil, errors.Wrapf(err, "error creating table %s", string(b))
+		}
+	}
+	return &DB{db, true}, nil
+}
+
+// findEncryptedDB returns the encryption layer of db if it has one.
+func findEncryptedDB(db nosql.DB) (*encryptedDB, bool) {
+	if o, ok := db.(*observedDB); ok {
+		db = o.DB
+	}
+	e, ok := db.(*encryptedDB)
+	return e, ok
+}
+
+func (db *namespacedDB) table(bucket []byte) []byte {
+	return append([]byte(db.prefix), bucket...)
+}
+
+// Close does not close the underlying database, it's closed by its authority.
+func (db *namespacedDB) Close() error {
+	return nil
+}
+
+// Get returns the value stored in the given table and key.
+func (db *namespacedDB) Get(bucket, key []byte) ([]byte, error) {
+	return db.DB.Get(db.table(bucket), key)
+}
+
+// Set sets the given value in the given table and key.
+func (db *namespacedDB) Set(bucket, key, value []byte) error {
+	return db.DB.Set(db.table(bucket), key, value)
+}
+
+// CmpAndSwap swaps the value in the given table and key if the current value
+// is equivalent to oldValue.
+func (db *namespacedDB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	return db.DB.CmpAndSwap(db.table(bucket), key, oldValue, newValue)
+}
+
+// Del deletes the value in the given table and key.
+func (db *namespacedDB) Del(bucket, key []byte) error {
+	return db.DB.Del(db.table(bucket), key)
+}
+
+// List returns the entries of a table, the entries have the table name without
+// the namespace.
+func (db *namespacedDB) List(bucket []byte) ([]*database.Entry, error) {
+	entries, err := db.DB.List(db.table(bucket))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		e.Bucket = bucket
+	}
+	return entries, nil
+}
+
+// Update runs the transaction in the tables of the namespace. The operations
+// keep their table names after the transaction.
+func (db *namespacedDB) Update(tx *database.Tx) error {
+	buckets := make([][]byte, len(tx.Operations))
+	for i, q := range tx.Operations {
+		buckets[i] = q.Bucket
+		q.Bucket = db.table(q.Bucket)
+	}
+	defer func() {
+		for i, q := range tx.Operations {
+			q.Bucket = buckets[i]
+		}
+	}()
+	return db.DB.Update(tx)
+}
+
+// CreateTable creates the table in the namespace.
+func (db *namespacedDB) CreateTable(bucket []byte) error {
+	return db.DB.CreateTable(db.table(bucket))
+}
+
+// DeleteTable deletes the table in the namespace.
+func (db *namespacedDB) DeleteTable(bucket []byte) error {
+	return db.DB.DeleteTable(db.table(bucket))
+}
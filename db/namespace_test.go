@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/nosql/database"
+)
+
+func Test_validateNamespace(t *testing.T) {
+	tests := []struct {
+		ns      string
+		wantErr bool
+	}{
+		{"tenant_a", false},
+		{"Team1", false},
+		{"", true},
+		{"tenant-a", true},
+		{"tenant/a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ns, func(t *testing.T) {
+			if err := validateNamespace(tt.ns); (err != nil) != tt.wantErr {
+				t.Errorf("validateNamespace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithNamespace(t *testing.T) {
+	mem, stored := newMemoryDB()
+	var created []string
+	mem.MCreateTable = func(bucket []byte) error {
+		created = append(created, string(bucket))
+		return nil
+	}
+	mem.MClose = func() error {
+		t.Error("shared database closed")
+		return nil
+	}
+	parent := &DB{mem, true}
+
+	_, err := WithNamespace(parent, "tenant-a")
+	assert.Error(t, err)
+
+	d, err := WithNamespace(parent, "tenant_a")
+	assert.FatalError(t, err)
+	assert.Len(t, len(tables), created)
+	for _, name := range created {
+		assert.True(t, bytes.HasPrefix([]byte(name), []byte("tenant_a_")), name)
+	}
+	ndb := d.(*DB)
+
+	// Values are stored in the tables of the namespace.
+	assert.FatalError(t, ndb.Set(certsTable, []byte("1"), []byte("cert")))
+	assert.FatalError(t, parent.Set(certsTable, []byte("1"), []byte("other")))
+	assert.Equals(t, []byte("cert"), stored["tenant_a_x509_certs"]["1"])
+	assert.Equals(t, []byte("other"), stored["x509_certs"]["1"])
+	v, err := ndb.Get(certsTable, []byte("1"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("cert"), v)
+
+	entries, err := ndb.List(certsTable)
+	assert.FatalError(t, err)
+	assert.Len(t, 1, entries)
+	assert.Equals(t, certsTable, entries[0].Bucket)
+
+	_, swapped, err := ndb.CmpAndSwap(certsTable, []byte("1"), []byte("cert"), []byte("new"))
+	assert.FatalError(t, err)
+	assert.True(t, swapped)
+	assert.Equals(t, []byte("new"), stored["tenant_a_x509_certs"]["1"])
+
+	// Transactions keep their table names.
+	tx := new(database.Tx)
+	tx.Set(provisionersTable, []byte("p1"), []byte("provisioner"))
+	assert.FatalError(t, ndb.Update(tx))
+	assert.Equals(t, []byte("provisioner"), stored["tenant_a_provisioners"]["p1"])
+	assert.Equals(t, provisionersTable, tx.Operations[0].Bucket)
+
+	// The parent database is not closed.
+	assert.FatalError(t, ndb.Shutdown())
+
+	// Databases without tables are returned as they are.
+	sdb, err := newSimpleDB(nil)
+	assert.FatalError(t, err)
+	d, err = WithNamespace(sdb, "tenant_a")
+	assert.FatalError(t, err)
+	assert.Equals(t, sdb, d)
+}
+
+func TestWithNamespace_encrypted(t *testing.T) {
+	km, dir, cleanup := mockNewKMS(t)
+	defer cleanup()
+	mem, tables := newMemoryDB()
+	mem.MCreateTable = func(bucket []byte) error { return nil }
+	edb, err := newEncryptedDB(mem, mustEncryptionConfig(t, dir, km, "1"))
+	assert.FatalError(t, err)
+
+	d, err := WithNamespace(&DB{edb, true}, "tenant_a")
+	assert.FatalError(t, err)
+	ndb := d.(*DB)
+	assert.FatalError(t, ndb.Set(usedOTTTable, []byte("id"), []byte("token")))
+	assert.True(t, bytes.HasPrefix(tables["tenant_a_used_ott"]["id"], encryptedPrefix))
+	v, err := ndb.Get(usedOTTTable, []byte("id"))
+	assert.FatalError(t, err)
+	assert.Equals(t, []byte("token"), v)
+}
@@ -41,16 +41,76 @@ func (s *SimpleDB) Revoke(rci *RevokedCertificateInfo) error {
 	return ErrNotImplemented
 }
 
+// GetRevokedCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedCertificate(sn string) (*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// Unrevoke returns a "NotImplemented" error.
+func (s *SimpleDB) Unrevoke(sn string) error {
+	return ErrNotImplemented
+}
+
 // RevokeSSH returns a "NotImplemented" error.
 func (s *SimpleDB) RevokeSSH(rci *RevokedCertificateInfo) error {
 	return ErrNotImplemented
 }
 
+// IsSSHKeyIDRevoked noop
+func (s *SimpleDB) IsSSHKeyIDRevoked(keyID string) (bool, error) {
+	return false, nil
+}
+
+// RevokeSSHKeyID returns a "NotImplemented" error.
+func (s *SimpleDB) RevokeSSHKeyID(rci *RevokedCertificateInfo) error {
+	return ErrNotImplemented
+}
+
+// GetRevokedSSHCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedSSHCertificates() ([]*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetRevokedCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevokedCertificates() ([]*RevokedCertificateInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+// RevokeBatch returns a "NotImplemented" error.
+func (s *SimpleDB) RevokeBatch(id string, batch []byte, rcis []*RevokedCertificateInfo) error {
+	return ErrNotImplemented
+}
+
+// GetRevocationBatch returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevocationBatch(id string) ([]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetRevocationBatches returns a "NotImplemented" error.
+func (s *SimpleDB) GetRevocationBatches() ([][]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetCertificates returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificates() ([]*x509.Certificate, error) {
+	return nil, ErrNotImplemented
+}
+
 // StoreCertificate returns a "NotImplemented" error.
 func (s *SimpleDB) StoreCertificate(crt *x509.Certificate) error {
 	return ErrNotImplemented
 }
 
+// StoreCTSubmissions returns a "NotImplemented" error.
+func (s *SimpleDB) StoreCTSubmissions(serial string, submissions []byte) error {
+	return ErrNotImplemented
+}
+
+// StoreLintResults returns a "NotImplemented" error.
+func (s *SimpleDB) StoreLintResults(serial string, results []byte) error {
+	return ErrNotImplemented
+}
+
 type usedToken struct {
 	UsedAt int64  `json:"ua,omitempty"`
 	Token  string `json:"tok,omitempty"`
@@ -84,6 +144,171 @@ func (s *SimpleDB) GetSSHHostPrincipals() ([]string, error) {
 	return nil, ErrNotImplemented
 }
 
+// GetProvisionerKeys returns nil, provisioner keys are not stored.
+func (s *SimpleDB) GetProvisionerKeys(provisionerID string) ([]byte, error) {
+	return nil, nil
+}
+
+// StoreProvisionerKeys returns a "NotImplemented" error.
+func (s *SimpleDB) StoreProvisionerKeys(provisionerID string, keys []byte) error {
+	return ErrNotImplemented
+}
+
+// GetProvisioners returns nil, provisioners are not stored.
+func (s *SimpleDB) GetProvisioners() ([][]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapProvisioner returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapProvisioner(name string, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetAdmins returns nil, administrators are not stored.
+func (s *SimpleDB) GetAdmins() ([][]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapAdmin returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapAdmin(subject string, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetPolicy returns nil, policies are not stored.
+func (s *SimpleDB) GetPolicy() ([]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapPolicy returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapPolicy(oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetJob returns nil, jobs are not stored.
+func (s *SimpleDB) GetJob(name string) ([]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapJob returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapJob(name string, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetSubCARequest returns nil, sub-CA requests are not stored.
+func (s *SimpleDB) GetSubCARequest(id string) ([]byte, error) {
+	return nil, nil
+}
+
+// GetSubCARequests returns nil, sub-CA requests are not stored.
+func (s *SimpleDB) GetSubCARequests() ([][]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapSubCARequest returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapSubCARequest(id string, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetRootRotation returns nil, root rotations are not stored.
+func (s *SimpleDB) GetRootRotation() ([]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapRootRotation returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapRootRotation(oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetIntermediate returns nil, renewed intermediates are not stored.
+func (s *SimpleDB) GetIntermediate() ([]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapIntermediate returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapIntermediate(oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// ReserveSerialNumber returns a "NotImplemented" error.
+func (s *SimpleDB) ReserveSerialNumber(serial string) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetSerialCounter returns nil, the serial counter is not stored.
+func (s *SimpleDB) GetSerialCounter() ([]byte, error) {
+	return nil, nil
+}
+
+// CmpAndSwapSerialCounter returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapSerialCounter(oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// GetShortLivedSummary returns a "NotImplemented" error.
+func (s *SimpleDB) GetShortLivedSummary(key string) ([]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetShortLivedSummaries returns a "NotImplemented" error.
+func (s *SimpleDB) GetShortLivedSummaries() ([][]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// CmpAndSwapShortLivedSummary returns a "NotImplemented" error.
+func (s *SimpleDB) CmpAndSwapShortLivedSummary(key string, oldValue, newValue []byte) (bool, error) {
+	return false, ErrNotImplemented
+}
+
+// StoreCertificateIndex returns a "NotImplemented" error.
+func (s *SimpleDB) StoreCertificateIndex(serial string, index []byte, names []string) error {
+	return ErrNotImplemented
+}
+
+// DeleteCertificate returns a "NotImplemented" error.
+func (s *SimpleDB) DeleteCertificate(serial string, names []string) error {
+	return ErrNotImplemented
+}
+
+// GetCertificateIndex returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificateIndex(serial string) ([]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetCertificateIndexes returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificateIndexes() ([][]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetCertificateSerialsByName returns a "NotImplemented" error.
+func (s *SimpleDB) GetCertificateSerialsByName(name string) ([]string, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetSchemaVersion returns a "NotImplemented" error.
+func (s *SimpleDB) GetSchemaVersion(name string) (int, error) {
+	return 0, ErrNotImplemented
+}
+
+// SetSchemaVersion returns a "NotImplemented" error.
+func (s *SimpleDB) SetSchemaVersion(name string, version int) error {
+	return ErrNotImplemented
+}
+
+// StoreAuditEvent returns a "NotImplemented" error.
+func (s *SimpleDB) StoreAuditEvent(seq uint64, event []byte) error {
+	return ErrNotImplemented
+}
+
+// GetLastAuditEvent returns a "NotImplemented" error.
+func (s *SimpleDB) GetLastAuditEvent() ([]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// GetAuditEvents returns a "NotImplemented" error.
+func (s *SimpleDB) GetAuditEvents() ([][]byte, error) {
+	return nil, ErrNotImplemented
+}
+
 // Shutdown returns nil
 func (s *SimpleDB) Shutdown() error {
 	return nil
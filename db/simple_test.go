@@ -13,14 +13,64 @@ func TestSimpleDB(t *testing.T) {
 	// Revoke
 	assert.Equals(t, ErrNotImplemented, db.Revoke(nil))
 
+	// GetRevokedCertificate
+	rci, err := db.GetRevokedCertificate("foo")
+	assert.Nil(t, rci)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// Unrevoke
+	assert.Equals(t, ErrNotImplemented, db.Unrevoke("foo"))
+
 	// IsRevoked -- verify noop
 	isRevoked, err := db.IsRevoked("foo")
 	assert.False(t, isRevoked)
 	assert.Nil(t, err)
 
+	// RevokeSSHKeyID
+	assert.Equals(t, ErrNotImplemented, db.RevokeSSHKeyID(nil))
+
+	// IsSSHKeyIDRevoked -- verify noop
+	isRevoked, err = db.IsSSHKeyIDRevoked("foo")
+	assert.False(t, isRevoked)
+	assert.Nil(t, err)
+
+	// GetRevokedSSHCertificates
+	revoked, err := db.GetRevokedSSHCertificates()
+	assert.Nil(t, revoked)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetRevokedCertificates
+	revoked, err = db.GetRevokedCertificates()
+	assert.Nil(t, revoked)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// RevokeBatch
+	assert.Equals(t, ErrNotImplemented, db.RevokeBatch("foo", []byte("{}"), nil))
+
+	// GetRevocationBatch
+	batch, err := db.GetRevocationBatch("foo")
+	assert.Nil(t, batch)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetRevocationBatches
+	batches, err := db.GetRevocationBatches()
+	assert.Nil(t, batches)
+	assert.Equals(t, ErrNotImplemented, err)
+
 	// StoreCertificate
 	assert.Equals(t, ErrNotImplemented, db.StoreCertificate(nil))
 
+	// GetCertificates
+	crts, err := db.GetCertificates()
+	assert.Nil(t, crts)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// StoreCTSubmissions
+	assert.Equals(t, ErrNotImplemented, db.StoreCTSubmissions("foo", []byte("[]")))
+
+	// StoreLintResults
+	assert.Equals(t, ErrNotImplemented, db.StoreLintResults("foo", []byte("[]")))
+
 	// UseToken
 	ok, err := db.UseToken("foo", "bar")
 	assert.True(t, ok)
@@ -29,6 +79,161 @@ func TestSimpleDB(t *testing.T) {
 	assert.False(t, ok)
 	assert.Nil(t, err)
 
+	// GetProvisionerKeys -- verify noop
+	keys, err := db.GetProvisionerKeys("foo")
+	assert.Nil(t, keys)
+	assert.Nil(t, err)
+
+	// StoreProvisionerKeys
+	assert.Equals(t, ErrNotImplemented, db.StoreProvisionerKeys("foo", []byte("[]")))
+
+	// GetProvisioners -- verify noop
+	provisioners, err := db.GetProvisioners()
+	assert.Nil(t, provisioners)
+	assert.Nil(t, err)
+
+	// CmpAndSwapProvisioner
+	ok, err = db.CmpAndSwapProvisioner("foo", nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetAdmins -- verify noop
+	admins, err := db.GetAdmins()
+	assert.Nil(t, admins)
+	assert.Nil(t, err)
+
+	// CmpAndSwapAdmin
+	ok, err = db.CmpAndSwapAdmin("foo", nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetPolicy -- verify noop
+	pol, err := db.GetPolicy()
+	assert.Nil(t, pol)
+	assert.Nil(t, err)
+
+	// CmpAndSwapPolicy
+	ok, err = db.CmpAndSwapPolicy(nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetJob -- verify noop
+	job, err := db.GetJob("foo")
+	assert.Nil(t, job)
+	assert.Nil(t, err)
+
+	// CmpAndSwapJob
+	ok, err = db.CmpAndSwapJob("foo", nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetSubCARequest -- verify noop
+	req, err := db.GetSubCARequest("foo")
+	assert.Nil(t, req)
+	assert.Nil(t, err)
+
+	// GetSubCARequests -- verify noop
+	reqs, err := db.GetSubCARequests()
+	assert.Nil(t, reqs)
+	assert.Nil(t, err)
+
+	// CmpAndSwapSubCARequest
+	ok, err = db.CmpAndSwapSubCARequest("foo", nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetRootRotation -- verify noop
+	rotation, err := db.GetRootRotation()
+	assert.Nil(t, rotation)
+	assert.Nil(t, err)
+
+	// CmpAndSwapRootRotation
+	ok, err = db.CmpAndSwapRootRotation(nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetIntermediate -- verify noop
+	intermediate, err := db.GetIntermediate()
+	assert.Nil(t, intermediate)
+	assert.Nil(t, err)
+
+	// CmpAndSwapIntermediate
+	ok, err = db.CmpAndSwapIntermediate(nil, []byte("{}"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// ReserveSerialNumber
+	ok, err = db.ReserveSerialNumber("1234")
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetSerialCounter -- verify noop
+	counter, err := db.GetSerialCounter()
+	assert.Nil(t, counter)
+	assert.Nil(t, err)
+
+	// CmpAndSwapSerialCounter
+	ok, err = db.CmpAndSwapSerialCounter(nil, []byte("1"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetShortLivedSummary
+	summary, err := db.GetShortLivedSummary("foo")
+	assert.Nil(t, summary)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetShortLivedSummaries
+	summaries, err := db.GetShortLivedSummaries()
+	assert.Nil(t, summaries)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// CmpAndSwapShortLivedSummary
+	ok, err = db.CmpAndSwapShortLivedSummary("foo", nil, []byte("1"))
+	assert.False(t, ok)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// StoreCertificateIndex
+	assert.Equals(t, ErrNotImplemented, db.StoreCertificateIndex("1234", []byte("{}"), []string{"foo"}))
+
+	// DeleteCertificate
+	assert.Equals(t, ErrNotImplemented, db.DeleteCertificate("1234", []string{"foo"}))
+
+	// GetCertificateIndex
+	index, err := db.GetCertificateIndex("1234")
+	assert.Nil(t, index)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetCertificateIndexes
+	indexes, err := db.GetCertificateIndexes()
+	assert.Nil(t, indexes)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetCertificateSerialsByName
+	serials, err := db.GetCertificateSerialsByName("foo")
+	assert.Nil(t, serials)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetSchemaVersion
+	version, err := db.GetSchemaVersion("foo")
+	assert.Equals(t, 0, version)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// SetSchemaVersion
+	assert.Equals(t, ErrNotImplemented, db.SetSchemaVersion("foo", 1))
+
+	// StoreAuditEvent
+	assert.Equals(t, ErrNotImplemented, db.StoreAuditEvent(1, []byte("foo")))
+
+	// GetLastAuditEvent
+	event, err := db.GetLastAuditEvent()
+	assert.Nil(t, event)
+	assert.Equals(t, ErrNotImplemented, err)
+
+	// GetAuditEvents
+	events, err := db.GetAuditEvents()
+	assert.Nil(t, events)
+	assert.Equals(t, ErrNotImplemented, err)
+
 	// Shutdown -- verify noop
 	assert.FatalError(t, db.Shutdown())
 	ok, err = db.UseToken("foo", "cat")
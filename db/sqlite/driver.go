@@ -0,0 +1,51 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening sqlite database")
+	}
+	return db, nil
+}
+
+func backup(ctx context.Context, db *sql.DB, path string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error connecting to sqlite")
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		src, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.Errorf("unexpected sqlite connection type %T", driverConn)
+		}
+		c, err := (&sqlite3.SQLiteDriver{}).Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "error opening %s", path)
+		}
+		dest := c.(*sqlite3.SQLiteConn)
+		defer dest.Close()
+
+		b, err := dest.Backup("main", src, "main")
+		if err != nil {
+			return errors.Wrap(err, "error starting backup")
+		}
+		if _, err := b.Step(-1); err != nil {
+			b.Finish()
+			return errors.Wrap(err, "error copying database")
+		}
+		return errors.Wrap(b.Finish(), "error finishing backup")
+	})
+}
@@ -0,0 +1,19 @@
+//go:build !cgo
+// +build !cgo
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func openDB(dsn string) (*sql.DB, error) {
+	return nil, errors.New("sqlite is not supported: step-ca must be compiled with cgo")
+}
+
+func backup(ctx context.Context, db *sql.DB, path string) error {
+	return errors.New("sqlite is not supported: step-ca must be compiled with cgo")
+}
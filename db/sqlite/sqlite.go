@@ -0,0 +1,397 @@
+// Package sqlite implements the nosql database interface over SQLite, a
+// durable option for single node installations that can be inspected and
+// backed up with the standard SQLite tools.
+//
+// The database uses write-ahead logging, so readers do not block the writer,
+// and transactions take the write lock when they begin, waiting up to the busy
+// timeout if another connection holds it.
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/nosql/database"
+)
+
+// defaultParams are the connection parameters used if the data source does not
+// define them.
+var defaultParams = map[string]string{
+	"_journal_mode": "WAL",
+	"_synchronous":  "NORMAL",
+	"_busy_timeout": "5000",
+	"_txlock":       "immediate",
+}
+
+// validTable is the regular expression used to validate the table names.
+var validTable = regexp.MustCompile(`^[a-zA-Z0-9_]{1,64}$`)
+
+// DB is a SQLite implementation of the nosql database.DB interface.
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database in the given path. The path can be
+// followed by the connection parameters of the driver, e.g.
+// "/var/lib/step/ca.db?_busy_timeout=10000". The database option is not used.
+func (db *DB) Open(dataSourceName string, opt ...database.Option) error {
+	opts := &database.Options{}
+	for _, o := range opt {
+		if err := o(opts); err != nil {
+			return err
+		}
+	}
+
+	dsn, err := formatDSN(dataSourceName)
+	if err != nil {
+		return err
+	}
+	if path := strings.TrimPrefix(strings.SplitN(dataSourceName, "?", 2)[0], "file:"); path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return errors.Wrapf(err, "error creating directory for %s", path)
+		}
+	}
+
+	sqlDB, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return errors.Wrap(err, "error opening sqlite database")
+	}
+	db.db = sqlDB
+	return nil
+}
+
+// formatDSN returns the data source name with the default parameters.
+func formatDSN(dataSourceName string) (string, error) {
+	parts := strings.SplitN(dataSourceName, "?", 2)
+	if parts[0] == "" {
+		return "", errors.New("sqlite dataSource cannot be empty")
+	}
+	params := url.Values{}
+	if len(parts) == 2 {
+		var err error
+		if params, err = url.ParseQuery(parts[1]); err != nil {
+			return "", errors.Wrap(err, "error parsing sqlite dataSource")
+		}
+	}
+	for k, v := range defaultParams {
+		if _, ok := params[k]; !ok {
+			params.Set(k, v)
+		}
+	}
+	path := parts[0]
+	if !strings.HasPrefix(path, "file:") {
+		path = "file:" + path
+	}
+	return path + "?" + params.Encode(), nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return errors.WithStack(db.db.Close())
+}
+
+// Get retrieves the value with the given key.
+func (db *DB) Get(bucket, key []byte) ([]byte, error) {
+	if err := validateTable(bucket); err != nil {
+		return nil, err
+	}
+	return get(db.db, bucket, key)
+}
+
+// Set inserts or updates the value with the given key.
+func (db *DB) Set(bucket, key, value []byte) error {
+	if err := validateTable(bucket); err != nil {
+		return err
+	}
+	return set(db.db, bucket, key, value)
+}
+
+// Del deletes the value with the given key.
+func (db *DB) Del(bucket, key []byte) error {
+	if err := validateTable(bucket); err != nil {
+		return err
+	}
+	return del(db.db, bucket, key)
+}
+
+// List returns all the entries in the given table.
+func (db *DB) List(bucket []byte) ([]*database.Entry, error) {
+	if err := validateTable(bucket); err != nil {
+		return nil, err
+	}
+	return list(context.Background(), db.db, bucket)
+}
+
+// Snapshot returns the entries of the given tables read in one read
+// transaction. With write-ahead logging the transaction sees a consistent
+// snapshot of the database without blocking the writer. The entries of a
+// table that does not exist are nil.
+func (db *DB) Snapshot(buckets [][]byte) ([][]*database.Entry, error) {
+	for _, b := range buckets {
+		if err := validateTable(b); err != nil {
+			return nil, err
+		}
+	}
+
+	// The transactions of the connection pool take the write lock, so a
+	// deferred transaction is started in a dedicated connection.
+	ctx := context.Background()
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting connection")
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN DEFERRED"); err != nil {
+		return nil, errors.Wrap(err, "error starting transaction")
+	}
+	defer conn.ExecContext(ctx, "ROLLBACK")
+
+	snapshot := make([][]*database.Entry, len(buckets))
+	for i, b := range buckets {
+		entries, err := list(ctx, conn, b)
+		switch {
+		case database.IsErrNotFound(err):
+			continue
+		case err != nil:
+			return nil, err
+		case entries == nil:
+			entries = []*database.Entry{}
+		}
+		snapshot[i] = entries
+	}
+	return snapshot, nil
+}
+
+// CmpAndSwap modifies the value at the given bucket and key (to newValue)
+// only if the existing (current) value matches oldValue.
+func (db *DB) CmpAndSwap(bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	if err := validateTable(bucket); err != nil {
+		return nil, false, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	val, swapped, err := cmpAndSwap(tx, bucket, key, oldValue, newValue)
+	switch {
+	case err != nil:
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, false, errors.Wrapf(err, "failed to execute CmpAndSwap transaction on %s/%s and failed to rollback transaction", bucket, key)
+		}
+		return nil, false, err
+	case swapped:
+		if err := tx.Commit(); err != nil {
+			return nil, false, errors.Wrap(err, "failed to commit sqlite transaction")
+		}
+		return val, true, nil
+	default:
+		if err := tx.Rollback(); err != nil {
+			return nil, false, errors.Wrapf(err, "failed to rollback read-only CmpAndSwap transaction on %s/%s", bucket, key)
+		}
+		return val, false, nil
+	}
+}
+
+// Update performs multiple commands on one read-write transaction.
+func (db *DB) Update(txn *database.Tx) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	rollback := func(err error) error {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Wrap(err, "UPDATE failed, unable to rollback transaction")
+		}
+		return errors.Wrap(err, "UPDATE failed")
+	}
+
+	for _, q := range txn.Operations {
+		if err := validateTable(q.Bucket); err != nil {
+			return rollback(err)
+		}
+		switch q.Cmd {
+		case database.CreateTable:
+			if err := createTable(tx, q.Bucket); err != nil {
+				return rollback(err)
+			}
+		case database.DeleteTable:
+			if err := deleteTable(tx, q.Bucket); err != nil {
+				return rollback(err)
+			}
+		case database.Get:
+			if q.Result, err = get(tx, q.Bucket, q.Key); err != nil {
+				return rollback(err)
+			}
+		case database.Set:
+			if err := set(tx, q.Bucket, q.Key, q.Value); err != nil {
+				return rollback(err)
+			}
+		case database.Delete:
+			if err := del(tx, q.Bucket, q.Key); err != nil {
+				return rollback(err)
+			}
+		case database.CmpAndSwap:
+			q.Result, q.Swapped, err = cmpAndSwap(tx, q.Bucket, q.Key, q.CmpValue, q.Value)
+			if err != nil {
+				return rollback(errors.Wrapf(err, "failed to load-or-store %s/%s", q.Bucket, q.Key))
+			}
+		default:
+			return rollback(database.ErrOpNotSupported)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return rollback(errors.WithStack(err))
+	}
+	return nil
+}
+
+// CreateTable creates a table in the database.
+func (db *DB) CreateTable(bucket []byte) error {
+	if err := validateTable(bucket); err != nil {
+		return err
+	}
+	return createTable(db.db, bucket)
+}
+
+// DeleteTable deletes a table in the database.
+func (db *DB) DeleteTable(bucket []byte) error {
+	if err := validateTable(bucket); err != nil {
+		return err
+	}
+	return deleteTable(db.db, bucket)
+}
+
+// Backup writes a consistent copy of the database to the given path using the
+// SQLite online backup API. The database can be used while the backup is
+// running.
+func (db *DB) Backup(ctx context.Context, path string) error {
+	return backup(ctx, db.db, path)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func list(ctx context.Context, q querier, bucket []byte) ([]*database.Entry, error) {
+	rows, err := q.QueryContext(ctx, "SELECT nkey, nvalue FROM "+quoteIdentifier(string(bucket)))
+	if err != nil {
+		if isNoSuchTable(err) {
+			return nil, errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+		}
+		return nil, errors.Wrapf(err, "error querying table %s", bucket)
+	}
+	defer rows.Close()
+
+	var entries []*database.Entry
+	for rows.Next() {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, errors.Wrap(err, "error getting key and value from row")
+		}
+		entries = append(entries, &database.Entry{
+			Bucket: bucket,
+			Key:    key,
+			Value:  value,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error accessing row")
+	}
+	return entries, nil
+}
+
+func createTable(e execer, bucket []byte) error {
+	if _, err := e.Exec("CREATE TABLE IF NOT EXISTS " + quoteIdentifier(string(bucket)) +
+		" (nkey BLOB NOT NULL PRIMARY KEY, nvalue BLOB)"); err != nil {
+		return errors.Wrapf(err, "failed to create table %s", bucket)
+	}
+	return nil
+}
+
+func deleteTable(e execer, bucket []byte) error {
+	if _, err := e.Exec("DROP TABLE " + quoteIdentifier(string(bucket))); err != nil {
+		if isNoSuchTable(err) {
+			return errors.Wrapf(database.ErrNotFound, "table %s not found", bucket)
+		}
+		return errors.Wrapf(err, "failed to delete table %s", bucket)
+	}
+	return nil
+}
+
+func get(e execer, bucket, key []byte) ([]byte, error) {
+	var val []byte
+	err := e.QueryRow("SELECT nvalue FROM "+quoteIdentifier(string(bucket))+" WHERE nkey = ?", key).Scan(&val)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, errors.Wrapf(database.ErrNotFound, "%s/%s not found", bucket, key)
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	default:
+		return val, nil
+	}
+}
+
+func set(e execer, bucket, key, value []byte) error {
+	if _, err := e.Exec("INSERT INTO "+quoteIdentifier(string(bucket))+
+		" (nkey, nvalue) VALUES (?, ?) ON CONFLICT (nkey) DO UPDATE SET nvalue = excluded.nvalue", key, value); err != nil {
+		return errors.Wrapf(err, "failed to set %s/%s", bucket, key)
+	}
+	return nil
+}
+
+func del(e execer, bucket, key []byte) error {
+	if _, err := e.Exec("DELETE FROM "+quoteIdentifier(string(bucket))+" WHERE nkey = ?", key); err != nil {
+		return errors.Wrapf(err, "failed to delete %s/%s", bucket, key)
+	}
+	return nil
+}
+
+// cmpAndSwap compares and swaps the value inside a transaction, the
+// transaction holds the write lock of the database.
+func cmpAndSwap(tx *sql.Tx, bucket, key, oldValue, newValue []byte) ([]byte, bool, error) {
+	var current []byte
+	err := tx.QueryRow("SELECT nvalue FROM "+quoteIdentifier(string(bucket))+" WHERE nkey = ?", key).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, errors.Wrapf(err, "failed to get %s/%s", bucket, key)
+	}
+	if !bytes.Equal(current, oldValue) {
+		return current, false, nil
+	}
+	if err := set(tx, bucket, key, newValue); err != nil {
+		return nil, false, err
+	}
+	return newValue, true, nil
+}
+
+func validateTable(bucket []byte) error {
+	if !validTable.Match(bucket) {
+		return errors.Errorf("invalid table name %q", bucket)
+	}
+	return nil
+}
+
+func quoteIdentifier(s string) string {
+	return `"` + s + `"`
+}
+
+func isNoSuchTable(err error) bool {
+	return strings.HasPrefix(errors.Cause(err).Error(), "no such table")
+}
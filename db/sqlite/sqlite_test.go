@@ -0,0 +1,299 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/smallstep/nosql/database"
+)
+
+func Test_formatDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		dataSource string
+		want       string
+		wantErr    bool
+	}{
+		{"ok", "/var/lib/step/ca.db", "file:/var/lib/step/ca.db?_busy_timeout=5000&_journal_mode=WAL&_synchronous=NORMAL&_txlock=immediate", false},
+		{"ok file", "file:ca.db", "file:ca.db?_busy_timeout=5000&_journal_mode=WAL&_synchronous=NORMAL&_txlock=immediate", false},
+		{"ok params", "ca.db?_busy_timeout=100&cache=shared", "file:ca.db?_busy_timeout=100&_journal_mode=WAL&_synchronous=NORMAL&_txlock=immediate&cache=shared", false},
+		{"fail empty", "", "", true},
+		{"fail params", "ca.db?%zz", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatDSN(tt.dataSource)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("formatDSN() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("formatDSN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  []byte
+		wantErr bool
+	}{
+		{"ok", []byte("x509_certs"), false},
+		{"fail empty", []byte(""), true},
+		{"fail quote", []byte(`x509"; DROP TABLE x509_certs; --`), true},
+		{"fail dash", []byte("x509-certs"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTable(tt.bucket); (err != nil) != tt.wantErr {
+				t.Errorf("validateTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustTempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func mustOpen(t *testing.T, path string) *DB {
+	t.Helper()
+	db := &DB{}
+	if err := db.Open(path); err != nil {
+		t.Fatalf("DB.Open() error = %v", err)
+	}
+	return db
+}
+
+func TestDB(t *testing.T) {
+	dir, cleanup := mustTempDir(t)
+	defer cleanup()
+	db := mustOpen(t, filepath.Join(dir, "db", "ca.db"))
+	defer db.Close()
+
+	var mode string
+	if err := db.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %s, %v, want wal", mode, err)
+	}
+
+	bucket := []byte("test_table")
+	if _, err := db.List(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.List() error = %v, want not found", err)
+	}
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatalf("DB.CreateTable() error = %v", err)
+	}
+	if _, err := db.Get(bucket, []byte("missing")); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Get() error = %v, want not found", err)
+	}
+	if err := db.Set([]byte("bad-table"), []byte("key"), []byte("value")); err == nil {
+		t.Error("DB.Set() error = nil, wantErr true")
+	}
+
+	large := bytes.Repeat([]byte("a"), 1<<20)
+	if err := db.Set(bucket, []byte("key"), large); err != nil {
+		t.Fatalf("DB.Set() error = %v", err)
+	}
+	if got, err := db.Get(bucket, []byte("key")); err != nil || !bytes.Equal(got, large) {
+		t.Errorf("DB.Get() = %d bytes, %v", len(got), err)
+	}
+
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("key"), []byte("other"), []byte("new")); err != nil || swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want false, nil", swapped, err)
+	}
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("key"), large, []byte("new")); err != nil || !swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want true, nil", swapped, err)
+	}
+	if _, swapped, err := db.CmpAndSwap(bucket, []byte("new-key"), nil, []byte("value")); err != nil || !swapped {
+		t.Errorf("DB.CmpAndSwap() = %v, %v, want true, nil", swapped, err)
+	}
+
+	tx := new(database.Tx)
+	tx.Set(bucket, []byte("tx-key"), []byte("tx-value"))
+	tx.Del(bucket, []byte("new-key"))
+	tx.Operations = append(tx.Operations, &database.TxEntry{
+		Bucket: bucket, Key: []byte("key"), CmpValue: []byte("new"), Value: []byte("newer"), Cmd: database.CmpAndSwap,
+	})
+	if err := db.Update(tx); err != nil {
+		t.Fatalf("DB.Update() error = %v", err)
+	}
+	if !tx.Operations[2].Swapped {
+		t.Error("DB.Update() compare and swap was not swapped")
+	}
+
+	// A failed transaction is rolled back.
+	tx = new(database.Tx)
+	tx.Set(bucket, []byte("rollback"), []byte("value"))
+	tx.Get(bucket, []byte("missing"))
+	if err := db.Update(tx); !database.IsErrNotFound(err) {
+		t.Errorf("DB.Update() error = %v, want not found", err)
+	}
+
+	entries, err := db.List(bucket)
+	if err != nil {
+		t.Fatalf("DB.List() error = %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		got[string(e.Key)] = string(e.Value)
+	}
+	if want := map[string]string{"key": "newer", "tx-key": "tx-value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DB.List() = %v, want %v", got, want)
+	}
+
+	if err := db.Del(bucket, []byte("key")); err != nil {
+		t.Errorf("DB.Del() error = %v", err)
+	}
+	if err := db.DeleteTable(bucket); err != nil {
+		t.Errorf("DB.DeleteTable() error = %v", err)
+	}
+	if _, err := db.List(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.List() error = %v, want not found", err)
+	}
+	if err := db.DeleteTable(bucket); !database.IsErrNotFound(err) {
+		t.Errorf("DB.DeleteTable() error = %v, want not found", err)
+	}
+}
+
+// TestDB_concurrent checks that concurrent writers wait for the lock and that
+// a nonce is only used once.
+func TestDB_concurrent(t *testing.T) {
+	dir, cleanup := mustTempDir(t)
+	defer cleanup()
+	db := mustOpen(t, filepath.Join(dir, "ca.db"))
+	defer db.Close()
+
+	bucket := []byte("nonces")
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Set(bucket, []byte(fmt.Sprintf("nonce-%d", i)), []byte("nonce")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	used := map[int]int{}
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			tx := new(database.Tx)
+			tx.Get(bucket, []byte(fmt.Sprintf("nonce-%d", n)))
+			tx.Del(bucket, []byte(fmt.Sprintf("nonce-%d", n)))
+			err := db.Update(tx)
+			switch {
+			case err == nil:
+				mu.Lock()
+				used[n]++
+				mu.Unlock()
+			case !database.IsErrNotFound(err):
+				t.Errorf("DB.Update() error = %v", err)
+			}
+		}(i % 10)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		if used[i] != 1 {
+			t.Errorf("nonce-%d used %d times, want 1", i, used[i])
+		}
+	}
+}
+
+func TestDB_Backup(t *testing.T) {
+	dir, cleanup := mustTempDir(t)
+	defer cleanup()
+	db := mustOpen(t, filepath.Join(dir, "ca.db"))
+	defer db.Close()
+
+	bucket := []byte("x509_certs")
+	if err := db.CreateTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(bucket, []byte("serial"), []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "backup.db")
+	if err := db.Backup(context.Background(), path); err != nil {
+		t.Fatalf("DB.Backup() error = %v", err)
+	}
+	// Changes after the backup are not in the copy.
+	if err := db.Set(bucket, []byte("other"), []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := mustOpen(t, path)
+	defer backup.Close()
+	entries, err := backup.List(bucket)
+	if err != nil {
+		t.Fatalf("DB.List() error = %v", err)
+	}
+	if len(entries) != 1 || string(entries[0].Key) != "serial" || string(entries[0].Value) != "certificate" {
+		t.Errorf("DB.List() = %v, want serial", entries)
+	}
+
+	if err := db.Backup(context.Background(), filepath.Join(dir, "missing", "backup.db")); err == nil {
+		t.Error("DB.Backup() error = nil, wantErr true")
+	}
+}
+
+func TestDB_Snapshot(t *testing.T) {
+	dir, cleanup := mustTempDir(t)
+	defer cleanup()
+	db := mustOpen(t, filepath.Join(dir, "ca.db"))
+	defer db.Close()
+
+	for _, b := range []string{"x509_certs", "empty"} {
+		if err := db.CreateTable([]byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set([]byte("x509_certs"), []byte("serial"), []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := db.Snapshot([][]byte{[]byte("x509_certs"), []byte("empty"), []byte("missing")})
+	if err != nil {
+		t.Fatalf("DB.Snapshot() error = %v", err)
+	}
+	if len(snapshot) != 3 {
+		t.Fatalf("DB.Snapshot() returned %d tables, want 3", len(snapshot))
+	}
+	if len(snapshot[0]) != 1 || string(snapshot[0][0].Key) != "serial" {
+		t.Errorf("DB.Snapshot() x509_certs = %v, want serial", snapshot[0])
+	}
+	if snapshot[1] == nil || len(snapshot[1]) != 0 {
+		t.Errorf("DB.Snapshot() empty = %v, want []", snapshot[1])
+	}
+	if snapshot[2] != nil {
+		t.Errorf("DB.Snapshot() missing = %v, want nil", snapshot[2])
+	}
+
+	// The connection is released and writes are not blocked.
+	if err := db.Set([]byte("x509_certs"), []byte("other"), []byte("certificate")); err != nil {
+		t.Errorf("DB.Set() error = %v", err)
+	}
+	if _, err := db.Snapshot([][]byte{[]byte("bad-table")}); err == nil {
+		t.Error("DB.Snapshot() error = nil, wantErr true")
+	}
+}
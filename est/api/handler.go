@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/est"
+)
+
+// maxRequestSize is the maximum size of an enrollment request.
+const maxRequestSize = 1 << 20
+
+const (
+	contentTypeCerts = "application/pkcs7-mime; smime-type=certs-only"
+	contentTypePKCS8 = "application/pkcs8"
+)
+
+// New returns a new EST API router.
+func New(estAuth *est.Authority) api.RouterHandler {
+	return &Handler{estAuth}
+}
+
+// Handler is the EST request handler.
+type Handler struct {
+	Auth *est.Authority
+}
+
+// Route traffic and implement the Router interface.
+func (h *Handler) Route(r api.Router) {
+	r.MethodFunc("GET", "/cacerts", h.CACerts)
+	r.MethodFunc("POST", "/simpleenroll", h.SimpleEnroll)
+	r.MethodFunc("POST", "/simplereenroll", h.SimpleReenroll)
+	r.MethodFunc("POST", "/serverkeygen", h.ServerKeyGen)
+}
+
+// CACerts returns the intermediate and root certificates.
+func (h *Handler) CACerts(w http.ResponseWriter, r *http.Request) {
+	certs, err := h.Auth.GetCACertificates()
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	h.writeCertificates(w, certs)
+}
+
+// SimpleEnroll signs a certificate request authenticated with a provisioner
+// token sent as the HTTP basic auth password, or with a TLS client
+// certificate issued by the CA.
+func (h *Handler) SimpleEnroll(w http.ResponseWriter, r *http.Request) {
+	csr, err := readCertificateRequest(w, r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	certs, err := h.enroll(w, r, csr)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	h.writeCertificates(w, certs)
+}
+
+// SimpleReenroll renews the TLS client certificate using the key in the
+// certificate request.
+func (h *Handler) SimpleReenroll(w http.ResponseWriter, r *http.Request) {
+	csr, err := readCertificateRequest(w, r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		api.WriteError(w, errs.Unauthorized("est.SimpleReenroll; missing client certificate"))
+		return
+	}
+	certs, err := h.Auth.Reenroll(r.Context(), r.TLS.PeerCertificates[0], csr)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	h.writeCertificates(w, certs)
+}
+
+// ServerKeyGen generates a new key and signs a certificate for it using the
+// subject and subject alternative names in the certificate request. The
+// request is authenticated as in SimpleEnroll.
+func (h *Handler) ServerKeyGen(w http.ResponseWriter, r *http.Request) {
+	csr, err := readCertificateRequest(w, r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	key, csr, err := est.NewServerKey(csr)
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusBadRequest, err, "est.ServerKeyGen"))
+		return
+	}
+	certs, err := h.enroll(w, r, csr)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "est.ServerKeyGen; error marshaling private key"))
+		return
+	}
+	certBytes, err := est.EncodeCertificates(certs)
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "est.ServerKeyGen; error encoding certificates"))
+		return
+	}
+
+	// The response is a multipart message with the private key followed by
+	// the certificate.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{contentTypePKCS8, est.EncodeBase64(keyBytes)},
+		{contentTypeCerts, certBytes},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err == nil {
+			_, err = pw.Write(part.body)
+		}
+		if err != nil {
+			api.WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "est.ServerKeyGen; error writing response"))
+			return
+		}
+	}
+	if err := mw.Close(); err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "est.ServerKeyGen; error writing response"))
+		return
+	}
+	writeResponse(w, "multipart/mixed; boundary="+mw.Boundary(), buf.Bytes())
+}
+
+// enroll authenticates the request with the HTTP basic auth password or the
+// TLS client certificate, in that order, and signs the certificate request.
+func (h *Handler) enroll(w http.ResponseWriter, r *http.Request, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	if _, token, ok := r.BasicAuth(); ok {
+		return h.Auth.Enroll(r.Context(), token, csr)
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return h.Auth.Reenroll(r.Context(), r.TLS.PeerCertificates[0], csr)
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+	return nil, errs.Unauthorized("est; missing credentials")
+}
+
+func (h *Handler) writeCertificates(w http.ResponseWriter, certs []*x509.Certificate) {
+	b, err := est.EncodeCertificates(certs)
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "est; error encoding certificates"))
+		return
+	}
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	writeResponse(w, contentTypeCerts, b)
+}
+
+func readCertificateRequest(w http.ResponseWriter, r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "est; error reading request")
+	}
+	csr, err := est.ParseCertificateRequest(body)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "est; error parsing request")
+	}
+	return csr, nil
+}
+
+func writeResponse(w http.ResponseWriter, contentType string, b []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		api.LogError(w, err)
+	}
+}
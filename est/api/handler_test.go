@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/est"
+	"github.com/smallstep/certificates/pkcs7"
+)
+
+type mockSignAuth struct {
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func (m *mockSignAuth) Authorize(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+	if token != "token" {
+		return nil, errs.Unauthorized("invalid token")
+	}
+	return []provisioner.SignOption{}, nil
+}
+
+func (m *mockSignAuth) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return m.issue(csr.Subject, csr.DNSNames, csr.PublicKey)
+}
+
+func (m *mockSignAuth) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+	return m.issue(oldCert.Subject, oldCert.DNSNames, pk)
+}
+
+func (m *mockSignAuth) GetRootCertificates() []*x509.Certificate {
+	return []*x509.Certificate{m.root}
+}
+
+func (m *mockSignAuth) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	return m.intermediate, m.signer
+}
+
+func (m *mockSignAuth) issue(subject pkix.Name, dnsNames []string, pub crypto.PublicKey) ([]*x509.Certificate, error) {
+	b, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, m.intermediate, pub, m.signer)
+	if err != nil {
+		return nil, err
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.New("error parsing certificate")
+	}
+	return []*x509.Certificate{crt, m.intermediate}, nil
+}
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	return key
+}
+
+func mustSignAuth(t *testing.T) *mockSignAuth {
+	t.Helper()
+	rootKey := mustKey(t)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Root CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, tpl, rootKey.Public(), rootKey)
+	assert.FatalError(t, err)
+	root, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+
+	key := mustKey(t)
+	tpl.SerialNumber = big.NewInt(2)
+	tpl.Subject = pkix.Name{CommonName: "Intermediate CA"}
+	b, err = x509.CreateCertificate(rand.Reader, tpl, root, key.Public(), rootKey)
+	assert.FatalError(t, err)
+	intermediate, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return &mockSignAuth{root: root, intermediate: intermediate, signer: key}
+}
+
+func mustCSR(t *testing.T, cn string, dnsNames ...string) []byte {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, mustKey(t))
+	assert.FatalError(t, err)
+	return est.EncodeBase64(der)
+}
+
+func parseCertificates(t *testing.T, b []byte) []*x509.Certificate {
+	t.Helper()
+	der, err := est.DecodeBase64(b)
+	assert.FatalError(t, err)
+	certs, err := pkcs7.ParseCertificates(der)
+	assert.FatalError(t, err)
+	return certs
+}
+
+func TestHandler(t *testing.T) {
+	sa := mustSignAuth(t)
+	mux := chi.NewRouter()
+	New(est.NewAuthority(sa)).Route(mux)
+
+	chain, err := sa.issue(pkix.Name{CommonName: "device"}, []string{"device.example.com"}, mustKey(t).Public())
+	assert.FatalError(t, err)
+	clientTLS := &tls.ConnectionState{PeerCertificates: chain}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        []byte
+		token       string
+		tls         *tls.ConnectionState
+		status      int
+		contentType string
+	}{
+		{"ok cacerts", "GET", "/cacerts", nil, "", nil, 200, contentTypeCerts},
+		{"ok simpleenroll", "POST", "/simpleenroll", mustCSR(t, "device", "device.example.com"), "token", nil, 200, contentTypeCerts},
+		{"ok simpleenroll tls", "POST", "/simpleenroll", mustCSR(t, "device", "device.example.com"), "", clientTLS, 200, contentTypeCerts},
+		{"ok simplereenroll", "POST", "/simplereenroll", mustCSR(t, "device", "device.example.com"), "", clientTLS, 200, contentTypeCerts},
+		{"ok serverkeygen", "POST", "/serverkeygen", mustCSR(t, "device", "device.example.com"), "token", nil, 200, "multipart/mixed"},
+		{"fail simpleenroll credentials", "POST", "/simpleenroll", mustCSR(t, "device"), "", nil, 401, "application/json"},
+		{"fail simpleenroll token", "POST", "/simpleenroll", mustCSR(t, "device"), "bad-token", nil, 401, "application/json"},
+		{"fail simpleenroll csr", "POST", "/simpleenroll", []byte("foo"), "token", nil, 400, "application/json"},
+		{"fail simplereenroll tls", "POST", "/simplereenroll", mustCSR(t, "device", "device.example.com"), "token", nil, 401, "application/json"},
+		{"fail simplereenroll identity", "POST", "/simplereenroll", mustCSR(t, "other", "device.example.com"), "", clientTLS, 403, "application/json"},
+		{"fail serverkeygen credentials", "POST", "/serverkeygen", mustCSR(t, "device"), "", nil, 401, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://ca.smallstep.com"+tt.target, bytes.NewReader(tt.body))
+			if tt.token != "" {
+				req.SetBasicAuth("device", tt.token)
+			}
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			res := w.Result()
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			assert.Equals(t, tt.status, res.StatusCode)
+			mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+			assert.FatalError(t, err)
+			switch mediaType {
+			case "application/pkcs7-mime":
+				assert.Equals(t, tt.contentType, res.Header.Get("Content-Type"))
+				certs := parseCertificates(t, body)
+				if tt.target == "/cacerts" {
+					assert.Equals(t, []*x509.Certificate{sa.intermediate, sa.root}, certs)
+				} else {
+					assert.Equals(t, "device", certs[0].Subject.CommonName)
+					assert.Equals(t, sa.intermediate, certs[1])
+				}
+			case "multipart/mixed":
+				assert.Equals(t, tt.contentType, mediaType)
+				mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+				part, err := mr.NextPart()
+				assert.FatalError(t, err)
+				assert.Equals(t, contentTypePKCS8, part.Header.Get("Content-Type"))
+				b, err := ioutil.ReadAll(part)
+				assert.FatalError(t, err)
+				der, err := est.DecodeBase64(b)
+				assert.FatalError(t, err)
+				key, err := x509.ParsePKCS8PrivateKey(der)
+				assert.FatalError(t, err)
+
+				part, err = mr.NextPart()
+				assert.FatalError(t, err)
+				assert.Equals(t, contentTypeCerts, part.Header.Get("Content-Type"))
+				b, err = ioutil.ReadAll(part)
+				assert.FatalError(t, err)
+				certs := parseCertificates(t, b)
+				assert.Equals(t, key.(crypto.Signer).Public(), certs[0].PublicKey)
+			default:
+				assert.Equals(t, tt.contentType, mediaType)
+				if tt.status == 401 && tt.token == "" && tt.tls == nil {
+					assert.Equals(t, `Basic realm="est"`, res.Header.Get("WWW-Authenticate"))
+				}
+			}
+		})
+	}
+}
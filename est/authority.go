@@ -0,0 +1,101 @@
+package est
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// SignAuthority is the interface implemented by a CA authority.
+type SignAuthority interface {
+	Authorize(ctx context.Context, token string) ([]provisioner.SignOption, error)
+	Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error)
+	GetRootCertificates() []*x509.Certificate
+	GetIntermediate() (*x509.Certificate, crypto.Signer)
+}
+
+// Authority is the layer that handles all EST interactions.
+type Authority struct {
+	signAuth SignAuthority
+}
+
+// NewAuthority returns a new EST authority.
+func NewAuthority(signAuth SignAuthority) *Authority {
+	return &Authority{
+		signAuth: signAuth,
+	}
+}
+
+// GetCACertificates returns the certificates sent in the /cacerts operation,
+// the intermediate followed by the roots.
+func (a *Authority) GetCACertificates() ([]*x509.Certificate, error) {
+	intermediate, _ := a.signAuth.GetIntermediate()
+	if intermediate == nil {
+		return nil, errs.InternalServer("est.GetCACertificates; intermediate is not configured")
+	}
+	return append([]*x509.Certificate{intermediate}, a.signAuth.GetRootCertificates()...), nil
+}
+
+// Enroll signs the certificate request using the provisioner that issued the
+// given token. The token is a one-time token of any of the provisioners that
+// use them, and it is sent by EST clients as the HTTP basic auth password.
+func (a *Authority) Enroll(ctx context.Context, token string, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SignMethod)
+	signOpts, err := a.signAuth.Authorize(ctx, token)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "est.Enroll")
+	}
+	certs, err := a.signAuth.Sign(csr, provisioner.Options{}, signOpts...)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "est.Enroll")
+	}
+	return certs, nil
+}
+
+// Reenroll issues a new certificate for the key in the request using a
+// certificate issued by the CA, usually the TLS client certificate. The
+// subject and subject alternative names of the request must match the ones in
+// the certificate, and the renewal must be allowed by the provisioner that
+// issued it.
+func (a *Authority) Reenroll(ctx context.Context, cert *x509.Certificate, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	if err := a.verifyCertificate(cert); err != nil {
+		return nil, errs.Wrap(http.StatusUnauthorized, err, "est.Reenroll; certificate is not issued by the CA")
+	}
+	if err := matchIdentity(csr, cert); err != nil {
+		return nil, errs.Wrap(http.StatusForbidden, err, "est.Reenroll")
+	}
+	certs, err := a.signAuth.Rekey(cert, csr.PublicKey)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "est.Reenroll")
+	}
+	return certs, nil
+}
+
+// verifyCertificate verifies that the given certificate is a valid
+// certificate issued by the CA.
+func (a *Authority) verifyCertificate(cert *x509.Certificate) error {
+	intermediate, _ := a.signAuth.GetIntermediate()
+	if intermediate == nil || !bytes.Equal(cert.RawIssuer, intermediate.RawSubject) {
+		return errs.Unauthorized("est.verifyCertificate; certificate issuer does not match the intermediate")
+	}
+	roots := x509.NewCertPool()
+	for _, crt := range a.signAuth.GetRootCertificates() {
+		roots.AddCert(crt)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
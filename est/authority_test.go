@@ -0,0 +1,231 @@
+package est
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+type mockSignAuth struct {
+	authorize    func(ctx context.Context, token string) ([]provisioner.SignOption, error)
+	sign         func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	rekey        func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error)
+	roots        []*x509.Certificate
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func (m *mockSignAuth) Authorize(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+	if m.authorize != nil {
+		return m.authorize(ctx, token)
+	}
+	return nil, errs.Unauthorized("not authorized")
+}
+
+func (m *mockSignAuth) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	if m.sign != nil {
+		return m.sign(csr, signOpts, extraOpts...)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) Rekey(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+	if m.rekey != nil {
+		return m.rekey(oldCert, pk)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) GetRootCertificates() []*x509.Certificate {
+	return m.roots
+}
+
+func (m *mockSignAuth) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	return m.intermediate, m.signer
+}
+
+type testCA struct {
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	return key
+}
+
+func mustCertificate(t *testing.T, tpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	if tpl.SerialNumber == nil {
+		tpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	if tpl.NotBefore.IsZero() {
+		tpl.NotBefore = time.Now().Add(-time.Minute)
+		tpl.NotAfter = time.Now().Add(time.Hour)
+	}
+	if parent == nil {
+		parent = tpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, signer)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return crt
+}
+
+func mustCA(t *testing.T) *testCA {
+	t.Helper()
+	rootKey := mustKey(t)
+	root := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Root CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, rootKey.Public(), rootKey)
+	key := mustKey(t)
+	intermediate := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Intermediate CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root, key.Public(), rootKey)
+	return &testCA{root: root, intermediate: intermediate, signer: key}
+}
+
+func (ca *testCA) mustLeaf(t *testing.T, cn string, dnsNames ...string) *x509.Certificate {
+	t.Helper()
+	key := mustKey(t)
+	return mustCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, ca.intermediate, key.Public(), ca.signer)
+}
+
+func mustCSR(t *testing.T, cn string, dnsNames ...string) *x509.CertificateRequest {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, mustKey(t))
+	assert.FatalError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.FatalError(t, err)
+	return csr
+}
+
+func TestAuthority_GetCACertificates(t *testing.T) {
+	ca := mustCA(t)
+	a := NewAuthority(&mockSignAuth{roots: []*x509.Certificate{ca.root}, intermediate: ca.intermediate, signer: ca.signer})
+	certs, err := a.GetCACertificates()
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{ca.intermediate, ca.root}, certs)
+
+	a = NewAuthority(&mockSignAuth{roots: []*x509.Certificate{ca.root}})
+	_, err = a.GetCACertificates()
+	assert.Error(t, err)
+}
+
+func TestAuthority_Enroll(t *testing.T) {
+	ca := mustCA(t)
+	csr := mustCSR(t, "device", "device.example.com")
+	leaf := ca.mustLeaf(t, "device", "device.example.com")
+
+	tests := []struct {
+		name      string
+		authorize func(ctx context.Context, token string) ([]provisioner.SignOption, error)
+		sign      func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+		want      []*x509.Certificate
+		code      int
+	}{
+		{"ok", func(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+			assert.Equals(t, provisioner.SignMethod, provisioner.MethodFromContext(ctx))
+			assert.Equals(t, "the-token", token)
+			return []provisioner.SignOption{}, nil
+		}, func(cr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			assert.Equals(t, csr, cr)
+			return []*x509.Certificate{leaf, ca.intermediate}, nil
+		}, []*x509.Certificate{leaf, ca.intermediate}, 0},
+		{"fail authorize", nil, nil, nil, http.StatusUnauthorized},
+		{"fail sign", func(ctx context.Context, token string) ([]provisioner.SignOption, error) {
+			return []provisioner.SignOption{}, nil
+		}, func(cr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			return nil, errs.Forbidden("not allowed")
+		}, nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthority(&mockSignAuth{authorize: tt.authorize, sign: tt.sign})
+			got, err := a.Enroll(context.Background(), "the-token", csr)
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func TestAuthority_Reenroll(t *testing.T) {
+	ca := mustCA(t)
+	otherCA := mustCA(t)
+	leaf := ca.mustLeaf(t, "device", "device.example.com")
+	csr := mustCSR(t, "device", "device.example.com")
+
+	tests := []struct {
+		name  string
+		cert  *x509.Certificate
+		csr   *x509.CertificateRequest
+		rekey func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error)
+		code  int
+	}{
+		{"ok", leaf, csr, func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+			assert.Equals(t, leaf, oldCert)
+			assert.Equals(t, csr.PublicKey, pk)
+			return []*x509.Certificate{leaf, ca.intermediate}, nil
+		}, 0},
+		{"fail other ca", otherCA.mustLeaf(t, "device", "device.example.com"), csr, nil, http.StatusUnauthorized},
+		{"fail subject", leaf, mustCSR(t, "other", "device.example.com"), nil, http.StatusForbidden},
+		{"fail dns names", leaf, mustCSR(t, "device", "device.example.com", "other.example.com"), nil, http.StatusForbidden},
+		{"fail rekey", leaf, csr, func(oldCert *x509.Certificate, pk crypto.PublicKey) ([]*x509.Certificate, error) {
+			return nil, errs.Unauthorized("renew is disabled")
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthority(&mockSignAuth{
+				rekey:        tt.rekey,
+				roots:        []*x509.Certificate{ca.root},
+				intermediate: ca.intermediate,
+				signer:       ca.signer,
+			})
+			got, err := a.Reenroll(context.Background(), tt.cert, tt.csr)
+			if tt.code != 0 {
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tt.code, sc.StatusCode())
+				return
+			}
+			assert.FatalError(t, err)
+			assert.Equals(t, []*x509.Certificate{leaf, ca.intermediate}, got)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Package est implements the Enrollment over Secure Transport (EST) protocol
+// defined in RFC 7030.
+package est
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/pkcs7"
+)
+
+// minRSAKeyBits is the minimum size of the RSA keys generated by the server.
+const minRSAKeyBits = 2048
+
+// DecodeBase64 decodes a base64 encoded body, EST clients usually split the
+// content in lines.
+func DecodeBase64(b []byte) ([]byte, error) {
+	b = bytes.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		default:
+			return r
+		}
+	}, b)
+	der := make([]byte, base64.StdEncoding.DecodedLen(len(b)))
+	n, err := base64.StdEncoding.Decode(der, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding base64 content")
+	}
+	return der[:n], nil
+}
+
+// EncodeBase64 encodes the given bytes in base64 using lines of 64
+// characters.
+func EncodeBase64(b []byte) []byte {
+	s := base64.StdEncoding.EncodeToString(b)
+	var buf bytes.Buffer
+	for len(s) > 64 {
+		buf.WriteString(s[:64])
+		buf.WriteString("\r\n")
+		s = s[64:]
+	}
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// ParseCertificateRequest parses a base64 encoded PKCS #10 request and
+// verifies its signature.
+func ParseCertificateRequest(body []byte) (*x509.CertificateRequest, error) {
+	der, err := DecodeBase64(body)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate request")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.Wrap(err, "error verifying certificate request")
+	}
+	return csr, nil
+}
+
+// EncodeCertificates returns the base64 encoded certs-only PKCS #7 with the
+// given certificates.
+func EncodeCertificates(certs []*x509.Certificate) ([]byte, error) {
+	b, err := pkcs7.DegenerateCertificates(certs)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeBase64(b), nil
+}
+
+// NewServerKey generates a new key of the same type and size as the public
+// key in the request, and returns it with a copy of the request signed by the
+// new key.
+func NewServerKey(csr *x509.CertificateRequest) (crypto.Signer, *x509.CertificateRequest, error) {
+	var key crypto.Signer
+	var err error
+	switch pub := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		key, err = ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case *rsa.PublicKey:
+		bits := pub.N.BitLen()
+		if bits < minRSAKeyBits {
+			bits = minRSAKeyBits
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case ed25519.PublicKey:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, errors.Errorf("unsupported public key type %T", pub)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error generating key")
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         csr.Subject,
+		DNSNames:        csr.DNSNames,
+		EmailAddresses:  csr.EmailAddresses,
+		IPAddresses:     csr.IPAddresses,
+		URIs:            csr.URIs,
+		ExtraExtensions: csr.ExtraExtensions,
+	}, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating certificate request")
+	}
+	cr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing certificate request")
+	}
+	return key, cr, nil
+}
+
+// matchIdentity returns an error if the subject and subject alternative names
+// of the request are not the same as the ones in the certificate.
+func matchIdentity(csr *x509.CertificateRequest, cert *x509.Certificate) error {
+	if csr.Subject.String() != cert.Subject.String() {
+		return errors.Errorf("certificate request subject '%s' does not match '%s'", csr.Subject, cert.Subject)
+	}
+	if !equalStrings(csr.DNSNames, cert.DNSNames) {
+		return errors.Errorf("certificate request dns names %v do not match %v", csr.DNSNames, cert.DNSNames)
+	}
+	if !equalStrings(csr.EmailAddresses, cert.EmailAddresses) {
+		return errors.Errorf("certificate request email addresses %v do not match %v", csr.EmailAddresses, cert.EmailAddresses)
+	}
+	var csrIPs, certIPs []string
+	for _, ip := range csr.IPAddresses {
+		csrIPs = append(csrIPs, ip.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	if !equalStrings(csrIPs, certIPs) {
+		return errors.Errorf("certificate request ip addresses %v do not match %v", csrIPs, certIPs)
+	}
+	var csrURIs, certURIs []string
+	for _, u := range csr.URIs {
+		csrURIs = append(csrURIs, u.String())
+	}
+	for _, u := range cert.URIs {
+		certURIs = append(certURIs, u.String())
+	}
+	if !equalStrings(csrURIs, certURIs) {
+		return errors.Errorf("certificate request uris %v do not match %v", csrURIs, certURIs)
+	}
+	return nil
+}
+
+// equalStrings returns true if both slices have the same elements regardless
+// of the order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
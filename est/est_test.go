@@ -0,0 +1,183 @@
+package est
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/pkcs7"
+)
+
+func TestBase64(t *testing.T) {
+	b := make([]byte, 200)
+	_, err := rand.Read(b)
+	assert.FatalError(t, err)
+
+	encoded := EncodeBase64(b)
+	for _, line := range bytes.Split(bytes.TrimSuffix(encoded, []byte("\r\n")), []byte("\r\n")) {
+		assert.True(t, len(line) <= 64)
+	}
+	decoded, err := DecodeBase64(encoded)
+	assert.FatalError(t, err)
+	assert.Equals(t, b, decoded)
+
+	_, err = DecodeBase64([]byte("%%%"))
+	assert.Error(t, err)
+}
+
+func TestParseCertificateRequest(t *testing.T) {
+	csr := mustCSR(t, "device", "device.example.com")
+	got, err := ParseCertificateRequest(EncodeBase64(csr.Raw))
+	assert.FatalError(t, err)
+	assert.Equals(t, csr.Raw, got.Raw)
+
+	_, err = ParseCertificateRequest(EncodeBase64([]byte("foo")))
+	assert.Error(t, err)
+
+	// Corrupt the signature.
+	raw := append([]byte(nil), csr.Raw...)
+	raw[len(raw)-1]++
+	_, err = ParseCertificateRequest(EncodeBase64(raw))
+	assert.Error(t, err)
+}
+
+func TestEncodeCertificates(t *testing.T) {
+	ca := mustCA(t)
+	b, err := EncodeCertificates([]*x509.Certificate{ca.intermediate, ca.root})
+	assert.FatalError(t, err)
+	der, err := DecodeBase64(b)
+	assert.FatalError(t, err)
+	certs, err := pkcs7.ParseCertificates(der)
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{ca.intermediate, ca.root}, certs)
+}
+
+func TestNewServerKey(t *testing.T) {
+	ecKey := mustKey(t)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.FatalError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.FatalError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.FatalError(t, err)
+
+	tpl := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "device"},
+		DNSNames:       []string{"device.example.com"},
+		EmailAddresses: []string{"device@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{{Scheme: "urn", Opaque: "device:1"}},
+	}
+	tests := []struct {
+		name  string
+		key   interface{}
+		check func(t *testing.T, pub interface{})
+	}{
+		{"ec", ecKey, func(t *testing.T, pub interface{}) {
+			assert.Equals(t, elliptic.P256(), pub.(*ecdsa.PublicKey).Curve)
+		}},
+		{"ec p384", p384Key, func(t *testing.T, pub interface{}) {
+			assert.Equals(t, elliptic.P384(), pub.(*ecdsa.PublicKey).Curve)
+		}},
+		{"rsa", rsaKey, func(t *testing.T, pub interface{}) {
+			assert.Equals(t, 2048, pub.(*rsa.PublicKey).N.BitLen())
+		}},
+		{"ed25519", edKey, func(t *testing.T, pub interface{}) {
+			_, ok := pub.(ed25519.PublicKey)
+			assert.True(t, ok)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.CreateCertificateRequest(rand.Reader, tpl, tt.key)
+			assert.FatalError(t, err)
+			csr, err := x509.ParseCertificateRequest(der)
+			assert.FatalError(t, err)
+
+			key, got, err := NewServerKey(csr)
+			assert.FatalError(t, err)
+			assert.FatalError(t, got.CheckSignature())
+			assert.Equals(t, key.Public(), got.PublicKey)
+			assert.NotEquals(t, csr.PublicKey, got.PublicKey)
+			tt.check(t, got.PublicKey)
+			assert.Equals(t, csr.Subject.String(), got.Subject.String())
+			assert.Equals(t, csr.DNSNames, got.DNSNames)
+			assert.Equals(t, csr.EmailAddresses, got.EmailAddresses)
+			assert.Equals(t, csr.IPAddresses, got.IPAddresses)
+			assert.Equals(t, csr.URIs, got.URIs)
+		})
+	}
+}
+
+func Test_matchIdentity(t *testing.T) {
+	ca := mustCA(t)
+	key := mustKey(t)
+	cert := mustCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "device"},
+		DNSNames:    []string{"a.example.com", "b.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}, ca.intermediate, key.Public(), ca.signer)
+
+	newCSR := func(tpl *x509.CertificateRequest) *x509.CertificateRequest {
+		der, err := x509.CreateCertificateRequest(rand.Reader, tpl, key)
+		assert.FatalError(t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		assert.FatalError(t, err)
+		return csr
+	}
+
+	tests := []struct {
+		name    string
+		csr     *x509.CertificateRequest
+		wantErr bool
+	}{
+		{"ok", newCSR(&x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: "device"},
+			DNSNames:    []string{"b.example.com", "a.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}), false},
+		{"fail subject", newCSR(&x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: "device", Organization: []string{"Acme"}},
+			DNSNames:    []string{"a.example.com", "b.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}), true},
+		{"fail dns", newCSR(&x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: "device"},
+			DNSNames:    []string{"a.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		}), true},
+		{"fail ip", newCSR(&x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: "device"},
+			DNSNames:    []string{"a.example.com", "b.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.2")},
+		}), true},
+		{"fail email", newCSR(&x509.CertificateRequest{
+			Subject:        pkix.Name{CommonName: "device"},
+			DNSNames:       []string{"a.example.com", "b.example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+			EmailAddresses: []string{"device@example.com"},
+		}), true},
+		{"fail uri", newCSR(&x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: "device"},
+			DNSNames:    []string{"a.example.com", "b.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			URIs:        []*url.URL{{Scheme: "urn", Opaque: "device:1"}},
+		}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := matchIdentity(tt.csr, cert); (err != nil) != tt.wantErr {
+				t.Errorf("matchIdentity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
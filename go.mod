@@ -5,23 +5,34 @@ go 1.13
 require (
 	cloud.google.com/go v0.51.0
 	github.com/Masterminds/sprig/v3 v3.0.0
+	github.com/aws/aws-sdk-go v1.25.43
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/go-chi/chi v4.0.2+incompatible
-	github.com/google/go-cmp v0.4.0 // indirect
+	github.com/go-sql-driver/mysql v1.4.1
+	github.com/golang/protobuf v1.3.4
 	github.com/googleapis/gax-go/v2 v2.0.5
+	github.com/mattn/go-sqlite3 v1.13.0
 	github.com/newrelic/go-agent v2.15.0+incompatible
 	github.com/pkg/errors v0.8.1
+	github.com/prometheus/client_golang v1.5.1
 	github.com/rs/xid v1.2.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/smallstep/assert v0.0.0-20200103212524-b99dc1097b15
 	github.com/smallstep/cli v0.14.0-rc.3
 	github.com/smallstep/nosql v0.2.0
 	github.com/urfave/cli v1.22.2
+	go.etcd.io/etcd v3.3.18+incompatible
+	go.opentelemetry.io/otel v0.4.3
+	go.opentelemetry.io/otel/exporters/otlp v0.4.3
 	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59
 	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
 	google.golang.org/api v0.15.0
 	google.golang.org/genproto v0.0.0-20191230161307-f3c370f40bfb
-	google.golang.org/grpc v1.26.0
+	google.golang.org/grpc v1.27.1
 	gopkg.in/square/go-jose.v2 v2.4.0
 )
 
 //replace github.com/smallstep/cli => ../cli
+
+// The etcd v3.3 client does not build with newer versions of grpc.
+replace google.golang.org/grpc => google.golang.org/grpc v1.26.0
@@ -0,0 +1,119 @@
+package grpcapi
+
+import "github.com/golang/protobuf/proto"
+
+// The messages in this file implement the wire format defined in
+// grpcapi.proto.
+
+// SignRequest is the request of the Sign method.
+type SignRequest struct {
+	Csr          []byte `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	Ott          string `protobuf:"bytes,2,opt,name=ott,proto3" json:"ott,omitempty"`
+	NotBefore    string `protobuf:"bytes,3,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter     string `protobuf:"bytes,4,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	Intermediate string `protobuf:"bytes,5,opt,name=intermediate,proto3" json:"intermediate,omitempty"`
+	Profile      string `protobuf:"bytes,6,opt,name=profile,proto3" json:"profile,omitempty"`
+}
+
+func (m *SignRequest) Reset()         { *m = SignRequest{} }
+func (m *SignRequest) String() string { return proto.CompactTextString(m) }
+func (*SignRequest) ProtoMessage()    {}
+
+// SignResponse is the response of the Sign, Renew and RenewStream methods.
+type SignResponse struct {
+	CertChain [][]byte `protobuf:"bytes,1,rep,name=cert_chain,json=certChain,proto3" json:"cert_chain,omitempty"`
+	Receipt   string   `protobuf:"bytes,2,opt,name=receipt,proto3" json:"receipt,omitempty"`
+}
+
+func (m *SignResponse) Reset()         { *m = SignResponse{} }
+func (m *SignResponse) String() string { return proto.CompactTextString(m) }
+func (*SignResponse) ProtoMessage()    {}
+
+// RenewRequest is the request of the Renew and RenewStream methods.
+type RenewRequest struct {
+	Token string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+}
+
+func (m *RenewRequest) Reset()         { *m = RenewRequest{} }
+func (m *RenewRequest) String() string { return proto.CompactTextString(m) }
+func (*RenewRequest) ProtoMessage()    {}
+
+// RevokeRequest is the request of the Revoke method.
+type RevokeRequest struct {
+	Serial     string `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	Ott        string `protobuf:"bytes,2,opt,name=ott,proto3" json:"ott,omitempty"`
+	ReasonCode int32  `protobuf:"varint,3,opt,name=reason_code,json=reasonCode,proto3" json:"reason_code,omitempty"`
+	Reason     string `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	Passive    bool   `protobuf:"varint,5,opt,name=passive,proto3" json:"passive,omitempty"`
+}
+
+func (m *RevokeRequest) Reset()         { *m = RevokeRequest{} }
+func (m *RevokeRequest) String() string { return proto.CompactTextString(m) }
+func (*RevokeRequest) ProtoMessage()    {}
+
+// RevokeResponse is the response of the Revoke method.
+type RevokeResponse struct {
+	Status string `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+}
+
+func (m *RevokeResponse) Reset()         { *m = RevokeResponse{} }
+func (m *RevokeResponse) String() string { return proto.CompactTextString(m) }
+func (*RevokeResponse) ProtoMessage()    {}
+
+// SSHSignRequest is the request of the SSHSign method.
+type SSHSignRequest struct {
+	PublicKey        []byte   `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	Ott              string   `protobuf:"bytes,2,opt,name=ott,proto3" json:"ott,omitempty"`
+	CertType         string   `protobuf:"bytes,3,opt,name=cert_type,json=certType,proto3" json:"cert_type,omitempty"`
+	KeyID            string   `protobuf:"bytes,4,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Principals       []string `protobuf:"bytes,5,rep,name=principals,proto3" json:"principals,omitempty"`
+	ValidAfter       string   `protobuf:"bytes,6,opt,name=valid_after,json=validAfter,proto3" json:"valid_after,omitempty"`
+	ValidBefore      string   `protobuf:"bytes,7,opt,name=valid_before,json=validBefore,proto3" json:"valid_before,omitempty"`
+	AddUserPublicKey []byte   `protobuf:"bytes,8,opt,name=add_user_public_key,json=addUserPublicKey,proto3" json:"add_user_public_key,omitempty"`
+}
+
+func (m *SSHSignRequest) Reset()         { *m = SSHSignRequest{} }
+func (m *SSHSignRequest) String() string { return proto.CompactTextString(m) }
+func (*SSHSignRequest) ProtoMessage()    {}
+
+// SSHSignResponse is the response of the SSHSign method.
+type SSHSignResponse struct {
+	Certificate        []byte `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	AddUserCertificate []byte `protobuf:"bytes,2,opt,name=add_user_certificate,json=addUserCertificate,proto3" json:"add_user_certificate,omitempty"`
+}
+
+func (m *SSHSignResponse) Reset()         { *m = SSHSignResponse{} }
+func (m *SSHSignResponse) String() string { return proto.CompactTextString(m) }
+func (*SSHSignResponse) ProtoMessage()    {}
+
+// RootsRequest is the request of the Roots method.
+type RootsRequest struct{}
+
+func (m *RootsRequest) Reset()         { *m = RootsRequest{} }
+func (m *RootsRequest) String() string { return proto.CompactTextString(m) }
+func (*RootsRequest) ProtoMessage()    {}
+
+// RootsResponse is the response of the Roots method.
+type RootsResponse struct {
+	Certificates [][]byte `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
+}
+
+func (m *RootsResponse) Reset()         { *m = RootsResponse{} }
+func (m *RootsResponse) String() string { return proto.CompactTextString(m) }
+func (*RootsResponse) ProtoMessage()    {}
+
+// FederationRequest is the request of the Federation method.
+type FederationRequest struct{}
+
+func (m *FederationRequest) Reset()         { *m = FederationRequest{} }
+func (m *FederationRequest) String() string { return proto.CompactTextString(m) }
+func (*FederationRequest) ProtoMessage()    {}
+
+// FederationResponse is the response of the Federation method.
+type FederationResponse struct {
+	Certificates [][]byte `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
+}
+
+func (m *FederationResponse) Reset()         { *m = FederationResponse{} }
+func (m *FederationResponse) String() string { return proto.CompactTextString(m) }
+func (*FederationResponse) ProtoMessage()    {}
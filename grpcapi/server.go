@@ -0,0 +1,375 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Authority is the interface implemented by the CA authority used by the gRPC
+// server, it's a subset of api.Authority.
+type Authority interface {
+	Authorize(ctx context.Context, ott string) ([]provisioner.SignOption, error)
+	Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	Renew(peer *x509.Certificate) ([]*x509.Certificate, error)
+	AuthorizeRenewToken(ctx context.Context, ott string) (*x509.Certificate, error)
+	Revoke(context.Context, *authority.RevokeOptions) error
+	SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
+	SignSSHAddUser(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
+	GetRoots() ([]*x509.Certificate, error)
+	GetFederation() ([]*x509.Certificate, error)
+	CreateReceipt(cert *x509.Certificate, token string) (string, error)
+}
+
+// renewAfter returns the time to wait before renewing the given certificate in
+// a RenewStream call, two thirds of its lifetime.
+func renewAfter(cert *x509.Certificate) time.Duration {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return time.Until(cert.NotBefore.Add(lifetime * 2 / 3))
+}
+
+// Server implements the CertificateAuthority gRPC service using the given
+// authority. The authority can be replaced on reloads without restarting the
+// gRPC server.
+type Server struct {
+	mu         sync.RWMutex
+	auth       Authority
+	renewAfter func(*x509.Certificate) time.Duration
+}
+
+// NewServer creates a new Server using the given authority.
+func NewServer(auth Authority) *Server {
+	return &Server{
+		auth:       auth,
+		renewAfter: renewAfter,
+	}
+}
+
+// Reload replaces the authority used by the server.
+func (s *Server) Reload(auth Authority) {
+	s.mu.Lock()
+	s.auth = auth
+	s.mu.Unlock()
+}
+
+func (s *Server) getAuthority() Authority {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.auth
+}
+
+// Sign implements the Sign method, it creates a new certificate using a
+// certificate request in ASN.1 DER form and a one-time token.
+func (s *Server) Sign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	csr, err := x509.ParseCertificateRequest(req.Csr)
+	if err != nil {
+		return nil, toStatus(errs.Wrap(http.StatusBadRequest, err, "error parsing csr"))
+	}
+	body := &api.SignRequest{
+		CsrPEM:       api.CertificateRequest{CertificateRequest: csr},
+		OTT:          req.Ott,
+		Intermediate: req.Intermediate,
+		Profile:      req.Profile,
+	}
+	if body.NotBefore, err = parseTimeDuration("not_before", req.NotBefore); err != nil {
+		return nil, toStatus(err)
+	}
+	if body.NotAfter, err = parseTimeDuration("not_after", req.NotAfter); err != nil {
+		return nil, toStatus(err)
+	}
+	if err := body.Validate(); err != nil {
+		return nil, toStatus(err)
+	}
+
+	auth := s.getAuthority()
+	signOpts, err := auth.Authorize(provisioner.NewContextWithMethod(ctx, provisioner.SignMethod), req.Ott)
+	if err != nil {
+		return nil, toStatus(errs.UnauthorizedErr(err))
+	}
+	certChain, err := auth.Sign(csr, provisioner.Options{
+		NotBefore:    body.NotBefore,
+		NotAfter:     body.NotAfter,
+		Intermediate: body.Intermediate,
+		Profile:      body.Profile,
+	}, signOpts...)
+	if err != nil {
+		return nil, toStatus(errs.ForbiddenErr(err))
+	}
+	return signResponse(auth, certChain, req.Ott)
+}
+
+// Renew implements the Renew method, it renews the client certificate of the
+// mTLS connection or the one in the renew token.
+func (s *Server) Renew(ctx context.Context, req *RenewRequest) (*SignResponse, error) {
+	auth := s.getAuthority()
+	cert, err := getPeerCertificate(ctx, auth, req.Token)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	certChain, err := auth.Renew(cert)
+	if err != nil {
+		return nil, toStatus(errs.Wrap(http.StatusInternalServerError, err, "grpcapi.Renew"))
+	}
+	return signResponse(auth, certChain, "")
+}
+
+// RenewStream implements the RenewStream method, it renews the certificate
+// like Renew and then keeps renewing the last certificate issued after two
+// thirds of its lifetime until the client cancels the call or the renewal
+// fails.
+func (s *Server) RenewStream(req *RenewRequest, stream RenewStreamServer) error {
+	ctx := stream.Context()
+	cert, err := getPeerCertificate(ctx, s.getAuthority(), req.Token)
+	if err != nil {
+		return toStatus(err)
+	}
+	for {
+		auth := s.getAuthority()
+		certChain, err := auth.Renew(cert)
+		if err != nil {
+			return toStatus(errs.Wrap(http.StatusInternalServerError, err, "grpcapi.RenewStream"))
+		}
+		resp, err := signResponse(auth, certChain, "")
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		cert = certChain[0]
+
+		t := time.NewTimer(s.renewAfter(cert))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return status.FromContextError(ctx.Err()).Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Revoke implements the Revoke method. As in the HTTP API, only passive
+// revocation is supported.
+func (s *Server) Revoke(ctx context.Context, req *RevokeRequest) (*RevokeResponse, error) {
+	body := &api.RevokeRequest{
+		Serial:     req.Serial,
+		OTT:        req.Ott,
+		ReasonCode: int(req.ReasonCode),
+		Reason:     req.Reason,
+		Passive:    req.Passive,
+	}
+	if err := body.Validate(); err != nil {
+		return nil, toStatus(err)
+	}
+
+	opts := &authority.RevokeOptions{
+		Serial:      body.Serial,
+		Reason:      body.Reason,
+		ReasonCode:  body.ReasonCode,
+		PassiveOnly: body.Passive,
+	}
+	auth := s.getAuthority()
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.RevokeMethod)
+	if len(body.OTT) > 0 {
+		if _, err := auth.Authorize(ctx, body.OTT); err != nil {
+			return nil, toStatus(errs.UnauthorizedErr(err))
+		}
+		opts.OTT = body.OTT
+	} else {
+		// Without a token the client certificate must be the one being revoked.
+		cert := peerCertificate(ctx)
+		if cert == nil {
+			return nil, toStatus(errs.BadRequest("missing ott or peer certificate"))
+		}
+		if cert.SerialNumber.String() != opts.Serial {
+			return nil, toStatus(errs.BadRequest("revoke: serial number in mtls certificate different than request"))
+		}
+		opts.Crt = cert
+		opts.MTLS = true
+	}
+
+	if err := auth.Revoke(ctx, opts); err != nil {
+		return nil, toStatus(errs.ForbiddenErr(err))
+	}
+	return &RevokeResponse{Status: "ok"}, nil
+}
+
+// SSHSign implements the SSHSign method, it creates a new SSH certificate
+// using a public key in the SSH wire format and a one-time token.
+func (s *Server) SSHSign(ctx context.Context, req *SSHSignRequest) (*SSHSignResponse, error) {
+	body := &api.SSHSignRequest{
+		PublicKey:        req.PublicKey,
+		OTT:              req.Ott,
+		CertType:         req.CertType,
+		Principals:       req.Principals,
+		KeyID:            req.KeyID,
+		AddUserPublicKey: req.AddUserPublicKey,
+	}
+	var err error
+	if body.ValidAfter, err = parseTimeDuration("valid_after", req.ValidAfter); err != nil {
+		return nil, toStatus(err)
+	}
+	if body.ValidBefore, err = parseTimeDuration("valid_before", req.ValidBefore); err != nil {
+		return nil, toStatus(err)
+	}
+	if err := body.Validate(); err != nil {
+		return nil, toStatus(errs.BadRequestErr(err))
+	}
+
+	publicKey, err := ssh.ParsePublicKey(body.PublicKey)
+	if err != nil {
+		return nil, toStatus(errs.Wrap(http.StatusBadRequest, err, "error parsing public_key"))
+	}
+	var addUserPublicKey ssh.PublicKey
+	if body.AddUserPublicKey != nil {
+		if addUserPublicKey, err = ssh.ParsePublicKey(body.AddUserPublicKey); err != nil {
+			return nil, toStatus(errs.Wrap(http.StatusBadRequest, err, "error parsing add_user_public_key"))
+		}
+	}
+
+	auth := s.getAuthority()
+	ctx = provisioner.NewContextWithMethod(ctx, provisioner.SSHSignMethod)
+	signOpts, err := auth.Authorize(ctx, body.OTT)
+	if err != nil {
+		return nil, toStatus(errs.UnauthorizedErr(err))
+	}
+	cert, err := auth.SignSSH(ctx, publicKey, provisioner.SSHOptions{
+		CertType:    body.CertType,
+		KeyID:       body.KeyID,
+		Principals:  body.Principals,
+		ValidBefore: body.ValidBefore,
+		ValidAfter:  body.ValidAfter,
+	}, signOpts...)
+	if err != nil {
+		return nil, toStatus(errs.ForbiddenErr(err))
+	}
+
+	resp := &SSHSignResponse{Certificate: cert.Marshal()}
+	if addUserPublicKey != nil && cert.CertType == ssh.UserCert && len(cert.ValidPrincipals) == 1 {
+		addUserCert, err := auth.SignSSHAddUser(ctx, addUserPublicKey, cert)
+		if err != nil {
+			return nil, toStatus(errs.ForbiddenErr(err))
+		}
+		resp.AddUserCertificate = addUserCert.Marshal()
+	}
+	return resp, nil
+}
+
+// Roots implements the Roots method, it returns the root certificates of the
+// CA.
+func (s *Server) Roots(ctx context.Context, req *RootsRequest) (*RootsResponse, error) {
+	roots, err := s.getAuthority().GetRoots()
+	if err != nil {
+		return nil, toStatus(errs.ForbiddenErr(err))
+	}
+	return &RootsResponse{Certificates: rawCertificates(roots)}, nil
+}
+
+// Federation implements the Federation method, it returns all the root
+// certificates in the federation.
+func (s *Server) Federation(ctx context.Context, req *FederationRequest) (*FederationResponse, error) {
+	federated, err := s.getAuthority().GetFederation()
+	if err != nil {
+		return nil, toStatus(errs.ForbiddenErr(err))
+	}
+	return &FederationResponse{Certificates: rawCertificates(federated)}, nil
+}
+
+func signResponse(auth Authority, certChain []*x509.Certificate, token string) (*SignResponse, error) {
+	receipt, err := auth.CreateReceipt(certChain[0], token)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &SignResponse{
+		CertChain: rawCertificates(certChain),
+		Receipt:   receipt,
+	}, nil
+}
+
+// peerCertificate returns the client certificate of the mTLS connection, or
+// nil if there's none.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			return info.State.PeerCertificates[0]
+		}
+	}
+	return nil
+}
+
+// getPeerCertificate returns the certificate to renew, the client certificate
+// of the mTLS connection or the one in the renew token.
+func getPeerCertificate(ctx context.Context, auth Authority, token string) (*x509.Certificate, error) {
+	if cert := peerCertificate(ctx); cert != nil {
+		return cert, nil
+	}
+	if token != "" {
+		return auth.AuthorizeRenewToken(ctx, token)
+	}
+	return nil, errs.BadRequest("missing peer certificate")
+}
+
+func parseTimeDuration(name, s string) (api.TimeDuration, error) {
+	if s == "" {
+		return api.TimeDuration{}, nil
+	}
+	td, err := api.ParseTimeDuration(s)
+	if err != nil {
+		return td, errs.Wrap(http.StatusBadRequest, err, "error parsing %s", name)
+	}
+	return td, nil
+}
+
+func rawCertificates(certs []*x509.Certificate) [][]byte {
+	raw := make([][]byte, len(certs))
+	for i, crt := range certs {
+		raw[i] = crt.Raw
+	}
+	return raw
+}
+
+// toStatus converts an error of the errs package into a gRPC status error
+// with the message that would be returned by the HTTP API. Server errors are
+// logged as the cause is not returned to the client.
+func toStatus(err error) error {
+	e, ok := errs.NewErr(http.StatusInternalServerError, err).(*errs.Error)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	var code codes.Code
+	switch e.StatusCode() {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	default:
+		code = codes.Internal
+	}
+	if code == codes.Internal {
+		log.Printf("grpcapi: %+v\n", err)
+	}
+	return status.Error(code, e.Message())
+}
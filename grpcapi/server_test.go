@@ -0,0 +1,407 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+type mockAuthority struct {
+	authorize           func(ctx context.Context, ott string) ([]provisioner.SignOption, error)
+	sign                func(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	renew               func(cert *x509.Certificate) ([]*x509.Certificate, error)
+	authorizeRenewToken func(ctx context.Context, ott string) (*x509.Certificate, error)
+	revoke              func(context.Context, *authority.RevokeOptions) error
+	signSSH             func(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error)
+	signSSHAddUser      func(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error)
+	getRoots            func() ([]*x509.Certificate, error)
+	getFederation       func() ([]*x509.Certificate, error)
+}
+
+func (m *mockAuthority) Authorize(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
+	if m.authorize != nil {
+		return m.authorize(ctx, ott)
+	}
+	return nil, nil
+}
+
+func (m *mockAuthority) Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return m.sign(cr, opts, signOpts...)
+}
+
+func (m *mockAuthority) Renew(cert *x509.Certificate) ([]*x509.Certificate, error) {
+	return m.renew(cert)
+}
+
+func (m *mockAuthority) AuthorizeRenewToken(ctx context.Context, ott string) (*x509.Certificate, error) {
+	return m.authorizeRenewToken(ctx, ott)
+}
+
+func (m *mockAuthority) Revoke(ctx context.Context, opts *authority.RevokeOptions) error {
+	return m.revoke(ctx, opts)
+}
+
+func (m *mockAuthority) SignSSH(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+	return m.signSSH(ctx, key, opts, signOpts...)
+}
+
+func (m *mockAuthority) SignSSHAddUser(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error) {
+	return m.signSSHAddUser(ctx, key, cert)
+}
+
+func (m *mockAuthority) GetRoots() ([]*x509.Certificate, error) {
+	return m.getRoots()
+}
+
+func (m *mockAuthority) GetFederation() ([]*x509.Certificate, error) {
+	return m.getFederation()
+}
+
+func (m *mockAuthority) CreateReceipt(cert *x509.Certificate, token string) (string, error) {
+	return "receipt", nil
+}
+
+func mustCertificate(t *testing.T, cn string, serial int64, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{cn},
+	}
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+		tpl.ExtKeyUsage = nil
+		parent, parentKey = tpl, key
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func mustCSR(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "test.smallstep.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServer_mTLS(t *testing.T) {
+	root, rootKey := mustCertificate(t, "Root CA", 1, nil, nil)
+	srvCert, srvKey := mustCertificate(t, "localhost", 2, root, rootKey)
+	clientCert, clientKey := mustCertificate(t, "client", 3, root, rootKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+
+	var (
+		mu      sync.Mutex
+		renewed []*x509.Certificate
+	)
+	auth := &mockAuthority{
+		renew: func(cert *x509.Certificate) ([]*x509.Certificate, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			renewed = append(renewed, cert)
+			leaf, _ := mustCertificate(t, "client", int64(len(renewed)+10), root, rootKey)
+			return []*x509.Certificate{leaf, root}, nil
+		},
+		getRoots: func() ([]*x509.Certificate, error) {
+			return []*x509.Certificate{root}, nil
+		},
+	}
+
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{srvCert.Raw}, PrivateKey: srvKey, Leaf: srvCert}},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	})))
+	s := NewServer(auth)
+	s.renewAfter = func(*x509.Certificate) time.Duration { return time.Millisecond }
+	RegisterCertificateAuthorityServer(srv, s)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey, Leaf: clientCert}},
+		RootCAs:      pool,
+		ServerName:   "localhost",
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewCertificateAuthorityClient(conn)
+
+	roots, err := client.Roots(context.Background(), &RootsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots.Certificates) != 1 || string(roots.Certificates[0]) != string(root.Raw) {
+		t.Errorf("Server.Roots() = %v, want %v", roots.Certificates, [][]byte{root.Raw})
+	}
+
+	resp, err := client.Renew(context.Background(), &RenewRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.CertChain) != 2 || resp.Receipt != "receipt" {
+		t.Errorf("Server.Renew() = %v", resp)
+	}
+	mu.Lock()
+	n := len(renewed)
+	mu.Unlock()
+	if n != 1 || renewed[0].SerialNumber.Int64() != 3 {
+		t.Fatalf("Server.Renew() did not renew the client certificate")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.RenewStream(ctx, &RenewRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		crt, err := x509.ParseCertificate(resp.CertChain[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(12 + i); crt.SerialNumber.Int64() != want {
+			t.Errorf("Server.RenewStream() serial = %d, want %d", crt.SerialNumber.Int64(), want)
+		}
+	}
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("Server.RenewStream() error = %v, want Canceled", err)
+	}
+	// The first renewal uses the client certificate and the rest the previous
+	// certificate in the stream.
+	mu.Lock()
+	defer mu.Unlock()
+	if renewed[1].SerialNumber.Int64() != 3 || renewed[2].SerialNumber.Int64() != 12 || renewed[3].SerialNumber.Int64() != 13 {
+		t.Errorf("Server.RenewStream() renewed wrong certificates")
+	}
+}
+
+func TestServer_Sign(t *testing.T) {
+	root, rootKey := mustCertificate(t, "Root CA", 1, nil, nil)
+	leaf, _ := mustCertificate(t, "test.smallstep.com", 2, root, rootKey)
+	csr := mustCSR(t)
+	auth := &mockAuthority{
+		authorize: func(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
+			if m := provisioner.MethodFromContext(ctx); m != provisioner.SignMethod {
+				return nil, errors.Errorf("unexpected method %v", m)
+			}
+			if ott != "token" {
+				return nil, errors.New("invalid token")
+			}
+			return nil, nil
+		},
+		sign: func(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			if opts.Profile == "forbidden" {
+				return nil, errors.New("not allowed")
+			}
+			return []*x509.Certificate{leaf, root}, nil
+		},
+	}
+	tests := []struct {
+		name     string
+		req      *SignRequest
+		wantCode codes.Code
+	}{
+		{"ok", &SignRequest{Csr: csr, Ott: "token", NotAfter: "1h"}, codes.OK},
+		{"fail csr", &SignRequest{Csr: []byte("foo"), Ott: "token"}, codes.InvalidArgument},
+		{"fail ott", &SignRequest{Csr: csr}, codes.InvalidArgument},
+		{"fail not_after", &SignRequest{Csr: csr, Ott: "token", NotAfter: "foo"}, codes.InvalidArgument},
+		{"fail authorize", &SignRequest{Csr: csr, Ott: "bad"}, codes.Unauthenticated},
+		{"fail sign", &SignRequest{Csr: csr, Ott: "token", Profile: "forbidden"}, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewServer(auth).Sign(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Server.Sign() error = %v, wantCode %v", err, tt.wantCode)
+				return
+			}
+			if err == nil && len(got.CertChain) != 2 {
+				t.Errorf("Server.Sign() certChain = %v", got.CertChain)
+			}
+		})
+	}
+}
+
+func TestServer_Revoke(t *testing.T) {
+	auth := &mockAuthority{
+		authorize: func(ctx context.Context, ott string) ([]provisioner.SignOption, error) {
+			if ott != "token" {
+				return nil, errors.New("invalid token")
+			}
+			return nil, nil
+		},
+		revoke: func(ctx context.Context, opts *authority.RevokeOptions) error {
+			if opts.Serial == "2" {
+				return errs.Errorf(409, "certificate already revoked")
+			}
+			return nil
+		},
+	}
+	tests := []struct {
+		name     string
+		req      *RevokeRequest
+		wantCode codes.Code
+	}{
+		{"ok", &RevokeRequest{Serial: "1", Ott: "token", Passive: true}, codes.OK},
+		{"fail serial", &RevokeRequest{Ott: "token", Passive: true}, codes.InvalidArgument},
+		{"fail reason", &RevokeRequest{Serial: "1", Ott: "token", ReasonCode: 7, Passive: true}, codes.InvalidArgument},
+		{"fail passive", &RevokeRequest{Serial: "1", Ott: "token"}, codes.Unimplemented},
+		{"fail authorize", &RevokeRequest{Serial: "1", Ott: "bad", Passive: true}, codes.Unauthenticated},
+		{"fail missing", &RevokeRequest{Serial: "1", Passive: true}, codes.InvalidArgument},
+		{"fail revoke", &RevokeRequest{Serial: "2", Ott: "token", Passive: true}, codes.Aborted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(auth).Revoke(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Server.Revoke() error = %v, wantCode %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_SSHSign(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(caKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert := func(key ssh.PublicKey, principals []string) (*ssh.Certificate, error) {
+		cert := &ssh.Certificate{
+			Key:             key,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: principals,
+			ValidBefore:     ssh.CertTimeInfinity,
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			return nil, err
+		}
+		return cert, nil
+	}
+	auth := &mockAuthority{
+		signSSH: func(ctx context.Context, key ssh.PublicKey, opts provisioner.SSHOptions, signOpts ...provisioner.SignOption) (*ssh.Certificate, error) {
+			if m := provisioner.MethodFromContext(ctx); m != provisioner.SSHSignMethod {
+				return nil, errors.Errorf("unexpected method %v", m)
+			}
+			return newCert(key, opts.Principals)
+		},
+		signSSHAddUser: func(ctx context.Context, key ssh.PublicKey, cert *ssh.Certificate) (*ssh.Certificate, error) {
+			return newCert(key, []string{"provisioner"})
+		},
+	}
+	tests := []struct {
+		name        string
+		req         *SSHSignRequest
+		wantCode    codes.Code
+		wantAddUser bool
+	}{
+		{"ok", &SSHSignRequest{PublicKey: pub.Marshal(), Ott: "token", CertType: "user", Principals: []string{"user"}, ValidBefore: "1h"}, codes.OK, false},
+		{"ok add user", &SSHSignRequest{PublicKey: pub.Marshal(), Ott: "token", CertType: "user", Principals: []string{"user"}, AddUserPublicKey: pub.Marshal()}, codes.OK, true},
+		{"fail cert type", &SSHSignRequest{PublicKey: pub.Marshal(), Ott: "token", CertType: "foo"}, codes.InvalidArgument, false},
+		{"fail public key", &SSHSignRequest{PublicKey: []byte("foo"), Ott: "token"}, codes.InvalidArgument, false},
+		{"fail valid_after", &SSHSignRequest{PublicKey: pub.Marshal(), Ott: "token", ValidAfter: "foo"}, codes.InvalidArgument, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewServer(auth).SSHSign(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Server.SSHSign() error = %v, wantCode %v", err, tt.wantCode)
+				return
+			}
+			if err == nil {
+				if _, err := ssh.ParsePublicKey(got.Certificate); err != nil {
+					t.Errorf("Server.SSHSign() certificate error = %v", err)
+				}
+				if (len(got.AddUserCertificate) > 0) != tt.wantAddUser {
+					t.Errorf("Server.SSHSign() addUserCertificate = %v, wantAddUser %v", got.AddUserCertificate, tt.wantAddUser)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	root, rootKey := mustCertificate(t, "Root CA", 1, nil, nil)
+	federated, _ := mustCertificate(t, "Federated CA", 2, root, rootKey)
+	s := NewServer(&mockAuthority{
+		getFederation: func() ([]*x509.Certificate, error) {
+			return []*x509.Certificate{root}, nil
+		},
+	})
+	s.Reload(&mockAuthority{
+		getFederation: func() ([]*x509.Certificate, error) {
+			return []*x509.Certificate{root, federated}, nil
+		},
+	})
+	got, err := s.Federation(context.Background(), &FederationRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Certificates) != 2 {
+		t.Errorf("Server.Federation() = %v, want 2 certificates", got.Certificates)
+	}
+}
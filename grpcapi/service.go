@@ -0,0 +1,260 @@
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// serviceName is the full name of the gRPC service.
+const serviceName = "grpcapi.CertificateAuthority"
+
+// CertificateAuthorityClient is the client API of the CertificateAuthority
+// service.
+type CertificateAuthorityClient interface {
+	Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+	Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*SignResponse, error)
+	RenewStream(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (RenewStreamClient, error)
+	Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*RevokeResponse, error)
+	SSHSign(ctx context.Context, in *SSHSignRequest, opts ...grpc.CallOption) (*SSHSignResponse, error)
+	Roots(ctx context.Context, in *RootsRequest, opts ...grpc.CallOption) (*RootsResponse, error)
+	Federation(ctx context.Context, in *FederationRequest, opts ...grpc.CallOption) (*FederationResponse, error)
+}
+
+// CertificateAuthorityServer is the server API of the CertificateAuthority
+// service.
+type CertificateAuthorityServer interface {
+	Sign(context.Context, *SignRequest) (*SignResponse, error)
+	Renew(context.Context, *RenewRequest) (*SignResponse, error)
+	RenewStream(*RenewRequest, RenewStreamServer) error
+	Revoke(context.Context, *RevokeRequest) (*RevokeResponse, error)
+	SSHSign(context.Context, *SSHSignRequest) (*SSHSignResponse, error)
+	Roots(context.Context, *RootsRequest) (*RootsResponse, error)
+	Federation(context.Context, *FederationRequest) (*FederationResponse, error)
+}
+
+// RenewStreamClient is the client side of the RenewStream method.
+type RenewStreamClient interface {
+	Recv() (*SignResponse, error)
+	grpc.ClientStream
+}
+
+// RenewStreamServer is the server side of the RenewStream method.
+type RenewStreamServer interface {
+	Send(*SignResponse) error
+	grpc.ServerStream
+}
+
+type certificateAuthorityClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewCertificateAuthorityClient returns a CertificateAuthorityClient using the
+// given connection.
+func NewCertificateAuthorityClient(cc *grpc.ClientConn) CertificateAuthorityClient {
+	return &certificateAuthorityClient{cc}
+}
+
+func (c *certificateAuthorityClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	out := new(SignResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Sign", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	out := new(SignResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Renew", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) RenewStream(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (RenewStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &certificateAuthorityServiceDesc.Streams[0], "/"+serviceName+"/RenewStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &renewStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type renewStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *renewStreamClient) Recv() (*SignResponse, error) {
+	m := new(SignResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *certificateAuthorityClient) Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*RevokeResponse, error) {
+	out := new(RevokeResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Revoke", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) SSHSign(ctx context.Context, in *SSHSignRequest, opts ...grpc.CallOption) (*SSHSignResponse, error) {
+	out := new(SSHSignResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/SSHSign", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) Roots(ctx context.Context, in *RootsRequest, opts ...grpc.CallOption) (*RootsResponse, error) {
+	out := new(RootsResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Roots", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) Federation(ctx context.Context, in *FederationRequest, opts ...grpc.CallOption) (*FederationResponse, error) {
+	out := new(FederationResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Federation", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterCertificateAuthorityServer registers the CertificateAuthority
+// service in the given gRPC server.
+func RegisterCertificateAuthorityServer(s *grpc.Server, srv CertificateAuthorityServer) {
+	s.RegisterService(&certificateAuthorityServiceDesc, srv)
+}
+
+var certificateAuthorityServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*CertificateAuthorityServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Sign", Handler: signHandler},
+		{MethodName: "Renew", Handler: renewHandler},
+		{MethodName: "Revoke", Handler: revokeHandler},
+		{MethodName: "SSHSign", Handler: sshSignHandler},
+		{MethodName: "Roots", Handler: rootsHandler},
+		{MethodName: "Federation", Handler: federationHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "RenewStream", Handler: renewStreamHandler, ServerStreams: true},
+	},
+	Metadata: "grpcapi.proto",
+}
+
+func signHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Sign"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Sign(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func renewHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Renew"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Renew(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func renewStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(RenewRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(CertificateAuthorityServer).RenewStream(in, &renewStreamServer{stream})
+}
+
+type renewStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *renewStreamServer) Send(m *SignResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func revokeHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Revoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Revoke"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Revoke(ctx, req.(*RevokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func sshSignHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SSHSignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).SSHSign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/SSHSign"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).SSHSign(ctx, req.(*SSHSignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func rootsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RootsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Roots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Roots"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Roots(ctx, req.(*RootsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func federationHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FederationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Federation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Federation"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Federation(ctx, req.(*FederationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -30,8 +30,18 @@ const (
 	CloudKMS Type = "cloudkms"
 	// AmazonKMS is a KMS implementation using Amazon AWS KMS.
 	AmazonKMS Type = "awskms"
+	// AzureKMS is a KMS implementation using Azure Key Vault.
+	AzureKMS Type = "azurekms"
 	// PKCS11 is a KMS implementation using the PKCS11 standard.
 	PKCS11 Type = "pkcs11"
+	// YubiKey is a KMS implementation using a YubiKey PIV.
+	YubiKey Type = "yubikey"
+	// TPMKMS is a KMS implementation using a TPM 2.0.
+	TPMKMS Type = "tpmkms"
+	// VaultKMS is a KMS implementation using HashiCorp Vault Transit.
+	VaultKMS Type = "vaultkms"
+	// RemoteKMS is a KMS implementation using a remote signer over gRPC.
+	RemoteKMS Type = "remotekms"
 )
 
 type Options struct {
@@ -44,8 +54,53 @@ type Options struct {
 	// Path to the module used with PKCS11 KMS.
 	Module string `json:"module"`
 
-	// Pin used to access the PKCS11 module.
+	// Pin used to access the PKCS11 module or the YubiKey. With TPMKMS it is
+	// the password of the keys without a PCR policy.
 	Pin string `json:"pin"`
+
+	// ManagementKey used with YubiKey KMS, a hex-encoded 3DES or AES key. It
+	// is only required to create keys, by default the YubiKey default one is
+	// used.
+	ManagementKey string `json:"managementKey,omitempty"`
+
+	// Slot used with PKCS11 KMS, by default the first slot with a token is
+	// used.
+	Slot *int `json:"slot,omitempty"`
+
+	// TokenLabel used to select the slot with PKCS11 KMS.
+	TokenLabel string `json:"tokenLabel,omitempty"`
+
+	// ClientID of the user-assigned managed identity used with AzureKMS, by
+	// default the system-assigned identity is used.
+	ClientID string `json:"clientId,omitempty"`
+
+	// Device is the path to the TPM used with TPMKMS, by default
+	// /dev/tpmrm0.
+	Device string `json:"device,omitempty"`
+
+	// Address of the Vault server used with VaultKMS, by default the
+	// environment variable VAULT_ADDR. With RemoteKMS it is the host and port
+	// of the signer daemon.
+	Address string `json:"address,omitempty"`
+
+	// Token used to authenticate to Vault with VaultKMS, by default the
+	// environment variable VAULT_TOKEN. Renewable tokens are renewed until
+	// the KMS is closed.
+	Token string `json:"token,omitempty"`
+
+	// Namespace used with VaultKMS on Vault Enterprise, by default the
+	// environment variable VAULT_NAMESPACE.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Certificate and CertificateKey are the paths to the PEM encoded client
+	// certificate and key used to authenticate to the signer daemon with
+	// RemoteKMS.
+	Certificate    string `json:"certificate,omitempty"`
+	CertificateKey string `json:"certificateKey,omitempty"`
+
+	// Root is the path to the PEM encoded bundle used to verify the
+	// certificate of the signer daemon with RemoteKMS.
+	Root string `json:"root,omitempty"`
 }
 
 // Validate checks the fields in Options.
@@ -55,11 +110,27 @@ func (o *Options) Validate() error {
 	}
 
 	switch Type(strings.ToLower(o.Type)) {
-	case DefaultKMS, SoftKMS, CloudKMS:
+	case DefaultKMS, SoftKMS, CloudKMS, AzureKMS, YubiKey, TPMKMS, VaultKMS:
 	case AmazonKMS:
 		return ErrNotImplemented{"support for AmazonKMS is not yet implemented"}
 	case PKCS11:
-		return ErrNotImplemented{"support for PKCS11 is not yet implemented"}
+		switch {
+		case o.Module == "":
+			return errors.New("kms module cannot be empty")
+		case o.Slot != nil && o.TokenLabel != "":
+			return errors.New("kms slot and tokenLabel cannot be used together")
+		case o.Slot != nil && *o.Slot < 0:
+			return errors.New("kms slot cannot be negative")
+		}
+	case RemoteKMS:
+		switch {
+		case o.Address == "":
+			return errors.New("kms address cannot be empty")
+		case o.Certificate == "" || o.CertificateKey == "":
+			return errors.New("kms certificate and certificateKey cannot be empty")
+		case o.Root == "":
+			return errors.New("kms root cannot be empty")
+		}
 	default:
 		return errors.Errorf("unsupported kms type %s", o.Type)
 	}
@@ -5,6 +5,7 @@ import (
 )
 
 func TestOptions_Validate(t *testing.T) {
+	slot, negative := 1, -1
 	tests := []struct {
 		name    string
 		options *Options
@@ -14,7 +15,20 @@ func TestOptions_Validate(t *testing.T) {
 		{"softkms", &Options{Type: "softkms"}, false},
 		{"cloudkms", &Options{Type: "cloudkms"}, false},
 		{"awskms", &Options{Type: "awskms"}, true},
-		{"pkcs11", &Options{Type: "pkcs11"}, true},
+		{"azurekms", &Options{Type: "azurekms"}, false},
+		{"azurekms client id", &Options{Type: "azurekms", ClientID: "00000000-0000-0000-0000-000000000000"}, false},
+		{"pkcs11", &Options{Type: "pkcs11", Module: "/usr/lib/softhsm/libsofthsm2.so"}, false},
+		{"pkcs11 slot", &Options{Type: "pkcs11", Module: "/usr/lib/softhsm/libsofthsm2.so", Slot: &slot}, false},
+		{"pkcs11 no module", &Options{Type: "pkcs11"}, true},
+		{"pkcs11 slot and label", &Options{Type: "pkcs11", Module: "/usr/lib/softhsm/libsofthsm2.so", Slot: &slot, TokenLabel: "token"}, true},
+		{"pkcs11 negative slot", &Options{Type: "pkcs11", Module: "/usr/lib/softhsm/libsofthsm2.so", Slot: &negative}, true},
+		{"yubikey", &Options{Type: "yubikey"}, false},
+		{"tpmkms", &Options{Type: "tpmkms"}, false},
+		{"vaultkms", &Options{Type: "vaultkms", Address: "https://vault.example.com:8200", Namespace: "ns1"}, false},
+		{"remotekms", &Options{Type: "remotekms", Address: "signer.internal:9443", Certificate: "client.crt", CertificateKey: "client.key", Root: "root_ca.crt"}, false},
+		{"remotekms no address", &Options{Type: "remotekms", Certificate: "client.crt", CertificateKey: "client.key", Root: "root_ca.crt"}, true},
+		{"remotekms no certificate", &Options{Type: "remotekms", Address: "signer.internal:9443", CertificateKey: "client.key", Root: "root_ca.crt"}, true},
+		{"remotekms no root", &Options{Type: "remotekms", Address: "signer.internal:9443", Certificate: "client.crt", CertificateKey: "client.key"}, true},
 		{"unsupported", &Options{Type: "unsupported"}, true},
 	}
 	for _, tt := range tests {
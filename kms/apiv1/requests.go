@@ -2,6 +2,7 @@ package apiv1
 
 import (
 	"crypto"
+	"crypto/x509"
 	"fmt"
 )
 
@@ -90,6 +91,67 @@ func (s SignatureAlgorithm) String() string {
 	}
 }
 
+// PINPolicy specifies on some KMS when the PIN is required to use a key.
+type PINPolicy int
+
+const (
+	// PIN policy not specified, the KMS default is used.
+	UnspecifiedPINPolicy PINPolicy = iota
+	// The PIN is never required.
+	PINPolicyNever
+	// The PIN is required once per session.
+	PINPolicyOnce
+	// The PIN is required for every operation.
+	PINPolicyAlways
+)
+
+// String returns a string representation of p.
+func (p PINPolicy) String() string {
+	switch p {
+	case UnspecifiedPINPolicy:
+		return "unspecified"
+	case PINPolicyNever:
+		return "never"
+	case PINPolicyOnce:
+		return "once"
+	case PINPolicyAlways:
+		return "always"
+	default:
+		return fmt.Sprintf("unknown(%d)", p)
+	}
+}
+
+// TouchPolicy specifies on some KMS when the user presence is required to use a
+// key.
+type TouchPolicy int
+
+const (
+	// Touch policy not specified, the KMS default is used.
+	UnspecifiedTouchPolicy TouchPolicy = iota
+	// Touch is never required.
+	TouchPolicyNever
+	// Touch is required for every operation.
+	TouchPolicyAlways
+	// Touch is cached for a few seconds.
+	TouchPolicyCached
+)
+
+// String returns a string representation of p.
+func (p TouchPolicy) String() string {
+	switch p {
+	case UnspecifiedTouchPolicy:
+		return "unspecified"
+	case TouchPolicyNever:
+		return "never"
+	case TouchPolicyAlways:
+		return "always"
+	case TouchPolicyCached:
+		return "cached"
+	default:
+		return fmt.Sprintf("unknown(%d)", p)
+	}
+}
+
 // GetPublicKeyRequest is the parameter used in the kms.GetPublicKey method.
 type GetPublicKeyRequest struct {
 	Name string
@@ -104,6 +166,17 @@ type CreateKeyRequest struct {
 	// ProtectionLevel specifies how cryptographic operations are performed.
 	// Used by: cloudkms
 	ProtectionLevel ProtectionLevel
+
+	// PINPolicy and TouchPolicy specify when the PIN and the touch are required
+	// to use the key.
+	// Used by: yubikey
+	PINPolicy   PINPolicy
+	TouchPolicy TouchPolicy
+
+	// PCRs is the list of PCRs in the SHA-256 bank that the key is bound to,
+	// the key can only be used if their values do not change.
+	// Used by: tpmkms
+	PCRs []int
 }
 
 // CreateKeyResponse is the response value of the kms.CreateKey method.
@@ -114,6 +187,41 @@ type CreateKeyResponse struct {
 	CreateSignerRequest CreateSignerRequest
 }
 
+// LoadCertificateRequest is the parameter used in the LoadCertificate method of
+// a kms.CertificateManager.
+type LoadCertificateRequest struct {
+	Name string
+}
+
+// StoreCertificateRequest is the parameter used in the StoreCertificate method
+// of the KMS that can store certificates.
+type StoreCertificateRequest struct {
+	Name        string
+	Certificate *x509.Certificate
+}
+
+// CreateAttestationRequest is the parameter used in the CreateAttestation
+// method of a kms.Attester.
+type CreateAttestationRequest struct {
+	Name string
+}
+
+// CreateAttestationResponse is the response value of the CreateAttestation
+// method of a kms.Attester. The certificate attests that the key was generated
+// in the device, and the chain contains the intermediates up to the
+// manufacturer root.
+type CreateAttestationResponse struct {
+	Certificate      *x509.Certificate
+	CertificateChain []*x509.Certificate
+}
+
+// DecryptRequest is the parameter used in the Decrypt method of a
+// kms.Decrypter. Name is the name of the key used to encrypt the ciphertext.
+type DecryptRequest struct {
+	Name       string
+	Ciphertext []byte
+}
+
 // CreateSignerRequest is the parameter used in the kms.CreateSigner method.
 type CreateSignerRequest struct {
 	Signer        crypto.Signer
@@ -49,3 +49,45 @@ func TestSignatureAlgorithm_String(t *testing.T) {
 		})
 	}
 }
+
+func TestPINPolicy_String(t *testing.T) {
+	tests := []struct {
+		name string
+		p    PINPolicy
+		want string
+	}{
+		{"unspecified", UnspecifiedPINPolicy, "unspecified"},
+		{"never", PINPolicyNever, "never"},
+		{"once", PINPolicyOnce, "once"},
+		{"always", PINPolicyAlways, "always"},
+		{"unknown", PINPolicy(100), "unknown(100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("PINPolicy.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTouchPolicy_String(t *testing.T) {
+	tests := []struct {
+		name string
+		p    TouchPolicy
+		want string
+	}{
+		{"unspecified", UnspecifiedTouchPolicy, "unspecified"},
+		{"never", TouchPolicyNever, "never"},
+		{"always", TouchPolicyAlways, "always"},
+		{"cached", TouchPolicyCached, "cached"},
+		{"unknown", TouchPolicy(100), "unknown(100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("TouchPolicy.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
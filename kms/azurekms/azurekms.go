@@ -0,0 +1,421 @@
+package azurekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// uriScheme is the scheme used in the key and certificate names, e.g.
+// azurekms:vault=my-vault;name=my-key;version=012345.
+const uriScheme = "azurekms:"
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 3072
+
+// signatureAlgorithmMapping maps the step signature algorithms with the Key
+// Vault key type and curve.
+//
+// Key Vault does not support PureEd25519.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]struct {
+	Kty string
+	Crv string
+}{
+	apiv1.UnspecifiedSignAlgorithm: {"EC", "P-256"},
+	apiv1.SHA256WithRSA:            {"RSA", ""},
+	apiv1.SHA384WithRSA:            {"RSA", ""},
+	apiv1.SHA512WithRSA:            {"RSA", ""},
+	apiv1.SHA256WithRSAPSS:         {"RSA", ""},
+	apiv1.SHA384WithRSAPSS:         {"RSA", ""},
+	apiv1.SHA512WithRSAPSS:         {"RSA", ""},
+	apiv1.ECDSAWithSHA256:          {"EC", "P-256"},
+	apiv1.ECDSAWithSHA384:          {"EC", "P-384"},
+	apiv1.ECDSAWithSHA512:          {"EC", "P-521"},
+}
+
+// JSONWebKey is the public part of a key in Key Vault, the values are base64url
+// encoded.
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// KeyBundle is the key returned by Key Vault.
+type KeyBundle struct {
+	Key JSONWebKey `json:"key"`
+}
+
+// KeyCreateParameters are the parameters used to create a key.
+type KeyCreateParameters struct {
+	Kty     string   `json:"kty"`
+	KeySize int      `json:"key_size,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	KeyOps  []string `json:"key_ops,omitempty"`
+}
+
+// KeySignParameters are the parameters used to sign a digest, the value is
+// base64url encoded.
+type KeySignParameters struct {
+	Algorithm string `json:"alg"`
+	Value     string `json:"value"`
+}
+
+// KeyOperationResult is the result of a sign operation, the value is base64url
+// encoded.
+type KeyOperationResult struct {
+	Kid    string `json:"kid"`
+	Result string `json:"value"`
+}
+
+// CertificateBundle is the certificate returned by Key Vault, Cer is the DER
+// encoded certificate.
+type CertificateBundle struct {
+	ID  string `json:"id"`
+	Kid string `json:"kid"`
+	Cer []byte `json:"cer"`
+}
+
+// KeyVaultClient defines the Key Vault operations that this package will use.
+// The vault is the base URL of the vault or the managed HSM, e.g.
+// https://my-vault.vault.azure.net, and an empty version means the latest one.
+// This interface will be used for unit testing.
+type KeyVaultClient interface {
+	GetKey(ctx context.Context, vault, name, version string) (*KeyBundle, error)
+	CreateKey(ctx context.Context, vault, name string, params *KeyCreateParameters) (*KeyBundle, error)
+	Sign(ctx context.Context, vault, name, version string, params *KeySignParameters) (*KeyOperationResult, error)
+	GetCertificate(ctx context.Context, vault, name, version string) (*CertificateBundle, error)
+}
+
+// KeyVault implements a KMS using Azure Key Vault or Azure Key Vault Managed
+// HSM.
+type KeyVault struct {
+	client KeyVaultClient
+}
+
+// New creates a new KeyVault that authenticates using the managed identity of
+// the Azure resource. If opts.ClientID is set, the user-assigned identity with
+// that client id is used, otherwise the system-assigned one.
+func New(ctx context.Context, opts apiv1.Options) (*KeyVault, error) {
+	return &KeyVault{
+		client: newHTTPClient(opts.ClientID),
+	}, nil
+}
+
+// NewKeyVault creates a new KeyVault with a given client.
+func NewKeyVault(client KeyVaultClient) *KeyVault {
+	return &KeyVault{
+		client: client,
+	}
+}
+
+// Close is a noop, there are no connections to close.
+func (k *KeyVault) Close() error {
+	return nil
+}
+
+// GetPublicKey returns the public key of the key with the given name.
+func (k *KeyVault) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	id, err := parseName(req.Name, "keys")
+	if err != nil {
+		return nil, err
+	}
+	pub, _, err := k.getKey(id)
+	return pub, err
+}
+
+// CreateKey creates a new key in Key Vault. HSM-protected keys are created if
+// the protection level is HSM or the vault is a managed HSM. The response name
+// pins the version of the new key.
+func (k *KeyVault) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("azurekms does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	id, err := parseName(req.Name, "keys")
+	if err != nil {
+		return nil, err
+	}
+	if id.version != "" {
+		return nil, errors.Errorf("createKeyRequest 'name' cannot contain a version")
+	}
+
+	params := &KeyCreateParameters{
+		Kty:    v.Kty,
+		Crv:    v.Crv,
+		KeyOps: []string{"sign", "verify"},
+	}
+	if req.ProtectionLevel == apiv1.HSM || id.isManagedHSM() {
+		params.Kty += "-HSM"
+	}
+	if v.Kty == "RSA" {
+		params.KeySize = req.Bits
+		if params.KeySize == 0 {
+			params.KeySize = DefaultRSAKeySize
+		}
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	bundle, err := k.client.CreateKey(ctx, id.vault, id.name, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "azurekms CreateKey failed")
+	}
+	pub, err := parseJSONWebKey(&bundle.Key)
+	if err != nil {
+		return nil, err
+	}
+	id.version = keyVersion(bundle.Key.Kid)
+	name := id.String()
+
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a new signer using the key with the given name. If the
+// name does not contain a version, the signer is pinned to the current version
+// of the key, so a rotation in Key Vault does not change the key used by a
+// running CA.
+func (k *KeyVault) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	id, err := parseName(req.SigningKey, "keys")
+	if err != nil {
+		return nil, err
+	}
+	pub, version, err := k.getKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if id.version == "" {
+		id.version = version
+	}
+	return NewSigner(k.client, id.vault, id.name, id.version, pub), nil
+}
+
+// LoadCertificate returns the certificate with the given name. The key of a Key
+// Vault certificate has the same name, so the same name can be used for the
+// key and the certificate of an intermediate.
+func (k *KeyVault) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	if req.Name == "" {
+		return nil, errors.New("loadCertificateRequest 'name' cannot be empty")
+	}
+	id, err := parseName(req.Name, "certificates")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	bundle, err := k.client.GetCertificate(ctx, id.vault, id.name, id.version)
+	if err != nil {
+		return nil, errors.Wrap(err, "azurekms GetCertificate failed")
+	}
+	crt, err := x509.ParseCertificate(bundle.Cer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing azurekms certificate")
+	}
+	return crt, nil
+}
+
+// getKey returns the public key and the version of the given key.
+func (k *KeyVault) getKey(id *keyID) (crypto.PublicKey, string, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	bundle, err := k.client.GetKey(ctx, id.vault, id.name, id.version)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "azurekms GetKey failed")
+	}
+	pub, err := parseJSONWebKey(&bundle.Key)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, keyVersion(bundle.Key.Kid), nil
+}
+
+// keyID identifies a key or a certificate in a vault.
+type keyID struct {
+	vault   string
+	name    string
+	version string
+}
+
+// String returns the name of the key in the azurekms URI format.
+func (id *keyID) String() string {
+	u, err := url.Parse(id.vault)
+	if err != nil {
+		return ""
+	}
+	s := uriScheme + "vault=" + u.Host + ";name=" + url.PathEscape(id.name)
+	if id.version != "" {
+		s += ";version=" + url.PathEscape(id.version)
+	}
+	return s
+}
+
+func (id *keyID) isManagedHSM() bool {
+	return strings.Contains(id.vault, ".managedhsm.")
+}
+
+// parseName parses the name of a key or a certificate. The name can be an
+// azurekms URI like azurekms:vault=my-vault;name=my-key;version=012345 or the
+// Key Vault identifier like https://my-vault.vault.azure.net/keys/my-key/012345.
+//
+// In the URI, the vault is the name of a Key Vault, the name of a managed HSM
+// with the hsm=true attribute, or a host name on a different cloud, e.g.
+// my-vault.vault.azure.cn. The version is optional.
+func parseName(name, collection string) (*keyID, error) {
+	switch {
+	case strings.HasPrefix(name, uriScheme):
+		return parseURI(name)
+	case strings.HasPrefix(name, "https://"):
+		u, err := url.Parse(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", name)
+		}
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] != collection || parts[1] == "" {
+			return nil, errors.Errorf("error parsing %s: invalid %s identifier", name, collection)
+		}
+		id := &keyID{
+			vault: "https://" + u.Host,
+			name:  parts[1],
+		}
+		if len(parts) == 3 {
+			id.version = parts[2]
+		}
+		return id, nil
+	default:
+		return nil, errors.Errorf("error parsing %s: name must start with %s or https://", name, uriScheme)
+	}
+}
+
+func parseURI(name string) (*keyID, error) {
+	values := make(map[string]string)
+	for _, attr := range strings.Split(strings.TrimPrefix(name, uriScheme), ";") {
+		if attr == "" {
+			continue
+		}
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("error parsing %s: invalid attribute %s", name, attr)
+		}
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", name)
+		}
+		values[parts[0]] = value
+	}
+
+	vault, keyName := values["vault"], values["name"]
+	if vault == "" || keyName == "" {
+		return nil, errors.Errorf("error parsing %s: vault and name are required", name)
+	}
+	if !strings.Contains(vault, ".") {
+		if values["hsm"] == "true" {
+			vault += ".managedhsm.azure.net"
+		} else {
+			vault += ".vault.azure.net"
+		}
+	}
+	return &keyID{
+		vault:   "https://" + vault,
+		name:    keyName,
+		version: values["version"],
+	}, nil
+}
+
+// keyVersion returns the version in a key identifier.
+func keyVersion(kid string) string {
+	if i := strings.LastIndex(kid, "/"); i >= 0 {
+		return kid[i+1:]
+	}
+	return ""
+}
+
+// parseJSONWebKey returns the public key of a Key Vault EC or RSA key.
+func parseJSONWebKey(jwk *JSONWebKey) (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(b) == 0 {
+			return nil, errors.Errorf("error parsing azurekms key %s", jwk.Kid)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch strings.TrimSuffix(jwk.Kty, "-HSM") {
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("azurekms key %s has an unsupported curve %s", jwk.Kid, jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.Errorf("error parsing azurekms key %s: invalid point", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.Errorf("error parsing azurekms key %s: invalid exponent", jwk.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	default:
+		return nil, errors.Errorf("azurekms key %s has an unsupported type %s", jwk.Kid, jwk.Kty)
+	}
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
@@ -0,0 +1,346 @@
+package azurekms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+const testVault = "https://my-vault.vault.azure.net"
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func ecJSONWebKey(pub *ecdsa.PublicKey, kid string) JSONWebKey {
+	return JSONWebKey{
+		Kid: kid,
+		Kty: "EC",
+		Crv: pub.Curve.Params().Name,
+		X:   encodeInt(pub.X),
+		Y:   encodeInt(pub.Y),
+	}
+}
+
+func rsaJSONWebKey(pub *rsa.PublicKey, kid string) JSONWebKey {
+	return JSONWebKey{
+		Kid: kid,
+		Kty: "RSA-HSM",
+		N:   encodeInt(pub.N),
+		E:   encodeInt(big.NewInt(int64(pub.E))),
+	}
+}
+
+func TestNew(t *testing.T) {
+	got, err := New(context.Background(), apiv1.Options{Type: "azurekms", ClientID: "client-id"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if c, ok := got.client.(*httpClient); !ok || c.clientID != "client-id" {
+		t.Errorf("New() client = %#v", got.client)
+	}
+	if err := got.Close(); err != nil {
+		t.Errorf("KeyVault.Close() error = %v", err)
+	}
+}
+
+func TestKeyVault_GetPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &MockClient{
+		getKey: func(ctx context.Context, vault, name, version string) (*KeyBundle, error) {
+			if vault != testVault || name != "my-key" {
+				return nil, fmt.Errorf("key %s/%s not found", vault, name)
+			}
+			return &KeyBundle{Key: ecJSONWebKey(&key.PublicKey, testVault+"/keys/my-key/v1")}, nil
+		},
+	}
+	tests := []struct {
+		name    string
+		keyName string
+		wantErr bool
+	}{
+		{"ok", "azurekms:vault=my-vault;name=my-key", false},
+		{"ok version", "azurekms:vault=my-vault;name=my-key;version=v1", false},
+		{"ok url", testVault + "/keys/my-key/v1", false},
+		{"fail name", "", true},
+		{"fail parse", "my-key", true},
+		{"fail get key", "azurekms:vault=my-vault;name=missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKeyVault(client)
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyVault.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, &key.PublicKey) {
+				t.Errorf("KeyVault.GetPublicKey() = %v, want %v", got, &key.PublicKey)
+			}
+		})
+	}
+}
+
+func TestKeyVault_CreateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		req        *apiv1.CreateKeyRequest
+		wantParams *KeyCreateParameters
+		wantName   string
+		wantErr    bool
+	}{
+		{"ok default", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-vault;name=my-key"},
+			&KeyCreateParameters{Kty: "EC", Crv: "P-256", KeyOps: []string{"sign", "verify"}},
+			"azurekms:vault=my-vault.vault.azure.net;name=my-key;version=v1", false},
+		{"ok hsm", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-vault;name=my-key", SignatureAlgorithm: apiv1.ECDSAWithSHA384, ProtectionLevel: apiv1.HSM},
+			&KeyCreateParameters{Kty: "EC-HSM", Crv: "P-384", KeyOps: []string{"sign", "verify"}},
+			"azurekms:vault=my-vault.vault.azure.net;name=my-key;version=v1", false},
+		{"ok managed hsm", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-hsm;name=my-key;hsm=true", SignatureAlgorithm: apiv1.SHA256WithRSA},
+			&KeyCreateParameters{Kty: "RSA-HSM", KeySize: 3072, KeyOps: []string{"sign", "verify"}},
+			"azurekms:vault=my-hsm.managedhsm.azure.net;name=my-key;version=v1", false},
+		{"ok rsa bits", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-vault;name=my-key", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 4096},
+			&KeyCreateParameters{Kty: "RSA", KeySize: 4096, KeyOps: []string{"sign", "verify"}},
+			"azurekms:vault=my-vault.vault.azure.net;name=my-key;version=v1", false},
+		{"fail name", &apiv1.CreateKeyRequest{}, nil, "", true},
+		{"fail parse", &apiv1.CreateKeyRequest{Name: "azurekms:name=my-key"}, nil, "", true},
+		{"fail version", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-vault;name=my-key;version=v1"}, nil, "", true},
+		{"fail Ed25519", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-vault;name=my-key", SignatureAlgorithm: apiv1.PureEd25519}, nil, "", true},
+		{"fail create", &apiv1.CreateKeyRequest{Name: "azurekms:vault=my-vault;name=fail"}, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKeyVault(&MockClient{
+				createKey: func(ctx context.Context, vault, name string, params *KeyCreateParameters) (*KeyBundle, error) {
+					if name == "fail" {
+						return nil, fmt.Errorf("an error")
+					}
+					if !reflect.DeepEqual(params, tt.wantParams) {
+						t.Errorf("CreateKey() params = %v, want %v", params, tt.wantParams)
+					}
+					kid := vault + "/keys/" + name + "/v1"
+					if params.Kty == "EC" || params.Kty == "EC-HSM" {
+						return &KeyBundle{Key: ecJSONWebKey(&ecKey.PublicKey, kid)}, nil
+					}
+					return &KeyBundle{Key: rsaJSONWebKey(&rsaKey.PublicKey, kid)}, nil
+				},
+			})
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyVault.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.wantName || got.CreateSignerRequest.SigningKey != tt.wantName {
+				t.Errorf("KeyVault.CreateKey() name = %s, want %s", got.Name, tt.wantName)
+			}
+			if got.PublicKey == nil {
+				t.Error("KeyVault.CreateKey() public key is nil")
+			}
+		})
+	}
+}
+
+func TestKeyVault_CreateSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &MockClient{
+		getKey: func(ctx context.Context, vault, name, version string) (*KeyBundle, error) {
+			if name != "my-key" {
+				return nil, fmt.Errorf("an error")
+			}
+			if version == "" {
+				version = "latest"
+			}
+			return &KeyBundle{Key: ecJSONWebKey(&key.PublicKey, vault+"/keys/my-key/"+version)}, nil
+		},
+	}
+	tests := []struct {
+		name       string
+		signingKey string
+		want       *Signer
+		wantErr    bool
+	}{
+		{"ok pinned", "azurekms:vault=my-vault;name=my-key;version=v1", &Signer{client, testVault, "my-key", "v1", &key.PublicKey}, false},
+		{"ok latest", "azurekms:vault=my-vault;name=my-key", &Signer{client, testVault, "my-key", "latest", &key.PublicKey}, false},
+		{"fail signing key", "", nil, true},
+		{"fail parse", "azurekms:vault=my-vault", nil, true},
+		{"fail get key", "azurekms:vault=my-vault;name=other", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKeyVault(client)
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyVault.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.CreateSigner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_LoadCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Intermediate"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &MockClient{
+		getCertificate: func(ctx context.Context, vault, name, version string) (*CertificateBundle, error) {
+			switch name {
+			case "my-cert":
+				return &CertificateBundle{Cer: der}, nil
+			case "bad-cert":
+				return &CertificateBundle{Cer: []byte("foo")}, nil
+			default:
+				return nil, fmt.Errorf("an error")
+			}
+		},
+	}
+	tests := []struct {
+		name     string
+		certName string
+		wantErr  bool
+	}{
+		{"ok", "azurekms:vault=my-vault;name=my-cert", false},
+		{"ok url", testVault + "/certificates/my-cert", false},
+		{"fail name", "", true},
+		{"fail url", testVault + "/keys/my-cert", true},
+		{"fail get", "azurekms:vault=my-vault;name=missing", true},
+		{"fail parse", "azurekms:vault=my-vault;name=bad-cert", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKeyVault(client)
+			got, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: tt.certName})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyVault.LoadCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, crt) {
+				t.Errorf("KeyVault.LoadCertificate() = %v, want %v", got, crt)
+			}
+		})
+	}
+}
+
+func Test_parseName(t *testing.T) {
+	tests := []struct {
+		name       string
+		keyName    string
+		collection string
+		want       *keyID
+		wantErr    bool
+	}{
+		{"ok", "azurekms:vault=my-vault;name=my-key", "keys", &keyID{testVault, "my-key", ""}, false},
+		{"ok version", "azurekms:name=my-key;vault=my-vault;version=0123", "keys", &keyID{testVault, "my-key", "0123"}, false},
+		{"ok managed hsm", "azurekms:vault=my-hsm;name=my-key;hsm=true", "keys", &keyID{"https://my-hsm.managedhsm.azure.net", "my-key", ""}, false},
+		{"ok host", "azurekms:vault=my-vault.vault.azure.cn;name=my-key", "keys", &keyID{"https://my-vault.vault.azure.cn", "my-key", ""}, false},
+		{"ok url", testVault + "/keys/my-key", "keys", &keyID{testVault, "my-key", ""}, false},
+		{"ok url version", testVault + "/keys/my-key/0123", "keys", &keyID{testVault, "my-key", "0123"}, false},
+		{"ok url certificate", testVault + "/certificates/my-cert/0123", "certificates", &keyID{testVault, "my-cert", "0123"}, false},
+		{"fail scheme", "my-key", "keys", nil, true},
+		{"fail vault", "azurekms:name=my-key", "keys", nil, true},
+		{"fail key name", "azurekms:vault=my-vault", "keys", nil, true},
+		{"fail attribute", "azurekms:vault", "keys", nil, true},
+		{"fail escape", "azurekms:vault=my-vault;name=%zz", "keys", nil, true},
+		{"fail url collection", testVault + "/secrets/my-key", "keys", nil, true},
+		{"fail url path", testVault + "/keys/my-key/0123/foo", "keys", nil, true},
+		{"fail url empty", testVault + "/keys/", "keys", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseName(tt.keyName, tt.collection)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseJSONWebKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badPoint := ecJSONWebKey(&ecKey.PublicKey, "kid")
+	badPoint.Y = badPoint.X
+	badCurve := ecJSONWebKey(&ecKey.PublicKey, "kid")
+	badCurve.Crv = "P-256K"
+	badX := ecJSONWebKey(&ecKey.PublicKey, "kid")
+	badX.X = "%%"
+	badE := rsaJSONWebKey(&rsaKey.PublicKey, "kid")
+	badE.E = ""
+
+	tests := []struct {
+		name    string
+		jwk     JSONWebKey
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok ec", ecJSONWebKey(&ecKey.PublicKey, "kid"), &ecKey.PublicKey, false},
+		{"ok rsa", rsaJSONWebKey(&rsaKey.PublicKey, "kid"), &rsaKey.PublicKey, false},
+		{"fail point", badPoint, nil, true},
+		{"fail curve", badCurve, nil, true},
+		{"fail decode", badX, nil, true},
+		{"fail exponent", badE, nil, true},
+		{"fail type", JSONWebKey{Kty: "oct"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSONWebKey(&tt.jwk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJSONWebKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseJSONWebKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
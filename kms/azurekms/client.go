@@ -0,0 +1,261 @@
+package azurekms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// apiVersion is the version of the Key Vault REST API.
+	apiVersion = "7.1"
+	// imdsEndpoint is the managed identity endpoint of the Azure Instance
+	// Metadata Service available in virtual machines.
+	imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// tokenRefreshMargin is the time before the expiration when a token is
+	// refreshed.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// accessToken is an OAuth token for a Key Vault resource.
+type accessToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// httpClient implements KeyVaultClient using the Key Vault REST API. It
+// authenticates with the managed identity of the resource, using the
+// environment variables IDENTITY_ENDPOINT and IDENTITY_HEADER if they are
+// available, e.g. on App Service, or the Instance Metadata Service.
+type httpClient struct {
+	client   *http.Client
+	clientID string
+	endpoint string
+	header   string
+	tokens   map[string]*accessToken
+	mutex    sync.Mutex
+}
+
+func newHTTPClient(clientID string) *httpClient {
+	c := &httpClient{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		clientID: clientID,
+		endpoint: imdsEndpoint,
+		tokens:   make(map[string]*accessToken),
+	}
+	if endpoint, header := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && header != "" {
+		c.endpoint = endpoint
+		c.header = header
+	}
+	return c
+}
+
+func (c *httpClient) GetKey(ctx context.Context, vault, name, version string) (*KeyBundle, error) {
+	var bundle KeyBundle
+	if err := c.do(ctx, http.MethodGet, vault, objectPath("keys", name, version), nil, &bundle); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+func (c *httpClient) CreateKey(ctx context.Context, vault, name string, params *KeyCreateParameters) (*KeyBundle, error) {
+	var bundle KeyBundle
+	if err := c.do(ctx, http.MethodPost, vault, objectPath("keys", name, "")+"/create", params, &bundle); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+func (c *httpClient) Sign(ctx context.Context, vault, name, version string, params *KeySignParameters) (*KeyOperationResult, error) {
+	var result KeyOperationResult
+	if err := c.do(ctx, http.MethodPost, vault, objectPath("keys", name, version)+"/sign", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *httpClient) GetCertificate(ctx context.Context, vault, name, version string) (*CertificateBundle, error) {
+	var bundle CertificateBundle
+	if err := c.do(ctx, http.MethodGet, vault, objectPath("certificates", name, version), nil, &bundle); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// do sends a request to the vault and decodes the JSON response in v.
+func (c *httpClient) do(ctx context.Context, method, vault, path string, body, v interface{}) error {
+	token, err := c.getToken(ctx, resourceName(vault))
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling request")
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, vault+path+"?api-version="+apiVersion, r)
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed", method, req.URL)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", req.URL)
+	}
+	if resp.StatusCode >= 400 {
+		return newError(resp.StatusCode, b)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrapf(err, "error decoding %s", req.URL)
+	}
+	return nil
+}
+
+// getToken returns a cached access token for the resource, or requests a new
+// one to the managed identity endpoint.
+func (c *httpClient) getToken(ctx context.Context, resource string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t, ok := c.tokens[resource]; ok && time.Now().Add(tokenRefreshMargin).Before(t.expiresAt) {
+		return t.value, nil
+	}
+
+	q := url.Values{}
+	q.Set("resource", resource)
+	if c.clientID != "" {
+		q.Set("client_id", c.clientID)
+	}
+	if c.header != "" {
+		q.Set("api-version", "2019-08-01")
+	} else {
+		q.Set("api-version", "2018-02-01")
+	}
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating managed identity request")
+	}
+	req = req.WithContext(ctx)
+	if c.header != "" {
+		req.Header.Set("X-IDENTITY-HEADER", c.header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting managed identity token")
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting managed identity token")
+	}
+	if resp.StatusCode >= 400 {
+		return "", errors.Wrap(newError(resp.StatusCode, b), "error getting managed identity token")
+	}
+
+	// The endpoints return expires_on as a string or a number.
+	var tok struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return "", errors.Wrap(err, "error decoding managed identity token")
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("error getting managed identity token: access_token is empty")
+	}
+	expiresOn, err := strconv.ParseInt(tok.ExpiresOn.String(), 10, 64)
+	if err != nil {
+		return "", errors.Wrap(err, "error decoding managed identity token")
+	}
+	c.tokens[resource] = &accessToken{
+		value:     tok.AccessToken,
+		expiresAt: time.Unix(expiresOn, 0),
+	}
+	return tok.AccessToken, nil
+}
+
+// resourceName returns the OAuth resource of a vault, the vault URL without
+// the vault name, e.g. https://vault.azure.net or https://managedhsm.azure.net.
+func resourceName(vault string) string {
+	u, err := url.Parse(vault)
+	if err != nil {
+		return vault
+	}
+	host := u.Host
+	if i := strings.Index(host, "."); i >= 0 {
+		host = host[i+1:]
+	}
+	return u.Scheme + "://" + host
+}
+
+func objectPath(collection, name, version string) string {
+	p := "/" + collection + "/" + url.PathEscape(name)
+	if version != "" {
+		p += "/" + url.PathEscape(version)
+	}
+	return p
+}
+
+// Error is an error returned by Key Vault or the managed identity endpoint.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func newError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode}
+	// Key Vault errors are {"error":{"code":"...","message":"..."}}, and
+	// managed identity errors are {"error":"...","error_description":"..."}.
+	var kv struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	var mi struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &kv); err == nil && kv.Error.Code != "" {
+		e.Code, e.Message = kv.Error.Code, kv.Error.Message
+	} else if err := json.Unmarshal(body, &mi); err == nil && mi.Error != "" {
+		e.Code, e.Message = mi.Error, mi.Description
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("azurekms: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("azurekms: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
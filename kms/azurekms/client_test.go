@@ -0,0 +1,161 @@
+package azurekms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHTTPClient(t *testing.T) {
+	var tokenRequests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		q := r.URL.Query()
+		switch {
+		case r.Header.Get("X-IDENTITY-HEADER") != "secret":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request","error_description":"missing header"}`)
+		case q.Get("client_id") != "client-id" || q.Get("api-version") != "2019-08-01":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request","error_description":"bad query"}`)
+		default:
+			fmt.Fprintf(w, `{"access_token":"token-%s","expires_on":"%d"}`, q.Get("resource"), time.Now().Add(time.Hour).Unix())
+		}
+	})
+	mux.HandleFunc("/keys/my-key/v1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" || r.URL.Query().Get("api-version") != apiVersion {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"key":{"kid":"https://my-vault.vault.azure.net/keys/my-key/v1","kty":"EC","crv":"P-256"}}`)
+	})
+	mux.HandleFunc("/keys/my-key/create", func(w http.ResponseWriter, r *http.Request) {
+		var params KeyCreateParameters
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&params) != nil || params.Kty != "EC" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"key":{"kid":"https://my-vault.vault.azure.net/keys/my-key/v2","kty":"EC","crv":"P-256"}}`)
+	})
+	mux.HandleFunc("/keys/my-key/v1/sign", func(w http.ResponseWriter, r *http.Request) {
+		var params KeySignParameters
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&params) != nil || params.Algorithm != "ES256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"kid":"https://my-vault.vault.azure.net/keys/my-key/v1","value":"%s"}`, params.Value)
+	})
+	mux.HandleFunc("/certificates/my-cert", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"https://my-vault.vault.azure.net/certificates/my-cert/v1","cer":"AQID"}`)
+	})
+	mux.HandleFunc("/keys/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"KeyNotFound","message":"A key with (name/id) missing was not found in this key vault."}}`)
+	})
+	mux.HandleFunc("/keys/bad-json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	os.Setenv("IDENTITY_ENDPOINT", srv.URL+"/token")
+	os.Setenv("IDENTITY_HEADER", "secret")
+	defer os.Unsetenv("IDENTITY_ENDPOINT")
+	defer os.Unsetenv("IDENTITY_HEADER")
+
+	ctx := context.Background()
+	c := newHTTPClient("client-id")
+	vault := srv.URL
+
+	bundle, err := c.GetKey(ctx, vault, "my-key", "v1")
+	if err != nil {
+		t.Fatalf("httpClient.GetKey() error = %v", err)
+	}
+	if bundle.Key.Kid != "https://my-vault.vault.azure.net/keys/my-key/v1" {
+		t.Errorf("httpClient.GetKey() = %v", bundle)
+	}
+	bundle, err = c.CreateKey(ctx, vault, "my-key", &KeyCreateParameters{Kty: "EC", Crv: "P-256"})
+	if err != nil {
+		t.Fatalf("httpClient.CreateKey() error = %v", err)
+	}
+	if keyVersion(bundle.Key.Kid) != "v2" {
+		t.Errorf("httpClient.CreateKey() = %v", bundle)
+	}
+	result, err := c.Sign(ctx, vault, "my-key", "v1", &KeySignParameters{Algorithm: "ES256", Value: "AQID"})
+	if err != nil {
+		t.Fatalf("httpClient.Sign() error = %v", err)
+	}
+	if result.Result != "AQID" {
+		t.Errorf("httpClient.Sign() = %v", result)
+	}
+	crt, err := c.GetCertificate(ctx, vault, "my-cert", "")
+	if err != nil {
+		t.Fatalf("httpClient.GetCertificate() error = %v", err)
+	}
+	if !reflect.DeepEqual(crt.Cer, []byte{1, 2, 3}) {
+		t.Errorf("httpClient.GetCertificate() = %v", crt)
+	}
+
+	// The token is reused.
+	if tokenRequests != 1 {
+		t.Errorf("token requests = %d, want 1", tokenRequests)
+	}
+
+	_, err = c.GetKey(ctx, vault, "missing", "")
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound || e.Code != "KeyNotFound" {
+		t.Errorf("httpClient.GetKey() error = %v, want KeyNotFound", err)
+	}
+	if _, err = c.GetKey(ctx, vault, "bad-json", ""); err == nil {
+		t.Error("httpClient.GetKey() error = nil, wantErr true")
+	}
+
+	// Token errors.
+	c = newHTTPClient("other-id")
+	if _, err := c.GetKey(ctx, vault, "my-key", "v1"); err == nil {
+		t.Error("httpClient.GetKey() error = nil, wantErr true")
+	}
+}
+
+func Test_resourceName(t *testing.T) {
+	tests := []struct {
+		vault string
+		want  string
+	}{
+		{"https://my-vault.vault.azure.net", "https://vault.azure.net"},
+		{"https://my-hsm.managedhsm.azure.net", "https://managedhsm.azure.net"},
+		{"https://my-vault.vault.azure.cn", "https://vault.azure.cn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vault, func(t *testing.T) {
+			if got := resourceName(tt.vault); got != tt.want {
+				t.Errorf("resourceName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{"key vault", newError(404, []byte(`{"error":{"code":"KeyNotFound","message":"not found"}}`)), "azurekms: 404 KeyNotFound: not found"},
+		{"managed identity", newError(400, []byte(`{"error":"invalid_request","error_description":"bad"}`)), "azurekms: 400 invalid_request: bad"},
+		{"empty", newError(500, nil), "azurekms: 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
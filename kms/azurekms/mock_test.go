@@ -0,0 +1,28 @@
+package azurekms
+
+import (
+	"context"
+)
+
+type MockClient struct {
+	getKey         func(ctx context.Context, vault, name, version string) (*KeyBundle, error)
+	createKey      func(ctx context.Context, vault, name string, params *KeyCreateParameters) (*KeyBundle, error)
+	sign           func(ctx context.Context, vault, name, version string, params *KeySignParameters) (*KeyOperationResult, error)
+	getCertificate func(ctx context.Context, vault, name, version string) (*CertificateBundle, error)
+}
+
+func (m *MockClient) GetKey(ctx context.Context, vault, name, version string) (*KeyBundle, error) {
+	return m.getKey(ctx, vault, name, version)
+}
+
+func (m *MockClient) CreateKey(ctx context.Context, vault, name string, params *KeyCreateParameters) (*KeyBundle, error) {
+	return m.createKey(ctx, vault, name, params)
+}
+
+func (m *MockClient) Sign(ctx context.Context, vault, name, version string, params *KeySignParameters) (*KeyOperationResult, error) {
+	return m.sign(ctx, vault, name, version, params)
+}
+
+func (m *MockClient) GetCertificate(ctx context.Context, vault, name, version string) (*CertificateBundle, error) {
+	return m.getCertificate(ctx, vault, name, version)
+}
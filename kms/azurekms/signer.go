@@ -0,0 +1,124 @@
+package azurekms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using a key in Azure Key Vault. The signer
+// always uses the same version of the key.
+type Signer struct {
+	client    KeyVaultClient
+	vault     string
+	name      string
+	version   string
+	publicKey crypto.PublicKey
+}
+
+// NewSigner creates a new signer using the given version of a key.
+func NewSigner(client KeyVaultClient, vault, name, version string, pub crypto.PublicKey) *Signer {
+	return &Signer{
+		client:    client,
+		vault:     vault,
+		name:      name,
+		version:   version,
+		publicKey: pub,
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the key stored in Azure Key Vault.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := signatureAlgorithm(s.publicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != opts.HashFunc().Size() {
+		return nil, errors.New("digest length does not match hash function")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := s.client.Sign(ctx, s.vault, s.name, s.version, &KeySignParameters{
+		Algorithm: alg,
+		Value:     base64.RawURLEncoding.EncodeToString(digest),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "azurekms Sign failed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(resp.Result)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding azurekms signature")
+	}
+
+	// Key Vault returns the concatenation of r and s.
+	if _, ok := s.publicKey.(*ecdsa.PublicKey); ok {
+		return ecdsaSignature(sig)
+	}
+	return sig, nil
+}
+
+// signatureAlgorithm returns the Key Vault algorithm for the given key and
+// options. Key Vault only supports RSA-PSS with a salt length equal to the hash
+// length.
+func signatureAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	var suffix string
+	switch h := opts.HashFunc(); h {
+	case crypto.SHA256:
+		suffix = "256"
+	case crypto.SHA384:
+		suffix = "384"
+	case crypto.SHA512:
+		suffix = "512"
+	default:
+		return "", errors.Errorf("unsupported hash function %v", h)
+	}
+
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ES" + suffix, nil
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			switch pss.SaltLength {
+			case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash, opts.HashFunc().Size():
+				return "PS" + suffix, nil
+			default:
+				return "", errors.Errorf("unsupported salt length %d", pss.SaltLength)
+			}
+		}
+		return "RS" + suffix, nil
+	default:
+		return "", errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// ecdsaSignature converts the concatenation of r and s to the ASN.1 format used
+// by Go.
+func ecdsaSignature(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("azurekms returned an invalid ECDSA signature")
+	}
+	n := len(sig) / 2
+	b, err := asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(sig[:n]),
+		S: new(big.Int).SetBytes(sig[n:]),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling ECDSA signature")
+	}
+	return b, nil
+}
@@ -0,0 +1,164 @@
+package azurekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// softSign signs the digest like Key Vault.
+func softSign(key crypto.Signer, params *KeySignParameters) (string, error) {
+	digest, err := base64.RawURLEncoding.DecodeString(params.Value)
+	if err != nil {
+		return "", err
+	}
+	var sig []byte
+	switch params.Algorithm {
+	case "ES256", "ES384":
+		k := key.(*ecdsa.PrivateKey)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return "", err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[size-len(rb):size], rb)
+		copy(sig[2*size-len(sb):], sb)
+	case "RS256":
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest); err != nil {
+			return "", err
+		}
+	case "PS256":
+		if sig, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported algorithm %s", params.Algorithm)
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func TestSigner_Sign(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]crypto.Signer{"ec": ecKey, "rsa": rsaKey}
+	client := &MockClient{
+		sign: func(ctx context.Context, vault, name, version string, params *KeySignParameters) (*KeyOperationResult, error) {
+			if version != "v1" {
+				return nil, fmt.Errorf("unexpected version %s", version)
+			}
+			key, ok := keys[name]
+			if !ok {
+				return nil, fmt.Errorf("an error")
+			}
+			if name == "ec" && vault == "bad" {
+				return &KeyOperationResult{Result: "AA"}, nil
+			}
+			if vault == "bad" {
+				return &KeyOperationResult{Result: "%%"}, nil
+			}
+			sig, err := softSign(key, params)
+			if err != nil {
+				return nil, err
+			}
+			return &KeyOperationResult{Result: sig}, nil
+		},
+	}
+	ecSigner := NewSigner(client, testVault, "ec", "v1", &ecKey.PublicKey)
+	rsaSigner := NewSigner(client, testVault, "rsa", "v1", &rsaKey.PublicKey)
+
+	sum256 := sha256.Sum256([]byte("data"))
+	sum384 := sha512.Sum384([]byte("data"))
+	tests := []struct {
+		name    string
+		signer  *Signer
+		digest  []byte
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok ecdsa", ecSigner, sum256[:], crypto.SHA256, false},
+		{"ok rsa", rsaSigner, sum256[:], crypto.SHA256, false},
+		{"ok rsa-pss", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, false},
+		{"ok rsa-pss salt", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}, false},
+		{"fail hash", ecSigner, sum256[:], crypto.SHA1, true},
+		{"fail digest", ecSigner, sum384[:], crypto.SHA256, true},
+		{"fail salt", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, true},
+		{"fail sign", NewSigner(client, testVault, "missing", "v1", &ecKey.PublicKey), sum256[:], crypto.SHA256, true},
+		{"fail decode", NewSigner(client, "bad", "rsa", "v1", &rsaKey.PublicKey), sum256[:], crypto.SHA256, true},
+		{"fail ecdsa signature", NewSigner(client, "bad", "ec", "v1", &ecKey.PublicKey), sum256[:], crypto.SHA256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			switch pub := tt.signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				var esig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub, tt.digest, esig.R, esig.S) {
+					t.Error("Signer.Sign() signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if _, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, crypto.SHA256, tt.digest, sig, nil)
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, tt.digest, sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_signatureAlgorithm(t *testing.T) {
+	tests := []struct {
+		name    string
+		pub     crypto.PublicKey
+		opts    crypto.SignerOpts
+		want    string
+		wantErr bool
+	}{
+		{"ES256", &ecdsa.PublicKey{}, crypto.SHA256, "ES256", false},
+		{"ES384", &ecdsa.PublicKey{}, crypto.SHA384, "ES384", false},
+		{"ES512", &ecdsa.PublicKey{}, crypto.SHA512, "ES512", false},
+		{"RS256", &rsa.PublicKey{}, crypto.SHA256, "RS256", false},
+		{"RS512", &rsa.PublicKey{}, crypto.SHA512, "RS512", false},
+		{"PS384", &rsa.PublicKey{}, &rsa.PSSOptions{Hash: crypto.SHA384}, "PS384", false},
+		{"fail key", []byte("foo"), crypto.SHA256, "", true},
+		{"fail hash", &rsa.PublicKey{}, crypto.MD5, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := signatureAlgorithm(tt.pub, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("signatureAlgorithm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("signatureAlgorithm() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -69,6 +69,7 @@ type KeyManagementClient interface {
 	GetKeyRing(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateKeyRing(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	Decrypt(context.Context, *kmspb.DecryptRequest, ...gax.CallOption) (*kmspb.DecryptResponse, error)
 }
 
 // CloudKMS implements a KMS using Google's Cloud apiv1.
@@ -118,6 +119,27 @@ func (k *CloudKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer,
 	return NewSigner(k.client, req.SigningKey), nil
 }
 
+// Decrypt decrypts the ciphertext using the symmetric key with the given
+// name, e.g. a ciphertext created with `gcloud kms encrypt`. The name is the
+// name of the key, not of a key version.
+func (k *CloudKMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := k.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:       req.Name,
+		Ciphertext: req.Ciphertext,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cloudKMS Decrypt failed")
+	}
+	return response.Plaintext, nil
+}
+
 // CreateKey creates in Google's Cloud KMS a new asymmetric key for signing.
 func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	if req.Name == "" {
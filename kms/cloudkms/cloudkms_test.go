@@ -328,6 +328,59 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 	}
 }
 
+func TestCloudKMS_Decrypt(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	testError := fmt.Errorf("an error")
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{
+			&MockClient{
+				decrypt: func(_ context.Context, req *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+					if req.Name != keyName || string(req.Ciphertext) != "ciphertext" {
+						return nil, testError
+					}
+					return &kmspb.DecryptResponse{Plaintext: []byte("password")}, nil
+				},
+			}},
+			args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext")}}, []byte("password"), false},
+		{"fail name", fields{&MockClient{}}, args{&apiv1.DecryptRequest{Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail decrypt", fields{
+			&MockClient{
+				decrypt: func(_ context.Context, _ *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+					return nil, testError
+				},
+			}},
+			args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.Decrypt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestCloudKMS_GetPublicKey(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
 	testError := fmt.Errorf("an error")
@@ -0,0 +1,35 @@
+package kms
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// CreateCertificateRequest creates a new key in the KMS and a certificate
+// signing request for it signed by the new key, so the private key does not
+// need to leave the KMS to get a certificate.
+func CreateCertificateRequest(km KeyManager, req *apiv1.CreateKeyRequest, tmpl *x509.CertificateRequest) (*apiv1.CreateKeyResponse, *x509.CertificateRequest, error) {
+	resp, err := km.CreateKey(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating key")
+	}
+	signer, err := km.CreateSigner(&resp.CreateSignerRequest)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating signer")
+	}
+	b, err := x509.CreateCertificateRequest(rand.Reader, tmpl, signer)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing certificate request")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, errors.Wrap(err, "error validating certificate request")
+	}
+	return resp, csr, nil
+}
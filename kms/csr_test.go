@@ -0,0 +1,70 @@
+package kms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/softkms"
+)
+
+type failKeyManager struct {
+	KeyManager
+	createKeyErr, createSignerErr error
+}
+
+func (m *failKeyManager) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if m.createKeyErr != nil {
+		return nil, m.createKeyErr
+	}
+	return m.KeyManager.CreateKey(req)
+}
+
+func (m *failKeyManager) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if m.createSignerErr != nil {
+		return nil, m.createSignerErr
+	}
+	return m.KeyManager.CreateSigner(req)
+}
+
+func TestCreateCertificateRequest(t *testing.T) {
+	km := &softkms.SoftKMS{}
+	tmpl := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "Intermediate CA"},
+	}
+
+	tests := []struct {
+		name    string
+		km      KeyManager
+		req     *apiv1.CreateKeyRequest
+		wantErr bool
+	}{
+		{"ok", km, &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, false},
+		{"ok ed25519", km, &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.PureEd25519}, false},
+		{"fail algorithm", km, &apiv1.CreateKeyRequest{Name: "intermediate", SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}, true},
+		{"fail createKey", &failKeyManager{KeyManager: km, createKeyErr: errors.New("an error")}, &apiv1.CreateKeyRequest{Name: "intermediate"}, true},
+		{"fail createSigner", &failKeyManager{KeyManager: km, createSignerErr: errors.New("an error")}, &apiv1.CreateKeyRequest{Name: "intermediate"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, csr, err := CreateCertificateRequest(tt.km, tt.req, tmpl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateCertificateRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(csr.PublicKey, resp.PublicKey) {
+				t.Errorf("CreateCertificateRequest() PublicKey = %v, want %v", csr.PublicKey, resp.PublicKey)
+			}
+			if csr.Subject.CommonName != "Intermediate CA" {
+				t.Errorf("CreateCertificateRequest() Subject = %v", csr.Subject)
+			}
+		})
+	}
+}
@@ -3,12 +3,19 @@ package kms
 import (
 	"context"
 	"crypto"
+	"crypto/x509"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/azurekms"
 	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkcs11"
+	"github.com/smallstep/certificates/kms/remotekms"
 	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/certificates/kms/tpmkms"
+	"github.com/smallstep/certificates/kms/vaultkms"
+	"github.com/smallstep/certificates/kms/yubikey"
 )
 
 // KeyManager is the interface implemented by all the KMS.
@@ -19,6 +26,24 @@ type KeyManager interface {
 	Close() error
 }
 
+// CertificateManager is the interface implemented by the KMS that can also
+// store certificates.
+type CertificateManager interface {
+	LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error)
+}
+
+// Attester is the interface implemented by the KMS that can attest that a key
+// was generated in the device.
+type Attester interface {
+	CreateAttestation(req *apiv1.CreateAttestationRequest) (*apiv1.CreateAttestationResponse, error)
+}
+
+// Decrypter is the interface implemented by the KMS that can decrypt data
+// encrypted with a symmetric key stored in them.
+type Decrypter interface {
+	Decrypt(req *apiv1.DecryptRequest) ([]byte, error)
+}
+
 // New initializes a new KMS from the given type.
 func New(ctx context.Context, opts apiv1.Options) (KeyManager, error) {
 	if err := opts.Validate(); err != nil {
@@ -30,6 +55,18 @@ func New(ctx context.Context, opts apiv1.Options) (KeyManager, error) {
 		return softkms.New(ctx, opts)
 	case apiv1.CloudKMS:
 		return cloudkms.New(ctx, opts)
+	case apiv1.PKCS11:
+		return pkcs11.New(ctx, opts)
+	case apiv1.AzureKMS:
+		return azurekms.New(ctx, opts)
+	case apiv1.YubiKey:
+		return yubikey.New(ctx, opts)
+	case apiv1.TPMKMS:
+		return tpmkms.New(ctx, opts)
+	case apiv1.VaultKMS:
+		return vaultkms.New(ctx, opts)
+	case apiv1.RemoteKMS:
+		return remotekms.New(ctx, opts)
 	default:
 		return nil, errors.Errorf("unsupported kms type '%s'", opts.Type)
 	}
@@ -7,8 +7,14 @@ import (
 	"testing"
 
 	"github.com/smallstep/certificates/kms/apiv1"
+	"github.com/smallstep/certificates/kms/azurekms"
 	"github.com/smallstep/certificates/kms/cloudkms"
+	"github.com/smallstep/certificates/kms/pkcs11"
+	"github.com/smallstep/certificates/kms/remotekms"
 	"github.com/smallstep/certificates/kms/softkms"
+	"github.com/smallstep/certificates/kms/tpmkms"
+	"github.com/smallstep/certificates/kms/vaultkms"
+	"github.com/smallstep/certificates/kms/yubikey"
 )
 
 func TestNew(t *testing.T) {
@@ -29,7 +35,13 @@ func TestNew(t *testing.T) {
 		{"default", false, args{ctx, apiv1.Options{}}, &softkms.SoftKMS{}, false},
 		{"cloudkms", true, args{ctx, apiv1.Options{Type: "cloudkms"}}, &cloudkms.CloudKMS{}, true}, // fails because not credentials
 		{"awskms", false, args{ctx, apiv1.Options{Type: "awskms"}}, nil, true},                     // not yet supported
-		{"pkcs11", false, args{ctx, apiv1.Options{Type: "pkcs11"}}, nil, true},                     // not yet supported
+		{"pkcs11", false, args{ctx, apiv1.Options{Type: "pkcs11"}}, nil, true},                     // fails because not module
+		{"pkcs11 missing module", false, args{ctx, apiv1.Options{Type: "pkcs11", Module: "testdata/missing.so"}}, &pkcs11.PKCS11{}, true},
+		{"azurekms", false, args{ctx, apiv1.Options{Type: "azurekms"}}, &azurekms.KeyVault{}, false},
+		{"yubikey", false, args{ctx, apiv1.Options{Type: "yubikey"}}, &yubikey.YubiKey{}, true}, // fails because there is no yubikey
+		{"tpmkms", false, args{ctx, apiv1.Options{Type: "tpmkms", Device: "testdata/missing"}}, &tpmkms.TPMKMS{}, true},
+		{"vaultkms", false, args{ctx, apiv1.Options{Type: "vaultkms", Address: "http://127.0.0.1:1", Token: "token"}}, &vaultkms.VaultKMS{}, true},
+		{"remotekms", false, args{ctx, apiv1.Options{Type: "remotekms", Address: "127.0.0.1:1", Certificate: "testdata/missing.crt", CertificateKey: "testdata/missing.key", Root: "testdata/missing.crt"}}, &remotekms.RemoteKMS{}, true},
 		{"fail validation", false, args{ctx, apiv1.Options{Type: "foobar"}}, nil, true},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,193 @@
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// MockModule is a software token implementing the Module interface.
+type MockModule struct {
+	objects map[ObjectHandle]map[uint]interface{}
+	keys    map[ObjectHandle]crypto.Signer
+	next    ObjectHandle
+	err     error
+	closed  bool
+}
+
+func newMockModule() *MockModule {
+	return &MockModule{
+		objects: make(map[ObjectHandle]map[uint]interface{}),
+		keys:    make(map[ObjectHandle]crypto.Signer),
+	}
+}
+
+func (m *MockModule) Close() error {
+	m.closed = true
+	return m.err
+}
+
+func (m *MockModule) FindObjects(template []Attribute) ([]ObjectHandle, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var objs []ObjectHandle
+	for h := ObjectHandle(1); h <= m.next; h++ {
+		attrs, ok := m.objects[h]
+		if !ok {
+			continue
+		}
+		match := true
+		for _, a := range template {
+			v, ok := attrs[a.Type]
+			if !ok || !equalValues(v, a.Value) {
+				match = false
+				break
+			}
+		}
+		if match {
+			objs = append(objs, h)
+		}
+	}
+	return objs, nil
+}
+
+func (m *MockModule) GetAttributeValue(obj ObjectHandle, types []uint) ([][]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	attrs, ok := m.objects[obj]
+	if !ok {
+		return nil, errors.New("CKR_OBJECT_HANDLE_INVALID")
+	}
+	values := make([][]byte, len(types))
+	for i, t := range types {
+		b, ok := attrs[t].([]byte)
+		if !ok {
+			return nil, errors.New("CKR_ATTRIBUTE_TYPE_INVALID")
+		}
+		values[i] = b
+	}
+	return values, nil
+}
+
+func (m *MockModule) Sign(mechanism *Mechanism, key ObjectHandle, data []byte) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	signer, ok := m.keys[key]
+	if !ok {
+		return nil, errors.New("CKR_KEY_HANDLE_INVALID")
+	}
+	switch mechanism.Type {
+	case ckmECDSA:
+		k := signer.(*ecdsa.PrivateKey)
+		r, s, err := ecdsa.Sign(rand.Reader, k, data)
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[size-len(rb):size], rb)
+		copy(sig[2*size-len(sb):], sb)
+		return sig, nil
+	case ckmRSAPKCS:
+		// The mock only supports DigestInfo of SHA-256.
+		k := signer.(*rsa.PrivateKey)
+		prefix := hashPrefixes[crypto.SHA256]
+		if !bytes.HasPrefix(data, prefix) {
+			return nil, errors.New("CKR_DATA_INVALID")
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, data[len(prefix):])
+	case ckmRSAPKCSPSS:
+		k := signer.(*rsa.PrivateKey)
+		p := mechanism.Parameter.(*PSSParams)
+		if p.Hash != ckmSHA256 || p.MGF != ckgMGF1SHA256 {
+			return nil, errors.New("CKR_MECHANISM_PARAM_INVALID")
+		}
+		return rsa.SignPSS(rand.Reader, k, crypto.SHA256, data, &rsa.PSSOptions{SaltLength: int(p.SaltLength)})
+	default:
+		return nil, errors.New("CKR_MECHANISM_INVALID")
+	}
+}
+
+func (m *MockModule) GenerateKeyPair(mechanism *Mechanism, public, private []Attribute) (ObjectHandle, ObjectHandle, error) {
+	if m.err != nil {
+		return 0, 0, m.err
+	}
+	pubAttrs := toMap(public)
+	privAttrs := toMap(private)
+	var key crypto.Signer
+	switch mechanism.Type {
+	case ckmECKeyPairGen:
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(pubAttrs[ckaECParams].([]byte), &oid); err != nil {
+			return 0, 0, err
+		}
+		var curve elliptic.Curve
+		switch {
+		case oid.Equal(oidNamedCurveP256):
+			curve = elliptic.P256()
+		case oid.Equal(oidNamedCurveP384):
+			curve = elliptic.P384()
+		case oid.Equal(oidNamedCurveP521):
+			curve = elliptic.P521()
+		}
+		k, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return 0, 0, err
+		}
+		point, err := asn1.Marshal(elliptic.Marshal(curve, k.X, k.Y))
+		if err != nil {
+			return 0, 0, err
+		}
+		pubAttrs[ckaECPoint] = point
+		key = k
+	case ckmRSAPKCSKeyPairGen:
+		k, err := rsa.GenerateKey(rand.Reader, int(pubAttrs[ckaModulusBits].(uint)))
+		if err != nil {
+			return 0, 0, err
+		}
+		pubAttrs[ckaModulus] = k.N.Bytes()
+		pubAttrs[ckaPublicExponent] = big.NewInt(int64(k.E)).Bytes()
+		key = k
+	default:
+		return 0, 0, errors.New("CKR_MECHANISM_INVALID")
+	}
+	pub := m.add(pubAttrs)
+	priv := m.add(privAttrs)
+	m.keys[priv] = key
+	return pub, priv, nil
+}
+
+func (m *MockModule) add(attrs map[uint]interface{}) ObjectHandle {
+	m.next++
+	m.objects[m.next] = attrs
+	return m.next
+}
+
+func toMap(template []Attribute) map[uint]interface{} {
+	attrs := make(map[uint]interface{}, len(template))
+	for _, a := range template {
+		attrs[a.Type] = a.Value
+	}
+	return attrs
+}
+
+func equalValues(a, b interface{}) bool {
+	switch v := a.(type) {
+	case []byte:
+		w, ok := b.([]byte)
+		return ok && bytes.Equal(v, w)
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,588 @@
+//go:build cgo && !windows
+// +build cgo,!windows
+
+package pkcs11
+
+/*
+#cgo linux LDFLAGS: -ldl
+
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef unsigned char CK_BYTE;
+typedef unsigned char CK_BBOOL;
+typedef unsigned long CK_ULONG;
+typedef CK_ULONG CK_RV;
+typedef CK_ULONG CK_FLAGS;
+typedef CK_ULONG CK_SLOT_ID;
+typedef CK_ULONG CK_SESSION_HANDLE;
+typedef CK_ULONG CK_OBJECT_HANDLE;
+typedef CK_ULONG CK_USER_TYPE;
+
+typedef struct CK_VERSION {
+	CK_BYTE major;
+	CK_BYTE minor;
+} CK_VERSION;
+
+typedef struct CK_ATTRIBUTE {
+	CK_ULONG type;
+	void *pValue;
+	CK_ULONG ulValueLen;
+} CK_ATTRIBUTE;
+
+typedef struct CK_MECHANISM {
+	CK_ULONG mechanism;
+	void *pParameter;
+	CK_ULONG ulParameterLen;
+} CK_MECHANISM;
+
+typedef struct CK_RSA_PKCS_PSS_PARAMS {
+	CK_ULONG hashAlg;
+	CK_ULONG mgf;
+	CK_ULONG sLen;
+} CK_RSA_PKCS_PSS_PARAMS;
+
+typedef struct CK_C_INITIALIZE_ARGS {
+	void *CreateMutex;
+	void *DestroyMutex;
+	void *LockMutex;
+	void *UnlockMutex;
+	CK_FLAGS flags;
+	void *pReserved;
+} CK_C_INITIALIZE_ARGS;
+
+typedef struct CK_TOKEN_INFO {
+	CK_BYTE label[32];
+	CK_BYTE manufacturerID[32];
+	CK_BYTE model[16];
+	CK_BYTE serialNumber[16];
+	CK_FLAGS flags;
+	CK_ULONG ulMaxSessionCount;
+	CK_ULONG ulSessionCount;
+	CK_ULONG ulMaxRwSessionCount;
+	CK_ULONG ulRwSessionCount;
+	CK_ULONG ulMaxPinLen;
+	CK_ULONG ulMinPinLen;
+	CK_ULONG ulTotalPublicMemory;
+	CK_ULONG ulFreePublicMemory;
+	CK_ULONG ulTotalPrivateMemory;
+	CK_ULONG ulFreePrivateMemory;
+	CK_VERSION hardwareVersion;
+	CK_VERSION firmwareVersion;
+	CK_BYTE utcTime[16];
+} CK_TOKEN_INFO;
+
+// CK_FUNCTION_LIST follows the order defined in PKCS #11 v2.40, only the
+// functions used by this package are typed.
+typedef struct CK_FUNCTION_LIST {
+	CK_VERSION version;
+	CK_RV (*C_Initialize)(void *);
+	CK_RV (*C_Finalize)(void *);
+	void *C_GetInfo;
+	void *C_GetFunctionList;
+	CK_RV (*C_GetSlotList)(CK_BBOOL, CK_SLOT_ID *, CK_ULONG *);
+	void *C_GetSlotInfo;
+	CK_RV (*C_GetTokenInfo)(CK_SLOT_ID, CK_TOKEN_INFO *);
+	void *C_GetMechanismList;
+	void *C_GetMechanismInfo;
+	void *C_InitToken;
+	void *C_InitPIN;
+	void *C_SetPIN;
+	CK_RV (*C_OpenSession)(CK_SLOT_ID, CK_FLAGS, void *, void *, CK_SESSION_HANDLE *);
+	CK_RV (*C_CloseSession)(CK_SESSION_HANDLE);
+	void *C_CloseAllSessions;
+	void *C_GetSessionInfo;
+	void *C_GetOperationState;
+	void *C_SetOperationState;
+	CK_RV (*C_Login)(CK_SESSION_HANDLE, CK_USER_TYPE, CK_BYTE *, CK_ULONG);
+	void *C_Logout;
+	void *C_CreateObject;
+	void *C_CopyObject;
+	void *C_DestroyObject;
+	void *C_GetObjectSize;
+	CK_RV (*C_GetAttributeValue)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	void *C_SetAttributeValue;
+	CK_RV (*C_FindObjectsInit)(CK_SESSION_HANDLE, CK_ATTRIBUTE *, CK_ULONG);
+	CK_RV (*C_FindObjects)(CK_SESSION_HANDLE, CK_OBJECT_HANDLE *, CK_ULONG, CK_ULONG *);
+	CK_RV (*C_FindObjectsFinal)(CK_SESSION_HANDLE);
+	void *C_EncryptInit;
+	void *C_Encrypt;
+	void *C_EncryptUpdate;
+	void *C_EncryptFinal;
+	void *C_DecryptInit;
+	void *C_Decrypt;
+	void *C_DecryptUpdate;
+	void *C_DecryptFinal;
+	void *C_DigestInit;
+	void *C_Digest;
+	void *C_DigestUpdate;
+	void *C_DigestKey;
+	void *C_DigestFinal;
+	CK_RV (*C_SignInit)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_OBJECT_HANDLE);
+	CK_RV (*C_Sign)(CK_SESSION_HANDLE, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+	void *C_SignUpdate;
+	void *C_SignFinal;
+	void *C_SignRecoverInit;
+	void *C_SignRecover;
+	void *C_VerifyInit;
+	void *C_Verify;
+	void *C_VerifyUpdate;
+	void *C_VerifyFinal;
+	void *C_VerifyRecoverInit;
+	void *C_VerifyRecover;
+	void *C_DigestEncryptUpdate;
+	void *C_DecryptDigestUpdate;
+	void *C_SignEncryptUpdate;
+	void *C_DecryptVerifyUpdate;
+	void *C_GenerateKey;
+	CK_RV (*C_GenerateKeyPair)(CK_SESSION_HANDLE, CK_MECHANISM *, CK_ATTRIBUTE *, CK_ULONG, CK_ATTRIBUTE *, CK_ULONG, CK_OBJECT_HANDLE *, CK_OBJECT_HANDLE *);
+	void *C_WrapKey;
+	void *C_UnwrapKey;
+	void *C_DeriveKey;
+	void *C_SeedRandom;
+	void *C_GenerateRandom;
+	void *C_GetFunctionStatus;
+	void *C_CancelFunction;
+	void *C_WaitForSlotEvent;
+} CK_FUNCTION_LIST;
+
+typedef CK_RV (*CK_C_GetFunctionList)(CK_FUNCTION_LIST **);
+
+typedef struct module {
+	void *handle;
+	CK_FUNCTION_LIST *fn;
+} module;
+
+static CK_RV mod_open(const char *path, module *m) {
+	CK_C_GetFunctionList getFunctionList;
+	m->handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (m->handle == NULL) {
+		return (CK_RV)-1;
+	}
+	getFunctionList = (CK_C_GetFunctionList)dlsym(m->handle, "C_GetFunctionList");
+	if (getFunctionList == NULL) {
+		dlclose(m->handle);
+		return (CK_RV)-2;
+	}
+	return getFunctionList(&m->fn);
+}
+
+static void mod_close(module *m) {
+	dlclose(m->handle);
+}
+
+static CK_RV mod_initialize(module *m) {
+	CK_C_INITIALIZE_ARGS args;
+	memset(&args, 0, sizeof(args));
+	args.flags = 0x00000002; // CKF_OS_LOCKING_OK
+	return m->fn->C_Initialize(&args);
+}
+
+static CK_RV mod_finalize(module *m) {
+	return m->fn->C_Finalize(NULL);
+}
+
+static CK_RV mod_get_slot_list(module *m, CK_SLOT_ID *list, CK_ULONG *count) {
+	return m->fn->C_GetSlotList(1, list, count);
+}
+
+static CK_RV mod_get_token_label(module *m, CK_SLOT_ID slot, CK_BYTE *label) {
+	CK_TOKEN_INFO info;
+	CK_RV rv = m->fn->C_GetTokenInfo(slot, &info);
+	if (rv == 0) {
+		memcpy(label, info.label, 32);
+	}
+	return rv;
+}
+
+static CK_RV mod_open_session(module *m, CK_SLOT_ID slot, CK_SESSION_HANDLE *session) {
+	// CKF_SERIAL_SESSION | CKF_RW_SESSION
+	return m->fn->C_OpenSession(slot, 0x00000006, NULL, NULL, session);
+}
+
+static CK_RV mod_close_session(module *m, CK_SESSION_HANDLE session) {
+	return m->fn->C_CloseSession(session);
+}
+
+static CK_RV mod_login(module *m, CK_SESSION_HANDLE session, CK_BYTE *pin, CK_ULONG len) {
+	// CKU_USER
+	return m->fn->C_Login(session, 1, pin, len);
+}
+
+static CK_RV mod_find_objects(module *m, CK_SESSION_HANDLE session, CK_ATTRIBUTE *template, CK_ULONG count, CK_OBJECT_HANDLE *objs, CK_ULONG max, CK_ULONG *found) {
+	CK_RV rv = m->fn->C_FindObjectsInit(session, template, count);
+	if (rv != 0) {
+		return rv;
+	}
+	rv = m->fn->C_FindObjects(session, objs, max, found);
+	m->fn->C_FindObjectsFinal(session);
+	return rv;
+}
+
+static CK_RV mod_get_attribute_value(module *m, CK_SESSION_HANDLE session, CK_OBJECT_HANDLE obj, CK_ATTRIBUTE *template, CK_ULONG count) {
+	return m->fn->C_GetAttributeValue(session, obj, template, count);
+}
+
+static CK_RV mod_sign(module *m, CK_SESSION_HANDLE session, CK_MECHANISM *mechanism, CK_OBJECT_HANDLE key, CK_BYTE *data, CK_ULONG len, CK_BYTE **sig, CK_ULONG *sigLen) {
+	CK_RV rv = m->fn->C_SignInit(session, mechanism, key);
+	if (rv != 0) {
+		return rv;
+	}
+	// Get the length of the signature, the operation is still active.
+	rv = m->fn->C_Sign(session, data, len, NULL, sigLen);
+	if (rv != 0) {
+		return rv;
+	}
+	*sig = malloc(*sigLen);
+	if (*sig == NULL) {
+		return 0x00000002; // CKR_HOST_MEMORY
+	}
+	rv = m->fn->C_Sign(session, data, len, *sig, sigLen);
+	if (rv != 0) {
+		free(*sig);
+		*sig = NULL;
+	}
+	return rv;
+}
+
+static CK_RV mod_generate_key_pair(module *m, CK_SESSION_HANDLE session, CK_MECHANISM *mechanism, CK_ATTRIBUTE *pub, CK_ULONG pubCount, CK_ATTRIBUTE *priv, CK_ULONG privCount, CK_OBJECT_HANDLE *pubKey, CK_OBJECT_HANDLE *privKey) {
+	return m->fn->C_GenerateKeyPair(session, mechanism, pub, pubCount, priv, privCount, pubKey, privKey);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// maxObjects is the maximum number of objects returned by FindObjects.
+const maxObjects = 16
+
+// Return values with special handling.
+const (
+	ckrOK                         = 0x000
+	ckrUserAlreadyLoggedIn        = 0x100
+	ckrCryptokiAlreadyInitialized = 0x191
+)
+
+// errorNames are the names of the common return values.
+var errorNames = map[uint]string{
+	0x001: "CKR_CANCEL",
+	0x002: "CKR_HOST_MEMORY",
+	0x003: "CKR_SLOT_ID_INVALID",
+	0x005: "CKR_GENERAL_ERROR",
+	0x006: "CKR_FUNCTION_FAILED",
+	0x007: "CKR_ARGUMENTS_BAD",
+	0x011: "CKR_ATTRIBUTE_SENSITIVE",
+	0x012: "CKR_ATTRIBUTE_TYPE_INVALID",
+	0x013: "CKR_ATTRIBUTE_VALUE_INVALID",
+	0x021: "CKR_DATA_LEN_RANGE",
+	0x030: "CKR_DEVICE_ERROR",
+	0x054: "CKR_FUNCTION_NOT_SUPPORTED",
+	0x060: "CKR_KEY_HANDLE_INVALID",
+	0x063: "CKR_KEY_TYPE_INCONSISTENT",
+	0x068: "CKR_KEY_FUNCTION_NOT_PERMITTED",
+	0x070: "CKR_MECHANISM_INVALID",
+	0x071: "CKR_MECHANISM_PARAM_INVALID",
+	0x082: "CKR_OBJECT_HANDLE_INVALID",
+	0x090: "CKR_OPERATION_ACTIVE",
+	0x091: "CKR_OPERATION_NOT_INITIALIZED",
+	0x0A0: "CKR_PIN_INCORRECT",
+	0x0A4: "CKR_PIN_LOCKED",
+	0x0B3: "CKR_SESSION_HANDLE_INVALID",
+	0x0B5: "CKR_SESSION_READ_ONLY",
+	0x0D0: "CKR_TEMPLATE_INCOMPLETE",
+	0x0D1: "CKR_TEMPLATE_INCONSISTENT",
+	0x0E0: "CKR_TOKEN_NOT_PRESENT",
+	0x0E2: "CKR_TOKEN_WRITE_PROTECTED",
+	0x100: "CKR_USER_ALREADY_LOGGED_IN",
+	0x101: "CKR_USER_NOT_LOGGED_IN",
+	0x102: "CKR_USER_PIN_NOT_INITIALIZED",
+	0x150: "CKR_BUFFER_TOO_SMALL",
+	0x190: "CKR_CRYPTOKI_NOT_INITIALIZED",
+	0x191: "CKR_CRYPTOKI_ALREADY_INITIALIZED",
+}
+
+// Error is a PKCS #11 return value.
+type Error uint
+
+func (e Error) Error() string {
+	if s, ok := errorNames[uint(e)]; ok {
+		return s
+	}
+	return fmt.Sprintf("CKR_0x%08X", uint(e))
+}
+
+// module is the Module implemented by a PKCS #11 shared library. It uses a
+// single session, the operations are serialized.
+type module struct {
+	m       C.module
+	session C.CK_SESSION_HANDLE
+	// initialized is true if this module initialized the library, only in
+	// that case it will be finalized on close.
+	initialized bool
+	mutex       sync.Mutex
+}
+
+// openModule loads the library in the given path, and opens a session logged
+// in the token in the given slot, the one with the given label, or the first
+// slot with a token.
+func openModule(path string, slot *int, tokenLabel, pin string) (Module, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	m := new(module)
+	switch rv := C.mod_open(cpath, &m.m); rv {
+	case ckrOK:
+	case C.CK_RV(^C.CK_ULONG(0)):
+		return nil, errors.Errorf("error loading pkcs11 module %s: %s", path, C.GoString(C.dlerror()))
+	case C.CK_RV(^C.CK_ULONG(1)):
+		return nil, errors.Errorf("error loading pkcs11 module %s: C_GetFunctionList not found", path)
+	default:
+		C.mod_close(&m.m)
+		return nil, errors.Wrapf(Error(rv), "error loading pkcs11 module %s", path)
+	}
+
+	switch rv := C.mod_initialize(&m.m); rv {
+	case ckrOK:
+		m.initialized = true
+	case ckrCryptokiAlreadyInitialized:
+	default:
+		C.mod_close(&m.m)
+		return nil, errors.Wrap(Error(rv), "pkcs11 C_Initialize failed")
+	}
+
+	slotID, err := m.findSlot(slot, tokenLabel)
+	if err != nil {
+		m.finalize()
+		return nil, err
+	}
+	if rv := C.mod_open_session(&m.m, slotID, &m.session); rv != ckrOK {
+		m.finalize()
+		return nil, errors.Wrap(Error(rv), "pkcs11 C_OpenSession failed")
+	}
+	if pin != "" {
+		cpin := C.CString(pin)
+		defer C.free(unsafe.Pointer(cpin))
+		rv := C.mod_login(&m.m, m.session, (*C.CK_BYTE)(unsafe.Pointer(cpin)), C.CK_ULONG(len(pin)))
+		if rv != ckrOK && rv != ckrUserAlreadyLoggedIn {
+			m.Close()
+			return nil, errors.Wrap(Error(rv), "pkcs11 C_Login failed")
+		}
+	}
+	return m, nil
+}
+
+func (m *module) findSlot(slot *int, tokenLabel string) (C.CK_SLOT_ID, error) {
+	var count C.CK_ULONG
+	if rv := C.mod_get_slot_list(&m.m, nil, &count); rv != ckrOK {
+		return 0, errors.Wrap(Error(rv), "pkcs11 C_GetSlotList failed")
+	}
+	if count == 0 {
+		return 0, errors.New("pkcs11 module does not have slots with a token")
+	}
+	slots := make([]C.CK_SLOT_ID, count)
+	if rv := C.mod_get_slot_list(&m.m, &slots[0], &count); rv != ckrOK {
+		return 0, errors.Wrap(Error(rv), "pkcs11 C_GetSlotList failed")
+	}
+	slots = slots[:count]
+
+	switch {
+	case slot != nil:
+		for _, id := range slots {
+			if int(id) == *slot {
+				return id, nil
+			}
+		}
+		return 0, errors.Errorf("pkcs11 slot %d does not exist or does not have a token", *slot)
+	case tokenLabel != "":
+		for _, id := range slots {
+			label := make([]byte, 32)
+			if rv := C.mod_get_token_label(&m.m, id, (*C.CK_BYTE)(unsafe.Pointer(&label[0]))); rv != ckrOK {
+				return 0, errors.Wrap(Error(rv), "pkcs11 C_GetTokenInfo failed")
+			}
+			// Labels are padded with blank characters.
+			if strings.TrimRight(string(bytes.TrimRight(label, "\x00")), " ") == tokenLabel {
+				return id, nil
+			}
+		}
+		return 0, errors.Errorf("pkcs11 token %s not found", tokenLabel)
+	default:
+		return slots[0], nil
+	}
+}
+
+func (m *module) finalize() {
+	if m.initialized {
+		C.mod_finalize(&m.m)
+	}
+	C.mod_close(&m.m)
+}
+
+// Close closes the session and finalizes the module.
+func (m *module) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rv := C.mod_close_session(&m.m, m.session)
+	m.finalize()
+	if rv != ckrOK {
+		return Error(rv)
+	}
+	return nil
+}
+
+// FindObjects returns the objects that match the given template.
+func (m *module) FindObjects(template []Attribute) ([]ObjectHandle, error) {
+	attrs, count, free := newAttributes(template)
+	defer free()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	objs := make([]C.CK_OBJECT_HANDLE, maxObjects)
+	var found C.CK_ULONG
+	if rv := C.mod_find_objects(&m.m, m.session, attrs, count, &objs[0], maxObjects, &found); rv != ckrOK {
+		return nil, Error(rv)
+	}
+	handles := make([]ObjectHandle, found)
+	for i := range handles {
+		handles[i] = ObjectHandle(objs[i])
+	}
+	return handles, nil
+}
+
+// GetAttributeValue returns the value of the given attributes of an object.
+func (m *module) GetAttributeValue(obj ObjectHandle, types []uint) ([][]byte, error) {
+	template := make([]Attribute, len(types))
+	for i, t := range types {
+		template[i].Type = t
+	}
+	attrs, count, free := newAttributes(template)
+	defer free()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	// Get the length of the values first.
+	if rv := C.mod_get_attribute_value(&m.m, m.session, C.CK_OBJECT_HANDLE(obj), attrs, count); rv != ckrOK {
+		return nil, Error(rv)
+	}
+	list := (*[1 << 20]C.CK_ATTRIBUTE)(unsafe.Pointer(attrs))[:count:count]
+	for i := range list {
+		list[i].pValue = C.malloc(C.size_t(list[i].ulValueLen) + 1)
+		defer C.free(list[i].pValue)
+	}
+	if rv := C.mod_get_attribute_value(&m.m, m.session, C.CK_OBJECT_HANDLE(obj), attrs, count); rv != ckrOK {
+		return nil, Error(rv)
+	}
+	values := make([][]byte, len(list))
+	for i := range list {
+		values[i] = C.GoBytes(list[i].pValue, C.int(list[i].ulValueLen))
+	}
+	return values, nil
+}
+
+// Sign signs the data with the given mechanism and key.
+func (m *module) Sign(mechanism *Mechanism, key ObjectHandle, data []byte) ([]byte, error) {
+	mech, free := newMechanism(mechanism)
+	defer free()
+	cdata := C.CBytes(data)
+	defer C.free(cdata)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var sig *C.CK_BYTE
+	var sigLen C.CK_ULONG
+	if rv := C.mod_sign(&m.m, m.session, mech, C.CK_OBJECT_HANDLE(key), (*C.CK_BYTE)(cdata), C.CK_ULONG(len(data)), &sig, &sigLen); rv != ckrOK {
+		return nil, Error(rv)
+	}
+	defer C.free(unsafe.Pointer(sig))
+	return C.GoBytes(unsafe.Pointer(sig), C.int(sigLen)), nil
+}
+
+// GenerateKeyPair generates a key pair with the given mechanism and templates,
+// it returns the public and private key objects.
+func (m *module) GenerateKeyPair(mechanism *Mechanism, public, private []Attribute) (ObjectHandle, ObjectHandle, error) {
+	mech, freeMech := newMechanism(mechanism)
+	defer freeMech()
+	pub, pubCount, freePub := newAttributes(public)
+	defer freePub()
+	priv, privCount, freePriv := newAttributes(private)
+	defer freePriv()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var pubKey, privKey C.CK_OBJECT_HANDLE
+	if rv := C.mod_generate_key_pair(&m.m, m.session, mech, pub, pubCount, priv, privCount, &pubKey, &privKey); rv != ckrOK {
+		return 0, 0, Error(rv)
+	}
+	return ObjectHandle(pubKey), ObjectHandle(privKey), nil
+}
+
+// newAttributes allocates in C memory the given attributes, the returned
+// function frees them.
+func newAttributes(template []Attribute) (*C.CK_ATTRIBUTE, C.CK_ULONG, func()) {
+	n := len(template)
+	if n == 0 {
+		return nil, 0, func() {}
+	}
+	attrs := (*C.CK_ATTRIBUTE)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.CK_ATTRIBUTE{}))))
+	list := (*[1 << 20]C.CK_ATTRIBUTE)(unsafe.Pointer(attrs))[:n:n]
+	for i, a := range template {
+		var b []byte
+		switch v := a.Value.(type) {
+		case bool:
+			if v {
+				b = []byte{1}
+			} else {
+				b = []byte{0}
+			}
+		case uint:
+			ul := C.CK_ULONG(v)
+			b = C.GoBytes(unsafe.Pointer(&ul), C.int(unsafe.Sizeof(ul)))
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		}
+		list[i]._type = C.CK_ULONG(a.Type)
+		if len(b) > 0 {
+			list[i].pValue = C.CBytes(b)
+			list[i].ulValueLen = C.CK_ULONG(len(b))
+		}
+	}
+	return attrs, C.CK_ULONG(n), func() {
+		for i := range list {
+			if list[i].pValue != nil {
+				C.free(list[i].pValue)
+			}
+		}
+		C.free(unsafe.Pointer(attrs))
+	}
+}
+
+// newMechanism allocates in C memory the given mechanism, the returned function
+// frees it.
+func newMechanism(mechanism *Mechanism) (*C.CK_MECHANISM, func()) {
+	mech := (*C.CK_MECHANISM)(C.calloc(1, C.size_t(unsafe.Sizeof(C.CK_MECHANISM{}))))
+	mech.mechanism = C.CK_ULONG(mechanism.Type)
+	if p, ok := mechanism.Parameter.(*PSSParams); ok {
+		params := (*C.CK_RSA_PKCS_PSS_PARAMS)(C.calloc(1, C.size_t(unsafe.Sizeof(C.CK_RSA_PKCS_PSS_PARAMS{}))))
+		params.hashAlg = C.CK_ULONG(p.Hash)
+		params.mgf = C.CK_ULONG(p.MGF)
+		params.sLen = C.CK_ULONG(p.SaltLength)
+		mech.pParameter = unsafe.Pointer(params)
+		mech.ulParameterLen = C.CK_ULONG(unsafe.Sizeof(*params))
+	}
+	return mech, func() {
+		if mech.pParameter != nil {
+			C.free(mech.pParameter)
+		}
+		C.free(unsafe.Pointer(mech))
+	}
+}
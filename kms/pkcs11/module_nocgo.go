@@ -0,0 +1,10 @@
+//go:build !cgo || windows
+// +build !cgo windows
+
+package pkcs11
+
+import "github.com/pkg/errors"
+
+func openModule(path string, slot *int, tokenLabel, pin string) (Module, error) {
+	return nil, errors.New("pkcs11 is not supported: step-ca must be compiled with cgo")
+}
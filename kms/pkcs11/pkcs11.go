@@ -0,0 +1,393 @@
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// PKCS #11 constants used by this package.
+const (
+	ckoPublicKey  = 0x02
+	ckoPrivateKey = 0x03
+
+	ckkRSA = 0x00
+	ckkEC  = 0x03
+
+	ckaClass          = 0x000
+	ckaToken          = 0x001
+	ckaPrivate        = 0x002
+	ckaLabel          = 0x003
+	ckaKeyType        = 0x100
+	ckaID             = 0x102
+	ckaSensitive      = 0x103
+	ckaSign           = 0x108
+	ckaVerify         = 0x10A
+	ckaModulus        = 0x120
+	ckaModulusBits    = 0x121
+	ckaPublicExponent = 0x122
+	ckaExtractable    = 0x162
+	ckaECParams       = 0x180
+	ckaECPoint        = 0x181
+
+	ckmRSAPKCSKeyPairGen = 0x0000
+	ckmRSAPKCS           = 0x0001
+	ckmRSAPKCSPSS        = 0x000D
+	ckmSHA256            = 0x0250
+	ckmSHA384            = 0x0260
+	ckmSHA512            = 0x0270
+	ckmECKeyPairGen      = 0x1040
+	ckmECDSA             = 0x1041
+
+	ckgMGF1SHA256 = 0x02
+	ckgMGF1SHA384 = 0x03
+	ckgMGF1SHA512 = 0x04
+)
+
+// uriScheme is the scheme of the PKCS #11 URIs defined in RFC 7512.
+const uriScheme = "pkcs11:"
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 3072
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// signatureAlgorithmMapping maps the step signature algorithms with the PKCS
+// #11 key type and the curve of the EC keys.
+//
+// PKCS #11 does not support PureEd25519 in this package.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]struct {
+	KeyType uint
+	Curve   asn1.ObjectIdentifier
+}{
+	apiv1.UnspecifiedSignAlgorithm: {ckkEC, oidNamedCurveP256},
+	apiv1.SHA256WithRSA:            {ckkRSA, nil},
+	apiv1.SHA384WithRSA:            {ckkRSA, nil},
+	apiv1.SHA512WithRSA:            {ckkRSA, nil},
+	apiv1.SHA256WithRSAPSS:         {ckkRSA, nil},
+	apiv1.SHA384WithRSAPSS:         {ckkRSA, nil},
+	apiv1.SHA512WithRSAPSS:         {ckkRSA, nil},
+	apiv1.ECDSAWithSHA256:          {ckkEC, oidNamedCurveP256},
+	apiv1.ECDSAWithSHA384:          {ckkEC, oidNamedCurveP384},
+	apiv1.ECDSAWithSHA512:          {ckkEC, oidNamedCurveP521},
+}
+
+// ObjectHandle is the handle of an object in the token.
+type ObjectHandle uint
+
+// Attribute is a PKCS #11 attribute, the value is a bool, an uint for the
+// CK_ULONG values, a string or a []byte.
+type Attribute struct {
+	Type  uint
+	Value interface{}
+}
+
+// Mechanism is a PKCS #11 mechanism, the parameter is nil or a *PSSParams.
+type Mechanism struct {
+	Type      uint
+	Parameter interface{}
+}
+
+// PSSParams are the parameters of the RSA-PSS mechanism.
+type PSSParams struct {
+	Hash       uint
+	MGF        uint
+	SaltLength uint
+}
+
+// Module defines the PKCS #11 operations that this package will use. All the
+// operations are performed in a session logged in the configured token. This
+// interface is implemented by the module loaded from a shared library, and it
+// will be used for unit testing.
+type Module interface {
+	FindObjects(template []Attribute) ([]ObjectHandle, error)
+	GetAttributeValue(obj ObjectHandle, types []uint) ([][]byte, error)
+	Sign(mechanism *Mechanism, key ObjectHandle, data []byte) ([]byte, error)
+	GenerateKeyPair(mechanism *Mechanism, public, private []Attribute) (ObjectHandle, ObjectHandle, error)
+	Close() error
+}
+
+// PKCS11 implements a KMS using a PKCS #11 module, e.g. SoftHSM or the ones
+// provided by the HSM vendors.
+type PKCS11 struct {
+	module Module
+}
+
+// New loads the PKCS #11 module, and opens a session logged in the token in
+// the configured slot, or the one with the given label. The keys are
+// identified by their label or by a PKCS #11 URI like
+// pkcs11:id=%01;object=intermediate-key.
+func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
+	if opts.Module == "" {
+		return nil, errors.New("kms module cannot be empty")
+	}
+	module, err := openModule(opts.Module, opts.Slot, opts.TokenLabel, opts.Pin)
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS11{
+		module: module,
+	}, nil
+}
+
+// NewPKCS11 creates a PKCS11 with a given module.
+func NewPKCS11(module Module) *PKCS11 {
+	return &PKCS11{
+		module: module,
+	}
+}
+
+// Close closes the session and finalizes the module.
+func (k *PKCS11) Close() error {
+	if err := k.module.Close(); err != nil {
+		return errors.Wrap(err, "pkcs11 Close failed")
+	}
+	return nil
+}
+
+// GetPublicKey returns the public key with the given name from the token.
+func (k *PKCS11) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	obj, keyType, err := k.findKey(ckoPublicKey, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return k.publicKey(obj, keyType)
+}
+
+// CreateKey generates in the token a new key pair with the given name. The
+// private key is sensitive and cannot be extracted.
+func (k *PKCS11) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("pkcs11 does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	label, id, err := parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := k.findKey(ckoPrivateKey, req.Name); err == nil {
+		return nil, errors.Errorf("pkcs11 key %s already exists", req.Name)
+	}
+	if len(id) == 0 {
+		id = make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, errors.Wrap(err, "error generating key id")
+		}
+	}
+
+	public := []Attribute{
+		{ckaClass, uint(ckoPublicKey)},
+		{ckaKeyType, v.KeyType},
+		{ckaToken, true},
+		{ckaVerify, true},
+		{ckaID, id},
+	}
+	private := []Attribute{
+		{ckaClass, uint(ckoPrivateKey)},
+		{ckaKeyType, v.KeyType},
+		{ckaToken, true},
+		{ckaPrivate, true},
+		{ckaSensitive, true},
+		{ckaExtractable, false},
+		{ckaSign, true},
+		{ckaID, id},
+	}
+	if label != "" {
+		public = append(public, Attribute{ckaLabel, label})
+		private = append(private, Attribute{ckaLabel, label})
+	}
+
+	var mechanism *Mechanism
+	if v.KeyType == ckkEC {
+		params, err := asn1.Marshal(v.Curve)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling curve")
+		}
+		public = append(public, Attribute{ckaECParams, params})
+		mechanism = &Mechanism{Type: ckmECKeyPairGen}
+	} else {
+		bits := req.Bits
+		if bits == 0 {
+			bits = DefaultRSAKeySize
+		}
+		public = append(public, Attribute{ckaModulusBits, uint(bits)}, Attribute{ckaPublicExponent, []byte{1, 0, 1}})
+		mechanism = &Mechanism{Type: ckmRSAPKCSKeyPairGen}
+	}
+
+	pubObj, _, err := k.module.GenerateKeyPair(mechanism, public, private)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 GenerateKeyPair failed")
+	}
+	pub, err := k.publicKey(pubObj, v.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a new signer using the private key with the given name
+// in the token. The token must also have the public key with the same name.
+func (k *PKCS11) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	obj, keyType, err := k.findKey(ckoPrivateKey, req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: req.SigningKey})
+	if err != nil {
+		return nil, err
+	}
+	if pubType := publicKeyType(pub); pubType != keyType {
+		return nil, errors.Errorf("pkcs11 key %s has inconsistent types", req.SigningKey)
+	}
+	return NewSigner(k.module, obj, pub), nil
+}
+
+// findKey returns the only key of the given class and name, and its type.
+func (k *PKCS11) findKey(class uint, name string) (ObjectHandle, uint, error) {
+	label, id, err := parseKeyName(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, keyType := range []uint{ckkEC, ckkRSA} {
+		template := []Attribute{{ckaClass, class}, {ckaKeyType, keyType}}
+		if label != "" {
+			template = append(template, Attribute{ckaLabel, label})
+		}
+		if len(id) > 0 {
+			template = append(template, Attribute{ckaID, id})
+		}
+		objs, err := k.module.FindObjects(template)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "pkcs11 FindObjects failed")
+		}
+		switch len(objs) {
+		case 0:
+		case 1:
+			return objs[0], keyType, nil
+		default:
+			return 0, 0, errors.Errorf("pkcs11 key %s is not unique", name)
+		}
+	}
+	return 0, 0, errors.Errorf("pkcs11 key %s not found", name)
+}
+
+// publicKey reads the public key object with the given type.
+func (k *PKCS11) publicKey(obj ObjectHandle, keyType uint) (crypto.PublicKey, error) {
+	if keyType == ckkRSA {
+		values, err := k.module.GetAttributeValue(obj, []uint{ckaModulus, ckaPublicExponent})
+		if err != nil {
+			return nil, errors.Wrap(err, "pkcs11 GetAttributeValue failed")
+		}
+		e := new(big.Int).SetBytes(values[1])
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("pkcs11 RSA public exponent is too large")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(values[0]),
+			E: int(e.Int64()),
+		}, nil
+	}
+
+	values, err := k.module.GetAttributeValue(obj, []uint{ckaECParams, ckaECPoint})
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 GetAttributeValue failed")
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(values[0], &oid); err != nil {
+		return nil, errors.Wrap(err, "error parsing pkcs11 EC params")
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		curve = elliptic.P384()
+	case oid.Equal(oidNamedCurveP521):
+		curve = elliptic.P521()
+	default:
+		return nil, errors.Errorf("pkcs11 curve %s is not supported", oid)
+	}
+	// The point should be a DER octet string, but some modules return the
+	// raw point.
+	point := values[1]
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("error parsing pkcs11 EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// parseKeyName returns the label and id of a key name, it can be a label or a
+// PKCS #11 URI with the object and id attributes.
+func parseKeyName(name string) (string, []byte, error) {
+	if !strings.HasPrefix(name, uriScheme) {
+		return name, nil, nil
+	}
+	var label string
+	var id []byte
+	path := strings.SplitN(strings.TrimPrefix(name, uriScheme), "?", 2)[0]
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return "", nil, errors.Errorf("error parsing %s: invalid attribute %s", name, attr)
+		}
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "error parsing %s", name)
+		}
+		switch parts[0] {
+		case "object":
+			label = value
+		case "id":
+			id = []byte(value)
+		}
+	}
+	if label == "" && len(id) == 0 {
+		return "", nil, errors.Errorf("error parsing %s: object or id are required", name)
+	}
+	return label, id, nil
+}
+
+func publicKeyType(pub crypto.PublicKey) uint {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return ckkRSA
+	}
+	return ckkEC
+}
@@ -0,0 +1,277 @@
+package pkcs11
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"fail empty module", apiv1.Options{Type: "pkcs11"}, true},
+		{"fail missing module", apiv1.Options{Type: "pkcs11", Module: "testdata/missing.so"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPKCS11_Close(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+	if err := k.Close(); err != nil {
+		t.Errorf("PKCS11.Close() error = %v", err)
+	}
+	if !m.closed {
+		t.Error("PKCS11.Close() did not close the module")
+	}
+	m.err = errors.New("an error")
+	if err := k.Close(); err == nil {
+		t.Error("PKCS11.Close() error = nil, wantErr true")
+	}
+}
+
+func TestPKCS11_CreateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		curve   elliptic.Curve
+		bits    int
+		wantErr bool
+	}{
+		{"ok default", &apiv1.CreateKeyRequest{Name: "default"}, elliptic.P256(), 0, false},
+		{"ok P-256", &apiv1.CreateKeyRequest{Name: "pkcs11:object=p256", SignatureAlgorithm: apiv1.ECDSAWithSHA256}, elliptic.P256(), 0, false},
+		{"ok P-384", &apiv1.CreateKeyRequest{Name: "pkcs11:id=%01;object=p384", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, elliptic.P384(), 0, false},
+		{"ok P-521", &apiv1.CreateKeyRequest{Name: "pkcs11:id=%02", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, elliptic.P521(), 0, false},
+		{"ok RSA", &apiv1.CreateKeyRequest{Name: "rsa", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}, nil, 2048, false},
+		{"ok RSA-PSS", &apiv1.CreateKeyRequest{Name: "rsa-pss", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 1024}, nil, 1024, false},
+		{"fail name", &apiv1.CreateKeyRequest{}, nil, 0, true},
+		{"fail uri", &apiv1.CreateKeyRequest{Name: "pkcs11:foo"}, nil, 0, true},
+		{"fail Ed25519", &apiv1.CreateKeyRequest{Name: "ed25519", SignatureAlgorithm: apiv1.PureEd25519}, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewPKCS11(newMockModule())
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PKCS11.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.req.Name || got.CreateSignerRequest.SigningKey != tt.req.Name {
+				t.Errorf("PKCS11.CreateKey() = %v, want name %s", got, tt.req.Name)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.curve {
+					t.Errorf("PKCS11.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.curve.Params().Name)
+				}
+			case *rsa.PublicKey:
+				if pub.N.BitLen() != tt.bits {
+					t.Errorf("PKCS11.CreateKey() bits = %d, want %d", pub.N.BitLen(), tt.bits)
+				}
+			default:
+				t.Errorf("PKCS11.CreateKey() unexpected public key %T", pub)
+			}
+
+			// The key exists now.
+			if _, err := k.CreateKey(tt.req); err == nil {
+				t.Error("PKCS11.CreateKey() error = nil, wantErr true")
+			}
+			pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.req.Name})
+			if err != nil {
+				t.Fatalf("PKCS11.GetPublicKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(pub, got.PublicKey) {
+				t.Errorf("PKCS11.GetPublicKey() = %v, want %v", pub, got.PublicKey)
+			}
+		})
+	}
+}
+
+func TestPKCS11_CreateKey_attributes(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "pkcs11:id=%7a%01;object=my-key"}); err != nil {
+		t.Fatal(err)
+	}
+	priv, err := m.FindObjects([]Attribute{{ckaClass, uint(ckoPrivateKey)}})
+	if err != nil || len(priv) != 1 {
+		t.Fatalf("FindObjects() = %v, %v", priv, err)
+	}
+	attrs := m.objects[priv[0]]
+	for typ, want := range map[uint]interface{}{
+		ckaLabel:       "my-key",
+		ckaToken:       true,
+		ckaPrivate:     true,
+		ckaSensitive:   true,
+		ckaExtractable: false,
+		ckaSign:        true,
+	} {
+		if attrs[typ] != want {
+			t.Errorf("private key attribute 0x%x = %v, want %v", typ, attrs[typ], want)
+		}
+	}
+	if !reflect.DeepEqual(attrs[ckaID], []byte{0x7a, 0x01}) {
+		t.Errorf("private key id = %x, want 7a01", attrs[ckaID])
+	}
+
+	// Random ids are generated for labels.
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "other-key"}); err != nil {
+		t.Fatal(err)
+	}
+	priv, err = m.FindObjects([]Attribute{{ckaClass, uint(ckoPrivateKey)}, {ckaLabel, "other-key"}})
+	if err != nil || len(priv) != 1 {
+		t.Fatalf("FindObjects() = %v, %v", priv, err)
+	}
+	if id, _ := m.objects[priv[0]][ckaID].([]byte); len(id) != 16 {
+		t.Errorf("private key id = %x, want 16 random bytes", id)
+	}
+}
+
+func TestPKCS11_GetPublicKey(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "pkcs11:id=%01;object=key"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "pkcs11:id=%02;object=dup"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "pkcs11:id=%03;object=dup"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyName string
+		err     error
+		wantErr bool
+	}{
+		{"ok label", "key", nil, false},
+		{"ok id", "pkcs11:id=%01", nil, false},
+		{"ok uri", "pkcs11:object=key;id=%01", nil, false},
+		{"fail name", "", nil, true},
+		{"fail missing", "missing", nil, true},
+		{"fail mismatch", "pkcs11:object=key;id=%02", nil, true},
+		{"fail not unique", "dup", nil, true},
+		{"fail uri", "pkcs11:id=%zz", nil, true},
+		{"fail module", "key", errors.New("an error"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.err = tt.err
+			defer func() { m.err = nil }()
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PKCS11.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if _, ok := got.(*ecdsa.PublicKey); !ok {
+					t.Errorf("PKCS11.GetPublicKey() = %T, want *ecdsa.PublicKey", got)
+				}
+			}
+		})
+	}
+}
+
+func TestPKCS11_GetPublicKey_rawPoint(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Some modules return the EC point without the octet string.
+	pub := resp.PublicKey.(*ecdsa.PublicKey)
+	for _, attrs := range m.objects {
+		if attrs[ckaClass] == uint(ckoPublicKey) {
+			attrs[ckaECPoint] = elliptic.Marshal(pub.Curve, pub.X, pub.Y)
+		}
+	}
+	got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, resp.PublicKey) {
+		t.Errorf("PKCS11.GetPublicKey() = %v, want %v", got, resp.PublicKey)
+	}
+}
+
+func TestPKCS11_CreateSigner(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Private key without public key.
+	m.add(map[uint]interface{}{ckaClass: uint(ckoPrivateKey), ckaKeyType: uint(ckkEC), ckaLabel: "private-only"})
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateSignerRequest
+		wantErr bool
+	}{
+		{"ok", &resp.CreateSignerRequest, false},
+		{"fail signing key", &apiv1.CreateSignerRequest{}, true},
+		{"fail missing", &apiv1.CreateSignerRequest{SigningKey: "missing"}, true},
+		{"fail public key", &apiv1.CreateSignerRequest{SigningKey: "private-only"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PKCS11.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.Public(), resp.PublicKey) {
+				t.Errorf("PKCS11.CreateSigner().Public() = %v, want %v", got.Public(), resp.PublicKey)
+			}
+		})
+	}
+}
+
+func Test_parseKeyName(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyName   string
+		wantLabel string
+		wantID    []byte
+		wantErr   bool
+	}{
+		{"ok label", "my-key", "my-key", nil, false},
+		{"ok object", "pkcs11:object=my-key", "my-key", nil, false},
+		{"ok id", "pkcs11:id=%01%02", "", []byte{1, 2}, false},
+		{"ok both", "pkcs11:token=foo;id=%01;object=my%20key?pin-value=1234", "my key", []byte{1}, false},
+		{"fail empty", "pkcs11:", "", nil, true},
+		{"fail attribute", "pkcs11:object", "", nil, true},
+		{"fail escape", "pkcs11:object=%zz", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			label, id, err := parseKeyName(tt.keyName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if label != tt.wantLabel || !reflect.DeepEqual(id, tt.wantID) {
+				t.Errorf("parseKeyName() = %s, %x, want %s, %x", label, id, tt.wantLabel, tt.wantID)
+			}
+		})
+	}
+}
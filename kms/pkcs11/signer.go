@@ -0,0 +1,118 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/asn1"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// hashPrefixes are the DER prefixes of the DigestInfo used in RSASSA-PKCS1-v1_5
+// signatures. CKM_RSA_PKCS only pads the data, so the prefix must be added.
+var hashPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pssMechanisms are the hash and MGF used in the CKM_RSA_PKCS_PSS mechanism.
+var pssMechanisms = map[crypto.Hash][2]uint{
+	crypto.SHA256: {ckmSHA256, ckgMGF1SHA256},
+	crypto.SHA384: {ckmSHA384, ckgMGF1SHA384},
+	crypto.SHA512: {ckmSHA512, ckgMGF1SHA512},
+}
+
+// Signer implements a crypto.Signer using a private key in a PKCS #11 token.
+type Signer struct {
+	module    Module
+	key       ObjectHandle
+	publicKey crypto.PublicKey
+}
+
+// NewSigner creates a new signer using the given private key object and its
+// public key.
+func NewSigner(m Module, key ObjectHandle, pub crypto.PublicKey) *Signer {
+	return &Signer{
+		module:    m,
+		key:       key,
+		publicKey: pub,
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in the PKCS #11 token.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	h := opts.HashFunc()
+	if _, ok := hashPrefixes[h]; !ok {
+		return nil, errors.Errorf("unsupported hash function %v", h)
+	}
+	if len(digest) != h.Size() {
+		return nil, errors.New("digest length does not match hash function")
+	}
+
+	pub, ok := s.publicKey.(*rsa.PublicKey)
+	if !ok {
+		sig, err := s.module.Sign(&Mechanism{Type: ckmECDSA}, s.key, digest)
+		if err != nil {
+			return nil, errors.Wrap(err, "pkcs11 Sign failed")
+		}
+		return ecdsaSignature(sig)
+	}
+
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		saltLength := pss.SaltLength
+		switch saltLength {
+		case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash:
+			saltLength = h.Size()
+		}
+		if saltLength < 0 || saltLength > (pub.N.BitLen()-1+7)/8-h.Size()-2 {
+			return nil, errors.Errorf("invalid salt length %d", pss.SaltLength)
+		}
+		m := pssMechanisms[h]
+		sig, err := s.module.Sign(&Mechanism{
+			Type: ckmRSAPKCSPSS,
+			Parameter: &PSSParams{
+				Hash:       m[0],
+				MGF:        m[1],
+				SaltLength: uint(saltLength),
+			},
+		}, s.key, digest)
+		if err != nil {
+			return nil, errors.Wrap(err, "pkcs11 Sign failed")
+		}
+		return sig, nil
+	}
+
+	data := append(append([]byte{}, hashPrefixes[h]...), digest...)
+	sig, err := s.module.Sign(&Mechanism{Type: ckmRSAPKCS}, s.key, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 Sign failed")
+	}
+	return sig, nil
+}
+
+// ecdsaSignature converts the PKCS #11 ECDSA signature, the concatenation of r
+// and s, to the ASN.1 format used by Go.
+func ecdsaSignature(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("pkcs11 returned an invalid ECDSA signature")
+	}
+	n := len(sig) / 2
+	b, err := asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(sig[:n]),
+		S: new(big.Int).SetBytes(sig[n:]),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling ECDSA signature")
+	}
+	return b, nil
+}
@@ -0,0 +1,114 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	m := newMockModule()
+	k := NewPKCS11(m)
+	for _, req := range []*apiv1.CreateKeyRequest{
+		{Name: "ec"},
+		{Name: "rsa", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048},
+	} {
+		if _, err := k.CreateKey(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ecSigner, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "ec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "rsa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum256 := sha256.Sum256([]byte("data"))
+	sum384 := sha512.Sum384([]byte("data"))
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		digest  []byte
+		opts    crypto.SignerOpts
+		err     error
+		wantErr bool
+	}{
+		{"ok ecdsa", ecSigner, sum256[:], crypto.SHA256, nil, false},
+		{"ok rsa", rsaSigner, sum256[:], crypto.SHA256, nil, false},
+		{"ok rsa-pss", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, nil, false},
+		{"ok rsa-pss auto", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256}, nil, false},
+		{"ok rsa-pss salt", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, nil, false},
+		{"fail hash", ecSigner, sum256[:], crypto.SHA1, nil, true},
+		{"fail digest", ecSigner, sum384[:], crypto.SHA256, nil, true},
+		{"fail salt", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: 1024, Hash: crypto.SHA256}, nil, true},
+		{"fail ecdsa module", ecSigner, sum256[:], crypto.SHA256, errors.New("an error"), true},
+		{"fail rsa module", rsaSigner, sum256[:], crypto.SHA256, errors.New("an error"), true},
+		{"fail rsa-pss module", rsaSigner, sum256[:], &rsa.PSSOptions{Hash: crypto.SHA256}, errors.New("an error"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.err = tt.err
+			defer func() { m.err = nil }()
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			switch pub := tt.signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				var esig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub, tt.digest, esig.R, esig.S) {
+					t.Error("Signer.Sign() signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if _, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, crypto.SHA256, tt.digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, tt.digest, sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_ecdsaSignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		sig     []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", []byte{1, 2}, []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02}, false},
+		{"ok high bit", []byte{0x80, 0x01}, []byte{0x30, 0x07, 0x02, 0x02, 0x00, 0x80, 0x02, 0x01, 0x01}, false},
+		{"fail empty", nil, nil, true},
+		{"fail odd", []byte{1, 2, 3}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ecdsaSignature(tt.sig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ecdsaSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("ecdsaSignature() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package remotekms
+
+import "github.com/golang/protobuf/proto"
+
+// The messages in this file implement the wire format defined in
+// remotekms.proto.
+
+// GetPublicKeyRequest is the request of the GetPublicKey method.
+type GetPublicKeyRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *GetPublicKeyRequest) Reset()         { *m = GetPublicKeyRequest{} }
+func (m *GetPublicKeyRequest) String() string { return proto.CompactTextString(m) }
+func (*GetPublicKeyRequest) ProtoMessage()    {}
+
+// GetPublicKeyResponse is the response of the GetPublicKey method, the public
+// key is in PKIX, ASN.1 DER form.
+type GetPublicKeyResponse struct {
+	PublicKey []byte `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+}
+
+func (m *GetPublicKeyResponse) Reset()         { *m = GetPublicKeyResponse{} }
+func (m *GetPublicKeyResponse) String() string { return proto.CompactTextString(m) }
+func (*GetPublicKeyResponse) ProtoMessage()    {}
+
+// CreateKeyRequest is the request of the CreateKey method.
+type CreateKeyRequest struct {
+	Name               string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	SignatureAlgorithm int32  `protobuf:"varint,2,opt,name=signature_algorithm,json=signatureAlgorithm,proto3" json:"signature_algorithm,omitempty"`
+	Bits               int32  `protobuf:"varint,3,opt,name=bits,proto3" json:"bits,omitempty"`
+	ProtectionLevel    int32  `protobuf:"varint,4,opt,name=protection_level,json=protectionLevel,proto3" json:"protection_level,omitempty"`
+}
+
+func (m *CreateKeyRequest) Reset()         { *m = CreateKeyRequest{} }
+func (m *CreateKeyRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateKeyRequest) ProtoMessage()    {}
+
+// CreateKeyResponse is the response of the CreateKey method.
+type CreateKeyResponse struct {
+	Name       string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	PublicKey  []byte `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	SigningKey string `protobuf:"bytes,3,opt,name=signing_key,json=signingKey,proto3" json:"signing_key,omitempty"`
+}
+
+func (m *CreateKeyResponse) Reset()         { *m = CreateKeyResponse{} }
+func (m *CreateKeyResponse) String() string { return proto.CompactTextString(m) }
+func (*CreateKeyResponse) ProtoMessage()    {}
+
+// SignRequest is the request of the Sign method.
+type SignRequest struct {
+	SigningKey   string `protobuf:"bytes,1,opt,name=signing_key,json=signingKey,proto3" json:"signing_key,omitempty"`
+	Digest       []byte `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+	HashFunction uint32 `protobuf:"varint,3,opt,name=hash_function,json=hashFunction,proto3" json:"hash_function,omitempty"`
+	Pss          bool   `protobuf:"varint,4,opt,name=pss,proto3" json:"pss,omitempty"`
+	SaltLength   int32  `protobuf:"varint,5,opt,name=salt_length,json=saltLength,proto3" json:"salt_length,omitempty"`
+}
+
+func (m *SignRequest) Reset()         { *m = SignRequest{} }
+func (m *SignRequest) String() string { return proto.CompactTextString(m) }
+func (*SignRequest) ProtoMessage()    {}
+
+// SignResponse is the response of the Sign method.
+type SignResponse struct {
+	Signature []byte `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *SignResponse) Reset()         { *m = SignResponse{} }
+func (m *SignResponse) String() string { return proto.CompactTextString(m) }
+func (*SignResponse) ProtoMessage()    {}
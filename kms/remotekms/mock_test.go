@@ -0,0 +1,189 @@
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// softKeyManager is an in-memory KeyManager used in the server side of the
+// tests.
+type softKeyManager struct {
+	keys map[string]crypto.Signer
+}
+
+func newSoftKeyManager() *softKeyManager {
+	return &softKeyManager{keys: make(map[string]crypto.Signer)}
+}
+
+func (m *softKeyManager) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	key, ok := m.keys[req.Name]
+	if !ok {
+		return nil, errors.Errorf("key %s not found", req.Name)
+	}
+	return key.Public(), nil
+}
+
+func (m *softKeyManager) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if _, ok := m.keys[req.Name]; ok {
+		return nil, errors.Errorf("key %s already exists", req.Name)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[req.Name] = key
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: key.Public(),
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+	}, nil
+}
+
+func (m *softKeyManager) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	key, ok := m.keys[req.SigningKey]
+	if !ok {
+		return nil, errors.Errorf("key %s not found", req.SigningKey)
+	}
+	return key, nil
+}
+
+func (m *softKeyManager) Close() error {
+	return nil
+}
+
+// MockClient implements KeyManagerClient.
+type MockClient struct {
+	getPublicKey func(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error)
+	createKey    func(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error)
+	sign         func(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+}
+
+func (m *MockClient) GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+	return m.getPublicKey(ctx, in, opts...)
+}
+
+func (m *MockClient) CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error) {
+	return m.createKey(ctx, in, opts...)
+}
+
+func (m *MockClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	return m.sign(ctx, in, opts...)
+}
+
+// testPKI contains the paths of the certificates and keys used by the tests.
+type testPKI struct {
+	dir, root, serverCrt, serverKey, clientCrt, clientKey string
+}
+
+func mustPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "remotekms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testPKI{
+		dir:       dir,
+		root:      filepath.Join(dir, "root_ca.crt"),
+		serverCrt: filepath.Join(dir, "server.crt"),
+		serverKey: filepath.Join(dir, "server.key"),
+		clientCrt: filepath.Join(dir, "client.crt"),
+		clientKey: filepath.Join(dir, "client.key"),
+	}
+	mustWritePEM(t, p.root, "CERTIFICATE", der)
+
+	leaf := func(serial int64, cn string, eku x509.ExtKeyUsage, crtFile, keyFile string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			DNSNames:     []string{cn},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{eku},
+		}, root, key.Public(), rootKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustWritePEM(t, crtFile, "CERTIFICATE", der)
+		mustWritePEM(t, keyFile, "EC PRIVATE KEY", b)
+	}
+	leaf(2, "localhost", x509.ExtKeyUsageServerAuth, p.serverCrt, p.serverKey)
+	leaf(3, "step-ca", x509.ExtKeyUsageClientAuth, p.clientCrt, p.clientKey)
+	return p
+}
+
+func mustWritePEM(t *testing.T, filename, typ string, b []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startServer starts a signer daemon using the given server and returns its
+// address.
+func startServer(t *testing.T, p *testPKI, srv *Server) (string, func()) {
+	t.Helper()
+	config, err := NewServerTLSConfig(p.serverCrt, p.serverKey, p.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	RegisterKeyManagerServer(s, srv)
+	go s.Serve(l)
+	return l.Addr().String(), s.Stop
+}
@@ -0,0 +1,160 @@
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// RemoteKMS implements a KMS that forwards the key operations to a signer
+// daemon over gRPC. The connection is authenticated using mutual TLS, so the
+// private keys never leave the host running the daemon.
+type RemoteKMS struct {
+	conn   *grpc.ClientConn
+	client KeyManagerClient
+}
+
+// New creates a new RemoteKMS connected to the signer daemon in the address of
+// the options. The client certificate and key are used to authenticate to the
+// daemon, and the root bundle is used to verify its certificate.
+func New(ctx context.Context, opts apiv1.Options) (*RemoteKMS, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(opts.Certificate, opts.CertificateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading remotekms certificate")
+	}
+	pool, err := loadRoots(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.DialContext(ctx, opts.Address, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	})))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to remotekms %s", opts.Address)
+	}
+	return &RemoteKMS{
+		conn:   conn,
+		client: NewKeyManagerClient(conn),
+	}, nil
+}
+
+// NewRemoteKMS creates a new RemoteKMS with a given client.
+func NewRemoteKMS(client KeyManagerClient) *RemoteKMS {
+	return &RemoteKMS{
+		client: client,
+	}
+}
+
+// Close closes the connection to the signer daemon.
+func (k *RemoteKMS) Close() error {
+	if k.conn == nil {
+		return nil
+	}
+	if err := k.conn.Close(); err != nil {
+		return errors.Wrap(err, "remotekms Close failed")
+	}
+	return nil
+}
+
+// GetPublicKey returns the public key of the given key name in the signer
+// daemon.
+func (k *RemoteKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	return k.getPublicKey(req.Name)
+}
+
+// CreateKey creates a new key in the signer daemon. The daemon must be
+// configured to allow the creation of keys.
+func (k *RemoteKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.client.CreateKey(ctx, &CreateKeyRequest{
+		Name:               req.Name,
+		SignatureAlgorithm: int32(req.SignatureAlgorithm),
+		Bits:               int32(req.Bits),
+		ProtectionLevel:    int32(req.ProtectionLevel),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "remotekms CreateKey failed")
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing remotekms public key")
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      resp.Name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: resp.SigningKey,
+		},
+	}, nil
+}
+
+// CreateSigner returns a new signer that signs with the given key in the
+// signer daemon.
+func (k *RemoteKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	pub, err := k.getPublicKey(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(k.client, req.SigningKey, pub), nil
+}
+
+func (k *RemoteKMS) getPublicKey(name string) (crypto.PublicKey, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.client.GetPublicKey(ctx, &GetPublicKeyRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "remotekms GetPublicKey failed")
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing remotekms public key")
+	}
+	return pub, nil
+}
+
+// loadRoots returns a pool with the certificates in the given PEM bundle.
+func loadRoots(filename string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.Errorf("error parsing %s: no certificates found", filename)
+	}
+	return pool, nil
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
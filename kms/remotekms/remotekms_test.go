@@ -0,0 +1,196 @@
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"google.golang.org/grpc"
+)
+
+func TestNew(t *testing.T) {
+	p := mustPKI(t)
+	defer os.RemoveAll(p.dir)
+
+	km := newSoftKeyManager()
+	if _, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "intermediate"}); err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := startServer(t, p, NewServer(km, ServerOptions{}))
+	defer stop()
+
+	opts := apiv1.Options{
+		Type:           "remotekms",
+		Address:        addr,
+		Certificate:    p.clientCrt,
+		CertificateKey: p.clientKey,
+		Root:           p.root,
+	}
+	k, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "intermediate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("the-message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("signature verification failed")
+	}
+
+	// The server rejects clients without a certificate signed by the root.
+	bad := opts
+	bad.Certificate, bad.CertificateKey = p.serverCrt, p.serverKey
+	bad.Root = p.root
+	if k, err := New(context.Background(), bad); err == nil {
+		if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "intermediate"}); err == nil {
+			t.Error("RemoteKMS.GetPublicKey() error = nil, wantErr true")
+		}
+		k.Close()
+	}
+
+	failOpts := []apiv1.Options{
+		{Type: "remotekms"},
+		{Type: "remotekms", Address: addr, Certificate: "missing.crt", CertificateKey: p.clientKey, Root: p.root},
+		{Type: "remotekms", Address: addr, Certificate: p.clientCrt, CertificateKey: p.clientKey, Root: "missing.crt"},
+		{Type: "remotekms", Address: addr, Certificate: p.clientCrt, CertificateKey: p.clientKey, Root: p.clientKey},
+	}
+	for i, o := range failOpts {
+		if _, err := New(context.Background(), o); err == nil {
+			t.Errorf("New() %d error = nil, wantErr true", i)
+		}
+	}
+}
+
+func TestRemoteKMS_GetPublicKey(t *testing.T) {
+	km := newSoftKeyManager()
+	resp, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		client  KeyManagerClient
+		keyName string
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", &MockClient{getPublicKey: func(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+			return &GetPublicKeyResponse{PublicKey: der}, nil
+		}}, "key", resp.PublicKey, false},
+		{"fail name", &MockClient{}, "", nil, true},
+		{"fail client", &MockClient{getPublicKey: func(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+			return nil, errors.New("an error")
+		}}, "key", nil, true},
+		{"fail parse", &MockClient{getPublicKey: func(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+			return &GetPublicKeyResponse{PublicKey: []byte("bad")}, nil
+		}}, "key", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewRemoteKMS(tt.client)
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RemoteKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RemoteKMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteKMS_CreateKey(t *testing.T) {
+	km := newSoftKeyManager()
+	srv := NewServer(km, ServerOptions{AllowCreateKey: true})
+	client := &MockClient{
+		createKey: func(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error) {
+			return srv.CreateKey(ctx, in)
+		},
+	}
+
+	tests := []struct {
+		name    string
+		keyName string
+		wantErr bool
+	}{
+		{"ok", "new-key", false},
+		{"fail name", "", true},
+		{"fail exists", "new-key", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewRemoteKMS(client)
+			got, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: tt.keyName, SignatureAlgorithm: apiv1.ECDSAWithSHA256})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RemoteKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				if got.Name != tt.keyName || got.CreateSignerRequest.SigningKey != tt.keyName {
+					t.Errorf("RemoteKMS.CreateKey() = %v", got)
+				}
+				if !reflect.DeepEqual(got.PublicKey, km.keys[tt.keyName].Public()) {
+					t.Errorf("RemoteKMS.CreateKey() PublicKey = %v, want %v", got.PublicKey, km.keys[tt.keyName].Public())
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteKMS_CreateSigner(t *testing.T) {
+	km := newSoftKeyManager()
+	if _, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(km, ServerOptions{})
+	client := &MockClient{
+		getPublicKey: func(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+			return srv.GetPublicKey(ctx, in)
+		},
+	}
+
+	tests := []struct {
+		name       string
+		signingKey string
+		wantErr    bool
+	}{
+		{"ok", "key", false},
+		{"fail empty", "", true},
+		{"fail missing", "missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewRemoteKMS(client)
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.signingKey})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RemoteKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got.Public(), km.keys[tt.signingKey].Public()) {
+				t.Errorf("RemoteKMS.CreateSigner() Public = %v, want %v", got.Public(), km.keys[tt.signingKey].Public())
+			}
+		})
+	}
+}
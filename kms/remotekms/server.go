@@ -0,0 +1,193 @@
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KeyManager is the interface implemented by the KMS used in the signer
+// daemon, it matches kms.KeyManager.
+type KeyManager interface {
+	GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error)
+	CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error)
+	CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error)
+	Close() error
+}
+
+// ServerOptions are the options used to restrict the operations allowed in a
+// Server.
+type ServerOptions struct {
+	// AllowedKeys is the list of key names that can be used by the clients, if
+	// empty all the keys are allowed.
+	AllowedKeys []string
+	// AllowCreateKey enables the CreateKey method.
+	AllowCreateKey bool
+}
+
+// Server implements the KeyManager gRPC service using a local KMS. The signers
+// are created on first use and cached for the lifetime of the server.
+type Server struct {
+	km             KeyManager
+	allowedKeys    map[string]bool
+	allowCreateKey bool
+	mu             sync.Mutex
+	signers        map[string]crypto.Signer
+}
+
+// NewServer creates a new Server using the given KMS.
+func NewServer(km KeyManager, opts ServerOptions) *Server {
+	var allowed map[string]bool
+	if len(opts.AllowedKeys) > 0 {
+		allowed = make(map[string]bool, len(opts.AllowedKeys))
+		for _, name := range opts.AllowedKeys {
+			allowed[name] = true
+		}
+	}
+	return &Server{
+		km:             km,
+		allowedKeys:    allowed,
+		allowCreateKey: opts.AllowCreateKey,
+		signers:        make(map[string]crypto.Signer),
+	}
+}
+
+// GetPublicKey implements the GetPublicKey method.
+func (s *Server) GetPublicKey(ctx context.Context, req *GetPublicKeyRequest) (*GetPublicKeyResponse, error) {
+	if err := s.authorizeKey(req.Name); err != nil {
+		return nil, err
+	}
+	pub, err := s.km.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: req.Name,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &GetPublicKeyResponse{
+		PublicKey: b,
+	}, nil
+}
+
+// CreateKey implements the CreateKey method, it fails if the server does not
+// allow the creation of keys.
+func (s *Server) CreateKey(ctx context.Context, req *CreateKeyRequest) (*CreateKeyResponse, error) {
+	if !s.allowCreateKey {
+		return nil, status.Error(codes.PermissionDenied, "key creation is not allowed")
+	}
+	if err := s.authorizeKey(req.Name); err != nil {
+		return nil, err
+	}
+	resp, err := s.km.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               req.Name,
+		SignatureAlgorithm: apiv1.SignatureAlgorithm(req.SignatureAlgorithm),
+		Bits:               int(req.Bits),
+		ProtectionLevel:    apiv1.ProtectionLevel(req.ProtectionLevel),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// Only named keys can be used remotely.
+	if resp.CreateSignerRequest.SigningKey == "" {
+		return nil, status.Error(codes.Unimplemented, "the kms does not return a signing key name")
+	}
+	b, err := x509.MarshalPKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &CreateKeyResponse{
+		Name:       resp.Name,
+		PublicKey:  b,
+		SigningKey: resp.CreateSignerRequest.SigningKey,
+	}, nil
+}
+
+// Sign implements the Sign method.
+func (s *Server) Sign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	if err := s.authorizeKey(req.SigningKey); err != nil {
+		return nil, err
+	}
+	signer, err := s.getSigner(req.SigningKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var opts crypto.SignerOpts = crypto.Hash(req.HashFunction)
+	if req.Pss {
+		opts = &rsa.PSSOptions{
+			Hash:       crypto.Hash(req.HashFunction),
+			SaltLength: int(req.SaltLength),
+		}
+	}
+	if h := opts.HashFunc(); h != 0 && (!h.Available() || len(req.Digest) != h.Size()) {
+		return nil, status.Error(codes.InvalidArgument, "digest length does not match hash function")
+	}
+
+	sig, err := signer.Sign(rand.Reader, req.Digest, opts)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &SignResponse{
+		Signature: sig,
+	}, nil
+}
+
+// authorizeKey checks that the given key name can be used.
+func (s *Server) authorizeKey(name string) error {
+	switch {
+	case name == "":
+		return status.Error(codes.InvalidArgument, "key name cannot be empty")
+	case s.allowedKeys != nil && !s.allowedKeys[name]:
+		return status.Errorf(codes.PermissionDenied, "key %s is not allowed", name)
+	default:
+		return nil
+	}
+}
+
+func (s *Server) getSigner(name string) (crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if signer, ok := s.signers[name]; ok {
+		return signer, nil
+	}
+	signer, err := s.km.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.signers[name] = signer
+	return signer, nil
+}
+
+// NewServerTLSConfig returns the TLS configuration of a signer daemon using
+// the given certificate and key. Clients must present a certificate signed by
+// one of the certificates in the root bundle.
+func NewServerTLSConfig(certFile, keyFile, rootFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading remotekms certificate")
+	}
+	pool, err := loadRoots(rootFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
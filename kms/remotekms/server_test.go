@@ -0,0 +1,98 @@
+package remotekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	km := newSoftKeyManager()
+	km.keys["rsa"] = rsaKey
+	if _, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "ecdsa"}); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("the-message"))
+
+	tests := []struct {
+		name     string
+		opts     ServerOptions
+		req      *SignRequest
+		wantCode codes.Code
+	}{
+		{"ok", ServerOptions{}, &SignRequest{SigningKey: "ecdsa", Digest: digest[:], HashFunction: uint32(crypto.SHA256)}, codes.OK},
+		{"ok pss", ServerOptions{}, &SignRequest{SigningKey: "rsa", Digest: digest[:], HashFunction: uint32(crypto.SHA256), Pss: true, SaltLength: rsa.PSSSaltLengthEqualsHash}, codes.OK},
+		{"ok allowed", ServerOptions{AllowedKeys: []string{"ecdsa"}}, &SignRequest{SigningKey: "ecdsa", Digest: digest[:], HashFunction: uint32(crypto.SHA256)}, codes.OK},
+		{"fail not allowed", ServerOptions{AllowedKeys: []string{"ecdsa"}}, &SignRequest{SigningKey: "rsa", Digest: digest[:], HashFunction: uint32(crypto.SHA256)}, codes.PermissionDenied},
+		{"fail empty", ServerOptions{}, &SignRequest{Digest: digest[:], HashFunction: uint32(crypto.SHA256)}, codes.InvalidArgument},
+		{"fail digest", ServerOptions{}, &SignRequest{SigningKey: "ecdsa", Digest: digest[:10], HashFunction: uint32(crypto.SHA256)}, codes.InvalidArgument},
+		{"fail missing", ServerOptions{}, &SignRequest{SigningKey: "missing", Digest: digest[:], HashFunction: uint32(crypto.SHA256)}, codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(km, tt.opts)
+			got, err := s.Sign(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Server.Sign() error = %v, wantCode %v", err, tt.wantCode)
+				return
+			}
+			if err == nil && len(got.Signature) == 0 {
+				t.Error("Server.Sign() signature is empty")
+			}
+		})
+	}
+}
+
+func TestServer_CreateKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     ServerOptions
+		keyName  string
+		wantCode codes.Code
+	}{
+		{"ok", ServerOptions{AllowCreateKey: true}, "key", codes.OK},
+		{"fail not enabled", ServerOptions{}, "key", codes.PermissionDenied},
+		{"fail not allowed", ServerOptions{AllowCreateKey: true, AllowedKeys: []string{"other"}}, "key", codes.PermissionDenied},
+		{"fail empty", ServerOptions{AllowCreateKey: true}, "", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(newSoftKeyManager(), tt.opts)
+			_, err := s.CreateKey(context.Background(), &CreateKeyRequest{Name: tt.keyName})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Server.CreateKey() error = %v, wantCode %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_getSigner(t *testing.T) {
+	km := newSoftKeyManager()
+	if _, err := km.CreateKey(&apiv1.CreateKeyRequest{Name: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(km, ServerOptions{})
+	s1, err := s.getSigner("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(km.keys, "key")
+	s2, err := s.getSigner("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1 != s2 {
+		t.Error("Server.getSigner() did not return the cached signer")
+	}
+}
@@ -0,0 +1,120 @@
+package remotekms
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// serviceName is the full name of the gRPC service.
+const serviceName = "remotekms.KeyManager"
+
+// KeyManagerClient is the client API of the KeyManager service.
+type KeyManagerClient interface {
+	GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error)
+	CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error)
+	Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+}
+
+// KeyManagerServer is the server API of the KeyManager service.
+type KeyManagerServer interface {
+	GetPublicKey(context.Context, *GetPublicKeyRequest) (*GetPublicKeyResponse, error)
+	CreateKey(context.Context, *CreateKeyRequest) (*CreateKeyResponse, error)
+	Sign(context.Context, *SignRequest) (*SignResponse, error)
+}
+
+type keyManagerClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewKeyManagerClient returns a KeyManagerClient using the given connection.
+func NewKeyManagerClient(cc *grpc.ClientConn) KeyManagerClient {
+	return &keyManagerClient{cc}
+}
+
+func (c *keyManagerClient) GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+	out := new(GetPublicKeyResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/GetPublicKey", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyManagerClient) CreateKey(ctx context.Context, in *CreateKeyRequest, opts ...grpc.CallOption) (*CreateKeyResponse, error) {
+	out := new(CreateKeyResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/CreateKey", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyManagerClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	out := new(SignResponse)
+	if err := c.cc.Invoke(ctx, "/"+serviceName+"/Sign", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegisterKeyManagerServer registers the KeyManager service in the given gRPC
+// server.
+func RegisterKeyManagerServer(s *grpc.Server, srv KeyManagerServer) {
+	s.RegisterService(&keyManagerServiceDesc, srv)
+}
+
+var keyManagerServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*KeyManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetPublicKey", Handler: getPublicKeyHandler},
+		{MethodName: "CreateKey", Handler: createKeyHandler},
+		{MethodName: "Sign", Handler: signHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "remotekms.proto",
+}
+
+func getPublicKeyHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPublicKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyManagerServer).GetPublicKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/GetPublicKey"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyManagerServer).GetPublicKey(ctx, req.(*GetPublicKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func createKeyHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyManagerServer).CreateKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/CreateKey"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyManagerServer).CreateKey(ctx, req.(*CreateKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func signHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyManagerServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Sign"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyManagerServer).Sign(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -0,0 +1,56 @@
+package remotekms
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using a key in the signer daemon.
+type Signer struct {
+	client     KeyManagerClient
+	signingKey string
+	publicKey  crypto.PublicKey
+}
+
+// NewSigner creates a new signer using the given key name.
+func NewSigner(client KeyManagerClient, signingKey string, pub crypto.PublicKey) *Signer {
+	return &Signer{
+		client:     client,
+		signingKey: signingKey,
+		publicKey:  pub,
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the key in the signer daemon. The hash function and
+// the RSA-PSS options are sent with the request, the rand reader is ignored.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &SignRequest{
+		SigningKey:   s.signingKey,
+		Digest:       digest,
+		HashFunction: uint32(opts.HashFunc()),
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		req.Pss = true
+		req.SaltLength = int32(pss.SaltLength)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := s.client.Sign(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "remotekms Sign failed")
+	}
+	if len(resp.Signature) == 0 {
+		return nil, errors.New("remotekms returned an empty signature")
+	}
+	return resp.Signature, nil
+}
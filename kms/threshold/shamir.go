@@ -0,0 +1,127 @@
+package threshold
+
+import (
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+)
+
+// MaxShares is the maximum number of shares, the x coordinates of the shares
+// are the non-zero elements of GF(2^8).
+const MaxShares = 255
+
+// expTable and logTable are the exponential and logarithm tables of GF(2^8)
+// with the AES polynomial x^8 + x^4 + x^3 + x + 1, using 3 as generator.
+var expTable, logTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		// Multiply by the generator 3 = x + 1.
+		x ^= xtime(x)
+	}
+	expTable[255] = expTable[0]
+}
+
+// xtime multiplies a by x in GF(2^8).
+func xtime(a byte) byte {
+	if a&0x80 != 0 {
+		return a<<1 ^ 0x1b
+	}
+	return a << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// split splits secret in n parts using Shamir's secret sharing, any k of
+// them are required to recover the secret. The x coordinate of the part i is
+// i+1.
+func split(secret []byte, n, k int) ([][]byte, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, errors.New("secret cannot be empty")
+	case k < 2:
+		return nil, errors.New("threshold must be at least 2")
+	case n < k:
+		return nil, errors.New("number of shares cannot be less than the threshold")
+	case n > MaxShares:
+		return nil, errors.Errorf("number of shares cannot be greater than %d", MaxShares)
+	}
+
+	parts := make([][]byte, n)
+	for i := range parts {
+		parts[i] = make([]byte, len(secret))
+	}
+	coefficients := make([]byte, k-1)
+	defer zero(coefficients)
+	for j, s := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, errors.Wrap(err, "error generating random coefficients")
+		}
+		// Evaluate the polynomial s + c1*x + ... + ck-1*x^(k-1) using Horner's
+		// method.
+		for i := range parts {
+			x := byte(i + 1)
+			var y byte
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = gfMul(y^coefficients[c], x)
+			}
+			parts[i][j] = y ^ s
+		}
+	}
+	return parts, nil
+}
+
+// combine recovers the secret from the given parts and their x coordinates
+// using Lagrange interpolation.
+func combine(xs []byte, parts [][]byte) ([]byte, error) {
+	if len(xs) != len(parts) || len(parts) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	seen := make(map[byte]bool, len(xs))
+	for i, x := range xs {
+		if x == 0 || seen[x] {
+			return nil, errors.New("shares must have distinct non-zero indexes")
+		}
+		if len(parts[i]) != len(parts[0]) {
+			return nil, errors.New("shares must have the same length")
+		}
+		seen[x] = true
+	}
+
+	secret := make([]byte, len(parts[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial evaluated at 0.
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(parts[i][b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// zero overwrites b with zeros.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
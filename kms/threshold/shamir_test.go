@@ -0,0 +1,98 @@
+package threshold
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_gfMul(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfDiv(gfMul(byte(a), 0x53), 0x53); got != byte(a) {
+			t.Fatalf("gfDiv(gfMul(%d, 0x53), 0x53) = %d", a, got)
+		}
+	}
+	// Test vector from FIPS-197.
+	if got := gfMul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("gfMul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+}
+
+func Test_split_combine(t *testing.T) {
+	secret := []byte("the root key is split in many parts")
+	parts, err := split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 5 {
+		t.Fatalf("split() returned %d parts, want 5", len(parts))
+	}
+
+	tests := []struct {
+		name    string
+		indexes []int
+		want    bool
+	}{
+		{"ok first", []int{0, 1, 2}, true},
+		{"ok last", []int{4, 3, 2}, true},
+		{"ok all", []int{0, 1, 2, 3, 4}, true},
+		{"wrong below threshold", []int{0, 4}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var xs []byte
+			var ps [][]byte
+			for _, i := range tt.indexes {
+				xs = append(xs, byte(i+1))
+				ps = append(ps, parts[i])
+			}
+			got, err := combine(xs, ps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(got, secret) != tt.want {
+				t.Errorf("combine() = %q, equal %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_split_fail(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		n, k   int
+	}{
+		{"empty", nil, 3, 2},
+		{"threshold", []byte("secret"), 3, 1},
+		{"shares", []byte("secret"), 2, 3},
+		{"max", []byte("secret"), 256, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := split(tt.secret, tt.n, tt.k); err == nil {
+				t.Error("split() error = nil, wantErr true")
+			}
+		})
+	}
+}
+
+func Test_combine_fail(t *testing.T) {
+	tests := []struct {
+		name  string
+		xs    []byte
+		parts [][]byte
+	}{
+		{"one", []byte{1}, [][]byte{{1}}},
+		{"length", []byte{1, 2}, [][]byte{{1}}},
+		{"zero", []byte{0, 2}, [][]byte{{1}, {2}}},
+		{"duplicated", []byte{1, 1}, [][]byte{{1}, {2}}},
+		{"size", []byte{1, 2}, [][]byte{{1}, {2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := combine(tt.xs, tt.parts); err == nil {
+				t.Error("combine() error = nil, wantErr true")
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package threshold
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// pemType is the PEM type of a share.
+	pemType = "STEP KEY SHARE"
+	// encryptedPEMType is the PEM type of a share encrypted with a password.
+	encryptedPEMType = "ENCRYPTED STEP KEY SHARE"
+)
+
+// scrypt parameters used to derive the key that encrypts a share.
+const (
+	scryptN    = 32768
+	scryptR    = 8
+	scryptP    = 1
+	saltLength = 16
+)
+
+// Share is a part of a private key split using Shamir's secret sharing. KeyID
+// identifies the key, it is the hex encoded SHA-256 of the public key in PKIX
+// form.
+type Share struct {
+	KeyID     string
+	Threshold int
+	Shares    int
+	Index     int
+	Value     []byte
+}
+
+// Zero overwrites the value of the share.
+func (s *Share) Zero() {
+	zero(s.Value)
+}
+
+// SplitKey splits the given private key in n shares, any k of them can
+// reconstruct the key.
+func SplitKey(key crypto.Signer, n, k int) ([]*Share, error) {
+	keyID, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling private key")
+	}
+	defer zero(der)
+
+	parts, err := split(der, n, k)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]*Share, n)
+	for i, p := range parts {
+		shares[i] = &Share{
+			KeyID:     keyID,
+			Threshold: k,
+			Shares:    n,
+			Index:     i + 1,
+			Value:     p,
+		}
+	}
+	return shares, nil
+}
+
+// CombineKey reconstructs a private key from the given shares. All the shares
+// must be of the same key and there must be at least as many as the threshold.
+func CombineKey(shares []*Share) (crypto.Signer, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("shares cannot be empty")
+	}
+	first := shares[0]
+	if len(shares) < first.Threshold {
+		return nil, errors.Errorf("%d shares are required, but only %d were provided", first.Threshold, len(shares))
+	}
+	xs := make([]byte, len(shares))
+	parts := make([][]byte, len(shares))
+	for i, s := range shares {
+		if s.KeyID != first.KeyID || s.Threshold != first.Threshold || s.Shares != first.Shares {
+			return nil, errors.New("shares do not belong to the same key")
+		}
+		if s.Index < 1 || s.Index > MaxShares {
+			return nil, errors.Errorf("share has an invalid index %d", s.Index)
+		}
+		xs[i], parts[i] = byte(s.Index), s.Value
+	}
+
+	der, err := combine(xs, parts)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(der)
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("error reconstructing private key: invalid shares")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("key of type %T is not a crypto.Signer", key)
+	}
+	keyID, err := KeyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if keyID != first.KeyID {
+		return nil, errors.New("error reconstructing private key: key id does not match")
+	}
+	return signer, nil
+}
+
+// KeyID returns the identifier of the given public key used in the shares.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling public key")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// EncodeShare returns the PEM encoding of the share. If a password is given
+// the value of the share is encrypted using AES-GCM with a key derived using
+// scrypt, the headers are authenticated but not encrypted.
+func EncodeShare(s *Share, password []byte) ([]byte, error) {
+	block := &pem.Block{
+		Type: pemType,
+		Headers: map[string]string{
+			"Key-Id":    s.KeyID,
+			"Threshold": strconv.Itoa(s.Threshold),
+			"Shares":    strconv.Itoa(s.Shares),
+			"Index":     strconv.Itoa(s.Index),
+		},
+		Bytes: s.Value,
+	}
+	if len(password) > 0 {
+		salt := make([]byte, saltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, errors.Wrap(err, "error generating salt")
+		}
+		aead, err := newAEAD(password, salt)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, errors.Wrap(err, "error generating nonce")
+		}
+		ciphertext := aead.Seal(nil, nonce, s.Value, additionalData(s))
+		block.Type = encryptedPEMType
+		block.Bytes = bytes.Join([][]byte{salt, nonce, ciphertext}, nil)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// IsEncrypted returns true if the given PEM encoded share is encrypted.
+func IsEncrypted(b []byte) bool {
+	block, _ := pem.Decode(b)
+	return block != nil && block.Type == encryptedPEMType
+}
+
+// DecodeShare parses a PEM encoded share, the password is only used if the
+// share is encrypted.
+func DecodeShare(b, password []byte) (*Share, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || (block.Type != pemType && block.Type != encryptedPEMType) {
+		return nil, errors.New("error decoding share: not a valid PEM share")
+	}
+	s := &Share{
+		KeyID: block.Headers["Key-Id"],
+	}
+	var err error
+	if s.Threshold, err = strconv.Atoi(block.Headers["Threshold"]); err != nil {
+		return nil, errors.New("error decoding share: invalid threshold")
+	}
+	if s.Shares, err = strconv.Atoi(block.Headers["Shares"]); err != nil {
+		return nil, errors.New("error decoding share: invalid number of shares")
+	}
+	if s.Index, err = strconv.Atoi(block.Headers["Index"]); err != nil {
+		return nil, errors.New("error decoding share: invalid index")
+	}
+	if s.KeyID == "" {
+		return nil, errors.New("error decoding share: key id cannot be empty")
+	}
+
+	if block.Type == pemType {
+		s.Value = block.Bytes
+		return s, nil
+	}
+	if len(password) == 0 {
+		return nil, errors.New("error decoding share: share is encrypted")
+	}
+	if len(block.Bytes) < saltLength {
+		return nil, errors.New("error decoding share: invalid encrypted share")
+	}
+	aead, err := newAEAD(password, block.Bytes[:saltLength])
+	if err != nil {
+		return nil, err
+	}
+	rest := block.Bytes[saltLength:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("error decoding share: invalid encrypted share")
+	}
+	if s.Value, err = aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], additionalData(s)); err != nil {
+		return nil, errors.New("error decoding share: invalid password")
+	}
+	return s, nil
+}
+
+func newAEAD(password, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(password, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "error deriving key")
+	}
+	defer zero(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData(s *Share) []byte {
+	return []byte(fmt.Sprintf("%s;%d;%d;%d", s.KeyID, s.Threshold, s.Shares, s.Index))
+}
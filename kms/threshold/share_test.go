@@ -0,0 +1,125 @@
+package threshold
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSplitKey_CombineKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecShares, err := SplitKey(ecKey, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edShares, err := SplitKey(edKey, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherShares, err := SplitKey(otherKey, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *ecShares[2]
+	tampered.Value = append([]byte{}, tampered.Value...)
+	tampered.Value[10] ^= 0xff
+
+	tests := []struct {
+		name    string
+		shares  []*Share
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok ecdsa", []*Share{ecShares[0], ecShares[2], ecShares[4]}, ecKey, false},
+		{"ok ed25519", []*Share{edShares[2], edShares[0]}, edKey, false},
+		{"fail empty", nil, nil, true},
+		{"fail threshold", []*Share{ecShares[0], ecShares[1]}, nil, true},
+		{"fail mixed", []*Share{ecShares[0], ecShares[1], otherShares[2]}, nil, true},
+		{"fail duplicated", []*Share{ecShares[0], ecShares[1], ecShares[1]}, nil, true},
+		{"fail tampered", []*Share{ecShares[0], ecShares[1], &tampered}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CombineKey(tt.shares)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CombineKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CombineKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeShare_DecodeShare(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := EncodeShare(shares[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncodeShare(shares[1], []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsEncrypted(plain) || !IsEncrypted(encrypted) {
+		t.Errorf("IsEncrypted() = %v, %v, want false, true", IsEncrypted(plain), IsEncrypted(encrypted))
+	}
+	tampered := []byte(string(encrypted))
+	for i := range tampered {
+		if string(tampered[i:i+8]) == "Index: 2" {
+			tampered[i+7] = '3'
+			break
+		}
+	}
+
+	tests := []struct {
+		name     string
+		b        []byte
+		password []byte
+		want     *Share
+		wantErr  bool
+	}{
+		{"ok", plain, nil, shares[0], false},
+		{"ok encrypted", encrypted, []byte("password"), shares[1], false},
+		{"fail pem", []byte("not a share"), nil, nil, true},
+		{"fail no password", encrypted, nil, nil, true},
+		{"fail password", encrypted, []byte("other"), nil, true},
+		{"fail headers", tampered, []byte("password"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeShare(tt.b, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeShare() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeShare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package threshold
+
+import (
+	"crypto"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ShareProvider returns the shares used to reconstruct a key, e.g. asking the
+// custodians of the shares for their files and passwords.
+type ShareProvider func() ([]*Share, error)
+
+// Signer implements a crypto.Signer using a key split in shares. The key is
+// reconstructed for every signature and discarded afterwards, so no single
+// operator can sign without the cooperation of the others.
+type Signer struct {
+	publicKey crypto.PublicKey
+	keyID     string
+	provider  ShareProvider
+}
+
+// NewSigner creates a new signer for the given public key, the shares are
+// requested from the provider on every signature.
+func NewSigner(pub crypto.PublicKey, provider ShareProvider) (*Signer, error) {
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		publicKey: pub,
+		keyID:     keyID,
+		provider:  provider,
+	}, nil
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign reconstructs the private key from the shares and signs the digest with
+// it. The shares are zeroed after the key is reconstructed.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	shares, err := s.provider()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading shares")
+	}
+	defer func() {
+		for _, share := range shares {
+			share.Zero()
+		}
+	}()
+	for _, share := range shares {
+		if share.KeyID != s.keyID {
+			return nil, errors.Errorf("share %d does not belong to the signer key", share.Index)
+		}
+	}
+
+	key, err := CombineKey(shares)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand, digest, opts)
+}
@@ -0,0 +1,86 @@
+package threshold
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares := func(k *ecdsa.PrivateKey, n int) ShareProvider {
+		return func() ([]*Share, error) {
+			s, err := SplitKey(k, 3, 2)
+			if err != nil {
+				return nil, err
+			}
+			return s[:n], nil
+		}
+	}
+	digest := sha256.Sum256([]byte("the-message"))
+
+	tests := []struct {
+		name     string
+		provider ShareProvider
+		wantErr  bool
+	}{
+		{"ok", shares(key, 2), false},
+		{"fail threshold", shares(key, 1), true},
+		{"fail other key", shares(other, 2), true},
+		{"fail provider", func() ([]*Share, error) { return nil, errors.New("an error") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSigner(key.Public(), tt.provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+				t.Error("Signer.Sign() signature verification failed")
+			}
+		})
+	}
+}
+
+func TestSigner_zeroShares(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitKey(key, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSigner(key.Public(), func() ([]*Share, error) { return shares, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("the-message"))
+	if _, err := s.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	for _, share := range shares {
+		for _, b := range share.Value {
+			if b != 0 {
+				t.Fatalf("share %d was not zeroed", share.Index)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package tpmkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// mockKey is a key stored in the MockDevice.
+type mockKey struct {
+	signer   crypto.Signer
+	password string
+	pcrs     []int
+}
+
+// MockDevice is a Device using software keys.
+type MockDevice struct {
+	keys   map[uint32]*mockKey
+	err    error
+	closed bool
+}
+
+func newMockDevice() *MockDevice {
+	return &MockDevice{
+		keys: make(map[uint32]*mockKey),
+	}
+}
+
+func (d *MockDevice) CreateKey(handle uint32, tmpl *KeyTemplate) (crypto.PublicKey, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if _, ok := d.keys[handle]; ok {
+		return nil, errors.Errorf("tpm handle 0x%x is already in use", handle)
+	}
+	var signer crypto.Signer
+	var err error
+	switch tmpl.Algorithm {
+	case ECCP256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECCP384:
+		signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case RSA2048:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case RSA3072:
+		signer, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, errors.New("unsupported algorithm")
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.keys[handle] = &mockKey{signer: signer, password: tmpl.Password, pcrs: tmpl.PCRs}
+	return signer.Public(), nil
+}
+
+func (d *MockDevice) PublicKey(handle uint32) (crypto.PublicKey, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	key, ok := d.keys[handle]
+	if !ok {
+		return nil, Error(0x18B)
+	}
+	return key.signer.Public(), nil
+}
+
+func (d *MockDevice) Sign(handle uint32, auth *Authorization, digest []byte, scheme SignatureScheme) ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	key, ok := d.keys[handle]
+	if !ok {
+		return nil, Error(0x18B)
+	}
+	if len(key.pcrs) > 0 {
+		if !reflect.DeepEqual(key.pcrs, auth.PCRs) {
+			return nil, Error(0x99)
+		}
+	} else if key.password != auth.Password {
+		return nil, Error(0x98E)
+	}
+
+	var h crypto.Hash
+	for k, v := range hashAlgorithms {
+		if v == scheme.Hash {
+			h = k
+		}
+	}
+	switch scheme.Scheme {
+	case algECDSA:
+		return key.signer.Sign(rand.Reader, digest, h)
+	case algRSASSA:
+		return key.signer.Sign(rand.Reader, digest, h)
+	case algRSAPSS:
+		return key.signer.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h})
+	default:
+		return nil, Error(0x092)
+	}
+}
+
+func (d *MockDevice) Close() error {
+	d.closed = true
+	return d.err
+}
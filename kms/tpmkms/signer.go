@@ -0,0 +1,79 @@
+package tpmkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// hashAlgorithms maps the supported hash functions with the TPM algorithms.
+var hashAlgorithms = map[crypto.Hash]uint16{
+	crypto.SHA256: algSHA256,
+	crypto.SHA384: algSHA384,
+	crypto.SHA512: algSHA512,
+}
+
+// Signer implements a crypto.Signer using a key stored in the TPM.
+type Signer struct {
+	device    Device
+	handle    uint32
+	auth      *Authorization
+	publicKey crypto.PublicKey
+}
+
+// NewSigner creates a new signer using the key in the given persistent handle
+// and its public key.
+func NewSigner(d Device, handle uint32, auth *Authorization, pub crypto.PublicKey) *Signer {
+	return &Signer{
+		device:    d,
+		handle:    handle,
+		auth:      auth,
+		publicKey: pub,
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in the TPM. RSA-PSS signatures
+// use the salt length chosen by the TPM, so only rsa.PSSSaltLengthAuto and
+// rsa.PSSSaltLengthEqualsHash are supported.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	h := opts.HashFunc()
+	hash, ok := hashAlgorithms[h]
+	if !ok {
+		return nil, errors.Errorf("unsupported hash function %v", h)
+	}
+	if len(digest) != h.Size() {
+		return nil, errors.New("digest length does not match hash function")
+	}
+
+	scheme := SignatureScheme{Hash: hash}
+	switch s.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		scheme.Scheme = algECDSA
+	case *rsa.PublicKey:
+		scheme.Scheme = algRSASSA
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			switch pss.SaltLength {
+			case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash:
+			default:
+				return nil, errors.Errorf("unsupported salt length %d", pss.SaltLength)
+			}
+			scheme.Scheme = algRSAPSS
+		}
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", s.publicKey)
+	}
+
+	sig, err := s.device.Sign(s.handle, s.auth, digest, scheme)
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmkms Sign failed")
+	}
+	return sig, nil
+}
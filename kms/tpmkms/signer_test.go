@@ -0,0 +1,83 @@
+package tpmkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	d := newMockDevice()
+	k := NewTPMKMS(d, "password")
+	signer := func(req *apiv1.CreateKeyRequest) crypto.Signer {
+		resp, err := k.CreateKey(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := k.CreateSigner(&resp.CreateSignerRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	ecSigner := signer(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100"})
+	pcrSigner := signer(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000101", PCRs: []int{7}})
+	rsaSigner := signer(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000102", SignatureAlgorithm: apiv1.SHA256WithRSA})
+
+	sum256 := sha256.Sum256([]byte("data"))
+	sum384 := sha512.Sum384([]byte("data"))
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		digest  []byte
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok ecdsa", ecSigner, sum256[:], crypto.SHA256, false},
+		{"ok ecdsa SHA384", ecSigner, sum384[:], crypto.SHA384, false},
+		{"ok pcrs", pcrSigner, sum256[:], crypto.SHA256, false},
+		{"ok rsa", rsaSigner, sum256[:], crypto.SHA256, false},
+		{"ok rsa-pss", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, false},
+		{"fail hash", ecSigner, sum256[:], crypto.SHA1, true},
+		{"fail digest", ecSigner, sum384[:], crypto.SHA256, true},
+		{"fail salt", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, true},
+		{"fail password", NewSigner(d, 0x81000100, &Authorization{Password: "foo"}, ecSigner.Public()), sum256[:], crypto.SHA256, true},
+		{"fail policy", NewSigner(d, 0x81000101, &Authorization{PCRs: []int{0}}, ecSigner.Public()), sum256[:], crypto.SHA256, true},
+		{"fail key type", NewSigner(d, 0x81000100, &Authorization{}, []byte("foo")), sum256[:], crypto.SHA256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			switch pub := tt.signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				var esig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub, tt.digest, esig.R, esig.S) {
+					t.Error("Signer.Sign() signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if opts, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, opts.Hash, tt.digest, sig, opts)
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, tt.opts.HashFunc(), tt.digest, sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+}
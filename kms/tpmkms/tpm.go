@@ -0,0 +1,632 @@
+package tpmkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TPM 2.0 structure tags and command codes.
+const (
+	stNoSessions = 0x8001
+	stSessions   = 0x8002
+	stHashCheck  = 0x8024
+
+	ccEvictControl     = 0x00000120
+	ccCreatePrimary    = 0x00000131
+	ccCreate           = 0x00000153
+	ccLoad             = 0x00000157
+	ccSign             = 0x0000015D
+	ccFlushContext     = 0x00000165
+	ccReadPublic       = 0x00000173
+	ccStartAuthSession = 0x00000176
+	ccPolicyPCR        = 0x0000017F
+	ccPolicyGetDigest  = 0x00000189
+)
+
+// TPM 2.0 handles.
+const (
+	rhOwner = 0x40000001
+	rhNull  = 0x40000007
+	rsPW    = 0x40000009
+)
+
+// TPM 2.0 algorithms and curves.
+const (
+	algRSA    = 0x0001
+	algAES    = 0x0006
+	algSHA256 = 0x000B
+	algSHA384 = 0x000C
+	algSHA512 = 0x000D
+	algNull   = 0x0010
+	algRSASSA = 0x0014
+	algRSAPSS = 0x0016
+	algECDSA  = 0x0018
+	algECC    = 0x0023
+	algCFB    = 0x0043
+
+	eccNistP256 = 0x0003
+	eccNistP384 = 0x0004
+	eccNistP521 = 0x0005
+)
+
+// TPM 2.0 session types and attributes.
+const (
+	sePolicy = 0x01
+	seTrial  = 0x03
+
+	sessionContinue = 0x01
+)
+
+// TPM 2.0 object attributes.
+const (
+	attrFixedTPM            = 0x00000002
+	attrFixedParent         = 0x00000010
+	attrSensitiveDataOrigin = 0x00000020
+	attrUserWithAuth        = 0x00000040
+	attrNoDA                = 0x00000400
+	attrRestricted          = 0x00010000
+	attrDecrypt             = 0x00020000
+	attrSign                = 0x00040000
+)
+
+// maxResponseSize is the size of the buffer used to read the responses.
+const maxResponseSize = 4096
+
+// errorNames are the names of the common TPM 2.0 response codes. Format-one
+// codes are included without the handle, session or parameter number.
+var errorNames = map[uint32]string{
+	0x084: "TPM_RC_VALUE",
+	0x08B: "TPM_RC_HANDLE",
+	0x08E: "TPM_RC_AUTH_FAIL",
+	0x092: "TPM_RC_SCHEME",
+	0x099: "TPM_RC_POLICY_FAIL",
+	0x0A2: "TPM_RC_BAD_AUTH",
+	0x0A6: "TPM_RC_CURVE",
+	0x100: "TPM_RC_INITIALIZE",
+	0x101: "TPM_RC_FAILURE",
+	0x143: "TPM_RC_COMMAND_CODE",
+	0x14B: "TPM_RC_NV_SPACE",
+	0x14C: "TPM_RC_NV_DEFINED",
+	0x902: "TPM_RC_OBJECT_MEMORY",
+	0x903: "TPM_RC_SESSION_MEMORY",
+	0x908: "TPM_RC_YIELDED",
+	0x921: "TPM_RC_LOCKOUT",
+	0x922: "TPM_RC_RETRY",
+}
+
+// Error is a TPM 2.0 response code.
+type Error uint32
+
+// code returns the response code without the handle, session or parameter
+// number of format-one errors.
+func (e Error) code() uint32 {
+	if e&0x80 != 0 {
+		return uint32(e) & 0xBF
+	}
+	return uint32(e)
+}
+
+func (e Error) Error() string {
+	if s, ok := errorNames[e.code()]; ok {
+		return fmt.Sprintf("%s (0x%03X)", s, uint32(e))
+	}
+	return fmt.Sprintf("TPM_RC_0x%03X", uint32(e))
+}
+
+func isHandleError(err error) bool {
+	e, ok := errors.Cause(err).(Error)
+	return ok && e.code() == 0x08B
+}
+
+// device is the Device implemented sending TPM 2.0 commands to a TPM
+// character device, preferably the kernel resource manager.
+type device struct {
+	rw    io.ReadWriteCloser
+	mutex sync.Mutex
+}
+
+// openDevice opens the TPM device in the given path.
+func openDevice(path string) (Device, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening tpm")
+	}
+	return &device{rw: f}, nil
+}
+
+func (d *device) Close() error {
+	return d.rw.Close()
+}
+
+// CreateKey creates a signing key under the storage primary key and persists
+// it in the given handle.
+func (d *device) CreateKey(handle uint32, tmpl *KeyTemplate) (crypto.PublicKey, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, err := d.readPublic(handle); err == nil {
+		return nil, errors.Errorf("tpm handle 0x%x is already in use", handle)
+	} else if !isHandleError(err) {
+		return nil, err
+	}
+
+	template, err := keyTemplate(tmpl.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	var policy []byte
+	if len(tmpl.PCRs) > 0 {
+		if policy, err = d.pcrPolicyDigest(tmpl.PCRs); err != nil {
+			return nil, err
+		}
+		template.attributes &^= attrUserWithAuth
+	} else if tmpl.Password == "" {
+		template.attributes |= attrNoDA
+	}
+	template.policy = policy
+
+	srk, err := d.createPrimary()
+	if err != nil {
+		return nil, err
+	}
+	defer d.flush(srk)
+
+	private, public, err := d.create(srk, tmpl.Password, template.encode())
+	if err != nil {
+		return nil, err
+	}
+	key, err := d.load(srk, private, public)
+	if err != nil {
+		return nil, err
+	}
+	defer d.flush(key)
+
+	if err := d.evictControl(key, handle); err != nil {
+		return nil, err
+	}
+	return decodePublic(public)
+}
+
+// PublicKey returns the public key in the given handle.
+func (d *device) PublicKey(handle uint32) (crypto.PublicKey, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	public, err := d.readPublic(handle)
+	if err != nil {
+		return nil, err
+	}
+	return decodePublic(public)
+}
+
+// Sign signs the digest with the key in the given handle. Keys with PCRs are
+// authorized with a policy session, and keys without them with the password.
+func (d *device) Sign(handle uint32, auth *Authorization, digest []byte, scheme SignatureScheme) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var session []byte
+	if len(auth.PCRs) > 0 {
+		s, err := d.startAuthSession(sePolicy)
+		if err != nil {
+			return nil, err
+		}
+		defer d.flush(s)
+		if err := d.policyPCR(s, auth.PCRs); err != nil {
+			return nil, err
+		}
+		session = authArea(s, sessionContinue, nil)
+	} else {
+		session = authArea(rsPW, sessionContinue, []byte(auth.Password))
+	}
+
+	params := tpm2b(nil, digest)
+	params = u16(params, scheme.Scheme)
+	params = u16(params, scheme.Hash)
+	// Null validation ticket, the key is not restricted.
+	params = u16(params, stHashCheck)
+	params = u32(params, rhNull)
+	params = tpm2b(params, nil)
+
+	_, resp, err := d.run(ccSign, []uint32{handle}, session, params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{b: resp}
+	sigAlg := r.u16()
+	r.u16() // hash
+	switch sigAlg {
+	case algECDSA:
+		sr, ss := r.tpm2b(), r.tpm2b()
+		if r.err != nil {
+			return nil, errors.Wrap(r.err, "error parsing tpm signature")
+		}
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sr), new(big.Int).SetBytes(ss),
+		})
+	case algRSASSA, algRSAPSS:
+		sig := r.tpm2b()
+		if r.err != nil {
+			return nil, errors.Wrap(r.err, "error parsing tpm signature")
+		}
+		return sig, nil
+	default:
+		return nil, errors.Errorf("unsupported tpm signature algorithm 0x%04x", sigAlg)
+	}
+}
+
+// pcrPolicyDigest computes the digest of a PolicyPCR with the current values of
+// the PCRs using a trial session.
+func (d *device) pcrPolicyDigest(pcrs []int) ([]byte, error) {
+	s, err := d.startAuthSession(seTrial)
+	if err != nil {
+		return nil, err
+	}
+	defer d.flush(s)
+	if err := d.policyPCR(s, pcrs); err != nil {
+		return nil, err
+	}
+	_, resp, err := d.run(ccPolicyGetDigest, []uint32{s}, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{b: resp}
+	digest := r.tpm2b()
+	return digest, errors.Wrap(r.err, "error parsing tpm policy digest")
+}
+
+func (d *device) startAuthSession(sessionType byte) (uint32, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, errors.Wrap(err, "error generating nonce")
+	}
+	params := tpm2b(nil, nonce)
+	params = tpm2b(params, nil)
+	params = append(params, sessionType)
+	params = u16(params, algNull)
+	params = u16(params, algSHA256)
+	handles, _, err := d.run(ccStartAuthSession, []uint32{rhNull, rhNull}, nil, params, 1)
+	if err != nil {
+		return 0, err
+	}
+	return handles[0], nil
+}
+
+func (d *device) policyPCR(session uint32, pcrs []int) error {
+	params := tpm2b(nil, nil)
+	params = append(params, pcrSelection(pcrs)...)
+	_, _, err := d.run(ccPolicyPCR, []uint32{session}, nil, params, 0)
+	return err
+}
+
+// createPrimary creates the storage primary key in the owner hierarchy using
+// the ECC P-256 template of the TCG provisioning guidance.
+func (d *device) createPrimary() (uint32, error) {
+	srk := &publicArea{
+		alg:        algECC,
+		attributes: attrFixedTPM | attrFixedParent | attrSensitiveDataOrigin | attrUserWithAuth | attrNoDA | attrRestricted | attrDecrypt,
+		symmetric:  true,
+		curve:      eccNistP256,
+	}
+	params := sensitiveCreate("")
+	params = tpm2b(params, srk.encode())
+	params = tpm2b(params, nil)
+	params = u32(params, 0)
+	handles, _, err := d.run(ccCreatePrimary, []uint32{rhOwner}, authArea(rsPW, sessionContinue, nil), params, 1)
+	if err != nil {
+		return 0, err
+	}
+	return handles[0], nil
+}
+
+// create creates a key under the parent and returns its private and public
+// areas.
+func (d *device) create(parent uint32, password string, public []byte) ([]byte, []byte, error) {
+	params := sensitiveCreate(password)
+	params = tpm2b(params, public)
+	params = tpm2b(params, nil)
+	params = u32(params, 0)
+	_, resp, err := d.run(ccCreate, []uint32{parent}, authArea(rsPW, sessionContinue, nil), params, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &reader{b: resp}
+	private, outPublic := r.tpm2b(), r.tpm2b()
+	if r.err != nil {
+		return nil, nil, errors.Wrap(r.err, "error parsing tpm key")
+	}
+	return private, outPublic, nil
+}
+
+func (d *device) load(parent uint32, private, public []byte) (uint32, error) {
+	params := tpm2b(nil, private)
+	params = tpm2b(params, public)
+	handles, _, err := d.run(ccLoad, []uint32{parent}, authArea(rsPW, sessionContinue, nil), params, 1)
+	if err != nil {
+		return 0, err
+	}
+	return handles[0], nil
+}
+
+func (d *device) evictControl(object, persistent uint32) error {
+	_, _, err := d.run(ccEvictControl, []uint32{rhOwner, object}, authArea(rsPW, sessionContinue, nil), u32(nil, persistent), 0)
+	return err
+}
+
+func (d *device) readPublic(handle uint32) ([]byte, error) {
+	_, resp, err := d.run(ccReadPublic, []uint32{handle}, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{b: resp}
+	public := r.tpm2b()
+	return public, errors.Wrap(r.err, "error parsing tpm public key")
+}
+
+// flush flushes a transient object or session, errors are ignored.
+func (d *device) flush(handle uint32) {
+	d.run(ccFlushContext, nil, nil, u32(nil, handle), 0)
+}
+
+// run sends a command and returns the handles and parameters of the response.
+func (d *device) run(cc uint32, handles []uint32, session, params []byte, responseHandles int) ([]uint32, []byte, error) {
+	tag := uint16(stNoSessions)
+	var body []byte
+	for _, h := range handles {
+		body = u32(body, h)
+	}
+	if session != nil {
+		tag = stSessions
+		body = u32(body, uint32(len(session)))
+		body = append(body, session...)
+	}
+	body = append(body, params...)
+
+	cmd := u16(nil, tag)
+	cmd = u32(cmd, uint32(10+len(body)))
+	cmd = u32(cmd, cc)
+	cmd = append(cmd, body...)
+	if _, err := d.rw.Write(cmd); err != nil {
+		return nil, nil, errors.Wrap(err, "error writing to tpm")
+	}
+	buf := make([]byte, maxResponseSize)
+	n, err := d.rw.Read(buf)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error reading from tpm")
+	}
+
+	r := &reader{b: buf[:n]}
+	respTag, size, rc := r.u16(), r.u32(), r.u32()
+	switch {
+	case r.err != nil || int(size) != n:
+		return nil, nil, errors.New("tpm: invalid response")
+	case rc != 0:
+		return nil, nil, Error(rc)
+	}
+	out := make([]uint32, responseHandles)
+	for i := range out {
+		out[i] = r.u32()
+	}
+	if respTag == stSessions {
+		if resp := r.bytes(int(r.u32())); r.err == nil {
+			return out, resp, nil
+		}
+	}
+	if r.err != nil {
+		return nil, nil, errors.New("tpm: invalid response")
+	}
+	return out, r.b, nil
+}
+
+// publicArea is a TPMT_PUBLIC of the keys used in this package.
+type publicArea struct {
+	alg        uint16
+	attributes uint32
+	policy     []byte
+	// symmetric is set in storage keys, using AES-128-CFB.
+	symmetric bool
+	curve     uint16
+	keyBits   uint16
+}
+
+// keyTemplate returns the public area of a signing key.
+func keyTemplate(alg KeyAlgorithm) (*publicArea, error) {
+	area := &publicArea{
+		attributes: attrFixedTPM | attrFixedParent | attrSensitiveDataOrigin | attrUserWithAuth | attrSign,
+	}
+	switch alg {
+	case ECCP256:
+		area.alg, area.curve = algECC, eccNistP256
+	case ECCP384:
+		area.alg, area.curve = algECC, eccNistP384
+	case RSA2048:
+		area.alg, area.keyBits = algRSA, 2048
+	case RSA3072:
+		area.alg, area.keyBits = algRSA, 3072
+	default:
+		return nil, errors.Errorf("unsupported key algorithm %d", alg)
+	}
+	return area, nil
+}
+
+func (p *publicArea) encode() []byte {
+	b := u16(nil, p.alg)
+	b = u16(b, algSHA256)
+	b = u32(b, p.attributes)
+	b = tpm2b(b, p.policy)
+	if p.symmetric {
+		b = u16(b, algAES)
+		b = u16(b, 128)
+		b = u16(b, algCFB)
+	} else {
+		b = u16(b, algNull)
+	}
+	// The signature scheme is chosen at signing time.
+	b = u16(b, algNull)
+	switch p.alg {
+	case algECC:
+		b = u16(b, p.curve)
+		b = u16(b, algNull)
+		b = tpm2b(b, nil)
+		b = tpm2b(b, nil)
+	case algRSA:
+		b = u16(b, p.keyBits)
+		b = u32(b, 0)
+		b = tpm2b(b, nil)
+	}
+	return b
+}
+
+// decodePublic decodes the public key in a TPMT_PUBLIC.
+func decodePublic(b []byte) (crypto.PublicKey, error) {
+	r := &reader{b: b}
+	alg := r.u16()
+	r.u16()   // nameAlg
+	r.u32()   // objectAttributes
+	r.tpm2b() // authPolicy
+	if r.u16() != algNull {
+		r.u16() // keyBits
+		r.u16() // mode
+	}
+	if r.u16() != algNull {
+		r.u16() // hash
+	}
+
+	switch alg {
+	case algECC:
+		curveID := r.u16()
+		if r.u16() != algNull {
+			r.u16() // kdf hash
+		}
+		x, y := r.tpm2b(), r.tpm2b()
+		if r.err != nil {
+			return nil, errors.Wrap(r.err, "error parsing tpm public key")
+		}
+		var curve elliptic.Curve
+		switch curveID {
+		case eccNistP256:
+			curve = elliptic.P256()
+		case eccNistP384:
+			curve = elliptic.P384()
+		case eccNistP521:
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported tpm curve 0x%04x", curveID)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("error parsing tpm public key: invalid point")
+		}
+		return pub, nil
+	case algRSA:
+		r.u16() // keyBits
+		exponent := r.u32()
+		n := r.tpm2b()
+		if r.err != nil {
+			return nil, errors.Wrap(r.err, "error parsing tpm public key")
+		}
+		if exponent == 0 {
+			exponent = 65537
+		}
+		if len(n) == 0 || exponent > 1<<31-1 {
+			return nil, errors.New("error parsing tpm public key: invalid RSA key")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent),
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported tpm key algorithm 0x%04x", alg)
+	}
+}
+
+// authArea encodes a TPMS_AUTH_COMMAND.
+func authArea(handle uint32, attributes byte, hmac []byte) []byte {
+	b := u32(nil, handle)
+	b = tpm2b(b, nil)
+	b = append(b, attributes)
+	return tpm2b(b, hmac)
+}
+
+// sensitiveCreate encodes a TPM2B_SENSITIVE_CREATE with the given password.
+func sensitiveCreate(password string) []byte {
+	b := tpm2b(nil, []byte(password))
+	b = tpm2b(b, nil)
+	return tpm2b(nil, b)
+}
+
+// pcrSelection encodes a TPML_PCR_SELECTION with the PCRs in the SHA-256 bank.
+func pcrSelection(pcrs []int) []byte {
+	bitmap := make([]byte, 3)
+	for _, n := range pcrs {
+		bitmap[n/8] |= 1 << uint(n%8)
+	}
+	b := u32(nil, 1)
+	b = u16(b, algSHA256)
+	b = append(b, byte(len(bitmap)))
+	return append(b, bitmap...)
+}
+
+func u16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func u32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func tpm2b(b, v []byte) []byte {
+	b = u16(b, uint16(len(v)))
+	return append(b, v...)
+}
+
+// reader parses TPM 2.0 structures, after an error all the methods return
+// zero values.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.b) < n {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) tpm2b() []byte {
+	return r.bytes(int(r.u16()))
+}
@@ -0,0 +1,546 @@
+package tpmkms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// commandHandles is the number of handles in the commands used by this
+// package.
+var commandHandles = map[uint32]int{
+	ccEvictControl:     2,
+	ccCreatePrimary:    1,
+	ccCreate:           1,
+	ccLoad:             1,
+	ccSign:             1,
+	ccFlushContext:     0,
+	ccReadPublic:       1,
+	ccStartAuthSession: 2,
+	ccPolicyPCR:        1,
+	ccPolicyGetDigest:  1,
+}
+
+// fakeKey is an object in the fakeTPM.
+type fakeKey struct {
+	signer   crypto.Signer
+	public   *publicArea
+	password []byte
+}
+
+// fakeTPM parses the TPM 2.0 commands and implements them using software keys.
+type fakeTPM struct {
+	persistent map[uint32]*fakeKey
+	transient  map[uint32]*fakeKey
+	sessions   map[uint32][]int
+	created    map[string]*fakeKey
+	next       uint32
+	resp       []byte
+	failures   map[uint32]uint32
+	writeErr   error
+	closed     bool
+}
+
+func newFakeTPM() *fakeTPM {
+	return &fakeTPM{
+		persistent: make(map[uint32]*fakeKey),
+		transient:  make(map[uint32]*fakeKey),
+		sessions:   make(map[uint32][]int),
+		created:    make(map[string]*fakeKey),
+		failures:   make(map[uint32]uint32),
+	}
+}
+
+func (f *fakeTPM) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeTPM) Read(b []byte) (int, error) {
+	return copy(b, f.resp), nil
+}
+
+func (f *fakeTPM) Write(b []byte) (int, error) {
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	r := &reader{b: b}
+	tag, size, cc := r.u16(), r.u32(), r.u32()
+	if r.err != nil || int(size) != len(b) {
+		f.resp = response(stNoSessions, 0x01E, nil, nil) // TPM_RC_COMMAND_SIZE
+		return len(b), nil
+	}
+	handles := make([]uint32, commandHandles[cc])
+	for i := range handles {
+		handles[i] = r.u32()
+	}
+	var auth *reader
+	if tag == stSessions {
+		auth = &reader{b: r.bytes(int(r.u32()))}
+	}
+	if rc, ok := f.failures[cc]; ok {
+		f.resp = response(stNoSessions, rc, nil, nil)
+		return len(b), nil
+	}
+	out, params, rc := f.handle(cc, handles, auth, r)
+	if r.err != nil {
+		rc = 0x095 // TPM_RC_INSUFFICIENT
+	}
+	if rc != 0 {
+		f.resp = response(stNoSessions, rc, nil, nil)
+	} else {
+		f.resp = response(tag, 0, out, params)
+	}
+	return len(b), nil
+}
+
+func response(tag uint16, rc uint32, handles []uint32, params []byte) []byte {
+	var body []byte
+	for _, h := range handles {
+		body = u32(body, h)
+	}
+	if tag == stSessions {
+		body = u32(body, uint32(len(params)))
+		body = append(body, params...)
+		// Response auth area with an empty nonce and hmac.
+		body = append(body, 0x00, 0x00, sessionContinue, 0x00, 0x00)
+	} else {
+		body = append(body, params...)
+	}
+	b := u16(nil, tag)
+	b = u32(b, uint32(10+len(body)))
+	b = u32(b, rc)
+	return append(b, body...)
+}
+
+func (f *fakeTPM) handle(cc uint32, handles []uint32, auth, r *reader) ([]uint32, []byte, uint32) {
+	switch cc {
+	case ccReadPublic:
+		key, ok := f.persistent[handles[0]]
+		if !ok {
+			return nil, nil, 0x18B
+		}
+		return nil, tpm2b(nil, encodeFakePublic(key)), 0
+	case ccStartAuthSession:
+		if len(r.tpm2b()) != 16 || len(r.tpm2b()) != 0 {
+			return nil, nil, 0x084
+		}
+		r.bytes(1)
+		if r.u16() != algNull || r.u16() != algSHA256 {
+			return nil, nil, 0x084
+		}
+		f.next++
+		h := 0x03000000 + f.next
+		f.sessions[h] = nil
+		return []uint32{h}, nil, 0
+	case ccPolicyPCR:
+		if _, ok := f.sessions[handles[0]]; !ok {
+			return nil, nil, 0x18B
+		}
+		r.tpm2b()
+		f.sessions[handles[0]] = parsePCRSelection(r)
+		return nil, nil, 0
+	case ccPolicyGetDigest:
+		pcrs, ok := f.sessions[handles[0]]
+		if !ok {
+			return nil, nil, 0x18B
+		}
+		return nil, tpm2b(nil, policyDigest(pcrs)), 0
+	case ccCreatePrimary:
+		if handles[0] != rhOwner || !passwordAuth(auth, nil) {
+			return nil, nil, 0x98E
+		}
+		f.next++
+		h := 0x80000000 + f.next
+		f.transient[h] = &fakeKey{}
+		return []uint32{h}, nil, 0
+	case ccCreate:
+		if _, ok := f.transient[handles[0]]; !ok || !passwordAuth(auth, nil) {
+			return nil, nil, 0x98E
+		}
+		sensitive := &reader{b: r.tpm2b()}
+		password := sensitive.tpm2b()
+		area := parsePublicArea(r.tpm2b())
+		if area == nil {
+			return nil, nil, 0x2D2
+		}
+		key := &fakeKey{public: area, password: password}
+		var err error
+		switch {
+		case area.alg == algECC && area.curve == eccNistP256:
+			key.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case area.alg == algECC && area.curve == eccNistP384:
+			key.signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case area.alg == algRSA:
+			key.signer, err = rsa.GenerateKey(rand.Reader, int(area.keyBits))
+		default:
+			return nil, nil, 0x0A6
+		}
+		if err != nil {
+			return nil, nil, 0x101
+		}
+		private := make([]byte, 8)
+		rand.Read(private)
+		f.created[string(private)] = key
+		params := tpm2b(nil, private)
+		params = tpm2b(params, encodeFakePublic(key))
+		// creationData, creationHash and creationTicket are ignored.
+		return nil, params, 0
+	case ccLoad:
+		if _, ok := f.transient[handles[0]]; !ok || !passwordAuth(auth, nil) {
+			return nil, nil, 0x98E
+		}
+		key, ok := f.created[string(r.tpm2b())]
+		if !ok {
+			return nil, nil, 0x084
+		}
+		f.next++
+		h := 0x80000000 + f.next
+		f.transient[h] = key
+		return []uint32{h}, tpm2b(nil, []byte("name")), 0
+	case ccEvictControl:
+		key, ok := f.transient[handles[1]]
+		if handles[0] != rhOwner || !ok || !passwordAuth(auth, nil) {
+			return nil, nil, 0x98E
+		}
+		persistent := r.u32()
+		if _, ok := f.persistent[persistent]; ok {
+			return nil, nil, 0x14C
+		}
+		f.persistent[persistent] = key
+		return nil, nil, 0
+	case ccFlushContext:
+		h := r.u32()
+		delete(f.transient, h)
+		delete(f.sessions, h)
+		return nil, nil, 0
+	case ccSign:
+		key, ok := f.persistent[handles[0]]
+		if !ok {
+			return nil, nil, 0x18B
+		}
+		if key.public.policy != nil {
+			session := auth.u32()
+			pcrs, ok := f.sessions[session]
+			if !ok || !bytes.Equal(policyDigest(pcrs), key.public.policy) {
+				return nil, nil, 0x99
+			}
+		} else if !passwordAuth(auth, key.password) {
+			return nil, nil, 0x98E
+		}
+		digest := r.tpm2b()
+		scheme, hashAlg := r.u16(), r.u16()
+		if r.u16() != stHashCheck || r.u32() != rhNull || len(r.tpm2b()) != 0 {
+			return nil, nil, 0x084
+		}
+		var h crypto.Hash
+		for k, v := range hashAlgorithms {
+			if v == hashAlg {
+				h = k
+			}
+		}
+		params := u16(nil, scheme)
+		params = u16(params, hashAlg)
+		switch scheme {
+		case algECDSA:
+			sig, err := key.signer.Sign(rand.Reader, digest, h)
+			if err != nil {
+				return nil, nil, 0x101
+			}
+			var esig struct{ R, S *big.Int }
+			asn1.Unmarshal(sig, &esig)
+			params = tpm2b(params, esig.R.Bytes())
+			params = tpm2b(params, esig.S.Bytes())
+		case algRSASSA, algRSAPSS:
+			var opts crypto.SignerOpts = h
+			if scheme == algRSAPSS {
+				opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: h}
+			}
+			sig, err := key.signer.Sign(rand.Reader, digest, opts)
+			if err != nil {
+				return nil, nil, 0x101
+			}
+			params = tpm2b(params, sig)
+		default:
+			return nil, nil, 0x092
+		}
+		return nil, params, 0
+	default:
+		return nil, nil, 0x143
+	}
+}
+
+func passwordAuth(auth *reader, password []byte) bool {
+	if auth == nil {
+		return false
+	}
+	handle := auth.u32()
+	auth.tpm2b()
+	auth.bytes(1)
+	hmac := auth.tpm2b()
+	return auth.err == nil && handle == rsPW && bytes.Equal(hmac, password)
+}
+
+func parsePCRSelection(r *reader) []int {
+	var pcrs []int
+	for i := r.u32(); i > 0; i-- {
+		r.u16()
+		bitmap := r.bytes(int(r.bytes(1)[0]))
+		for n := 0; n < len(bitmap)*8; n++ {
+			if bitmap[n/8]&(1<<uint(n%8)) != 0 {
+				pcrs = append(pcrs, n)
+			}
+		}
+	}
+	return pcrs
+}
+
+func policyDigest(pcrs []int) []byte {
+	sum := sha256.Sum256(pcrSelection(pcrs))
+	return sum[:]
+}
+
+// parsePublicArea parses the templates created by keyTemplate.
+func parsePublicArea(b []byte) *publicArea {
+	r := &reader{b: b}
+	area := &publicArea{alg: r.u16()}
+	r.u16()
+	area.attributes = r.u32()
+	if policy := r.tpm2b(); len(policy) > 0 {
+		area.policy = policy
+	}
+	if r.u16() != algNull || r.u16() != algNull {
+		return nil
+	}
+	switch area.alg {
+	case algECC:
+		area.curve = r.u16()
+		r.u16()
+		r.tpm2b()
+		r.tpm2b()
+	case algRSA:
+		area.keyBits = r.u16()
+		r.u32()
+		r.tpm2b()
+	}
+	if r.err != nil || len(r.b) != 0 {
+		return nil
+	}
+	return area
+}
+
+// encodeFakePublic encodes the public area of a key with the unique field.
+func encodeFakePublic(key *fakeKey) []byte {
+	b := key.public.encode()
+	switch pub := key.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		b = b[:len(b)-4]
+		b = tpm2b(b, pub.X.Bytes())
+		b = tpm2b(b, pub.Y.Bytes())
+	case *rsa.PublicKey:
+		b = b[:len(b)-2]
+		b = tpm2b(b, pub.N.Bytes())
+	}
+	return b
+}
+
+func TestDevice(t *testing.T) {
+	tpm := newFakeTPM()
+	d := &device{rw: tpm}
+
+	// Password keys.
+	pub, err := d.CreateKey(0x81000100, &KeyTemplate{Algorithm: ECCP256, Password: "password"})
+	if err != nil {
+		t.Fatalf("device.CreateKey() error = %v", err)
+	}
+	if tpm.persistent[0x81000100].public.attributes&attrUserWithAuth == 0 {
+		t.Error("device.CreateKey() key does not have userWithAuth")
+	}
+	got, err := d.PublicKey(0x81000100)
+	if err != nil {
+		t.Fatalf("device.PublicKey() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, pub) || !reflect.DeepEqual(pub, tpm.persistent[0x81000100].signer.Public()) {
+		t.Errorf("device.PublicKey() = %v, want %v", got, pub)
+	}
+	digest := sha256.Sum256([]byte("data"))
+	sig, err := d.Sign(0x81000100, &Authorization{Password: "password"}, digest[:], SignatureScheme{algECDSA, algSHA256})
+	if err != nil {
+		t.Fatalf("device.Sign() error = %v", err)
+	}
+	var esig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub.(*ecdsa.PublicKey), digest[:], esig.R, esig.S) {
+		t.Error("device.Sign() signature is not valid")
+	}
+	if _, err := d.Sign(0x81000100, &Authorization{Password: "foo"}, digest[:], SignatureScheme{algECDSA, algSHA256}); err == nil {
+		t.Error("device.Sign() error = nil, wantErr true")
+	}
+
+	// RSA keys.
+	pub, err = d.CreateKey(0x81000101, &KeyTemplate{Algorithm: RSA2048})
+	if err != nil {
+		t.Fatalf("device.CreateKey() error = %v", err)
+	}
+	if tpm.persistent[0x81000101].public.attributes&attrNoDA == 0 {
+		t.Error("device.CreateKey() key without password does not have noDA")
+	}
+	for _, scheme := range []uint16{algRSASSA, algRSAPSS} {
+		sig, err := d.Sign(0x81000101, &Authorization{}, digest[:], SignatureScheme{scheme, algSHA256})
+		if err != nil {
+			t.Fatalf("device.Sign() error = %v", err)
+		}
+		if scheme == algRSAPSS {
+			err = rsa.VerifyPSS(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+		}
+		if err != nil {
+			t.Errorf("device.Sign() signature is not valid: %v", err)
+		}
+	}
+
+	// PCR policy keys.
+	pub, err = d.CreateKey(0x81000102, &KeyTemplate{Algorithm: ECCP384, PCRs: []int{0, 7}})
+	if err != nil {
+		t.Fatalf("device.CreateKey() error = %v", err)
+	}
+	key := tpm.persistent[0x81000102]
+	if key.public.attributes&attrUserWithAuth != 0 || !bytes.Equal(key.public.policy, policyDigest([]int{0, 7})) {
+		t.Errorf("device.CreateKey() public area = %v", key.public)
+	}
+	sum := sha256.Sum256([]byte("data"))
+	if sig, err = d.Sign(0x81000102, &Authorization{PCRs: []int{0, 7}}, sum[:], SignatureScheme{algECDSA, algSHA256}); err != nil {
+		t.Fatalf("device.Sign() error = %v", err)
+	}
+	if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub.(*ecdsa.PublicKey), sum[:], esig.R, esig.S) {
+		t.Error("device.Sign() signature is not valid")
+	}
+	if _, err := d.Sign(0x81000102, &Authorization{PCRs: []int{7}}, sum[:], SignatureScheme{algECDSA, algSHA256}); err == nil {
+		t.Error("device.Sign() error = nil, wantErr true")
+	}
+
+	// All the transient objects and sessions are flushed.
+	if len(tpm.transient) != 0 || len(tpm.sessions) != 0 {
+		t.Errorf("device left %d objects and %d sessions", len(tpm.transient), len(tpm.sessions))
+	}
+
+	// Errors.
+	if _, err := d.CreateKey(0x81000100, &KeyTemplate{Algorithm: ECCP256}); err == nil {
+		t.Error("device.CreateKey() error = nil, wantErr true")
+	}
+	if _, err := d.CreateKey(0x81000103, &KeyTemplate{}); err == nil {
+		t.Error("device.CreateKey() error = nil, wantErr true")
+	}
+	if _, err := d.PublicKey(0x81000103); !isHandleError(err) {
+		t.Errorf("device.PublicKey() error = %v, want TPM_RC_HANDLE", err)
+	}
+	for _, cc := range []uint32{ccReadPublic, ccStartAuthSession, ccCreatePrimary, ccCreate, ccLoad, ccEvictControl} {
+		tpm.failures[cc] = 0x101
+		if _, err := d.CreateKey(0x81000103, &KeyTemplate{Algorithm: ECCP256, PCRs: []int{1}}); err == nil {
+			t.Errorf("device.CreateKey() with failing 0x%x error = nil, wantErr true", cc)
+		}
+		delete(tpm.failures, cc)
+	}
+	if len(tpm.transient) != 0 || len(tpm.sessions) != 0 {
+		t.Errorf("device left %d objects and %d sessions", len(tpm.transient), len(tpm.sessions))
+	}
+	tpm.writeErr = errors.New("an error")
+	if _, err := d.PublicKey(0x81000100); err == nil {
+		t.Error("device.PublicKey() error = nil, wantErr true")
+	}
+
+	if err := d.Close(); err != nil || !tpm.closed {
+		t.Errorf("device.Close() error = %v", err)
+	}
+}
+
+func TestDevice_run(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    []byte
+		wantErr bool
+	}{
+		{"ok", response(stNoSessions, 0, nil, []byte{1, 2}), false},
+		{"ok sessions", response(stSessions, 0, nil, []byte{1, 2}), false},
+		{"fail short", []byte{0x80, 0x01}, true},
+		{"fail size", u32(u32(u16(nil, stNoSessions), 20), 0), true},
+		{"fail rc", response(stNoSessions, 0x101, nil, nil), true},
+		{"fail handles", response(stNoSessions, 0, nil, []byte{1, 2}), true},
+		{"fail params", u32(u32(u32(u16(nil, stSessions), 14), 0), 10), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &device{rw: &staticTPM{resp: tt.resp}}
+			responseHandles := 0
+			if tt.name == "fail handles" {
+				responseHandles = 1
+			}
+			_, params, err := d.run(ccReadPublic, nil, nil, nil, responseHandles)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("device.run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(params, []byte{1, 2}) {
+				t.Errorf("device.run() params = %x, want 0102", params)
+			}
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	tests := []struct {
+		err  Error
+		want string
+	}{
+		{0x18B, "TPM_RC_HANDLE (0x18B)"},
+		{0x98E, "TPM_RC_AUTH_FAIL (0x98E)"},
+		{0x921, "TPM_RC_LOCKOUT (0x921)"},
+		{0x14C, "TPM_RC_NV_DEFINED (0x14C)"},
+		{0x1FF, "TPM_RC_0x1FF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodePublic(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"fail empty", nil},
+		{"fail point", (&publicArea{alg: algECC, curve: eccNistP256}).encode()},
+		{"fail curve", (&publicArea{alg: algECC, curve: 0x0020}).encode()},
+		{"fail rsa", (&publicArea{alg: algRSA, keyBits: 2048}).encode()},
+		{"fail algorithm", (&publicArea{alg: 0x0025}).encode()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePublic(tt.b); err == nil {
+				t.Error("decodePublic() error = nil, wantErr true")
+			}
+		})
+	}
+}
+
+// staticTPM always returns the same response.
+type staticTPM struct {
+	resp []byte
+}
+
+func (s *staticTPM) Read(b []byte) (int, error)  { return copy(b, s.resp), nil }
+func (s *staticTPM) Write(b []byte) (int, error) { return len(b), nil }
+func (s *staticTPM) Close() error                { return nil }
@@ -0,0 +1,277 @@
+package tpmkms
+
+import (
+	"context"
+	"crypto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// uriScheme is the scheme used in the key names, e.g.
+// tpmkms:handle=0x81000100;pcrs=0,7.
+const uriScheme = "tpmkms:"
+
+// defaultDevice is the TPM resource manager in Linux.
+const defaultDevice = "/dev/tpmrm0"
+
+// Persistent handles that can be used by the owner, see the TCG "Registry of
+// Reserved TPM 2.0 Handles and Localities".
+const (
+	minPersistentHandle = 0x81000000
+	maxPersistentHandle = 0x81FFFFFF
+	maxPCR              = 23
+)
+
+// KeyAlgorithm is the type of key created in the TPM.
+type KeyAlgorithm int
+
+// Supported key algorithms.
+const (
+	ECCP256 KeyAlgorithm = iota + 1
+	ECCP384
+	RSA2048
+	RSA3072
+)
+
+// KeyTemplate are the parameters used to create a key in the TPM.
+type KeyTemplate struct {
+	Algorithm KeyAlgorithm
+	// Password is the authorization value of the key, it is not used if the
+	// key has a PCR policy.
+	Password string
+	// PCRs are the PCRs in the SHA-256 bank used in the key policy.
+	PCRs []int
+}
+
+// Authorization is the authorization used to sign with a key.
+type Authorization struct {
+	Password string
+	PCRs     []int
+}
+
+// SignatureScheme is the TPM signature scheme and hash algorithm used to sign.
+type SignatureScheme struct {
+	Scheme uint16
+	Hash   uint16
+}
+
+// Device is the interface with the TPM operations used by the TPMKMS. The
+// default implementation sends the TPM 2.0 commands to the TPM character
+// device; this interface will be used for unit testing.
+type Device interface {
+	CreateKey(handle uint32, tmpl *KeyTemplate) (crypto.PublicKey, error)
+	PublicKey(handle uint32) (crypto.PublicKey, error)
+	Sign(handle uint32, auth *Authorization, digest []byte, scheme SignatureScheme) ([]byte, error)
+	Close() error
+}
+
+// TPMKMS implements a KMS using the keys stored in the persistent handles of a
+// TPM 2.0. The keys are created under the storage primary key of the owner
+// hierarchy, and they can be bound to the values of a list of PCRs.
+type TPMKMS struct {
+	device   Device
+	password string
+}
+
+// New opens the TPM device in the given path, /dev/tpmrm0 by default.
+func New(ctx context.Context, opts apiv1.Options) (*TPMKMS, error) {
+	path := opts.Device
+	if path == "" {
+		path = defaultDevice
+	}
+	d, err := openDevice(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTPMKMS(d, opts.Pin), nil
+}
+
+// NewTPMKMS creates a TPMKMS using the given device. The password is used as
+// the authorization value of the keys without a PCR policy.
+func NewTPMKMS(d Device, password string) *TPMKMS {
+	return &TPMKMS{
+		device:   d,
+		password: password,
+	}
+}
+
+// Close closes the TPM device.
+func (k *TPMKMS) Close() error {
+	if err := k.device.Close(); err != nil {
+		return errors.Wrap(err, "error closing tpm")
+	}
+	return nil
+}
+
+// GetPublicKey returns the public key of the key in the given handle.
+func (k *TPMKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	handle, _, err := parseName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.device.PublicKey(handle)
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmkms GetPublicKey failed")
+	}
+	return pub, nil
+}
+
+// CreateKey creates a key in the given persistent handle. If the request or
+// the name contain a list of PCRs, the key can only be used while the PCRs
+// keep their current values, and the returned name contains the PCRs.
+func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	handle, pcrs, err := parseName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.PCRs) > 0 {
+		if pcrs, err = normalizePCRs(req.PCRs); err != nil {
+			return nil, err
+		}
+	}
+	alg, err := keyAlgorithm(req.SignatureAlgorithm, req.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &KeyTemplate{
+		Algorithm: alg,
+		PCRs:      pcrs,
+	}
+	if len(pcrs) == 0 {
+		tmpl.Password = k.password
+	}
+	pub, err := k.device.CreateKey(handle, tmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmkms CreateKey failed")
+	}
+
+	name := formatName(handle, pcrs)
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a signer using the key in the given handle.
+func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	handle, pcrs, err := parseName(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.device.PublicKey(handle)
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmkms CreateSigner failed")
+	}
+	auth := &Authorization{PCRs: pcrs}
+	if len(pcrs) == 0 {
+		auth.Password = k.password
+	}
+	return NewSigner(k.device, handle, auth, pub), nil
+}
+
+// keyAlgorithm returns the TPM key algorithm for the given signature algorithm
+// and bits.
+func keyAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (KeyAlgorithm, error) {
+	switch alg {
+	case apiv1.UnspecifiedSignAlgorithm, apiv1.ECDSAWithSHA256:
+		return ECCP256, nil
+	case apiv1.ECDSAWithSHA384:
+		return ECCP384, nil
+	case apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA,
+		apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS:
+		switch bits {
+		case 0, 2048:
+			return RSA2048, nil
+		case 3072:
+			return RSA3072, nil
+		}
+	}
+	return 0, errors.Errorf("tpmkms does not support signature algorithm '%s' with %d bits", alg, bits)
+}
+
+// parseName parses a key name like tpmkms:handle=0x81000100;pcrs=0,7 and
+// returns the persistent handle and the PCRs.
+func parseName(name string) (uint32, []int, error) {
+	if !strings.HasPrefix(name, uriScheme) {
+		return 0, nil, errors.Errorf("error parsing %s: name must start with %s", name, uriScheme)
+	}
+	var handle uint64
+	var pcrs []int
+	var err error
+	for _, attr := range strings.Split(strings.TrimPrefix(name, uriScheme), ";") {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return 0, nil, errors.Errorf("error parsing %s: invalid attribute %s", name, attr)
+		}
+		switch parts[0] {
+		case "handle":
+			if handle, err = strconv.ParseUint(parts[1], 0, 32); err != nil {
+				return 0, nil, errors.Errorf("error parsing %s: invalid handle", name)
+			}
+		case "pcrs":
+			var list []int
+			for _, s := range strings.Split(parts[1], ",") {
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					return 0, nil, errors.Errorf("error parsing %s: invalid pcrs", name)
+				}
+				list = append(list, n)
+			}
+			if pcrs, err = normalizePCRs(list); err != nil {
+				return 0, nil, errors.Wrapf(err, "error parsing %s", name)
+			}
+		default:
+			return 0, nil, errors.Errorf("error parsing %s: unsupported attribute %s", name, parts[0])
+		}
+	}
+	if handle < minPersistentHandle || handle > maxPersistentHandle {
+		return 0, nil, errors.Errorf("error parsing %s: handle must be a persistent handle between 0x%x and 0x%x", name, minPersistentHandle, maxPersistentHandle)
+	}
+	return uint32(handle), pcrs, nil
+}
+
+// normalizePCRs validates, sorts and removes the duplicates in a list of PCRs.
+func normalizePCRs(pcrs []int) ([]int, error) {
+	seen := make(map[int]bool)
+	var list []int
+	for _, n := range pcrs {
+		if n < 0 || n > maxPCR {
+			return nil, errors.Errorf("invalid pcr %d", n)
+		}
+		if !seen[n] {
+			seen[n] = true
+			list = append(list, n)
+		}
+	}
+	sort.Ints(list)
+	return list, nil
+}
+
+func formatName(handle uint32, pcrs []int) string {
+	name := uriScheme + "handle=0x" + strconv.FormatUint(uint64(handle), 16)
+	if len(pcrs) > 0 {
+		s := make([]string, len(pcrs))
+		for i, n := range pcrs {
+			s[i] = strconv.Itoa(n)
+		}
+		name += ";pcrs=" + strings.Join(s, ",")
+	}
+	return name
+}
@@ -0,0 +1,230 @@
+package tpmkms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestNew(t *testing.T) {
+	f, err := ioutil.TempFile("", "tpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"ok", apiv1.Options{Type: "tpmkms", Device: f.Name()}, false},
+		{"fail missing device", apiv1.Options{Type: "tpmkms", Device: "testdata/missing"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != nil {
+				got.Close()
+			}
+		})
+	}
+}
+
+func TestTPMKMS_Close(t *testing.T) {
+	d := newMockDevice()
+	k := NewTPMKMS(d, "")
+	if err := k.Close(); err != nil {
+		t.Errorf("TPMKMS.Close() error = %v", err)
+	}
+	if !d.closed {
+		t.Error("TPMKMS.Close() did not close the device")
+	}
+	d.err = errors.New("an error")
+	if err := k.Close(); err == nil {
+		t.Error("TPMKMS.Close() error = nil, wantErr true")
+	}
+}
+
+func TestTPMKMS_CreateKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *apiv1.CreateKeyRequest
+		wantName string
+		curve    elliptic.Curve
+		bits     int
+		password string
+		pcrs     []int
+		wantErr  bool
+	}{
+		{"ok default", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100"}, "tpmkms:handle=0x81000100", elliptic.P256(), 0, "password", nil, false},
+		{"ok P-384", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000101", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, "tpmkms:handle=0x81000101", elliptic.P384(), 0, "password", nil, false},
+		{"ok RSA", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000102", SignatureAlgorithm: apiv1.SHA256WithRSA}, "tpmkms:handle=0x81000102", nil, 2048, "password", nil, false},
+		{"ok RSA 3072", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=2164261123", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 3072}, "tpmkms:handle=0x81000103", nil, 3072, "password", nil, false},
+		{"ok pcrs", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000104", PCRs: []int{7, 0, 7, 2}}, "tpmkms:handle=0x81000104;pcrs=0,2,7", elliptic.P256(), 0, "", []int{0, 2, 7}, false},
+		{"ok pcrs in name", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000105;pcrs=7"}, "tpmkms:handle=0x81000105;pcrs=7", elliptic.P256(), 0, "", []int{7}, false},
+		{"fail name", &apiv1.CreateKeyRequest{}, "", nil, 0, "", nil, true},
+		{"fail handle", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x80000000"}, "", nil, 0, "", nil, true},
+		{"fail pcrs", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100", PCRs: []int{24}}, "", nil, 0, "", nil, true},
+		{"fail Ed25519", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100", SignatureAlgorithm: apiv1.PureEd25519}, "", nil, 0, "", nil, true},
+		{"fail RSA 1024", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, "", nil, 0, "", nil, true},
+		{"fail in use", &apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000001"}, "", nil, 0, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newMockDevice()
+			d.keys[0x81000001] = &mockKey{}
+			k := NewTPMKMS(d, "password")
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TPMKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.wantName || got.CreateSignerRequest.SigningKey != tt.wantName {
+				t.Errorf("TPMKMS.CreateKey() name = %s, want %s", got.Name, tt.wantName)
+			}
+			handle, _, err := parseName(got.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := d.keys[handle]
+			if key.password != tt.password || !reflect.DeepEqual(key.pcrs, tt.pcrs) {
+				t.Errorf("TPMKMS.CreateKey() key = %v", key)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.curve {
+					t.Errorf("TPMKMS.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.curve.Params().Name)
+				}
+			case *rsa.PublicKey:
+				if pub.N.BitLen() != tt.bits {
+					t.Errorf("TPMKMS.CreateKey() bits = %d, want %d", pub.N.BitLen(), tt.bits)
+				}
+			default:
+				t.Errorf("TPMKMS.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+
+	d := newMockDevice()
+	d.err = errors.New("an error")
+	if _, err := NewTPMKMS(d, "").CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100"}); err == nil {
+		t.Error("TPMKMS.CreateKey() error = nil, wantErr true")
+	}
+}
+
+func TestTPMKMS_GetPublicKey(t *testing.T) {
+	d := newMockDevice()
+	k := NewTPMKMS(d, "")
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.GetPublicKeyRequest
+		wantErr bool
+	}{
+		{"ok", &apiv1.GetPublicKeyRequest{Name: "tpmkms:handle=0x81000100"}, false},
+		{"ok pcrs", &apiv1.GetPublicKeyRequest{Name: "tpmkms:handle=0x81000100;pcrs=7"}, false},
+		{"fail name", &apiv1.GetPublicKeyRequest{}, true},
+		{"fail scheme", &apiv1.GetPublicKeyRequest{Name: "pkcs11:handle=0x81000100"}, true},
+		{"fail missing", &apiv1.GetPublicKeyRequest{Name: "tpmkms:handle=0x81000101"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TPMKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, resp.PublicKey) {
+				t.Errorf("TPMKMS.GetPublicKey() = %v, want %v", got, resp.PublicKey)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_CreateSigner(t *testing.T) {
+	d := newMockDevice()
+	k := NewTPMKMS(d, "password")
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000100"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:handle=0x81000101", PCRs: []int{0, 7}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateSignerRequest
+		want    *Authorization
+		wantErr bool
+	}{
+		{"ok password", &apiv1.CreateSignerRequest{SigningKey: "tpmkms:handle=0x81000100"}, &Authorization{Password: "password"}, false},
+		{"ok pcrs", &apiv1.CreateSignerRequest{SigningKey: "tpmkms:handle=0x81000101;pcrs=7,0"}, &Authorization{PCRs: []int{0, 7}}, false},
+		{"fail empty", &apiv1.CreateSignerRequest{}, nil, true},
+		{"fail name", &apiv1.CreateSignerRequest{SigningKey: "tpmkms:handle=0x81000100;foo=bar"}, nil, true},
+		{"fail missing", &apiv1.CreateSignerRequest{SigningKey: "tpmkms:handle=0x81000102"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TPMKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if s := got.(*Signer); !reflect.DeepEqual(s.auth, tt.want) {
+				t.Errorf("TPMKMS.CreateSigner() auth = %v, want %v", s.auth, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseName(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantHandle uint32
+		wantPCRs   []int
+		wantErr    bool
+	}{
+		{"tpmkms:handle=0x81000100", 0x81000100, nil, false},
+		{"tpmkms:handle=0x81ffffff;pcrs=23,0", 0x81ffffff, []int{0, 23}, false},
+		{"tpmkms:pcrs=1;handle=2164260864", 0x81000000, []int{1}, false},
+		{"handle=0x81000100", 0, nil, true},
+		{"tpmkms:", 0, nil, true},
+		{"tpmkms:handle", 0, nil, true},
+		{"tpmkms:handle=foo", 0, nil, true},
+		{"tpmkms:handle=0x82000000", 0, nil, true},
+		{"tpmkms:handle=0x81000100;pcrs=", 0, nil, true},
+		{"tpmkms:handle=0x81000100;pcrs=-1", 0, nil, true},
+		{"tpmkms:handle=0x81000100;id=1", 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, pcrs, err := parseName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if handle != tt.wantHandle || !reflect.DeepEqual(pcrs, tt.wantPCRs) {
+				t.Errorf("parseName() = %x, %v, want %x, %v", handle, pcrs, tt.wantHandle, tt.wantPCRs)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package vaultkms
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// tokenRetryInterval is the time to wait before retrying a failed token
+// renewal.
+var tokenRetryInterval = 30 * time.Second
+
+// httpClient implements TransitClient using the Vault HTTP API. If the token is
+// renewable, it is renewed in the background when two thirds of its TTL have
+// passed.
+type httpClient struct {
+	client    *http.Client
+	address   string
+	namespace string
+	token     string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHTTPClient(ctx context.Context, opts apiv1.Options) (*httpClient, error) {
+	c := &httpClient{
+		client:    &http.Client{Timeout: 30 * time.Second},
+		address:   strings.TrimRight(firstNonEmpty(opts.Address, os.Getenv("VAULT_ADDR")), "/"),
+		namespace: strings.Trim(firstNonEmpty(opts.Namespace, os.Getenv("VAULT_NAMESPACE")), "/"),
+		token:     firstNonEmpty(opts.Token, os.Getenv("VAULT_TOKEN")),
+		done:      make(chan struct{}),
+	}
+	switch {
+	case c.address == "":
+		return nil, errors.New("vaultkms address cannot be empty, use the address option or VAULT_ADDR")
+	case c.token == "":
+		return nil, errors.New("vaultkms token cannot be empty, use the token option or VAULT_TOKEN")
+	}
+	if _, err := url.Parse(c.address); err != nil {
+		return nil, errors.Wrapf(err, "error parsing vaultkms address %s", c.address)
+	}
+	if path := os.Getenv("VAULT_CACERT"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", path)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("error parsing %s: no certificates found", path)
+		}
+		c.client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}
+	}
+
+	// Validate the token, and start the renewal if necessary.
+	var lookup struct {
+		TTL       int  `json:"ttl"`
+		Renewable bool `json:"renewable"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/auth/token/lookup-self", nil, &lookup); err != nil {
+		return nil, errors.Wrap(err, "vaultkms token lookup failed")
+	}
+	if lookup.Renewable && lookup.TTL > 0 {
+		go c.renewToken(time.Duration(lookup.TTL) * time.Second)
+	}
+	return c, nil
+}
+
+func (c *httpClient) ReadKey(ctx context.Context, mount, name string) (*KeyInfo, error) {
+	var info KeyInfo
+	if err := c.do(ctx, http.MethodGet, keyPath(mount, "keys", name), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *httpClient) CreateKey(ctx context.Context, mount, name string, params *CreateKeyParameters) error {
+	return c.do(ctx, http.MethodPost, keyPath(mount, "keys", name), params, nil)
+}
+
+func (c *httpClient) Sign(ctx context.Context, mount, name string, params *SignParameters) (*SignResult, error) {
+	var result SignResult
+	if err := c.do(ctx, http.MethodPost, keyPath(mount, "sign", name), params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *httpClient) Verify(ctx context.Context, mount, name string, params *VerifyParameters) (bool, error) {
+	var result struct {
+		Valid bool `json:"valid"`
+	}
+	if err := c.do(ctx, http.MethodPost, keyPath(mount, "verify", name), params, &result); err != nil {
+		return false, err
+	}
+	return result.Valid, nil
+}
+
+func (c *httpClient) Decrypt(ctx context.Context, mount, name, ciphertext string) ([]byte, error) {
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+	params := map[string]string{"ciphertext": ciphertext}
+	if err := c.do(ctx, http.MethodPost, keyPath(mount, "decrypt", name), params, &result); err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding plaintext")
+	}
+	return plaintext, nil
+}
+
+// Close stops the renewal of the token. The token is not revoked.
+func (c *httpClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+// renewToken renews the token until the client is closed or the token is no
+// longer renewable, e.g. if it has reached its max TTL.
+func (c *httpClient) renewToken(ttl time.Duration) {
+	wait := ttl * 2 / 3
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		var auth struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		}
+		ctx, cancel := defaultContext()
+		err := c.do(ctx, http.MethodPost, "/v1/auth/token/renew-self", map[string]string{}, &auth)
+		cancel()
+		switch {
+		case err != nil:
+			log.Printf("vaultkms: error renewing token: %v", err)
+			wait = tokenRetryInterval
+		case !auth.Renewable || auth.LeaseDuration <= 0:
+			return
+		default:
+			wait = time.Duration(auth.LeaseDuration) * time.Second * 2 / 3
+		}
+	}
+}
+
+// do sends a request to Vault and decodes the data or auth object of the JSON
+// response in v.
+func (c *httpClient) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling request")
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.address+path, r)
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", c.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed", method, req.URL)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", req.URL)
+	}
+	if resp.StatusCode >= 400 {
+		return newError(resp.StatusCode, b)
+	}
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	var data struct {
+		Data json.RawMessage `json:"data"`
+		Auth json.RawMessage `json:"auth"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Wrapf(err, "error decoding %s", req.URL)
+	}
+	raw := data.Data
+	if len(data.Auth) > 0 && string(data.Auth) != "null" {
+		raw = data.Auth
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return errors.Errorf("error decoding %s: response does not contain data", req.URL)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errors.Wrapf(err, "error decoding %s", req.URL)
+	}
+	return nil
+}
+
+func keyPath(mount, endpoint, name string) string {
+	return "/v1/" + mount + "/" + endpoint + "/" + url.PathEscape(name)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Error is an error returned by Vault.
+type Error struct {
+	StatusCode int
+	Errors     []string
+}
+
+func newError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode}
+	// Vault errors are {"errors":["..."]}.
+	var v struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &v); err == nil {
+		e.Errors = v.Errors
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vaultkms: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("vaultkms: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.Join(e.Errors, ", "))
+}
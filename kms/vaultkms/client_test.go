@@ -0,0 +1,254 @@
+package vaultkms
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestHTTPClient(t *testing.T) {
+	var mutex sync.Mutex
+	var renewals int
+	renewed := make(chan struct{}, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/token/lookup-self", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Vault-Token") {
+		case "renewable":
+			fmt.Fprint(w, `{"data":{"ttl":1,"renewable":true}}`)
+		case "token":
+			fmt.Fprint(w, `{"data":{"ttl":0,"renewable":false}}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		}
+	})
+	mux.HandleFunc("/v1/auth/token/renew-self", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		renewals++
+		n := renewals
+		mutex.Unlock()
+		// The second renewal reaches the max TTL.
+		fmt.Fprintf(w, `{"auth":{"client_token":"renewable","lease_duration":1,"renewable":%v}}`, n < 2)
+		renewed <- struct{}{}
+	})
+	mux.HandleFunc("/v1/transit/keys/my-key", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Namespace") != "ns1" || r.Header.Get("X-Vault-Request") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, `{"data":{"name":"my-key","type":"ed25519","latest_version":1,"keys":{"1":{"public_key":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}}}`)
+		case http.MethodPost:
+			var params CreateKeyParameters
+			if json.NewDecoder(r.Body).Decode(&params) != nil || params.Type != "ed25519" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("/v1/transit/sign/my-key", func(w http.ResponseWriter, r *http.Request) {
+		var params SignParameters
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&params) != nil || params.KeyVersion != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"signature":"vault:v1:%s","key_version":1}}`, params.Input)
+	})
+	mux.HandleFunc("/v1/transit/verify/my-key", func(w http.ResponseWriter, r *http.Request) {
+		var params VerifyParameters
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&params) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"valid":%v}}`, params.Signature == "vault:v1:"+params.Input)
+	})
+	mux.HandleFunc("/v1/transit/decrypt/my-aes-key", func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&params) != nil || params["ciphertext"] != "vault:v1:AQID" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"plaintext":"cGFzc3dvcmQ="}}`)
+	})
+	mux.HandleFunc("/v1/transit/keys/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[]}`)
+	})
+	mux.HandleFunc("/v1/transit/keys/bad-json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{`)
+	})
+	mux.HandleFunc("/v1/transit/keys/no-data", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":null}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c, err := newHTTPClient(ctx, apiv1.Options{Address: srv.URL + "/", Token: "token", Namespace: "/ns1/"})
+	if err != nil {
+		t.Fatalf("newHTTPClient() error = %v", err)
+	}
+	defer c.Close()
+
+	info, err := c.ReadKey(ctx, "transit", "my-key")
+	if err != nil {
+		t.Fatalf("httpClient.ReadKey() error = %v", err)
+	}
+	if info.Name != "my-key" || info.Type != "ed25519" || info.LatestVersion != 1 {
+		t.Errorf("httpClient.ReadKey() = %v", info)
+	}
+	if err := c.CreateKey(ctx, "transit", "my-key", &CreateKeyParameters{Type: "ed25519"}); err != nil {
+		t.Errorf("httpClient.CreateKey() error = %v", err)
+	}
+	result, err := c.Sign(ctx, "transit", "my-key", &SignParameters{Input: "AQID", KeyVersion: 1})
+	if err != nil {
+		t.Fatalf("httpClient.Sign() error = %v", err)
+	}
+	if result.Signature != "vault:v1:AQID" || result.KeyVersion != 1 {
+		t.Errorf("httpClient.Sign() = %v", result)
+	}
+	if valid, err := c.Verify(ctx, "transit", "my-key", &VerifyParameters{Input: "AQID", Signature: "vault:v1:AQID"}); err != nil || !valid {
+		t.Errorf("httpClient.Verify() = %v, %v", valid, err)
+	}
+	if plaintext, err := c.Decrypt(ctx, "transit", "my-aes-key", "vault:v1:AQID"); err != nil || string(plaintext) != "password" {
+		t.Errorf("httpClient.Decrypt() = %s, %v", plaintext, err)
+	}
+
+	_, err = c.ReadKey(ctx, "transit", "missing")
+	if !isNotFound(err) {
+		t.Errorf("httpClient.ReadKey() error = %v, want not found", err)
+	}
+	if _, err = c.ReadKey(ctx, "transit", "bad-json"); err == nil {
+		t.Error("httpClient.ReadKey() error = nil, wantErr true")
+	}
+	if _, err = c.ReadKey(ctx, "transit", "no-data"); err == nil {
+		t.Error("httpClient.ReadKey() error = nil, wantErr true")
+	}
+
+	// Renewable tokens are renewed until they reach the max TTL.
+	r, err := newHTTPClient(ctx, apiv1.Options{Address: srv.URL, Token: "renewable"})
+	if err != nil {
+		t.Fatalf("newHTTPClient() error = %v", err)
+	}
+	defer r.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-renewed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("token was renewed %d times, want 2", i)
+		}
+	}
+	select {
+	case <-renewed:
+		t.Error("token was renewed after reaching the max TTL")
+	case <-time.After(1 * time.Second):
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("httpClient.Close() error = %v", err)
+	}
+
+	// Configuration errors.
+	if _, err := newHTTPClient(ctx, apiv1.Options{Address: srv.URL, Token: "bad-token"}); err == nil {
+		t.Error("newHTTPClient() error = nil, wantErr true")
+	}
+}
+
+func TestHTTPClient_environment(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "env-token" || r.Header.Get("X-Vault-Namespace") != "env-ns" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"ttl":0,"renewable":false}}`)
+	}))
+	defer srv.Close()
+
+	f, err := ioutil.TempFile("", "vault-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	env := map[string]string{
+		"VAULT_ADDR":      srv.URL,
+		"VAULT_TOKEN":     "env-token",
+		"VAULT_NAMESPACE": "env-ns",
+		"VAULT_CACERT":    f.Name(),
+	}
+	setenv := func(overrides map[string]string) {
+		for k, v := range env {
+			if o, ok := overrides[k]; ok {
+				v = o
+			}
+			os.Setenv(k, v)
+		}
+	}
+	defer func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		opts    apiv1.Options
+		wantErr bool
+	}{
+		{"ok", nil, apiv1.Options{}, false},
+		{"ok options", map[string]string{"VAULT_TOKEN": "", "VAULT_NAMESPACE": ""}, apiv1.Options{Token: "env-token", Namespace: "env-ns"}, false},
+		{"fail address", map[string]string{"VAULT_ADDR": ""}, apiv1.Options{}, true},
+		{"fail token", map[string]string{"VAULT_TOKEN": ""}, apiv1.Options{}, true},
+		{"fail cacert", map[string]string{"VAULT_CACERT": "testdata/missing.crt"}, apiv1.Options{}, true},
+		{"fail cacert pem", map[string]string{"VAULT_CACERT": os.DevNull}, apiv1.Options{}, true},
+		{"fail unknown authority", map[string]string{"VAULT_CACERT": ""}, apiv1.Options{}, true},
+		{"fail options", nil, apiv1.Options{Token: "other-token"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(tt.env)
+			c, err := newHTTPClient(context.Background(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if c != nil {
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{"errors", newError(403, []byte(`{"errors":["permission denied"]}`)), "vaultkms: 403 Forbidden: permission denied"},
+		{"multiple", newError(400, []byte(`{"errors":["foo","bar"]}`)), "vaultkms: 400 Bad Request: foo, bar"},
+		{"empty", newError(500, nil), "vaultkms: 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
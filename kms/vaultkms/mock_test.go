@@ -0,0 +1,205 @@
+package vaultkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+type MockClient struct {
+	readKey   func(ctx context.Context, mount, name string) (*KeyInfo, error)
+	createKey func(ctx context.Context, mount, name string, params *CreateKeyParameters) error
+	sign      func(ctx context.Context, mount, name string, params *SignParameters) (*SignResult, error)
+	verify    func(ctx context.Context, mount, name string, params *VerifyParameters) (bool, error)
+	decrypt   func(ctx context.Context, mount, name, ciphertext string) ([]byte, error)
+	close     func() error
+}
+
+func (m *MockClient) ReadKey(ctx context.Context, mount, name string) (*KeyInfo, error) {
+	return m.readKey(ctx, mount, name)
+}
+
+func (m *MockClient) CreateKey(ctx context.Context, mount, name string, params *CreateKeyParameters) error {
+	return m.createKey(ctx, mount, name, params)
+}
+
+func (m *MockClient) Sign(ctx context.Context, mount, name string, params *SignParameters) (*SignResult, error) {
+	return m.sign(ctx, mount, name, params)
+}
+
+func (m *MockClient) Verify(ctx context.Context, mount, name string, params *VerifyParameters) (bool, error) {
+	return m.verify(ctx, mount, name, params)
+}
+
+func (m *MockClient) Decrypt(ctx context.Context, mount, name, ciphertext string) ([]byte, error) {
+	return m.decrypt(ctx, mount, name, ciphertext)
+}
+
+func (m *MockClient) Close() error {
+	return m.close()
+}
+
+// newSoftClient returns a MockClient that implements the Transit operations
+// with software keys, every key has one version.
+func newSoftClient() *MockClient {
+	types := make(map[string]string)
+	keys := make(map[string]crypto.Signer)
+	hashes := map[string]crypto.Hash{
+		"sha2-256": crypto.SHA256,
+		"sha2-384": crypto.SHA384,
+		"sha2-512": crypto.SHA512,
+	}
+	sign := func(key crypto.Signer, params *VerifyParameters) ([]byte, error) {
+		input, err := base64.StdEncoding.DecodeString(params.Input)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(ed25519.PrivateKey); ok {
+			return key.Sign(rand.Reader, input, crypto.Hash(0))
+		}
+		h, ok := hashes[params.HashAlgorithm]
+		if !ok || !params.Prehashed {
+			return nil, errors.New("unsupported hash algorithm")
+		}
+		if params.SignatureAlgorithm == "pss" {
+			opts := &rsa.PSSOptions{Hash: h, SaltLength: rsa.PSSSaltLengthAuto}
+			if params.SaltLength == "hash" {
+				opts.SaltLength = rsa.PSSSaltLengthEqualsHash
+			}
+			return key.Sign(rand.Reader, input, opts)
+		}
+		return key.Sign(rand.Reader, input, h)
+	}
+	key := func(mount, name string) (crypto.Signer, error) {
+		if k, ok := keys[mount+"/"+name]; ok {
+			return k, nil
+		}
+		return nil, &Error{StatusCode: http.StatusNotFound}
+	}
+
+	return &MockClient{
+		readKey: func(ctx context.Context, mount, name string) (*KeyInfo, error) {
+			k, err := key(mount, name)
+			if err != nil {
+				return nil, err
+			}
+			var pub string
+			if p, ok := k.Public().(ed25519.PublicKey); ok {
+				pub = base64.StdEncoding.EncodeToString(p)
+			} else {
+				b, err := x509.MarshalPKIXPublicKey(k.Public())
+				if err != nil {
+					return nil, err
+				}
+				pub = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+			}
+			v, err := json.Marshal(KeyVersion{PublicKey: pub})
+			if err != nil {
+				return nil, err
+			}
+			return &KeyInfo{
+				Name:          name,
+				Type:          types[mount+"/"+name],
+				LatestVersion: 1,
+				Keys:          map[string]json.RawMessage{"1": v},
+			}, nil
+		},
+		createKey: func(ctx context.Context, mount, name string, params *CreateKeyParameters) error {
+			var k crypto.Signer
+			var err error
+			switch params.Type {
+			case "ecdsa-p256":
+				k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			case "ecdsa-p384":
+				k, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+			case "ecdsa-p521":
+				k, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+			case "ed25519":
+				_, k, err = ed25519.GenerateKey(rand.Reader)
+			case "rsa-2048", "rsa-3072", "rsa-4096":
+				// Use small keys to speed up the tests.
+				k, err = rsa.GenerateKey(rand.Reader, 1024)
+			default:
+				return &Error{StatusCode: http.StatusBadRequest, Errors: []string{"unknown key type " + params.Type}}
+			}
+			if err != nil {
+				return err
+			}
+			types[mount+"/"+name] = params.Type
+			keys[mount+"/"+name] = k
+			return nil
+		},
+		sign: func(ctx context.Context, mount, name string, params *SignParameters) (*SignResult, error) {
+			k, err := key(mount, name)
+			if err != nil {
+				return nil, err
+			}
+			if params.KeyVersion != 0 && params.KeyVersion != 1 {
+				return nil, &Error{StatusCode: http.StatusBadRequest, Errors: []string{"invalid key version"}}
+			}
+			sig, err := sign(k, &VerifyParameters{
+				Input:              params.Input,
+				HashAlgorithm:      params.HashAlgorithm,
+				Prehashed:          params.Prehashed,
+				SignatureAlgorithm: params.SignatureAlgorithm,
+				SaltLength:         params.SaltLength,
+			})
+			if err != nil {
+				return nil, &Error{StatusCode: http.StatusBadRequest, Errors: []string{err.Error()}}
+			}
+			return &SignResult{
+				Signature:  "vault:v1:" + base64.StdEncoding.EncodeToString(sig),
+				KeyVersion: 1,
+			}, nil
+		},
+		verify: func(ctx context.Context, mount, name string, params *VerifyParameters) (bool, error) {
+			k, err := key(mount, name)
+			if err != nil {
+				return false, err
+			}
+			version, sig, err := decodeSignature(params.Signature)
+			if err != nil || version != 1 {
+				return false, &Error{StatusCode: http.StatusBadRequest, Errors: []string{"invalid signature"}}
+			}
+			input, err := base64.StdEncoding.DecodeString(params.Input)
+			if err != nil {
+				return false, err
+			}
+			switch pub := k.Public().(type) {
+			case ed25519.PublicKey:
+				return ed25519.Verify(pub, input, sig), nil
+			case *ecdsa.PublicKey:
+				var esig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+					return false, nil
+				}
+				return ecdsa.Verify(pub, input, esig.R, esig.S), nil
+			case *rsa.PublicKey:
+				h := hashes[params.HashAlgorithm]
+				if params.SignatureAlgorithm == "pss" {
+					return rsa.VerifyPSS(pub, h, input, sig, nil) == nil, nil
+				}
+				return rsa.VerifyPKCS1v15(pub, h, input, sig) == nil, nil
+			default:
+				return false, fmt.Errorf("unsupported key %T", pub)
+			}
+		},
+		close: func() error {
+			return nil
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package vaultkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using a key in the Transit secrets engine.
+// The signer always uses the same version of the key.
+type Signer struct {
+	client    TransitClient
+	mount     string
+	name      string
+	version   int
+	publicKey crypto.PublicKey
+}
+
+// NewSigner creates a new signer using the given version of a key.
+func NewSigner(client TransitClient, mount, name string, version int, pub crypto.PublicKey) *Signer {
+	return &Signer{
+		client:    client,
+		mount:     mount,
+		name:      name,
+		version:   version,
+		publicKey: pub,
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the key stored in Vault. With Ed25519 keys, digest is
+// the message to sign.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	p, err := signatureParameters(s.publicKey, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := s.client.Sign(ctx, s.mount, s.name, &SignParameters{
+		Input:               p.Input,
+		KeyVersion:          s.version,
+		HashAlgorithm:       p.HashAlgorithm,
+		Prehashed:           p.Prehashed,
+		SignatureAlgorithm:  p.SignatureAlgorithm,
+		SaltLength:          p.SaltLength,
+		MarshalingAlgorithm: p.MarshalingAlgorithm,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "vaultkms Sign failed")
+	}
+	version, sig, err := decodeSignature(resp.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if version != s.version {
+		return nil, errors.Errorf("vaultkms signed with version %d, want %d", version, s.version)
+	}
+	return sig, nil
+}
+
+// Verify verifies the signature of digest using the verify endpoint of the
+// Transit secrets engine. It can be used to check that Vault considers valid a
+// signature created with this signer.
+func (s *Signer) Verify(digest, signature []byte, opts crypto.SignerOpts) error {
+	p, err := signatureParameters(s.publicKey, digest, opts)
+	if err != nil {
+		return err
+	}
+	p.Signature = "vault:v" + strconv.Itoa(s.version) + ":" + base64.StdEncoding.EncodeToString(signature)
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	valid, err := s.client.Verify(ctx, s.mount, s.name, p)
+	if err != nil {
+		return errors.Wrap(err, "vaultkms Verify failed")
+	}
+	if !valid {
+		return errors.New("vaultkms signature is not valid")
+	}
+	return nil
+}
+
+// signatureParameters returns the common sign and verify parameters for the
+// given key and options. RSA-PSS signatures with a salt length equal to the
+// hash length require Vault 1.11 or newer.
+func signatureParameters(pub crypto.PublicKey, digest []byte, opts crypto.SignerOpts) (*VerifyParameters, error) {
+	if _, ok := pub.(ed25519.PublicKey); ok {
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, errors.New("ed25519 keys do not support pre-hashed messages")
+		}
+		return &VerifyParameters{
+			Input: base64.StdEncoding.EncodeToString(digest),
+		}, nil
+	}
+
+	var hashAlgorithm string
+	switch h := opts.HashFunc(); h {
+	case crypto.SHA256:
+		hashAlgorithm = "sha2-256"
+	case crypto.SHA384:
+		hashAlgorithm = "sha2-384"
+	case crypto.SHA512:
+		hashAlgorithm = "sha2-512"
+	default:
+		return nil, errors.Errorf("unsupported hash function %v", h)
+	}
+	if len(digest) != opts.HashFunc().Size() {
+		return nil, errors.New("digest length does not match hash function")
+	}
+	p := &VerifyParameters{
+		Input:         base64.StdEncoding.EncodeToString(digest),
+		HashAlgorithm: hashAlgorithm,
+		Prehashed:     true,
+	}
+
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		p.MarshalingAlgorithm = "asn1"
+	case *rsa.PublicKey:
+		p.SignatureAlgorithm = "pkcs1v15"
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			p.SignatureAlgorithm = "pss"
+			switch pss.SaltLength {
+			case rsa.PSSSaltLengthAuto:
+				p.SaltLength = "auto"
+			case rsa.PSSSaltLengthEqualsHash, opts.HashFunc().Size():
+				p.SaltLength = "hash"
+			default:
+				return nil, errors.Errorf("unsupported salt length %d", pss.SaltLength)
+			}
+		}
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", pub)
+	}
+	return p, nil
+}
+
+// decodeSignature returns the key version and the signature in a Vault
+// signature with the format vault:v<version>:<base64-signature>.
+func decodeSignature(s string) (int, []byte, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, nil, errors.New("vaultkms returned an invalid signature")
+	}
+	version, err := strconv.Atoi(parts[1][1:])
+	if err != nil {
+		return 0, nil, errors.New("vaultkms returned an invalid signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) == 0 {
+		return 0, nil, errors.New("vaultkms returned an invalid signature")
+	}
+	return version, sig, nil
+}
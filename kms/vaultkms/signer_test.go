@@ -0,0 +1,171 @@
+package vaultkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	client := newSoftClient()
+	k := NewVaultKMS(client)
+	signer := func(name string, alg apiv1.SignatureAlgorithm) *Signer {
+		resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: name, SignatureAlgorithm: alg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := k.CreateSigner(&resp.CreateSignerRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.(*Signer)
+	}
+	ecSigner := signer("vaultkms:name=ec", apiv1.ECDSAWithSHA256)
+	rsaSigner := signer("vaultkms:name=rsa", apiv1.SHA256WithRSA)
+	edSigner := signer("vaultkms:name=ed", apiv1.PureEd25519)
+
+	sum256 := sha256.Sum256([]byte("data"))
+	sum384 := sha512.Sum384([]byte("data"))
+	sum512 := sha512.Sum512([]byte("data"))
+	tests := []struct {
+		name    string
+		signer  *Signer
+		digest  []byte
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok ecdsa", ecSigner, sum256[:], crypto.SHA256, false},
+		{"ok ecdsa SHA512", ecSigner, sum512[:], crypto.SHA512, false},
+		{"ok rsa", rsaSigner, sum384[:], crypto.SHA384, false},
+		{"ok rsa-pss", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, false},
+		{"ok rsa-pss auto", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256}, false},
+		{"ok ed25519", edSigner, []byte("data"), crypto.Hash(0), false},
+		{"fail hash", ecSigner, sum256[:], crypto.SHA1, true},
+		{"fail digest", ecSigner, sum384[:], crypto.SHA256, true},
+		{"fail salt", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, true},
+		{"fail ed25519 hash", edSigner, sum256[:], crypto.SHA256, true},
+		{"fail key type", NewSigner(client, "transit", "ec", 1, []byte("foo")), sum256[:], crypto.SHA256, true},
+		{"fail version", NewSigner(client, "transit", "ec", 2, ecSigner.Public()), sum256[:], crypto.SHA256, true},
+		{"fail missing", NewSigner(client, "transit", "missing", 1, ecSigner.Public()), sum256[:], crypto.SHA256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			switch pub := tt.signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				var esig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub, tt.digest, esig.R, esig.S) {
+					t.Error("Signer.Sign() signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if opts, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, opts.Hash, tt.digest, sig, opts)
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, tt.opts.HashFunc(), tt.digest, sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() signature is not valid: %v", err)
+				}
+			case ed25519.PublicKey:
+				if !ed25519.Verify(pub, tt.digest, sig) {
+					t.Error("Signer.Sign() signature is not valid")
+				}
+			}
+			if err := tt.signer.Verify(tt.digest, sig, tt.opts); err != nil {
+				t.Errorf("Signer.Verify() error = %v", err)
+			}
+		})
+	}
+
+	// The signature returned by Vault must be valid.
+	client.sign = func(ctx context.Context, mount, name string, params *SignParameters) (*SignResult, error) {
+		return &SignResult{Signature: "vault:v1:", KeyVersion: 1}, nil
+	}
+	if _, err := ecSigner.Sign(rand.Reader, sum256[:], crypto.SHA256); err == nil {
+		t.Error("Signer.Sign() error = nil, wantErr true")
+	}
+}
+
+func TestSigner_Verify(t *testing.T) {
+	k := NewVaultKMS(newSoftClient())
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "vaultkms:name=ec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := k.CreateSigner(&resp.CreateSignerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := s.(*Signer)
+	sum := sha256.Sum256([]byte("data"))
+	sig, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		digest  []byte
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok", signer, sum[:], crypto.SHA256, false},
+		{"fail invalid", signer, other[:], crypto.SHA256, true},
+		{"fail hash", signer, sum[:], crypto.SHA1, true},
+		{"fail version", NewSigner(k.client, "transit", "ec", 2, signer.Public()), sum[:], crypto.SHA256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signer.Verify(tt.digest, sig, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("Signer.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_decodeSignature(t *testing.T) {
+	tests := []struct {
+		name        string
+		sig         string
+		wantVersion int
+		wantErr     bool
+	}{
+		{"ok", "vault:v1:AQID", 1, false},
+		{"ok version", "vault:v12:AQID", 12, false},
+		{"fail prefix", "foo:v1:AQID", 0, true},
+		{"fail parts", "vault:v1", 0, true},
+		{"fail version", "vault:1:AQID", 0, true},
+		{"fail version number", "vault:vx:AQID", 0, true},
+		{"fail base64", "vault:v1:%%%", 0, true},
+		{"fail empty", "vault:v1:", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, sig, err := decodeSignature(tt.sig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if version != tt.wantVersion || (!tt.wantErr && len(sig) != 3) {
+				t.Errorf("decodeSignature() = %d, %x", version, sig)
+			}
+		})
+	}
+}
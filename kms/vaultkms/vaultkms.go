@@ -0,0 +1,384 @@
+package vaultkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// uriScheme is the scheme used in the key names, e.g.
+// vaultkms:name=my-key;mount=transit;version=1.
+const uriScheme = "vaultkms:"
+
+// DefaultMount is the default path where the Transit secrets engine is
+// mounted.
+const DefaultMount = "transit"
+
+// DefaultRSAKeySize is the default size for RSA keys.
+const DefaultRSAKeySize = 3072
+
+// signatureAlgorithmMapping maps the step signature algorithms with the
+// Transit key types; the RSA key types depend on the number of bits.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]string{
+	apiv1.UnspecifiedSignAlgorithm: "ecdsa-p256",
+	apiv1.SHA256WithRSA:            "rsa",
+	apiv1.SHA384WithRSA:            "rsa",
+	apiv1.SHA512WithRSA:            "rsa",
+	apiv1.SHA256WithRSAPSS:         "rsa",
+	apiv1.SHA384WithRSAPSS:         "rsa",
+	apiv1.SHA512WithRSAPSS:         "rsa",
+	apiv1.ECDSAWithSHA256:          "ecdsa-p256",
+	apiv1.ECDSAWithSHA384:          "ecdsa-p384",
+	apiv1.ECDSAWithSHA512:          "ecdsa-p521",
+	apiv1.PureEd25519:              "ed25519",
+}
+
+// KeyInfo is the key returned by the Transit secrets engine. Keys contains the
+// public key of each version of the key.
+type KeyInfo struct {
+	Name          string                     `json:"name"`
+	Type          string                     `json:"type"`
+	LatestVersion int                        `json:"latest_version"`
+	Keys          map[string]json.RawMessage `json:"keys"`
+}
+
+// KeyVersion is a version of an asymmetric key. The public key is PEM encoded
+// for ECDSA and RSA keys, and base64 encoded for Ed25519 keys.
+type KeyVersion struct {
+	PublicKey string `json:"public_key"`
+}
+
+// CreateKeyParameters are the parameters used to create a key.
+type CreateKeyParameters struct {
+	Type string `json:"type"`
+}
+
+// SignParameters are the parameters used to sign an input, the input is base64
+// encoded. Input is a digest unless the key is an Ed25519 key.
+type SignParameters struct {
+	Input               string `json:"input"`
+	KeyVersion          int    `json:"key_version,omitempty"`
+	HashAlgorithm       string `json:"hash_algorithm,omitempty"`
+	Prehashed           bool   `json:"prehashed,omitempty"`
+	SignatureAlgorithm  string `json:"signature_algorithm,omitempty"`
+	SaltLength          string `json:"salt_length,omitempty"`
+	MarshalingAlgorithm string `json:"marshaling_algorithm,omitempty"`
+}
+
+// SignResult is the result of a sign operation, the signature has the format
+// vault:v<version>:<base64-signature>.
+type SignResult struct {
+	Signature  string `json:"signature"`
+	KeyVersion int    `json:"key_version"`
+}
+
+// VerifyParameters are the parameters used to verify a signature with the
+// Transit secrets engine.
+type VerifyParameters struct {
+	Input               string `json:"input"`
+	Signature           string `json:"signature"`
+	HashAlgorithm       string `json:"hash_algorithm,omitempty"`
+	Prehashed           bool   `json:"prehashed,omitempty"`
+	SignatureAlgorithm  string `json:"signature_algorithm,omitempty"`
+	SaltLength          string `json:"salt_length,omitempty"`
+	MarshalingAlgorithm string `json:"marshaling_algorithm,omitempty"`
+}
+
+// TransitClient defines the Transit operations that this package will use.
+// The mount is the path of the secrets engine, e.g. transit. This interface
+// will be used for unit testing.
+type TransitClient interface {
+	ReadKey(ctx context.Context, mount, name string) (*KeyInfo, error)
+	CreateKey(ctx context.Context, mount, name string, params *CreateKeyParameters) error
+	Sign(ctx context.Context, mount, name string, params *SignParameters) (*SignResult, error)
+	Verify(ctx context.Context, mount, name string, params *VerifyParameters) (bool, error)
+	Decrypt(ctx context.Context, mount, name, ciphertext string) ([]byte, error)
+	Close() error
+}
+
+// VaultKMS implements a KMS using the Transit secrets engine of HashiCorp
+// Vault.
+type VaultKMS struct {
+	client TransitClient
+}
+
+// New creates a new VaultKMS using the Vault address, token and namespace in
+// the options, or the environment variables VAULT_ADDR, VAULT_TOKEN and
+// VAULT_NAMESPACE. If VAULT_CACERT is set, it is used to verify the Vault
+// server. Renewable tokens are renewed in the background until the KMS is
+// closed.
+func New(ctx context.Context, opts apiv1.Options) (*VaultKMS, error) {
+	client, err := newHTTPClient(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &VaultKMS{
+		client: client,
+	}, nil
+}
+
+// NewVaultKMS creates a new VaultKMS with a given client.
+func NewVaultKMS(client TransitClient) *VaultKMS {
+	return &VaultKMS{
+		client: client,
+	}
+}
+
+// Close stops the renewal of the token.
+func (k *VaultKMS) Close() error {
+	if err := k.client.Close(); err != nil {
+		return errors.Wrap(err, "vaultkms Close failed")
+	}
+	return nil
+}
+
+// GetPublicKey returns the public key of the key with the given name. If the
+// name does not contain a version, the latest version is used.
+func (k *VaultKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	id, err := parseName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, err := k.getKey(id)
+	return pub, err
+}
+
+// CreateKey creates a new key in the Transit secrets engine. The key cannot be
+// exported, and the response name pins the first version of the key.
+func (k *VaultKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	kty, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("vaultkms does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	if kty == "rsa" {
+		switch req.Bits {
+		case 0:
+			kty = "rsa-" + strconv.Itoa(DefaultRSAKeySize)
+		case 2048, 3072, 4096:
+			kty = "rsa-" + strconv.Itoa(req.Bits)
+		default:
+			return nil, errors.Errorf("vaultkms does not support RSA keys of %d bits", req.Bits)
+		}
+	}
+	id, err := parseName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if id.version != 0 {
+		return nil, errors.Errorf("createKeyRequest 'name' cannot contain a version")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	// Creating an existing key is a noop in Vault, so make sure that the key
+	// returned is a new one.
+	switch _, err := k.client.ReadKey(ctx, id.mount, id.name); {
+	case err == nil:
+		return nil, errors.Errorf("vaultkms key %s already exists", id.name)
+	case !isNotFound(err):
+		return nil, errors.Wrap(err, "vaultkms ReadKey failed")
+	}
+	if err := k.client.CreateKey(ctx, id.mount, id.name, &CreateKeyParameters{Type: kty}); err != nil {
+		return nil, errors.Wrap(err, "vaultkms CreateKey failed")
+	}
+	pub, version, err := k.getKey(id)
+	if err != nil {
+		return nil, err
+	}
+	id.version = version
+	name := id.String()
+
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a new signer using the key with the given name. If the
+// name does not contain a version, the signer is pinned to the latest version
+// of the key, so a rotation in Vault does not change the key used by a running
+// CA.
+func (k *VaultKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	id, err := parseName(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, version, err := k.getKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(k.client, id.mount, id.name, version, pub), nil
+}
+
+// Decrypt decrypts a ciphertext created with the encrypt endpoint of the
+// Transit secrets engine, the ciphertext has the format
+// vault:v<version>:<base64-ciphertext>.
+func (k *VaultKMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	}
+	id, err := parseName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := strings.TrimSpace(string(req.Ciphertext))
+	if !strings.HasPrefix(ciphertext, "vault:v") {
+		return nil, errors.New("decryptRequest 'ciphertext' is not a vault ciphertext")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	plaintext, err := k.client.Decrypt(ctx, id.mount, id.name, ciphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, "vaultkms Decrypt failed")
+	}
+	return plaintext, nil
+}
+
+// getKey returns the public key and the version of the given key.
+func (k *VaultKMS) getKey(id *keyID) (crypto.PublicKey, int, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	info, err := k.client.ReadKey(ctx, id.mount, id.name)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "vaultkms ReadKey failed")
+	}
+	version := id.version
+	if version == 0 {
+		version = info.LatestVersion
+	}
+	pub, err := parsePublicKey(info, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pub, version, nil
+}
+
+// keyID identifies a key version in a Transit secrets engine.
+type keyID struct {
+	mount   string
+	name    string
+	version int
+}
+
+// String returns the name of the key in the vaultkms URI format.
+func (id *keyID) String() string {
+	s := uriScheme + "name=" + url.PathEscape(id.name)
+	if id.mount != DefaultMount {
+		s += ";mount=" + url.PathEscape(id.mount)
+	}
+	if id.version != 0 {
+		s += ";version=" + strconv.Itoa(id.version)
+	}
+	return s
+}
+
+// parseName parses a key name like vaultkms:name=my-key;mount=transit;version=1.
+// The mount defaults to transit and the version is optional.
+func parseName(name string) (*keyID, error) {
+	if !strings.HasPrefix(name, uriScheme) {
+		return nil, errors.Errorf("error parsing %s: name must start with %s", name, uriScheme)
+	}
+	id := &keyID{
+		mount: DefaultMount,
+	}
+	for _, attr := range strings.Split(strings.TrimPrefix(name, uriScheme), ";") {
+		if attr == "" {
+			continue
+		}
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("error parsing %s: invalid attribute %s", name, attr)
+		}
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", name)
+		}
+		switch parts[0] {
+		case "name":
+			id.name = value
+		case "mount":
+			id.mount = strings.Trim(value, "/")
+		case "version":
+			if id.version, err = strconv.Atoi(value); err != nil || id.version <= 0 {
+				return nil, errors.Errorf("error parsing %s: invalid version %s", name, value)
+			}
+		default:
+			return nil, errors.Errorf("error parsing %s: unsupported attribute %s", name, parts[0])
+		}
+	}
+	if id.name == "" || id.mount == "" {
+		return nil, errors.Errorf("error parsing %s: name and mount cannot be empty", name)
+	}
+	return id, nil
+}
+
+// parsePublicKey returns the public key of the given version of a Transit key.
+func parsePublicKey(info *KeyInfo, version int) (crypto.PublicKey, error) {
+	raw, ok := info.Keys[strconv.Itoa(version)]
+	if !ok {
+		return nil, errors.Errorf("vaultkms key %s does not have version %d", info.Name, version)
+	}
+	var v KeyVersion
+	if err := json.Unmarshal(raw, &v); err != nil || v.PublicKey == "" {
+		return nil, errors.Errorf("vaultkms key %s of type %s is not an asymmetric key", info.Name, info.Type)
+	}
+
+	switch {
+	case info.Type == "ed25519":
+		b, err := base64.StdEncoding.DecodeString(v.PublicKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("error parsing vaultkms key %s: invalid public key", info.Name)
+		}
+		return ed25519.PublicKey(b), nil
+	case strings.HasPrefix(info.Type, "ecdsa-"), strings.HasPrefix(info.Type, "rsa-"):
+		block, _ := pem.Decode([]byte(v.PublicKey))
+		if block == nil {
+			return nil, errors.Errorf("error parsing vaultkms key %s: invalid PEM", info.Name)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing vaultkms key %s", info.Name)
+		}
+		return pub, nil
+	default:
+		return nil, errors.Errorf("vaultkms key %s has an unsupported type %s", info.Name, info.Type)
+	}
+}
+
+// isNotFound returns true if the error is a Vault 404 error.
+func isNotFound(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
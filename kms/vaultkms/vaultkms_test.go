@@ -0,0 +1,272 @@
+package vaultkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestVaultKMS_Close(t *testing.T) {
+	closed := false
+	k := NewVaultKMS(&MockClient{close: func() error {
+		closed = true
+		return nil
+	}})
+	if err := k.Close(); err != nil || !closed {
+		t.Errorf("VaultKMS.Close() error = %v, closed = %v", err, closed)
+	}
+	k = NewVaultKMS(&MockClient{close: func() error {
+		return errors.New("an error")
+	}})
+	if err := k.Close(); err == nil {
+		t.Error("VaultKMS.Close() error = nil, wantErr true")
+	}
+}
+
+func TestVaultKMS_GetPublicKey(t *testing.T) {
+	client := newSoftClient()
+	k := NewVaultKMS(client)
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readKey := client.readKey
+	client.readKey = func(ctx context.Context, mount, name string) (*KeyInfo, error) {
+		if name == "aes" {
+			return &KeyInfo{Name: "aes", Type: "aes256-gcm96", LatestVersion: 1, Keys: map[string]json.RawMessage{"1": json.RawMessage("1600000000")}}, nil
+		}
+		return readKey(ctx, mount, name)
+	}
+
+	tests := []struct {
+		name    string
+		keyName string
+		wantErr bool
+	}{
+		{"ok", "vaultkms:name=my-key", false},
+		{"ok version", "vaultkms:name=my-key;version=1", false},
+		{"ok mount", "vaultkms:mount=transit/;name=my-key", false},
+		{"fail name", "", true},
+		{"fail parse", "my-key", true},
+		{"fail version", "vaultkms:name=my-key;version=2", true},
+		{"fail mount", "vaultkms:mount=other;name=my-key", true},
+		{"fail symmetric", "vaultkms:name=aes", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VaultKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, resp.PublicKey) {
+				t.Errorf("VaultKMS.GetPublicKey() = %v, want %v", got, resp.PublicKey)
+			}
+		})
+	}
+}
+
+func TestVaultKMS_CreateKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *apiv1.CreateKeyRequest
+		wantType string
+		wantName string
+		wantErr  bool
+	}{
+		{"ok default", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key"}, "ecdsa-p256", "vaultkms:name=my-key;version=1", false},
+		{"ok P-384", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, "ecdsa-p384", "vaultkms:name=my-key;version=1", false},
+		{"ok P-521", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key", SignatureAlgorithm: apiv1.ECDSAWithSHA512}, "ecdsa-p521", "vaultkms:name=my-key;version=1", false},
+		{"ok Ed25519", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key;mount=ca/transit", SignatureAlgorithm: apiv1.PureEd25519}, "ed25519", "vaultkms:name=my-key;mount=ca%2Ftransit;version=1", false},
+		{"ok RSA", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key", SignatureAlgorithm: apiv1.SHA256WithRSA}, "rsa-3072", "vaultkms:name=my-key;version=1", false},
+		{"ok RSA 4096", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 4096}, "rsa-4096", "vaultkms:name=my-key;version=1", false},
+		{"fail name", &apiv1.CreateKeyRequest{}, "", "", true},
+		{"fail algorithm", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key", SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}, "", "", true},
+		{"fail bits", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, "", "", true},
+		{"fail parse", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key;foo=bar"}, "", "", true},
+		{"fail version", &apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key;version=1"}, "", "", true},
+		{"fail exists", &apiv1.CreateKeyRequest{Name: "vaultkms:name=existing"}, "", "", true},
+		{"fail read", &apiv1.CreateKeyRequest{Name: "vaultkms:name=forbidden"}, "", "", true},
+		{"fail create", &apiv1.CreateKeyRequest{Name: "vaultkms:name=read-only"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newSoftClient()
+			if err := client.CreateKey(context.Background(), "transit", "existing", &CreateKeyParameters{Type: "ed25519"}); err != nil {
+				t.Fatal(err)
+			}
+			var gotType string
+			readKey, createKey := client.readKey, client.createKey
+			client.readKey = func(ctx context.Context, mount, name string) (*KeyInfo, error) {
+				if name == "forbidden" {
+					return nil, &Error{StatusCode: http.StatusForbidden, Errors: []string{"permission denied"}}
+				}
+				return readKey(ctx, mount, name)
+			}
+			client.createKey = func(ctx context.Context, mount, name string, params *CreateKeyParameters) error {
+				if name == "read-only" {
+					return &Error{StatusCode: http.StatusForbidden, Errors: []string{"permission denied"}}
+				}
+				gotType = params.Type
+				return createKey(ctx, mount, name, params)
+			}
+
+			got, err := NewVaultKMS(client).CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VaultKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotType != tt.wantType {
+				t.Errorf("VaultKMS.CreateKey() type = %s, want %s", gotType, tt.wantType)
+			}
+			if got.Name != tt.wantName || got.CreateSignerRequest.SigningKey != tt.wantName {
+				t.Errorf("VaultKMS.CreateKey() name = %s, want %s", got.Name, tt.wantName)
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+			default:
+				t.Errorf("VaultKMS.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+}
+
+func TestVaultKMS_CreateSigner(t *testing.T) {
+	k := NewVaultKMS(newSoftClient())
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "vaultkms:name=my-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateSignerRequest
+		want    crypto.Signer
+		wantErr bool
+	}{
+		{"ok", &resp.CreateSignerRequest, &Signer{client: k.client, mount: "transit", name: "my-key", version: 1, publicKey: resp.PublicKey}, false},
+		{"ok latest", &apiv1.CreateSignerRequest{SigningKey: "vaultkms:name=my-key"}, &Signer{client: k.client, mount: "transit", name: "my-key", version: 1, publicKey: resp.PublicKey}, false},
+		{"fail empty", &apiv1.CreateSignerRequest{}, nil, true},
+		{"fail parse", &apiv1.CreateSignerRequest{SigningKey: "vaultkms:my-key"}, nil, true},
+		{"fail missing", &apiv1.CreateSignerRequest{SigningKey: "vaultkms:name=missing"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VaultKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VaultKMS.CreateSigner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultKMS_Decrypt(t *testing.T) {
+	k := NewVaultKMS(&MockClient{
+		decrypt: func(ctx context.Context, mount, name, ciphertext string) ([]byte, error) {
+			if mount != "transit" || name != "my-aes-key" || ciphertext != "vault:v1:AQID" {
+				return nil, errors.New("an error")
+			}
+			return []byte("password"), nil
+		},
+	})
+
+	tests := []struct {
+		name    string
+		req     *apiv1.DecryptRequest
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", &apiv1.DecryptRequest{Name: "vaultkms:name=my-aes-key", Ciphertext: []byte("vault:v1:AQID\n")}, []byte("password"), false},
+		{"fail empty", &apiv1.DecryptRequest{Ciphertext: []byte("vault:v1:AQID")}, nil, true},
+		{"fail parse", &apiv1.DecryptRequest{Name: "vaultkms:my-aes-key", Ciphertext: []byte("vault:v1:AQID")}, nil, true},
+		{"fail ciphertext", &apiv1.DecryptRequest{Name: "vaultkms:name=my-aes-key", Ciphertext: []byte("AQID")}, nil, true},
+		{"fail decrypt", &apiv1.DecryptRequest{Name: "vaultkms:name=other", Ciphertext: []byte("vault:v1:AQID")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.Decrypt(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VaultKMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VaultKMS.Decrypt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    *keyID
+		wantErr bool
+	}{
+		{"vaultkms:name=my-key", &keyID{mount: "transit", name: "my-key"}, false},
+		{"vaultkms:name=my-key;mount=/ca/transit/;version=3", &keyID{mount: "ca/transit", name: "my-key", version: 3}, false},
+		{"vaultkms:name=my%2Fkey;", &keyID{mount: "transit", name: "my/key"}, false},
+		{"name=my-key", nil, true},
+		{"vaultkms:", nil, true},
+		{"vaultkms:name", nil, true},
+		{"vaultkms:name=%zz", nil, true},
+		{"vaultkms:name=my-key;mount=", nil, true},
+		{"vaultkms:name=my-key;version=0", nil, true},
+		{"vaultkms:name=my-key;version=foo", nil, true},
+		{"vaultkms:name=my-key;vault=foo", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseName(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parsePublicKey(t *testing.T) {
+	raw := func(s string) map[string]json.RawMessage {
+		return map[string]json.RawMessage{"1": json.RawMessage(s)}
+	}
+	tests := []struct {
+		name    string
+		info    *KeyInfo
+		version int
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok ed25519", &KeyInfo{Type: "ed25519", Keys: raw(`{"public_key":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}`)}, 1, ed25519.PublicKey(make([]byte, 32)), false},
+		{"fail version", &KeyInfo{Type: "ed25519", Keys: raw(`{"public_key":"AA=="}`)}, 2, nil, true},
+		{"fail ed25519", &KeyInfo{Type: "ed25519", Keys: raw(`{"public_key":"AA=="}`)}, 1, nil, true},
+		{"fail pem", &KeyInfo{Type: "ecdsa-p256", Keys: raw(`{"public_key":"foo"}`)}, 1, nil, true},
+		{"fail pkix", &KeyInfo{Type: "rsa-2048", Keys: raw(`{"public_key":"-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"}`)}, 1, nil, true},
+		{"fail type", &KeyInfo{Type: "hmac", Keys: raw(`{"public_key":"AA=="}`)}, 1, nil, true},
+		{"fail symmetric", &KeyInfo{Type: "aes256-gcm96", Keys: raw(`1600000000`)}, 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePublicKey(tt.info, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
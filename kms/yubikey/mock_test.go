@@ -0,0 +1,411 @@
+package yubikey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// mockSlot is a key stored in the MockCard.
+type mockSlot struct {
+	key         crypto.Signer
+	algorithm   byte
+	pinPolicy   byte
+	touchPolicy byte
+}
+
+// MockCard emulates the PIV application of a YubiKey using software keys.
+type MockCard struct {
+	pin              string
+	managementKey    []byte
+	managementKeyAlg byte
+	noMetadata       bool
+	slots            map[byte]*mockSlot
+	objects          map[string][]byte
+	attestationKey   crypto.Signer
+	// failures forces the status word returned by an instruction.
+	failures    map[byte]uint16
+	transmitErr error
+	beginErr    error
+
+	inTransaction bool
+	pinVerified   bool
+	authenticated bool
+	witness       []byte
+	chained       []byte
+	pending       []byte
+	closed        bool
+}
+
+func newMockCard() *MockCard {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Yubico PIV Attestation"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	return &MockCard{
+		pin:              "123456",
+		managementKey:    defaultManagementKey,
+		managementKeyAlg: algTripleDES,
+		slots:            make(map[byte]*mockSlot),
+		objects: map[string][]byte{
+			string(attestationObject): append(tlv(0x70, der), tlv(0x71, []byte{0})...),
+		},
+		attestationKey: key,
+		failures:       make(map[byte]uint16),
+	}
+}
+
+// addKey adds a software key to a slot.
+func (c *MockCard) addKey(slot, alg, pinPolicy, touchPolicy byte) crypto.Signer {
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case algECCP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algECCP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case algRSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		panic(err)
+	}
+	c.slots[slot] = &mockSlot{key: key, algorithm: alg, pinPolicy: pinPolicy, touchPolicy: touchPolicy}
+	return key
+}
+
+// addCertificate stores a self-signed certificate of the key in the slot.
+func (c *MockCard) addCertificate(slot byte) *x509.Certificate {
+	key := c.slots[slot].key
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	c.objects[string(slotObjects[slot])] = append(tlv(0x70, der), tlv(0x71, []byte{0})...)
+	return crt
+}
+
+func (c *MockCard) Begin() error {
+	if c.beginErr != nil {
+		return c.beginErr
+	}
+	c.inTransaction = true
+	return nil
+}
+
+func (c *MockCard) End() error {
+	c.inTransaction = false
+	c.pinVerified = false
+	c.authenticated = false
+	return nil
+}
+
+func (c *MockCard) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *MockCard) Transmit(apdu []byte) ([]byte, error) {
+	if c.transmitErr != nil {
+		return nil, c.transmitErr
+	}
+	if !c.inTransaction {
+		return nil, errors.New("not in a transaction")
+	}
+	if len(apdu) < 4 {
+		return status(0x6700), nil
+	}
+	cla, ins, p1, p2 := apdu[0], apdu[1], apdu[2], apdu[3]
+	var data []byte
+	if len(apdu) > 5 {
+		data = apdu[5:]
+		if int(apdu[4]) != len(data) {
+			return status(0x6700), nil
+		}
+	}
+	if sw, ok := c.failures[ins]; ok {
+		return status(sw), nil
+	}
+	if ins == insGetResponse {
+		return c.respond(c.pending)
+	}
+	if cla&claChaining != 0 {
+		c.chained = append(c.chained, data...)
+		return status(swSuccess), nil
+	}
+	data = append(c.chained, data...)
+	c.chained = nil
+
+	resp, sw := c.handle(ins, p1, p2, data)
+	if sw != swSuccess {
+		return status(sw), nil
+	}
+	return c.respond(resp)
+}
+
+// respond returns the response in chunks of 256 bytes.
+func (c *MockCard) respond(resp []byte) ([]byte, error) {
+	if len(resp) > 256 {
+		c.pending = resp[256:]
+		n := len(c.pending)
+		if n > 255 {
+			n = 0
+		}
+		return append(append([]byte{}, resp[:256]...), 0x61, byte(n)), nil
+	}
+	c.pending = nil
+	return append(append([]byte{}, resp...), 0x90, 0x00), nil
+}
+
+func status(sw uint16) []byte {
+	return []byte{byte(sw >> 8), byte(sw)}
+}
+
+func (c *MockCard) handle(ins, p1, p2 byte, data []byte) ([]byte, uint16) {
+	switch ins {
+	case insSelect:
+		if !bytes.Equal(data, pivAID) {
+			return nil, swNotFound
+		}
+		return nil, swSuccess
+	case insVerify:
+		pin := bytes.Repeat([]byte{0xFF}, 8)
+		copy(pin, c.pin)
+		if !bytes.Equal(data, pin) {
+			return nil, 0x63C2
+		}
+		c.pinVerified = true
+		return nil, swSuccess
+	case insAuthenticate:
+		if p2 == managementKeySlot {
+			return c.authenticate(p1, data)
+		}
+		return c.sign(p1, p2, data)
+	case insGenerate:
+		return c.generate(p2, data)
+	case insGetData:
+		tag, object, _, err := parseTLV(data)
+		if err != nil || tag != 0x5C {
+			return nil, 0x6A80
+		}
+		value, ok := c.objects[string(object)]
+		if !ok {
+			return nil, swNotFound
+		}
+		return tlv(0x53, value), swSuccess
+	case insPutData:
+		if !c.authenticated {
+			return nil, swSecurityStatus
+		}
+		tag, object, rest, err := parseTLV(data)
+		if err != nil || tag != 0x5C {
+			return nil, 0x6A80
+		}
+		tag, value, _, err := parseTLV(rest)
+		if err != nil || tag != 0x53 {
+			return nil, 0x6A80
+		}
+		c.objects[string(object)] = value
+		return nil, swSuccess
+	case insGetMetadata:
+		if c.noMetadata {
+			return nil, swWrongINS
+		}
+		if p2 == managementKeySlot {
+			return tlv(0x01, []byte{c.managementKeyAlg}), swSuccess
+		}
+		s, ok := c.slots[p2]
+		if !ok {
+			return nil, swNoReferenceData
+		}
+		resp := tlv(0x01, []byte{s.algorithm})
+		resp = append(resp, tlv(0x02, []byte{s.pinPolicy, s.touchPolicy})...)
+		resp = append(resp, tlv(0x04, encodePublicKey(s.key.Public()))...)
+		return resp, swSuccess
+	case insAttest:
+		s, ok := c.slots[p1]
+		if !ok {
+			return nil, swNoReferenceData
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "YubiKey PIV Attestation " + slotName(p1)},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		_, der, _, _ := parseTLV(c.objects[string(attestationObject)])
+		parent, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, 0x6F00
+		}
+		der, err = x509.CreateCertificate(rand.Reader, template, parent, s.key.Public(), c.attestationKey)
+		if err != nil {
+			return nil, 0x6F00
+		}
+		return der, swSuccess
+	default:
+		return nil, swWrongINS
+	}
+}
+
+func (c *MockCard) block() cipher.Block {
+	var block cipher.Block
+	var err error
+	if c.managementKeyAlg == algTripleDES {
+		block, err = des.NewTripleDESCipher(c.managementKey)
+	} else {
+		block, err = aes.NewCipher(c.managementKey)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+func (c *MockCard) authenticate(alg byte, data []byte) ([]byte, uint16) {
+	if alg != c.managementKeyAlg {
+		return nil, 0x6A86
+	}
+	block := c.block()
+	if bytes.Equal(data, []byte{0x7C, 0x02, 0x80, 0x00}) {
+		c.witness = make([]byte, block.BlockSize())
+		rand.Read(c.witness)
+		encrypted := make([]byte, len(c.witness))
+		block.Encrypt(encrypted, c.witness)
+		return tlv(0x7C, tlv(0x80, encrypted)), swSuccess
+	}
+	decrypted, err := dynamicAuthValue(data, 0x80)
+	if err != nil || c.witness == nil || !bytes.Equal(decrypted, c.witness) {
+		return nil, swSecurityStatus
+	}
+	challenge, err := dynamicAuthValue(data, 0x81)
+	if err != nil || len(challenge) != block.BlockSize() {
+		return nil, 0x6A80
+	}
+	c.witness = nil
+	c.authenticated = true
+	response := make([]byte, len(challenge))
+	block.Encrypt(response, challenge)
+	return tlv(0x7C, tlv(0x82, response)), swSuccess
+}
+
+func (c *MockCard) sign(alg, slot byte, data []byte) ([]byte, uint16) {
+	s, ok := c.slots[slot]
+	if !ok {
+		return nil, swNoReferenceData
+	}
+	if s.algorithm != alg {
+		return nil, 0x6A86
+	}
+	if s.pinPolicy != pinPolicyNever && !c.pinVerified {
+		return nil, swSecurityStatus
+	}
+	if s.pinPolicy == 0x03 {
+		c.pinVerified = false
+	}
+	msg, err := dynamicAuthValue(data, 0x81)
+	if err != nil {
+		return nil, 0x6A80
+	}
+	var sig []byte
+	switch key := s.key.(type) {
+	case *ecdsa.PrivateKey:
+		if len(msg) != (key.Curve.Params().BitSize+7)/8 {
+			return nil, 0x6A80
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, msg)
+		if err != nil {
+			return nil, 0x6F00
+		}
+		sig, _ = asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	case *rsa.PrivateKey:
+		if len(msg) != key.Size() {
+			return nil, 0x6A80
+		}
+		m := new(big.Int).SetBytes(msg)
+		sig = make([]byte, key.Size())
+		b := new(big.Int).Exp(m, key.D, key.N).Bytes()
+		copy(sig[len(sig)-len(b):], b)
+	}
+	return tlv(0x7C, tlv(0x82, sig)), swSuccess
+}
+
+func (c *MockCard) generate(slot byte, data []byte) ([]byte, uint16) {
+	if !c.authenticated {
+		return nil, swSecurityStatus
+	}
+	tag, template, _, err := parseTLV(data)
+	if err != nil || tag != 0xAC {
+		return nil, 0x6A80
+	}
+	var alg, pinPolicy, touchPolicy byte = 0, 0x02, 0x01
+	for len(template) > 0 {
+		tag, value, rest, err := parseTLV(template)
+		if err != nil || len(value) != 1 {
+			return nil, 0x6A80
+		}
+		switch tag {
+		case 0x80:
+			alg = value[0]
+		case 0xAA:
+			pinPolicy = value[0]
+		case 0xAB:
+			touchPolicy = value[0]
+		}
+		template = rest
+	}
+	switch alg {
+	case algECCP256, algECCP384, algRSA2048:
+	default:
+		return nil, 0x6A80
+	}
+	key := c.addKey(slot, alg, pinPolicy, touchPolicy)
+	return tlv(0x7F49, encodePublicKey(key.Public())), swSuccess
+}
+
+// encodePublicKey encodes a public key like the YubiKey.
+func encodePublicKey(pub crypto.PublicKey) []byte {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return tlv(0x86, elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+	case *rsa.PublicKey:
+		return append(tlv(0x81, pub.N.Bytes()), tlv(0x82, big.NewInt(int64(pub.E)).Bytes())...)
+	default:
+		panic("unsupported public key")
+	}
+}
@@ -0,0 +1,216 @@
+//go:build cgo && !windows
+// +build cgo,!windows
+
+package yubikey
+
+/*
+#cgo linux LDFLAGS: -ldl
+
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+#ifdef __APPLE__
+#define PCSC_LIBRARY "/System/Library/Frameworks/PCSC.framework/PCSC"
+typedef uint32_t DWORD;
+typedef int32_t LONG;
+#else
+#define PCSC_LIBRARY "libpcsclite.so.1"
+typedef unsigned long DWORD;
+typedef long LONG;
+#endif
+
+typedef LONG SCARDCONTEXT;
+typedef LONG SCARDHANDLE;
+
+typedef struct {
+	DWORD dwProtocol;
+	DWORD cbPciLength;
+} SCARD_IO_REQUEST;
+
+typedef struct pcsc {
+	void *handle;
+	LONG (*SCardEstablishContext)(DWORD, const void *, const void *, SCARDCONTEXT *);
+	LONG (*SCardReleaseContext)(SCARDCONTEXT);
+	LONG (*SCardListReaders)(SCARDCONTEXT, const char *, char *, DWORD *);
+	LONG (*SCardConnect)(SCARDCONTEXT, const char *, DWORD, DWORD, SCARDHANDLE *, DWORD *);
+	LONG (*SCardDisconnect)(SCARDHANDLE, DWORD);
+	LONG (*SCardBeginTransaction)(SCARDHANDLE);
+	LONG (*SCardEndTransaction)(SCARDHANDLE, DWORD);
+	LONG (*SCardTransmit)(SCARDHANDLE, const SCARD_IO_REQUEST *, const unsigned char *, DWORD, SCARD_IO_REQUEST *, unsigned char *, DWORD *);
+} pcsc;
+
+static int pcsc_open(pcsc *p) {
+	p->handle = dlopen(PCSC_LIBRARY, RTLD_NOW | RTLD_LOCAL);
+	if (p->handle == NULL) {
+		return -1;
+	}
+	p->SCardEstablishContext = dlsym(p->handle, "SCardEstablishContext");
+	p->SCardReleaseContext = dlsym(p->handle, "SCardReleaseContext");
+	p->SCardListReaders = dlsym(p->handle, "SCardListReaders");
+	p->SCardConnect = dlsym(p->handle, "SCardConnect");
+	p->SCardDisconnect = dlsym(p->handle, "SCardDisconnect");
+	p->SCardBeginTransaction = dlsym(p->handle, "SCardBeginTransaction");
+	p->SCardEndTransaction = dlsym(p->handle, "SCardEndTransaction");
+	p->SCardTransmit = dlsym(p->handle, "SCardTransmit");
+	if (p->SCardEstablishContext == NULL || p->SCardReleaseContext == NULL ||
+		p->SCardListReaders == NULL || p->SCardConnect == NULL ||
+		p->SCardDisconnect == NULL || p->SCardBeginTransaction == NULL ||
+		p->SCardEndTransaction == NULL || p->SCardTransmit == NULL) {
+		dlclose(p->handle);
+		return -2;
+	}
+	return 0;
+}
+
+static void pcsc_close(pcsc *p) {
+	dlclose(p->handle);
+}
+
+static LONG pcsc_establish_context(pcsc *p, SCARDCONTEXT *ctx) {
+	// SCARD_SCOPE_SYSTEM
+	return p->SCardEstablishContext(2, NULL, NULL, ctx);
+}
+
+static LONG pcsc_release_context(pcsc *p, SCARDCONTEXT ctx) {
+	return p->SCardReleaseContext(ctx);
+}
+
+static LONG pcsc_list_readers(pcsc *p, SCARDCONTEXT ctx, char *readers, DWORD *len) {
+	return p->SCardListReaders(ctx, NULL, readers, len);
+}
+
+static LONG pcsc_connect(pcsc *p, SCARDCONTEXT ctx, const char *reader, SCARDHANDLE *card) {
+	DWORD protocol;
+	// SCARD_SHARE_SHARED, SCARD_PROTOCOL_T1
+	return p->SCardConnect(ctx, reader, 2, 2, card, &protocol);
+}
+
+static LONG pcsc_disconnect(pcsc *p, SCARDHANDLE card) {
+	// SCARD_LEAVE_CARD
+	return p->SCardDisconnect(card, 0);
+}
+
+static LONG pcsc_begin_transaction(pcsc *p, SCARDHANDLE card) {
+	return p->SCardBeginTransaction(card);
+}
+
+static LONG pcsc_end_transaction(pcsc *p, SCARDHANDLE card) {
+	// SCARD_LEAVE_CARD
+	return p->SCardEndTransaction(card, 0);
+}
+
+static LONG pcsc_transmit(pcsc *p, SCARDHANDLE card, const unsigned char *apdu, DWORD len, unsigned char *resp, DWORD *respLen) {
+	SCARD_IO_REQUEST pci = {2, sizeof(SCARD_IO_REQUEST)};
+	return p->SCardTransmit(card, &pci, apdu, len, NULL, resp, respLen);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// maxResponseLength is the size of the buffer used to receive responses, the
+// YubiKey never returns more than 256 bytes plus the status word.
+const maxResponseLength = 4096
+
+// pcscError is an error returned by the PC/SC library.
+type pcscError uint32
+
+func (e pcscError) Error() string {
+	return fmt.Sprintf("pcsc: error 0x%08X", uint32(e))
+}
+
+func pcscErr(rv C.LONG) error {
+	if rv == 0 {
+		return nil
+	}
+	return pcscError(uint32(rv))
+}
+
+// pcscCard is the Card implemented by the PC/SC library.
+type pcscCard struct {
+	p    C.pcsc
+	ctx  C.SCARDCONTEXT
+	card C.SCARDHANDLE
+}
+
+// openCard connects to the first reader with a YubiKey.
+func openCard() (Card, error) {
+	c := new(pcscCard)
+	if C.pcsc_open(&c.p) != 0 {
+		return nil, errors.New("error loading the PC/SC library")
+	}
+	if err := pcscErr(C.pcsc_establish_context(&c.p, &c.ctx)); err != nil {
+		C.pcsc_close(&c.p)
+		return nil, errors.Wrap(err, "error establishing PC/SC context")
+	}
+	reader, err := c.findReader()
+	if err == nil {
+		creader := C.CString(reader)
+		err = errors.Wrapf(pcscErr(C.pcsc_connect(&c.p, c.ctx, creader, &c.card)), "error connecting to %s", reader)
+		C.free(unsafe.Pointer(creader))
+	}
+	if err != nil {
+		C.pcsc_release_context(&c.p, c.ctx)
+		C.pcsc_close(&c.p)
+		return nil, err
+	}
+	return c, nil
+}
+
+// findReader returns the name of the first reader containing yubikey.
+func (c *pcscCard) findReader() (string, error) {
+	var n C.DWORD
+	if err := pcscErr(C.pcsc_list_readers(&c.p, c.ctx, nil, &n)); err != nil {
+		return "", errors.Wrap(err, "error listing smart card readers")
+	}
+	buf := make([]byte, n)
+	if n > 0 {
+		if err := pcscErr(C.pcsc_list_readers(&c.p, c.ctx, (*C.char)(unsafe.Pointer(&buf[0])), &n)); err != nil {
+			return "", errors.Wrap(err, "error listing smart card readers")
+		}
+	}
+	// The readers are a multi-string terminated by two null characters.
+	for _, reader := range bytes.Split(buf[:n], []byte{0}) {
+		if strings.Contains(strings.ToLower(string(reader)), "yubikey") {
+			return string(reader), nil
+		}
+	}
+	return "", errors.New("yubikey not found")
+}
+
+func (c *pcscCard) Begin() error {
+	return pcscErr(C.pcsc_begin_transaction(&c.p, c.card))
+}
+
+func (c *pcscCard) End() error {
+	return pcscErr(C.pcsc_end_transaction(&c.p, c.card))
+}
+
+func (c *pcscCard) Transmit(apdu []byte) ([]byte, error) {
+	resp := make([]byte, maxResponseLength)
+	n := C.DWORD(len(resp))
+	capdu := C.CBytes(apdu)
+	defer C.free(capdu)
+	rv := C.pcsc_transmit(&c.p, c.card, (*C.uchar)(capdu), C.DWORD(len(apdu)), (*C.uchar)(unsafe.Pointer(&resp[0])), &n)
+	if err := pcscErr(rv); err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+func (c *pcscCard) Close() error {
+	err := pcscErr(C.pcsc_disconnect(&c.p, c.card))
+	if e := pcscErr(C.pcsc_release_context(&c.p, c.ctx)); err == nil {
+		err = e
+	}
+	C.pcsc_close(&c.p)
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !cgo || windows
+// +build !cgo windows
+
+package yubikey
+
+import "github.com/pkg/errors"
+
+func openCard() (Card, error) {
+	return nil, errors.New("yubikey is not supported: step-ca must be compiled with cgo")
+}
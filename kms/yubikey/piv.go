@@ -0,0 +1,421 @@
+package yubikey
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PIV instructions, including the Yubico extensions.
+const (
+	insVerify         = 0x20
+	insGenerate       = 0x47
+	insAuthenticate   = 0x87
+	insSelect         = 0xA4
+	insGetResponse    = 0xC0
+	insGetData        = 0xCB
+	insPutData        = 0xDB
+	insGetMetadata    = 0xF7
+	insAttest         = 0xF9
+	claChaining       = 0x10
+	maxCommandLength  = 0xFF
+	managementKeySlot = 0x9B
+	pinReference      = 0x80
+)
+
+// PIV algorithms.
+const (
+	algTripleDES = 0x03
+	algAES128    = 0x08
+	algAES192    = 0x0A
+	algAES256    = 0x0C
+	algRSA2048   = 0x07
+	algECCP256   = 0x11
+	algECCP384   = 0x14
+)
+
+// Status words with special handling.
+const (
+	swSuccess         = 0x9000
+	swNotFound        = 0x6A82
+	swNoReferenceData = 0x6A88
+	swWrongINS        = 0x6D00
+	swPINBlocked      = 0x6983
+	swSecurityStatus  = 0x6982
+)
+
+// pivAID is the application identifier of PIV.
+var pivAID = []byte{0xA0, 0x00, 0x00, 0x03, 0x08}
+
+// attestationObject is the object with the Yubico attestation intermediate
+// certificate of the device, the one in slot f9.
+var attestationObject = []byte{0x5F, 0xFF, 0x01}
+
+// defaultManagementKey is the default management key of the YubiKeys.
+var defaultManagementKey = []byte{
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+}
+
+// Card is the smart card interface used to send APDUs to the YubiKey. The
+// commands between Begin and End are performed in an exclusive transaction.
+// The PC/SC implementation is used by default; this interface will be used for
+// unit testing.
+type Card interface {
+	Begin() error
+	End() error
+	Transmit(apdu []byte) ([]byte, error)
+	Close() error
+}
+
+// apduError is an error status word returned by the YubiKey.
+type apduError struct {
+	sw uint16
+}
+
+func (e *apduError) Error() string {
+	switch {
+	case e.sw == swSecurityStatus:
+		return "yubikey: security status not satisfied"
+	case e.sw == swPINBlocked:
+		return "yubikey: PIN is blocked"
+	case e.sw&0xFFF0 == 0x63C0:
+		return fmt.Sprintf("yubikey: wrong PIN, %d retries left", e.sw&0x0F)
+	case e.sw == swNotFound || e.sw == swNoReferenceData:
+		return "yubikey: object not found"
+	default:
+		return fmt.Sprintf("yubikey: command failed with status %04x", e.sw)
+	}
+}
+
+func isStatus(err error, sw uint16) bool {
+	e, ok := err.(*apduError)
+	return ok && e.sw == sw
+}
+
+// slotMetadata is the Yubico metadata of a key slot.
+type slotMetadata struct {
+	algorithm   byte
+	pinPolicy   byte
+	touchPolicy byte
+	publicKey   []byte
+}
+
+// pivClient implements the PIV commands used by this package. Every operation
+// selects the PIV application in a new transaction, so the authentication state
+// is not shared with other applications using the YubiKey.
+type pivClient struct {
+	card  Card
+	mutex sync.Mutex
+}
+
+// tx runs fn in a transaction with the PIV application selected.
+func (c *pivClient) tx(fn func() error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.card.Begin(); err != nil {
+		return errors.Wrap(err, "error beginning yubikey transaction")
+	}
+	defer c.card.End()
+	if _, err := c.send(0x00, insSelect, 0x04, 0x00, pivAID); err != nil {
+		return errors.Wrap(err, "error selecting PIV application")
+	}
+	return fn()
+}
+
+// send sends a command using command chaining for long data, and returns the
+// response concatenating the chained responses.
+func (c *pivClient) send(cla, ins, p1, p2 byte, data []byte) ([]byte, error) {
+	for len(data) > maxCommandLength {
+		if _, err := c.transmit(cla|claChaining, ins, p1, p2, data[:maxCommandLength]); err != nil {
+			return nil, err
+		}
+		data = data[maxCommandLength:]
+	}
+	return c.transmit(cla, ins, p1, p2, data)
+}
+
+func (c *pivClient) transmit(cla, ins, p1, p2 byte, data []byte) ([]byte, error) {
+	apdu := []byte{cla, ins, p1, p2}
+	if len(data) > 0 {
+		apdu = append(apdu, byte(len(data)))
+		apdu = append(apdu, data...)
+	}
+	var resp []byte
+	for {
+		b, err := c.card.Transmit(apdu)
+		if err != nil {
+			return nil, errors.Wrap(err, "error transmitting to yubikey")
+		}
+		if len(b) < 2 {
+			return nil, errors.New("yubikey: invalid response")
+		}
+		sw1, sw2 := b[len(b)-2], b[len(b)-1]
+		resp = append(resp, b[:len(b)-2]...)
+		switch {
+		case sw1 == 0x61:
+			// More data available.
+			apdu = []byte{0x00, insGetResponse, 0x00, 0x00, sw2}
+		case sw1 == 0x90 && sw2 == 0x00:
+			return resp, nil
+		default:
+			return nil, &apduError{sw: uint16(sw1)<<8 | uint16(sw2)}
+		}
+	}
+}
+
+// verifyPIN authenticates with the given PIN.
+func (c *pivClient) verifyPIN(pin string) error {
+	if len(pin) == 0 || len(pin) > 8 {
+		return errors.New("yubikey PIN must have 1 to 8 characters")
+	}
+	data := bytes.Repeat([]byte{0xFF}, 8)
+	copy(data, pin)
+	_, err := c.send(0x00, insVerify, 0x00, pinReference, data)
+	return err
+}
+
+// authenticate authenticates with the management key, the algorithm of the key
+// is read from the metadata, if it is not available 3DES is used.
+func (c *pivClient) authenticate(key []byte) error {
+	alg := byte(algTripleDES)
+	md, err := c.metadata(managementKeySlot)
+	switch {
+	case err == nil:
+		alg = md.algorithm
+	case !isStatus(err, swWrongINS):
+		return err
+	}
+
+	var block cipher.Block
+	switch alg {
+	case algTripleDES:
+		block, err = des.NewTripleDESCipher(key)
+	case algAES128, algAES192, algAES256:
+		block, err = aes.NewCipher(key)
+	default:
+		return errors.Errorf("yubikey management key algorithm %x is not supported", alg)
+	}
+	if err != nil {
+		return errors.Wrap(err, "invalid yubikey management key")
+	}
+
+	// Request a witness, decrypt it and send it back with a challenge.
+	resp, err := c.send(0x00, insAuthenticate, alg, managementKeySlot, []byte{0x7C, 0x02, 0x80, 0x00})
+	if err != nil {
+		return errors.Wrap(err, "error authenticating yubikey management key")
+	}
+	witness, err := dynamicAuthValue(resp, 0x80)
+	if err != nil || len(witness) != block.BlockSize() {
+		return errors.New("error authenticating yubikey management key: invalid witness")
+	}
+	decrypted := make([]byte, len(witness))
+	block.Decrypt(decrypted, witness)
+	challenge := make([]byte, block.BlockSize())
+	if _, err := rand.Read(challenge); err != nil {
+		return errors.Wrap(err, "error generating challenge")
+	}
+	data := tlv(0x7C, append(tlv(0x80, decrypted), tlv(0x81, challenge)...))
+	if resp, err = c.send(0x00, insAuthenticate, alg, managementKeySlot, data); err != nil {
+		return errors.Wrap(err, "error authenticating yubikey management key")
+	}
+	response, err := dynamicAuthValue(resp, 0x82)
+	if err != nil {
+		return errors.New("error authenticating yubikey management key: invalid response")
+	}
+	expected := make([]byte, len(challenge))
+	block.Encrypt(expected, challenge)
+	if subtle.ConstantTimeCompare(expected, response) != 1 {
+		return errors.New("error authenticating yubikey management key: invalid response")
+	}
+	return nil
+}
+
+// metadata returns the metadata of a slot, the command is available on
+// YubiKeys with firmware 5.3 or newer.
+func (c *pivClient) metadata(slot byte) (*slotMetadata, error) {
+	resp, err := c.send(0x00, insGetMetadata, 0x00, slot, nil)
+	if err != nil {
+		return nil, err
+	}
+	md := new(slotMetadata)
+	for len(resp) > 0 {
+		tag, value, rest, err := parseTLV(resp)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing yubikey metadata")
+		}
+		switch {
+		case tag == 0x01 && len(value) == 1:
+			md.algorithm = value[0]
+		case tag == 0x02 && len(value) == 2:
+			md.pinPolicy, md.touchPolicy = value[0], value[1]
+		case tag == 0x04:
+			md.publicKey = value
+		}
+		resp = rest
+	}
+	return md, nil
+}
+
+// generate generates a new key in the slot and returns the encoded public key.
+func (c *pivClient) generate(slot, alg, pinPolicy, touchPolicy byte) ([]byte, error) {
+	template := tlv(0x80, []byte{alg})
+	if pinPolicy != 0 {
+		template = append(template, tlv(0xAA, []byte{pinPolicy})...)
+	}
+	if touchPolicy != 0 {
+		template = append(template, tlv(0xAB, []byte{touchPolicy})...)
+	}
+	resp, err := c.send(0x00, insGenerate, 0x00, slot, tlv(0xAC, template))
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating yubikey key")
+	}
+	tag, value, _, err := parseTLV(resp)
+	if err != nil || tag != 0x7F49 {
+		return nil, errors.New("error generating yubikey key: invalid response")
+	}
+	return value, nil
+}
+
+// sign signs the data with the key in the slot.
+func (c *pivClient) sign(slot, alg byte, data []byte) ([]byte, error) {
+	req := tlv(0x7C, append([]byte{0x82, 0x00}, tlv(0x81, data)...))
+	resp, err := c.send(0x00, insAuthenticate, alg, slot, req)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := dynamicAuthValue(resp, 0x82)
+	if err != nil {
+		return nil, errors.New("yubikey: invalid signature response")
+	}
+	return sig, nil
+}
+
+// getObject returns the content of a data object.
+func (c *pivClient) getObject(object []byte) ([]byte, error) {
+	resp, err := c.send(0x00, insGetData, 0x3F, 0xFF, tlv(0x5C, object))
+	if err != nil {
+		return nil, err
+	}
+	tag, value, _, err := parseTLV(resp)
+	if err != nil || tag != 0x53 {
+		return nil, errors.New("yubikey: invalid data object")
+	}
+	return value, nil
+}
+
+// putObject stores the content of a data object.
+func (c *pivClient) putObject(object, value []byte) error {
+	_, err := c.send(0x00, insPutData, 0x3F, 0xFF, append(tlv(0x5C, object), tlv(0x53, value)...))
+	return err
+}
+
+// certificate returns the DER certificate stored in a data object.
+func (c *pivClient) certificate(object []byte) ([]byte, error) {
+	value, err := c.getObject(object)
+	if err != nil {
+		return nil, err
+	}
+	for len(value) > 0 {
+		tag, v, rest, err := parseTLV(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing yubikey certificate")
+		}
+		if tag == 0x70 {
+			return v, nil
+		}
+		value = rest
+	}
+	return nil, errors.New("yubikey: certificate not found in data object")
+}
+
+// putCertificate stores a DER certificate in a data object.
+func (c *pivClient) putCertificate(object, der []byte) error {
+	value := append(tlv(0x70, der), tlv(0x71, []byte{0x00})...)
+	value = append(value, tlv(0xFE, nil)...)
+	return c.putObject(object, value)
+}
+
+// attest returns the attestation certificate of the key in the slot.
+func (c *pivClient) attest(slot byte) ([]byte, error) {
+	return c.send(0x00, insAttest, slot, 0x00, nil)
+}
+
+// dynamicAuthValue returns the value with the given tag in a dynamic
+// authentication template.
+func dynamicAuthValue(b []byte, tag uint) ([]byte, error) {
+	t, value, _, err := parseTLV(b)
+	if err != nil || t != 0x7C {
+		return nil, errors.New("invalid dynamic authentication template")
+	}
+	for len(value) > 0 {
+		t, v, rest, err := parseTLV(value)
+		if err != nil {
+			return nil, err
+		}
+		if t == tag {
+			return v, nil
+		}
+		value = rest
+	}
+	return nil, errors.New("invalid dynamic authentication template")
+}
+
+// tlv encodes a BER-TLV with a one or two bytes tag.
+func tlv(tag uint, value []byte) []byte {
+	var b []byte
+	if tag > 0xFF {
+		b = append(b, byte(tag>>8))
+	}
+	b = append(b, byte(tag))
+	switch n := len(value); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xFF:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, value...)
+}
+
+// parseTLV parses a BER-TLV and returns its tag, value and the remaining
+// bytes.
+func parseTLV(b []byte) (uint, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("invalid tlv")
+	}
+	tag := uint(b[0])
+	b = b[1:]
+	// Multi-byte tags have the low five bits set in the first byte.
+	if tag&0x1F == 0x1F {
+		tag = tag<<8 | uint(b[0])
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, nil, nil, errors.New("invalid tlv")
+		}
+	}
+	n := int(b[0])
+	b = b[1:]
+	switch {
+	case n < 0x80:
+	case n == 0x81 && len(b) >= 1:
+		n, b = int(b[0]), b[1:]
+	case n == 0x82 && len(b) >= 2:
+		n, b = int(b[0])<<8|int(b[1]), b[2:]
+	default:
+		return 0, nil, nil, errors.New("invalid tlv length")
+	}
+	if len(b) < n {
+		return 0, nil, nil, errors.New("invalid tlv length")
+	}
+	return tag, b[:n], b[n:], nil
+}
@@ -0,0 +1,224 @@
+package yubikey
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func Test_tlv(t *testing.T) {
+	long := bytes.Repeat([]byte{0xAB}, 300)
+	tests := []struct {
+		name  string
+		tag   uint
+		value []byte
+		want  []byte
+	}{
+		{"empty", 0x53, nil, []byte{0x53, 0x00}},
+		{"short", 0x81, []byte{1, 2}, []byte{0x81, 0x02, 1, 2}},
+		{"two bytes tag", 0x7F49, []byte{1}, []byte{0x7F, 0x49, 0x01, 1}},
+		{"one byte length", 0x70, bytes.Repeat([]byte{0xAB}, 200), append([]byte{0x70, 0x81, 200}, bytes.Repeat([]byte{0xAB}, 200)...)},
+		{"two bytes length", 0x70, long, append([]byte{0x70, 0x82, 0x01, 0x2C}, long...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tlv(tt.tag, tt.value)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("tlv() = %x, want %x", got, tt.want)
+			}
+			tag, value, rest, err := parseTLV(append(got, 0xFF))
+			if err != nil {
+				t.Fatalf("parseTLV() error = %v", err)
+			}
+			if tag != tt.tag || !bytes.Equal(value, tt.value) || !bytes.Equal(rest, []byte{0xFF}) {
+				t.Errorf("parseTLV() = %x, %x, %x", tag, value, rest)
+			}
+		})
+	}
+}
+
+func Test_parseTLV(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		{0x53},
+		{0x7F, 0x49},
+		{0x53, 0x02, 0x01},
+		{0x53, 0x83, 0x01, 0x02, 0x03},
+		{0x53, 0x81},
+		{0x53, 0x82, 0x01},
+	} {
+		if _, _, _, err := parseTLV(b); err == nil {
+			t.Errorf("parseTLV(%x) error = nil, wantErr true", b)
+		}
+	}
+}
+
+func Test_apduError_Error(t *testing.T) {
+	tests := []struct {
+		sw   uint16
+		want string
+	}{
+		{0x6982, "yubikey: security status not satisfied"},
+		{0x6983, "yubikey: PIN is blocked"},
+		{0x63C2, "yubikey: wrong PIN, 2 retries left"},
+		{0x6A82, "yubikey: object not found"},
+		{0x6A88, "yubikey: object not found"},
+		{0x6A80, "yubikey: command failed with status 6a80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := (&apduError{sw: tt.sw}).Error(); got != tt.want {
+				t.Errorf("apduError.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPIVClient_transmit(t *testing.T) {
+	card := newMockCard()
+	c := &pivClient{card: card}
+	long := bytes.Repeat([]byte{0xAB}, 600)
+	card.objects["\x5f\xc1\x0a"] = long
+
+	// Command chaining and GET RESPONSE.
+	if err := c.tx(func() error {
+		card.authenticated = true
+		if err := c.putObject([]byte{0x5F, 0xC1, 0x0A}, long); err != nil {
+			return err
+		}
+		got, err := c.getObject([]byte{0x5F, 0xC1, 0x0A})
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, long) {
+			t.Errorf("pivClient.getObject() = %x, want %x", got, long)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("pivClient.tx() error = %v", err)
+	}
+
+	card.transmitErr = errors.New("an error")
+	if err := c.tx(func() error { return nil }); err == nil {
+		t.Error("pivClient.tx() error = nil, wantErr true")
+	}
+	card.transmitErr = nil
+
+	// Invalid responses.
+	invalid := &responseCard{MockCard: newMockCard(), resp: []byte{0x90}}
+	c = &pivClient{card: invalid}
+	if _, err := c.transmit(0x00, insSelect, 0x04, 0x00, pivAID); err == nil {
+		t.Error("pivClient.transmit() error = nil, wantErr true")
+	}
+}
+
+func TestPIVClient_verifyPIN(t *testing.T) {
+	card := newMockCard()
+	c := &pivClient{card: card}
+	tests := []struct {
+		name    string
+		pin     string
+		wantErr bool
+	}{
+		{"ok", "123456", false},
+		{"fail empty", "", true},
+		{"fail long", "123456789", true},
+		{"fail wrong", "654321", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.tx(func() error {
+				return c.verifyPIN(tt.pin)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pivClient.verifyPIN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPIVClient_authenticate(t *testing.T) {
+	aesKey := []byte("0123456789abcdef0123456789abcdef")
+	tests := []struct {
+		name    string
+		card    func() *MockCard
+		key     []byte
+		wantErr bool
+	}{
+		{"ok 3DES", newMockCard, defaultManagementKey, false},
+		{"ok 3DES no metadata", func() *MockCard {
+			c := newMockCard()
+			c.noMetadata = true
+			return c
+		}, defaultManagementKey, false},
+		{"ok AES256", func() *MockCard {
+			c := newMockCard()
+			c.managementKey, c.managementKeyAlg = aesKey, algAES256
+			return c
+		}, aesKey, false},
+		{"fail key", newMockCard, aesKey[:24], true},
+		{"fail key length", newMockCard, aesKey[:16], true},
+		{"fail algorithm", func() *MockCard {
+			c := newMockCard()
+			c.managementKeyAlg = algRSA2048
+			return c
+		}, defaultManagementKey, true},
+		{"fail metadata", func() *MockCard {
+			c := newMockCard()
+			c.failures[insGetMetadata] = 0x6A80
+			return c
+		}, defaultManagementKey, true},
+		{"fail authenticate", func() *MockCard {
+			c := newMockCard()
+			c.failures[insAuthenticate] = 0x6A80
+			return c
+		}, defaultManagementKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card := tt.card()
+			c := &pivClient{card: card}
+			err := c.tx(func() error {
+				if err := c.authenticate(tt.key); err != nil {
+					return err
+				}
+				if !card.authenticated {
+					t.Error("pivClient.authenticate() did not authenticate")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pivClient.authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPIVClient_metadata(t *testing.T) {
+	card := newMockCard()
+	card.addKey(0x9c, algECCP256, 0x03, 0x02)
+	c := &pivClient{card: card}
+	var md *slotMetadata
+	if err := c.tx(func() (err error) {
+		md, err = c.metadata(0x9c)
+		return
+	}); err != nil {
+		t.Fatalf("pivClient.metadata() error = %v", err)
+	}
+	want := &slotMetadata{algorithm: algECCP256, pinPolicy: 0x03, touchPolicy: 0x02, publicKey: encodePublicKey(card.slots[0x9c].key.Public())}
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("pivClient.metadata() = %v, want %v", md, want)
+	}
+}
+
+// responseCard is a card that always returns the same response.
+type responseCard struct {
+	*MockCard
+	resp []byte
+}
+
+func (c *responseCard) Transmit(apdu []byte) ([]byte, error) {
+	return c.resp, nil
+}
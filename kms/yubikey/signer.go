@@ -0,0 +1,188 @@
+package yubikey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// hashPrefixes are the DER prefixes of the DigestInfo used in RSASSA-PKCS1-v1_5
+// signatures. The YubiKey performs a raw RSA operation, so the padding is done
+// in the host.
+var hashPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pinPolicyNever is the Yubico PIN policy that does not require the PIN.
+const pinPolicyNever = 0x01
+
+// Signer implements a crypto.Signer using a private key in a YubiKey slot.
+type Signer struct {
+	yubikey   *YubiKey
+	slot      byte
+	publicKey crypto.PublicKey
+	verifyPIN bool
+}
+
+func newSigner(k *YubiKey, slot byte, pub crypto.PublicKey, md *slotMetadata) *Signer {
+	return &Signer{
+		yubikey:   k,
+		slot:      slot,
+		publicKey: pub,
+		verifyPIN: k.pin != "" && (md == nil || md.pinPolicy != pinPolicyNever),
+	}
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key stored in the YubiKey. If the PIN is
+// configured it is verified in the same transaction.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	h := opts.HashFunc()
+	if _, ok := hashPrefixes[h]; !ok {
+		return nil, errors.Errorf("unsupported hash function %v", h)
+	}
+	if len(digest) != h.Size() {
+		return nil, errors.New("digest length does not match hash function")
+	}
+
+	var alg byte
+	var data []byte
+	switch pub := s.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		switch size {
+		case 32:
+			alg = algECCP256
+		case 48:
+			alg = algECCP384
+		default:
+			return nil, errors.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+		// The digest is truncated or padded to the size of the curve.
+		data = make([]byte, size)
+		if len(digest) > size {
+			copy(data, digest[:size])
+		} else {
+			copy(data[size-len(digest):], digest)
+		}
+	case *rsa.PublicKey:
+		if pub.N.BitLen() != 2048 {
+			return nil, errors.Errorf("unsupported RSA key size %d", pub.N.BitLen())
+		}
+		alg = algRSA2048
+		var err error
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			data, err = emsaPSSEncode(rand, digest, h, pss.SaltLength, pub.N.BitLen()-1)
+		} else {
+			data, err = emsaPKCS1v15Encode(digest, h, (pub.N.BitLen()+7)/8)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", pub)
+	}
+
+	var sig []byte
+	err := s.yubikey.piv.tx(func() (err error) {
+		if s.verifyPIN {
+			if err := s.yubikey.piv.verifyPIN(s.yubikey.pin); err != nil {
+				return errors.Wrap(err, "error verifying yubikey PIN")
+			}
+		}
+		sig, err = s.yubikey.piv.sign(s.slot, alg, data)
+		return
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "yubikey Sign failed")
+	}
+	return sig, nil
+}
+
+// emsaPKCS1v15Encode returns the RSASSA-PKCS1-v1_5 encoded message of the
+// digest.
+func emsaPKCS1v15Encode(digest []byte, h crypto.Hash, size int) ([]byte, error) {
+	prefix := hashPrefixes[h]
+	tLen := len(prefix) + len(digest)
+	if size < tLen+11 {
+		return nil, errors.New("message too long for RSA key size")
+	}
+	em := bytes.Repeat([]byte{0xFF}, size)
+	em[0], em[1] = 0x00, 0x01
+	em[size-tLen-1] = 0x00
+	copy(em[size-tLen:], prefix)
+	copy(em[size-len(digest):], digest)
+	return em, nil
+}
+
+// emsaPSSEncode returns the EMSA-PSS encoded message of the digest as described
+// in RFC 8017, section 9.1.1.
+func emsaPSSEncode(rand io.Reader, digest []byte, h crypto.Hash, saltLength, emBits int) ([]byte, error) {
+	hLen := h.Size()
+	emLen := (emBits + 7) / 8
+	switch saltLength {
+	case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash:
+		saltLength = hLen
+	}
+	if saltLength < 0 || emLen < hLen+saltLength+2 {
+		return nil, errors.Errorf("invalid salt length %d", saltLength)
+	}
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, errors.Wrap(err, "error generating salt")
+	}
+
+	hash := h.New()
+	hash.Write(make([]byte, 8))
+	hash.Write(digest)
+	hash.Write(salt)
+	mHash := hash.Sum(nil)
+
+	// DB = PS || 0x01 || salt, masked with MGF1(H).
+	db := make([]byte, emLen-hLen-1)
+	db[len(db)-saltLength-1] = 0x01
+	copy(db[len(db)-saltLength:], salt)
+	mgf1XOR(db, h, mHash)
+	db[0] &= 0xFF >> uint(8*emLen-emBits)
+
+	em := make([]byte, 0, emLen)
+	em = append(em, db...)
+	em = append(em, mHash...)
+	em = append(em, 0xBC)
+
+	// The raw RSA operation requires an input of the size of the modulus.
+	if size := (emBits + 1 + 7) / 8; size > emLen {
+		em = append(make([]byte, size-emLen), em...)
+	}
+	return em, nil
+}
+
+// mgf1XOR XORs out with the MGF1 mask generated with the given seed.
+func mgf1XOR(out []byte, h crypto.Hash, seed []byte) {
+	var counter [4]byte
+	hash := h.New()
+	for done := 0; done < len(out); {
+		hash.Reset()
+		hash.Write(seed)
+		hash.Write(counter[:])
+		for _, b := range hash.Sum(nil) {
+			if done == len(out) {
+				break
+			}
+			out[done] ^= b
+			done++
+		}
+		binary.BigEndian.PutUint32(counter[:], binary.BigEndian.Uint32(counter[:])+1)
+	}
+}
@@ -0,0 +1,108 @@
+package yubikey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	card := newMockCard()
+	card.addKey(0x9a, algECCP256, 0x02, 0x01)
+	card.addKey(0x9c, algECCP384, 0x03, 0x01)
+	card.addKey(0x9d, algRSA2048, pinPolicyNever, 0x01)
+	k, err := NewYubiKey(card, apiv1.Options{Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := func(name string) crypto.Signer {
+		s, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	p256 := signer("yubikey:slot-id=9a")
+	p384 := signer("yubikey:slot-id=9c")
+	rsa2048 := signer("yubikey:slot-id=9d")
+
+	badPIN, err := NewYubiKey(card, apiv1.Options{Pin: "000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noPIN, err := NewYubiKey(card, apiv1.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum256 := sha256.Sum256([]byte("data"))
+	sum384 := sha512.Sum384([]byte("data"))
+	sum512 := sha512.Sum512([]byte("data"))
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		digest  []byte
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok P-256", p256, sum256[:], crypto.SHA256, false},
+		{"ok P-256 SHA512", p256, sum512[:], crypto.SHA512, false},
+		{"ok P-384", p384, sum384[:], crypto.SHA384, false},
+		{"ok P-384 SHA256", p384, sum256[:], crypto.SHA256, false},
+		{"ok rsa", rsa2048, sum256[:], crypto.SHA256, false},
+		{"ok rsa SHA512", rsa2048, sum512[:], crypto.SHA512, false},
+		{"ok rsa-pss", rsa2048, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, false},
+		{"ok rsa-pss SHA384", rsa2048, sum384[:], &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA384}, false},
+		{"ok rsa no PIN", newSigner(noPIN, 0x9d, rsa2048.Public(), nil), sum256[:], crypto.SHA256, false},
+		{"fail hash", p256, sum256[:], crypto.SHA1, true},
+		{"fail digest", p256, sum384[:], crypto.SHA256, true},
+		{"fail salt", rsa2048, sum256[:], &rsa.PSSOptions{SaltLength: 512, Hash: crypto.SHA256}, true},
+		{"fail PIN", newSigner(badPIN, 0x9a, p256.Public(), nil), sum256[:], crypto.SHA256, true},
+		{"fail no PIN", newSigner(noPIN, 0x9a, p256.Public(), nil), sum256[:], crypto.SHA256, true},
+		{"fail key", newSigner(k, 0x9e, p256.Public(), nil), sum256[:], crypto.SHA256, true},
+		{"fail key type", newSigner(k, 0x9a, []byte("foo"), nil), sum256[:], crypto.SHA256, true},
+		{"fail rsa size", newSigner(k, 0x9d, &rsa.PublicKey{N: big.NewInt(1 << 62), E: 65537}, nil), sum256[:], crypto.SHA256, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			switch pub := tt.signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				var esig struct{ R, S *big.Int }
+				if _, err := asn1.Unmarshal(sig, &esig); err != nil || !ecdsa.Verify(pub, tt.digest, esig.R, esig.S) {
+					t.Error("Signer.Sign() signature is not valid")
+				}
+			case *rsa.PublicKey:
+				if opts, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, opts.Hash, tt.digest, sig, opts)
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, tt.opts.HashFunc(), tt.digest, sig)
+				}
+				if err != nil {
+					t.Errorf("Signer.Sign() signature is not valid: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_emsaPKCS1v15Encode(t *testing.T) {
+	sum := sha512.Sum512([]byte("data"))
+	if _, err := emsaPKCS1v15Encode(sum[:], crypto.SHA512, 64); err == nil {
+		t.Error("emsaPKCS1v15Encode() error = nil, wantErr true")
+	}
+}
@@ -0,0 +1,386 @@
+package yubikey
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// uriScheme is the scheme used in the key names, e.g. yubikey:slot-id=9c.
+const uriScheme = "yubikey:"
+
+// slotObjects maps the key slots with the data objects that store their
+// certificates. The retired key management slots 82 to 95 use the objects
+// 5fc10d to 5fc120.
+var slotObjects = map[byte][]byte{
+	0x9A: {0x5F, 0xC1, 0x05},
+	0x9C: {0x5F, 0xC1, 0x0A},
+	0x9D: {0x5F, 0xC1, 0x0B},
+	0x9E: {0x5F, 0xC1, 0x01},
+}
+
+func init() {
+	for i := byte(0); i < 20; i++ {
+		slotObjects[0x82+i] = []byte{0x5F, 0xC1, 0x0D + i}
+	}
+}
+
+// signatureAlgorithmMapping maps the step signature algorithms, and bits for
+// RSA keys, with the PIV algorithms.
+//
+// YubiKey does not support SHA384WithRSAPSS and SHA512WithRSAPSS with RSA 2048
+// keys in this package, nor PureEd25519.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]byte{
+	apiv1.UnspecifiedSignAlgorithm: algECCP256,
+	apiv1.SHA256WithRSA:            algRSA2048,
+	apiv1.SHA384WithRSA:            algRSA2048,
+	apiv1.SHA512WithRSA:            algRSA2048,
+	apiv1.SHA256WithRSAPSS:         algRSA2048,
+	apiv1.ECDSAWithSHA256:          algECCP256,
+	apiv1.ECDSAWithSHA384:          algECCP384,
+}
+
+// pinPolicyMapping and touchPolicyMapping map the step policies with the Yubico
+// ones, 0 is the default policy of the slot.
+var pinPolicyMapping = map[apiv1.PINPolicy]byte{
+	apiv1.UnspecifiedPINPolicy: 0x00,
+	apiv1.PINPolicyNever:       0x01,
+	apiv1.PINPolicyOnce:        0x02,
+	apiv1.PINPolicyAlways:      0x03,
+}
+
+var touchPolicyMapping = map[apiv1.TouchPolicy]byte{
+	apiv1.UnspecifiedTouchPolicy: 0x00,
+	apiv1.TouchPolicyNever:       0x01,
+	apiv1.TouchPolicyAlways:      0x02,
+	apiv1.TouchPolicyCached:      0x03,
+}
+
+// YubiKey implements a KMS using the PIV application of a YubiKey. The keys are
+// identified by their slot, e.g. yubikey:slot-id=9c.
+type YubiKey struct {
+	piv           *pivClient
+	pin           string
+	managementKey []byte
+}
+
+// New opens the first YubiKey connected to the system. The PIN is used to sign
+// and the management key to create keys and store certificates, by default the
+// YubiKey default management key is used.
+func New(ctx context.Context, opts apiv1.Options) (*YubiKey, error) {
+	card, err := openCard()
+	if err != nil {
+		return nil, err
+	}
+	k, err := NewYubiKey(card, opts)
+	if err != nil {
+		card.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewYubiKey creates a YubiKey using the given card.
+func NewYubiKey(card Card, opts apiv1.Options) (*YubiKey, error) {
+	managementKey := defaultManagementKey
+	if opts.ManagementKey != "" {
+		b, err := hex.DecodeString(opts.ManagementKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding managementKey")
+		}
+		switch len(b) {
+		case 16, 24, 32:
+		default:
+			return nil, errors.New("managementKey must be a 3DES or AES key")
+		}
+		managementKey = b
+	}
+	return &YubiKey{
+		piv:           &pivClient{card: card},
+		pin:           opts.Pin,
+		managementKey: managementKey,
+	}, nil
+}
+
+// Close releases the connection to the YubiKey.
+func (k *YubiKey) Close() error {
+	if err := k.piv.card.Close(); err != nil {
+		return errors.Wrap(err, "error closing yubikey")
+	}
+	return nil
+}
+
+// GetPublicKey returns the public key of the key in the given slot.
+func (k *YubiKey) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+	slot, err := parseSlot(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	var pub crypto.PublicKey
+	err = k.piv.tx(func() (err error) {
+		pub, _, err = k.publicKey(slot)
+		return
+	})
+	return pub, err
+}
+
+// CreateKey generates a new key in the given slot with the PIN and touch
+// policies in the request. It fails if the slot already contains a key.
+func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+	alg, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok || (alg == algRSA2048 && req.Bits != 0 && req.Bits != 2048) {
+		return nil, errors.Errorf("yubikey does not support signature algorithm '%s' with %d bits", req.SignatureAlgorithm, req.Bits)
+	}
+	pinPolicy, ok := pinPolicyMapping[req.PINPolicy]
+	if !ok {
+		return nil, errors.Errorf("yubikey does not support PIN policy '%s'", req.PINPolicy)
+	}
+	touchPolicy, ok := touchPolicyMapping[req.TouchPolicy]
+	if !ok {
+		return nil, errors.Errorf("yubikey does not support touch policy '%s'", req.TouchPolicy)
+	}
+	slot, err := parseSlot(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var pub crypto.PublicKey
+	err = k.piv.tx(func() error {
+		if _, _, err := k.publicKey(slot); err == nil {
+			return errors.Errorf("yubikey slot %s already has a key", req.Name)
+		} else if !isStatus(errors.Cause(err), swNoReferenceData) && !isStatus(errors.Cause(err), swNotFound) {
+			return err
+		}
+		if err := k.piv.authenticate(k.managementKey); err != nil {
+			return err
+		}
+		b, err := k.piv.generate(slot, alg, pinPolicy, touchPolicy)
+		if err != nil {
+			return err
+		}
+		pub, err = decodePublicKey(alg, b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	name := slotName(slot)
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner returns a signer using the key in the given slot. If the slot
+// requires touch, every signature blocks until the YubiKey is touched.
+func (k *YubiKey) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("signing key cannot be empty")
+	}
+	slot, err := parseSlot(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	var pub crypto.PublicKey
+	var md *slotMetadata
+	if err := k.piv.tx(func() (err error) {
+		pub, md, err = k.publicKey(slot)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	if md != nil && (md.touchPolicy == touchPolicyMapping[apiv1.TouchPolicyAlways] || md.touchPolicy == touchPolicyMapping[apiv1.TouchPolicyCached]) {
+		log.Printf("yubikey slot %s requires touch, signatures will wait until the YubiKey is touched", slotName(slot))
+	}
+	return newSigner(k, slot, pub, md), nil
+}
+
+// LoadCertificate returns the certificate stored in the given slot.
+func (k *YubiKey) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	if req.Name == "" {
+		return nil, errors.New("loadCertificateRequest 'name' cannot be empty")
+	}
+	slot, err := parseSlot(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	var crt *x509.Certificate
+	err = k.piv.tx(func() (err error) {
+		crt, err = k.certificate(slotObjects[slot])
+		return
+	})
+	return crt, err
+}
+
+// StoreCertificate stores the certificate in the given slot.
+func (k *YubiKey) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
+	if req.Name == "" {
+		return errors.New("storeCertificateRequest 'name' cannot be empty")
+	}
+	if req.Certificate == nil {
+		return errors.New("storeCertificateRequest 'certificate' cannot be empty")
+	}
+	slot, err := parseSlot(req.Name)
+	if err != nil {
+		return err
+	}
+	return k.piv.tx(func() error {
+		if err := k.piv.authenticate(k.managementKey); err != nil {
+			return err
+		}
+		if err := k.piv.putCertificate(slotObjects[slot], req.Certificate.Raw); err != nil {
+			return errors.Wrap(err, "error storing yubikey certificate")
+		}
+		return nil
+	})
+}
+
+// CreateAttestation returns the certificate signed by the YubiKey attestation
+// key that proves that the key in the given slot was generated in the device.
+// The chain contains the attestation certificate of the YubiKey, signed by the
+// Yubico PIV root CA.
+func (k *YubiKey) CreateAttestation(req *apiv1.CreateAttestationRequest) (*apiv1.CreateAttestationResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createAttestationRequest 'name' cannot be empty")
+	}
+	slot, err := parseSlot(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(apiv1.CreateAttestationResponse)
+	err = k.piv.tx(func() error {
+		b, err := k.piv.attest(slot)
+		if err != nil {
+			return errors.Wrap(err, "error attesting yubikey key")
+		}
+		if resp.Certificate, err = x509.ParseCertificate(b); err != nil {
+			return errors.Wrap(err, "error parsing yubikey attestation")
+		}
+		intermediate, err := k.certificate(attestationObject)
+		if err != nil {
+			return err
+		}
+		resp.CertificateChain = []*x509.Certificate{intermediate}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// publicKey returns the public key in the slot and its metadata, on YubiKeys
+// without metadata support the public key is read from the certificate in the
+// slot, and the metadata is nil.
+func (k *YubiKey) publicKey(slot byte) (crypto.PublicKey, *slotMetadata, error) {
+	md, err := k.piv.metadata(slot)
+	switch {
+	case err == nil:
+		pub, err := decodePublicKey(md.algorithm, md.publicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pub, md, nil
+	case isStatus(err, swWrongINS):
+		crt, err := k.certificate(slotObjects[slot])
+		if err != nil {
+			return nil, nil, err
+		}
+		return crt.PublicKey, nil, nil
+	default:
+		return nil, nil, errors.Wrapf(err, "error reading yubikey slot %s", slotName(slot))
+	}
+}
+
+func (k *YubiKey) certificate(object []byte) (*x509.Certificate, error) {
+	b, err := k.piv.certificate(object)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading yubikey certificate")
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing yubikey certificate")
+	}
+	return crt, nil
+}
+
+// parseSlot parses a key name like yubikey:slot-id=9c and returns the slot.
+func parseSlot(name string) (byte, error) {
+	if !strings.HasPrefix(name, uriScheme) {
+		return 0, errors.Errorf("error parsing %s: name must start with %s", name, uriScheme)
+	}
+	var slotID string
+	for _, attr := range strings.Split(strings.TrimPrefix(name, uriScheme), ";") {
+		if parts := strings.SplitN(attr, "=", 2); len(parts) == 2 && parts[0] == "slot-id" {
+			slotID = parts[1]
+		}
+	}
+	n, err := strconv.ParseUint(slotID, 16, 8)
+	if err != nil {
+		return 0, errors.Errorf("error parsing %s: invalid slot-id", name)
+	}
+	if _, ok := slotObjects[byte(n)]; !ok {
+		return 0, errors.Errorf("error parsing %s: unsupported slot-id %s", name, slotID)
+	}
+	return byte(n), nil
+}
+
+func slotName(slot byte) string {
+	return uriScheme + "slot-id=" + hex.EncodeToString([]byte{slot})
+}
+
+// decodePublicKey decodes the public key returned by the YubiKey, the content
+// of the 7f49 template.
+func decodePublicKey(alg byte, b []byte) (crypto.PublicKey, error) {
+	values := make(map[uint][]byte)
+	for len(b) > 0 {
+		tag, value, rest, err := parseTLV(b)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing yubikey public key")
+		}
+		values[tag] = value
+		b = rest
+	}
+
+	switch alg {
+	case algECCP256, algECCP384:
+		curve := elliptic.P256()
+		if alg == algECCP384 {
+			curve = elliptic.P384()
+		}
+		x, y := elliptic.Unmarshal(curve, values[0x86])
+		if x == nil {
+			return nil, errors.New("error parsing yubikey public key: invalid point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case algRSA2048:
+		n, e := values[0x81], new(big.Int).SetBytes(values[0x82])
+		if len(n) == 0 || !e.IsInt64() || e.Int64() <= 1 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("error parsing yubikey public key: invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(e.Int64())}, nil
+	default:
+		return nil, errors.Errorf("yubikey key algorithm %x is not supported", alg)
+	}
+}
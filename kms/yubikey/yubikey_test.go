@@ -0,0 +1,407 @@
+package yubikey
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+func TestNew(t *testing.T) {
+	// The test environment does not have a YubiKey.
+	if _, err := New(context.Background(), apiv1.Options{Type: "yubikey"}); err == nil {
+		t.Error("New() error = nil, wantErr true")
+	}
+}
+
+func TestNewYubiKey(t *testing.T) {
+	aesKey := "000102030405060708090a0b0c0d0e0f"
+	tests := []struct {
+		name    string
+		opts    apiv1.Options
+		want    []byte
+		wantErr bool
+	}{
+		{"ok default", apiv1.Options{}, defaultManagementKey, false},
+		{"ok aes", apiv1.Options{ManagementKey: aesKey}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, false},
+		{"fail hex", apiv1.Options{ManagementKey: "zz"}, nil, true},
+		{"fail length", apiv1.Options{ManagementKey: "0102"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewYubiKey(newMockCard(), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewYubiKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.managementKey, tt.want) {
+				t.Errorf("NewYubiKey() managementKey = %x, want %x", got.managementKey, tt.want)
+			}
+		})
+	}
+}
+
+func TestYubiKey_Close(t *testing.T) {
+	card := newMockCard()
+	k, err := NewYubiKey(card, apiv1.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Close(); err != nil {
+		t.Errorf("YubiKey.Close() error = %v", err)
+	}
+	if !card.closed {
+		t.Error("YubiKey.Close() did not close the card")
+	}
+}
+
+func TestYubiKey_CreateKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		card        func() *MockCard
+		req         *apiv1.CreateKeyRequest
+		curve       elliptic.Curve
+		pinPolicy   byte
+		touchPolicy byte
+		wantErr     bool
+	}{
+		{"ok default", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, elliptic.P256(), 0x02, 0x01, false},
+		{"ok P-384", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9a", SignatureAlgorithm: apiv1.ECDSAWithSHA384}, elliptic.P384(), 0x02, 0x01, false},
+		{"ok RSA", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=82", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}, nil, 0x02, 0x01, false},
+		{"ok policies", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9d", PINPolicy: apiv1.PINPolicyAlways, TouchPolicy: apiv1.TouchPolicyCached}, elliptic.P256(), 0x03, 0x03, false},
+		{"ok aes management key", func() *MockCard {
+			c := newMockCard()
+			c.managementKeyAlg = algAES192
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9e"}, elliptic.P256(), 0x02, 0x01, false},
+		{"ok no metadata", func() *MockCard {
+			c := newMockCard()
+			c.noMetadata = true
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, elliptic.P256(), 0x02, 0x01, false},
+		{"fail name", newMockCard, &apiv1.CreateKeyRequest{}, nil, 0, 0, true},
+		{"fail slot", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=f9"}, nil, 0, 0, true},
+		{"fail Ed25519", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c", SignatureAlgorithm: apiv1.PureEd25519}, nil, 0, 0, true},
+		{"fail RSA 4096", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 4096}, nil, 0, 0, true},
+		{"fail pin policy", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c", PINPolicy: apiv1.PINPolicy(10)}, nil, 0, 0, true},
+		{"fail touch policy", newMockCard, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c", TouchPolicy: apiv1.TouchPolicy(10)}, nil, 0, 0, true},
+		{"fail slot in use", func() *MockCard {
+			c := newMockCard()
+			c.addKey(0x9c, algECCP256, 0, 0)
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, nil, 0, 0, true},
+		{"fail slot in use no metadata", func() *MockCard {
+			c := newMockCard()
+			c.noMetadata = true
+			c.addKey(0x9c, algECCP256, 0, 0)
+			c.addCertificate(0x9c)
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, nil, 0, 0, true},
+		{"fail management key", func() *MockCard {
+			c := newMockCard()
+			c.managementKey = []byte("012345678901234567890123")
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, nil, 0, 0, true},
+		{"fail generate", func() *MockCard {
+			c := newMockCard()
+			c.failures[insGenerate] = 0x6A80
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, nil, 0, 0, true},
+		{"fail transaction", func() *MockCard {
+			c := newMockCard()
+			c.beginErr = errors.New("an error")
+			return c
+		}, &apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}, nil, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card := tt.card()
+			k, err := NewYubiKey(card, apiv1.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("YubiKey.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.req.Name || got.CreateSignerRequest.SigningKey != tt.req.Name {
+				t.Errorf("YubiKey.CreateKey() = %v, want name %s", got, tt.req.Name)
+			}
+			slot := card.slots[mustParseSlot(t, tt.req.Name)]
+			if slot.pinPolicy != tt.pinPolicy || slot.touchPolicy != tt.touchPolicy {
+				t.Errorf("YubiKey.CreateKey() policies = %d %d, want %d %d", slot.pinPolicy, slot.touchPolicy, tt.pinPolicy, tt.touchPolicy)
+			}
+			if !reflect.DeepEqual(got.PublicKey, slot.key.Public()) {
+				t.Errorf("YubiKey.CreateKey() public key = %v, want %v", got.PublicKey, slot.key.Public())
+			}
+			switch pub := got.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				if pub.Curve != tt.curve {
+					t.Errorf("YubiKey.CreateKey() curve = %v, want %v", pub.Curve.Params().Name, tt.curve.Params().Name)
+				}
+			case *rsa.PublicKey:
+				if tt.curve != nil || pub.N.BitLen() != 2048 {
+					t.Errorf("YubiKey.CreateKey() RSA bits = %d", pub.N.BitLen())
+				}
+			default:
+				t.Errorf("YubiKey.CreateKey() public key type = %T", pub)
+			}
+		})
+	}
+}
+
+func TestYubiKey_GetPublicKey(t *testing.T) {
+	card := newMockCard()
+	ecKey := card.addKey(0x9c, algECCP256, 0, 0)
+	rsaKey := card.addKey(0x9a, algRSA2048, 0, 0)
+	oldCard := newMockCard()
+	oldCard.noMetadata = true
+	oldKey := oldCard.addKey(0x9c, algECCP384, 0, 0)
+	oldCard.addCertificate(0x9c)
+
+	tests := []struct {
+		name    string
+		card    *MockCard
+		req     *apiv1.GetPublicKeyRequest
+		want    interface{}
+		wantErr bool
+	}{
+		{"ok ecdsa", card, &apiv1.GetPublicKeyRequest{Name: "yubikey:slot-id=9c"}, ecKey.Public(), false},
+		{"ok rsa", card, &apiv1.GetPublicKeyRequest{Name: "yubikey:slot-id=9a"}, rsaKey.Public(), false},
+		{"ok certificate", oldCard, &apiv1.GetPublicKeyRequest{Name: "yubikey:slot-id=9c"}, oldKey.Public(), false},
+		{"fail name", card, &apiv1.GetPublicKeyRequest{}, nil, true},
+		{"fail scheme", card, &apiv1.GetPublicKeyRequest{Name: "pkcs11:slot-id=9c"}, nil, true},
+		{"fail missing", card, &apiv1.GetPublicKeyRequest{Name: "yubikey:slot-id=9d"}, nil, true},
+		{"fail missing certificate", oldCard, &apiv1.GetPublicKeyRequest{Name: "yubikey:slot-id=9d"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewYubiKey(tt.card, apiv1.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := k.GetPublicKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("YubiKey.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("YubiKey.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYubiKey_CreateSigner(t *testing.T) {
+	card := newMockCard()
+	key := card.addKey(0x9c, algECCP256, pinPolicyNever, 0)
+	k, err := NewYubiKey(card, apiv1.Options{Pin: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "yubikey:slot-id=9c"})
+	if err != nil {
+		t.Fatalf("YubiKey.CreateSigner() error = %v", err)
+	}
+	s := got.(*Signer)
+	if !reflect.DeepEqual(s.Public(), key.Public()) || s.slot != 0x9c || s.verifyPIN {
+		t.Errorf("YubiKey.CreateSigner() = %v", s)
+	}
+
+	for _, req := range []*apiv1.CreateSignerRequest{
+		{},
+		{SigningKey: "yubikey:slot-id=zz"},
+		{SigningKey: "yubikey:slot-id=9d"},
+	} {
+		if _, err := k.CreateSigner(req); err == nil {
+			t.Errorf("YubiKey.CreateSigner(%v) error = nil, wantErr true", req)
+		}
+	}
+}
+
+func TestYubiKey_StoreCertificate(t *testing.T) {
+	card := newMockCard()
+	card.addKey(0x9c, algRSA2048, 0, 0)
+	crt := card.addCertificate(0x9c)
+	delete(card.objects, string(slotObjects[0x9c]))
+
+	tests := []struct {
+		name    string
+		card    *MockCard
+		req     *apiv1.StoreCertificateRequest
+		wantErr bool
+	}{
+		{"ok", card, &apiv1.StoreCertificateRequest{Name: "yubikey:slot-id=9c", Certificate: crt}, false},
+		{"fail name", card, &apiv1.StoreCertificateRequest{Certificate: crt}, true},
+		{"fail certificate", card, &apiv1.StoreCertificateRequest{Name: "yubikey:slot-id=9c"}, true},
+		{"fail slot", card, &apiv1.StoreCertificateRequest{Name: "yubikey:slot-id=00", Certificate: crt}, true},
+		{"fail put", func() *MockCard {
+			c := newMockCard()
+			c.failures[insPutData] = 0x6A84
+			return c
+		}(), &apiv1.StoreCertificateRequest{Name: "yubikey:slot-id=9c", Certificate: crt}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewYubiKey(tt.card, apiv1.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := k.StoreCertificate(tt.req); (err != nil) != tt.wantErr {
+				t.Fatalf("YubiKey.StoreCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: tt.req.Name})
+			if err != nil {
+				t.Fatalf("YubiKey.LoadCertificate() error = %v", err)
+			}
+			if !got.Equal(crt) {
+				t.Errorf("YubiKey.LoadCertificate() = %v, want %v", got, crt)
+			}
+		})
+	}
+}
+
+func TestYubiKey_LoadCertificate(t *testing.T) {
+	card := newMockCard()
+	card.addKey(0x9a, algECCP256, 0, 0)
+	crt := card.addCertificate(0x9a)
+	card.objects[string(slotObjects[0x9d])] = tlv(0x70, []byte("foo"))
+	card.objects[string(slotObjects[0x9e])] = tlv(0x71, []byte{0})
+
+	tests := []struct {
+		name    string
+		req     *apiv1.LoadCertificateRequest
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", &apiv1.LoadCertificateRequest{Name: "yubikey:slot-id=9a"}, crt, false},
+		{"fail name", &apiv1.LoadCertificateRequest{}, nil, true},
+		{"fail slot", &apiv1.LoadCertificateRequest{Name: "yubikey:slot-id="}, nil, true},
+		{"fail missing", &apiv1.LoadCertificateRequest{Name: "yubikey:slot-id=9c"}, nil, true},
+		{"fail parse", &apiv1.LoadCertificateRequest{Name: "yubikey:slot-id=9d"}, nil, true},
+		{"fail no certificate", &apiv1.LoadCertificateRequest{Name: "yubikey:slot-id=9e"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewYubiKey(card, apiv1.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := k.LoadCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("YubiKey.LoadCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("YubiKey.LoadCertificate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYubiKey_CreateAttestation(t *testing.T) {
+	card := newMockCard()
+	key := card.addKey(0x9c, algECCP256, 0, 0)
+	k, err := NewYubiKey(card, apiv1.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := k.CreateAttestation(&apiv1.CreateAttestationRequest{Name: "yubikey:slot-id=9c"})
+	if err != nil {
+		t.Fatalf("YubiKey.CreateAttestation() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.Certificate.PublicKey, key.Public()) {
+		t.Errorf("YubiKey.CreateAttestation() public key = %v, want %v", got.Certificate.PublicKey, key.Public())
+	}
+	if len(got.CertificateChain) != 1 {
+		t.Fatalf("YubiKey.CreateAttestation() chain = %v", got.CertificateChain)
+	}
+	if err := got.Certificate.CheckSignatureFrom(got.CertificateChain[0]); err != nil {
+		t.Errorf("YubiKey.CreateAttestation() signature error = %v", err)
+	}
+
+	for _, req := range []*apiv1.CreateAttestationRequest{
+		{},
+		{Name: "yubikey:slot-id=9"},
+		{Name: "yubikey:slot-id=9a"},
+	} {
+		if _, err := k.CreateAttestation(req); err == nil {
+			t.Errorf("YubiKey.CreateAttestation(%v) error = nil, wantErr true", req)
+		}
+	}
+
+	delete(card.objects, string(attestationObject))
+	if _, err := k.CreateAttestation(&apiv1.CreateAttestationRequest{Name: "yubikey:slot-id=9c"}); err == nil {
+		t.Error("YubiKey.CreateAttestation() error = nil, wantErr true")
+	}
+}
+
+func Test_parseSlot(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    byte
+		wantErr bool
+	}{
+		{"yubikey:slot-id=9a", 0x9a, false},
+		{"yubikey:slot-id=9C", 0x9c, false},
+		{"yubikey:foo=bar;slot-id=95", 0x95, false},
+		{"yubikey:slot-id=82", 0x82, false},
+		{"9a", 0, true},
+		{"yubikey:slot-id=96", 0, true},
+		{"yubikey:slot-id=f9", 0, true},
+		{"yubikey:slot-id=100", 0, true},
+		{"yubikey:", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSlot(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSlot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSlot() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodePublicKey(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  byte
+		b    []byte
+	}{
+		{"fail tlv", algECCP256, []byte{0x86}},
+		{"fail point", algECCP256, tlv(0x86, []byte{0x04, 0x01})},
+		{"fail modulus", algRSA2048, tlv(0x82, []byte{0x01, 0x00, 0x01})},
+		{"fail exponent", algRSA2048, tlv(0x81, []byte{0x01})},
+		{"fail algorithm", algTripleDES, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePublicKey(tt.alg, tt.b); err == nil {
+				t.Error("decodePublicKey() error = nil, wantErr true")
+			}
+		})
+	}
+}
+
+func mustParseSlot(t *testing.T, name string) byte {
+	t.Helper()
+	slot, err := parseSlot(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return slot
+}
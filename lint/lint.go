@@ -0,0 +1,207 @@
+// Package lint implements the checks run on the X.509 certificates before
+// they are signed. The names of the built-in lints follow the zlint
+// conventions: the names of the lints that reject a certificate by default
+// start with "e_", and the ones that only flag it with "w_".
+package lint
+
+import (
+	"crypto/x509"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Severity is the result of a lint that fails.
+type Severity string
+
+const (
+	// Error is the severity of the lints that reject the certificate.
+	Error Severity = "error"
+	// Warning is the severity of the lints that only flag the certificate.
+	Warning Severity = "warn"
+)
+
+// Lint is a check run on a certificate template before it is signed. Check
+// returns an error describing the violation if the certificate does not pass
+// the lint.
+type Lint struct {
+	Name        string
+	Description string
+	Severity    Severity
+	Check       func(crt *x509.Certificate) error
+}
+
+var (
+	registry      = make(map[string]*Lint)
+	registryMutex sync.RWMutex
+)
+
+// Register adds a custom lint, it returns an error if the name is already
+// used. Custom lints are enabled by default, like the built-in ones, and they
+// must be registered before the authority is initialized.
+func Register(l *Lint) error {
+	switch {
+	case l == nil || l.Name == "":
+		return errors.New("lint name cannot be empty")
+	case l.Check == nil:
+		return errors.Errorf("lint %s check cannot be empty", l.Name)
+	case l.Severity != Error && l.Severity != Warning:
+		return errors.Errorf("lint %s severity %s is not valid", l.Name, l.Severity)
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[l.Name]; ok {
+		return errors.Errorf("lint %s is already registered", l.Name)
+	}
+	registry[l.Name] = l
+	return nil
+}
+
+// Lints returns the registered lints sorted by name.
+func Lints() []*Lint {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	lints := make([]*Lint, 0, len(registry))
+	for _, l := range registry {
+		lints = append(lints, l)
+	}
+	sort.Slice(lints, func(i, j int) bool {
+		return lints[i].Name < lints[j].Name
+	})
+	return lints
+}
+
+func lookup(name string) (*Lint, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	l, ok := registry[name]
+	return l, ok
+}
+
+// Options configures the lints run on the certificates. All the registered
+// lints are run with their default severity, Disable are the names of the
+// lints that are not run, Warn the ones that only flag the certificates and
+// Error the ones that reject them. The name "*" matches all the lints.
+type Options struct {
+	Disable []string `json:"disable,omitempty"`
+	Warn    []string `json:"warn,omitempty"`
+	Error   []string `json:"error,omitempty"`
+}
+
+// Validate checks that the lints in the options are registered, nil is ok.
+func (o *Options) Validate() error {
+	_, err := New(o)
+	return err
+}
+
+// Linter runs a set of lints with their configured severities.
+type Linter struct {
+	lints      []*Lint
+	severities map[string]Severity
+}
+
+// New returns the linter for the given options. A nil options returns a
+// nil linter that does not run any lint.
+func New(o *Options) (*Linter, error) {
+	if o == nil {
+		return nil, nil
+	}
+
+	disabled := make(map[string]bool)
+	severities := make(map[string]Severity)
+	for _, l := range Lints() {
+		severities[l.Name] = l.Severity
+	}
+	set := func(names []string, field string, fn func(name string)) error {
+		for _, name := range names {
+			if name == "*" {
+				for n := range severities {
+					fn(n)
+				}
+				continue
+			}
+			if _, ok := lookup(name); !ok {
+				return errors.Errorf("lint.%s contains the unknown lint %s", field, name)
+			}
+			fn(name)
+		}
+		return nil
+	}
+	if err := set(o.Disable, "disable", func(name string) { disabled[name] = true }); err != nil {
+		return nil, err
+	}
+	if err := set(o.Warn, "warn", func(name string) { severities[name] = Warning }); err != nil {
+		return nil, err
+	}
+	if err := set(o.Error, "error", func(name string) { severities[name] = Error }); err != nil {
+		return nil, err
+	}
+
+	linter := &Linter{severities: severities}
+	for _, l := range Lints() {
+		if !disabled[l.Name] {
+			linter.lints = append(linter.lints, l)
+		}
+	}
+	return linter, nil
+}
+
+// Result is the violation of a lint.
+type Result struct {
+	Lint     string   `json:"lint"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Results is the list of violations in a certificate.
+type Results []Result
+
+// Run runs the lints on the given certificate template and returns the
+// violations found. A nil linter does not run any lint.
+func (l *Linter) Run(crt *x509.Certificate) Results {
+	if l == nil {
+		return nil
+	}
+	var results Results
+	for _, lint := range l.lints {
+		if err := lint.Check(crt); err != nil {
+			results = append(results, Result{
+				Lint:     lint.Name,
+				Severity: l.severities[lint.Name],
+				Message:  err.Error(),
+			})
+		}
+	}
+	return results
+}
+
+// Warnings returns the results with the warning severity.
+func (r Results) Warnings() Results {
+	return r.filter(Warning)
+}
+
+// Err returns an error with the results with the error severity, or nil if
+// there are none.
+func (r Results) Err() error {
+	errs := r.filter(Error)
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, res := range errs {
+		msgs[i] = res.Lint + ": " + res.Message
+	}
+	return errors.Errorf("certificate does not pass the lints: %s", strings.Join(msgs, "; "))
+}
+
+func (r Results) filter(s Severity) Results {
+	var results Results
+	for _, res := range r {
+		if res.Severity == s {
+			results = append(results, res)
+		}
+	}
+	return results
+}
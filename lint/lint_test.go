@@ -0,0 +1,186 @@
+package lint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/assert"
+)
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	return key
+}
+
+func validCertificate(t *testing.T) *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "test.smallstep.com"},
+		NotBefore:    now,
+		NotAfter:     now.Add(24 * time.Hour),
+		PublicKey:    mustKey(t).Public(),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		DNSNames:     []string{"test.smallstep.com", "*.smallstep.com", "_acme.smallstep.com"},
+	}
+}
+
+func TestRegister(t *testing.T) {
+	check := func(crt *x509.Certificate) error { return nil }
+	tests := []struct {
+		name    string
+		lint    *Lint
+		wantErr bool
+	}{
+		{"ok", &Lint{Name: "e_test_register", Severity: Error, Check: check}, false},
+		{"fail/duplicated", &Lint{Name: "e_test_register", Severity: Error, Check: check}, true},
+		{"fail/builtin", &Lint{Name: "e_validity_negative", Severity: Error, Check: check}, true},
+		{"fail/nil", nil, true},
+		{"fail/name", &Lint{Severity: Error, Check: check}, true},
+		{"fail/check", &Lint{Name: "e_test_check", Severity: Error}, true},
+		{"fail/severity", &Lint{Name: "e_test_severity", Severity: "info", Check: check}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Register(tt.lint); (err != nil) != tt.wantErr {
+				t.Errorf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	_, ok := lookup("e_test_register")
+	assert.True(t, ok)
+}
+
+func TestNew(t *testing.T) {
+	l, err := New(nil)
+	assert.FatalError(t, err)
+	assert.Nil(t, l)
+	assert.Len(t, 0, l.Run(&x509.Certificate{}))
+
+	l, err = New(&Options{})
+	assert.FatalError(t, err)
+	assert.Equals(t, len(Lints()), len(l.lints))
+
+	l, err = New(&Options{Disable: []string{"*"}})
+	assert.FatalError(t, err)
+	assert.Len(t, 0, l.lints)
+
+	l, err = New(&Options{Disable: []string{"w_ext_san_missing"}, Warn: []string{"e_validity_negative"}, Error: []string{"w_subject_common_name_not_in_san"}})
+	assert.FatalError(t, err)
+	assert.Equals(t, len(Lints())-1, len(l.lints))
+	assert.Equals(t, Warning, l.severities["e_validity_negative"])
+	assert.Equals(t, Error, l.severities["w_subject_common_name_not_in_san"])
+
+	for _, o := range []*Options{
+		{Disable: []string{"foo"}},
+		{Warn: []string{"foo"}},
+		{Error: []string{"foo"}},
+	} {
+		_, err := New(o)
+		assert.Error(t, err)
+		assert.Error(t, o.Validate())
+	}
+	assert.FatalError(t, (*Options)(nil).Validate())
+}
+
+func TestLinter_Run(t *testing.T) {
+	l, err := New(&Options{})
+	assert.FatalError(t, err)
+
+	crt := validCertificate(t)
+	results := l.Run(crt)
+	assert.Len(t, 0, results)
+	assert.Nil(t, results.Err())
+
+	// Warnings do not reject the certificate.
+	crt.Subject.CommonName = "Test"
+	results = l.Run(crt)
+	assert.Equals(t, Results{{Lint: "w_subject_common_name_not_in_san", Severity: Warning, Message: "common name Test is not a subject alternative name"}}, results)
+	assert.Nil(t, results.Err())
+	assert.Equals(t, results, results.Warnings())
+
+	// Errors do.
+	crt.KeyUsage |= x509.KeyUsageCertSign
+	crt.DNSNames = append(crt.DNSNames, "foo bar.smallstep.com")
+	results = l.Run(crt)
+	assert.Len(t, 3, results)
+	assert.Len(t, 1, results.Warnings())
+	assert.Equals(t, errors.New("certificate does not pass the lints: e_dnsname_bad_character: dns name foo bar.smallstep.com contains the invalid character ' '; e_sub_cert_cert_sign_bit_set: certificate has the keyCertSign or cRLSign key usage").Error(), results.Err().Error())
+
+	// Overridden severities.
+	l, err = New(&Options{Warn: []string{"*"}})
+	assert.FatalError(t, err)
+	results = l.Run(crt)
+	assert.Len(t, 3, results)
+	assert.Nil(t, results.Err())
+}
+
+func TestLints(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.FatalError(t, err)
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.FatalError(t, err)
+	longSerial := new(big.Int).Lsh(big.NewInt(1), 159)
+
+	tests := []struct {
+		lint   string
+		modify func(crt *x509.Certificate)
+	}{
+		{"e_sub_cert_cert_sign_bit_set", func(crt *x509.Certificate) { crt.KeyUsage |= x509.KeyUsageCRLSign }},
+		{"e_sub_cert_cert_sign_bit_set", func(crt *x509.Certificate) { crt.IsCA = true }},
+		{"e_rsa_mod_less_than_2048_bits", func(crt *x509.Certificate) { crt.PublicKey = rsaKey.Public() }},
+		{"e_ec_improper_curves", func(crt *x509.Certificate) { crt.PublicKey = p224Key.Public() }},
+		{"e_validity_negative", func(crt *x509.Certificate) { crt.NotAfter = crt.NotBefore }},
+		{"e_serial_number_not_positive", func(crt *x509.Certificate) { crt.SerialNumber = big.NewInt(0) }},
+		{"e_serial_number_not_positive", func(crt *x509.Certificate) { crt.SerialNumber = longSerial }},
+		{"e_subject_common_name_max_length", func(crt *x509.Certificate) {
+			crt.Subject.CommonName = "a123456789.b123456789.c123456789.d123456789.e123456789.smallstep.com"
+			crt.DNSNames = []string{crt.Subject.CommonName}
+		}},
+		{"e_dnsname_bad_character", func(crt *x509.Certificate) { crt.DNSNames = []string{"-foo.smallstep.com"} }},
+		{"e_dnsname_bad_character", func(crt *x509.Certificate) { crt.DNSNames = []string{"foo.*.smallstep.com"} }},
+		{"e_dnsname_bad_character", func(crt *x509.Certificate) { crt.DNSNames = []string{"foo..smallstep.com"} }},
+		{"e_dnsname_bad_character", func(crt *x509.Certificate) { crt.DNSNames = []string{""} }},
+		{"e_rfc822_name_invalid", func(crt *x509.Certificate) { crt.EmailAddresses = []string{"smallstep.com"} }},
+		{"e_ext_duplicate_extension", func(crt *x509.Certificate) {
+			ext := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{5, 0}}
+			crt.ExtraExtensions = []pkix.Extension{ext, ext}
+		}},
+		{"w_ext_san_missing", func(crt *x509.Certificate) { crt.Subject.CommonName, crt.DNSNames = "", nil }},
+		{"w_subject_common_name_not_in_san", func(crt *x509.Certificate) { crt.DNSNames = []string{"foo.smallstep.com"} }},
+		{"w_sub_cert_validity_too_long", func(crt *x509.Certificate) { crt.NotAfter = crt.NotBefore.Add(400 * 24 * time.Hour) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lint, func(t *testing.T) {
+			l, ok := lookup(tt.lint)
+			assert.Fatal(t, ok)
+			crt := validCertificate(t)
+			assert.FatalError(t, l.Check(crt))
+			tt.modify(crt)
+			assert.Error(t, l.Check(crt))
+		})
+	}
+
+	// Names in the common name.
+	l, _ := lookup("w_subject_common_name_not_in_san")
+	ip := &x509.Certificate{Subject: pkix.Name{CommonName: "10.0.0.1"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}
+	assert.FatalError(t, l.Check(ip))
+	email := &x509.Certificate{Subject: pkix.Name{CommonName: "jane@smallstep.com"}, EmailAddresses: []string{"jane@smallstep.com"}}
+	assert.FatalError(t, l.Check(email))
+	u, err := url.Parse("spiffe://smallstep.com/jane")
+	assert.FatalError(t, err)
+	uri := &x509.Certificate{Subject: pkix.Name{CommonName: u.String()}, URIs: []*url.URL{u}}
+	assert.FatalError(t, l.Check(uri))
+}
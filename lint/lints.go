@@ -0,0 +1,253 @@
+package lint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxValidity is the maximum validity of the publicly trusted TLS
+// certificates, used by the w_sub_cert_validity_too_long lint.
+const maxValidity = 398 * 24 * time.Hour
+
+func init() {
+	for _, l := range []*Lint{
+		{
+			Name:        "e_sub_cert_cert_sign_bit_set",
+			Description: "Leaf certificates must not have the keyCertSign or cRLSign key usages",
+			Severity:    Error,
+			Check:       checkCertSign,
+		},
+		{
+			Name:        "e_rsa_mod_less_than_2048_bits",
+			Description: "RSA keys must be at least 2048 bits",
+			Severity:    Error,
+			Check:       checkRSAKeySize,
+		},
+		{
+			Name:        "e_ec_improper_curves",
+			Description: "EC keys must use the P-256, P-384 or P-521 curves",
+			Severity:    Error,
+			Check:       checkECCurve,
+		},
+		{
+			Name:        "e_validity_negative",
+			Description: "The notAfter of a certificate must be after its notBefore",
+			Severity:    Error,
+			Check:       checkValidity,
+		},
+		{
+			Name:        "e_serial_number_not_positive",
+			Description: "Serial numbers must be positive and at most 20 octets long",
+			Severity:    Error,
+			Check:       checkSerialNumber,
+		},
+		{
+			Name:        "e_subject_common_name_max_length",
+			Description: "The common name must be at most 64 characters long",
+			Severity:    Error,
+			Check:       checkCommonNameLength,
+		},
+		{
+			Name:        "e_dnsname_bad_character",
+			Description: "DNS names must contain only letters, digits, hyphens, underscores and a leftmost wildcard label",
+			Severity:    Error,
+			Check:       checkDNSNames,
+		},
+		{
+			Name:        "e_rfc822_name_invalid",
+			Description: "Email addresses must have a local part and a domain",
+			Severity:    Error,
+			Check:       checkEmailAddresses,
+		},
+		{
+			Name:        "e_ext_duplicate_extension",
+			Description: "A certificate must not contain the same extension more than once",
+			Severity:    Error,
+			Check:       checkDuplicateExtensions,
+		},
+		{
+			Name:        "w_ext_san_missing",
+			Description: "Certificates should contain at least one subject alternative name",
+			Severity:    Warning,
+			Check:       checkSANs,
+		},
+		{
+			Name:        "w_subject_common_name_not_in_san",
+			Description: "The common name should be one of the subject alternative names",
+			Severity:    Warning,
+			Check:       checkCommonNameInSANs,
+		},
+		{
+			Name:        "w_sub_cert_validity_too_long",
+			Description: "Leaf certificates should not be valid for more than 398 days",
+			Severity:    Warning,
+			Check:       checkMaxValidity,
+		},
+	} {
+		if err := Register(l); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func checkCertSign(crt *x509.Certificate) error {
+	if crt.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return errors.New("certificate has the keyCertSign or cRLSign key usage")
+	}
+	if crt.IsCA {
+		return errors.New("certificate is a CA")
+	}
+	return nil
+}
+
+func checkRSAKeySize(crt *x509.Certificate) error {
+	if k, ok := crt.PublicKey.(*rsa.PublicKey); ok && k.N.BitLen() < 2048 {
+		return errors.Errorf("rsa key has %d bits", k.N.BitLen())
+	}
+	return nil
+}
+
+func checkECCurve(crt *x509.Certificate) error {
+	if k, ok := crt.PublicKey.(*ecdsa.PublicKey); ok {
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return errors.Errorf("ec key uses the curve %s", k.Curve.Params().Name)
+		}
+	}
+	return nil
+}
+
+func checkValidity(crt *x509.Certificate) error {
+	if !crt.NotAfter.After(crt.NotBefore) {
+		return errors.Errorf("notAfter %s is not after notBefore %s", crt.NotAfter, crt.NotBefore)
+	}
+	return nil
+}
+
+func checkSerialNumber(crt *x509.Certificate) error {
+	switch {
+	case crt.SerialNumber == nil || crt.SerialNumber.Sign() <= 0:
+		return errors.New("serial number is not positive")
+	// The DER encoding adds a leading zero if the first bit is set.
+	case (crt.SerialNumber.BitLen()+8)/8 > 20:
+		return errors.New("serial number is longer than 20 octets")
+	default:
+		return nil
+	}
+}
+
+func checkCommonNameLength(crt *x509.Certificate) error {
+	if n := len([]rune(crt.Subject.CommonName)); n > 64 {
+		return errors.Errorf("common name has %d characters", n)
+	}
+	return nil
+}
+
+func checkDNSNames(crt *x509.Certificate) error {
+	for _, name := range crt.DNSNames {
+		if err := validateDNSName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateDNSName(name string) error {
+	if name == "" {
+		return errors.New("dns name is empty")
+	}
+	if len(name) > 253 {
+		return errors.Errorf("dns name %s is longer than 253 characters", name)
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i, label := range labels {
+		if label == "*" && i == 0 && len(labels) > 1 {
+			continue
+		}
+		if label == "" || len(label) > 63 {
+			return errors.Errorf("dns name %s has an invalid label", name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return errors.Errorf("dns name %s has a label that starts or ends with a hyphen", name)
+		}
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			default:
+				return errors.Errorf("dns name %s contains the invalid character %q", name, r)
+			}
+		}
+	}
+	return nil
+}
+
+func checkEmailAddresses(crt *x509.Certificate) error {
+	for _, email := range crt.EmailAddresses {
+		i := strings.LastIndex(email, "@")
+		if i <= 0 || i == len(email)-1 || strings.ContainsAny(email, " <>") {
+			return errors.Errorf("email address %s is not valid", email)
+		}
+	}
+	return nil
+}
+
+func checkDuplicateExtensions(crt *x509.Certificate) error {
+	seen := make(map[string]bool, len(crt.ExtraExtensions))
+	for _, ext := range crt.ExtraExtensions {
+		id := ext.Id.String()
+		if seen[id] {
+			return errors.Errorf("extension %s is duplicated", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func checkSANs(crt *x509.Certificate) error {
+	if len(crt.DNSNames) == 0 && len(crt.IPAddresses) == 0 && len(crt.EmailAddresses) == 0 && len(crt.URIs) == 0 {
+		return errors.New("certificate does not have subject alternative names")
+	}
+	return nil
+}
+
+func checkCommonNameInSANs(crt *x509.Certificate) error {
+	cn := crt.Subject.CommonName
+	if cn == "" {
+		return nil
+	}
+	for _, s := range crt.DNSNames {
+		if strings.EqualFold(s, cn) {
+			return nil
+		}
+	}
+	for _, ip := range crt.IPAddresses {
+		if ip.String() == cn {
+			return nil
+		}
+	}
+	for _, s := range crt.EmailAddresses {
+		if s == cn {
+			return nil
+		}
+	}
+	for _, u := range crt.URIs {
+		if u.String() == cn {
+			return nil
+		}
+	}
+	return errors.Errorf("common name %s is not a subject alternative name", cn)
+}
+
+func checkMaxValidity(crt *x509.Certificate) error {
+	if d := crt.NotAfter.Sub(crt.NotBefore); d > maxValidity {
+		return errors.Errorf("certificate is valid for %s", d)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 type LoggerHandler struct {
 	name   string
 	logger *logrus.Logger
+	filter *filter
 	next   http.Handler
 }
 
@@ -21,6 +22,7 @@ func NewLoggerHandler(name string, logger *Logger, next http.Handler) http.Handl
 	return h(&LoggerHandler{
 		name:   name,
 		logger: logger.GetImpl(),
+		filter: logger.filter,
 		next:   next,
 	})
 }
@@ -67,6 +69,10 @@ func (l *LoggerHandler) writeEntry(w ResponseLogger, r *http.Request, t time.Tim
 	}
 
 	status := w.StatusCode()
+	outcome := "success"
+	if status >= http.StatusBadRequest {
+		outcome = "failure"
+	}
 
 	fields := logrus.Fields{
 		"request-id":     reqID,
@@ -80,6 +86,7 @@ func (l *LoggerHandler) writeEntry(w ResponseLogger, r *http.Request, t time.Tim
 		"path":           uri,
 		"protocol":       r.Proto,
 		"status":         status,
+		"outcome":        outcome,
 		"size":           w.Size(),
 		"referer":        r.Referer(),
 		"user-agent":     r.UserAgent(),
@@ -88,6 +95,7 @@ func (l *LoggerHandler) writeEntry(w ResponseLogger, r *http.Request, t time.Tim
 	for k, v := range w.Fields() {
 		fields[k] = v
 	}
+	fields = l.filter.apply(fields)
 
 	switch {
 	case status < http.StatusBadRequest:
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"strings"
 
@@ -20,12 +21,18 @@ type Logger struct {
 	*logrus.Logger
 	name        string
 	traceHeader string
+	filter      *filter
+	closer      io.Closer
 }
 
-// loggerConfig represents the configuration options for the logger.
+// loggerConfig represents the configuration options for the logger. Fields is
+// the list of fields written in the request logs, all of them if empty.
 type loggerConfig struct {
-	Format      string `json:"format"`
-	TraceHeader string `json:"traceHeader"`
+	Format      string        `json:"format"`
+	TraceHeader string        `json:"traceHeader"`
+	Fields      []string      `json:"fields,omitempty"`
+	Redact      *redactConfig `json:"redact,omitempty"`
+	Output      *outputConfig `json:"output,omitempty"`
 }
 
 // New initializes the logger with the given options.
@@ -46,17 +53,33 @@ func New(name string, raw json.RawMessage) (*Logger, error) {
 		return nil, errors.Errorf("unsupported logger.format '%s'", config.Format)
 	}
 
+	out, closer, err := newOutput(config.Output)
+	if err != nil {
+		return nil, err
+	}
+
 	logger := &Logger{
 		Logger:      logrus.New(),
 		name:        name,
 		traceHeader: config.TraceHeader,
+		filter:      newFilter(config.Fields, config.Redact),
+		closer:      closer,
 	}
+	logger.Out = out
 	if formatter != nil {
 		logger.Formatter = formatter
 	}
 	return logger, nil
 }
 
+// Close closes the output of the logger if necessary.
+func (l *Logger) Close() error {
+	if l.closer != nil {
+		return l.closer.Close()
+	}
+	return nil
+}
+
 // GetImpl returns the real implementation of the logger.
 func (l *Logger) GetImpl() *logrus.Logger {
 	return l.Logger
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{"ok", `{}`, false},
+		{"ok/json", `{"format":"json"}`, false},
+		{"ok/stdout", `{"format":"json","output":{"type":"stdout"}}`, false},
+		{"ok/file", `{"format":"json","output":{"type":"file","path":"` + filepath.Join(dir, "ca.log") + `","maxSize":1,"maxBackups":2}}`, false},
+		{"fail/format", `{"format":"xml"}`, true},
+		{"fail/output", `{"output":{"type":"kafka"}}`, true},
+		{"fail/path", `{"output":{"type":"file"}}`, true},
+		{"fail/maxSize", `{"output":{"type":"file","path":"` + filepath.Join(dir, "ca.log") + `","maxSize":-1}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := New("ca", json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := l.Close(); err != nil {
+					t.Errorf("Logger.Close() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoggerHandler_fields(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]interface{}
+	}{
+		{"all", `{"format":"json"}`, map[string]interface{}{
+			"outcome": "success", "provisioner": "step-cli", "ott": "the-token",
+			"sans": []interface{}{"test.smallstep.com", "jane@smallstep.com"},
+		}},
+		{"redact", `{"format":"json","redact":{"fields":["ott"],"emails":true}}`, map[string]interface{}{
+			"outcome": "success", "provisioner": "step-cli", "ott": redactedValue,
+			"sans": []interface{}{"test.smallstep.com", redactedValue},
+		}},
+		{"fields", `{"format":"json","fields":["request-id","outcome","sans"]}`, map[string]interface{}{
+			"outcome": "success", "sans": []interface{}{"test.smallstep.com", "jane@smallstep.com"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := New("ca", json.RawMessage(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := new(bytes.Buffer)
+			l.Out = buf
+
+			h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.(ResponseLogger).WithFields(map[string]interface{}{
+					"provisioner": "step-cli",
+					"ott":         "the-token",
+					"sans":        []string{"test.smallstep.com", "jane@smallstep.com"},
+				})
+				w.WriteHeader(http.StatusCreated)
+			}))
+			req := httptest.NewRequest("POST", "/1.0/sign", nil)
+			req.Header.Set(defaultTraceIDHeader, "the-request-id")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("error unmarshalling %s: %v", buf.Bytes(), err)
+			}
+			if entry["request-id"] != "the-request-id" {
+				t.Errorf("request-id = %v, want the-request-id", entry["request-id"])
+			}
+			for k, v := range tt.want {
+				if !reflect.DeepEqual(entry[k], v) {
+					t.Errorf("%s = %v, want %v", k, entry[k], v)
+				}
+			}
+			if tt.name == "fields" {
+				for _, k := range []string{"provisioner", "ott", "path", "status"} {
+					if _, ok := entry[k]; ok {
+						t.Errorf("field %s is not expected", k)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ca.log")
+	f, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, s := range want {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Errorf("%s = %q, want %q", name, b, s)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 should not exist", path)
+	}
+}
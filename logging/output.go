@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Supported logger outputs.
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+)
+
+// outputConfig represents the destination of the logs. By default they are
+// written to stderr. A file output is rotated when it reaches MaxSize
+// megabytes, keeping up to MaxBackups old files. A syslog output connects to
+// the syslog daemon in Network and Address, or the local one if empty.
+type outputConfig struct {
+	Type       string `json:"type"`
+	Path       string `json:"path,omitempty"`
+	MaxSize    int    `json:"maxSize,omitempty"`
+	MaxBackups int    `json:"maxBackups,omitempty"`
+	Network    string `json:"network,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// newOutput returns the writer configured in o. The returned closer is nil if
+// the writer does not need to be closed.
+func newOutput(o *outputConfig) (io.Writer, io.Closer, error) {
+	if o == nil {
+		return os.Stderr, nil, nil
+	}
+	switch strings.ToLower(o.Type) {
+	case "", OutputStderr:
+		return os.Stderr, nil, nil
+	case OutputStdout:
+		return os.Stdout, nil, nil
+	case OutputFile:
+		switch {
+		case o.Path == "":
+			return nil, nil, errors.New("logger.output.path cannot be empty")
+		case o.MaxSize < 0:
+			return nil, nil, errors.New("logger.output.maxSize cannot be negative")
+		case o.MaxBackups < 0:
+			return nil, nil, errors.New("logger.output.maxBackups cannot be negative")
+		}
+		f, err := newRotatingFile(o.Path, int64(o.MaxSize)*1024*1024, o.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, f, nil
+	case OutputSyslog:
+		w, err := newSyslogWriter(o.Network, o.Address, o.Tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, w, nil
+	default:
+		return nil, nil, errors.Errorf("unsupported logger.output.type '%s'", o.Type)
+	}
+}
+
+// rotatingFile is an io.WriteCloser that appends to a file and rotates it
+// once it reaches maxSize bytes. The rotated files are renamed to path.1,
+// path.2, ... being path.1 the most recent one.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	size       int64
+	f          *os.File
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return errors.Wrapf(err, "error creating %s", filepath.Dir(r.path))
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "error opening %s", r.path)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error opening %s", r.path)
+	}
+	r.f, r.size = f, st.Size()
+	return nil
+}
+
+// Write writes the log entry in p, rotating the file first if the entry does
+// not fit in it. Entries are never split between files.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return errors.Wrapf(err, "error closing %s", r.path)
+	}
+	r.f = nil
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error removing %s", r.path)
+		}
+		return r.open()
+	}
+	// Rename path.n-1 to path.n, ..., path to path.1, the oldest is
+	// overwritten.
+	for i := r.maxBackups; i > 0; i-- {
+		src := r.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", r.path, i)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error rotating %s", src)
+		}
+	}
+	return r.open()
+}
+
+// Close closes the file.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
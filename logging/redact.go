@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redactedValue is the value written instead of the redacted ones.
+const redactedValue = "[REDACTED]"
+
+// redactConfig represents the fields that are not written as they are. The
+// values of the fields in Fields are replaced, and if Emails is set, so are
+// the email addresses in any field, e.g. the email SANs of a certificate.
+type redactConfig struct {
+	Fields []string `json:"fields,omitempty"`
+	Emails bool     `json:"emails,omitempty"`
+}
+
+// filter selects and redacts the fields of the log entries.
+type filter struct {
+	include map[string]bool
+	redact  map[string]bool
+	emails  bool
+}
+
+func newFilter(fields []string, r *redactConfig) *filter {
+	f := new(filter)
+	if len(fields) > 0 {
+		f.include = make(map[string]bool, len(fields))
+		for _, s := range fields {
+			f.include[s] = true
+		}
+	}
+	if r != nil {
+		f.redact = make(map[string]bool, len(r.Fields))
+		for _, s := range r.Fields {
+			f.redact[s] = true
+		}
+		f.emails = r.Emails
+	}
+	return f
+}
+
+// apply removes the fields that are not included and redacts the rest.
+func (f *filter) apply(fields logrus.Fields) logrus.Fields {
+	if f == nil {
+		return fields
+	}
+	for k, v := range fields {
+		switch {
+		case f.include != nil && !f.include[k]:
+			delete(fields, k)
+		case f.redact[k]:
+			fields[k] = redactedValue
+		case f.emails:
+			fields[k] = redactEmails(v)
+		}
+	}
+	return fields
+}
+
+// redactEmails replaces the email addresses in strings and lists of strings.
+func redactEmails(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if isEmail(v) {
+			return redactedValue
+		}
+	case []string:
+		var values []string
+		for i, s := range v {
+			if isEmail(s) {
+				if values == nil {
+					values = append([]string{}, v...)
+				}
+				values[i] = redactedValue
+			}
+		}
+		if values != nil {
+			return values
+		}
+	}
+	return v
+}
+
+// isEmail returns true if s looks like an email address, URIs with user
+// information are not considered emails.
+func isEmail(s string) bool {
+	i := strings.LastIndexByte(s, '@')
+	return i > 0 && i < len(s)-1 && !strings.ContainsAny(s, " /:")
+}
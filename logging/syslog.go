@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logging
+
+import (
+	"io"
+	"log/syslog"
+
+	"github.com/pkg/errors"
+)
+
+// newSyslogWriter connects to the syslog daemon in the given network and
+// address, each log entry is sent as one message.
+func newSyslogWriter(network, address, tag string) (io.WriteCloser, error) {
+	if tag == "" {
+		tag = "step-ca"
+	}
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "error connecting to syslog")
+	}
+	return w, nil
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package logging
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+func newSyslogWriter(network, address, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("logger.output syslog is not supported on this platform")
+}
@@ -0,0 +1,133 @@
+package monitoring
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smallstep/certificates/logging"
+)
+
+// CAMetrics is a prometheus.Collector with the counters and the latency
+// histograms of the operations of the CA, the HTTP requests and the database.
+// It is shared by the CA after a reload, so the counters are not reset.
+type CAMetrics struct {
+	operations        *prometheus.CounterVec
+	operationDuration *prometheus.HistogramVec
+	requestDuration   *prometheus.HistogramVec
+	dbDuration        *prometheus.HistogramVec
+}
+
+// NewCAMetrics creates a new CAMetrics.
+func NewCAMetrics() *CAMetrics {
+	return &CAMetrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "step_ca_operations_total",
+			Help: "Number of certificates signed, renewed, rekeyed and revoked.",
+		}, []string{"operation", "provisioner", "result"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "step_ca_operation_duration_seconds",
+			Help:    "Duration of the signatures, renewals, rekeys and revocations.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "provisioner"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "step_ca_http_request_duration_seconds",
+			Help:    "Duration of the HTTP requests by endpoint.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"endpoint", "method", "code"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "step_ca_db_operation_duration_seconds",
+			Help:    "Duration of the database operations by table.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation", "table"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *CAMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.operations.Describe(ch)
+	m.operationDuration.Describe(ch)
+	m.requestDuration.Describe(ch)
+	m.dbDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *CAMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.operations.Collect(ch)
+	m.operationDuration.Collect(ch)
+	m.requestDuration.Collect(ch)
+	m.dbDuration.Collect(ch)
+}
+
+// ObserveOperation records the result and the duration of an operation of the
+// authority, e.g. x509.sign or ssh.revoke.
+func (m *CAMetrics) ObserveOperation(operation, provisioner string, d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.operations.WithLabelValues(operation, provisioner, result).Inc()
+	m.operationDuration.WithLabelValues(operation, provisioner).Observe(d.Seconds())
+}
+
+// ObserveDB records the duration of a database operation.
+func (m *CAMetrics) ObserveDB(operation, table string, d time.Duration) {
+	m.dbDuration.WithLabelValues(operation, table).Observe(d.Seconds())
+}
+
+// Middleware is a chi middleware that records the duration of the requests
+// by route pattern, so the paths with parameters are a single endpoint. The
+// requests that do not match a route use the endpoint "other".
+func (m *CAMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := logging.NewResponseLogger(w)
+		next.ServeHTTP(rw, r)
+
+		endpoint := "other"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				endpoint = pattern
+			}
+		}
+		m.requestDuration.WithLabelValues(endpoint, r.Method, strconv.Itoa(rw.StatusCode())).Observe(time.Since(start).Seconds())
+	})
+}
+
+// certificateCollector is a prometheus.Collector that reports the validity
+// of the TLS certificate of the CA.
+type certificateCollector struct {
+	certificate func() *x509.Certificate
+	notBefore   *prometheus.Desc
+	notAfter    *prometheus.Desc
+}
+
+// NewCertificateCollector returns a prometheus.Collector that reports the
+// validity of the certificate returned by the given function, the TLS
+// certificate of the CA.
+func NewCertificateCollector(fn func() *x509.Certificate) prometheus.Collector {
+	return &certificateCollector{
+		certificate: fn,
+		notBefore: prometheus.NewDesc("step_ca_tls_certificate_not_before_timestamp_seconds",
+			"Unix time when the TLS certificate of the CA becomes valid.", nil, nil),
+		notAfter: prometheus.NewDesc("step_ca_tls_certificate_not_after_timestamp_seconds",
+			"Unix time when the TLS certificate of the CA expires.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.notBefore
+	ch <- c.notAfter
+}
+
+// Collect implements prometheus.Collector.
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	if crt := c.certificate(); crt != nil {
+		ch <- prometheus.MustNewConstMetric(c.notBefore, prometheus.GaugeValue, float64(crt.NotBefore.Unix()))
+		ch <- prometheus.MustNewConstMetric(c.notAfter, prometheus.GaugeValue, float64(crt.NotAfter.Unix()))
+	}
+}
@@ -0,0 +1,60 @@
+package monitoring
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestCAMetrics(t *testing.T) {
+	m := NewCAMetrics()
+	crt := &x509.Certificate{
+		NotBefore: time.Unix(1000, 0),
+		NotAfter:  time.Unix(2000, 0),
+	}
+	metrics, err := NewMetrics(m, NewCertificateCollector(func() *x509.Certificate { return crt }))
+	if err != nil {
+		t.Fatalf("NewMetrics() error = %v", err)
+	}
+
+	m.ObserveOperation("x509.sign", "jwk", time.Second, nil)
+	m.ObserveOperation("x509.sign", "jwk", time.Second, errors.New("force"))
+	m.ObserveDB("get", "x509_certs", time.Millisecond)
+
+	mux := chi.NewRouter()
+	mux.Use(m.Middleware)
+	mux.Get("/sign/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	for _, path := range []string{"/sign/1", "/sign/2", "/missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`step_ca_operations_total{operation="x509.sign",provisioner="jwk",result="success"} 1`,
+		`step_ca_operations_total{operation="x509.sign",provisioner="jwk",result="failure"} 1`,
+		`step_ca_operation_duration_seconds_count{operation="x509.sign",provisioner="jwk"} 2`,
+		`step_ca_db_operation_duration_seconds_count{operation="get",table="x509_certs"} 1`,
+		`step_ca_http_request_duration_seconds_count{code="201",endpoint="/sign/{id}",method="GET"} 2`,
+		`step_ca_http_request_duration_seconds_count{code="404",endpoint="other",method="GET"} 1`,
+		`step_ca_tls_certificate_not_before_timestamp_seconds 1000`,
+		`step_ca_tls_certificate_not_after_timestamp_seconds 2000`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
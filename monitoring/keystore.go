@@ -0,0 +1,76 @@
+package monitoring
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+var keyStoreLabels = []string{"provisioner", "type"}
+
+// keyStoreCollector is a prometheus.Collector that reports the status of the
+// keys used by the provisioners to validate tokens.
+type keyStoreCollector struct {
+	provisioners func() []provisioner.KeyStoreProvisioner
+	reloads      *prometheus.Desc
+	failures     *prometheus.Desc
+	lastReload   *prometheus.Desc
+	lastSuccess  *prometheus.Desc
+	keys         *prometheus.Desc
+	cacheAge     *prometheus.Desc
+}
+
+// NewKeyStoreCollector returns a prometheus.Collector that reports the
+// statistics of the keys used by the provisioners returned by the given
+// function.
+func NewKeyStoreCollector(fn func() []provisioner.KeyStoreProvisioner) prometheus.Collector {
+	return &keyStoreCollector{
+		provisioners: fn,
+		reloads: prometheus.NewDesc("step_ca_keystore_reloads_total",
+			"Total number of successful reloads of the provisioner keys.", keyStoreLabels, nil),
+		failures: prometheus.NewDesc("step_ca_keystore_reload_failures_total",
+			"Total number of failed reloads of the provisioner keys.", keyStoreLabels, nil),
+		lastReload: prometheus.NewDesc("step_ca_keystore_last_reload_timestamp_seconds",
+			"Unix time of the last successful reload of the provisioner keys.", keyStoreLabels, nil),
+		lastSuccess: prometheus.NewDesc("step_ca_keystore_last_reload_success",
+			"Whether the last reload of the provisioner keys was successful (1) or not (0).", keyStoreLabels, nil),
+		keys: prometheus.NewDesc("step_ca_keystore_keys",
+			"Number of keys available to validate tokens.", keyStoreLabels, nil),
+		cacheAge: prometheus.NewDesc("step_ca_keystore_cache_age_seconds",
+			"Seconds since the last successful reload of the provisioner keys.", keyStoreLabels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *keyStoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.reloads
+	ch <- c.failures
+	ch <- c.lastReload
+	ch <- c.lastSuccess
+	ch <- c.keys
+	ch <- c.cacheAge
+}
+
+// Collect implements prometheus.Collector.
+func (c *keyStoreCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, p := range c.provisioners() {
+		stats := p.GetKeyStoreStats()
+		labels := []string{p.GetName(), p.GetType().String()}
+		var success, lastReload, cacheAge float64
+		if stats.Err == nil {
+			success = 1
+		}
+		if !stats.LastReload.IsZero() {
+			lastReload = float64(stats.LastReload.Unix())
+			cacheAge = now.Sub(stats.LastReload).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(c.reloads, prometheus.CounterValue, float64(stats.Reloads), labels...)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(stats.Failures), labels...)
+		ch <- prometheus.MustNewConstMetric(c.lastReload, prometheus.GaugeValue, lastReload, labels...)
+		ch <- prometheus.MustNewConstMetric(c.lastSuccess, prometheus.GaugeValue, success, labels...)
+		ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(stats.Keys), labels...)
+		ch <- prometheus.MustNewConstMetric(c.cacheAge, prometheus.GaugeValue, cacheAge, labels...)
+	}
+}
@@ -0,0 +1,99 @@
+package monitoring
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+)
+
+type mockKeyStoreProvisioner struct {
+	provisioner.Interface
+	name  string
+	typ   provisioner.Type
+	stats provisioner.KeyStoreStats
+}
+
+func (m *mockKeyStoreProvisioner) GetName() string {
+	return m.name
+}
+
+func (m *mockKeyStoreProvisioner) GetType() provisioner.Type {
+	return m.typ
+}
+
+func (m *mockKeyStoreProvisioner) GetKeyStoreStats() provisioner.KeyStoreStats {
+	return m.stats
+}
+
+func TestKeyStoreCollector(t *testing.T) {
+	lastReload := time.Now().Add(-time.Minute).Truncate(time.Second)
+	provisioners := []provisioner.KeyStoreProvisioner{
+		&mockKeyStoreProvisioner{
+			name: "google", typ: provisioner.TypeOIDC,
+			stats: provisioner.KeyStoreStats{
+				URI:        "https://www.googleapis.com/oauth2/v3/certs",
+				Keys:       2,
+				Reloads:    3,
+				Failures:   1,
+				LastReload: lastReload,
+			},
+		},
+		&mockKeyStoreProvisioner{
+			name: "gcp", typ: provisioner.TypeGCP,
+			stats: provisioner.KeyStoreStats{
+				Failures: 2,
+				Err:      errors.New("force"),
+			},
+		},
+	}
+	metrics, err := NewMetrics(NewKeyStoreCollector(func() []provisioner.KeyStoreProvisioner {
+		return provisioners
+	}))
+	if err != nil {
+		t.Fatalf("NewMetrics() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`step_ca_keystore_reloads_total{provisioner="google",type="OIDC"} 3`,
+		`step_ca_keystore_reload_failures_total{provisioner="google",type="OIDC"} 1`,
+		`step_ca_keystore_last_reload_timestamp_seconds{provisioner="google",type="OIDC"} ` + strconv.FormatFloat(float64(lastReload.Unix()), 'g', -1, 64),
+		`step_ca_keystore_last_reload_success{provisioner="google",type="OIDC"} 1`,
+		`step_ca_keystore_keys{provisioner="google",type="OIDC"} 2`,
+		`step_ca_keystore_reloads_total{provisioner="gcp",type="GCP"} 0`,
+		`step_ca_keystore_reload_failures_total{provisioner="gcp",type="GCP"} 2`,
+		`step_ca_keystore_last_reload_timestamp_seconds{provisioner="gcp",type="GCP"} 0`,
+		`step_ca_keystore_last_reload_success{provisioner="gcp",type="GCP"} 0`,
+		`step_ca_keystore_keys{provisioner="gcp",type="GCP"} 0`,
+		`step_ca_keystore_cache_age_seconds{provisioner="gcp",type="GCP"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+
+	// The cache age is the time since the last reload.
+	prefix := `step_ca_keystore_cache_age_seconds{provisioner="google",type="OIDC"} `
+	var cacheAge float64
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			if cacheAge, err = strconv.ParseFloat(strings.TrimPrefix(line, prefix), 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if cacheAge < 60 || cacheAge > 120 {
+		t.Errorf("cache age = %v, want between 60 and 120", cacheAge)
+	}
+}
@@ -0,0 +1,32 @@
+package monitoring
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics is the type holding the registry with the metrics exposed by the CA
+// in the Prometheus format.
+type Metrics struct {
+	registry *prometheus.Registry
+}
+
+// NewMetrics creates a new Metrics with the given collectors.
+func NewMetrics(collectors ...prometheus.Collector) (*Metrics, error) {
+	registry := prometheus.NewRegistry()
+	for _, c := range collectors {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return &Metrics{
+		registry: registry,
+	}, nil
+}
+
+// Handler returns the HTTP handler that serves the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
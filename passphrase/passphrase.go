@@ -0,0 +1,240 @@
+// Package passphrase implements the providers used to retrieve the password
+// of the intermediate key at startup, so it does not need to be stored in
+// plaintext in the configuration or in a password file.
+package passphrase
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+// Type is the type of a password provider.
+type Type string
+
+const (
+	// File reads the password from a file.
+	File Type = "file"
+	// Env reads the password from an environment variable.
+	Env Type = "env"
+	// KMS decrypts a file containing the encrypted password with a key in a
+	// KMS, e.g. Google's Cloud KMS or HashiCorp Vault Transit.
+	KMS Type = "kms"
+	// Keychain reads the password from the macOS keychain or from the Secret
+	// Service on Linux.
+	Keychain Type = "keychain"
+	// Socket waits until an operator sends the password to a unix socket, e.g.
+	// using `step-ca unlock`.
+	Socket Type = "socket"
+)
+
+// Config is the configuration of a password provider.
+type Config struct {
+	// Type is the type of provider: file, env, kms, keychain or socket.
+	Type Type `json:"type"`
+
+	// Path is the path of the password file with the file provider, the path
+	// of the file with the ciphertext with the kms provider, and the path of
+	// the unix socket with the socket provider.
+	Path string `json:"path,omitempty"`
+
+	// Variable is the name of the environment variable with the env provider.
+	Variable string `json:"variable,omitempty"`
+
+	// KMS are the options of the KMS used to decrypt the ciphertext with the
+	// kms provider, and Key is the name of the key in that KMS.
+	KMS *apiv1.Options `json:"kms,omitempty"`
+	Key string         `json:"key,omitempty"`
+
+	// Service and Account identify the item with the keychain provider.
+	Service string `json:"service,omitempty"`
+	Account string `json:"account,omitempty"`
+}
+
+// Validate validates the password provider configuration, nil is ok.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Type {
+	case File, Socket:
+		if c.Path == "" {
+			return errors.Errorf("passwordProvider path is required with type %s", c.Type)
+		}
+	case Env:
+		if c.Variable == "" {
+			return errors.New("passwordProvider variable is required with type env")
+		}
+	case KMS:
+		switch {
+		case c.Path == "":
+			return errors.New("passwordProvider path is required with type kms")
+		case c.Key == "":
+			return errors.New("passwordProvider key is required with type kms")
+		case c.KMS == nil:
+			return errors.New("passwordProvider kms is required with type kms")
+		}
+		return c.KMS.Validate()
+	case Keychain:
+		if c.Service == "" {
+			return errors.New("passwordProvider service is required with type keychain")
+		}
+	case "":
+		return errors.New("passwordProvider type cannot be empty")
+	default:
+		return errors.Errorf("unsupported passwordProvider type %s", c.Type)
+	}
+	return nil
+}
+
+// CheckFunc validates a password, e.g. decrypting the intermediate key.
+type CheckFunc func(password []byte) error
+
+// Provider is the interface implemented by the password providers.
+type Provider interface {
+	// Password returns the password, if check is not nil the password is only
+	// returned if check succeeds.
+	Password(ctx context.Context, check CheckFunc) ([]byte, error)
+}
+
+// New returns the password provider for the given configuration.
+func New(c *Config) (Provider, error) {
+	if c == nil {
+		return nil, errors.New("passwordProvider cannot be empty")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case File:
+		return &fileProvider{path: c.Path}, nil
+	case Env:
+		return &envProvider{variable: c.Variable}, nil
+	case KMS:
+		return &kmsProvider{path: c.Path, key: c.Key, options: *c.KMS}, nil
+	case Keychain:
+		return &keychainProvider{service: c.Service, account: c.Account}, nil
+	default:
+		return &socketProvider{path: c.Path}, nil
+	}
+}
+
+func checkPassword(password []byte, check CheckFunc) ([]byte, error) {
+	if check != nil {
+		if err := check(password); err != nil {
+			return nil, err
+		}
+	}
+	return password, nil
+}
+
+func trimSpace(b []byte) []byte {
+	return bytes.TrimRightFunc(b, unicode.IsSpace)
+}
+
+type fileProvider struct {
+	path string
+}
+
+func (p *fileProvider) Password(ctx context.Context, check CheckFunc) ([]byte, error) {
+	b, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", p.path)
+	}
+	return checkPassword(trimSpace(b), check)
+}
+
+type envProvider struct {
+	variable string
+}
+
+func (p *envProvider) Password(ctx context.Context, check CheckFunc) ([]byte, error) {
+	v, ok := os.LookupEnv(p.variable)
+	if !ok {
+		return nil, errors.Errorf("environment variable %s is not set", p.variable)
+	}
+	return checkPassword([]byte(v), check)
+}
+
+// newKMS is the function used to initialize the KMS, it can be replaced in
+// tests.
+var newKMS = kms.New
+
+type kmsProvider struct {
+	path    string
+	key     string
+	options apiv1.Options
+}
+
+func (p *kmsProvider) Password(ctx context.Context, check CheckFunc) ([]byte, error) {
+	ciphertext, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", p.path)
+	}
+	km, err := newKMS(ctx, p.options)
+	if err != nil {
+		return nil, err
+	}
+	defer km.Close()
+
+	decrypter, ok := km.(kms.Decrypter)
+	if !ok {
+		return nil, errors.Errorf("kms %s does not support decryption", p.options.Type)
+	}
+	password, err := decrypter.Decrypt(&apiv1.DecryptRequest{
+		Name:       p.key,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkPassword(trimSpace(password), check)
+}
+
+// execCommand runs the command and returns its output, it can be replaced in
+// tests.
+var execCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+type keychainProvider struct {
+	service string
+	account string
+}
+
+func (p *keychainProvider) Password(ctx context.Context, check CheckFunc) ([]byte, error) {
+	var name string
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		name, args = "security", []string{"find-generic-password", "-w", "-s", p.service}
+		if p.account != "" {
+			args = append(args, "-a", p.account)
+		}
+	case "linux":
+		name, args = "secret-tool", []string{"lookup", "service", p.service}
+		if p.account != "" {
+			args = append(args, "account", p.account)
+		}
+	default:
+		return nil, errors.Errorf("keychain is not supported on %s", runtime.GOOS)
+	}
+
+	out, err := execCommand(ctx, name, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the password from the keychain using %s", name)
+	}
+	password := trimSpace(out)
+	if len(password) == 0 {
+		return nil, errors.Errorf("keychain item %s not found", p.service)
+	}
+	return checkPassword(password, check)
+}
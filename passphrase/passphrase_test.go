@@ -0,0 +1,228 @@
+package passphrase
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/kms"
+	"github.com/smallstep/certificates/kms/apiv1"
+)
+
+type decrypterKMS struct {
+	kms.KeyManager
+	decrypt func(req *apiv1.DecryptRequest) ([]byte, error)
+}
+
+func (m *decrypterKMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	return m.decrypt(req)
+}
+
+func (m *decrypterKMS) Close() error {
+	return nil
+}
+
+func checkEquals(want string) CheckFunc {
+	return func(password []byte) error {
+		if string(password) != want {
+			return errors.New("invalid password")
+		}
+		return nil
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{"ok nil", nil, false},
+		{"ok file", &Config{Type: File, Path: "password.txt"}, false},
+		{"ok env", &Config{Type: Env, Variable: "STEP_PASSWORD"}, false},
+		{"ok kms", &Config{Type: KMS, Path: "password.enc", Key: "vaultkms:name=aes", KMS: &apiv1.Options{Type: "vaultkms", Address: "https://vault:8200"}}, false},
+		{"ok keychain", &Config{Type: Keychain, Service: "step-ca"}, false},
+		{"ok socket", &Config{Type: Socket, Path: "/run/step-ca/unlock.sock"}, false},
+		{"fail empty", &Config{}, true},
+		{"fail type", &Config{Type: "foo"}, true},
+		{"fail file", &Config{Type: File}, true},
+		{"fail env", &Config{Type: Env}, true},
+		{"fail kms path", &Config{Type: KMS, Key: "key", KMS: &apiv1.Options{Type: "cloudkms"}}, true},
+		{"fail kms key", &Config{Type: KMS, Path: "password.enc", KMS: &apiv1.Options{Type: "cloudkms"}}, true},
+		{"fail kms options", &Config{Type: KMS, Path: "password.enc", Key: "key"}, true},
+		{"fail kms type", &Config{Type: KMS, Path: "password.enc", Key: "key", KMS: &apiv1.Options{Type: "foo"}}, true},
+		{"fail keychain", &Config{Type: Keychain}, true},
+		{"fail socket", &Config{Type: Socket}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvider_Password(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passwordFile := filepath.Join(dir, "password.txt")
+	if err := ioutil.WriteFile(passwordFile, []byte("password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ciphertextFile := filepath.Join(dir, "password.enc")
+	if err := ioutil.WriteFile(ciphertextFile, []byte("ciphertext"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("STEP_TEST_PASSWORD", "password")
+	defer os.Unsetenv("STEP_TEST_PASSWORD")
+
+	tmpNewKMS := newKMS
+	defer func() { newKMS = tmpNewKMS }()
+	newKMS = func(ctx context.Context, opts apiv1.Options) (kms.KeyManager, error) {
+		switch opts.Type {
+		case "cloudkms":
+			return &decrypterKMS{decrypt: func(req *apiv1.DecryptRequest) ([]byte, error) {
+				if req.Name != "my-key" || string(req.Ciphertext) != "ciphertext" {
+					return nil, errors.New("decrypt failed")
+				}
+				return []byte("password\n"), nil
+			}}, nil
+		default:
+			return nil, errors.New("new failed")
+		}
+	}
+
+	tmpExecCommand := execCommand
+	defer func() { execCommand = tmpExecCommand }()
+	execCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if args[len(args)-1] == "missing" {
+			return nil, errors.New("exit status 1")
+		}
+		return []byte("password\n"), nil
+	}
+	keychainErr := runtime.GOOS != "darwin" && runtime.GOOS != "linux"
+
+	tests := []struct {
+		name    string
+		config  *Config
+		check   CheckFunc
+		want    []byte
+		wantErr bool
+	}{
+		{"ok file", &Config{Type: File, Path: passwordFile}, nil, []byte("password"), false},
+		{"ok file check", &Config{Type: File, Path: passwordFile}, checkEquals("password"), []byte("password"), false},
+		{"ok env", &Config{Type: Env, Variable: "STEP_TEST_PASSWORD"}, checkEquals("password"), []byte("password"), false},
+		{"ok kms", &Config{Type: KMS, Path: ciphertextFile, Key: "my-key", KMS: &apiv1.Options{Type: "cloudkms"}}, checkEquals("password"), []byte("password"), false},
+		{"ok keychain", &Config{Type: Keychain, Service: "step-ca", Account: "intermediate"}, checkEquals("password"), []byte("password"), keychainErr},
+		{"fail file", &Config{Type: File, Path: filepath.Join(dir, "missing")}, nil, nil, true},
+		{"fail file check", &Config{Type: File, Path: passwordFile}, checkEquals("other"), nil, true},
+		{"fail env", &Config{Type: Env, Variable: "STEP_TEST_MISSING"}, nil, nil, true},
+		{"fail kms file", &Config{Type: KMS, Path: filepath.Join(dir, "missing"), Key: "my-key", KMS: &apiv1.Options{Type: "cloudkms"}}, nil, nil, true},
+		{"fail kms new", &Config{Type: KMS, Path: ciphertextFile, Key: "my-key", KMS: &apiv1.Options{Type: "vaultkms", Address: "https://vault:8200"}}, nil, nil, true},
+		{"fail kms decrypt", &Config{Type: KMS, Path: ciphertextFile, Key: "other", KMS: &apiv1.Options{Type: "cloudkms"}}, nil, nil, true},
+		{"fail keychain", &Config{Type: Keychain, Service: "step-ca", Account: "missing"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.config)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			got, err := p.Password(context.Background(), tt.check)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Provider.Password() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Provider.Password() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketProvider_Password(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "unlock.sock")
+
+	p, err := New(&Config{Type: Socket, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		password []byte
+		err      error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		password, err := p.Password(context.Background(), checkEquals("password"))
+		ch <- result{password, err}
+	}()
+
+	// Wait for the socket.
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	if err := Send(path, []byte("other")); err == nil || err.Error() != "invalid password" {
+		t.Errorf("Send() error = %v, want invalid password", err)
+	}
+	if err := Send(path, []byte("password")); err != nil {
+		t.Errorf("Send() error = %v", err)
+	}
+	select {
+	case r := <-ch:
+		if r.err != nil || string(r.password) != "password" {
+			t.Errorf("socketProvider.Password() = %s, %v", r.password, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("socketProvider.Password() timed out")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket %s was not removed", path)
+	}
+	if err := Send(path, []byte("password")); err == nil {
+		t.Error("Send() error = nil, wantErr true")
+	}
+
+	// Cancel the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		password, err := p.Password(ctx, nil)
+		ch <- result{password, err}
+	}()
+	cancel()
+	select {
+	case r := <-ch:
+		if r.err == nil {
+			t.Error("socketProvider.Password() error = nil, wantErr true")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("socketProvider.Password() timed out")
+	}
+}
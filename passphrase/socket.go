@@ -0,0 +1,110 @@
+package passphrase
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// socketTimeout is the time a client has to send the password once it is
+// connected.
+const socketTimeout = 30 * time.Second
+
+// socketProvider listens on a unix socket until a client sends a valid
+// password. The socket is only accessible by the user running the CA, and it
+// is removed once the password is received.
+type socketProvider struct {
+	path string
+}
+
+func (p *socketProvider) Password(ctx context.Context, check CheckFunc) ([]byte, error) {
+	// Remove a socket left by a previous run.
+	if fi, err := os.Lstat(p.path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(p.path)
+	}
+
+	ln, err := net.Listen("unix", p.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listening on %s", p.path)
+	}
+	defer ln.Close()
+	if err := os.Chmod(p.path, 0600); err != nil {
+		return nil, errors.Wrapf(err, "error changing permissions of %s", p.path)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, errors.Wrapf(err, "error accepting connection on %s", p.path)
+		}
+		if password, ok := p.handle(conn, check); ok {
+			return password, nil
+		}
+	}
+}
+
+// handle reads a password from the connection and replies with the result of
+// the check.
+func (p *socketProvider) handle(conn net.Conn, check CheckFunc) ([]byte, bool) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, false
+	}
+	password, err := checkPassword(trimSpace(line), check)
+	if err != nil {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return nil, false
+	}
+	fmt.Fprintln(conn, "ok")
+	return password, true
+}
+
+// Send sends the password to the socket provider listening on the given path
+// and returns an error if the password was not accepted.
+func Send(path string, password []byte) error {
+	conn, err := net.DialTimeout("unix", path, socketTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to %s", path)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	if _, err := conn.Write(append(append([]byte{}, password...), '\n')); err != nil {
+		return errors.Wrapf(err, "error writing to %s", path)
+	}
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return errors.Wrapf(err, "error reading from %s", path)
+	}
+	resp = strings.TrimSuffix(resp, "\n")
+	switch {
+	case resp == "ok":
+		return nil
+	case strings.HasPrefix(resp, "error: "):
+		return errors.New(strings.TrimPrefix(resp, "error: "))
+	default:
+		return errors.Errorf("unexpected response %q", resp)
+	}
+}
@@ -0,0 +1,315 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	oidEncryptionDESCBC     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
+	oidEncryptionDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidEncryptionAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidEncryptionAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidEncryptionAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// EncryptionAlgorithm is the content encryption algorithm of an enveloped
+// data message.
+type EncryptionAlgorithm int
+
+const (
+	// EncryptionAlgorithmDESCBC is DES in CBC mode. It is only supported to
+	// decrypt messages from legacy clients.
+	EncryptionAlgorithmDESCBC EncryptionAlgorithm = iota + 1
+	// EncryptionAlgorithmDESEDE3CBC is Triple DES in CBC mode.
+	EncryptionAlgorithmDESEDE3CBC
+	// EncryptionAlgorithmAES128CBC is AES-128 in CBC mode.
+	EncryptionAlgorithmAES128CBC
+	// EncryptionAlgorithmAES192CBC is AES-192 in CBC mode.
+	EncryptionAlgorithmAES192CBC
+	// EncryptionAlgorithmAES256CBC is AES-256 in CBC mode.
+	EncryptionAlgorithmAES256CBC
+)
+
+// String returns the name of the encryption algorithm.
+func (a EncryptionAlgorithm) String() string {
+	switch a {
+	case EncryptionAlgorithmDESCBC:
+		return "DES-CBC"
+	case EncryptionAlgorithmDESEDE3CBC:
+		return "DES-EDE3-CBC"
+	case EncryptionAlgorithmAES128CBC:
+		return "AES-128-CBC"
+	case EncryptionAlgorithmAES192CBC:
+		return "AES-192-CBC"
+	case EncryptionAlgorithmAES256CBC:
+		return "AES-256-CBC"
+	default:
+		return "unknown"
+	}
+}
+
+func (a EncryptionAlgorithm) oid() asn1.ObjectIdentifier {
+	switch a {
+	case EncryptionAlgorithmDESCBC:
+		return oidEncryptionDESCBC
+	case EncryptionAlgorithmDESEDE3CBC:
+		return oidEncryptionDESEDE3CBC
+	case EncryptionAlgorithmAES128CBC:
+		return oidEncryptionAES128CBC
+	case EncryptionAlgorithmAES192CBC:
+		return oidEncryptionAES192CBC
+	case EncryptionAlgorithmAES256CBC:
+		return oidEncryptionAES256CBC
+	default:
+		return nil
+	}
+}
+
+// keySize returns the size of the key in bytes.
+func (a EncryptionAlgorithm) keySize() int {
+	switch a {
+	case EncryptionAlgorithmDESCBC:
+		return 8
+	case EncryptionAlgorithmDESEDE3CBC, EncryptionAlgorithmAES192CBC:
+		return 24
+	case EncryptionAlgorithmAES128CBC:
+		return 16
+	case EncryptionAlgorithmAES256CBC:
+		return 32
+	default:
+		return 0
+	}
+}
+
+func (a EncryptionAlgorithm) newCipher(key []byte) (cipher.Block, error) {
+	switch a {
+	case EncryptionAlgorithmDESCBC:
+		return des.NewCipher(key)
+	case EncryptionAlgorithmDESEDE3CBC:
+		return des.NewTripleDESCipher(key)
+	case EncryptionAlgorithmAES128CBC, EncryptionAlgorithmAES192CBC, EncryptionAlgorithmAES256CBC:
+		return aes.NewCipher(key)
+	default:
+		return nil, errors.Errorf("unsupported encryption algorithm %d", a)
+	}
+}
+
+func getEncryptionAlgorithm(oid asn1.ObjectIdentifier) (EncryptionAlgorithm, error) {
+	for _, a := range []EncryptionAlgorithm{
+		EncryptionAlgorithmDESCBC, EncryptionAlgorithmDESEDE3CBC,
+		EncryptionAlgorithmAES128CBC, EncryptionAlgorithmAES192CBC, EncryptionAlgorithmAES256CBC,
+	} {
+		if a.oid().Equal(oid) {
+			return a, nil
+		}
+	}
+	return 0, errors.Errorf("unsupported encryption algorithm %s", oid)
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// EnvelopedData is a parsed PKCS #7 enveloped data message.
+type EnvelopedData struct {
+	algorithm  EncryptionAlgorithm
+	iv         []byte
+	content    []byte
+	recipients []recipientInfo
+}
+
+// ParseEnvelopedData parses a DER encoded PKCS #7 content info with an
+// enveloped data message.
+func ParseEnvelopedData(der []byte) (*EnvelopedData, error) {
+	b, err := parseContentInfo(der, oidEnvelopedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(b, &ed); err != nil {
+		return nil, errors.Wrap(err, "error parsing enveloped data")
+	}
+	if len(ed.RecipientInfos) == 0 {
+		return nil, errors.New("enveloped data does not have any recipient")
+	}
+
+	eci := ed.EncryptedContentInfo
+	alg, err := getEncryptionAlgorithm(eci.ContentEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.Wrap(err, "error parsing enveloped data iv")
+	}
+	content, err := parseOctetString(eci.EncryptedContent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvelopedData{
+		algorithm:  alg,
+		iv:         iv,
+		content:    content,
+		recipients: ed.RecipientInfos,
+	}, nil
+}
+
+// EncryptionAlgorithm returns the content encryption algorithm used in the
+// message.
+func (ed *EnvelopedData) EncryptionAlgorithm() EncryptionAlgorithm {
+	return ed.algorithm
+}
+
+// Decrypt decrypts the content of the message using the given certificate and
+// its RSA key.
+func (ed *EnvelopedData) Decrypt(cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ri *recipientInfo
+	for i := range ed.recipients {
+		r := &ed.recipients[i]
+		if bytes.Equal(cert.RawIssuer, r.IssuerAndSerialNumber.IssuerName.FullBytes) && cert.SerialNumber.Cmp(r.IssuerAndSerialNumber.SerialNumber) == 0 {
+			ri = r
+			break
+		}
+	}
+	if ri == nil {
+		return nil, errors.New("enveloped data is not encrypted for the given certificate")
+	}
+	if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidEncryptionRSA) {
+		return nil, errors.Errorf("unsupported key encryption algorithm %s", ri.KeyEncryptionAlgorithm.Algorithm)
+	}
+
+	// A nil options uses RSA PKCS #1 v1.5.
+	cek, err := key.Decrypt(rand.Reader, ri.EncryptedKey, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting enveloped data key")
+	}
+	if len(cek) != ed.algorithm.keySize() {
+		return nil, errors.New("error decrypting enveloped data key: invalid key size")
+	}
+
+	block, err := ed.algorithm.newCipher(cek)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting enveloped data")
+	}
+	bs := block.BlockSize()
+	if len(ed.iv) != bs {
+		return nil, errors.New("error decrypting enveloped data: invalid iv size")
+	}
+	if len(ed.content) == 0 || len(ed.content)%bs != 0 {
+		return nil, errors.New("error decrypting enveloped data: invalid content size")
+	}
+	plaintext := make([]byte, len(ed.content))
+	cipher.NewCBCDecrypter(block, ed.iv).CryptBlocks(plaintext, ed.content)
+
+	// Remove PKCS #7 padding.
+	n := int(plaintext[len(plaintext)-1])
+	if n == 0 || n > bs {
+		return nil, errors.New("error decrypting enveloped data: invalid padding")
+	}
+	for _, b := range plaintext[len(plaintext)-n:] {
+		if int(b) != n {
+			return nil, errors.New("error decrypting enveloped data: invalid padding")
+		}
+	}
+	return plaintext[:len(plaintext)-n], nil
+}
+
+// Encrypt creates a DER encoded enveloped data message with the given content
+// encrypted for the given recipients. The recipients must have an RSA key.
+func Encrypt(content []byte, recipients []*x509.Certificate, alg EncryptionAlgorithm) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("recipients cannot be empty")
+	}
+
+	cek := make([]byte, alg.keySize())
+	if _, err := rand.Read(cek); err != nil {
+		return nil, errors.Wrap(err, "error generating encryption key")
+	}
+	block, err := alg.newCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+	iv := make([]byte, bs)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, errors.Wrap(err, "error generating encryption iv")
+	}
+
+	n := bs - len(content)%bs
+	ciphertext := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	ris := make([]recipientInfo, len(recipients))
+	for i, crt := range recipients {
+		pub, ok := crt.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("unsupported recipient key type %T", crt.PublicKey)
+		}
+		key, err := rsa.EncryptPKCS1v15(rand.Reader, pub, cek)
+		if err != nil {
+			return nil, errors.Wrap(err, "error encrypting enveloped data key")
+		}
+		ris[i] = recipientInfo{
+			Version: 0,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: crt.RawIssuer},
+				SerialNumber: crt.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA, Parameters: asn1.NullRawValue},
+			EncryptedKey:           key,
+		}
+	}
+
+	params, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling enveloped data iv")
+	}
+	b, err := asn1.Marshal(envelopedData{
+		Version:        0,
+		RecipientInfos: ris,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  alg.oid(),
+				Parameters: asn1.RawValue{FullBytes: params},
+			},
+			EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling enveloped data")
+	}
+	b, err = asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     wrapExplicit(b),
+	})
+	return b, errors.Wrap(err, "error marshaling content info")
+}
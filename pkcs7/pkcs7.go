@@ -0,0 +1,483 @@
+// Package pkcs7 implements the subset of the PKCS #7 Cryptographic Message
+// Syntax (RFC 2315 and RFC 5652) used by the certificate enrollment protocols
+// supported by the CA: signed data with signed attributes, enveloped data
+// using RSA key transport, and the degenerate "certs-only" signed data.
+//
+// Only DER encoded messages are supported.
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"sort"
+	"time"
+
+	// Register hash functions used in the digest algorithms.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidDigestSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidEncryptionRSA            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSignatureSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSignatureSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSignatureSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidSignatureECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignatureECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSignatureECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// Attribute is a signed attribute added to a signed data message. The value
+// is encoded using encoding/asn1.
+type Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value interface{}
+}
+
+// SignedData is a parsed PKCS #7 signed data message.
+type SignedData struct {
+	// Content is the encapsulated content, it will be nil if the message does
+	// not include it.
+	Content []byte
+	// Certificates is the list of certificates included in the message.
+	Certificates []*x509.Certificate
+	signers      []signerInfo
+	attributes   []attribute
+}
+
+// wrapExplicit returns the given DER encoded value wrapped in an explicit
+// context-specific tag 0.
+func wrapExplicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// parseContentInfo parses a content info structure and returns the inner
+// content if the type matches the given one.
+func parseContentInfo(der []byte, typ asn1.ObjectIdentifier) ([]byte, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.Wrap(err, "error parsing content info")
+	} else if len(rest) > 0 {
+		return nil, errors.New("error parsing content info: trailing data")
+	}
+	if !ci.ContentType.Equal(typ) {
+		return nil, errors.Errorf("unexpected content type %s", ci.ContentType)
+	}
+	if len(ci.Content.Bytes) == 0 {
+		return nil, errors.New("error parsing content info: content is empty")
+	}
+	return ci.Content.Bytes, nil
+}
+
+// parseOctetString returns the content of a primitive or constructed octet
+// string.
+func parseOctetString(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.Bytes, nil
+	}
+	var b []byte
+	rest := v.Bytes
+	for len(rest) > 0 {
+		var chunk []byte
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &chunk); err != nil {
+			return nil, errors.Wrap(err, "error parsing octet string")
+		}
+		b = append(b, chunk...)
+	}
+	return b, nil
+}
+
+// ParseSignedData parses a DER encoded PKCS #7 content info with a signed data
+// message. It does not verify the signatures, use Verify for that.
+func ParseSignedData(der []byte) (*SignedData, error) {
+	b, err := parseContentInfo(der, oidSignedData)
+	if err != nil {
+		return nil, err
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(b, &sd); err != nil {
+		return nil, errors.Wrap(err, "error parsing signed data")
+	}
+
+	msg := &SignedData{
+		signers: sd.SignerInfos,
+	}
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		var v asn1.RawValue
+		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &v); err != nil {
+			return nil, errors.Wrap(err, "error parsing signed data content")
+		}
+		if msg.Content, err = parseOctetString(v); err != nil {
+			return nil, err
+		}
+	}
+	if len(sd.Certificates.Bytes) > 0 {
+		if msg.Certificates, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, errors.Wrap(err, "error parsing signed data certificates")
+		}
+	}
+	if len(sd.SignerInfos) > 0 {
+		if msg.attributes, err = parseAttributes(sd.SignerInfos[0].AuthenticatedAttributes.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// ParseCertificates returns the certificates in a DER encoded signed data
+// message, usually a degenerate one.
+func ParseCertificates(der []byte) ([]*x509.Certificate, error) {
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	return sd.Certificates, nil
+}
+
+func parseAttributes(b []byte) ([]attribute, error) {
+	var attrs []attribute
+	for len(b) > 0 {
+		var attr attribute
+		var err error
+		if b, err = asn1.Unmarshal(b, &attr); err != nil {
+			return nil, errors.Wrap(err, "error parsing signed attributes")
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// GetAttribute unmarshals the first value of the signed attribute with the
+// given type into v. It returns an error if the attribute is not present.
+func (sd *SignedData) GetAttribute(typ asn1.ObjectIdentifier, v interface{}) error {
+	for _, attr := range sd.attributes {
+		if attr.Type.Equal(typ) {
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, v); err != nil {
+				return errors.Wrapf(err, "error parsing attribute %s", typ)
+			}
+			return nil
+		}
+	}
+	return errors.Errorf("attribute %s not found", typ)
+}
+
+// Verify verifies the signature of the first signer of the message and
+// returns the certificate of the signer. The signer certificate must be one
+// of the certificates in the message. Verify does not validate the chain of
+// the signer certificate.
+func (sd *SignedData) Verify() (*x509.Certificate, error) {
+	if len(sd.signers) == 0 {
+		return nil, errors.New("signed data does not have any signer")
+	}
+	si := sd.signers[0]
+
+	var cert *x509.Certificate
+	for _, c := range sd.Certificates {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.IssuerName.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("signed data does not contain the signer certificate")
+	}
+
+	hash, err := getHash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without signed attributes the signature is over the content.
+	signed := sd.Content
+	if len(si.AuthenticatedAttributes.Bytes) > 0 {
+		var contentType asn1.ObjectIdentifier
+		if err := sd.GetAttribute(oidAttributeContentType, &contentType); err != nil {
+			return nil, err
+		}
+		var digest []byte
+		if err := sd.GetAttribute(oidAttributeMessageDigest, &digest); err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(sd.Content)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return nil, errors.New("signed data message digest does not match")
+		}
+		// The signature is over the DER encoding of the SET OF attributes.
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	if err := verifySignature(cert.PublicKey, hash, h.Sum(nil), si.EncryptedDigest); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func getHash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1), oid.Equal(oidSignatureSHA1WithRSA):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256), oid.Equal(oidSignatureSHA256WithRSA):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384), oid.Equal(oidSignatureSHA384WithRSA):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512), oid.Equal(oidSignatureSHA512WithRSA):
+		return crypto.SHA512, nil
+	default:
+		return 0, errors.Errorf("unsupported digest algorithm %s", oid)
+	}
+}
+
+func getDigestAlgorithm(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA1:
+		return oidDigestSHA1, nil
+	case crypto.SHA256:
+		return oidDigestSHA256, nil
+	case crypto.SHA384:
+		return oidDigestSHA384, nil
+	case crypto.SHA512:
+		return oidDigestSHA512, nil
+	default:
+		return nil, errors.Errorf("unsupported hash function %s", hash)
+	}
+}
+
+func verifySignature(pub crypto.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errors.Wrap(err, "error verifying signed data signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		var s struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(sig, &s); err != nil {
+			return errors.Wrap(err, "error parsing signed data signature")
+		}
+		if !ecdsa.Verify(pub, digest, s.R, s.S) {
+			return errors.New("error verifying signed data signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func getSignatureAlgorithm(pub crypto.PublicKey, hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return oidEncryptionRSA, nil
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA1:
+			return oidSignatureECDSAWithSHA1, nil
+		case crypto.SHA256:
+			return oidSignatureECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return oidSignatureECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return oidSignatureECDSAWithSHA512, nil
+		}
+		return nil, errors.Errorf("unsupported hash function %s", hash)
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func marshalAttributes(attrs []Attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		v, err := asn1.Marshal(a.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error marshaling attribute %s", a.Type)
+		}
+		if encoded[i], err = asn1.Marshal(attribute{
+			Type:  a.Type,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: v},
+		}); err != nil {
+			return nil, errors.Wrapf(err, "error marshaling attribute %s", a.Type)
+		}
+	}
+	// The elements of a DER encoded SET OF are sorted.
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	return bytes.Join(encoded, nil), nil
+}
+
+func marshalCertificates(certs []*x509.Certificate) asn1.RawValue {
+	var b []byte
+	for _, crt := range certs {
+		b = append(b, crt.Raw...)
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+func marshalSignedData(sd signedData) ([]byte, error) {
+	b, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling signed data")
+	}
+	b, err = asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     wrapExplicit(b),
+	})
+	return b, errors.Wrap(err, "error marshaling content info")
+}
+
+// Sign creates a DER encoded signed data message with the given content signed
+// by the given key using SHA-256. The message includes the signing time,
+// the given attributes and the certificate of the signer followed by the
+// given certificates. A nil content creates a message without encapsulated
+// content.
+func Sign(content []byte, cert *x509.Certificate, key crypto.Signer, attrs []Attribute, certs ...*x509.Certificate) ([]byte, error) {
+	return SignWithHash(content, cert, key, crypto.SHA256, attrs, certs...)
+}
+
+// SignWithHash is like Sign but uses the given hash function.
+func SignWithHash(content []byte, cert *x509.Certificate, key crypto.Signer, hash crypto.Hash, attrs []Attribute, certs ...*x509.Certificate) ([]byte, error) {
+	digestAlg, err := getDigestAlgorithm(hash)
+	if err != nil {
+		return nil, err
+	}
+	sigAlg, err := getSignatureAlgorithm(key.Public(), hash)
+	if err != nil {
+		return nil, err
+	}
+	// The ECDSA signature algorithms do not have parameters.
+	sigParams := asn1.NullRawValue
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		sigParams = asn1.RawValue{}
+	}
+
+	h := hash.New()
+	h.Write(content)
+	attrs = append([]Attribute{
+		{Type: oidAttributeContentType, Value: oidData},
+		{Type: oidAttributeMessageDigest, Value: h.Sum(nil)},
+		{Type: oidAttributeSigningTime, Value: time.Now().UTC()},
+	}, attrs...)
+	signedAttrs, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the DER encoding of the SET OF attributes.
+	setOf, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling signed attributes")
+	}
+	h = hash.New()
+	h.Write(setOf)
+	sig, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing signed data")
+	}
+
+	ci := contentInfo{ContentType: oidData}
+	if content != nil {
+		b, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling signed data content")
+		}
+		ci.Content = wrapExplicit(b)
+	}
+
+	return marshalSignedData(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlg, Parameters: asn1.NullRawValue}},
+		ContentInfo:      ci,
+		Certificates:     marshalCertificates(append([]*x509.Certificate{cert}, certs...)),
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: digestAlg, Parameters: asn1.NullRawValue},
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: sigAlg, Parameters: sigParams},
+			EncryptedDigest:           sig,
+		}},
+	})
+}
+
+// DegenerateCertificates creates a DER encoded degenerate "certs-only" signed
+// data message with the given certificates.
+func DegenerateCertificates(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("certificates cannot be empty")
+	}
+	return marshalSignedData(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     marshalCertificates(certs),
+		SignerInfos:      []signerInfo{},
+	})
+}
@@ -0,0 +1,255 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+)
+
+var oidTestAttribute = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+
+func generateCertificate(t *testing.T, cn string, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.FatalError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return crt
+}
+
+func generateRSA(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	return generateCertificate(t, "rsa", key), key
+}
+
+func TestSign(t *testing.T) {
+	rsaCert, rsaKey := generateRSA(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	ecCert := generateCertificate(t, "ecdsa", ecKey)
+	otherCert, _ := generateRSA(t)
+
+	type args struct {
+		content []byte
+		cert    *x509.Certificate
+		key     crypto.Signer
+		hash    crypto.Hash
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok rsa sha256", args{[]byte("content"), rsaCert, rsaKey, crypto.SHA256}, false},
+		{"ok rsa sha1", args{[]byte("content"), rsaCert, rsaKey, crypto.SHA1}, false},
+		{"ok rsa sha512", args{[]byte("content"), rsaCert, rsaKey, crypto.SHA512}, false},
+		{"ok ecdsa sha256", args{[]byte("content"), ecCert, ecKey, crypto.SHA256}, false},
+		{"ok ecdsa sha384", args{[]byte("content"), ecCert, ecKey, crypto.SHA384}, false},
+		{"ok no content", args{nil, rsaCert, rsaKey, crypto.SHA256}, false},
+		{"fail hash", args{[]byte("content"), rsaCert, rsaKey, crypto.MD5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := []Attribute{{Type: oidTestAttribute, Value: "1234"}}
+			der, err := SignWithHash(tt.args.content, tt.args.cert, tt.args.key, tt.args.hash, attrs, otherCert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SignWithHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			sd, err := ParseSignedData(der)
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.args.content, sd.Content)
+			assert.Equals(t, []*x509.Certificate{tt.args.cert, otherCert}, sd.Certificates)
+
+			signer, err := sd.Verify()
+			assert.FatalError(t, err)
+			assert.Equals(t, tt.args.cert, signer)
+
+			var v string
+			assert.FatalError(t, sd.GetAttribute(oidTestAttribute, &v))
+			assert.Equals(t, "1234", v)
+			assert.Error(t, sd.GetAttribute(asn1.ObjectIdentifier{1, 2, 3}, &v))
+		})
+	}
+}
+
+func TestSignedData_Verify(t *testing.T) {
+	cert, key := generateRSA(t)
+	otherCert, otherKey := generateRSA(t)
+
+	mustSign := func(content []byte) *SignedData {
+		der, err := Sign(content, cert, key, nil)
+		assert.FatalError(t, err)
+		sd, err := ParseSignedData(der)
+		assert.FatalError(t, err)
+		return sd
+	}
+	tamperedContent := mustSign([]byte("content"))
+	tamperedContent.Content = []byte("tampered")
+
+	noSignerCert := mustSign([]byte("content"))
+	noSignerCert.Certificates = []*x509.Certificate{otherCert}
+
+	// Signed with a different key.
+	der, err := Sign([]byte("content"), cert, otherKey, nil)
+	assert.FatalError(t, err)
+	badSignature, err := ParseSignedData(der)
+	assert.FatalError(t, err)
+
+	degenerate, err := DegenerateCertificates([]*x509.Certificate{cert})
+	assert.FatalError(t, err)
+	noSigner, err := ParseSignedData(degenerate)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		sd      *SignedData
+		wantErr bool
+	}{
+		{"ok", mustSign([]byte("content")), false},
+		{"fail content", tamperedContent, true},
+		{"fail signer certificate", noSignerCert, true},
+		{"fail signature", badSignature, true},
+		{"fail no signer", noSigner, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sd.Verify()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SignedData.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equals(t, cert, got)
+			}
+		})
+	}
+}
+
+func TestDegenerateCertificates(t *testing.T) {
+	c1, _ := generateRSA(t)
+	c2, _ := generateRSA(t)
+
+	der, err := DegenerateCertificates([]*x509.Certificate{c1, c2})
+	assert.FatalError(t, err)
+	certs, err := ParseCertificates(der)
+	assert.FatalError(t, err)
+	assert.Equals(t, []*x509.Certificate{c1, c2}, certs)
+
+	_, err = DegenerateCertificates(nil)
+	assert.Error(t, err)
+}
+
+func TestParseSignedData(t *testing.T) {
+	cert, key := generateRSA(t)
+	der, err := Sign([]byte("content"), cert, key, nil)
+	assert.FatalError(t, err)
+	enveloped, err := Encrypt([]byte("content"), []*x509.Certificate{cert}, EncryptionAlgorithmAES128CBC)
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name    string
+		der     []byte
+		wantErr bool
+	}{
+		{"ok", der, false},
+		{"fail asn1", []byte("foo"), true},
+		{"fail trailing data", append(append([]byte{}, der...), 0), true},
+		{"fail enveloped data", enveloped, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSignedData(tt.der)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSignedData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	cert, key := generateRSA(t)
+	otherCert, otherKey := generateRSA(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	ecCert := generateCertificate(t, "ecdsa", ecKey)
+
+	content := bytes.Repeat([]byte("content"), 10)
+	for _, alg := range []EncryptionAlgorithm{
+		EncryptionAlgorithmDESCBC, EncryptionAlgorithmDESEDE3CBC,
+		EncryptionAlgorithmAES128CBC, EncryptionAlgorithmAES192CBC, EncryptionAlgorithmAES256CBC,
+	} {
+		t.Run(alg.String(), func(t *testing.T) {
+			der, err := Encrypt(content, []*x509.Certificate{otherCert, cert}, alg)
+			assert.FatalError(t, err)
+			ed, err := ParseEnvelopedData(der)
+			assert.FatalError(t, err)
+			assert.Equals(t, alg, ed.EncryptionAlgorithm())
+
+			got, err := ed.Decrypt(cert, key)
+			assert.FatalError(t, err)
+			assert.Equals(t, content, got)
+			got, err = ed.Decrypt(otherCert, otherKey)
+			assert.FatalError(t, err)
+			assert.Equals(t, content, got)
+		})
+	}
+
+	t.Run("fail recipient", func(t *testing.T) {
+		der, err := Encrypt(content, []*x509.Certificate{cert}, EncryptionAlgorithmAES256CBC)
+		assert.FatalError(t, err)
+		ed, err := ParseEnvelopedData(der)
+		assert.FatalError(t, err)
+		_, err = ed.Decrypt(otherCert, otherKey)
+		assert.Error(t, err)
+	})
+	t.Run("fail key", func(t *testing.T) {
+		der, err := Encrypt(content, []*x509.Certificate{cert}, EncryptionAlgorithmAES256CBC)
+		assert.FatalError(t, err)
+		ed, err := ParseEnvelopedData(der)
+		assert.FatalError(t, err)
+		_, err = ed.Decrypt(cert, otherKey)
+		assert.Error(t, err)
+	})
+	t.Run("fail ecdsa recipient", func(t *testing.T) {
+		_, err := Encrypt(content, []*x509.Certificate{ecCert}, EncryptionAlgorithmAES256CBC)
+		assert.Error(t, err)
+	})
+	t.Run("fail no recipients", func(t *testing.T) {
+		_, err := Encrypt(content, nil, EncryptionAlgorithmAES256CBC)
+		assert.Error(t, err)
+	})
+	t.Run("fail algorithm", func(t *testing.T) {
+		_, err := Encrypt(content, []*x509.Certificate{cert}, EncryptionAlgorithm(0))
+		assert.Error(t, err)
+	})
+	t.Run("fail signed data", func(t *testing.T) {
+		der, err := Sign(content, cert, key, nil)
+		assert.FatalError(t, err)
+		_, err = ParseEnvelopedData(der)
+		assert.Error(t, err)
+	})
+}
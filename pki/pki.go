@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
@@ -17,9 +18,12 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/smallstep/certificates/authority"
+	"github.com/smallstep/certificates/authority/policy"
 	"github.com/smallstep/certificates/authority/provisioner"
 	"github.com/smallstep/certificates/ca"
 	"github.com/smallstep/certificates/db"
+	"github.com/smallstep/certificates/kms"
+	kmsapi "github.com/smallstep/certificates/kms/apiv1"
 	"github.com/smallstep/cli/config"
 	"github.com/smallstep/cli/crypto/keys"
 	"github.com/smallstep/cli/crypto/pemutil"
@@ -145,6 +149,19 @@ type PKI struct {
 	dnsNames                       []string
 	caURL                          string
 	enableSSH                      bool
+	nameConstraints                *policy.NameConstraints
+	keyManager                     kms.KeyManager
+	kmsOptions                     *kmsapi.Options
+	kmsKeys                        KMSKeys
+}
+
+// KMSKeys are the names of the keys created in the KMS configured with SetKMS.
+// The keys with an empty name are generated in software and written to disk.
+type KMSKeys struct {
+	Root         string
+	Intermediate string
+	SSHHost      string
+	SSHUser      string
 }
 
 // New creates a new PKI configuration.
@@ -241,6 +258,47 @@ func (p *PKI) SetCAURL(s string) {
 	p.caURL = s
 }
 
+// SetNameConstraints sets the name constraints added to the intermediate
+// certificate.
+func (p *PKI) SetNameConstraints(nc *policy.NameConstraints) {
+	p.nameConstraints = nc
+}
+
+// SetKMS sets the KMS where the keys of the PKI are created, the options are
+// added to the CA configuration. The private keys of the given names are
+// generated directly in the KMS, and the certificate of the intermediate is
+// issued from a certificate request signed by the new key.
+func (p *PKI) SetKMS(km kms.KeyManager, opts *kmsapi.Options, keys KMSKeys) {
+	p.keyManager = km
+	p.kmsOptions = opts
+	p.kmsKeys = keys
+}
+
+// createKMSKey creates a key in the KMS and returns its signer. The name of
+// the key in the configuration is written in keyName. If the KMS returns the
+// private key, e.g. softkms, it is encrypted and written in keyName.
+func (p *PKI) createKMSKey(name string, keyName *string, pass []byte) (crypto.Signer, error) {
+	resp, err := p.keyManager.CreateKey(&kmsapi.CreateKeyRequest{
+		Name:               name,
+		SignatureAlgorithm: kmsapi.ECDSAWithSHA256,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating key %s", name)
+	}
+	signer, err := p.keyManager.CreateSigner(&resp.CreateSignerRequest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating signer for %s", name)
+	}
+	if resp.PrivateKey != nil {
+		if _, err := pemutil.Serialize(resp.PrivateKey, pemutil.WithPassword(pass), pemutil.ToFile(*keyName, 0600)); err != nil {
+			return nil, err
+		}
+		return signer, nil
+	}
+	*keyName = resp.Name
+	return signer, nil
+}
+
 // GenerateKeyPairs generates the key pairs used by the certificate authority.
 func (p *PKI) GenerateKeyPairs(pass []byte) error {
 	var err error
@@ -254,17 +312,42 @@ func (p *PKI) GenerateKeyPairs(pass []byte) error {
 }
 
 // GenerateRootCertificate generates a root certificate with the given name.
+// If the root key is configured in the KMS, the key is created in it and the
+// returned key is its crypto.Signer.
 func (p *PKI) GenerateRootCertificate(name string, pass []byte) (*x509.Certificate, interface{}, error) {
-	rootProfile, err := x509util.NewRootProfile(name)
-	if err != nil {
-		return nil, nil, err
+	var withOps []x509util.WithOption
+	var signer crypto.Signer
+	if p.keyManager != nil && p.kmsKeys.Root != "" {
+		var err error
+		if signer, err = p.createKMSKey(p.kmsKeys.Root, &p.rootKey, pass); err != nil {
+			return nil, nil, err
+		}
+		withOps = append(withOps, x509util.WithPublicKey(signer.Public()))
 	}
 
-	rootBytes, err := rootProfile.CreateWriteCertificate(p.root, p.rootKey, string(pass))
+	rootProfile, err := x509util.NewRootProfile(name, withOps...)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	var rootBytes []byte
+	if signer != nil {
+		rootProfile.SetIssuerPrivateKey(signer)
+		if rootBytes, err = rootProfile.CreateCertificate(); err != nil {
+			return nil, nil, err
+		}
+		if err := utils.WriteFile(p.root, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: rootBytes,
+		}), 0600); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if rootBytes, err = rootProfile.CreateWriteCertificate(p.root, p.rootKey, string(pass)); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	rootCrt, err := x509.ParseCertificate(rootBytes)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "error parsing root certificate")
@@ -273,10 +356,14 @@ func (p *PKI) GenerateRootCertificate(name string, pass []byte) (*x509.Certifica
 	sum := sha256.Sum256(rootCrt.Raw)
 	p.rootFingerprint = strings.ToLower(hex.EncodeToString(sum[:]))
 
+	if signer != nil {
+		return rootCrt, signer, nil
+	}
 	return rootCrt, rootProfile.SubjectPrivateKey(), nil
 }
 
-// WriteRootCertificate writes to disk the given certificate and key.
+// WriteRootCertificate writes to disk the given certificate and key. The key
+// is not written if it is stored in the KMS.
 func (p *PKI) WriteRootCertificate(rootCrt *x509.Certificate, rootKey interface{}, pass []byte) error {
 	if err := utils.WriteFile(p.root, pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
@@ -284,6 +371,9 @@ func (p *PKI) WriteRootCertificate(rootCrt *x509.Certificate, rootKey interface{
 	}), 0600); err != nil {
 		return err
 	}
+	if p.keyManager != nil && p.kmsKeys.Root != "" {
+		return nil
+	}
 
 	_, err := pemutil.Serialize(rootKey, pemutil.WithPassword([]byte(pass)), pemutil.ToFile(p.rootKey, 0600))
 	if err != nil {
@@ -293,22 +383,84 @@ func (p *PKI) WriteRootCertificate(rootCrt *x509.Certificate, rootKey interface{
 }
 
 // GenerateIntermediateCertificate generates an intermediate certificate with
-// the given name.
+// the given name and the configured name constraints. If the intermediate key
+// is configured in the KMS, the key is created in it, and the certificate is
+// issued from a certificate request signed by the new key.
 func (p *PKI) GenerateIntermediateCertificate(name string, rootCrt *x509.Certificate, rootKey interface{}, pass []byte) error {
-	interProfile, err := x509util.NewIntermediateProfile(name, rootCrt, rootKey)
+	withOps := []x509util.WithOption{func(prof x509util.Profile) error {
+		return p.nameConstraints.Apply(prof.Subject())
+	}}
+	if p.keyManager == nil || p.kmsKeys.Intermediate == "" {
+		interProfile, err := x509util.NewIntermediateProfile(name, rootCrt, rootKey, withOps...)
+		if err != nil {
+			return err
+		}
+		_, err = interProfile.CreateWriteCertificate(p.intermediate, p.intermediateKey, string(pass))
+		return err
+	}
+
+	csr, err := p.createKMSCertificateRequest(name, pass)
 	if err != nil {
 		return err
 	}
-	_, err = interProfile.CreateWriteCertificate(p.intermediate, p.intermediateKey, string(pass))
-	return err
+	withOps = append(withOps, x509util.WithPublicKey(csr.PublicKey))
+	interProfile, err := x509util.NewIntermediateProfile(name, rootCrt, rootKey, withOps...)
+	if err != nil {
+		return err
+	}
+	b, err := interProfile.CreateCertificate()
+	if err != nil {
+		return err
+	}
+	return utils.WriteFile(p.intermediate, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: b,
+	}), 0600)
+}
+
+// createKMSCertificateRequest creates the intermediate key in the KMS and
+// returns a certificate request signed by it.
+func (p *PKI) createKMSCertificateRequest(name string, pass []byte) (*x509.CertificateRequest, error) {
+	resp, csr, err := kms.CreateCertificateRequest(p.keyManager, &kmsapi.CreateKeyRequest{
+		Name:               p.kmsKeys.Intermediate,
+		SignatureAlgorithm: kmsapi.ECDSAWithSHA256,
+	}, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.PrivateKey != nil {
+		if _, err := pemutil.Serialize(resp.PrivateKey, pemutil.WithPassword(pass), pemutil.ToFile(p.intermediateKey, 0600)); err != nil {
+			return nil, err
+		}
+	} else {
+		p.intermediateKey = resp.Name
+	}
+	return csr, nil
 }
 
 // GenerateSSHSigningKeys generates and encrypts a private key used for signing
 // SSH user certificates and a private key used for signing host certificates.
 func (p *PKI) GenerateSSHSigningKeys(password []byte) error {
 	var pubNames = []string{p.sshHostPubKey, p.sshUserPubKey}
-	var privNames = []string{p.sshHostKey, p.sshUserKey}
+	var privNames = []*string{&p.sshHostKey, &p.sshUserKey}
+	var kmsNames = []string{p.kmsKeys.SSHHost, p.kmsKeys.SSHUser}
 	for i := 0; i < 2; i++ {
+		if p.keyManager != nil && kmsNames[i] != "" {
+			signer, err := p.createKMSKey(kmsNames[i], privNames[i], password)
+			if err != nil {
+				return err
+			}
+			sshKey, err := ssh.NewPublicKey(signer.Public())
+			if err != nil {
+				return errors.Wrapf(err, "error converting public key")
+			}
+			if err = utils.WriteFile(pubNames[i], ssh.MarshalAuthorizedKey(sshKey), 0600); err != nil {
+				return err
+			}
+			continue
+		}
 		pub, priv, err := keys.GenerateDefaultKeyPair()
 		if err != nil {
 			return err
@@ -320,7 +472,7 @@ func (p *PKI) GenerateSSHSigningKeys(password []byte) error {
 		if err != nil {
 			return errors.Wrapf(err, "error converting public key")
 		}
-		_, err = pemutil.Serialize(priv, pemutil.WithFilename(privNames[i]), pemutil.WithPassword(password))
+		_, err = pemutil.Serialize(priv, pemutil.WithFilename(*privNames[i]), pemutil.WithPassword(password))
 		if err != nil {
 			return err
 		}
@@ -436,6 +588,9 @@ func (p *PKI) GenerateConfig(opt ...Option) (*authority.Config, error) {
 		},
 		Templates: p.getTemplates(),
 	}
+	if p.kmsOptions != nil {
+		config.KMS = p.kmsOptions
+	}
 	if p.enableSSH {
 		enableSSHCA := true
 		config.SSH = &authority.SSHConfig{
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/certificates/api"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/pkcs7"
+	"github.com/smallstep/certificates/scep"
+)
+
+const (
+	opnGetCACert    = "GetCACert"
+	opnGetCACaps    = "GetCACaps"
+	opnPKIOperation = "PKIOperation"
+)
+
+// maxPKIMessageSize is the maximum size of a PKIOperation request.
+const maxPKIMessageSize = 1 << 20
+
+// New returns a new SCEP API router.
+func New(scepAuth *scep.Authority) api.RouterHandler {
+	return &Handler{scepAuth}
+}
+
+// Handler is the SCEP request handler.
+type Handler struct {
+	Auth *scep.Authority
+}
+
+// Route traffic and implement the Router interface. Some clients append the
+// legacy pkiclient.exe path to the URL.
+func (h *Handler) Route(r api.Router) {
+	r.MethodFunc("GET", "/{provisionerID}", h.Get)
+	r.MethodFunc("POST", "/{provisionerID}", h.Post)
+	r.MethodFunc("GET", "/{provisionerID}/pkiclient.exe", h.Get)
+	r.MethodFunc("POST", "/{provisionerID}/pkiclient.exe", h.Post)
+}
+
+// Get handles the GetCACert, GetCACaps and PKIOperation operations sent
+// using the GET method.
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	switch op := r.URL.Query().Get("operation"); op {
+	case opnGetCACert:
+		h.getCACert(w, r)
+	case opnGetCACaps:
+		h.getCACaps(w, r)
+	case opnPKIOperation:
+		// The message is base64 encoded, some clients do not escape it.
+		message := strings.Replace(r.URL.Query().Get("message"), " ", "+", -1)
+		der, err := base64.StdEncoding.DecodeString(message)
+		if err != nil {
+			api.WriteError(w, errs.Wrap(http.StatusBadRequest, err, "scep.Get; error decoding message"))
+			return
+		}
+		h.pkiOperation(w, r, der)
+	default:
+		api.WriteError(w, errs.BadRequest("scep.Get; unsupported operation '%s'", op))
+	}
+}
+
+// Post handles the PKIOperation operation sent using the POST method.
+func (h *Handler) Post(w http.ResponseWriter, r *http.Request) {
+	if op := r.URL.Query().Get("operation"); op != opnPKIOperation {
+		api.WriteError(w, errs.BadRequest("scep.Post; unsupported operation '%s'", op))
+		return
+	}
+	der, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPKIMessageSize))
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusBadRequest, err, "scep.Post; error reading request"))
+		return
+	}
+	h.pkiOperation(w, r, der)
+}
+
+func (h *Handler) lookupProvisioner(r *http.Request) (*provisioner.SCEP, error) {
+	name, err := url.PathUnescape(chi.URLParam(r, "provisionerID"))
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "scep; error url unescaping provisioner id")
+	}
+	return h.Auth.LoadProvisionerByName(name)
+}
+
+func (h *Handler) getCACert(w http.ResponseWriter, r *http.Request) {
+	p, err := h.lookupProvisioner(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	certs, err := h.Auth.GetCACertificates(p)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+
+	// A single certificate is sent in DER format, a CA with an RA certificate
+	// is sent as a degenerate PKCS #7.
+	if len(certs) == 1 {
+		writeResponse(w, "application/x-x509-ca-cert", certs[0].Raw)
+		return
+	}
+	b, err := pkcs7.DegenerateCertificates(certs)
+	if err != nil {
+		api.WriteError(w, errs.Wrap(http.StatusInternalServerError, err, "scep.GetCACert"))
+		return
+	}
+	writeResponse(w, "application/x-x509-ca-ra-cert", b)
+}
+
+func (h *Handler) getCACaps(w http.ResponseWriter, r *http.Request) {
+	p, err := h.lookupProvisioner(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	caps := h.Auth.GetCACaps(p)
+	writeResponse(w, "text/plain", []byte(strings.Join(caps, "\n")))
+}
+
+func (h *Handler) pkiOperation(w http.ResponseWriter, r *http.Request, der []byte) {
+	p, err := h.lookupProvisioner(r)
+	if err != nil {
+		api.WriteError(w, err)
+		return
+	}
+	resp, err := h.Auth.PKIOperation(r.Context(), p, der)
+	if resp == nil {
+		api.WriteError(w, err)
+		return
+	}
+	// Rejected requests are answered with a CertRep with the failure status.
+	if err != nil {
+		api.LogError(w, err)
+	}
+	writeResponse(w, "application/x-pki-message", resp)
+}
+
+func writeResponse(w http.ResponseWriter, contentType string, b []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		api.LogError(w, err)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/pkcs7"
+	"github.com/smallstep/certificates/scep"
+)
+
+type mockSignAuth struct {
+	provisioners map[string]provisioner.Interface
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func (m *mockSignAuth) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) LoadProvisionerByID(id string) (provisioner.Interface, error) {
+	if p, ok := m.provisioners[id]; ok {
+		return p, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockSignAuth) LoadProvisionerByCertificate(*x509.Certificate) (provisioner.Interface, error) {
+	return nil, errors.New("not found")
+}
+
+func (m *mockSignAuth) GetRootCertificates() []*x509.Certificate {
+	return nil
+}
+
+func (m *mockSignAuth) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	return m.intermediate, m.signer
+}
+
+func (m *mockSignAuth) IsRevoked(string) (bool, error) {
+	return false, nil
+}
+
+func mustCertificate(t *testing.T, cn string) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return crt, key
+}
+
+func mustProvisioner(t *testing.T, name string, decrypter *x509.Certificate, decrypterKey *rsa.PrivateKey) *provisioner.SCEP {
+	t.Helper()
+	p := &provisioner.SCEP{Type: "SCEP", Name: name, ChallengePassword: "password"}
+	if decrypter != nil {
+		p.DecrypterCertificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: decrypter.Raw})
+		p.DecrypterKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(decrypterKey)})
+	}
+	assert.FatalError(t, p.Init(provisioner.Config{Claims: provisioner.Claims{
+		MinTLSDur:     &provisioner.Duration{Duration: 5 * time.Minute},
+		MaxTLSDur:     &provisioner.Duration{Duration: 24 * time.Hour},
+		DefaultTLSDur: &provisioner.Duration{Duration: 24 * time.Hour},
+	}}))
+	return p
+}
+
+func TestHandler(t *testing.T) {
+	intermediate, intermediateKey := mustCertificate(t, "Intermediate")
+	decrypter, decrypterKey := mustCertificate(t, "RA")
+	client, clientKey := mustCertificate(t, "device")
+
+	sa := &mockSignAuth{
+		provisioners: map[string]provisioner.Interface{
+			"scep/scep":       mustProvisioner(t, "scep", nil, nil),
+			"scep/ra":         mustProvisioner(t, "ra", decrypter, decrypterKey),
+			"scep/not-scep":   &provisioner.ACME{Type: "ACME", Name: "not-scep"},
+			"scep/with space": mustProvisioner(t, "with space", nil, nil),
+		},
+		intermediate: intermediate,
+		signer:       intermediateKey,
+	}
+	mux := chi.NewRouter()
+	New(scep.NewAuthority(sa)).Route(mux)
+
+	// A GetCRL request is answered with a failure CertRep.
+	envelope, err := pkcs7.Encrypt([]byte("issuer and serial"), []*x509.Certificate{intermediate}, pkcs7.EncryptionAlgorithmAES128CBC)
+	assert.FatalError(t, err)
+	message, err := pkcs7.Sign(envelope, client, clientKey, []pkcs7.Attribute{
+		{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}, Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(scep.GetCRL)}},
+		{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}, Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte("transaction-id")}},
+		{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}, Value: []byte("sender-nonce")},
+	})
+	assert.FatalError(t, err)
+	raCerts, err := pkcs7.DegenerateCertificates([]*x509.Certificate{decrypter, intermediate})
+	assert.FatalError(t, err)
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        []byte
+		status      int
+		contentType string
+		want        []byte
+	}{
+		{"ok GetCACaps", "GET", "/scep?operation=GetCACaps", nil, 200, "text/plain", []byte("Renewal\nSHA-1\nSHA-256\nSHA-512\nAES\nDES3\nSCEPStandard\nPOSTPKIOperation")},
+		{"ok GetCACert", "GET", "/scep?operation=GetCACert", nil, 200, "application/x-x509-ca-cert", intermediate.Raw},
+		{"ok GetCACert pkiclient.exe", "GET", "/scep/pkiclient.exe?operation=GetCACert", nil, 200, "application/x-x509-ca-cert", intermediate.Raw},
+		{"ok GetCACert escaped", "GET", "/with%20space?operation=GetCACert", nil, 200, "application/x-x509-ca-cert", intermediate.Raw},
+		{"ok GetCACert ra", "GET", "/ra?operation=GetCACert", nil, 200, "application/x-x509-ca-ra-cert", raCerts},
+		{"ok PKIOperation GET", "GET", "/scep?operation=PKIOperation&message=" + url.QueryEscape(base64.StdEncoding.EncodeToString(message)), nil, 200, "application/x-pki-message", nil},
+		{"ok PKIOperation POST", "POST", "/scep/pkiclient.exe?operation=PKIOperation", message, 200, "application/x-pki-message", nil},
+		{"fail operation", "GET", "/scep?operation=GetNextCACert", nil, 400, "application/json", nil},
+		{"fail POST operation", "POST", "/scep?operation=GetCACaps", nil, 400, "application/json", nil},
+		{"fail provisioner", "GET", "/missing?operation=GetCACaps", nil, 404, "application/json", nil},
+		{"fail provisioner type", "GET", "/not-scep?operation=GetCACaps", nil, 404, "application/json", nil},
+		{"fail GET message", "GET", "/scep?operation=PKIOperation&message=%%%", nil, 400, "application/json", nil},
+		{"fail POST message", "POST", "/scep?operation=PKIOperation", []byte("foo"), 400, "application/json", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://ca.smallstep.com"+tt.target, bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			res := w.Result()
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			assert.FatalError(t, err)
+
+			assert.Equals(t, tt.status, res.StatusCode)
+			assert.Equals(t, tt.contentType, res.Header.Get("Content-Type"))
+			if tt.want != nil {
+				assert.Equals(t, tt.want, body)
+			}
+			if tt.contentType == "application/x-pki-message" {
+				sd, err := pkcs7.ParseSignedData(body)
+				assert.FatalError(t, err)
+				signer, err := sd.Verify()
+				assert.FatalError(t, err)
+				assert.Equals(t, intermediate, signer)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package scep
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+)
+
+// capabilities is the list of capabilities returned by GetCACaps.
+var capabilities = []string{
+	"Renewal",
+	"SHA-1",
+	"SHA-256",
+	"SHA-512",
+	"AES",
+	"DES3",
+	"SCEPStandard",
+	"POSTPKIOperation",
+}
+
+// SignAuthority is the interface implemented by a CA authority.
+type SignAuthority interface {
+	Sign(cr *x509.CertificateRequest, opts provisioner.Options, signOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	LoadProvisionerByID(string) (provisioner.Interface, error)
+	LoadProvisionerByCertificate(*x509.Certificate) (provisioner.Interface, error)
+	GetRootCertificates() []*x509.Certificate
+	GetIntermediate() (*x509.Certificate, crypto.Signer)
+	IsRevoked(serialNumber string) (bool, error)
+}
+
+// Authority is the layer that handles all SCEP interactions.
+type Authority struct {
+	signAuth SignAuthority
+}
+
+// NewAuthority returns a new SCEP authority.
+func NewAuthority(signAuth SignAuthority) *Authority {
+	return &Authority{
+		signAuth: signAuth,
+	}
+}
+
+// LoadProvisionerByName loads the SCEP provisioner with the given name.
+func (a *Authority) LoadProvisionerByName(name string) (*provisioner.SCEP, error) {
+	p, err := a.signAuth.LoadProvisionerByID("scep/" + name)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusNotFound, err, "scep.LoadProvisionerByName; provisioner %s not found", name)
+	}
+	sp, ok := p.(*provisioner.SCEP)
+	if !ok {
+		return nil, errs.NotFound("scep.LoadProvisionerByName; provisioner %s is not a scep provisioner", name)
+	}
+	return sp, nil
+}
+
+// getDecrypter returns the certificate and key used to decrypt the requests
+// and sign the responses. It defaults to the intermediate if the provisioner
+// does not define one.
+func (a *Authority) getDecrypter(p *provisioner.SCEP) (*x509.Certificate, crypto.Decrypter, crypto.Signer, error) {
+	if cert, key := p.GetDecrypter(); cert != nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, nil, nil, errs.InternalServer("scep.getDecrypter; decrypter of provisioner %s is not a signer", p.GetName())
+		}
+		return cert, key, signer, nil
+	}
+
+	cert, signer := a.signAuth.GetIntermediate()
+	if cert == nil || signer == nil {
+		return nil, nil, nil, errs.InternalServer("scep.getDecrypter; intermediate is not configured")
+	}
+	decrypter, ok := signer.(crypto.Decrypter)
+	if _, isRSA := cert.PublicKey.(*rsa.PublicKey); !ok || !isRSA {
+		return nil, nil, nil, errs.InternalServer("scep.getDecrypter; provisioner %s requires a decrypter certificate, the intermediate does not have an RSA key", p.GetName())
+	}
+	return cert, decrypter, signer, nil
+}
+
+// GetCACertificates returns the certificates sent in the GetCACert operation.
+// The first certificate is the one used to encrypt the requests, followed by
+// the intermediate if they are different.
+func (a *Authority) GetCACertificates(p *provisioner.SCEP) ([]*x509.Certificate, error) {
+	cert, _, _, err := a.getDecrypter(p)
+	if err != nil {
+		return nil, err
+	}
+	certs := []*x509.Certificate{cert}
+	if intermediate, _ := a.signAuth.GetIntermediate(); intermediate != nil && !intermediate.Equal(cert) {
+		certs = append(certs, intermediate)
+	}
+	return certs, nil
+}
+
+// GetCACaps returns the capabilities sent in the GetCACaps operation.
+func (a *Authority) GetCACaps(p *provisioner.SCEP) []string {
+	return capabilities
+}
+
+// PKIOperation processes a SCEP request and returns the DER encoded CertRep
+// message. If the request is rejected the response is a CertRep with the
+// failure status and the error with the reason is also returned. A nil
+// response is returned if the request cannot be answered.
+func (a *Authority) PKIOperation(ctx context.Context, p *provisioner.SCEP, der []byte) ([]byte, error) {
+	msg, err := ParsePKIMessage(der)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusBadRequest, err, "scep.PKIOperation; error parsing message")
+	}
+	cert, decrypter, signer, err := a.getDecrypter(p)
+	if err != nil {
+		return nil, err
+	}
+
+	failure := func(info FailInfo, err error) ([]byte, error) {
+		resp, e := newCertRep(msg, StatusFailure, info, nil, 0, cert, signer)
+		if e != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, e, "scep.PKIOperation; error creating response")
+		}
+		return resp, err
+	}
+
+	switch msg.MessageType {
+	case PKCSReq, RenewalReq, UpdateReq:
+	default:
+		return failure(BadRequest, errs.BadRequest("scep.PKIOperation; message type %s is not supported", msg.MessageType))
+	}
+
+	alg, err := msg.Decrypt(cert, decrypter)
+	if err != nil {
+		return failure(BadMessageCheck, errs.Wrap(http.StatusBadRequest, err, "scep.PKIOperation; error decrypting message"))
+	}
+
+	// Requests signed by a certificate issued by the CA using the same
+	// provisioner are renewals, the rest must include a valid challenge
+	// password.
+	if a.isRenewal(p, msg) {
+		if isRevoked, err := a.signAuth.IsRevoked(msg.Signer.SerialNumber.String()); err != nil {
+			return nil, errs.Wrap(http.StatusInternalServerError, err, "scep.PKIOperation")
+		} else if isRevoked {
+			return failure(BadCertID, errs.Unauthorized("scep.PKIOperation; certificate has been revoked"))
+		}
+		if err := p.AuthorizeRenew(ctx, msg.Signer); err != nil {
+			return failure(BadRequest, err)
+		}
+	} else {
+		if msg.MessageType != PKCSReq {
+			return failure(BadCertID, errs.Unauthorized("scep.PKIOperation; renewal request is not signed by a valid certificate"))
+		}
+		if err := p.ValidateChallenge(ctx, msg.ChallengePassword, msg.TransactionID, msg.CSR); err != nil {
+			return failure(BadRequest, err)
+		}
+	}
+
+	signOps, err := p.AuthorizeSign(ctx, "")
+	if err != nil {
+		return failure(BadRequest, err)
+	}
+	certs, err := a.signAuth.Sign(msg.CSR, provisioner.Options{}, signOps...)
+	if err != nil {
+		return failure(BadRequest, err)
+	}
+
+	resp, err := newCertRep(msg, StatusSuccess, "", certs, alg, cert, signer)
+	if err != nil {
+		return nil, errs.Wrap(http.StatusInternalServerError, err, "scep.PKIOperation; error creating response")
+	}
+	return resp, nil
+}
+
+// isRenewal returns true if the signer of the message is a valid certificate
+// issued by the CA with the given provisioner.
+func (a *Authority) isRenewal(p *provisioner.SCEP, msg *PKIMessage) bool {
+	intermediate, _ := a.signAuth.GetIntermediate()
+	if intermediate == nil || !bytes.Equal(msg.Signer.RawIssuer, intermediate.RawSubject) {
+		return false
+	}
+	roots := x509.NewCertPool()
+	for _, crt := range a.signAuth.GetRootCertificates() {
+		roots.AddCert(crt)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	_, err := msg.Signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return false
+	}
+	prov, err := a.signAuth.LoadProvisionerByCertificate(msg.Signer)
+	return err == nil && prov.GetID() == p.GetID()
+}
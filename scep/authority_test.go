@@ -0,0 +1,597 @@
+package scep
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallstep/assert"
+	"github.com/smallstep/certificates/authority/provisioner"
+	"github.com/smallstep/certificates/errs"
+	"github.com/smallstep/certificates/pkcs7"
+)
+
+type mockSignAuth struct {
+	sign                         func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error)
+	loadProvisionerByID          func(string) (provisioner.Interface, error)
+	loadProvisionerByCertificate func(*x509.Certificate) (provisioner.Interface, error)
+	isRevoked                    func(string) (bool, error)
+	roots                        []*x509.Certificate
+	intermediate                 *x509.Certificate
+	signer                       crypto.Signer
+}
+
+func (m *mockSignAuth) Sign(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+	if m.sign != nil {
+		return m.sign(csr, signOpts, extraOpts...)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockSignAuth) LoadProvisionerByID(id string) (provisioner.Interface, error) {
+	if m.loadProvisionerByID != nil {
+		return m.loadProvisionerByID(id)
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockSignAuth) LoadProvisionerByCertificate(crt *x509.Certificate) (provisioner.Interface, error) {
+	if m.loadProvisionerByCertificate != nil {
+		return m.loadProvisionerByCertificate(crt)
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockSignAuth) GetRootCertificates() []*x509.Certificate {
+	return m.roots
+}
+
+func (m *mockSignAuth) GetIntermediate() (*x509.Certificate, crypto.Signer) {
+	return m.intermediate, m.signer
+}
+
+func (m *mockSignAuth) IsRevoked(sn string) (bool, error) {
+	if m.isRevoked != nil {
+		return m.isRevoked(sn)
+	}
+	return false, nil
+}
+
+type testCA struct {
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	signer       crypto.Signer
+}
+
+func mustCertificate(t *testing.T, tpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.FatalError(t, err)
+	tpl.SerialNumber = serial
+	if tpl.NotBefore.IsZero() {
+		tpl.NotBefore = time.Now().Add(-time.Minute)
+		tpl.NotAfter = time.Now().Add(time.Hour)
+	}
+	if parent == nil {
+		parent = tpl
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, signer)
+	assert.FatalError(t, err)
+	crt, err := x509.ParseCertificate(b)
+	assert.FatalError(t, err)
+	return crt
+}
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.FatalError(t, err)
+	return key
+}
+
+func newTestCA(t *testing.T, intermediateKey crypto.Signer) *testCA {
+	t.Helper()
+	rootKey := mustRSAKey(t)
+	root := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, rootKey.Public(), rootKey)
+	intermediate := mustCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}, root, intermediateKey.Public(), rootKey)
+	return &testCA{root: root, intermediate: intermediate, signer: intermediateKey}
+}
+
+// sign issues a certificate for the given certificate request.
+func (ca *testCA) sign(t *testing.T, csr *x509.CertificateRequest) *x509.Certificate {
+	return mustCertificate(t, &x509.Certificate{
+		Subject:     csr.Subject,
+		DNSNames:    csr.DNSNames,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.intermediate, csr.PublicKey, ca.signer)
+}
+
+func (ca *testCA) signAuth(t *testing.T, p *provisioner.SCEP) *mockSignAuth {
+	return &mockSignAuth{
+		sign: func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+			return []*x509.Certificate{ca.sign(t, csr), ca.intermediate}, nil
+		},
+		loadProvisionerByID: func(id string) (provisioner.Interface, error) {
+			if id == p.GetID() {
+				return p, nil
+			}
+			return nil, errors.New("not found")
+		},
+		loadProvisionerByCertificate: func(*x509.Certificate) (provisioner.Interface, error) {
+			return p, nil
+		},
+		roots:        []*x509.Certificate{ca.root},
+		intermediate: ca.intermediate,
+		signer:       ca.signer,
+	}
+}
+
+func newSCEPProvisioner(t *testing.T, decrypterCert *x509.Certificate, decrypterKey *rsa.PrivateKey) *provisioner.SCEP {
+	t.Helper()
+	p := &provisioner.SCEP{
+		Type:              "SCEP",
+		Name:              "scep",
+		ChallengePassword: "password",
+	}
+	if decrypterCert != nil {
+		p.DecrypterCertificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: decrypterCert.Raw})
+		p.DecrypterKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(decrypterKey)})
+	}
+	disableRenewal := false
+	err := p.Init(provisioner.Config{Claims: provisioner.Claims{
+		DisableRenewal: &disableRenewal,
+		MinTLSDur:      &provisioner.Duration{Duration: 5 * time.Minute},
+		MaxTLSDur:      &provisioner.Duration{Duration: 24 * time.Hour},
+		DefaultTLSDur:  &provisioner.Duration{Duration: 24 * time.Hour},
+	}})
+	assert.FatalError(t, err)
+	return p
+}
+
+type certificationRequest struct {
+	TBS                asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// newCSR creates a certificate request with the given challenge password
+// attribute. The standard library cannot encode it.
+func newCSR(t *testing.T, cn string, key *rsa.PrivateKey, challenge string) []byte {
+	t.Helper()
+	subject, err := asn1.Marshal(pkix.Name{CommonName: cn}.ToRDNSequence())
+	assert.FatalError(t, err)
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	assert.FatalError(t, err)
+
+	info := certificationRequestInfo{
+		Version:   0,
+		Subject:   asn1.RawValue{FullBytes: subject},
+		PublicKey: asn1.RawValue{FullBytes: spki},
+	}
+	if challenge != "" {
+		value, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(challenge)})
+		assert.FatalError(t, err)
+		attr, err := asn1.Marshal(csrAttribute{
+			Type:  oidChallengePassword,
+			Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		assert.FatalError(t, err)
+		info.Attributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attr}
+	} else {
+		// The attributes are not optional in a certificate request.
+		info.Attributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{}}
+	}
+	tbs, err := asn1.Marshal(info)
+	assert.FatalError(t, err)
+
+	digest := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.FatalError(t, err)
+	der, err := asn1.Marshal(certificationRequest{
+		TBS:                asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+	assert.FatalError(t, err)
+	return der
+}
+
+type testClient struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newTestClient(t *testing.T) *testClient {
+	key := mustRSAKey(t)
+	cert := mustCertificate(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "device"},
+	}, nil, key.Public(), key)
+	return &testClient{cert: cert, key: key}
+}
+
+func printableString(s string) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(s)}
+}
+
+func (c *testClient) newPKIMessage(t *testing.T, typ MessageType, csr []byte, recipient *x509.Certificate, alg pkcs7.EncryptionAlgorithm) []byte {
+	t.Helper()
+	envelope, err := pkcs7.Encrypt(csr, []*x509.Certificate{recipient}, alg)
+	assert.FatalError(t, err)
+	der, err := pkcs7.Sign(envelope, c.cert, c.key, []pkcs7.Attribute{
+		{Type: oidSCEPMessageType, Value: printableString(string(typ))},
+		{Type: oidSCEPTransactionID, Value: printableString("transaction-id")},
+		{Type: oidSCEPSenderNonce, Value: []byte("sender-nonce")},
+	})
+	assert.FatalError(t, err)
+	return der
+}
+
+type certRep struct {
+	signer       *x509.Certificate
+	status       PKIStatus
+	failInfo     FailInfo
+	certificates []*x509.Certificate
+}
+
+func (c *testClient) parseCertRep(t *testing.T, der []byte) *certRep {
+	t.Helper()
+	sd, err := pkcs7.ParseSignedData(der)
+	assert.FatalError(t, err)
+	signer, err := sd.Verify()
+	assert.FatalError(t, err)
+
+	var messageType, status, transactionID string
+	var recipientNonce []byte
+	assert.FatalError(t, sd.GetAttribute(oidSCEPMessageType, &messageType))
+	assert.FatalError(t, sd.GetAttribute(oidSCEPPKIStatus, &status))
+	assert.FatalError(t, sd.GetAttribute(oidSCEPTransactionID, &transactionID))
+	assert.FatalError(t, sd.GetAttribute(oidSCEPRecipientNonce, &recipientNonce))
+	assert.Equals(t, string(CertRep), messageType)
+	assert.Equals(t, "transaction-id", transactionID)
+	assert.Equals(t, []byte("sender-nonce"), recipientNonce)
+
+	rep := &certRep{signer: signer, status: PKIStatus(status)}
+	switch rep.status {
+	case StatusSuccess:
+		ed, err := pkcs7.ParseEnvelopedData(sd.Content)
+		assert.FatalError(t, err)
+		b, err := ed.Decrypt(c.cert, c.key)
+		assert.FatalError(t, err)
+		rep.certificates, err = pkcs7.ParseCertificates(b)
+		assert.FatalError(t, err)
+	case StatusFailure:
+		var failInfo string
+		assert.FatalError(t, sd.GetAttribute(oidSCEPFailInfo, &failInfo))
+		rep.failInfo = FailInfo(failInfo)
+	}
+	return rep
+}
+
+func TestAuthority_LoadProvisionerByName(t *testing.T) {
+	ca := newTestCA(t, mustRSAKey(t))
+	p := newSCEPProvisioner(t, nil, nil)
+	sa := ca.signAuth(t, p)
+	sa.loadProvisionerByID = func(id string) (provisioner.Interface, error) {
+		switch id {
+		case "scep/scep":
+			return p, nil
+		case "scep/acme":
+			return &provisioner.ACME{Type: "ACME", Name: "acme"}, nil
+		default:
+			return nil, errors.New("not found")
+		}
+	}
+	a := NewAuthority(sa)
+
+	got, err := a.LoadProvisionerByName("scep")
+	assert.FatalError(t, err)
+	assert.Equals(t, p, got)
+
+	for _, name := range []string{"acme", "missing"} {
+		_, err := a.LoadProvisionerByName(name)
+		sc, ok := err.(errs.StatusCoder)
+		assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+		assert.Equals(t, http.StatusNotFound, sc.StatusCode())
+	}
+}
+
+func TestAuthority_GetCACertificates(t *testing.T) {
+	rsaCA := newTestCA(t, mustRSAKey(t))
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.FatalError(t, err)
+	ecCA := newTestCA(t, ecKey)
+
+	decrypterKey := mustRSAKey(t)
+	decrypter := mustCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "SCEP RA"},
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}, ecCA.intermediate, decrypterKey.Public(), ecCA.signer)
+
+	p := newSCEPProvisioner(t, nil, nil)
+	pWithDecrypter := newSCEPProvisioner(t, decrypter, decrypterKey)
+
+	tests := []struct {
+		name    string
+		ca      *testCA
+		p       *provisioner.SCEP
+		want    []*x509.Certificate
+		wantErr bool
+	}{
+		{"ok intermediate", rsaCA, p, []*x509.Certificate{rsaCA.intermediate}, false},
+		{"ok decrypter", ecCA, pWithDecrypter, []*x509.Certificate{decrypter, ecCA.intermediate}, false},
+		{"fail ecdsa intermediate", ecCA, p, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthority(tt.ca.signAuth(t, tt.p))
+			got, err := a.GetCACertificates(tt.p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authority.GetCACertificates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equals(t, tt.want, got)
+		})
+	}
+}
+
+func TestAuthority_PKIOperation(t *testing.T) {
+	ca := newTestCA(t, mustRSAKey(t))
+	p := newSCEPProvisioner(t, nil, nil)
+
+	decrypterKey := mustRSAKey(t)
+	decrypter := mustCertificate(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "SCEP RA"},
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}, ca.intermediate, decrypterKey.Public(), ca.signer)
+	pWithDecrypter := newSCEPProvisioner(t, decrypter, decrypterKey)
+
+	client := newTestClient(t)
+
+	// The renewal client is signed with a certificate issued by the CA.
+	renewalKey := mustRSAKey(t)
+	renewalCSR, err := x509.ParseCertificateRequest(newCSR(t, "device", renewalKey, ""))
+	assert.FatalError(t, err)
+	renewal := &testClient{cert: ca.sign(t, renewalCSR), key: renewalKey}
+
+	type test struct {
+		p         *provisioner.SCEP
+		sa        *mockSignAuth
+		message   []byte
+		recipient *x509.Certificate
+		client    *testClient
+		status    PKIStatus
+		failInfo  FailInfo
+		code      int
+	}
+	tests := map[string]func(t *testing.T) test{
+		"ok": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, "password"), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusSuccess,
+			}
+		},
+		"ok des3": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, "password"), ca.intermediate, pkcs7.EncryptionAlgorithmDESEDE3CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusSuccess,
+			}
+		},
+		"ok decrypter": func(t *testing.T) test {
+			return test{
+				p:         pWithDecrypter,
+				sa:        ca.signAuth(t, pWithDecrypter),
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, "password"), decrypter, pkcs7.EncryptionAlgorithmAES256CBC),
+				recipient: decrypter,
+				client:    client,
+				status:    StatusSuccess,
+			}
+		},
+		"ok renewal": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   renewal.newPKIMessage(t, RenewalReq, newCSR(t, "device", mustRSAKey(t), ""), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    renewal,
+				status:    StatusSuccess,
+			}
+		},
+		"ok renewal with pkcsreq": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   renewal.newPKIMessage(t, PKCSReq, newCSR(t, "device", renewal.key, ""), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    renewal,
+				status:    StatusSuccess,
+			}
+		},
+		"fail renewal revoked": func(t *testing.T) test {
+			sa := ca.signAuth(t, p)
+			sa.isRevoked = func(string) (bool, error) { return true, nil }
+			return test{
+				p:         p,
+				sa:        sa,
+				message:   renewal.newPKIMessage(t, RenewalReq, newCSR(t, "device", renewal.key, ""), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    renewal,
+				status:    StatusFailure,
+				failInfo:  BadCertID,
+			}
+		},
+		"fail renewal other provisioner": func(t *testing.T) test {
+			sa := ca.signAuth(t, p)
+			sa.loadProvisionerByCertificate = func(*x509.Certificate) (provisioner.Interface, error) {
+				return &provisioner.ACME{Type: "ACME", Name: "acme"}, nil
+			}
+			return test{
+				p:         p,
+				sa:        sa,
+				message:   renewal.newPKIMessage(t, RenewalReq, newCSR(t, "device", renewal.key, ""), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    renewal,
+				status:    StatusFailure,
+				failInfo:  BadCertID,
+			}
+		},
+		"fail renewal self-signed": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, RenewalReq, newCSR(t, "device", client.key, "password"), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusFailure,
+				failInfo:  BadCertID,
+			}
+		},
+		"fail challenge": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, "wrong"), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusFailure,
+				failInfo:  BadRequest,
+			}
+		},
+		"fail no challenge": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, ""), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusFailure,
+				failInfo:  BadRequest,
+			}
+		},
+		"fail message type": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, GetCRL, []byte("issuer and serial"), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusFailure,
+				failInfo:  BadRequest,
+			}
+		},
+		"fail recipient": func(t *testing.T) test {
+			return test{
+				p:         p,
+				sa:        ca.signAuth(t, p),
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, "password"), decrypter, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusFailure,
+				failInfo:  BadMessageCheck,
+			}
+		},
+		"fail sign": func(t *testing.T) test {
+			sa := ca.signAuth(t, p)
+			sa.sign = func(csr *x509.CertificateRequest, signOpts provisioner.Options, extraOpts ...provisioner.SignOption) ([]*x509.Certificate, error) {
+				return nil, errs.Unauthorized("force")
+			}
+			return test{
+				p:         p,
+				sa:        sa,
+				message:   client.newPKIMessage(t, PKCSReq, newCSR(t, "device", client.key, "password"), ca.intermediate, pkcs7.EncryptionAlgorithmAES128CBC),
+				recipient: ca.intermediate,
+				client:    client,
+				status:    StatusFailure,
+				failInfo:  BadRequest,
+			}
+		},
+		"fail parse": func(t *testing.T) test {
+			return test{
+				p:       p,
+				sa:      ca.signAuth(t, p),
+				message: []byte("not a message"),
+				code:    http.StatusBadRequest,
+			}
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			tc := run(t)
+			a := NewAuthority(tc.sa)
+			resp, err := a.PKIOperation(context.Background(), tc.p, tc.message)
+			if tc.code != 0 {
+				assert.Nil(t, resp)
+				sc, ok := err.(errs.StatusCoder)
+				assert.Fatal(t, ok, "error does not implement StatusCoder interface")
+				assert.Equals(t, tc.code, sc.StatusCode())
+				return
+			}
+			if tc.status == StatusSuccess {
+				assert.FatalError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+
+			rep := tc.client.parseCertRep(t, resp)
+			assert.Equals(t, tc.recipient, rep.signer)
+			assert.Equals(t, tc.status, rep.status)
+			assert.Equals(t, tc.failInfo, rep.failInfo)
+			if tc.status == StatusSuccess {
+				assert.Len(t, 2, rep.certificates)
+				assert.Equals(t, "device", rep.certificates[0].Subject.CommonName)
+				assert.Equals(t, ca.intermediate, rep.certificates[1])
+			}
+		})
+	}
+}
+
+func TestParseChallengePassword(t *testing.T) {
+	key := mustRSAKey(t)
+	for _, challenge := range []string{"password", ""} {
+		csr, err := x509.ParseCertificateRequest(newCSR(t, "device", key, challenge))
+		assert.FatalError(t, err)
+		got, err := parseChallengePassword(csr)
+		assert.FatalError(t, err)
+		assert.Equals(t, challenge, got)
+	}
+
+	// Without the attribute
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "device"},
+	}, key)
+	assert.FatalError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.FatalError(t, err)
+	got, err := parseChallengePassword(csr)
+	assert.FatalError(t, err)
+	assert.Equals(t, "", got)
+}
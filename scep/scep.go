@@ -0,0 +1,225 @@
+// Package scep implements the Simple Certificate Enrollment Protocol (SCEP)
+// defined in RFC 8894.
+package scep
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/pkcs7"
+)
+
+// MessageType is the type of a SCEP message.
+type MessageType string
+
+const (
+	// CertRep is the response to a certificate or CRL request.
+	CertRep MessageType = "3"
+	// RenewalReq is a PKCS #10 certificate request authenticated with an
+	// existing certificate.
+	RenewalReq MessageType = "17"
+	// UpdateReq is the legacy message type used for certificate renewals.
+	UpdateReq MessageType = "18"
+	// PKCSReq is a PKCS #10 certificate request.
+	PKCSReq MessageType = "19"
+	// CertPoll is a certificate polling request in manual enrollment.
+	CertPoll MessageType = "20"
+	// GetCert is a request to retrieve a certificate.
+	GetCert MessageType = "21"
+	// GetCRL is a request to retrieve a CRL.
+	GetCRL MessageType = "22"
+)
+
+// PKIStatus is the status of a CertRep message.
+type PKIStatus string
+
+const (
+	// StatusSuccess indicates that the request was granted.
+	StatusSuccess PKIStatus = "0"
+	// StatusFailure indicates that the request was rejected.
+	StatusFailure PKIStatus = "2"
+	// StatusPending indicates that the request is awaiting manual approval.
+	StatusPending PKIStatus = "3"
+)
+
+// FailInfo is the reason of a failed request.
+type FailInfo string
+
+const (
+	// BadAlg indicates an unrecognized or unsupported algorithm.
+	BadAlg FailInfo = "0"
+	// BadMessageCheck indicates that the integrity check failed.
+	BadMessageCheck FailInfo = "1"
+	// BadRequest indicates that the transaction is not permitted or
+	// supported.
+	BadRequest FailInfo = "2"
+	// BadTime indicates that the signing time attribute was not sufficiently
+	// close to the system time.
+	BadTime FailInfo = "3"
+	// BadCertID indicates that no certificate could be identified matching
+	// the provided criteria.
+	BadCertID FailInfo = "4"
+)
+
+var (
+	oidSCEPMessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPPKIStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPFailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPSenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPRecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPTransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// PKIMessage is a parsed SCEP request.
+type PKIMessage struct {
+	TransactionID string
+	MessageType   MessageType
+	SenderNonce   []byte
+	// Signer is the certificate that signed the message, it's a self-signed
+	// certificate on initial enrollments, and the current certificate on
+	// renewals.
+	Signer *x509.Certificate
+	// CSR and ChallengePassword are set after decrypting the message.
+	CSR               *x509.CertificateRequest
+	ChallengePassword string
+	envelope          []byte
+}
+
+// ParsePKIMessage parses a DER encoded SCEP request and verifies its
+// signature.
+func ParsePKIMessage(der []byte) (*PKIMessage, error) {
+	sd, err := pkcs7.ParseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := sd.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &PKIMessage{
+		Signer:   signer,
+		envelope: sd.Content,
+	}
+	var messageType string
+	if err := sd.GetAttribute(oidSCEPMessageType, &messageType); err != nil {
+		return nil, err
+	}
+	msg.MessageType = MessageType(messageType)
+	if err := sd.GetAttribute(oidSCEPTransactionID, &msg.TransactionID); err != nil {
+		return nil, err
+	}
+	if err := sd.GetAttribute(oidSCEPSenderNonce, &msg.SenderNonce); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Decrypt decrypts the envelope of a certificate request message using the
+// given certificate and key, and parses the certificate request and its
+// challenge password.
+func (m *PKIMessage) Decrypt(cert *x509.Certificate, key crypto.Decrypter) (pkcs7.EncryptionAlgorithm, error) {
+	if len(m.envelope) == 0 {
+		return 0, errors.New("scep message does not have content")
+	}
+	ed, err := pkcs7.ParseEnvelopedData(m.envelope)
+	if err != nil {
+		return 0, err
+	}
+	b, err := ed.Decrypt(cert, key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch m.MessageType {
+	case PKCSReq, RenewalReq, UpdateReq:
+		if m.CSR, err = x509.ParseCertificateRequest(b); err != nil {
+			return 0, errors.Wrap(err, "error parsing certificate request")
+		}
+		if err := m.CSR.CheckSignature(); err != nil {
+			return 0, errors.Wrap(err, "error verifying certificate request")
+		}
+		if m.ChallengePassword, err = parseChallengePassword(m.CSR); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, errors.Errorf("scep message type %s is not supported", m.MessageType)
+	}
+	return ed.EncryptionAlgorithm(), nil
+}
+
+type csrAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certificationRequestInfo struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// parseChallengePassword returns the challenge password attribute of the
+// certificate request. The standard library does not parse it because its
+// value is not a list of attribute type and values.
+func parseChallengePassword(csr *x509.CertificateRequest) (string, error) {
+	var info certificationRequestInfo
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &info); err != nil {
+		return "", errors.Wrap(err, "error parsing certificate request")
+	}
+	rest := info.Attributes.Bytes
+	for len(rest) > 0 {
+		var attr csrAttribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return "", errors.Wrap(err, "error parsing certificate request attributes")
+		}
+		if attr.Type.Equal(oidChallengePassword) {
+			var password string
+			if _, err := asn1.Unmarshal(attr.Value.Bytes, &password); err != nil {
+				return "", errors.Wrap(err, "error parsing challenge password")
+			}
+			return password, nil
+		}
+	}
+	return "", nil
+}
+
+// newCertRep creates a CertRep message in response to the given request. On
+// success the issued certificates are encrypted for the signer of the request.
+func newCertRep(req *PKIMessage, status PKIStatus, failInfo FailInfo, certs []*x509.Certificate, alg pkcs7.EncryptionAlgorithm, signerCert *x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "error generating nonce")
+	}
+	attrs := []pkcs7.Attribute{
+		{Type: oidSCEPMessageType, Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(CertRep)}},
+		{Type: oidSCEPPKIStatus, Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(status)}},
+		{Type: oidSCEPTransactionID, Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(req.TransactionID)}},
+		{Type: oidSCEPSenderNonce, Value: nonce},
+		{Type: oidSCEPRecipientNonce, Value: req.SenderNonce},
+	}
+
+	var content []byte
+	switch status {
+	case StatusSuccess:
+		degenerate, err := pkcs7.DegenerateCertificates(certs)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = pkcs7.Encrypt(degenerate, []*x509.Certificate{req.Signer}, alg); err != nil {
+			return nil, err
+		}
+	case StatusFailure:
+		attrs = append(attrs, pkcs7.Attribute{
+			Type: oidSCEPFailInfo, Value: asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(failInfo)},
+		})
+	}
+
+	return pkcs7.Sign(content, signerCert, signer, attrs)
+}
@@ -96,9 +96,20 @@ func (srv *Server) Serve(ln net.Listener) error {
 // connections.
 func (srv *Server) Shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
-	defer cancel()              // release resources if Shutdown ends before the timeout
+	defer cancel() // release resources if Shutdown ends before the timeout
+	return srv.ShutdownContext(ctx)
+}
+
+// ShutdownContext stops accepting new connections and waits for the active
+// ones to finish until the context is done, then the remaining connections are
+// closed.
+func (srv *Server) ShutdownContext(ctx context.Context) error {
 	defer close(srv.shutdownCh) // close shutdown channel
-	return srv.Server.Shutdown(ctx)
+	err := srv.Server.Shutdown(ctx)
+	if err != nil && ctx.Err() != nil {
+		srv.Server.Close()
+	}
+	return err
 }
 
 func (srv *Server) reloadShutdown() error {
@@ -135,9 +146,11 @@ func (srv *Server) Reload(ns *Server) error {
 		}
 	}
 
-	// Close old server without sending a signal
+	// Close old server without sending a signal. If the active connections
+	// do not finish in time the new server is started anyway, the remaining
+	// connections are kept until they finish.
 	if err := srv.reloadShutdown(); err != nil {
-		return err
+		log.Printf("error shutting down previous server: %v", err)
 	}
 
 	// Update old server
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// SystemdListeners returns the TCP listeners passed by systemd with socket
+// activation, indexed by their FileDescriptorName, or by "unknown" if they do
+// not have one. It returns nil if the process was not activated by systemd.
+// The environment variables of the activation are removed, so they are not
+// inherited by other processes.
+func SystemdListeners() (map[string]net.Listener, error) {
+	return systemdListeners(listenFdsStart)
+}
+
+func systemdListeners(start int) (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, names := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	n, err := strconv.Atoi(nfds)
+	if err != nil || n < 0 {
+		return nil, errors.Errorf("error parsing LISTEN_FDS %s", nfds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	listeners := make(map[string]net.Listener, n)
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		if _, ok := listeners[name]; ok {
+			closeAll()
+			return nil, errors.Errorf("systemd socket %s is duplicated", name)
+		}
+		// FileListener duplicates the file descriptor, the original one is
+		// closed.
+		f := os.NewFile(uintptr(start+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrapf(err, "error using systemd socket %s", name)
+		}
+		if _, ok := ln.(*net.TCPListener); !ok {
+			ln.Close()
+			closeAll()
+			return nil, errors.Errorf("systemd socket %s is not a TCP socket", name)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
@@ -0,0 +1,83 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/smallstep/assert"
+)
+
+// listenFd returns a copy of the file descriptor of a new TCP listener.
+func listenFd(t *testing.T) (int, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.FatalError(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	assert.FatalError(t, err)
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	assert.FatalError(t, err)
+	return fd, ln.Addr().String()
+}
+
+func setListenEnv(pid int, fds, names string) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(pid))
+	os.Setenv("LISTEN_FDS", fds)
+	os.Setenv("LISTEN_FDNAMES", names)
+}
+
+func Test_systemdListeners(t *testing.T) {
+	defer setListenEnv(0, "", "")
+
+	// Not activated by systemd.
+	os.Unsetenv("LISTEN_PID")
+	listeners, err := systemdListeners(listenFdsStart)
+	assert.FatalError(t, err)
+	assert.Nil(t, listeners)
+	setListenEnv(os.Getpid()+1, "1", "")
+	listeners, err = systemdListeners(listenFdsStart)
+	assert.FatalError(t, err)
+	assert.Nil(t, listeners)
+
+	// Named socket.
+	fd, addr := listenFd(t)
+	setListenEnv(os.Getpid(), "1", "ca")
+	listeners, err = systemdListeners(fd)
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, listeners) {
+		assert.Equals(t, addr, listeners["ca"].Addr().String())
+		listeners["ca"].Close()
+	}
+	assert.Equals(t, "", os.Getenv("LISTEN_PID"))
+	assert.Equals(t, "", os.Getenv("LISTEN_FDS"))
+	assert.Equals(t, "", os.Getenv("LISTEN_FDNAMES"))
+
+	// Socket without name.
+	fd, addr = listenFd(t)
+	setListenEnv(os.Getpid(), "1", "")
+	listeners, err = systemdListeners(fd)
+	assert.FatalError(t, err)
+	if assert.Len(t, 1, listeners) {
+		assert.Equals(t, addr, listeners["unknown"].Addr().String())
+		listeners["unknown"].Close()
+	}
+
+	// Invalid values.
+	setListenEnv(os.Getpid(), "foo", "")
+	_, err = systemdListeners(listenFdsStart)
+	assert.Error(t, err)
+
+	// Not a TCP socket.
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.FatalError(t, err)
+	syscall.Close(fds[1])
+	setListenEnv(os.Getpid(), "1", "ca")
+	_, err = systemdListeners(fds[0])
+	assert.Error(t, err)
+}
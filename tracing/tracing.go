@@ -0,0 +1,204 @@
+package tracing
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/smallstep/certificates/logging"
+	"go.opentelemetry.io/otel/api/core"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/key"
+	"go.opentelemetry.io/otel/api/propagation"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+)
+
+// instrumentationName is the name of the tracer used by the CA.
+const instrumentationName = "github.com/smallstep/certificates"
+
+// defaultServiceName is the service name used if none is configured.
+const defaultServiceName = "step-ca"
+
+// Config configures the export of the traces of the CA to an OpenTelemetry
+// collector using OTLP over gRPC. By default all the traces are sampled, or
+// only the fraction in SampleRate. Requests with a sampled parent in the
+// traceparent header are always sampled.
+type Config struct {
+	Address     string            `json:"address"`
+	Insecure    bool              `json:"insecure,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"serviceName,omitempty"`
+	SampleRate  *float64          `json:"sampleRate,omitempty"`
+}
+
+// Validate checks the fields in Config, nil is ok.
+func (c *Config) Validate() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Address == "":
+		return errors.New("tracing.address cannot be empty")
+	case c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1):
+		return errors.New("tracing.sampleRate must be between 0 and 1")
+	default:
+		return nil
+	}
+}
+
+// Tracer exports the spans of the CA. It's registered as the global
+// OpenTelemetry provider, so the spans started with Start are exported.
+type Tracer struct {
+	provider  *sdktrace.Provider
+	processor sdktrace.SpanProcessor
+	stop      func() error
+}
+
+// New creates the exporter and the provider configured in c and registers
+// the provider as the global one.
+func New(c *Config) (*Tracer, error) {
+	opts := []otlp.ExporterOption{
+		otlp.WithAddress(c.Address),
+	}
+	if c.Insecure {
+		opts = append(opts, otlp.WithInsecure())
+	} else {
+		opts = append(opts, otlp.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+	}
+	if len(c.Headers) > 0 {
+		opts = append(opts, otlp.WithHeaders(c.Headers))
+	}
+	exporter, err := otlp.NewExporter(opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating otlp exporter")
+	}
+	processor, err := sdktrace.NewBatchSpanProcessor(exporter)
+	if err != nil {
+		exporter.Stop()
+		return nil, errors.Wrap(err, "error creating span processor")
+	}
+	t, err := newTracer(c, processor)
+	if err != nil {
+		exporter.Stop()
+		return nil, err
+	}
+	t.stop = exporter.Stop
+	global.SetTraceProvider(t.provider)
+	return t, nil
+}
+
+// newTracer creates a provider with the configured sampler and service name
+// that sends the spans to the given processor.
+func newTracer(c *Config, processor sdktrace.SpanProcessor) (*Tracer, error) {
+	sampler := sdktrace.AlwaysSample()
+	if c.SampleRate != nil {
+		sampler = sdktrace.ProbabilitySampler(*c.SampleRate)
+	}
+	serviceName := c.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	provider, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sampler}),
+		sdktrace.WithResourceAttributes(key.String("service.name", serviceName)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating trace provider")
+	}
+	provider.RegisterSpanProcessor(processor)
+	return &Tracer{
+		provider:  provider,
+		processor: processor,
+	}, nil
+}
+
+// Stop sends the pending spans and closes the exporter.
+func (t *Tracer) Stop() error {
+	t.provider.UnregisterSpanProcessor(t.processor)
+	if t.stop != nil {
+		return t.stop()
+	}
+	return nil
+}
+
+// Middleware is a chi middleware that starts a server span for each request,
+// continuing the trace in the traceparent header if present. The span is
+// named after the method and the route pattern of the request.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.ExtractHTTP(r.Context(), global.Propagators(), r.Header)
+		ctx, span := t.provider.Tracer(instrumentationName).Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				key.String("http.method", r.Method),
+				key.String("http.target", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rw := logging.NewResponseLogger(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				span.SetName(r.Method + " " + pattern)
+				span.SetAttributes(key.String("http.route", pattern))
+			}
+		}
+		code := rw.StatusCode()
+		span.SetAttributes(key.Int("http.status_code", code))
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Internal, http.StatusText(code))
+		}
+	})
+}
+
+// Start starts a span with the given name and attributes using the global
+// provider. The span is a child of the span in ctx if any.
+func Start(ctx context.Context, name string, attrs ...core.KeyValue) (context.Context, trace.Span) {
+	return global.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(context.Background(), err, trace.WithErrorStatus(codes.Internal))
+	}
+	span.End()
+}
+
+// IsTraced returns true if ctx has a valid span, the context of the requests
+// is only propagated to the signatures if they are traced.
+func IsTraced(ctx context.Context) bool {
+	return trace.SpanFromContext(ctx).SpanContext().IsValid()
+}
+
+// signer is a crypto.Signer that starts a span for each signature.
+type signer struct {
+	crypto.Signer
+	ctx  context.Context
+	name string
+}
+
+// NewSigner returns a crypto.Signer that records each signature of s as a
+// child span of the span in ctx. If ctx is not traced s is returned.
+func NewSigner(ctx context.Context, name string, s crypto.Signer) crypto.Signer {
+	if s == nil || !IsTraced(ctx) {
+		return s
+	}
+	return &signer{Signer: s, ctx: ctx, name: name}
+}
+
+// Sign signs the digest with the wrapped signer.
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	_, span := Start(s.ctx, s.name)
+	sig, err := s.Signer.Sign(rand, digest, opts)
+	End(span, err)
+	return sig, err
+}
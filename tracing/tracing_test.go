@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/codes"
+)
+
+type recorder struct {
+	sync.Mutex
+	spans []*export.SpanData
+}
+
+func (r *recorder) ExportSpan(ctx context.Context, s *export.SpanData) {
+	r.Lock()
+	r.spans = append(r.spans, s)
+	r.Unlock()
+}
+
+func newTestTracer(t *testing.T, c *Config) (*Tracer, *recorder) {
+	rec := new(recorder)
+	tr, err := newTracer(c, sdktrace.NewSimpleSpanProcessor(rec))
+	if err != nil {
+		t.Fatalf("newTracer() error = %v", err)
+	}
+	return tr, rec
+}
+
+func TestConfig_Validate(t *testing.T) {
+	rate := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"ok", &Config{Address: "localhost:55680"}, false},
+		{"ok/sampleRate", &Config{Address: "localhost:55680", SampleRate: rate(0.5)}, false},
+		{"fail/address", &Config{}, true},
+		{"fail/sampleRate", &Config{Address: "localhost:55680", SampleRate: rate(1.5)}, true},
+		{"fail/negative", &Config{Address: "localhost:55680", SampleRate: rate(-1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTracer_Middleware(t *testing.T) {
+	tr, rec := newTestTracer(t, &Config{Address: "localhost:55680"})
+	defer tr.Stop()
+
+	mux := chi.NewRouter()
+	mux.Use(tr.Middleware)
+	mux.Get("/sign/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !IsTraced(r.Context()) {
+			t.Error("request context is not traced")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("GET", "/sign/1", nil)
+	req.Header.Set("traceparent", parent)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(rec.spans) != 1 {
+		t.Fatalf("spans = %d, want 1", len(rec.spans))
+	}
+	span := rec.spans[0]
+	if span.Name != "GET /sign/{id}" {
+		t.Errorf("span name = %s, want GET /sign/{id}", span.Name)
+	}
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span kind = %v, want server", span.SpanKind)
+	}
+	if got := span.SpanContext.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want 4bf92f3577b34da6a3ce929d0e0e4736", got)
+	}
+	if !span.HasRemoteParent {
+		t.Error("span does not have a remote parent")
+	}
+	if span.StatusCode != codes.Internal {
+		t.Errorf("span status = %v, want %v", span.StatusCode, codes.Internal)
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a traced context the signer is not wrapped.
+	if s := NewSigner(context.Background(), "kms.Sign", key); s != crypto.Signer(key) {
+		t.Errorf("NewSigner() = %T, want %T", s, key)
+	}
+
+	tr, rec := newTestTracer(t, &Config{Address: "localhost:55680"})
+	defer tr.Stop()
+	global.SetTraceProvider(tr.provider)
+	defer global.SetTraceProvider(trace.NoopProvider{})
+
+	ctx, span := Start(context.Background(), "authority.Sign")
+	s := NewSigner(ctx, "kms.Sign", key)
+	sum := sha256.Sum256([]byte("data"))
+	if _, err := s.Sign(rand.Reader, sum[:], crypto.SHA256); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	End(span, nil)
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(rec.spans))
+	}
+	if rec.spans[0].Name != "kms.Sign" || rec.spans[1].Name != "authority.Sign" {
+		t.Errorf("spans = %s, %s, want kms.Sign, authority.Sign", rec.spans[0].Name, rec.spans[1].Name)
+	}
+	if rec.spans[0].ParentSpanID != rec.spans[1].SpanContext.SpanID {
+		t.Error("kms.Sign is not a child of authority.Sign")
+	}
+}